@@ -0,0 +1,198 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxrpc"
+)
+
+// signAdmin signs an admin command with the client's key, which has to be the exchange's admin key
+func (cl *BenchClient) signAdmin(command string, args ...string) (compactSig []byte, err error) {
+
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, cxrpc.AdminCommandHash(command, args...), false); err != nil {
+		return
+	}
+
+	return
+}
+
+// ListWithdrawals calls the listwithdrawals rpc command
+func (cl *BenchClient) ListWithdrawals(asset string, state string) (listWithdrawalsReply *cxrpc.ListWithdrawalsReply, err error) {
+
+	listWithdrawalsReply = new(cxrpc.ListWithdrawalsReply)
+	listWithdrawalsArgs := &cxrpc.ListWithdrawalsArgs{
+		Asset: asset,
+		State: state,
+	}
+
+	if listWithdrawalsArgs.Signature, err = cl.signAdmin("listwithdrawals", asset, state); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.ListWithdrawals", listWithdrawalsArgs, listWithdrawalsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// ApproveWithdrawal calls the approvewithdrawal rpc command
+func (cl *BenchClient) ApproveWithdrawal(asset string, id uint64) (approveWithdrawalReply *cxrpc.ApproveWithdrawalReply, err error) {
+
+	approveWithdrawalReply = new(cxrpc.ApproveWithdrawalReply)
+	approveWithdrawalArgs := &cxrpc.ApproveWithdrawalArgs{
+		Asset: asset,
+		ID:    id,
+	}
+
+	if approveWithdrawalArgs.Signature, err = cl.signAdmin("approvewithdrawal", asset, fmt.Sprintf("%d", id)); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.ApproveWithdrawal", approveWithdrawalArgs, approveWithdrawalReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// RejectWithdrawal calls the rejectwithdrawal rpc command
+func (cl *BenchClient) RejectWithdrawal(asset string, id uint64, reason string) (rejectWithdrawalReply *cxrpc.RejectWithdrawalReply, err error) {
+
+	rejectWithdrawalReply = new(cxrpc.RejectWithdrawalReply)
+	rejectWithdrawalArgs := &cxrpc.RejectWithdrawalArgs{
+		Asset:  asset,
+		ID:     id,
+		Reason: reason,
+	}
+
+	if rejectWithdrawalArgs.Signature, err = cl.signAdmin("rejectwithdrawal", asset, fmt.Sprintf("%d", id), reason); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.RejectWithdrawal", rejectWithdrawalArgs, rejectWithdrawalReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// WalletBalances calls the walletbalances rpc command
+func (cl *BenchClient) WalletBalances(asset string) (walletBalancesReply *cxrpc.WalletBalancesReply, err error) {
+
+	walletBalancesReply = new(cxrpc.WalletBalancesReply)
+	walletBalancesArgs := &cxrpc.WalletBalancesArgs{
+		Asset: asset,
+	}
+
+	if walletBalancesArgs.Signature, err = cl.signAdmin("walletbalances", asset); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.WalletBalances", walletBalancesArgs, walletBalancesReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SweepToCold calls the sweeptocold rpc command
+func (cl *BenchClient) SweepToCold(asset string) (sweepToColdReply *cxrpc.SweepToColdReply, err error) {
+
+	sweepToColdReply = new(cxrpc.SweepToColdReply)
+	sweepToColdArgs := &cxrpc.SweepToColdArgs{
+		Asset: asset,
+	}
+
+	if sweepToColdArgs.Signature, err = cl.signAdmin("sweeptocold", asset); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.SweepToCold", sweepToColdArgs, sweepToColdReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// CreateRefill calls the createrefill rpc command
+func (cl *BenchClient) CreateRefill(asset string, amount uint64) (createRefillReply *cxrpc.CreateRefillReply, err error) {
+
+	createRefillReply = new(cxrpc.CreateRefillReply)
+	createRefillArgs := &cxrpc.CreateRefillArgs{
+		Asset:  asset,
+		Amount: amount,
+	}
+
+	if createRefillArgs.Signature, err = cl.signAdmin("createrefill", asset, fmt.Sprintf("%d", amount)); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.CreateRefill", createRefillArgs, createRefillReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SubmitRefill calls the submitrefill rpc command
+func (cl *BenchClient) SubmitRefill(asset string, tx []byte) (submitRefillReply *cxrpc.SubmitRefillReply, err error) {
+
+	submitRefillReply = new(cxrpc.SubmitRefillReply)
+	submitRefillArgs := &cxrpc.SubmitRefillArgs{
+		Asset: asset,
+		Tx:    tx,
+	}
+
+	if submitRefillArgs.Signature, err = cl.signAdmin("submitrefill", asset, fmt.Sprintf("%x", tx)); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.SubmitRefill", submitRefillArgs, submitRefillReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// ReconcileLightning calls the reconcilelightning rpc command
+func (cl *BenchClient) ReconcileLightning(asset string) (reconcileLightningReply *cxrpc.ReconcileLightningReply, err error) {
+
+	reconcileLightningReply = new(cxrpc.ReconcileLightningReply)
+	reconcileLightningArgs := &cxrpc.ReconcileLightningArgs{
+		Asset: asset,
+	}
+
+	if reconcileLightningArgs.Signature, err = cl.signAdmin("reconcilelightning", asset); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.ReconcileLightning", reconcileLightningArgs, reconcileLightningReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetBreachAlerts calls the getbreachalerts rpc command
+func (cl *BenchClient) GetBreachAlerts() (getBreachAlertsReply *cxrpc.GetBreachAlertsReply, err error) {
+
+	getBreachAlertsReply = new(cxrpc.GetBreachAlertsReply)
+	getBreachAlertsArgs := new(cxrpc.GetBreachAlertsArgs)
+
+	if getBreachAlertsArgs.Signature, err = cl.signAdmin("getbreachalerts"); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetBreachAlerts", getBreachAlertsArgs, getBreachAlertsReply); err != nil {
+		return
+	}
+
+	return
+}
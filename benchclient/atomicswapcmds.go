@@ -0,0 +1,115 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// AtomicOrderAtPriceCommand places an atomic swap order giving up amountHave at a price, like
+// OrderAtPriceCommand. The pair has to be one the exchange settles by atomic swap.
+func (cl *BenchClient) AtomicOrderAtPriceCommand(pubkey *koblitz.PublicKey, side match.Side, pair string, amountHave uint64, price uint64) (reply *cxrpc.SubmitAtomicOrderReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var newOrder match.LimitOrder
+	copy(newOrder.Pubkey[:], pubkey.SerializeCompressed())
+	newOrder.Side = side
+	if err = newOrder.TradingPair.FromString(pair); err != nil {
+		err = fmt.Errorf("Error getting asset pair from string: \n%s", err)
+		return
+	}
+
+	newOrder.AmountHave = amountHave
+	if newOrder.AmountWant, err = newOrder.TradingPair.AmountWantAtPrice(side, amountHave, price); err != nil {
+		return
+	}
+
+	orderArgs := new(cxrpc.SubmitAtomicOrderArgs)
+	reply = new(cxrpc.SubmitAtomicOrderReply)
+	if orderArgs.Signature, err = cl.signOrder(&newOrder); err != nil {
+		return
+	}
+	orderArgs.Order = &newOrder
+
+	if err = cl.Call("OpencxRPC.SubmitAtomicOrder", orderArgs, reply); err != nil {
+		err = fmt.Errorf("Error calling 'SubmitAtomicOrder' service method:\n%s", err)
+		return
+	}
+
+	return
+}
+
+// InitiateAtomicSwap calls the initiateatomicswap rpc command, signing the atomic swap's ID and
+// hash. The client picks the preimage and only sends its hash.
+func (cl *BenchClient) InitiateAtomicSwap(id [32]byte, rhash [32]byte) (initiateAtomicSwapReply *cxrpc.InitiateAtomicSwapReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	initiateAtomicSwapReply = new(cxrpc.InitiateAtomicSwapReply)
+	initiateAtomicSwapArgs := &cxrpc.InitiateAtomicSwapArgs{
+		ID:    id,
+		RHash: rhash,
+	}
+
+	if initiateAtomicSwapArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, match.AtomicInitiationSigHash(id, rhash), false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.InitiateAtomicSwap", initiateAtomicSwapArgs, initiateAtomicSwapReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetAtomicSwaps calls the getatomicswaps rpc command, signing the exchange's getatomicswaps
+// string
+func (cl *BenchClient) GetAtomicSwaps() (getAtomicSwapsReply *cxrpc.GetAtomicSwapsReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getAtomicSwapsReply = new(cxrpc.GetAtomicSwapsReply)
+	getAtomicSwapsArgs := new(cxrpc.GetAtomicSwapsArgs)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte("opencx-getatomicswaps"))
+	e := sha3.Sum(nil)
+
+	// Sign
+	if getAtomicSwapsArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetAtomicSwaps", getAtomicSwapsArgs, getAtomicSwapsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SubmitAtomicSpend calls the submitatomicspend rpc command
+func (cl *BenchClient) SubmitAtomicSpend(asset string, tx []byte) (submitAtomicSpendReply *cxrpc.SubmitAtomicSpendReply, err error) {
+	submitAtomicSpendReply = new(cxrpc.SubmitAtomicSpendReply)
+	submitAtomicSpendArgs := &cxrpc.SubmitAtomicSpendArgs{
+		Asset: asset,
+		Tx:    tx,
+	}
+
+	if err = cl.Call("OpencxRPC.SubmitAtomicSpend", submitAtomicSpendArgs, submitAtomicSpendReply); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,122 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauctionrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// GetAuctionOrderStatus calls the getauctionorderstatus rpc command
+func (cl *BenchClient) GetAuctionOrderStatus(receiptID [32]byte) (getAuctionOrderStatusReply *cxauctionrpc.GetAuctionOrderStatusReply, err error) {
+	getAuctionOrderStatusReply = new(cxauctionrpc.GetAuctionOrderStatusReply)
+	getAuctionOrderStatusArgs := &cxauctionrpc.GetAuctionOrderStatusArgs{
+		ReceiptID: receiptID,
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.GetAuctionOrderStatus", getAuctionOrderStatusArgs, getAuctionOrderStatusReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// CancelAuctionOrder calls the cancelauctionorder rpc command, signing the receipt ID. This has
+// to be the key the order was placed with for the order to be cancelled.
+func (cl *BenchClient) CancelAuctionOrder(receiptID [32]byte) (cancelAuctionOrderReply *cxauctionrpc.CancelAuctionOrderReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	cancelAuctionOrderReply = new(cxauctionrpc.CancelAuctionOrderReply)
+	cancelAuctionOrderArgs := &cxauctionrpc.CancelAuctionOrderArgs{
+		ReceiptID: receiptID,
+	}
+
+	if cancelAuctionOrderArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, match.AuctionCancelSigHash(receiptID), false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.CancelAuctionOrder", cancelAuctionOrderArgs, cancelAuctionOrderReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetAuctionResult calls the getauctionresult rpc command
+func (cl *BenchClient) GetAuctionResult(auctionID [32]byte) (getAuctionResultReply *cxauctionrpc.GetAuctionResultReply, err error) {
+	getAuctionResultReply = new(cxauctionrpc.GetAuctionResultReply)
+	getAuctionResultArgs := &cxauctionrpc.GetAuctionResultArgs{
+		AuctionID: auctionID,
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.GetAuctionResult", getAuctionResultArgs, getAuctionResultReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetTranscript calls the gettranscript rpc command, returning the signed transcript for an
+// auction. The transcript isn't checked, that's up to the caller.
+func (cl *BenchClient) GetTranscript(pair *match.Pair, auctionID [32]byte) (transcript *match.Transcript, err error) {
+	getTranscriptReply := new(cxauctionrpc.GetTranscriptReply)
+	getTranscriptArgs := &cxauctionrpc.GetTranscriptArgs{
+		Pair:      *pair,
+		AuctionID: auctionID,
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.GetTranscript", getTranscriptArgs, getTranscriptReply); err != nil {
+		return
+	}
+
+	transcript = new(match.Transcript)
+	if err = transcript.Deserialize(getTranscriptReply.TranscriptBytes); err != nil {
+		err = fmt.Errorf("Error deserializing transcript from exchange: %s", err)
+		return
+	}
+
+	return
+}
+
+// SubmitCommitResponse calls the submitcommitresponse rpc command, revealing the factors of the
+// puzzle for an order in the transcript. The response is signed with the client's key, so this
+// has to be the key the puzzled order was signed with.
+func (cl *BenchClient) SubmitCommitResponse(transcript *match.Transcript, answer match.SolutionOrder) (submitCommitResponseReply *cxauctionrpc.SubmitCommitResponseReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var e []byte
+	if e, err = transcript.ResponseSigHash(answer); err != nil {
+		return
+	}
+
+	var sig []byte
+	if sig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	response := match.CommitResponse{
+		PuzzleAnswerReveal: answer,
+	}
+	copy(response.CommResponseSig[:], sig)
+
+	submitCommitResponseReply = new(cxauctionrpc.SubmitCommitResponseReply)
+	submitCommitResponseArgs := &cxauctionrpc.SubmitCommitResponseArgs{
+		AuctionID: transcript.BatchId,
+	}
+	if submitCommitResponseArgs.ResponseBytes, err = response.Serialize(); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.SubmitCommitResponse", submitCommitResponseArgs, submitCommitResponseReply); err != nil {
+		return
+	}
+
+	return
+}
@@ -2,6 +2,7 @@ package benchclient
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxrpc"
@@ -71,6 +72,40 @@ func (cl *BenchClient) GetDepositAddress(asset string) (getDepositAddressReply *
 	return
 }
 
+// GetDeposits calls the getdeposits rpc command
+func (cl *BenchClient) GetDeposits(asset string) (getDepositsReply *cxrpc.GetDepositsReply, err error) {
+
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getDepositsReply = new(cxrpc.GetDepositsReply)
+	getDepositsArgs := &cxrpc.GetDepositsArgs{
+		Asset: asset,
+	}
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(asset))
+	e := sha3.Sum(nil)
+
+	// Sign
+	var compactSig []byte
+	if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	// set signature in args
+	getDepositsArgs.Signature = compactSig
+
+	if err = cl.Call("OpencxRPC.GetDeposits", getDepositsArgs, getDepositsReply); err != nil {
+		return
+	}
+
+	return
+}
+
 // GetAllBalances get the balance for every token
 func (cl *BenchClient) GetAllBalances() (balances map[string]uint64, err error) {
 
@@ -100,13 +135,9 @@ func (cl *BenchClient) GetAllBalances() (balances map[string]uint64, err error)
 	return
 }
 
-// Withdraw calls the withdraw rpc command
-func (cl *BenchClient) Withdraw(amount uint64, asset match.Asset, address string) (withdrawReply *cxrpc.WithdrawReply, err error) {
-
-	if cl.PrivKey == nil {
-		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
-		return
-	}
+// Withdraw calls the withdraw rpc command. The priority decides the fee rate the withdrawal's
+// transaction pays, and if payFee is set the user's share of the fee comes out of what they're sent.
+func (cl *BenchClient) Withdraw(amount uint64, asset match.Asset, address string, priority match.FeePriority, payFee bool) (withdrawReply *cxrpc.WithdrawReply, err error) {
 
 	withdrawReply = new(cxrpc.WithdrawReply)
 	withdrawArgs := &cxrpc.WithdrawArgs{
@@ -115,19 +146,119 @@ func (cl *BenchClient) Withdraw(amount uint64, asset match.Asset, address string
 			Asset:     asset,
 			Address:   address,
 			Lightning: false,
+			Priority:  priority,
+			PayFee:    payFee,
 		},
 	}
 
+	if withdrawArgs.Signature, err = cl.SignWithdrawal(withdrawArgs.Withdrawal); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.Withdraw", withdrawArgs, withdrawReply); err != nil {
+		return
+	}
+
+	if withdrawReply.Withdrawal == nil {
+		err = fmt.Errorf("Error: Unsupported Asset")
+		return
+	}
+
+	return
+}
+
+// GetFeeEstimates calls the getfeeestimates rpc command
+func (cl *BenchClient) GetFeeEstimates(asset string) (getFeeEstimatesReply *cxrpc.GetFeeEstimatesReply, err error) {
+	getFeeEstimatesReply = new(cxrpc.GetFeeEstimatesReply)
+	getFeeEstimatesArgs := &cxrpc.GetFeeEstimatesArgs{
+		Asset: asset,
+	}
+
+	if err = cl.Call("OpencxRPC.GetFeeEstimates", getFeeEstimatesArgs, getFeeEstimatesReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SignWithdrawal sets the exchange's withdrawal domain and a new nonce on a withdrawal, and
+// returns the client's signature over it
+func (cl *BenchClient) SignWithdrawal(withdrawal *match.Withdrawal) (compactSig []byte, err error) {
+
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	// the domain doesn't change, so only ask for it once
+	if cl.withdrawalDomain == "" {
+		getWithdrawalDomainReply := new(cxrpc.GetWithdrawalDomainReply)
+		if err = cl.Call("OpencxRPC.GetWithdrawalDomain", &cxrpc.GetWithdrawalDomainArgs{}, getWithdrawalDomainReply); err != nil {
+			return
+		}
+		cl.withdrawalDomain = getWithdrawalDomainReply.Domain
+	}
+	withdrawal.Domain = cl.withdrawalDomain
+
+	// the exchange won't take a nonce twice, so the time is a good enough nonce
+	withdrawal.Nonce = uint64(time.Now().UnixNano())
+
+	if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, withdrawal.SigHash(), false); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetWithdrawals calls the getwithdrawals rpc command
+func (cl *BenchClient) GetWithdrawals(asset string) (getWithdrawalsReply *cxrpc.GetWithdrawalsReply, err error) {
+
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getWithdrawalsReply = new(cxrpc.GetWithdrawalsReply)
+	getWithdrawalsArgs := &cxrpc.GetWithdrawalsArgs{
+		Asset: asset,
+	}
+
 	// create e = hash(m)
 	sha3 := sha3.New256()
-	sha3.Write(withdrawArgs.Withdrawal.Serialize())
+	sha3.Write([]byte(asset))
 	e := sha3.Sum(nil)
 
-	// Sign order
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
+	// Sign
+	var compactSig []byte
+	if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
 
 	// set signature in args
-	withdrawArgs.Signature = compactSig
+	getWithdrawalsArgs.Signature = compactSig
+
+	if err = cl.Call("OpencxRPC.GetWithdrawals", getWithdrawalsArgs, getWithdrawalsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// WithdrawLightning calls the withdraw rpc command, but with the lightning boolean set to true
+func (cl *BenchClient) WithdrawLightning(amount uint64, asset match.Asset) (withdrawReply *cxrpc.WithdrawReply, err error) {
+
+	withdrawReply = new(cxrpc.WithdrawReply)
+	withdrawArgs := &cxrpc.WithdrawArgs{
+		Withdrawal: &match.Withdrawal{
+			Amount:    amount,
+			Asset:     asset,
+			Lightning: true,
+		},
+	}
+
+	if withdrawArgs.Signature, err = cl.SignWithdrawal(withdrawArgs.Withdrawal); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.Withdraw", withdrawArgs, withdrawReply); err != nil {
 		return
@@ -141,39 +272,34 @@ func (cl *BenchClient) Withdraw(amount uint64, asset match.Asset, address string
 	return
 }
 
-// WithdrawLightning calls the withdraw rpc command, but with the lightning boolean set to true
-func (cl *BenchClient) WithdrawLightning(amount uint64, asset match.Asset) (withdrawReply *cxrpc.WithdrawReply, err error) {
+// PayPaymentRequest calls the withdraw rpc command for a lightning withdrawal that pays a payment
+// request through the exchange's lit node. The withdrawal is for the request's amount and asset.
+func (cl *BenchClient) PayPaymentRequest(paymentRequest string) (withdrawReply *cxrpc.WithdrawReply, err error) {
 
-	if cl.PrivKey == nil {
-		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+	var request *match.PaymentRequest
+	if request, err = match.ParsePaymentRequest(paymentRequest); err != nil {
 		return
 	}
 
 	withdrawReply = new(cxrpc.WithdrawReply)
 	withdrawArgs := &cxrpc.WithdrawArgs{
 		Withdrawal: &match.Withdrawal{
-			Amount:    amount,
-			Asset:     asset,
+			Amount:    request.Amount,
+			Asset:     request.Asset,
+			Address:   paymentRequest,
 			Lightning: true,
 		},
 	}
 
-	// create e = hash(m)
-	sha3 := sha3.New256()
-	sha3.Write(withdrawArgs.Withdrawal.Serialize())
-	e := sha3.Sum(nil)
-
-	// Sign order
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
-
-	// set signature in args
-	withdrawArgs.Signature = compactSig
+	if withdrawArgs.Signature, err = cl.SignWithdrawal(withdrawArgs.Withdrawal); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.Withdraw", withdrawArgs, withdrawReply); err != nil {
 		return
 	}
 
-	if withdrawReply.Txid == "" {
+	if withdrawReply.Withdrawal == nil {
 		err = fmt.Errorf("Error: Unsupported Asset")
 		return
 	}
@@ -4,7 +4,10 @@
 package benchclient
 
 import (
+	"github.com/mit-dci/lit/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/opencx/cxrpc"
 )
 
@@ -14,6 +17,9 @@ type BenchClient struct {
 	port      uint16
 	RPCClient cxrpc.OpencxClient
 	PrivKey   *koblitz.PrivateKey
+
+	// withdrawalDomain is the domain the exchange wants withdrawals signed for
+	withdrawalDomain string
 }
 
 // SetupBenchClient creates a new BenchClient for use as an RPC Client
@@ -52,6 +58,29 @@ func (cl *BenchClient) SetupBenchNoiseClient(server string, port uint16) (err er
 	return
 }
 
+// SetKeyFromSeed derives the key the client signs with from the seed in a key file, the same way
+// lit derives its keys, so a client and a lit node with the same seed have the same pubkey.
+func (cl *BenchClient) SetKeyFromSeed(seed *[32]byte) (err error) {
+	// We use TestNet3Params because that's what qln uses
+	var rootPrivKey *hdkeychain.ExtendedKey
+	if rootPrivKey, err = hdkeychain.NewMaster(seed[:], &coinparam.TestNet3Params); err != nil {
+		return
+	}
+
+	// make keygen the same
+	var kg portxo.KeyGen
+	kg.Depth = 5
+	kg.Step[0] = 44 | 1<<31
+	kg.Step[1] = 513 | 1<<31
+	kg.Step[2] = 9 | 1<<31
+	kg.Step[3] = 0 | 1<<31
+	kg.Step[4] = 0 | 1<<31
+	if cl.PrivKey, err = kg.DerivePrivateKey(rootPrivKey); err != nil {
+		return
+	}
+	return
+}
+
 // Call calls a method from the rpc client
 func (cl *BenchClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
 	return cl.RPCClient.Call(serviceMethod, args, reply)
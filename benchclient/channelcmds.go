@@ -1,9 +1,8 @@
 package benchclient
 
 import (
-	"fmt"
-
 	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
 )
 
 // GetLitConnection gets the lit con to pass in to lit. Maybe do this more automatically later on
@@ -18,16 +17,22 @@ func (cl *BenchClient) GetLitConnection() (getLitConnectionReply *cxrpc.GetLitCo
 	return
 }
 
-// WithdrawToLightningNode takes in some arguments such as public key, amount, and ln node address
-func (cl *BenchClient) WithdrawToLightningNode() (withdrawToLightningNodeReply *cxrpc.WithdrawToLightningNodeReply, err error) {
+// WithdrawToLightningNode signs a lightning withdrawal and calls the withdrawtolightning rpc command,
+// which pushes the amount to the client's lightning node in a new channel
+func (cl *BenchClient) WithdrawToLightningNode(amount uint64, asset match.Asset) (withdrawToLightningNodeReply *cxrpc.WithdrawToLightningNodeReply, err error) {
 
-	if cl.PrivKey == nil {
-		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
-		return
+	withdrawToLightningNodeReply = new(cxrpc.WithdrawToLightningNodeReply)
+	withdrawToLightningNodeArgs := &cxrpc.WithdrawToLightningNodeArgs{
+		Withdrawal: &match.Withdrawal{
+			Amount:    amount,
+			Asset:     asset,
+			Lightning: true,
+		},
 	}
 
-	withdrawToLightningNodeReply = new(cxrpc.WithdrawToLightningNodeReply)
-	withdrawToLightningNodeArgs := &cxrpc.WithdrawToLightningNodeArgs{}
+	if withdrawToLightningNodeArgs.Signature, err = cl.SignWithdrawal(withdrawToLightningNodeArgs.Withdrawal); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.WithdrawToLightningNode", withdrawToLightningNodeArgs, withdrawToLightningNodeReply); err != nil {
 		return
@@ -0,0 +1,62 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// CreateInvoice calls the createinvoice rpc command, signing the request. The exchange picks the
+// preimage, whoever pays the invoice credits this client.
+func (cl *BenchClient) CreateInvoice(request *match.InvoiceRequest) (createInvoiceReply *cxrpc.CreateInvoiceReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	createInvoiceReply = new(cxrpc.CreateInvoiceReply)
+	createInvoiceArgs := &cxrpc.CreateInvoiceArgs{
+		Request: request,
+	}
+
+	if createInvoiceArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, request.SigHash(), false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.CreateInvoice", createInvoiceArgs, createInvoiceReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetInvoices calls the getinvoices rpc command, signing the exchange's getinvoices string
+func (cl *BenchClient) GetInvoices() (getInvoicesReply *cxrpc.GetInvoicesReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getInvoicesReply = new(cxrpc.GetInvoicesReply)
+	getInvoicesArgs := new(cxrpc.GetInvoicesArgs)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte("opencx-getinvoices"))
+	e := sha3.Sum(nil)
+
+	// Sign
+	if getInvoicesArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetInvoices", getInvoicesArgs, getInvoicesReply); err != nil {
+		return
+	}
+
+	return
+}
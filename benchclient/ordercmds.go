@@ -43,8 +43,7 @@ func (cl *BenchClient) OrderAsync(pubkey *koblitz.PublicKey, side match.Side, pa
 	errChan <- func() (err error) {
 		// TODO: this can be refactored to look more like the rest of the code, it's just using channels and works really well so I don't want to mess with it rn
 
-		orderArgs := new(cxrpc.SubmitOrderArgs)
-		orderReply := new(cxrpc.SubmitOrderReply)
+		var orderReply *cxrpc.SubmitOrderReply
 		var newOrder match.LimitOrder
 
 		copy(newOrder.Pubkey[:], pubkey.SerializeCompressed())
@@ -59,35 +58,79 @@ func (cl *BenchClient) OrderAsync(pubkey *koblitz.PublicKey, side match.Side, pa
 		newOrder.AmountHave = amountHave
 		newOrder.AmountWant = uint64(price * float64(amountHave))
 
-		var newOrderBytes []byte
-		if newOrderBytes, err = newOrder.Serialize(); err != nil {
-			err = fmt.Errorf("Error serializing new order: %s", err)
+		if orderReply, err = cl.submitLimitOrder(&newOrder); err != nil {
 			return
 		}
 
-		// create e = hash(m)
-		sha3 := sha3.New256()
-		sha3.Write(newOrderBytes)
-		e := sha3.Sum(nil)
+		replyChan <- orderReply
 
-		// Sign order
-		var compactSig []byte
-		if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
-			return
-		}
+		return
+	}()
 
-		orderArgs.Signature = compactSig
-		orderArgs.Order = &newOrder
+	return
+}
 
-		if err = cl.Call("OpencxRPC.SubmitOrder", orderArgs, orderReply); err != nil {
-			err = fmt.Errorf("Error calling 'SubmitOrder' service method:\n%s", err)
-			return
-		}
+// OrderAtPriceCommand places a limit order giving up amountHave at a price, in base units of the
+// pair's quote asset for a whole unit of its base asset. The amount wanted is worked out exactly,
+// so the order passes the exchange's tick size check whenever the price is on the tick.
+func (cl *BenchClient) OrderAtPriceCommand(pubkey *koblitz.PublicKey, side match.Side, pair string, amountHave uint64, price uint64) (reply *cxrpc.SubmitOrderReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
 
-		replyChan <- orderReply
+	var newOrder match.LimitOrder
+	copy(newOrder.Pubkey[:], pubkey.SerializeCompressed())
+	newOrder.Side = side
+	if err = newOrder.TradingPair.FromString(pair); err != nil {
+		err = fmt.Errorf("Error getting asset pair from string: \n%s", err)
+		return
+	}
 
+	newOrder.AmountHave = amountHave
+	if newOrder.AmountWant, err = newOrder.TradingPair.AmountWantAtPrice(side, amountHave, price); err != nil {
 		return
-	}()
+	}
+
+	reply, err = cl.submitLimitOrder(&newOrder)
+	return
+}
+
+// submitLimitOrder signs an order and submits it
+func (cl *BenchClient) submitLimitOrder(newOrder *match.LimitOrder) (orderReply *cxrpc.SubmitOrderReply, err error) {
+	orderArgs := new(cxrpc.SubmitOrderArgs)
+	orderReply = new(cxrpc.SubmitOrderReply)
+
+	if orderArgs.Signature, err = cl.signOrder(newOrder); err != nil {
+		return
+	}
+	orderArgs.Order = newOrder
+
+	if err = cl.Call("OpencxRPC.SubmitOrder", orderArgs, orderReply); err != nil {
+		err = fmt.Errorf("Error calling 'SubmitOrder' service method:\n%s", err)
+		return
+	}
+
+	return
+}
+
+// signOrder signs the hash of a serialized order
+func (cl *BenchClient) signOrder(newOrder *match.LimitOrder) (compactSig []byte, err error) {
+	var newOrderBytes []byte
+	if newOrderBytes, err = newOrder.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing new order: %s", err)
+		return
+	}
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write(newOrderBytes)
+	e := sha3.Sum(nil)
+
+	// Sign order
+	if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
 
 	return
 }
@@ -174,6 +217,104 @@ func (cl *BenchClient) GetPairs() (getPairsReply *cxrpc.GetPairsReply, err error
 	return
 }
 
+// GetAssets gets every asset the exchange knows about
+func (cl *BenchClient) GetAssets() (getAssetsReply *cxrpc.GetAssetsReply, err error) {
+	getAssetsReply = new(cxrpc.GetAssetsReply)
+	getAssetsArgs := new(cxrpc.GetAssetsArgs)
+
+	if err = cl.Call("OpencxRPC.GetAssets", getAssetsArgs, getAssetsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SyncAssets registers the exchange's assets locally, so amounts are parsed and shown with the
+// exchange's decimals. Assets on chains the client doesn't know about are skipped.
+func (cl *BenchClient) SyncAssets() (err error) {
+	var getAssetsReply *cxrpc.GetAssetsReply
+	if getAssetsReply, err = cl.GetAssets(); err != nil {
+		return
+	}
+
+	for _, assetStr := range getAssetsReply.Assets {
+		var info *match.AssetInfo
+		if info, err = match.ParseAssetInfo(assetStr); err != nil {
+			logging.Debugf("Skipping exchange asset %s: %s", assetStr, err)
+			err = nil
+			continue
+		}
+		if err = match.RegisterAsset(info); err != nil {
+			err = fmt.Errorf("Error registering exchange asset %s: %s", assetStr, err)
+			return
+		}
+	}
+	return
+}
+
+// GetOrderHistory gets a page of order history for the client's pubkey
+func (cl *BenchClient) GetOrderHistory(query *match.HistoryQuery) (getOrderHistoryReply *cxrpc.GetOrderHistoryReply, err error) {
+	getOrderHistoryReply = new(cxrpc.GetOrderHistoryReply)
+	getOrderHistoryArgs := &cxrpc.GetOrderHistoryArgs{
+		Query: query,
+	}
+
+	if getOrderHistoryArgs.Signature, err = cl.signHistoryQuery(query); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetOrderHistory", getOrderHistoryArgs, getOrderHistoryReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetFillHistory gets a page of fills for the client's pubkey
+func (cl *BenchClient) GetFillHistory(query *match.HistoryQuery) (getFillHistoryReply *cxrpc.GetFillHistoryReply, err error) {
+	getFillHistoryReply = new(cxrpc.GetFillHistoryReply)
+	getFillHistoryArgs := &cxrpc.GetFillHistoryArgs{
+		Query: query,
+	}
+
+	if getFillHistoryArgs.Signature, err = cl.signHistoryQuery(query); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetFillHistory", getFillHistoryArgs, getFillHistoryReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// signHistoryQuery signs a history query, so the server knows whose history to return
+func (cl *BenchClient) signHistoryQuery(query *match.HistoryQuery) (compactSig []byte, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var queryBytes []byte
+	if queryBytes, err = query.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing history query for signHistoryQuery: %s", err)
+		return
+	}
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write(queryBytes)
+	e := sha3.Sum(nil)
+
+	// Sign query
+	if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		err = fmt.Errorf("Error signing history query for signHistoryQuery: %s", err)
+		return
+	}
+
+	return
+}
+
 // AuctionOrderCommand submits an order synchronously. Uses asynchronous order function
 func (cl *BenchClient) AuctionOrderCommand(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, t uint64, auctionID [32]byte) (reply *cxauctionrpc.SubmitPuzzledOrderReply, err error) {
 	errorChannel := make(chan error, 1)
@@ -256,6 +397,22 @@ func (cl *BenchClient) AuctionOrderAsync(pubkey *koblitz.PublicKey, side string,
 			return
 		}
 
+		// Sign the puzzled order so the exchange can put it in the auction transcript
+		var encSolOrder match.EncryptedSolutionOrder
+		if encSolOrder, err = order.SolutionForm(); err != nil {
+			err = fmt.Errorf("Error getting solution form of puzzled order: %s", err)
+			return
+		}
+		var puzzleSigHash []byte
+		if puzzleSigHash, err = encSolOrder.SigHash(); err != nil {
+			err = fmt.Errorf("Error hashing puzzled order for signing: %s", err)
+			return
+		}
+		if orderArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, puzzleSigHash, false); err != nil {
+			err = fmt.Errorf("Error signing puzzled order: %s", err)
+			return
+		}
+
 		if err = cl.Call("OpencxAuctionRPC.SubmitPuzzledOrder", orderArgs, orderReply); err != nil {
 			err = fmt.Errorf("Error calling 'SubmitPuzzledOrder' service method:\n%s", err)
 			return
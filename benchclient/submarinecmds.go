@@ -0,0 +1,78 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// CreateSubmarineSwap calls the createsubmarineswap rpc command, signing the request. The client
+// picks the preimage and only sends its hash, it needs the preimage to claim or refund later.
+func (cl *BenchClient) CreateSubmarineSwap(request *match.SubmarineRequest) (createSubmarineSwapReply *cxrpc.CreateSubmarineSwapReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	createSubmarineSwapReply = new(cxrpc.CreateSubmarineSwapReply)
+	createSubmarineSwapArgs := &cxrpc.CreateSubmarineSwapArgs{
+		Request: request,
+	}
+
+	if createSubmarineSwapArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, request.SigHash(), false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.CreateSubmarineSwap", createSubmarineSwapArgs, createSubmarineSwapReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetSubmarineSwaps calls the getsubmarineswaps rpc command, signing the exchange's
+// getsubmarineswaps string
+func (cl *BenchClient) GetSubmarineSwaps() (getSubmarineSwapsReply *cxrpc.GetSubmarineSwapsReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getSubmarineSwapsReply = new(cxrpc.GetSubmarineSwapsReply)
+	getSubmarineSwapsArgs := new(cxrpc.GetSubmarineSwapsArgs)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte("opencx-getsubmarineswaps"))
+	e := sha3.Sum(nil)
+
+	// Sign
+	if getSubmarineSwapsArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetSubmarineSwaps", getSubmarineSwapsArgs, getSubmarineSwapsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SubmitSubmarineSpend calls the submitsubmarinespend rpc command
+func (cl *BenchClient) SubmitSubmarineSpend(asset string, tx []byte) (submitSubmarineSpendReply *cxrpc.SubmitSubmarineSpendReply, err error) {
+	submitSubmarineSpendReply = new(cxrpc.SubmitSubmarineSpendReply)
+	submitSubmarineSpendArgs := &cxrpc.SubmitSubmarineSpendArgs{
+		Asset: asset,
+		Tx:    tx,
+	}
+
+	if err = cl.Call("OpencxRPC.SubmitSubmarineSpend", submitSubmarineSpendArgs, submitSubmarineSpendReply); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,166 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SwapOrderAtPriceCommand places a swap order giving up amountHave at a price, like
+// OrderAtPriceCommand. Swap orders are settled with HTLCs over the client's lightning channels,
+// so the client's lit node has to have channels with the exchange for both assets.
+func (cl *BenchClient) SwapOrderAtPriceCommand(pubkey *koblitz.PublicKey, side match.Side, pair string, amountHave uint64, price uint64) (reply *cxrpc.SubmitSwapOrderReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var newOrder match.LimitOrder
+	copy(newOrder.Pubkey[:], pubkey.SerializeCompressed())
+	newOrder.Side = side
+	if err = newOrder.TradingPair.FromString(pair); err != nil {
+		err = fmt.Errorf("Error getting asset pair from string: \n%s", err)
+		return
+	}
+
+	newOrder.AmountHave = amountHave
+	if newOrder.AmountWant, err = newOrder.TradingPair.AmountWantAtPrice(side, amountHave, price); err != nil {
+		return
+	}
+
+	orderArgs := new(cxrpc.SubmitSwapOrderArgs)
+	reply = new(cxrpc.SubmitSwapOrderReply)
+	if orderArgs.Signature, err = cl.signOrder(&newOrder); err != nil {
+		return
+	}
+	orderArgs.Order = &newOrder
+
+	if err = cl.Call("OpencxRPC.SubmitSwapOrder", orderArgs, reply); err != nil {
+		err = fmt.Errorf("Error calling 'SubmitSwapOrder' service method:\n%s", err)
+		return
+	}
+
+	return
+}
+
+// ChannelOrderAtPriceCommand places a channel order giving up amountHave at a price, like
+// OrderAtPriceCommand. The reply has the escrow the client's lit node has to offer HTLCs for
+// before the order goes on the book.
+func (cl *BenchClient) ChannelOrderAtPriceCommand(pubkey *koblitz.PublicKey, side match.Side, pair string, amountHave uint64, price uint64) (reply *cxrpc.SubmitChannelOrderReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var newOrder match.LimitOrder
+	copy(newOrder.Pubkey[:], pubkey.SerializeCompressed())
+	newOrder.Side = side
+	if err = newOrder.TradingPair.FromString(pair); err != nil {
+		err = fmt.Errorf("Error getting asset pair from string: \n%s", err)
+		return
+	}
+
+	newOrder.AmountHave = amountHave
+	if newOrder.AmountWant, err = newOrder.TradingPair.AmountWantAtPrice(side, amountHave, price); err != nil {
+		return
+	}
+
+	orderArgs := new(cxrpc.SubmitChannelOrderArgs)
+	reply = new(cxrpc.SubmitChannelOrderReply)
+	if orderArgs.Signature, err = cl.signOrder(&newOrder); err != nil {
+		return
+	}
+	orderArgs.Order = &newOrder
+
+	if err = cl.Call("OpencxRPC.SubmitChannelOrder", orderArgs, reply); err != nil {
+		err = fmt.Errorf("Error calling 'SubmitChannelOrder' service method:\n%s", err)
+		return
+	}
+
+	return
+}
+
+// GetEscrows calls the getescrows rpc command, signing the exchange's getescrows string
+func (cl *BenchClient) GetEscrows() (getEscrowsReply *cxrpc.GetEscrowsReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getEscrowsReply = new(cxrpc.GetEscrowsReply)
+	getEscrowsArgs := new(cxrpc.GetEscrowsArgs)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte("opencx-getescrows"))
+	e := sha3.Sum(nil)
+
+	// Sign
+	if getEscrowsArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetEscrows", getEscrowsArgs, getEscrowsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetSwaps calls the getswaps rpc command, signing the exchange's getswaps string
+func (cl *BenchClient) GetSwaps() (getSwapsReply *cxrpc.GetSwapsReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getSwapsReply = new(cxrpc.GetSwapsReply)
+	getSwapsArgs := new(cxrpc.GetSwapsArgs)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte("opencx-getswaps"))
+	e := sha3.Sum(nil)
+
+	// Sign
+	if getSwapsArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetSwaps", getSwapsArgs, getSwapsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetLiquidity calls the getliquidity rpc command, signing the exchange's getliquidity string
+func (cl *BenchClient) GetLiquidity() (getLiquidityReply *cxrpc.GetLiquidityReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getLiquidityReply = new(cxrpc.GetLiquidityReply)
+	getLiquidityArgs := new(cxrpc.GetLiquidityArgs)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write([]byte("opencx-getliquidity"))
+	e := sha3.Sum(nil)
+
+	// Sign
+	if getLiquidityArgs.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetLiquidity", getLiquidityArgs, getLiquidityReply); err != nil {
+		return
+	}
+
+	return
+}
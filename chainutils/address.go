@@ -0,0 +1,211 @@
+package chainutils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/base58"
+	"github.com/mit-dci/lit/coinparam"
+)
+
+const (
+	// bech32Const is what a bech32 checksum (BIP173) has to come out to, for version 0 witness
+	// programs
+	bech32Const = 1
+	// bech32mConst is what a bech32m checksum (BIP350) has to come out to, for witness programs
+	// from version 1 on
+	bech32mConst = 0x2bc830a3
+	// maxAddressLength is the longest a segwit address can be
+	maxAddressLength = 90
+)
+
+// checksumConst returns the constant a witness version's address checksum comes out to
+func checksumConst(version byte) uint32 {
+	if version == 0 {
+		return bech32Const
+	}
+	return bech32mConst
+}
+
+// checkWitnessProgram makes sure a witness program is the right length for its version
+func checkWitnessProgram(version byte, program []byte) (err error) {
+	if version > 16 {
+		err = fmt.Errorf("Witness version %d is over 16", version)
+		return
+	}
+	if len(program) < 2 || len(program) > 40 {
+		err = fmt.Errorf("Witness program is %d bytes, should be 2 to 40", len(program))
+		return
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		err = fmt.Errorf("Version 0 witness program is %d bytes, should be 20 or 32", len(program))
+		return
+	}
+	return
+}
+
+// EncodeSegWitAddress encodes a witness program as an address with the hrp, using bech32 for
+// version 0 and bech32m for every version after.
+func EncodeSegWitAddress(hrp string, version byte, program []byte) (address string, err error) {
+	if err = checkWitnessProgram(version, program); err != nil {
+		return
+	}
+
+	data := append([]byte{version}, bech32.Bytes8to5(program)...)
+
+	values := append(bech32.HRPExpand(hrp), data...)
+	values = append(values, make([]byte, 6)...)
+	checksum := bech32.PolyMod(values) ^ checksumConst(version)
+	for i := 0; i < 6; i++ {
+		data = append(data, byte(checksum>>(5*(5-uint32(i))))&0x1f)
+	}
+
+	var dataString string
+	if dataString, err = bech32.SquashedBytesToString(data); err != nil {
+		err = fmt.Errorf("Error encoding segwit address: %s", err)
+		return
+	}
+	address = hrp + "1" + dataString
+	return
+}
+
+// DecodeSegWitAddress decodes a segwit address that should have the hrp, and returns its witness
+// version and program. Version 0 addresses have to use bech32 and later versions bech32m.
+func DecodeSegWitAddress(hrp string, address string) (version byte, program []byte, err error) {
+	if len(address) > maxAddressLength {
+		err = fmt.Errorf("Address is %d characters, segwit addresses are at most %d", len(address), maxAddressLength)
+		return
+	}
+	for _, c := range address {
+		if c < 33 || c > 126 {
+			err = fmt.Errorf("Address has a character that can't be in a segwit address")
+			return
+		}
+	}
+
+	lowAddress := strings.ToLower(address)
+	if address != lowAddress && address != strings.ToUpper(address) {
+		err = fmt.Errorf("Address %s is mixed case", address)
+		return
+	}
+	address = lowAddress
+
+	splitLoc := strings.LastIndex(address, "1")
+	if splitLoc == -1 {
+		err = fmt.Errorf("Address %s has no separator", address)
+		return
+	}
+	if address[:splitLoc] != hrp {
+		err = fmt.Errorf("Address %s is for %s, not %s", address, address[:splitLoc], hrp)
+		return
+	}
+
+	var data []byte
+	if data, err = bech32.StringToSquashedBytes(address[splitLoc+1:]); err != nil {
+		err = fmt.Errorf("Error decoding segwit address %s: %s", address, err)
+		return
+	}
+	// the version and the checksum
+	if len(data) < 7 {
+		err = fmt.Errorf("Address %s is too short", address)
+		return
+	}
+
+	version = data[0]
+	if bech32.PolyMod(append(bech32.HRPExpand(hrp), data...)) != checksumConst(version) {
+		err = fmt.Errorf("Address %s has an invalid checksum for witness version %d", address, version)
+		return
+	}
+
+	if program, err = bech32.Bytes5to8(data[1 : len(data)-6]); err != nil {
+		err = fmt.Errorf("Error decoding witness program of %s: %s", address, err)
+		return
+	}
+	if err = checkWitnessProgram(version, program); err != nil {
+		return
+	}
+	return
+}
+
+// WitnessScript returns the output script paying to a witness program
+func WitnessScript(version byte, program []byte) (script []byte) {
+	versionOp := byte(0x00)
+	if version != 0 {
+		// OP_1 through OP_16
+		versionOp = 0x50 + version
+	}
+	script = append([]byte{versionOp, byte(len(program))}, program...)
+	return
+}
+
+// AddressScript checks that an address is for a coin and returns the output script paying to it.
+// Segwit addresses of any witness version are fine, as are P2PKH and P2SH addresses.
+func AddressScript(address string, coin *coinparam.Params) (script []byte, err error) {
+	if coin.Bech32Prefix != "" && strings.HasPrefix(strings.ToLower(address), coin.Bech32Prefix+"1") {
+		var version byte
+		var program []byte
+		if version, program, err = DecodeSegWitAddress(coin.Bech32Prefix, address); err != nil {
+			return
+		}
+		script = WitnessScript(version, program)
+		return
+	}
+
+	var decoded []byte
+	var netID byte
+	if decoded, netID, err = base58.CheckDecode(address); err != nil {
+		err = fmt.Errorf("Address %s isn't a segwit address for %s or a base58 address: %s", address, coin.Name, err)
+		return
+	}
+	if len(decoded) != 20 {
+		err = fmt.Errorf("Address %s has a %d byte hash, should be 20", address, len(decoded))
+		return
+	}
+
+	switch netID {
+	case coin.PubKeyHashAddrID:
+		// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+		script = append(append([]byte{0x76, 0xa9, 0x14}, decoded...), 0x88, 0xac)
+	case coin.ScriptHashAddrID:
+		// OP_HASH160 <hash> OP_EQUAL
+		script = append(append([]byte{0xa9, 0x14}, decoded...), 0x87)
+	default:
+		err = fmt.Errorf("Address %s isn't a %s address", address, coin.Name)
+	}
+	return
+}
+
+// ValidateAddress returns an error if an address can't be paid to on a coin
+func ValidateAddress(address string, coin *coinparam.Params) (err error) {
+	_, err = AddressScript(address, coin)
+	return
+}
+
+// ScriptAddress returns the address of an output script on a coin. Only P2PKH, P2SH and witness
+// scripts have addresses.
+func ScriptAddress(script []byte, coin *coinparam.Params) (address string, err error) {
+	if version, program, ok := WitnessProgram(script); ok {
+		address, err = EncodeSegWitAddress(coin.Bech32Prefix, version, program)
+		return
+	}
+
+	switch scriptType, data := ScriptType(script); scriptType {
+	case "P2PKH":
+		var addr *btcutil.AddressPubKeyHash
+		if addr, err = btcutil.NewAddressPubKeyHash(data, coin); err != nil {
+			return
+		}
+		address = addr.String()
+	case "P2SH":
+		var addr *btcutil.AddressScriptHash
+		if addr, err = btcutil.NewAddressScriptHashFromHash(data, coin); err != nil {
+			return
+		}
+		address = addr.String()
+	default:
+		err = fmt.Errorf("%s scripts don't have an address", scriptType)
+	}
+	return
+}
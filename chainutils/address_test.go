@@ -0,0 +1,144 @@
+package chainutils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+)
+
+// validSegWitAddresses are the valid addresses from BIP350, with their hrp and output script
+var validSegWitAddresses = []struct {
+	hrp     string
+	address string
+	script  string
+}{
+	{"bc", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+	{"tb", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+	{"bc", "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+	{"bc", "BC1SW50QGDZ25J", "6002751e"},
+	{"bc", "bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+	{"tb", "tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+	{"tb", "tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+	{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+}
+
+// invalidSegWitAddresses are invalid addresses from BIP350, with the hrp they're decoded with
+var invalidSegWitAddresses = []struct {
+	hrp     string
+	address string
+	reason  string
+}{
+	{"tb", "tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", "wrong hrp"},
+	{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", "bech32 checksum for version 1"},
+	{"tb", "tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", "bech32 checksum for version 2"},
+	{"bc", "BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", "bech32 checksum for version 16"},
+	{"bc", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", "bech32m checksum for version 0"},
+	{"tb", "tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", "bech32m checksum for version 0"},
+	{"bc", "bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", "invalid character"},
+	{"bc", "BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", "version 17"},
+	{"bc", "bc1pw5dgrnzv", "1 byte program"},
+	{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", "41 byte program"},
+	{"bc", "BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P", "16 byte version 0 program"},
+	{"tb", "tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq", "mixed case"},
+	{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf", "more than 4 bits of padding"},
+	{"tb", "tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j", "non-zero padding"},
+	{"bc", "bc1gmk9yu", "empty data"},
+}
+
+// TestSegWitAddresses decodes and re-encodes the valid BIP350 addresses and makes sure every
+// invalid one fails to decode
+func TestSegWitAddresses(t *testing.T) {
+	for _, test := range validSegWitAddresses {
+		version, program, err := DecodeSegWitAddress(test.hrp, test.address)
+		if err != nil {
+			t.Errorf("Error decoding valid address %s: %s", test.address, err)
+			continue
+		}
+
+		expected, _ := hex.DecodeString(test.script)
+		if script := WitnessScript(version, program); !bytes.Equal(script, expected) {
+			t.Errorf("Script for %s should be %s, got %x", test.address, test.script, script)
+		}
+
+		var encoded string
+		if encoded, err = EncodeSegWitAddress(test.hrp, version, program); err != nil {
+			t.Errorf("Error encoding %s: %s", test.address, err)
+			continue
+		}
+		if encoded != strings.ToLower(test.address) {
+			t.Errorf("Re-encoding %s gave %s", test.address, encoded)
+		}
+	}
+
+	for _, test := range invalidSegWitAddresses {
+		if _, _, err := DecodeSegWitAddress(test.hrp, test.address); err == nil {
+			t.Errorf("Address %s should be invalid because of %s", test.address, test.reason)
+		}
+	}
+}
+
+// TestScriptType checks that taproot and future witness versions are recognized
+func TestScriptType(t *testing.T) {
+	for _, test := range validSegWitAddresses {
+		script, _ := hex.DecodeString(test.script)
+		scriptType, _ := ScriptType(script)
+
+		expected := "WITNESS"
+		switch {
+		case len(script) == 22 && script[0] == 0x00:
+			expected = "P2WPKH"
+		case len(script) == 34 && script[0] == 0x00:
+			expected = "P2WSH"
+		case len(script) == 34 && script[0] == 0x51:
+			expected = "P2TR"
+		}
+		if scriptType != expected {
+			t.Errorf("Script %s should be %s, got %s", test.script, expected, scriptType)
+		}
+	}
+
+	// a version 0 program that isn't 20 or 32 bytes isn't a witness program
+	if scriptType, _ := ScriptType(append([]byte{0x00, 0x10}, make([]byte, 16)...)); scriptType != "INVALID" {
+		t.Errorf("16 byte version 0 program should be INVALID, got %s", scriptType)
+	}
+}
+
+// TestAddressScript checks that addresses are only valid for the coin they're for, and that the
+// scripts they pay to have the same address
+func TestAddressScript(t *testing.T) {
+	taproot := "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"
+	for _, address := range []string{
+		taproot,
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+		"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy",
+	} {
+		script, err := AddressScript(address, &coinparam.BitcoinParams)
+		if err != nil {
+			t.Errorf("Error getting script for %s: %s", address, err)
+			continue
+		}
+
+		var roundTrip string
+		if roundTrip, err = ScriptAddress(script, &coinparam.BitcoinParams); err != nil {
+			t.Errorf("Error getting address for %s script: %s", address, err)
+			continue
+		}
+		if roundTrip != address {
+			t.Errorf("Script for %s has address %s", address, roundTrip)
+		}
+	}
+
+	if err := ValidateAddress(taproot, &coinparam.TestNet3Params); err == nil {
+		t.Errorf("Mainnet address %s shouldn't be valid on testnet", taproot)
+	}
+	if err := ValidateAddress("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", &coinparam.TestNet3Params); err == nil {
+		t.Errorf("Mainnet P2PKH address shouldn't be valid on testnet")
+	}
+	if err := ValidateAddress("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", &coinparam.BitcoinParams); err == nil {
+		t.Errorf("Pubkeys shouldn't be valid withdrawal addresses")
+	}
+}
@@ -4,6 +4,9 @@
 package chainutils
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/mit-dci/lit/coinparam"
 )
 
@@ -39,3 +42,26 @@ func HostParamsFromCoinList(coinList []*coinparam.Params) (hParams []*HostParams
 	}
 	return
 }
+
+// ParseHostParams parses full node hosts of the form coin=host, for example litecoin=localhost:9333.
+// The coin is the name of any registered coinparam.
+func ParseHostParams(hostStrs []string) (hParams []*HostParams, err error) {
+	for _, hostStr := range hostStrs {
+		parts := strings.SplitN(hostStr, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			err = fmt.Errorf("Host %s should look like coin=host", hostStr)
+			return
+		}
+
+		var coin *coinparam.Params
+		if coin, err = GetParamFromName(parts[0]); err != nil {
+			err = fmt.Errorf("Error getting coin for host %s: %s", hostStr, err)
+			return
+		}
+		hParams = append(hParams, &HostParams{
+			Param: coin,
+			Host:  parts[1],
+		})
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package chainutils
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/wire"
+	"golang.org/x/crypto/scrypt"
+)
+
+// LitecoinParams are the parameters for the litecoin main network, which lit doesn't have. They're
+// registered with coinparam when chainutils is imported, so lit's address and key code knows about
+// them too.
+var LitecoinParams = coinparam.Params{
+	Name:          "litecoin",
+	NetMagicBytes: 0xdbb6c0fb,
+	DefaultPort:   "9333",
+	DNSSeeds: []string{
+		"seed-a.litecoin.loshan.co.uk",
+		"dnsseed.thrasher.io",
+		"dnsseed.litecointools.com",
+		"dnsseed.litecoinpool.org",
+	},
+
+	// Chain parameters
+	GenesisBlock: &litecoinGenesisBlock,
+	GenesisHash:  &litecoinGenesisHash,
+	PoWFunction: func(b []byte, height int32) chainhash.Hash {
+		scryptBytes, _ := scrypt.Key(b, b, 1024, 1, 1, 32)
+		asChainHash, _ := chainhash.NewHash(scryptBytes)
+		return *asChainHash
+	},
+	// lit's bitcoin difficulty function already does litecoin's retargeting for a coin named
+	// litecoin, it just isn't exported
+	DiffCalcFunction:         coinparam.LiteCoinTestNet4Params.DiffCalcFunction,
+	FeePerByte:               80,
+	PowLimit:                 litecoinPowLimit,
+	PowLimitBits:             0x1e0fffff,
+	CoinbaseMaturity:         100,
+	SubsidyReductionInterval: 840000,
+	TargetTimespan:           time.Hour * 84,    // 84 hours (3.5 days)
+	TargetTimePerBlock:       time.Second * 150, // 150 seconds (2.5 min)
+	RetargetAdjustmentFactor: 4,                 // 25% less, 400% more
+	ReduceMinDifficulty:      false,
+	MinDiffReductionTime:     0,
+	GenerateSupported:        false,
+
+	// Checkpoints ordered from oldest to newest.
+	Checkpoints: []coinparam.Checkpoint{},
+
+	// Enforce current block version once majority of the network has
+	// upgraded.
+	// 75% (750 / 1000)
+	// Reject previous block versions once a majority of the network has
+	// upgraded.
+	// 95% (950 / 1000)
+	BlockEnforceNumRequired: 750,
+	BlockRejectNumRequired:  950,
+	BlockUpgradeNumToCheck:  1000,
+
+	// Mempool parameters
+	RelayNonStdTxs: false,
+
+	// Address encoding magics
+	PubKeyHashAddrID: 0x30,  // starts with L
+	ScriptHashAddrID: 0x32,  // starts with M
+	PrivateKeyID:     0xb0,  // starts with 6 (uncompressed) or T (compressed)
+	Bech32Prefix:     "ltc", // starts with ltc1
+
+	// BIP32 hierarchical deterministic extended key magics, litecoin core uses the same ones as
+	// bitcoin
+	HDPrivateKeyID: [4]byte{0x04, 0x88, 0xad, 0xe4}, // starts with xprv
+	HDPublicKeyID:  [4]byte{0x04, 0x88, 0xb2, 0x1e}, // starts with xpub
+
+	// BIP44 coin type used in the hierarchical deterministic path for
+	// address generation. SLIP44 says litecoin is 2, but lit already
+	// registers its BC2 test chain as 2.
+	HDCoinType: 65538,
+}
+
+// litecoinPowLimit is the highest proof of work value a litecoin block can have, 2^236 - 1
+var litecoinPowLimit = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 236), big.NewInt(1))
+
+// litecoinGenesisHash is the hash of the first block on litecoin
+var litecoinGenesisHash = chainhash.Hash([chainhash.HashSize]byte{
+	0xe2, 0xbf, 0x04, 0x7e, 0x7e, 0x5a, 0x19, 0x1a, 0xa4, 0xef, 0x34, 0xd3,
+	0x14, 0x97, 0x9d, 0xc9, 0x98, 0x6e, 0x0f, 0x19, 0x25, 0x1e, 0xda, 0xba,
+	0x59, 0x40, 0xfd, 0x1f, 0xe3, 0x65, 0xa7, 0x12,
+})
+
+// litecoinMerkleRoot is the merkle root of the litecoin genesis block
+var litecoinMerkleRoot = chainhash.Hash([chainhash.HashSize]byte{
+	0xd9, 0xce, 0xd4, 0xed, 0x11, 0x30, 0xf7, 0xb7, 0xfa, 0xad, 0x9b, 0xe2,
+	0x53, 0x23, 0xff, 0xaf, 0xa3, 0x32, 0x32, 0xa1, 0x7c, 0x3e, 0xdf, 0x6c,
+	0xfd, 0x97, 0xbe, 0xe6, 0xba, 0xfb, 0xdd, 0x97,
+})
+
+// litecoinGenesisBlock is the header of the first block on litecoin
+var litecoinGenesisBlock = wire.MsgBlock{
+	Header: wire.BlockHeader{
+		Version:    1,
+		PrevBlock:  chainhash.Hash{},
+		MerkleRoot: litecoinMerkleRoot,
+		Timestamp:  time.Unix(1317972665, 0),
+		Bits:       0x1e0ffff0,
+		Nonce:      2084524493,
+	},
+}
+
+func init() {
+	if err := coinparam.Register(&LitecoinParams); err != nil {
+		panic("failed to register litecoin: " + err.Error())
+	}
+}
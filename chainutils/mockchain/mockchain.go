@@ -0,0 +1,352 @@
+package mockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/blockchain"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/wire"
+)
+
+const (
+	// channelBuffer is how many blocks or heights each channel holds before mining blocks, same
+	// as the uspv chainhook
+	channelBuffer = 8
+)
+
+// Chain is an in-process blockchain that implements uspv.ChainHook, so a wallit and the exchange
+// can run against it without a node or a network. Blocks are only mined when MineBlock is
+// called, they have whatever transactions they're given and everything that was broadcast, and
+// they don't have any proof of work. Everything is deterministic, blocks are one target block time
+// apart starting from the genesis block.
+type Chain struct {
+	params *coinparam.Params
+
+	// notifyMtx is held while blocks are mined or disconnected, so everyone hears about them in
+	// order. It's acquired before mtx, which isn't held while sending on the channels because the
+	// wallit registers outpoints while it's ingesting.
+	notifyMtx sync.Mutex
+	mtx       sync.Mutex
+	// blocks are the blocks in the chain, by height, starting with the genesis block
+	blocks []*wire.MsgBlock
+	// mempool is everything that's been broadcast or disconnected and not mined yet
+	mempool []*wire.MsgTx
+
+	// txChan and heightChan are how the wallit hears about its transactions and new heights
+	txChan     chan lnutil.TxAndHeight
+	heightChan chan int32
+	// rawBlockChans and heightChans are everyone else listening for blocks and heights
+	rawBlockChans []chan *wire.MsgBlock
+	heightChans   []chan int32
+
+	// addresses and outPoints are what the wallit cares about, only transactions paying to or
+	// spending them are sent to it
+	addresses map[[20]byte]bool
+	outPoints map[wire.OutPoint]bool
+}
+
+// NewChain creates a chain for a coin with only the coin's genesis block
+func NewChain(params *coinparam.Params) (chain *Chain) {
+	chain = &Chain{
+		params:    params,
+		blocks:    []*wire.MsgBlock{params.GenesisBlock},
+		addresses: make(map[[20]byte]bool),
+		outPoints: make(map[wire.OutPoint]bool),
+	}
+	return
+}
+
+// Start starts the chain for a wallit. The chain always starts at the genesis block, so the
+// height, host and path are ignored.
+func (c *Chain) Start(height int32, host, path string, proxyURL string, params *coinparam.Params) (txChan chan lnutil.TxAndHeight, heightChan chan int32, err error) {
+	if params != c.params {
+		err = fmt.Errorf("Chain is for %s, can't start it for %s", c.params.Name, params.Name)
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.txChan = make(chan lnutil.TxAndHeight, channelBuffer)
+	c.heightChan = make(chan int32, channelBuffer)
+	txChan, heightChan = c.txChan, c.heightChan
+	return
+}
+
+// RegisterAddress tells the chain to send the wallit transactions paying to a key hash
+func (c *Chain) RegisterAddress(address [20]byte) (err error) {
+	c.mtx.Lock()
+	c.addresses[address] = true
+	c.mtx.Unlock()
+	return
+}
+
+// RegisterOutPoint tells the chain to send the wallit transactions spending an outpoint
+func (c *Chain) RegisterOutPoint(op wire.OutPoint) (err error) {
+	c.mtx.Lock()
+	c.outPoints[op] = true
+	c.mtx.Unlock()
+	return
+}
+
+// UnregisterOutPoint tells the chain the wallit doesn't care about an outpoint anymore
+func (c *Chain) UnregisterOutPoint(op wire.OutPoint) (err error) {
+	c.mtx.Lock()
+	delete(c.outPoints, op)
+	c.mtx.Unlock()
+	return
+}
+
+// NewRawBlocksChannel returns a new channel that gets every block that's mined
+func (c *Chain) NewRawBlocksChannel() (blockChan chan *wire.MsgBlock) {
+	blockChan = make(chan *wire.MsgBlock, channelBuffer)
+	c.mtx.Lock()
+	c.rawBlockChans = append(c.rawBlockChans, blockChan)
+	c.mtx.Unlock()
+	return
+}
+
+// NewHeightChannel returns a new channel that gets the height of every block that's mined, and
+// the height the chain went back to when there's a reorg
+func (c *Chain) NewHeightChannel() (heightChan chan int32) {
+	heightChan = make(chan int32, channelBuffer)
+	c.mtx.Lock()
+	c.heightChans = append(c.heightChans, heightChan)
+	c.mtx.Unlock()
+	return
+}
+
+// RawBlocks returns a new channel that gets every block that's mined
+func (c *Chain) RawBlocks() (blockChan chan *wire.MsgBlock) {
+	return c.NewRawBlocksChannel()
+}
+
+// PushTx broadcasts a transaction, it goes in the next block that's mined. Transactions in the
+// mempool that spend the same outputs are replaced, like with replace by fee, but the fee isn't
+// checked.
+func (c *Chain) PushTx(tx *wire.MsgTx) (err error) {
+	if err = blockchain.CheckTransactionSanity(btcutil.NewTx(tx)); err != nil {
+		err = fmt.Errorf("Transaction %s is invalid: %s", tx.TxHash(), err)
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	txid := tx.TxHash()
+	spends := make(map[wire.OutPoint]bool)
+	for _, txIn := range tx.TxIn {
+		spends[txIn.PreviousOutPoint] = true
+	}
+
+	var kept []*wire.MsgTx
+	for _, mempoolTx := range c.mempool {
+		if mempoolTx.TxHash() == txid {
+			err = fmt.Errorf("Transaction %s was already broadcast", txid)
+			return
+		}
+		conflicts := false
+		for _, txIn := range mempoolTx.TxIn {
+			if spends[txIn.PreviousOutPoint] {
+				conflicts = true
+				break
+			}
+		}
+		if !conflicts {
+			kept = append(kept, mempoolTx)
+		}
+	}
+	c.mempool = append(kept, tx)
+	return
+}
+
+// Mempool returns the transactions that have been broadcast and not mined yet
+func (c *Chain) Mempool() (txs []*wire.MsgTx) {
+	c.mtx.Lock()
+	txs = append(txs, c.mempool...)
+	c.mtx.Unlock()
+	return
+}
+
+// Height returns the height of the tip of the chain
+func (c *Chain) Height() (height int32) {
+	c.mtx.Lock()
+	height = int32(len(c.blocks) - 1)
+	c.mtx.Unlock()
+	return
+}
+
+// Block returns the block at a height
+func (c *Chain) Block(height int32) (block *wire.MsgBlock, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if height < 0 || int(height) >= len(c.blocks) {
+		err = fmt.Errorf("No block at height %d, the chain is at %d", height, len(c.blocks)-1)
+		return
+	}
+	block = c.blocks[height]
+	return
+}
+
+// MineBlock mines a block with everything in the mempool and txs, and tells everyone listening
+// about it
+func (c *Chain) MineBlock(txs ...*wire.MsgTx) (block *wire.MsgBlock, err error) {
+	for _, tx := range txs {
+		if err = blockchain.CheckTransactionSanity(btcutil.NewTx(tx)); err != nil {
+			err = fmt.Errorf("Transaction %s is invalid: %s", tx.TxHash(), err)
+			return
+		}
+	}
+
+	c.notifyMtx.Lock()
+	defer c.notifyMtx.Unlock()
+
+	c.mtx.Lock()
+	height := int32(len(c.blocks))
+	tip := c.blocks[height-1]
+
+	blockTxs := []*wire.MsgTx{coinbaseTx(height)}
+	blockTxs = append(blockTxs, c.mempool...)
+	blockTxs = append(blockTxs, txs...)
+	c.mempool = nil
+
+	utilTxs := make([]*btcutil.Tx, len(blockTxs))
+	for i, tx := range blockTxs {
+		utilTxs[i] = btcutil.NewTx(tx)
+	}
+	merkles := blockchain.BuildMerkleTreeStore(utilTxs, false)
+
+	block = &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    1,
+			PrevBlock:  tip.Header.BlockHash(),
+			MerkleRoot: *merkles[len(merkles)-1],
+			Timestamp:  tip.Header.Timestamp.Add(c.params.TargetTimePerBlock).Truncate(time.Second),
+			Bits:       c.params.PowLimitBits,
+		},
+		Transactions: blockTxs,
+	}
+	c.blocks = append(c.blocks, block)
+
+	var walletTxs []*wire.MsgTx
+	for _, tx := range blockTxs {
+		if c.relevant(tx) {
+			walletTxs = append(walletTxs, tx)
+		}
+	}
+	txChan, walletHeightChan := c.txChan, c.heightChan
+	rawBlockChans, heightChans := c.rawBlockChans, c.heightChans
+	c.mtx.Unlock()
+
+	// blocks go out before heights, like the uspv chainhook
+	for _, blockChan := range rawBlockChans {
+		blockChan <- block
+	}
+	if txChan != nil {
+		for _, tx := range walletTxs {
+			txChan <- lnutil.TxAndHeight{Tx: tx, Height: height}
+		}
+		walletHeightChan <- height
+	}
+	for _, heightChan := range heightChans {
+		heightChan <- height
+	}
+	return
+}
+
+// MineBlocks mines n blocks, the first one has everything in the mempool
+func (c *Chain) MineBlocks(n int) (err error) {
+	for i := 0; i < n; i++ {
+		if _, err = c.MineBlock(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Reorg disconnects every block from height up. The transactions in them go back in the mempool,
+// so they're mined again in the next block unless they're replaced.
+func (c *Chain) Reorg(height int32) (err error) {
+	c.notifyMtx.Lock()
+	defer c.notifyMtx.Unlock()
+
+	c.mtx.Lock()
+	if height <= 0 || int(height) >= len(c.blocks) {
+		err = fmt.Errorf("Can't reorg back to height %d, the chain is at %d", height, len(c.blocks)-1)
+		c.mtx.Unlock()
+		return
+	}
+
+	var disconnected []*wire.MsgTx
+	for _, block := range c.blocks[height:] {
+		disconnected = append(disconnected, block.Transactions[1:]...)
+	}
+	c.blocks = c.blocks[:height]
+	c.mempool = append(disconnected, c.mempool...)
+	walletHeightChan, heightChans := c.heightChan, c.heightChans
+	c.mtx.Unlock()
+
+	// the wallit keeps utxos at the height it's told, so it's told the new tip. Everyone else is
+	// told the first height that was disconnected, like the uspv chainhook does.
+	if walletHeightChan != nil {
+		walletHeightChan <- height - 1
+	}
+	for _, heightChan := range heightChans {
+		heightChan <- height
+	}
+	return
+}
+
+// relevant returns whether a transaction pays to an address or spends an outpoint the wallit
+// cares about. The lock should be held.
+func (c *Chain) relevant(tx *wire.MsgTx) bool {
+	for _, txOut := range tx.TxOut {
+		var keyHash [20]byte
+		if hash := lnutil.KeyHashFromPkScript(txOut.PkScript); len(hash) == 20 {
+			copy(keyHash[:], hash)
+			if c.addresses[keyHash] {
+				return true
+			}
+		}
+	}
+	for _, txIn := range tx.TxIn {
+		if c.outPoints[txIn.PreviousOutPoint] {
+			return true
+		}
+	}
+	return false
+}
+
+// coinbaseTx returns a coinbase for a block at a height. It has the height in it, like BIP34, so
+// every coinbase has a different txid, and it doesn't pay anything.
+func coinbaseTx(height int32) (tx *wire.MsgTx) {
+	var heightBytes [4]byte
+	binary.LittleEndian.PutUint32(heightBytes[:], uint32(height))
+	sigScript := append([]byte{byte(len(heightBytes))}, heightBytes[:]...)
+
+	tx = wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), sigScript, nil))
+	// OP_TRUE
+	tx.AddTxOut(wire.NewTxOut(0, []byte{0x51}))
+	return
+}
+
+// FundingTx returns a transaction paying value to pkScript, spending an output that doesn't exist.
+// The chain doesn't check inputs, so it's an easy way to send coins to an address in a test. The
+// seed makes the transaction unique.
+func FundingTx(pkScript []byte, value int64, seed uint32) (tx *wire.MsgTx) {
+	var prevHash chainhash.Hash
+	binary.BigEndian.PutUint32(prevHash[:], seed)
+	// so it's never the null hash a coinbase spends
+	prevHash[len(prevHash)-1] = 0x01
+
+	tx = wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(value, pkScript))
+	return
+}
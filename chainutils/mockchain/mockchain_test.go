@@ -0,0 +1,103 @@
+package mockchain
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/wire"
+)
+
+var testScript = []byte{0x51}
+
+func TestMineBlock(t *testing.T) {
+	chain := NewChain(&coinparam.RegressionNetParams)
+	blockChan := chain.NewRawBlocksChannel()
+	heightChan := chain.NewHeightChannel()
+
+	if err := chain.PushTx(FundingTx(testScript, 1000, 1)); err != nil {
+		t.Fatalf("push tx: %v", err)
+	}
+	block, err := chain.MineBlock(FundingTx(testScript, 2000, 2))
+	if err != nil {
+		t.Fatalf("mine block: %v", err)
+	}
+
+	// coinbase, then the mempool, then the txs given to MineBlock
+	if len(block.Transactions) != 3 || block.Transactions[1].TxOut[0].Value != 1000 || block.Transactions[2].TxOut[0].Value != 2000 {
+		t.Fatalf("block has the wrong transactions")
+	}
+	if len(chain.Mempool()) != 0 {
+		t.Fatalf("mempool should be empty after mining, has %d", len(chain.Mempool()))
+	}
+	if block.Header.PrevBlock != *coinparam.RegressionNetParams.GenesisHash {
+		t.Fatalf("block doesn't build on the genesis block")
+	}
+	if height := <-heightChan; height != 1 {
+		t.Fatalf("height should be 1, got %d", height)
+	}
+	if notified := <-blockChan; notified.BlockHash() != block.BlockHash() {
+		t.Fatalf("wrong block sent on the raw blocks channel")
+	}
+}
+
+func TestReorg(t *testing.T) {
+	chain := NewChain(&coinparam.RegressionNetParams)
+	if err := chain.MineBlocks(2); err != nil {
+		t.Fatalf("mine blocks: %v", err)
+	}
+	deposit := FundingTx(testScript, 1000, 1)
+	if _, err := chain.MineBlock(deposit); err != nil {
+		t.Fatalf("mine block: %v", err)
+	}
+	heightChan := chain.NewHeightChannel()
+
+	if err := chain.Reorg(0); err == nil {
+		t.Fatalf("reorging out the genesis block should fail")
+	}
+	if err := chain.Reorg(3); err != nil {
+		t.Fatalf("reorg: %v", err)
+	}
+	if chain.Height() != 2 {
+		t.Fatalf("height should be 2 after the reorg, got %d", chain.Height())
+	}
+	if height := <-heightChan; height != 3 {
+		t.Fatalf("reorg should send the first disconnected height 3, got %d", height)
+	}
+	mempool := chain.Mempool()
+	if len(mempool) != 1 || mempool[0].TxHash() != deposit.TxHash() {
+		t.Fatalf("disconnected transaction should be back in the mempool")
+	}
+
+	// the next block mined at height 3 has the transaction again
+	block, err := chain.MineBlock()
+	if err != nil {
+		t.Fatalf("mine block: %v", err)
+	}
+	if len(block.Transactions) != 2 || block.Transactions[1].TxHash() != deposit.TxHash() {
+		t.Fatalf("disconnected transaction should be mined again")
+	}
+}
+
+func TestPushTxReplaces(t *testing.T) {
+	chain := NewChain(&coinparam.RegressionNetParams)
+	original := FundingTx(testScript, 1000, 1)
+	if err := chain.PushTx(original); err != nil {
+		t.Fatalf("push tx: %v", err)
+	}
+	if err := chain.PushTx(original); err == nil {
+		t.Fatalf("pushing the same transaction twice should fail")
+	}
+
+	replacement := wire.NewMsgTx()
+	replacement.Version = original.Version
+	replacement.AddTxIn(wire.NewTxIn(&original.TxIn[0].PreviousOutPoint, nil, nil))
+	replacement.AddTxOut(wire.NewTxOut(900, testScript))
+	if err := chain.PushTx(replacement); err != nil {
+		t.Fatalf("push replacement: %v", err)
+	}
+
+	mempool := chain.Mempool()
+	if len(mempool) != 1 || mempool[0].TxHash() != replacement.TxHash() {
+		t.Fatalf("replacement should be the only transaction in the mempool")
+	}
+}
@@ -0,0 +1,70 @@
+package mockchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mit-dci/lit/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wallit"
+)
+
+// NewWallit creates a wallit with its files in dataDir that gets its blocks from the chain and
+// broadcasts to it, instead of connecting to a node.
+func NewWallit(chain *Chain, rootKey *hdkeychain.ExtendedKey, dataDir string) (wallet *wallit.Wallit, err error) {
+	// wallit.NewWallit always starts a uspv chainhook. Putting a directory where the chainhook's
+	// header file goes makes it fail before it touches the network, then the wallet gets this
+	// chain as its hook instead.
+	wallitPath := filepath.Join(dataDir, chain.params.Name)
+	if err = os.MkdirAll(filepath.Join(wallitPath, "header.bin"), 0700); err != nil {
+		err = fmt.Errorf("Error creating wallit directory for NewWallit: %s", err)
+		return
+	}
+
+	if wallet, _, err = wallit.NewWallit(rootKey, 0, false, "", dataDir, "", chain.params); wallet == nil {
+		err = fmt.Errorf("Error creating wallit for NewWallit: %s", err)
+		return
+	}
+	err = nil
+
+	if wallet.StateDB == nil {
+		err = fmt.Errorf("Could not open the wallit db in %s for NewWallit", wallitPath)
+		return
+	}
+	wallet.Hook = chain
+
+	// tell the chain about everything the wallet already has, like a chainhook would be told
+	var addresses [][20]byte
+	if addresses, err = wallet.AdrDump(); err != nil {
+		err = fmt.Errorf("Error getting wallit addresses for NewWallit: %s", err)
+		return
+	}
+	for _, address := range addresses {
+		if err = chain.RegisterAddress(address); err != nil {
+			return
+		}
+	}
+
+	var utxos []*portxo.PorTxo
+	if utxos, err = wallet.UtxoDump(); err != nil {
+		err = fmt.Errorf("Error getting wallit utxos for NewWallit: %s", err)
+		return
+	}
+	for _, utxo := range utxos {
+		if err = chain.RegisterOutPoint(utxo.Op); err != nil {
+			return
+		}
+	}
+
+	var txChan chan lnutil.TxAndHeight
+	var heightChan chan int32
+	if txChan, heightChan, err = chain.Start(0, "", wallitPath, "", chain.params); err != nil {
+		err = fmt.Errorf("Error starting chain for NewWallit: %s", err)
+		return
+	}
+	go wallet.TxHandler(txChan)
+	go wallet.HeightHandler(heightChan)
+	return
+}
@@ -1,6 +1,7 @@
 package chainutils
 
-// ScriptType takes in a script and returns "P2PKH", "P2WPKH", "P2SH", "P2WSH", "P2PK", "INVALID" denoting the type of transaction it is, and the relevant non opcode data
+// ScriptType takes in a script and returns "P2PKH", "P2WPKH", "P2SH", "P2WSH", "P2TR", "P2PK", "WITNESS" or "INVALID" denoting the type of transaction it is, and the relevant non opcode data.
+// "WITNESS" is any other witness program, for witness versions we don't know about yet, and the data is the program.
 func ScriptType(pkScript []byte) (string, []byte) {
 
 	if len(pkScript) == 22 && pkScript[0] == 0x00 && pkScript[1] == 0x14 {
@@ -19,9 +20,46 @@ func ScriptType(pkScript []byte) (string, []byte) {
 		return "P2WSH", pkScript[2:34]
 	}
 
+	if len(pkScript) == 34 && pkScript[0] == 0x51 && pkScript[1] == 0x20 {
+		return "P2TR", pkScript[2:34]
+	}
+
 	if len(pkScript) == 67 && pkScript[0] == 0x41 && pkScript[66] == 0xac {
 		return "P2PK", pkScript[1:66]
 	}
 
+	if version, program, ok := WitnessProgram(pkScript); ok && version != 0 {
+		return "WITNESS", program
+	}
+
 	return "INVALID", nil
 }
+
+// WitnessProgram returns the witness version and program of a script, and false if the script
+// isn't a witness program. A witness program is a version push (OP_0 or OP_1 through OP_16)
+// followed by a single 2 to 40 byte push.
+func WitnessProgram(pkScript []byte) (version byte, program []byte, ok bool) {
+	if len(pkScript) < 4 || len(pkScript) > 42 {
+		return
+	}
+	if int(pkScript[1]) != len(pkScript)-2 {
+		return
+	}
+
+	switch {
+	case pkScript[0] == 0x00:
+		version = 0
+		// version 0 programs are only ever P2WPKH or P2WSH
+		if len(pkScript) != 22 && len(pkScript) != 34 {
+			return
+		}
+	case pkScript[0] >= 0x51 && pkScript[0] <= 0x60:
+		version = pkScript[0] - 0x50
+	default:
+		return
+	}
+
+	program = pkScript[2:]
+	ok = true
+	return
+}
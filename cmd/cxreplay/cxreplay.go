@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mit-dci/opencx/cxdb/cxdbbolt"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/cxevent"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+type cxreplayConfig struct {
+	// the event log to replay
+	EventLog string `long:"log" short:"l" description:"Event log to replay" required:"true"`
+
+	// which engines to replay against, and what to compare to
+	Engine  string `long:"engine" short:"e" description:"Engine to replay the events against, either memory or bolt"`
+	Against string `long:"against" short:"a" description:"What to compare the replay to, either recorded, memory or bolt"`
+
+	// where bolt engines keep their files, a temporary directory is used if this isn't set
+	DataDir string `long:"datadir" short:"d" description:"Directory for bolt engine files, should not have any engine files in it already"`
+
+	// logging and debug parameters
+	LogLevel []bool `short:"v" description:"Set verbosity level to verbose (-v), very verbose (-vv) or very very verbose (-vvv)"`
+}
+
+var (
+	defaultEngine  = memoryEngine
+	defaultAgainst = recordedResults
+)
+
+const (
+	memoryEngine    = "memory"
+	boltEngine      = "bolt"
+	recordedResults = "recorded"
+)
+
+// newConfigParser returns a new command line flags parser.
+func newConfigParser(conf *cxreplayConfig, options flags.Options) *flags.Parser {
+	parser := flags.NewParser(conf, options)
+	return parser
+}
+
+// cxreplay re-runs an event log recorded by opencxd or frred against a set of matching engines,
+// and prints every event where the executions don't match.
+func main() {
+	var err error
+
+	conf := &cxreplayConfig{
+		Engine:  defaultEngine,
+		Against: defaultAgainst,
+	}
+
+	parser := newConfigParser(conf, flags.Default)
+	if _, err = parser.Parse(); err != nil {
+		if flags.WroteHelp(err) {
+			return
+		}
+		logging.Fatalf("Error parsing args: \n%s", err)
+	}
+	logging.SetLogLevel(len(conf.LogLevel))
+
+	var events []*cxevent.Event
+	if events, err = cxevent.ReadEventLog(conf.EventLog); err != nil {
+		logging.Fatalf("Error reading event log: %s", err)
+	}
+	pairList := eventPairs(events)
+	logging.Infof("Read %d events for %d pairs from %s", len(events), len(pairList), conf.EventLog)
+
+	// we remove the temporary directory ourselves since os.Exit doesn't run deferred calls
+	var tempDir string
+	if conf.DataDir == "" {
+		if tempDir, err = ioutil.TempDir("", "cxreplay"); err != nil {
+			logging.Fatalf("Error creating temporary data directory: %s", err)
+		}
+		conf.DataDir = tempDir
+	}
+
+	var got []*cxevent.Result
+	if got, err = replayAgainst(conf.Engine, events, pairList, filepath.Join(conf.DataDir, "engine")); err != nil {
+		logging.Fatalf("Error replaying against %s engines: %s", conf.Engine, err)
+	}
+
+	var expected []*cxevent.Result
+	if conf.Against == recordedResults {
+		expected = cxevent.RecordedResults(events)
+	} else if expected, err = replayAgainst(conf.Against, events, pairList, filepath.Join(conf.DataDir, "against")); err != nil {
+		logging.Fatalf("Error replaying against %s engines: %s", conf.Against, err)
+	}
+
+	if tempDir != "" {
+		os.RemoveAll(tempDir)
+	}
+
+	diffs := cxevent.Diff(events, expected, got)
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	fmt.Printf("%d events replayed against %s engines, %d differ from %s\n", len(events), conf.Engine, len(diffs), conf.Against)
+
+	if len(diffs) != 0 {
+		os.Exit(1)
+	}
+	return
+}
+
+// replayAgainst creates a fresh set of engines of some kind for the pairs, and replays the events
+// against them.
+func replayAgainst(engineKind string, events []*cxevent.Event, pairList []*match.Pair, dataDir string) (results []*cxevent.Result, err error) {
+	var limitEngines map[match.Pair]match.LimitEngine
+	var auctionEngines map[match.Pair]match.AuctionEngine
+	switch engineKind {
+	case memoryEngine:
+		if limitEngines, err = cxdbmemory.CreateLimitEngineMap(pairList); err != nil {
+			err = fmt.Errorf("Error creating memory limit engines: %s", err)
+			return
+		}
+		if auctionEngines, err = cxdbmemory.CreateAuctionEngineMap(pairList); err != nil {
+			err = fmt.Errorf("Error creating memory auction engines: %s", err)
+			return
+		}
+	case boltEngine:
+		if limitEngines, err = cxdbbolt.CreateLimitEngineMap(pairList, dataDir); err != nil {
+			err = fmt.Errorf("Error creating bolt limit engines: %s", err)
+			return
+		}
+		if auctionEngines, err = cxdbbolt.CreateAuctionEngineMap(pairList, dataDir); err != nil {
+			err = fmt.Errorf("Error creating bolt auction engines: %s", err)
+			return
+		}
+	default:
+		err = fmt.Errorf("Unknown engine %s, use %s or %s", engineKind, memoryEngine, boltEngine)
+		return
+	}
+
+	if results, err = cxevent.Replay(events, limitEngines, auctionEngines); err != nil {
+		return
+	}
+	return
+}
+
+// eventPairs returns every pair that an event goes to
+func eventPairs(events []*cxevent.Event) (pairList []*match.Pair) {
+	seen := make(map[match.Pair]bool)
+	for _, event := range events {
+		var pair *match.Pair
+		if event.Order != nil {
+			pair = &event.Order.Pair
+		} else if event.Pair != nil {
+			pair = event.Pair
+		} else {
+			continue
+		}
+		if !seen[*pair] {
+			seen[*pair] = true
+			pairList = append(pairList, pair)
+		}
+	}
+	return
+}
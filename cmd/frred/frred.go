@@ -4,6 +4,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/mit-dci/lit/coinparam"
@@ -13,7 +14,10 @@ import (
 	"github.com/mit-dci/opencx/cxauctionrpc"
 	"github.com/mit-dci/opencx/cxauctionserver"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxdb/cxdbbolt"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
 	"github.com/mit-dci/opencx/cxdb/cxdbsql"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -49,6 +53,12 @@ type frredConfig struct {
 	Litereghost string `long:"litereg" description:"Connect to litecoin regtest. Specify a socket address."`
 	Rtvtchost   string `long:"rtvtc" description:"Connect to Vertcoin regtest node. Specify a socket address."`
 
+	// any chain in the asset registry can be connected to with a host entry
+	Hosts []string `long:"host" description:"Connect to a full node for any coin, as coin=host, for example litecoin=localhost:9333. The coin has to have an asset, either built in or from an asset entry"`
+
+	// Assets on top of (or replacing) the built in ones
+	Assets []string `long:"asset" description:"Add or change an asset, as coin=asset:decimals:mindeposit:minwithdrawal:lotsize:ticksize:name, for example litecoin=2:8:100000:1000000:0:0:Litecoin. The coin is a coinparam name, the asset is the byte clients use for it, and amounts are in base units"`
+
 	// configuration for concurrent RPC users.
 	MaxPeers    uint16 `long:"numpeers" description:"Maximum number of peers that you'd like to support"`
 	MinPeerPort uint16 `long:"minpeerport" description:"Port to start creating ports for peers at"`
@@ -67,6 +77,16 @@ type frredConfig struct {
 	// Auction server options
 	AuctionTime  uint64 `long:"auctiontime" description:"Time it should take to generate a timelock puzzle protected order"`
 	MaxBatchSize uint64 `long:"maxbatchsize" description:"Maximum number of orders that can go in a batch"`
+
+	// Storage backend, sql needs a database server, bolt stores everything in the home directory
+	DBBackend string `long:"dbbackend" description:"Storage backend to use, either sql or bolt"`
+
+	// Memory stores backed by a write-ahead log, for the stores that have one
+	WAL                 bool   `long:"wal" description:"Whether or not to keep the auction engines, auction orderbooks, puzzle stores and settlement engines in memory, backed by a write-ahead log and snapshots in the wal directory, instead of in the storage backend"`
+	WALSnapshotInterval uint64 `long:"walsnapshotinterval" description:"How many records get written to a write-ahead log before the state is snapshotted and the log is cleared"`
+
+	// Event log, for replaying the auction engines offline with cxreplay
+	EventLog bool `long:"eventlog" description:"Whether or not to record every auction order and auction in an event log"`
 }
 
 var (
@@ -90,6 +110,24 @@ var (
 	// default auction options
 	defaultAuctionTime  = uint64(30000)
 	defaultMaxBatchSize = uint64(1000)
+
+	// default storage options
+	defaultDBBackend   = sqlBackend
+	defaultBoltDirName = "db"
+
+	// memory stores use the storage backend unless asked for
+	defaultWAL                 = false
+	defaultWALDirName          = "wal"
+	defaultWALSnapshotInterval = uint64(1000)
+
+	// the event log is off unless asked for
+	defaultEventLog         = false
+	defaultEventLogFileName = "events.log"
+)
+
+const (
+	sqlBackend  = "sql"
+	boltBackend = "bolt"
 )
 
 // newConfigParser returns a new command line flags parser.
@@ -113,38 +151,100 @@ func main() {
 		LightningSupport: defaultLightningSupport,
 		AuctionTime:      defaultAuctionTime,
 		MaxBatchSize:     defaultMaxBatchSize,
+		DBBackend:        defaultDBBackend,
+		EventLog:         defaultEventLog,
+
+		WAL:                 defaultWAL,
+		WALSnapshotInterval: defaultWALSnapshotInterval,
 	}
 
 	// Check and load config params
 	key := opencxSetup(&conf)
 
+	// Assets from the configuration have to be registered before anything looks them up
+	if err = registerAssets(&conf); err != nil {
+		logging.Fatalf("Error registering assets: %s", err)
+	}
+
 	// Generate the coin list based on the parameters we know
-	coinList := generateCoinList(&conf)
+	var coinList []*coinparam.Params
+	if coinList, err = generateCoinList(&conf); err != nil {
+		logging.Fatalf("Error generating coin list: %s", err)
+	}
 
 	var pairList []*match.Pair
 	if pairList, err = match.GenerateAssetPairs(coinList); err != nil {
 		logging.Fatalf("Could not generate asset pairs from coin list: %s", err)
 	}
 
-	// Create in memory matching engine
+	if conf.DBBackend != sqlBackend && conf.DBBackend != boltBackend {
+		logging.Fatalf("Unknown storage backend %s, use %s or %s", conf.DBBackend, sqlBackend, boltBackend)
+	}
+	boltDir := filepath.Join(conf.FrredHomeDir, defaultBoltDirName)
+	walConf := &cxdbmemory.WALConfig{
+		Dir:              filepath.Join(conf.FrredHomeDir, defaultWALDirName),
+		SnapshotInterval: conf.WALSnapshotInterval,
+	}
+
+	// Create matching engines
 	var mengines map[match.Pair]match.AuctionEngine
-	if mengines, err = cxdbsql.CreateAuctionEngineMap(pairList); err != nil {
-		logging.Fatalf("Error creating auction engines for pairs: %s", err)
+	if conf.WAL {
+		if mengines, err = cxdbmemory.CreateAuctionEngineMapWithWAL(pairList, walConf); err != nil {
+			logging.Fatalf("Error creating auction engines for pairs: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		if mengines, err = cxdbbolt.CreateAuctionEngineMap(pairList, boltDir); err != nil {
+			logging.Fatalf("Error creating auction engines for pairs: %s", err)
+		}
+	} else {
+		if mengines, err = cxdbsql.CreateAuctionEngineMap(pairList); err != nil {
+			logging.Fatalf("Error creating auction engines for pairs: %s", err)
+		}
 	}
 
 	var setEngines map[*coinparam.Params]match.SettlementEngine
-	if setEngines, err = cxdbsql.CreateSettlementEngineMap(coinList); err != nil {
-		logging.Fatalf("Error creating settlement engine map: %s", err)
+	if conf.WAL {
+		if setEngines, err = cxdbmemory.CreateSettlementEngineMapWithWAL(coinList, walConf); err != nil {
+			logging.Fatalf("Error creating settlement engine map: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		if setEngines, err = cxdbbolt.CreateSettlementEngineMap(coinList, boltDir); err != nil {
+			logging.Fatalf("Error creating settlement engine map: %s", err)
+		}
+	} else {
+		if setEngines, err = cxdbsql.CreateSettlementEngineMap(coinList); err != nil {
+			logging.Fatalf("Error creating settlement engine map: %s", err)
+		}
 	}
 
 	var auctionBooks map[match.Pair]match.AuctionOrderbook
-	if auctionBooks, err = cxdbsql.CreateAuctionOrderbookMap(pairList); err != nil {
-		logging.Fatalf("Error creating auction orderbook map: %s", err)
+	if conf.WAL {
+		if auctionBooks, err = cxdbmemory.CreateAuctionOrderbookMapWithWAL(pairList, walConf); err != nil {
+			logging.Fatalf("Error creating auction orderbook map: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		if auctionBooks, err = cxdbbolt.CreateAuctionOrderbookMap(pairList, boltDir); err != nil {
+			logging.Fatalf("Error creating auction orderbook map: %s", err)
+		}
+	} else {
+		if auctionBooks, err = cxdbsql.CreateAuctionOrderbookMap(pairList); err != nil {
+			logging.Fatalf("Error creating auction orderbook map: %s", err)
+		}
 	}
 
 	var puzzleStores map[match.Pair]cxdb.PuzzleStore
-	if puzzleStores, err = cxdbsql.CreatePuzzleStoreMap(pairList); err != nil {
-		logging.Fatalf("Error creating puzzle store map: %s", err)
+	if conf.WAL {
+		if puzzleStores, err = cxdbmemory.CreatePuzzleStoreMapWithWAL(pairList, walConf); err != nil {
+			logging.Fatalf("Error creating puzzle store map: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		if puzzleStores, err = cxdbbolt.CreatePuzzleStoreMap(pairList, boltDir); err != nil {
+			logging.Fatalf("Error creating puzzle store map: %s", err)
+		}
+	} else {
+		if puzzleStores, err = cxdbsql.CreatePuzzleStoreMap(pairList); err != nil {
+			logging.Fatalf("Error creating puzzle store map: %s", err)
+		}
 	}
 
 	var batchers map[match.Pair]match.AuctionBatcher
@@ -158,6 +258,18 @@ func main() {
 		logging.Fatalf("Error initializing server: \n%s", err)
 	}
 
+	// auction transcripts are signed with the same key that's used for noise
+	privkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
+	frredServer.SetExchangeKey(privkey)
+
+	if conf.EventLog {
+		var eventLog *cxevent.EventLog
+		if eventLog, err = cxevent.OpenEventLog(filepath.Join(conf.FrredHomeDir, defaultEventLogFileName)); err != nil {
+			logging.Fatalf("Error opening event log: %s", err)
+		}
+		frredServer.SetEventLog(eventLog)
+	}
+
 	if err = frredServer.StartClockRandomAuction(); err != nil {
 		logging.Fatalf("Error starting clock: %s", err)
 	}
@@ -184,6 +296,28 @@ func main() {
 				logging.Fatalf("Error killing server: %s", err)
 			}
 
+			if frredServer.EventLog != nil {
+				if err = frredServer.EventLog.Close(); err != nil {
+					logging.Errorf("Error closing event log: %s", err)
+				}
+			}
+
+			// stores with a write-ahead log take a final snapshot, so the next start doesn't replay
+			if conf.WAL {
+				for _, store := range mengines {
+					closeWALStore(store)
+				}
+				for _, store := range setEngines {
+					closeWALStore(store)
+				}
+				for _, store := range auctionBooks {
+					closeWALStore(store)
+				}
+				for _, store := range puzzleStores {
+					closeWALStore(store)
+				}
+			}
+
 			return
 		}
 	}()
@@ -195,7 +329,6 @@ func main() {
 			logging.Fatalf("Error listening for rpc for auction serer: %s", err)
 		}
 	} else {
-		privkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
 		// this tells us when the rpclisten is done
 		logging.Infof(" === will start to listen on noise-rpc ===")
 		if err = rpcListener.NoiseListen(privkey, conf.Rpchost, conf.Rpcport); err != nil {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/mit-dci/lit/lnutil"
 	litLogging "github.com/mit-dci/lit/logging"
 	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
 )
 
 var (
@@ -131,16 +133,36 @@ func opencxSetup(conf *frredConfig) *[32]byte {
 	return privkey
 }
 
-func generateCoinList(conf *frredConfig) []*coinparam.Params {
-	return util.HostParamList(generateHostParams(conf)).CoinListFromHostParams()
+// registerAssets adds the assets in the configuration to the asset registry, on top of the built
+// in ones. This has to happen before anything looks up an asset.
+func registerAssets(conf *frredConfig) (err error) {
+	for _, assetStr := range conf.Assets {
+		var info *match.AssetInfo
+		if info, err = match.ParseAssetInfo(assetStr); err != nil {
+			return
+		}
+		if err = match.RegisterAsset(info); err != nil {
+			err = fmt.Errorf("Error registering asset %s: %s", assetStr, err)
+			return
+		}
+	}
+	return
 }
 
-func generateHostParams(conf *frredConfig) (hostParamList []*util.HostParams) {
+func generateCoinList(conf *frredConfig) (coinList []*coinparam.Params, err error) {
+	var hostParamList []*util.HostParams
+	if hostParamList, err = generateHostParams(conf); err != nil {
+		return
+	}
+	coinList = util.HostParamList(hostParamList).CoinListFromHostParams()
+	return
+}
+
+func generateHostParams(conf *frredConfig) (hostParamList []*util.HostParams, err error) {
 	// Regular networks (Just like don't use any of these, I support them though)
 	hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.BitcoinParams, Host: conf.Btchost})
 	hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.VertcoinParams, Host: conf.Vtchost})
-	// Wait until supported by lit
-	// hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.LitecoinParams, Host: conf.Ltchost})
+	hostParamList = append(hostParamList, &util.HostParams{Param: &util.LitecoinParams, Host: conf.Ltchost})
 
 	// Test nets
 	hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.TestNet3Params, Host: conf.Tn3host})
@@ -151,5 +173,35 @@ func generateHostParams(conf *frredConfig) (hostParamList []*util.HostParams) {
 	hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.RegressionNetParams, Host: conf.Reghost})
 	hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.VertcoinRegTestParams, Host: conf.Rtvtchost})
 	hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.LiteRegNetParams, Host: conf.Litereghost})
+
+	// Anything else from host entries
+	var extraHosts []*util.HostParams
+	if extraHosts, err = util.ParseHostParams(conf.Hosts); err != nil {
+		return
+	}
+	for _, extraHost := range extraHosts {
+		for _, hostParam := range hostParamList {
+			if hostParam.Param == extraHost.Param && hostParam.Host != "" {
+				err = fmt.Errorf("Coin %s has more than one host", extraHost.Param.Name)
+				return
+			}
+		}
+		if _, err = match.AssetFromCoinParam(extraHost.Param); err != nil {
+			err = fmt.Errorf("Coin %s isn't an asset, add an asset entry for it", extraHost.Param.Name)
+			return
+		}
+	}
+	hostParamList = append(hostParamList, extraHosts...)
+	return
+}
+
+// closeWALStore calls DestroyHandler on a memory store, which takes a final snapshot and closes
+// its write-ahead log
+func closeWALStore(store interface{}) {
+	if destroyer, ok := store.(interface{ DestroyHandler() error }); ok {
+		if err := destroyer.DestroyHandler(); err != nil {
+			logging.Errorf("Error closing write-ahead log: %s", err)
+		}
+	}
 	return
 }
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+var getPubkeyCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getpubkey")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Print the compressed public key for your key, in hex.",
+		"This is what gets passed to opencxd with --adminpubkey to make this key the admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Print your public key."),
+}
+
+// GetPubkey prints the public key for the unlocked key
+func (cl *ocxClient) GetPubkey(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.RetrievePublicKey(); err != nil {
+		return
+	}
+
+	logging.Infof("Public key: %x\n", pubkey.SerializeCompressed())
+	return
+}
+
+var listWithdrawalsCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("listwithdrawals"), lnutil.ReqColor("asset"), lnutil.ReqColor("state")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"List every user's withdrawals of asset in state, which is one of requested, approved, broadcast, confirmed, or failed.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "List withdrawals of an asset in a state. Admin only."),
+}
+
+// ListWithdrawals lists every withdrawal of an asset in a state
+func (cl *ocxClient) ListWithdrawals(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+	state := args[1]
+
+	var listWithdrawalsReply *cxrpc.ListWithdrawalsReply
+	if listWithdrawalsReply, err = cl.RPCClient.ListWithdrawals(asset, state); err != nil {
+		return
+	}
+
+	if len(listWithdrawalsReply.Withdrawals) == 0 {
+		logging.Infof("No %s withdrawals for token %s\n", state, asset)
+		return
+	}
+	for _, withdrawal := range listWithdrawalsReply.Withdrawals {
+		logWithdrawal(withdrawal, asset)
+	}
+	return
+}
+
+var approveWithdrawalCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("approvewithdrawal"), lnutil.ReqColor("asset"), lnutil.ReqColor("id")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Approve a requested withdrawal so it's sent in the next batch.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Approve a requested withdrawal. Admin only."),
+}
+
+// ApproveWithdrawal approves a requested withdrawal
+func (cl *ocxClient) ApproveWithdrawal(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+	var id uint64
+	if id, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+		return
+	}
+
+	var approveWithdrawalReply *cxrpc.ApproveWithdrawalReply
+	if approveWithdrawalReply, err = cl.RPCClient.ApproveWithdrawal(asset, id); err != nil {
+		return
+	}
+
+	logWithdrawal(approveWithdrawalReply.Withdrawal, asset)
+	return
+}
+
+var rejectWithdrawalCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.Red("rejectwithdrawal"), lnutil.ReqColor("asset"), lnutil.ReqColor("id"), lnutil.ReqColor("reason")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Reject a withdrawal that hasn't been broadcast yet, refunding the user.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Reject a withdrawal and refund it. Admin only."),
+}
+
+// RejectWithdrawal rejects a withdrawal and refunds it
+func (cl *ocxClient) RejectWithdrawal(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+	var id uint64
+	if id, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+		return
+	}
+
+	var rejectWithdrawalReply *cxrpc.RejectWithdrawalReply
+	if rejectWithdrawalReply, err = cl.RPCClient.RejectWithdrawal(asset, id, args[2]); err != nil {
+		return
+	}
+
+	logWithdrawal(rejectWithdrawalReply.Withdrawal, asset)
+	return
+}
+
+var walletBalancesCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("walletbalances"), lnutil.ReqColor("asset")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show how much of asset the exchange holds in its hot and cold wallets, and the hot wallet ceiling.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Show hot and cold wallet balances. Admin only."),
+}
+
+// WalletBalances prints how much the exchange holds in its hot and cold wallets
+func (cl *ocxClient) WalletBalances(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var walletBalancesReply *cxrpc.WalletBalancesReply
+	if walletBalancesReply, err = cl.RPCClient.WalletBalances(asset); err != nil {
+		return
+	}
+
+	balances := walletBalancesReply.Balances
+	logging.Infof("Hot balance for token %s: %s\n", asset, formatAmount(balances.Hot, asset))
+	if balances.Destination == "" {
+		logging.Infof("No cold wallet for token %s\n", asset)
+		return
+	}
+	logging.Infof("Cold balance for token %s: %s\n", asset, formatAmount(balances.Cold, asset))
+	logging.Infof("Hot wallet ceiling: %s, cold wallet: %s\n", formatAmount(balances.HotCeiling, asset), balances.Destination)
+	return
+}
+
+var sweepToColdCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("sweeptocold"), lnutil.ReqColor("asset")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Sweep everything in the hot wallet above the hot wallet ceiling to the cold wallet now, rather than waiting for the sweeper.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Sweep the hot wallet to the cold wallet. Admin only."),
+}
+
+// SweepToCold sweeps the hot wallet down to its ceiling
+func (cl *ocxClient) SweepToCold(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var sweepToColdReply *cxrpc.SweepToColdReply
+	if sweepToColdReply, err = cl.RPCClient.SweepToCold(asset); err != nil {
+		return
+	}
+
+	if sweepToColdReply.Txid == "" {
+		logging.Infof("Hot wallet for token %s is under its ceiling, nothing to sweep\n", asset)
+		return
+	}
+	logging.Infof("Swept token %s to cold wallet in %s\n", asset, sweepToColdReply.Txid)
+	return
+}
+
+var createRefillCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.Red("createrefill"), lnutil.ReqColor("asset"), lnutil.ReqColor("amount"), lnutil.ReqColor("refillfile")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Create an unsigned transaction moving amount of asset from the cold wallet to the hot wallet, and write it to refillfile. The amount is decimal, like 0.5.",
+		"Take the file to the machine with the cold keys, sign it with signrefill, then send it with submitrefill.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Create a refill of the hot wallet for the cold keys to sign. Admin only."),
+}
+
+// CreateRefill creates an unsigned refill and writes it to a file
+func (cl *ocxClient) CreateRefill(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+	var parsedAsset match.Asset
+	if parsedAsset, err = match.AssetFromString(asset); err != nil {
+		return
+	}
+	var amount uint64
+	if amount, err = parsedAsset.ParseAmount(args[1]); err != nil {
+		return
+	}
+
+	var createRefillReply *cxrpc.CreateRefillReply
+	if createRefillReply, err = cl.RPCClient.CreateRefill(asset, amount); err != nil {
+		return
+	}
+
+	if err = writeRefill(args[2], createRefillReply.Refill); err != nil {
+		return
+	}
+	logging.Infof("Wrote unsigned refill of %s token %s spending %d cold outputs to %s\n", args[1], asset, len(createRefillReply.Refill.Inputs), args[2])
+	return
+}
+
+var signRefillCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("signrefill"), lnutil.ReqColor("refillfile"), lnutil.ReqColor("keyfile")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Sign the refill in refillfile with the cold key in keyfile, and write it back to refillfile.",
+		"The key is the xprv for a cold xpub, or the WIF for a cold address.",
+		"This doesn't talk to the exchange, so it can be run on a machine that's offline.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Sign a refill with the cold key, offline."),
+}
+
+// SignRefill signs a refill with a cold key. It doesn't need the exchange.
+func (cl *ocxClient) SignRefill(args []string) (err error) {
+	var refill *match.RefillTx
+	if refill, err = readRefill(args[0]); err != nil {
+		return
+	}
+
+	var keyBytes []byte
+	if keyBytes, err = ioutil.ReadFile(args[1]); err != nil {
+		err = fmt.Errorf("Error reading cold key file: %s", err)
+		return
+	}
+
+	if err = refill.Sign(strings.TrimSpace(string(keyBytes))); err != nil {
+		return
+	}
+	if err = refill.Verify(); err != nil {
+		return
+	}
+
+	if err = writeRefill(args[0], refill); err != nil {
+		return
+	}
+	logging.Infof("Signed refill in %s, send it with submitrefill\n", args[0])
+	return
+}
+
+var submitRefillCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("submitrefill"), lnutil.ReqColor("refillfile")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Send the signed refill in refillfile. The exchange checks it's a refill it created and that it's signed properly.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Send a signed refill. Admin only."),
+}
+
+// SubmitRefill sends a signed refill to the exchange
+func (cl *ocxClient) SubmitRefill(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var refill *match.RefillTx
+	if refill, err = readRefill(args[0]); err != nil {
+		return
+	}
+
+	var submitRefillReply *cxrpc.SubmitRefillReply
+	if submitRefillReply, err = cl.RPCClient.SubmitRefill(refill.Asset.String(), refill.Tx); err != nil {
+		return
+	}
+
+	logging.Infof("Sent token %s refill in %s\n", refill.Asset, submitRefillReply.Txid)
+	return
+}
+
+var reconcileLightningCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("reconcilelightning"), lnutil.ReqColor("asset")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Compare what's been credited for deposits over each open channel for asset with what the exchange has in the channel.",
+		"A channel doesn't reconcile if it was credited more than its capacity, for a state it hasn't reached, or if the exchange's side changed without a new state.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Reconcile lightning deposits with channel balances. Admin only."),
+}
+
+// ReconcileLightning prints the reconciliation of every channel for an asset that's had deposits
+func (cl *ocxClient) ReconcileLightning(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var reconcileLightningReply *cxrpc.ReconcileLightningReply
+	if reconcileLightningReply, err = cl.RPCClient.ReconcileLightning(asset); err != nil {
+		return
+	}
+
+	if len(reconcileLightningReply.Channels) == 0 {
+		logging.Infof("No open %s channels with deposits\n", asset)
+		return
+	}
+	for _, rec := range reconcileLightningReply.Channels {
+		status := "ok"
+		if rec.Problem != "" {
+			status = rec.Problem
+		}
+		logging.Infof("%s: %s credited up to state %d, %s sent back, %s in the channel at state %d, moved %d since, %s\n", rec.Outpoint, rec.Asset.FormatAmount(rec.Credited), rec.CreditedState, rec.Asset.FormatAmount(rec.Withdrawn), rec.Asset.FormatAmount(rec.ChannelAmount), rec.ChannelState, rec.Moved, status)
+	}
+	return
+}
+
+var getBreachAlertsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getbreachalerts")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Get every revoked channel state the exchange's watchtower has seen broadcast since the exchange started. The tower sends out the justice transaction for each one on its own.",
+		"A breach by the exchange is the exchange's node broadcasting a revoked state of a user's channel, which means something is badly wrong with it.",
+		"Your key must be the exchange's admin key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get channel breaches the watchtower has seen. Admin only."),
+}
+
+// GetBreachAlerts prints the breaches the exchange's watchtower has seen
+func (cl *ocxClient) GetBreachAlerts(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getBreachAlertsReply *cxrpc.GetBreachAlertsReply
+	if getBreachAlertsReply, err = cl.RPCClient.GetBreachAlerts(); err != nil {
+		return
+	}
+
+	if len(getBreachAlertsReply.Alerts) == 0 {
+		logging.Infof("No breaches\n")
+		return
+	}
+	for _, alert := range getBreachAlertsReply.Alerts {
+		logging.Infof("%s\n", alert)
+	}
+	return
+}
+
+// readRefill reads a refill written by writeRefill
+func readRefill(path string) (refill *match.RefillTx, err error) {
+	var refillBytes []byte
+	if refillBytes, err = ioutil.ReadFile(path); err != nil {
+		err = fmt.Errorf("Error reading refill file: %s", err)
+		return
+	}
+
+	refill = new(match.RefillTx)
+	if err = json.Unmarshal(refillBytes, refill); err != nil {
+		err = fmt.Errorf("Error parsing refill file: %s", err)
+		return
+	}
+	return
+}
+
+// writeRefill writes a refill to a file as json
+func writeRefill(path string, refill *match.RefillTx) (err error) {
+	var refillBytes []byte
+	if refillBytes, err = json.MarshalIndent(refill, "", "  "); err != nil {
+		err = fmt.Errorf("Error encoding refill: %s", err)
+		return
+	}
+
+	if err = ioutil.WriteFile(path, refillBytes, 0600); err != nil {
+		err = fmt.Errorf("Error writing refill file: %s", err)
+		return
+	}
+	return
+}
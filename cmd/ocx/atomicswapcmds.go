@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+var placeAtomicOrderCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.Red("placeatomicorder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Submit an order like placeorder on a pair the exchange settles by atomic swap. Nothing comes out of your balance, each fill is an atomic swap between your wallet and the other side's.",
+		"If your order takes another one you initiate the atomic swap with initiateatomic, otherwise you wait for the other side to lock their coins and then lock yours. See getatomicswaps for what to do next and the deadline for it.",
+		"If you don't do your part in time your orders on atomic swap pairs are cancelled, and abandoning too many atomic swaps bans you from placing them for a while.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Place an order settled by on-chain atomic swap."),
+}
+
+// AtomicOrderCommand submits an atomic swap order
+func (cl *ocxClient) AtomicOrderCommand(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var orderSide *match.Side
+	var amountHave, price uint64
+	if orderSide, amountHave, price, err = parseOrderArgs(args); err != nil {
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.RetrievePublicKey(); err != nil {
+		return
+	}
+
+	var reply *cxrpc.SubmitAtomicOrderReply
+	if reply, err = cl.RPCClient.AtomicOrderAtPriceCommand(pubkey, *orderSide, args[1], amountHave, price); err != nil {
+		return
+	}
+
+	var text []byte
+	if text, err = reply.OrderID.MarshalText(); err != nil {
+		err = fmt.Errorf("Could not marshal to text for some reason: %s", err)
+		return
+	}
+
+	logging.Infof("Submitted atomic swap order successfully, orderID: %s", text)
+	return nil
+}
+
+var getAtomicSwapsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getatomicswaps")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get every atomic swap for your atomic swap orders, with both sides, its state, and what you have to do next and by when.",
+		"Atomic swaps are matched, initiated, participated, redeemed, then completed. Abandoned atomic swaps have a reason, refund whatever you locked in them with refundatomic once it times out.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your atomic swaps and their state."),
+}
+
+// GetAtomicSwaps prints the atomic swaps for the client's pubkey
+func (cl *ocxClient) GetAtomicSwaps(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getAtomicSwapsReply *cxrpc.GetAtomicSwapsReply
+	if getAtomicSwapsReply, err = cl.RPCClient.GetAtomicSwaps(); err != nil {
+		return
+	}
+
+	if len(getAtomicSwapsReply.Swaps) == 0 {
+		logging.Infof("No atomic swaps\n")
+		return
+	}
+	var pubkey [33]byte
+	copy(pubkey[:], cl.RPCClient.PrivKey.PubKey().SerializeCompressed())
+	for _, swap := range getAtomicSwapsReply.Swaps {
+		logging.Infof("%s\n", swap)
+		for _, role := range []match.AtomicSwapRole{match.AtomicInitiator, match.AtomicParticipant} {
+			leg := swap.Leg(role)
+			outpoint := "not locked"
+			if leg.Outpoint != "" {
+				outpoint = leg.Outpoint
+			}
+			logging.Infof("  %s: %s %s in %s until %s\n", role, leg.Asset.FormatAmount(leg.Amount), leg.Asset, outpoint, time.Unix(int64(leg.Locktime), 0).Format(time.RFC3339))
+		}
+		if err = logAtomicSwapTurn(swap, pubkey); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// logAtomicSwapTurn prints what the user has to do next in an atomic swap, if it's their turn
+func logAtomicSwapTurn(swap *match.AtomicSwap, pubkey [33]byte) (err error) {
+	role, ok := swap.Turn()
+	if !ok || swap.Leg(role).Pubkey != pubkey {
+		return
+	}
+
+	deadline := swap.Deadline.Format(time.RFC3339)
+	switch swap.State {
+	case match.AtomicSwapMatched:
+		logging.Infof("  Your turn: initiate with initiateatomic %s by %s\n", swap.IDString(), deadline)
+	case match.AtomicSwapInitiated:
+		var address string
+		if address, err = atomicSwapAddress(swap, match.AtomicParticipant); err != nil {
+			return
+		}
+		logging.Infof("  Your turn: send %s %s to %s by %s\n", swap.Participant.Asset.FormatAmount(swap.Participant.Amount), swap.Participant.Asset, address, deadline)
+	default:
+		logging.Infof("  Your turn: redeem with redeematomic %s by %s\n", swap.IDString(), deadline)
+	}
+	return
+}
+
+// atomicSwapAddress returns the address of the HTLC for the side a role locks
+func atomicSwapAddress(swap *match.AtomicSwap, role match.AtomicSwapRole) (address string, err error) {
+	var coin *coinparam.Params
+	if coin, err = swap.Leg(role).Asset.CoinParamFromAsset(); err != nil {
+		return
+	}
+	var pkScript []byte
+	if pkScript, err = swap.PkScript(role); err != nil {
+		return
+	}
+	address, err = util.ScriptAddress(pkScript, coin)
+	return
+}
+
+// atomicSwapPreimage returns the preimage the client uses to initiate an atomic swap. It comes
+// from the client's key so it doesn't have to be kept anywhere.
+func (cl *ocxClient) atomicSwapPreimage(id [32]byte) (preimage [16]byte) {
+	hash := sha256.Sum256(append(cl.RPCClient.PrivKey.Serialize(), id[:]...))
+	copy(preimage[:], hash[:16])
+	return
+}
+
+var initiateAtomicCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("initiateatomic"), lnutil.ReqColor("id")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Initiate an atomic swap where your order took the other side's. This prints an address, send the amount it prints there from your own wallet.",
+		"Once that confirms the other side locks their coins, then redeem them with redeematomic. What you sent can be refunded with refundatomic if they never do.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Initiate an atomic swap."),
+}
+
+// InitiateAtomic initiates one of the client's atomic swaps with a preimage derived from its key
+func (cl *ocxClient) InitiateAtomic(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var id [32]byte
+	if id, err = parseAtomicSwapID(args[0]); err != nil {
+		return
+	}
+	preimage := cl.atomicSwapPreimage(id)
+
+	var initiateAtomicSwapReply *cxrpc.InitiateAtomicSwapReply
+	if initiateAtomicSwapReply, err = cl.RPCClient.InitiateAtomicSwap(id, sha256.Sum256(preimage[:])); err != nil {
+		return
+	}
+
+	swap := initiateAtomicSwapReply.Swap
+	logging.Infof("Initiated %s\n", swap)
+	logging.Infof("Send %s %s to %s by %s\n", swap.Initiator.Asset.FormatAmount(swap.Initiator.Amount), swap.Initiator.Asset, initiateAtomicSwapReply.Address, swap.Deadline.Format(time.RFC3339))
+	return
+}
+
+var redeemAtomicCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("redeematomic"), lnutil.ReqColor("id"), lnutil.ReqColor("address")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Redeem what the other side locked in an atomic swap, sending it to address less the network fee.",
+		"The initiator redeems first, which reveals the preimage the participant then redeems with.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Redeem the other side of an atomic swap."),
+}
+
+// RedeemAtomic redeems the other side of one of the client's atomic swaps with the preimage
+func (cl *ocxClient) RedeemAtomic(args []string) (err error) {
+	return cl.spendAtomic(args[0], true, args[1])
+}
+
+var refundAtomicCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("refundatomic"), lnutil.ReqColor("id"), lnutil.ReqColor("address")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Take back what you locked in an atomic swap, sending it to address less the network fee.",
+		"This only works once your side has timed out, see getatomicswaps for when.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Refund your side of an atomic swap."),
+}
+
+// RefundAtomic refunds the client's side of one of its atomic swaps once it's timed out
+func (cl *ocxClient) RefundAtomic(args []string) (err error) {
+	return cl.spendAtomic(args[0], false, args[1])
+}
+
+// spendAtomic redeems the other side of one of the client's atomic swaps, or refunds the
+// client's own side, to address
+func (cl *ocxClient) spendAtomic(idStr string, redeem bool, address string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var id [32]byte
+	if id, err = parseAtomicSwapID(idStr); err != nil {
+		return
+	}
+
+	var getAtomicSwapsReply *cxrpc.GetAtomicSwapsReply
+	if getAtomicSwapsReply, err = cl.RPCClient.GetAtomicSwaps(); err != nil {
+		return
+	}
+	var swap *match.AtomicSwap
+	for _, mySwap := range getAtomicSwapsReply.Swaps {
+		if mySwap.ID == id {
+			swap = mySwap
+		}
+	}
+	if swap == nil {
+		err = fmt.Errorf("You don't have an atomic swap %s", idStr)
+		return
+	}
+
+	// find the side to spend, the other side's if redeeming and the client's if refunding
+	var pubkey [33]byte
+	copy(pubkey[:], cl.RPCClient.PrivKey.PubKey().SerializeCompressed())
+	var spendRole match.AtomicSwapRole
+	var preimage *[16]byte
+	found := false
+	for _, role := range swap.Roles(pubkey) {
+		spendRole = role
+		if redeem {
+			spendRole = match.AtomicParticipant
+			if role == match.AtomicParticipant {
+				spendRole = match.AtomicInitiator
+			}
+		}
+		leg := swap.Leg(spendRole)
+		if leg.Outpoint == "" || leg.SpendTxid != "" {
+			continue
+		}
+		found = true
+
+		if redeem && role == match.AtomicInitiator {
+			derived := cl.atomicSwapPreimage(id)
+			preimage = &derived
+		} else if redeem {
+			if swap.Preimage == ([16]byte{}) {
+				err = fmt.Errorf("The initiator hasn't redeemed atomic swap %s yet, so there's no preimage to redeem with", idStr)
+				return
+			}
+			preimage = &swap.Preimage
+		}
+		break
+	}
+	if !found {
+		err = fmt.Errorf("Atomic swap %s has nothing locked for you to spend", idStr)
+		return
+	}
+
+	leg := swap.Leg(spendRole)
+	var coin *coinparam.Params
+	if coin, err = leg.Asset.CoinParamFromAsset(); err != nil {
+		return
+	}
+	var outScript []byte
+	if outScript, err = util.AddressScript(address, coin); err != nil {
+		return
+	}
+
+	var getFeeEstimatesReply *cxrpc.GetFeeEstimatesReply
+	if getFeeEstimatesReply, err = cl.RPCClient.GetFeeEstimates(leg.Asset.String()); err != nil {
+		return
+	}
+	fee := uint64(getFeeEstimatesReply.Estimates.Normal) * match.AtomicSwapSpendSize
+
+	var spendTx *wire.MsgTx
+	if spendTx, err = swap.SpendTx(spendRole, outScript, fee, cl.RPCClient.PrivKey, preimage); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = spendTx.Serialize(&buf); err != nil {
+		err = fmt.Errorf("Error serializing spend: %s", err)
+		return
+	}
+
+	var submitAtomicSpendReply *cxrpc.SubmitAtomicSpendReply
+	if submitAtomicSpendReply, err = cl.RPCClient.SubmitAtomicSpend(leg.Asset.String(), buf.Bytes()); err != nil {
+		return
+	}
+	logging.Infof("Sent %s %s to %s in %s\n", leg.Asset.FormatAmount(leg.FundAmount-fee), leg.Asset, address, submitAtomicSpendReply.Txid)
+	return
+}
+
+// parseAtomicSwapID parses the hex ID of an atomic swap
+func parseAtomicSwapID(idStr string) (id [32]byte, err error) {
+	var idBytes []byte
+	if idBytes, err = hex.DecodeString(idStr); err != nil || len(idBytes) != len(id) {
+		err = fmt.Errorf("Atomic swap ID should be 32 bytes of hex: %v", err)
+		return
+	}
+	copy(id[:], idBytes)
+	return
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"strconv"
 
@@ -15,7 +17,7 @@ var placeAuctionOrderCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.Red("placeauctionorder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price")),
 	Description: fmt.Sprintf("%s\n%s\n",
 		"Submit a front-running resistant auction order with side \"buy\" or side \"sell\", for pair \"asset1\"/\"asset2\", where you give up amounthave of \"asset1\" (if on buy side) or \"asset2\" if on sell side, for the other token at a specific price.",
-		"This will return an order ID which can be used as input to cancelorder, or getorder.",
+		"This will return a receipt ID which can be used as input to auctionstatus, or cancelauctionorder.",
 	),
 	ShortDescription: fmt.Sprintf("%s\n", "Place a front-running resistant order on the exchange."),
 }
@@ -58,13 +60,189 @@ func (cl *ocxClient) AuctionOrderCommand(args []string) (err error) {
 		return
 	}
 
-	// we ignore reply because there's nothing in it and we don't use it
-	// var reply *cxauctionrpc.SubmitPuzzledOrderReply
-	if _, err = cl.RPCClient.AuctionOrderCommand(pubkey, side, pair, amountHave, price, paramreply.AuctionTime, paramreply.AuctionID); err != nil {
+	var reply *cxauctionrpc.SubmitPuzzledOrderReply
+	if reply, err = cl.RPCClient.AuctionOrderCommand(pubkey, side, pair, amountHave, price, paramreply.AuctionTime, paramreply.AuctionID); err != nil {
 		return
 	}
 
-	logging.Infof("Successfully placed auction order")
+	logging.Infof("Successfully placed auction order with receipt %x in auction %x", reply.Receipt.ID, reply.Receipt.AuctionID)
 
 	return
 }
+
+var auctionStatusCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("auctionstatus"), lnutil.ReqColor("receiptid")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get the status of an auction order from the receipt ID placeauctionorder returned.",
+		"Orders are submitted until their auction ends, then they're rejected if they're invalid, cancelled, placed, partially filled, or filled.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get the status of an auction order."),
+}
+
+// AuctionStatus gets the status of an auction order from its receipt ID
+func (cl *ocxClient) AuctionStatus(args []string) (err error) {
+	var receiptID [32]byte
+	if receiptID, err = parseAuctionHexID("Receipt", args[0]); err != nil {
+		return
+	}
+
+	var reply *cxauctionrpc.GetAuctionOrderStatusReply
+	if reply, err = cl.RPCClient.GetAuctionOrderStatus(receiptID); err != nil {
+		return
+	}
+
+	status := reply.Status
+	logging.Infof("%s\n", status)
+	if status.Order != nil {
+		logging.Infof("Order: %s\n", status.Order)
+	}
+	if status.Execution != nil {
+		logging.Infof("Order %x now wants %d for %d, filled: %t\n", status.OrderID, status.Execution.NewAmountWant, status.Execution.NewAmountHave, status.Execution.Filled)
+	}
+	return
+}
+
+var cancelAuctionOrderCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("cancelauctionorder"), lnutil.ReqColor("receiptid")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Cancel an auction order before its auction ends, using the receipt ID placeauctionorder returned.",
+		"The exchange can't read the order until its auction ends, so the order is cancelled then if it was placed with the same key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Cancel an auction order before its auction ends."),
+}
+
+// CancelAuctionOrder cancels an auction order before its auction ends
+func (cl *ocxClient) CancelAuctionOrder(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var receiptID [32]byte
+	if receiptID, err = parseAuctionHexID("Receipt", args[0]); err != nil {
+		return
+	}
+
+	if _, err = cl.RPCClient.CancelAuctionOrder(receiptID); err != nil {
+		return
+	}
+
+	logging.Infof("Cancelling auction order %x when its auction ends", receiptID)
+	return
+}
+
+var auctionResultCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("auctionresult"), lnutil.ReqColor("auctionid")),
+	Description: fmt.Sprintf("%s\n",
+		"Get the clearing price, fills, rejected orders and why, and cancelled orders for an auction that has ended.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get the result of an auction."),
+}
+
+// AuctionResult gets the result of an auction that has ended
+func (cl *ocxClient) AuctionResult(args []string) (err error) {
+	var auctionID [32]byte
+	if auctionID, err = parseAuctionHexID("Auction", args[0]); err != nil {
+		return
+	}
+
+	var reply *cxauctionrpc.GetAuctionResultReply
+	if reply, err = cl.RPCClient.GetAuctionResult(auctionID); err != nil {
+		return
+	}
+
+	result := reply.Result
+	logging.Infof("%s\n", result)
+	for _, fill := range result.Fills {
+		logging.Infof("Order %x now wants %d for %d, filled: %t\n", fill.OrderID, fill.NewAmountWant, fill.NewAmountHave, fill.Filled)
+	}
+	for _, rejection := range result.Rejections {
+		logging.Infof("Rejected %x: %s\n", rejection.ReceiptID, rejection.Reason)
+	}
+	for _, receiptID := range result.Cancelled {
+		logging.Infof("Cancelled %x\n", receiptID)
+	}
+	return
+}
+
+var verifyAuctionCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("verifyauction"), lnutil.ReqColor("pair"), lnutil.ReqColor("auctionid")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Download the signed transcript for an auction and check it.",
+		"This checks the exchange's signatures on the auction ID and its commitment to the puzzled orders, the users' signatures on their orders and responses, and that the exchange signed with the key from its public parameters.",
+		"Then every puzzle is solved to check that the solutions are exactly the orders in the puzzles, which takes about as long as the auction did.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Verify the transcript of an auction."),
+}
+
+// VerifyAuction downloads the transcript for an auction and checks it independently of the exchange
+func (cl *ocxClient) VerifyAuction(args []string) (err error) {
+	pair := new(match.Pair)
+	if err = pair.FromString(args[0]); err != nil {
+		err = fmt.Errorf("Error parsing pair, please enter something valid: %s", err)
+		return
+	}
+
+	var auctionID [32]byte
+	if auctionID, err = parseAuctionHexID("Auction", args[1]); err != nil {
+		return
+	}
+
+	var paramreply *cxauctionrpc.GetPublicParametersReply
+	if paramreply, err = cl.RPCClient.GetPublicParameters(pair); err != nil {
+		err = fmt.Errorf("Error getting public parameters before verifying auction: %s", err)
+		return
+	}
+	if paramreply.ExchangePubkey == [33]byte{} {
+		err = fmt.Errorf("Exchange isn't signing transcripts")
+		return
+	}
+
+	var transcript *match.Transcript
+	if transcript, err = cl.RPCClient.GetTranscript(pair, auctionID); err != nil {
+		return
+	}
+
+	if transcript.BatchId != auctionID {
+		err = fmt.Errorf("Exchange sent the transcript for auction %x instead of %x", transcript.BatchId, auctionID)
+		return
+	}
+
+	var valid bool
+	if valid, err = transcript.Verify(); err != nil || !valid {
+		err = fmt.Errorf("Invalid transcript: %v", err)
+		return
+	}
+
+	var exchangePubkey *koblitz.PublicKey
+	if exchangePubkey, err = transcript.ExchangePubkey(); err != nil {
+		return
+	}
+	if !bytes.Equal(exchangePubkey.SerializeCompressed(), paramreply.ExchangePubkey[:]) {
+		err = fmt.Errorf("Transcript was signed by %x, not the exchange's key %x", exchangePubkey.SerializeCompressed(), paramreply.ExchangePubkey)
+		return
+	}
+	logging.Infof("Exchange committed to %d puzzled orders with %x, and got %d responses\n", len(transcript.PuzzledOrders), transcript.Commitment, len(transcript.Responses))
+
+	if len(transcript.Solutions) == 0 && len(transcript.PuzzledOrders) != 0 {
+		logging.Infof("Transcript has no solutions, the exchange may not have solved the puzzles yet\n")
+	}
+	logging.Infof("Solving %d puzzles to check the solutions...\n", len(transcript.PuzzledOrders))
+	if err = transcript.VerifySolutions(); err != nil {
+		err = fmt.Errorf("Invalid solutions: %s", err)
+		return
+	}
+
+	logging.Infof("Transcript for auction %x is valid, with %d solutions\n", auctionID, len(transcript.Solutions))
+	return
+}
+
+// parseAuctionHexID parses the hex of a receipt or auction ID
+func parseAuctionHexID(kind string, idStr string) (id [32]byte, err error) {
+	var idBytes []byte
+	if idBytes, err = hex.DecodeString(idStr); err != nil || len(idBytes) != len(id) {
+		err = fmt.Errorf("%s ID should be 32 bytes of hex: %v", kind, err)
+		return
+	}
+	copy(id[:], idBytes)
+	return
+}
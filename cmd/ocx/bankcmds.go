@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"strconv"
+	"strings"
 
 	"github.com/mit-dci/lit/lnutil"
 
@@ -32,7 +32,7 @@ func (cl *ocxClient) GetBalance(args []string) (err error) {
 		return
 	}
 
-	logging.Infof("Balance for token %s: %f %s\n", asset, float64(balanceReply.Amount)/math.Pow10(8), asset)
+	logging.Infof("Balance for token %s: %s %s\n", asset, formatAmount(balanceReply.Amount, asset), asset)
 	return
 }
 
@@ -61,6 +61,37 @@ func (cl *ocxClient) GetDepositAddress(args []string) (err error) {
 	return
 }
 
+var getDepositsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("getdeposits"), lnutil.ReqColor("asset")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get every deposit you've made of the given asset, with how many confirmations it has and needs.",
+		"Pending deposits are credited once they have enough confirmations, orphaned deposits were in a block that was reorged out.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your deposits of the given asset and their status."),
+}
+
+func (cl *ocxClient) GetDeposits(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var getDepositsReply *cxrpc.GetDepositsReply
+	if getDepositsReply, err = cl.RPCClient.GetDeposits(asset); err != nil {
+		return
+	}
+
+	if len(getDepositsReply.Deposits) == 0 {
+		logging.Infof("No deposits for token %s\n", asset)
+		return
+	}
+	for _, deposit := range getDepositsReply.Deposits {
+		logging.Infof("%s: %s %s, %d/%d confirmations, %s\n", deposit.Txid, formatAmount(deposit.Amount, asset), asset, deposit.Confirmations, deposit.ConfirmationsRequired, deposit.Status)
+	}
+	return
+}
+
 var getAllBalancesCommand = &Command{
 	Format: fmt.Sprintf("%s\n", lnutil.Red("getallbalances")),
 	Description: fmt.Sprintf("%s\n",
@@ -81,16 +112,17 @@ func (cl *ocxClient) GetAllBalances(args []string) (err error) {
 	}
 
 	for asset, amount := range getAllBalancesReply {
-		logging.Infof("Balance for token %s: %f %s\n", asset, float64(amount)/math.Pow10(8), asset)
+		logging.Infof("Balance for token %s: %s %s\n", asset, formatAmount(amount, asset), asset)
 	}
 
 	return
 }
 
 var withdrawCommand = &Command{
-	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.Red("withdraw"), lnutil.ReqColor("amount"), lnutil.ReqColor("asset"), lnutil.ReqColor("recvaddress")),
-	Description: fmt.Sprintf("%s\n%s\n",
-		"Withdraw amount of asset into recvaddress.",
+	Format: fmt.Sprintf("%s%s%s%s%s%s\n", lnutil.Red("withdraw"), lnutil.ReqColor("amount"), lnutil.ReqColor("asset"), lnutil.ReqColor("recvaddress"), lnutil.OptColor("priority=low|normal|high"), lnutil.OptColor("payfee=true")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Withdraw amount of asset into recvaddress. The amount is decimal, like 0.5.",
+		"The priority decides the fee rate the withdrawal's transaction pays, see getfeeestimates. With payfee=true your share of the network fee comes out of what you're sent.",
 		"Make sure you feel your asset has enough confirmations such that it has been confirmed.",
 	),
 	ShortDescription: fmt.Sprintf("%s\n", "Withdraw amount of asset into recvaddress."),
@@ -101,26 +133,117 @@ func (cl *ocxClient) Withdraw(args []string) (err error) {
 		logging.Fatalf("Could not unlock key! Fatal!")
 	}
 
-	var amount uint64
-	if amount, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+	var asset match.Asset
+	if asset, err = match.AssetFromString(args[1]); err != nil {
 		return
 	}
 
-	var asset match.Asset
-	if asset, err = match.AssetFromString(args[1]); err != nil {
+	var amount uint64
+	if amount, err = asset.ParseAmount(args[0]); err != nil {
 		return
 	}
 	address := args[2]
 
+	var priority match.FeePriority
+	var payFee bool
+	for _, arg := range args[3:] {
+		keyValue := strings.SplitN(arg, "=", 2)
+		if len(keyValue) != 2 {
+			err = fmt.Errorf("Argument %s should look like key=value", arg)
+			return
+		}
+		switch keyValue[0] {
+		case "priority":
+			if priority, err = match.FeePriorityFromString(keyValue[1]); err != nil {
+				return
+			}
+		case "payfee":
+			if payFee, err = strconv.ParseBool(keyValue[1]); err != nil {
+				err = fmt.Errorf("Error parsing payfee: %s", err)
+				return
+			}
+		default:
+			err = fmt.Errorf("Unknown argument %s", keyValue[0])
+			return
+		}
+	}
+
 	var withdrawReply *cxrpc.WithdrawReply
-	if withdrawReply, err = cl.RPCClient.Withdraw(amount, asset, address); err != nil {
+	if withdrawReply, err = cl.RPCClient.Withdraw(amount, asset, address, priority, payFee); err != nil {
 		return
 	}
 
-	logging.Infof("Withdraw transaction ID: %s\n", withdrawReply.Txid)
+	logging.Infof("Withdrawal %d %s, it will be sent in the next batch once approved\n", withdrawReply.Withdrawal.ID, withdrawReply.Withdrawal.State)
 	return
 }
 
+var getFeeEstimatesCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("getfeeestimates"), lnutil.ReqColor("asset")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get the fee rates, in satoshis per vbyte, withdrawals of the given asset pay at each priority.",
+		"They're estimated from recent blocks, and stuck withdrawals are replaced with ones paying more.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get the fee rates withdrawals of the given asset pay."),
+}
+
+// GetFeeEstimates prints the fee rate for each withdrawal priority
+func (cl *ocxClient) GetFeeEstimates(args []string) (err error) {
+	var getFeeEstimatesReply *cxrpc.GetFeeEstimatesReply
+	if getFeeEstimatesReply, err = cl.RPCClient.GetFeeEstimates(args[0]); err != nil {
+		return
+	}
+
+	estimates := getFeeEstimatesReply.Estimates
+	logging.Infof("%s fee rates from the last %d blocks: low %d, normal %d, high %d sat/vbyte\n", args[0], estimates.Blocks, estimates.Low, estimates.Normal, estimates.High)
+	return
+}
+
+var getWithdrawalsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("getwithdrawals"), lnutil.ReqColor("asset")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get every withdrawal you've requested of the given asset, with its state and transaction ID once it's been broadcast.",
+		"Withdrawals are requested, approved, broadcast, then confirmed. Failed withdrawals have been refunded.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your withdrawals of the given asset and their state."),
+}
+
+func (cl *ocxClient) GetWithdrawals(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var getWithdrawalsReply *cxrpc.GetWithdrawalsReply
+	if getWithdrawalsReply, err = cl.RPCClient.GetWithdrawals(asset); err != nil {
+		return
+	}
+
+	if len(getWithdrawalsReply.Withdrawals) == 0 {
+		logging.Infof("No withdrawals for token %s\n", asset)
+		return
+	}
+	for _, withdrawal := range getWithdrawalsReply.Withdrawals {
+		logWithdrawal(withdrawal, asset)
+	}
+	return
+}
+
+// formatAmount formats base units of the asset named assetStr as a decimal amount, or as base units
+// if the asset isn't known
+func formatAmount(amount uint64, assetStr string) string {
+	asset, err := match.AssetFromString(assetStr)
+	if err != nil {
+		return strconv.FormatUint(amount, 10)
+	}
+	return asset.FormatAmount(amount)
+}
+
+// logWithdrawal prints a withdrawal request on one line
+func logWithdrawal(withdrawal *match.WithdrawalRequest, asset string) {
+	logging.Infof("%d: %s %s to %s, %s priority, %s %s %s\n", withdrawal.ID, formatAmount(withdrawal.Amount-withdrawal.Fee, asset), asset, withdrawal.Address, withdrawal.Priority, withdrawal.State, withdrawal.Txid, withdrawal.Reason)
+}
+
 var litWithdrawCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("litwithdraw"), lnutil.ReqColor("amount"), lnutil.ReqColor("asset")),
 	Description: fmt.Sprintf("%s\n%s\n%s\n",
@@ -136,13 +259,13 @@ func (cl *ocxClient) LitWithdraw(args []string) (err error) {
 		logging.Fatalf("Could not unlock key! Fatal!")
 	}
 
-	var amount uint64
-	if amount, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+	var asset match.Asset
+	if asset, err = match.AssetFromString(args[1]); err != nil {
 		return
 	}
 
-	var asset match.Asset
-	if asset, err = match.AssetFromString(args[1]); err != nil {
+	var amount uint64
+	if amount, err = asset.ParseAmount(args[0]); err != nil {
 		return
 	}
 
@@ -154,3 +277,28 @@ func (cl *ocxClient) LitWithdraw(args []string) (err error) {
 	logging.Infof("Withdraw transaction ID: %s\n", withdrawReply.Txid)
 	return
 }
+
+var payLightningCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("paylightning"), lnutil.ReqColor("paymentrequest")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Withdraw by having the exchange pay a payment request, node:asset:amount:expiry, over lightning. You don't need a channel with the exchange, only a route from it to the node.",
+		"The amount is in base units and is taken out of your balance right away. The node picks the preimage, so the request can't have a hash.",
+		"The withdrawal is broadcast until the payment is claimed. If it can't be routed or times out it fails and is refunded, check it with getwithdrawals.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Withdraw by paying a lightning payment request."),
+}
+
+// PayLightning withdraws by having the exchange pay a payment request
+func (cl *ocxClient) PayLightning(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var withdrawReply *cxrpc.WithdrawReply
+	if withdrawReply, err = cl.RPCClient.PayPaymentRequest(args[0]); err != nil {
+		return
+	}
+
+	logWithdrawal(withdrawReply.Withdrawal, withdrawReply.Withdrawal.Asset.String())
+	return
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// defaultInvoiceExpiry is how long invoices last if createinvoice isn't given a duration
+const defaultInvoiceExpiry = time.Hour
+
+var createInvoiceCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.Red("createinvoice"), lnutil.ReqColor("asset"), lnutil.ReqColor("amount"), lnutil.OptColor("expiry")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Create an invoice to deposit amount of asset over lightning, without a channel to the exchange. The amount is decimal, like 0.5.",
+		fmt.Sprintf("The invoice lasts for expiry, like 30m, which is %s if it isn't given and can't be more than %s.", defaultInvoiceExpiry, match.MaxInvoiceExpiry),
+		"This prints a payment request. Whoever pays it with HTLCs locked to its hash before it expires, directly or routed through other nodes, credits you with what they paid.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Create an invoice to deposit over lightning."),
+}
+
+// CreateInvoice creates an invoice that credits the client once it's paid
+func (cl *ocxClient) CreateInvoice(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromString(args[0]); err != nil {
+		return
+	}
+	var amount uint64
+	if amount, err = asset.ParseAmount(args[1]); err != nil {
+		return
+	}
+	expiry := defaultInvoiceExpiry
+	if len(args) > 2 {
+		if expiry, err = time.ParseDuration(args[2]); err != nil {
+			err = fmt.Errorf("Error parsing expiry: %s", err)
+			return
+		}
+	}
+
+	request := &match.InvoiceRequest{
+		Asset:  asset,
+		Amount: amount,
+		Expiry: time.Now().Add(expiry).Unix(),
+	}
+
+	var createInvoiceReply *cxrpc.CreateInvoiceReply
+	if createInvoiceReply, err = cl.RPCClient.CreateInvoice(request); err != nil {
+		return
+	}
+
+	invoice := createInvoiceReply.Invoice
+	logging.Infof("Created invoice %s for %s %s, pay it before %s\n", invoice.ID(), asset.FormatAmount(amount), asset, invoice.Expiry)
+	logging.Infof("Payment request: %s\n", createInvoiceReply.PaymentRequest)
+	return
+}
+
+var getInvoicesCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getinvoices")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get every invoice you've created, with its hash, amount, expiry, and its state.",
+		"Invoices are open until they're paid or expire. Paid invoices show what you were credited and their preimage.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your invoices and their state."),
+}
+
+// GetInvoices prints the invoices for the client's pubkey
+func (cl *ocxClient) GetInvoices(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getInvoicesReply *cxrpc.GetInvoicesReply
+	if getInvoicesReply, err = cl.RPCClient.GetInvoices(); err != nil {
+		return
+	}
+
+	if len(getInvoicesReply.Invoices) == 0 {
+		logging.Infof("No invoices\n")
+		return
+	}
+	for _, invoice := range getInvoicesReply.Invoices {
+		if invoice.State == match.InvoicePaid {
+			logging.Infof("%s: %s %s until %s, %s %s %s, preimage %x\n", invoice.ID(), invoice.Asset.FormatAmount(invoice.Amount), invoice.Asset, invoice.Expiry, invoice.State, invoice.Asset.FormatAmount(invoice.AmountPaid), invoice.Asset, invoice.Preimage)
+			continue
+		}
+		logging.Infof("%s: %s %s until %s, %s %s\n", invoice.ID(), invoice.Asset.FormatAmount(invoice.Amount), invoice.Asset, invoice.Expiry, invoice.State, invoice.Reason)
+	}
+	return
+}
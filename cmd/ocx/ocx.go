@@ -8,13 +8,9 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/mit-dci/lit/btcutil/hdkeychain"
-	"github.com/mit-dci/lit/coinparam"
-
 	"golang.org/x/crypto/sha3"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
-	"github.com/mit-dci/lit/portxo"
 
 	"github.com/mit-dci/opencx/benchclient"
 
@@ -105,7 +101,8 @@ func main() {
 		return
 	}
 
-	if os.Args[1] == "help" {
+	// help and signing refills with the cold keys don't need the exchange or our key
+	if os.Args[1] == "help" || os.Args[1] == "signrefill" {
 		if err = client.parseCommands(os.Args[1:]); err != nil {
 			logging.Fatalf("%s", err)
 		}
@@ -133,6 +130,11 @@ func main() {
 
 	}
 
+	// so amounts are parsed and shown the way the exchange does
+	if err = client.RPCClient.SyncAssets(); err != nil {
+		logging.Warnf("Could not get the exchange's assets, amounts may be off: %s", err)
+	}
+
 	if err = client.parseCommands(os.Args[1:]); err != nil {
 		logging.Fatalf("%s", err)
 	}
@@ -218,21 +220,7 @@ func (cl *ocxClient) UnlockKey() (err error) {
 			}
 		}
 
-		// We use TestNet3Params because that's what qln uses
-		var rootPrivKey *hdkeychain.ExtendedKey
-		if rootPrivKey, err = hdkeychain.NewMaster(keyFromFile[:], &coinparam.TestNet3Params); err != nil {
-			return
-		}
-
-		// make keygen the same
-		var kg portxo.KeyGen
-		kg.Depth = 5
-		kg.Step[0] = 44 | 1<<31
-		kg.Step[1] = 513 | 1<<31
-		kg.Step[2] = 9 | 1<<31
-		kg.Step[3] = 0 | 1<<31
-		kg.Step[4] = 0 | 1<<31
-		if cl.RPCClient.PrivKey, err = kg.DerivePrivateKey(rootPrivKey); err != nil {
+		if err = cl.RPCClient.SetKeyFromSeed(keyFromFile); err != nil {
 			return
 		}
 		cl.unlocked = true
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/lnutil"
@@ -17,8 +19,9 @@ import (
 
 var placeOrderCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.Red("placeorder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price")),
-	Description: fmt.Sprintf("%s\n%s\n",
-		"Submit a order with side \"buy\" or side \"sell\", for pair \"asset1\"/\"asset2\", where you give up amounthave of \"asset1\" (if on buy side) or \"asset2\" if on sell side, for the other token at a specific price.",
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Submit a order with side \"buy\" or side \"sell\", for pair \"asset1\"/\"asset2\", where you give up amounthave of \"asset2\" (if on buy side) or \"asset1\" if on sell side, for the other token at a specific price.",
+		"Amounts are decimal, like 0.5, and the price is how much asset2 one whole asset1 costs. Amounts have to be a multiple of the exchange's lot size and prices of its tick size, see getassets.",
 		"This will return an order ID which can be used as input to cancelorder, or getorder.",
 	),
 	ShortDescription: fmt.Sprintf("%s\n", "Place an order on the exchange."),
@@ -30,17 +33,10 @@ func (cl *ocxClient) OrderCommand(args []string) (err error) {
 		logging.Fatalf("Could not unlock key! Fatal!")
 	}
 
-	side := args[0]
-	pair := args[1]
-
-	amountHave, err := strconv.ParseUint(args[2], 10, 64)
-	if err != nil {
-		return fmt.Errorf("Error parsing amountHave, please enter something valid:\n%s", err)
-	}
-
-	price, err := strconv.ParseFloat(args[3], 64)
-	if err != nil {
-		return fmt.Errorf("Error parsing price: \n%s", err)
+	var orderSide *match.Side
+	var amountHave, price uint64
+	if orderSide, amountHave, price, err = parseOrderArgs(args); err != nil {
+		return
 	}
 
 	var pubkey *koblitz.PublicKey
@@ -48,14 +44,8 @@ func (cl *ocxClient) OrderCommand(args []string) (err error) {
 		return
 	}
 
-	var orderSide *match.Side = new(match.Side)
-	if err = orderSide.FromString(side); err != nil {
-		err = fmt.Errorf("Error getting side from string for OrderCommand: %s", err)
-		return
-	}
-
 	var reply *cxrpc.SubmitOrderReply
-	if reply, err = cl.RPCClient.OrderCommand(pubkey, *orderSide, pair, amountHave, price); err != nil {
+	if reply, err = cl.RPCClient.OrderAtPriceCommand(pubkey, *orderSide, args[1], amountHave, price); err != nil {
 		return
 	}
 
@@ -69,6 +59,34 @@ func (cl *ocxClient) OrderCommand(args []string) (err error) {
 	return nil
 }
 
+// parseOrderArgs parses the side, pair, amounthave and price of an order command. The amounts
+// are decimal, amounthave is in the asset given up and the price is in the quote asset.
+func parseOrderArgs(args []string) (orderSide *match.Side, amountHave uint64, price uint64, err error) {
+	orderSide = new(match.Side)
+	if err = orderSide.FromString(args[0]); err != nil {
+		err = fmt.Errorf("Error getting side from string for OrderCommand: %s", err)
+		return
+	}
+
+	var pair match.Pair
+	if err = pair.FromString(args[1]); err != nil {
+		err = fmt.Errorf("Error getting pair from string for OrderCommand: %s", err)
+		return
+	}
+
+	haveAsset, _ := pair.HaveWantAssets(*orderSide)
+	if amountHave, err = haveAsset.ParseAmount(args[2]); err != nil {
+		err = fmt.Errorf("Error parsing amountHave, please enter something valid:\n%s", err)
+		return
+	}
+
+	if price, err = pair.AssetHave.ParseAmount(args[3]); err != nil {
+		err = fmt.Errorf("Error parsing price: \n%s", err)
+		return
+	}
+	return
+}
+
 var getPriceCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.Red("getprice"), lnutil.ReqColor("pair")),
 	Description: fmt.Sprintf("%s\n",
@@ -129,8 +147,9 @@ func (cl *ocxClient) ViewOrderbook(args []string) (err error) {
 
 			// convert stuff to strings
 			strOrderID := fmt.Sprintf("%x", order.OrderID)
-			strPrice := fmt.Sprintf("%f", order.Price)
-			strVolume := fmt.Sprintf("%d", order.Order.AmountHave)
+			strPrice := formatPrice(order.Order, order.Price)
+			haveAsset, _ := order.Order.TradingPair.HaveWantAssets(order.Order.Side)
+			strVolume := haveAsset.FormatAmount(order.Order.AmountHave)
 			// append to the table
 			data = append(data, []string{strOrderID, strPrice, strVolume, order.Order.Side.String()})
 		}
@@ -145,6 +164,16 @@ func (cl *ocxClient) ViewOrderbook(args []string) (err error) {
 	return
 }
 
+// formatPrice formats the price of an order in the quote asset, or as the float price the exchange
+// returned if it can't be worked out
+func formatPrice(order *match.LimitOrder, price float64) string {
+	quotePrice, err := order.QuotePrice()
+	if err != nil {
+		return fmt.Sprintf("%f", price)
+	}
+	return order.TradingPair.AssetHave.FormatAmount(quotePrice)
+}
+
 var cancelOrderCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.Red("cancelorder"), lnutil.ReqColor("orderID")),
 	Description: fmt.Sprintf("%s\n",
@@ -192,3 +221,176 @@ func (cl *ocxClient) GetPairs() (err error) {
 
 	return
 }
+
+var orderHistoryCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s%s%s\n", lnutil.Red("orderhistory"), lnutil.OptColor("pair=pair"), lnutil.OptColor("status=status1,status2"), lnutil.OptColor("from=time"), lnutil.OptColor("to=time"), lnutil.OptColor("cursor=cursor"), lnutil.OptColor("limit=limit")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Get your order history, newest first, including orders that were filled, cancelled, or expired.",
+		"Statuses are open, partiallyfilled, filled, cancelled, partiallycancelled, and expired. Times are RFC3339 or unix seconds.",
+		"If there are more orders, the cursor for the next page is printed.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your order history."),
+}
+
+// OrderHistory prints a page of order history
+func (cl *ocxClient) OrderHistory(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var query *match.HistoryQuery
+	if query, err = parseHistoryQuery(args); err != nil {
+		return
+	}
+
+	var reply *cxrpc.GetOrderHistoryReply
+	if reply, err = cl.RPCClient.GetOrderHistory(query); err != nil {
+		return
+	}
+
+	// Build the table
+	var data [][]string
+	buf := new(bytes.Buffer)
+	table := tablewriter.NewWriter(buf)
+	table.SetHeader([]string{"orderID", "pair", "side", "price", "amounthave", "filled", "status", "placed"})
+	for _, entry := range reply.Orders {
+		strOrderID := fmt.Sprintf("%x", entry.OrderID)
+		strPrice := formatPrice(entry.Order, entry.Price)
+		haveAsset, _ := entry.Order.TradingPair.HaveWantAssets(entry.Order.Side)
+		strAmountHave := haveAsset.FormatAmount(entry.Order.AmountHave)
+		strFilled := haveAsset.FormatAmount(entry.AmountHaveFilled)
+		data = append(data, []string{strOrderID, entry.Order.TradingPair.String(), entry.Order.Side.String(), strPrice, strAmountHave, strFilled, string(entry.Status), entry.Placed.Format(time.RFC3339)})
+	}
+
+	// render the table
+	table.AppendBulk(data)
+	table.Render()
+
+	// actually print out table stored in buffer
+	logging.Infof("\n%s\n", buf.String())
+	if reply.NextCursor != 0 {
+		logging.Infof("More orders, next page with cursor=%d", reply.NextCursor)
+	}
+	return
+}
+
+var fillHistoryCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s%s\n", lnutil.Red("fillhistory"), lnutil.OptColor("pair=pair"), lnutil.OptColor("from=time"), lnutil.OptColor("to=time"), lnutil.OptColor("cursor=cursor"), lnutil.OptColor("limit=limit")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Get the individual fills of your orders, newest first.",
+		"Times are RFC3339 or unix seconds.",
+		"If there are more fills, the cursor for the next page is printed.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get the fills of your orders."),
+}
+
+// FillHistory prints a page of fill history
+func (cl *ocxClient) FillHistory(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var query *match.HistoryQuery
+	if query, err = parseHistoryQuery(args); err != nil {
+		return
+	}
+	if len(query.Statuses) != 0 {
+		err = fmt.Errorf("Fills don't have a status, don't filter on status")
+		return
+	}
+
+	var reply *cxrpc.GetFillHistoryReply
+	if reply, err = cl.RPCClient.GetFillHistory(query); err != nil {
+		return
+	}
+
+	// Build the table
+	var data [][]string
+	buf := new(bytes.Buffer)
+	table := tablewriter.NewWriter(buf)
+	table.SetHeader([]string{"orderID", "pair", "side", "amounthave", "amountwant", "time"})
+	for _, fill := range reply.Fills {
+		strOrderID := fmt.Sprintf("%x", fill.OrderID)
+		haveAsset, wantAsset := fill.TradingPair.HaveWantAssets(fill.Side)
+		strAmountHave := haveAsset.FormatAmount(fill.AmountHave)
+		strAmountWant := wantAsset.FormatAmount(fill.AmountWant)
+		data = append(data, []string{strOrderID, fill.TradingPair.String(), fill.Side.String(), strAmountHave, strAmountWant, fill.Time.Format(time.RFC3339)})
+	}
+
+	// render the table
+	table.AppendBulk(data)
+	table.Render()
+
+	// actually print out table stored in buffer
+	logging.Infof("\n%s\n", buf.String())
+	if reply.NextCursor != 0 {
+		logging.Infof("More fills, next page with cursor=%d", reply.NextCursor)
+	}
+	return
+}
+
+// parseHistoryQuery creates a history query from key=value arguments
+func parseHistoryQuery(args []string) (query *match.HistoryQuery, err error) {
+	query = new(match.HistoryQuery)
+	for _, arg := range args {
+		keyValue := strings.SplitN(arg, "=", 2)
+		if len(keyValue) != 2 {
+			err = fmt.Errorf("Argument %s should look like key=value", arg)
+			return
+		}
+		key, value := keyValue[0], keyValue[1]
+
+		switch key {
+		case "pair":
+			query.Pair = new(match.Pair)
+			if err = query.Pair.FromString(value); err != nil {
+				err = fmt.Errorf("Error parsing pair: %s", err)
+				return
+			}
+		case "status":
+			for _, statusString := range strings.Split(value, ",") {
+				var status match.OrderStatus
+				if status, err = match.OrderStatusFromString(statusString); err != nil {
+					return
+				}
+				query.Statuses = append(query.Statuses, status)
+			}
+		case "from":
+			if query.Start, err = parseHistoryTime(value); err != nil {
+				return
+			}
+		case "to":
+			if query.End, err = parseHistoryTime(value); err != nil {
+				return
+			}
+		case "cursor":
+			if query.Cursor, err = strconv.ParseUint(value, 10, 64); err != nil {
+				err = fmt.Errorf("Error parsing cursor: %s", err)
+				return
+			}
+		case "limit":
+			if query.Limit, err = strconv.ParseUint(value, 10, 64); err != nil {
+				err = fmt.Errorf("Error parsing limit: %s", err)
+				return
+			}
+		default:
+			err = fmt.Errorf("Unknown argument %s, use pair, status, from, to, cursor, or limit", key)
+			return
+		}
+	}
+	return
+}
+
+// parseHistoryTime parses an RFC3339 time or unix seconds
+func parseHistoryTime(value string) (t time.Time, err error) {
+	var seconds int64
+	if seconds, err = strconv.ParseInt(value, 10, 64); err == nil {
+		t = time.Unix(seconds, 0)
+		return
+	}
+	if t, err = time.Parse(time.RFC3339, value); err != nil {
+		err = fmt.Errorf("Error parsing time %s, use RFC3339 or unix seconds: %s", value, err)
+		return
+	}
+	return
+}
@@ -91,6 +91,18 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 			return fmt.Errorf("Error getting deposit address: \n%s", err)
 		}
 	}
+	if cmd == "getdeposits" {
+		if getHelpForCommand(getDepositsCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify asset to get deposits for asset")
+		}
+
+		if err := cl.GetDeposits(args); err != nil {
+			return fmt.Errorf("Error getting deposits: \n%s", err)
+		}
+	}
 	if cmd == "placeorder" {
 		if getHelpForCommand(placeOrderCommand, args) {
 			return nil
@@ -103,6 +115,186 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 			return fmt.Errorf("Error calling order command: \n%s", err)
 		}
 	}
+	if cmd == "placeswaporder" {
+		if getHelpForCommand(placeSwapOrderCommand, args) {
+			return nil
+		}
+		if len(args) != 4 {
+			return fmt.Errorf("Must specify 4 arguments: side, pair, amountHave, and price")
+		}
+
+		if err := cl.SwapOrderCommand(args); err != nil {
+			return fmt.Errorf("Error calling swap order command: \n%s", err)
+		}
+	}
+	if cmd == "getswaps" {
+		if getHelpForCommand(getSwapsCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetSwaps(args); err != nil {
+			return fmt.Errorf("Error getting swaps: \n%s", err)
+		}
+	}
+	if cmd == "placechannelorder" {
+		if getHelpForCommand(placeChannelOrderCommand, args) {
+			return nil
+		}
+		if len(args) != 4 {
+			return fmt.Errorf("Must specify 4 arguments: side, pair, amountHave, and price")
+		}
+
+		if err := cl.ChannelOrderCommand(args); err != nil {
+			return fmt.Errorf("Error calling channel order command: \n%s", err)
+		}
+	}
+	if cmd == "getescrows" {
+		if getHelpForCommand(getEscrowsCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetEscrows(args); err != nil {
+			return fmt.Errorf("Error getting escrows: \n%s", err)
+		}
+	}
+	if cmd == "getliquidity" {
+		if getHelpForCommand(getLiquidityCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetLiquidity(args); err != nil {
+			return fmt.Errorf("Error getting liquidity: \n%s", err)
+		}
+	}
+	if cmd == "submarinein" {
+		if getHelpForCommand(submarineInCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: asset amount")
+		}
+
+		if err := cl.SubmarineIn(args); err != nil {
+			return fmt.Errorf("Error creating submarine swap in: \n%s", err)
+		}
+	}
+	if cmd == "submarineout" {
+		if getHelpForCommand(submarineOutCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: asset amount")
+		}
+
+		if err := cl.SubmarineOut(args); err != nil {
+			return fmt.Errorf("Error creating submarine swap out: \n%s", err)
+		}
+	}
+	if cmd == "getsubmarineswaps" {
+		if getHelpForCommand(getSubmarineSwapsCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetSubmarineSwaps(args); err != nil {
+			return fmt.Errorf("Error getting submarine swaps: \n%s", err)
+		}
+	}
+	if cmd == "claimsubmarine" {
+		if getHelpForCommand(claimSubmarineCommand, args) {
+			return nil
+		}
+		if len(args) != 3 {
+			return fmt.Errorf("Must specify 3 arguments: hash preimage address")
+		}
+
+		if err := cl.ClaimSubmarine(args); err != nil {
+			return fmt.Errorf("Error claiming submarine swap: \n%s", err)
+		}
+	}
+	if cmd == "refundsubmarine" {
+		if getHelpForCommand(refundSubmarineCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: hash address")
+		}
+
+		if err := cl.RefundSubmarine(args); err != nil {
+			return fmt.Errorf("Error refunding submarine swap: \n%s", err)
+		}
+	}
+	if cmd == "placeatomicorder" {
+		if getHelpForCommand(placeAtomicOrderCommand, args) {
+			return nil
+		}
+		if len(args) != 4 {
+			return fmt.Errorf("Must specify 4 arguments: side, pair, amountHave, and price")
+		}
+
+		if err := cl.AtomicOrderCommand(args); err != nil {
+			return fmt.Errorf("Error calling atomic swap order command: \n%s", err)
+		}
+	}
+	if cmd == "getatomicswaps" {
+		if getHelpForCommand(getAtomicSwapsCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetAtomicSwaps(args); err != nil {
+			return fmt.Errorf("Error getting atomic swaps: \n%s", err)
+		}
+	}
+	if cmd == "initiateatomic" {
+		if getHelpForCommand(initiateAtomicCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: id")
+		}
+
+		if err := cl.InitiateAtomic(args); err != nil {
+			return fmt.Errorf("Error initiating atomic swap: \n%s", err)
+		}
+	}
+	if cmd == "redeematomic" {
+		if getHelpForCommand(redeemAtomicCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: id address")
+		}
+
+		if err := cl.RedeemAtomic(args); err != nil {
+			return fmt.Errorf("Error redeeming atomic swap: \n%s", err)
+		}
+	}
+	if cmd == "refundatomic" {
+		if getHelpForCommand(refundAtomicCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: id address")
+		}
+
+		if err := cl.RefundAtomic(args); err != nil {
+			return fmt.Errorf("Error refunding atomic swap: \n%s", err)
+		}
+	}
 	if cmd == "vieworderbook" {
 		if getHelpForCommand(viewOrderbookCommand, args) {
 			return nil
@@ -131,14 +323,167 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		if getHelpForCommand(withdrawCommand, args) {
 			return nil
 		}
-		if len(args) != 3 {
-			return fmt.Errorf("Must specify 3 arguments: amount coin address")
+		if len(args) < 3 || len(args) > 5 {
+			return fmt.Errorf("Must specify 3 arguments: amount coin address, and optionally priority=priority and payfee=true")
 		}
 
 		if err := cl.Withdraw(args); err != nil {
 			return fmt.Errorf("Error calling withdraw command: \n%s", err)
 		}
 	}
+	if cmd == "getfeeestimates" {
+		if getHelpForCommand(getFeeEstimatesCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify asset to get fee estimates for asset")
+		}
+
+		if err := cl.GetFeeEstimates(args); err != nil {
+			return fmt.Errorf("Error getting fee estimates: \n%s", err)
+		}
+	}
+	if cmd == "getwithdrawals" {
+		if getHelpForCommand(getWithdrawalsCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify asset to get withdrawals for asset")
+		}
+
+		if err := cl.GetWithdrawals(args); err != nil {
+			return fmt.Errorf("Error getting withdrawals: \n%s", err)
+		}
+	}
+	if cmd == "getpubkey" {
+		if getHelpForCommand(getPubkeyCommand, args) {
+			return nil
+		}
+
+		if err := cl.GetPubkey(args); err != nil {
+			return fmt.Errorf("Error getting pubkey: \n%s", err)
+		}
+	}
+	if cmd == "listwithdrawals" {
+		if getHelpForCommand(listWithdrawalsCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: asset state")
+		}
+
+		if err := cl.ListWithdrawals(args); err != nil {
+			return fmt.Errorf("Error listing withdrawals: \n%s", err)
+		}
+	}
+	if cmd == "approvewithdrawal" {
+		if getHelpForCommand(approveWithdrawalCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: asset id")
+		}
+
+		if err := cl.ApproveWithdrawal(args); err != nil {
+			return fmt.Errorf("Error approving withdrawal: \n%s", err)
+		}
+	}
+	if cmd == "rejectwithdrawal" {
+		if getHelpForCommand(rejectWithdrawalCommand, args) {
+			return nil
+		}
+		if len(args) != 3 {
+			return fmt.Errorf("Must specify 3 arguments: asset id reason")
+		}
+
+		if err := cl.RejectWithdrawal(args); err != nil {
+			return fmt.Errorf("Error rejecting withdrawal: \n%s", err)
+		}
+	}
+	if cmd == "walletbalances" {
+		if getHelpForCommand(walletBalancesCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify asset to get wallet balances for asset")
+		}
+
+		if err := cl.WalletBalances(args); err != nil {
+			return fmt.Errorf("Error getting wallet balances: \n%s", err)
+		}
+	}
+	if cmd == "sweeptocold" {
+		if getHelpForCommand(sweepToColdCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify asset to sweep to cold wallet")
+		}
+
+		if err := cl.SweepToCold(args); err != nil {
+			return fmt.Errorf("Error sweeping to cold wallet: \n%s", err)
+		}
+	}
+	if cmd == "createrefill" {
+		if getHelpForCommand(createRefillCommand, args) {
+			return nil
+		}
+		if len(args) != 3 {
+			return fmt.Errorf("Must specify 3 arguments: asset amount refillfile")
+		}
+
+		if err := cl.CreateRefill(args); err != nil {
+			return fmt.Errorf("Error creating refill: \n%s", err)
+		}
+	}
+	if cmd == "signrefill" {
+		if getHelpForCommand(signRefillCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: refillfile keyfile")
+		}
+
+		if err := cl.SignRefill(args); err != nil {
+			return fmt.Errorf("Error signing refill: \n%s", err)
+		}
+	}
+	if cmd == "submitrefill" {
+		if getHelpForCommand(submitRefillCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify refill file to submit")
+		}
+
+		if err := cl.SubmitRefill(args); err != nil {
+			return fmt.Errorf("Error submitting refill: \n%s", err)
+		}
+	}
+	if cmd == "reconcilelightning" {
+		if getHelpForCommand(reconcileLightningCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify asset to reconcile")
+		}
+
+		if err := cl.ReconcileLightning(args); err != nil {
+			return fmt.Errorf("Error reconciling lightning deposits: \n%s", err)
+		}
+	}
+	if cmd == "getbreachalerts" {
+		if getHelpForCommand(getBreachAlertsCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetBreachAlerts(args); err != nil {
+			return fmt.Errorf("Error getting breach alerts: \n%s", err)
+		}
+	}
 	if cmd == "litwithdraw" {
 		if getHelpForCommand(litWithdrawCommand, args) {
 			return nil
@@ -151,6 +496,42 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 			return fmt.Errorf("Error calling withdraw command: \n%s", err)
 		}
 	}
+	if cmd == "paylightning" {
+		if getHelpForCommand(payLightningCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: paymentrequest")
+		}
+
+		if err := cl.PayLightning(args); err != nil {
+			return fmt.Errorf("Error paying payment request: \n%s", err)
+		}
+	}
+	if cmd == "createinvoice" {
+		if getHelpForCommand(createInvoiceCommand, args) {
+			return nil
+		}
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("Must specify 2 or 3 arguments: asset amount [expiry]")
+		}
+
+		if err := cl.CreateInvoice(args); err != nil {
+			return fmt.Errorf("Error creating invoice: \n%s", err)
+		}
+	}
+	if cmd == "getinvoices" {
+		if getHelpForCommand(getInvoicesCommand, args) {
+			return nil
+		}
+		if len(args) != 0 {
+			return fmt.Errorf("Please do not specify any arguments")
+		}
+
+		if err := cl.GetInvoices(args); err != nil {
+			return fmt.Errorf("Error getting invoices: \n%s", err)
+		}
+	}
 	if cmd == "cancelorder" {
 		if getHelpForCommand(cancelOrderCommand, args) {
 			return nil
@@ -175,6 +556,24 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 			return fmt.Errorf("Error getting pairs: \n%s", err)
 		}
 	}
+	if cmd == "orderhistory" {
+		if getHelpForCommand(orderHistoryCommand, args) {
+			return nil
+		}
+
+		if err := cl.OrderHistory(args); err != nil {
+			return fmt.Errorf("Error getting order history: \n%s", err)
+		}
+	}
+	if cmd == "fillhistory" {
+		if getHelpForCommand(fillHistoryCommand, args) {
+			return nil
+		}
+
+		if err := cl.FillHistory(args); err != nil {
+			return fmt.Errorf("Error getting fill history: \n%s", err)
+		}
+	}
 	if cmd == "getlitconnection" {
 		if getHelpForCommand(getLitConnectionCommand, args) {
 			return nil
@@ -199,6 +598,54 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 			return fmt.Errorf("Error placing auction order: \n%s", err)
 		}
 	}
+	if cmd == "auctionstatus" {
+		if getHelpForCommand(auctionStatusCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: receiptid")
+		}
+
+		if err := cl.AuctionStatus(args); err != nil {
+			return fmt.Errorf("Error getting auction order status: \n%s", err)
+		}
+	}
+	if cmd == "cancelauctionorder" {
+		if getHelpForCommand(cancelAuctionOrderCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: receiptid")
+		}
+
+		if err := cl.CancelAuctionOrder(args); err != nil {
+			return fmt.Errorf("Error cancelling auction order: \n%s", err)
+		}
+	}
+	if cmd == "auctionresult" {
+		if getHelpForCommand(auctionResultCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: auctionid")
+		}
+
+		if err := cl.AuctionResult(args); err != nil {
+			return fmt.Errorf("Error getting auction result: \n%s", err)
+		}
+	}
+	if cmd == "verifyauction" {
+		if getHelpForCommand(verifyAuctionCommand, args) {
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("Must specify 2 arguments: pair auctionid")
+		}
+
+		if err := cl.VerifyAuction(args); err != nil {
+			return fmt.Errorf("Error verifying auction: \n%s", err)
+		}
+	}
 	return nil
 }
 
@@ -212,7 +659,7 @@ func (cl *ocxClient) Help(textArgs []string) error {
 	if len(textArgs) == 0 {
 
 		fmt.Fprintf(color.Output, lnutil.Header("Commands:\n"))
-		listofCommands := []*Command{helpCommand, registerCommand, getBalanceCommand, getDepositAddressCommand, getAllBalancesCommand, withdrawCommand, litWithdrawCommand, getLitConnectionCommand, placeOrderCommand, getPriceCommand, viewOrderbookCommand, cancelOrderCommand, getPairsCommand, placeAuctionOrderCommand}
+		listofCommands := []*Command{helpCommand, registerCommand, getBalanceCommand, getDepositAddressCommand, getDepositsCommand, getAllBalancesCommand, withdrawCommand, getFeeEstimatesCommand, getWithdrawalsCommand, litWithdrawCommand, payLightningCommand, createInvoiceCommand, getInvoicesCommand, getLitConnectionCommand, placeOrderCommand, placeSwapOrderCommand, getSwapsCommand, placeChannelOrderCommand, getEscrowsCommand, getLiquidityCommand, submarineInCommand, submarineOutCommand, getSubmarineSwapsCommand, claimSubmarineCommand, refundSubmarineCommand, placeAtomicOrderCommand, getAtomicSwapsCommand, initiateAtomicCommand, redeemAtomicCommand, refundAtomicCommand, getPriceCommand, viewOrderbookCommand, cancelOrderCommand, getPairsCommand, orderHistoryCommand, fillHistoryCommand, placeAuctionOrderCommand, auctionStatusCommand, cancelAuctionOrderCommand, auctionResultCommand, verifyAuctionCommand, getPubkeyCommand, listWithdrawalsCommand, approveWithdrawalCommand, rejectWithdrawalCommand, walletBalancesCommand, sweepToColdCommand, createRefillCommand, signRefillCommand, submitRefillCommand, reconcileLightningCommand, getBreachAlertsCommand}
 		printHelp(listofCommands)
 		return nil
 	}
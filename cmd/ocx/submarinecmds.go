@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+var submarineInCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("submarinein"), lnutil.ReqColor("asset"), lnutil.ReqColor("amount")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Move amount of asset from on chain into your lightning channels with the exchange. The amount is decimal, like 0.5.",
+		"This prints an address and a preimage. Send at least amount to the address, and once it confirms the exchange offers you HTLCs for amount locked to the preimage's hash. Claim them with your lit node using the preimage.",
+		"Keep the preimage secret until you claim. If the exchange never offers its HTLCs, take your coins back with refundsubmarine once the on-chain HTLC times out.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Swap on-chain coins into your channels."),
+}
+
+// SubmarineIn creates a submarine swap from on chain into the client's channels
+func (cl *ocxClient) SubmarineIn(args []string) (err error) {
+	return cl.createSubmarineSwap(match.SubmarineIn, args)
+}
+
+var submarineOutCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("submarineout"), lnutil.ReqColor("asset"), lnutil.ReqColor("amount")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Move amount of asset out of your lightning channels with the exchange on chain. The amount is decimal, like 0.5.",
+		"This prints an address, a hash and a preimage. Offer the exchange HTLCs for amount locked to the hash with your lit node, lasting until at least the height printed. Once they're there the exchange pays the address.",
+		"Claim what it pays with claimsubmarine, which reveals the preimage so the exchange can claim your HTLCs. Keep the preimage secret until then.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Swap coins in your channels out on chain."),
+}
+
+// SubmarineOut creates a submarine swap from the client's channels out on chain
+func (cl *ocxClient) SubmarineOut(args []string) (err error) {
+	return cl.createSubmarineSwap(match.SubmarineOut, args)
+}
+
+// createSubmarineSwap picks a preimage and creates a submarine swap locked to its hash
+func (cl *ocxClient) createSubmarineSwap(direction match.SubmarineDirection, args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromString(args[0]); err != nil {
+		return
+	}
+	var amount uint64
+	if amount, err = asset.ParseAmount(args[1]); err != nil {
+		return
+	}
+
+	var preimage [16]byte
+	if _, err = rand.Read(preimage[:]); err != nil {
+		err = fmt.Errorf("Error creating preimage: %s", err)
+		return
+	}
+
+	request := &match.SubmarineRequest{
+		Direction: direction,
+		Asset:     asset,
+		Amount:    amount,
+		RHash:     sha256.Sum256(preimage[:]),
+	}
+
+	var createSubmarineSwapReply *cxrpc.CreateSubmarineSwapReply
+	if createSubmarineSwapReply, err = cl.RPCClient.CreateSubmarineSwap(request); err != nil {
+		return
+	}
+
+	swap := createSubmarineSwapReply.Swap
+	logging.Infof("Created submarine swap %s\n", swap.ID())
+	logging.Infof("Preimage, keep it secret: %x\n", preimage)
+	if direction == match.SubmarineIn {
+		logging.Infof("Send at least %s %s to %s before height %d\n", asset.FormatAmount(amount), asset, createSubmarineSwapReply.Address, swap.ChainLocktime)
+		return
+	}
+	logging.Infof("Offer HTLCs for %s %s locked to %s lasting until at least height %d\n", asset.FormatAmount(amount), asset, swap.ID(), swap.ChannelLocktime)
+	logging.Infof("The exchange will pay %s, claim it before height %d\n", createSubmarineSwapReply.Address, swap.ChainLocktime)
+	return
+}
+
+var getSubmarineSwapsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getsubmarineswaps")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get every submarine swap you've created, with its hash, amount, on-chain HTLC and locktimes, and its state.",
+		"Submarine swaps are created, funded, offered, claimed, then completed. Expired and refunded submarine swaps have a reason.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your submarine swaps and their state."),
+}
+
+// GetSubmarineSwaps prints the submarine swaps for the client's pubkey
+func (cl *ocxClient) GetSubmarineSwaps(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getSubmarineSwapsReply *cxrpc.GetSubmarineSwapsReply
+	if getSubmarineSwapsReply, err = cl.RPCClient.GetSubmarineSwaps(); err != nil {
+		return
+	}
+
+	if len(getSubmarineSwapsReply.Swaps) == 0 {
+		logging.Infof("No submarine swaps\n")
+		return
+	}
+	for _, swap := range getSubmarineSwapsReply.Swaps {
+		outpoint := "not funded"
+		if swap.Outpoint != "" {
+			outpoint = swap.Outpoint
+		}
+		logging.Infof("%s: %s %s %s, on chain %s until height %d, channel until height %d, %s %s\n", swap.ID(), swap.Direction, swap.Asset.FormatAmount(swap.Amount), swap.Asset, outpoint, swap.ChainLocktime, swap.ChannelLocktime, swap.State, swap.Reason)
+	}
+	return
+}
+
+var claimSubmarineCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.Red("claimsubmarine"), lnutil.ReqColor("hash"), lnutil.ReqColor("preimage"), lnutil.ReqColor("address")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Claim the on-chain HTLC the exchange paid for a submarine swap out, sending it to address less the network fee.",
+		"This reveals the preimage, which the exchange uses to claim your HTLCs.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Claim the on-chain side of a submarine swap out."),
+}
+
+// ClaimSubmarine claims a swap out's on-chain HTLC with the preimage
+func (cl *ocxClient) ClaimSubmarine(args []string) (err error) {
+	var preimageBytes []byte
+	if preimageBytes, err = hex.DecodeString(args[1]); err != nil || len(preimageBytes) != 16 {
+		err = fmt.Errorf("Preimage should be 16 bytes of hex: %v", err)
+		return
+	}
+	var preimage [16]byte
+	copy(preimage[:], preimageBytes)
+	return cl.spendSubmarine(args[0], &preimage, args[2])
+}
+
+var refundSubmarineCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("refundsubmarine"), lnutil.ReqColor("hash"), lnutil.ReqColor("address")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Take back what you sent to the on-chain HTLC of a submarine swap in, sending it to address less the network fee.",
+		"This only works once the on-chain HTLC has timed out, see getsubmarineswaps for the height.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Refund the on-chain side of a submarine swap in."),
+}
+
+// RefundSubmarine refunds a swap in's on-chain HTLC once it's timed out
+func (cl *ocxClient) RefundSubmarine(args []string) (err error) {
+	return cl.spendSubmarine(args[0], nil, args[1])
+}
+
+// spendSubmarine spends the on-chain HTLC of one of the client's submarine swaps to address,
+// claiming it with the preimage or refunding it if the preimage is nil
+func (cl *ocxClient) spendSubmarine(hash string, preimage *[16]byte, address string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getSubmarineSwapsReply *cxrpc.GetSubmarineSwapsReply
+	if getSubmarineSwapsReply, err = cl.RPCClient.GetSubmarineSwaps(); err != nil {
+		return
+	}
+	var swap *match.SubmarineSwap
+	for _, mySwap := range getSubmarineSwapsReply.Swaps {
+		if mySwap.ID() == hash {
+			swap = mySwap
+		}
+	}
+	if swap == nil {
+		err = fmt.Errorf("You don't have a submarine swap %s", hash)
+		return
+	}
+
+	// you claim swaps out and refund swaps in
+	if (preimage != nil) != (swap.Direction == match.SubmarineOut) {
+		err = fmt.Errorf("Submarine swap %s is %s, claim swaps out and refund swaps in", hash, swap.Direction)
+		return
+	}
+
+	var coin *coinparam.Params
+	if coin, err = swap.Asset.CoinParamFromAsset(); err != nil {
+		return
+	}
+	var outScript []byte
+	if outScript, err = util.AddressScript(address, coin); err != nil {
+		return
+	}
+
+	var getFeeEstimatesReply *cxrpc.GetFeeEstimatesReply
+	if getFeeEstimatesReply, err = cl.RPCClient.GetFeeEstimates(swap.Asset.String()); err != nil {
+		return
+	}
+	fee := uint64(getFeeEstimatesReply.Estimates.Normal) * match.SubmarineSpendSize
+
+	var spendTx *wire.MsgTx
+	if spendTx, err = swap.SpendTx(outScript, fee, cl.RPCClient.PrivKey, preimage); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = spendTx.Serialize(&buf); err != nil {
+		err = fmt.Errorf("Error serializing spend: %s", err)
+		return
+	}
+
+	var submitSubmarineSpendReply *cxrpc.SubmitSubmarineSpendReply
+	if submitSubmarineSpendReply, err = cl.RPCClient.SubmitSubmarineSpend(swap.Asset.String(), buf.Bytes()); err != nil {
+		return
+	}
+	logging.Infof("Sent %s %s to %s in %s\n", swap.Asset.FormatAmount(swap.FundAmount-fee), swap.Asset, address, submitSubmarineSpendReply.Txid)
+	return
+}
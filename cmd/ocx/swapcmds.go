@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+var placeSwapOrderCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.Red("placeswaporder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Submit an order like placeorder, but settled with HTLCs over your lightning channels with the exchange instead of your balance.",
+		"When it's filled the exchange offers you HTLCs for what you want, and you offer HTLCs with the same hash for what you give up. The exchange claims yours, which reveals the preimage so you can claim theirs.",
+		"Your lit node needs channels with the exchange for both assets. See getswaps for the hash and timeout of each swap.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Place an order settled with lightning HTLCs."),
+}
+
+// SwapOrderCommand submits a swap order
+func (cl *ocxClient) SwapOrderCommand(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var orderSide *match.Side
+	var amountHave, price uint64
+	if orderSide, amountHave, price, err = parseOrderArgs(args); err != nil {
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.RetrievePublicKey(); err != nil {
+		return
+	}
+
+	var reply *cxrpc.SubmitSwapOrderReply
+	if reply, err = cl.RPCClient.SwapOrderAtPriceCommand(pubkey, *orderSide, args[1], amountHave, price); err != nil {
+		return
+	}
+
+	var text []byte
+	if text, err = reply.OrderID.MarshalText(); err != nil {
+		err = fmt.Errorf("Could not marshal to text for some reason: %s", err)
+		return
+	}
+
+	logging.Infof("Submitted swap order successfully, orderID: %s", text)
+	return nil
+}
+
+var getSwapsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getswaps")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get every swap for your swap orders, with its hash, amounts, the height the exchange's HTLCs time out, and its state.",
+		"Swaps are pending, offered, claimed, then completed. Refunded and failed swaps have a reason. The preimage is shown once the exchange has claimed your HTLCs.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your swaps and their state."),
+}
+
+// GetSwaps prints the swaps for the client's pubkey
+func (cl *ocxClient) GetSwaps(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getSwapsReply *cxrpc.GetSwapsReply
+	if getSwapsReply, err = cl.RPCClient.GetSwaps(); err != nil {
+		return
+	}
+
+	if len(getSwapsReply.Swaps) == 0 {
+		logging.Infof("No swaps\n")
+		return
+	}
+	for _, swap := range getSwapsReply.Swaps {
+		logSwap(swap)
+	}
+	return
+}
+
+// logSwap prints a swap on one line
+func logSwap(swap *match.Swap) {
+	preimage := ""
+	if swap.Preimage != [16]byte{} {
+		preimage = fmt.Sprintf(" preimage %x", swap.Preimage)
+	}
+	// the exchange sends what you receive
+	logging.Infof("%s: receive %s %s until height %d, send %s %s, %s%s %s\n", swap.ID(), swap.SendAsset.FormatAmount(swap.AmountSend), swap.SendAsset, swap.SendLocktime, swap.ReceiveAsset.FormatAmount(swap.AmountReceive), swap.ReceiveAsset, swap.State, preimage, swap.Reason)
+}
+
+var placeChannelOrderCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.Red("placechannelorder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Submit an order like placeorder, but committed to your lightning channels with the exchange instead of your balance.",
+		"The exchange replies with a hash. Offer HTLCs to the exchange from your lit node locked to that hash, adding up to amounthave, that time out no earlier than the height shown. The order goes on the book once they're all there.",
+		"When the order is filled or cancelled the exchange offers you HTLCs with the same hash for what it got and the rest of what you locked, then claims yours, which reveals the preimage so you can claim theirs. See getescrows for the state of each escrow.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Place an order committed to HTLCs in your lightning channels."),
+}
+
+// ChannelOrderCommand submits a channel order and prints what to lock for it
+func (cl *ocxClient) ChannelOrderCommand(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var orderSide *match.Side
+	var amountHave, price uint64
+	if orderSide, amountHave, price, err = parseOrderArgs(args); err != nil {
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.RetrievePublicKey(); err != nil {
+		return
+	}
+
+	var reply *cxrpc.SubmitChannelOrderReply
+	if reply, err = cl.RPCClient.ChannelOrderAtPriceCommand(pubkey, *orderSide, args[1], amountHave, price); err != nil {
+		return
+	}
+
+	escrow := reply.Escrow
+	logging.Infof("Submitted channel order successfully, offer HTLCs to the exchange locked to hash %s adding up to %s %s, timing out no earlier than height %d\n", escrow.ID(), escrow.LockAsset.FormatAmount(escrow.AmountLock), escrow.LockAsset, escrow.Locktime)
+	return nil
+}
+
+var getEscrowsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getescrows")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get the escrow for each of your channel orders, with its hash, what's locked and until when, what it releases, and its state.",
+		"Escrows are pending, committed, releasing, claimed, then completed. Refunded and expired escrows have a reason. The preimage is shown once the exchange has claimed your HTLCs.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get your channel order escrows and their state."),
+}
+
+// GetEscrows prints the escrows for the client's pubkey
+func (cl *ocxClient) GetEscrows(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getEscrowsReply *cxrpc.GetEscrowsReply
+	if getEscrowsReply, err = cl.RPCClient.GetEscrows(); err != nil {
+		return
+	}
+
+	if len(getEscrowsReply.Escrows) == 0 {
+		logging.Infof("No escrows\n")
+		return
+	}
+	for _, escrow := range getEscrowsReply.Escrows {
+		preimage := ""
+		if escrow.Preimage != [16]byte{} {
+			preimage = fmt.Sprintf(" preimage %x", escrow.Preimage)
+		}
+		logging.Infof("%s: lock %s %s until height %d, receive %s %s, refund %s %s, %s%s %s\n", escrow.ID(), escrow.LockAsset.FormatAmount(escrow.AmountLock), escrow.LockAsset, escrow.Locktime, escrow.ReceiveAsset.FormatAmount(escrow.AmountReceive), escrow.ReceiveAsset, escrow.LockAsset.FormatAmount(escrow.AmountRefund), escrow.LockAsset, escrow.State, preimage, escrow.Reason)
+	}
+	return
+}
+
+var getLiquidityCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.Red("getliquidity")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Get how much you can receive from and send to the exchange over your channels for each asset, and how much of it is reserved for your resting swap orders and swaps that haven't been offered.",
+		"A swap order is refused if what's left after reservations doesn't cover it. The exchange opens more channels to you as your swaps need them.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get the capacity of your channels with the exchange."),
+}
+
+// GetLiquidity prints the liquidity of the client's channels
+func (cl *ocxClient) GetLiquidity(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	var getLiquidityReply *cxrpc.GetLiquidityReply
+	if getLiquidityReply, err = cl.RPCClient.GetLiquidity(); err != nil {
+		return
+	}
+
+	if len(getLiquidityReply.Liquidity) == 0 {
+		logging.Infof("No channels with the exchange\n")
+		return
+	}
+	for _, liq := range getLiquidityReply.Liquidity {
+		// the exchange's outbound is what you receive
+		logging.Infof("%s over %d channels: receive %s (%s reserved), send %s (%s reserved)\n", liq.Asset, liq.Channels, liq.Asset.FormatAmount(liq.Outbound), liq.Asset.FormatAmount(liq.ReservedOutbound), liq.Asset.FormatAmount(liq.Inbound), liq.Asset.FormatAmount(liq.ReservedInbound))
+	}
+	return
+}
@@ -4,12 +4,12 @@
 package main
 
 import (
-	"bytes"
 	"encoding/hex"
-	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
@@ -18,8 +18,10 @@ import (
 	flags "github.com/jessevdk/go-flags"
 	util "github.com/mit-dci/opencx/chainutils"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxdb/cxdbbolt"
 	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
 	"github.com/mit-dci/opencx/cxdb/cxdbsql"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/cxrpc"
 	"github.com/mit-dci/opencx/cxserver"
 	"github.com/mit-dci/opencx/logging"
@@ -57,6 +59,12 @@ type opencxConfig struct {
 	Litereghost string `long:"litereg" description:"Connect to litecoin regtest. Specify a socket address."`
 	Rtvtchost   string `long:"rtvtc" description:"Connect to Vertcoin regtest node. Specify a socket address."`
 
+	// any chain in the asset registry can be connected to with a host entry
+	Hosts []string `long:"host" description:"Connect to a full node for any coin, as coin=host, for example litecoin=localhost:9333. The coin has to have an asset, either built in or from an asset entry"`
+
+	// Assets on top of (or replacing) the built in ones
+	Assets []string `long:"asset" description:"Add or change an asset, as coin=asset:decimals:mindeposit:minwithdrawal:lotsize:ticksize:name, for example litecoin=2:8:100000:1000000:0:0:Litecoin. The coin is a coinparam name, the asset is the byte clients use for it, and amounts are in base units"`
+
 	// configuration for concurrent RPC users.
 	MaxPeers    uint16   `long:"numpeers" description:"Maximum number of peers that you'd like to support"`
 	MinPeerPort uint16   `long:"minpeerport" description:"Port to start creating ports for peers at"`
@@ -78,6 +86,52 @@ type opencxConfig struct {
 
 	// support lightning or not to support lightning?
 	LightningSupport bool `long:"lightning" description:"Whether or not to support lightning on the exchange"`
+
+	// Storage backend, sql needs a database server, bolt stores everything in the home directory
+	DBBackend string `long:"dbbackend" description:"Storage backend to use, either sql or bolt"`
+
+	// Memory stores backed by a write-ahead log, for the stores that have one
+	WAL                 bool   `long:"wal" description:"Whether or not to keep the settlement engines and deposit stores in memory, backed by a write-ahead log and snapshots in the wal directory, instead of in the storage backend"`
+	WALSnapshotInterval uint64 `long:"walsnapshotinterval" description:"How many records get written to a write-ahead log before the state is snapshotted and the log is cleared"`
+
+	// Event log, for replaying the matching engines offline with cxreplay
+	EventLog bool `long:"eventlog" description:"Whether or not to record every input to the exchange in an event log"`
+
+	// Confirmations deposits need, per coin
+	Confirmations []string `long:"confirmations" description:"Confirmations deposits of a coin need, as coin=confirmations or coin=confirmations:scaleamount:maxconfirmations to add a confirmation for every scaleamount deposited. Coins without one need 6"`
+
+	// Withdrawal limits and batching
+	WithdrawalLimits []string      `long:"withdrawallimits" description:"Withdrawal limits for a coin, as coin=dailylimit or coin=dailylimit:approvalthreshold so withdrawals above the threshold need an admin to approve them. Coins without one have no limit"`
+	AdminPubkey      string        `long:"adminpubkey" description:"Hex compressed pubkey allowed to run admin commands, like approving withdrawals"`
+	BatchInterval    time.Duration `long:"batchinterval" description:"How often approved withdrawals get batched into a transaction and sent"`
+	WithdrawalDomain string        `long:"withdrawaldomain" description:"Domain users sign withdrawals for, so withdrawals signed for another exchange can't be used here. Use something unique to this exchange, like its hostname"`
+
+	// Hot wallet ceilings and cold wallets
+	ColdWallets   []string      `long:"coldwallets" description:"Hot wallet ceiling and cold wallet for a coin, as coin=hotceiling:destination where destination is a cold xpub or P2PKH or P2WPKH address. Anything in the hot wallet above the ceiling is swept to the cold wallet. Coins without one keep everything in the hot wallet"`
+	SweepInterval time.Duration `long:"sweepinterval" description:"How often hot wallets above their ceiling get swept to their cold wallet"`
+
+	// Swap orders, settled with HTLCs over lightning
+	SwapTimeout     uint32 `long:"swaptimeout" description:"How many blocks the exchange's HTLCs for a swap are locked for before they can be refunded"`
+	SwapClaimMargin uint32 `long:"swapclaimmargin" description:"How many blocks a user's HTLCs for a swap have to be locked for past the current height before the exchange claims them"`
+	EscrowTimeout   uint32 `long:"escrowtimeout" description:"How many blocks a user's HTLCs for a channel order have to be locked for, the order comes off the book before they time out"`
+	MinChannel      uint64 `long:"minchannel" description:"The smallest channel the exchange opens to a user that needs more capacity for swaps, in base units"`
+	MaxChannel      uint64 `long:"maxchannel" description:"The largest channel the exchange opens to a user that needs more capacity for swaps, in base units"`
+	FlowHeadroom    uint64 `long:"flowheadroom" description:"How much of a user's recent swap flow, in percent, the exchange keeps spare capacity for on top of what's reserved"`
+	FlowDecay       uint64 `long:"flowdecay" description:"How much of a user's recent swap flow, in percent, is forgotten every block"`
+
+	// Lightning deposit reconciliation
+	ReconcileInterval time.Duration `long:"reconcileinterval" description:"How often lightning deposits get reconciled with the balances of the channels they came in on"`
+
+	// Watchtowers for the exchange's channels
+	Tower         bool          `long:"tower" description:"Whether or not to run a watchtower for the exchange's channels and for users' channels with the exchange"`
+	TowerPeer     string        `long:"towerpeer" description:"Another watchtower to send the exchange's channel states to, so breaches are punished while the exchange is down, as ln1...@host:port"`
+	TowerInterval time.Duration `long:"towerinterval" description:"How often the exchange's channel states get sent to its watchtowers"`
+
+	// Atomic swap pairs, settled between users' own wallets without the exchange holding anything
+	AtomicPairs       []string      `long:"atomicpairs" description:"A pair whose orders are settled by on-chain atomic swap between users instead of through balances, as have/want like btc/ltc. Both coins need a host so the exchange can follow the swaps"`
+	AtomicTimeout     time.Duration `long:"atomictimeout" description:"How long the participant's side of an atomic swap is locked for, the initiator's side is locked for twice as long"`
+	AtomicMaxAbandons int           `long:"atomicmaxabandons" description:"How many atomic swaps a user can abandon within the atomic ban duration before they can't place atomic swap orders"`
+	AtomicBanDuration time.Duration `long:"atomicbanduration" description:"How long an abandoned atomic swap counts against the user who abandoned it"`
 }
 
 var (
@@ -97,6 +151,36 @@ var (
 
 	// Yes we want lightning
 	defaultLightningSupport = true
+
+	// default storage options
+	defaultDBBackend   = sqlBackend
+	defaultBoltDirName = "db"
+
+	// memory stores use the storage backend unless asked for
+	defaultWAL                 = false
+	defaultWALDirName          = "wal"
+	defaultWALSnapshotInterval = uint64(1000)
+
+	// the event log is off unless asked for
+	defaultEventLog         = false
+	defaultEventLogFileName = "events.log"
+
+	// batch withdrawals every 10 minutes, about once a bitcoin block
+	defaultBatchInterval = 10 * time.Minute
+
+	// sweep hot wallets to cold every hour
+	defaultSweepInterval = time.Hour
+
+	// reconcile lightning deposits with channel balances every 10 minutes
+	defaultReconcileInterval = 10 * time.Minute
+
+	// send channel states to watchtowers every 10 minutes
+	defaultTowerInterval = 10 * time.Minute
+)
+
+const (
+	sqlBackend  = "sql"
+	boltBackend = "bolt"
 )
 
 // newConfigParser returns a new command line flags parser.
@@ -123,24 +207,71 @@ func main() {
 		Litport:          defaultLitport,
 		AuthenticatedRPC: defaultAuthenticatedRPC,
 		LightningSupport: defaultLightningSupport,
+		DBBackend:        defaultDBBackend,
+		EventLog:         defaultEventLog,
+		BatchInterval:    defaultBatchInterval,
+		WithdrawalDomain: match.DefaultWithdrawalDomain,
+		SweepInterval:    defaultSweepInterval,
+		SwapTimeout:      match.DefaultSwapTimeout,
+		SwapClaimMargin:  match.DefaultSwapClaimMargin,
+		EscrowTimeout:    match.DefaultEscrowTimeout,
+		MinChannel:       match.DefaultMinChannel,
+		MaxChannel:       match.DefaultMaxChannel,
+		FlowHeadroom:     match.DefaultFlowHeadroom,
+		FlowDecay:        match.DefaultFlowDecay,
+
+		ReconcileInterval: defaultReconcileInterval,
+		TowerInterval:     defaultTowerInterval,
+		AtomicTimeout:     match.DefaultAtomicSwapTimeout,
+		AtomicMaxAbandons: match.DefaultAtomicSwapMaxAbandons,
+		AtomicBanDuration: match.DefaultAtomicSwapBanDuration,
+
+		WAL:                 defaultWAL,
+		WALSnapshotInterval: defaultWALSnapshotInterval,
 	}
 
 	// Check and load config params
 	key := opencxSetup(&conf)
 
+	// Assets from the configuration have to be registered before anything looks them up
+	if err = registerAssets(&conf); err != nil {
+		logging.Fatalf("Error registering assets: %s", err)
+	}
+	for _, info := range match.RegisteredAssets() {
+		logging.Debugf("Asset %s", info)
+	}
+
 	// Build the list of coins the server will support from the
 	// command-line configuration.
-	coinList := generateCoinList(&conf)
+	var coinList []*coinparam.Params
+	if coinList, err = generateCoinList(&conf); err != nil {
+		logging.Fatalf("Error generating coin list: %s", err)
+	}
 
 	var pairList []*match.Pair
 	if pairList, err = match.GenerateAssetPairs(coinList); err != nil {
 		logging.Fatalf("Could not generate asset pairs from coin list: %s", err)
 	}
 
+	if conf.DBBackend != sqlBackend && conf.DBBackend != boltBackend {
+		logging.Fatalf("Unknown storage backend %s, use %s or %s", conf.DBBackend, sqlBackend, boltBackend)
+	}
+	boltDir := filepath.Join(conf.OpencxHomeDir, defaultBoltDirName)
+	walConf := &cxdbmemory.WALConfig{
+		Dir:              filepath.Join(conf.OpencxHomeDir, defaultWALDirName),
+		SnapshotInterval: conf.WALSnapshotInterval,
+	}
+
 	logging.Infof("Creating limit engines...")
 	var mengines map[match.Pair]match.LimitEngine
-	if mengines, err = cxdbsql.CreateLimitEngineMap(pairList); err != nil {
-		logging.Fatalf("Error creating limit engine map with coinlist for opencxd: %s", err)
+	if conf.DBBackend == boltBackend {
+		if mengines, err = cxdbbolt.CreateLimitEngineMap(pairList, boltDir); err != nil {
+			logging.Fatalf("Error creating limit engine map with coinlist for opencxd: %s", err)
+		}
+	} else {
+		if mengines, err = cxdbsql.CreateLimitEngineMap(pairList); err != nil {
+			logging.Fatalf("Error creating limit engine map with coinlist for opencxd: %s", err)
+		}
 	}
 
 	var setEngines map[*coinparam.Params]match.SettlementEngine
@@ -165,6 +296,16 @@ func main() {
 		if setEngines, err = cxdbmemory.CreatePinkySwearEngineMap(whitelistMap, true); err != nil {
 			logging.Fatalf("Error creating pinky swear settlement engine map for opencxd: %s", err)
 		}
+	} else if conf.WAL {
+		logging.Infof("Creating settlement engines with write-ahead logs...")
+		if setEngines, err = cxdbmemory.CreateSettlementEngineMapWithWAL(coinList, walConf); err != nil {
+			logging.Fatalf("Error creating settlement engine map for opencxd: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		logging.Infof("Creating settlement engines...")
+		if setEngines, err = cxdbbolt.CreateSettlementEngineMap(coinList, boltDir); err != nil {
+			logging.Fatalf("Error creating settlement engine map for opencxd: %s", err)
+		}
 	} else {
 		logging.Infof("Creating settlement engines...")
 		if setEngines, err = cxdbsql.CreateSettlementEngineMap(coinList); err != nil {
@@ -177,39 +318,162 @@ func main() {
 
 	logging.Infof("Creating limit orderbooks...")
 	var limBooks map[match.Pair]match.LimitOrderbook
-	if limBooks, err = cxdbsql.CreateLimitOrderbookMap(pairList); err != nil {
-		logging.Fatalf("Error creating limit orderbook map for opencxd: %s", err)
+	if conf.DBBackend == boltBackend {
+		if limBooks, err = cxdbbolt.CreateLimitOrderbookMap(pairList, boltDir); err != nil {
+			logging.Fatalf("Error creating limit orderbook map for opencxd: %s", err)
+		}
+	} else {
+		if limBooks, err = cxdbsql.CreateLimitOrderbookMap(pairList); err != nil {
+			logging.Fatalf("Error creating limit orderbook map for opencxd: %s", err)
+		}
 	}
 
 	// Each coin requires its own persistent deposit store where
 	// on-chain funds can be tracked.
 	logging.Infof("Creating deposit stores...")
 	var depositStores map[*coinparam.Params]cxdb.DepositStore
-	if len(conf.Whitelist) != 0 {
+	if conf.WAL {
+		if depositStores, err = cxdbmemory.CreateDepositStoreMapWithWAL(coinList, walConf); err != nil {
+			logging.Fatalf("Error creating deposit store map for opencxd: %s", err)
+		}
+	} else if len(conf.Whitelist) != 0 {
 		if depositStores, err = cxdbmemory.CreateDepositStoreMap(coinList); err != nil {
 			logging.Fatalf("Error creating deposit store map for opencxd: %s", err)
 		}
+	} else if conf.DBBackend == boltBackend {
+		if depositStores, err = cxdbbolt.CreateDepositStoreMap(coinList, boltDir); err != nil {
+			logging.Fatalf("Error creating deposit store map for opencxd: %s", err)
+		}
 	} else {
 		if depositStores, err = cxdbsql.CreateDepositStoreMap(coinList); err != nil {
 			logging.Fatalf("Error creating deposit store map for opencxd: %s", err)
 		}
 	}
 
+	// Withdrawals are queued in a store per coin until they're confirmed
+	logging.Infof("Creating withdrawal stores...")
+	var withdrawalStores map[*coinparam.Params]cxdb.WithdrawalStore
+	if len(conf.Whitelist) != 0 {
+		if withdrawalStores, err = cxdbmemory.CreateWithdrawalStoreMap(coinList); err != nil {
+			logging.Fatalf("Error creating withdrawal store map for opencxd: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		if withdrawalStores, err = cxdbbolt.CreateWithdrawalStoreMap(coinList, boltDir); err != nil {
+			logging.Fatalf("Error creating withdrawal store map for opencxd: %s", err)
+		}
+	} else {
+		if withdrawalStores, err = cxdbsql.CreateWithdrawalStoreMap(coinList); err != nil {
+			logging.Fatalf("Error creating withdrawal store map for opencxd: %s", err)
+		}
+	}
+
+	// Cold wallets only get a store for the coins that have one
+	var coldPolicies map[*coinparam.Params]*match.ColdWalletPolicy
+	if coldPolicies, err = generateColdWalletPolicies(&conf, coinList); err != nil {
+		logging.Fatalf("Error generating cold wallet policies for opencxd: %s", err)
+	}
+	var coldCoins []*coinparam.Params
+	for coin := range coldPolicies {
+		coldCoins = append(coldCoins, coin)
+	}
+	logging.Infof("Creating cold stores...")
+	var coldStores map[*coinparam.Params]cxdb.ColdStore
+	if len(conf.Whitelist) != 0 {
+		if coldStores, err = cxdbmemory.CreateColdStoreMap(coldCoins); err != nil {
+			logging.Fatalf("Error creating cold store map for opencxd: %s", err)
+		}
+	} else if conf.DBBackend == boltBackend {
+		if coldStores, err = cxdbbolt.CreateColdStoreMap(coldCoins, boltDir); err != nil {
+			logging.Fatalf("Error creating cold store map for opencxd: %s", err)
+		}
+	} else {
+		if coldStores, err = cxdbsql.CreateColdStoreMap(coldCoins); err != nil {
+			logging.Fatalf("Error creating cold store map for opencxd: %s", err)
+		}
+	}
+
 	logging.Infof("Creating settlement stores...")
 	var setStores map[*coinparam.Params]cxdb.SettlementStore
-	if setStores, err = cxdbsql.CreateSettlementStoreMap(coinList); err != nil {
-		logging.Fatalf("Error creating settlement store map for opencxd: %s", err)
+	if conf.DBBackend == boltBackend {
+		if setStores, err = cxdbbolt.CreateSettlementStoreMap(coinList, boltDir); err != nil {
+			logging.Fatalf("Error creating settlement store map for opencxd: %s", err)
+		}
+	} else {
+		if setStores, err = cxdbsql.CreateSettlementStoreMap(coinList); err != nil {
+			logging.Fatalf("Error creating settlement store map for opencxd: %s", err)
+		}
+	}
+
+	logging.Infof("Creating history store...")
+	var historyStore cxdb.HistoryStore
+	if conf.DBBackend == boltBackend {
+		if historyStore, err = cxdbbolt.CreateHistoryStore(boltDir); err != nil {
+			logging.Fatalf("Error creating history store for opencxd: %s", err)
+		}
+	} else {
+		if historyStore, err = cxdbsql.CreateHistoryStore(); err != nil {
+			logging.Fatalf("Error creating history store for opencxd: %s", err)
+		}
 	}
 
 	// Anyways, here's where we set the server
 	var ocxServer *cxserver.OpencxServer
-	if ocxServer, err = cxserver.InitServer(setEngines, mengines, limBooks, depositStores, setStores, conf.OpencxHomeDir); err != nil {
+	if ocxServer, err = cxserver.InitServer(setEngines, mengines, limBooks, depositStores, withdrawalStores, setStores, historyStore, conf.OpencxHomeDir); err != nil {
 		logging.Fatalf("Error initializing server for opencxd: %s", err)
 	}
 
+	if conf.EventLog {
+		var eventLog *cxevent.EventLog
+		if eventLog, err = cxevent.OpenEventLog(filepath.Join(conf.OpencxHomeDir, defaultEventLogFileName)); err != nil {
+			logging.Fatalf("Error opening event log for opencxd: %s", err)
+		}
+		ocxServer.SetEventLog(eventLog)
+	}
+
+	var policies map[*coinparam.Params]*match.ConfirmationPolicy
+	if policies, err = generateConfirmationPolicies(&conf, coinList); err != nil {
+		logging.Fatalf("Error generating confirmation policies for opencxd: %s", err)
+	}
+	for coin, policy := range policies {
+		ocxServer.SetConfirmationPolicy(coin, policy)
+	}
+
+	var withdrawalPolicies map[*coinparam.Params]*match.WithdrawalPolicy
+	if withdrawalPolicies, err = generateWithdrawalPolicies(&conf, coinList); err != nil {
+		logging.Fatalf("Error generating withdrawal policies for opencxd: %s", err)
+	}
+	for coin, policy := range withdrawalPolicies {
+		ocxServer.SetWithdrawalPolicy(coin, policy)
+	}
+
+	ocxServer.SetWithdrawalDomain(conf.WithdrawalDomain)
+
+	for coin, policy := range coldPolicies {
+		if err = ocxServer.SetColdWallet(coin, policy, coldStores[coin]); err != nil {
+			logging.Fatalf("Error setting cold wallet for opencxd: %s", err)
+		}
+	}
+
+	if conf.AdminPubkey != "" {
+		var adminPubkey *koblitz.PublicKey
+		if adminPubkey, err = parseAdminPubkey(conf.AdminPubkey); err != nil {
+			logging.Fatalf("Error parsing admin pubkey for opencxd: %s", err)
+		}
+		ocxServer.SetAdminPubkey(adminPubkey)
+	}
+
 	// For debugging but also it looks nice
 	for _, coin := range coinList {
 		logging.Infof("Coin supported: %s", coin.Name)
+		if policy, ok := policies[coin]; ok {
+			logging.Infof("Confirmation policy for %s: %s", coin.Name, policy)
+		}
+		if policy, ok := withdrawalPolicies[coin]; ok {
+			logging.Infof("Withdrawal policy for %s: %s", coin.Name, policy)
+		}
+		if policy, ok := coldPolicies[coin]; ok {
+			logging.Infof("Hot wallet ceiling and cold wallet for %s: %s", coin.Name, policy)
+		}
 	}
 
 	// Check that the private key exists and if it does, load it
@@ -220,7 +484,11 @@ func main() {
 	// Generate the host param list
 	// the host params are all of the coinparams / coins we support
 	// this coinparam list is generated from the configuration file with generateHostParams
-	hpList := util.HostParamList(generateHostParams(&conf))
+	var hostParamList []*util.HostParams
+	if hostParamList, err = generateHostParams(&conf); err != nil {
+		logging.Fatalf("Error generating host params: \n%s", err)
+	}
+	hpList := util.HostParamList(hostParamList)
 
 	// Set up all chain hooks and wallets
 	if err = ocxServer.SetupAllWallets(hpList, "wallit/", conf.Resync); err != nil {
@@ -228,6 +496,54 @@ func main() {
 		return
 	}
 
+	// Now that there are wallets, start sending approved withdrawals
+	if conf.BatchInterval <= 0 {
+		logging.Fatalf("Batch interval must be positive, got %s", conf.BatchInterval)
+	}
+	ocxServer.StartWithdrawalBatcher(conf.BatchInterval)
+
+	// and keep the hot wallets under their ceilings
+	if len(coldPolicies) != 0 {
+		if conf.SweepInterval <= 0 {
+			logging.Fatalf("Sweep interval must be positive, got %s", conf.SweepInterval)
+		}
+		ocxServer.StartColdSweeper(conf.SweepInterval)
+	}
+
+	// orders on atomic swap pairs are settled between the users' own wallets, the exchange
+	// only follows the swaps on chain
+	if len(conf.AtomicPairs) != 0 {
+		atomicPairs := make([]*match.Pair, len(conf.AtomicPairs))
+		for i, pairString := range conf.AtomicPairs {
+			atomicPairs[i] = new(match.Pair)
+			if err = atomicPairs[i].FromString(pairString); err != nil {
+				logging.Fatalf("Error parsing atomic swap pair %s: %s", pairString, err)
+			}
+		}
+		if conf.AtomicTimeout <= 0 || conf.AtomicMaxAbandons <= 0 || conf.AtomicBanDuration <= 0 {
+			logging.Fatalf("Atomic timeout, max abandons and ban duration must be positive, got %s, %d and %s", conf.AtomicTimeout, conf.AtomicMaxAbandons, conf.AtomicBanDuration)
+		}
+		atomicPolicy := &match.AtomicSwapPolicy{Timeout: conf.AtomicTimeout, MaxAbandons: conf.AtomicMaxAbandons, BanDuration: conf.AtomicBanDuration}
+
+		var atomicSwapStore cxdb.AtomicSwapStore
+		if len(conf.Whitelist) != 0 {
+			if atomicSwapStore, err = cxdbmemory.CreateAtomicSwapStore(); err != nil {
+				logging.Fatalf("Error creating atomic swap store for opencxd: %s", err)
+			}
+		} else if conf.DBBackend == boltBackend {
+			if atomicSwapStore, err = cxdbbolt.CreateAtomicSwapStore(boltDir); err != nil {
+				logging.Fatalf("Error creating atomic swap store for opencxd: %s", err)
+			}
+		} else {
+			if atomicSwapStore, err = cxdbsql.CreateAtomicSwapStore(); err != nil {
+				logging.Fatalf("Error creating atomic swap store for opencxd: %s", err)
+			}
+		}
+		if err = ocxServer.SetAtomicSwapStore(atomicSwapStore, atomicPolicy, atomicPairs); err != nil {
+			logging.Fatalf("Error setting atomic swap store for opencxd: %s", err)
+		}
+	}
+
 	if conf.LightningSupport {
 		// start the lit node for the exchange
 		if err = ocxServer.SetupLitNode(key, "lit", "http://hubris.media.mit.edu:46580", "", ""); err != nil {
@@ -247,11 +563,107 @@ func main() {
 		ocxServer.ExchangeNode.Events.RegisterHandler("qln.chanupdate.push", ocxServer.GetPushHandler())
 		logging.Infof("done registering push handler")
 
+		// swap orders need somewhere to keep their swaps
+		if conf.SwapClaimMargin >= conf.SwapTimeout {
+			logging.Fatalf("Swap claim margin must be less than the swap timeout, got %d and %d", conf.SwapClaimMargin, conf.SwapTimeout)
+		}
+		// channel orders have to be able to rest on the book before they're released
+		if conf.EscrowTimeout <= 2*conf.SwapClaimMargin {
+			logging.Fatalf("Escrow timeout must be more than twice the swap claim margin, got %d and %d", conf.EscrowTimeout, conf.SwapClaimMargin)
+		}
+		ocxServer.SwapPolicy = &match.SwapPolicy{Timeout: conf.SwapTimeout, ClaimMargin: conf.SwapClaimMargin, EscrowTimeout: conf.EscrowTimeout}
+
+		// channels are opened to users whose swaps need more capacity
+		if conf.MinChannel > conf.MaxChannel || conf.FlowDecay > 100 {
+			logging.Fatalf("Min channel must be at most max channel and flow decay at most 100, got %d, %d and %d", conf.MinChannel, conf.MaxChannel, conf.FlowDecay)
+		}
+		ocxServer.SetLiquidityPolicy(&match.LiquidityPolicy{MinChannel: conf.MinChannel, MaxChannel: conf.MaxChannel, FlowHeadroom: conf.FlowHeadroom, FlowDecay: conf.FlowDecay})
+		logging.Infof("Liquidity policy: %s", ocxServer.LiquidityPolicy)
+
+		var swapStore cxdb.SwapStore
+		if len(conf.Whitelist) != 0 {
+			if swapStore, err = cxdbmemory.CreateSwapStore(); err != nil {
+				logging.Fatalf("Error creating swap store for opencxd: %s", err)
+			}
+		} else if conf.DBBackend == boltBackend {
+			if swapStore, err = cxdbbolt.CreateSwapStore(boltDir); err != nil {
+				logging.Fatalf("Error creating swap store for opencxd: %s", err)
+			}
+		} else {
+			if swapStore, err = cxdbsql.CreateSwapStore(); err != nil {
+				logging.Fatalf("Error creating swap store for opencxd: %s", err)
+			}
+		}
+		if err = ocxServer.SetSwapStore(swapStore); err != nil {
+			logging.Fatalf("Error setting swap store for opencxd: %s", err)
+		}
+
+		// submarine swaps move coins between the chain and lightning channels without a balance
+		var submarineStore cxdb.SubmarineStore
+		if len(conf.Whitelist) != 0 {
+			if submarineStore, err = cxdbmemory.CreateSubmarineStore(); err != nil {
+				logging.Fatalf("Error creating submarine swap store for opencxd: %s", err)
+			}
+		} else if conf.DBBackend == boltBackend {
+			if submarineStore, err = cxdbbolt.CreateSubmarineStore(boltDir); err != nil {
+				logging.Fatalf("Error creating submarine swap store for opencxd: %s", err)
+			}
+		} else {
+			if submarineStore, err = cxdbsql.CreateSubmarineStore(); err != nil {
+				logging.Fatalf("Error creating submarine swap store for opencxd: %s", err)
+			}
+		}
+		if err = ocxServer.SetSubmarineStore(submarineStore); err != nil {
+			logging.Fatalf("Error setting submarine swap store for opencxd: %s", err)
+		}
+
+		// lightning deposits are recorded per channel state so they're only credited once
+		var lightningDepositStore cxdb.LightningDepositStore
+		if len(conf.Whitelist) != 0 {
+			if lightningDepositStore, err = cxdbmemory.CreateLightningDepositStore(); err != nil {
+				logging.Fatalf("Error creating lightning deposit store for opencxd: %s", err)
+			}
+		} else if conf.DBBackend == boltBackend {
+			if lightningDepositStore, err = cxdbbolt.CreateLightningDepositStore(boltDir); err != nil {
+				logging.Fatalf("Error creating lightning deposit store for opencxd: %s", err)
+			}
+		} else {
+			if lightningDepositStore, err = cxdbsql.CreateLightningDepositStore(); err != nil {
+				logging.Fatalf("Error creating lightning deposit store for opencxd: %s", err)
+			}
+		}
+		ocxServer.SetLightningDepositStore(lightningDepositStore)
+		if conf.ReconcileInterval <= 0 {
+			logging.Fatalf("Reconcile interval must be positive, got %s", conf.ReconcileInterval)
+		}
+		ocxServer.StartLightningReconciler(conf.ReconcileInterval)
+
+		logging.Infof("registering swap htlc handler")
+		ocxServer.ExchangeNode.Events.RegisterHandler("qln.chanupdate.sigrev", ocxServer.GetSwapHTLCHandler())
+		logging.Infof("done registering swap htlc handler")
+
+		// the tower has to be set up before the wallets are linked so they feed it blocks
+		if conf.Tower {
+			if err = ocxServer.SetupWatchtower(); err != nil {
+				logging.Fatalf("Error setting up watchtower: %s", err)
+			}
+		}
+		if conf.TowerPeer != "" {
+			ocxServer.SetTowerPeer(conf.TowerPeer)
+		}
+
 		// Waited until the wallets are started, time to link them!
 		if err = ocxServer.LinkAllWallets(); err != nil {
 			logging.Fatalf("Could not link wallets: \n%s", err)
 		}
 
+		if conf.Tower || conf.TowerPeer != "" {
+			if conf.TowerInterval <= 0 {
+				logging.Fatalf("Tower interval must be positive, got %s", conf.TowerInterval)
+			}
+			ocxServer.StartTowerSync(conf.TowerInterval)
+		}
+
 		// Listen on a bunch of ports according to the number of peers you want to support.
 		for portNum := conf.MinPeerPort; portNum < conf.MinPeerPort+conf.MaxPeers; portNum++ {
 			var _ string
@@ -288,6 +700,22 @@ func main() {
 				logging.Fatalf("Error killing server: %s", err)
 			}
 
+			if ocxServer.EventLog != nil {
+				if err = ocxServer.EventLog.Close(); err != nil {
+					logging.Errorf("Error closing event log: %s", err)
+				}
+			}
+
+			// stores with a write-ahead log take a final snapshot, so the next start doesn't replay
+			if conf.WAL {
+				for _, store := range setEngines {
+					closeWALStore(store)
+				}
+				for _, store := range depositStores {
+					closeWALStore(store)
+				}
+			}
+
 			return
 		}
 	}()
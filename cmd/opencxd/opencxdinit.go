@@ -4,12 +4,17 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
 	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/match"
 
 	memguard "github.com/awnumar/memguard"
 	flags "github.com/jessevdk/go-flags"
@@ -201,17 +206,38 @@ func obtainPassword(conf *opencxConfig) (*memguard.LockedBuffer, error) {
 	return nil, nil
 }
 
+// registerAssets adds the assets in the configuration to the asset registry, on top of the built
+// in ones. This has to happen before anything looks up an asset.
+func registerAssets(conf *opencxConfig) (err error) {
+	for _, assetStr := range conf.Assets {
+		var info *match.AssetInfo
+		if info, err = match.ParseAssetInfo(assetStr); err != nil {
+			return
+		}
+		if err = match.RegisterAsset(info); err != nil {
+			err = fmt.Errorf("Error registering asset %s: %s", assetStr, err)
+			return
+		}
+	}
+	return
+}
+
 // generateCoinList derives the list of coin parameters from the command
 // line configuration.  It reuses generateHostParams and strips the host
 // information.
-func generateCoinList(conf *opencxConfig) []*coinparam.Params {
-	return util.HostParamList(generateHostParams(conf)).CoinListFromHostParams()
+func generateCoinList(conf *opencxConfig) (coinList []*coinparam.Params, err error) {
+	var hostParamList []*util.HostParams
+	if hostParamList, err = generateHostParams(conf); err != nil {
+		return
+	}
+	coinList = util.HostParamList(hostParamList).CoinListFromHostParams()
+	return
 }
 
 // generateHostParams constructs a list of HostParams based on the
 // configured network options.  Only entries with a host specified are
-// included in the result.
-func generateHostParams(conf *opencxConfig) (hostParamList []*util.HostParams) {
+// included in the result, and every coin has to be a registered asset.
+func generateHostParams(conf *opencxConfig) (hostParamList []*util.HostParams, err error) {
 	// Regular networks (Just like don't use any of these, I support them though)
 	if conf.Btchost != "" {
 		hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.BitcoinParams, Host: conf.Btchost})
@@ -220,8 +246,10 @@ func generateHostParams(conf *opencxConfig) (hostParamList []*util.HostParams) {
 	if conf.Vtchost != "" {
 		hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.VertcoinParams, Host: conf.Vtchost})
 	}
-	// Wait until supported by lit
-	// hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.LitecoinParams, Host: conf.Ltchost})
+
+	if conf.Ltchost != "" {
+		hostParamList = append(hostParamList, &util.HostParams{Param: &util.LitecoinParams, Host: conf.Ltchost})
+	}
 
 	// Test nets
 	if conf.Tn3host != "" {
@@ -248,5 +276,160 @@ func generateHostParams(conf *opencxConfig) (hostParamList []*util.HostParams) {
 	if conf.Litereghost != "" {
 		hostParamList = append(hostParamList, &util.HostParams{Param: &coinparam.LiteRegNetParams, Host: conf.Litereghost})
 	}
+
+	// Anything else from host entries
+	var extraHosts []*util.HostParams
+	if extraHosts, err = util.ParseHostParams(conf.Hosts); err != nil {
+		return
+	}
+	hostParamList = append(hostParamList, extraHosts...)
+
+	if err = checkHostParams(hostParamList); err != nil {
+		return
+	}
+	return
+}
+
+// checkHostParams makes sure no coin has two hosts, and that every coin is a registered asset
+func checkHostParams(hostParamList []*util.HostParams) (err error) {
+	seen := make(map[*coinparam.Params]bool)
+	for _, hostParam := range hostParamList {
+		if seen[hostParam.Param] {
+			err = fmt.Errorf("Coin %s has more than one host", hostParam.Param.Name)
+			return
+		}
+		seen[hostParam.Param] = true
+
+		if _, err = match.AssetFromCoinParam(hostParam.Param); err != nil {
+			err = fmt.Errorf("Coin %s isn't an asset, add an asset entry for it", hostParam.Param.Name)
+			return
+		}
+	}
+	return
+}
+
+// generateConfirmationPolicies parses the confirmation policies in the configuration, which look
+// like coin=policy, for example regtest=3 or btc=6:100000000:12. Every coin has to be one the
+// exchange supports.
+func generateConfirmationPolicies(conf *opencxConfig, coinList []*coinparam.Params) (policies map[*coinparam.Params]*match.ConfirmationPolicy, err error) {
+	policies = make(map[*coinparam.Params]*match.ConfirmationPolicy)
+	for _, policyStr := range conf.Confirmations {
+		parts := strings.SplitN(policyStr, "=", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("Confirmation policy %s should look like coin=policy", policyStr)
+			return
+		}
+
+		var coin *coinparam.Params
+		if coin, err = util.GetParamFromName(parts[0]); err != nil {
+			err = fmt.Errorf("Error getting coin for confirmation policy %s: %s", policyStr, err)
+			return
+		}
+		supported := false
+		for _, supportedCoin := range coinList {
+			supported = supported || supportedCoin == coin
+		}
+		if !supported {
+			err = fmt.Errorf("Confirmation policy for %s, which the exchange isn't connected to", coin.Name)
+			return
+		}
+
+		if policies[coin], err = match.ParseConfirmationPolicy(parts[1]); err != nil {
+			err = fmt.Errorf("Error parsing confirmation policy for %s: %s", coin.Name, err)
+			return
+		}
+	}
+	return
+}
+
+// generateWithdrawalPolicies parses the withdrawal limits in the configuration, which look like
+// coin=policy, for example regtest=100000000 or btc=100000000:10000000.
+func generateWithdrawalPolicies(conf *opencxConfig, coinList []*coinparam.Params) (policies map[*coinparam.Params]*match.WithdrawalPolicy, err error) {
+	policies = make(map[*coinparam.Params]*match.WithdrawalPolicy)
+	for _, policyStr := range conf.WithdrawalLimits {
+		parts := strings.SplitN(policyStr, "=", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("Withdrawal limit %s should look like coin=policy", policyStr)
+			return
+		}
+
+		var coin *coinparam.Params
+		if coin, err = util.GetParamFromName(parts[0]); err != nil {
+			err = fmt.Errorf("Error getting coin for withdrawal limit %s: %s", policyStr, err)
+			return
+		}
+		supported := false
+		for _, supportedCoin := range coinList {
+			supported = supported || supportedCoin == coin
+		}
+		if !supported {
+			err = fmt.Errorf("Withdrawal limit for %s, which the exchange isn't connected to", coin.Name)
+			return
+		}
+
+		if policies[coin], err = match.ParseWithdrawalPolicy(parts[1]); err != nil {
+			err = fmt.Errorf("Error parsing withdrawal limit for %s: %s", coin.Name, err)
+			return
+		}
+	}
+	return
+}
+
+// generateColdWalletPolicies parses the cold wallets in the configuration, which look like
+// coin=hotceiling:destination, for example regtest=100000000:tpubD6Nz...
+func generateColdWalletPolicies(conf *opencxConfig, coinList []*coinparam.Params) (policies map[*coinparam.Params]*match.ColdWalletPolicy, err error) {
+	policies = make(map[*coinparam.Params]*match.ColdWalletPolicy)
+	for _, policyStr := range conf.ColdWallets {
+		parts := strings.SplitN(policyStr, "=", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("Cold wallet %s should look like coin=hotceiling:destination", policyStr)
+			return
+		}
+
+		var coin *coinparam.Params
+		if coin, err = util.GetParamFromName(parts[0]); err != nil {
+			err = fmt.Errorf("Error getting coin for cold wallet %s: %s", policyStr, err)
+			return
+		}
+		supported := false
+		for _, supportedCoin := range coinList {
+			supported = supported || supportedCoin == coin
+		}
+		if !supported {
+			err = fmt.Errorf("Cold wallet for %s, which the exchange isn't connected to", coin.Name)
+			return
+		}
+
+		if policies[coin], err = match.ParseColdWalletPolicy(parts[1]); err != nil {
+			err = fmt.Errorf("Error parsing cold wallet for %s: %s", coin.Name, err)
+			return
+		}
+	}
+	return
+}
+
+// parseAdminPubkey parses the hex compressed pubkey that's allowed to run admin commands
+func parseAdminPubkey(pubkeyHex string) (pubkey *koblitz.PublicKey, err error) {
+	var pubkeyBytes []byte
+	if pubkeyBytes, err = hex.DecodeString(pubkeyHex); err != nil {
+		err = fmt.Errorf("Error decoding admin pubkey hex: %s", err)
+		return
+	}
+
+	if pubkey, err = koblitz.ParsePubKey(pubkeyBytes, koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing admin pubkey: %s", err)
+		return
+	}
+	return
+}
+
+// closeWALStore calls DestroyHandler on a memory store, which takes a final snapshot and closes
+// its write-ahead log
+func closeWALStore(store interface{}) {
+	if destroyer, ok := store.(interface{ DestroyHandler() error }); ok {
+		if err := destroyer.DestroyHandler(); err != nil {
+			logging.Errorf("Error closing write-ahead log: %s", err)
+		}
+	}
 	return
 }
@@ -6,12 +6,29 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/opencx/benchclient"
 	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
 )
 
 var client benchclient.BenchClient
 
+// orderbookRow is an order in the orderbook, with its price and amount as decimals
+type orderbookRow struct {
+	Side string `json:"side"`
+	// Price is in the quote asset for a whole unit of the base asset
+	Price string `json:"price"`
+	// Amount is what the order gives up
+	Amount string `json:"amount"`
+}
+
+// depositRow is a deposit with its amount as a decimal
+type depositRow struct {
+	*match.DepositStatus
+	FormattedAmount string `json:"formattedamount"`
+}
+
 func orderbookHandler(w http.ResponseWriter, r *http.Request) {
 	pair := r.URL.Query().Get("pair")
 	if pair == "" {
@@ -23,8 +40,20 @@ func orderbookHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	rows := []orderbookRow{}
+	for floatPrice, orders := range reply.Orderbook {
+		for _, order := range orders {
+			row := orderbookRow{Side: order.Order.Side.String(), Price: fmt.Sprintf("%f", floatPrice)}
+			if price, err := order.Order.QuotePrice(); err == nil {
+				row.Price = order.Order.TradingPair.AssetHave.FormatAmount(price)
+			}
+			haveAsset, _ := order.Order.TradingPair.HaveWantAssets(order.Order.Side)
+			row.Amount = haveAsset.FormatAmount(order.Order.AmountHave)
+			rows = append(rows, row)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(reply.Orderbook)
+	json.NewEncoder(w).Encode(rows)
 }
 
 func pairsHandler(w http.ResponseWriter, r *http.Request) {
@@ -52,23 +81,68 @@ func priceHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(reply.Price)
 }
 
+// depositsHandler lists the deposits of the key the web UI was started with. Deposits are private,
+// so the request is signed with that key, and there's nothing to show without one.
+func depositsHandler(w http.ResponseWriter, r *http.Request) {
+	if client.PrivKey == nil {
+		http.Error(w, "web UI was started without a key file, restart it with -keyfile to see deposits", http.StatusBadRequest)
+		return
+	}
+	asset := r.URL.Query().Get("asset")
+	if asset == "" {
+		http.Error(w, "missing asset", http.StatusBadRequest)
+		return
+	}
+	reply, err := client.GetDeposits(asset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows := []depositRow{}
+	for _, deposit := range reply.Deposits {
+		row := depositRow{DepositStatus: deposit, FormattedAmount: fmt.Sprintf("%d", deposit.Amount)}
+		if parsedAsset, err := match.AssetFromString(asset); err == nil {
+			row.FormattedAmount = parsedAsset.FormatAmount(deposit.Amount)
+		}
+		rows = append(rows, row)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
+
 func main() {
 	var rpchost string
 	var rpcport uint
 	var webport uint
+	var keyfile string
 
 	flag.StringVar(&rpchost, "rpchost", "localhost", "RPC server host")
 	flag.UintVar(&rpcport, "rpcport", 12345, "RPC server port")
 	flag.UintVar(&webport, "webport", 8080, "web interface port")
+	flag.StringVar(&keyfile, "keyfile", "", "ocx key file to sign private requests like deposits with")
 	flag.Parse()
 
+	if keyfile != "" {
+		seed, err := lnutil.ReadKeyFile(keyfile)
+		if err != nil {
+			logging.Fatalf("Error reading key file: %v", err)
+		}
+		if err = client.SetKeyFromSeed(seed); err != nil {
+			logging.Fatalf("Error deriving key from key file: %v", err)
+		}
+	}
+
 	if err := client.SetupBenchClient(rpchost, uint16(rpcport)); err != nil {
 		logging.Fatalf("Error setting up RPC client: %v", err)
 	}
+	if err := client.SyncAssets(); err != nil {
+		logging.Warnf("Could not get the exchange's assets, amounts may be off: %v", err)
+	}
 
 	http.HandleFunc("/api/orderbook", orderbookHandler)
 	http.HandleFunc("/api/pairs", pairsHandler)
 	http.HandleFunc("/api/price", priceHandler)
+	http.HandleFunc("/api/deposits", depositsHandler)
 	http.Handle("/", http.FileServer(http.Dir("cmd/webui/static")))
 
 	addr := fmt.Sprintf(":%d", webport)
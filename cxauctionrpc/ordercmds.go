@@ -11,11 +11,15 @@ import (
 type SubmitPuzzledOrderArgs struct {
 	// Use the serialize method on match.EncryptedAuctionOrder
 	EncryptedOrderBytes []byte
+	// Signature is a compact signature of the SigHash of the order's solution form, so the order
+	// can be put in the auction's transcript
+	Signature []byte
 }
 
 // SubmitPuzzledOrderReply holds the reply for the submitpuzzledorder command
 type SubmitPuzzledOrderReply struct {
-	// empty
+	// Receipt can be used to get the status of the order or cancel it
+	Receipt match.AuctionReceipt
 }
 
 // SubmitPuzzledOrder submits an order to the order book or throws an error
@@ -29,10 +33,94 @@ func (cl *OpencxAuctionRPC) SubmitPuzzledOrder(args SubmitPuzzledOrderArgs, repl
 		return
 	}
 
-	if err = cl.Server.PlacePuzzledOrder(order); err != nil {
+	if len(args.Signature) == 0 {
+		err = fmt.Errorf("Puzzled orders must be signed so they can be put in the auction transcript")
+		return
+	}
+
+	if err = cl.Server.PlaceSignedPuzzledOrder(order, args.Signature); err != nil {
 		err = fmt.Errorf("Error placing order while submitting order: \n%s", err)
 		return
 	}
 
+	var receiptID [32]byte
+	if receiptID, err = order.ReceiptID(); err != nil {
+		err = fmt.Errorf("Error getting receipt ID while submitting order: %s", err)
+		return
+	}
+
+	var status *match.AuctionOrderStatus
+	if status, err = cl.Server.GetAuctionOrderStatus(receiptID); err != nil {
+		err = fmt.Errorf("Error getting receipt while submitting order: %s", err)
+		return
+	}
+	reply.Receipt = status.Receipt
+
+	return
+}
+
+// GetAuctionOrderStatusArgs holds the args for the getauctionorderstatus command
+type GetAuctionOrderStatusArgs struct {
+	ReceiptID [32]byte
+}
+
+// GetAuctionOrderStatusReply holds the reply for the getauctionorderstatus command
+type GetAuctionOrderStatusReply struct {
+	Status *match.AuctionOrderStatus
+}
+
+// GetAuctionOrderStatus gets the status of an auction order from its receipt ID
+func (cl *OpencxAuctionRPC) GetAuctionOrderStatus(args GetAuctionOrderStatusArgs, reply *GetAuctionOrderStatusReply) (err error) {
+	if reply.Status, err = cl.Server.GetAuctionOrderStatus(args.ReceiptID); err != nil {
+		err = fmt.Errorf("Error getting status for GetAuctionOrderStatus RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// CancelAuctionOrderArgs holds the args for the cancelauctionorder command
+type CancelAuctionOrderArgs struct {
+	ReceiptID [32]byte
+	// Signature is a compact signature of match.AuctionCancelSigHash for the receipt ID, by the
+	// pubkey in the order
+	Signature []byte
+}
+
+// CancelAuctionOrderReply holds the reply for the cancelauctionorder command
+type CancelAuctionOrderReply struct {
+	// empty
+}
+
+// CancelAuctionOrder cancels an auction order before its auction ends. The order is only
+// cancelled if the signature is by the pubkey in the order, which can't be checked until the
+// order's puzzle is solved.
+func (cl *OpencxAuctionRPC) CancelAuctionOrder(args CancelAuctionOrderArgs, reply *CancelAuctionOrderReply) (err error) {
+	if err = cl.Server.CancelAuctionOrder(args.ReceiptID, args.Signature); err != nil {
+		err = fmt.Errorf("Error cancelling order for CancelAuctionOrder RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetAuctionResultArgs holds the args for the getauctionresult command
+type GetAuctionResultArgs struct {
+	AuctionID [32]byte
+}
+
+// GetAuctionResultReply holds the reply for the getauctionresult command
+type GetAuctionResultReply struct {
+	Result *match.AuctionResult
+}
+
+// GetAuctionResult gets the clearing price, fills, rejections, and cancellations for an auction
+// that has ended
+func (cl *OpencxAuctionRPC) GetAuctionResult(args GetAuctionResultArgs, reply *GetAuctionResultReply) (err error) {
+	if reply.Result, err = cl.Server.GetAuctionResult(args.AuctionID); err != nil {
+		err = fmt.Errorf("Error getting result for GetAuctionResult RPC command: %s", err)
+		return
+	}
+
 	return
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/match"
 )
 
@@ -20,6 +21,9 @@ type GetPublicParametersReply struct {
 	// for extra time.
 	AuctionTime uint64
 	StartTime   time.Time
+	// ExchangePubkey is the compressed pubkey that auction transcripts are signed with, empty if
+	// the exchange isn't signing transcripts
+	ExchangePubkey [33]byte
 }
 
 // GetPublicParameters gets public parameters from the exchange, like time and auctionID
@@ -34,5 +38,13 @@ func (cl *OpencxAuctionRPC) GetPublicParameters(args GetPublicParametersArgs, re
 		return
 	}
 
+	// the pubkey is left empty if the exchange isn't signing transcripts
+	var exchangePubkey *koblitz.PublicKey
+	if exchangePubkey, err = cl.Server.ExchangePubkey(); err != nil {
+		err = nil
+		return
+	}
+	copy(reply.ExchangePubkey[:], exchangePubkey.SerializeCompressed())
+
 	return
 }
@@ -0,0 +1,65 @@
+package cxauctionrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// GetTranscriptArgs holds the args for the gettranscript command
+type GetTranscriptArgs struct {
+	Pair      match.Pair
+	AuctionID [32]byte
+}
+
+// GetTranscriptReply holds the reply for the gettranscript command
+type GetTranscriptReply struct {
+	// Use the deserialize method on match.Transcript
+	TranscriptBytes []byte
+}
+
+// GetTranscript gets the signed transcript for an auction, so anyone can check that the exchange
+// committed to the orders before it could have solved them, and that the solutions are right
+func (cl *OpencxAuctionRPC) GetTranscript(args GetTranscriptArgs, reply *GetTranscriptReply) (err error) {
+	var transcript *match.Transcript
+	if transcript, err = cl.Server.GetTranscript(&args.Pair, args.AuctionID); err != nil {
+		err = fmt.Errorf("Error getting transcript for GetTranscript RPC command: %s", err)
+		return
+	}
+
+	if reply.TranscriptBytes, err = transcript.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing transcript for GetTranscript RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// SubmitCommitResponseArgs holds the args for the submitcommitresponse command
+type SubmitCommitResponseArgs struct {
+	AuctionID [32]byte
+	// Use the serialize method on match.CommitResponse
+	ResponseBytes []byte
+}
+
+// SubmitCommitResponseReply holds the reply for the submitcommitresponse command
+type SubmitCommitResponseReply struct {
+	// empty
+}
+
+// SubmitCommitResponse responds to the exchange's commitment for an auction by revealing the
+// factors of the user's puzzle
+func (cl *OpencxAuctionRPC) SubmitCommitResponse(args SubmitCommitResponseArgs, reply *SubmitCommitResponseReply) (err error) {
+	var response match.CommitResponse
+	if err = response.Deserialize(args.ResponseBytes); err != nil {
+		err = fmt.Errorf("Error deserializing response for SubmitCommitResponse RPC command: %s", err)
+		return
+	}
+
+	if err = cl.Server.SubmitCommitResponse(args.AuctionID, response); err != nil {
+		err = fmt.Errorf("Error submitting response for SubmitCommitResponse RPC command: %s", err)
+		return
+	}
+
+	return
+}
@@ -7,9 +7,11 @@ import (
 	"time"
 
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxdb"
 	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
 	"github.com/mit-dci/opencx/cxdb/cxdbsql"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 	"golang.org/x/text/number"
@@ -26,6 +28,18 @@ type OpencxAuctionServer struct {
 	orderChannel      chan *match.OrderPuzzleResult
 	orderChanMap      map[[32]byte]chan *match.OrderPuzzleResult
 
+	// EventLog records every auction order and auction tick, it's nil if events aren't being recorded
+	EventLog *cxevent.EventLog
+
+	// exchangeKey signs auction transcripts, and no transcripts are made if it's nil.
+	// openTranscripts are the committed transcripts for auctions whose batches haven't been
+	// placed yet. They're stored whenever they change, and until an auction is committed to, its
+	// transcript is made from the puzzles and signatures in the puzzle store. If the server
+	// restarts before the batch, the stored transcript is opened again when the batch is placed.
+	// Both are protected by dbLock.
+	exchangeKey     *koblitz.PrivateKey
+	openTranscripts map[match.AuctionID]*openTranscript
+
 	// auction params -- we'll store them in here for now
 	t uint64
 
@@ -138,6 +152,7 @@ func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEn
 		dbLock:            new(sync.Mutex),
 		orderChannel:      make(chan *match.OrderPuzzleResult, orderChanSize),
 		orderChanMap:      make(map[[32]byte]chan *match.OrderPuzzleResult),
+		openTranscripts:   make(map[match.AuctionID]*openTranscript),
 		t:                 standardAuctionTime,
 		clockOffButton:    make(chan bool, 1),
 	}
@@ -188,6 +203,15 @@ func (s *OpencxAuctionServer) EndAuctionWithID(pair *match.Pair, auctionID [32]b
 		return
 	}
 
+	// nothing else can be added to the auction, so commit to the orders in it
+	s.dbLock.Lock()
+	if err = s.commitTranscript(pair, match.AuctionID(auctionID)); err != nil {
+		err = fmt.Errorf("Error committing transcript for EndAuctionWithID: %s", err)
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
 	result = <-batchResultChan
 	logging.Infof("Results for auction %x retrieved", auctionID)
 	// get the batcher
@@ -0,0 +1,62 @@
+package cxauctionserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/cxevent"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetEventLog makes the server record every auction order and auction tick in an event log, so
+// the auction engines can be replayed offline. dbLock should not be held.
+func (s *OpencxAuctionServer) SetEventLog(eventLog *cxevent.EventLog) {
+	s.dbLock.Lock()
+	s.EventLog = eventLog
+	s.dbLock.Unlock()
+	return
+}
+
+// newEvent creates and sequences an event if there's an event log, otherwise it returns nil.
+// dbLock should be held so events are sequenced in the order the engines see them.
+func (s *OpencxAuctionServer) newEvent(eventType cxevent.EventType, pair *match.Pair, auctionID *match.AuctionID) (event *cxevent.Event) {
+	if s.EventLog == nil {
+		return
+	}
+	event = &cxevent.Event{
+		Type:      eventType,
+		Pair:      new(match.Pair),
+		AuctionID: new(match.AuctionID),
+	}
+	*event.Pair = *pair
+	*event.AuctionID = *auctionID
+	s.EventLog.Sequence(event)
+	return
+}
+
+// appendEvent writes an event to the event log before the engines see it, so the log has every
+// change the engines make. If it can't be written the engines shouldn't be changed, so the error
+// is returned. A nil event means there's no event log.
+func (s *OpencxAuctionServer) appendEvent(event *cxevent.Event) (err error) {
+	if event == nil {
+		return
+	}
+	if err = s.EventLog.Append(event); err != nil {
+		err = fmt.Errorf("Error appending %s to event log: %s", event, err)
+		return
+	}
+	return
+}
+
+// recordEvent writes what the engines did with an appended event to the event log. A nil event
+// means there's no event log. The event itself is already in the log, so failing to record what
+// the engines did only loses the result, and it's logged rather than returned.
+func (s *OpencxAuctionServer) recordEvent(event *cxevent.Event, orderExecs []*match.OrderExecution, setExecs []*match.SettlementExecution) {
+	if event == nil {
+		return
+	}
+	if err := s.EventLog.Record(event, orderExecs, setExecs); err != nil {
+		logging.Errorf("Error recording %s: %s", event, err)
+	}
+	return
+}
@@ -3,6 +3,7 @@ package cxauctionserver
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/btcsuite/golangcrypto/sha3"
 	"github.com/mit-dci/lit/coinparam"
@@ -10,16 +11,51 @@ import (
 	"github.com/mit-dci/opencx/crypto/rsw"
 	"github.com/mit-dci/opencx/crypto/timelockencoders"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
 
 // PlacePuzzledOrder places a timelock encrypted order. It also starts to decrypt the order in a goroutine.
+// The order gets a receipt which can be used to get its status or cancel it. Orders placed this way
+// aren't signed, so they can't be put in a transcript, and are only taken if the server isn't
+// making transcripts.
 func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAuctionOrder, errChan chan error) {
-	var err error
-	defer func() {
-		errChan <- err
-	}()
+	errChan <- s.placePuzzledOrder(order, nil)
+	return
+}
+
+// PlaceSignedPuzzledOrder places a timelock encrypted order along with the user's signature on
+// it, so the order can be put in the auction's transcript. The signature is a compact signature of
+// the SigHash of the order's solution form.
+func (s *OpencxAuctionServer) PlaceSignedPuzzledOrder(order *match.EncryptedAuctionOrder, signature []byte) (err error) {
+	if order == nil {
+		err = fmt.Errorf("Cannot place nil order, invalid")
+		return
+	}
+
+	signedOrder := &match.SignedEncSolOrder{
+		Signature: signature,
+	}
+	if signedOrder.EncSolOrder, err = order.SolutionForm(); err != nil {
+		err = fmt.Errorf("Error getting solution form of order for PlaceSignedPuzzledOrder: %s", err)
+		return
+	}
+
+	if _, err = signedOrder.Signer(); err != nil {
+		err = fmt.Errorf("Invalid signature on puzzled order: %s", err)
+		return
+	}
+
+	if err = s.placePuzzledOrder(order, signedOrder); err != nil {
+		return
+	}
+	return
+}
+
+// placePuzzledOrder validates an encrypted order, adds it to its auction and gives it a receipt.
+// If the order is signed it's also added to the auction's transcript.
+func (s *OpencxAuctionServer) placePuzzledOrder(order *match.EncryptedAuctionOrder, signedOrder *match.SignedEncSolOrder) (err error) {
 	if order == nil {
 		err = fmt.Errorf("Cannot place nil order, invalid")
 		return
@@ -32,9 +68,22 @@ func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAucti
 		return
 	}
 
+	var receipt *match.AuctionReceipt
+	if receipt, err = match.NewAuctionReceipt(order, time.Now()); err != nil {
+		err = fmt.Errorf("Error creating receipt for order: %s", err)
+		return
+	}
+
 	// Placing an auction puzzle is how the exchange will then recall and commit to a set of puzzles.
 	s.dbLock.Lock()
 
+	// every order in an auction has to be in its transcript, so they all have to be signed
+	if s.exchangeKey != nil && signedOrder == nil {
+		err = fmt.Errorf("Auctions have transcripts, so puzzled orders have to be signed")
+		s.dbLock.Unlock()
+		return
+	}
+
 	// get the puzzle engine we'll use
 	var pzEngine cxdb.PuzzleStore
 	var ok bool
@@ -44,6 +93,18 @@ func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAucti
 		return
 	}
 
+	var submitted *match.AuctionOrderStatus
+	if submitted, err = pzEngine.ViewAuctionOrderStatus(receipt.ID); err != nil {
+		err = fmt.Errorf("Error checking for order with receipt %x: %s", receipt.ID, err)
+		s.dbLock.Unlock()
+		return
+	}
+	if submitted != nil {
+		err = fmt.Errorf("Order with receipt %x has already been submitted", receipt.ID)
+		s.dbLock.Unlock()
+		return
+	}
+
 	if err = pzEngine.PlaceAuctionPuzzle(order); err != nil {
 		err = fmt.Errorf("Error placing puzzled order: \n%s", err)
 		s.dbLock.Unlock()
@@ -64,6 +125,22 @@ func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAucti
 		return
 	}
 
+	// the signature is stored with the status, so the transcript can be made from the store when
+	// the auction ends
+	status := &match.AuctionOrderStatus{
+		Receipt: *receipt,
+		State:   match.AuctionOrderSubmitted,
+		Updated: receipt.Received,
+	}
+	if signedOrder != nil {
+		status.Signature = signedOrder.Signature
+	}
+	if err = pzEngine.PutAuctionOrderStatus(status); err != nil {
+		err = fmt.Errorf("Error storing status for order with receipt %x: %s", receipt.ID, err)
+		s.dbLock.Unlock()
+		return
+	}
+
 	s.dbLock.Unlock()
 
 	return
@@ -142,8 +219,16 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 		return
 	}
 
+	// Nothing else can be added to the auction, so commit to the orders in its transcript before
+	// any of them could be solved
+	if err = s.commitTranscript(pair, *matchAuctionID); err != nil {
+		err = fmt.Errorf("Error committing transcript for CommitOrdersNewAuction: %s", err)
+		s.dbLock.Unlock()
+		return
+	}
+
 	// Make this boi wait for the batch to come in
-	go s.asyncBatchPlacer(commitOrderChannel)
+	go s.asyncBatchPlacer(*pair, commitOrderChannel)
 
 	// Then get the puzzles
 	var puzzles []*match.EncryptedAuctionOrder
@@ -197,7 +282,7 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 }
 
 // asyncBatchPlacer waits for a batch and places it. This should be done in a goroutine
-func (s *OpencxAuctionServer) asyncBatchPlacer(batchChan chan *match.AuctionBatch) {
+func (s *OpencxAuctionServer) asyncBatchPlacer(pair match.Pair, batchChan chan *match.AuctionBatch) {
 	var err error
 
 	defer func() {
@@ -212,87 +297,215 @@ func (s *OpencxAuctionServer) asyncBatchPlacer(batchChan chan *match.AuctionBatc
 
 	s.dbLock.Lock()
 
-	var batchRes *match.BatchResult
-	batchRes = s.validateBatch(batch)
-
-	logging.Infof("Got a batch result for %x! \n\tValid orders: %d\n\tInvalid orders: %d", batchRes.OriginalBatch, len(batchRes.AcceptedResults), len(batchRes.RejectedResults))
-
-	for _, acceptedOrder := range batchRes.AcceptedResults {
-		if acceptedOrder.Err != nil {
-			err = fmt.Errorf("Accepted order has a non-nil error: %s", acceptedOrder.Err)
-			s.dbLock.Unlock()
-			return
-		}
-
-		// TODO work out auction placing
-		// if err = s.OpencxDB.PlaceAuctionOrder(acceptedOrder.Auction); err != nil {
-		// 	err = fmt.Errorf("Error placing auction order with async batch placer: %s", err)
-		// 	return
-		// }
+	if err = s.placeBatch(&pair, batch); err != nil {
+		err = fmt.Errorf("Error placing batch with async batch placer: %s", err)
+		s.dbLock.Unlock()
+		return
 	}
 
 	s.dbLock.Unlock()
 	return
 }
 
+// PlaceBatch places and matches the valid orders in a batch, and records the result of the
+// batch's auction
 func (s *OpencxAuctionServer) PlaceBatch(batch *match.AuctionBatch) (err error) {
+	if batch == nil {
+		err = fmt.Errorf("Cannot place nil batch, invalid")
+		return
+	}
+
+	// the pair is only known from the orders, so there's nothing to do for an empty batch
+	if len(batch.Batch) == 0 || batch.Batch[0].Encrypted == nil {
+		return
+	}
+	pair := batch.Batch[0].Encrypted.IntendedPair
 
 	s.dbLock.Lock()
 
+	if err = s.placeBatch(&pair, batch); err != nil {
+		s.dbLock.Unlock()
+		return
+	}
+
+	s.dbLock.Unlock()
+	return
+}
+
+// placeBatch validates a batch for a pair, places the orders that are valid and weren't
+// cancelled, matches them, and records the status of every order and the result of the auction.
+// dbLock should be held.
+func (s *OpencxAuctionServer) placeBatch(pair *match.Pair, batch *match.AuctionBatch) (err error) {
+
 	var auctionEngine match.AuctionEngine
 	var ok bool
+	if auctionEngine, ok = s.MatchingEngines[*pair]; !ok {
+		err = fmt.Errorf("Could not find matching engine for pair %s", pair.String())
+		return
+	}
+
+	var auctionID *match.AuctionID = new(match.AuctionID)
+	if err = auctionID.UnmarshalBinary(batch.AuctionID[:]); err != nil {
+		err = fmt.Errorf("Error unmarshalling auction ID: %s", err)
+		return
+	}
 
 	var batchRes *match.BatchResult = s.validateBatch(batch)
 
-	logging.Infof("Got a batch result for %x! \n\tValid orders: %d\n\tInvalid orders: %d", batchRes.OriginalBatch, len(batchRes.AcceptedResults), len(batchRes.RejectedResults))
+	logging.Infof("Got a batch result for %x! \n\tValid orders: %d\n\tInvalid orders: %d\n\tCancelled orders: %d", batch.AuctionID, len(batchRes.AcceptedResults), len(batchRes.RejectedResults), len(batchRes.CancelledResults))
+
+	result := &match.AuctionResult{
+		AuctionID:  *auctionID,
+		Pair:       *pair,
+		Fills:      []*match.OrderExecution{},
+		Rejections: []*match.AuctionRejection{},
+		Cancelled:  [][32]byte{},
+	}
+
+	var status *match.AuctionOrderStatus
+	for _, rejectedOrder := range batchRes.RejectedResults {
+		if status, err = s.updateAuctionOrder(rejectedOrder, match.AuctionOrderRejected, rejectedOrder.Err.Error()); err != nil {
+			err = fmt.Errorf("Error rejecting auction order: %s", err)
+			return
+		}
+		result.Rejections = append(result.Rejections, &match.AuctionRejection{
+			ReceiptID: status.Receipt.ID,
+			Reason:    status.Reason,
+		})
+	}
+
+	for _, cancelledOrder := range batchRes.CancelledResults {
+		if status, err = s.updateAuctionOrder(cancelledOrder, match.AuctionOrderCancelled, ""); err != nil {
+			err = fmt.Errorf("Error cancelling auction order: %s", err)
+			return
+		}
+		result.Cancelled = append(result.Cancelled, status.Receipt.ID)
+		logging.Infof("Cancelled auction order %x", status.Receipt.ID)
+	}
 
-	var auctionIDList map[match.AuctionID]bool = make(map[match.AuctionID]bool)
+	// The clearing price is calculated from the book the orders are placed into, before matching
+	// changes the orders in it
+	var book map[float64][]*match.AuctionOrderIDPair = make(map[float64][]*match.AuctionOrderIDPair)
+	var placed map[match.OrderID]*match.AuctionOrderStatus = make(map[match.OrderID]*match.AuctionOrderStatus)
 	for _, acceptedOrder := range batchRes.AcceptedResults {
 		if acceptedOrder.Err != nil {
 			err = fmt.Errorf("Accepted order has a non-nil error: %s", acceptedOrder.Err)
-			s.dbLock.Unlock()
 			return
 		}
 
-		if auctionEngine, ok = s.MatchingEngines[acceptedOrder.Auction.TradingPair]; !ok {
-			err = fmt.Errorf("Could not find matching engine for pair %s", acceptedOrder.Auction.TradingPair.String())
-			s.dbLock.Unlock()
+		event := s.newEvent(cxevent.AuctionOrderEvent, pair, auctionID)
+		if event != nil {
+			event.AuctionOrder = acceptedOrder.Auction.Serialize()
+		}
+		if err = s.appendEvent(event); err != nil {
+			err = fmt.Errorf("Error logging auction order with batch placer: %s", err)
 			return
 		}
 
-		var idStruct *match.AuctionID = new(match.AuctionID)
-		if err = idStruct.UnmarshalBinary(acceptedOrder.Auction.AuctionID[:]); err != nil {
-			err = fmt.Errorf("Error unmarshalling auction ID: %s", err)
-			s.dbLock.Unlock()
+		var placeRes *match.AuctionOrderIDPair
+		if placeRes, err = auctionEngine.PlaceAuctionOrder(acceptedOrder.Auction, auctionID); err != nil {
+			err = fmt.Errorf("Error placing auction order with batch placer: %s", err)
 			return
 		}
 
-		auctionIDList[*idStruct] = true
+		if event != nil {
+			event.OrderID = new(match.OrderID)
+			*event.OrderID = placeRes.OrderID
+			s.recordEvent(event, nil, nil)
+		}
 
-		var placeRes *match.AuctionOrderIDPair
-		if placeRes, err = auctionEngine.PlaceAuctionOrder(acceptedOrder.Auction, idStruct); err != nil {
-			err = fmt.Errorf("Error placing auction order with async batch placer: %s", err)
-			s.dbLock.Unlock()
+		if status, err = s.updateAuctionOrder(acceptedOrder, match.AuctionOrderPlaced, ""); err != nil {
+			err = fmt.Errorf("Error updating placed auction order: %s", err)
 			return
 		}
+		status.OrderID = placeRes.OrderID
+		placed[placeRes.OrderID] = status
 
-		logging.Infof("Placed order %x for auction %x", placeRes.OrderID[:], acceptedOrder.Auction.AuctionID)
+		bookOrder := *acceptedOrder.Auction
+		book[placeRes.Price] = append(book[placeRes.Price], &match.AuctionOrderIDPair{
+			OrderID: placeRes.OrderID,
+			Price:   placeRes.Price,
+			Order:   &bookOrder,
+		})
 
+		logging.Infof("Placed order %x for auction %x", placeRes.OrderID[:], acceptedOrder.Auction.AuctionID)
 	}
 
 	// Now we're going to match it
-	var currIDPtr *match.AuctionID
-	for id, _ := range auctionIDList {
-		// I don't want to reuse the `id` loop var pointer
-		currIDPtr = new(match.AuctionID)
-		*currIDPtr = id
-		// We ignore the Order executions because we're not doing anything about them yet
-		if _, _, err = auctionEngine.MatchAuctionOrders(currIDPtr); err != nil {
-			err = fmt.Errorf("Error matching orders for PlaceBatch: %s", err)
-			s.dbLock.Unlock()
+	if len(placed) > 0 {
+		var clearingPrice *match.Price
+		if clearingPrice, err = match.CalculateClearingPrice(book); err != nil {
+			err = fmt.Errorf("Error calculating clearing price for placeBatch: %s", err)
+			return
+		}
+		if result.ClearingPrice, err = clearingPrice.ToFloat(); err != nil {
+			// nothing crossed, so there's nothing to match
+			result.ClearingPrice = 0
+			err = nil
+		} else {
+			event := s.newEvent(cxevent.AuctionTickEvent, pair, auctionID)
+			if err = s.appendEvent(event); err != nil {
+				err = fmt.Errorf("Error logging auction tick for placeBatch: %s", err)
+				return
+			}
+			// The executions only go to the event log and the auction result because we're not doing anything else with them yet
+			var orderExecs []*match.OrderExecution
+			var setExecs []*match.SettlementExecution
+			if orderExecs, setExecs, err = auctionEngine.MatchAuctionOrders(auctionID); err != nil {
+				err = fmt.Errorf("Error matching orders for placeBatch: %s", err)
+				return
+			}
+			s.recordEvent(event, orderExecs, setExecs)
+
+			for _, orderExec := range orderExecs {
+				result.Fills = append(result.Fills, orderExec)
+				if status, ok = placed[orderExec.OrderID]; !ok {
+					continue
+				}
+				state := match.AuctionOrderPartiallyFilled
+				if orderExec.Filled {
+					state = match.AuctionOrderFilled
+				}
+				status.Execution = orderExec
+				status.SetState(state, "", time.Now())
+			}
+		}
+	}
+
+	var pzStore cxdb.PuzzleStore
+	if pzStore, ok = s.PuzzleEngines[*pair]; !ok {
+		err = fmt.Errorf("Could not find puzzle engine for pair %s", pair.String())
+		return
+	}
+
+	// placed orders got their order IDs and fills after they were stored
+	for _, status = range placed {
+		if err = pzStore.PutAuctionOrderStatus(status); err != nil {
+			err = fmt.Errorf("Error storing placed auction order for placeBatch: %s", err)
 			return
 		}
 	}
+
+	if len(batch.Batch) > 0 {
+		result.Placed = uint64(len(placed))
+		result.Ended = time.Now()
+		if err = pzStore.PutAuctionResult(result); err != nil {
+			err = fmt.Errorf("Error storing auction result for placeBatch: %s", err)
+			return
+		}
+		logging.Infof("Result for %s", result)
+	}
+
+	// old auctions aren't worth failing this one over
+	if pruneErr := pzStore.PruneAuctions(time.Now().Add(-auctionRetention)); pruneErr != nil {
+		logging.Errorf("Error pruning old auctions for %s: %s", pair.String(), pruneErr)
+	}
+
+	if err = s.finishTranscript(pair, *auctionID, batch); err != nil {
+		err = fmt.Errorf("Error finishing transcript for placeBatch: %s", err)
+		return
+	}
+
 	return
 }
 
@@ -314,14 +527,16 @@ func (s *OpencxAuctionServer) validateEncryptedOrder(order *match.EncryptedAucti
 	return
 }
 
-// validateBatch validates a batch of orders, sorting into accepted and rejected piles using validateOrder
+// validateBatch validates a batch of orders, sorting into accepted, rejected, and cancelled piles using validateOrder.
+// dbLock should be held.
 func (s *OpencxAuctionServer) validateBatch(auctionBatch *match.AuctionBatch) (batchResult *match.BatchResult) {
 	var err error
 
 	batchResult = &match.BatchResult{
-		OriginalBatch:   auctionBatch,
-		RejectedResults: []*match.OrderPuzzleResult{},
-		AcceptedResults: []*match.OrderPuzzleResult{},
+		OriginalBatch:    auctionBatch,
+		RejectedResults:  []*match.OrderPuzzleResult{},
+		AcceptedResults:  []*match.OrderPuzzleResult{},
+		CancelledResults: []*match.OrderPuzzleResult{},
 	}
 
 	for _, orderPzRes := range auctionBatch.Batch {
@@ -340,6 +555,8 @@ func (s *OpencxAuctionServer) validateBatch(auctionBatch *match.AuctionBatch) (b
 		if err = s.validateOrderResult(auctionBatch.AuctionID, orderPzRes); err != nil {
 			orderPzRes.Err = fmt.Errorf("Order invalid: %s", err)
 			batchResult.RejectedResults = append(batchResult.RejectedResults, orderPzRes)
+		} else if s.auctionOrderCancelled(orderPzRes) {
+			batchResult.CancelledResults = append(batchResult.CancelledResults, orderPzRes)
 		} else {
 			batchResult.AcceptedResults = append(batchResult.AcceptedResults, orderPzRes)
 		}
@@ -405,6 +622,11 @@ func (s *OpencxAuctionServer) validateOrderResult(claimedAuction [32]byte, resul
 		return
 	}
 
+	if result.Encrypted.IntendedPair != result.Auction.TradingPair {
+		err = fmt.Errorf("Pair for decrypted and encrypted order must be equal")
+		return
+	}
+
 	if !bytes.Equal(result.Encrypted.IntendedAuction[:], result.Auction.AuctionID[:]) {
 		err = fmt.Errorf("Auction ID for decrypted and encrypted order must be equal")
 		return
@@ -431,6 +653,12 @@ func (s *OpencxAuctionServer) runMatching(auctionID *match.AuctionID, pair *matc
 	}
 
 	// We can now calculate a clearing price and run the matching algorithm
+	event := s.newEvent(cxevent.AuctionTickEvent, pair, auctionID)
+	if err = s.appendEvent(event); err != nil {
+		err = fmt.Errorf("Error logging auction tick for runMatching: %s", err)
+		s.dbLock.Unlock()
+		return
+	}
 	var orderExecs []*match.OrderExecution
 	var setExecs []*match.SettlementExecution
 	if orderExecs, setExecs, err = matchEngine.MatchAuctionOrders(auctionID); err != nil {
@@ -438,6 +666,7 @@ func (s *OpencxAuctionServer) runMatching(auctionID *match.AuctionID, pair *matc
 		s.dbLock.Unlock()
 		return
 	}
+	s.recordEvent(event, orderExecs, setExecs)
 
 	var orderbook match.AuctionOrderbook
 	if orderbook, ok = s.Orderbooks[*pair]; !ok {
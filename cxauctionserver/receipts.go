@@ -0,0 +1,209 @@
+package cxauctionserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// auctionRetention is how long order statuses and auction results are kept once an auction is
+// over
+const auctionRetention = 7 * 24 * time.Hour
+
+// updateAuctionOrder moves the order for a puzzle result to a new state, stores it, and returns
+// its status. Orders that were never given a receipt get one here. dbLock should be held.
+func (s *OpencxAuctionServer) updateAuctionOrder(result *match.OrderPuzzleResult, state match.AuctionOrderState, reason string) (status *match.AuctionOrderStatus, err error) {
+	if result.Encrypted == nil {
+		err = fmt.Errorf("Can't update an auction order without its encrypted order")
+		return
+	}
+
+	var receiptID [32]byte
+	if receiptID, err = result.Encrypted.ReceiptID(); err != nil {
+		err = fmt.Errorf("Error getting receipt ID for updateAuctionOrder: %s", err)
+		return
+	}
+
+	var pzStore cxdb.PuzzleStore
+	var ok bool
+	if pzStore, ok = s.PuzzleEngines[result.Encrypted.IntendedPair]; !ok {
+		err = fmt.Errorf("Could not find puzzle engine for pair %s", result.Encrypted.IntendedPair.String())
+		return
+	}
+
+	if status, err = pzStore.ViewAuctionOrderStatus(receiptID); err != nil {
+		err = fmt.Errorf("Error getting status for updateAuctionOrder: %s", err)
+		return
+	}
+	if status == nil {
+		var receipt *match.AuctionReceipt
+		if receipt, err = match.NewAuctionReceipt(result.Encrypted, time.Now()); err != nil {
+			err = fmt.Errorf("Error creating receipt for updateAuctionOrder: %s", err)
+			return
+		}
+		status = &match.AuctionOrderStatus{Receipt: *receipt}
+	}
+
+	// the engine may change the order it's given when it's filled, so we keep our own
+	if result.Auction != nil {
+		order := *result.Auction
+		status.Order = &order
+	}
+	status.SetState(state, reason, time.Now())
+
+	// the order can't be cancelled any more
+	status.Cancels = nil
+
+	if err = pzStore.PutAuctionOrderStatus(status); err != nil {
+		err = fmt.Errorf("Error storing status for updateAuctionOrder: %s", err)
+		return
+	}
+	return
+}
+
+// auctionOrderCancelled returns whether the user that signed a solved order asked for it to be
+// cancelled before its auction ended. dbLock should be held.
+func (s *OpencxAuctionServer) auctionOrderCancelled(result *match.OrderPuzzleResult) (cancelled bool) {
+	receiptID, err := result.Encrypted.ReceiptID()
+	if err != nil {
+		return
+	}
+
+	pzStore, ok := s.PuzzleEngines[result.Encrypted.IntendedPair]
+	if !ok {
+		return
+	}
+	var status *match.AuctionOrderStatus
+	if status, err = pzStore.ViewAuctionOrderStatus(receiptID); err != nil || status == nil {
+		return
+	}
+
+	for _, pubkey := range status.Cancels {
+		if pubkey == result.Auction.Pubkey {
+			cancelled = true
+			return
+		}
+	}
+	return
+}
+
+// findAuctionOrderStatus looks for the status of the auction order with a receipt ID in every
+// puzzle store, and returns the store it's in. The status is nil if there's no order with that
+// receipt. dbLock should be held.
+func (s *OpencxAuctionServer) findAuctionOrderStatus(receiptID [32]byte) (pzStore cxdb.PuzzleStore, status *match.AuctionOrderStatus, err error) {
+	for _, pzStore = range s.PuzzleEngines {
+		if status, err = pzStore.ViewAuctionOrderStatus(receiptID); err != nil {
+			err = fmt.Errorf("Error getting status for findAuctionOrderStatus: %s", err)
+			return
+		}
+		if status != nil {
+			return
+		}
+	}
+	pzStore = nil
+	return
+}
+
+// CancelAuctionOrder cancels a submitted auction order before its auction ends. The order is
+// encrypted until then, so we can't tell whether the cancel was signed by the user that placed
+// the order yet. The order is cancelled when its puzzle is solved if the pubkeys match, otherwise
+// the cancel is ignored. Only match.MaxAuctionCancels different pubkeys can cancel an order.
+func (s *OpencxAuctionServer) CancelAuctionOrder(receiptID [32]byte, signature []byte) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = match.RecoverAuctionCancelPubkey(receiptID, signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	var pzStore cxdb.PuzzleStore
+	var status *match.AuctionOrderStatus
+	if pzStore, status, err = s.findAuctionOrderStatus(receiptID); err != nil {
+		err = fmt.Errorf("Error finding order for CancelAuctionOrder: %s", err)
+		return
+	}
+	if status == nil {
+		err = fmt.Errorf("No auction order with receipt %x", receiptID)
+		return
+	}
+	if status.State != match.AuctionOrderSubmitted {
+		err = fmt.Errorf("Can't cancel auction order %x, it's already %s", receiptID, status.State)
+		return
+	}
+
+	var batcher match.AuctionBatcher
+	var ok bool
+	if batcher, ok = s.OrderBatchers[status.Receipt.Pair]; !ok {
+		err = fmt.Errorf("Could not find batcher for pair %s", status.Receipt.Pair.String())
+		return
+	}
+	if _, ok = batcher.ActiveAuctions()[status.Receipt.AuctionID]; !ok {
+		err = fmt.Errorf("Can't cancel auction order %x, auction %x has already ended", receiptID, status.Receipt.AuctionID)
+		return
+	}
+
+	var cancelPubkey [33]byte
+	copy(cancelPubkey[:], pubkey.SerializeCompressed())
+	for _, pendingPubkey := range status.Cancels {
+		if pendingPubkey == cancelPubkey {
+			return
+		}
+	}
+	if len(status.Cancels) >= match.MaxAuctionCancels {
+		err = fmt.Errorf("Can't cancel auction order %x, it already has %d cancels", receiptID, len(status.Cancels))
+		return
+	}
+	status.Cancels = append(status.Cancels, cancelPubkey)
+
+	if err = pzStore.PutAuctionOrderStatus(status); err != nil {
+		err = fmt.Errorf("Error storing cancel for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	logging.Infof("Got a cancel for auction order %x by pubkey %x", receiptID, cancelPubkey)
+	return
+}
+
+// GetAuctionOrderStatus returns the status of the auction order with a receipt ID
+func (s *OpencxAuctionServer) GetAuctionOrderStatus(receiptID [32]byte) (status *match.AuctionOrderStatus, err error) {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	if _, status, err = s.findAuctionOrderStatus(receiptID); err != nil {
+		err = fmt.Errorf("Error finding order for GetAuctionOrderStatus: %s", err)
+		return
+	}
+	if status == nil {
+		err = fmt.Errorf("No auction order with receipt %x", receiptID)
+		return
+	}
+	// who asked for the order to be cancelled is only for us
+	status.Cancels = nil
+	return
+}
+
+// GetAuctionResult returns the result of an auction once it has ended and its orders have been
+// placed
+func (s *OpencxAuctionServer) GetAuctionResult(auctionID [32]byte) (result *match.AuctionResult, err error) {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	id := match.AuctionID(auctionID)
+	for _, pzStore := range s.PuzzleEngines {
+		if result, err = pzStore.ViewAuctionResult(&id); err != nil {
+			err = fmt.Errorf("Error getting result for GetAuctionResult: %s", err)
+			return
+		}
+		if result != nil {
+			return
+		}
+	}
+	err = fmt.Errorf("No result for auction %x, it hasn't ended or had no orders", auctionID)
+	return
+}
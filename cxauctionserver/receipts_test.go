@@ -0,0 +1,190 @@
+package cxauctionserver
+
+import (
+	"testing"
+
+	"github.com/btcsuite/golangcrypto/sha3"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// signedEncryptedOrder creates an encrypted order for the test auction that's signed by priv
+func signedEncryptedOrder(t *testing.T, priv *koblitz.PrivateKey, side match.Side, amountHave uint64, amountWant uint64) (encrypted *match.EncryptedAuctionOrder) {
+	order := &match.AuctionOrder{
+		Side:        side,
+		AuctionID:   testAuctionOrder.AuctionID,
+		TradingPair: testAuctionOrder.TradingPair,
+		AmountHave:  amountHave,
+		AmountWant:  amountWant,
+	}
+	copy(order.Pubkey[:], priv.PubKey().SerializeCompressed())
+
+	sha3 := sha3.New256()
+	sha3.Write(order.SerializeSignable())
+	var err error
+	if order.Signature, err = koblitz.SignCompact(koblitz.S256(), priv, sha3.Sum(nil), false); err != nil {
+		t.Fatalf("Error signing order: %s", err)
+	}
+
+	if encrypted, err = order.TurnIntoEncryptedOrder(testStandardAuctionTime); err != nil {
+		t.Fatalf("Error encrypting order: %s", err)
+	}
+	return
+}
+
+// cancelAuctionOrder signs a cancel for an order with priv and sends it to the server
+func cancelAuctionOrder(t *testing.T, s *OpencxAuctionServer, priv *koblitz.PrivateKey, receiptID [32]byte) (err error) {
+	var sig []byte
+	if sig, err = koblitz.SignCompact(koblitz.S256(), priv, match.AuctionCancelSigHash(receiptID), false); err != nil {
+		t.Fatalf("Error signing cancel: %s", err)
+	}
+	err = s.CancelAuctionOrder(receiptID, sig)
+	return
+}
+
+// TestAuctionOrderLifecycle tests that auction orders can be followed from their receipts to
+// being filled, rejected, or cancelled, and that the auction's result has all of them
+func TestAuctionOrderLifecycle(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Fatalf("Error initializing test server: %s", err)
+	}
+	pair := testAuctionOrder.TradingPair
+	auctionID := testAuctionOrder.AuctionID
+	if err = s.StartAuctionWithID(&pair, auctionID); err != nil {
+		t.Fatalf("Error starting auction: %s", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	otherPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{2})
+
+	buy := signedEncryptedOrder(t, priv, match.Buy, 10000, 10000)
+	sell := signedEncryptedOrder(t, priv, match.Sell, 10000, 20000)
+	cancelled := signedEncryptedOrder(t, priv, match.Buy, 5000, 5000)
+	// changing the order after it's signed makes the signature invalid
+	badOrder := *testAuctionOrder
+	badOrder.Nonce = incrementNonce(badOrder.Nonce)
+	var bad *match.EncryptedAuctionOrder
+	if bad, err = badOrder.TurnIntoEncryptedOrder(testStandardAuctionTime); err != nil {
+		t.Fatalf("Error encrypting bad order: %s", err)
+	}
+
+	receiptIDs := make(map[string][32]byte)
+	for name, order := range map[string]*match.EncryptedAuctionOrder{"buy": buy, "sell": sell, "cancelled": cancelled, "bad": bad} {
+		if err = s.PlacePuzzledOrder(order); err != nil {
+			t.Fatalf("Error placing %s order: %s", name, err)
+		}
+		var status *match.AuctionOrderStatus
+		if receiptIDs[name], err = order.ReceiptID(); err != nil {
+			t.Fatalf("Error getting receipt ID: %s", err)
+		}
+		if status, err = s.GetAuctionOrderStatus(receiptIDs[name]); err != nil || status.State != match.AuctionOrderSubmitted {
+			t.Fatalf("%s order should be submitted, got %v and %v", name, status, err)
+		}
+	}
+	if err = s.PlacePuzzledOrder(buy); err == nil {
+		t.Errorf("Submitting the same order twice should fail")
+	}
+
+	if err = cancelAuctionOrder(t, s, priv, receiptIDs["cancelled"]); err != nil {
+		t.Fatalf("Error cancelling order: %s", err)
+	}
+	// a cancel by someone else is ignored once the order is decrypted
+	if err = cancelAuctionOrder(t, s, otherPriv, receiptIDs["buy"]); err != nil {
+		t.Fatalf("Error sending cancel by another key: %s", err)
+	}
+
+	var batch *match.AuctionBatch
+	if batch, err = s.EndAuctionWithID(&pair, auctionID); err != nil {
+		t.Fatalf("Error ending auction: %s", err)
+	}
+	if err = s.PlaceBatch(batch); err != nil {
+		t.Fatalf("Error placing batch: %s", err)
+	}
+
+	if err = cancelAuctionOrder(t, s, priv, receiptIDs["buy"]); err == nil {
+		t.Errorf("Should not be able to cancel an order once its auction has ended")
+	}
+
+	for name, state := range map[string]match.AuctionOrderState{"buy": match.AuctionOrderFilled, "sell": match.AuctionOrderFilled, "cancelled": match.AuctionOrderCancelled, "bad": match.AuctionOrderRejected} {
+		var status *match.AuctionOrderStatus
+		if status, err = s.GetAuctionOrderStatus(receiptIDs[name]); err != nil {
+			t.Fatalf("Error getting status for %s order: %s", name, err)
+		}
+		if status.State != state {
+			t.Errorf("%s order should be %s, got %s", name, state, status)
+		}
+		if status.Order == nil {
+			t.Errorf("%s order should have been decrypted", name)
+		}
+	}
+
+	var result *match.AuctionResult
+	if result, err = s.GetAuctionResult(auctionID); err != nil {
+		t.Fatalf("Error getting auction result: %s", err)
+	}
+	if result.Placed != 2 || len(result.Fills) != 2 || result.ClearingPrice != 1.5 {
+		t.Errorf("Auction should have 2 orders filled at 1.5, got %s", result)
+	}
+	if len(result.Rejections) != 1 || result.Rejections[0].ReceiptID != receiptIDs["bad"] || result.Rejections[0].Reason == "" {
+		t.Errorf("Auction should have rejected the bad order with a reason, got %v", result.Rejections)
+	}
+	if len(result.Cancelled) != 1 || result.Cancelled[0] != receiptIDs["cancelled"] {
+		t.Errorf("Auction should have cancelled one order, got %x", result.Cancelled)
+	}
+
+	if _, err = s.GetAuctionResult([32]byte{1}); err == nil {
+		t.Errorf("Should not get a result for an auction that never ran")
+	}
+	return
+}
+
+// TestAuctionOrderCancelLimit tests that only match.MaxAuctionCancels different pubkeys can ask
+// for an order to be cancelled, and that a cancel isn't counted twice
+func TestAuctionOrderCancelLimit(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Fatalf("Error initializing test server: %s", err)
+	}
+	pair := testAuctionOrder.TradingPair
+	if err = s.StartAuctionWithID(&pair, testAuctionOrder.AuctionID); err != nil {
+		t.Fatalf("Error starting auction: %s", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	order := signedEncryptedOrder(t, priv, match.Buy, 10000, 10000)
+	if err = s.PlacePuzzledOrder(order); err != nil {
+		t.Fatalf("Error placing order: %s", err)
+	}
+	var receiptID [32]byte
+	if receiptID, err = order.ReceiptID(); err != nil {
+		t.Fatalf("Error getting receipt ID: %s", err)
+	}
+
+	for i := 0; i < match.MaxAuctionCancels; i++ {
+		otherPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{byte(i + 2)})
+		if err = cancelAuctionOrder(t, s, otherPriv, receiptID); err != nil {
+			t.Fatalf("Error sending cancel %d: %s", i, err)
+		}
+		// sending the same cancel again doesn't take up another spot
+		if err = cancelAuctionOrder(t, s, otherPriv, receiptID); err != nil {
+			t.Fatalf("Error sending cancel %d again: %s", i, err)
+		}
+	}
+	if err = cancelAuctionOrder(t, s, priv, receiptID); err == nil {
+		t.Errorf("Should not take more than %d cancels for an order", match.MaxAuctionCancels)
+	}
+
+	var status *match.AuctionOrderStatus
+	if status, err = s.GetAuctionOrderStatus(receiptID); err != nil {
+		t.Fatalf("Error getting status: %s", err)
+	}
+	if status.State != match.AuctionOrderSubmitted || len(status.Cancels) != 0 {
+		t.Errorf("Order should be submitted without showing who cancelled it, got %s with %d cancels", status, len(status.Cancels))
+	}
+	return
+}
@@ -0,0 +1,312 @@
+package cxauctionserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// openTranscript is the transcript for an auction whose batch hasn't been placed yet, along with
+// the receipt IDs of its puzzled orders, in the same order
+type openTranscript struct {
+	pair       match.Pair
+	transcript *match.Transcript
+	receiptIDs [][32]byte
+}
+
+// SetExchangeKey sets the key the server signs auction transcripts with. Once it's set, only
+// signed orders are taken. Orders placed before it was set aren't signed, so they're left out of
+// transcripts. dbLock should not be held.
+func (s *OpencxAuctionServer) SetExchangeKey(exchangeKey *koblitz.PrivateKey) {
+	s.dbLock.Lock()
+	s.exchangeKey = exchangeKey
+	s.dbLock.Unlock()
+	return
+}
+
+// ExchangePubkey returns the pubkey that auction transcripts are signed with
+func (s *OpencxAuctionServer) ExchangePubkey() (pubkey *koblitz.PublicKey, err error) {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+	if s.exchangeKey == nil {
+		err = fmt.Errorf("Server has no exchange key, so it isn't signing transcripts")
+		return
+	}
+	pubkey = s.exchangeKey.PubKey()
+	return
+}
+
+// commitTranscript makes the transcript for an auction from the puzzles placed in it and the
+// signatures stored with their statuses, signs the auction's batch ID, commits to the puzzled
+// orders, and stores the transcript. This should happen once the auction has ended, before any of
+// the puzzles could have been solved. Auctions with no signed orders have no transcript, and
+// auctions that were already committed to are left alone, unless their transcript was stored
+// without solutions, in which case it's opened again. dbLock should be held.
+func (s *OpencxAuctionServer) commitTranscript(pair *match.Pair, auctionID match.AuctionID) (err error) {
+	if s.exchangeKey == nil {
+		return
+	}
+	if _, ok := s.openTranscripts[auctionID]; ok {
+		return
+	}
+
+	var pzEngine cxdb.PuzzleStore
+	var ok bool
+	if pzEngine, ok = s.PuzzleEngines[*pair]; !ok {
+		err = fmt.Errorf("Could not find puzzle engine for pair %s", pair.String())
+		return
+	}
+	if stored, viewErr := pzEngine.ViewTranscript(&auctionID); viewErr == nil {
+		// a committed transcript without solutions was left open when the server stopped, so
+		// it's opened again to be finished
+		if len(stored.Solutions) == 0 {
+			if err = s.reopenTranscript(pzEngine, pair, stored); err != nil {
+				err = fmt.Errorf("Error reopening stored transcript for commitTranscript: %s", err)
+				return
+			}
+		}
+		return
+	}
+
+	var puzzles []*match.EncryptedAuctionOrder
+	if puzzles, err = pzEngine.ViewAuctionPuzzleBook(&auctionID); err != nil {
+		err = fmt.Errorf("Error getting puzzles for commitTranscript: %s", err)
+		return
+	}
+
+	open := &openTranscript{
+		pair:       *pair,
+		transcript: &match.Transcript{BatchId: auctionID},
+	}
+	seen := make(map[[32]byte]bool)
+	for _, puzzle := range puzzles {
+		if puzzle.IntendedAuction != auctionID {
+			continue
+		}
+		var receiptID [32]byte
+		if receiptID, err = puzzle.ReceiptID(); err != nil {
+			err = fmt.Errorf("Error getting receipt ID for commitTranscript: %s", err)
+			return
+		}
+		if seen[receiptID] {
+			continue
+		}
+		seen[receiptID] = true
+
+		// puzzles that didn't make it into the auction have no status
+		var status *match.AuctionOrderStatus
+		if status, err = pzEngine.ViewAuctionOrderStatus(receiptID); err != nil {
+			err = fmt.Errorf("Error getting status for commitTranscript: %s", err)
+			return
+		}
+		if status == nil {
+			continue
+		}
+		if len(status.Signature) == 0 {
+			logging.Warnf("Auction order %x isn't signed, so it's left out of the transcript for auction %x", receiptID, auctionID)
+			continue
+		}
+
+		signedOrder := match.SignedEncSolOrder{Signature: status.Signature}
+		if signedOrder.EncSolOrder, err = puzzle.SolutionForm(); err != nil {
+			err = fmt.Errorf("Error getting solution form for commitTranscript: %s", err)
+			return
+		}
+		open.transcript.PuzzledOrders = append(open.transcript.PuzzledOrders, signedOrder)
+		open.receiptIDs = append(open.receiptIDs, receiptID)
+	}
+	if len(open.transcript.PuzzledOrders) == 0 {
+		return
+	}
+
+	if err = open.transcript.SignBatchID(s.exchangeKey); err != nil {
+		err = fmt.Errorf("Error signing batch ID for commitTranscript: %s", err)
+		return
+	}
+
+	if err = open.transcript.Commit(s.exchangeKey); err != nil {
+		err = fmt.Errorf("Error committing to orders for commitTranscript: %s", err)
+		return
+	}
+
+	if err = s.putTranscript(open); err != nil {
+		err = fmt.Errorf("Error storing committed transcript for commitTranscript: %s", err)
+		return
+	}
+	s.openTranscripts[auctionID] = open
+
+	logging.Infof("Committed to %d orders for auction %x: %x", len(open.transcript.PuzzledOrders), auctionID, open.transcript.Commitment)
+	return
+}
+
+// reopenTranscript opens a transcript that was committed to and stored, but never finished. The
+// receipt IDs aren't stored with the transcript, so they're found by matching the puzzled orders
+// against the puzzles placed in the auction. dbLock should be held.
+func (s *OpencxAuctionServer) reopenTranscript(pzEngine cxdb.PuzzleStore, pair *match.Pair, transcript *match.Transcript) (err error) {
+	var puzzles []*match.EncryptedAuctionOrder
+	if puzzles, err = pzEngine.ViewAuctionPuzzleBook(&transcript.BatchId); err != nil {
+		err = fmt.Errorf("Error getting puzzles for reopenTranscript: %s", err)
+		return
+	}
+
+	receiptIDs := make(map[string][32]byte)
+	for _, puzzle := range puzzles {
+		var encSolOrder match.EncryptedSolutionOrder
+		if encSolOrder, err = puzzle.SolutionForm(); err != nil {
+			err = fmt.Errorf("Error getting solution form for reopenTranscript: %s", err)
+			return
+		}
+		var rawOrder []byte
+		if rawOrder, err = encSolOrder.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing solution form for reopenTranscript: %s", err)
+			return
+		}
+		if receiptIDs[string(rawOrder)], err = puzzle.ReceiptID(); err != nil {
+			err = fmt.Errorf("Error getting receipt ID for reopenTranscript: %s", err)
+			return
+		}
+	}
+
+	open := &openTranscript{
+		pair:       *pair,
+		transcript: transcript,
+	}
+	for _, signedOrder := range transcript.PuzzledOrders {
+		var rawOrder []byte
+		if rawOrder, err = signedOrder.EncSolOrder.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing puzzled order for reopenTranscript: %s", err)
+			return
+		}
+		receiptID, ok := receiptIDs[string(rawOrder)]
+		if !ok {
+			err = fmt.Errorf("Could not find the puzzle for a puzzled order in auction %x", transcript.BatchId)
+			return
+		}
+		open.receiptIDs = append(open.receiptIDs, receiptID)
+	}
+	s.openTranscripts[transcript.BatchId] = open
+
+	logging.Infof("Reopened transcript with %d orders for auction %x", len(open.transcript.PuzzledOrders), transcript.BatchId)
+	return
+}
+
+// finishTranscript adds the solutions from an auction's batch to its transcript and stores it.
+// The auction's transcript can't change after this. dbLock should be held.
+func (s *OpencxAuctionServer) finishTranscript(pair *match.Pair, auctionID match.AuctionID, batch *match.AuctionBatch) (err error) {
+	if err = s.commitTranscript(pair, auctionID); err != nil {
+		err = fmt.Errorf("Error committing transcript for finishTranscript: %s", err)
+		return
+	}
+
+	var open *openTranscript
+	var ok bool
+	if open, ok = s.openTranscripts[auctionID]; !ok {
+		return
+	}
+
+	solved := make(map[[32]byte]*match.AuctionOrder)
+	for _, result := range batch.Batch {
+		if result.Encrypted == nil || result.Auction == nil {
+			continue
+		}
+		var receiptID [32]byte
+		if receiptID, err = result.Encrypted.ReceiptID(); err != nil {
+			err = fmt.Errorf("Error getting receipt ID for finishTranscript: %s", err)
+			return
+		}
+		solved[receiptID] = result.Auction
+	}
+
+	// The solutions are in the same order as the puzzles they're from
+	for _, receiptID := range open.receiptIDs {
+		var order *match.AuctionOrder
+		if order, ok = solved[receiptID]; ok {
+			open.transcript.Solutions = append(open.transcript.Solutions, *order)
+		}
+	}
+
+	if err = s.putTranscript(open); err != nil {
+		err = fmt.Errorf("Error storing finished transcript for finishTranscript: %s", err)
+		return
+	}
+
+	delete(s.openTranscripts, auctionID)
+	return
+}
+
+// putTranscript stores a transcript in the puzzle store for its pair. dbLock should be held.
+func (s *OpencxAuctionServer) putTranscript(open *openTranscript) (err error) {
+	var pzEngine cxdb.PuzzleStore
+	var ok bool
+	if pzEngine, ok = s.PuzzleEngines[open.pair]; !ok {
+		err = fmt.Errorf("Could not find puzzle engine for pair %s", open.pair.String())
+		return
+	}
+
+	if err = pzEngine.PutTranscript(open.transcript); err != nil {
+		return
+	}
+	return
+}
+
+// SubmitCommitResponse adds a user's response to an auction's commitment to its transcript. The
+// response reveals the factors of the user's puzzle, and is signed by the same key as the
+// puzzled order. Responses are only taken after the auction has been committed to, and before
+// its batch is placed.
+func (s *OpencxAuctionServer) SubmitCommitResponse(auctionID [32]byte, response match.CommitResponse) (err error) {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	var open *openTranscript
+	var ok bool
+	if open, ok = s.openTranscripts[auctionID]; !ok || !open.transcript.IsCommitted() {
+		err = fmt.Errorf("Auction %x isn't waiting for responses to its commitment", auctionID)
+		return
+	}
+
+	for _, prevResponse := range open.transcript.Responses {
+		if prevResponse.CommResponseSig == response.CommResponseSig {
+			err = fmt.Errorf("Response has already been submitted")
+			return
+		}
+	}
+
+	if err = open.transcript.CheckResponse(response); err != nil {
+		err = fmt.Errorf("Invalid response to commitment: %s", err)
+		return
+	}
+
+	open.transcript.Responses = append(open.transcript.Responses, response)
+	if err = s.putTranscript(open); err != nil {
+		err = fmt.Errorf("Error storing transcript for SubmitCommitResponse: %s", err)
+		// the response is only taken if it can be stored
+		open.transcript.Responses = open.transcript.Responses[:len(open.transcript.Responses)-1]
+		return
+	}
+
+	return
+}
+
+// GetTranscript returns the transcript for an auction on a pair. Transcripts are only available
+// once the auction has been committed to, and have solutions once its batch has been placed.
+func (s *OpencxAuctionServer) GetTranscript(pair *match.Pair, auctionID [32]byte) (transcript *match.Transcript, err error) {
+	s.dbLock.Lock()
+	var pzEngine cxdb.PuzzleStore
+	var ok bool
+	if pzEngine, ok = s.PuzzleEngines[*pair]; !ok {
+		err = fmt.Errorf("Could not find puzzle engine for pair %s", pair.String())
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
+	matchAuctionID := match.AuctionID(auctionID)
+	if transcript, err = pzEngine.ViewTranscript(&matchAuctionID); err != nil {
+		err = fmt.Errorf("Error getting transcript for GetTranscript: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,192 @@
+package cxauctionserver
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// signPuzzledOrder signs the solution form of an encrypted order with priv, like a client does
+// when it submits the order
+func signPuzzledOrder(t *testing.T, priv *koblitz.PrivateKey, order *match.EncryptedAuctionOrder) (signature []byte) {
+	encSolOrder, err := order.SolutionForm()
+	if err != nil {
+		t.Fatalf("Error getting solution form of order: %s", err)
+	}
+	var e []byte
+	if e, err = encSolOrder.SigHash(); err != nil {
+		t.Fatalf("Error hashing order: %s", err)
+	}
+	if signature, err = koblitz.SignCompact(koblitz.S256(), priv, e, false); err != nil {
+		t.Fatalf("Error signing order: %s", err)
+	}
+	return
+}
+
+// TestAuctionTranscript tests that the server commits to the signed orders in an auction when it
+// ends, and that the transcript it serves has the solutions once the batch is placed
+func TestAuctionTranscript(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Fatalf("Error initializing test server: %s", err)
+	}
+	exchangeKey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{3})
+	s.SetExchangeKey(exchangeKey)
+
+	pair := testAuctionOrder.TradingPair
+	auctionID := testAuctionOrder.AuctionID
+	if err = s.StartAuctionWithID(&pair, auctionID); err != nil {
+		t.Fatalf("Error starting auction: %s", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	buy := signedEncryptedOrder(t, priv, match.Buy, 10000, 10000)
+	sell := signedEncryptedOrder(t, priv, match.Sell, 10000, 20000)
+	unsigned := signedEncryptedOrder(t, priv, match.Buy, 5000, 5000)
+	for _, order := range []*match.EncryptedAuctionOrder{buy, sell} {
+		if err = s.PlaceSignedPuzzledOrder(order, signPuzzledOrder(t, priv, order)); err != nil {
+			t.Fatalf("Error placing signed order: %s", err)
+		}
+	}
+	if err = s.PlaceSignedPuzzledOrder(unsigned, []byte{0x01}); err == nil {
+		t.Errorf("Order with an invalid signature should not be placed")
+	}
+	// every order has to be in the transcript, so orders without a signature aren't taken
+	if err = s.PlacePuzzledOrder(unsigned); err == nil {
+		t.Errorf("Order without a signature should not be placed when the server makes transcripts")
+	}
+
+	if _, err = s.GetTranscript(&pair, auctionID); err == nil {
+		t.Errorf("Transcript should not be served before the auction is committed to")
+	}
+
+	var batch *match.AuctionBatch
+	if batch, err = s.EndAuctionWithID(&pair, auctionID); err != nil {
+		t.Fatalf("Error ending auction: %s", err)
+	}
+
+	var transcript *match.Transcript
+	if transcript, err = s.GetTranscript(&pair, auctionID); err != nil {
+		t.Fatalf("Error getting committed transcript: %s", err)
+	}
+	var valid bool
+	if valid, err = transcript.Verify(); !valid || err != nil {
+		t.Fatalf("Committed transcript should be valid: %v", err)
+	}
+	if len(transcript.PuzzledOrders) != 2 || len(transcript.Solutions) != 0 {
+		t.Errorf("Committed transcript should have the 2 signed orders and no solutions, got %d and %d", len(transcript.PuzzledOrders), len(transcript.Solutions))
+	}
+	var exchangePubkey *koblitz.PublicKey
+	if exchangePubkey, err = transcript.ExchangePubkey(); err != nil || !exchangePubkey.IsEqual(exchangeKey.PubKey()) {
+		t.Errorf("Transcript should be signed with the exchange key, got %v", err)
+	}
+
+	// the factors of the puzzles aren't known, so this can't reveal anything
+	response := match.CommitResponse{PuzzleAnswerReveal: match.SolutionOrder{P: exchangeKey.D, Q: exchangeKey.D}}
+	if err = s.SubmitCommitResponse(auctionID, response); err == nil {
+		t.Errorf("Response that doesn't reveal a puzzle should be rejected")
+	}
+
+	if err = s.PlaceBatch(batch); err != nil {
+		t.Fatalf("Error placing batch: %s", err)
+	}
+
+	if transcript, err = s.GetTranscript(&pair, auctionID); err != nil {
+		t.Fatalf("Error getting finished transcript: %s", err)
+	}
+	if valid, err = transcript.Verify(); !valid || err != nil {
+		t.Fatalf("Finished transcript should be valid: %v", err)
+	}
+	if len(transcript.Solutions) != 2 {
+		t.Errorf("Finished transcript should have 2 solutions, got %d", len(transcript.Solutions))
+	}
+	if err = transcript.VerifySolutions(); err != nil {
+		t.Errorf("Solutions should be the orders in the puzzles: %s", err)
+	}
+
+	if err = s.SubmitCommitResponse(auctionID, response); err == nil {
+		t.Errorf("Responses should not be taken once the batch is placed")
+	}
+	return
+}
+
+// TestAuctionTranscriptAfterRestart tests that the orders in an auction are committed to even if
+// the server that took them is gone, since the transcript is made from what's in the puzzle store,
+// and that a committed transcript still gets its solutions if the server restarts before the batch
+func TestAuctionTranscriptAfterRestart(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Fatalf("Error initializing test server: %s", err)
+	}
+	exchangeKey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{3})
+	s.SetExchangeKey(exchangeKey)
+
+	pair := testAuctionOrder.TradingPair
+	auctionID := testAuctionOrder.AuctionID
+	if err = s.StartAuctionWithID(&pair, auctionID); err != nil {
+		t.Fatalf("Error starting auction: %s", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	buy := signedEncryptedOrder(t, priv, match.Buy, 10000, 10000)
+	sell := signedEncryptedOrder(t, priv, match.Sell, 10000, 20000)
+	for _, order := range []*match.EncryptedAuctionOrder{buy, sell} {
+		if err = s.PlaceSignedPuzzledOrder(order, signPuzzledOrder(t, priv, order)); err != nil {
+			t.Fatalf("Error placing signed order: %s", err)
+		}
+	}
+
+	// a new server with the same stores doesn't have anything the old one kept in memory
+	var restarted *OpencxAuctionServer
+	if restarted, err = InitServer(s.SettlementEngines, s.MatchingEngines, s.Orderbooks, s.PuzzleEngines, s.OrderBatchers, testOrderChanSize, testStandardAuctionTime); err != nil {
+		t.Fatalf("Error initializing restarted server: %s", err)
+	}
+	restarted.SetExchangeKey(exchangeKey)
+
+	var batch *match.AuctionBatch
+	if batch, err = restarted.EndAuctionWithID(&pair, auctionID); err != nil {
+		t.Fatalf("Error ending auction: %s", err)
+	}
+
+	var transcript *match.Transcript
+	if transcript, err = restarted.GetTranscript(&pair, auctionID); err != nil {
+		t.Fatalf("Error getting committed transcript: %s", err)
+	}
+	if len(transcript.PuzzledOrders) != 2 {
+		t.Errorf("Transcript should have both signed orders, got %d", len(transcript.PuzzledOrders))
+	}
+	var valid bool
+	if valid, err = transcript.Verify(); !valid || err != nil {
+		t.Errorf("Committed transcript should be valid: %v", err)
+	}
+
+	// the server is restarted again between the commitment and the batch, so the stored
+	// transcript has to be opened again to get its solutions
+	if restarted, err = InitServer(s.SettlementEngines, s.MatchingEngines, s.Orderbooks, s.PuzzleEngines, s.OrderBatchers, testOrderChanSize, testStandardAuctionTime); err != nil {
+		t.Fatalf("Error initializing restarted server: %s", err)
+	}
+	restarted.SetExchangeKey(exchangeKey)
+
+	if err = restarted.PlaceBatch(batch); err != nil {
+		t.Fatalf("Error placing batch: %s", err)
+	}
+
+	if transcript, err = restarted.GetTranscript(&pair, auctionID); err != nil {
+		t.Fatalf("Error getting finished transcript: %s", err)
+	}
+	if valid, err = transcript.Verify(); !valid || err != nil {
+		t.Fatalf("Finished transcript should be valid: %v", err)
+	}
+	if len(transcript.Solutions) != 2 {
+		t.Errorf("Finished transcript should have 2 solutions, got %d", len(transcript.Solutions))
+	}
+	if err = transcript.VerifySolutions(); err != nil {
+		t.Errorf("Solutions should be the orders in the puzzles: %s", err)
+	}
+	return
+}
@@ -69,15 +69,27 @@ func createLightSettleServer(coinList []*coinparam.Params, whitelist []*koblitz.
 		return
 	}
 
+	var withdrawalStores map[*coinparam.Params]cxdb.WithdrawalStore
+	if withdrawalStores, err = cxdbmemory.CreateWithdrawalStoreMap(coinList); err != nil {
+		err = fmt.Errorf("Error creating withdrawal store map for createFullServer: %s", err)
+		return
+	}
+
 	var setStores map[*coinparam.Params]cxdb.SettlementStore
 	if setStores, err = cxdbsql.CreateSettlementStoreMap(coinList); err != nil {
 		err = fmt.Errorf("Error creating settlement store map for createFullServer: %s", err)
 		return
 	}
 
+	var historyStore cxdb.HistoryStore
+	if historyStore, err = cxdbsql.CreateHistoryStore(); err != nil {
+		err = fmt.Errorf("Error creating history store for createFullServer: %s", err)
+		return
+	}
+
 	// TODO: change this root directory nonsense!!!
 	var ocxServer *cxserver.OpencxServer
-	if ocxServer, err = cxserver.InitServer(setEngines, mengines, limBooks, depositStores, setStores, ".benchmarkInfo/"); err != nil {
+	if ocxServer, err = cxserver.InitServer(setEngines, mengines, limBooks, depositStores, withdrawalStores, setStores, historyStore, ".benchmarkInfo/"); err != nil {
 		err = fmt.Errorf("Error initializing server for createFullServer: %s", err)
 		return
 	}
@@ -275,15 +287,27 @@ func createFullServer(coinList []*coinparam.Params, serverhost string, serverpor
 		return
 	}
 
+	var withdrawalStores map[*coinparam.Params]cxdb.WithdrawalStore
+	if withdrawalStores, err = cxdbmemory.CreateWithdrawalStoreMap(coinList); err != nil {
+		err = fmt.Errorf("Error creating withdrawal store map for createFullServer: %s", err)
+		return
+	}
+
 	var setStores map[*coinparam.Params]cxdb.SettlementStore
 	if setStores, err = cxdbsql.CreateSettlementStoreMap(coinList); err != nil {
 		err = fmt.Errorf("Error creating settlement store map for createFullServer: %s", err)
 		return
 	}
 
+	var historyStore cxdb.HistoryStore
+	if historyStore, err = cxdbsql.CreateHistoryStore(); err != nil {
+		err = fmt.Errorf("Error creating history store for createFullServer: %s", err)
+		return
+	}
+
 	// TODO: get rid of this directory nonsense, just figure out a nice way to deal with these things
 	var ocxServer *cxserver.OpencxServer
-	if ocxServer, err = cxserver.InitServer(setEngines, mengines, limBooks, depositStores, setStores, ".benchmarkInfo/"); err != nil {
+	if ocxServer, err = cxserver.InitServer(setEngines, mengines, limBooks, depositStores, withdrawalStores, setStores, historyStore, ".benchmarkInfo/"); err != nil {
 		err = fmt.Errorf("Error initializing server for createFullServer: %s", err)
 		return
 	}
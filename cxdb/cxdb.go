@@ -1,6 +1,8 @@
 package cxdb
 
 import (
+	"time"
+
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/match"
 )
@@ -16,12 +18,81 @@ type SettlementStore interface {
 type DepositStore interface {
 	// RegisterUser takes in a pubkey, and an address for the pubkey
 	RegisterUser(pubkey *koblitz.PublicKey, address string) (err error)
-	// UpdateDeposits updates the deposits when a block comes in
+	// UpdateDeposits updates the deposits when a block comes in, and returns execs crediting the
+	// deposits that have enough confirmations at that height and haven't been credited yet
 	UpdateDeposits(deposits []match.Deposit, blockheight uint64) (depositExecs []*match.SettlementExecution, err error)
+	// DisconnectBlocks rolls deposits back after a reorg, when every block above blockheight has
+	// been disconnected. Deposits seen in those blocks are orphaned, and deposits that were
+	// credited in those blocks are pending again. It returns execs taking back whatever had
+	// already been credited.
+	DisconnectBlocks(blockheight uint64) (rollbackExecs []*match.SettlementExecution, err error)
+	// GetDeposits gets every deposit for a pubkey, oldest first, including orphaned ones. The store
+	// doesn't know the height of the chain, so confirmations so far aren't set.
+	GetDeposits(pubkey *koblitz.PublicKey) (deposits []*match.DepositStatus, err error)
 	// GetDepositAddressMap gets a map of the deposit addresses we own to pubkeys
 	GetDepositAddressMap() (depAddrMap map[string]*koblitz.PublicKey, err error)
 	// GetDepositAddress gets the deposit address for a pubkey and an asset.
 	GetDepositAddress(pubkey *koblitz.PublicKey) (addr string, err error)
+	// AddDepositDebt adds to what a pubkey owes for deposits that were reorged out after they
+	// had already been spent
+	AddDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error)
+	// PayDepositDebt takes amount off what a pubkey owes for reorged deposits, it fails if that's
+	// more than they owe
+	PayDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error)
+	// GetDepositDebt gets what a pubkey owes for reorged deposits, 0 if they don't owe anything
+	GetDepositDebt(pubkey *koblitz.PublicKey) (debt uint64, err error)
+}
+
+// WithdrawalStore keeps on-chain withdrawal requests for a coin, from when they're requested until
+// they're confirmed or fail. Requests are never deleted.
+type WithdrawalStore interface {
+	// AddWithdrawal stores a new withdrawal request and sets its ID
+	AddWithdrawal(withdrawal *match.WithdrawalRequest) (err error)
+	// UpdateWithdrawal saves the state, txid, reason, fee and update time of a stored withdrawal request
+	UpdateWithdrawal(withdrawal *match.WithdrawalRequest) (err error)
+	// GetWithdrawal gets a withdrawal request by ID
+	GetWithdrawal(id uint64) (withdrawal *match.WithdrawalRequest, err error)
+	// GetWithdrawals gets every withdrawal request for a pubkey, oldest first
+	GetWithdrawals(pubkey *koblitz.PublicKey) (withdrawals []*match.WithdrawalRequest, err error)
+	// GetWithdrawalsByState gets every withdrawal request in a state, oldest first
+	GetWithdrawalsByState(state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error)
+	// PutWithdrawalBatch stores a withdrawal transaction that hasn't confirmed yet, keyed by the
+	// txid of its first version. Putting a batch that's already stored replaces it, since it's
+	// put again every time the transaction is replaced.
+	PutWithdrawalBatch(batch *match.WithdrawalBatch) (err error)
+	// GetWithdrawalBatches gets every withdrawal transaction that hasn't confirmed yet
+	GetWithdrawalBatches() (batches []*match.WithdrawalBatch, err error)
+	// RemoveWithdrawalBatch removes a batch once a version of its transaction confirms. Removing
+	// a batch that isn't stored does nothing.
+	RemoveWithdrawalBatch(txid string) (err error)
+}
+
+// ColdStore keeps the outputs paying to a coin's cold wallet, which the exchange only has a
+// watch-only view of. Spent outputs are kept until a reorg can't unspend them.
+type ColdStore interface {
+	// AddColdUtxos stores new outputs paying to the cold wallet. Outputs that are already stored
+	// are ignored.
+	AddColdUtxos(utxos []*match.ColdUtxo) (err error)
+	// SpendColdUtxos marks cold outputs, by txid:index, as spent in the block at blockheight.
+	// Outpoints that aren't cold outputs are ignored.
+	SpendColdUtxos(outpoints []string, blockheight uint64) (err error)
+	// DisconnectBlocks rolls the cold wallet back after a reorg, when every block above
+	// blockheight has been disconnected. Outputs seen in those blocks are removed, and outputs
+	// spent in them are unspent again.
+	DisconnectBlocks(blockheight uint64) (err error)
+	// GetColdUtxos gets every unspent cold output, oldest first
+	GetColdUtxos() (utxos []*match.ColdUtxo, err error)
+	// AddRefill stores a refill that's waiting for the operator to sign it, keyed by its
+	// unsigned txid. Adding a refill with the same txid replaces it.
+	AddRefill(txid string, refill *match.RefillTx) (err error)
+	// GetRefill gets the refill waiting to be signed with an unsigned txid. The refill is nil if
+	// there isn't one.
+	GetRefill(txid string) (refill *match.RefillTx, err error)
+	// GetRefills gets every refill waiting to be signed
+	GetRefills() (refills []*match.RefillTx, err error)
+	// RemoveRefill removes a refill once it's signed and sent. Removing a refill that isn't
+	// stored does nothing.
+	RemoveRefill(txid string) (err error)
 }
 
 // PuzzleStore is an interface for defining a storage layer for auction order puzzles.
@@ -32,4 +103,136 @@ type PuzzleStore interface {
 	ViewAuctionPuzzleBook(auctionID *match.AuctionID) (puzzles []*match.EncryptedAuctionOrder, err error)
 	// PlaceAuctionPuzzle puts an encrypted auction order in the datastore.
 	PlaceAuctionPuzzle(puzzledOrder *match.EncryptedAuctionOrder) (err error)
+	// PutTranscript stores the signed transcript for an auction, keyed by its batch ID. Putting a
+	// transcript for an auction that already has one replaces it, since the exchange puts it once
+	// it's committed to the puzzles, and again when the responses and solutions are in.
+	PutTranscript(transcript *match.Transcript) (err error)
+	// ViewTranscript returns the transcript for an auction, and errors if there isn't one.
+	ViewTranscript(auctionID *match.AuctionID) (transcript *match.Transcript, err error)
+	// PutAuctionOrderStatus stores the status of an auction order, keyed by its receipt ID,
+	// replacing the one that was there.
+	PutAuctionOrderStatus(status *match.AuctionOrderStatus) (err error)
+	// ViewAuctionOrderStatus returns the status of the auction order with a receipt ID. The
+	// status is nil if there's no order with that receipt.
+	ViewAuctionOrderStatus(receiptID [32]byte) (status *match.AuctionOrderStatus, err error)
+	// PutAuctionResult stores the result of an auction, replacing the one that was there.
+	PutAuctionResult(result *match.AuctionResult) (err error)
+	// ViewAuctionResult returns the result of an auction. The result is nil if the auction
+	// doesn't have one.
+	ViewAuctionResult(auctionID *match.AuctionID) (result *match.AuctionResult, err error)
+	// PruneAuctions removes the results of auctions that ended before a time, and the statuses of
+	// orders that were decided and haven't been updated since then. Puzzles and transcripts are
+	// kept.
+	PruneAuctions(before time.Time) (err error)
+}
+
+// HistoryStore keeps every limit order the exchange has seen and every fill, so users can look
+// at orders after they've left the orderbook. It's for every pair, unlike the orderbooks, so a
+// user's history can be paged through in one go.
+type HistoryStore interface {
+	// RecordPlace adds a newly placed order to the history
+	RecordPlace(limitIDPair *match.LimitOrderIDPair) (err error)
+	// RecordExec updates an order with an execution and adds the fill to the history
+	RecordExec(orderExec *match.OrderExecution, execTime time.Time) (err error)
+	// RecordCancel marks an order as cancelled
+	RecordCancel(cancel *match.CancelledOrder, cancelTime time.Time) (err error)
+	// RecordExpire marks an order as expired
+	RecordExpire(orderID *match.OrderID, expireTime time.Time) (err error)
+	// GetOrderHistory gets orders for a pubkey, newest first, along with the cursor for the next
+	// page. The next cursor is 0 if there are no more pages.
+	GetOrderHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error)
+	// GetFillHistory gets fills for a pubkey, newest first, along with the cursor for the next
+	// page. The next cursor is 0 if there are no more pages.
+	GetFillHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error)
+}
+
+// SwapStore keeps swaps, which settle fills of swap orders over lightning, the IDs of the swap
+// orders themselves, and the escrows of channel orders, swap orders whose side is locked in HTLCs
+// when they're placed. It's for every pair, since a swap settles across two chains. Swaps and
+// escrows are keyed by their hash.
+type SwapStore interface {
+	// AddSwap stores a new swap
+	AddSwap(swap *match.Swap) (err error)
+	// UpdateSwap saves the state, reason and update time of a stored swap
+	UpdateSwap(swap *match.Swap) (err error)
+	// GetSwap gets a swap by its hash
+	GetSwap(rhash [32]byte) (swap *match.Swap, err error)
+	// GetSwaps gets every swap for a pubkey, oldest first
+	GetSwaps(pubkey *koblitz.PublicKey) (swaps []*match.Swap, err error)
+	// GetSwapsByState gets every swap in a state, oldest first
+	GetSwapsByState(state match.SwapState) (swaps []*match.Swap, err error)
+	// AddSwapOrder marks an order as a swap order, so its fills are settled with swaps
+	AddSwapOrder(orderID *match.OrderID) (err error)
+	// IsSwapOrder returns true if an order is a swap order
+	IsSwapOrder(orderID *match.OrderID) (swapOrder bool, err error)
+	// AddEscrow stores a new escrow, it fails if there's already one with the hash
+	AddEscrow(escrow *match.OrderEscrow) (err error)
+	// UpdateEscrow saves everything about a stored escrow that changes as it moves along: its
+	// order ID, what it releases, state, reason and update time
+	UpdateEscrow(escrow *match.OrderEscrow) (err error)
+	// GetEscrow gets an escrow by its hash
+	GetEscrow(rhash [32]byte) (escrow *match.OrderEscrow, err error)
+	// GetOrderEscrow gets the escrow of a channel order, escrow is nil if the order doesn't have
+	// one
+	GetOrderEscrow(orderID *match.OrderID) (escrow *match.OrderEscrow, err error)
+	// GetEscrows gets every escrow for a pubkey, oldest first
+	GetEscrows(pubkey *koblitz.PublicKey) (escrows []*match.OrderEscrow, err error)
+	// GetEscrowsByState gets every escrow in a state, oldest first
+	GetEscrowsByState(state match.EscrowState) (escrows []*match.OrderEscrow, err error)
+}
+
+// SubmarineStore keeps submarine swaps, which move coins between users' on-chain coins and their
+// lightning channels with the exchange, for every coin. Submarine swaps are keyed by their hash.
+type SubmarineStore interface {
+	// AddSubmarineSwap stores a new submarine swap, it fails if there's already one with the hash
+	AddSubmarineSwap(swap *match.SubmarineSwap) (err error)
+	// UpdateSubmarineSwap saves everything about a stored submarine swap that changes as it moves
+	// along: its preimage, channel locktime, funding, spend, state, reason and update time
+	UpdateSubmarineSwap(swap *match.SubmarineSwap) (err error)
+	// GetSubmarineSwap gets a submarine swap by its hash
+	GetSubmarineSwap(rhash [32]byte) (swap *match.SubmarineSwap, err error)
+	// GetSubmarineSwaps gets every submarine swap for a pubkey, oldest first
+	GetSubmarineSwaps(pubkey *koblitz.PublicKey) (swaps []*match.SubmarineSwap, err error)
+	// GetSubmarineSwapsByState gets every submarine swap in a state, oldest first
+	GetSubmarineSwapsByState(state match.SubmarineState) (swaps []*match.SubmarineSwap, err error)
+}
+
+// AtomicSwapStore keeps atomic swaps, which settle fills on atomic swap pairs with on-chain HTLCs
+// between the users' own wallets. Atomic swaps are keyed by their ID.
+type AtomicSwapStore interface {
+	// AddAtomicSwap stores a new atomic swap, it fails if there's already one with the ID
+	AddAtomicSwap(swap *match.AtomicSwap) (err error)
+	// UpdateAtomicSwap saves everything about a stored atomic swap that changes as it moves
+	// along: its hash, preimage, both sides, state, deadline, who abandoned it and why, and
+	// update time
+	UpdateAtomicSwap(swap *match.AtomicSwap) (err error)
+	// GetAtomicSwap gets an atomic swap by its ID
+	GetAtomicSwap(id [32]byte) (swap *match.AtomicSwap, err error)
+	// GetAtomicSwaps gets every atomic swap a pubkey is on either side of, oldest first
+	GetAtomicSwaps(pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error)
+	// GetAtomicSwapsByState gets every atomic swap in a state, oldest first
+	GetAtomicSwapsByState(state match.AtomicSwapState) (swaps []*match.AtomicSwap, err error)
+}
+
+// LightningDepositStore keeps every deposit credited from a lightning channel, for every coin. A
+// deposit is keyed by its channel's outpoint and state index, so a channel event that's seen
+// twice is only credited once. It also keeps the invoices users are paid through, keyed by hash.
+type LightningDepositStore interface {
+	// AddLightningDeposit stores a deposit, added is false if there's already one for the
+	// channel and state
+	AddLightningDeposit(deposit *match.LightningDeposit) (added bool, err error)
+	// GetLightningDeposits gets every deposit for a pubkey, oldest first
+	GetLightningDeposits(pubkey *koblitz.PublicKey) (deposits []*match.LightningDeposit, err error)
+	// GetChannelDeposits gets every deposit over a channel, oldest first
+	GetChannelDeposits(outpoint string) (deposits []*match.LightningDeposit, err error)
+	// AddInvoice stores a new invoice, it fails if there's already one with the hash
+	AddInvoice(invoice *match.Invoice) (err error)
+	// UpdateInvoice saves the amount paid, state, reason and update time of a stored invoice
+	UpdateInvoice(invoice *match.Invoice) (err error)
+	// GetInvoice gets an invoice by its hash
+	GetInvoice(rhash [32]byte) (invoice *match.Invoice, err error)
+	// GetInvoices gets every invoice for a pubkey, oldest first
+	GetInvoices(pubkey *koblitz.PublicKey) (invoices []*match.Invoice, err error)
+	// GetInvoicesByState gets every invoice in a state, oldest first
+	GetInvoicesByState(state match.InvoiceState) (invoices []*match.Invoice, err error)
 }
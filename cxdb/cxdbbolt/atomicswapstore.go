@@ -0,0 +1,154 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for atomic swaps, keyed by seq so they're kept oldest first
+	atomicSwapsBucket = []byte("atomicswaps")
+	// bucket for the key of each atomic swap, keyed by ID
+	atomicKeysBucket = []byte("atomickeys")
+)
+
+// BoltAtomicSwapStore keeps atomic swaps for every pair in a bolt db. Atomic swaps are gob
+// encoded.
+type BoltAtomicSwapStore struct {
+	db *bolt.DB
+}
+
+// CreateAtomicSwapStore creates an atomic swap store, storing atomic swaps in dataDir.
+func CreateAtomicSwapStore(dataDir string) (store cxdb.AtomicSwapStore, err error) {
+	ss := new(BoltAtomicSwapStore)
+	if ss.db, err = openStoreDB(dataDir, "atomicswapstore", "all", atomicSwapsBucket, atomicKeysBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateAtomicSwapStore: %s", err)
+		return
+	}
+	store = ss
+	return
+}
+
+// AddAtomicSwap stores a new atomic swap
+func (ss *BoltAtomicSwapStore) AddAtomicSwap(swap *match.AtomicSwap) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		submarineKeys := tx.Bucket(atomicKeysBucket)
+		if submarineKeys.Get(swap.ID[:]) != nil {
+			err = fmt.Errorf("Atomic swap %s already exists", swap.IDString())
+			return
+		}
+		var key []byte
+		if key, err = sequenceKey(tx.Bucket(atomicSwapsBucket), nil); err != nil {
+			return
+		}
+		if err = submarineKeys.Put(swap.ID[:], key); err != nil {
+			err = fmt.Errorf("Error putting atomic swap key: %s", err)
+			return
+		}
+		return putGob(tx.Bucket(atomicSwapsBucket), key, swap)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddAtomicSwap: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateAtomicSwap saves everything about a stored atomic swap that changes as it moves
+// along
+func (ss *BoltAtomicSwapStore) UpdateAtomicSwap(swap *match.AtomicSwap) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		var stored *match.AtomicSwap
+		var key []byte
+		if stored, key, err = getAtomicSwapTx(tx, swap.ID); err != nil {
+			return
+		}
+		stored.UpdateFrom(swap)
+		return putGob(tx.Bucket(atomicSwapsBucket), key, stored)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateAtomicSwap: %s", err)
+		return
+	}
+	return
+}
+
+// GetAtomicSwap gets an atomic swap by its ID
+func (ss *BoltAtomicSwapStore) GetAtomicSwap(id [32]byte) (swap *match.AtomicSwap, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		swap, _, err = getAtomicSwapTx(tx, id)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetAtomicSwap: %s", err)
+		return
+	}
+	return
+}
+
+// getAtomicSwapTx gets an atomic swap by its ID, along with the key it's stored under
+func getAtomicSwapTx(tx *bolt.Tx, id [32]byte) (swap *match.AtomicSwap, key []byte, err error) {
+	if key = tx.Bucket(atomicKeysBucket).Get(id[:]); key == nil {
+		err = fmt.Errorf("No atomic swap %x", id)
+		return
+	}
+	// bolt values are only valid for the transaction, and we use the key to put the swap back
+	key = append([]byte{}, key...)
+
+	swap = new(match.AtomicSwap)
+	if err = getGob(tx.Bucket(atomicSwapsBucket).Get(key), swap); err != nil {
+		return
+	}
+	return
+}
+
+// GetAtomicSwaps gets every atomic swap a pubkey is on either side of, oldest first
+func (ss *BoltAtomicSwapStore) GetAtomicSwaps(pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if swaps, err = ss.filterAtomicSwaps(func(swap *match.AtomicSwap) bool {
+		return bytes.Equal(swap.Initiator.Pubkey[:], pkBytes) || bytes.Equal(swap.Participant.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetAtomicSwaps: %s", err)
+		return
+	}
+	return
+}
+
+// GetAtomicSwapsByState gets every atomic swap in a state, oldest first
+func (ss *BoltAtomicSwapStore) GetAtomicSwapsByState(state match.AtomicSwapState) (swaps []*match.AtomicSwap, err error) {
+	if swaps, err = ss.filterAtomicSwaps(func(swap *match.AtomicSwap) bool {
+		return swap.State == state
+	}); err != nil {
+		err = fmt.Errorf("Error for GetAtomicSwapsByState: %s", err)
+		return
+	}
+	return
+}
+
+// filterAtomicSwaps returns the atomic swaps that keep returns true for, oldest first
+func (ss *BoltAtomicSwapStore) filterAtomicSwaps(keep func(*match.AtomicSwap) bool) (swaps []*match.AtomicSwap, err error) {
+	err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(atomicSwapsBucket).ForEach(func(k, v []byte) (err error) {
+			swap := new(match.AtomicSwap)
+			if err = getGob(v, swap); err != nil {
+				return
+			}
+			if keep(swap) {
+				swaps = append(swaps, swap)
+			}
+			return
+		})
+	})
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ss *BoltAtomicSwapStore) DestroyHandler() (err error) {
+	if err = ss.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing atomic swap store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
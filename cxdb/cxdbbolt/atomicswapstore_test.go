@@ -0,0 +1,81 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestAtomicSwapStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateAtomicSwapStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic swap store: %s", err)
+	}
+
+	taker, maker := createTestKey(t), createTestKey(t)
+	var takerBytes, makerBytes [33]byte
+	copy(takerBytes[:], taker.SerializeCompressed())
+	copy(makerBytes[:], maker.SerializeCompressed())
+	created := time.Unix(1500000000, 0)
+	var swaps []*match.AtomicSwap
+	for i := 0; i < 2; i++ {
+		fill := &match.FillEntry{OrderID: match.OrderID{byte(i + 1)}, Pubkey: makerBytes, TradingPair: *testPair, Side: match.Sell, AmountHave: 1000, AmountWant: 150000}
+		var swap *match.AtomicSwap
+		if swap, err = match.NewAtomicSwap(match.OrderID{0x10}, takerBytes, fill, match.DefaultAtomicSwapPolicy(), created); err != nil {
+			t.Fatalf("Error creating atomic swap: %s", err)
+		}
+		if err = store.AddAtomicSwap(swap); err != nil {
+			t.Fatalf("Error adding atomic swap: %s", err)
+		}
+		swaps = append(swaps, swap)
+	}
+	if err = store.AddAtomicSwap(swaps[0]); err == nil {
+		t.Errorf("Adding the same atomic swap twice should fail")
+	}
+
+	swaps[0].RHash = [32]byte{0x03}
+	swaps[0].Preimage = [16]byte{0x04}
+	swaps[0].Initiator.Locktime = 1500100000
+	swaps[0].Participant.Outpoint = "00:0"
+	swaps[0].Participant.SpendTxid = "01"
+	swaps[0].SetState(match.AtomicSwapRedeemed, created.Add(time.Hour), created.Add(time.Minute))
+	if err = store.UpdateAtomicSwap(swaps[0]); err != nil {
+		t.Fatalf("Error updating atomic swap: %s", err)
+	}
+
+	if err = store.(*BoltAtomicSwapStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing atomic swap store: %s", err)
+	}
+	if store, err = CreateAtomicSwapStore(dataDir); err != nil {
+		t.Fatalf("Error reopening atomic swap store: %s", err)
+	}
+	defer store.(*BoltAtomicSwapStore).DestroyHandler()
+
+	var swap *match.AtomicSwap
+	if swap, err = store.GetAtomicSwap(swaps[0].ID); err != nil {
+		t.Fatalf("Error getting atomic swap after restart: %s", err)
+	}
+	if swap.State != match.AtomicSwapRedeemed || swap.Preimage != swaps[0].Preimage || swap.RHash != swaps[0].RHash || swap.Initiator.Locktime != 1500100000 || swap.Participant.SpendTxid != "01" || !swap.Deadline.Equal(created.Add(time.Hour)) {
+		t.Errorf("Atomic swap should be redeemed and keep its preimage, sides and deadline, got %s", swap)
+	}
+
+	var waiting []*match.AtomicSwap
+	if waiting, err = store.GetAtomicSwapsByState(match.AtomicSwapMatched); err != nil {
+		t.Fatalf("Error getting matched atomic swaps: %s", err)
+	}
+	if len(waiting) != 1 || waiting[0].ID != swaps[1].ID {
+		t.Errorf("Only the second atomic swap should be matched, got %d matched", len(waiting))
+	}
+
+	var all []*match.AtomicSwap
+	if all, err = store.GetAtomicSwaps(maker); err != nil {
+		t.Fatalf("Error getting atomic swaps: %s", err)
+	}
+	if len(all) != 2 || all[0].ID != swaps[0].ID {
+		t.Errorf("Maker should have both atomic swaps oldest first, got %d", len(all))
+	}
+}
@@ -0,0 +1,171 @@
+package cxdbbolt
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/crypto/sha3"
+)
+
+// BoltAuctionEngine is an auction matching engine that keeps its orders in a bolt db
+type BoltAuctionEngine struct {
+	db *bolt.DB
+
+	// this pair
+	pair *match.Pair
+}
+
+// CreateAuctionEngine creates an auction engine for a pair, storing the orders in dataDir.
+func CreateAuctionEngine(pair *match.Pair, dataDir string) (engine match.AuctionEngine, err error) {
+	ae := &BoltAuctionEngine{
+		pair: pair,
+	}
+	if ae.db, err = openStoreDB(dataDir, "auctionengine", pair.String(), ordersBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateAuctionEngine: %s", err)
+		return
+	}
+	engine = ae
+	return
+}
+
+// PlaceAuctionOrder places an order in the auction engine for a specific auction ID.
+// This assumes that the order is valid and is for the same pair as the matching engine
+func (ae *BoltAuctionEngine) PlaceAuctionOrder(order *match.AuctionOrder, auctionID *match.AuctionID) (idRes *match.AuctionOrderIDPair, err error) {
+	if order == nil {
+		err = fmt.Errorf("Cannot place nil order, please enter valid input")
+		return
+	}
+
+	// calculate price, this also makes sure neither amount is zero
+	var price float64
+	if price, err = order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price from order while placing order: %s", err)
+		return
+	}
+
+	// the auction ID passed in is the one we store it under
+	storedOrder := *order
+	storedOrder.AuctionID = *auctionID
+
+	// hash order so we can use that as the order ID
+	hasher := sha3.New256()
+	hasher.Write(storedOrder.SerializeSignable())
+
+	idRes = &match.AuctionOrderIDPair{
+		Order: order,
+		Price: price,
+	}
+	copy(idRes.OrderID[:], hasher.Sum(nil))
+
+	if err = ae.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(ordersBucket).Put(idRes.OrderID[:], storedOrder.Serialize())
+	}); err != nil {
+		idRes = nil
+		err = fmt.Errorf("Error placing order into db for PlaceAuctionOrder: %s", err)
+		return
+	}
+	return
+}
+
+// CancelAuctionOrder cancels an auction order, this assumes that the auction order actually exists
+func (ae *BoltAuctionEngine) CancelAuctionOrder(orderID *match.OrderID) (cancelled *match.CancelledOrder, cancelSettlement *match.SettlementExecution, err error) {
+	if err = ae.db.Update(func(tx *bolt.Tx) (err error) {
+		orders := tx.Bucket(ordersBucket)
+
+		var idPair *match.AuctionOrderIDPair
+		if idPair, err = decodeAuctionOrder(orderID[:], orders.Get(orderID[:])); err != nil {
+			return
+		}
+
+		if err = orders.Delete(orderID[:]); err != nil {
+			err = fmt.Errorf("Error deleting order: %s", err)
+			return
+		}
+
+		var debitAsset match.Asset
+		if idPair.Order.Side == match.Buy {
+			debitAsset = ae.pair.AssetHave
+		} else {
+			debitAsset = ae.pair.AssetWant
+		}
+		cancelled = &match.CancelledOrder{
+			OrderID: orderID,
+		}
+		cancelSettlement = &match.SettlementExecution{
+			Pubkey: idPair.Order.Pubkey,
+			Amount: idPair.Order.AmountHave,
+			Asset:  debitAsset,
+			Type:   match.Debit,
+		}
+		return
+	}); err != nil {
+		cancelled = nil
+		cancelSettlement = nil
+		err = fmt.Errorf("Error for CancelAuctionOrder: %s", err)
+		return
+	}
+	return
+}
+
+// MatchAuctionOrders calculates a single clearing price to execute orders at, and executes at that price.
+func (ae *BoltAuctionEngine) MatchAuctionOrders(auctionID *match.AuctionID) (orderExecs []*match.OrderExecution, settlementExecs []*match.SettlementExecution, err error) {
+	if err = ae.db.Update(func(tx *bolt.Tx) (err error) {
+		var orderList []*match.AuctionOrderIDPair
+		if orderList, err = getAuctionOrdersTx(tx, auctionID); err != nil {
+			err = fmt.Errorf("Error getting orders: %s", err)
+			return
+		}
+
+		book := make(map[float64][]*match.AuctionOrderIDPair)
+		for _, order := range orderList {
+			book[order.Price] = append(book[order.Price], order)
+		}
+
+		// We can now calculate a clearing price and run the matching algorithm
+		if orderExecs, settlementExecs, err = match.MatchClearingAlgorithm(book); err != nil {
+			err = fmt.Errorf("Error running clearing matching algorithm: %s", err)
+			return
+		}
+
+		// now process all of these matches based on the matching algorithm
+		for _, exec := range orderExecs {
+			if err = updateAuctionOrderTx(tx, exec); err != nil {
+				err = fmt.Errorf("Error updating order for exec: %s", err)
+				return
+			}
+		}
+		return
+	}); err != nil {
+		orderExecs = nil
+		settlementExecs = nil
+		err = fmt.Errorf("Error for MatchAuctionOrders: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the engine can't be used after this
+func (ae *BoltAuctionEngine) DestroyHandler() (err error) {
+	if err = ae.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing auction engine db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateAuctionEngineMap creates a map of pair to auction engine, given a list of pairs.
+func CreateAuctionEngineMap(pairList []*match.Pair, dataDir string) (aucMap map[match.Pair]match.AuctionEngine, err error) {
+
+	aucMap = make(map[match.Pair]match.AuctionEngine)
+	var curAucEng match.AuctionEngine
+	for _, pair := range pairList {
+		if curAucEng, err = CreateAuctionEngine(pair, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single auction engine while creating auction engine map: %s", err)
+			return
+		}
+		aucMap[*pair] = curAucEng
+	}
+
+	return
+}
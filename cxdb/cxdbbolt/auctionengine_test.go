@@ -0,0 +1,102 @@
+package cxdbbolt
+
+import (
+	"testing"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestAuctionEngineOrdersSurviveRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	engine, err := CreateAuctionEngine(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating auction engine: %s", err)
+	}
+
+	auctionID := &match.AuctionID{0x01}
+	order := &match.AuctionOrder{
+		Side:        match.Buy,
+		TradingPair: *testPair,
+		AmountHave:  1000,
+		AmountWant:  2000,
+		AuctionID:   *auctionID,
+		Nonce:       [2]byte{0x01, 0x02},
+		Signature:   []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	copy(order.Pubkey[:], createTestKey(t).SerializeCompressed())
+
+	var idRes *match.AuctionOrderIDPair
+	if idRes, err = engine.PlaceAuctionOrder(order, auctionID); err != nil {
+		t.Fatalf("Error placing auction order: %s", err)
+	}
+
+	if err = engine.(*BoltAuctionEngine).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing auction engine: %s", err)
+	}
+	if engine, err = CreateAuctionEngine(testPair, dataDir); err != nil {
+		t.Fatalf("Error reopening auction engine: %s", err)
+	}
+	defer engine.(*BoltAuctionEngine).DestroyHandler()
+
+	_, cancelSettlement, err := engine.CancelAuctionOrder(&idRes.OrderID)
+	if err != nil {
+		t.Fatalf("Error cancelling auction order after restart: %s", err)
+	}
+	if cancelSettlement.Amount != order.AmountHave || cancelSettlement.Pubkey != order.Pubkey {
+		t.Errorf("Cancel should give back the remaining amount to the order's pubkey")
+	}
+}
+
+func TestAuctionOrderbookPlaceGet(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	book, err := CreateAuctionOrderbook(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating auction orderbook: %s", err)
+	}
+	defer book.(*BoltAuctionOrderbook).DestroyHandler()
+
+	pubkey := createTestKey(t)
+	idPair := &match.AuctionOrderIDPair{
+		OrderID: match.OrderID{0x02},
+		Order: &match.AuctionOrder{
+			Side:        match.Sell,
+			TradingPair: *testPair,
+			AmountHave:  1000,
+			AmountWant:  2000,
+			AuctionID:   match.AuctionID{0x01},
+			Signature:   []byte{0x01},
+		},
+	}
+	copy(idPair.Order.Pubkey[:], pubkey.SerializeCompressed())
+
+	if err = book.UpdateBookPlace(idPair); err != nil {
+		t.Fatalf("Error placing order in book: %s", err)
+	}
+
+	got, err := book.GetOrder(&idPair.OrderID)
+	if err != nil {
+		t.Fatalf("Error getting order: %s", err)
+	}
+	if got.Order.AuctionID != idPair.Order.AuctionID || got.Order.AmountHave != idPair.Order.AmountHave {
+		t.Errorf("Stored order doesn't match placed order")
+	}
+
+	orders, err := book.GetOrdersForPubkey(pubkey)
+	if err != nil {
+		t.Fatalf("Error getting orders for pubkey: %s", err)
+	}
+	if len(orders[got.Price]) != 1 {
+		t.Errorf("Pubkey should have one order at price %f", got.Price)
+	}
+
+	if err = book.UpdateBookCancel(&match.CancelledOrder{OrderID: &idPair.OrderID}); err != nil {
+		t.Fatalf("Error cancelling order: %s", err)
+	}
+	if _, err = book.GetOrder(&idPair.OrderID); err == nil {
+		t.Errorf("Cancelled order should have been removed from the book")
+	}
+}
@@ -0,0 +1,171 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BoltAuctionOrderbook is an auction orderbook that keeps its orders in a bolt db
+type BoltAuctionOrderbook struct {
+	db *bolt.DB
+
+	// this pair
+	pair *match.Pair
+}
+
+// CreateAuctionOrderbook creates an auction orderbook for a pair, storing the orders in dataDir.
+func CreateAuctionOrderbook(pair *match.Pair, dataDir string) (book match.AuctionOrderbook, err error) {
+	ao := &BoltAuctionOrderbook{
+		pair: pair,
+	}
+	if ao.db, err = openStoreDB(dataDir, "auctionorderbook", pair.String(), ordersBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateAuctionOrderbook: %s", err)
+		return
+	}
+	book = ao
+	return
+}
+
+// UpdateBookExec takes in an order execution and updates the orderbook.
+func (ao *BoltAuctionOrderbook) UpdateBookExec(orderExec *match.OrderExecution) (err error) {
+	if err = ao.db.Update(func(tx *bolt.Tx) (err error) {
+		return updateAuctionOrderTx(tx, orderExec)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBookExec: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateBookCancel takes in an order cancellation and updates the orderbook.
+func (ao *BoltAuctionOrderbook) UpdateBookCancel(cancel *match.CancelledOrder) (err error) {
+	if err = ao.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(ordersBucket).Delete(cancel.OrderID[:])
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBookCancel: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateBookPlace takes in an order, ID, auction ID and adds the order to the orderbook.
+func (ao *BoltAuctionOrderbook) UpdateBookPlace(auctionIDPair *match.AuctionOrderIDPair) (err error) {
+	if err = ao.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(ordersBucket).Put(auctionIDPair.OrderID[:], auctionIDPair.Order.Serialize())
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBookPlace: %s", err)
+		return
+	}
+	return
+}
+
+// GetOrder gets an order from an OrderID
+func (ao *BoltAuctionOrderbook) GetOrder(orderID *match.OrderID) (aucOrder *match.AuctionOrderIDPair, err error) {
+	if err = ao.db.View(func(tx *bolt.Tx) (err error) {
+		aucOrder, err = decodeAuctionOrder(orderID[:], tx.Bucket(ordersBucket).Get(orderID[:]))
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetOrder: %s", err)
+		return
+	}
+	return
+}
+
+// CalculatePrice returns the calculated price based on the orderbook for a specific auction.
+func (ao *BoltAuctionOrderbook) CalculatePrice(auctionID *match.AuctionID) (price float64, err error) {
+	var orders []*match.AuctionOrderIDPair
+	if orders, err = ao.getOrders(auctionID); err != nil {
+		err = fmt.Errorf("Error getting orders for CalculatePrice: %s", err)
+		return
+	}
+
+	// an empty side is treated as zero, same as the sql orderbook
+	var maxSell, minBuy float64
+	var hasSell, hasBuy bool
+	for _, order := range orders {
+		if order.Order.Side == match.Sell {
+			if !hasSell || order.Price > maxSell {
+				maxSell = order.Price
+				hasSell = true
+			}
+		} else {
+			if !hasBuy || order.Price < minBuy {
+				minBuy = order.Price
+				hasBuy = true
+			}
+		}
+	}
+	price = (minBuy + maxSell) / 2
+	return
+}
+
+// GetOrdersForPubkey gets orders for a specific pubkey.
+func (ao *BoltAuctionOrderbook) GetOrdersForPubkey(pubkey *koblitz.PublicKey) (orders map[float64][]*match.AuctionOrderIDPair, err error) {
+	var allOrders []*match.AuctionOrderIDPair
+	if allOrders, err = ao.getOrders(nil); err != nil {
+		err = fmt.Errorf("Error getting orders for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	pkBytes := pubkey.SerializeCompressed()
+	orders = make(map[float64][]*match.AuctionOrderIDPair)
+	for _, order := range allOrders {
+		if bytes.Equal(order.Order.Pubkey[:], pkBytes) {
+			orders[order.Price] = append(orders[order.Price], order)
+		}
+	}
+	return
+}
+
+// ViewAuctionOrderBook returns the orderbook as a map
+func (ao *BoltAuctionOrderbook) ViewAuctionOrderBook() (book map[float64][]*match.AuctionOrderIDPair, err error) {
+	var allOrders []*match.AuctionOrderIDPair
+	if allOrders, err = ao.getOrders(nil); err != nil {
+		err = fmt.Errorf("Error getting orders for ViewAuctionOrderBook: %s", err)
+		return
+	}
+
+	book = make(map[float64][]*match.AuctionOrderIDPair)
+	for _, order := range allOrders {
+		book[order.Price] = append(book[order.Price], order)
+	}
+	return
+}
+
+// getOrders gets the orders for an auction, or every order if auctionID is nil
+func (ao *BoltAuctionOrderbook) getOrders(auctionID *match.AuctionID) (orders []*match.AuctionOrderIDPair, err error) {
+	err = ao.db.View(func(tx *bolt.Tx) (err error) {
+		orders, err = getAuctionOrdersTx(tx, auctionID)
+		return
+	})
+	return
+}
+
+// DestroyHandler closes the db, the orderbook can't be used after this
+func (ao *BoltAuctionOrderbook) DestroyHandler() (err error) {
+	if err = ao.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing auction orderbook db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateAuctionOrderbookMap creates a map of pair to auction orderbook, given a list of pairs.
+func CreateAuctionOrderbookMap(pairList []*match.Pair, dataDir string) (aucMap map[match.Pair]match.AuctionOrderbook, err error) {
+
+	aucMap = make(map[match.Pair]match.AuctionOrderbook)
+	var curAucBook match.AuctionOrderbook
+	for _, pair := range pairList {
+		if curAucBook, err = CreateAuctionOrderbook(pair, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single auction orderbook while creating auction orderbook map: %s", err)
+			return
+		}
+		aucMap[*pair] = curAucBook
+	}
+
+	return
+}
@@ -0,0 +1,64 @@
+package cxdbbolt
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/opencx/match"
+)
+
+// decodeAuctionOrder deserializes an auction order stored with (*match.AuctionOrder).Serialize
+func decodeAuctionOrder(orderIDBytes []byte, buf []byte) (idPair *match.AuctionOrderIDPair, err error) {
+	if buf == nil {
+		err = fmt.Errorf("Order %x does not exist", orderIDBytes)
+		return
+	}
+
+	// Deserialize keeps a reference to the signature, and bolt memory is only valid during the tx
+	idPair = &match.AuctionOrderIDPair{
+		Order: new(match.AuctionOrder),
+	}
+	if err = idPair.Order.Deserialize(append([]byte{}, buf...)); err != nil {
+		err = fmt.Errorf("Error deserializing stored auction order: %s", err)
+		return
+	}
+	copy(idPair.OrderID[:], orderIDBytes)
+
+	if idPair.Price, err = idPair.Order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price for stored auction order: %s", err)
+		return
+	}
+	return
+}
+
+// getAuctionOrdersTx gets every auction order in the orders bucket, if auctionID is not nil
+// then only orders for that auction are returned.
+func getAuctionOrdersTx(tx *bolt.Tx, auctionID *match.AuctionID) (orders []*match.AuctionOrderIDPair, err error) {
+	err = tx.Bucket(ordersBucket).ForEach(func(k, v []byte) (err error) {
+		var idPair *match.AuctionOrderIDPair
+		if idPair, err = decodeAuctionOrder(k, v); err != nil {
+			return
+		}
+		if auctionID == nil || idPair.Order.AuctionID == *auctionID {
+			orders = append(orders, idPair)
+		}
+		return
+	})
+	return
+}
+
+// updateAuctionOrderTx applies an order execution to a stored auction order
+func updateAuctionOrderTx(tx *bolt.Tx, exec *match.OrderExecution) (err error) {
+	bucket := tx.Bucket(ordersBucket)
+	if exec.Filled {
+		return bucket.Delete(exec.OrderID[:])
+	}
+
+	var idPair *match.AuctionOrderIDPair
+	if idPair, err = decodeAuctionOrder(exec.OrderID[:], bucket.Get(exec.OrderID[:])); err != nil {
+		return
+	}
+	idPair.Order.AmountHave = exec.NewAmountHave
+	idPair.Order.AmountWant = exec.NewAmountWant
+	return bucket.Put(exec.OrderID[:], idPair.Order.Serialize())
+}
@@ -0,0 +1,28 @@
+package cxdbbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// getBalanceTx gets the balance for a pubkey from the balances bucket. Users without a balance
+// have a balance of zero.
+func getBalanceTx(tx *bolt.Tx, pubkey []byte) (balance uint64, err error) {
+	balBytes := tx.Bucket(balancesBucket).Get(pubkey)
+	if balBytes == nil {
+		return
+	}
+	if len(balBytes) != 8 {
+		err = fmt.Errorf("Balance for %x should be 8 bytes, got %d", pubkey, len(balBytes))
+		return
+	}
+	balance = binary.BigEndian.Uint64(balBytes)
+	return
+}
+
+// putBalanceTx sets the balance for a pubkey in the balances bucket
+func putBalanceTx(tx *bolt.Tx, pubkey []byte, balance uint64) (err error) {
+	return tx.Bucket(balancesBucket).Put(pubkey, uint64Bytes(balance))
+}
@@ -0,0 +1,247 @@
+package cxdbbolt
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for cold outputs, keyed by outpoint
+	coldUtxosBucket = []byte("coldutxos")
+	// bucket for refills waiting to be signed, keyed by unsigned txid
+	refillsBucket = []byte("refills")
+)
+
+// BoltColdStore keeps the outputs paying to a coin's cold wallet in a bolt db. Outputs are gob
+// encoded and keyed by outpoint, and refills waiting to be signed are keyed by unsigned txid.
+type BoltColdStore struct {
+	db *bolt.DB
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// CreateColdStore creates a cold store for a coin, storing outputs in dataDir.
+func CreateColdStore(coin *coinparam.Params, dataDir string) (store cxdb.ColdStore, err error) {
+	cs := &BoltColdStore{
+		coin: coin,
+	}
+	if cs.db, err = openStoreDB(dataDir, "coldstore", coin.Name, coldUtxosBucket, refillsBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateColdStore: %s", err)
+		return
+	}
+	store = cs
+	return
+}
+
+// AddColdUtxos stores new outputs paying to the cold wallet
+func (cs *BoltColdStore) AddColdUtxos(utxos []*match.ColdUtxo) (err error) {
+	if err = cs.db.Update(func(tx *bolt.Tx) (err error) {
+		coldUtxos := tx.Bucket(coldUtxosBucket)
+		for _, utxo := range utxos {
+			key := []byte(utxo.OutPoint())
+			if coldUtxos.Get(key) != nil {
+				continue
+			}
+			if err = putGob(coldUtxos, key, utxo); err != nil {
+				return
+			}
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for AddColdUtxos: %s", err)
+		return
+	}
+	return
+}
+
+// SpendColdUtxos marks cold outputs as spent in the block at blockheight
+func (cs *BoltColdStore) SpendColdUtxos(outpoints []string, blockheight uint64) (err error) {
+	if err = cs.db.Update(func(tx *bolt.Tx) (err error) {
+		coldUtxos := tx.Bucket(coldUtxosBucket)
+		for _, outpoint := range outpoints {
+			var value []byte
+			if value = coldUtxos.Get([]byte(outpoint)); value == nil {
+				continue
+			}
+			utxo := new(match.ColdUtxo)
+			if err = getGob(value, utxo); err != nil {
+				return
+			}
+			if utxo.SpentHeight != 0 {
+				continue
+			}
+			utxo.SpentHeight = blockheight
+			if err = putGob(coldUtxos, []byte(outpoint), utxo); err != nil {
+				return
+			}
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for SpendColdUtxos: %s", err)
+		return
+	}
+	return
+}
+
+// DisconnectBlocks removes outputs seen above blockheight and unspends outputs spent above it
+func (cs *BoltColdStore) DisconnectBlocks(blockheight uint64) (err error) {
+	if err = cs.db.Update(func(tx *bolt.Tx) (err error) {
+		coldUtxos := tx.Bucket(coldUtxosBucket)
+		// the bucket can't be changed while we're going through it
+		var removed [][]byte
+		var unspent []*match.ColdUtxo
+		if err = coldUtxos.ForEach(func(k, v []byte) (err error) {
+			utxo := new(match.ColdUtxo)
+			if err = getGob(v, utxo); err != nil {
+				return
+			}
+			if utxo.Height > blockheight {
+				removed = append(removed, k)
+			} else if utxo.SpentHeight > blockheight {
+				utxo.SpentHeight = 0
+				unspent = append(unspent, utxo)
+			}
+			return
+		}); err != nil {
+			return
+		}
+
+		for _, k := range removed {
+			if err = coldUtxos.Delete(k); err != nil {
+				return
+			}
+		}
+		for _, utxo := range unspent {
+			if err = putGob(coldUtxos, []byte(utxo.OutPoint()), utxo); err != nil {
+				return
+			}
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for DisconnectBlocks: %s", err)
+		return
+	}
+	return
+}
+
+// GetColdUtxos gets every unspent cold output, oldest first
+func (cs *BoltColdStore) GetColdUtxos() (utxos []*match.ColdUtxo, err error) {
+	if err = cs.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(coldUtxosBucket).ForEach(func(k, v []byte) (err error) {
+			utxo := new(match.ColdUtxo)
+			if err = getGob(v, utxo); err != nil {
+				return
+			}
+			if utxo.SpentHeight == 0 {
+				utxos = append(utxos, utxo)
+			}
+			return
+		})
+	}); err != nil {
+		err = fmt.Errorf("Error for GetColdUtxos: %s", err)
+		return
+	}
+
+	// they're keyed by outpoint, not in the order they were added
+	sort.SliceStable(utxos, func(i, j int) bool {
+		if utxos[i].Height != utxos[j].Height {
+			return utxos[i].Height < utxos[j].Height
+		}
+		return utxos[i].OutPoint() < utxos[j].OutPoint()
+	})
+	return
+}
+
+// AddRefill stores a refill that's waiting to be signed
+func (cs *BoltColdStore) AddRefill(txid string, refill *match.RefillTx) (err error) {
+	var raw []byte
+	if raw, err = refill.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing refill for AddRefill: %s", err)
+		return
+	}
+	if err = cs.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(refillsBucket).Put([]byte(txid), raw)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddRefill: %s", err)
+		return
+	}
+	return
+}
+
+// GetRefill gets the refill waiting to be signed with an unsigned txid, nil if there isn't one
+func (cs *BoltColdStore) GetRefill(txid string) (refill *match.RefillTx, err error) {
+	if err = cs.db.View(func(tx *bolt.Tx) (err error) {
+		var raw []byte
+		if raw = tx.Bucket(refillsBucket).Get([]byte(txid)); raw == nil {
+			return
+		}
+		refill = new(match.RefillTx)
+		return refill.Deserialize(raw)
+	}); err != nil {
+		refill = nil
+		err = fmt.Errorf("Error for GetRefill: %s", err)
+		return
+	}
+	return
+}
+
+// GetRefills gets every refill waiting to be signed, by unsigned txid
+func (cs *BoltColdStore) GetRefills() (refills []*match.RefillTx, err error) {
+	if err = cs.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(refillsBucket).ForEach(func(k, v []byte) (err error) {
+			refill := new(match.RefillTx)
+			if err = refill.Deserialize(v); err != nil {
+				return
+			}
+			refills = append(refills, refill)
+			return
+		})
+	}); err != nil {
+		refills = nil
+		err = fmt.Errorf("Error for GetRefills: %s", err)
+		return
+	}
+	return
+}
+
+// RemoveRefill removes a refill once it's signed and sent
+func (cs *BoltColdStore) RemoveRefill(txid string) (err error) {
+	if err = cs.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(refillsBucket).Delete([]byte(txid))
+	}); err != nil {
+		err = fmt.Errorf("Error for RemoveRefill: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (cs *BoltColdStore) DestroyHandler() (err error) {
+	if err = cs.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing cold store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateColdStoreMap creates a map of coin to cold store, given a list of coins.
+func CreateColdStoreMap(coinList []*coinparam.Params, dataDir string) (coldMap map[*coinparam.Params]cxdb.ColdStore, err error) {
+
+	coldMap = make(map[*coinparam.Params]cxdb.ColdStore)
+	var curColdStore cxdb.ColdStore
+	for _, coin := range coinList {
+		if curColdStore, err = CreateColdStore(coin, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single cold store while creating cold store map: %s", err)
+			return
+		}
+		coldMap[coin] = curColdStore
+	}
+
+	return
+}
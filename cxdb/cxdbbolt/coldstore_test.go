@@ -0,0 +1,54 @@
+package cxdbbolt
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestColdStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	store, err := CreateColdStore(coin, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating cold store: %s", err)
+	}
+
+	first := &match.ColdUtxo{Txid: "firsttxid", Index: 0, Amount: 1000, PkScript: []byte{0x00, 0x14}, KeyIndex: 2, Height: 10}
+	second := &match.ColdUtxo{Txid: "secondtxid", Index: 1, Amount: 2000, Height: 12}
+	if err = store.AddColdUtxos([]*match.ColdUtxo{second, first}); err != nil {
+		t.Fatalf("Error adding cold utxos: %s", err)
+	}
+	if err = store.SpendColdUtxos([]string{first.OutPoint()}, 13); err != nil {
+		t.Fatalf("Error spending cold utxos: %s", err)
+	}
+
+	if err = store.(*BoltColdStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing cold store: %s", err)
+	}
+	if store, err = CreateColdStore(coin, dataDir); err != nil {
+		t.Fatalf("Error reopening cold store: %s", err)
+	}
+	defer store.(*BoltColdStore).DestroyHandler()
+
+	var utxos []*match.ColdUtxo
+	if utxos, err = store.GetColdUtxos(); err != nil {
+		t.Fatalf("Error getting cold utxos: %s", err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint() != second.OutPoint() {
+		t.Fatalf("Only %s should be unspent, got %d unspent", second.OutPoint(), len(utxos))
+	}
+
+	if err = store.DisconnectBlocks(11); err != nil {
+		t.Fatalf("Error disconnecting blocks: %s", err)
+	}
+	if utxos, err = store.GetColdUtxos(); err != nil {
+		t.Fatalf("Error getting cold utxos: %s", err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint() != first.OutPoint() || utxos[0].KeyIndex != 2 || utxos[0].Amount != 1000 {
+		t.Errorf("Only %s should be unspent after the reorg, got %d unspent", first.OutPoint(), len(utxos))
+	}
+}
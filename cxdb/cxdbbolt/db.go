@@ -0,0 +1,119 @@
+// Package cxdbbolt implements the cxdb and match storage interfaces using bolt, an embedded
+// key-value store. Every store gets its own file in a data directory, so the exchange can run
+// on a single machine with no database server, and keeps books and balances across restarts.
+package cxdbbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// bucket for orders, keyed by order ID
+	ordersBucket = []byte("orders")
+	// bucket for balances, keyed by pubkey
+	balancesBucket = []byte("balances")
+	// bucket for deposit addresses, keyed by pubkey
+	addressesBucket = []byte("addresses")
+	// bucket for pending deposits, keyed by expected confirm height and a sequence number
+	pendingBucket = []byte("pending")
+	// bucket for credited deposits, keyed the same way as pending deposits so a reorg can find
+	// the ones it needs to take back
+	creditedBucket = []byte("credited")
+	// bucket for deposits from blocks that were reorged out, keyed the same way as pending deposits
+	orphanedBucket = []byte("orphaned")
+	// bucket for what users owe for reorged deposits they already spent, keyed by pubkey
+	depositDebtsBucket = []byte("depositdebts")
+	// bucket for puzzles, keyed by auction ID and a sequence number
+	puzzlesBucket = []byte("puzzles")
+	// bucket for auction transcripts, keyed by auction ID
+	transcriptsBucket = []byte("transcripts")
+	// bucket for auction order statuses, keyed by receipt ID
+	auctionStatusesBucket = []byte("auctionstatuses")
+	// bucket for auction results, keyed by auction ID
+	auctionResultsBucket = []byte("auctionresults")
+)
+
+const (
+	// how long we wait for the file lock before giving up, bolt only lets one process open a file
+	defaultOpenTimeout = 1 * time.Second
+)
+
+// openStoreDB opens (or creates) the bolt file for a single store, and creates the buckets the
+// store needs. The file is named after the kind of store and the pair or coin it's for.
+func openStoreDB(dataDir string, kind string, name string, buckets ...[]byte) (db *bolt.DB, err error) {
+	if err = os.MkdirAll(dataDir, 0700); err != nil {
+		err = fmt.Errorf("Error creating data directory %s for openStoreDB: %s", dataDir, err)
+		return
+	}
+
+	dbPath := filepath.Join(dataDir, fmt.Sprintf("%s_%s.db", kind, name))
+	if db, err = bolt.Open(dbPath, 0600, &bolt.Options{Timeout: defaultOpenTimeout}); err != nil {
+		err = fmt.Errorf("Error opening bolt db at %s, is another process using it? %s", dbPath, err)
+		return
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		for _, bucket := range buckets {
+			if _, err = tx.CreateBucketIfNotExists(bucket); err != nil {
+				err = fmt.Errorf("Error creating bucket %s: %s", bucket, err)
+				return
+			}
+		}
+		return
+	}); err != nil {
+		db.Close()
+		err = fmt.Errorf("Error setting up buckets for openStoreDB: %s", err)
+		return
+	}
+	return
+}
+
+// uint64Bytes returns the big endian encoding of a uint64, big endian so keys sort by value
+func uint64Bytes(n uint64) (buf []byte) {
+	buf = make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	return
+}
+
+// sequenceKey creates a key from a prefix and the next sequence number of the bucket, so
+// entries with the same prefix are kept in insertion order.
+func sequenceKey(bucket *bolt.Bucket, prefix []byte) (key []byte, err error) {
+	var seq uint64
+	if seq, err = bucket.NextSequence(); err != nil {
+		err = fmt.Errorf("Error getting next sequence for bucket: %s", err)
+		return
+	}
+	key = append(append([]byte{}, prefix...), uint64Bytes(seq)...)
+	return
+}
+
+// putGob gob encodes a value and puts it in a bucket
+func putGob(bucket *bolt.Bucket, key []byte, value interface{}) (err error) {
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(value); err != nil {
+		err = fmt.Errorf("Error encoding entry: %s", err)
+		return
+	}
+	if err = bucket.Put(key, buf.Bytes()); err != nil {
+		err = fmt.Errorf("Error putting entry: %s", err)
+		return
+	}
+	return
+}
+
+// getGob decodes a value that was put in a bucket with putGob
+func getGob(buf []byte, value interface{}) (err error) {
+	if err = gob.NewDecoder(bytes.NewReader(buf)).Decode(value); err != nil {
+		err = fmt.Errorf("Error decoding entry: %s", err)
+		return
+	}
+	return
+}
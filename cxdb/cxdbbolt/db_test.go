@@ -0,0 +1,42 @@
+package cxdbbolt
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	litereg, _ = match.AssetFromCoinParam(&coinparam.LiteRegNetParams)
+	btcreg, _  = match.AssetFromCoinParam(&coinparam.RegressionNetParams)
+	testPair   = &match.Pair{
+		AssetWant: btcreg,
+		AssetHave: litereg,
+	}
+)
+
+// createTestDir creates a temporary data directory, and returns a func to remove it
+func createTestDir(t *testing.T) (dataDir string, cleanup func()) {
+	var err error
+	if dataDir, err = ioutil.TempDir("", "cxdbbolt"); err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	cleanup = func() {
+		os.RemoveAll(dataDir)
+	}
+	return
+}
+
+// createTestKey creates a new pubkey for a test user
+func createTestKey(t *testing.T) (pubkey *koblitz.PublicKey) {
+	priv, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	pubkey = priv.PubKey()
+	return
+}
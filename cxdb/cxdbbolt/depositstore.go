@@ -0,0 +1,387 @@
+package cxdbbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BoltDepositStore keeps deposit addresses and pending deposits for a coin in a bolt db. Credited
+// deposits are kept too, so they can be taken back if the block they were credited in is reorged,
+// and so are orphaned deposits, so users can see them.
+type BoltDepositStore struct {
+	db *bolt.DB
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// depositRecord is a pending or credited deposit, gob encoded in the pending and credited buckets
+type depositRecord struct {
+	Pubkey    [33]byte
+	Amount    uint64
+	Txid      string
+	BlockHash string
+	Height    uint64
+	Confirm   uint64
+}
+
+// CreateDepositStore creates a deposit store for a coin, storing addresses and deposits in dataDir.
+func CreateDepositStore(coin *coinparam.Params, dataDir string) (store cxdb.DepositStore, err error) {
+	ds := &BoltDepositStore{
+		coin: coin,
+	}
+	if ds.db, err = openStoreDB(dataDir, "depositstore", coin.Name, addressesBucket, pendingBucket, creditedBucket, orphanedBucket, depositDebtsBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateDepositStore: %s", err)
+		return
+	}
+	store = ds
+	return
+}
+
+// RegisterUser takes in a pubkey, and an address for the pubkey, and puts the deposit address as the
+// value for the user's pubkey key
+func (ds *BoltDepositStore) RegisterUser(pubkey *koblitz.PublicKey, address string) (err error) {
+	if err = ds.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(addressesBucket).Put(pubkey.SerializeCompressed(), []byte(address))
+	}); err != nil {
+		err = fmt.Errorf("Error for RegisterUser: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateDeposits updates the deposits when a block comes in, and returns execs for deposits that are
+// now confirmed
+func (ds *BoltDepositStore) UpdateDeposits(deposits []match.Deposit, blockheight uint64) (depositExecs []*match.SettlementExecution, err error) {
+
+	// first get debit asset
+	var depositAsset match.Asset
+	if depositAsset, err = match.AssetFromCoinParam(ds.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for UpdateDeposits: %s", err)
+		return
+	}
+
+	if err = ds.db.Update(func(tx *bolt.Tx) (err error) {
+		pending := tx.Bucket(pendingBucket)
+		credited := tx.Bucket(creditedBucket)
+
+		// First we insert these deposits, keyed by the height they'll be confirmed at
+		for _, deposit := range deposits {
+			record := depositRecord{
+				Amount:    deposit.Amount,
+				Txid:      deposit.Txid,
+				BlockHash: deposit.BlockHash,
+				Height:    deposit.BlockHeightReceived,
+				Confirm:   deposit.BlockHeightReceived + deposit.Confirmations,
+			}
+			copy(record.Pubkey[:], deposit.Pubkey.SerializeCompressed())
+
+			var key []byte
+			if key, err = sequenceKey(pending, uint64Bytes(record.Confirm)); err != nil {
+				return
+			}
+			if err = putGob(pending, key, record); err != nil {
+				err = fmt.Errorf("Error inserting deposit: %s", err)
+				return
+			}
+		}
+
+		// Now we get the ones that are confirmed by this height and move them to the credited
+		// bucket. Everything at or below the height counts, since a deposit that went back to
+		// pending in a reorg might be past its confirm height when the new chain catches up.
+		var confirmedKeys [][]byte
+		cursor := pending.Cursor()
+		for k, v := cursor.First(); k != nil && len(k) >= 8 && binary.BigEndian.Uint64(k[:8]) <= blockheight; k, v = cursor.Next() {
+			var record depositRecord
+			if err = getGob(v, &record); err != nil {
+				return
+			}
+			currSettlement := &match.SettlementExecution{
+				Pubkey: record.Pubkey,
+				Amount: record.Amount,
+				Asset:  depositAsset,
+				Type:   match.Debit,
+			}
+			depositExecs = append(depositExecs, currSettlement)
+			if err = credited.Put(k, v); err != nil {
+				err = fmt.Errorf("Error crediting confirmed deposit: %s", err)
+				return
+			}
+			confirmedKeys = append(confirmedKeys, append([]byte{}, k...))
+		}
+
+		for _, key := range confirmedKeys {
+			if err = pending.Delete(key); err != nil {
+				err = fmt.Errorf("Error removing confirmed deposit: %s", err)
+				return
+			}
+		}
+		return
+	}); err != nil {
+		depositExecs = nil
+		err = fmt.Errorf("Error for UpdateDeposits: %s", err)
+		return
+	}
+	return
+}
+
+// DisconnectBlocks rolls deposits back after a reorg, when every block above blockheight has been
+// disconnected, and returns execs taking back whatever had already been credited.
+func (ds *BoltDepositStore) DisconnectBlocks(blockheight uint64) (rollbackExecs []*match.SettlementExecution, err error) {
+
+	// first get the asset we're taking back
+	var depositAsset match.Asset
+	if depositAsset, err = match.AssetFromCoinParam(ds.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for DisconnectBlocks: %s", err)
+		return
+	}
+
+	if err = ds.db.Update(func(tx *bolt.Tx) (err error) {
+		pending := tx.Bucket(pendingBucket)
+		credited := tx.Bucket(creditedBucket)
+		orphaned := tx.Bucket(orphanedBucket)
+
+		// Credited deposits that confirm above the height weren't really confirmed, so we take
+		// them back. A deposit always confirms after its block, so this covers deposits from
+		// disconnected blocks too, which are orphaned instead of going back to pending.
+		start := uint64Bytes(blockheight + 1)
+		var uncreditedKeys [][]byte
+		cursor := credited.Cursor()
+		for k, v := cursor.Seek(start); k != nil; k, v = cursor.Next() {
+			var record depositRecord
+			if err = getGob(v, &record); err != nil {
+				return
+			}
+			// Credit is the one that takes away from a balance
+			currSettlement := &match.SettlementExecution{
+				Pubkey: record.Pubkey,
+				Amount: record.Amount,
+				Asset:  depositAsset,
+				Type:   match.Credit,
+			}
+			rollbackExecs = append(rollbackExecs, currSettlement)
+			if record.Height <= blockheight {
+				if err = pending.Put(k, v); err != nil {
+					err = fmt.Errorf("Error making deposit pending again: %s", err)
+					return
+				}
+			} else if err = orphaned.Put(k, v); err != nil {
+				err = fmt.Errorf("Error orphaning credited deposit: %s", err)
+				return
+			}
+			uncreditedKeys = append(uncreditedKeys, append([]byte{}, k...))
+		}
+
+		for _, key := range uncreditedKeys {
+			if err = credited.Delete(key); err != nil {
+				err = fmt.Errorf("Error removing credited deposit: %s", err)
+				return
+			}
+		}
+
+		// Now orphan pending deposits from the disconnected blocks
+		var disconnectedKeys [][]byte
+		cursor = pending.Cursor()
+		for k, v := cursor.Seek(start); k != nil; k, v = cursor.Next() {
+			var record depositRecord
+			if err = getGob(v, &record); err != nil {
+				return
+			}
+			if record.Height > blockheight {
+				if err = orphaned.Put(k, v); err != nil {
+					err = fmt.Errorf("Error orphaning pending deposit: %s", err)
+					return
+				}
+				disconnectedKeys = append(disconnectedKeys, append([]byte{}, k...))
+			}
+		}
+
+		for _, key := range disconnectedKeys {
+			if err = pending.Delete(key); err != nil {
+				err = fmt.Errorf("Error removing disconnected deposit: %s", err)
+				return
+			}
+		}
+		return
+	}); err != nil {
+		rollbackExecs = nil
+		err = fmt.Errorf("Error for DisconnectBlocks: %s", err)
+		return
+	}
+	return
+}
+
+// GetDeposits gets every deposit for a pubkey, oldest first, including orphaned ones
+func (ds *BoltDepositStore) GetDeposits(pubkey *koblitz.PublicKey) (deposits []*match.DepositStatus, err error) {
+	var pk [33]byte
+	copy(pk[:], pubkey.SerializeCompressed())
+
+	if err = ds.db.View(func(tx *bolt.Tx) (err error) {
+		for _, bucketState := range []struct {
+			bucket []byte
+			state  match.DepositState
+		}{
+			{pendingBucket, match.DepositPending},
+			{creditedBucket, match.DepositCredited},
+			{orphanedBucket, match.DepositOrphaned},
+		} {
+			if err = tx.Bucket(bucketState.bucket).ForEach(func(k, v []byte) (err error) {
+				var record depositRecord
+				if err = getGob(v, &record); err != nil {
+					return
+				}
+				if record.Pubkey != pk {
+					return
+				}
+				deposits = append(deposits, &match.DepositStatus{
+					Txid:                  record.Txid,
+					Amount:                record.Amount,
+					BlockHash:             record.BlockHash,
+					BlockHeight:           record.Height,
+					ConfirmationsRequired: record.Confirm - record.Height,
+					Status:                bucketState.state,
+				})
+				return
+			}); err != nil {
+				return
+			}
+		}
+		return
+	}); err != nil {
+		deposits = nil
+		err = fmt.Errorf("Error for GetDeposits: %s", err)
+		return
+	}
+
+	// the buckets are keyed by confirm height, so sort by the height they were received at
+	sort.SliceStable(deposits, func(i, j int) bool {
+		return deposits[i].BlockHeight < deposits[j].BlockHeight
+	})
+	return
+}
+
+// GetDepositAddressMap gets a map of the deposit addresses we own to pubkeys
+func (ds *BoltDepositStore) GetDepositAddressMap() (depAddrMap map[string]*koblitz.PublicKey, err error) {
+	depAddrMap = make(map[string]*koblitz.PublicKey)
+	if err = ds.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(addressesBucket).ForEach(func(k, v []byte) (err error) {
+			if depAddrMap[string(v)], err = koblitz.ParsePubKey(k, koblitz.S256()); err != nil {
+				err = fmt.Errorf("Error parsing pub key from bytes: %s", err)
+				return
+			}
+			return
+		})
+	}); err != nil {
+		err = fmt.Errorf("Error for GetDepositAddressMap: %s", err)
+		return
+	}
+	return
+}
+
+// GetDepositAddress gets the deposit address for a pubkey and an asset.
+func (ds *BoltDepositStore) GetDepositAddress(pubkey *koblitz.PublicKey) (addr string, err error) {
+	if err = ds.db.View(func(tx *bolt.Tx) (err error) {
+		addrBytes := tx.Bucket(addressesBucket).Get(pubkey.SerializeCompressed())
+		if addrBytes == nil {
+			err = fmt.Errorf("No deposit address for pubkey %x", pubkey.SerializeCompressed())
+			return
+		}
+		addr = string(addrBytes)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetDepositAddress: %s", err)
+		return
+	}
+	return
+}
+
+// AddDepositDebt adds to what a pubkey owes for deposits that were reorged out after they were spent
+func (ds *BoltDepositStore) AddDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	if err = ds.db.Update(func(tx *bolt.Tx) (err error) {
+		debts := tx.Bucket(depositDebtsBucket)
+		key := pubkey.SerializeCompressed()
+		var debt uint64
+		if value := debts.Get(key); value != nil {
+			debt = binary.BigEndian.Uint64(value)
+		}
+		if debt+amount < debt {
+			err = fmt.Errorf("Debt of %d would overflow adding %d", debt, amount)
+			return
+		}
+		return debts.Put(key, uint64Bytes(debt+amount))
+	}); err != nil {
+		err = fmt.Errorf("Error for AddDepositDebt: %s", err)
+		return
+	}
+	return
+}
+
+// PayDepositDebt takes amount off what a pubkey owes for reorged deposits
+func (ds *BoltDepositStore) PayDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	if err = ds.db.Update(func(tx *bolt.Tx) (err error) {
+		debts := tx.Bucket(depositDebtsBucket)
+		key := pubkey.SerializeCompressed()
+		var debt uint64
+		if value := debts.Get(key); value != nil {
+			debt = binary.BigEndian.Uint64(value)
+		}
+		if amount > debt {
+			err = fmt.Errorf("Can't pay %d, only %d is owed", amount, debt)
+			return
+		}
+		if debt == amount {
+			return debts.Delete(key)
+		}
+		return debts.Put(key, uint64Bytes(debt-amount))
+	}); err != nil {
+		err = fmt.Errorf("Error for PayDepositDebt: %s", err)
+		return
+	}
+	return
+}
+
+// GetDepositDebt gets what a pubkey owes for reorged deposits
+func (ds *BoltDepositStore) GetDepositDebt(pubkey *koblitz.PublicKey) (debt uint64, err error) {
+	if err = ds.db.View(func(tx *bolt.Tx) (err error) {
+		if value := tx.Bucket(depositDebtsBucket).Get(pubkey.SerializeCompressed()); value != nil {
+			debt = binary.BigEndian.Uint64(value)
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetDepositDebt: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ds *BoltDepositStore) DestroyHandler() (err error) {
+	if err = ds.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing deposit store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateDepositStoreMap creates a map of coin to deposit store, given a list of coins.
+func CreateDepositStoreMap(coinList []*coinparam.Params, dataDir string) (depositMap map[*coinparam.Params]cxdb.DepositStore, err error) {
+
+	depositMap = make(map[*coinparam.Params]cxdb.DepositStore)
+	var curDepStore cxdb.DepositStore
+	for _, coin := range coinList {
+		if curDepStore, err = CreateDepositStore(coin, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single deposit store while creating deposit store map: %s", err)
+			return
+		}
+		depositMap[coin] = curDepStore
+	}
+
+	return
+}
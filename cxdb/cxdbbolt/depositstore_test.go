@@ -0,0 +1,195 @@
+package cxdbbolt
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestDepositStorePendingSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	store, err := CreateDepositStore(coin, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating deposit store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	if err = store.RegisterUser(pubkey, "bcrt1qtestaddress"); err != nil {
+		t.Fatalf("Error registering user: %s", err)
+	}
+
+	deposit := match.Deposit{
+		Pubkey:              pubkey,
+		Address:             "bcrt1qtestaddress",
+		Amount:              5000,
+		Txid:                "testtxid",
+		CoinType:            coin,
+		BlockHeightReceived: 100,
+		Confirmations:       6,
+	}
+
+	var execs []*match.SettlementExecution
+	if execs, err = store.UpdateDeposits([]match.Deposit{deposit}, 100); err != nil {
+		t.Fatalf("Error updating deposits: %s", err)
+	}
+	if len(execs) != 0 {
+		t.Errorf("Deposit should not be confirmed yet, got %d execs", len(execs))
+	}
+
+	if err = store.(*BoltDepositStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing deposit store: %s", err)
+	}
+	if store, err = CreateDepositStore(coin, dataDir); err != nil {
+		t.Fatalf("Error reopening deposit store: %s", err)
+	}
+	defer store.(*BoltDepositStore).DestroyHandler()
+
+	var addr string
+	if addr, err = store.GetDepositAddress(pubkey); err != nil {
+		t.Fatalf("Error getting deposit address after restart: %s", err)
+	}
+	if addr != deposit.Address {
+		t.Errorf("Deposit address should be %s, got %s", deposit.Address, addr)
+	}
+
+	if execs, err = store.UpdateDeposits(nil, 106); err != nil {
+		t.Fatalf("Error updating deposits at confirm height: %s", err)
+	}
+	if len(execs) != 1 || execs[0].Amount != deposit.Amount || execs[0].Type != match.Debit {
+		t.Fatalf("Expected a single debit of %d at confirm height, got %v", deposit.Amount, execs)
+	}
+
+	// the deposit was credited, so it shouldn't be credited again
+	if execs, err = store.UpdateDeposits(nil, 106); err != nil {
+		t.Fatalf("Error updating deposits a second time: %s", err)
+	}
+	if len(execs) != 0 {
+		t.Errorf("Deposit should only be credited once, got %d execs", len(execs))
+	}
+}
+
+func TestDepositStoreReorgSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	store, err := CreateDepositStore(coin, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating deposit store: %s", err)
+	}
+
+	confirmed := match.Deposit{
+		Pubkey:              createTestKey(t),
+		Address:             "bcrt1qtestaddress",
+		Amount:              5000,
+		Txid:                "confirmedtx",
+		CoinType:            coin,
+		BlockHeightReceived: 100,
+		BlockHash:           "block100",
+		Confirmations:       6,
+	}
+	pending := confirmed
+	pending.Amount = 2000
+	pending.Txid = "pendingtx"
+	pending.BlockHeightReceived = 104
+	pending.BlockHash = "block104"
+
+	if _, err = store.UpdateDeposits([]match.Deposit{confirmed}, 100); err != nil {
+		t.Fatalf("Error updating deposits: %s", err)
+	}
+	if _, err = store.UpdateDeposits([]match.Deposit{pending}, 104); err != nil {
+		t.Fatalf("Error updating deposits: %s", err)
+	}
+	var execs []*match.SettlementExecution
+	if execs, err = store.UpdateDeposits(nil, 106); err != nil {
+		t.Fatalf("Error updating deposits at confirm height: %s", err)
+	}
+	if len(execs) != 1 || execs[0].Amount != confirmed.Amount {
+		t.Fatalf("Expected the first deposit to be credited, got %v", execs)
+	}
+
+	// blocks 104 and up are reorged out
+	var rollbacks []*match.SettlementExecution
+	if rollbacks, err = store.DisconnectBlocks(103); err != nil {
+		t.Fatalf("Error disconnecting blocks: %s", err)
+	}
+	if len(rollbacks) != 1 || rollbacks[0].Amount != confirmed.Amount || rollbacks[0].Type != match.Credit {
+		t.Fatalf("Expected a single credit taking back the first deposit, got %v", rollbacks)
+	}
+
+	if err = store.(*BoltDepositStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing deposit store: %s", err)
+	}
+	if store, err = CreateDepositStore(coin, dataDir); err != nil {
+		t.Fatalf("Error reopening deposit store: %s", err)
+	}
+	defer store.(*BoltDepositStore).DestroyHandler()
+
+	// the first deposit is pending again, and the second one is gone with its block
+	if execs, err = store.UpdateDeposits(nil, 112); err != nil {
+		t.Fatalf("Error updating deposits on the new chain: %s", err)
+	}
+	if len(execs) != 1 || execs[0].Amount != confirmed.Amount {
+		t.Errorf("Expected only the first deposit to be credited on the new chain, got %v", execs)
+	}
+
+	var deposits []*match.DepositStatus
+	if deposits, err = store.GetDeposits(confirmed.Pubkey); err != nil {
+		t.Fatalf("Error getting deposits: %s", err)
+	}
+	if len(deposits) != 2 || deposits[0].Status != match.DepositCredited || deposits[1].Status != match.DepositOrphaned {
+		t.Fatalf("Expected a credited deposit and an orphaned one, got %d deposits", len(deposits))
+	}
+	if deposits[1].Txid != pending.Txid || deposits[1].BlockHash != pending.BlockHash {
+		t.Errorf("Orphaned deposit should be %s in %s, got %s in %s", pending.Txid, pending.BlockHash, deposits[1].Txid, deposits[1].BlockHash)
+	}
+}
+
+func TestDepositStoreDebtSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	store, err := CreateDepositStore(coin, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating deposit store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	if err = store.AddDepositDebt(pubkey, 3000); err != nil {
+		t.Fatalf("Error adding debt: %s", err)
+	}
+	if err = store.AddDepositDebt(pubkey, 2000); err != nil {
+		t.Fatalf("Error adding more debt: %s", err)
+	}
+
+	if err = store.(*BoltDepositStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing deposit store: %s", err)
+	}
+	if store, err = CreateDepositStore(coin, dataDir); err != nil {
+		t.Fatalf("Error reopening deposit store: %s", err)
+	}
+	defer store.(*BoltDepositStore).DestroyHandler()
+
+	var debt uint64
+	if debt, err = store.GetDepositDebt(pubkey); err != nil {
+		t.Fatalf("Error getting debt after restart: %s", err)
+	}
+	if debt != 5000 {
+		t.Fatalf("Debt should be 5000 after restart, got %d", debt)
+	}
+
+	if err = store.PayDepositDebt(pubkey, 6000); err == nil {
+		t.Errorf("Paying more than is owed should fail")
+	}
+	if err = store.PayDepositDebt(pubkey, 5000); err != nil {
+		t.Fatalf("Error paying debt: %s", err)
+	}
+	if debt, err = store.GetDepositDebt(pubkey); err != nil || debt != 0 {
+		t.Errorf("Debt should be paid off, got %d: %v", debt, err)
+	}
+}
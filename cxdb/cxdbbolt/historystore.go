@@ -0,0 +1,245 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for order history, keyed by pubkey and seq so a pubkey's orders are together
+	historyOrdersBucket = []byte("historyorders")
+	// bucket for the key of each order in the order history, keyed by order ID
+	historyOrderKeysBucket = []byte("historyorderkeys")
+	// bucket for fill history, keyed by pubkey and seq
+	historyFillsBucket = []byte("historyfills")
+)
+
+// BoltHistoryStore keeps order and fill history for every pair in a bolt db. Entries are gob
+// encoded, and keyed by pubkey and seq so a page of history for a pubkey is a range scan.
+type BoltHistoryStore struct {
+	db *bolt.DB
+}
+
+// CreateHistoryStore creates a history store, storing history in dataDir.
+func CreateHistoryStore(dataDir string) (store cxdb.HistoryStore, err error) {
+	hs := new(BoltHistoryStore)
+	if hs.db, err = openStoreDB(dataDir, "historystore", "all", historyOrdersBucket, historyOrderKeysBucket, historyFillsBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateHistoryStore: %s", err)
+		return
+	}
+	store = hs
+	return
+}
+
+// historyKey creates the key for a history entry from its pubkey and seq
+func historyKey(pubkey []byte, seq uint64) (key []byte) {
+	key = append(append([]byte{}, pubkey...), uint64Bytes(seq)...)
+	return
+}
+
+// getHistoryOrderTx gets an order from the history, along with the key it's stored under
+func getHistoryOrderTx(tx *bolt.Tx, orderID *match.OrderID) (entry *match.OrderHistoryEntry, key []byte, err error) {
+	if key = tx.Bucket(historyOrderKeysBucket).Get(orderID[:]); key == nil {
+		err = fmt.Errorf("Order %x not in history", orderID[:])
+		return
+	}
+	// bolt values are only valid for the transaction, and we use the key to put the entry back
+	key = append([]byte{}, key...)
+
+	entry = new(match.OrderHistoryEntry)
+	if err = getGob(tx.Bucket(historyOrdersBucket).Get(key), entry); err != nil {
+		return
+	}
+	return
+}
+
+// RecordPlace adds a newly placed order to the history
+func (hs *BoltHistoryStore) RecordPlace(limitIDPair *match.LimitOrderIDPair) (err error) {
+	if err = hs.db.Update(func(tx *bolt.Tx) (err error) {
+		keys := tx.Bucket(historyOrderKeysBucket)
+		if keys.Get(limitIDPair.OrderID[:]) != nil {
+			err = fmt.Errorf("Order %x is already in the history", limitIDPair.OrderID[:])
+			return
+		}
+
+		orders := tx.Bucket(historyOrdersBucket)
+		entry := match.NewOrderHistoryEntry(limitIDPair)
+		if entry.Seq, err = orders.NextSequence(); err != nil {
+			return
+		}
+		key := historyKey(entry.Order.Pubkey[:], entry.Seq)
+		if err = putGob(orders, key, entry); err != nil {
+			return
+		}
+		err = keys.Put(entry.OrderID[:], key)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for RecordPlace: %s", err)
+		return
+	}
+	return
+}
+
+// RecordExec updates an order with an execution and adds the fill to the history
+func (hs *BoltHistoryStore) RecordExec(orderExec *match.OrderExecution, execTime time.Time) (err error) {
+	if err = hs.db.Update(func(tx *bolt.Tx) (err error) {
+		var entry *match.OrderHistoryEntry
+		var key []byte
+		if entry, key, err = getHistoryOrderTx(tx, &orderExec.OrderID); err != nil {
+			return
+		}
+
+		fill := entry.ApplyExec(orderExec, execTime)
+		if err = putGob(tx.Bucket(historyOrdersBucket), key, entry); err != nil {
+			return
+		}
+
+		fills := tx.Bucket(historyFillsBucket)
+		if fill.Seq, err = fills.NextSequence(); err != nil {
+			return
+		}
+		err = putGob(fills, historyKey(fill.Pubkey[:], fill.Seq), fill)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for RecordExec: %s", err)
+		return
+	}
+	return
+}
+
+// RecordCancel marks an order as cancelled
+func (hs *BoltHistoryStore) RecordCancel(cancel *match.CancelledOrder, cancelTime time.Time) (err error) {
+	if err = hs.db.Update(func(tx *bolt.Tx) (err error) {
+		var entry *match.OrderHistoryEntry
+		var key []byte
+		if entry, key, err = getHistoryOrderTx(tx, cancel.OrderID); err != nil {
+			return
+		}
+		entry.ApplyCancel(cancelTime)
+		err = putGob(tx.Bucket(historyOrdersBucket), key, entry)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for RecordCancel: %s", err)
+		return
+	}
+	return
+}
+
+// RecordExpire marks an order as expired
+func (hs *BoltHistoryStore) RecordExpire(orderID *match.OrderID, expireTime time.Time) (err error) {
+	if err = hs.db.Update(func(tx *bolt.Tx) (err error) {
+		var entry *match.OrderHistoryEntry
+		var key []byte
+		if entry, key, err = getHistoryOrderTx(tx, orderID); err != nil {
+			return
+		}
+		entry.Status = match.OrderExpired
+		entry.Updated = expireTime
+		err = putGob(tx.Bucket(historyOrdersBucket), key, entry)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for RecordExpire: %s", err)
+		return
+	}
+	return
+}
+
+// scanHistory calls fn on the values for a pubkey in a history bucket, newest first, starting
+// before the cursor. It stops when fn returns false or an error.
+func scanHistory(bucket *bolt.Bucket, pkBytes []byte, cursor uint64, fn func(value []byte) (bool, error)) (err error) {
+	c := bucket.Cursor()
+
+	// seek to the first key after the ones we want, then go backwards
+	start := historyKey(pkBytes, cursor)
+	if cursor == 0 {
+		start = historyKey(pkBytes, ^uint64(0))
+	}
+	k, v := c.Seek(start)
+	if k == nil {
+		k, v = c.Last()
+	}
+	if k != nil && bytes.Compare(k, start) >= 0 {
+		k, v = c.Prev()
+	}
+
+	var more bool
+	for ; k != nil && bytes.HasPrefix(k, pkBytes); k, v = c.Prev() {
+		if more, err = fn(v); err != nil || !more {
+			return
+		}
+	}
+	return
+}
+
+// GetOrderHistory gets orders for a pubkey, newest first, along with the cursor for the next page.
+func (hs *BoltHistoryStore) GetOrderHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error) {
+	limit := query.PageLimit()
+	if err = hs.db.View(func(tx *bolt.Tx) (err error) {
+		err = scanHistory(tx.Bucket(historyOrdersBucket), pubkey.SerializeCompressed(), query.Cursor, func(value []byte) (more bool, err error) {
+			entry := new(match.OrderHistoryEntry)
+			if err = getGob(value, entry); err != nil {
+				return
+			}
+			if !query.MatchesOrder(entry) {
+				more = true
+				return
+			}
+			if uint64(len(orders)) == limit {
+				nextCursor = orders[len(orders)-1].Seq
+				return
+			}
+			orders = append(orders, entry)
+			more = true
+			return
+		})
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetOrderHistory: %s", err)
+		return
+	}
+	return
+}
+
+// GetFillHistory gets fills for a pubkey, newest first, along with the cursor for the next page.
+func (hs *BoltHistoryStore) GetFillHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error) {
+	limit := query.PageLimit()
+	if err = hs.db.View(func(tx *bolt.Tx) (err error) {
+		err = scanHistory(tx.Bucket(historyFillsBucket), pubkey.SerializeCompressed(), query.Cursor, func(value []byte) (more bool, err error) {
+			fill := new(match.FillEntry)
+			if err = getGob(value, fill); err != nil {
+				return
+			}
+			if !query.MatchesFill(fill) {
+				more = true
+				return
+			}
+			if uint64(len(fills)) == limit {
+				nextCursor = fills[len(fills)-1].Seq
+				return
+			}
+			fills = append(fills, fill)
+			more = true
+			return
+		})
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetFillHistory: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (hs *BoltHistoryStore) DestroyHandler() (err error) {
+	if err = hs.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing history store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestHistoryStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateHistoryStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating history store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	otherPubkey := createTestKey(t)
+	start := time.Unix(1500000000, 0)
+
+	// interleave another pubkey's orders so pages have to skip over them
+	var orderIDs []match.OrderID
+	for i := 0; i < 3; i++ {
+		for j, pk := range [][]byte{pubkey.SerializeCompressed(), otherPubkey.SerializeCompressed()} {
+			order := &match.LimitOrder{
+				Side:        match.Sell,
+				TradingPair: *testPair,
+				AmountHave:  1000,
+				AmountWant:  1000,
+			}
+			copy(order.Pubkey[:], pk)
+			idPair := &match.LimitOrderIDPair{
+				OrderID:   &match.OrderID{byte(i), byte(j)},
+				Order:     order,
+				Price:     1,
+				Timestamp: start.Add(time.Duration(i) * time.Minute),
+			}
+			if err = store.RecordPlace(idPair); err != nil {
+				t.Fatalf("Error recording place: %s", err)
+			}
+			if j == 0 {
+				orderIDs = append(orderIDs, *idPair.OrderID)
+			}
+		}
+	}
+
+	if err = store.RecordExec(&match.OrderExecution{OrderID: orderIDs[0], NewAmountHave: 250, NewAmountWant: 250}, start.Add(time.Hour)); err != nil {
+		t.Fatalf("Error recording exec: %s", err)
+	}
+	if err = store.RecordExpire(&orderIDs[1], start.Add(time.Hour)); err != nil {
+		t.Fatalf("Error recording expire: %s", err)
+	}
+
+	if err = store.(*BoltHistoryStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing history store: %s", err)
+	}
+	if store, err = CreateHistoryStore(dataDir); err != nil {
+		t.Fatalf("Error reopening history store: %s", err)
+	}
+	defer store.(*BoltHistoryStore).DestroyHandler()
+
+	var orders []*match.OrderHistoryEntry
+	query := &match.HistoryQuery{Limit: 2}
+	for {
+		var page []*match.OrderHistoryEntry
+		if page, query.Cursor, err = store.GetOrderHistory(pubkey, query); err != nil {
+			t.Fatalf("Error getting order history: %s", err)
+		}
+		orders = append(orders, page...)
+		if query.Cursor == 0 {
+			break
+		}
+	}
+
+	if len(orders) != 3 {
+		t.Fatalf("Should have 3 orders for pubkey, got %d", len(orders))
+	}
+	for i, entry := range orders {
+		if entry.OrderID != orderIDs[2-i] {
+			t.Errorf("Orders should be newest first, got %x at %d", entry.OrderID, i)
+		}
+	}
+	if orders[1].Status != match.OrderExpired {
+		t.Errorf("Second order should be expired, got %s", orders[1].Status)
+	}
+	if orders[2].Status != match.OrderPartiallyFilled || orders[2].AmountHaveFilled != 750 {
+		t.Errorf("First order should be partially filled with 750, got %s with %d", orders[2].Status, orders[2].AmountHaveFilled)
+	}
+
+	var fills []*match.FillEntry
+	if fills, _, err = store.GetFillHistory(pubkey, &match.HistoryQuery{}); err != nil {
+		t.Fatalf("Error getting fill history: %s", err)
+	}
+	if len(fills) != 1 || fills[0].AmountHave != 750 || fills[0].Side != match.Sell {
+		t.Errorf("Should have one sell fill of 750, got %d fills", len(fills))
+	}
+	if fills, _, err = store.GetFillHistory(otherPubkey, &match.HistoryQuery{}); err != nil || len(fills) != 0 {
+		t.Errorf("Other pubkey should have no fills, got %d, err %v", len(fills), err)
+	}
+}
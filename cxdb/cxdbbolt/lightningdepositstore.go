@@ -0,0 +1,239 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for lightning deposits, keyed by seq so they're kept oldest first
+	lightningDepositsBucket = []byte("lightningdeposits")
+	// bucket for the channel states that have been credited, keyed by outpoint and state index
+	lightningDepositKeysBucket = []byte("lightningdepositkeys")
+	// bucket for invoices, keyed by seq so they're kept oldest first
+	invoicesBucket = []byte("invoices")
+	// bucket for the key of each invoice, keyed by hash
+	invoiceKeysBucket = []byte("invoicekeys")
+)
+
+// BoltLightningDepositStore keeps lightning deposits and invoices for every coin in a bolt db.
+// Deposits and invoices are gob encoded.
+type BoltLightningDepositStore struct {
+	db *bolt.DB
+}
+
+// CreateLightningDepositStore creates a lightning deposit store, storing deposits in dataDir.
+func CreateLightningDepositStore(dataDir string) (store cxdb.LightningDepositStore, err error) {
+	ls := new(BoltLightningDepositStore)
+	if ls.db, err = openStoreDB(dataDir, "lightningdepositstore", "all", lightningDepositsBucket, lightningDepositKeysBucket, invoicesBucket, invoiceKeysBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateLightningDepositStore: %s", err)
+		return
+	}
+	store = ls
+	return
+}
+
+// lightningDepositKey is the outpoint followed by the big endian state index
+func lightningDepositKey(deposit *match.LightningDeposit) (key []byte) {
+	key = make([]byte, len(deposit.Outpoint)+8)
+	copy(key, deposit.Outpoint)
+	binary.BigEndian.PutUint64(key[len(deposit.Outpoint):], deposit.StateIdx)
+	return
+}
+
+// AddLightningDeposit stores a deposit, added is false if there's already one for the channel and
+// state
+func (ls *BoltLightningDepositStore) AddLightningDeposit(deposit *match.LightningDeposit) (added bool, err error) {
+	if err = ls.db.Update(func(tx *bolt.Tx) (err error) {
+		depositKeys := tx.Bucket(lightningDepositKeysBucket)
+		depositKey := lightningDepositKey(deposit)
+		if depositKeys.Get(depositKey) != nil {
+			return
+		}
+		var key []byte
+		if key, err = sequenceKey(tx.Bucket(lightningDepositsBucket), nil); err != nil {
+			return
+		}
+		if err = depositKeys.Put(depositKey, key); err != nil {
+			err = fmt.Errorf("Error putting lightning deposit key: %s", err)
+			return
+		}
+		if err = putGob(tx.Bucket(lightningDepositsBucket), key, deposit); err != nil {
+			return
+		}
+		added = true
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for AddLightningDeposit: %s", err)
+		added = false
+		return
+	}
+	return
+}
+
+// GetLightningDeposits gets every deposit for a pubkey, oldest first
+func (ls *BoltLightningDepositStore) GetLightningDeposits(pubkey *koblitz.PublicKey) (deposits []*match.LightningDeposit, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if deposits, err = ls.filterDeposits(func(deposit *match.LightningDeposit) bool {
+		return bytes.Equal(deposit.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetLightningDeposits: %s", err)
+		return
+	}
+	return
+}
+
+// GetChannelDeposits gets every deposit over a channel, oldest first
+func (ls *BoltLightningDepositStore) GetChannelDeposits(outpoint string) (deposits []*match.LightningDeposit, err error) {
+	if deposits, err = ls.filterDeposits(func(deposit *match.LightningDeposit) bool {
+		return deposit.Outpoint == outpoint
+	}); err != nil {
+		err = fmt.Errorf("Error for GetChannelDeposits: %s", err)
+		return
+	}
+	return
+}
+
+// filterDeposits returns the deposits that keep returns true for, oldest first
+func (ls *BoltLightningDepositStore) filterDeposits(keep func(*match.LightningDeposit) bool) (deposits []*match.LightningDeposit, err error) {
+	err = ls.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(lightningDepositsBucket).ForEach(func(k, v []byte) (err error) {
+			deposit := new(match.LightningDeposit)
+			if err = getGob(v, deposit); err != nil {
+				return
+			}
+			if keep(deposit) {
+				deposits = append(deposits, deposit)
+			}
+			return
+		})
+	})
+	return
+}
+
+// AddInvoice stores a new invoice
+func (ls *BoltLightningDepositStore) AddInvoice(invoice *match.Invoice) (err error) {
+	if err = ls.db.Update(func(tx *bolt.Tx) (err error) {
+		invoiceKeys := tx.Bucket(invoiceKeysBucket)
+		if invoiceKeys.Get(invoice.RHash[:]) != nil {
+			err = fmt.Errorf("Invoice %s already exists", invoice.ID())
+			return
+		}
+		var key []byte
+		if key, err = sequenceKey(tx.Bucket(invoicesBucket), nil); err != nil {
+			return
+		}
+		if err = invoiceKeys.Put(invoice.RHash[:], key); err != nil {
+			err = fmt.Errorf("Error putting invoice key: %s", err)
+			return
+		}
+		return putGob(tx.Bucket(invoicesBucket), key, invoice)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddInvoice: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateInvoice saves the amount paid, state, reason and update time of a stored invoice
+func (ls *BoltLightningDepositStore) UpdateInvoice(invoice *match.Invoice) (err error) {
+	if err = ls.db.Update(func(tx *bolt.Tx) (err error) {
+		var stored *match.Invoice
+		var key []byte
+		if stored, key, err = getInvoiceTx(tx, invoice.RHash); err != nil {
+			return
+		}
+		stored.AmountPaid = invoice.AmountPaid
+		stored.State = invoice.State
+		stored.Reason = invoice.Reason
+		stored.Updated = invoice.Updated
+		return putGob(tx.Bucket(invoicesBucket), key, stored)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateInvoice: %s", err)
+		return
+	}
+	return
+}
+
+// GetInvoice gets an invoice by its hash
+func (ls *BoltLightningDepositStore) GetInvoice(rhash [32]byte) (invoice *match.Invoice, err error) {
+	if err = ls.db.View(func(tx *bolt.Tx) (err error) {
+		invoice, _, err = getInvoiceTx(tx, rhash)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetInvoice: %s", err)
+		return
+	}
+	return
+}
+
+// getInvoiceTx gets an invoice by its hash, along with the key it's stored under
+func getInvoiceTx(tx *bolt.Tx, rhash [32]byte) (invoice *match.Invoice, key []byte, err error) {
+	if key = tx.Bucket(invoiceKeysBucket).Get(rhash[:]); key == nil {
+		err = fmt.Errorf("No invoice %x", rhash)
+		return
+	}
+	// bolt values are only valid for the transaction, and we use the key to put the invoice back
+	key = append([]byte{}, key...)
+
+	invoice = new(match.Invoice)
+	if err = getGob(tx.Bucket(invoicesBucket).Get(key), invoice); err != nil {
+		return
+	}
+	return
+}
+
+// GetInvoices gets every invoice for a pubkey, oldest first
+func (ls *BoltLightningDepositStore) GetInvoices(pubkey *koblitz.PublicKey) (invoices []*match.Invoice, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if invoices, err = ls.filterInvoices(func(invoice *match.Invoice) bool {
+		return bytes.Equal(invoice.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetInvoices: %s", err)
+		return
+	}
+	return
+}
+
+// GetInvoicesByState gets every invoice in a state, oldest first
+func (ls *BoltLightningDepositStore) GetInvoicesByState(state match.InvoiceState) (invoices []*match.Invoice, err error) {
+	if invoices, err = ls.filterInvoices(func(invoice *match.Invoice) bool {
+		return invoice.State == state
+	}); err != nil {
+		err = fmt.Errorf("Error for GetInvoicesByState: %s", err)
+		return
+	}
+	return
+}
+
+// filterInvoices returns the invoices that keep returns true for, oldest first
+func (ls *BoltLightningDepositStore) filterInvoices(keep func(*match.Invoice) bool) (invoices []*match.Invoice, err error) {
+	err = ls.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(invoicesBucket).ForEach(func(k, v []byte) (err error) {
+			invoice := new(match.Invoice)
+			if err = getGob(v, invoice); err != nil {
+				return
+			}
+			if keep(invoice) {
+				invoices = append(invoices, invoice)
+			}
+			return
+		})
+	})
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ls *BoltLightningDepositStore) DestroyHandler() (err error) {
+	if err = ls.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing lightning deposit store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestLightningDepositStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateLightningDepositStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating lightning deposit store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	deposit := &match.LightningDeposit{Asset: match.BTCTest, Amount: 5000, Outpoint: "ab:0", StateIdx: 1, ChannelAmount: 5000, Received: time.Unix(1500000000, 0)}
+	copy(deposit.Pubkey[:], pubkey.SerializeCompressed())
+	var added bool
+	if added, err = store.AddLightningDeposit(deposit); err != nil || !added {
+		t.Fatalf("First deposit should be added, got %t, %v", added, err)
+	}
+
+	if err = store.(*BoltLightningDepositStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing lightning deposit store: %s", err)
+	}
+	if store, err = CreateLightningDepositStore(dataDir); err != nil {
+		t.Fatalf("Error reopening lightning deposit store: %s", err)
+	}
+	defer store.(*BoltLightningDepositStore).DestroyHandler()
+
+	// the channel state was credited before the restart, so seeing it again doesn't add it
+	if added, err = store.AddLightningDeposit(deposit); err != nil || added {
+		t.Errorf("Replayed deposit shouldn't be added after restart, got %t, %v", added, err)
+	}
+	next := *deposit
+	next.StateIdx = 2
+	next.Amount = 1000
+	if added, err = store.AddLightningDeposit(&next); err != nil || !added {
+		t.Errorf("Deposit in the next state should be added, got %t, %v", added, err)
+	}
+
+	var deposits []*match.LightningDeposit
+	if deposits, err = store.GetChannelDeposits("ab:0"); err != nil {
+		t.Fatalf("Error getting channel deposits: %s", err)
+	}
+	if len(deposits) != 2 || deposits[0].Amount != 5000 || deposits[1].Amount != 1000 || !deposits[0].Received.Equal(deposit.Received) {
+		t.Errorf("Channel should have both deposits in order, got %d", len(deposits))
+	}
+	if deposits, err = store.GetLightningDeposits(pubkey); err != nil || len(deposits) != 2 {
+		t.Errorf("Pubkey should have 2 deposits, got %d, %v", len(deposits), err)
+	}
+}
+
+func TestLightningDepositStoreInvoicesSurviveRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateLightningDepositStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating lightning deposit store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+	start := time.Unix(1500000000, 0)
+	request := &match.InvoiceRequest{Asset: match.BTCTest, Amount: 5000, Expiry: start.Add(time.Hour).Unix()}
+	var invoice *match.Invoice
+	if invoice, err = match.NewInvoice(request, pkBytes, "ln1exchange", start); err != nil {
+		t.Fatalf("Error creating invoice: %s", err)
+	}
+	if err = store.AddInvoice(invoice); err != nil {
+		t.Fatalf("Error adding invoice: %s", err)
+	}
+
+	if err = store.(*BoltLightningDepositStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing lightning deposit store: %s", err)
+	}
+	if store, err = CreateLightningDepositStore(dataDir); err != nil {
+		t.Fatalf("Error reopening lightning deposit store: %s", err)
+	}
+	defer store.(*BoltLightningDepositStore).DestroyHandler()
+
+	if err = store.AddInvoice(invoice); err == nil {
+		t.Errorf("Adding an invoice twice should fail after restart")
+	}
+	invoice.SetState(match.InvoiceExpired, "not paid in time", start.Add(time.Hour))
+	if err = store.UpdateInvoice(invoice); err != nil {
+		t.Fatalf("Error updating invoice: %s", err)
+	}
+
+	var invoices []*match.Invoice
+	if invoices, err = store.GetInvoicesByState(match.InvoiceExpired); err != nil {
+		t.Fatalf("Error getting expired invoices: %s", err)
+	}
+	if len(invoices) != 1 || invoices[0].Preimage != invoice.Preimage || invoices[0].Reason != "not paid in time" || !invoices[0].Expiry.Equal(invoice.Expiry) {
+		t.Errorf("Invoice should be expired with its preimage, got %d", len(invoices))
+	}
+	if invoices, err = store.GetInvoices(pubkey); err != nil || len(invoices) != 1 {
+		t.Errorf("Pubkey should have 1 invoice, got %d, %v", len(invoices), err)
+	}
+}
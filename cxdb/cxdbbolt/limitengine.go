@@ -0,0 +1,225 @@
+package cxdbbolt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/crypto/sha3"
+)
+
+// BoltLimitEngine is a limit matching engine that keeps its orders in a bolt db
+type BoltLimitEngine struct {
+	db *bolt.DB
+
+	// this pair
+	pair *match.Pair
+}
+
+// CreateLimitEngine creates a limit engine for a pair, storing the orders in dataDir.
+func CreateLimitEngine(pair *match.Pair, dataDir string) (engine match.LimitEngine, err error) {
+	le := &BoltLimitEngine{
+		pair: pair,
+	}
+	if le.db, err = openStoreDB(dataDir, "limitengine", pair.String(), ordersBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateLimitEngine: %s", err)
+		return
+	}
+	engine = le
+	return
+}
+
+// PlaceLimitOrder places an order in the limit matching engine.
+// This assumes that the order is valid and is for the same pair as the matching engine
+func (le *BoltLimitEngine) PlaceLimitOrder(order *match.LimitOrder) (idRes *match.LimitOrderIDPair, err error) {
+	return le.PlaceLimitOrderAt(order, time.Now())
+}
+
+// PlaceLimitOrderAt places an order in the limit matching engine as if it were placed at
+// placementTime. The order ID depends on the order, the time, and how many orders were placed
+// before it, so identical orders placed at the same time still get different IDs.
+func (le *BoltLimitEngine) PlaceLimitOrderAt(order *match.LimitOrder, placementTime time.Time) (idRes *match.LimitOrderIDPair, err error) {
+	if order == nil {
+		err = fmt.Errorf("Cannot place nil order, please enter valid input")
+		return
+	}
+
+	// calculate price, this also makes sure neither amount is zero
+	var price float64
+	if price, err = order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price from order while placing order: %s", err)
+		return
+	}
+
+	record := encodeLimitOrder(order, placementTime)
+
+	idRes = &match.LimitOrderIDPair{
+		OrderID:   new(match.OrderID),
+		Order:     order,
+		Price:     price,
+		Timestamp: placementTime,
+	}
+
+	if err = le.db.Update(func(tx *bolt.Tx) (err error) {
+		orders := tx.Bucket(ordersBucket)
+
+		// the placement number keeps two identical orders placed at the same time from
+		// getting the same ID and overwriting each other
+		var placed uint64
+		if placed, err = orders.NextSequence(); err != nil {
+			err = fmt.Errorf("Error getting placement number: %s", err)
+			return
+		}
+
+		// hash the pair, the record, and the placement number so we can use that as the order ID
+		hasher := sha3.New256()
+		hasher.Write(le.pair.Serialize())
+		hasher.Write(record)
+		hasher.Write(uint64Bytes(placed))
+		copy(idRes.OrderID[:], hasher.Sum(nil))
+
+		if orders.Get(idRes.OrderID[:]) != nil {
+			err = fmt.Errorf("Order %x already exists", idRes.OrderID[:])
+			return
+		}
+		return orders.Put(idRes.OrderID[:], record)
+	}); err != nil {
+		idRes = nil
+		err = fmt.Errorf("Error placing order into db for PlaceLimitOrder: %s", err)
+		return
+	}
+	return
+}
+
+// CancelLimitOrder cancels a limit order, this assumes that the limit order actually exists
+func (le *BoltLimitEngine) CancelLimitOrder(orderID *match.OrderID) (cancelled *match.CancelledOrder, cancelSettlement *match.SettlementExecution, err error) {
+	if err = le.db.Update(func(tx *bolt.Tx) (err error) {
+		orders := tx.Bucket(ordersBucket)
+
+		var idPair *match.LimitOrderIDPair
+		if idPair, err = decodeLimitOrder(orderID[:], orders.Get(orderID[:]), le.pair); err != nil {
+			err = fmt.Errorf("Could not find order %x: %s", orderID[:], err)
+			return
+		}
+
+		if err = orders.Delete(orderID[:]); err != nil {
+			err = fmt.Errorf("Error deleting order: %s", err)
+			return
+		}
+
+		var debitAsset match.Asset
+		if idPair.Order.Side == match.Buy {
+			debitAsset = le.pair.AssetHave
+		} else {
+			debitAsset = le.pair.AssetWant
+		}
+		cancelled = &match.CancelledOrder{
+			OrderID: orderID,
+		}
+		cancelSettlement = &match.SettlementExecution{
+			Pubkey: idPair.Order.Pubkey,
+			Amount: idPair.Order.AmountHave,
+			Asset:  debitAsset,
+			Type:   match.Debit,
+		}
+		return
+	}); err != nil {
+		cancelled = nil
+		cancelSettlement = nil
+		err = fmt.Errorf("Error for CancelLimitOrder: %s", err)
+		return
+	}
+	return
+}
+
+// MatchLimitOrders matches limit orders based on price/time priority
+func (le *BoltLimitEngine) MatchLimitOrders() (orderExecs []*match.OrderExecution, settlementExecs []*match.SettlementExecution, err error) {
+	if err = le.db.Update(func(tx *bolt.Tx) (err error) {
+		var orders []*match.LimitOrderIDPair
+		if orders, err = getLimitOrdersTx(tx, le.pair); err != nil {
+			err = fmt.Errorf("Error getting orders: %s", err)
+			return
+		}
+
+		// if either side is empty, or the min buy > max sell, there's nothing to match
+		maxSell, hasSell, minBuy, hasBuy := limitOrderbookPrices(orders)
+		if !hasSell || !hasBuy || minBuy > maxSell {
+			return
+		}
+
+		// sell side is ordered by price descending, buy side by price ascending, both by time ascending
+		var sellOrders []*match.LimitOrderIDPair
+		var buyOrders []*match.LimitOrderIDPair
+		for _, order := range orders {
+			if order.Order.Side == match.Sell && order.Price >= minBuy {
+				sellOrders = append(sellOrders, order)
+			} else if order.Order.Side == match.Buy && order.Price <= maxSell {
+				buyOrders = append(buyOrders, order)
+			}
+		}
+		sortLimitOrders(sellOrders, false)
+		sortLimitOrders(buyOrders, true)
+
+		if orderExecs, settlementExecs, err = match.MatchPrioritizedOrders(buyOrders, sellOrders); err != nil {
+			err = fmt.Errorf("Error matching prioritized orders: %s", err)
+			return
+		}
+
+		// Update the matching engine with the new state
+		bucket := tx.Bucket(ordersBucket)
+		for _, orderExec := range orderExecs {
+			if orderExec.Filled {
+				if err = bucket.Delete(orderExec.OrderID[:]); err != nil {
+					err = fmt.Errorf("Error deleting filled order: %s", err)
+					return
+				}
+				continue
+			}
+
+			var idPair *match.LimitOrderIDPair
+			if idPair, err = decodeLimitOrder(orderExec.OrderID[:], bucket.Get(orderExec.OrderID[:]), le.pair); err != nil {
+				err = fmt.Errorf("Error getting order to update: %s", err)
+				return
+			}
+			idPair.Order.AmountHave = orderExec.NewAmountHave
+			idPair.Order.AmountWant = orderExec.NewAmountWant
+			if err = bucket.Put(orderExec.OrderID[:], encodeLimitOrder(idPair.Order, idPair.Timestamp)); err != nil {
+				err = fmt.Errorf("Error updating order for order exec: %s", err)
+				return
+			}
+		}
+		return
+	}); err != nil {
+		orderExecs = nil
+		settlementExecs = nil
+		err = fmt.Errorf("Error for MatchLimitOrders: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the engine can't be used after this
+func (le *BoltLimitEngine) DestroyHandler() (err error) {
+	if err = le.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing limit engine db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateLimitEngineMap creates a map of pair to limit engine, given a list of pairs.
+func CreateLimitEngineMap(pairList []*match.Pair, dataDir string) (limMap map[match.Pair]match.LimitEngine, err error) {
+
+	limMap = make(map[match.Pair]match.LimitEngine)
+	var curLimEng match.LimitEngine
+	for _, pair := range pairList {
+		if curLimEng, err = CreateLimitEngine(pair, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single limit engine while creating limit engine map: %s", err)
+			return
+		}
+		limMap[*pair] = curLimEng
+	}
+
+	return
+}
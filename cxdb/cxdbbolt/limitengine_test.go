@@ -0,0 +1,197 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestLimitEngineOrdersSurviveRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	engine, err := CreateLimitEngine(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating limit engine: %s", err)
+	}
+
+	buyOrder := &match.LimitOrder{
+		Side:        match.Buy,
+		TradingPair: *testPair,
+		AmountHave:  1000,
+		AmountWant:  1000,
+	}
+	copy(buyOrder.Pubkey[:], createTestKey(t).SerializeCompressed())
+
+	idRes, err := engine.PlaceLimitOrder(buyOrder)
+	if err != nil {
+		t.Fatalf("Error placing order: %s", err)
+	}
+
+	if err = engine.(*BoltLimitEngine).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing limit engine: %s", err)
+	}
+
+	if engine, err = CreateLimitEngine(testPair, dataDir); err != nil {
+		t.Fatalf("Error reopening limit engine: %s", err)
+	}
+	defer engine.(*BoltLimitEngine).DestroyHandler()
+
+	// the order should still be there to cancel, and give back everything it had
+	_, cancelSettlement, err := engine.CancelLimitOrder(idRes.OrderID)
+	if err != nil {
+		t.Fatalf("Error cancelling order after restart: %s", err)
+	}
+	if cancelSettlement.Amount != buyOrder.AmountHave || cancelSettlement.Asset != testPair.AssetHave {
+		t.Errorf("Cancel should debit %d %s, got %d %s", buyOrder.AmountHave, testPair.AssetHave, cancelSettlement.Amount, cancelSettlement.Asset)
+	}
+
+	if _, _, err = engine.CancelLimitOrder(idRes.OrderID); err == nil {
+		t.Errorf("Cancelling an order twice should have failed")
+	}
+}
+
+func TestLimitEngineMatchCrossingOrders(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	engine, err := CreateLimitEngine(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating limit engine: %s", err)
+	}
+	defer engine.(*BoltLimitEngine).DestroyHandler()
+
+	buyOrder := &match.LimitOrder{
+		Side:        match.Buy,
+		TradingPair: *testPair,
+		AmountHave:  1000,
+		AmountWant:  1000,
+	}
+	copy(buyOrder.Pubkey[:], createTestKey(t).SerializeCompressed())
+	sellOrder := &match.LimitOrder{
+		Side:        match.Sell,
+		TradingPair: *testPair,
+		AmountHave:  1000,
+		AmountWant:  1000,
+	}
+	copy(sellOrder.Pubkey[:], createTestKey(t).SerializeCompressed())
+
+	if _, err = engine.PlaceLimitOrder(buyOrder); err != nil {
+		t.Fatalf("Error placing buy order: %s", err)
+	}
+	if _, err = engine.PlaceLimitOrder(sellOrder); err != nil {
+		t.Fatalf("Error placing sell order: %s", err)
+	}
+
+	orderExecs, setExecs, err := engine.MatchLimitOrders()
+	if err != nil {
+		t.Fatalf("Error matching orders: %s", err)
+	}
+	if len(orderExecs) == 0 || len(setExecs) == 0 {
+		t.Fatalf("Crossing orders should have produced executions")
+	}
+
+	// running it again shouldn't produce anything since the filled orders are gone
+	if orderExecs, _, err = engine.MatchLimitOrders(); err != nil {
+		t.Fatalf("Error matching orders a second time: %s", err)
+	}
+	for _, exec := range orderExecs {
+		if exec.Filled {
+			t.Errorf("Filled order %x was matched twice", exec.OrderID[:])
+		}
+	}
+}
+
+func TestLimitOrderbookPlaceGetExec(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	book, err := CreateLimitOrderbook(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating limit orderbook: %s", err)
+	}
+	defer book.(*BoltLimitOrderbook).DestroyHandler()
+
+	pubkey := createTestKey(t)
+	idPair := &match.LimitOrderIDPair{
+		OrderID: &match.OrderID{0x01},
+		Order: &match.LimitOrder{
+			Side:        match.Sell,
+			TradingPair: *testPair,
+			AmountHave:  1000,
+			AmountWant:  2000,
+		},
+	}
+	copy(idPair.Order.Pubkey[:], pubkey.SerializeCompressed())
+
+	if err = book.UpdateBookPlace(idPair); err != nil {
+		t.Fatalf("Error placing order in book: %s", err)
+	}
+
+	if err = book.UpdateBookExec(&match.OrderExecution{OrderID: *idPair.OrderID, NewAmountHave: 500, NewAmountWant: 1000}); err != nil {
+		t.Fatalf("Error updating book with exec: %s", err)
+	}
+
+	got, err := book.GetOrder(idPair.OrderID)
+	if err != nil {
+		t.Fatalf("Error getting order: %s", err)
+	}
+	if got.Order.AmountHave != 500 || got.Order.AmountWant != 1000 {
+		t.Errorf("Order should have been partially filled, got have %d want %d", got.Order.AmountHave, got.Order.AmountWant)
+	}
+
+	orders, err := book.GetOrdersForPubkey(pubkey)
+	if err != nil {
+		t.Fatalf("Error getting orders for pubkey: %s", err)
+	}
+	if len(orders[got.Price]) != 1 {
+		t.Errorf("Pubkey should have one order at price %f", got.Price)
+	}
+
+	if err = book.UpdateBookExec(&match.OrderExecution{OrderID: *idPair.OrderID, Filled: true}); err != nil {
+		t.Fatalf("Error updating book with filled exec: %s", err)
+	}
+	if _, err = book.GetOrder(idPair.OrderID); err == nil {
+		t.Errorf("Filled order should have been removed from the book")
+	}
+}
+
+func TestLimitEngineIdenticalOrders(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	engine, err := CreateLimitEngine(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating limit engine: %s", err)
+	}
+	defer engine.(*BoltLimitEngine).DestroyHandler()
+
+	order := &match.LimitOrder{
+		Side:        match.Buy,
+		TradingPair: *testPair,
+		AmountHave:  1000,
+		AmountWant:  1000,
+	}
+	copy(order.Pubkey[:], createTestKey(t).SerializeCompressed())
+
+	// two identical orders placed at the same time are still two orders
+	placementTime := time.Now()
+	firstRes, err := engine.(*BoltLimitEngine).PlaceLimitOrderAt(order, placementTime)
+	if err != nil {
+		t.Fatalf("Error placing first order: %s", err)
+	}
+	secondRes, err := engine.(*BoltLimitEngine).PlaceLimitOrderAt(order, placementTime)
+	if err != nil {
+		t.Fatalf("Error placing second order: %s", err)
+	}
+	if *firstRes.OrderID == *secondRes.OrderID {
+		t.Fatalf("Identical orders placed at the same time should get different IDs")
+	}
+
+	for _, idRes := range []*match.LimitOrderIDPair{firstRes, secondRes} {
+		if _, _, err = engine.CancelLimitOrder(idRes.OrderID); err != nil {
+			t.Errorf("Error cancelling order %x: %s", idRes.OrderID[:], err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BoltLimitOrderbook is a limit orderbook that keeps its orders in a bolt db
+type BoltLimitOrderbook struct {
+	db *bolt.DB
+
+	// this pair
+	pair *match.Pair
+}
+
+// CreateLimitOrderbook creates a limit orderbook for a pair, storing the orders in dataDir.
+func CreateLimitOrderbook(pair *match.Pair, dataDir string) (book match.LimitOrderbook, err error) {
+	lo := &BoltLimitOrderbook{
+		pair: pair,
+	}
+	if lo.db, err = openStoreDB(dataDir, "limitorderbook", pair.String(), ordersBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateLimitOrderbook: %s", err)
+		return
+	}
+	book = lo
+	return
+}
+
+// UpdateBookExec takes in an order execution and updates the orderbook.
+func (lo *BoltLimitOrderbook) UpdateBookExec(orderExec *match.OrderExecution) (err error) {
+	if err = lo.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(ordersBucket)
+		if orderExec.Filled {
+			return bucket.Delete(orderExec.OrderID[:])
+		}
+
+		var idPair *match.LimitOrderIDPair
+		if idPair, err = decodeLimitOrder(orderExec.OrderID[:], bucket.Get(orderExec.OrderID[:]), lo.pair); err != nil {
+			err = fmt.Errorf("Could not find order %x: %s", orderExec.OrderID[:], err)
+			return
+		}
+		idPair.Order.AmountHave = orderExec.NewAmountHave
+		idPair.Order.AmountWant = orderExec.NewAmountWant
+		return bucket.Put(orderExec.OrderID[:], encodeLimitOrder(idPair.Order, idPair.Timestamp))
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBookExec: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateBookCancel takes in an order cancellation and updates the orderbook.
+func (lo *BoltLimitOrderbook) UpdateBookCancel(cancel *match.CancelledOrder) (err error) {
+	if err = lo.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(ordersBucket).Delete(cancel.OrderID[:])
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBookCancel: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateBookPlace takes in an order, ID, timestamp, and adds the order to the orderbook.
+func (lo *BoltLimitOrderbook) UpdateBookPlace(limitIDPair *match.LimitOrderIDPair) (err error) {
+	if err = lo.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(ordersBucket).Put(limitIDPair.OrderID[:], encodeLimitOrder(limitIDPair.Order, limitIDPair.Timestamp))
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBookPlace: %s", err)
+		return
+	}
+	return
+}
+
+// GetOrder gets an order from an OrderID
+func (lo *BoltLimitOrderbook) GetOrder(orderID *match.OrderID) (limOrder *match.LimitOrderIDPair, err error) {
+	if err = lo.db.View(func(tx *bolt.Tx) (err error) {
+		record := tx.Bucket(ordersBucket).Get(orderID[:])
+		if record == nil {
+			err = fmt.Errorf("Order %x does not exist", orderID[:])
+			return
+		}
+		limOrder, err = decodeLimitOrder(orderID[:], record, lo.pair)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetOrder: %s", err)
+		return
+	}
+	return
+}
+
+// CalculatePrice takes in a pair and returns the calculated price based on the orderbook.
+func (lo *BoltLimitOrderbook) CalculatePrice() (price float64, err error) {
+	var orders []*match.LimitOrderIDPair
+	if orders, err = lo.getAllOrders(); err != nil {
+		err = fmt.Errorf("Error getting orders for CalculatePrice: %s", err)
+		return
+	}
+
+	// an empty side is treated as zero, same as the sql orderbook
+	maxSell, _, minBuy, _ := limitOrderbookPrices(orders)
+	price = (minBuy + maxSell) / 2
+	return
+}
+
+// GetOrdersForPubkey gets orders for a specific pubkey.
+func (lo *BoltLimitOrderbook) GetOrdersForPubkey(pubkey *koblitz.PublicKey) (orders map[float64][]*match.LimitOrderIDPair, err error) {
+	var allOrders []*match.LimitOrderIDPair
+	if allOrders, err = lo.getAllOrders(); err != nil {
+		err = fmt.Errorf("Error getting orders for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	pkBytes := pubkey.SerializeCompressed()
+	orders = make(map[float64][]*match.LimitOrderIDPair)
+	for _, order := range allOrders {
+		if bytes.Equal(order.Order.Pubkey[:], pkBytes) {
+			orders[order.Price] = append(orders[order.Price], order)
+		}
+	}
+	return
+}
+
+// ViewLimitOrderBook returns the orderbook as a map
+func (lo *BoltLimitOrderbook) ViewLimitOrderBook() (book map[float64][]*match.LimitOrderIDPair, err error) {
+	var allOrders []*match.LimitOrderIDPair
+	if allOrders, err = lo.getAllOrders(); err != nil {
+		err = fmt.Errorf("Error getting orders for ViewLimitOrderBook: %s", err)
+		return
+	}
+
+	book = make(map[float64][]*match.LimitOrderIDPair)
+	for _, order := range allOrders {
+		book[order.Price] = append(book[order.Price], order)
+	}
+	return
+}
+
+// getAllOrders gets every order in the book
+func (lo *BoltLimitOrderbook) getAllOrders() (orders []*match.LimitOrderIDPair, err error) {
+	err = lo.db.View(func(tx *bolt.Tx) (err error) {
+		orders, err = getLimitOrdersTx(tx, lo.pair)
+		return
+	})
+	return
+}
+
+// DestroyHandler closes the db, the orderbook can't be used after this
+func (lo *BoltLimitOrderbook) DestroyHandler() (err error) {
+	if err = lo.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing limit orderbook db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateLimitOrderbookMap creates a map of pair to limit orderbook, given a list of pairs.
+func CreateLimitOrderbookMap(pairList []*match.Pair, dataDir string) (orderbookMap map[match.Pair]match.LimitOrderbook, err error) {
+
+	orderbookMap = make(map[match.Pair]match.LimitOrderbook)
+	var curLimBook match.LimitOrderbook
+	for _, pair := range pairList {
+		if curLimBook, err = CreateLimitOrderbook(pair, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single limit orderbook while creating limit orderbook map: %s", err)
+			return
+		}
+		orderbookMap[*pair] = curLimBook
+	}
+
+	return
+}
@@ -0,0 +1,106 @@
+package cxdbbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	// pubkey, side, amountHave, amountWant, timestamp
+	limitOrderRecordLength = 33 + 1 + 8 + 8 + 8
+)
+
+// encodeLimitOrder serializes a limit order and the time it was placed. The pair isn't stored
+// because every store is for a single pair.
+func encodeLimitOrder(order *match.LimitOrder, timestamp time.Time) (buf []byte) {
+	buf = make([]byte, limitOrderRecordLength)
+	copy(buf[:33], order.Pubkey[:])
+	if order.Side == match.Buy {
+		buf[33] = 0x01
+	}
+	binary.BigEndian.PutUint64(buf[34:42], order.AmountHave)
+	binary.BigEndian.PutUint64(buf[42:50], order.AmountWant)
+	binary.BigEndian.PutUint64(buf[50:58], uint64(timestamp.UnixNano()))
+	return
+}
+
+// decodeLimitOrder deserializes a limit order stored by encodeLimitOrder into an order ID pair
+func decodeLimitOrder(orderIDBytes []byte, buf []byte, pair *match.Pair) (idPair *match.LimitOrderIDPair, err error) {
+	if len(buf) != limitOrderRecordLength {
+		err = fmt.Errorf("Limit order record should be %d bytes, got %d", limitOrderRecordLength, len(buf))
+		return
+	}
+	if len(orderIDBytes) != len(match.OrderID{}) {
+		err = fmt.Errorf("Order ID should be %d bytes, got %d", len(match.OrderID{}), len(orderIDBytes))
+		return
+	}
+
+	idPair = &match.LimitOrderIDPair{
+		OrderID: new(match.OrderID),
+		Order: &match.LimitOrder{
+			Side:        match.Side(buf[33] != 0x00),
+			TradingPair: *pair,
+			AmountHave:  binary.BigEndian.Uint64(buf[34:42]),
+			AmountWant:  binary.BigEndian.Uint64(buf[42:50]),
+		},
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(buf[50:58]))),
+	}
+	copy(idPair.OrderID[:], orderIDBytes)
+	copy(idPair.Order.Pubkey[:], buf[:33])
+
+	if idPair.Price, err = idPair.Order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price for stored limit order: %s", err)
+		return
+	}
+	return
+}
+
+// getLimitOrdersTx gets every limit order in the orders bucket
+func getLimitOrdersTx(tx *bolt.Tx, pair *match.Pair) (orders []*match.LimitOrderIDPair, err error) {
+	err = tx.Bucket(ordersBucket).ForEach(func(k, v []byte) (err error) {
+		var idPair *match.LimitOrderIDPair
+		if idPair, err = decodeLimitOrder(k, v, pair); err != nil {
+			return
+		}
+		orders = append(orders, idPair)
+		return
+	})
+	return
+}
+
+// limitOrderbookPrices gets the max sell price and min buy price, if there are no orders on a side
+// then the price for that side is zero, and the bool for that side is false.
+func limitOrderbookPrices(orders []*match.LimitOrderIDPair) (maxSell float64, hasSell bool, minBuy float64, hasBuy bool) {
+	for _, order := range orders {
+		if order.Order.Side == match.Sell {
+			if !hasSell || order.Price > maxSell {
+				maxSell = order.Price
+				hasSell = true
+			}
+		} else {
+			if !hasBuy || order.Price < minBuy {
+				minBuy = order.Price
+				hasBuy = true
+			}
+		}
+	}
+	return
+}
+
+// sortLimitOrders sorts orders by price, ascending or descending, and then by time ascending.
+func sortLimitOrders(orders []*match.LimitOrderIDPair, priceAscending bool) {
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].Price != orders[j].Price {
+			if priceAscending {
+				return orders[i].Price < orders[j].Price
+			}
+			return orders[i].Price > orders[j].Price
+		}
+		return orders[i].Timestamp.Before(orders[j].Timestamp)
+	})
+}
@@ -0,0 +1,272 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BoltPuzzleStore keeps the encrypted auction orders for a pair in a bolt db
+type BoltPuzzleStore struct {
+	db *bolt.DB
+
+	// the pair for this puzzle store
+	pair *match.Pair
+}
+
+// CreatePuzzleStore creates a puzzle store for a pair, storing puzzles in dataDir.
+func CreatePuzzleStore(pair *match.Pair, dataDir string) (store cxdb.PuzzleStore, err error) {
+	bp := &BoltPuzzleStore{
+		pair: pair,
+	}
+	if bp.db, err = openStoreDB(dataDir, "puzzlestore", pair.String(), puzzlesBucket, transcriptsBucket, auctionStatusesBucket, auctionResultsBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreatePuzzleStore: %s", err)
+		return
+	}
+	store = bp
+	return
+}
+
+// ViewAuctionPuzzleBook takes in an auction ID, and returns encrypted auction orders, and puzzles.
+// They're returned in the order they were placed.
+func (bp *BoltPuzzleStore) ViewAuctionPuzzleBook(auctionID *match.AuctionID) (puzzles []*match.EncryptedAuctionOrder, err error) {
+	if err = bp.db.View(func(tx *bolt.Tx) (err error) {
+		cursor := tx.Bucket(puzzlesBucket).Cursor()
+		for k, v := cursor.Seek(auctionID[:]); k != nil && bytes.HasPrefix(k, auctionID[:]); k, v = cursor.Next() {
+			currPuzzle := new(match.EncryptedAuctionOrder)
+			if err = currPuzzle.Deserialize(append([]byte{}, v...)); err != nil {
+				err = fmt.Errorf("Error deserializing puzzle: %s", err)
+				return
+			}
+			puzzles = append(puzzles, currPuzzle)
+		}
+		return
+	}); err != nil {
+		puzzles = nil
+		err = fmt.Errorf("Error for ViewAuctionPuzzleBook: %s", err)
+		return
+	}
+	return
+}
+
+// PlaceAuctionPuzzle puts an encrypted auction order in the datastore.
+func (bp *BoltPuzzleStore) PlaceAuctionPuzzle(puzzledOrder *match.EncryptedAuctionOrder) (err error) {
+	var pzOrderBytes []byte
+	if pzOrderBytes, err = puzzledOrder.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing puzzled order for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+
+	if err = bp.db.Update(func(tx *bolt.Tx) (err error) {
+		puzzles := tx.Bucket(puzzlesBucket)
+
+		var key []byte
+		if key, err = sequenceKey(puzzles, puzzledOrder.IntendedAuction[:]); err != nil {
+			return
+		}
+		return puzzles.Put(key, pzOrderBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+	return
+}
+
+// PutTranscript stores the transcript for an auction, replacing the one that was there.
+func (bp *BoltPuzzleStore) PutTranscript(transcript *match.Transcript) (err error) {
+	var transcriptBytes []byte
+	if transcriptBytes, err = transcript.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing transcript for PutTranscript: %s", err)
+		return
+	}
+
+	if err = bp.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(transcriptsBucket).Put(transcript.BatchId[:], transcriptBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for PutTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// ViewTranscript returns the transcript for an auction, or an error if there isn't one
+func (bp *BoltPuzzleStore) ViewTranscript(auctionID *match.AuctionID) (transcript *match.Transcript, err error) {
+	if err = bp.db.View(func(tx *bolt.Tx) (err error) {
+		var transcriptBytes []byte
+		if transcriptBytes = tx.Bucket(transcriptsBucket).Get(auctionID[:]); transcriptBytes == nil {
+			err = fmt.Errorf("No transcript for auction %x", auctionID[:])
+			return
+		}
+		transcript = new(match.Transcript)
+		if err = transcript.Deserialize(append([]byte{}, transcriptBytes...)); err != nil {
+			err = fmt.Errorf("Error deserializing transcript: %s", err)
+			return
+		}
+		return
+	}); err != nil {
+		transcript = nil
+		err = fmt.Errorf("Error for ViewTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionOrderStatus stores the status of an auction order, replacing the one that was there
+func (bp *BoltPuzzleStore) PutAuctionOrderStatus(status *match.AuctionOrderStatus) (err error) {
+	var statusBytes []byte
+	if statusBytes, err = status.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing status for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	if err = bp.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(auctionStatusesBucket).Put(status.Receipt.ID[:], statusBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for PutAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionOrderStatus returns the status of the auction order with a receipt ID, or nil if
+// there isn't one
+func (bp *BoltPuzzleStore) ViewAuctionOrderStatus(receiptID [32]byte) (status *match.AuctionOrderStatus, err error) {
+	if err = bp.db.View(func(tx *bolt.Tx) (err error) {
+		var statusBytes []byte
+		if statusBytes = tx.Bucket(auctionStatusesBucket).Get(receiptID[:]); statusBytes == nil {
+			return
+		}
+		status = new(match.AuctionOrderStatus)
+		if err = status.Deserialize(append([]byte{}, statusBytes...)); err != nil {
+			err = fmt.Errorf("Error deserializing status: %s", err)
+			return
+		}
+		return
+	}); err != nil {
+		status = nil
+		err = fmt.Errorf("Error for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionResult stores the result of an auction, replacing the one that was there
+func (bp *BoltPuzzleStore) PutAuctionResult(result *match.AuctionResult) (err error) {
+	var resultBytes []byte
+	if resultBytes, err = result.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing result for PutAuctionResult: %s", err)
+		return
+	}
+
+	if err = bp.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(auctionResultsBucket).Put(result.AuctionID[:], resultBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for PutAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionResult returns the result of an auction, or nil if it doesn't have one
+func (bp *BoltPuzzleStore) ViewAuctionResult(auctionID *match.AuctionID) (result *match.AuctionResult, err error) {
+	if err = bp.db.View(func(tx *bolt.Tx) (err error) {
+		var resultBytes []byte
+		if resultBytes = tx.Bucket(auctionResultsBucket).Get(auctionID[:]); resultBytes == nil {
+			return
+		}
+		result = new(match.AuctionResult)
+		if err = result.Deserialize(append([]byte{}, resultBytes...)); err != nil {
+			err = fmt.Errorf("Error deserializing result: %s", err)
+			return
+		}
+		return
+	}); err != nil {
+		result = nil
+		err = fmt.Errorf("Error for ViewAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// PruneAuctions removes the results of auctions that ended before a time, and the statuses of
+// decided orders that haven't been updated since then
+func (bp *BoltPuzzleStore) PruneAuctions(before time.Time) (err error) {
+	if err = bp.db.Update(func(tx *bolt.Tx) (err error) {
+		// deleting while iterating makes bolt's cursor skip keys, so we delete once we're done
+		var pruneKeys [][]byte
+		statuses := tx.Bucket(auctionStatusesBucket)
+		if err = statuses.ForEach(func(k, v []byte) (err error) {
+			status := new(match.AuctionOrderStatus)
+			if err = status.Deserialize(append([]byte{}, v...)); err != nil {
+				err = fmt.Errorf("Error deserializing status to prune: %s", err)
+				return
+			}
+			if status.Decided() && status.Updated.Before(before) {
+				pruneKeys = append(pruneKeys, k)
+			}
+			return
+		}); err != nil {
+			return
+		}
+		for _, k := range pruneKeys {
+			if err = statuses.Delete(k); err != nil {
+				return
+			}
+		}
+
+		pruneKeys = nil
+		results := tx.Bucket(auctionResultsBucket)
+		if err = results.ForEach(func(k, v []byte) (err error) {
+			result := new(match.AuctionResult)
+			if err = result.Deserialize(append([]byte{}, v...)); err != nil {
+				err = fmt.Errorf("Error deserializing result to prune: %s", err)
+				return
+			}
+			if result.Ended.Before(before) {
+				pruneKeys = append(pruneKeys, k)
+			}
+			return
+		}); err != nil {
+			return
+		}
+		for _, k := range pruneKeys {
+			if err = results.Delete(k); err != nil {
+				return
+			}
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for PruneAuctions: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (bp *BoltPuzzleStore) DestroyHandler() (err error) {
+	if err = bp.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing puzzle store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreatePuzzleStoreMap creates a map of pair to puzzle store, given a list of pairs.
+func CreatePuzzleStoreMap(pairList []*match.Pair, dataDir string) (pzMap map[match.Pair]cxdb.PuzzleStore, err error) {
+
+	pzMap = make(map[match.Pair]cxdb.PuzzleStore)
+	var curPzStore cxdb.PuzzleStore
+	for _, pair := range pairList {
+		if curPzStore, err = CreatePuzzleStore(pair, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single puzzle store while creating puzzle store map: %s", err)
+			return
+		}
+		pzMap[*pair] = curPzStore
+	}
+
+	return
+}
@@ -0,0 +1,110 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestTranscriptStore tests that the latest transcript for an auction is kept after the store is
+// reopened
+func TestTranscriptStore(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreatePuzzleStore(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating puzzle store: %s", err)
+	}
+
+	auctionID := match.AuctionID{0x01}
+	transcript := &match.Transcript{BatchId: auctionID, BatchIdSig: []byte{0x02}, Commitment: [32]byte{0x03}}
+	if err = store.PutTranscript(transcript); err != nil {
+		t.Fatalf("Error putting transcript: %s", err)
+	}
+	transcript.Solutions = []match.AuctionOrder{{AuctionID: auctionID, AmountHave: 100, AmountWant: 200}}
+	if err = store.PutTranscript(transcript); err != nil {
+		t.Fatalf("Error putting finished transcript: %s", err)
+	}
+	if err = store.(*BoltPuzzleStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing puzzle store: %s", err)
+	}
+
+	if store, err = CreatePuzzleStore(testPair, dataDir); err != nil {
+		t.Fatalf("Error reopening puzzle store: %s", err)
+	}
+	defer store.(*BoltPuzzleStore).DestroyHandler()
+
+	var got *match.Transcript
+	if got, err = store.ViewTranscript(&auctionID); err != nil {
+		t.Fatalf("Error viewing transcript: %s", err)
+	}
+	if got.Commitment != transcript.Commitment || len(got.Solutions) != 1 || got.Solutions[0].AmountWant != 200 {
+		t.Errorf("Latest transcript should be kept, got %+v", got)
+	}
+	if _, err = store.ViewTranscript(&match.AuctionID{0x04}); err == nil {
+		t.Errorf("Auction without a transcript should error")
+	}
+}
+
+// TestAuctionStatusStore tests that auction order statuses and results are kept after the store
+// is reopened, and that pruning only removes decided orders and results from before the prune time
+func TestAuctionStatusStore(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreatePuzzleStore(testPair, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating puzzle store: %s", err)
+	}
+
+	submitted := &match.AuctionOrderStatus{Receipt: match.AuctionReceipt{ID: [32]byte{0x01}}, State: match.AuctionOrderSubmitted, Updated: time.Unix(500, 0), Cancels: [][33]byte{{0x02}}}
+	oldRejected := &match.AuctionOrderStatus{Receipt: match.AuctionReceipt{ID: [32]byte{0x02}}, State: match.AuctionOrderRejected, Reason: "bad", Updated: time.Unix(500, 0)}
+	newFilled := &match.AuctionOrderStatus{Receipt: match.AuctionReceipt{ID: [32]byte{0x03}}, State: match.AuctionOrderFilled, Updated: time.Unix(1500, 0)}
+	for _, status := range []*match.AuctionOrderStatus{submitted, oldRejected, newFilled} {
+		if err = store.PutAuctionOrderStatus(status); err != nil {
+			t.Fatalf("Error putting status: %s", err)
+		}
+	}
+	oldResult := &match.AuctionResult{AuctionID: match.AuctionID{0x01}, Ended: time.Unix(500, 0)}
+	newResult := &match.AuctionResult{AuctionID: match.AuctionID{0x02}, Ended: time.Unix(1500, 0)}
+	for _, result := range []*match.AuctionResult{oldResult, newResult} {
+		if err = store.PutAuctionResult(result); err != nil {
+			t.Fatalf("Error putting result: %s", err)
+		}
+	}
+	if err = store.(*BoltPuzzleStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing puzzle store: %s", err)
+	}
+
+	if store, err = CreatePuzzleStore(testPair, dataDir); err != nil {
+		t.Fatalf("Error reopening puzzle store: %s", err)
+	}
+	defer store.(*BoltPuzzleStore).DestroyHandler()
+
+	var status *match.AuctionOrderStatus
+	if status, err = store.ViewAuctionOrderStatus(oldRejected.Receipt.ID); err != nil || status == nil || status.Reason != "bad" {
+		t.Fatalf("Rejected order should be kept, got %v err %v", status, err)
+	}
+	if err = store.PruneAuctions(time.Unix(1000, 0)); err != nil {
+		t.Fatalf("Error pruning auctions: %s", err)
+	}
+
+	if status, err = store.ViewAuctionOrderStatus(submitted.Receipt.ID); err != nil || status == nil || len(status.Cancels) != 1 {
+		t.Errorf("Submitted order should keep its cancels, got %v err %v", status, err)
+	}
+	if status, err = store.ViewAuctionOrderStatus(oldRejected.Receipt.ID); err != nil || status != nil {
+		t.Errorf("Old rejected order should be pruned, got %v err %v", status, err)
+	}
+	if status, err = store.ViewAuctionOrderStatus(newFilled.Receipt.ID); err != nil || status == nil {
+		t.Errorf("New filled order should be kept, got %v err %v", status, err)
+	}
+	var result *match.AuctionResult
+	if result, err = store.ViewAuctionResult(&oldResult.AuctionID); err != nil || result != nil {
+		t.Errorf("Old result should be pruned, got %v err %v", result, err)
+	}
+	if result, err = store.ViewAuctionResult(&newResult.AuctionID); err != nil || result == nil {
+		t.Errorf("New result should be kept, got %v err %v", result, err)
+	}
+}
@@ -0,0 +1,111 @@
+package cxdbbolt
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BoltSettlementEngine is a settlement engine that keeps balances in a bolt db
+type BoltSettlementEngine struct {
+	db *bolt.DB
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// CreateSettlementEngine creates a settlement engine for a coin, storing balances in dataDir.
+func CreateSettlementEngine(coin *coinparam.Params, dataDir string) (engine match.SettlementEngine, err error) {
+	se := &BoltSettlementEngine{
+		coin: coin,
+	}
+	if se.db, err = openStoreDB(dataDir, "settlementengine", coin.Name, balancesBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateSettlementEngine: %s", err)
+		return
+	}
+	engine = se
+	return
+}
+
+// ApplySettlementExecution applies the settlementExecution, this assumes that the settlement execution is
+// valid
+func (se *BoltSettlementEngine) ApplySettlementExecution(setExec *match.SettlementExecution) (setRes *match.SettlementResult, err error) {
+	if err = se.db.Update(func(tx *bolt.Tx) (err error) {
+		var curBal uint64
+		if curBal, err = getBalanceTx(tx, setExec.Pubkey[:]); err != nil {
+			return
+		}
+
+		var newBal uint64
+		if setExec.Type == match.Debit {
+			newBal = curBal + setExec.Amount
+		} else if setExec.Type == match.Credit {
+			newBal = curBal - setExec.Amount
+		}
+
+		if err = putBalanceTx(tx, setExec.Pubkey[:], newBal); err != nil {
+			return
+		}
+
+		setRes = &match.SettlementResult{
+			NewBal:         newBal,
+			SuccessfulExec: setExec,
+		}
+		return
+	}); err != nil {
+		setRes = nil
+		err = fmt.Errorf("Error while applying settlement exec: %s", err)
+		return
+	}
+	return
+}
+
+// CheckValid returns true if the settlement execution would be valid
+func (se *BoltSettlementEngine) CheckValid(setExec *match.SettlementExecution) (valid bool, err error) {
+	if setExec.Type == match.Debit {
+		// No settlement will be an invalid debit
+		valid = true
+		return
+	}
+
+	var curBal uint64
+	if err = se.db.View(func(tx *bolt.Tx) (err error) {
+		curBal, err = getBalanceTx(tx, setExec.Pubkey[:])
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error while checking settlement exec: %s", err)
+		return
+	}
+
+	logging.Infof("User with %d %s trying to complete action costing %d %[2]s.", curBal, se.coin.Name, setExec.Amount)
+	valid = setExec.Amount <= curBal
+	return
+}
+
+// DestroyHandler closes the db, the engine can't be used after this
+func (se *BoltSettlementEngine) DestroyHandler() (err error) {
+	if err = se.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing settlement engine db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateSettlementEngineMap creates a map of coin to settlement engine, given a list of coins.
+func CreateSettlementEngineMap(coins []*coinparam.Params, dataDir string) (setMap map[*coinparam.Params]match.SettlementEngine, err error) {
+
+	setMap = make(map[*coinparam.Params]match.SettlementEngine)
+	var curSetEng match.SettlementEngine
+	for _, coin := range coins {
+		if curSetEng, err = CreateSettlementEngine(coin, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single settlement engine while creating settlement engine map: %s", err)
+			return
+		}
+		setMap[coin] = curSetEng
+	}
+
+	return
+}
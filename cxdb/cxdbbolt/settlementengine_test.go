@@ -0,0 +1,100 @@
+package cxdbbolt
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestSettlementEngineBalanceSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	engine, err := CreateSettlementEngine(&coinparam.RegressionNetParams, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating settlement engine: %s", err)
+	}
+
+	debit := &match.SettlementExecution{
+		Amount: 1000,
+		Asset:  btcreg,
+		Type:   match.Debit,
+	}
+	copy(debit.Pubkey[:], createTestKey(t).SerializeCompressed())
+	credit := &match.SettlementExecution{
+		Pubkey: debit.Pubkey,
+		Amount: 1000,
+		Asset:  btcreg,
+		Type:   match.Credit,
+	}
+
+	var valid bool
+	if valid, err = engine.CheckValid(credit); err != nil {
+		t.Fatalf("Error checking credit: %s", err)
+	}
+	if valid {
+		t.Errorf("Credit should not be valid for a user with no balance")
+	}
+
+	if _, err = engine.ApplySettlementExecution(debit); err != nil {
+		t.Fatalf("Error applying debit: %s", err)
+	}
+
+	if err = engine.(*BoltSettlementEngine).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing settlement engine: %s", err)
+	}
+	if engine, err = CreateSettlementEngine(&coinparam.RegressionNetParams, dataDir); err != nil {
+		t.Fatalf("Error reopening settlement engine: %s", err)
+	}
+	defer engine.(*BoltSettlementEngine).DestroyHandler()
+
+	if valid, err = engine.CheckValid(credit); err != nil {
+		t.Fatalf("Error checking credit after restart: %s", err)
+	}
+	if !valid {
+		t.Errorf("Credit for the entire balance should be valid after restart")
+	}
+
+	var setRes *match.SettlementResult
+	if setRes, err = engine.ApplySettlementExecution(credit); err != nil {
+		t.Fatalf("Error applying credit: %s", err)
+	}
+	if setRes.NewBal != 0 {
+		t.Errorf("Balance should be 0 after credit, got %d", setRes.NewBal)
+	}
+}
+
+func TestSettlementStoreUpdateGet(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateSettlementStore(&coinparam.RegressionNetParams, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating settlement store: %s", err)
+	}
+	defer store.(*BoltSettlementStore).DestroyHandler()
+
+	pubkey := createTestKey(t)
+	setRes := &match.SettlementResult{
+		NewBal: 1234,
+		SuccessfulExec: &match.SettlementExecution{
+			Amount: 1234,
+			Asset:  btcreg,
+			Type:   match.Debit,
+		},
+	}
+	copy(setRes.SuccessfulExec.Pubkey[:], pubkey.SerializeCompressed())
+
+	if err = store.UpdateBalances([]*match.SettlementResult{setRes}); err != nil {
+		t.Fatalf("Error updating balances: %s", err)
+	}
+
+	var balance uint64
+	if balance, err = store.GetBalance(pubkey); err != nil {
+		t.Fatalf("Error getting balance: %s", err)
+	}
+	if balance != setRes.NewBal {
+		t.Errorf("Balance should be %d, got %d", setRes.NewBal, balance)
+	}
+}
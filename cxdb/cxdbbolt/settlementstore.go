@@ -0,0 +1,86 @@
+package cxdbbolt
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BoltSettlementStore keeps the client-viewable balances for a coin in a bolt db. It is updated
+// with the results of the settlement engine, which is what actually does validation.
+type BoltSettlementStore struct {
+	db *bolt.DB
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// CreateSettlementStore creates a settlement store for a coin, storing balances in dataDir.
+func CreateSettlementStore(coin *coinparam.Params, dataDir string) (store cxdb.SettlementStore, err error) {
+	ss := &BoltSettlementStore{
+		coin: coin,
+	}
+	if ss.db, err = openStoreDB(dataDir, "settlementstore", coin.Name, balancesBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateSettlementStore: %s", err)
+		return
+	}
+	store = ss
+	return
+}
+
+// UpdateBalances updates the balances from the settlement executions
+func (ss *BoltSettlementStore) UpdateBalances(settlementResults []*match.SettlementResult) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		for _, setResult := range settlementResults {
+			if err = putBalanceTx(tx, setResult.SuccessfulExec.Pubkey[:], setResult.NewBal); err != nil {
+				return
+			}
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateBalances: %s", err)
+		return
+	}
+	return
+}
+
+// GetBalance gets the balance for a pubkey and an asset.
+func (ss *BoltSettlementStore) GetBalance(pubkey *koblitz.PublicKey) (balance uint64, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		balance, err = getBalanceTx(tx, pubkey.SerializeCompressed())
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetBalance: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ss *BoltSettlementStore) DestroyHandler() (err error) {
+	if err = ss.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing settlement store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateSettlementStoreMap creates a map of coin to settlement store, given a list of coins.
+func CreateSettlementStoreMap(coins []*coinparam.Params, dataDir string) (setMap map[*coinparam.Params]cxdb.SettlementStore, err error) {
+
+	setMap = make(map[*coinparam.Params]cxdb.SettlementStore)
+	var curSetStore cxdb.SettlementStore
+	for _, coin := range coins {
+		if curSetStore, err = CreateSettlementStore(coin, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single settlement store while creating settlement store map: %s", err)
+			return
+		}
+		setMap[coin] = curSetStore
+	}
+
+	return
+}
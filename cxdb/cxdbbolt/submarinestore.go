@@ -0,0 +1,154 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for submarine swaps, keyed by seq so they're kept oldest first
+	submarineSwapsBucket = []byte("submarineswaps")
+	// bucket for the key of each submarine swap, keyed by hash
+	submarineKeysBucket = []byte("submarinekeys")
+)
+
+// BoltSubmarineStore keeps submarine swaps for every coin in a bolt db. Submarine swaps are gob
+// encoded.
+type BoltSubmarineStore struct {
+	db *bolt.DB
+}
+
+// CreateSubmarineStore creates a submarine swap store, storing submarine swaps in dataDir.
+func CreateSubmarineStore(dataDir string) (store cxdb.SubmarineStore, err error) {
+	ss := new(BoltSubmarineStore)
+	if ss.db, err = openStoreDB(dataDir, "submarinestore", "all", submarineSwapsBucket, submarineKeysBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateSubmarineStore: %s", err)
+		return
+	}
+	store = ss
+	return
+}
+
+// AddSubmarineSwap stores a new submarine swap
+func (ss *BoltSubmarineStore) AddSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		submarineKeys := tx.Bucket(submarineKeysBucket)
+		if submarineKeys.Get(swap.RHash[:]) != nil {
+			err = fmt.Errorf("Submarine swap %s already exists", swap.ID())
+			return
+		}
+		var key []byte
+		if key, err = sequenceKey(tx.Bucket(submarineSwapsBucket), nil); err != nil {
+			return
+		}
+		if err = submarineKeys.Put(swap.RHash[:], key); err != nil {
+			err = fmt.Errorf("Error putting submarine swap key: %s", err)
+			return
+		}
+		return putGob(tx.Bucket(submarineSwapsBucket), key, swap)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddSubmarineSwap: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateSubmarineSwap saves everything about a stored submarine swap that changes as it moves
+// along
+func (ss *BoltSubmarineStore) UpdateSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		var stored *match.SubmarineSwap
+		var key []byte
+		if stored, key, err = getSubmarineSwapTx(tx, swap.RHash); err != nil {
+			return
+		}
+		stored.UpdateFrom(swap)
+		return putGob(tx.Bucket(submarineSwapsBucket), key, stored)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateSubmarineSwap: %s", err)
+		return
+	}
+	return
+}
+
+// GetSubmarineSwap gets a submarine swap by its hash
+func (ss *BoltSubmarineStore) GetSubmarineSwap(rhash [32]byte) (swap *match.SubmarineSwap, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		swap, _, err = getSubmarineSwapTx(tx, rhash)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetSubmarineSwap: %s", err)
+		return
+	}
+	return
+}
+
+// getSubmarineSwapTx gets a submarine swap by its hash, along with the key it's stored under
+func getSubmarineSwapTx(tx *bolt.Tx, rhash [32]byte) (swap *match.SubmarineSwap, key []byte, err error) {
+	if key = tx.Bucket(submarineKeysBucket).Get(rhash[:]); key == nil {
+		err = fmt.Errorf("No submarine swap %x", rhash)
+		return
+	}
+	// bolt values are only valid for the transaction, and we use the key to put the swap back
+	key = append([]byte{}, key...)
+
+	swap = new(match.SubmarineSwap)
+	if err = getGob(tx.Bucket(submarineSwapsBucket).Get(key), swap); err != nil {
+		return
+	}
+	return
+}
+
+// GetSubmarineSwaps gets every submarine swap for a pubkey, oldest first
+func (ss *BoltSubmarineStore) GetSubmarineSwaps(pubkey *koblitz.PublicKey) (swaps []*match.SubmarineSwap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if swaps, err = ss.filterSubmarineSwaps(func(swap *match.SubmarineSwap) bool {
+		return bytes.Equal(swap.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetSubmarineSwaps: %s", err)
+		return
+	}
+	return
+}
+
+// GetSubmarineSwapsByState gets every submarine swap in a state, oldest first
+func (ss *BoltSubmarineStore) GetSubmarineSwapsByState(state match.SubmarineState) (swaps []*match.SubmarineSwap, err error) {
+	if swaps, err = ss.filterSubmarineSwaps(func(swap *match.SubmarineSwap) bool {
+		return swap.State == state
+	}); err != nil {
+		err = fmt.Errorf("Error for GetSubmarineSwapsByState: %s", err)
+		return
+	}
+	return
+}
+
+// filterSubmarineSwaps returns the submarine swaps that keep returns true for, oldest first
+func (ss *BoltSubmarineStore) filterSubmarineSwaps(keep func(*match.SubmarineSwap) bool) (swaps []*match.SubmarineSwap, err error) {
+	err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(submarineSwapsBucket).ForEach(func(k, v []byte) (err error) {
+			swap := new(match.SubmarineSwap)
+			if err = getGob(v, swap); err != nil {
+				return
+			}
+			if keep(swap) {
+				swaps = append(swaps, swap)
+			}
+			return
+		})
+	})
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ss *BoltSubmarineStore) DestroyHandler() (err error) {
+	if err = ss.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing submarine swap store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
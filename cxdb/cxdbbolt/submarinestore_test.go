@@ -0,0 +1,79 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestSubmarineStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateSubmarineStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating submarine swap store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+	created := time.Unix(1500000000, 0)
+	var swaps []*match.SubmarineSwap
+	for i, direction := range []match.SubmarineDirection{match.SubmarineOut, match.SubmarineIn} {
+		request := &match.SubmarineRequest{Direction: direction, Asset: testPair.AssetHave, Amount: 100000, RHash: [32]byte{byte(i + 1)}}
+		var swap *match.SubmarineSwap
+		if swap, err = match.NewSubmarineSwap(request, pkBytes, [33]byte{0x02}, 100, match.DefaultSwapPolicy(), created); err != nil {
+			t.Fatalf("Error creating submarine swap: %s", err)
+		}
+		if err = store.AddSubmarineSwap(swap); err != nil {
+			t.Fatalf("Error adding submarine swap: %s", err)
+		}
+		swaps = append(swaps, swap)
+	}
+	if err = store.AddSubmarineSwap(swaps[0]); err == nil {
+		t.Errorf("Adding the same submarine swap twice should fail")
+	}
+
+	swaps[0].Outpoint = "00:0"
+	swaps[0].FundAmount = 100000
+	swaps[0].SpendTxid = "01"
+	swaps[0].Preimage = [16]byte{0x03}
+	swaps[0].SetState(match.SubmarineClaimed, "", created.Add(time.Minute))
+	if err = store.UpdateSubmarineSwap(swaps[0]); err != nil {
+		t.Fatalf("Error updating submarine swap: %s", err)
+	}
+
+	if err = store.(*BoltSubmarineStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing submarine swap store: %s", err)
+	}
+	if store, err = CreateSubmarineStore(dataDir); err != nil {
+		t.Fatalf("Error reopening submarine swap store: %s", err)
+	}
+	defer store.(*BoltSubmarineStore).DestroyHandler()
+
+	var swap *match.SubmarineSwap
+	if swap, err = store.GetSubmarineSwap(swaps[0].RHash); err != nil {
+		t.Fatalf("Error getting submarine swap after restart: %s", err)
+	}
+	if swap.State != match.SubmarineClaimed || swap.Preimage != swaps[0].Preimage || swap.Outpoint != "00:0" || swap.SpendTxid != "01" || swap.ChannelLocktime != swaps[0].ChannelLocktime {
+		t.Errorf("Submarine swap should be claimed and keep its preimage, outpoint and locktime, got %s", swap)
+	}
+
+	var waiting []*match.SubmarineSwap
+	if waiting, err = store.GetSubmarineSwapsByState(match.SubmarineCreated); err != nil {
+		t.Fatalf("Error getting created submarine swaps: %s", err)
+	}
+	if len(waiting) != 1 || waiting[0].RHash != swaps[1].RHash || waiting[0].Direction != match.SubmarineIn {
+		t.Errorf("Only the second submarine swap should be created, got %d created", len(waiting))
+	}
+
+	var all []*match.SubmarineSwap
+	if all, err = store.GetSubmarineSwaps(pubkey); err != nil {
+		t.Fatalf("Error getting submarine swaps: %s", err)
+	}
+	if len(all) != 2 || all[0].RHash != swaps[0].RHash {
+		t.Errorf("Pubkey should have both submarine swaps oldest first, got %d", len(all))
+	}
+}
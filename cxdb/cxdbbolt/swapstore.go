@@ -0,0 +1,331 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for swaps, keyed by seq so they're kept oldest first
+	swapsBucket = []byte("swaps")
+	// bucket for the key of each swap, keyed by hash
+	swapKeysBucket = []byte("swapkeys")
+	// bucket for swap orders, keyed by order ID
+	swapOrdersBucket = []byte("swaporders")
+	// bucket for escrows, keyed by seq so they're kept oldest first
+	escrowsBucket = []byte("escrows")
+	// bucket for the key of each escrow, keyed by hash
+	escrowKeysBucket = []byte("escrowkeys")
+	// bucket for the hash of each committed escrow, keyed by order ID
+	escrowOrdersBucket = []byte("escroworders")
+)
+
+// BoltSwapStore keeps swaps, swap orders and escrows for every pair in a bolt db. Swaps and
+// escrows are gob encoded.
+type BoltSwapStore struct {
+	db *bolt.DB
+}
+
+// CreateSwapStore creates a swap store, storing swaps in dataDir.
+func CreateSwapStore(dataDir string) (store cxdb.SwapStore, err error) {
+	ss := new(BoltSwapStore)
+	if ss.db, err = openStoreDB(dataDir, "swapstore", "all", swapsBucket, swapKeysBucket, swapOrdersBucket, escrowsBucket, escrowKeysBucket, escrowOrdersBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateSwapStore: %s", err)
+		return
+	}
+	store = ss
+	return
+}
+
+// AddSwap stores a new swap
+func (ss *BoltSwapStore) AddSwap(swap *match.Swap) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		swapKeys := tx.Bucket(swapKeysBucket)
+		if swapKeys.Get(swap.RHash[:]) != nil {
+			err = fmt.Errorf("Swap %s already exists", swap.ID())
+			return
+		}
+		var key []byte
+		if key, err = sequenceKey(tx.Bucket(swapsBucket), nil); err != nil {
+			return
+		}
+		if err = swapKeys.Put(swap.RHash[:], key); err != nil {
+			err = fmt.Errorf("Error putting swap key: %s", err)
+			return
+		}
+		return putGob(tx.Bucket(swapsBucket), key, swap)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddSwap: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateSwap saves the state, reason and update time of a stored swap
+func (ss *BoltSwapStore) UpdateSwap(swap *match.Swap) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		var stored *match.Swap
+		var key []byte
+		if stored, key, err = getSwapTx(tx, swap.RHash); err != nil {
+			return
+		}
+		stored.State = swap.State
+		stored.Reason = swap.Reason
+		stored.Updated = swap.Updated
+		return putGob(tx.Bucket(swapsBucket), key, stored)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateSwap: %s", err)
+		return
+	}
+	return
+}
+
+// GetSwap gets a swap by its hash
+func (ss *BoltSwapStore) GetSwap(rhash [32]byte) (swap *match.Swap, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		swap, _, err = getSwapTx(tx, rhash)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetSwap: %s", err)
+		return
+	}
+	return
+}
+
+// getSwapTx gets a swap by its hash, along with the key it's stored under
+func getSwapTx(tx *bolt.Tx, rhash [32]byte) (swap *match.Swap, key []byte, err error) {
+	if key = tx.Bucket(swapKeysBucket).Get(rhash[:]); key == nil {
+		err = fmt.Errorf("No swap %x", rhash)
+		return
+	}
+	// bolt values are only valid for the transaction, and we use the key to put the swap back
+	key = append([]byte{}, key...)
+
+	swap = new(match.Swap)
+	if err = getGob(tx.Bucket(swapsBucket).Get(key), swap); err != nil {
+		return
+	}
+	return
+}
+
+// GetSwaps gets every swap for a pubkey, oldest first
+func (ss *BoltSwapStore) GetSwaps(pubkey *koblitz.PublicKey) (swaps []*match.Swap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if swaps, err = ss.filterSwaps(func(swap *match.Swap) bool {
+		return bytes.Equal(swap.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetSwaps: %s", err)
+		return
+	}
+	return
+}
+
+// GetSwapsByState gets every swap in a state, oldest first
+func (ss *BoltSwapStore) GetSwapsByState(state match.SwapState) (swaps []*match.Swap, err error) {
+	if swaps, err = ss.filterSwaps(func(swap *match.Swap) bool {
+		return swap.State == state
+	}); err != nil {
+		err = fmt.Errorf("Error for GetSwapsByState: %s", err)
+		return
+	}
+	return
+}
+
+// filterSwaps returns the swaps that keep returns true for, oldest first
+func (ss *BoltSwapStore) filterSwaps(keep func(*match.Swap) bool) (swaps []*match.Swap, err error) {
+	err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(swapsBucket).ForEach(func(k, v []byte) (err error) {
+			swap := new(match.Swap)
+			if err = getGob(v, swap); err != nil {
+				return
+			}
+			if keep(swap) {
+				swaps = append(swaps, swap)
+			}
+			return
+		})
+	})
+	return
+}
+
+// AddSwapOrder marks an order as a swap order
+func (ss *BoltSwapStore) AddSwapOrder(orderID *match.OrderID) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		if err = tx.Bucket(swapOrdersBucket).Put(orderID[:], []byte{1}); err != nil {
+			err = fmt.Errorf("Error putting swap order: %s", err)
+			return
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for AddSwapOrder: %s", err)
+		return
+	}
+	return
+}
+
+// IsSwapOrder returns true if an order is a swap order
+func (ss *BoltSwapStore) IsSwapOrder(orderID *match.OrderID) (swapOrder bool, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		swapOrder = tx.Bucket(swapOrdersBucket).Get(orderID[:]) != nil
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for IsSwapOrder: %s", err)
+		return
+	}
+	return
+}
+
+// AddEscrow stores a new escrow
+func (ss *BoltSwapStore) AddEscrow(escrow *match.OrderEscrow) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		escrowKeys := tx.Bucket(escrowKeysBucket)
+		if escrowKeys.Get(escrow.RHash[:]) != nil {
+			err = fmt.Errorf("Escrow %s already exists", escrow.ID())
+			return
+		}
+		var key []byte
+		if key, err = sequenceKey(tx.Bucket(escrowsBucket), nil); err != nil {
+			return
+		}
+		if err = escrowKeys.Put(escrow.RHash[:], key); err != nil {
+			err = fmt.Errorf("Error putting escrow key: %s", err)
+			return
+		}
+		return putGob(tx.Bucket(escrowsBucket), key, escrow)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddEscrow: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateEscrow saves the order ID, release, state, reason and update time of a stored escrow
+func (ss *BoltSwapStore) UpdateEscrow(escrow *match.OrderEscrow) (err error) {
+	if err = ss.db.Update(func(tx *bolt.Tx) (err error) {
+		var stored *match.OrderEscrow
+		var key []byte
+		if stored, key, err = getEscrowTx(tx, escrow.RHash); err != nil {
+			return
+		}
+		stored.OrderID = escrow.OrderID
+		stored.AmountFilled = escrow.AmountFilled
+		stored.AmountReceive = escrow.AmountReceive
+		stored.ReceiveLocktime = escrow.ReceiveLocktime
+		stored.AmountRefund = escrow.AmountRefund
+		stored.RefundLocktime = escrow.RefundLocktime
+		stored.State = escrow.State
+		stored.Reason = escrow.Reason
+		stored.Updated = escrow.Updated
+		if escrow.OrderID != (match.OrderID{}) {
+			if err = tx.Bucket(escrowOrdersBucket).Put(escrow.OrderID[:], escrow.RHash[:]); err != nil {
+				err = fmt.Errorf("Error putting escrow order: %s", err)
+				return
+			}
+		}
+		return putGob(tx.Bucket(escrowsBucket), key, stored)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateEscrow: %s", err)
+		return
+	}
+	return
+}
+
+// GetEscrow gets an escrow by its hash
+func (ss *BoltSwapStore) GetEscrow(rhash [32]byte) (escrow *match.OrderEscrow, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		escrow, _, err = getEscrowTx(tx, rhash)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetEscrow: %s", err)
+		return
+	}
+	return
+}
+
+// GetOrderEscrow gets the escrow of a channel order, escrow is nil if the order doesn't have one
+func (ss *BoltSwapStore) GetOrderEscrow(orderID *match.OrderID) (escrow *match.OrderEscrow, err error) {
+	if err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		hashBytes := tx.Bucket(escrowOrdersBucket).Get(orderID[:])
+		if hashBytes == nil {
+			return
+		}
+		var rhash [32]byte
+		copy(rhash[:], hashBytes)
+		escrow, _, err = getEscrowTx(tx, rhash)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetOrderEscrow: %s", err)
+		return
+	}
+	return
+}
+
+// getEscrowTx gets an escrow by its hash, along with the key it's stored under
+func getEscrowTx(tx *bolt.Tx, rhash [32]byte) (escrow *match.OrderEscrow, key []byte, err error) {
+	if key = tx.Bucket(escrowKeysBucket).Get(rhash[:]); key == nil {
+		err = fmt.Errorf("No escrow %x", rhash)
+		return
+	}
+	// bolt values are only valid for the transaction, and we use the key to put the escrow back
+	key = append([]byte{}, key...)
+
+	escrow = new(match.OrderEscrow)
+	if err = getGob(tx.Bucket(escrowsBucket).Get(key), escrow); err != nil {
+		return
+	}
+	return
+}
+
+// GetEscrows gets every escrow for a pubkey, oldest first
+func (ss *BoltSwapStore) GetEscrows(pubkey *koblitz.PublicKey) (escrows []*match.OrderEscrow, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if escrows, err = ss.filterEscrows(func(escrow *match.OrderEscrow) bool {
+		return bytes.Equal(escrow.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetEscrows: %s", err)
+		return
+	}
+	return
+}
+
+// GetEscrowsByState gets every escrow in a state, oldest first
+func (ss *BoltSwapStore) GetEscrowsByState(state match.EscrowState) (escrows []*match.OrderEscrow, err error) {
+	if escrows, err = ss.filterEscrows(func(escrow *match.OrderEscrow) bool {
+		return escrow.State == state
+	}); err != nil {
+		err = fmt.Errorf("Error for GetEscrowsByState: %s", err)
+		return
+	}
+	return
+}
+
+// filterEscrows returns the escrows that keep returns true for, oldest first
+func (ss *BoltSwapStore) filterEscrows(keep func(*match.OrderEscrow) bool) (escrows []*match.OrderEscrow, err error) {
+	err = ss.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(escrowsBucket).ForEach(func(k, v []byte) (err error) {
+			escrow := new(match.OrderEscrow)
+			if err = getGob(v, escrow); err != nil {
+				return
+			}
+			if keep(escrow) {
+				escrows = append(escrows, escrow)
+			}
+			return
+		})
+	})
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ss *BoltSwapStore) DestroyHandler() (err error) {
+	if err = ss.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing swap store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
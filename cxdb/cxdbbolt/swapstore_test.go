@@ -0,0 +1,155 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestSwapStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateSwapStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating swap store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	created := time.Unix(1500000000, 0)
+	var swaps []*match.Swap
+	for i := 0; i < 2; i++ {
+		fill := &match.FillEntry{
+			OrderID:     match.OrderID{byte(i + 1)},
+			TradingPair: *testPair,
+			Side:        match.Buy,
+			AmountHave:  4000,
+			AmountWant:  1000,
+		}
+		copy(fill.Pubkey[:], pubkey.SerializeCompressed())
+		var swap *match.Swap
+		if swap, err = match.NewSwap(fill, 100, match.DefaultSwapPolicy(), created); err != nil {
+			t.Fatalf("Error creating swap: %s", err)
+		}
+		if err = store.AddSwap(swap); err != nil {
+			t.Fatalf("Error adding swap: %s", err)
+		}
+		swaps = append(swaps, swap)
+	}
+	if err = store.AddSwap(swaps[0]); err == nil {
+		t.Errorf("Adding the same swap twice should fail")
+	}
+	if err = store.AddSwapOrder(&swaps[0].OrderID); err != nil {
+		t.Fatalf("Error adding swap order: %s", err)
+	}
+
+	swaps[0].SetState(match.SwapOffered, "", created.Add(time.Minute))
+	if err = store.UpdateSwap(swaps[0]); err != nil {
+		t.Fatalf("Error updating swap: %s", err)
+	}
+
+	if err = store.(*BoltSwapStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing swap store: %s", err)
+	}
+	if store, err = CreateSwapStore(dataDir); err != nil {
+		t.Fatalf("Error reopening swap store: %s", err)
+	}
+	defer store.(*BoltSwapStore).DestroyHandler()
+
+	var swap *match.Swap
+	if swap, err = store.GetSwap(swaps[0].RHash); err != nil {
+		t.Fatalf("Error getting swap after restart: %s", err)
+	}
+	if swap.State != match.SwapOffered || swap.Preimage != swaps[0].Preimage || swap.SendLocktime != swaps[0].SendLocktime {
+		t.Errorf("Swap should be offered and keep its preimage and locktime, got %s", swap)
+	}
+
+	var pending []*match.Swap
+	if pending, err = store.GetSwapsByState(match.SwapPending); err != nil {
+		t.Fatalf("Error getting pending swaps: %s", err)
+	}
+	if len(pending) != 1 || pending[0].RHash != swaps[1].RHash {
+		t.Errorf("Only the second swap should be pending, got %d pending", len(pending))
+	}
+
+	var all []*match.Swap
+	if all, err = store.GetSwaps(pubkey); err != nil {
+		t.Fatalf("Error getting swaps: %s", err)
+	}
+	if len(all) != 2 || all[0].RHash != swaps[0].RHash {
+		t.Errorf("Pubkey should have both swaps oldest first, got %d", len(all))
+	}
+
+	var swapOrder bool
+	if swapOrder, err = store.IsSwapOrder(&swaps[0].OrderID); err != nil || !swapOrder {
+		t.Errorf("First order should still be a swap order after restart, got %t, %v", swapOrder, err)
+	}
+	if swapOrder, err = store.IsSwapOrder(&swaps[1].OrderID); err != nil || swapOrder {
+		t.Errorf("Second order was never a swap order, got %t, %v", swapOrder, err)
+	}
+}
+
+func TestSwapStoreEscrowsSurviveRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	store, err := CreateSwapStore(dataDir)
+	if err != nil {
+		t.Fatalf("Error creating swap store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	created := time.Unix(1500000000, 0)
+	order := &match.LimitOrder{
+		TradingPair: *testPair,
+		Side:        match.Buy,
+		AmountHave:  4000,
+		AmountWant:  1000,
+	}
+	copy(order.Pubkey[:], pubkey.SerializeCompressed())
+	var escrow *match.OrderEscrow
+	if escrow, err = match.NewOrderEscrow(order, 100, match.DefaultSwapPolicy(), created); err != nil {
+		t.Fatalf("Error creating escrow: %s", err)
+	}
+	if err = store.AddEscrow(escrow); err != nil {
+		t.Fatalf("Error adding escrow: %s", err)
+	}
+	if err = store.AddEscrow(escrow); err == nil {
+		t.Errorf("Adding the same escrow twice should fail")
+	}
+
+	escrow.OrderID = match.OrderID{0x01}
+	escrow.SetState(match.EscrowCommitted, "", created.Add(time.Minute))
+	if err = store.UpdateEscrow(escrow); err != nil {
+		t.Fatalf("Error updating escrow: %s", err)
+	}
+
+	if err = store.(*BoltSwapStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing swap store: %s", err)
+	}
+	if store, err = CreateSwapStore(dataDir); err != nil {
+		t.Fatalf("Error reopening swap store: %s", err)
+	}
+	defer store.(*BoltSwapStore).DestroyHandler()
+
+	var stored *match.OrderEscrow
+	if stored, err = store.GetOrderEscrow(&escrow.OrderID); err != nil || stored == nil {
+		t.Fatalf("Error getting escrow for order after restart: %v", err)
+	}
+	if stored.State != match.EscrowCommitted || stored.Preimage != escrow.Preimage || stored.Locktime != escrow.Locktime || stored.Order != *order {
+		t.Errorf("Escrow should be committed and keep its preimage, locktime and order, got %s", stored)
+	}
+	if stored, err = store.GetOrderEscrow(&match.OrderID{0x02}); err != nil || stored != nil {
+		t.Errorf("Other order shouldn't have an escrow, got %v, %v", stored, err)
+	}
+
+	var committed []*match.OrderEscrow
+	if committed, err = store.GetEscrowsByState(match.EscrowCommitted); err != nil || len(committed) != 1 {
+		t.Errorf("Escrow should be committed, got %d, %v", len(committed), err)
+	}
+	var all []*match.OrderEscrow
+	if all, err = store.GetEscrows(pubkey); err != nil || len(all) != 1 || all[0].RHash != escrow.RHash {
+		t.Errorf("Pubkey should have the escrow, got %d, %v", len(all), err)
+	}
+}
@@ -0,0 +1,219 @@
+package cxdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// bucket for withdrawal requests, keyed by ID
+	withdrawalsBucket = []byte("withdrawals")
+	// bucket for withdrawal transactions that haven't confirmed yet, keyed by first txid
+	withdrawalBatchesBucket = []byte("withdrawalbatches")
+)
+
+// BoltWithdrawalStore keeps withdrawal requests for a coin in a bolt db. Requests are gob encoded
+// and keyed by ID, which is the bucket's sequence number, so they're kept oldest first. Unconfirmed
+// withdrawal transactions are keyed by the txid of their first version.
+type BoltWithdrawalStore struct {
+	db *bolt.DB
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// CreateWithdrawalStore creates a withdrawal store for a coin, storing requests in dataDir.
+func CreateWithdrawalStore(coin *coinparam.Params, dataDir string) (store cxdb.WithdrawalStore, err error) {
+	ws := &BoltWithdrawalStore{
+		coin: coin,
+	}
+	if ws.db, err = openStoreDB(dataDir, "withdrawalstore", coin.Name, withdrawalsBucket, withdrawalBatchesBucket); err != nil {
+		err = fmt.Errorf("Error opening db for CreateWithdrawalStore: %s", err)
+		return
+	}
+	store = ws
+	return
+}
+
+// AddWithdrawal stores a new withdrawal request and sets its ID
+func (ws *BoltWithdrawalStore) AddWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	if err = ws.db.Update(func(tx *bolt.Tx) (err error) {
+		withdrawals := tx.Bucket(withdrawalsBucket)
+		var id uint64
+		if id, err = withdrawals.NextSequence(); err != nil {
+			err = fmt.Errorf("Error getting next withdrawal ID: %s", err)
+			return
+		}
+		withdrawal.ID = id
+		return putGob(withdrawals, uint64Bytes(id), withdrawal)
+	}); err != nil {
+		err = fmt.Errorf("Error for AddWithdrawal: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateWithdrawal saves the state, txid, reason, fee and update time of a stored withdrawal request
+func (ws *BoltWithdrawalStore) UpdateWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	if err = ws.db.Update(func(tx *bolt.Tx) (err error) {
+		withdrawals := tx.Bucket(withdrawalsBucket)
+		var stored *match.WithdrawalRequest
+		if stored, err = getWithdrawalTx(withdrawals, withdrawal.ID); err != nil {
+			return
+		}
+		stored.State = withdrawal.State
+		stored.Txid = withdrawal.Txid
+		stored.Reason = withdrawal.Reason
+		stored.Fee = withdrawal.Fee
+		stored.Updated = withdrawal.Updated
+		return putGob(withdrawals, uint64Bytes(stored.ID), stored)
+	}); err != nil {
+		err = fmt.Errorf("Error for UpdateWithdrawal: %s", err)
+		return
+	}
+	return
+}
+
+// GetWithdrawal gets a withdrawal request by ID
+func (ws *BoltWithdrawalStore) GetWithdrawal(id uint64) (withdrawal *match.WithdrawalRequest, err error) {
+	if err = ws.db.View(func(tx *bolt.Tx) (err error) {
+		withdrawal, err = getWithdrawalTx(tx.Bucket(withdrawalsBucket), id)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error for GetWithdrawal: %s", err)
+		return
+	}
+	return
+}
+
+// getWithdrawalTx gets a withdrawal request from the withdrawals bucket
+func getWithdrawalTx(withdrawals *bolt.Bucket, id uint64) (withdrawal *match.WithdrawalRequest, err error) {
+	var value []byte
+	if value = withdrawals.Get(uint64Bytes(id)); value == nil {
+		err = fmt.Errorf("No withdrawal with ID %d", id)
+		return
+	}
+	withdrawal = new(match.WithdrawalRequest)
+	if err = getGob(value, withdrawal); err != nil {
+		return
+	}
+	return
+}
+
+// GetWithdrawals gets every withdrawal request for a pubkey, oldest first
+func (ws *BoltWithdrawalStore) GetWithdrawals(pubkey *koblitz.PublicKey) (withdrawals []*match.WithdrawalRequest, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	if withdrawals, err = ws.filterWithdrawals(func(withdrawal *match.WithdrawalRequest) bool {
+		return bytes.Equal(withdrawal.Pubkey[:], pkBytes)
+	}); err != nil {
+		err = fmt.Errorf("Error for GetWithdrawals: %s", err)
+		return
+	}
+	return
+}
+
+// GetWithdrawalsByState gets every withdrawal request in a state, oldest first
+func (ws *BoltWithdrawalStore) GetWithdrawalsByState(state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error) {
+	if withdrawals, err = ws.filterWithdrawals(func(withdrawal *match.WithdrawalRequest) bool {
+		return withdrawal.State == state
+	}); err != nil {
+		err = fmt.Errorf("Error for GetWithdrawalsByState: %s", err)
+		return
+	}
+	return
+}
+
+// filterWithdrawals returns the withdrawals that keep returns true for, oldest first
+func (ws *BoltWithdrawalStore) filterWithdrawals(keep func(*match.WithdrawalRequest) bool) (withdrawals []*match.WithdrawalRequest, err error) {
+	err = ws.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(withdrawalsBucket).ForEach(func(k, v []byte) (err error) {
+			withdrawal := new(match.WithdrawalRequest)
+			if err = getGob(v, withdrawal); err != nil {
+				return
+			}
+			if keep(withdrawal) {
+				withdrawals = append(withdrawals, withdrawal)
+			}
+			return
+		})
+	})
+	return
+}
+
+// PutWithdrawalBatch stores a withdrawal transaction that hasn't confirmed yet, replacing it if
+// it's already stored
+func (ws *BoltWithdrawalStore) PutWithdrawalBatch(batch *match.WithdrawalBatch) (err error) {
+	var raw []byte
+	if raw, err = batch.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing withdrawal batch for PutWithdrawalBatch: %s", err)
+		return
+	}
+	if err = ws.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(withdrawalBatchesBucket).Put([]byte(batch.Txid), raw)
+	}); err != nil {
+		err = fmt.Errorf("Error for PutWithdrawalBatch: %s", err)
+		return
+	}
+	return
+}
+
+// GetWithdrawalBatches gets every withdrawal transaction that hasn't confirmed yet, by txid
+func (ws *BoltWithdrawalStore) GetWithdrawalBatches() (batches []*match.WithdrawalBatch, err error) {
+	if err = ws.db.View(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(withdrawalBatchesBucket).ForEach(func(k, v []byte) (err error) {
+			batch := new(match.WithdrawalBatch)
+			if err = batch.Deserialize(v); err != nil {
+				return
+			}
+			batches = append(batches, batch)
+			return
+		})
+	}); err != nil {
+		batches = nil
+		err = fmt.Errorf("Error for GetWithdrawalBatches: %s", err)
+		return
+	}
+	return
+}
+
+// RemoveWithdrawalBatch removes a batch once a version of its transaction confirms
+func (ws *BoltWithdrawalStore) RemoveWithdrawalBatch(txid string) (err error) {
+	if err = ws.db.Update(func(tx *bolt.Tx) (err error) {
+		return tx.Bucket(withdrawalBatchesBucket).Delete([]byte(txid))
+	}); err != nil {
+		err = fmt.Errorf("Error for RemoveWithdrawalBatch: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the db, the store can't be used after this
+func (ws *BoltWithdrawalStore) DestroyHandler() (err error) {
+	if err = ws.db.Close(); err != nil {
+		err = fmt.Errorf("Error closing withdrawal store db for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// CreateWithdrawalStoreMap creates a map of coin to withdrawal store, given a list of coins.
+func CreateWithdrawalStoreMap(coinList []*coinparam.Params, dataDir string) (withdrawalMap map[*coinparam.Params]cxdb.WithdrawalStore, err error) {
+
+	withdrawalMap = make(map[*coinparam.Params]cxdb.WithdrawalStore)
+	var curWithdrawalStore cxdb.WithdrawalStore
+	for _, coin := range coinList {
+		if curWithdrawalStore, err = CreateWithdrawalStore(coin, dataDir); err != nil {
+			err = fmt.Errorf("Error creating single withdrawal store while creating withdrawal store map: %s", err)
+			return
+		}
+		withdrawalMap[coin] = curWithdrawalStore
+	}
+
+	return
+}
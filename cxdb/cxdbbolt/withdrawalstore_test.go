@@ -0,0 +1,138 @@
+package cxdbbolt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/wire"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestWithdrawalStoreSurvivesRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	store, err := CreateWithdrawalStore(coin, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating withdrawal store: %s", err)
+	}
+
+	pubkey := createTestKey(t)
+	requested := time.Unix(1500000000, 0)
+	var ids []uint64
+	for i := 0; i < 2; i++ {
+		withdrawal := &match.WithdrawalRequest{
+			Asset:     btcreg,
+			Amount:    5000,
+			Address:   "bcrt1qtestaddress",
+			State:     match.WithdrawalApproved,
+			Requested: requested,
+			Updated:   requested,
+		}
+		copy(withdrawal.Pubkey[:], pubkey.SerializeCompressed())
+		if err = store.AddWithdrawal(withdrawal); err != nil {
+			t.Fatalf("Error adding withdrawal: %s", err)
+		}
+		ids = append(ids, withdrawal.ID)
+	}
+	if ids[0] == ids[1] {
+		t.Fatalf("Withdrawals should have different IDs, both got %d", ids[0])
+	}
+
+	broadcast := &match.WithdrawalRequest{ID: ids[0], Txid: "testtxid"}
+	broadcast.SetState(match.WithdrawalBroadcast, requested.Add(time.Minute))
+	if err = store.UpdateWithdrawal(broadcast); err != nil {
+		t.Fatalf("Error updating withdrawal: %s", err)
+	}
+
+	if err = store.(*BoltWithdrawalStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing withdrawal store: %s", err)
+	}
+	if store, err = CreateWithdrawalStore(coin, dataDir); err != nil {
+		t.Fatalf("Error reopening withdrawal store: %s", err)
+	}
+	defer store.(*BoltWithdrawalStore).DestroyHandler()
+
+	var withdrawal *match.WithdrawalRequest
+	if withdrawal, err = store.GetWithdrawal(ids[0]); err != nil {
+		t.Fatalf("Error getting withdrawal after restart: %s", err)
+	}
+	if withdrawal.State != match.WithdrawalBroadcast || withdrawal.Txid != "testtxid" || withdrawal.Amount != 5000 || !withdrawal.Requested.Equal(requested) {
+		t.Errorf("Withdrawal should be broadcast in testtxid and keep its amount, got %s", withdrawal)
+	}
+
+	var approved []*match.WithdrawalRequest
+	if approved, err = store.GetWithdrawalsByState(match.WithdrawalApproved); err != nil {
+		t.Fatalf("Error getting approved withdrawals: %s", err)
+	}
+	if len(approved) != 1 || approved[0].ID != ids[1] {
+		t.Errorf("Only withdrawal %d should be approved, got %d approved", ids[1], len(approved))
+	}
+
+	var all []*match.WithdrawalRequest
+	if all, err = store.GetWithdrawals(pubkey); err != nil {
+		t.Fatalf("Error getting withdrawals: %s", err)
+	}
+	if len(all) != 2 || all[0].ID != ids[0] {
+		t.Errorf("Pubkey should have both withdrawals oldest first, got %d", len(all))
+	}
+}
+
+func TestWithdrawalBatchesSurviveRestart(t *testing.T) {
+	dataDir, cleanup := createTestDir(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	store, err := CreateWithdrawalStore(coin, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating withdrawal store: %s", err)
+	}
+
+	batch := &match.WithdrawalBatch{
+		Txid:    "aa",
+		IDs:     []uint64{1, 2},
+		Outputs: []*wire.TxOut{wire.NewTxOut(5000, []byte{0x00, 0x14})},
+		FeeRate: 2,
+		Txs:     [][]byte{{0x01}},
+	}
+	if err = store.PutWithdrawalBatch(batch); err != nil {
+		t.Fatalf("Error putting withdrawal batch: %s", err)
+	}
+	// bumping the batch puts it again with another version
+	batch.FeeRate = 3
+	batch.Txs = append(batch.Txs, []byte{0x02})
+	if err = store.PutWithdrawalBatch(batch); err != nil {
+		t.Fatalf("Error putting bumped withdrawal batch: %s", err)
+	}
+	if err = store.PutWithdrawalBatch(&match.WithdrawalBatch{Txid: "bb", Txs: [][]byte{{0x03}}}); err != nil {
+		t.Fatalf("Error putting second withdrawal batch: %s", err)
+	}
+
+	if err = store.(*BoltWithdrawalStore).DestroyHandler(); err != nil {
+		t.Fatalf("Error closing withdrawal store: %s", err)
+	}
+	if store, err = CreateWithdrawalStore(coin, dataDir); err != nil {
+		t.Fatalf("Error reopening withdrawal store: %s", err)
+	}
+	defer store.(*BoltWithdrawalStore).DestroyHandler()
+
+	var batches []*match.WithdrawalBatch
+	if batches, err = store.GetWithdrawalBatches(); err != nil {
+		t.Fatalf("Error getting withdrawal batches after restart: %s", err)
+	}
+	if len(batches) != 2 || batches[0].Txid != "aa" || batches[0].FeeRate != 3 || len(batches[0].Txs) != 2 || len(batches[0].Outputs) != 1 || batches[0].Outputs[0].Value != 5000 {
+		t.Fatalf("Both batches should be stored, the first with its bump, got %d", len(batches))
+	}
+
+	if err = store.RemoveWithdrawalBatch("aa"); err != nil {
+		t.Fatalf("Error removing withdrawal batch: %s", err)
+	}
+	if batches, err = store.GetWithdrawalBatches(); err != nil {
+		t.Fatalf("Error getting withdrawal batches: %s", err)
+	}
+	if len(batches) != 1 || batches[0].Txid != "bb" {
+		t.Errorf("Only batch bb should be left, got %d", len(batches))
+	}
+}
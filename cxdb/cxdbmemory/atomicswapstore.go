@@ -0,0 +1,110 @@
+package cxdbmemory
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemoryAtomicSwapStore keeps atomic swaps in memory
+type MemoryAtomicSwapStore struct {
+	// swaps are in the order they were added, swapIndex maps an atomic swap's ID to its index
+	swaps     []*match.AtomicSwap
+	swapIndex map[[32]byte]int
+	atomicMtx *sync.Mutex
+}
+
+// CreateAtomicSwapStore creates an in memory atomic swap store
+func CreateAtomicSwapStore() (store cxdb.AtomicSwapStore, err error) {
+	ms := &MemoryAtomicSwapStore{
+		swapIndex: make(map[[32]byte]int),
+		atomicMtx: new(sync.Mutex),
+	}
+	store = ms
+	return
+}
+
+// AddAtomicSwap stores a new atomic swap
+func (ms *MemoryAtomicSwapStore) AddAtomicSwap(swap *match.AtomicSwap) (err error) {
+	ms.atomicMtx.Lock()
+	defer ms.atomicMtx.Unlock()
+
+	if _, ok := ms.swapIndex[swap.ID]; ok {
+		err = fmt.Errorf("Error adding atomic swap, atomic swap %s already exists", swap.IDString())
+		return
+	}
+	// keep a copy so callers can't change what's stored without UpdateAtomicSwap
+	stored := new(match.AtomicSwap)
+	*stored = *swap
+	ms.swapIndex[swap.ID] = len(ms.swaps)
+	ms.swaps = append(ms.swaps, stored)
+	return
+}
+
+// UpdateAtomicSwap saves everything about a stored atomic swap that changes as it moves
+// along
+func (ms *MemoryAtomicSwapStore) UpdateAtomicSwap(swap *match.AtomicSwap) (err error) {
+	ms.atomicMtx.Lock()
+	defer ms.atomicMtx.Unlock()
+
+	idx, ok := ms.swapIndex[swap.ID]
+	if !ok {
+		err = fmt.Errorf("Error updating atomic swap, no atomic swap %s", swap.IDString())
+		return
+	}
+	ms.swaps[idx].UpdateFrom(swap)
+	return
+}
+
+// GetAtomicSwap gets an atomic swap by its hash
+func (ms *MemoryAtomicSwapStore) GetAtomicSwap(id [32]byte) (swap *match.AtomicSwap, err error) {
+	ms.atomicMtx.Lock()
+	defer ms.atomicMtx.Unlock()
+
+	idx, ok := ms.swapIndex[id]
+	if !ok {
+		err = fmt.Errorf("Error getting atomic swap, no atomic swap %x", id)
+		return
+	}
+
+	swap = new(match.AtomicSwap)
+	*swap = *ms.swaps[idx]
+	return
+}
+
+// GetAtomicSwaps gets every atomic swap a pubkey is on either side of, oldest first
+func (ms *MemoryAtomicSwapStore) GetAtomicSwaps(pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	swaps = ms.filterAtomicSwaps(func(swap *match.AtomicSwap) bool {
+		return bytes.Equal(swap.Initiator.Pubkey[:], pkBytes) || bytes.Equal(swap.Participant.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetAtomicSwapsByState gets every atomic swap in a state, oldest first
+func (ms *MemoryAtomicSwapStore) GetAtomicSwapsByState(state match.AtomicSwapState) (swaps []*match.AtomicSwap, err error) {
+	swaps = ms.filterAtomicSwaps(func(swap *match.AtomicSwap) bool {
+		return swap.State == state
+	})
+	return
+}
+
+// filterAtomicSwaps returns copies of the atomic swaps that keep returns true for, oldest
+// first
+func (ms *MemoryAtomicSwapStore) filterAtomicSwaps(keep func(*match.AtomicSwap) bool) (swaps []*match.AtomicSwap) {
+	ms.atomicMtx.Lock()
+	defer ms.atomicMtx.Unlock()
+
+	for _, stored := range ms.swaps {
+		if keep(stored) {
+			swap := new(match.AtomicSwap)
+			*swap = *stored
+			swaps = append(swaps, swap)
+		}
+	}
+	return
+}
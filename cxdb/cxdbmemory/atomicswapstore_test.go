@@ -0,0 +1,65 @@
+package cxdbmemory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestAtomicSwapStoreStates(t *testing.T) {
+	store, _ := CreateAtomicSwapStore()
+	taker, _ := koblitz.NewPrivateKey(koblitz.S256())
+	maker, _ := koblitz.NewPrivateKey(koblitz.S256())
+	start := time.Unix(1500000000, 0)
+
+	var takerPubkey, makerPubkey [33]byte
+	copy(takerPubkey[:], taker.PubKey().SerializeCompressed())
+	copy(makerPubkey[:], maker.PubKey().SerializeCompressed())
+	fill := &match.FillEntry{OrderID: match.OrderID{0x02}, Pubkey: makerPubkey, TradingPair: match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest}, Side: match.Buy, AmountHave: 150000, AmountWant: 1000}
+	swap, err := match.NewAtomicSwap(match.OrderID{0x01}, takerPubkey, fill, match.DefaultAtomicSwapPolicy(), start)
+	if err != nil {
+		t.Fatalf("new atomic swap err: %v", err)
+	}
+	if err = store.AddAtomicSwap(swap); err != nil {
+		t.Fatalf("add atomic swap err: %v", err)
+	}
+	if err = store.AddAtomicSwap(swap); err == nil {
+		t.Errorf("adding an atomic swap twice should fail")
+	}
+
+	// changing the swap shouldn't change what's stored until it's updated
+	swap.RHash = [32]byte{0x03}
+	swap.Initiator.Outpoint = "00:1"
+	swap.Initiator.FundAmount = 1000
+	swap.SetState(match.AtomicSwapInitiated, start.Add(time.Hour), start.Add(time.Minute))
+	got, err := store.GetAtomicSwap(swap.ID)
+	if err != nil {
+		t.Fatalf("get atomic swap err: %v", err)
+	}
+	if got.State != match.AtomicSwapMatched || got.Initiator.Outpoint != "" {
+		t.Errorf("stored atomic swap should still be matched, got %s", got)
+	}
+	if err = store.UpdateAtomicSwap(swap); err != nil {
+		t.Fatalf("update atomic swap err: %v", err)
+	}
+	initiated, err := store.GetAtomicSwapsByState(match.AtomicSwapInitiated)
+	if err != nil {
+		t.Fatalf("get atomic swaps by state err: %v", err)
+	}
+	if len(initiated) != 1 || initiated[0].Initiator.Outpoint != "00:1" || initiated[0].RHash != swap.RHash || !initiated[0].Deadline.Equal(start.Add(time.Hour)) {
+		t.Errorf("atomic swap should be initiated, got %d initiated", len(initiated))
+	}
+
+	// both sides see the swap
+	for _, priv := range []*koblitz.PrivateKey{taker, maker} {
+		all, err := store.GetAtomicSwaps(priv.PubKey())
+		if err != nil || len(all) != 1 {
+			t.Errorf("pubkey should have 1 atomic swap, got %d, %v", len(all), err)
+		}
+	}
+	if _, err = store.GetAtomicSwap([32]byte{}); err == nil {
+		t.Errorf("getting an atomic swap that doesn't exist should fail")
+	}
+}
@@ -1,10 +1,10 @@
 package cxdbmemory
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 
-	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 	"golang.org/x/crypto/sha3"
 )
@@ -13,6 +13,53 @@ type MemoryAuctionEngine struct {
 	orders     map[match.AuctionID]map[float64][]*match.AuctionOrderIDPair
 	auctionMtx *sync.Mutex
 	pair       *match.Pair
+
+	// the write-ahead log, nil if there isn't one
+	wal *writeAheadLog
+}
+
+const (
+	// wal op for PlaceAuctionOrder
+	auctionPlaceOp = "auctionplace"
+	// wal op for CancelAuctionOrder
+	auctionCancelOp = "auctioncancel"
+	// wal op for MatchAuctionOrders, this logs the resulting executions
+	auctionMatchOp = "auctionmatch"
+)
+
+// auctionPlaceRecord is a PlaceAuctionOrder call in the wal
+type auctionPlaceRecord struct {
+	AuctionID match.AuctionID    `json:"auctionid"`
+	Order     auctionOrderRecord `json:"order"`
+}
+
+// CreateAuctionEngine creates an auction engine for a specific pair
+func CreateAuctionEngine(pair *match.Pair) (engine match.AuctionEngine, err error) {
+	me := &MemoryAuctionEngine{
+		orders:     make(map[match.AuctionID]map[float64][]*match.AuctionOrderIDPair),
+		auctionMtx: new(sync.Mutex),
+		pair:       pair,
+	}
+	engine = me
+	return
+}
+
+// CreateAuctionEngineWithWAL creates an auction engine for a specific pair, backed by a write-ahead
+// log. The orders are rebuilt from the log if there is one.
+func CreateAuctionEngineWithWAL(pair *match.Pair, conf *WALConfig) (engine match.AuctionEngine, err error) {
+	me := &MemoryAuctionEngine{
+		orders:     make(map[match.AuctionID]map[float64][]*match.AuctionOrderIDPair),
+		auctionMtx: new(sync.Mutex),
+		pair:       pair,
+	}
+
+	if me.wal, err = openWAL(conf, "auctionengine", pair.String(), me); err != nil {
+		err = fmt.Errorf("Error opening wal for CreateAuctionEngineWithWAL: %s", err)
+		return
+	}
+
+	engine = me
+	return
 }
 
 // PlaceAuctionOrder should place an order for a specific auction ID, and produce a response output.
@@ -20,14 +67,12 @@ type MemoryAuctionEngine struct {
 // This method assumes that the auction order is valid, and has the same pair as all of the other orders that have been placed for this matching engine.
 func (me *MemoryAuctionEngine) PlaceAuctionOrder(order *match.AuctionOrder, auctionID *match.AuctionID) (idRes *match.AuctionOrderIDPair, err error) {
 	me.auctionMtx.Lock()
-
-	idCopy := *auctionID
+	defer me.auctionMtx.Unlock()
 
 	// First get the price of the order, if this errors then that's really bad
 	var pr float64
 	if pr, err = order.Price(); err != nil {
 		err = fmt.Errorf("Critical error when placing order for matching engine: %s", err)
-		me.auctionMtx.Unlock()
 		return
 	}
 
@@ -39,76 +84,64 @@ func (me *MemoryAuctionEngine) PlaceAuctionOrder(order *match.AuctionOrder, auct
 
 	idRes = &match.AuctionOrderIDPair{
 		OrderID: id,
+		Price:   pr,
 		Order:   order,
 	}
 
-	// We assume that the order has been properly validated when it goes in to the auction orderbook
-	var ok bool
-	// If the map for the auction isn't there, create it
-	if _, ok = me.orders[idCopy]; !ok {
-
-		// Since we assume the order is valid, place it in the auction
-		me.orders[idCopy] = map[float64][]*match.AuctionOrderIDPair{
-			pr: []*match.AuctionOrderIDPair{
-				idRes,
-			},
-		}
-		return
+	// we only need the record if we're going to log it
+	rec := auctionPlaceRecord{AuctionID: *auctionID}
+	if me.wal != nil {
+		rec.Order = newAuctionOrderRecord(idRes)
 	}
 
-	// if the map for the auction is there but the price index isn't, create it
-	if _, ok = me.orders[idCopy][pr]; !ok {
-		me.orders[idCopy][pr] = []*match.AuctionOrderIDPair{
-			idRes,
-		}
+	// We assume that the order has been properly validated when it goes in to the auction orderbook
+	// The record is passed as a pointer so the IDs get marshalled as hex
+	if err = me.wal.logAndApply(auctionPlaceOp, &rec, func() (err error) {
+		me.placeAuctionOrder(idRes, *auctionID)
+		return
+	}); err != nil {
+		idRes = nil
+		err = fmt.Errorf("Error placing order for PlaceAuctionOrder: %s", err)
 		return
 	}
-
-	// if both are fine then awesome
-	me.orders[idCopy][pr] = append(me.orders[idCopy][pr], idRes)
-
-	me.auctionMtx.Unlock()
 	return
 }
 
+// placeAuctionOrder puts an order in an auction, the lock should be held
+func (me *MemoryAuctionEngine) placeAuctionOrder(idRes *match.AuctionOrderIDPair, auctionID match.AuctionID) {
+	if _, ok := me.orders[auctionID]; !ok {
+		me.orders[auctionID] = make(map[float64][]*match.AuctionOrderIDPair)
+	}
+	me.orders[auctionID][idRes.Price] = append(me.orders[auctionID][idRes.Price], idRes)
+}
+
 // CancelAuctionOrder should cancel an order for a specific order ID, and produce a response output.
 // This response output should be used in case the matching engine dies, and this can be replayed to build the state.
 func (me *MemoryAuctionEngine) CancelAuctionOrder(id *match.OrderID) (cancelled *match.CancelledOrder, cancelSettlement *match.SettlementExecution, err error) {
 	me.auctionMtx.Lock()
-	// Go through the maps and, since we don't have an order ID => order index just delete em all
-	var deletedOrder *match.AuctionOrderIDPair
-	for _, orderMap := range me.orders {
-		for pr, orderIDPairList := range orderMap {
-			var deletedIdx int
-			var deleted bool
-			for idx, orderIDPair := range orderIDPairList {
-				if orderIDPair.OrderID == *id {
-					deletedOrder = orderIDPair
-					deleted = true
-					deletedIdx = idx
-				}
-			}
+	defer me.auctionMtx.Unlock()
 
-			// If deleted remove from thing
-			if deleted {
-				oidLen := len(orderIDPairList)
-				orderIDPairList[oidLen-1], orderIDPairList[deletedIdx] = orderIDPairList[deletedIdx], orderIDPairList[oidLen-1]
-				orderMap[pr] = orderIDPairList[:oidLen-1]
-			}
-		}
+	var deletedOrder *match.AuctionOrderIDPair
+	if deletedOrder = me.findOrder(id); deletedOrder == nil {
+		err = fmt.Errorf("Error cancelling order, order %x not found", id[:])
+		return
 	}
-	// Get side from string rip
-	var orderSide *match.Side
-	orderSide = new(match.Side)
-	// because we just init the pointer we can get the value and set
-	*orderSide = deletedOrder.Order.Side
 
 	var debitAsset match.Asset
-	if *orderSide == match.Buy {
+	if deletedOrder.Order.Side == match.Buy {
 		debitAsset = me.pair.AssetHave
 	} else {
 		debitAsset = me.pair.AssetWant
 	}
+
+	if err = me.wal.logAndApply(auctionCancelOp, id, func() (err error) {
+		me.cancelAuctionOrder(id)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error cancelling order for CancelAuctionOrder: %s", err)
+		return
+	}
+
 	cancelled = &match.CancelledOrder{
 		OrderID: id,
 	}
@@ -119,23 +152,190 @@ func (me *MemoryAuctionEngine) CancelAuctionOrder(id *match.OrderID) (cancelled
 		Asset:  debitAsset,
 		Type:   match.Debit,
 	}
-	me.auctionMtx.Unlock()
 	return
 }
 
+// findOrder returns the order with an ID, or nil if it's not in any auction. The lock should be held.
+func (me *MemoryAuctionEngine) findOrder(id *match.OrderID) (idPair *match.AuctionOrderIDPair) {
+	for _, orderMap := range me.orders {
+		for _, orderIDPairList := range orderMap {
+			for _, orderIDPair := range orderIDPairList {
+				if orderIDPair.OrderID == *id {
+					return orderIDPair
+				}
+			}
+		}
+	}
+	return
+}
+
+// cancelAuctionOrder removes an order from whatever auction it's in, the lock should be held
+func (me *MemoryAuctionEngine) cancelAuctionOrder(id *match.OrderID) {
+	// Go through the maps and, since we don't have an order ID => order index just delete em all
+	for _, orderMap := range me.orders {
+		for pr, orderIDPairList := range orderMap {
+			for idx, orderIDPair := range orderIDPairList {
+				if orderIDPair.OrderID == *id {
+					oidLen := len(orderIDPairList)
+					orderIDPairList[oidLen-1], orderIDPairList[idx] = orderIDPairList[idx], orderIDPairList[oidLen-1]
+					orderMap[pr] = orderIDPairList[:oidLen-1]
+					if len(orderMap[pr]) == 0 {
+						delete(orderMap, pr)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
 // MatchAuctionOrders matches the auction orders for a specific auction ID
 func (me *MemoryAuctionEngine) MatchAuctionOrders(auctionID *match.AuctionID) (orderExecs []*match.OrderExecution, settlementExecs []*match.SettlementExecution, err error) {
-	// TODO
-	logging.Fatalf("UNIMPLEMENTED!")
+	me.auctionMtx.Lock()
+	defer me.auctionMtx.Unlock()
+
+	book := make(map[float64][]*match.AuctionOrderIDPair)
+	for pr, orderIDPairList := range me.orders[*auctionID] {
+		book[pr] = append(book[pr], orderIDPairList...)
+	}
+
+	// We can now calculate a clearing price and run the matching algorithm
+	if orderExecs, settlementExecs, err = match.MatchClearingAlgorithm(book); err != nil {
+		err = fmt.Errorf("Error running clearing matching algorithm for MatchAuctionOrders: %s", err)
+		return
+	}
+
+	// The matching algorithm doesn't need to be run again on replay, we log what it did
+	if err = me.wal.logAndApply(auctionMatchOp, orderExecs, func() (err error) {
+		me.applyOrderExecs(orderExecs)
+		return
+	}); err != nil {
+		orderExecs = nil
+		settlementExecs = nil
+		err = fmt.Errorf("Error applying order execs for MatchAuctionOrders: %s", err)
+		return
+	}
 	return
 }
 
+// applyOrderExecs removes filled orders and updates partially filled ones, the lock should be held
+func (me *MemoryAuctionEngine) applyOrderExecs(orderExecs []*match.OrderExecution) {
+	for _, exec := range orderExecs {
+		if exec.Filled {
+			me.cancelAuctionOrder(&exec.OrderID)
+			continue
+		}
+		if idPair := me.findOrder(&exec.OrderID); idPair != nil {
+			idPair.Order.AmountHave = exec.NewAmountHave
+			idPair.Order.AmountWant = exec.NewAmountWant
+		}
+	}
+}
+
+// DestroyHandler takes a final snapshot and closes the write-ahead log, if there is one
+func (me *MemoryAuctionEngine) DestroyHandler() (err error) {
+	me.auctionMtx.Lock()
+	defer me.auctionMtx.Unlock()
+	if err = me.wal.close(); err != nil {
+		err = fmt.Errorf("Error closing wal for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// snapshotState returns every order, the auction ID is part of the order so we don't need to
+// save which auction it was placed in separately.
+func (me *MemoryAuctionEngine) snapshotState() (state interface{}, err error) {
+	var recs []auctionOrderRecord
+	for _, orderMap := range me.orders {
+		for _, orderIDPairList := range orderMap {
+			for _, orderIDPair := range orderIDPairList {
+				recs = append(recs, newAuctionOrderRecord(orderIDPair))
+			}
+		}
+	}
+	state = recs
+	return
+}
+
+func (me *MemoryAuctionEngine) restoreState(state []byte) (err error) {
+	var recs []auctionOrderRecord
+	if err = json.Unmarshal(state, &recs); err != nil {
+		err = fmt.Errorf("Error unmarshalling auction engine snapshot: %s", err)
+		return
+	}
+	me.orders = make(map[match.AuctionID]map[float64][]*match.AuctionOrderIDPair)
+	for _, rec := range recs {
+		var idPair *match.AuctionOrderIDPair
+		if idPair, err = rec.idPair(); err != nil {
+			return
+		}
+		me.placeAuctionOrder(idPair, idPair.Order.AuctionID)
+	}
+	return
+}
+
+func (me *MemoryAuctionEngine) replayRecord(op string, data []byte) (err error) {
+	switch op {
+	case auctionPlaceOp:
+		var rec auctionPlaceRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling auction place record: %s", err)
+			return
+		}
+		var idPair *match.AuctionOrderIDPair
+		if idPair, err = rec.Order.idPair(); err != nil {
+			return
+		}
+		me.placeAuctionOrder(idPair, rec.AuctionID)
+	case auctionCancelOp:
+		id := new(match.OrderID)
+		if err = json.Unmarshal(data, id); err != nil {
+			err = fmt.Errorf("Error unmarshalling auction cancel record: %s", err)
+			return
+		}
+		me.cancelAuctionOrder(id)
+	case auctionMatchOp:
+		var orderExecs []*match.OrderExecution
+		if err = json.Unmarshal(data, &orderExecs); err != nil {
+			err = fmt.Errorf("Error unmarshalling auction match record: %s", err)
+			return
+		}
+		me.applyOrderExecs(orderExecs)
+	default:
+		err = fmt.Errorf("Unknown auction engine wal op %s", op)
+	}
+	return
+}
+
+// CreateAuctionEngineMap creates a map of pair to auction engine, given a list of pairs.
 func CreateAuctionEngineMap(pairList []*match.Pair) (mengines map[match.Pair]match.AuctionEngine, err error) {
 	mengines = make(map[match.Pair]match.AuctionEngine)
 
-	// We just create a new struct because that's all we really need, we satisfy the interface
+	var curAucEng match.AuctionEngine
+	for _, pair := range pairList {
+		if curAucEng, err = CreateAuctionEngine(pair); err != nil {
+			err = fmt.Errorf("Error creating single auction engine while creating auction engine map: %s", err)
+			return
+		}
+		mengines[*pair] = curAucEng
+	}
+
+	return
+}
+
+// CreateAuctionEngineMapWithWAL creates a map of pair to auction engine, each backed by a
+// write-ahead log in the same directory.
+func CreateAuctionEngineMapWithWAL(pairList []*match.Pair, conf *WALConfig) (mengines map[match.Pair]match.AuctionEngine, err error) {
+	mengines = make(map[match.Pair]match.AuctionEngine)
+
+	var curAucEng match.AuctionEngine
 	for _, pair := range pairList {
-		mengines[*pair] = new(MemoryAuctionEngine)
+		if curAucEng, err = CreateAuctionEngineWithWAL(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating single auction engine while creating auction engine map: %s", err)
+			return
+		}
+		mengines[*pair] = curAucEng
 	}
 
 	return
@@ -1,11 +1,11 @@
 package cxdbmemory
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
-	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
 
@@ -17,6 +17,50 @@ type MemoryAuctionOrderbook struct {
 
 	// this pair
 	pair *match.Pair
+
+	// the write-ahead log, nil if there isn't one
+	wal *writeAheadLog
+}
+
+const (
+	// wal op for UpdateBookExec
+	bookExecOp = "bookexec"
+	// wal op for UpdateBookCancel
+	bookCancelOp = "bookcancel"
+	// wal op for UpdateBookPlace
+	bookPlaceOp = "bookplace"
+)
+
+// auctionOrderRecord is an auction order and its ID in the wal, or in a snapshot. The order is
+// serialized since Side doesn't survive json.
+type auctionOrderRecord struct {
+	OrderID match.OrderID `json:"orderid"`
+	Price   float64       `json:"price"`
+	Order   []byte        `json:"order"`
+}
+
+// newAuctionOrderRecord creates a record from an order ID pair
+func newAuctionOrderRecord(idPair *match.AuctionOrderIDPair) (rec auctionOrderRecord) {
+	rec = auctionOrderRecord{
+		OrderID: idPair.OrderID,
+		Price:   idPair.Price,
+		Order:   idPair.Order.Serialize(),
+	}
+	return
+}
+
+// idPair deserializes the order in the record
+func (rec auctionOrderRecord) idPair() (idPair *match.AuctionOrderIDPair, err error) {
+	idPair = &match.AuctionOrderIDPair{
+		OrderID: rec.OrderID,
+		Price:   rec.Price,
+		Order:   new(match.AuctionOrder),
+	}
+	if err = idPair.Order.Deserialize(rec.Order); err != nil {
+		err = fmt.Errorf("Error deserializing auction order %x: %s", rec.OrderID[:], err)
+		return
+	}
+	return
 }
 
 // CreateAuctionOrderbook creates a auction orderbook based on a pair
@@ -32,66 +76,102 @@ func CreateAuctionOrderbook(pair *match.Pair) (book match.AuctionOrderbook, err
 	return
 }
 
+// CreateAuctionOrderbookWithWAL creates a auction orderbook based on a pair, backed by a
+// write-ahead log. The book is rebuilt from the log if there is one.
+func CreateAuctionOrderbookWithWAL(pair *match.Pair, conf *WALConfig) (book match.AuctionOrderbook, err error) {
+	mo := &MemoryAuctionOrderbook{
+		pair:    pair,
+		orders:  make(map[match.AuctionID]map[float64][]*match.AuctionOrderIDPair),
+		bookMtx: new(sync.Mutex),
+	}
+
+	if mo.wal, err = openWAL(conf, "auctionorderbook", pair.String(), mo); err != nil {
+		err = fmt.Errorf("Error opening wal for CreateAuctionOrderbookWithWAL: %s", err)
+		return
+	}
+
+	book = mo
+	return
+}
+
 // UpdateBookExec takes in an order execution and updates the orderbook.
 func (mo *MemoryAuctionOrderbook) UpdateBookExec(exec *match.OrderExecution) (err error) {
 	mo.bookMtx.Lock()
 	defer mo.bookMtx.Unlock()
 
-	for aucID, priceMap := range mo.orders {
-		for pr, pairList := range priceMap {
-			for idx, pair := range pairList {
-				if pair.OrderID == exec.OrderID {
-					if exec.Filled {
-						// remove
-						last := len(pairList) - 1
-						pairList[idx] = pairList[last]
-						mo.orders[aucID][pr] = pairList[:last]
-						if len(mo.orders[aucID][pr]) == 0 {
-							delete(mo.orders[aucID], pr)
-						}
-					} else {
-						pair.Order.AmountHave = exec.NewAmountHave
-						pair.Order.AmountWant = exec.NewAmountWant
-					}
-					return
-				}
-			}
-		}
+	if _, _, _, ok := mo.findOrder(&exec.OrderID); !ok {
+		err = fmt.Errorf("order not found")
+		return
 	}
-	err = fmt.Errorf("order not found")
+
+	err = mo.wal.logAndApply(bookExecOp, exec, func() (err error) {
+		mo.updateBookExec(exec)
+		return
+	})
 	return
 }
 
+// updateBookExec removes a filled order or updates the amounts of a partially filled one, the lock
+// should be held
+func (mo *MemoryAuctionOrderbook) updateBookExec(exec *match.OrderExecution) {
+	aucID, pr, idx, ok := mo.findOrder(&exec.OrderID)
+	if !ok {
+		return
+	}
+	if exec.Filled {
+		mo.removeOrder(aucID, pr, idx)
+		return
+	}
+	pair := mo.orders[aucID][pr][idx]
+	pair.Order.AmountHave = exec.NewAmountHave
+	pair.Order.AmountWant = exec.NewAmountWant
+}
+
 // UpdateBookCancel takes in an order cancellation and updates the orderbook.
 func (mo *MemoryAuctionOrderbook) UpdateBookCancel(cancel *match.CancelledOrder) (err error) {
 	mo.bookMtx.Lock()
 	defer mo.bookMtx.Unlock()
 
-	for aucID, priceMap := range mo.orders {
-		for pr, pairList := range priceMap {
-			for idx, pair := range pairList {
-				if pair.OrderID == *cancel.OrderID {
-					last := len(pairList) - 1
-					pairList[idx] = pairList[last]
-					mo.orders[aucID][pr] = pairList[:last]
-					if len(mo.orders[aucID][pr]) == 0 {
-						delete(mo.orders[aucID], pr)
-					}
-					return
-				}
-			}
-		}
+	if _, _, _, ok := mo.findOrder(cancel.OrderID); !ok {
+		err = fmt.Errorf("order not found")
+		return
 	}
 
-	err = fmt.Errorf("order not found")
+	err = mo.wal.logAndApply(bookCancelOp, cancel.OrderID, func() (err error) {
+		mo.updateBookCancel(cancel.OrderID)
+		return
+	})
 	return
 }
 
+// updateBookCancel removes an order from the book, the lock should be held
+func (mo *MemoryAuctionOrderbook) updateBookCancel(orderID *match.OrderID) {
+	if aucID, pr, idx, ok := mo.findOrder(orderID); ok {
+		mo.removeOrder(aucID, pr, idx)
+	}
+}
+
 // UpdateBookPlace takes in an order, ID, auction ID, and adds the order to the orderbook.
 func (mo *MemoryAuctionOrderbook) UpdateBookPlace(auctionIDPair *match.AuctionOrderIDPair) (err error) {
 	mo.bookMtx.Lock()
 	defer mo.bookMtx.Unlock()
 
+	// we only need the record if we're going to log it
+	var rec auctionOrderRecord
+	if mo.wal != nil {
+		rec = newAuctionOrderRecord(auctionIDPair)
+	}
+
+	// The record is passed as a pointer so the IDs get marshalled as hex
+	err = mo.wal.logAndApply(bookPlaceOp, &rec, func() (err error) {
+		mo.updateBookPlace(auctionIDPair)
+		return
+	})
+	return
+}
+
+// updateBookPlace adds an order to the book, the lock should be held
+func (mo *MemoryAuctionOrderbook) updateBookPlace(auctionIDPair *match.AuctionOrderIDPair) {
 	aid := auctionIDPair.Order.AuctionID
 	pr := auctionIDPair.Price
 
@@ -99,9 +179,33 @@ func (mo *MemoryAuctionOrderbook) UpdateBookPlace(auctionIDPair *match.AuctionOr
 		mo.orders[aid] = make(map[float64][]*match.AuctionOrderIDPair)
 	}
 	mo.orders[aid][pr] = append(mo.orders[aid][pr], auctionIDPair)
+}
+
+// findOrder finds where an order is in the book, the lock should be held
+func (mo *MemoryAuctionOrderbook) findOrder(orderID *match.OrderID) (aucID match.AuctionID, pr float64, idx int, ok bool) {
+	for aucID, priceMap := range mo.orders {
+		for pr, pairList := range priceMap {
+			for idx, pair := range pairList {
+				if pair.OrderID == *orderID {
+					return aucID, pr, idx, true
+				}
+			}
+		}
+	}
 	return
 }
 
+// removeOrder removes the order at a position in the book, the lock should be held
+func (mo *MemoryAuctionOrderbook) removeOrder(aucID match.AuctionID, pr float64, idx int) {
+	pairList := mo.orders[aucID][pr]
+	last := len(pairList) - 1
+	pairList[idx] = pairList[last]
+	mo.orders[aucID][pr] = pairList[:last]
+	if len(mo.orders[aucID][pr]) == 0 {
+		delete(mo.orders[aucID], pr)
+	}
+}
+
 // GetOrder gets an order from an OrderID
 func (mo *MemoryAuctionOrderbook) GetOrder(orderID *match.OrderID) (aucOrder *match.AuctionOrderIDPair, err error) {
 	mo.bookMtx.Lock()
@@ -189,6 +293,80 @@ func (mo *MemoryAuctionOrderbook) ViewAuctionOrderBook() (book map[float64][]*ma
 	return
 }
 
+// DestroyHandler takes a final snapshot and closes the write-ahead log, if there is one
+func (mo *MemoryAuctionOrderbook) DestroyHandler() (err error) {
+	mo.bookMtx.Lock()
+	defer mo.bookMtx.Unlock()
+	if err = mo.wal.close(); err != nil {
+		err = fmt.Errorf("Error closing wal for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+func (mo *MemoryAuctionOrderbook) snapshotState() (state interface{}, err error) {
+	var recs []auctionOrderRecord
+	for _, priceMap := range mo.orders {
+		for _, pairList := range priceMap {
+			for _, pair := range pairList {
+				recs = append(recs, newAuctionOrderRecord(pair))
+			}
+		}
+	}
+	state = recs
+	return
+}
+
+func (mo *MemoryAuctionOrderbook) restoreState(state []byte) (err error) {
+	var recs []auctionOrderRecord
+	if err = json.Unmarshal(state, &recs); err != nil {
+		err = fmt.Errorf("Error unmarshalling auction orderbook snapshot: %s", err)
+		return
+	}
+	mo.orders = make(map[match.AuctionID]map[float64][]*match.AuctionOrderIDPair)
+	for _, rec := range recs {
+		var idPair *match.AuctionOrderIDPair
+		if idPair, err = rec.idPair(); err != nil {
+			return
+		}
+		mo.updateBookPlace(idPair)
+	}
+	return
+}
+
+func (mo *MemoryAuctionOrderbook) replayRecord(op string, data []byte) (err error) {
+	switch op {
+	case bookExecOp:
+		exec := new(match.OrderExecution)
+		if err = json.Unmarshal(data, exec); err != nil {
+			err = fmt.Errorf("Error unmarshalling book exec record: %s", err)
+			return
+		}
+		mo.updateBookExec(exec)
+	case bookCancelOp:
+		orderID := new(match.OrderID)
+		if err = json.Unmarshal(data, orderID); err != nil {
+			err = fmt.Errorf("Error unmarshalling book cancel record: %s", err)
+			return
+		}
+		mo.updateBookCancel(orderID)
+	case bookPlaceOp:
+		var rec auctionOrderRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling book place record: %s", err)
+			return
+		}
+		var idPair *match.AuctionOrderIDPair
+		if idPair, err = rec.idPair(); err != nil {
+			return
+		}
+		mo.updateBookPlace(idPair)
+	default:
+		err = fmt.Errorf("Unknown auction orderbook wal op %s", op)
+	}
+	return
+}
+
 // CreateAuctionOrderbookMap creates a map of pair to auction engine, given a list of pairs.
 func CreateAuctionOrderbookMap(pairList []*match.Pair) (aucMap map[match.Pair]match.AuctionOrderbook, err error) {
 
@@ -204,3 +382,20 @@ func CreateAuctionOrderbookMap(pairList []*match.Pair) (aucMap map[match.Pair]ma
 
 	return
 }
+
+// CreateAuctionOrderbookMapWithWAL creates a map of pair to auction orderbook, each backed by a
+// write-ahead log in the same directory.
+func CreateAuctionOrderbookMapWithWAL(pairList []*match.Pair, conf *WALConfig) (aucMap map[match.Pair]match.AuctionOrderbook, err error) {
+
+	aucMap = make(map[match.Pair]match.AuctionOrderbook)
+	var curAucBook match.AuctionOrderbook
+	for _, pair := range pairList {
+		if curAucBook, err = CreateAuctionOrderbookWithWAL(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating single auction orderbook while creating auction orderbook map: %s", err)
+			return
+		}
+		aucMap[*pair] = curAucBook
+	}
+
+	return
+}
@@ -0,0 +1,183 @@
+package cxdbmemory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemoryColdStore keeps the outputs paying to a coin's cold wallet in memory
+type MemoryColdStore struct {
+	coin *coinparam.Params
+
+	// utxos are in the order they were added, spent ones included
+	utxos []*match.ColdUtxo
+	// refills are the serialized refills waiting to be signed, by unsigned txid
+	refills map[string][]byte
+	coldMtx *sync.Mutex
+}
+
+// CreateColdStore creates an in memory cold store for a coin
+func CreateColdStore(coin *coinparam.Params) (store cxdb.ColdStore, err error) {
+	mc := &MemoryColdStore{
+		coin:    coin,
+		refills: make(map[string][]byte),
+		coldMtx: new(sync.Mutex),
+	}
+	store = mc
+	return
+}
+
+// CreateColdStoreMap creates a map of coin to cold store for a list of coins.
+func CreateColdStoreMap(coinList []*coinparam.Params) (coldMap map[*coinparam.Params]cxdb.ColdStore, err error) {
+	coldMap = make(map[*coinparam.Params]cxdb.ColdStore)
+	var cur cxdb.ColdStore
+	for _, coin := range coinList {
+		if cur, err = CreateColdStore(coin); err != nil {
+			return
+		}
+		coldMap[coin] = cur
+	}
+	return
+}
+
+// AddColdUtxos stores new outputs paying to the cold wallet
+func (mc *MemoryColdStore) AddColdUtxos(utxos []*match.ColdUtxo) (err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	for _, utxo := range utxos {
+		if mc.find(utxo.OutPoint()) != nil {
+			continue
+		}
+		stored := new(match.ColdUtxo)
+		*stored = *utxo
+		mc.utxos = append(mc.utxos, stored)
+	}
+	return
+}
+
+// SpendColdUtxos marks cold outputs as spent in the block at blockheight
+func (mc *MemoryColdStore) SpendColdUtxos(outpoints []string, blockheight uint64) (err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	for _, outpoint := range outpoints {
+		if stored := mc.find(outpoint); stored != nil && stored.SpentHeight == 0 {
+			stored.SpentHeight = blockheight
+		}
+	}
+	return
+}
+
+// DisconnectBlocks removes outputs seen above blockheight and unspends outputs spent above it
+func (mc *MemoryColdStore) DisconnectBlocks(blockheight uint64) (err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	var kept []*match.ColdUtxo
+	for _, stored := range mc.utxos {
+		if stored.Height > blockheight {
+			continue
+		}
+		if stored.SpentHeight > blockheight {
+			stored.SpentHeight = 0
+		}
+		kept = append(kept, stored)
+	}
+	mc.utxos = kept
+	return
+}
+
+// GetColdUtxos gets every unspent cold output, oldest first
+func (mc *MemoryColdStore) GetColdUtxos() (utxos []*match.ColdUtxo, err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	for _, stored := range mc.utxos {
+		if stored.SpentHeight != 0 {
+			continue
+		}
+		utxo := new(match.ColdUtxo)
+		*utxo = *stored
+		utxos = append(utxos, utxo)
+	}
+	return
+}
+
+// AddRefill stores a refill that's waiting to be signed
+func (mc *MemoryColdStore) AddRefill(txid string, refill *match.RefillTx) (err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	// keep a copy so the caller can't change what's stored
+	var raw []byte
+	if raw, err = refill.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing refill for AddRefill: %s", err)
+		return
+	}
+	mc.refills[txid] = raw
+	return
+}
+
+// GetRefill gets the refill waiting to be signed with an unsigned txid, nil if there isn't one
+func (mc *MemoryColdStore) GetRefill(txid string) (refill *match.RefillTx, err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	raw, ok := mc.refills[txid]
+	if !ok {
+		return
+	}
+	refill = new(match.RefillTx)
+	if err = refill.Deserialize(raw); err != nil {
+		refill = nil
+		err = fmt.Errorf("Error deserializing refill for GetRefill: %s", err)
+		return
+	}
+	return
+}
+
+// GetRefills gets every refill waiting to be signed, by unsigned txid
+func (mc *MemoryColdStore) GetRefills() (refills []*match.RefillTx, err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	var txids []string
+	for txid := range mc.refills {
+		txids = append(txids, txid)
+	}
+	sort.Strings(txids)
+	for _, txid := range txids {
+		refill := new(match.RefillTx)
+		if err = refill.Deserialize(mc.refills[txid]); err != nil {
+			err = fmt.Errorf("Error deserializing refill for GetRefills: %s", err)
+			return
+		}
+		refills = append(refills, refill)
+	}
+	return
+}
+
+// RemoveRefill removes a refill once it's signed and sent
+func (mc *MemoryColdStore) RemoveRefill(txid string) (err error) {
+	mc.coldMtx.Lock()
+	defer mc.coldMtx.Unlock()
+
+	delete(mc.refills, txid)
+	return
+}
+
+// find returns the stored output for an outpoint, nil if there isn't one. The lock should be held
+func (mc *MemoryColdStore) find(outpoint string) (stored *match.ColdUtxo) {
+	for _, utxo := range mc.utxos {
+		if utxo.OutPoint() == outpoint {
+			return utxo
+		}
+	}
+	return
+}
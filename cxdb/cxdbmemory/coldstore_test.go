@@ -0,0 +1,40 @@
+package cxdbmemory
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestColdStoreReorg(t *testing.T) {
+	store, _ := CreateColdStore(&coinparam.RegressionNetParams)
+
+	first := &match.ColdUtxo{Txid: "firsttxid", Index: 0, Amount: 1000, Height: 10}
+	second := &match.ColdUtxo{Txid: "secondtxid", Index: 1, Amount: 2000, Height: 12}
+	if err := store.AddColdUtxos([]*match.ColdUtxo{first, second, first}); err != nil {
+		t.Fatalf("add cold utxos err: %v", err)
+	}
+	if err := store.SpendColdUtxos([]string{first.OutPoint(), "othertxid:0"}, 13); err != nil {
+		t.Fatalf("spend cold utxos err: %v", err)
+	}
+
+	utxos, err := store.GetColdUtxos()
+	if err != nil {
+		t.Fatalf("get cold utxos err: %v", err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint() != second.OutPoint() {
+		t.Errorf("only %s should be unspent, got %d unspent", second.OutPoint(), len(utxos))
+	}
+
+	// disconnecting 12 and 13 should drop the second output and unspend the first
+	if err = store.DisconnectBlocks(11); err != nil {
+		t.Fatalf("disconnect blocks err: %v", err)
+	}
+	if utxos, err = store.GetColdUtxos(); err != nil {
+		t.Fatalf("get cold utxos err: %v", err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint() != first.OutPoint() || utxos[0].SpentHeight != 0 {
+		t.Errorf("only %s should be unspent after the reorg, got %d unspent", first.OutPoint(), len(utxos))
+	}
+}
@@ -1,7 +1,9 @@
 package cxdbmemory
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/mit-dci/lit/coinparam"
@@ -11,9 +13,12 @@ import (
 )
 
 type pendingDeposit struct {
-	pubkey  [33]byte
-	amount  uint64
-	confirm uint64
+	pubkey    [33]byte
+	amount    uint64
+	txid      string
+	blockHash string
+	height    uint64
+	confirm   uint64
 }
 
 // MemoryDepositStore is a simple in-memory implementation of cxdb.DepositStore
@@ -24,8 +29,73 @@ type MemoryDepositStore struct {
 	addrToPub map[string]*koblitz.PublicKey
 	pubToAddr map[[33]byte]string
 	pending   map[uint64][]pendingDeposit
+	// deposits that have been credited, kept so a reorg can take them back
+	credited []pendingDeposit
+	// deposits from blocks that were reorged out, kept so users can see them
+	orphaned []pendingDeposit
+	// what users owe for reorged deposits they already spent
+	debts map[[33]byte]uint64
 
 	mtx *sync.Mutex
+
+	// the write-ahead log, nil if there isn't one
+	wal *writeAheadLog
+}
+
+const (
+	// wal op for RegisterUser
+	registerUserOp = "registeruser"
+	// wal op for UpdateDeposits
+	updateDepositsOp = "updatedeposits"
+	// wal op for DisconnectBlocks
+	disconnectBlocksOp = "disconnectblocks"
+	// wal op for AddDepositDebt
+	addDepositDebtOp = "adddepositdebt"
+	// wal op for PayDepositDebt
+	payDepositDebtOp = "paydepositdebt"
+)
+
+// registerUserRecord is a RegisterUser call in the wal, and a registered user in a snapshot
+type registerUserRecord struct {
+	Pubkey  []byte `json:"pubkey"`
+	Address string `json:"address"`
+}
+
+// pendingDepositRecord is a deposit in the wal or a snapshot
+type pendingDepositRecord struct {
+	Pubkey    [33]byte `json:"pubkey"`
+	Amount    uint64   `json:"amount"`
+	Txid      string   `json:"txid,omitempty"`
+	BlockHash string   `json:"blockhash,omitempty"`
+	Height    uint64   `json:"height"`
+	Confirm   uint64   `json:"confirm"`
+}
+
+// updateDepositsRecord is an UpdateDeposits call in the wal
+type updateDepositsRecord struct {
+	Deposits    []pendingDepositRecord `json:"deposits"`
+	BlockHeight uint64                 `json:"blockheight"`
+}
+
+// disconnectBlocksRecord is a DisconnectBlocks call in the wal
+type disconnectBlocksRecord struct {
+	BlockHeight uint64 `json:"blockheight"`
+}
+
+// depositDebtRecord is an AddDepositDebt or PayDepositDebt call in the wal, and a debt in a
+// snapshot
+type depositDebtRecord struct {
+	Pubkey [33]byte `json:"pubkey"`
+	Amount uint64   `json:"amount"`
+}
+
+// depositSnapshot is the state of the deposit store in a snapshot
+type depositSnapshot struct {
+	Users    []registerUserRecord   `json:"users"`
+	Pending  []pendingDepositRecord `json:"pending"`
+	Credited []pendingDepositRecord `json:"credited,omitempty"`
+	Orphaned []pendingDepositRecord `json:"orphaned,omitempty"`
+	Debts    []depositDebtRecord    `json:"debts,omitempty"`
 }
 
 // CreateDepositStore creates a deposit store for a specific coin.
@@ -35,12 +105,34 @@ func CreateDepositStore(coin *coinparam.Params) (store cxdb.DepositStore, err er
 		addrToPub: make(map[string]*koblitz.PublicKey),
 		pubToAddr: make(map[[33]byte]string),
 		pending:   make(map[uint64][]pendingDeposit),
+		debts:     make(map[[33]byte]uint64),
 		mtx:       new(sync.Mutex),
 	}
 	store = md
 	return
 }
 
+// CreateDepositStoreWithWAL creates a deposit store for a specific coin, backed by a write-ahead
+// log. Deposit addresses and pending deposits are rebuilt from the log if there is one.
+func CreateDepositStoreWithWAL(coin *coinparam.Params, conf *WALConfig) (store cxdb.DepositStore, err error) {
+	md := &MemoryDepositStore{
+		coin:      coin,
+		addrToPub: make(map[string]*koblitz.PublicKey),
+		pubToAddr: make(map[[33]byte]string),
+		pending:   make(map[uint64][]pendingDeposit),
+		debts:     make(map[[33]byte]uint64),
+		mtx:       new(sync.Mutex),
+	}
+
+	if md.wal, err = openWAL(conf, "depositstore", coin.Name, md); err != nil {
+		err = fmt.Errorf("Error opening wal for CreateDepositStoreWithWAL: %s", err)
+		return
+	}
+
+	store = md
+	return
+}
+
 // CreateDepositStoreMap creates a map of coin to deposit store for a list of coins.
 func CreateDepositStoreMap(coinList []*coinparam.Params) (depositMap map[*coinparam.Params]cxdb.DepositStore, err error) {
 	depositMap = make(map[*coinparam.Params]cxdb.DepositStore)
@@ -54,16 +146,44 @@ func CreateDepositStoreMap(coinList []*coinparam.Params) (depositMap map[*coinpa
 	return
 }
 
+// CreateDepositStoreMapWithWAL creates a map of coin to deposit store for a list of coins, each
+// backed by a write-ahead log in the same directory.
+func CreateDepositStoreMapWithWAL(coinList []*coinparam.Params, conf *WALConfig) (depositMap map[*coinparam.Params]cxdb.DepositStore, err error) {
+	depositMap = make(map[*coinparam.Params]cxdb.DepositStore)
+	var cur cxdb.DepositStore
+	for _, coin := range coinList {
+		if cur, err = CreateDepositStoreWithWAL(coin, conf); err != nil {
+			return
+		}
+		depositMap[coin] = cur
+	}
+	return
+}
+
 // RegisterUser associates a pubkey with a deposit address.
 func (md *MemoryDepositStore) RegisterUser(pubkey *koblitz.PublicKey, address string) (err error) {
 	md.mtx.Lock()
 	defer md.mtx.Unlock()
 
+	if err = md.wal.logAndApply(registerUserOp, registerUserRecord{
+		Pubkey:  pubkey.SerializeCompressed(),
+		Address: address,
+	}, func() (err error) {
+		md.registerUser(pubkey, address)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error registering user for RegisterUser: %s", err)
+		return
+	}
+	return
+}
+
+// registerUser associates a pubkey with a deposit address, the lock should be held
+func (md *MemoryDepositStore) registerUser(pubkey *koblitz.PublicKey, address string) {
 	var pk [33]byte
 	copy(pk[:], pubkey.SerializeCompressed())
 	md.pubToAddr[pk] = address
 	md.addrToPub[address] = pubkey
-	return
 }
 
 // UpdateDeposits updates pending deposits and returns settlement executions for
@@ -78,17 +198,71 @@ func (md *MemoryDepositStore) UpdateDeposits(deposits []match.Deposit, blockheig
 	md.mtx.Lock()
 	defer md.mtx.Unlock()
 
-	// record new deposits
+	rec := updateDepositsRecord{
+		BlockHeight: blockheight,
+	}
 	for _, dep := range deposits {
-		exp := dep.BlockHeightReceived + dep.Confirmations
-		var pd pendingDeposit
-		copy(pd.pubkey[:], dep.Pubkey.SerializeCompressed())
-		pd.amount = dep.Amount
-		pd.confirm = exp
-		md.pending[exp] = append(md.pending[exp], pd)
+		var pd pendingDepositRecord
+		copy(pd.Pubkey[:], dep.Pubkey.SerializeCompressed())
+		pd.Amount = dep.Amount
+		pd.Txid = dep.Txid
+		pd.BlockHash = dep.BlockHash
+		pd.Height = dep.BlockHeightReceived
+		pd.Confirm = dep.BlockHeightReceived + dep.Confirmations
+		rec.Deposits = append(rec.Deposits, pd)
 	}
 
-	if list, ok := md.pending[blockheight]; ok {
+	if err = md.wal.logAndApply(updateDepositsOp, rec, func() (err error) {
+		depositExecs = md.updateDeposits(rec, asset)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error updating deposits for UpdateDeposits: %s", err)
+		return
+	}
+
+	return
+}
+
+// DisconnectBlocks rolls deposits back after a reorg, when every block above blockheight has been
+// disconnected, and returns execs taking back whatever had already been credited.
+func (md *MemoryDepositStore) DisconnectBlocks(blockheight uint64) (rollbackExecs []*match.SettlementExecution, err error) {
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(md.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for DisconnectBlocks: %s", err)
+		return
+	}
+
+	md.mtx.Lock()
+	defer md.mtx.Unlock()
+
+	if err = md.wal.logAndApply(disconnectBlocksOp, disconnectBlocksRecord{
+		BlockHeight: blockheight,
+	}, func() (err error) {
+		rollbackExecs = md.disconnectBlocks(blockheight, asset)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error disconnecting blocks for DisconnectBlocks: %s", err)
+		return
+	}
+
+	return
+}
+
+// updateDeposits records new pending deposits and returns settlement executions for the ones that
+// have enough confirmations at the record's block height, the lock should be held
+func (md *MemoryDepositStore) updateDeposits(rec updateDepositsRecord, asset match.Asset) (depositExecs []*match.SettlementExecution) {
+	// record new deposits
+	for _, dep := range rec.Deposits {
+		pd := pendingDepositFromRecord(dep)
+		md.pending[pd.confirm] = append(md.pending[pd.confirm], pd)
+	}
+
+	// anything at or below the height is confirmed, since a deposit that went back to pending in
+	// a reorg might be past its confirm height by the time the new chain catches up
+	for confirm, list := range md.pending {
+		if confirm > rec.BlockHeight {
+			continue
+		}
 		for _, pd := range list {
 			exec := &match.SettlementExecution{
 				Pubkey: pd.pubkey,
@@ -97,10 +271,315 @@ func (md *MemoryDepositStore) UpdateDeposits(deposits []match.Deposit, blockheig
 				Type:   match.Debit,
 			}
 			depositExecs = append(depositExecs, exec)
+			md.credited = append(md.credited, pd)
+		}
+		delete(md.pending, confirm)
+	}
+
+	return
+}
+
+// disconnectBlocks takes back credited deposits that didn't have enough confirmations at the
+// block height, and orphans deposits from blocks above it, the lock should be held
+func (md *MemoryDepositStore) disconnectBlocks(blockheight uint64, asset match.Asset) (rollbackExecs []*match.SettlementExecution) {
+	var stillCredited []pendingDeposit
+	for _, pd := range md.credited {
+		if pd.confirm <= blockheight {
+			stillCredited = append(stillCredited, pd)
+			continue
+		}
+
+		// Credit is the one that takes away from a balance
+		exec := &match.SettlementExecution{
+			Pubkey: pd.pubkey,
+			Amount: pd.amount,
+			Asset:  asset,
+			Type:   match.Credit,
 		}
-		delete(md.pending, blockheight)
+		rollbackExecs = append(rollbackExecs, exec)
+
+		if pd.height <= blockheight {
+			md.pending[pd.confirm] = append(md.pending[pd.confirm], pd)
+			continue
+		}
+		md.orphaned = append(md.orphaned, pd)
 	}
+	md.credited = stillCredited
 
+	for confirm, list := range md.pending {
+		var kept []pendingDeposit
+		for _, pd := range list {
+			if pd.height <= blockheight {
+				kept = append(kept, pd)
+				continue
+			}
+			md.orphaned = append(md.orphaned, pd)
+		}
+		if len(kept) == 0 {
+			delete(md.pending, confirm)
+			continue
+		}
+		md.pending[confirm] = kept
+	}
+
+	return
+}
+
+// pendingDepositFromRecord creates a pending deposit from its wal or snapshot record
+func pendingDepositFromRecord(rec pendingDepositRecord) (pd pendingDeposit) {
+	pd = pendingDeposit{
+		pubkey:    rec.Pubkey,
+		amount:    rec.Amount,
+		txid:      rec.Txid,
+		blockHash: rec.BlockHash,
+		height:    rec.Height,
+		confirm:   rec.Confirm,
+	}
+	return
+}
+
+// record creates the wal or snapshot record for a pending deposit
+func (pd pendingDeposit) record() (rec pendingDepositRecord) {
+	rec = pendingDepositRecord{
+		Pubkey:    pd.pubkey,
+		Amount:    pd.amount,
+		Txid:      pd.txid,
+		BlockHash: pd.blockHash,
+		Height:    pd.height,
+		Confirm:   pd.confirm,
+	}
+	return
+}
+
+// DestroyHandler takes a final snapshot and closes the write-ahead log, if there is one
+func (md *MemoryDepositStore) DestroyHandler() (err error) {
+	md.mtx.Lock()
+	defer md.mtx.Unlock()
+	if err = md.wal.close(); err != nil {
+		err = fmt.Errorf("Error closing wal for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+func (md *MemoryDepositStore) snapshotState() (state interface{}, err error) {
+	var snap depositSnapshot
+	for addr, pubkey := range md.addrToPub {
+		snap.Users = append(snap.Users, registerUserRecord{
+			Pubkey:  pubkey.SerializeCompressed(),
+			Address: addr,
+		})
+	}
+	for _, list := range md.pending {
+		for _, pd := range list {
+			snap.Pending = append(snap.Pending, pd.record())
+		}
+	}
+	for _, pd := range md.credited {
+		snap.Credited = append(snap.Credited, pd.record())
+	}
+	for _, pd := range md.orphaned {
+		snap.Orphaned = append(snap.Orphaned, pd.record())
+	}
+	for pubkey, debt := range md.debts {
+		snap.Debts = append(snap.Debts, depositDebtRecord{Pubkey: pubkey, Amount: debt})
+	}
+	state = snap
+	return
+}
+
+func (md *MemoryDepositStore) restoreState(state []byte) (err error) {
+	var snap depositSnapshot
+	if err = json.Unmarshal(state, &snap); err != nil {
+		err = fmt.Errorf("Error unmarshalling deposit store snapshot: %s", err)
+		return
+	}
+
+	md.addrToPub = make(map[string]*koblitz.PublicKey)
+	md.pubToAddr = make(map[[33]byte]string)
+	md.pending = make(map[uint64][]pendingDeposit)
+	md.credited = nil
+	md.orphaned = nil
+	md.debts = make(map[[33]byte]uint64)
+	for _, user := range snap.Users {
+		if err = md.replayRegisterUser(user); err != nil {
+			return
+		}
+	}
+	for _, pd := range snap.Pending {
+		md.pending[pd.Confirm] = append(md.pending[pd.Confirm], pendingDepositFromRecord(pd))
+	}
+	for _, pd := range snap.Credited {
+		md.credited = append(md.credited, pendingDepositFromRecord(pd))
+	}
+	for _, pd := range snap.Orphaned {
+		md.orphaned = append(md.orphaned, pendingDepositFromRecord(pd))
+	}
+	for _, debt := range snap.Debts {
+		md.debts[debt.Pubkey] = debt.Amount
+	}
+	return
+}
+
+func (md *MemoryDepositStore) replayRecord(op string, data []byte) (err error) {
+	switch op {
+	case registerUserOp:
+		var rec registerUserRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling register user record: %s", err)
+			return
+		}
+		err = md.replayRegisterUser(rec)
+	case updateDepositsOp:
+		var rec updateDepositsRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling update deposits record: %s", err)
+			return
+		}
+		// the settlement executions from this were already applied, we only need the state
+		md.updateDeposits(rec, match.Asset(0))
+	case disconnectBlocksOp:
+		var rec disconnectBlocksRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling disconnect blocks record: %s", err)
+			return
+		}
+		md.disconnectBlocks(rec.BlockHeight, match.Asset(0))
+	case addDepositDebtOp:
+		var rec depositDebtRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling add deposit debt record: %s", err)
+			return
+		}
+		md.debts[rec.Pubkey] += rec.Amount
+	case payDepositDebtOp:
+		var rec depositDebtRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling pay deposit debt record: %s", err)
+			return
+		}
+		err = md.payDepositDebt(rec)
+	default:
+		err = fmt.Errorf("Unknown deposit store wal op %s", op)
+	}
+	return
+}
+
+// replayRegisterUser parses the pubkey in a register user record and registers it
+func (md *MemoryDepositStore) replayRegisterUser(rec registerUserRecord) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(rec.Pubkey, koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for registered user: %s", err)
+		return
+	}
+	md.registerUser(pubkey, rec.Address)
+	return
+}
+
+// AddDepositDebt adds to what a pubkey owes for deposits that were reorged out after they were spent
+func (md *MemoryDepositStore) AddDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	md.mtx.Lock()
+	defer md.mtx.Unlock()
+
+	rec := depositDebtRecord{Amount: amount}
+	copy(rec.Pubkey[:], pubkey.SerializeCompressed())
+	if md.debts[rec.Pubkey]+amount < md.debts[rec.Pubkey] {
+		err = fmt.Errorf("Debt of %d would overflow adding %d for AddDepositDebt", md.debts[rec.Pubkey], amount)
+		return
+	}
+
+	if err = md.wal.logAndApply(addDepositDebtOp, rec, func() (err error) {
+		md.debts[rec.Pubkey] += rec.Amount
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error adding debt for AddDepositDebt: %s", err)
+		return
+	}
+	return
+}
+
+// PayDepositDebt takes amount off what a pubkey owes for reorged deposits
+func (md *MemoryDepositStore) PayDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	md.mtx.Lock()
+	defer md.mtx.Unlock()
+
+	rec := depositDebtRecord{Amount: amount}
+	copy(rec.Pubkey[:], pubkey.SerializeCompressed())
+	if amount > md.debts[rec.Pubkey] {
+		err = fmt.Errorf("Can't pay %d for PayDepositDebt, only %d is owed", amount, md.debts[rec.Pubkey])
+		return
+	}
+
+	if err = md.wal.logAndApply(payDepositDebtOp, rec, func() (err error) {
+		return md.payDepositDebt(rec)
+	}); err != nil {
+		err = fmt.Errorf("Error paying debt for PayDepositDebt: %s", err)
+		return
+	}
+	return
+}
+
+// payDepositDebt takes the record's amount off what its pubkey owes, the lock should be held
+func (md *MemoryDepositStore) payDepositDebt(rec depositDebtRecord) (err error) {
+	debt := md.debts[rec.Pubkey]
+	if rec.Amount > debt {
+		err = fmt.Errorf("Can't pay %d, only %d is owed", rec.Amount, debt)
+		return
+	}
+	if debt == rec.Amount {
+		delete(md.debts, rec.Pubkey)
+		return
+	}
+	md.debts[rec.Pubkey] = debt - rec.Amount
+	return
+}
+
+// GetDepositDebt gets what a pubkey owes for reorged deposits
+func (md *MemoryDepositStore) GetDepositDebt(pubkey *koblitz.PublicKey) (debt uint64, err error) {
+	md.mtx.Lock()
+	defer md.mtx.Unlock()
+
+	var pk [33]byte
+	copy(pk[:], pubkey.SerializeCompressed())
+	debt = md.debts[pk]
+	return
+}
+
+// GetDeposits gets every deposit for a pubkey, oldest first, including orphaned ones
+func (md *MemoryDepositStore) GetDeposits(pubkey *koblitz.PublicKey) (deposits []*match.DepositStatus, err error) {
+	md.mtx.Lock()
+	defer md.mtx.Unlock()
+
+	var pk [33]byte
+	copy(pk[:], pubkey.SerializeCompressed())
+	addDeposits := func(list []pendingDeposit, state match.DepositState) {
+		for _, pd := range list {
+			if pd.pubkey != pk {
+				continue
+			}
+			deposits = append(deposits, &match.DepositStatus{
+				Txid:                  pd.txid,
+				Amount:                pd.amount,
+				BlockHash:             pd.blockHash,
+				BlockHeight:           pd.height,
+				ConfirmationsRequired: pd.confirm - pd.height,
+				Status:                state,
+			})
+		}
+	}
+	for _, list := range md.pending {
+		addDeposits(list, match.DepositPending)
+	}
+	addDeposits(md.credited, match.DepositCredited)
+	addDeposits(md.orphaned, match.DepositOrphaned)
+
+	// pending deposits come out of a map, so sort everything to keep it stable
+	sort.SliceStable(deposits, func(i, j int) bool {
+		if deposits[i].BlockHeight != deposits[j].BlockHeight {
+			return deposits[i].BlockHeight < deposits[j].BlockHeight
+		}
+		return deposits[i].Txid < deposits[j].Txid
+	})
 	return
 }
 
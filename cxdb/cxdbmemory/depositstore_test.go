@@ -78,3 +78,90 @@ func TestMemoryDepositStoreUpdateDeposits(t *testing.T) {
 		t.Fatalf("incorrect exec pubkey")
 	}
 }
+
+// mineTestBlocks updates the store with a block at every height from start to end, with deposits
+// only in the first one, and returns all of the execs
+func mineTestBlocks(t *testing.T, store *MemoryDepositStore, deposits []match.Deposit, start uint64, end uint64) (execs []*match.SettlementExecution) {
+	for height := start; height <= end; height++ {
+		var blockDeposits []match.Deposit
+		if height == start {
+			blockDeposits = deposits
+		}
+		blockExecs, err := store.UpdateDeposits(blockDeposits, height)
+		if err != nil {
+			t.Fatalf("update deposits at %d: %v", height, err)
+		}
+		execs = append(execs, blockExecs...)
+	}
+	return
+}
+
+// TestMemoryDepositStoreReorg mines a deposit to confirmation, and another one that's still
+// pending, then reorgs both of those blocks out like a regtest invalidateblock would
+func TestMemoryDepositStoreReorg(t *testing.T) {
+	storeIface, _ := CreateDepositStore(&coinparam.RegressionNetParams)
+	store := storeIface.(*MemoryDepositStore)
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{4})
+	pub := priv.PubKey()
+	addr := "addr4"
+	_ = store.RegisterUser(pub, addr)
+
+	confirmed := match.Deposit{
+		Pubkey:              pub,
+		Address:             addr,
+		Amount:              100,
+		Txid:                "confirmedtx",
+		CoinType:            &coinparam.RegressionNetParams,
+		BlockHeightReceived: 100,
+		BlockHash:           "block100",
+		Confirmations:       6,
+	}
+	pending := confirmed
+	pending.Amount = 50
+	pending.Txid = "pendingtx"
+	pending.BlockHeightReceived = 104
+	pending.BlockHash = "block104"
+
+	execs := mineTestBlocks(t, store, []match.Deposit{confirmed}, 100, 103)
+	execs = append(execs, mineTestBlocks(t, store, []match.Deposit{pending}, 104, 106)...)
+	if len(execs) != 1 || execs[0].Amount != confirmed.Amount || execs[0].Type != match.Debit {
+		t.Fatalf("expected a single debit for the confirmed deposit, got %v", execs)
+	}
+
+	// blocks 104 and up are reorged out, so the confirmed deposit only has 4 confirmations
+	rollbacks, err := store.DisconnectBlocks(103)
+	if err != nil {
+		t.Fatalf("disconnect blocks: %v", err)
+	}
+	if len(rollbacks) != 1 || rollbacks[0].Amount != confirmed.Amount || rollbacks[0].Type != match.Credit {
+		t.Fatalf("expected a single credit taking back the confirmed deposit, got %v", rollbacks)
+	}
+	if rollbacks[0].Pubkey != execs[0].Pubkey {
+		t.Fatalf("rollback should be for the depositor")
+	}
+
+	// the new chain doesn't have the pending deposit, so only the first deposit confirms again
+	if execs = mineTestBlocks(t, store, nil, 104, 112); len(execs) != 1 || execs[0].Amount != confirmed.Amount {
+		t.Fatalf("expected the first deposit to be credited once on the new chain, got %v", execs)
+	}
+
+	// a reorg below the confirmation shouldn't take back anything that was credited deeper
+	if rollbacks, err = store.DisconnectBlocks(110); err != nil || len(rollbacks) != 0 {
+		t.Fatalf("shallow reorg should not roll anything back, got %v, err %v", rollbacks, err)
+	}
+
+	deposits, err := store.GetDeposits(pub)
+	if err != nil {
+		t.Fatalf("get deposits: %v", err)
+	}
+	if len(deposits) != 2 {
+		t.Fatalf("expected both deposits, got %d", len(deposits))
+	}
+	if deposits[0].Txid != confirmed.Txid || deposits[0].Status != match.DepositCredited || deposits[0].ConfirmationsRequired != 6 {
+		t.Errorf("first deposit should be credited and need 6 confirmations, got %s %s %d", deposits[0].Txid, deposits[0].Status, deposits[0].ConfirmationsRequired)
+	}
+	if deposits[1].Txid != pending.Txid || deposits[1].Status != match.DepositOrphaned || deposits[1].BlockHash != pending.BlockHash {
+		t.Errorf("second deposit should be orphaned in its old block, got %s %s %s", deposits[1].Txid, deposits[1].Status, deposits[1].BlockHash)
+	}
+}
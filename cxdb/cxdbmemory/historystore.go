@@ -0,0 +1,151 @@
+package cxdbmemory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemoryHistoryStore keeps order and fill history in memory
+type MemoryHistoryStore struct {
+	// orders and fills are in the order they were added, so an entry's seq is its index plus one
+	orders     []*match.OrderHistoryEntry
+	fills      []*match.FillEntry
+	orderIndex map[match.OrderID]*match.OrderHistoryEntry
+	historyMtx *sync.Mutex
+}
+
+// CreateHistoryStore creates an in memory history store
+func CreateHistoryStore() (store cxdb.HistoryStore, err error) {
+	mh := &MemoryHistoryStore{
+		orderIndex: make(map[match.OrderID]*match.OrderHistoryEntry),
+		historyMtx: new(sync.Mutex),
+	}
+	store = mh
+	return
+}
+
+// RecordPlace adds a newly placed order to the history
+func (mh *MemoryHistoryStore) RecordPlace(limitIDPair *match.LimitOrderIDPair) (err error) {
+	mh.historyMtx.Lock()
+	defer mh.historyMtx.Unlock()
+
+	if _, ok := mh.orderIndex[*limitIDPair.OrderID]; ok {
+		err = fmt.Errorf("Error, order %x is already in the history", limitIDPair.OrderID[:])
+		return
+	}
+
+	entry := match.NewOrderHistoryEntry(limitIDPair)
+	entry.Seq = uint64(len(mh.orders)) + 1
+	mh.orders = append(mh.orders, entry)
+	mh.orderIndex[entry.OrderID] = entry
+	return
+}
+
+// RecordExec updates an order with an execution and adds the fill to the history
+func (mh *MemoryHistoryStore) RecordExec(orderExec *match.OrderExecution, execTime time.Time) (err error) {
+	mh.historyMtx.Lock()
+	defer mh.historyMtx.Unlock()
+
+	var entry *match.OrderHistoryEntry
+	var ok bool
+	if entry, ok = mh.orderIndex[orderExec.OrderID]; !ok {
+		err = fmt.Errorf("Error recording exec, order %x not in history", orderExec.OrderID[:])
+		return
+	}
+
+	fill := entry.ApplyExec(orderExec, execTime)
+	fill.Seq = uint64(len(mh.fills)) + 1
+	mh.fills = append(mh.fills, fill)
+	return
+}
+
+// RecordCancel marks an order as cancelled
+func (mh *MemoryHistoryStore) RecordCancel(cancel *match.CancelledOrder, cancelTime time.Time) (err error) {
+	mh.historyMtx.Lock()
+	defer mh.historyMtx.Unlock()
+
+	var entry *match.OrderHistoryEntry
+	var ok bool
+	if entry, ok = mh.orderIndex[*cancel.OrderID]; !ok {
+		err = fmt.Errorf("Error recording cancel, order %x not in history", cancel.OrderID[:])
+		return
+	}
+	entry.ApplyCancel(cancelTime)
+	return
+}
+
+// RecordExpire marks an order as expired
+func (mh *MemoryHistoryStore) RecordExpire(orderID *match.OrderID, expireTime time.Time) (err error) {
+	mh.historyMtx.Lock()
+	defer mh.historyMtx.Unlock()
+
+	var entry *match.OrderHistoryEntry
+	var ok bool
+	if entry, ok = mh.orderIndex[*orderID]; !ok {
+		err = fmt.Errorf("Error recording expiry, order %x not in history", orderID[:])
+		return
+	}
+	entry.Status = match.OrderExpired
+	entry.Updated = expireTime
+	return
+}
+
+// GetOrderHistory gets orders for a pubkey, newest first, along with the cursor for the next page.
+func (mh *MemoryHistoryStore) GetOrderHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error) {
+	mh.historyMtx.Lock()
+	defer mh.historyMtx.Unlock()
+
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+
+	limit := query.PageLimit()
+	for i := len(mh.orders) - 1; i >= 0; i-- {
+		entry := mh.orders[i]
+		if entry.Order.Pubkey != pkBytes || !query.MatchesOrder(entry) {
+			continue
+		}
+		if uint64(len(orders)) == limit {
+			nextCursor = orders[len(orders)-1].Seq
+			return
+		}
+
+		// copy so the caller can't change the history
+		entryCopy := new(match.OrderHistoryEntry)
+		*entryCopy = *entry
+		entryCopy.Order = new(match.LimitOrder)
+		*entryCopy.Order = *entry.Order
+		orders = append(orders, entryCopy)
+	}
+	return
+}
+
+// GetFillHistory gets fills for a pubkey, newest first, along with the cursor for the next page.
+func (mh *MemoryHistoryStore) GetFillHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error) {
+	mh.historyMtx.Lock()
+	defer mh.historyMtx.Unlock()
+
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+
+	limit := query.PageLimit()
+	for i := len(mh.fills) - 1; i >= 0; i-- {
+		fill := mh.fills[i]
+		if fill.Pubkey != pkBytes || !query.MatchesFill(fill) {
+			continue
+		}
+		if uint64(len(fills)) == limit {
+			nextCursor = fills[len(fills)-1].Seq
+			return
+		}
+
+		fillCopy := new(match.FillEntry)
+		*fillCopy = *fill
+		fills = append(fills, fillCopy)
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package cxdbmemory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+func createTestHistoryOrder(pub *koblitz.PublicKey, id byte, placed time.Time) *match.LimitOrderIDPair {
+	order := &match.LimitOrder{
+		Side:        match.Buy,
+		TradingPair: *createTestPair(),
+		AmountHave:  1000,
+		AmountWant:  2000,
+	}
+	copy(order.Pubkey[:], pub.SerializeCompressed())
+	pr, _ := order.Price()
+	return &match.LimitOrderIDPair{
+		OrderID:   &match.OrderID{id},
+		Order:     order,
+		Price:     pr,
+		Timestamp: placed,
+	}
+}
+
+// recordTestHistory records three orders for pub: one partially filled then cancelled, one
+// filled, and one cancelled. It returns them oldest first.
+func recordTestHistory(t *testing.T, store cxdb.HistoryStore, pub *koblitz.PublicKey, start time.Time) (orders []*match.LimitOrderIDPair) {
+	for i := 0; i < 3; i++ {
+		order := createTestHistoryOrder(pub, byte(i+1), start.Add(time.Duration(i)*time.Minute))
+		if err := store.RecordPlace(order); err != nil {
+			t.Fatalf("record place err: %v", err)
+		}
+		orders = append(orders, order)
+	}
+
+	partial := &match.OrderExecution{OrderID: *orders[0].OrderID, NewAmountHave: 600, NewAmountWant: 1200}
+	if err := store.RecordExec(partial, start.Add(3*time.Minute)); err != nil {
+		t.Fatalf("record partial exec err: %v", err)
+	}
+	filled := &match.OrderExecution{OrderID: *orders[1].OrderID, Filled: true}
+	if err := store.RecordExec(filled, start.Add(4*time.Minute)); err != nil {
+		t.Fatalf("record filled exec err: %v", err)
+	}
+	for _, order := range []*match.LimitOrderIDPair{orders[0], orders[2]} {
+		if err := store.RecordCancel(&match.CancelledOrder{OrderID: order.OrderID}, start.Add(5*time.Minute)); err != nil {
+			t.Fatalf("record cancel err: %v", err)
+		}
+	}
+	return
+}
+
+func TestHistoryStoreStatuses(t *testing.T) {
+	store, _ := CreateHistoryStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	start := time.Unix(1500000000, 0)
+	orders := recordTestHistory(t, store, pub, start)
+
+	got, nextCursor, err := store.GetOrderHistory(pub, &match.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("get order history err: %v", err)
+	}
+	if nextCursor != 0 {
+		t.Errorf("everything fits on one page, next cursor should be 0, got %d", nextCursor)
+	}
+	expected := []match.OrderStatus{match.OrderCancelled, match.OrderFilled, match.OrderPartiallyCancelled}
+	if len(got) != len(expected) {
+		t.Fatalf("should have %d orders, got %d", len(expected), len(got))
+	}
+	for i, entry := range got {
+		if entry.OrderID != *orders[len(orders)-1-i].OrderID {
+			t.Errorf("orders should be newest first, got %x at %d", entry.OrderID, i)
+		}
+		if entry.Status != expected[i] {
+			t.Errorf("order %d should be %s, got %s", i, expected[i], entry.Status)
+		}
+	}
+	if got[2].AmountHaveFilled != 400 || got[2].AmountWantFilled != 800 {
+		t.Errorf("partially cancelled order should have filled 400/800, got %d/%d", got[2].AmountHaveFilled, got[2].AmountWantFilled)
+	}
+
+	fills, _, err := store.GetFillHistory(pub, &match.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("get fill history err: %v", err)
+	}
+	if len(fills) != 2 {
+		t.Fatalf("should have 2 fills, got %d", len(fills))
+	}
+	if fills[0].OrderID != *orders[1].OrderID || fills[0].AmountHave != 1000 || fills[0].AmountWant != 2000 {
+		t.Errorf("newest fill should fill all of the second order, got %x %d/%d", fills[0].OrderID, fills[0].AmountHave, fills[0].AmountWant)
+	}
+	if fills[1].OrderID != *orders[0].OrderID || fills[1].AmountHave != 400 || fills[1].AmountWant != 800 {
+		t.Errorf("oldest fill should be 400/800 of the first order, got %x %d/%d", fills[1].OrderID, fills[1].AmountHave, fills[1].AmountWant)
+	}
+
+	// other pubkeys shouldn't see any of it
+	otherPriv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	if got, _, err = store.GetOrderHistory(otherPriv.PubKey(), &match.HistoryQuery{}); err != nil || len(got) != 0 {
+		t.Errorf("other pubkey should have no history, got %d orders, err %v", len(got), err)
+	}
+
+	if err = store.RecordExec(&match.OrderExecution{OrderID: match.OrderID{0xff}, Filled: true}, start); err == nil {
+		t.Errorf("exec for an order that isn't in the history should fail")
+	}
+}
+
+func TestHistoryStoreQuery(t *testing.T) {
+	store, _ := CreateHistoryStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	start := time.Unix(1500000000, 0)
+	orders := recordTestHistory(t, store, pub, start)
+
+	// page through two at a time
+	page, nextCursor, err := store.GetOrderHistory(pub, &match.HistoryQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("get first page err: %v", err)
+	}
+	if len(page) != 2 || nextCursor != page[1].Seq {
+		t.Fatalf("first page should have 2 orders and point after the last one, got %d orders and cursor %d", len(page), nextCursor)
+	}
+	if page, nextCursor, err = store.GetOrderHistory(pub, &match.HistoryQuery{Limit: 2, Cursor: nextCursor}); err != nil {
+		t.Fatalf("get second page err: %v", err)
+	}
+	if len(page) != 1 || page[0].OrderID != *orders[0].OrderID || nextCursor != 0 {
+		t.Errorf("second page should just have the first order and no cursor, got %d orders and cursor %d", len(page), nextCursor)
+	}
+
+	statusQuery := &match.HistoryQuery{Statuses: []match.OrderStatus{match.OrderFilled, match.OrderPartiallyCancelled}}
+	if page, _, err = store.GetOrderHistory(pub, statusQuery); err != nil {
+		t.Fatalf("get by status err: %v", err)
+	}
+	if len(page) != 2 || page[0].OrderID != *orders[1].OrderID || page[1].OrderID != *orders[0].OrderID {
+		t.Errorf("status filter should return the filled and partially cancelled orders, got %d orders", len(page))
+	}
+
+	timeQuery := &match.HistoryQuery{Start: start.Add(time.Minute), End: start.Add(time.Minute)}
+	if page, _, err = store.GetOrderHistory(pub, timeQuery); err != nil {
+		t.Fatalf("get by time err: %v", err)
+	}
+	if len(page) != 1 || page[0].OrderID != *orders[1].OrderID {
+		t.Errorf("time filter should only return the order placed at that time, got %d orders", len(page))
+	}
+
+	otherPair := &match.Pair{AssetWant: createTestPair().AssetHave, AssetHave: createTestPair().AssetWant}
+	if page, _, err = store.GetOrderHistory(pub, &match.HistoryQuery{Pair: otherPair}); err != nil || len(page) != 0 {
+		t.Errorf("no orders were placed on the other pair, got %d orders, err %v", len(page), err)
+	}
+}
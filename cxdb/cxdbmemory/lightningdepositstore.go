@@ -0,0 +1,172 @@
+package cxdbmemory
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// lightningDepositKey is the channel and state a lightning deposit was made in
+type lightningDepositKey struct {
+	outpoint string
+	stateIdx uint64
+}
+
+// MemoryLightningDepositStore keeps lightning deposits and invoices in memory
+type MemoryLightningDepositStore struct {
+	// deposits are in the order they were added
+	deposits   []*match.LightningDeposit
+	depositSet map[lightningDepositKey]bool
+	// invoices are in the order they were added, invoiceIndex maps an invoice's hash to its index
+	invoices     []*match.Invoice
+	invoiceIndex map[[32]byte]int
+	depositMtx   *sync.Mutex
+}
+
+// CreateLightningDepositStore creates an in memory lightning deposit store
+func CreateLightningDepositStore() (store cxdb.LightningDepositStore, err error) {
+	store = &MemoryLightningDepositStore{
+		depositSet:   make(map[lightningDepositKey]bool),
+		invoiceIndex: make(map[[32]byte]int),
+		depositMtx:   new(sync.Mutex),
+	}
+	return
+}
+
+// AddLightningDeposit stores a deposit, added is false if there's already one for the channel and
+// state
+func (ms *MemoryLightningDepositStore) AddLightningDeposit(deposit *match.LightningDeposit) (added bool, err error) {
+	ms.depositMtx.Lock()
+	defer ms.depositMtx.Unlock()
+
+	key := lightningDepositKey{outpoint: deposit.Outpoint, stateIdx: deposit.StateIdx}
+	if ms.depositSet[key] {
+		return
+	}
+	stored := new(match.LightningDeposit)
+	*stored = *deposit
+	ms.depositSet[key] = true
+	ms.deposits = append(ms.deposits, stored)
+	added = true
+	return
+}
+
+// GetLightningDeposits gets every deposit for a pubkey, oldest first
+func (ms *MemoryLightningDepositStore) GetLightningDeposits(pubkey *koblitz.PublicKey) (deposits []*match.LightningDeposit, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	deposits = ms.filterDeposits(func(deposit *match.LightningDeposit) bool {
+		return bytes.Equal(deposit.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetChannelDeposits gets every deposit over a channel, oldest first
+func (ms *MemoryLightningDepositStore) GetChannelDeposits(outpoint string) (deposits []*match.LightningDeposit, err error) {
+	deposits = ms.filterDeposits(func(deposit *match.LightningDeposit) bool {
+		return deposit.Outpoint == outpoint
+	})
+	return
+}
+
+// filterDeposits returns copies of the deposits that keep returns true for, oldest first
+func (ms *MemoryLightningDepositStore) filterDeposits(keep func(*match.LightningDeposit) bool) (deposits []*match.LightningDeposit) {
+	ms.depositMtx.Lock()
+	defer ms.depositMtx.Unlock()
+
+	for _, stored := range ms.deposits {
+		if keep(stored) {
+			deposit := new(match.LightningDeposit)
+			*deposit = *stored
+			deposits = append(deposits, deposit)
+		}
+	}
+	return
+}
+
+// AddInvoice stores a new invoice
+func (ms *MemoryLightningDepositStore) AddInvoice(invoice *match.Invoice) (err error) {
+	ms.depositMtx.Lock()
+	defer ms.depositMtx.Unlock()
+
+	if _, ok := ms.invoiceIndex[invoice.RHash]; ok {
+		err = fmt.Errorf("Error adding invoice, invoice %s already exists", invoice.ID())
+		return
+	}
+	// keep a copy so callers can't change what's stored without UpdateInvoice
+	stored := new(match.Invoice)
+	*stored = *invoice
+	ms.invoiceIndex[invoice.RHash] = len(ms.invoices)
+	ms.invoices = append(ms.invoices, stored)
+	return
+}
+
+// UpdateInvoice saves the amount paid, state, reason and update time of a stored invoice
+func (ms *MemoryLightningDepositStore) UpdateInvoice(invoice *match.Invoice) (err error) {
+	ms.depositMtx.Lock()
+	defer ms.depositMtx.Unlock()
+
+	idx, ok := ms.invoiceIndex[invoice.RHash]
+	if !ok {
+		err = fmt.Errorf("Error updating invoice, no invoice %s", invoice.ID())
+		return
+	}
+
+	stored := ms.invoices[idx]
+	stored.AmountPaid = invoice.AmountPaid
+	stored.State = invoice.State
+	stored.Reason = invoice.Reason
+	stored.Updated = invoice.Updated
+	return
+}
+
+// GetInvoice gets an invoice by its hash
+func (ms *MemoryLightningDepositStore) GetInvoice(rhash [32]byte) (invoice *match.Invoice, err error) {
+	ms.depositMtx.Lock()
+	defer ms.depositMtx.Unlock()
+
+	idx, ok := ms.invoiceIndex[rhash]
+	if !ok {
+		err = fmt.Errorf("Error getting invoice, no invoice %x", rhash)
+		return
+	}
+
+	invoice = new(match.Invoice)
+	*invoice = *ms.invoices[idx]
+	return
+}
+
+// GetInvoices gets every invoice for a pubkey, oldest first
+func (ms *MemoryLightningDepositStore) GetInvoices(pubkey *koblitz.PublicKey) (invoices []*match.Invoice, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	invoices = ms.filterInvoices(func(invoice *match.Invoice) bool {
+		return bytes.Equal(invoice.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetInvoicesByState gets every invoice in a state, oldest first
+func (ms *MemoryLightningDepositStore) GetInvoicesByState(state match.InvoiceState) (invoices []*match.Invoice, err error) {
+	invoices = ms.filterInvoices(func(invoice *match.Invoice) bool {
+		return invoice.State == state
+	})
+	return
+}
+
+// filterInvoices returns copies of the invoices that keep returns true for, oldest first
+func (ms *MemoryLightningDepositStore) filterInvoices(keep func(*match.Invoice) bool) (invoices []*match.Invoice) {
+	ms.depositMtx.Lock()
+	defer ms.depositMtx.Unlock()
+
+	for _, stored := range ms.invoices {
+		if keep(stored) {
+			invoice := new(match.Invoice)
+			*invoice = *stored
+			invoices = append(invoices, invoice)
+		}
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package cxdbmemory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestLightningDepositStoreIdempotent(t *testing.T) {
+	store, _ := CreateLightningDepositStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+
+	deposit := &match.LightningDeposit{Asset: match.BTCTest, Amount: 5000, Outpoint: "ab:0", StateIdx: 1, ChannelAmount: 5000, Received: time.Unix(1500000000, 0)}
+	copy(deposit.Pubkey[:], pub.SerializeCompressed())
+	if added, err := store.AddLightningDeposit(deposit); err != nil || !added {
+		t.Fatalf("first deposit should be added, got %t, %v", added, err)
+	}
+
+	// the same channel state seen again, even with a different amount, isn't a new deposit
+	replayed := *deposit
+	replayed.Amount = 9000
+	if added, err := store.AddLightningDeposit(&replayed); err != nil || added {
+		t.Errorf("replayed deposit shouldn't be added, got %t, %v", added, err)
+	}
+
+	next := *deposit
+	next.StateIdx = 2
+	if added, err := store.AddLightningDeposit(&next); err != nil || !added {
+		t.Errorf("deposit in the next state should be added, got %t, %v", added, err)
+	}
+	other := *deposit
+	other.Outpoint = "cd:1"
+	if added, err := store.AddLightningDeposit(&other); err != nil || !added {
+		t.Errorf("deposit over another channel should be added, got %t, %v", added, err)
+	}
+
+	channel, err := store.GetChannelDeposits("ab:0")
+	if err != nil || len(channel) != 2 || channel[0].Amount != 5000 || channel[1].StateIdx != 2 {
+		t.Errorf("channel should have its 2 deposits in order, got %d, %v", len(channel), err)
+	}
+	all, err := store.GetLightningDeposits(pub)
+	if err != nil || len(all) != 3 {
+		t.Errorf("pubkey should have 3 deposits, got %d, %v", len(all), err)
+	}
+}
+
+func TestLightningDepositStoreInvoices(t *testing.T) {
+	store, _ := CreateLightningDepositStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	start := time.Unix(1500000000, 0)
+
+	var pubkey [33]byte
+	copy(pubkey[:], pub.SerializeCompressed())
+	request := &match.InvoiceRequest{Asset: match.BTCTest, Amount: 5000, Expiry: start.Add(time.Hour).Unix()}
+	invoice, err := match.NewInvoice(request, pubkey, "ln1exchange", start)
+	if err != nil {
+		t.Fatalf("new invoice err: %v", err)
+	}
+	if err = store.AddInvoice(invoice); err != nil {
+		t.Fatalf("add invoice err: %v", err)
+	}
+	if err = store.AddInvoice(invoice); err == nil {
+		t.Errorf("adding an invoice twice should fail")
+	}
+
+	invoice.AmountPaid = 6000
+	invoice.SetState(match.InvoicePaid, "", start.Add(time.Minute))
+	if err = store.UpdateInvoice(invoice); err != nil {
+		t.Fatalf("update invoice err: %v", err)
+	}
+
+	got, err := store.GetInvoice(invoice.RHash)
+	if err != nil || got.State != match.InvoicePaid || got.AmountPaid != 6000 || got.Preimage != invoice.Preimage {
+		t.Errorf("invoice should be paid 6000, got %v, %v", got, err)
+	}
+	if open, err := store.GetInvoicesByState(match.InvoiceOpen); err != nil || len(open) != 0 {
+		t.Errorf("there shouldn't be open invoices, got %d, %v", len(open), err)
+	}
+	all, err := store.GetInvoices(pub)
+	if err != nil || len(all) != 1 {
+		t.Errorf("pubkey should have 1 invoice, got %d, %v", len(all), err)
+	}
+	if _, err = store.GetInvoice([32]byte{}); err == nil {
+		t.Errorf("getting an invoice that doesn't exist should fail")
+	}
+}
@@ -0,0 +1,235 @@
+package cxdbmemory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/crypto/sha3"
+)
+
+// MemoryLimitEngine is a limit matching engine that keeps orders in memory. It's deterministic:
+// the same orders placed with PlaceLimitOrderAt at the same times always give the same order IDs
+// and executions, which makes it useful for replaying a log of inputs offline.
+type MemoryLimitEngine struct {
+	orders   map[match.OrderID]*memoryLimitOrder
+	limitMtx *sync.Mutex
+	pair     *match.Pair
+
+	// placed is the number of orders that have been placed, this breaks ties between orders with
+	// the same price and time, and makes identical orders have different IDs
+	placed uint64
+}
+
+// memoryLimitOrder is an order in the engine, and the order it was placed in
+type memoryLimitOrder struct {
+	idPair *match.LimitOrderIDPair
+	number uint64
+}
+
+// CreateLimitEngine creates a limit engine for a specific pair
+func CreateLimitEngine(pair *match.Pair) (engine match.LimitEngine, err error) {
+	me := &MemoryLimitEngine{
+		orders:   make(map[match.OrderID]*memoryLimitOrder),
+		limitMtx: new(sync.Mutex),
+		pair:     pair,
+	}
+	engine = me
+	return
+}
+
+// PlaceLimitOrder places an order in the limit matching engine.
+// This assumes that the order is valid and is for the same pair as the matching engine
+func (me *MemoryLimitEngine) PlaceLimitOrder(order *match.LimitOrder) (idRes *match.LimitOrderIDPair, err error) {
+	return me.PlaceLimitOrderAt(order, time.Now())
+}
+
+// PlaceLimitOrderAt places an order in the limit matching engine as if it were placed at
+// placementTime. The order ID depends on the order, the time, and how many orders were placed
+// before it.
+func (me *MemoryLimitEngine) PlaceLimitOrderAt(order *match.LimitOrder, placementTime time.Time) (idRes *match.LimitOrderIDPair, err error) {
+	if order == nil {
+		err = fmt.Errorf("Cannot place nil order, please enter valid input")
+		return
+	}
+
+	// calculate price, this also makes sure neither amount is zero
+	var price float64
+	if price, err = order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price from order while placing order: %s", err)
+		return
+	}
+
+	me.limitMtx.Lock()
+	defer me.limitMtx.Unlock()
+
+	// copy the order so the caller can't change what's in the engine
+	orderCopy := new(match.LimitOrder)
+	*orderCopy = *order
+
+	numBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(numBytes, me.placed)
+	timeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(timeBytes, uint64(placementTime.UnixNano()))
+	amountBytes := make([]byte, 16)
+	binary.BigEndian.PutUint64(amountBytes[:8], order.AmountHave)
+	binary.BigEndian.PutUint64(amountBytes[8:], order.AmountWant)
+	var sideByte byte
+	if order.Side == match.Buy {
+		sideByte = 0x01
+	}
+
+	hasher := sha3.New256()
+	hasher.Write(me.pair.Serialize())
+	hasher.Write(order.Pubkey[:])
+	hasher.Write([]byte{sideByte})
+	hasher.Write(amountBytes)
+	hasher.Write(timeBytes)
+	hasher.Write(numBytes)
+
+	stored := &match.LimitOrderIDPair{
+		OrderID:   new(match.OrderID),
+		Order:     orderCopy,
+		Price:     price,
+		Timestamp: placementTime,
+	}
+	copy(stored.OrderID[:], hasher.Sum(nil))
+
+	me.orders[*stored.OrderID] = &memoryLimitOrder{
+		idPair: stored,
+		number: me.placed,
+	}
+	me.placed++
+
+	idRes = &match.LimitOrderIDPair{
+		OrderID:   new(match.OrderID),
+		Order:     order,
+		Price:     price,
+		Timestamp: placementTime,
+	}
+	*idRes.OrderID = *stored.OrderID
+	return
+}
+
+// CancelLimitOrder cancels a limit order, this assumes that the limit order actually exists
+func (me *MemoryLimitEngine) CancelLimitOrder(orderID *match.OrderID) (cancelled *match.CancelledOrder, cancelSettlement *match.SettlementExecution, err error) {
+	me.limitMtx.Lock()
+	defer me.limitMtx.Unlock()
+
+	var order *memoryLimitOrder
+	var ok bool
+	if order, ok = me.orders[*orderID]; !ok {
+		err = fmt.Errorf("Error cancelling order, order %x not found", orderID[:])
+		return
+	}
+	delete(me.orders, *orderID)
+
+	var debitAsset match.Asset
+	if order.idPair.Order.Side == match.Buy {
+		debitAsset = me.pair.AssetHave
+	} else {
+		debitAsset = me.pair.AssetWant
+	}
+	cancelled = &match.CancelledOrder{
+		OrderID: orderID,
+	}
+	cancelSettlement = &match.SettlementExecution{
+		Pubkey: order.idPair.Order.Pubkey,
+		Amount: order.idPair.Order.AmountHave,
+		Asset:  debitAsset,
+		Type:   match.Debit,
+	}
+	return
+}
+
+// MatchLimitOrders matches limit orders based on price/time priority
+func (me *MemoryLimitEngine) MatchLimitOrders() (orderExecs []*match.OrderExecution, settlementExecs []*match.SettlementExecution, err error) {
+	me.limitMtx.Lock()
+	defer me.limitMtx.Unlock()
+
+	// The matching algorithm changes the amounts on the orders it gets, so it gets copies, and
+	// we only change what's in the engine using the executions
+	var sellOrders []*memoryLimitOrder
+	var buyOrders []*memoryLimitOrder
+	for _, order := range me.orders {
+		orderCopy := &memoryLimitOrder{
+			idPair: new(match.LimitOrderIDPair),
+			number: order.number,
+		}
+		*orderCopy.idPair = *order.idPair
+		orderCopy.idPair.Order = new(match.LimitOrder)
+		*orderCopy.idPair.Order = *order.idPair.Order
+		if order.idPair.Order.Side == match.Sell {
+			sellOrders = append(sellOrders, orderCopy)
+		} else {
+			buyOrders = append(buyOrders, orderCopy)
+		}
+	}
+
+	// sell side is ordered by price descending, buy side by price ascending, both by time ascending
+	// and then by the order they were placed in, so map ordering never matters
+	var sellPairs []*match.LimitOrderIDPair
+	var buyPairs []*match.LimitOrderIDPair
+	for _, order := range sortMemoryLimitOrders(sellOrders, false) {
+		sellPairs = append(sellPairs, order.idPair)
+	}
+	for _, order := range sortMemoryLimitOrders(buyOrders, true) {
+		buyPairs = append(buyPairs, order.idPair)
+	}
+
+	if orderExecs, settlementExecs, err = match.MatchPrioritizedOrders(buyPairs, sellPairs); err != nil {
+		err = fmt.Errorf("Error matching prioritized orders for MatchLimitOrders: %s", err)
+		return
+	}
+
+	// Update the matching engine with the new state
+	for _, orderExec := range orderExecs {
+		if orderExec.Filled {
+			delete(me.orders, orderExec.OrderID)
+			continue
+		}
+		if order, ok := me.orders[orderExec.OrderID]; ok {
+			order.idPair.Order.AmountHave = orderExec.NewAmountHave
+			order.idPair.Order.AmountWant = orderExec.NewAmountWant
+		}
+	}
+	return
+}
+
+// sortMemoryLimitOrders sorts orders by price, ascending or descending, then by time ascending,
+// then by the order they were placed in.
+func sortMemoryLimitOrders(orders []*memoryLimitOrder, priceAscending bool) []*memoryLimitOrder {
+	sort.Slice(orders, func(i, j int) bool {
+		left, right := orders[i].idPair, orders[j].idPair
+		if left.Price != right.Price {
+			if priceAscending {
+				return left.Price < right.Price
+			}
+			return left.Price > right.Price
+		}
+		if !left.Timestamp.Equal(right.Timestamp) {
+			return left.Timestamp.Before(right.Timestamp)
+		}
+		return orders[i].number < orders[j].number
+	})
+	return orders
+}
+
+// CreateLimitEngineMap creates a map of pair to limit engine, given a list of pairs.
+func CreateLimitEngineMap(pairList []*match.Pair) (limMap map[match.Pair]match.LimitEngine, err error) {
+
+	limMap = make(map[match.Pair]match.LimitEngine)
+	var curLimEng match.LimitEngine
+	for _, pair := range pairList {
+		if curLimEng, err = CreateLimitEngine(pair); err != nil {
+			err = fmt.Errorf("Error creating single limit engine while creating limit engine map: %s", err)
+			return
+		}
+		limMap[*pair] = curLimEng
+	}
+
+	return
+}
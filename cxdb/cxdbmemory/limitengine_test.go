@@ -0,0 +1,100 @@
+package cxdbmemory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func createTestLimitOrder(side match.Side, have uint64, want uint64, pubByte byte) *match.LimitOrder {
+	order := &match.LimitOrder{
+		Side:        side,
+		TradingPair: *createTestPair(),
+		AmountHave:  have,
+		AmountWant:  want,
+	}
+	order.Pubkey[0] = 0x02
+	order.Pubkey[1] = pubByte
+	return order
+}
+
+func TestLimitEngineDeterministic(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	orders := []*match.LimitOrder{
+		createTestLimitOrder(match.Buy, 1000, 1000, 0x01),
+		createTestLimitOrder(match.Buy, 1000, 1000, 0x01),
+		createTestLimitOrder(match.Sell, 1500, 1500, 0x02),
+	}
+
+	run := func() (ids []match.OrderID, orderExecs []*match.OrderExecution) {
+		engine, err := CreateLimitEngine(createTestPair())
+		if err != nil {
+			t.Fatalf("create engine err: %v", err)
+		}
+		for i, order := range orders {
+			idRes, err := engine.(*MemoryLimitEngine).PlaceLimitOrderAt(order, start.Add(time.Duration(i)*time.Second))
+			if err != nil {
+				t.Fatalf("place err: %v", err)
+			}
+			ids = append(ids, *idRes.OrderID)
+		}
+		if orderExecs, _, err = engine.MatchLimitOrders(); err != nil {
+			t.Fatalf("match err: %v", err)
+		}
+		return
+	}
+
+	firstIDs, firstExecs := run()
+	secondIDs, secondExecs := run()
+	if !reflect.DeepEqual(firstIDs, secondIDs) {
+		t.Errorf("order IDs should be the same for the same orders and times")
+	}
+	if firstIDs[0] == firstIDs[1] {
+		t.Errorf("identical orders should get different IDs")
+	}
+	if !reflect.DeepEqual(firstExecs, secondExecs) {
+		t.Errorf("executions should be the same for the same orders and times")
+	}
+}
+
+func TestLimitEngineTimePriority(t *testing.T) {
+	engine, err := CreateLimitEngine(createTestPair())
+	if err != nil {
+		t.Fatalf("create engine err: %v", err)
+	}
+	me := engine.(*MemoryLimitEngine)
+
+	start := time.Unix(1500000000, 0)
+	first, err := me.PlaceLimitOrderAt(createTestLimitOrder(match.Buy, 1000, 1000, 0x01), start)
+	if err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+	second, err := me.PlaceLimitOrderAt(createTestLimitOrder(match.Buy, 1000, 1000, 0x02), start.Add(time.Second))
+	if err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+	if _, err = me.PlaceLimitOrderAt(createTestLimitOrder(match.Sell, 1000, 1000, 0x03), start.Add(2*time.Second)); err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+
+	if _, _, err = engine.MatchLimitOrders(); err != nil {
+		t.Fatalf("match err: %v", err)
+	}
+
+	// the earlier buy should be the one that was filled
+	if _, ok := me.orders[*first.OrderID]; ok {
+		t.Errorf("earlier order should have been filled")
+	}
+	if _, ok := me.orders[*second.OrderID]; !ok {
+		t.Errorf("later order should still be in the engine")
+	}
+
+	if _, _, err = engine.CancelLimitOrder(second.OrderID); err != nil {
+		t.Fatalf("cancel err: %v", err)
+	}
+	if _, _, err = engine.CancelLimitOrder(second.OrderID); err == nil {
+		t.Errorf("cancelling a cancelled order should fail")
+	}
+}
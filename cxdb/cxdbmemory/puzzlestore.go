@@ -1,8 +1,10 @@
 package cxdbmemory
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/mit-dci/opencx/cxdb"
 	"github.com/mit-dci/opencx/match"
@@ -12,26 +14,79 @@ import (
 type MemoryPuzzleStore struct {
 	puzzles   map[match.AuctionID][]*match.EncryptedAuctionOrder
 	puzzleMtx *sync.Mutex
+	// serialized transcripts, so callers can keep changing theirs after putting it
+	transcripts map[match.AuctionID][]byte
+	// serialized auction order statuses by receipt ID, and auction results, for the same reason
+	statuses map[[32]byte][]byte
+	results  map[match.AuctionID][]byte
 	// the pair for this puzzle store
 	// this is just for convenience, the protocol still works if you have one massive puzzle store
 	// but if you run many markets at once then you may want to invalidate orders that weren't submitted
 	// for the pair they said they were
 	pair *match.Pair
+
+	// the write-ahead log, nil if there isn't one
+	wal *writeAheadLog
+}
+
+const (
+	// wal op for PlaceAuctionPuzzle
+	placePuzzleOp = "placepuzzle"
+	// wal op for PutTranscript
+	putTranscriptOp = "puttranscript"
+	// wal op for PutAuctionOrderStatus
+	putStatusOp = "putauctionstatus"
+	// wal op for PutAuctionResult
+	putResultOp = "putauctionresult"
+	// wal op for PruneAuctions
+	pruneAuctionsOp = "pruneauctions"
+)
+
+// puzzleSnapshot is the state of the puzzle store in a snapshot
+type puzzleSnapshot struct {
+	Puzzles     [][]byte `json:"puzzles"`
+	Transcripts [][]byte `json:"transcripts,omitempty"`
+	Statuses    [][]byte `json:"statuses,omitempty"`
+	Results     [][]byte `json:"results,omitempty"`
 }
 
 // CreatePuzzleStore creates a puzzle store for a specific coin.
 func CreatePuzzleStore(pair *match.Pair) (store cxdb.PuzzleStore, err error) {
 	// Set values
 	mp := &MemoryPuzzleStore{
-		puzzles:   make(map[match.AuctionID][]*match.EncryptedAuctionOrder),
-		puzzleMtx: new(sync.Mutex),
-		pair:      pair,
+		puzzles:     make(map[match.AuctionID][]*match.EncryptedAuctionOrder),
+		puzzleMtx:   new(sync.Mutex),
+		transcripts: make(map[match.AuctionID][]byte),
+		statuses:    make(map[[32]byte][]byte),
+		results:     make(map[match.AuctionID][]byte),
+		pair:        pair,
 	}
 	// Now we actually set the engine
 	store = mp
 	return
 }
 
+// CreatePuzzleStoreWithWAL creates a puzzle store for a specific pair, backed by a write-ahead
+// log. The puzzles are rebuilt from the log if there is one.
+func CreatePuzzleStoreWithWAL(pair *match.Pair, conf *WALConfig) (store cxdb.PuzzleStore, err error) {
+	mp := &MemoryPuzzleStore{
+		puzzles:     make(map[match.AuctionID][]*match.EncryptedAuctionOrder),
+		puzzleMtx:   new(sync.Mutex),
+		transcripts: make(map[match.AuctionID][]byte),
+		statuses:    make(map[[32]byte][]byte),
+		results:     make(map[match.AuctionID][]byte),
+		pair:        pair,
+	}
+
+	if mp.wal, err = openWAL(conf, "puzzlestore", pair.String(), mp); err != nil {
+		err = fmt.Errorf("Error opening wal for CreatePuzzleStoreWithWAL: %s", err)
+		return
+	}
+
+	store = mp
+	return
+}
+
 // ViewAuctionPuzzleBook takes in an auction ID, and returns encrypted auction orders, and puzzles.
 // You don't know what auction IDs should be in the orders encrypted in the puzzle book, but this is
 // what was submitted.
@@ -47,8 +102,342 @@ func (mp *MemoryPuzzleStore) ViewAuctionPuzzleBook(auctionID *match.AuctionID) (
 // PlaceAuctionPuzzle puts an encrypted auction order in the datastore.
 func (mp *MemoryPuzzleStore) PlaceAuctionPuzzle(puzzledOrder *match.EncryptedAuctionOrder) (err error) {
 	mp.puzzleMtx.Lock()
-	mp.puzzles[puzzledOrder.IntendedAuction] = append(mp.puzzles[puzzledOrder.IntendedAuction], puzzledOrder)
+	defer mp.puzzleMtx.Unlock()
+
+	// we only need the serialized puzzle if we're going to log it
+	var raw []byte
+	if mp.wal != nil {
+		if raw, err = puzzledOrder.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing puzzle for PlaceAuctionPuzzle: %s", err)
+			return
+		}
+	}
+
+	if err = mp.wal.logAndApply(placePuzzleOp, raw, func() (err error) {
+		mp.puzzles[puzzledOrder.IntendedAuction] = append(mp.puzzles[puzzledOrder.IntendedAuction], puzzledOrder)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error placing puzzle for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+	return
+}
+
+// PutTranscript stores the transcript for an auction, replacing the one that was there. The
+// exchange puts the transcript once it's committed to the puzzles, and again when the solutions
+// and responses are in.
+func (mp *MemoryPuzzleStore) PutTranscript(transcript *match.Transcript) (err error) {
+	var raw []byte
+	if raw, err = transcript.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing transcript for PutTranscript: %s", err)
+		return
+	}
+
+	mp.puzzleMtx.Lock()
+	defer mp.puzzleMtx.Unlock()
+	if err = mp.wal.logAndApply(putTranscriptOp, raw, func() (err error) {
+		mp.transcripts[transcript.BatchId] = raw
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error putting transcript for PutTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// ViewTranscript returns the transcript for an auction, or an error if there isn't one
+func (mp *MemoryPuzzleStore) ViewTranscript(auctionID *match.AuctionID) (transcript *match.Transcript, err error) {
+	mp.puzzleMtx.Lock()
+	raw, ok := mp.transcripts[*auctionID]
 	mp.puzzleMtx.Unlock()
+	if !ok {
+		err = fmt.Errorf("No transcript for auction %x", auctionID[:])
+		return
+	}
+
+	transcript = new(match.Transcript)
+	if err = transcript.Deserialize(raw); err != nil {
+		err = fmt.Errorf("Error deserializing transcript for ViewTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionOrderStatus stores the status of an auction order, replacing the one that was there
+func (mp *MemoryPuzzleStore) PutAuctionOrderStatus(status *match.AuctionOrderStatus) (err error) {
+	var raw []byte
+	if raw, err = status.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing status for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	mp.puzzleMtx.Lock()
+	defer mp.puzzleMtx.Unlock()
+	if err = mp.wal.logAndApply(putStatusOp, raw, func() (err error) {
+		mp.statuses[status.Receipt.ID] = raw
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error putting status for PutAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionOrderStatus returns the status of the auction order with a receipt ID, or nil if
+// there isn't one
+func (mp *MemoryPuzzleStore) ViewAuctionOrderStatus(receiptID [32]byte) (status *match.AuctionOrderStatus, err error) {
+	mp.puzzleMtx.Lock()
+	raw, ok := mp.statuses[receiptID]
+	mp.puzzleMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	status = new(match.AuctionOrderStatus)
+	if err = status.Deserialize(raw); err != nil {
+		status = nil
+		err = fmt.Errorf("Error deserializing status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionResult stores the result of an auction, replacing the one that was there
+func (mp *MemoryPuzzleStore) PutAuctionResult(result *match.AuctionResult) (err error) {
+	var raw []byte
+	if raw, err = result.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing result for PutAuctionResult: %s", err)
+		return
+	}
+
+	mp.puzzleMtx.Lock()
+	defer mp.puzzleMtx.Unlock()
+	if err = mp.wal.logAndApply(putResultOp, raw, func() (err error) {
+		mp.results[result.AuctionID] = raw
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error putting result for PutAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionResult returns the result of an auction, or nil if it doesn't have one
+func (mp *MemoryPuzzleStore) ViewAuctionResult(auctionID *match.AuctionID) (result *match.AuctionResult, err error) {
+	mp.puzzleMtx.Lock()
+	raw, ok := mp.results[*auctionID]
+	mp.puzzleMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	result = new(match.AuctionResult)
+	if err = result.Deserialize(raw); err != nil {
+		result = nil
+		err = fmt.Errorf("Error deserializing result for ViewAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// PruneAuctions removes the results of auctions that ended before a time, and the statuses of
+// decided orders that haven't been updated since then
+func (mp *MemoryPuzzleStore) PruneAuctions(before time.Time) (err error) {
+	mp.puzzleMtx.Lock()
+	defer mp.puzzleMtx.Unlock()
+	if err = mp.wal.logAndApply(pruneAuctionsOp, before.UnixNano(), func() (err error) {
+		return mp.pruneAuctions(before)
+	}); err != nil {
+		err = fmt.Errorf("Error pruning auctions for PruneAuctions: %s", err)
+		return
+	}
+	return
+}
+
+// pruneAuctions removes old results and statuses, the lock should be held
+func (mp *MemoryPuzzleStore) pruneAuctions(before time.Time) (err error) {
+	for receiptID, raw := range mp.statuses {
+		status := new(match.AuctionOrderStatus)
+		if err = status.Deserialize(raw); err != nil {
+			err = fmt.Errorf("Error deserializing status to prune: %s", err)
+			return
+		}
+		if status.Decided() && status.Updated.Before(before) {
+			delete(mp.statuses, receiptID)
+		}
+	}
+	for auctionID, raw := range mp.results {
+		result := new(match.AuctionResult)
+		if err = result.Deserialize(raw); err != nil {
+			err = fmt.Errorf("Error deserializing result to prune: %s", err)
+			return
+		}
+		if result.Ended.Before(before) {
+			delete(mp.results, auctionID)
+		}
+	}
+	return
+}
+
+// DestroyHandler takes a final snapshot and closes the write-ahead log, if there is one
+func (mp *MemoryPuzzleStore) DestroyHandler() (err error) {
+	mp.puzzleMtx.Lock()
+	defer mp.puzzleMtx.Unlock()
+	if err = mp.wal.close(); err != nil {
+		err = fmt.Errorf("Error closing wal for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+// snapshotState returns every puzzle, transcript, order status and auction result, serialized.
+// Each of them has the ID it's stored by in it, so that's all we need.
+func (mp *MemoryPuzzleStore) snapshotState() (state interface{}, err error) {
+	var snap puzzleSnapshot
+	for _, pzList := range mp.puzzles {
+		for _, pz := range pzList {
+			var raw []byte
+			if raw, err = pz.Serialize(); err != nil {
+				err = fmt.Errorf("Error serializing puzzle for snapshot: %s", err)
+				return
+			}
+			snap.Puzzles = append(snap.Puzzles, raw)
+		}
+	}
+	for _, raw := range mp.transcripts {
+		snap.Transcripts = append(snap.Transcripts, raw)
+	}
+	for _, raw := range mp.statuses {
+		snap.Statuses = append(snap.Statuses, raw)
+	}
+	for _, raw := range mp.results {
+		snap.Results = append(snap.Results, raw)
+	}
+	state = snap
+	return
+}
+
+func (mp *MemoryPuzzleStore) restoreState(state []byte) (err error) {
+	var snap puzzleSnapshot
+	if err = json.Unmarshal(state, &snap); err != nil {
+		// snapshots from before there were transcripts are just the puzzles
+		if err = json.Unmarshal(state, &snap.Puzzles); err != nil {
+			err = fmt.Errorf("Error unmarshalling puzzle store snapshot: %s", err)
+			return
+		}
+	}
+	mp.puzzles = make(map[match.AuctionID][]*match.EncryptedAuctionOrder)
+	mp.transcripts = make(map[match.AuctionID][]byte)
+	mp.statuses = make(map[[32]byte][]byte)
+	mp.results = make(map[match.AuctionID][]byte)
+	for _, raw := range snap.Puzzles {
+		if err = mp.placeRawPuzzle(raw); err != nil {
+			return
+		}
+	}
+	for _, raw := range snap.Transcripts {
+		if err = mp.putRawTranscript(raw); err != nil {
+			return
+		}
+	}
+	for _, raw := range snap.Statuses {
+		if err = mp.putRawStatus(raw); err != nil {
+			return
+		}
+	}
+	for _, raw := range snap.Results {
+		if err = mp.putRawResult(raw); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (mp *MemoryPuzzleStore) replayRecord(op string, data []byte) (err error) {
+	switch op {
+	case placePuzzleOp:
+		var raw []byte
+		if err = json.Unmarshal(data, &raw); err != nil {
+			err = fmt.Errorf("Error unmarshalling place puzzle record: %s", err)
+			return
+		}
+		err = mp.placeRawPuzzle(raw)
+	case putTranscriptOp:
+		var raw []byte
+		if err = json.Unmarshal(data, &raw); err != nil {
+			err = fmt.Errorf("Error unmarshalling put transcript record: %s", err)
+			return
+		}
+		err = mp.putRawTranscript(raw)
+	case putStatusOp:
+		var raw []byte
+		if err = json.Unmarshal(data, &raw); err != nil {
+			err = fmt.Errorf("Error unmarshalling put auction status record: %s", err)
+			return
+		}
+		err = mp.putRawStatus(raw)
+	case putResultOp:
+		var raw []byte
+		if err = json.Unmarshal(data, &raw); err != nil {
+			err = fmt.Errorf("Error unmarshalling put auction result record: %s", err)
+			return
+		}
+		err = mp.putRawResult(raw)
+	case pruneAuctionsOp:
+		var before int64
+		if err = json.Unmarshal(data, &before); err != nil {
+			err = fmt.Errorf("Error unmarshalling prune auctions record: %s", err)
+			return
+		}
+		err = mp.pruneAuctions(time.Unix(0, before))
+	default:
+		err = fmt.Errorf("Unknown puzzle store wal op %s", op)
+	}
+	return
+}
+
+// placeRawPuzzle deserializes a puzzle and puts it in the store, the lock should be held
+func (mp *MemoryPuzzleStore) placeRawPuzzle(raw []byte) (err error) {
+	puzzledOrder := new(match.EncryptedAuctionOrder)
+	if err = puzzledOrder.Deserialize(raw); err != nil {
+		err = fmt.Errorf("Error deserializing puzzle: %s", err)
+		return
+	}
+	mp.puzzles[puzzledOrder.IntendedAuction] = append(mp.puzzles[puzzledOrder.IntendedAuction], puzzledOrder)
+	return
+}
+
+// putRawTranscript deserializes a transcript and puts it in the store, the lock should be held
+func (mp *MemoryPuzzleStore) putRawTranscript(raw []byte) (err error) {
+	transcript := new(match.Transcript)
+	if err = transcript.Deserialize(raw); err != nil {
+		err = fmt.Errorf("Error deserializing transcript: %s", err)
+		return
+	}
+	mp.transcripts[transcript.BatchId] = raw
+	return
+}
+
+// putRawStatus deserializes an auction order status and puts it in the store, the lock should be
+// held
+func (mp *MemoryPuzzleStore) putRawStatus(raw []byte) (err error) {
+	status := new(match.AuctionOrderStatus)
+	if err = status.Deserialize(raw); err != nil {
+		err = fmt.Errorf("Error deserializing auction order status: %s", err)
+		return
+	}
+	mp.statuses[status.Receipt.ID] = raw
+	return
+}
+
+// putRawResult deserializes an auction result and puts it in the store, the lock should be held
+func (mp *MemoryPuzzleStore) putRawResult(raw []byte) (err error) {
+	result := new(match.AuctionResult)
+	if err = result.Deserialize(raw); err != nil {
+		err = fmt.Errorf("Error deserializing auction result: %s", err)
+		return
+	}
+	mp.results[result.AuctionID] = raw
 	return
 }
 
@@ -67,3 +456,20 @@ func CreatePuzzleStoreMap(pairList []*match.Pair) (pzMap map[match.Pair]cxdb.Puz
 
 	return
 }
+
+// CreatePuzzleStoreMapWithWAL creates a map of pair to puzzle store, each backed by a write-ahead
+// log in the same directory.
+func CreatePuzzleStoreMapWithWAL(pairList []*match.Pair, conf *WALConfig) (pzMap map[match.Pair]cxdb.PuzzleStore, err error) {
+
+	pzMap = make(map[match.Pair]cxdb.PuzzleStore)
+	var curPzEng cxdb.PuzzleStore
+	for _, pair := range pairList {
+		if curPzEng, err = CreatePuzzleStoreWithWAL(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating single puzzle store while creating puzzle store map: %s", err)
+			return
+		}
+		pzMap[*pair] = curPzEng
+	}
+
+	return
+}
@@ -1,6 +1,7 @@
 package cxdbmemory
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 
@@ -8,6 +9,11 @@ import (
 	"github.com/mit-dci/opencx/match"
 )
 
+const (
+	// wal op for ApplySettlementExecution
+	settlementExecOp = "settlementexec"
+)
+
 type MemorySettlementEngine struct {
 	// Balances
 	balances    map[[33]byte]uint64
@@ -15,6 +21,24 @@ type MemorySettlementEngine struct {
 
 	// this coin
 	coin *coinparam.Params
+
+	// the write-ahead log, nil if there isn't one
+	wal *writeAheadLog
+}
+
+// settlementExecRecord is a settlement execution in the wal. SettleType doesn't survive json so
+// we keep it as a bool.
+type settlementExecRecord struct {
+	Pubkey [33]byte    `json:"pubkey"`
+	Amount uint64      `json:"amount"`
+	Asset  match.Asset `json:"asset"`
+	Debit  bool        `json:"debit"`
+}
+
+// settlementSnapshotEntry is a single balance in a snapshot
+type settlementSnapshotEntry struct {
+	Pubkey  [33]byte `json:"pubkey"`
+	Balance uint64   `json:"balance"`
 }
 
 // CreateSettlementEngine creates a settlement engine for a specific coin
@@ -32,28 +56,51 @@ func CreateSettlementEngine(coin *coinparam.Params) (engine match.SettlementEngi
 	return
 }
 
+// CreateSettlementEngineWithWAL creates a settlement engine for a specific coin, backed by a
+// write-ahead log. The balances are rebuilt from the log if there is one.
+func CreateSettlementEngineWithWAL(coin *coinparam.Params, conf *WALConfig) (engine match.SettlementEngine, err error) {
+
+	me := &MemorySettlementEngine{
+		balances:    make(map[[33]byte]uint64),
+		balancesMtx: new(sync.Mutex),
+		coin:        coin,
+	}
+
+	if me.wal, err = openWAL(conf, "settlementengine", coin.Name, me); err != nil {
+		err = fmt.Errorf("Error opening wal for CreateSettlementEngineWithWAL: %s", err)
+		return
+	}
+
+	engine = me
+	return
+}
+
 // ApplySettlementExecution applies the settlementExecution, this assumes that the settlement execution is
 // valid
 func (me *MemorySettlementEngine) ApplySettlementExecution(setExec *match.SettlementExecution) (setRes *match.SettlementResult, err error) {
 
 	me.balancesMtx.Lock()
-	var curBal uint64
-	var ok bool
-	if curBal, ok = me.balances[setExec.Pubkey]; !ok && setExec.Type == match.Credit {
+	defer me.balancesMtx.Unlock()
+	if _, ok := me.balances[setExec.Pubkey]; !ok && setExec.Type == match.Credit {
 		err = fmt.Errorf("Trying to apply settlement execution credit to order with no balance")
-		me.balancesMtx.Unlock()
 		return
 	}
 
+	rec := settlementExecRecord{
+		Pubkey: setExec.Pubkey,
+		Amount: setExec.Amount,
+		Asset:  setExec.Asset,
+		Debit:  setExec.Type == match.Debit,
+	}
 	var newBal uint64
-	if setExec.Type == match.Debit {
-		newBal = curBal + setExec.Amount
-	} else if setExec.Type == match.Credit {
-		newBal = curBal - setExec.Amount
+	if err = me.wal.logAndApply(settlementExecOp, rec, func() (err error) {
+		newBal = me.applySettlementExec(rec)
+		return
+	}); err != nil {
+		err = fmt.Errorf("Error applying settlement exec for ApplySettlementExecution: %s", err)
+		return
 	}
 
-	me.balances[setExec.Pubkey] = newBal
-	me.balancesMtx.Unlock()
 	// Finally set return value
 	setRes = &match.SettlementResult{
 		NewBal:         newBal,
@@ -63,6 +110,18 @@ func (me *MemorySettlementEngine) ApplySettlementExecution(setExec *match.Settle
 	return
 }
 
+// applySettlementExec changes the balance for a settlement exec record, the lock should be held
+func (me *MemorySettlementEngine) applySettlementExec(rec settlementExecRecord) (newBal uint64) {
+	curBal := me.balances[rec.Pubkey]
+	if rec.Debit {
+		newBal = curBal + rec.Amount
+	} else {
+		newBal = curBal - rec.Amount
+	}
+	me.balances[rec.Pubkey] = newBal
+	return
+}
+
 // CheckValid returns true if the settlement execution would be valid
 func (me *MemorySettlementEngine) CheckValid(setExec *match.SettlementExecution) (valid bool, err error) {
 	if setExec.Type == match.Debit {
@@ -73,7 +132,55 @@ func (me *MemorySettlementEngine) CheckValid(setExec *match.SettlementExecution)
 	me.balancesMtx.Lock()
 	curBal := me.balances[setExec.Pubkey]
 	me.balancesMtx.Unlock()
-	valid = setExec.Amount > curBal
+	valid = setExec.Amount <= curBal
+	return
+}
+
+// DestroyHandler takes a final snapshot and closes the write-ahead log, if there is one
+func (me *MemorySettlementEngine) DestroyHandler() (err error) {
+	me.balancesMtx.Lock()
+	defer me.balancesMtx.Unlock()
+	if err = me.wal.close(); err != nil {
+		err = fmt.Errorf("Error closing wal for DestroyHandler: %s", err)
+		return
+	}
+	return
+}
+
+func (me *MemorySettlementEngine) snapshotState() (state interface{}, err error) {
+	var entries []settlementSnapshotEntry
+	for pubkey, bal := range me.balances {
+		entries = append(entries, settlementSnapshotEntry{Pubkey: pubkey, Balance: bal})
+	}
+	state = entries
+	return
+}
+
+func (me *MemorySettlementEngine) restoreState(state []byte) (err error) {
+	var entries []settlementSnapshotEntry
+	if err = json.Unmarshal(state, &entries); err != nil {
+		err = fmt.Errorf("Error unmarshalling settlement engine snapshot: %s", err)
+		return
+	}
+	me.balances = make(map[[33]byte]uint64)
+	for _, entry := range entries {
+		me.balances[entry.Pubkey] = entry.Balance
+	}
+	return
+}
+
+func (me *MemorySettlementEngine) replayRecord(op string, data []byte) (err error) {
+	switch op {
+	case settlementExecOp:
+		var rec settlementExecRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling settlement exec record: %s", err)
+			return
+		}
+		me.applySettlementExec(rec)
+	default:
+		err = fmt.Errorf("Unknown settlement engine wal op %s", op)
+	}
 	return
 }
 
@@ -92,3 +199,20 @@ func CreateSettlementEngineMap(coins []*coinparam.Params) (setMap map[*coinparam
 
 	return
 }
+
+// CreateSettlementEngineMapWithWAL creates a map of coin to settlement engine, each backed by a
+// write-ahead log in the same directory.
+func CreateSettlementEngineMapWithWAL(coins []*coinparam.Params, conf *WALConfig) (setMap map[*coinparam.Params]match.SettlementEngine, err error) {
+
+	setMap = make(map[*coinparam.Params]match.SettlementEngine)
+	var curSetEng match.SettlementEngine
+	for _, coin := range coins {
+		if curSetEng, err = CreateSettlementEngineWithWAL(coin, conf); err != nil {
+			err = fmt.Errorf("Error creating single settlement engine while creating settlement engine map: %s", err)
+			return
+		}
+		setMap[coin] = curSetEng
+	}
+
+	return
+}
@@ -0,0 +1,44 @@
+package cxdbmemory
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestSettlementEngineCheckValid makes sure credits are valid up to the user's balance and no
+// further, and that debits are always valid
+func TestSettlementEngineCheckValid(t *testing.T) {
+	engine, err := CreateSettlementEngine(&coinparam.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("create engine err: %v", err)
+	}
+
+	pubkey := [33]byte{0x02}
+	debit := &match.SettlementExecution{Pubkey: pubkey, Amount: 1000, Type: match.Debit}
+	var valid bool
+	if valid, err = engine.CheckValid(debit); err != nil || !valid {
+		t.Fatalf("debit should be valid, got %t, %v", valid, err)
+	}
+	if _, err = engine.ApplySettlementExecution(debit); err != nil {
+		t.Fatalf("debit err: %v", err)
+	}
+
+	for _, test := range []struct {
+		amount uint64
+		valid  bool
+	}{
+		{amount: 1, valid: true},
+		{amount: 1000, valid: true},
+		{amount: 1001, valid: false},
+	} {
+		credit := &match.SettlementExecution{Pubkey: pubkey, Amount: test.amount, Type: match.Credit}
+		if valid, err = engine.CheckValid(credit); err != nil {
+			t.Fatalf("check valid err: %v", err)
+		}
+		if valid != test.valid {
+			t.Errorf("credit of %d with a balance of 1000 should have valid %t, got %t", test.amount, test.valid, valid)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cxdbmemory
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemorySubmarineStore keeps submarine swaps in memory
+type MemorySubmarineStore struct {
+	// swaps are in the order they were added, swapIndex maps a submarine swap's hash to its index
+	swaps        []*match.SubmarineSwap
+	swapIndex    map[[32]byte]int
+	submarineMtx *sync.Mutex
+}
+
+// CreateSubmarineStore creates an in memory submarine swap store
+func CreateSubmarineStore() (store cxdb.SubmarineStore, err error) {
+	ms := &MemorySubmarineStore{
+		swapIndex:    make(map[[32]byte]int),
+		submarineMtx: new(sync.Mutex),
+	}
+	store = ms
+	return
+}
+
+// AddSubmarineSwap stores a new submarine swap
+func (ms *MemorySubmarineStore) AddSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	ms.submarineMtx.Lock()
+	defer ms.submarineMtx.Unlock()
+
+	if _, ok := ms.swapIndex[swap.RHash]; ok {
+		err = fmt.Errorf("Error adding submarine swap, submarine swap %s already exists", swap.ID())
+		return
+	}
+	// keep a copy so callers can't change what's stored without UpdateSubmarineSwap
+	stored := new(match.SubmarineSwap)
+	*stored = *swap
+	ms.swapIndex[swap.RHash] = len(ms.swaps)
+	ms.swaps = append(ms.swaps, stored)
+	return
+}
+
+// UpdateSubmarineSwap saves everything about a stored submarine swap that changes as it moves
+// along
+func (ms *MemorySubmarineStore) UpdateSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	ms.submarineMtx.Lock()
+	defer ms.submarineMtx.Unlock()
+
+	idx, ok := ms.swapIndex[swap.RHash]
+	if !ok {
+		err = fmt.Errorf("Error updating submarine swap, no submarine swap %s", swap.ID())
+		return
+	}
+	ms.swaps[idx].UpdateFrom(swap)
+	return
+}
+
+// GetSubmarineSwap gets a submarine swap by its hash
+func (ms *MemorySubmarineStore) GetSubmarineSwap(rhash [32]byte) (swap *match.SubmarineSwap, err error) {
+	ms.submarineMtx.Lock()
+	defer ms.submarineMtx.Unlock()
+
+	idx, ok := ms.swapIndex[rhash]
+	if !ok {
+		err = fmt.Errorf("Error getting submarine swap, no submarine swap %x", rhash)
+		return
+	}
+
+	swap = new(match.SubmarineSwap)
+	*swap = *ms.swaps[idx]
+	return
+}
+
+// GetSubmarineSwaps gets every submarine swap for a pubkey, oldest first
+func (ms *MemorySubmarineStore) GetSubmarineSwaps(pubkey *koblitz.PublicKey) (swaps []*match.SubmarineSwap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	swaps = ms.filterSubmarineSwaps(func(swap *match.SubmarineSwap) bool {
+		return bytes.Equal(swap.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetSubmarineSwapsByState gets every submarine swap in a state, oldest first
+func (ms *MemorySubmarineStore) GetSubmarineSwapsByState(state match.SubmarineState) (swaps []*match.SubmarineSwap, err error) {
+	swaps = ms.filterSubmarineSwaps(func(swap *match.SubmarineSwap) bool {
+		return swap.State == state
+	})
+	return
+}
+
+// filterSubmarineSwaps returns copies of the submarine swaps that keep returns true for, oldest
+// first
+func (ms *MemorySubmarineStore) filterSubmarineSwaps(keep func(*match.SubmarineSwap) bool) (swaps []*match.SubmarineSwap) {
+	ms.submarineMtx.Lock()
+	defer ms.submarineMtx.Unlock()
+
+	for _, stored := range ms.swaps {
+		if keep(stored) {
+			swap := new(match.SubmarineSwap)
+			*swap = *stored
+			swaps = append(swaps, swap)
+		}
+	}
+	return
+}
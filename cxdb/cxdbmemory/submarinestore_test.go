@@ -0,0 +1,61 @@
+package cxdbmemory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestSubmarineStoreStates(t *testing.T) {
+	store, _ := CreateSubmarineStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	start := time.Unix(1500000000, 0)
+
+	var pubkey [33]byte
+	copy(pubkey[:], pub.SerializeCompressed())
+	request := &match.SubmarineRequest{Direction: match.SubmarineIn, Asset: match.BTCTest, Amount: 100000, RHash: [32]byte{0x01}}
+	swap, err := match.NewSubmarineSwap(request, pubkey, [33]byte{0x02}, 100, match.DefaultSwapPolicy(), start)
+	if err != nil {
+		t.Fatalf("new submarine swap err: %v", err)
+	}
+	if err = store.AddSubmarineSwap(swap); err != nil {
+		t.Fatalf("add submarine swap err: %v", err)
+	}
+	if err = store.AddSubmarineSwap(swap); err == nil {
+		t.Errorf("adding a submarine swap twice should fail")
+	}
+
+	// changing the swap shouldn't change what's stored until it's updated
+	swap.Outpoint = "00:1"
+	swap.FundAmount = 100000
+	swap.FundHeight = 110
+	swap.SetState(match.SubmarineFunded, "", start.Add(time.Minute))
+	got, err := store.GetSubmarineSwap(swap.RHash)
+	if err != nil {
+		t.Fatalf("get submarine swap err: %v", err)
+	}
+	if got.State != match.SubmarineCreated || got.Outpoint != "" {
+		t.Errorf("stored submarine swap should still be created, got %s", got)
+	}
+	if err = store.UpdateSubmarineSwap(swap); err != nil {
+		t.Fatalf("update submarine swap err: %v", err)
+	}
+	funded, err := store.GetSubmarineSwapsByState(match.SubmarineFunded)
+	if err != nil {
+		t.Fatalf("get submarine swaps by state err: %v", err)
+	}
+	if len(funded) != 1 || funded[0].Outpoint != "00:1" || funded[0].FundHeight != 110 || !funded[0].Updated.Equal(start.Add(time.Minute)) {
+		t.Errorf("submarine swap should be funded, got %d funded", len(funded))
+	}
+
+	all, err := store.GetSubmarineSwaps(pub)
+	if err != nil || len(all) != 1 {
+		t.Errorf("pubkey should have 1 submarine swap, got %d, %v", len(all), err)
+	}
+	if _, err = store.GetSubmarineSwap([32]byte{}); err == nil {
+		t.Errorf("getting a submarine swap that doesn't exist should fail")
+	}
+}
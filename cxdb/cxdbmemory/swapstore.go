@@ -0,0 +1,246 @@
+package cxdbmemory
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemorySwapStore keeps swaps, swap orders and escrows in memory
+type MemorySwapStore struct {
+	// swaps are in the order they were added, swapIndex maps a swap's hash to its index
+	swaps      []*match.Swap
+	swapIndex  map[[32]byte]int
+	swapOrders map[match.OrderID]bool
+	// escrows are in the order they were added, escrowIndex maps an escrow's hash to its index
+	// and escrowOrders maps the order of a committed escrow to its index
+	escrows      []*match.OrderEscrow
+	escrowIndex  map[[32]byte]int
+	escrowOrders map[match.OrderID]int
+	swapMtx      *sync.Mutex
+}
+
+// CreateSwapStore creates an in memory swap store
+func CreateSwapStore() (store cxdb.SwapStore, err error) {
+	ms := &MemorySwapStore{
+		swapIndex:    make(map[[32]byte]int),
+		swapOrders:   make(map[match.OrderID]bool),
+		escrowIndex:  make(map[[32]byte]int),
+		escrowOrders: make(map[match.OrderID]int),
+		swapMtx:      new(sync.Mutex),
+	}
+	store = ms
+	return
+}
+
+// AddSwap stores a new swap
+func (ms *MemorySwapStore) AddSwap(swap *match.Swap) (err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	if _, ok := ms.swapIndex[swap.RHash]; ok {
+		err = fmt.Errorf("Error adding swap, swap %s already exists", swap.ID())
+		return
+	}
+	// keep a copy so callers can't change what's stored without UpdateSwap
+	stored := new(match.Swap)
+	*stored = *swap
+	ms.swapIndex[swap.RHash] = len(ms.swaps)
+	ms.swaps = append(ms.swaps, stored)
+	return
+}
+
+// UpdateSwap saves the state, reason and update time of a stored swap
+func (ms *MemorySwapStore) UpdateSwap(swap *match.Swap) (err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	idx, ok := ms.swapIndex[swap.RHash]
+	if !ok {
+		err = fmt.Errorf("Error updating swap, no swap %s", swap.ID())
+		return
+	}
+
+	stored := ms.swaps[idx]
+	stored.State = swap.State
+	stored.Reason = swap.Reason
+	stored.Updated = swap.Updated
+	return
+}
+
+// GetSwap gets a swap by its hash
+func (ms *MemorySwapStore) GetSwap(rhash [32]byte) (swap *match.Swap, err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	idx, ok := ms.swapIndex[rhash]
+	if !ok {
+		err = fmt.Errorf("Error getting swap, no swap %x", rhash)
+		return
+	}
+
+	swap = new(match.Swap)
+	*swap = *ms.swaps[idx]
+	return
+}
+
+// GetSwaps gets every swap for a pubkey, oldest first
+func (ms *MemorySwapStore) GetSwaps(pubkey *koblitz.PublicKey) (swaps []*match.Swap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	swaps = ms.filterSwaps(func(swap *match.Swap) bool {
+		return bytes.Equal(swap.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetSwapsByState gets every swap in a state, oldest first
+func (ms *MemorySwapStore) GetSwapsByState(state match.SwapState) (swaps []*match.Swap, err error) {
+	swaps = ms.filterSwaps(func(swap *match.Swap) bool {
+		return swap.State == state
+	})
+	return
+}
+
+// filterSwaps returns copies of the swaps that keep returns true for, oldest first
+func (ms *MemorySwapStore) filterSwaps(keep func(*match.Swap) bool) (swaps []*match.Swap) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	for _, stored := range ms.swaps {
+		if keep(stored) {
+			swap := new(match.Swap)
+			*swap = *stored
+			swaps = append(swaps, swap)
+		}
+	}
+	return
+}
+
+// AddSwapOrder marks an order as a swap order
+func (ms *MemorySwapStore) AddSwapOrder(orderID *match.OrderID) (err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	ms.swapOrders[*orderID] = true
+	return
+}
+
+// IsSwapOrder returns true if an order is a swap order
+func (ms *MemorySwapStore) IsSwapOrder(orderID *match.OrderID) (swapOrder bool, err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	swapOrder = ms.swapOrders[*orderID]
+	return
+}
+
+// AddEscrow stores a new escrow
+func (ms *MemorySwapStore) AddEscrow(escrow *match.OrderEscrow) (err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	if _, ok := ms.escrowIndex[escrow.RHash]; ok {
+		err = fmt.Errorf("Error adding escrow, escrow %s already exists", escrow.ID())
+		return
+	}
+	// keep a copy so callers can't change what's stored without UpdateEscrow
+	stored := new(match.OrderEscrow)
+	*stored = *escrow
+	ms.escrowIndex[escrow.RHash] = len(ms.escrows)
+	ms.escrows = append(ms.escrows, stored)
+	return
+}
+
+// UpdateEscrow saves the order ID, release, state, reason and update time of a stored escrow
+func (ms *MemorySwapStore) UpdateEscrow(escrow *match.OrderEscrow) (err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	idx, ok := ms.escrowIndex[escrow.RHash]
+	if !ok {
+		err = fmt.Errorf("Error updating escrow, no escrow %s", escrow.ID())
+		return
+	}
+
+	stored := ms.escrows[idx]
+	stored.OrderID = escrow.OrderID
+	stored.AmountFilled = escrow.AmountFilled
+	stored.AmountReceive = escrow.AmountReceive
+	stored.ReceiveLocktime = escrow.ReceiveLocktime
+	stored.AmountRefund = escrow.AmountRefund
+	stored.RefundLocktime = escrow.RefundLocktime
+	stored.State = escrow.State
+	stored.Reason = escrow.Reason
+	stored.Updated = escrow.Updated
+	if escrow.OrderID != (match.OrderID{}) {
+		ms.escrowOrders[escrow.OrderID] = idx
+	}
+	return
+}
+
+// GetEscrow gets an escrow by its hash
+func (ms *MemorySwapStore) GetEscrow(rhash [32]byte) (escrow *match.OrderEscrow, err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	idx, ok := ms.escrowIndex[rhash]
+	if !ok {
+		err = fmt.Errorf("Error getting escrow, no escrow %x", rhash)
+		return
+	}
+
+	escrow = new(match.OrderEscrow)
+	*escrow = *ms.escrows[idx]
+	return
+}
+
+// GetOrderEscrow gets the escrow of a channel order, escrow is nil if the order doesn't have one
+func (ms *MemorySwapStore) GetOrderEscrow(orderID *match.OrderID) (escrow *match.OrderEscrow, err error) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	idx, ok := ms.escrowOrders[*orderID]
+	if !ok {
+		return
+	}
+
+	escrow = new(match.OrderEscrow)
+	*escrow = *ms.escrows[idx]
+	return
+}
+
+// GetEscrows gets every escrow for a pubkey, oldest first
+func (ms *MemorySwapStore) GetEscrows(pubkey *koblitz.PublicKey) (escrows []*match.OrderEscrow, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	escrows = ms.filterEscrows(func(escrow *match.OrderEscrow) bool {
+		return bytes.Equal(escrow.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetEscrowsByState gets every escrow in a state, oldest first
+func (ms *MemorySwapStore) GetEscrowsByState(state match.EscrowState) (escrows []*match.OrderEscrow, err error) {
+	escrows = ms.filterEscrows(func(escrow *match.OrderEscrow) bool {
+		return escrow.State == state
+	})
+	return
+}
+
+// filterEscrows returns copies of the escrows that keep returns true for, oldest first
+func (ms *MemorySwapStore) filterEscrows(keep func(*match.OrderEscrow) bool) (escrows []*match.OrderEscrow) {
+	ms.swapMtx.Lock()
+	defer ms.swapMtx.Unlock()
+
+	for _, stored := range ms.escrows {
+		if keep(stored) {
+			escrow := new(match.OrderEscrow)
+			*escrow = *stored
+			escrows = append(escrows, escrow)
+		}
+	}
+	return
+}
@@ -0,0 +1,132 @@
+package cxdbmemory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestSwapStoreStates(t *testing.T) {
+	store, _ := CreateSwapStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	start := time.Unix(1500000000, 0)
+
+	fill := &match.FillEntry{
+		OrderID:     match.OrderID{0x01},
+		TradingPair: match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest},
+		Side:        match.Sell,
+		AmountHave:  1000,
+		AmountWant:  4000,
+	}
+	copy(fill.Pubkey[:], pub.SerializeCompressed())
+	swap, err := match.NewSwap(fill, 100, match.DefaultSwapPolicy(), start)
+	if err != nil {
+		t.Fatalf("new swap err: %v", err)
+	}
+	if err = store.AddSwap(swap); err != nil {
+		t.Fatalf("add swap err: %v", err)
+	}
+
+	// changing the swap shouldn't change what's stored until it's updated
+	swap.SetState(match.SwapClaimed, "", start.Add(time.Minute))
+	got, err := store.GetSwap(swap.RHash)
+	if err != nil {
+		t.Fatalf("get swap err: %v", err)
+	}
+	if got.State != match.SwapPending {
+		t.Errorf("stored swap should still be pending, got %s", got.State)
+	}
+	if err = store.UpdateSwap(swap); err != nil {
+		t.Fatalf("update swap err: %v", err)
+	}
+	claimed, err := store.GetSwapsByState(match.SwapClaimed)
+	if err != nil {
+		t.Fatalf("get swaps by state err: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].RHash != swap.RHash || !claimed[0].Updated.Equal(start.Add(time.Minute)) {
+		t.Errorf("swap should be claimed, got %d claimed", len(claimed))
+	}
+
+	all, err := store.GetSwaps(pub)
+	if err != nil || len(all) != 1 {
+		t.Errorf("pubkey should have 1 swap, got %d, %v", len(all), err)
+	}
+	if _, err = store.GetSwap([32]byte{}); err == nil {
+		t.Errorf("getting a swap that doesn't exist should fail")
+	}
+
+	if err = store.AddSwapOrder(&fill.OrderID); err != nil {
+		t.Fatalf("add swap order err: %v", err)
+	}
+	if swapOrder, _ := store.IsSwapOrder(&fill.OrderID); !swapOrder {
+		t.Errorf("order should be a swap order")
+	}
+	if swapOrder, _ := store.IsSwapOrder(&match.OrderID{0x02}); swapOrder {
+		t.Errorf("other order should not be a swap order")
+	}
+}
+
+func TestSwapStoreEscrows(t *testing.T) {
+	store, _ := CreateSwapStore()
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	start := time.Unix(1500000000, 0)
+
+	order := &match.LimitOrder{
+		TradingPair: match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest},
+		Side:        match.Sell,
+		AmountHave:  1000,
+		AmountWant:  4000,
+	}
+	copy(order.Pubkey[:], pub.SerializeCompressed())
+	escrow, err := match.NewOrderEscrow(order, 100, match.DefaultSwapPolicy(), start)
+	if err != nil {
+		t.Fatalf("new escrow err: %v", err)
+	}
+	if err = store.AddEscrow(escrow); err != nil {
+		t.Fatalf("add escrow err: %v", err)
+	}
+	if err = store.AddEscrow(escrow); err == nil {
+		t.Errorf("adding an escrow twice should fail")
+	}
+
+	// a pending escrow's order isn't on the book yet
+	orderID := match.OrderID{0x01}
+	if got, err := store.GetOrderEscrow(&orderID); err != nil || got != nil {
+		t.Errorf("order shouldn't have an escrow yet, got %v, %v", got, err)
+	}
+
+	escrow.OrderID = orderID
+	escrow.SetState(match.EscrowCommitted, "", start.Add(time.Minute))
+	if err = store.UpdateEscrow(escrow); err != nil {
+		t.Fatalf("update escrow err: %v", err)
+	}
+	if err = escrow.Release(600, 2400, 200, 110, match.DefaultSwapPolicy(), start.Add(2*time.Minute)); err != nil {
+		t.Fatalf("release escrow err: %v", err)
+	}
+	if err = store.UpdateEscrow(escrow); err != nil {
+		t.Fatalf("update escrow err: %v", err)
+	}
+
+	got, err := store.GetOrderEscrow(&orderID)
+	if err != nil || got == nil {
+		t.Fatalf("order should have an escrow, got %v", err)
+	}
+	if got.State != match.EscrowReleasing || got.AmountReceive != 2400 || got.AmountRefund != 400 || got.RefundLocktime != 110+match.DefaultSwapTimeout {
+		t.Errorf("escrow should be releasing 2400 and refunding 400, got %s", got)
+	}
+	releasing, err := store.GetEscrowsByState(match.EscrowReleasing)
+	if err != nil || len(releasing) != 1 || releasing[0].RHash != escrow.RHash {
+		t.Errorf("escrow should be releasing, got %d, %v", len(releasing), err)
+	}
+	all, err := store.GetEscrows(pub)
+	if err != nil || len(all) != 1 {
+		t.Errorf("pubkey should have 1 escrow, got %d, %v", len(all), err)
+	}
+	if _, err = store.GetEscrow([32]byte{}); err == nil {
+		t.Errorf("getting an escrow that doesn't exist should fail")
+	}
+}
@@ -0,0 +1,331 @@
+package cxdbmemory
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mit-dci/opencx/logging"
+)
+
+const (
+	// how many records get written to the log before we take a snapshot and start a new log
+	defaultSnapshotInterval = 1000
+)
+
+// WALConfig configures the write-ahead log for a memory store. Every mutating call on the store is
+// appended to the log before it's applied, and every SnapshotInterval records the whole state is
+// written to a snapshot and the log is truncated. When the store is created again with the same
+// config, the last snapshot is loaded and the log is replayed on top of it.
+type WALConfig struct {
+	// Dir is the directory the log and snapshot files go in
+	Dir string
+	// SnapshotInterval is the number of records between snapshots, 0 uses the default
+	SnapshotInterval uint64
+}
+
+// walRecord is a single line in the log. Seq always goes up, even across snapshots, so we know
+// which records a snapshot already includes.
+type walRecord struct {
+	Seq  uint64          `json:"seq"`
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+// walSnapshot is what's written to the snapshot file. Seq is the last record the state includes.
+type walSnapshot struct {
+	Seq   uint64          `json:"seq"`
+	State json.RawMessage `json:"state"`
+}
+
+// walState is implemented by the memory stores that can be backed by a write-ahead log. These are
+// only called while the store's lock is held, or before the store is returned to anyone.
+type walState interface {
+	// snapshotState returns something that can be marshalled into json and restored with restoreState
+	snapshotState() (state interface{}, err error)
+	// restoreState replaces the state of the store with a snapshot
+	restoreState(state []byte) (err error)
+	// replayRecord applies a record from the log to the store
+	replayRecord(op string, data []byte) (err error)
+}
+
+// writeAheadLog is the log and snapshot files for a single store. A nil *writeAheadLog is valid,
+// and just applies changes without logging anything, so stores without a WAL don't need to check.
+type writeAheadLog struct {
+	logPath          string
+	snapshotPath     string
+	logFile          *os.File
+	seq              uint64
+	sinceSnapshot    uint64
+	snapshotInterval uint64
+	state            walState
+}
+
+// openWAL opens (or creates) the write-ahead log for a single store, and rebuilds the state of
+// the store from the last snapshot and the log. The files are named after the kind of store and
+// the pair or coin it's for.
+func openWAL(conf *WALConfig, kind string, name string, state walState) (wal *writeAheadLog, err error) {
+	if err = os.MkdirAll(conf.Dir, 0700); err != nil {
+		err = fmt.Errorf("Error creating wal directory %s for openWAL: %s", conf.Dir, err)
+		return
+	}
+
+	wal = &writeAheadLog{
+		logPath:          filepath.Join(conf.Dir, fmt.Sprintf("%s_%s.wal", kind, name)),
+		snapshotPath:     filepath.Join(conf.Dir, fmt.Sprintf("%s_%s.snapshot", kind, name)),
+		snapshotInterval: conf.SnapshotInterval,
+		state:            state,
+	}
+	if wal.snapshotInterval == 0 {
+		wal.snapshotInterval = defaultSnapshotInterval
+	}
+
+	if err = wal.loadSnapshot(); err != nil {
+		err = fmt.Errorf("Error loading snapshot for openWAL: %s", err)
+		return
+	}
+
+	if err = wal.replayLog(); err != nil {
+		err = fmt.Errorf("Error replaying log for openWAL: %s", err)
+		return
+	}
+
+	if wal.logFile, err = os.OpenFile(wal.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		err = fmt.Errorf("Error opening log %s for openWAL: %s", wal.logPath, err)
+		return
+	}
+	return
+}
+
+// loadSnapshot restores the state from the snapshot file, if there is one
+func (w *writeAheadLog) loadSnapshot() (err error) {
+	var raw []byte
+	if raw, err = ioutil.ReadFile(w.snapshotPath); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	var snap walSnapshot
+	if err = json.Unmarshal(raw, &snap); err != nil {
+		err = fmt.Errorf("Error unmarshalling snapshot %s: %s", w.snapshotPath, err)
+		return
+	}
+
+	if err = w.state.restoreState(snap.State); err != nil {
+		err = fmt.Errorf("Error restoring state from snapshot %s: %s", w.snapshotPath, err)
+		return
+	}
+	w.seq = snap.Seq
+	return
+}
+
+// replayLog applies every record in the log that isn't already in the snapshot. If we crashed
+// in the middle of writing a record the last line is incomplete, that record was never applied
+// so we cut it off the log.
+func (w *writeAheadLog) replayLog() (err error) {
+	var logFile *os.File
+	if logFile, err = os.OpenFile(w.logPath, os.O_RDWR, 0600); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer logFile.Close()
+
+	reader := bufio.NewReader(logFile)
+	var goodOffset int64
+	for {
+		var line []byte
+		if line, err = reader.ReadBytes('\n'); err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("Error reading log %s: %s", w.logPath, err)
+				return
+			}
+			err = nil
+			if len(line) != 0 {
+				logging.Warnf("Found incomplete record at the end of %s, truncating", w.logPath)
+				if err = logFile.Truncate(goodOffset); err != nil {
+					err = fmt.Errorf("Error truncating incomplete record from log %s: %s", w.logPath, err)
+					return
+				}
+			}
+			return
+		}
+
+		var rec walRecord
+		if err = json.Unmarshal(line, &rec); err != nil {
+			err = fmt.Errorf("Error unmarshalling record at offset %d of %s: %s", goodOffset, w.logPath, err)
+			return
+		}
+		goodOffset += int64(len(line))
+
+		// If we crashed after the snapshot was written but before the log was cleared, the
+		// snapshot already has these
+		if rec.Seq <= w.seq {
+			continue
+		}
+
+		if err = w.state.replayRecord(rec.Op, rec.Data); err != nil {
+			err = fmt.Errorf("Error replaying record %d (%s) from %s: %s", rec.Seq, rec.Op, w.logPath, err)
+			return
+		}
+		w.seq = rec.Seq
+		w.sinceSnapshot++
+	}
+}
+
+// logAndApply appends a record to the log, and once it's on disk, calls apply to change the
+// state. The store's lock should be held, and apply should only fail if replaying the record
+// would fail too, so check everything that can go wrong before calling this. If apply does fail
+// anyways, the record is cut back off the log, so it isn't replayed on the next start.
+func (w *writeAheadLog) logAndApply(op string, data interface{}, apply func() error) (err error) {
+	if w == nil {
+		return apply()
+	}
+
+	var rec walRecord
+	rec.Seq = w.seq + 1
+	rec.Op = op
+	if rec.Data, err = json.Marshal(data); err != nil {
+		err = fmt.Errorf("Error marshalling %s record for logAndApply: %s", op, err)
+		return
+	}
+
+	var line []byte
+	if line, err = json.Marshal(rec); err != nil {
+		err = fmt.Errorf("Error marshalling record for logAndApply: %s", err)
+		return
+	}
+
+	// this is where the log gets cut back to if the record can't be applied
+	var logInfo os.FileInfo
+	if logInfo, err = w.logFile.Stat(); err != nil {
+		err = fmt.Errorf("Error getting size of log for logAndApply: %s", err)
+		return
+	}
+
+	if _, err = w.logFile.Write(append(line, '\n')); err != nil {
+		err = fmt.Errorf("Error writing record to log for logAndApply: %s", err)
+		if truncErr := w.truncate(logInfo.Size()); truncErr != nil {
+			err = fmt.Errorf("%s, and error removing partial record: %s", err, truncErr)
+		}
+		return
+	}
+	if err = w.logFile.Sync(); err != nil {
+		err = fmt.Errorf("Error syncing log for logAndApply: %s", err)
+		if truncErr := w.truncate(logInfo.Size()); truncErr != nil {
+			err = fmt.Errorf("%s, and error removing the unsynced record: %s", err, truncErr)
+		}
+		return
+	}
+
+	if err = apply(); err != nil {
+		if truncErr := w.truncate(logInfo.Size()); truncErr != nil {
+			err = fmt.Errorf("%s, and error removing the record that failed from the log: %s", err, truncErr)
+		}
+		return
+	}
+	w.seq = rec.Seq
+
+	// the change is already in the log and applied, so a failed snapshot is only logged, and
+	// tried again after another interval
+	if w.sinceSnapshot++; w.sinceSnapshot >= w.snapshotInterval {
+		if snapErr := w.snapshot(); snapErr != nil {
+			logging.Errorf("Error taking snapshot of %s, will try again later: %s", w.logPath, snapErr)
+			w.sinceSnapshot = 0
+		}
+	}
+	return
+}
+
+// truncate cuts the log back to size bytes and syncs it
+func (w *writeAheadLog) truncate(size int64) (err error) {
+	if err = w.logFile.Truncate(size); err != nil {
+		err = fmt.Errorf("Error truncating log %s to %d bytes: %s", w.logPath, size, err)
+		return
+	}
+	if err = w.logFile.Sync(); err != nil {
+		err = fmt.Errorf("Error syncing log %s after truncating: %s", w.logPath, err)
+		return
+	}
+	return
+}
+
+// snapshot writes the entire state to the snapshot file, then truncates the log. The snapshot is
+// written to a temp file and renamed so there's always a complete snapshot on disk.
+func (w *writeAheadLog) snapshot() (err error) {
+	var snap walSnapshot
+	snap.Seq = w.seq
+
+	var state interface{}
+	if state, err = w.state.snapshotState(); err != nil {
+		err = fmt.Errorf("Error getting state for snapshot: %s", err)
+		return
+	}
+	if snap.State, err = json.Marshal(state); err != nil {
+		err = fmt.Errorf("Error marshalling state for snapshot: %s", err)
+		return
+	}
+
+	var raw []byte
+	if raw, err = json.Marshal(snap); err != nil {
+		err = fmt.Errorf("Error marshalling snapshot: %s", err)
+		return
+	}
+
+	tmpPath := w.snapshotPath + ".tmp"
+	var tmpFile *os.File
+	if tmpFile, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600); err != nil {
+		err = fmt.Errorf("Error creating temp snapshot %s: %s", tmpPath, err)
+		return
+	}
+	if _, err = tmpFile.Write(raw); err != nil {
+		tmpFile.Close()
+		err = fmt.Errorf("Error writing temp snapshot %s: %s", tmpPath, err)
+		return
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		err = fmt.Errorf("Error syncing temp snapshot %s: %s", tmpPath, err)
+		return
+	}
+	if err = tmpFile.Close(); err != nil {
+		err = fmt.Errorf("Error closing temp snapshot %s: %s", tmpPath, err)
+		return
+	}
+	if err = os.Rename(tmpPath, w.snapshotPath); err != nil {
+		err = fmt.Errorf("Error renaming snapshot to %s: %s", w.snapshotPath, err)
+		return
+	}
+
+	// everything in the log is in the snapshot now
+	if err = w.logFile.Truncate(0); err != nil {
+		err = fmt.Errorf("Error truncating log %s after snapshot: %s", w.logPath, err)
+		return
+	}
+	w.sinceSnapshot = 0
+	return
+}
+
+// close takes a final snapshot and closes the log, so the next start doesn't have to replay
+// anything.
+func (w *writeAheadLog) close() (err error) {
+	if w == nil {
+		return
+	}
+	if err = w.snapshot(); err != nil {
+		err = fmt.Errorf("Error taking snapshot while closing log: %s", err)
+		return
+	}
+	if err = w.logFile.Close(); err != nil {
+		err = fmt.Errorf("Error closing log %s: %s", w.logPath, err)
+		return
+	}
+	return
+}
@@ -0,0 +1,579 @@
+package cxdbmemory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+func createTestWALConfig(t *testing.T) (conf *WALConfig, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cxdbmemory")
+	if err != nil {
+		t.Fatalf("temp dir err: %v", err)
+	}
+	conf = &WALConfig{Dir: dir}
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+func createTestPair() *match.Pair {
+	btc, _ := match.AssetFromCoinParam(&coinparam.RegressionNetParams)
+	ltc, _ := match.AssetFromCoinParam(&coinparam.LiteRegNetParams)
+	return &match.Pair{AssetWant: btc, AssetHave: ltc}
+}
+
+func TestSettlementEngineWALReplay(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	engine, err := CreateSettlementEngineWithWAL(coin, conf)
+	if err != nil {
+		t.Fatalf("create engine err: %v", err)
+	}
+
+	debit := &match.SettlementExecution{Pubkey: [33]byte{0x02}, Amount: 1000, Type: match.Debit}
+	credit := &match.SettlementExecution{Pubkey: [33]byte{0x02}, Amount: 400, Type: match.Credit}
+	if _, err = engine.ApplySettlementExecution(debit); err != nil {
+		t.Fatalf("debit err: %v", err)
+	}
+	if _, err = engine.ApplySettlementExecution(credit); err != nil {
+		t.Fatalf("credit err: %v", err)
+	}
+
+	// don't close it, so the balance has to come from replaying the log
+	if engine, err = CreateSettlementEngineWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen engine err: %v", err)
+	}
+	defer engine.(*MemorySettlementEngine).DestroyHandler()
+
+	if bal := engine.(*MemorySettlementEngine).balances[debit.Pubkey]; bal != 600 {
+		t.Errorf("balance after replay should be 600, got %d", bal)
+	}
+}
+
+func TestSettlementEngineWALSnapshot(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+	conf.SnapshotInterval = 3
+
+	coin := &coinparam.RegressionNetParams
+	engine, err := CreateSettlementEngineWithWAL(coin, conf)
+	if err != nil {
+		t.Fatalf("create engine err: %v", err)
+	}
+
+	debit := &match.SettlementExecution{Pubkey: [33]byte{0x03}, Amount: 10, Type: match.Debit}
+	for i := 0; i < 4; i++ {
+		if _, err = engine.ApplySettlementExecution(debit); err != nil {
+			t.Fatalf("debit err: %v", err)
+		}
+	}
+
+	// the first three should be in the snapshot, and only the last one in the log
+	wal := engine.(*MemorySettlementEngine).wal
+	if _, err = os.Stat(wal.snapshotPath); err != nil {
+		t.Fatalf("snapshot should exist: %v", err)
+	}
+	if wal.sinceSnapshot != 1 {
+		t.Errorf("log should have 1 record after snapshot, has %d", wal.sinceSnapshot)
+	}
+
+	if engine, err = CreateSettlementEngineWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen engine err: %v", err)
+	}
+	if bal := engine.(*MemorySettlementEngine).balances[debit.Pubkey]; bal != 40 {
+		t.Errorf("balance after snapshot and replay should be 40, got %d", bal)
+	}
+
+	// after a clean shutdown everything is in the snapshot
+	if err = engine.(*MemorySettlementEngine).DestroyHandler(); err != nil {
+		t.Fatalf("destroy err: %v", err)
+	}
+	if engine, err = CreateSettlementEngineWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen engine err: %v", err)
+	}
+	defer engine.(*MemorySettlementEngine).DestroyHandler()
+	if bal := engine.(*MemorySettlementEngine).balances[debit.Pubkey]; bal != 40 {
+		t.Errorf("balance after clean shutdown should be 40, got %d", bal)
+	}
+}
+
+func TestWALIncompleteRecord(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	coin := &coinparam.RegressionNetParams
+	engine, err := CreateSettlementEngineWithWAL(coin, conf)
+	if err != nil {
+		t.Fatalf("create engine err: %v", err)
+	}
+	debit := &match.SettlementExecution{Pubkey: [33]byte{0x04}, Amount: 10, Type: match.Debit}
+	if _, err = engine.ApplySettlementExecution(debit); err != nil {
+		t.Fatalf("debit err: %v", err)
+	}
+
+	// pretend we crashed halfway through writing the next record
+	logPath := filepath.Join(conf.Dir, "settlementengine_"+coin.Name+".wal")
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("open log err: %v", err)
+	}
+	logFile.Write([]byte(`{"seq":2,"op":"settlem`))
+	logFile.Close()
+
+	if engine, err = CreateSettlementEngineWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen engine with incomplete record err: %v", err)
+	}
+	if bal := engine.(*MemorySettlementEngine).balances[debit.Pubkey]; bal != 10 {
+		t.Errorf("balance should be 10, got %d", bal)
+	}
+
+	// new records shouldn't end up after the garbage
+	if _, err = engine.ApplySettlementExecution(debit); err != nil {
+		t.Fatalf("debit err: %v", err)
+	}
+	if engine, err = CreateSettlementEngineWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen engine err: %v", err)
+	}
+	defer engine.(*MemorySettlementEngine).DestroyHandler()
+	if bal := engine.(*MemorySettlementEngine).balances[debit.Pubkey]; bal != 20 {
+		t.Errorf("balance should be 20, got %d", bal)
+	}
+}
+
+func TestDepositStoreWALReplay(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	coin := &coinparam.BitcoinParams
+	store, err := CreateDepositStoreWithWAL(coin, conf)
+	if err != nil {
+		t.Fatalf("create store err: %v", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{3})
+	pub := priv.PubKey()
+	addr := "addr3"
+	if err = store.RegisterUser(pub, addr); err != nil {
+		t.Fatalf("register user err: %v", err)
+	}
+
+	dep := match.Deposit{
+		Pubkey:              pub,
+		Address:             addr,
+		Amount:              100,
+		CoinType:            coin,
+		BlockHeightReceived: 5,
+		Confirmations:       2,
+	}
+	if _, err = store.UpdateDeposits([]match.Deposit{dep}, 5); err != nil {
+		t.Fatalf("update deposits err: %v", err)
+	}
+
+	if store, err = CreateDepositStoreWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen store err: %v", err)
+	}
+	defer store.(*MemoryDepositStore).DestroyHandler()
+
+	if got, err := store.GetDepositAddress(pub); err != nil || got != addr {
+		t.Fatalf("deposit address after replay mismatch: %v %s", err, got)
+	}
+
+	execs, err := store.UpdateDeposits(nil, 7)
+	if err != nil {
+		t.Fatalf("update deposits err: %v", err)
+	}
+	if len(execs) != 1 || execs[0].Amount != dep.Amount {
+		t.Fatalf("pending deposit should confirm after replay, got %v", execs)
+	}
+}
+
+func TestDepositStoreWALDebts(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	coin := &coinparam.BitcoinParams
+	store, err := CreateDepositStoreWithWAL(coin, conf)
+	if err != nil {
+		t.Fatalf("create store err: %v", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{4})
+	pub := priv.PubKey()
+	if err = store.AddDepositDebt(pub, 300); err != nil {
+		t.Fatalf("add debt err: %v", err)
+	}
+	if err = store.PayDepositDebt(pub, 100); err != nil {
+		t.Fatalf("pay debt err: %v", err)
+	}
+	if err = store.PayDepositDebt(pub, 500); err == nil {
+		t.Fatalf("paying more than is owed should fail")
+	}
+
+	// replay from the log
+	if store, err = CreateDepositStoreWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen store err: %v", err)
+	}
+	if debt, err := store.GetDepositDebt(pub); err != nil || debt != 200 {
+		t.Fatalf("debt after replay should be 200, got %d: %v", debt, err)
+	}
+
+	// and from a snapshot
+	if err = store.(*MemoryDepositStore).DestroyHandler(); err != nil {
+		t.Fatalf("destroy err: %v", err)
+	}
+	if store, err = CreateDepositStoreWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen store from snapshot err: %v", err)
+	}
+	defer store.(*MemoryDepositStore).DestroyHandler()
+	if debt, err := store.GetDepositDebt(pub); err != nil || debt != 200 {
+		t.Fatalf("debt after snapshot should be 200, got %d: %v", debt, err)
+	}
+}
+
+func TestDepositStoreWALReplayReorg(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	coin := &coinparam.BitcoinParams
+	store, err := CreateDepositStoreWithWAL(coin, conf)
+	if err != nil {
+		t.Fatalf("create store err: %v", err)
+	}
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{5})
+	pub := priv.PubKey()
+	dep := match.Deposit{
+		Pubkey:              pub,
+		Address:             "addr5",
+		Amount:              100,
+		CoinType:            coin,
+		BlockHeightReceived: 5,
+		BlockHash:           "block5",
+		Confirmations:       2,
+	}
+	if _, err = store.UpdateDeposits([]match.Deposit{dep}, 5); err != nil {
+		t.Fatalf("update deposits err: %v", err)
+	}
+	if _, err = store.UpdateDeposits(nil, 7); err != nil {
+		t.Fatalf("update deposits err: %v", err)
+	}
+	if _, err = store.DisconnectBlocks(6); err != nil {
+		t.Fatalf("disconnect blocks err: %v", err)
+	}
+
+	// the deposit should be pending again after replay, not credited and not forgotten
+	if store, err = CreateDepositStoreWithWAL(coin, conf); err != nil {
+		t.Fatalf("reopen store err: %v", err)
+	}
+	defer store.(*MemoryDepositStore).DestroyHandler()
+
+	rollbacks, err := store.DisconnectBlocks(6)
+	if err != nil || len(rollbacks) != 0 {
+		t.Fatalf("deposit was already rolled back before replay, got %v, err %v", rollbacks, err)
+	}
+	execs, err := store.UpdateDeposits(nil, 7)
+	if err != nil {
+		t.Fatalf("update deposits err: %v", err)
+	}
+	if len(execs) != 1 || execs[0].Amount != dep.Amount {
+		t.Fatalf("pending deposit should confirm again after replay, got %v", execs)
+	}
+}
+
+func TestAuctionEngineWALReplay(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	pair := createTestPair()
+	engine, err := CreateAuctionEngineWithWAL(pair, conf)
+	if err != nil {
+		t.Fatalf("create engine err: %v", err)
+	}
+
+	first, _ := createTestOrder(t, pair)
+	second, _ := createTestOrder(t, pair)
+	auctionID := &match.AuctionID{0x01}
+	if _, err = engine.PlaceAuctionOrder(first.Order, auctionID); err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+	if _, err = engine.PlaceAuctionOrder(second.Order, auctionID); err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+	if _, _, err = engine.CancelAuctionOrder(&first.OrderID); err != nil {
+		t.Fatalf("cancel err: %v", err)
+	}
+
+	if engine, err = CreateAuctionEngineWithWAL(pair, conf); err != nil {
+		t.Fatalf("reopen engine err: %v", err)
+	}
+	defer engine.(*MemoryAuctionEngine).DestroyHandler()
+
+	if _, _, err = engine.CancelAuctionOrder(&first.OrderID); err == nil {
+		t.Errorf("cancelled order should still be cancelled after replay")
+	}
+	_, cancelSettlement, err := engine.CancelAuctionOrder(&second.OrderID)
+	if err != nil {
+		t.Fatalf("cancel after replay err: %v", err)
+	}
+	if cancelSettlement.Amount != second.Order.AmountHave || cancelSettlement.Pubkey != second.Order.Pubkey {
+		t.Errorf("cancel after replay should give back the order's amount")
+	}
+}
+
+func TestAuctionOrderbookWALReplay(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	pair := createTestPair()
+	book, err := CreateAuctionOrderbookWithWAL(pair, conf)
+	if err != nil {
+		t.Fatalf("create orderbook err: %v", err)
+	}
+
+	filled, _ := createTestOrder(t, pair)
+	partial, pub := createTestOrder(t, pair)
+	if err = book.UpdateBookPlace(filled); err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+	if err = book.UpdateBookPlace(partial); err != nil {
+		t.Fatalf("place err: %v", err)
+	}
+	if err = book.UpdateBookExec(&match.OrderExecution{OrderID: filled.OrderID, Filled: true}); err != nil {
+		t.Fatalf("filled exec err: %v", err)
+	}
+	if err = book.UpdateBookExec(&match.OrderExecution{OrderID: partial.OrderID, NewAmountHave: 500, NewAmountWant: 1000}); err != nil {
+		t.Fatalf("partial exec err: %v", err)
+	}
+	if err = book.UpdateBookExec(&match.OrderExecution{OrderID: match.OrderID{0xff}, Filled: true}); err == nil {
+		t.Errorf("exec for an order that isn't in the book should fail")
+	}
+
+	if book, err = CreateAuctionOrderbookWithWAL(pair, conf); err != nil {
+		t.Fatalf("reopen orderbook err: %v", err)
+	}
+	defer book.(*MemoryAuctionOrderbook).DestroyHandler()
+
+	if _, err = book.GetOrder(&filled.OrderID); err == nil {
+		t.Errorf("filled order should not be in the book after replay")
+	}
+	got, err := book.GetOrder(&partial.OrderID)
+	if err != nil {
+		t.Fatalf("get order after replay err: %v", err)
+	}
+	if got.Order.AmountHave != 500 || got.Order.AmountWant != 1000 {
+		t.Errorf("partial fill should survive replay, got have %d want %d", got.Order.AmountHave, got.Order.AmountWant)
+	}
+	orders, err := book.GetOrdersForPubkey(pub)
+	if err != nil {
+		t.Fatalf("get orders for pubkey err: %v", err)
+	}
+	if len(orders[got.Price]) != 1 {
+		t.Errorf("pubkey should have one order after replay")
+	}
+}
+
+func TestPuzzleStoreWALTranscripts(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	pair := createTestPair()
+	store, err := CreatePuzzleStoreWithWAL(pair, conf)
+	if err != nil {
+		t.Fatalf("create store err: %v", err)
+	}
+
+	auctionID := match.AuctionID{0x01}
+	transcript := &match.Transcript{BatchId: auctionID, BatchIdSig: []byte{0x02}, Commitment: [32]byte{0x03}}
+	if err = store.PutTranscript(transcript); err != nil {
+		t.Fatalf("put transcript err: %v", err)
+	}
+	// the transcript is put again once the solutions are in
+	transcript.Solutions = []match.AuctionOrder{{AuctionID: auctionID, AmountHave: 100, AmountWant: 200}}
+	if err = store.PutTranscript(transcript); err != nil {
+		t.Fatalf("put finished transcript err: %v", err)
+	}
+
+	if store, err = CreatePuzzleStoreWithWAL(pair, conf); err != nil {
+		t.Fatalf("reopen store err: %v", err)
+	}
+	defer store.(*MemoryPuzzleStore).DestroyHandler()
+
+	got, err := store.ViewTranscript(&auctionID)
+	if err != nil {
+		t.Fatalf("view transcript after replay err: %v", err)
+	}
+	if got.Commitment != transcript.Commitment || len(got.Solutions) != 1 || got.Solutions[0].AmountWant != 200 {
+		t.Errorf("latest transcript should survive replay, got %+v", got)
+	}
+	if _, err = store.ViewTranscript(&match.AuctionID{0x04}); err == nil {
+		t.Errorf("auction without a transcript should error")
+	}
+}
+
+func TestPuzzleStoreWALAuctions(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	pair := createTestPair()
+	store, err := CreatePuzzleStoreWithWAL(pair, conf)
+	if err != nil {
+		t.Fatalf("create store err: %v", err)
+	}
+
+	pruneTime := time.Unix(1000, 0)
+	submitted := &match.AuctionOrderStatus{Receipt: match.AuctionReceipt{ID: [32]byte{0x01}}, State: match.AuctionOrderSubmitted, Updated: time.Unix(500, 0), Cancels: [][33]byte{{0x02}}}
+	oldFilled := &match.AuctionOrderStatus{Receipt: match.AuctionReceipt{ID: [32]byte{0x02}}, State: match.AuctionOrderFilled, Updated: time.Unix(500, 0)}
+	newPlaced := &match.AuctionOrderStatus{Receipt: match.AuctionReceipt{ID: [32]byte{0x03}}, State: match.AuctionOrderPlaced, Updated: time.Unix(1500, 0)}
+	for _, status := range []*match.AuctionOrderStatus{submitted, oldFilled, newPlaced} {
+		if err = store.PutAuctionOrderStatus(status); err != nil {
+			t.Fatalf("put status err: %v", err)
+		}
+	}
+	oldResult := &match.AuctionResult{AuctionID: match.AuctionID{0x01}, Ended: time.Unix(500, 0)}
+	newResult := &match.AuctionResult{AuctionID: match.AuctionID{0x02}, Ended: time.Unix(1500, 0)}
+	for _, result := range []*match.AuctionResult{oldResult, newResult} {
+		if err = store.PutAuctionResult(result); err != nil {
+			t.Fatalf("put result err: %v", err)
+		}
+	}
+	if err = store.PruneAuctions(pruneTime); err != nil {
+		t.Fatalf("prune err: %v", err)
+	}
+
+	checkStore := func(when string) {
+		got, err := store.ViewAuctionOrderStatus(submitted.Receipt.ID)
+		if err != nil || got == nil || len(got.Cancels) != 1 {
+			t.Errorf("submitted order should keep its cancels %s, got %v err %v", when, got, err)
+		}
+		if got, err = store.ViewAuctionOrderStatus(oldFilled.Receipt.ID); err != nil || got != nil {
+			t.Errorf("old filled order should be pruned %s, got %v err %v", when, got, err)
+		}
+		if got, err = store.ViewAuctionOrderStatus(newPlaced.Receipt.ID); err != nil || got == nil || got.State != match.AuctionOrderPlaced {
+			t.Errorf("new placed order should be kept %s, got %v err %v", when, got, err)
+		}
+		result, err := store.ViewAuctionResult(&oldResult.AuctionID)
+		if err != nil || result != nil {
+			t.Errorf("old result should be pruned %s, got %v err %v", when, result, err)
+		}
+		if result, err = store.ViewAuctionResult(&newResult.AuctionID); err != nil || result == nil || !result.Ended.Equal(newResult.Ended) {
+			t.Errorf("new result should be kept %s, got %v err %v", when, result, err)
+		}
+	}
+	checkStore("before replay")
+
+	if store, err = CreatePuzzleStoreWithWAL(pair, conf); err != nil {
+		t.Fatalf("reopen store err: %v", err)
+	}
+	checkStore("after replay")
+
+	// closing takes a snapshot, which should have the same auctions in it
+	if err = store.(*MemoryPuzzleStore).DestroyHandler(); err != nil {
+		t.Fatalf("destroy err: %v", err)
+	}
+	if store, err = CreatePuzzleStoreWithWAL(pair, conf); err != nil {
+		t.Fatalf("reopen store from snapshot err: %v", err)
+	}
+	defer store.(*MemoryPuzzleStore).DestroyHandler()
+	checkStore("after snapshot")
+}
+
+// countingState counts the records it replays, and fails any record with the op "fail"
+type countingState struct {
+	replayed int
+}
+
+func (c *countingState) snapshotState() (state interface{}, err error) { return c.replayed, nil }
+func (c *countingState) restoreState(state []byte) (err error)         { return nil }
+func (c *countingState) replayRecord(op string, data []byte) (err error) {
+	if op == "fail" {
+		return fmt.Errorf("record should not have been replayed")
+	}
+	c.replayed++
+	return
+}
+
+func TestWALFailedApply(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+
+	wal, err := openWAL(conf, "test", "failedapply", &countingState{})
+	if err != nil {
+		t.Fatalf("open wal err: %v", err)
+	}
+	if err = wal.logAndApply("ok", 1, func() error { return nil }); err != nil {
+		t.Fatalf("apply err: %v", err)
+	}
+	if err = wal.logAndApply("fail", 2, func() error { return fmt.Errorf("apply failed") }); err == nil {
+		t.Fatalf("failed apply should return an error")
+	}
+	if err = wal.logAndApply("ok", 3, func() error { return nil }); err != nil {
+		t.Fatalf("apply after failed apply err: %v", err)
+	}
+	wal.logFile.Close()
+
+	// the failed record should be gone, so the log can still be replayed
+	state := &countingState{}
+	if wal, err = openWAL(conf, "test", "failedapply", state); err != nil {
+		t.Fatalf("reopen wal err: %v", err)
+	}
+	defer wal.logFile.Close()
+	if state.replayed != 2 || wal.seq != 2 {
+		t.Errorf("should have replayed 2 records up to seq 2, replayed %d up to %d", state.replayed, wal.seq)
+	}
+}
+
+func TestWALFailedSnapshot(t *testing.T) {
+	conf, cleanup := createTestWALConfig(t)
+	defer cleanup()
+	conf.SnapshotInterval = 2
+
+	wal, err := openWAL(conf, "test", "failedsnapshot", &countingState{})
+	if err != nil {
+		t.Fatalf("open wal err: %v", err)
+	}
+
+	// a directory where the temp snapshot goes makes every snapshot fail
+	if err = os.Mkdir(wal.snapshotPath+".tmp", 0700); err != nil {
+		t.Fatalf("mkdir err: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = wal.logAndApply("ok", i, func() error { return nil }); err != nil {
+			t.Fatalf("a failed snapshot should not fail the change: %v", err)
+		}
+	}
+	if wal.seq != 3 {
+		t.Fatalf("should be at seq 3, at %d", wal.seq)
+	}
+
+	// once the snapshot can be written, it's taken at the next interval
+	if err = os.Remove(wal.snapshotPath + ".tmp"); err != nil {
+		t.Fatalf("remove err: %v", err)
+	}
+	if err = wal.logAndApply("ok", 3, func() error { return nil }); err != nil {
+		t.Fatalf("apply err: %v", err)
+	}
+	if _, err = os.Stat(wal.snapshotPath); err != nil {
+		t.Errorf("snapshot should have been taken: %v", err)
+	}
+	wal.logFile.Close()
+
+	state := &countingState{}
+	if wal, err = openWAL(conf, "test", "failedsnapshot", state); err != nil {
+		t.Fatalf("reopen wal err: %v", err)
+	}
+	defer wal.logFile.Close()
+	if wal.seq != 4 {
+		t.Errorf("should have reopened at seq 4, at %d", wal.seq)
+	}
+}
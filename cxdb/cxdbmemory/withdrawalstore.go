@@ -0,0 +1,174 @@
+package cxdbmemory
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemoryWithdrawalStore keeps withdrawal requests for a coin in memory
+type MemoryWithdrawalStore struct {
+	coin *coinparam.Params
+
+	// withdrawals are in the order they were added, so a withdrawal's ID is its index plus one
+	withdrawals []*match.WithdrawalRequest
+	// batches are the serialized withdrawal transactions that haven't confirmed yet, by the txid
+	// of their first version
+	batches       map[string][]byte
+	withdrawalMtx *sync.Mutex
+}
+
+// CreateWithdrawalStore creates an in memory withdrawal store for a coin
+func CreateWithdrawalStore(coin *coinparam.Params) (store cxdb.WithdrawalStore, err error) {
+	mw := &MemoryWithdrawalStore{
+		coin:          coin,
+		batches:       make(map[string][]byte),
+		withdrawalMtx: new(sync.Mutex),
+	}
+	store = mw
+	return
+}
+
+// CreateWithdrawalStoreMap creates a map of coin to withdrawal store for a list of coins.
+func CreateWithdrawalStoreMap(coinList []*coinparam.Params) (withdrawalMap map[*coinparam.Params]cxdb.WithdrawalStore, err error) {
+	withdrawalMap = make(map[*coinparam.Params]cxdb.WithdrawalStore)
+	var cur cxdb.WithdrawalStore
+	for _, coin := range coinList {
+		if cur, err = CreateWithdrawalStore(coin); err != nil {
+			return
+		}
+		withdrawalMap[coin] = cur
+	}
+	return
+}
+
+// AddWithdrawal stores a new withdrawal request and sets its ID
+func (mw *MemoryWithdrawalStore) AddWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	withdrawal.ID = uint64(len(mw.withdrawals)) + 1
+	// keep a copy so callers can't change what's stored without UpdateWithdrawal
+	stored := new(match.WithdrawalRequest)
+	*stored = *withdrawal
+	mw.withdrawals = append(mw.withdrawals, stored)
+	return
+}
+
+// UpdateWithdrawal saves the state, txid, reason, fee and update time of a stored withdrawal request
+func (mw *MemoryWithdrawalStore) UpdateWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	if withdrawal.ID == 0 || withdrawal.ID > uint64(len(mw.withdrawals)) {
+		err = fmt.Errorf("Error updating withdrawal, no withdrawal with ID %d", withdrawal.ID)
+		return
+	}
+
+	stored := mw.withdrawals[withdrawal.ID-1]
+	stored.State = withdrawal.State
+	stored.Txid = withdrawal.Txid
+	stored.Reason = withdrawal.Reason
+	stored.Fee = withdrawal.Fee
+	stored.Updated = withdrawal.Updated
+	return
+}
+
+// GetWithdrawal gets a withdrawal request by ID
+func (mw *MemoryWithdrawalStore) GetWithdrawal(id uint64) (withdrawal *match.WithdrawalRequest, err error) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	if id == 0 || id > uint64(len(mw.withdrawals)) {
+		err = fmt.Errorf("Error getting withdrawal, no withdrawal with ID %d", id)
+		return
+	}
+
+	withdrawal = new(match.WithdrawalRequest)
+	*withdrawal = *mw.withdrawals[id-1]
+	return
+}
+
+// GetWithdrawals gets every withdrawal request for a pubkey, oldest first
+func (mw *MemoryWithdrawalStore) GetWithdrawals(pubkey *koblitz.PublicKey) (withdrawals []*match.WithdrawalRequest, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	withdrawals = mw.filterWithdrawals(func(withdrawal *match.WithdrawalRequest) bool {
+		return bytes.Equal(withdrawal.Pubkey[:], pkBytes)
+	})
+	return
+}
+
+// GetWithdrawalsByState gets every withdrawal request in a state, oldest first
+func (mw *MemoryWithdrawalStore) GetWithdrawalsByState(state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error) {
+	withdrawals = mw.filterWithdrawals(func(withdrawal *match.WithdrawalRequest) bool {
+		return withdrawal.State == state
+	})
+	return
+}
+
+// PutWithdrawalBatch stores a withdrawal transaction that hasn't confirmed yet, replacing it if
+// it's already stored
+func (mw *MemoryWithdrawalStore) PutWithdrawalBatch(batch *match.WithdrawalBatch) (err error) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	// keep a copy so the caller can't change what's stored
+	var raw []byte
+	if raw, err = batch.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing withdrawal batch for PutWithdrawalBatch: %s", err)
+		return
+	}
+	mw.batches[batch.Txid] = raw
+	return
+}
+
+// GetWithdrawalBatches gets every withdrawal transaction that hasn't confirmed yet, by txid
+func (mw *MemoryWithdrawalStore) GetWithdrawalBatches() (batches []*match.WithdrawalBatch, err error) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	var txids []string
+	for txid := range mw.batches {
+		txids = append(txids, txid)
+	}
+	sort.Strings(txids)
+	for _, txid := range txids {
+		batch := new(match.WithdrawalBatch)
+		if err = batch.Deserialize(mw.batches[txid]); err != nil {
+			err = fmt.Errorf("Error deserializing withdrawal batch for GetWithdrawalBatches: %s", err)
+			return
+		}
+		batches = append(batches, batch)
+	}
+	return
+}
+
+// RemoveWithdrawalBatch removes a batch once a version of its transaction confirms
+func (mw *MemoryWithdrawalStore) RemoveWithdrawalBatch(txid string) (err error) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	delete(mw.batches, txid)
+	return
+}
+
+// filterWithdrawals returns copies of the withdrawals that keep returns true for, oldest first
+func (mw *MemoryWithdrawalStore) filterWithdrawals(keep func(*match.WithdrawalRequest) bool) (withdrawals []*match.WithdrawalRequest) {
+	mw.withdrawalMtx.Lock()
+	defer mw.withdrawalMtx.Unlock()
+
+	for _, stored := range mw.withdrawals {
+		if keep(stored) {
+			withdrawal := new(match.WithdrawalRequest)
+			*withdrawal = *stored
+			withdrawals = append(withdrawals, withdrawal)
+		}
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package cxdbmemory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestWithdrawalStoreStates(t *testing.T) {
+	store, _ := CreateWithdrawalStore(&coinparam.RegressionNetParams)
+	priv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	pub := priv.PubKey()
+	otherPriv, _ := koblitz.NewPrivateKey(koblitz.S256())
+	start := time.Unix(1500000000, 0)
+
+	var withdrawals []*match.WithdrawalRequest
+	for i, state := range []match.WithdrawalState{match.WithdrawalApproved, match.WithdrawalRequested, match.WithdrawalApproved} {
+		withdrawal := &match.WithdrawalRequest{
+			Asset:     match.BTCTest,
+			Amount:    uint64(1000 * (i + 1)),
+			Address:   "bcrt1qtestaddress",
+			State:     state,
+			Requested: start.Add(time.Duration(i) * time.Minute),
+			Updated:   start.Add(time.Duration(i) * time.Minute),
+		}
+		copy(withdrawal.Pubkey[:], pub.SerializeCompressed())
+		if err := store.AddWithdrawal(withdrawal); err != nil {
+			t.Fatalf("add withdrawal err: %v", err)
+		}
+		if withdrawal.ID != uint64(i+1) {
+			t.Errorf("withdrawal %d should have ID %d, got %d", i, i+1, withdrawal.ID)
+		}
+		withdrawals = append(withdrawals, withdrawal)
+	}
+
+	withdrawals[0].Txid = "testtxid"
+	withdrawals[0].SetState(match.WithdrawalBroadcast, start.Add(time.Hour))
+	if err := store.UpdateWithdrawal(withdrawals[0]); err != nil {
+		t.Fatalf("update withdrawal err: %v", err)
+	}
+
+	got, err := store.GetWithdrawal(withdrawals[0].ID)
+	if err != nil {
+		t.Fatalf("get withdrawal err: %v", err)
+	}
+	if got.State != match.WithdrawalBroadcast || got.Txid != "testtxid" || !got.Updated.Equal(start.Add(time.Hour)) {
+		t.Errorf("withdrawal should be broadcast in testtxid, got %s", got)
+	}
+
+	approved, err := store.GetWithdrawalsByState(match.WithdrawalApproved)
+	if err != nil {
+		t.Fatalf("get withdrawals by state err: %v", err)
+	}
+	if len(approved) != 1 || approved[0].ID != withdrawals[2].ID {
+		t.Errorf("only withdrawal %d should be approved, got %d approved", withdrawals[2].ID, len(approved))
+	}
+
+	all, err := store.GetWithdrawals(pub)
+	if err != nil {
+		t.Fatalf("get withdrawals err: %v", err)
+	}
+	if len(all) != 3 || all[0].ID != 1 || all[2].ID != 3 {
+		t.Errorf("pubkey should have withdrawals 1, 2, 3 oldest first, got %d withdrawals", len(all))
+	}
+
+	if all, err = store.GetWithdrawals(otherPriv.PubKey()); err != nil {
+		t.Fatalf("get withdrawals err: %v", err)
+	}
+	if len(all) != 0 {
+		t.Errorf("other pubkey should have no withdrawals, got %d", len(all))
+	}
+
+	if _, err = store.GetWithdrawal(4); err == nil {
+		t.Errorf("getting a withdrawal that doesn't exist should fail")
+	}
+}
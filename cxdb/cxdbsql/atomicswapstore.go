@@ -0,0 +1,469 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLAtomicSwapStore keeps atomic swaps for every pair in SQL. It uses the swap schema, and
+// like swaps rows are never deleted.
+type SQLAtomicSwapStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// swap schema name
+	swapSchemaName string
+}
+
+// Times are unix nanoseconds like the swap table, apart from the locktimes, which are unix
+// seconds like they are on chain. Outpoints, spend txids and the reason are stored as hex so
+// nothing a user sends ends up in a query.
+const (
+	atomicSwapsTable  = "atomicswaps"
+	atomicSwapsSchema = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, id VARCHAR(64) NOT NULL, rhash VARCHAR(64) NOT NULL, preimage VARCHAR(32) NOT NULL, assetWant TINYINT UNSIGNED, assetHave TINYINT UNSIGNED, " +
+		"initiatorOrderID VARCHAR(64) NOT NULL, initiatorPubkey VARCHAR(66) NOT NULL, initiatorAsset TINYINT UNSIGNED, initiatorAmount BIGINT UNSIGNED, initiatorLocktime INT UNSIGNED, initiatorOutpoint VARCHAR(160) NOT NULL, initiatorFundAmount BIGINT UNSIGNED, initiatorFundHeight BIGINT UNSIGNED, initiatorSpendTxid VARCHAR(128) NOT NULL, initiatorSpendHeight BIGINT UNSIGNED, " +
+		"participantOrderID VARCHAR(64) NOT NULL, participantPubkey VARCHAR(66) NOT NULL, participantAsset TINYINT UNSIGNED, participantAmount BIGINT UNSIGNED, participantLocktime INT UNSIGNED, participantOutpoint VARCHAR(160) NOT NULL, participantFundAmount BIGINT UNSIGNED, participantFundHeight BIGINT UNSIGNED, participantSpendTxid VARCHAR(128) NOT NULL, participantSpendHeight BIGINT UNSIGNED, " +
+		"state VARCHAR(16) NOT NULL, deadline BIGINT, abandoner VARCHAR(66) NOT NULL, reason TEXT, created BIGINT, updated BIGINT, PRIMARY KEY (seq), UNIQUE KEY (id), KEY (initiatorPubkey), KEY (participantPubkey), KEY (state)"
+
+	// the columns we select for atomic swaps, in the order queryAtomicSwaps scans them. Both sides
+	// have the same columns, prefixed with their role.
+	atomicSwapColumns = "id, rhash, preimage, assetWant, assetHave, " +
+		"initiatorOrderID, initiatorPubkey, initiatorAsset, initiatorAmount, initiatorLocktime, initiatorOutpoint, initiatorFundAmount, initiatorFundHeight, initiatorSpendTxid, initiatorSpendHeight, " +
+		"participantOrderID, participantPubkey, participantAsset, participantAmount, participantLocktime, participantOutpoint, participantFundAmount, participantFundHeight, participantSpendTxid, participantSpendHeight, " +
+		"state, deadline, abandoner, reason, created, updated"
+)
+
+// CreateAtomicSwapStoreStructWithConf creates an atomic swap store, returning the struct rather
+// than the interface.
+func CreateAtomicSwapStoreStructWithConf(conf *dbsqlConfig) (ss *SQLAtomicSwapStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateAtomicSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	ss = &SQLAtomicSwapStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		swapSchemaName: conf.SwapSchemaName,
+		dbAddr:         addr,
+	}
+
+	if err = ss.setupAtomicSwapTables(); err != nil {
+		err = fmt.Errorf("Error setting up atomic swap tables for CreateAtomicSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ss.dbUsername, ss.dbPassword, ss.dbAddr.Network(), ss.dbAddr.String())
+	if ss.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateAtomicSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// CreateAtomicSwapStore creates an atomic swap store for every pair
+func CreateAtomicSwapStore() (store cxdb.AtomicSwapStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGAtomicSwapStoreStructWithConf(conf); err != nil {
+			err = fmt.Errorf("Error creating postgres atomic swap store struct for CreateAtomicSwapStore: %s", err)
+			return
+		}
+		return
+	}
+
+	if store, err = CreateAtomicSwapStoreStructWithConf(conf); err != nil {
+		err = fmt.Errorf("Error creating atomic swap store struct for CreateAtomicSwapStore: %s", err)
+		return
+	}
+	return
+}
+
+// setupAtomicSwapTables sets up the table for atomic swaps.
+// This assumes everything else is set
+func (ss *SQLAtomicSwapStore) setupAtomicSwapTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ss.dbUsername, ss.dbPassword, ss.dbAddr.Network(), ss.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup atomic swap tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup atomic swap tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating atomic swap tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup atomic swap tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.swapSchemaName, err)
+		return
+	}
+
+	createQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", atomicSwapsTable, atomicSwapsSchema)
+	if _, err = tx.Exec(createQuery); err != nil {
+		err = fmt.Errorf("Error creating atomic swap table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *SQLAtomicSwapStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new atomic swap store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing atomic swap store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the swap schema. If the returned error is nil, the
+// caller has to call finishSwapTx with its own error, which commits or rolls back.
+func (ss *SQLAtomicSwapStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + ss.swapSchemaName + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using swap schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddAtomicSwap stores a new atomic swap
+func (ss *SQLAtomicSwapStore) AddAtomicSwap(swap *match.AtomicSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddAtomicSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddAtomicSwap", err)
+	}()
+
+	err = insertAtomicSwap(tx, swap)
+	return
+}
+
+// UpdateAtomicSwap saves everything about a stored atomic swap that changes as it moves
+// along
+func (ss *SQLAtomicSwapStore) UpdateAtomicSwap(swap *match.AtomicSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateAtomicSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateAtomicSwap", err)
+	}()
+
+	err = updateAtomicSwap(tx, swap)
+	return
+}
+
+// GetAtomicSwap gets an atomic swap by its ID
+func (ss *SQLAtomicSwapStore) GetAtomicSwap(id [32]byte) (swap *match.AtomicSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetAtomicSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetAtomicSwap", err)
+	}()
+
+	swap, err = getAtomicSwap(tx, id)
+	return
+}
+
+// GetAtomicSwaps gets every atomic swap a pubkey is on either side of, oldest first
+func (ss *SQLAtomicSwapStore) GetAtomicSwaps(pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetAtomicSwaps"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetAtomicSwaps", err)
+	}()
+
+	swaps, err = queryAtomicSwapsForPubkey(tx, pubkey)
+	return
+}
+
+// GetAtomicSwapsByState gets every atomic swap in a state, oldest first
+func (ss *SQLAtomicSwapStore) GetAtomicSwapsByState(state match.AtomicSwapState) (swaps []*match.AtomicSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetAtomicSwapsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetAtomicSwapsByState", err)
+	}()
+
+	swaps, err = queryAtomicSwapsByState(tx, state)
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres atomic swap stores, the queries are
+// the same once the transaction is using the swap schema.
+
+// insertAtomicSwap inserts a new atomic swap into the atomic swap table
+func insertAtomicSwap(tx *sql.Tx, swap *match.AtomicSwap) (err error) {
+	if _, err = match.AtomicSwapStateFromString(string(swap.State)); err != nil {
+		err = fmt.Errorf("Error with atomic swap state: %s", err)
+		return
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES ('%x', '%x', '%x', %d, %d, %s, %s, '%s', %d, '%x', '%x', %d, %d);",
+		atomicSwapsTable, atomicSwapColumns, swap.ID[:], swap.RHash[:], swap.Preimage[:], swap.TradingPair.AssetWant, swap.TradingPair.AssetHave,
+		atomicLegValues(&swap.Initiator), atomicLegValues(&swap.Participant), swap.State, swap.Deadline.UnixNano(), swap.Abandoner[:], swap.Reason,
+		swap.Created.UnixNano(), swap.Updated.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting atomic swap %s: %s", swap.IDString(), err)
+		return
+	}
+	return
+}
+
+// atomicLegValues returns the values of the columns for one side of an atomic swap, in the order
+// they're in atomicSwapColumns
+func atomicLegValues(leg *match.AtomicSwapLeg) string {
+	return fmt.Sprintf("'%x', '%x', %d, %d, %d, '%x', %d, %d, '%x', %d", leg.OrderID[:], leg.Pubkey[:], leg.Asset, leg.Amount, leg.Locktime,
+		leg.Outpoint, leg.FundAmount, leg.FundHeight, leg.SpendTxid, leg.SpendHeight)
+}
+
+// atomicLegUpdates returns the assignments for the columns of one side of an atomic swap that
+// change as it moves along
+func atomicLegUpdates(role match.AtomicSwapRole, leg *match.AtomicSwapLeg) string {
+	return fmt.Sprintf("%[1]sLocktime=%[2]d, %[1]sOutpoint='%[3]x', %[1]sFundAmount=%[4]d, %[1]sFundHeight=%[5]d, %[1]sSpendTxid='%[6]x', %[1]sSpendHeight=%[7]d",
+		role, leg.Locktime, leg.Outpoint, leg.FundAmount, leg.FundHeight, leg.SpendTxid, leg.SpendHeight)
+}
+
+// updateAtomicSwap writes everything about an atomic swap that changes as it moves along
+func updateAtomicSwap(tx *sql.Tx, swap *match.AtomicSwap) (err error) {
+	if _, err = match.AtomicSwapStateFromString(string(swap.State)); err != nil {
+		err = fmt.Errorf("Error with atomic swap state: %s", err)
+		return
+	}
+
+	// check the row is there first, mysql only counts rows that changed
+	if _, err = getAtomicSwap(tx, swap.ID); err != nil {
+		return
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET rhash='%x', preimage='%x', %s, %s, state='%s', deadline=%d, abandoner='%x', reason='%x', updated=%d WHERE id='%x';",
+		atomicSwapsTable, swap.RHash[:], swap.Preimage[:], atomicLegUpdates(match.AtomicInitiator, &swap.Initiator),
+		atomicLegUpdates(match.AtomicParticipant, &swap.Participant), swap.State, swap.Deadline.UnixNano(), swap.Abandoner[:], swap.Reason,
+		swap.Updated.UnixNano(), swap.ID[:])
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating atomic swap %s: %s", swap.IDString(), err)
+		return
+	}
+	return
+}
+
+// getAtomicSwap gets a single atomic swap by its ID
+func getAtomicSwap(tx *sql.Tx, id [32]byte) (swap *match.AtomicSwap, err error) {
+	var swaps []*match.AtomicSwap
+	if swaps, err = queryAtomicSwaps(tx, fmt.Sprintf("id='%x'", id)); err != nil {
+		return
+	}
+	if len(swaps) == 0 {
+		err = fmt.Errorf("No atomic swap %x", id)
+		return
+	}
+	swap = swaps[0]
+	return
+}
+
+// queryAtomicSwapsForPubkey gets every atomic swap a pubkey is on either side of, oldest first
+func queryAtomicSwapsForPubkey(tx *sql.Tx, pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error) {
+	pkBytes := pubkey.SerializeCompressed()
+	swaps, err = queryAtomicSwaps(tx, fmt.Sprintf("initiatorPubkey='%x' OR participantPubkey='%x'", pkBytes, pkBytes))
+	return
+}
+
+// queryAtomicSwapsByState gets every atomic swap in a state, oldest first
+func queryAtomicSwapsByState(tx *sql.Tx, state match.AtomicSwapState) (swaps []*match.AtomicSwap, err error) {
+	if _, err = match.AtomicSwapStateFromString(string(state)); err != nil {
+		err = fmt.Errorf("Error with state for querying atomic swaps: %s", err)
+		return
+	}
+	swaps, err = queryAtomicSwaps(tx, fmt.Sprintf("state='%s'", state))
+	return
+}
+
+// atomicLegRow holds the hex columns of one side of an atomic swap while it's scanned
+type atomicLegRow struct {
+	orderID   string
+	pubkey    string
+	outpoint  string
+	spendTxid string
+}
+
+// scanDests returns where to scan the columns of one side of an atomic swap, in the order
+// they're in atomicSwapColumns
+func (r *atomicLegRow) scanDests(leg *match.AtomicSwapLeg) []interface{} {
+	return []interface{}{&r.orderID, &r.pubkey, &leg.Asset, &leg.Amount, &leg.Locktime, &r.outpoint, &leg.FundAmount, &leg.FundHeight, &r.spendTxid, &leg.SpendHeight}
+}
+
+// decode decodes the hex columns into the side of the atomic swap
+func (r *atomicLegRow) decode(leg *match.AtomicSwapLeg) (err error) {
+	var orderIDBytes, pkBytes, outpointBytes, spendTxidBytes []byte
+	if orderIDBytes, err = hex.DecodeString(r.orderID); err != nil {
+		err = fmt.Errorf("Error decoding order ID: %s", err)
+		return
+	}
+	if pkBytes, err = hex.DecodeString(r.pubkey); err != nil {
+		err = fmt.Errorf("Error decoding pubkey: %s", err)
+		return
+	}
+	if outpointBytes, err = hex.DecodeString(r.outpoint); err != nil {
+		err = fmt.Errorf("Error decoding outpoint: %s", err)
+		return
+	}
+	if spendTxidBytes, err = hex.DecodeString(r.spendTxid); err != nil {
+		err = fmt.Errorf("Error decoding spend txid: %s", err)
+		return
+	}
+	copy(leg.OrderID[:], orderIDBytes)
+	copy(leg.Pubkey[:], pkBytes)
+	leg.Outpoint = string(outpointBytes)
+	leg.SpendTxid = string(spendTxidBytes)
+	return
+}
+
+// queryAtomicSwaps gets the atomic swaps matching a condition, oldest first
+func queryAtomicSwaps(tx *sql.Tx, condition string) (swaps []*match.AtomicSwap, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq;", atomicSwapColumns, atomicSwapsTable, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying atomic swaps: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		swap := new(match.AtomicSwap)
+		var idString, rhashString, preimageString, stateString, abandonerString, reasonString string
+		var initiatorRow, participantRow atomicLegRow
+		var deadline, created, updated int64
+		dests := []interface{}{&idString, &rhashString, &preimageString, &swap.TradingPair.AssetWant, &swap.TradingPair.AssetHave}
+		dests = append(dests, initiatorRow.scanDests(&swap.Initiator)...)
+		dests = append(dests, participantRow.scanDests(&swap.Participant)...)
+		dests = append(dests, &stateString, &deadline, &abandonerString, &reasonString, &created, &updated)
+		if err = rows.Scan(dests...); err != nil {
+			err = fmt.Errorf("Error scanning atomic swap: %s", err)
+			return
+		}
+
+		var idBytes, rhashBytes, preimageBytes, abandonerBytes, reasonBytes []byte
+		if idBytes, err = hex.DecodeString(idString); err != nil {
+			err = fmt.Errorf("Error decoding atomic swap ID: %s", err)
+			return
+		}
+		if rhashBytes, err = hex.DecodeString(rhashString); err != nil {
+			err = fmt.Errorf("Error decoding hash for atomic swap %s: %s", idString, err)
+			return
+		}
+		if preimageBytes, err = hex.DecodeString(preimageString); err != nil {
+			err = fmt.Errorf("Error decoding preimage for atomic swap %s: %s", idString, err)
+			return
+		}
+		if abandonerBytes, err = hex.DecodeString(abandonerString); err != nil {
+			err = fmt.Errorf("Error decoding abandoner for atomic swap %s: %s", idString, err)
+			return
+		}
+		if reasonBytes, err = hex.DecodeString(reasonString); err != nil {
+			err = fmt.Errorf("Error decoding reason for atomic swap %s: %s", idString, err)
+			return
+		}
+		if err = initiatorRow.decode(&swap.Initiator); err != nil {
+			err = fmt.Errorf("Error with initiator side of atomic swap %s: %s", idString, err)
+			return
+		}
+		if err = participantRow.decode(&swap.Participant); err != nil {
+			err = fmt.Errorf("Error with participant side of atomic swap %s: %s", idString, err)
+			return
+		}
+		if swap.State, err = match.AtomicSwapStateFromString(stateString); err != nil {
+			return
+		}
+
+		copy(swap.ID[:], idBytes)
+		copy(swap.RHash[:], rhashBytes)
+		copy(swap.Preimage[:], preimageBytes)
+		copy(swap.Abandoner[:], abandonerBytes)
+		swap.Reason = string(reasonBytes)
+		swap.Deadline = time.Unix(0, deadline)
+		swap.Created = time.Unix(0, created)
+		swap.Updated = time.Unix(0, updated)
+		swaps = append(swaps, swap)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading atomic swap rows: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package cxdbsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestAtomicSwapStoreStates adds an atomic swap, moves it along, and checks it can be looked up
+// by ID, by either side's pubkey and by state
+func TestAtomicSwapStoreStates(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var ss *SQLAtomicSwapStore
+	if ss, err = CreateAtomicSwapStoreStructWithConf(testConfig()); err != nil {
+		t.Errorf("Error creating atomic swap store: %s", err)
+		return
+	}
+	defer ss.DestroyHandler()
+
+	var taker, maker *koblitz.PrivateKey
+	if taker, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+	if maker, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+	var takerPubkey, makerPubkey [33]byte
+	copy(takerPubkey[:], taker.PubKey().SerializeCompressed())
+	copy(makerPubkey[:], maker.PubKey().SerializeCompressed())
+
+	created := time.Unix(1500000000, 0)
+	fill := &match.FillEntry{OrderID: match.OrderID{0x02}, Pubkey: makerPubkey, TradingPair: match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest}, Side: match.Buy, AmountHave: 150000, AmountWant: 1000}
+	var swap *match.AtomicSwap
+	if swap, err = match.NewAtomicSwap(match.OrderID{0x01}, takerPubkey, fill, match.DefaultAtomicSwapPolicy(), created); err != nil {
+		t.Errorf("Error creating atomic swap: %s", err)
+		return
+	}
+	if err = ss.AddAtomicSwap(swap); err != nil {
+		t.Errorf("Error adding atomic swap: %s", err)
+		return
+	}
+
+	swap.RHash = [32]byte{0x03}
+	swap.Initiator.Locktime = 1500100000
+	swap.Initiator.Outpoint = "0011:1"
+	swap.Initiator.FundAmount = 1000
+	swap.Initiator.FundHeight = 110
+	swap.SetState(match.AtomicSwapInitiated, created.Add(time.Hour), created.Add(time.Minute))
+	if err = ss.UpdateAtomicSwap(swap); err != nil {
+		t.Errorf("Error updating atomic swap: %s", err)
+		return
+	}
+
+	var got *match.AtomicSwap
+	if got, err = ss.GetAtomicSwap(swap.ID); err != nil {
+		t.Errorf("Error getting atomic swap: %s", err)
+		return
+	}
+	if got.State != match.AtomicSwapInitiated || got.RHash != swap.RHash || got.Initiator != swap.Initiator || got.Participant != swap.Participant || got.TradingPair != swap.TradingPair || !got.Deadline.Equal(swap.Deadline) {
+		t.Errorf("Atomic swap should be initiated and keep both sides, got %s", got)
+	}
+
+	var initiated []*match.AtomicSwap
+	if initiated, err = ss.GetAtomicSwapsByState(match.AtomicSwapInitiated); err != nil {
+		t.Errorf("Error getting initiated atomic swaps: %s", err)
+		return
+	}
+	if len(initiated) != 1 || initiated[0].ID != swap.ID {
+		t.Errorf("The atomic swap should be initiated, got %d initiated", len(initiated))
+	}
+
+	for _, priv := range []*koblitz.PrivateKey{taker, maker} {
+		var all []*match.AtomicSwap
+		if all, err = ss.GetAtomicSwaps(priv.PubKey()); err != nil {
+			t.Errorf("Error getting atomic swaps: %s", err)
+			return
+		}
+		if len(all) != 1 {
+			t.Errorf("Both sides should have 1 atomic swap, got %d", len(all))
+		}
+	}
+
+	if err = ss.UpdateAtomicSwap(&match.AtomicSwap{State: match.AtomicSwapAbandoned}); err == nil {
+		t.Errorf("Updating an atomic swap that doesn't exist should fail")
+	}
+}
@@ -37,6 +37,18 @@ const (
 
 // CreateAuctionEngineWithConf creates an auction engine, sets up the connection and tables, and returns the auctionengine interface.
 func CreateAuctionEngineWithConf(pair *match.Pair, conf *dbsqlConfig) (engine match.AuctionEngine, err error) {
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		var pgae *PGAuctionEngine
+		if pgae, err = CreatePGAucEngineStructWithConf(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres auction engine struct w/ conf for CreateAuctionEngineWithConf: %s", err)
+			return
+		}
+		engine = pgae
+		return
+	}
 
 	var ae *SQLAuctionEngine
 	if ae, err = CreateAucEngineStructWithConf(pair, conf); err != nil {
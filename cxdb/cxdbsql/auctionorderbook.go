@@ -43,6 +43,14 @@ func CreateAuctionOrderbook(pair *match.Pair) (book match.AuctionOrderbook, err
 	// set the default conf
 	dbConfigSetup(conf)
 
+	if conf.DBDriver == postgresDriver {
+		if book, err = CreatePGAuctionOrderbookStructWithConf(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres auction orderbook for CreateAuctionOrderbook: %s", err)
+			return
+		}
+		return
+	}
+
 	// Resolve new address
 	var addr net.Addr
 	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
@@ -0,0 +1,554 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLColdStore keeps the outputs paying to a coin's cold wallet in SQL.
+type SQLColdStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// cold schema name
+	coldSchemaName string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// Each coin gets a table in the cold schema, keyed by outpoint. A spent height of 0 means the
+// output is unspent.
+const (
+	coldStoreSchema = "txid VARCHAR(64) NOT NULL, idx INT UNSIGNED NOT NULL, amount BIGINT UNSIGNED, pkscript TEXT, keyindex INT UNSIGNED, height BIGINT UNSIGNED, spentheight BIGINT UNSIGNED, PRIMARY KEY (txid, idx)"
+
+	// the columns we select for cold outputs, in the order queryColdUtxos scans them
+	coldUtxoColumns = "txid, idx, amount, pkscript, keyindex, height, spentheight"
+
+	// refills waiting to be signed are in another table for the coin, keyed by unsigned txid.
+	// This is the same for mysql and postgres.
+	coldRefillSchema = "txid VARCHAR(64) NOT NULL, encodedRefill TEXT, PRIMARY KEY (txid)"
+)
+
+// CreateColdStoreStructWithConf creates a cold store for a coin, returning the struct rather than
+// the interface.
+func CreateColdStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (cs *SQLColdStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateColdStoreStructWithConf: %s", err)
+		return
+	}
+
+	cs = &SQLColdStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		coldSchemaName: conf.ColdSchemaName,
+
+		dbAddr: addr,
+		coin:   coin,
+	}
+
+	if err = cs.setupColdTables(); err != nil {
+		err = fmt.Errorf("Error setting up cold tables for CreateColdStoreStructWithConf: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", cs.dbUsername, cs.dbPassword, cs.dbAddr.Network(), cs.dbAddr.String())
+	if cs.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateColdStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = cs.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// CreateColdStore creates a cold store for a coin
+func CreateColdStore(coin *coinparam.Params) (store cxdb.ColdStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGColdStoreStructWithConf(coin, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres cold store struct for CreateColdStore: %s", err)
+			return
+		}
+		return
+	}
+
+	if store, err = CreateColdStoreStructWithConf(coin, conf); err != nil {
+		err = fmt.Errorf("Error creating cold store struct for CreateColdStore: %s", err)
+		return
+	}
+	return
+}
+
+// setupColdTables sets up the table for this coin's cold outputs.
+// This assumes everything else is set
+func (cs *SQLColdStore) setupColdTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", cs.dbUsername, cs.dbPassword, cs.dbAddr.Network(), cs.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup cold tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup cold tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating cold tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + cs.coldSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup cold tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + cs.coldSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", cs.coldSchemaName, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", cs.coin.Name, coldStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating cold table: %s", err)
+		return
+	}
+
+	createRefillTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", refillTable(cs.coin), coldRefillSchema)
+	if _, err = tx.Exec(createRefillTableQuery); err != nil {
+		err = fmt.Errorf("Error creating refill table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (cs *SQLColdStore) DestroyHandler() (err error) {
+	if cs.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new cold store")
+		return
+	}
+	if err = cs.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing cold store handler for DestroyHandler: %s", err)
+		return
+	}
+	cs.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the cold schema. If the returned error is nil, the caller
+// has to call finishColdTx with its own error, which commits or rolls back.
+func (cs *SQLColdStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = cs.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + cs.coldSchemaName + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using cold schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// finishColdTx commits the transaction if there was no error and rolls it back if there was
+func finishColdTx(tx *sql.Tx, funcName string, err error) error {
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error with %s: \n%s", funcName, err)
+	}
+	return tx.Commit()
+}
+
+// AddColdUtxos stores new outputs paying to the cold wallet
+func (cs *SQLColdStore) AddColdUtxos(utxos []*match.ColdUtxo) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("AddColdUtxos"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "AddColdUtxos", err)
+	}()
+
+	for _, utxo := range utxos {
+		var values string
+		if values, err = coldUtxoValues(utxo); err != nil {
+			return
+		}
+		insertQuery := fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES %s;", cs.coin.Name, coldUtxoColumns, values)
+		if _, err = tx.Exec(insertQuery); err != nil {
+			err = fmt.Errorf("Error inserting cold utxo %s: %s", utxo.OutPoint(), err)
+			return
+		}
+	}
+	return
+}
+
+// SpendColdUtxos marks cold outputs as spent in the block at blockheight
+func (cs *SQLColdStore) SpendColdUtxos(outpoints []string, blockheight uint64) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("SpendColdUtxos"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "SpendColdUtxos", err)
+	}()
+
+	err = spendColdUtxos(tx, cs.coin.Name, outpoints, blockheight)
+	return
+}
+
+// DisconnectBlocks removes outputs seen above blockheight and unspends outputs spent above it
+func (cs *SQLColdStore) DisconnectBlocks(blockheight uint64) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("DisconnectBlocks"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "DisconnectBlocks", err)
+	}()
+
+	err = disconnectColdBlocks(tx, cs.coin.Name, blockheight)
+	return
+}
+
+// GetColdUtxos gets every unspent cold output, oldest first
+func (cs *SQLColdStore) GetColdUtxos() (utxos []*match.ColdUtxo, err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("GetColdUtxos"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "GetColdUtxos", err)
+	}()
+
+	utxos, err = queryColdUtxos(tx, cs.coin.Name)
+	return
+}
+
+// AddRefill stores a refill that's waiting to be signed
+func (cs *SQLColdStore) AddRefill(txid string, refill *match.RefillTx) (err error) {
+	var encodedRefill string
+	if encodedRefill, err = encodeRefill(txid, refill); err != nil {
+		err = fmt.Errorf("Error encoding refill for AddRefill: %s", err)
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = cs.begin("AddRefill"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "AddRefill", err)
+	}()
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%s', '%s') ON DUPLICATE KEY UPDATE encodedRefill='%[3]s';", refillTable(cs.coin), txid, encodedRefill)
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting refill %s: %s", txid, err)
+		return
+	}
+	return
+}
+
+// GetRefill gets the refill waiting to be signed with an unsigned txid, nil if there isn't one
+func (cs *SQLColdStore) GetRefill(txid string) (refill *match.RefillTx, err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("GetRefill"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "GetRefill", err)
+	}()
+
+	refill, err = queryRefill(tx, refillTable(cs.coin), txid)
+	return
+}
+
+// GetRefills gets every refill waiting to be signed, by unsigned txid
+func (cs *SQLColdStore) GetRefills() (refills []*match.RefillTx, err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("GetRefills"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "GetRefills", err)
+	}()
+
+	refills, err = queryRefills(tx, refillTable(cs.coin))
+	return
+}
+
+// RemoveRefill removes a refill once it's signed and sent
+func (cs *SQLColdStore) RemoveRefill(txid string) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("RemoveRefill"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "RemoveRefill", err)
+	}()
+
+	err = removeRefill(tx, refillTable(cs.coin), txid)
+	return
+}
+
+// CreateColdStoreMap creates a map of coin to cold store, given a list of coins.
+func CreateColdStoreMap(coinList []*coinparam.Params) (coldMap map[*coinparam.Params]cxdb.ColdStore, err error) {
+
+	coldMap = make(map[*coinparam.Params]cxdb.ColdStore)
+	var curColdStore cxdb.ColdStore
+	for _, coin := range coinList {
+		if curColdStore, err = CreateColdStore(coin); err != nil {
+			err = fmt.Errorf("Error creating single cold store while creating cold store map: %s", err)
+			return
+		}
+		coldMap[coin] = curColdStore
+	}
+
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres cold stores, the queries are the same
+// once the transaction is using the cold schema.
+
+// parseOutPoint splits a txid:index outpoint, making sure the txid is hex so it can go in a query
+func parseOutPoint(outpoint string) (txid string, index uint32, err error) {
+	sep := strings.LastIndex(outpoint, ":")
+	if sep == -1 {
+		err = fmt.Errorf("Outpoint %s should look like txid:index", outpoint)
+		return
+	}
+	txid = outpoint[:sep]
+	if _, err = hex.DecodeString(txid); err != nil {
+		err = fmt.Errorf("Error with outpoint txid, should be hex: %s", err)
+		return
+	}
+	var index64 uint64
+	if index64, err = strconv.ParseUint(outpoint[sep+1:], 10, 32); err != nil {
+		err = fmt.Errorf("Error parsing outpoint index: %s", err)
+		return
+	}
+	index = uint32(index64)
+	return
+}
+
+// coldUtxoValues creates the values for inserting a cold output, in the order of coldUtxoColumns
+func coldUtxoValues(utxo *match.ColdUtxo) (values string, err error) {
+	if _, err = hex.DecodeString(utxo.Txid); err != nil {
+		err = fmt.Errorf("Error with cold utxo txid, should be hex: %s", err)
+		return
+	}
+	values = fmt.Sprintf("('%s', %d, %d, '%x', %d, %d, %d)", utxo.Txid, utxo.Index, utxo.Amount, utxo.PkScript, utxo.KeyIndex, utxo.Height, utxo.SpentHeight)
+	return
+}
+
+// spendColdUtxos sets the spent height of cold outputs that are unspent
+func spendColdUtxos(tx *sql.Tx, table string, outpoints []string, blockheight uint64) (err error) {
+	for _, outpoint := range outpoints {
+		var txid string
+		var index uint32
+		if txid, index, err = parseOutPoint(outpoint); err != nil {
+			return
+		}
+		updateQuery := fmt.Sprintf("UPDATE %s SET spentheight=%d WHERE txid='%s' AND idx=%d AND spentheight=0;", table, blockheight, txid, index)
+		if _, err = tx.Exec(updateQuery); err != nil {
+			err = fmt.Errorf("Error spending cold utxo %s: %s", outpoint, err)
+			return
+		}
+	}
+	return
+}
+
+// disconnectColdBlocks deletes cold outputs seen above blockheight and unspends ones spent above it
+func disconnectColdBlocks(tx *sql.Tx, table string, blockheight uint64) (err error) {
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE height>%d;", table, blockheight)
+	if _, err = tx.Exec(deleteQuery); err != nil {
+		err = fmt.Errorf("Error deleting orphaned cold utxos: %s", err)
+		return
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET spentheight=0 WHERE spentheight>%d;", table, blockheight)
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error unspending cold utxos: %s", err)
+		return
+	}
+	return
+}
+
+// queryColdUtxos gets every unspent cold output from a coin's table, oldest first
+func queryColdUtxos(tx *sql.Tx, table string) (utxos []*match.ColdUtxo, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE spentheight=0 ORDER BY height, txid, idx;", coldUtxoColumns, table)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying cold utxos: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		utxo := new(match.ColdUtxo)
+		var pkScriptString string
+		if err = rows.Scan(&utxo.Txid, &utxo.Index, &utxo.Amount, &pkScriptString, &utxo.KeyIndex, &utxo.Height, &utxo.SpentHeight); err != nil {
+			err = fmt.Errorf("Error scanning cold utxo: %s", err)
+			return
+		}
+		if utxo.PkScript, err = hex.DecodeString(pkScriptString); err != nil {
+			err = fmt.Errorf("Error decoding pkscript for cold utxo %s: %s", utxo.OutPoint(), err)
+			return
+		}
+		utxos = append(utxos, utxo)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading cold utxo rows: %s", err)
+		return
+	}
+	return
+}
+
+// refillTable is the name of the table a coin's refills waiting to be signed are kept in
+func refillTable(coin *coinparam.Params) string {
+	return coin.Name + "_refills"
+}
+
+// encodeRefill serializes a refill as hex so it can go in a query, making sure its txid is hex too
+func encodeRefill(txid string, refill *match.RefillTx) (encodedRefill string, err error) {
+	if _, err = hex.DecodeString(txid); err != nil {
+		err = fmt.Errorf("Error with refill txid, should be hex: %s", err)
+		return
+	}
+	var raw []byte
+	if raw, err = refill.Serialize(); err != nil {
+		return
+	}
+	encodedRefill = hex.EncodeToString(raw)
+	return
+}
+
+// decodeRefill turns a hex refill from a table back into a refill
+func decodeRefill(encodedRefill string) (refill *match.RefillTx, err error) {
+	var raw []byte
+	if raw, err = hex.DecodeString(encodedRefill); err != nil {
+		err = fmt.Errorf("Error decoding hex refill: %s", err)
+		return
+	}
+	refill = new(match.RefillTx)
+	if err = refill.Deserialize(raw); err != nil {
+		refill = nil
+		return
+	}
+	return
+}
+
+// queryRefill gets the refill with an unsigned txid, nil if there isn't one
+func queryRefill(tx *sql.Tx, table string, txid string) (refill *match.RefillTx, err error) {
+	if _, err = hex.DecodeString(txid); err != nil {
+		err = fmt.Errorf("Error with refill txid, should be hex: %s", err)
+		return
+	}
+
+	var encodedRefill string
+	selectQuery := fmt.Sprintf("SELECT encodedRefill FROM %s WHERE txid='%s';", table, txid)
+	if err = tx.QueryRow(selectQuery).Scan(&encodedRefill); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Error querying refill %s: %s", txid, err)
+		return
+	}
+	refill, err = decodeRefill(encodedRefill)
+	return
+}
+
+// queryRefills gets every refill from a coin's refill table, by unsigned txid
+func queryRefills(tx *sql.Tx, table string) (refills []*match.RefillTx, err error) {
+	selectQuery := fmt.Sprintf("SELECT encodedRefill FROM %s ORDER BY txid;", table)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying refills: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var encodedRefill string
+		if err = rows.Scan(&encodedRefill); err != nil {
+			err = fmt.Errorf("Error scanning refill: %s", err)
+			return
+		}
+		var refill *match.RefillTx
+		if refill, err = decodeRefill(encodedRefill); err != nil {
+			return
+		}
+		refills = append(refills, refill)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading refill rows: %s", err)
+		return
+	}
+	return
+}
+
+// removeRefill deletes the refill with an unsigned txid
+func removeRefill(tx *sql.Tx, table string, txid string) (err error) {
+	if _, err = hex.DecodeString(txid); err != nil {
+		err = fmt.Errorf("Error with refill txid, should be hex: %s", err)
+		return
+	}
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE txid='%s';", table, txid)
+	if _, err = tx.Exec(deleteQuery); err != nil {
+		err = fmt.Errorf("Error deleting refill %s: %s", txid, err)
+		return
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package cxdbsql
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestColdStoreReorg adds and spends cold outputs, then makes sure a reorg drops the outputs and
+// spends it disconnected
+func TestColdStoreReorg(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	coin := &coinparam.RegressionNetParams
+	var cs *SQLColdStore
+	if cs, err = CreateColdStoreStructWithConf(coin, testConfig()); err != nil {
+		t.Errorf("Error creating cold store: %s", err)
+		return
+	}
+	defer cs.DestroyHandler()
+
+	first := &match.ColdUtxo{Txid: "aa", Index: 0, Amount: 1000, PkScript: []byte{0x00, 0x14, 0x01}, KeyIndex: 4, Height: 10}
+	second := &match.ColdUtxo{Txid: "bb", Index: 1, Amount: 2000, Height: 12}
+	if err = cs.AddColdUtxos([]*match.ColdUtxo{first, second, first}); err != nil {
+		t.Errorf("Error adding cold utxos: %s", err)
+		return
+	}
+	if err = cs.SpendColdUtxos([]string{first.OutPoint(), "cc:0"}, 13); err != nil {
+		t.Errorf("Error spending cold utxos: %s", err)
+		return
+	}
+
+	var utxos []*match.ColdUtxo
+	if utxos, err = cs.GetColdUtxos(); err != nil {
+		t.Errorf("Error getting cold utxos: %s", err)
+		return
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint() != second.OutPoint() {
+		t.Errorf("Only %s should be unspent, got %d unspent", second.OutPoint(), len(utxos))
+		return
+	}
+
+	if err = cs.DisconnectBlocks(11); err != nil {
+		t.Errorf("Error disconnecting blocks: %s", err)
+		return
+	}
+	if utxos, err = cs.GetColdUtxos(); err != nil {
+		t.Errorf("Error getting cold utxos: %s", err)
+		return
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint() != first.OutPoint() || utxos[0].KeyIndex != 4 || !bytes.Equal(utxos[0].PkScript, first.PkScript) {
+		t.Errorf("Only %s should be unspent after the reorg, got %d unspent", first.OutPoint(), len(utxos))
+	}
+}
@@ -141,6 +141,9 @@ func testConfig() (conf *dbsqlConfig) {
 		AuctionOrderSchemaName:   testString + defaultAuctionOrderSchema,
 		OrderSchemaName:          testString + defaultOrderSchema,
 		PeerSchemaName:           testString + defaultPeerSchema,
+		WithdrawalSchemaName:     testString + defaultWithdrawalSchema,
+		ColdSchemaName:           testString + defaultColdSchema,
+		SwapSchemaName:           testString + defaultSwapSchema,
 
 		// tables
 		PuzzleTableName:       testString + defaultPuzzleTable,
@@ -175,5 +178,8 @@ func getSchemasFromConfig(conf *dbsqlConfig) (schemas []string) {
 		conf.BalanceSchemaName,
 		conf.OrderSchemaName,
 		conf.PeerSchemaName,
+		conf.WithdrawalSchemaName,
+		conf.ColdSchemaName,
+		conf.SwapSchemaName,
 	}
 }
@@ -16,12 +16,19 @@ type dbsqlConfig struct {
 	// database home dir
 	DBHomeDir string `long:"dir" description:"Location of the root directory for the sql db info and config"`
 
+	// database driver, either mysql or postgres
+	DBDriver string `long:"dbdriver" description:"Database driver to use, either mysql or postgres"`
+
 	// database info required to establish connection
 	DBUsername string `long:"dbuser" description:"database username"`
 	DBPassword string `long:"dbpassword" description:"database password"`
 	DBHost     string `long:"dbhost" description:"Host for the database connection"`
 	DBPort     uint16 `long:"dbport" description:"Port for the database connection"`
 
+	// postgres only, since postgres schemas live inside of a database
+	DBName    string `long:"dbname" description:"Name of the database to connect to (postgres only)"`
+	DBSSLMode string `long:"dbsslmode" description:"SSL mode for the database connection (postgres only)"`
+
 	// database schema names
 	ReadOnlyOrderSchemaName   string `long:"readonlyorderschema" description:"Name of read-only orderbook schema"`
 	ReadOnlyAuctionSchemaName string `long:"readonlyauctionschema" description:"Name of read-only auction schema"`
@@ -34,6 +41,10 @@ type dbsqlConfig struct {
 	AuctionOrderSchemaName    string `long:"auctionorderschema" description:"Name of schema for auction orderbook"`
 	OrderSchemaName           string `long:"orderschema" description:"Name of schema for limit orderbook"`
 	PeerSchemaName            string `long:"peerschema" description:"Name of schema for peer storage"`
+	HistorySchemaName         string `long:"historyschema" description:"Name of schema for order and fill history"`
+	WithdrawalSchemaName      string `long:"withdrawalschema" description:"Name of schema for withdrawal requests"`
+	ColdSchemaName            string `long:"coldschema" description:"Name of schema for cold wallet outputs"`
+	SwapSchemaName            string `long:"swapschema" description:"Name of schema for lightning swaps"`
 
 	// database table names
 	PuzzleTableName       string `long:"puzzletable" description:"Name of table for puzzle orderbooks"`
@@ -41,12 +52,22 @@ type dbsqlConfig struct {
 	PeerTableName         string `long:"peertable" description:"Name of table for peer storage"`
 }
 
+// The drivers we know how to talk to
+const (
+	mysqlDriver    = "mysql"
+	postgresDriver = "postgres"
+)
+
 // Let these be turned into config things at some point
 var (
 	defaultConfigFilename = "sqldb.conf"
 	defaultHomeDir        = os.Getenv("HOME")
 	defaultDBHomeDirName  = defaultHomeDir + "/.opencx/db/"
+	defaultDBDriver       = mysqlDriver
 	defaultDBPort         = uint16(3306)
+	defaultPGDBPort       = uint16(5432)
+	defaultDBName         = "opencx"
+	defaultDBSSLMode      = "disable"
 	defaultDBHost         = "localhost"
 	defaultDBUser         = "opencx"
 	defaultDBPass         = "testpass"
@@ -63,6 +84,10 @@ var (
 	defaultAuctionOrderSchema    = "auctionorder"
 	defaultOrderSchema           = "orders"
 	defaultPeerSchema            = "peers"
+	defaultHistorySchema         = "history"
+	defaultWithdrawalSchema      = "withdrawals"
+	defaultColdSchema            = "cold"
+	defaultSwapSchema            = "swaps"
 
 	// tables
 	defaultAuctionOrderTable = "auctionorders"
@@ -74,11 +99,16 @@ var (
 		// home dir
 		DBHomeDir: defaultDBHomeDirName,
 
+		// driver
+		DBDriver: defaultDBDriver,
+
 		// user / pass / net stuff
 		DBUsername: defaultDBUser,
 		DBPassword: defaultDBPass,
 		DBHost:     defaultDBHost,
 		DBPort:     defaultDBPort,
+		DBName:     defaultDBName,
+		DBSSLMode:  defaultDBSSLMode,
 
 		// schemas
 		ReadOnlyAuctionSchemaName: defaultReadOnlyAuctionSchema,
@@ -92,6 +122,10 @@ var (
 		AuctionOrderSchemaName:    defaultAuctionOrderSchema,
 		OrderSchemaName:           defaultOrderSchema,
 		PeerSchemaName:            defaultPeerSchema,
+		HistorySchemaName:         defaultHistorySchema,
+		WithdrawalSchemaName:      defaultWithdrawalSchema,
+		ColdSchemaName:            defaultColdSchema,
+		SwapSchemaName:            defaultSwapSchema,
 
 		// tables
 		PuzzleTableName:       defaultPuzzleTable,
@@ -118,7 +152,7 @@ func createDefaultConfigFile(destinationPath string) error {
 	defer dest.Close()
 
 	writer := bufio.NewWriter(dest)
-	defaultArgs := []byte("dbuser=opencx\ndbpassword=testpass\n")
+	defaultArgs := []byte("dbdriver=mysql\ndbuser=opencx\ndbpassword=testpass\n")
 	_, err = writer.Write(defaultArgs)
 	if err != nil {
 		return err
@@ -168,5 +202,19 @@ func dbConfigSetup(conf *dbsqlConfig) {
 		}
 	}
 
+	switch conf.DBDriver {
+	case "":
+		// older config files don't have a driver, they were all mysql
+		conf.DBDriver = mysqlDriver
+	case mysqlDriver:
+	case postgresDriver:
+		// If the port was never changed from the mysql default, use the postgres one
+		if conf.DBPort == defaultDBPort {
+			conf.DBPort = defaultPGDBPort
+		}
+	default:
+		logging.Fatalf("Unknown database driver %s in config, use %s or %s", conf.DBDriver, mysqlDriver, postgresDriver)
+	}
+
 	return
 }
@@ -36,7 +36,8 @@ type SQLDepositStore struct {
 // The schema for the deposit store
 const (
 	depositAddrStoreSchema    = "pubkey VARBINARY(66), address VARCHAR(34), CONSTRAINT unique_pubkeys UNIQUE (pubkey, address)"
-	pendingDepositStoreSchema = "pubkey VARBINARY(66), expectedConfirmHeight INT(32) UNSIGNED, depositHeight INT(32) UNSIGNED, amount BIGINT(64), txid TEXT"
+	pendingDepositStoreSchema = "pubkey VARBINARY(66), expectedConfirmHeight INT(32) UNSIGNED, depositHeight INT(32) UNSIGNED, amount BIGINT(64), txid TEXT, blockHash VARCHAR(64), status VARCHAR(16) NOT NULL DEFAULT 'pending'"
+	depositDebtStoreSchema    = "pubkey VARCHAR(66) NOT NULL, debt BIGINT UNSIGNED, PRIMARY KEY (pubkey)"
 )
 
 func CreateDepositStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (ds *SQLDepositStore, err error) {
@@ -88,6 +89,17 @@ func CreateDepositStore(coin *coinparam.Params) (store cxdb.DepositStore, err er
 	conf := new(dbsqlConfig)
 	*conf = *defaultConf
 
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGDepositStoreStructWithConf(coin, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres deposit store struct for CreateDepositStore: %s", err)
+			return
+		}
+		return
+	}
+
 	if store, err = CreateDepositStoreStructWithConf(coin, conf); err != nil {
 		err = fmt.Errorf("Error creating deposit store struct for CreateDepositStore: %s", err)
 		return
@@ -165,6 +177,12 @@ func (ds *SQLDepositStore) setupDepositTables() (err error) {
 		err = fmt.Errorf("Error creating deposit addr table: %s", err)
 		return
 	}
+
+	createTableQuery = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", depositDebtTable(ds.coin), depositDebtStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating deposit debt table: %s", err)
+		return
+	}
 	return
 }
 
@@ -215,58 +233,290 @@ func (ds *SQLDepositStore) UpdateDeposits(deposits []match.Deposit, blockheight
 		return
 	}
 
+	depositExecs, err = updatePendingDeposits(tx, ds.coin.Name, depositAsset, deposits, blockheight)
+	return
+}
+
+// DisconnectBlocks rolls deposits back after a reorg, when every block above blockheight has been
+// disconnected, and returns execs taking back whatever had already been credited.
+func (ds *SQLDepositStore) DisconnectBlocks(blockheight uint64) (rollbackExecs []*match.SettlementExecution, err error) {
+
+	// first get the asset we're taking back
+	var depositAsset match.Asset
+	if depositAsset, err = match.AssetFromCoinParam(ds.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for DisconnectBlocks: %s", err)
+		return
+	}
+
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for DisconnectBlocks: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for DisconnectBlocks: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + ds.pendingDepositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for DisconnectBlocks: %s", err)
+		return
+	}
+
+	rollbackExecs, err = disconnectPendingDeposits(tx, ds.coin.Name, depositAsset, blockheight)
+	return
+}
+
+// GetDeposits gets every deposit for a pubkey, oldest first, including orphaned ones
+func (ds *SQLDepositStore) GetDeposits(pubkey *koblitz.PublicKey) (deposits []*match.DepositStatus, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetDeposits: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for GetDeposits: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + ds.pendingDepositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for GetDeposits: %s", err)
+		return
+	}
+
+	deposits, err = queryDeposits(tx, ds.coin.Name, pubkey)
+	return
+}
+
+// AddDepositDebt adds to what a pubkey owes for deposits that were reorged out after they were spent
+func (ds *SQLDepositStore) AddDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for AddDepositDebt: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for AddDepositDebt: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + ds.pendingDepositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for AddDepositDebt: %s", err)
+		return
+	}
+
+	err = addDepositDebt(tx, depositDebtTable(ds.coin), pubkey, amount)
+	return
+}
+
+// PayDepositDebt takes amount off what a pubkey owes for reorged deposits
+func (ds *SQLDepositStore) PayDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PayDepositDebt: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PayDepositDebt: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + ds.pendingDepositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for PayDepositDebt: %s", err)
+		return
+	}
+
+	err = payDepositDebt(tx, depositDebtTable(ds.coin), pubkey, amount)
+	return
+}
+
+// GetDepositDebt gets what a pubkey owes for reorged deposits
+func (ds *SQLDepositStore) GetDepositDebt(pubkey *koblitz.PublicKey) (debt uint64, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetDepositDebt: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for GetDepositDebt: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + ds.pendingDepositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for GetDepositDebt: %s", err)
+		return
+	}
+
+	debt, err = queryDepositDebt(tx, depositDebtTable(ds.coin), pubkey)
+	return
+}
+
+// updatePendingDeposits inserts deposits into a pending deposit table, then marks the pending ones
+// with enough confirmations as credited and returns debits for them. The tx should already be using
+// the pending deposit schema. This is the same for mysql and postgres.
+func updatePendingDeposits(tx *sql.Tx, table string, asset match.Asset, deposits []match.Deposit, blockheight uint64) (depositExecs []*match.SettlementExecution, err error) {
+
 	// First we insert these deposits
 	for _, deposit := range deposits {
-		txid := []byte(deposit.Txid)
 		expectedConfirm := deposit.BlockHeightReceived + deposit.Confirmations
-		insertDepQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', %d, %d, %d, '%x');", ds.coin.Name, deposit.Pubkey.SerializeCompressed(), expectedConfirm, deposit.BlockHeightReceived, deposit.Amount, txid)
+		insertDepQuery := fmt.Sprintf("INSERT INTO %s (pubkey, expectedConfirmHeight, depositHeight, amount, txid, blockHash, status) VALUES ('%x', %d, %d, %d, '%x', '%s', '%s');", table, deposit.Pubkey.SerializeCompressed(), expectedConfirm, deposit.BlockHeightReceived, deposit.Amount, []byte(deposit.Txid), deposit.BlockHash, match.DepositPending)
 		if _, err = tx.Exec(insertDepQuery); err != nil {
 			err = fmt.Errorf("Error inserting deposit for UpdateDeposits: %s", err)
 			return
 		}
 	}
 
-	// There is an issue with the way we do this, if a reorg were to happen, users would be credited
-	// twice because there would be two blocks worth of deposits for some expected confirm heights.
-	// This is why we use non custodial / lightning anyways, if my channel fund was confirmed 15 days ago
-	// I really don't think it will be reorged. So for all intents and purposes this method of taking
-	// money from people should be considered legacy.
+	// Credited deposits stay in the table until a reorg takes them back, so they're only credited
+	// once even if we see the confirm height twice. Anything at or below the height that hasn't
+	// been credited is confirmed, not just what's exactly at it, so a deposit that went back to
+	// pending in a reorg gets credited when the new chain catches up.
+	confirmedCond := fmt.Sprintf("expectedConfirmHeight<=%d AND status='%s'", blockheight, match.DepositPending)
+	if depositExecs, err = selectDepositExecs(tx, table, confirmedCond, asset, match.Debit); err != nil {
+		err = fmt.Errorf("Error selecting confirmed deposits for UpdateDeposits: %s", err)
+		return
+	}
+
+	creditQuery := fmt.Sprintf("UPDATE %s SET status='%s' WHERE %s;", table, match.DepositCredited, confirmedCond)
+	if _, err = tx.Exec(creditQuery); err != nil {
+		err = fmt.Errorf("Error marking deposits credited for UpdateDeposits: %s", err)
+		return
+	}
+	return
+}
+
+// disconnectPendingDeposits takes back credited deposits that didn't have enough confirmations at
+// blockheight, orphans deposits from blocks above blockheight, and makes the rest pending again.
+// The tx should already be using the pending deposit schema. This is the same for mysql and
+// postgres.
+func disconnectPendingDeposits(tx *sql.Tx, table string, asset match.Asset, blockheight uint64) (rollbackExecs []*match.SettlementExecution, err error) {
+
+	// A deposit is always confirmed after the block it was in, so this covers credited deposits
+	// from disconnected blocks too. Credit is the one that takes away from a balance.
+	rolledBackCond := fmt.Sprintf("expectedConfirmHeight>%d AND status='%s'", blockheight, match.DepositCredited)
+	if rollbackExecs, err = selectDepositExecs(tx, table, rolledBackCond, asset, match.Credit); err != nil {
+		err = fmt.Errorf("Error selecting credited deposits for DisconnectBlocks: %s", err)
+		return
+	}
+
+	// Orphaned deposits are kept so users can see what happened to them. If the transaction makes
+	// it into the new chain it's a new deposit, with the new block's hash.
+	orphanQuery := fmt.Sprintf("UPDATE %s SET status='%s' WHERE depositHeight>%d;", table, match.DepositOrphaned, blockheight)
+	if _, err = tx.Exec(orphanQuery); err != nil {
+		err = fmt.Errorf("Error orphaning disconnected deposits for DisconnectBlocks: %s", err)
+		return
+	}
+
+	uncreditQuery := fmt.Sprintf("UPDATE %s SET status='%s' WHERE %s;", table, match.DepositPending, rolledBackCond)
+	if _, err = tx.Exec(uncreditQuery); err != nil {
+		err = fmt.Errorf("Error marking deposits pending for DisconnectBlocks: %s", err)
+		return
+	}
+	return
+}
 
-	// Now we select the ones where expectedConfirm EQUALS the current height.
+// queryDeposits gets every deposit in the table for a pubkey, oldest first. The tx should already
+// be using the pending deposit schema. This is the same for mysql and postgres.
+func queryDeposits(tx *sql.Tx, table string, pubkey *koblitz.PublicKey) (deposits []*match.DepositStatus, err error) {
 	var rows *sql.Rows
-	selectConfirmedQuery := fmt.Sprintf("SELECT pubkey, amount FROM %s WHERE expectedConfirmHeight=%d;", ds.coin.Name, blockheight)
-	if rows, err = tx.Query(selectConfirmedQuery); err != nil {
-		err = fmt.Errorf("Error running select confirmed query for UpdateDeposits: %s", err)
+	selectQuery := fmt.Sprintf("SELECT txid, amount, blockHash, depositHeight, expectedConfirmHeight, status FROM %s WHERE pubkey='%x' ORDER BY depositHeight;", table, pubkey.SerializeCompressed())
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error running select deposits query: %s", err)
+		return
+	}
+
+	var txidBytes []byte
+	var expectedConfirm uint64
+	for rows.Next() {
+		currDeposit := new(match.DepositStatus)
+		if err = rows.Scan(&txidBytes, &currDeposit.Amount, &currDeposit.BlockHash, &currDeposit.BlockHeight, &expectedConfirm, &currDeposit.Status); err != nil {
+			rows.Close()
+			err = fmt.Errorf("Error scanning for deposit: %s", err)
+			return
+		}
+
+		// the txid is stored as hex, like the pubkey
+		if txidBytes, err = hex.DecodeString(string(txidBytes)); err != nil {
+			rows.Close()
+			err = fmt.Errorf("Error decoding txid string: %s", err)
+			return
+		}
+		currDeposit.Txid = string(txidBytes)
+		currDeposit.ConfirmationsRequired = expectedConfirm - currDeposit.BlockHeight
+		deposits = append(deposits, currDeposit)
+	}
+	if err = rows.Close(); err != nil {
+		err = fmt.Errorf("Error closing deposit rows: %s", err)
+		return
+	}
+	return
+}
+
+// selectDepositExecs creates a settlement exec of execType for every deposit in the table that
+// matches the condition
+func selectDepositExecs(tx *sql.Tx, table string, condition string, asset match.Asset, execType match.SettleType) (execs []*match.SettlementExecution, err error) {
+	var rows *sql.Rows
+	selectQuery := fmt.Sprintf("SELECT pubkey, amount FROM %s WHERE %s;", table, condition)
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error running select deposits query: %s", err)
 		return
 	}
 
 	var currSettlement *match.SettlementExecution
 	var pubkeyBytes []byte
 	for rows.Next() {
-		// A confirmed deposit is a debit for the deposit store's asset
 		currSettlement = &match.SettlementExecution{
-			Asset: depositAsset,
-			Type:  match.Debit,
+			Asset: asset,
+			Type:  execType,
 		}
 		if err = rows.Scan(&pubkeyBytes, &currSettlement.Amount); err != nil {
-			err = fmt.Errorf("Error scanning for confirmed deposit: %s", err)
+			rows.Close()
+			err = fmt.Errorf("Error scanning for deposit: %s", err)
 			return
 		}
 
 		// because we really only know that sql will give us a hex string, not actual bytes
 		if pubkeyBytes, err = hex.DecodeString(string(pubkeyBytes)); err != nil {
-			err = fmt.Errorf("Error decoding pubkey bytes string for UpdateDeposits: %s", err)
+			rows.Close()
+			err = fmt.Errorf("Error decoding pubkey bytes string: %s", err)
 			return
 		}
 		copy(currSettlement.Pubkey[:], pubkeyBytes)
-		// Now that the settlement is filled in, let's add it
-		depositExecs = append(depositExecs, currSettlement)
+		execs = append(execs, currSettlement)
 	}
 	if err = rows.Close(); err != nil {
-		err = fmt.Errorf("Error closing rows for UpdateDeposits: %s", err)
+		err = fmt.Errorf("Error closing deposit rows: %s", err)
 		return
 	}
-
 	return
 }
 
@@ -414,3 +664,73 @@ func CreateDepositStoreMap(coinList []*coinparam.Params) (depositMap map[*coinpa
 
 	return
 }
+
+// depositDebtTable is the name of the table in the pending deposit schema that keeps what users owe
+// for reorged deposits they already spent
+func depositDebtTable(coin *coinparam.Params) string {
+	return coin.Name + "_debts"
+}
+
+// queryDepositDebt gets what a pubkey owes from a debt table, 0 if there's no row for it. This is
+// the same for mysql and postgres.
+func queryDepositDebt(tx *sql.Tx, table string, pubkey *koblitz.PublicKey) (debt uint64, err error) {
+	selectQuery := fmt.Sprintf("SELECT debt FROM %s WHERE pubkey='%x';", table, pubkey.SerializeCompressed())
+	if err = tx.QueryRow(selectQuery).Scan(&debt); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Error querying debt: %s", err)
+		return
+	}
+	return
+}
+
+// addDepositDebt adds to what a pubkey owes in a debt table
+func addDepositDebt(tx *sql.Tx, table string, pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	var debt uint64
+	if debt, err = queryDepositDebt(tx, table, pubkey); err != nil {
+		return
+	}
+	if debt+amount < debt {
+		err = fmt.Errorf("Debt of %d would overflow adding %d", debt, amount)
+		return
+	}
+	err = setDepositDebt(tx, table, pubkey, debt, debt+amount)
+	return
+}
+
+// payDepositDebt takes amount off what a pubkey owes in a debt table
+func payDepositDebt(tx *sql.Tx, table string, pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	var debt uint64
+	if debt, err = queryDepositDebt(tx, table, pubkey); err != nil {
+		return
+	}
+	if amount > debt {
+		err = fmt.Errorf("Can't pay %d, only %d is owed", amount, debt)
+		return
+	}
+	err = setDepositDebt(tx, table, pubkey, debt, debt-amount)
+	return
+}
+
+// setDepositDebt changes a pubkey's row in a debt table from what it owed to what it owes now,
+// adding the row if it didn't owe anything and removing it once it doesn't
+func setDepositDebt(tx *sql.Tx, table string, pubkey *koblitz.PublicKey, oldDebt uint64, newDebt uint64) (err error) {
+	var query string
+	switch {
+	case oldDebt == 0 && newDebt == 0:
+		return
+	case oldDebt == 0:
+		query = fmt.Sprintf("INSERT INTO %s VALUES ('%x', %d);", table, pubkey.SerializeCompressed(), newDebt)
+	case newDebt == 0:
+		query = fmt.Sprintf("DELETE FROM %s WHERE pubkey='%x';", table, pubkey.SerializeCompressed())
+	default:
+		query = fmt.Sprintf("UPDATE %s SET debt=%d WHERE pubkey='%x';", table, newDebt, pubkey.SerializeCompressed())
+	}
+	if _, err = tx.Exec(query); err != nil {
+		err = fmt.Errorf("Error setting debt to %d: %s", newDebt, err)
+		return
+	}
+	return
+}
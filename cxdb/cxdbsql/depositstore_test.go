@@ -2,6 +2,10 @@ package cxdbsql
 
 import (
 	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
 )
 
 func TestCreateDepositStoreAllParams(t *testing.T) {
@@ -31,3 +35,120 @@ func TestCreateDepositStoreAllParams(t *testing.T) {
 	}
 
 }
+
+// TestDepositStoreReorg mines a deposit to confirmation, and another one that's still pending,
+// then reorgs both of those blocks out like a regtest invalidateblock would
+func TestDepositStoreReorg(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	coin := &coinparam.RegressionNetParams
+	var ds *SQLDepositStore
+	if ds, err = CreateDepositStoreStructWithConf(coin, testConfig()); err != nil {
+		t.Errorf("Error creating deposit store for coin: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = ds.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for deposit store: %s", err)
+		}
+	}()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating new private key: %s", err)
+		return
+	}
+
+	confirmed := match.Deposit{
+		Pubkey:              priv.PubKey(),
+		Address:             "bcrt1qtestaddress",
+		Amount:              100,
+		Txid:                "confirmedtx",
+		CoinType:            coin,
+		BlockHeightReceived: 100,
+		BlockHash:           "block100",
+		Confirmations:       6,
+	}
+	pending := confirmed
+	pending.Amount = 50
+	pending.Txid = "pendingtx"
+	pending.BlockHeightReceived = 104
+	pending.BlockHash = "block104"
+
+	// mine updates the store with a block at every height from start to end, with deposits only
+	// in the first one
+	mine := func(deposits []match.Deposit, start uint64, end uint64) (execs []*match.SettlementExecution) {
+		for height := start; height <= end; height++ {
+			var blockDeposits []match.Deposit
+			if height == start {
+				blockDeposits = deposits
+			}
+			var blockExecs []*match.SettlementExecution
+			if blockExecs, err = ds.UpdateDeposits(blockDeposits, height); err != nil {
+				t.Fatalf("Error updating deposits at %d: %s", height, err)
+			}
+			execs = append(execs, blockExecs...)
+		}
+		return
+	}
+
+	execs := mine([]match.Deposit{confirmed}, 100, 103)
+	execs = append(execs, mine([]match.Deposit{pending}, 104, 106)...)
+	if len(execs) != 1 || execs[0].Amount != confirmed.Amount || execs[0].Type != match.Debit {
+		t.Errorf("Expected a single debit for the confirmed deposit, got %v", execs)
+		return
+	}
+
+	// blocks 104 and up are reorged out, so the confirmed deposit only has 4 confirmations
+	var rollbacks []*match.SettlementExecution
+	if rollbacks, err = ds.DisconnectBlocks(103); err != nil {
+		t.Errorf("Error disconnecting blocks: %s", err)
+		return
+	}
+	if len(rollbacks) != 1 || rollbacks[0].Amount != confirmed.Amount || rollbacks[0].Type != match.Credit || rollbacks[0].Pubkey != execs[0].Pubkey {
+		t.Errorf("Expected a single credit taking back the confirmed deposit, got %v", rollbacks)
+		return
+	}
+
+	// the new chain doesn't have the pending deposit, so only the first deposit confirms again
+	if execs = mine(nil, 104, 112); len(execs) != 1 || execs[0].Amount != confirmed.Amount {
+		t.Errorf("Expected the first deposit to be credited once on the new chain, got %v", execs)
+		return
+	}
+
+	// a reorg below the confirmation shouldn't take back anything that was credited deeper
+	if rollbacks, err = ds.DisconnectBlocks(110); err != nil || len(rollbacks) != 0 {
+		t.Errorf("Shallow reorg should not roll anything back, got %v, err %v", rollbacks, err)
+		return
+	}
+
+	var deposits []*match.DepositStatus
+	if deposits, err = ds.GetDeposits(priv.PubKey()); err != nil {
+		t.Errorf("Error getting deposits: %s", err)
+		return
+	}
+	if len(deposits) != 2 {
+		t.Errorf("Expected both deposits, got %d", len(deposits))
+		return
+	}
+	if deposits[0].Txid != confirmed.Txid || deposits[0].Status != match.DepositCredited || deposits[0].ConfirmationsRequired != 6 {
+		t.Errorf("First deposit should be credited and need 6 confirmations, got %s %s %d", deposits[0].Txid, deposits[0].Status, deposits[0].ConfirmationsRequired)
+	}
+	if deposits[1].Txid != pending.Txid || deposits[1].Status != match.DepositOrphaned || deposits[1].BlockHash != pending.BlockHash {
+		t.Errorf("Second deposit should be orphaned in its old block, got %s %s %s", deposits[1].Txid, deposits[1].Status, deposits[1].BlockHash)
+	}
+}
@@ -0,0 +1,518 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLHistoryStore keeps the history of every limit order and every fill in SQL. Unlike the
+// orderbook, rows are never deleted, orders just get a final status.
+type SQLHistoryStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// history schema name
+	historySchema string
+}
+
+// The history tables are shared between every pair. Times are unix nanoseconds so they sort and
+// compare the same way in mysql and postgres. seq is what cursors point to.
+const (
+	orderHistoryTable  = "orderhistory"
+	fillHistoryTable   = "fillhistory"
+	orderHistorySchema = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, orderID VARCHAR(64) NOT NULL, pubkey VARCHAR(66) NOT NULL, assetWant TINYINT UNSIGNED, assetHave TINYINT UNSIGNED, buy BOOLEAN, amountHave BIGINT UNSIGNED, amountWant BIGINT UNSIGNED, amountHaveFilled BIGINT UNSIGNED, amountWantFilled BIGINT UNSIGNED, status VARCHAR(32), placed BIGINT, updated BIGINT, PRIMARY KEY (seq), UNIQUE KEY (orderID), KEY (pubkey, seq)"
+	fillHistorySchema  = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, orderID VARCHAR(64) NOT NULL, pubkey VARCHAR(66) NOT NULL, assetWant TINYINT UNSIGNED, assetHave TINYINT UNSIGNED, buy BOOLEAN, amountHave BIGINT UNSIGNED, amountWant BIGINT UNSIGNED, time BIGINT, PRIMARY KEY (seq), KEY (pubkey, seq)"
+
+	// the columns we select for order and fill history, in the order the scan functions expect
+	orderHistoryColumns = "seq, orderID, pubkey, assetWant, assetHave, buy, amountHave, amountWant, amountHaveFilled, amountWantFilled, status, placed, updated"
+	fillHistoryColumns  = "seq, orderID, pubkey, assetWant, assetHave, buy, amountHave, amountWant, time"
+)
+
+// CreateHistoryStore creates a history store for every pair
+func CreateHistoryStore() (store cxdb.HistoryStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGHistoryStoreStructWithConf(conf); err != nil {
+			err = fmt.Errorf("Error creating postgres history store for CreateHistoryStore: %s", err)
+			return
+		}
+		return
+	}
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateHistoryStore: %s", err)
+		return
+	}
+
+	hs := &SQLHistoryStore{
+		dbUsername:    conf.DBUsername,
+		dbPassword:    conf.DBPassword,
+		historySchema: conf.HistorySchemaName,
+		dbAddr:        addr,
+	}
+
+	if err = hs.setupHistoryTables(); err != nil {
+		err = fmt.Errorf("Error setting up history tables while creating store: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", hs.dbUsername, hs.dbPassword, hs.dbAddr.Network(), hs.dbAddr.String())
+	if hs.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateHistoryStore: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = hs.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	store = hs
+	return
+}
+
+// setupHistoryTables sets up the tables needed for the history store.
+// This assumes the schema name is set
+func (hs *SQLHistoryStore) setupHistoryTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", hs.dbUsername, hs.dbPassword, hs.dbAddr.Network(), hs.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup history tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup history tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating history tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + hs.historySchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup history tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + hs.historySchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", hs.historySchema, err)
+		return
+	}
+
+	createOrdersQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", orderHistoryTable, orderHistorySchema)
+	if _, err = tx.Exec(createOrdersQuery); err != nil {
+		err = fmt.Errorf("Error creating order history table: %s", err)
+		return
+	}
+
+	createFillsQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", fillHistoryTable, fillHistorySchema)
+	if _, err = tx.Exec(createFillsQuery); err != nil {
+		err = fmt.Errorf("Error creating fill history table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (hs *SQLHistoryStore) DestroyHandler() (err error) {
+	if hs.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new history store")
+		return
+	}
+	if err = hs.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing history store handler for DestroyHandler: %s", err)
+		return
+	}
+	hs.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the history schema. If the returned error is nil, the
+// caller has to call finishHistoryTx with its own error, which commits or rolls back.
+func (hs *SQLHistoryStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = hs.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + hs.historySchema + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using history schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// finishHistoryTx commits the transaction if there was no error and rolls it back if there was
+func finishHistoryTx(tx *sql.Tx, funcName string, err error) error {
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error with %s: \n%s", funcName, err)
+	}
+	return tx.Commit()
+}
+
+// RecordPlace adds a newly placed order to the history
+func (hs *SQLHistoryStore) RecordPlace(limitIDPair *match.LimitOrderIDPair) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordPlace"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordPlace", err)
+	}()
+
+	err = recordHistoryPlace(tx, limitIDPair)
+	return
+}
+
+// RecordExec updates an order with an execution and adds the fill to the history
+func (hs *SQLHistoryStore) RecordExec(orderExec *match.OrderExecution, execTime time.Time) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordExec"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordExec", err)
+	}()
+
+	err = recordHistoryExec(tx, orderExec, execTime)
+	return
+}
+
+// RecordCancel marks an order as cancelled
+func (hs *SQLHistoryStore) RecordCancel(cancel *match.CancelledOrder, cancelTime time.Time) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordCancel"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordCancel", err)
+	}()
+
+	err = recordHistoryFinal(tx, cancel.OrderID, cancelTime, false)
+	return
+}
+
+// RecordExpire marks an order as expired
+func (hs *SQLHistoryStore) RecordExpire(orderID *match.OrderID, expireTime time.Time) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordExpire"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordExpire", err)
+	}()
+
+	err = recordHistoryFinal(tx, orderID, expireTime, true)
+	return
+}
+
+// GetOrderHistory gets orders for a pubkey, newest first, along with the cursor for the next page.
+func (hs *SQLHistoryStore) GetOrderHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("GetOrderHistory"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "GetOrderHistory", err)
+	}()
+
+	orders, nextCursor, err = queryOrderHistory(tx, pubkey, query)
+	return
+}
+
+// GetFillHistory gets fills for a pubkey, newest first, along with the cursor for the next page.
+func (hs *SQLHistoryStore) GetFillHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("GetFillHistory"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "GetFillHistory", err)
+	}()
+
+	fills, nextCursor, err = queryFillHistory(tx, pubkey, query)
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres history stores, the queries are the
+// same once the transaction is using the history schema.
+
+// recordHistoryPlace inserts a new order into the order history table
+func recordHistoryPlace(tx *sql.Tx, limitIDPair *match.LimitOrderIDPair) (err error) {
+	entry := match.NewOrderHistoryEntry(limitIDPair)
+	insertQuery := fmt.Sprintf("INSERT INTO %s (orderID, pubkey, assetWant, assetHave, buy, amountHave, amountWant, amountHaveFilled, amountWantFilled, status, placed, updated) VALUES ('%x', '%x', %d, %d, %t, %d, %d, 0, 0, '%s', %d, %[10]d);",
+		orderHistoryTable, entry.OrderID[:], entry.Order.Pubkey[:], entry.Order.TradingPair.AssetWant, entry.Order.TradingPair.AssetHave, entry.Order.Side == match.Buy, entry.Order.AmountHave, entry.Order.AmountWant, entry.Status, entry.Placed.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting order into history: %s", err)
+		return
+	}
+	return
+}
+
+// getHistoryOrderForUpdate gets an order from the order history table and locks the row
+func getHistoryOrderForUpdate(tx *sql.Tx, orderID *match.OrderID) (entry *match.OrderHistoryEntry, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE orderID='%x' FOR UPDATE;", orderHistoryColumns, orderHistoryTable, orderID[:])
+	if entry, err = scanHistoryOrder(tx.QueryRow(selectQuery)); err != nil {
+		err = fmt.Errorf("Error getting order %x from history: %s", orderID[:], err)
+		return
+	}
+	return
+}
+
+// updateHistoryOrder writes the filled amounts and status of an order history entry
+func updateHistoryOrder(tx *sql.Tx, entry *match.OrderHistoryEntry) (err error) {
+	updateQuery := fmt.Sprintf("UPDATE %s SET amountHaveFilled=%d, amountWantFilled=%d, status='%s', updated=%d WHERE orderID='%x';",
+		orderHistoryTable, entry.AmountHaveFilled, entry.AmountWantFilled, entry.Status, entry.Updated.UnixNano(), entry.OrderID[:])
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating order %x in history: %s", entry.OrderID[:], err)
+		return
+	}
+	return
+}
+
+// recordHistoryExec applies an execution to an order in the history and inserts the fill
+func recordHistoryExec(tx *sql.Tx, orderExec *match.OrderExecution, execTime time.Time) (err error) {
+	var entry *match.OrderHistoryEntry
+	if entry, err = getHistoryOrderForUpdate(tx, &orderExec.OrderID); err != nil {
+		return
+	}
+
+	fill := entry.ApplyExec(orderExec, execTime)
+	if err = updateHistoryOrder(tx, entry); err != nil {
+		return
+	}
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s (orderID, pubkey, assetWant, assetHave, buy, amountHave, amountWant, time) VALUES ('%x', '%x', %d, %d, %t, %d, %d, %d);",
+		fillHistoryTable, fill.OrderID[:], fill.Pubkey[:], fill.TradingPair.AssetWant, fill.TradingPair.AssetHave, fill.Side == match.Buy, fill.AmountHave, fill.AmountWant, fill.Time.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting fill into history: %s", err)
+		return
+	}
+	return
+}
+
+// recordHistoryFinal marks an order in the history as cancelled, or expired if expired is true
+func recordHistoryFinal(tx *sql.Tx, orderID *match.OrderID, finalTime time.Time, expired bool) (err error) {
+	var entry *match.OrderHistoryEntry
+	if entry, err = getHistoryOrderForUpdate(tx, orderID); err != nil {
+		return
+	}
+
+	if expired {
+		entry.Status = match.OrderExpired
+		entry.Updated = finalTime
+	} else {
+		entry.ApplyCancel(finalTime)
+	}
+	err = updateHistoryOrder(tx, entry)
+	return
+}
+
+// historyWhereClause creates the WHERE clause for a history query. timeColumn is the column the
+// time range applies to, and statuses are only filtered on if withStatus is true.
+func historyWhereClause(pubkey *koblitz.PublicKey, query *match.HistoryQuery, timeColumn string, withStatus bool) (where string, err error) {
+	conditions := []string{fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed())}
+	if query.Cursor != 0 {
+		conditions = append(conditions, fmt.Sprintf("seq<%d", query.Cursor))
+	}
+	if query.Pair != nil {
+		conditions = append(conditions, fmt.Sprintf("assetWant=%d AND assetHave=%d", query.Pair.AssetWant, query.Pair.AssetHave))
+	}
+	if !query.Start.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s>=%d", timeColumn, query.Start.UnixNano()))
+	}
+	if !query.End.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s<=%d", timeColumn, query.End.UnixNano()))
+	}
+	if withStatus && len(query.Statuses) != 0 {
+		// statuses come from users, so make sure they're real statuses before they go in the query
+		var statusStrings []string
+		for _, status := range query.Statuses {
+			if _, err = match.OrderStatusFromString(string(status)); err != nil {
+				err = fmt.Errorf("Error with status in history query: %s", err)
+				return
+			}
+			statusStrings = append(statusStrings, fmt.Sprintf("'%s'", status))
+		}
+		conditions = append(conditions, fmt.Sprintf("status IN (%s)", strings.Join(statusStrings, ", ")))
+	}
+	where = strings.Join(conditions, " AND ")
+	return
+}
+
+// queryOrderHistory gets a page of order history. It asks for one more order than the page
+// limit, so we know if there's another page.
+func queryOrderHistory(tx *sql.Tx, pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error) {
+	var where string
+	if where, err = historyWhereClause(pubkey, query, "placed", true); err != nil {
+		return
+	}
+
+	limit := query.PageLimit()
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq DESC LIMIT %d;", orderHistoryColumns, orderHistoryTable, where, limit+1)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying order history: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry *match.OrderHistoryEntry
+		if entry, err = scanHistoryOrder(rows); err != nil {
+			err = fmt.Errorf("Error scanning order history: %s", err)
+			return
+		}
+		if uint64(len(orders)) == limit {
+			nextCursor = orders[len(orders)-1].Seq
+			break
+		}
+		orders = append(orders, entry)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading order history rows: %s", err)
+		return
+	}
+	return
+}
+
+// queryFillHistory gets a page of fill history. It asks for one more fill than the page limit,
+// so we know if there's another page.
+func queryFillHistory(tx *sql.Tx, pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error) {
+	var where string
+	if where, err = historyWhereClause(pubkey, query, "time", false); err != nil {
+		return
+	}
+
+	limit := query.PageLimit()
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq DESC LIMIT %d;", fillHistoryColumns, fillHistoryTable, where, limit+1)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying fill history: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fill *match.FillEntry
+		if fill, err = scanHistoryFill(rows); err != nil {
+			err = fmt.Errorf("Error scanning fill history: %s", err)
+			return
+		}
+		if uint64(len(fills)) == limit {
+			nextCursor = fills[len(fills)-1].Seq
+			break
+		}
+		fills = append(fills, fill)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading fill history rows: %s", err)
+		return
+	}
+	return
+}
+
+// historyScanner is a *sql.Row or *sql.Rows
+type historyScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanHistoryOrder scans the orderHistoryColumns into an order history entry
+func scanHistoryOrder(row historyScanner) (entry *match.OrderHistoryEntry, err error) {
+	entry = &match.OrderHistoryEntry{Order: new(match.LimitOrder)}
+	var orderIDBytes []byte
+	var pkBytes []byte
+	var buy bool
+	var statusString string
+	var placed, updated int64
+	if err = row.Scan(&entry.Seq, &orderIDBytes, &pkBytes, &entry.Order.TradingPair.AssetWant, &entry.Order.TradingPair.AssetHave, &buy, &entry.Order.AmountHave, &entry.Order.AmountWant, &entry.AmountHaveFilled, &entry.AmountWantFilled, &statusString, &placed, &updated); err != nil {
+		return
+	}
+
+	if err = entry.OrderID.UnmarshalText(orderIDBytes); err != nil {
+		return
+	}
+	if pkBytes, err = hex.DecodeString(string(pkBytes)); err != nil {
+		return
+	}
+	if entry.Status, err = match.OrderStatusFromString(statusString); err != nil {
+		return
+	}
+	copy(entry.Order.Pubkey[:], pkBytes)
+	entry.Order.Side = match.Side(buy)
+	entry.Placed = time.Unix(0, placed)
+	entry.Updated = time.Unix(0, updated)
+
+	// the price isn't stored, it comes from the amounts like it does for the orderbook
+	if entry.Price, err = entry.Order.Price(); err != nil {
+		return
+	}
+	return
+}
+
+// scanHistoryFill scans the fillHistoryColumns into a fill entry
+func scanHistoryFill(row historyScanner) (fill *match.FillEntry, err error) {
+	fill = new(match.FillEntry)
+	var orderIDBytes []byte
+	var pkBytes []byte
+	var buy bool
+	var fillTime int64
+	if err = row.Scan(&fill.Seq, &orderIDBytes, &pkBytes, &fill.TradingPair.AssetWant, &fill.TradingPair.AssetHave, &buy, &fill.AmountHave, &fill.AmountWant, &fillTime); err != nil {
+		return
+	}
+
+	if err = fill.OrderID.UnmarshalText(orderIDBytes); err != nil {
+		return
+	}
+	if pkBytes, err = hex.DecodeString(string(pkBytes)); err != nil {
+		return
+	}
+	copy(fill.Pubkey[:], pkBytes)
+	fill.Side = match.Side(buy)
+	fill.Time = time.Unix(0, fillTime)
+	return
+}
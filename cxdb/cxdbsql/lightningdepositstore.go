@@ -0,0 +1,516 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLLightningDepositStore keeps lightning deposits and invoices for every coin in SQL, in tables
+// of the deposit schema. Rows are never deleted.
+type SQLLightningDepositStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// deposit schema name
+	depositSchemaName string
+}
+
+// The lightning deposit table is shared between every coin, so it can't clash with the deposit
+// address tables, which are named after their coin. Times are unix nanoseconds.
+const (
+	lightningDepositsTable  = "lightningdeposits"
+	lightningDepositsSchema = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, outpoint VARCHAR(160) NOT NULL, stateIdx BIGINT UNSIGNED NOT NULL, pubkey VARCHAR(66) NOT NULL, asset TINYINT UNSIGNED, amount BIGINT UNSIGNED, chanIdx INT UNSIGNED, channelAmount BIGINT UNSIGNED, received BIGINT, PRIMARY KEY (seq), UNIQUE KEY (outpoint, stateIdx), KEY (pubkey)"
+
+	// the columns we select for lightning deposits, in the order queryLightningDeposits scans them
+	lightningDepositColumns = "outpoint, stateIdx, pubkey, asset, amount, chanIdx, channelAmount, received"
+
+	// invoices are kept with the lightning deposits, expiry is a unix time
+	invoicesTable  = "invoices"
+	invoicesSchema = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, rhash VARCHAR(64) NOT NULL, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, asset TINYINT UNSIGNED, amount BIGINT UNSIGNED, node VARCHAR(128) NOT NULL, expiry BIGINT, amountPaid BIGINT UNSIGNED, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT, PRIMARY KEY (seq), UNIQUE KEY (rhash), KEY (pubkey), KEY (state)"
+
+	// the columns we select for invoices, in the order queryInvoices scans them
+	invoiceColumns = "rhash, preimage, pubkey, asset, amount, node, expiry, amountPaid, state, reason, created, updated"
+)
+
+// CreateLightningDepositStoreStructWithConf creates a lightning deposit store, returning the
+// struct rather than the interface.
+func CreateLightningDepositStoreStructWithConf(conf *dbsqlConfig) (ls *SQLLightningDepositStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateLightningDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	ls = &SQLLightningDepositStore{
+		dbUsername:        conf.DBUsername,
+		dbPassword:        conf.DBPassword,
+		depositSchemaName: conf.DepositSchemaName,
+		dbAddr:            addr,
+	}
+
+	if err = ls.setupLightningDepositTables(); err != nil {
+		err = fmt.Errorf("Error setting up lightning deposit tables for CreateLightningDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ls.dbUsername, ls.dbPassword, ls.dbAddr.Network(), ls.dbAddr.String())
+	if ls.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateLightningDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ls.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// CreateLightningDepositStore creates a lightning deposit store for every coin
+func CreateLightningDepositStore() (store cxdb.LightningDepositStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGLightningDepositStoreStructWithConf(conf); err != nil {
+			err = fmt.Errorf("Error creating postgres lightning deposit store struct for CreateLightningDepositStore: %s", err)
+			return
+		}
+		return
+	}
+
+	if store, err = CreateLightningDepositStoreStructWithConf(conf); err != nil {
+		err = fmt.Errorf("Error creating lightning deposit store struct for CreateLightningDepositStore: %s", err)
+		return
+	}
+	return
+}
+
+// setupLightningDepositTables sets up the lightning deposit table.
+// This assumes everything else is set
+func (ls *SQLLightningDepositStore) setupLightningDepositTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ls.dbUsername, ls.dbPassword, ls.dbAddr.Network(), ls.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup lightning deposit tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup lightning deposit tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating lightning deposit tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ls.depositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup lightning deposit tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + ls.depositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ls.depositSchemaName, err)
+		return
+	}
+
+	createQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", lightningDepositsTable, lightningDepositsSchema)
+	if _, err = tx.Exec(createQuery); err != nil {
+		err = fmt.Errorf("Error creating lightning deposit table: %s", err)
+		return
+	}
+
+	createInvoicesQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", invoicesTable, invoicesSchema)
+	if _, err = tx.Exec(createInvoicesQuery); err != nil {
+		err = fmt.Errorf("Error creating invoice table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ls *SQLLightningDepositStore) DestroyHandler() (err error) {
+	if ls.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new lightning deposit store")
+		return
+	}
+	if err = ls.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing lightning deposit store handler for DestroyHandler: %s", err)
+		return
+	}
+	ls.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the deposit schema. If the returned error is nil, the
+// caller has to call finishLightningDepositTx with its own error, which commits or rolls back.
+func (ls *SQLLightningDepositStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ls.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + ls.depositSchemaName + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using deposit schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// finishLightningDepositTx commits the transaction if there was no error and rolls it back if
+// there was
+func finishLightningDepositTx(tx *sql.Tx, funcName string, err error) error {
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error with %s: \n%s", funcName, err)
+	}
+	return tx.Commit()
+}
+
+// AddLightningDeposit stores a deposit, added is false if there's already one for the channel and
+// state
+func (ls *SQLLightningDepositStore) AddLightningDeposit(deposit *match.LightningDeposit) (added bool, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("AddLightningDeposit"); err != nil {
+		return
+	}
+	defer func() {
+		if err = finishLightningDepositTx(tx, "AddLightningDeposit", err); err != nil {
+			added = false
+		}
+	}()
+
+	// the unique key on the channel and state makes this a no-op for a deposit we already have
+	added, err = insertLightningDeposit(tx, "INSERT IGNORE INTO %s (%s) VALUES (%s);", deposit)
+	return
+}
+
+// GetLightningDeposits gets every deposit for a pubkey, oldest first
+func (ls *SQLLightningDepositStore) GetLightningDeposits(pubkey *koblitz.PublicKey) (deposits []*match.LightningDeposit, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetLightningDeposits"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetLightningDeposits", err)
+	}()
+
+	deposits, err = queryLightningDeposits(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetChannelDeposits gets every deposit over a channel, oldest first
+func (ls *SQLLightningDepositStore) GetChannelDeposits(outpoint string) (deposits []*match.LightningDeposit, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetChannelDeposits"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetChannelDeposits", err)
+	}()
+
+	deposits, err = queryLightningDeposits(tx, fmt.Sprintf("outpoint='%x'", outpoint))
+	return
+}
+
+// AddInvoice stores a new invoice
+func (ls *SQLLightningDepositStore) AddInvoice(invoice *match.Invoice) (err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("AddInvoice"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "AddInvoice", err)
+	}()
+
+	err = insertInvoice(tx, invoice)
+	return
+}
+
+// UpdateInvoice saves the amount paid, state, reason and update time of a stored invoice
+func (ls *SQLLightningDepositStore) UpdateInvoice(invoice *match.Invoice) (err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("UpdateInvoice"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "UpdateInvoice", err)
+	}()
+
+	err = updateInvoice(tx, invoice)
+	return
+}
+
+// GetInvoice gets an invoice by its hash
+func (ls *SQLLightningDepositStore) GetInvoice(rhash [32]byte) (invoice *match.Invoice, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetInvoice"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetInvoice", err)
+	}()
+
+	invoice, err = getInvoice(tx, rhash)
+	return
+}
+
+// GetInvoices gets every invoice for a pubkey, oldest first
+func (ls *SQLLightningDepositStore) GetInvoices(pubkey *koblitz.PublicKey) (invoices []*match.Invoice, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetInvoices"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetInvoices", err)
+	}()
+
+	invoices, err = queryInvoices(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetInvoicesByState gets every invoice in a state, oldest first
+func (ls *SQLLightningDepositStore) GetInvoicesByState(state match.InvoiceState) (invoices []*match.Invoice, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetInvoicesByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetInvoicesByState", err)
+	}()
+
+	invoices, err = queryInvoicesByState(tx, state)
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres lightning deposit stores, the
+// queries are the same once the transaction is using the deposit schema. Outpoints are stored as
+// hex, like every other string that comes from outside the exchange.
+
+// insertLightningDeposit inserts a deposit using an insert query that doesn't fail if the channel
+// state is already there, and returns whether a row was added
+func insertLightningDeposit(tx *sql.Tx, insertFormat string, deposit *match.LightningDeposit) (added bool, err error) {
+	values := fmt.Sprintf("'%x', %d, '%x', %d, %d, %d, %d, %d",
+		deposit.Outpoint, deposit.StateIdx, deposit.Pubkey[:], deposit.Asset, deposit.Amount, deposit.ChanIdx, deposit.ChannelAmount, deposit.Received.UnixNano())
+
+	var res sql.Result
+	if res, err = tx.Exec(fmt.Sprintf(insertFormat, lightningDepositsTable, lightningDepositColumns, values)); err != nil {
+		err = fmt.Errorf("Error inserting lightning deposit for %s state %d: %s", deposit.Outpoint, deposit.StateIdx, err)
+		return
+	}
+
+	var rows int64
+	if rows, err = res.RowsAffected(); err != nil {
+		err = fmt.Errorf("Error getting rows inserted for lightning deposit: %s", err)
+		return
+	}
+	added = rows != 0
+	return
+}
+
+// queryLightningDeposits gets the deposits matching a condition, oldest first
+func queryLightningDeposits(tx *sql.Tx, condition string) (deposits []*match.LightningDeposit, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq;", lightningDepositColumns, lightningDepositsTable, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying lightning deposits: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		deposit := new(match.LightningDeposit)
+		var outpointString, pkString string
+		var received int64
+		if err = rows.Scan(&outpointString, &deposit.StateIdx, &pkString, &deposit.Asset, &deposit.Amount, &deposit.ChanIdx, &deposit.ChannelAmount, &received); err != nil {
+			err = fmt.Errorf("Error scanning lightning deposit: %s", err)
+			return
+		}
+
+		var outpointBytes, pkBytes []byte
+		if outpointBytes, err = hex.DecodeString(outpointString); err != nil {
+			err = fmt.Errorf("Error decoding lightning deposit outpoint: %s", err)
+			return
+		}
+		if pkBytes, err = hex.DecodeString(pkString); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for lightning deposit: %s", err)
+			return
+		}
+
+		deposit.Outpoint = string(outpointBytes)
+		copy(deposit.Pubkey[:], pkBytes)
+		deposit.Received = time.Unix(0, received)
+		deposits = append(deposits, deposit)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading lightning deposit rows: %s", err)
+		return
+	}
+	return
+}
+
+// insertInvoice inserts a new invoice into the invoice table
+func insertInvoice(tx *sql.Tx, invoice *match.Invoice) (err error) {
+	if _, err = match.InvoiceStateFromString(string(invoice.State)); err != nil {
+		err = fmt.Errorf("Error with invoice state: %s", err)
+		return
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES ('%x', '%x', '%x', %d, %d, '%x', %d, %d, '%s', '%x', %d, %d);",
+		invoicesTable, invoiceColumns, invoice.RHash[:], invoice.Preimage[:], invoice.Pubkey[:], invoice.Asset, invoice.Amount, invoice.Node, invoice.Expiry.Unix(),
+		invoice.AmountPaid, invoice.State, invoice.Reason, invoice.Created.UnixNano(), invoice.Updated.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting invoice %s: %s", invoice.ID(), err)
+		return
+	}
+	return
+}
+
+// updateInvoice writes the amount paid, state, reason and update time of an invoice
+func updateInvoice(tx *sql.Tx, invoice *match.Invoice) (err error) {
+	if _, err = match.InvoiceStateFromString(string(invoice.State)); err != nil {
+		err = fmt.Errorf("Error with invoice state: %s", err)
+		return
+	}
+
+	// check the row is there first, mysql only counts rows that changed
+	if _, err = getInvoice(tx, invoice.RHash); err != nil {
+		return
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET amountPaid=%d, state='%s', reason='%x', updated=%d WHERE rhash='%x';",
+		invoicesTable, invoice.AmountPaid, invoice.State, invoice.Reason, invoice.Updated.UnixNano(), invoice.RHash[:])
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating invoice %s: %s", invoice.ID(), err)
+		return
+	}
+	return
+}
+
+// getInvoice gets a single invoice by its hash
+func getInvoice(tx *sql.Tx, rhash [32]byte) (invoice *match.Invoice, err error) {
+	var invoices []*match.Invoice
+	if invoices, err = queryInvoices(tx, fmt.Sprintf("rhash='%x'", rhash)); err != nil {
+		return
+	}
+	if len(invoices) == 0 {
+		err = fmt.Errorf("No invoice %x", rhash)
+		return
+	}
+	invoice = invoices[0]
+	return
+}
+
+// queryInvoicesByState gets every invoice in a state, oldest first
+func queryInvoicesByState(tx *sql.Tx, state match.InvoiceState) (invoices []*match.Invoice, err error) {
+	if _, err = match.InvoiceStateFromString(string(state)); err != nil {
+		err = fmt.Errorf("Error with state for querying invoices: %s", err)
+		return
+	}
+	invoices, err = queryInvoices(tx, fmt.Sprintf("state='%s'", state))
+	return
+}
+
+// queryInvoices gets the invoices matching a condition, oldest first
+func queryInvoices(tx *sql.Tx, condition string) (invoices []*match.Invoice, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq;", invoiceColumns, invoicesTable, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying invoices: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		invoice := new(match.Invoice)
+		var rhashString, preimageString, pkString, nodeString, stateString, reasonString string
+		var expiry, created, updated int64
+		if err = rows.Scan(&rhashString, &preimageString, &pkString, &invoice.Asset, &invoice.Amount, &nodeString, &expiry,
+			&invoice.AmountPaid, &stateString, &reasonString, &created, &updated); err != nil {
+			err = fmt.Errorf("Error scanning invoice: %s", err)
+			return
+		}
+
+		var rhashBytes, preimageBytes, pkBytes, nodeBytes, reasonBytes []byte
+		if rhashBytes, err = hex.DecodeString(rhashString); err != nil {
+			err = fmt.Errorf("Error decoding invoice hash: %s", err)
+			return
+		}
+		if preimageBytes, err = hex.DecodeString(preimageString); err != nil {
+			err = fmt.Errorf("Error decoding preimage for invoice %s: %s", rhashString, err)
+			return
+		}
+		if pkBytes, err = hex.DecodeString(pkString); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for invoice %s: %s", rhashString, err)
+			return
+		}
+		if nodeBytes, err = hex.DecodeString(nodeString); err != nil {
+			err = fmt.Errorf("Error decoding node for invoice %s: %s", rhashString, err)
+			return
+		}
+		if reasonBytes, err = hex.DecodeString(reasonString); err != nil {
+			err = fmt.Errorf("Error decoding reason for invoice %s: %s", rhashString, err)
+			return
+		}
+		if invoice.State, err = match.InvoiceStateFromString(stateString); err != nil {
+			return
+		}
+
+		copy(invoice.RHash[:], rhashBytes)
+		copy(invoice.Preimage[:], preimageBytes)
+		copy(invoice.Pubkey[:], pkBytes)
+		invoice.Node = string(nodeBytes)
+		invoice.Expiry = time.Unix(expiry, 0)
+		invoice.Reason = string(reasonBytes)
+		invoice.Created = time.Unix(0, created)
+		invoice.Updated = time.Unix(0, updated)
+		invoices = append(invoices, invoice)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading invoice rows: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package cxdbsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestLightningDepositStoreIdempotent adds a deposit twice and checks it's only stored once
+func TestLightningDepositStoreIdempotent(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var ls *SQLLightningDepositStore
+	if ls, err = CreateLightningDepositStoreStructWithConf(testConfig()); err != nil {
+		t.Errorf("Error creating lightning deposit store: %s", err)
+		return
+	}
+	defer ls.DestroyHandler()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+
+	deposit := &match.LightningDeposit{Asset: match.BTCTest, Amount: 5000, Outpoint: "ab:0", StateIdx: 1, ChannelAmount: 5000, Received: time.Unix(1500000000, 0)}
+	copy(deposit.Pubkey[:], priv.PubKey().SerializeCompressed())
+	var added bool
+	if added, err = ls.AddLightningDeposit(deposit); err != nil || !added {
+		t.Errorf("First deposit should be added, got %t, %v", added, err)
+		return
+	}
+	if added, err = ls.AddLightningDeposit(deposit); err != nil || added {
+		t.Errorf("Replayed deposit shouldn't be added, got %t, %v", added, err)
+		return
+	}
+
+	var deposits []*match.LightningDeposit
+	if deposits, err = ls.GetChannelDeposits("ab:0"); err != nil {
+		t.Errorf("Error getting channel deposits: %s", err)
+		return
+	}
+	if len(deposits) != 1 || deposits[0].Amount != 5000 || deposits[0].Pubkey != deposit.Pubkey || !deposits[0].Received.Equal(deposit.Received) {
+		t.Errorf("Channel should have one deposit, got %d", len(deposits))
+	}
+	if deposits, err = ls.GetLightningDeposits(priv.PubKey()); err != nil || len(deposits) != 1 {
+		t.Errorf("Pubkey should have 1 deposit, got %d, %v", len(deposits), err)
+	}
+}
+
+// TestLightningDepositStoreInvoices adds an invoice, pays it, and checks it's read back paid
+func TestLightningDepositStoreInvoices(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var ls *SQLLightningDepositStore
+	if ls, err = CreateLightningDepositStoreStructWithConf(testConfig()); err != nil {
+		t.Errorf("Error creating lightning deposit store: %s", err)
+		return
+	}
+	defer ls.DestroyHandler()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+
+	var pubkey [33]byte
+	copy(pubkey[:], priv.PubKey().SerializeCompressed())
+	start := time.Unix(1500000000, 0)
+	request := &match.InvoiceRequest{Asset: match.BTCTest, Amount: 5000, Expiry: start.Add(time.Hour).Unix()}
+	var invoice *match.Invoice
+	if invoice, err = match.NewInvoice(request, pubkey, "ln1exchange", start); err != nil {
+		t.Errorf("Error creating invoice: %s", err)
+		return
+	}
+	if err = ls.AddInvoice(invoice); err != nil {
+		t.Errorf("Error adding invoice: %s", err)
+		return
+	}
+	if err = ls.AddInvoice(invoice); err == nil {
+		t.Errorf("Adding an invoice twice should fail")
+		return
+	}
+
+	invoice.AmountPaid = 6000
+	invoice.SetState(match.InvoicePaid, "", start.Add(time.Minute))
+	if err = ls.UpdateInvoice(invoice); err != nil {
+		t.Errorf("Error updating invoice: %s", err)
+		return
+	}
+
+	var got *match.Invoice
+	if got, err = ls.GetInvoice(invoice.RHash); err != nil {
+		t.Errorf("Error getting invoice: %s", err)
+		return
+	}
+	if got.State != match.InvoicePaid || got.AmountPaid != 6000 || got.Preimage != invoice.Preimage || got.Node != "ln1exchange" || !got.Expiry.Equal(invoice.Expiry) {
+		t.Errorf("Invoice should be paid 6000, got %s", got)
+	}
+	var invoices []*match.Invoice
+	if invoices, err = ls.GetInvoicesByState(match.InvoicePaid); err != nil || len(invoices) != 1 {
+		t.Errorf("There should be 1 paid invoice, got %d, %v", len(invoices), err)
+	}
+	if invoices, err = ls.GetInvoices(priv.PubKey()); err != nil || len(invoices) != 1 {
+		t.Errorf("Pubkey should have 1 invoice, got %d, %v", len(invoices), err)
+	}
+}
@@ -83,6 +83,19 @@ func CreateLimEngineStructWithConf(pair *match.Pair, conf *dbsqlConfig) (engine
 }
 
 func CreateLimitEngineWithConf(pair *match.Pair, conf *dbsqlConfig) (engine match.LimitEngine, err error) {
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		var pgle *PGLimitEngine
+		if pgle, err = CreatePGLimEngineStructWithConf(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres limit engine struct with conf for CreateLimitEngineWithConf: %s", err)
+			return
+		}
+		engine = pgle
+		return
+	}
+
 	var le *SQLLimitEngine
 	if le, err = CreateLimEngineStructWithConf(pair, conf); err != nil {
 		err = fmt.Errorf("Error creating limit engine struct with conf for CreateLimitEngineWithConf: %s", err)
@@ -177,6 +190,12 @@ func (le *SQLLimitEngine) DestroyHandler() (err error) {
 // PlaceLimitOrder places an order in the limit matching engine.
 // This assumes that the order is valid and is for the same pair as the matching engine
 func (le *SQLLimitEngine) PlaceLimitOrder(order *match.LimitOrder) (idRes *match.LimitOrderIDPair, err error) {
+	return le.PlaceLimitOrderAt(order, time.Now())
+}
+
+// PlaceLimitOrderAt places an order in the limit matching engine as if it were placed at
+// placementTime.
+func (le *SQLLimitEngine) PlaceLimitOrderAt(order *match.LimitOrder, placementTime time.Time) (idRes *match.LimitOrderIDPair, err error) {
 	if order == nil {
 		err = fmt.Errorf("Cannot place nil order, please enter valid input")
 		return
@@ -192,8 +211,6 @@ func (le *SQLLimitEngine) PlaceLimitOrder(order *match.LimitOrder) (idRes *match
 		return
 	}
 
-	// First, get the time.
-	placementTime := time.Now()
 	placementTimeFormatted := placementTime.Format(sqlTimeFormat)
 
 	// Do these first so we don't have to rollback any tx's if they're wrong
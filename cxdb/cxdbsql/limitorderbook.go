@@ -43,6 +43,14 @@ func CreateLimitOrderbook(pair *match.Pair) (book match.LimitOrderbook, err erro
 	// set the default conf
 	dbConfigSetup(conf)
 
+	if conf.DBDriver == postgresDriver {
+		if book, err = CreatePGLimitOrderbookStructWithConf(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres limit orderbook for CreateLimitOrderbook: %s", err)
+			return
+		}
+		return
+	}
+
 	// Resolve new address
 	var addr net.Addr
 	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
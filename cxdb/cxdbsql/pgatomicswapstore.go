@@ -0,0 +1,244 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGAtomicSwapStore is the postgres version of SQLAtomicSwapStore, it keeps atomic swaps for
+// every pair.
+type PGAtomicSwapStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// swap schema name
+	swapSchemaName string
+}
+
+// The columns are the same as the mysql atomic swap table, postgres just doesn't have
+// unsigned integers or inline indexes.
+const (
+	pgAtomicSwapsSchema = "seq BIGSERIAL PRIMARY KEY, id VARCHAR(64) NOT NULL UNIQUE, rhash VARCHAR(64) NOT NULL, preimage VARCHAR(32) NOT NULL, assetWant SMALLINT, assetHave SMALLINT, " +
+		"initiatorOrderID VARCHAR(64) NOT NULL, initiatorPubkey VARCHAR(66) NOT NULL, initiatorAsset SMALLINT, initiatorAmount BIGINT, initiatorLocktime BIGINT, initiatorOutpoint VARCHAR(160) NOT NULL, initiatorFundAmount BIGINT, initiatorFundHeight BIGINT, initiatorSpendTxid VARCHAR(128) NOT NULL, initiatorSpendHeight BIGINT, " +
+		"participantOrderID VARCHAR(64) NOT NULL, participantPubkey VARCHAR(66) NOT NULL, participantAsset SMALLINT, participantAmount BIGINT, participantLocktime BIGINT, participantOutpoint VARCHAR(160) NOT NULL, participantFundAmount BIGINT, participantFundHeight BIGINT, participantSpendTxid VARCHAR(128) NOT NULL, participantSpendHeight BIGINT, " +
+		"state VARCHAR(16) NOT NULL, deadline BIGINT, abandoner VARCHAR(66) NOT NULL, reason TEXT, created BIGINT, updated BIGINT"
+)
+
+// CreatePGAtomicSwapStoreStructWithConf creates a postgres atomic swap store, returning the
+// struct rather than the interface.
+func CreatePGAtomicSwapStoreStructWithConf(conf *dbsqlConfig) (ss *PGAtomicSwapStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGAtomicSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	ss = &PGAtomicSwapStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		dbName:         conf.DBName,
+		dbSSLMode:      conf.DBSSLMode,
+		swapSchemaName: conf.SwapSchemaName,
+		dbAddr:         addr,
+	}
+
+	if err = ss.setupAtomicSwapTables(); err != nil {
+		err = fmt.Errorf("Error setting up atomic swap tables for CreatePGAtomicSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	if ss.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGAtomicSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+	return
+}
+
+// setupAtomicSwapTables sets up the table for atomic swaps.
+// This assumes everything else is set
+func (ss *PGAtomicSwapStore) setupAtomicSwapTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup atomic swap tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup atomic swap tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating atomic swap tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup atomic swap tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ss.swapSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.swapSchemaName, err)
+		return
+	}
+
+	createQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", atomicSwapsTable, pgAtomicSwapsSchema)
+	if _, err = tx.Exec(createQuery); err != nil {
+		err = fmt.Errorf("Error creating atomic swap table: %s", err)
+		return
+	}
+
+	// atomic swaps are looked up by either side's pubkey for users and by state when blocks come in
+	for _, column := range []string{"initiatorPubkey", "participantPubkey", "state"} {
+		createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_%[2]s ON %[1]s (%[2]s);", atomicSwapsTable, column)
+		if _, err = tx.Exec(createIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating %s index on atomic swap table: %s", column, err)
+			return
+		}
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *PGAtomicSwapStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new atomic swap store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing atomic swap store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the swap schema. If the returned error is nil, the
+// caller has to call finishSwapTx with its own error, which commits or rolls back.
+func (ss *PGAtomicSwapStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(ss.swapSchemaName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using swap schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddAtomicSwap stores a new atomic swap
+func (ss *PGAtomicSwapStore) AddAtomicSwap(swap *match.AtomicSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddAtomicSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddAtomicSwap", err)
+	}()
+
+	err = insertAtomicSwap(tx, swap)
+	return
+}
+
+// UpdateAtomicSwap saves everything about a stored atomic swap that changes as it moves
+// along
+func (ss *PGAtomicSwapStore) UpdateAtomicSwap(swap *match.AtomicSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateAtomicSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateAtomicSwap", err)
+	}()
+
+	err = updateAtomicSwap(tx, swap)
+	return
+}
+
+// GetAtomicSwap gets an atomic swap by its ID
+func (ss *PGAtomicSwapStore) GetAtomicSwap(id [32]byte) (swap *match.AtomicSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetAtomicSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetAtomicSwap", err)
+	}()
+
+	swap, err = getAtomicSwap(tx, id)
+	return
+}
+
+// GetAtomicSwaps gets every atomic swap a pubkey is on either side of, oldest first
+func (ss *PGAtomicSwapStore) GetAtomicSwaps(pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetAtomicSwaps"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetAtomicSwaps", err)
+	}()
+
+	swaps, err = queryAtomicSwapsForPubkey(tx, pubkey)
+	return
+}
+
+// GetAtomicSwapsByState gets every atomic swap in a state, oldest first
+func (ss *PGAtomicSwapStore) GetAtomicSwapsByState(state match.AtomicSwapState) (swaps []*match.AtomicSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetAtomicSwapsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetAtomicSwapsByState", err)
+	}()
+
+	swaps, err = queryAtomicSwapsByState(tx, state)
+	return
+}
@@ -0,0 +1,405 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/crypto/sha3"
+)
+
+// PGAuctionEngine is the representation of an auction matching engine for postgres
+type PGAuctionEngine struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// auction orderbook schema name
+	auctionOrderSchema string
+
+	// this pair
+	pair *match.Pair
+}
+
+// The postgres schema for the auction orderbook. The price is calculated from priceWant and priceHave.
+const (
+	pgAuctionEngineSchema = "pubkey VARCHAR(66), side TEXT, priceWant BIGINT, priceHave BIGINT, amountHave BIGINT, amountWant BIGINT, auctionID VARCHAR(64), nonce VARCHAR(4), sig TEXT, hashedOrder VARCHAR(64), PRIMARY KEY (hashedOrder)"
+)
+
+// CreatePGAucEngineStructWithConf creates a postgres auction engine, returning the struct rather than the interface.
+func CreatePGAucEngineStructWithConf(pair *match.Pair, conf *dbsqlConfig) (engine *PGAuctionEngine, err error) {
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGAucEngineStructWithConf: %s", err)
+		return
+	}
+
+	// Set values
+	ae := &PGAuctionEngine{
+		dbUsername:         conf.DBUsername,
+		dbPassword:         conf.DBPassword,
+		dbName:             conf.DBName,
+		dbSSLMode:          conf.DBSSLMode,
+		auctionOrderSchema: conf.AuctionSchemaName,
+		dbAddr:             addr,
+		pair:               pair,
+	}
+
+	if err = ae.setupAuctionOrderbookTables(); err != nil {
+		err = fmt.Errorf("Error setting up auction orderbook tables while creating pg engine: %s", err)
+		return
+	}
+
+	if ae.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ae.dbUsername, ae.dbPassword, ae.dbAddr, ae.dbName, ae.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGAucEngineStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ae.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// now we actually set the return, all checks have passed
+	engine = ae
+	return
+}
+
+// setupAuctionOrderbookTables sets up the tables needed for the auction orderbook.
+// This assumes the schema name is set
+func (ae *PGAuctionEngine) setupAuctionOrderbookTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ae.dbUsername, ae.dbPassword, ae.dbAddr, ae.dbName, ae.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup auction tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup auction tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while matching setup auction tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ae.auctionOrderSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup auction order tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ae.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ae.auctionOrderSchema, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ae.pair.String(), pgAuctionEngineSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating auction orderbook table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ae *PGAuctionEngine) DestroyHandler() (err error) {
+	if ae.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new engine")
+		return
+	}
+	if err = ae.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing engine handler for DestroyHandler: %s", err)
+		return
+	}
+	ae.DBHandler = nil
+	return
+}
+
+// PlaceAuctionOrder places an order in the unencrypted datastore. This assumes that the order is valid.
+func (ae *PGAuctionEngine) PlaceAuctionOrder(order *match.AuctionOrder, auctionID *match.AuctionID) (idRes *match.AuctionOrderIDPair, err error) {
+	if ae.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot place order for nil handler, please create new engine")
+		return
+	}
+
+	// calculate price, this also makes sure neither amount is zero
+	var price float64
+	if price, err = order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price from order while placing order: %s", err)
+		return
+	}
+
+	// hash order so we can use that as a primary key
+	sha := sha3.New256()
+	sha.Write(order.SerializeSignable())
+	hashedOrder := sha.Sum(nil)
+
+	var tx *sql.Tx
+	if tx, err = ae.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PlaceAuctionOrder: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PlaceAuctionOrder: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+		return
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ae.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for PlaceAuctionOrder: %s", err)
+		return
+	}
+
+	insertOrderQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%s', %d, %d, %d, %d, '%x', '%x', '%x', '%x');", ae.pair.String(), order.Pubkey[:], order.Side.String(), order.AmountWant, order.AmountHave, order.AmountHave, order.AmountWant, auctionID[:], order.Nonce[:], order.Signature, hashedOrder)
+	if _, err = tx.Exec(insertOrderQuery); err != nil {
+		logging.Errorf("Bad query run: %s", insertOrderQuery)
+		err = fmt.Errorf("Error placing order into db for PlaceAuctionOrder: %s", err)
+		return
+	}
+
+	logging.Infof("Placed order with id %x!", hashedOrder)
+
+	// Finally, set the auction order / id pair
+	idRes = &match.AuctionOrderIDPair{
+		Order: order,
+		Price: price,
+	}
+	copy(idRes.OrderID[:], hashedOrder)
+
+	return
+}
+
+// CancelAuctionOrder cancels an auction order, this assumes that the auction order actually exists
+func (ae *PGAuctionEngine) CancelAuctionOrder(orderID *match.OrderID) (cancelled *match.CancelledOrder, cancelSettlement *match.SettlementExecution, err error) {
+	if ae.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot cancel order with nil handler, please create new engine")
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = ae.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for CancelAuctionOrder: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+		return
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ae.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	var row *sql.Row
+	selectOrderQuery := fmt.Sprintf("SELECT pubkey, side, amountHave FROM %s WHERE hashedOrder = '%x' FOR UPDATE;", ae.pair.String(), orderID[:])
+	// errors deferred to scan
+	row = tx.QueryRow(selectOrderQuery)
+
+	var pkBytes []byte
+	var orderSide string
+	var remainingHave uint64
+	if err = row.Scan(&pkBytes, &orderSide, &remainingHave); err != nil {
+		err = fmt.Errorf("Error scanning for order for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	// decode them all weirdly because we store the bytes as hex
+	if pkBytes, err = hex.DecodeString(string(pkBytes)); err != nil {
+		err = fmt.Errorf("Error decoding pkBytes for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE hashedOrder = '%x';", ae.pair.String(), orderID[:])
+	if _, err = tx.Exec(deleteOrderQuery); err != nil {
+		err = fmt.Errorf("Error deleting order for CancelAuctionOrder: %s", err)
+		return
+	}
+
+	cancelled = &match.CancelledOrder{
+		OrderID: orderID,
+	}
+	var debitAsset match.Asset
+	if orderSide == match.Buy.String() {
+		debitAsset = ae.pair.AssetHave
+	} else {
+		debitAsset = ae.pair.AssetWant
+	}
+	cancelSettlement = &match.SettlementExecution{
+		Amount: remainingHave,
+		Type:   match.Debit,
+		Asset:  debitAsset,
+	}
+	copy(cancelSettlement.Pubkey[:], pkBytes)
+
+	return
+}
+
+// MatchAuctionOrders calculates a single clearing price to execute orders at, and executes at that price.
+func (ae *PGAuctionEngine) MatchAuctionOrders(auctionID *match.AuctionID) (orderExecs []*match.OrderExecution, settlementExecs []*match.SettlementExecution, err error) {
+	if ae.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot match orders for nil handler, please create new engine")
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = ae.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for MatchAuctionOrders: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while matching auction: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ae.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for MatchAuctionOrders: %s", err)
+		return
+	}
+
+	// map representation of orderbook
+	var orderList []*match.AuctionOrderIDPair
+	selectOrderQuery := fmt.Sprintf("SELECT pubkey, side, %s, amountHave, amountWant, auctionID, nonce, sig, hashedOrder FROM %s WHERE auctionID = '%x' FOR UPDATE;", pgPriceExpr, ae.pair.String(), auctionID[:])
+	if orderList, err = getPGAuctionOrdersTx(tx, selectOrderQuery, ae.pair); err != nil {
+		err = fmt.Errorf("Error getting orders for MatchAuctionOrders: %s", err)
+		return
+	}
+
+	book := make(map[float64][]*match.AuctionOrderIDPair)
+	for _, order := range orderList {
+		book[order.Price] = append(book[order.Price], order)
+	}
+
+	// We can now calculate a clearing price and run the matching algorithm
+	if orderExecs, settlementExecs, err = match.MatchClearingAlgorithm(book); err != nil {
+		err = fmt.Errorf("Error running clearing matching algorithm for MatchAuctionOrders: %s", err)
+		return
+	}
+
+	// now process all of these matches based on the matching algorithm
+	for _, exec := range orderExecs {
+		if exec.Filled {
+			deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE hashedOrder='%x';", ae.pair.String(), exec.OrderID[:])
+			if _, err = tx.Exec(deleteOrderQuery); err != nil {
+				err = fmt.Errorf("Error deleting filled order for MatchAuctionOrders: %s", err)
+				return
+			}
+		} else {
+			updateOrderQuery := fmt.Sprintf("UPDATE %s SET amountHave=%d, amountWant=%d WHERE hashedOrder='%x';", ae.pair.String(), exec.NewAmountHave, exec.NewAmountWant, exec.OrderID[:])
+			if _, err = tx.Exec(updateOrderQuery); err != nil {
+				err = fmt.Errorf("Error updating order for MatchAuctionOrders: %s", err)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// getPGAuctionOrdersTx runs a query that selects pubkey, side, price, amountHave, amountWant, auctionID,
+// nonce, sig, hashedOrder and turns the rows into auction orders for the pair.
+func getPGAuctionOrdersTx(tx *sql.Tx, query string, pair *match.Pair) (orders []*match.AuctionOrderIDPair, err error) {
+	var rows *sql.Rows
+	if rows, err = tx.Query(query); err != nil {
+		err = fmt.Errorf("Error querying for auction orders: %s", err)
+		return
+	}
+
+	defer func() {
+		var newErr error
+		if newErr = rows.Close(); newErr != nil && err == nil {
+			err = fmt.Errorf("Error closing auction order rows: %s", newErr)
+			return
+		}
+		return
+	}()
+
+	// we create these here so we don't take up a ton of memory allocating space for new intermediate arrays
+	var pkBytes []byte
+	var sideString string
+	var auctionIDBytes []byte
+	var nonceBytes []byte
+	var sigBytes []byte
+	var hashedOrderBytes []byte
+
+	for rows.Next() {
+		thisOrder := new(match.AuctionOrder)
+		thisOrderPair := new(match.AuctionOrderIDPair)
+		if err = rows.Scan(&pkBytes, &sideString, &thisOrderPair.Price, &thisOrder.AmountHave, &thisOrder.AmountWant, &auctionIDBytes, &nonceBytes, &sigBytes, &hashedOrderBytes); err != nil {
+			err = fmt.Errorf("Error scanning into auction order: %s", err)
+			return
+		}
+
+		// decode them all weirdly because we store the bytes as hex
+		for _, byteArrayPtr := range []*[]byte{&pkBytes, &auctionIDBytes, &nonceBytes, &sigBytes, &hashedOrderBytes} {
+			if *byteArrayPtr, err = hex.DecodeString(string(*byteArrayPtr)); err != nil {
+				err = fmt.Errorf("Error decoding bytes for auction order: %s", err)
+				return
+			}
+		}
+
+		// Copy all of the bytes
+		copy(thisOrder.Pubkey[:], pkBytes)
+		copy(thisOrder.AuctionID[:], auctionIDBytes)
+		copy(thisOrder.Nonce[:], nonceBytes)
+		thisOrder.Signature = append([]byte{}, sigBytes...)
+		thisOrder.Side = sideString == match.Buy.String()
+		thisOrder.TradingPair = *pair
+		copy(thisOrderPair.OrderID[:], hashedOrderBytes)
+		thisOrderPair.Order = thisOrder
+		orders = append(orders, thisOrderPair)
+	}
+
+	return
+}
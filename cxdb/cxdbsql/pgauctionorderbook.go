@@ -0,0 +1,440 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGAuctionOrderbook is the representation of an auction orderbook for postgres
+type PGAuctionOrderbook struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// orderbook schema name
+	auctionOrderSchema string
+
+	// this pair
+	pair *match.Pair
+}
+
+// The postgres schema for the auction orderbook
+const (
+	pgAuctionOrderbookSchema = "pubkey VARCHAR(66), side TEXT, priceWant BIGINT, priceHave BIGINT, amountHave BIGINT, amountWant BIGINT, auctionID VARCHAR(64), nonce VARCHAR(4), sig TEXT, hashedOrder VARCHAR(64), PRIMARY KEY (hashedOrder)"
+)
+
+// CreatePGAuctionOrderbookStructWithConf creates a postgres auction orderbook based on a pair, returning the struct.
+func CreatePGAuctionOrderbookStructWithConf(pair *match.Pair, conf *dbsqlConfig) (book *PGAuctionOrderbook, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGAuctionOrderbookStructWithConf: %s", err)
+		return
+	}
+
+	// Set values for auction orderbook
+	ao := &PGAuctionOrderbook{
+		dbUsername:         conf.DBUsername,
+		dbPassword:         conf.DBPassword,
+		dbName:             conf.DBName,
+		dbSSLMode:          conf.DBSSLMode,
+		auctionOrderSchema: conf.ReadOnlyAuctionSchemaName,
+		dbAddr:             addr,
+		pair:               pair,
+	}
+
+	if err = ao.setupAuctionOrderbookTables(); err != nil {
+		err = fmt.Errorf("Error setting up auction orderbook tables while creating pg orderbook: %s", err)
+		return
+	}
+
+	if ao.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ao.dbUsername, ao.dbPassword, ao.dbAddr, ao.dbName, ao.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGAuctionOrderbookStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ao.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We can connect, now set return
+	book = ao
+	return
+}
+
+// setupAuctionOrderbookTables sets up the tables needed for the auction orderbook.
+// This assumes everything else is set
+func (ao *PGAuctionOrderbook) setupAuctionOrderbookTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ao.dbUsername, ao.dbPassword, ao.dbAddr, ao.dbName, ao.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup auction orderbook tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup auction orderbook tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while setting up auction orderbook tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ao.auctionOrderSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup auction order tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ao.auctionOrderSchema, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ao.pair.String(), pgAuctionOrderbookSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating auction orderbook table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ao *PGAuctionOrderbook) DestroyHandler() (err error) {
+	if ao.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new orderbook")
+		return
+	}
+	if err = ao.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing orderbook handler for DestroyHandler: %s", err)
+		return
+	}
+	ao.DBHandler = nil
+	return
+}
+
+// UpdateBookExec takes in an order execution and updates the orderbook.
+func (ao *PGAuctionOrderbook) UpdateBookExec(exec *match.OrderExecution) (err error) {
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateBookExec: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while running UpdateBookExec: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the auction schema
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for UpdateBookExec: %s", err)
+		return
+	}
+
+	// If the order was filled then delete it. If not then update it.
+	var res sql.Result
+	if exec.Filled {
+		deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE hashedOrder='%x';", ao.pair.String(), exec.OrderID[:])
+		if res, err = tx.Exec(deleteOrderQuery); err != nil {
+			err = fmt.Errorf("Error deleting order within tx for UpdateBookExec: %s", err)
+			return
+		}
+	} else {
+		updateOrderQuery := fmt.Sprintf("UPDATE %s SET amountHave=%d, amountWant=%d WHERE hashedOrder='%x';", ao.pair.String(), exec.NewAmountHave, exec.NewAmountWant, exec.OrderID[:])
+		if res, err = tx.Exec(updateOrderQuery); err != nil {
+			err = fmt.Errorf("Error updating order within tx for UpdateBookExec: %s", err)
+			return
+		}
+	}
+
+	// hashedOrder is the primary key so this should only ever be one row
+	var rowsAffected int64
+	if rowsAffected, err = res.RowsAffected(); err != nil {
+		err = fmt.Errorf("Error while getting rows affected for UpdateBookExec: %s", err)
+		return
+	}
+	if rowsAffected != 1 {
+		err = fmt.Errorf("Error: Order execution should only have affected one row. Instead, it affected %d", rowsAffected)
+		return
+	}
+	return
+}
+
+// UpdateBookCancel takes in an order cancellation and updates the orderbook.
+func (ao *PGAuctionOrderbook) UpdateBookCancel(cancel *match.CancelledOrder) (err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateBookCancel: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with UpdateBookCancel: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the auction schema
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for UpdateBookCancel: %s", err)
+		return
+	}
+
+	deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE hashedOrder='%x';", ao.pair.String(), cancel.OrderID[:])
+	var res sql.Result
+	if res, err = tx.Exec(deleteOrderQuery); err != nil {
+		err = fmt.Errorf("Error deleting order within tx for cancel: %s", err)
+		return
+	}
+
+	var rowsAffected int64
+	if rowsAffected, err = res.RowsAffected(); err != nil {
+		err = fmt.Errorf("Error while getting rows affected for cancel: %s", err)
+		return
+	}
+	if rowsAffected != 1 {
+		err = fmt.Errorf("Error: Order cancel should only have affected one row. Instead, it affected %d", rowsAffected)
+		return
+	}
+	return
+}
+
+// UpdateBookPlace takes in an order, ID, auction ID, and adds the order to the orderbook.
+func (ao *PGAuctionOrderbook) UpdateBookPlace(auctionIDPair *match.AuctionOrderIDPair) (err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateBookPlace: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for UpdateBookPlace: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the auction schema
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for UpdateBookPlace: %s", err)
+		return
+	}
+
+	order := auctionIDPair.Order
+	insertOrderQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%s', %d, %d, %d, %d, '%x', '%x', '%x', '%x');", ao.pair.String(), order.Pubkey[:], order.Side.String(), order.AmountWant, order.AmountHave, order.AmountHave, order.AmountWant, order.AuctionID[:], order.Nonce[:], order.Signature, auctionIDPair.OrderID[:])
+	if _, err = tx.Exec(insertOrderQuery); err != nil {
+		err = fmt.Errorf("Error placing order into db for UpdateBookPlace: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetOrder gets an order from an OrderID
+func (ao *PGAuctionOrderbook) GetOrder(orderID *match.OrderID) (aucOrder *match.AuctionOrderIDPair, err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetOrder: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with GetOrder: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the auction schema
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for GetOrder: %s", err)
+		return
+	}
+
+	var orders []*match.AuctionOrderIDPair
+	selectOrderQuery := fmt.Sprintf("SELECT pubkey, side, %s, amountHave, amountWant, auctionID, nonce, sig, hashedOrder FROM %s WHERE hashedOrder='%x';", pgPriceExpr, ao.pair.String(), orderID[:])
+	if orders, err = getPGAuctionOrdersTx(tx, selectOrderQuery, ao.pair); err != nil {
+		err = fmt.Errorf("Error getting order for GetOrder: %s", err)
+		return
+	}
+
+	if len(orders) != 1 {
+		err = fmt.Errorf("Error, expected one order for id %x but found %d", orderID[:], len(orders))
+		return
+	}
+
+	aucOrder = orders[0]
+	return
+}
+
+// CalculatePrice returns the calculated price for an auction based on the orderbook.
+func (ao *PGAuctionOrderbook) CalculatePrice(auctionID *match.AuctionID) (price float64, err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for CalculatePrice: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with CalculatePrice: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the auction schema
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for CalculatePrice: %s", err)
+		return
+	}
+
+	// First get the max sell price and min buy price
+	var maxSell sql.NullFloat64
+	getMaxSellPrice := fmt.Sprintf("SELECT MAX%s FROM %s WHERE side='%s' AND auctionID='%x';", pgPriceExpr, ao.pair.String(), match.Sell.String(), auctionID[:])
+	if err = tx.QueryRow(getMaxSellPrice).Scan(&maxSell); err != nil {
+		err = fmt.Errorf("Error scanning max sell row for auction CalculatePrice: %s", err)
+		return
+	}
+
+	var minBuy sql.NullFloat64
+	getMinBuyPrice := fmt.Sprintf("SELECT MIN%s FROM %s WHERE side='%s' AND auctionID='%x';", pgPriceExpr, ao.pair.String(), match.Buy.String(), auctionID[:])
+	if err = tx.QueryRow(getMinBuyPrice).Scan(&minBuy); err != nil {
+		err = fmt.Errorf("Error scanning min buy row for auction CalculatePrice: %s", err)
+		return
+	}
+
+	price = (minBuy.Float64 + maxSell.Float64) / 2
+	return
+}
+
+// GetOrdersForPubkey gets orders for a specific pubkey.
+func (ao *PGAuctionOrderbook) GetOrdersForPubkey(pubkey *koblitz.PublicKey) (orders map[float64][]*match.AuctionOrderIDPair, err error) {
+	// Make the book!!!!
+	orders = make(map[float64][]*match.AuctionOrderIDPair)
+
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with GetOrdersForPubkey: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	var orderList []*match.AuctionOrderIDPair
+	selectOrderQuery := fmt.Sprintf("SELECT pubkey, side, %s, amountHave, amountWant, auctionID, nonce, sig, hashedOrder FROM %s WHERE pubkey='%x';", pgPriceExpr, ao.pair.String(), pubkey.SerializeCompressed())
+	if orderList, err = getPGAuctionOrdersTx(tx, selectOrderQuery, ao.pair); err != nil {
+		err = fmt.Errorf("Error getting orders for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	for _, order := range orderList {
+		orders[order.Price] = append(orders[order.Price], order)
+	}
+	return
+}
+
+// ViewAuctionOrderBook returns the orderbook as a map
+func (ao *PGAuctionOrderbook) ViewAuctionOrderBook() (book map[float64][]*match.AuctionOrderIDPair, err error) {
+	// Make the book!!!!
+	book = make(map[float64][]*match.AuctionOrderIDPair)
+
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = ao.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewAuctionOrderBook: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with ViewAuctionOrderBook: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ao.auctionOrderSchema)); err != nil {
+		err = fmt.Errorf("Error using auction schema for ViewAuctionOrderBook: %s", err)
+		return
+	}
+
+	var orderList []*match.AuctionOrderIDPair
+	selectOrderQuery := fmt.Sprintf("SELECT pubkey, side, %s, amountHave, amountWant, auctionID, nonce, sig, hashedOrder FROM %s;", pgPriceExpr, ao.pair.String())
+	if orderList, err = getPGAuctionOrdersTx(tx, selectOrderQuery, ao.pair); err != nil {
+		err = fmt.Errorf("Error getting orders for ViewAuctionOrderBook: %s", err)
+		return
+	}
+
+	for _, order := range orderList {
+		book[order.Price] = append(book[order.Price], order)
+	}
+	return
+}
@@ -0,0 +1,305 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGColdStore is the postgres version of SQLColdStore, it keeps the outputs paying to a coin's
+// cold wallet.
+type PGColdStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// cold schema name
+	coldSchemaName string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// The columns are the same as the mysql cold table, postgres just doesn't have unsigned integers.
+const (
+	pgColdStoreSchema = "txid VARCHAR(64) NOT NULL, idx BIGINT NOT NULL, amount BIGINT, pkscript TEXT, keyindex BIGINT, height BIGINT, spentheight BIGINT, PRIMARY KEY (txid, idx)"
+)
+
+// CreatePGColdStoreStructWithConf creates a postgres cold store for a coin, returning the struct
+// rather than the interface.
+func CreatePGColdStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (cs *PGColdStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGColdStoreStructWithConf: %s", err)
+		return
+	}
+
+	cs = &PGColdStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		dbName:         conf.DBName,
+		dbSSLMode:      conf.DBSSLMode,
+		coldSchemaName: conf.ColdSchemaName,
+
+		dbAddr: addr,
+		coin:   coin,
+	}
+
+	if err = cs.setupColdTables(); err != nil {
+		err = fmt.Errorf("Error setting up cold tables for CreatePGColdStoreStructWithConf: %s", err)
+		return
+	}
+
+	if cs.DBHandler, err = sql.Open(postgresDriver, pgOpenString(cs.dbUsername, cs.dbPassword, cs.dbAddr, cs.dbName, cs.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGColdStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = cs.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// setupColdTables sets up the table for this coin's cold outputs.
+// This assumes everything else is set
+func (cs *PGColdStore) setupColdTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(cs.dbUsername, cs.dbPassword, cs.dbAddr, cs.dbName, cs.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup cold tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup cold tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating cold tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + cs.coldSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup cold tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(cs.coldSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", cs.coldSchemaName, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", cs.coin.Name, pgColdStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating cold table: %s", err)
+		return
+	}
+
+	createRefillTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", refillTable(cs.coin), coldRefillSchema)
+	if _, err = tx.Exec(createRefillTableQuery); err != nil {
+		err = fmt.Errorf("Error creating refill table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (cs *PGColdStore) DestroyHandler() (err error) {
+	if cs.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new cold store")
+		return
+	}
+	if err = cs.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing cold store handler for DestroyHandler: %s", err)
+		return
+	}
+	cs.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the cold schema. If the returned error is nil, the caller
+// has to call finishColdTx with its own error, which commits or rolls back.
+func (cs *PGColdStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = cs.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(cs.coldSchemaName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using cold schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddColdUtxos stores new outputs paying to the cold wallet
+func (cs *PGColdStore) AddColdUtxos(utxos []*match.ColdUtxo) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("AddColdUtxos"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "AddColdUtxos", err)
+	}()
+
+	for _, utxo := range utxos {
+		var values string
+		if values, err = coldUtxoValues(utxo); err != nil {
+			return
+		}
+		// postgres doesn't have INSERT IGNORE
+		insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT DO NOTHING;", cs.coin.Name, coldUtxoColumns, values)
+		if _, err = tx.Exec(insertQuery); err != nil {
+			err = fmt.Errorf("Error inserting cold utxo %s: %s", utxo.OutPoint(), err)
+			return
+		}
+	}
+	return
+}
+
+// SpendColdUtxos marks cold outputs as spent in the block at blockheight
+func (cs *PGColdStore) SpendColdUtxos(outpoints []string, blockheight uint64) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("SpendColdUtxos"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "SpendColdUtxos", err)
+	}()
+
+	err = spendColdUtxos(tx, cs.coin.Name, outpoints, blockheight)
+	return
+}
+
+// DisconnectBlocks removes outputs seen above blockheight and unspends outputs spent above it
+func (cs *PGColdStore) DisconnectBlocks(blockheight uint64) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("DisconnectBlocks"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "DisconnectBlocks", err)
+	}()
+
+	err = disconnectColdBlocks(tx, cs.coin.Name, blockheight)
+	return
+}
+
+// GetColdUtxos gets every unspent cold output, oldest first
+func (cs *PGColdStore) GetColdUtxos() (utxos []*match.ColdUtxo, err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("GetColdUtxos"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "GetColdUtxos", err)
+	}()
+
+	utxos, err = queryColdUtxos(tx, cs.coin.Name)
+	return
+}
+
+// AddRefill stores a refill that's waiting to be signed
+func (cs *PGColdStore) AddRefill(txid string, refill *match.RefillTx) (err error) {
+	var encodedRefill string
+	if encodedRefill, err = encodeRefill(txid, refill); err != nil {
+		err = fmt.Errorf("Error encoding refill for AddRefill: %s", err)
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = cs.begin("AddRefill"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "AddRefill", err)
+	}()
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%s', '%s') ON CONFLICT (txid) DO UPDATE SET encodedRefill = EXCLUDED.encodedRefill;", refillTable(cs.coin), txid, encodedRefill)
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting refill %s: %s", txid, err)
+		return
+	}
+	return
+}
+
+// GetRefill gets the refill waiting to be signed with an unsigned txid, nil if there isn't one
+func (cs *PGColdStore) GetRefill(txid string) (refill *match.RefillTx, err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("GetRefill"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "GetRefill", err)
+	}()
+
+	refill, err = queryRefill(tx, refillTable(cs.coin), txid)
+	return
+}
+
+// GetRefills gets every refill waiting to be signed, by unsigned txid
+func (cs *PGColdStore) GetRefills() (refills []*match.RefillTx, err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("GetRefills"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "GetRefills", err)
+	}()
+
+	refills, err = queryRefills(tx, refillTable(cs.coin))
+	return
+}
+
+// RemoveRefill removes a refill once it's signed and sent
+func (cs *PGColdStore) RemoveRefill(txid string) (err error) {
+	var tx *sql.Tx
+	if tx, err = cs.begin("RemoveRefill"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishColdTx(tx, "RemoveRefill", err)
+	}()
+
+	err = removeRefill(tx, refillTable(cs.coin), txid)
+	return
+}
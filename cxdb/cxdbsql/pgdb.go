@@ -0,0 +1,37 @@
+package cxdbsql
+
+import (
+	"net"
+	"net/url"
+
+	_ "github.com/lib/pq"
+)
+
+// Postgres doesn't have USE, so every transaction sets the search path instead. SET LOCAL only
+// lasts until the end of the transaction, so handlers in the pool don't leak schemas between calls.
+// Postgres also won't let us use FOR UPDATE with aggregates like MAX and MIN, so anything that
+// used to lock on those locks the whole table at the start of the transaction instead.
+
+const (
+	// pgPriceExpr computes the price from the integer priceWant and priceHave columns, this is the
+	// same as (*match.LimitOrder).Price() but done by the database.
+	pgPriceExpr = "(priceWant::NUMERIC / priceHave)"
+)
+
+// pgOpenString creates the connection string for lib/pq from the things we know about the db
+func pgOpenString(username string, password string, addr net.Addr, dbName string, sslMode string) string {
+	connURL := &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(username, password),
+		Host:     addr.String(),
+		Path:     "/" + dbName,
+		RawQuery: "sslmode=" + url.QueryEscape(sslMode),
+	}
+	return connURL.String()
+}
+
+// pgUseSchema returns the query that makes the rest of the transaction run in a schema, this is
+// the postgres version of USE schema;
+func pgUseSchema(schema string) string {
+	return "SET LOCAL search_path TO " + schema + ";"
+}
@@ -0,0 +1,320 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	// postgres superuser stuff
+	pgRootUser = "postgres"
+	pgRootPass = ""
+)
+
+type pgTesterContainer struct {
+	rootHandler *sql.DB
+	conf        *dbsqlConfig
+}
+
+// CreatePGTesterContainer creates a struct that contains a postgres superuser connection, which is used to
+// create the testing role and drop the schemas created by the tests.
+func CreatePGTesterContainer() (tc *pgTesterContainer, err error) {
+	tc = &pgTesterContainer{conf: pgTestConfig()}
+
+	var dbAddr net.Addr
+	if dbAddr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(tc.conf.DBHost, fmt.Sprintf("%d", tc.conf.DBPort))); err != nil {
+		err = fmt.Errorf("Error resolving conf derived address for CreatePGTesterContainer: %s", err)
+		return
+	}
+
+	// this is the superuser!
+	if tc.rootHandler, err = sql.Open(postgresDriver, pgOpenString(pgRootUser, pgRootPass, dbAddr, tc.conf.DBName, tc.conf.DBSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening db to create testing user: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = tc.rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	if _, err = tc.rootHandler.Exec(fmt.Sprintf("DROP ROLE IF EXISTS %s;", tc.conf.DBUsername)); err != nil {
+		err = fmt.Errorf("Error dropping old user for testing: %s", err)
+		return
+	}
+
+	if _, err = tc.rootHandler.Exec(fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD '%s';", tc.conf.DBUsername, tc.conf.DBPassword)); err != nil {
+		err = fmt.Errorf("Error creating user for testing: %s", err)
+		return
+	}
+
+	if _, err = tc.rootHandler.Exec(fmt.Sprintf("GRANT CREATE ON DATABASE %s TO %s;", tc.conf.DBName, tc.conf.DBUsername)); err != nil {
+		err = fmt.Errorf("Error granting user for testing: %s", err)
+		return
+	}
+	return
+}
+
+// Kill drops the schemas that would have been created by the test config, drops the testing role, then closes the handler
+func (tc *pgTesterContainer) Kill() (err error) {
+	if tc.rootHandler == nil {
+		err = fmt.Errorf("Error, cannot kill nil handler, construct container correctly")
+		return
+	}
+	for _, schema := range getSchemasFromConfig(tc.conf) {
+		if schema != "" {
+			if _, err = tc.rootHandler.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE;", schema)); err != nil {
+				err = fmt.Errorf("Error dropping schema for testing: %s", err)
+				return
+			}
+		}
+	}
+	if _, err = tc.rootHandler.Exec(fmt.Sprintf("REVOKE CREATE ON DATABASE %s FROM %s;", tc.conf.DBName, tc.conf.DBUsername)); err != nil {
+		err = fmt.Errorf("Error revoking user for testing: %s", err)
+		return
+	}
+	if _, err = tc.rootHandler.Exec(fmt.Sprintf("DROP ROLE IF EXISTS %s;", tc.conf.DBUsername)); err != nil {
+		err = fmt.Errorf("Error dropping user for testing: %s", err)
+		return
+	}
+	if err = tc.rootHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing tc handler for Kill: %s", err)
+		return
+	}
+	return
+}
+
+// pgTestConfig is the test config with the postgres driver. The config file is parsed first so the
+// testing role gets created with whatever credentials the stores will end up using.
+func pgTestConfig() (conf *dbsqlConfig) {
+	conf = testConfig()
+	conf.DBHomeDir = defaultDBHomeDirName + "testpg/"
+	conf.DBName = "postgres"
+	conf.DBSSLMode = defaultDBSSLMode
+	dbConfigSetup(conf)
+	conf.DBDriver = postgresDriver
+	conf.DBPort = defaultPGDBPort
+	return
+}
+
+// pgTesterOrSkip creates a postgres tester container, or skips the test if there's no postgres to test against.
+func pgTesterOrSkip(t *testing.T) (tc *pgTesterContainer) {
+	var err error
+	if tc, err = CreatePGTesterContainer(); err != nil {
+		t.Skipf("Skipping postgres test, could not create tester container: %s", err)
+	}
+	return
+}
+
+func TestCreatePGStoresAllParams(t *testing.T) {
+	var err error
+
+	tc := pgTesterOrSkip(t)
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var pairList []*match.Pair
+	if pairList, err = match.GenerateAssetPairs(constCoinParams()); err != nil {
+		t.Errorf("Error creating asset pairs from coin list: %s", err)
+		return
+	}
+
+	for _, pair := range pairList {
+		var le *PGLimitEngine
+		if le, err = CreatePGLimEngineStructWithConf(pair, tc.conf); err != nil {
+			t.Errorf("Error creating limit engine for pair: %s", err)
+			return
+		}
+		if err = le.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for limit engine: %s", err)
+		}
+
+		var lb *PGLimitOrderbook
+		if lb, err = CreatePGLimitOrderbookStructWithConf(pair, tc.conf); err != nil {
+			t.Errorf("Error creating limit orderbook for pair: %s", err)
+			return
+		}
+		if err = lb.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for limit orderbook: %s", err)
+		}
+
+		var ae *PGAuctionEngine
+		if ae, err = CreatePGAucEngineStructWithConf(pair, tc.conf); err != nil {
+			t.Errorf("Error creating auction engine for pair: %s", err)
+			return
+		}
+		if err = ae.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for auction engine: %s", err)
+		}
+
+		var ab *PGAuctionOrderbook
+		if ab, err = CreatePGAuctionOrderbookStructWithConf(pair, tc.conf); err != nil {
+			t.Errorf("Error creating auction orderbook for pair: %s", err)
+			return
+		}
+		if err = ab.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for auction orderbook: %s", err)
+		}
+
+		var ps *PGPuzzleStore
+		if ps, err = CreatePGPuzzleStoreStructWithConf(pair, tc.conf); err != nil {
+			t.Errorf("Error creating puzzle store for pair: %s", err)
+			return
+		}
+		if err = ps.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for puzzle store: %s", err)
+		}
+	}
+
+	for _, coin := range constCoinParams() {
+		var ds *PGDepositStore
+		if ds, err = CreatePGDepositStoreStructWithConf(coin, tc.conf); err != nil {
+			t.Errorf("Error creating deposit store for coin: %s", err)
+			return
+		}
+		if err = ds.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for deposit store: %s", err)
+		}
+
+		var ws *PGWithdrawalStore
+		if ws, err = CreatePGWithdrawalStoreStructWithConf(coin, tc.conf); err != nil {
+			t.Errorf("Error creating withdrawal store for coin: %s", err)
+			return
+		}
+		if err = ws.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for withdrawal store: %s", err)
+		}
+
+		var se *PGSettlementEngine
+		if se, err = CreatePGSettlementEngineStructWithConf(coin, tc.conf); err != nil {
+			t.Errorf("Error creating settlement engine for coin: %s", err)
+			return
+		}
+		if err = se.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for settlement engine: %s", err)
+		}
+
+		var ss *PGSettlementStore
+		if ss, err = CreatePGSettlementStoreStructWithConf(coin, tc.conf); err != nil {
+			t.Errorf("Error creating settlement store for coin: %s", err)
+			return
+		}
+		if err = ss.DestroyHandler(); err != nil {
+			t.Errorf("Error destroying handler for settlement store: %s", err)
+		}
+	}
+}
+
+func TestPGPlaceCancelLimitOrder(t *testing.T) {
+	var err error
+
+	tc := pgTesterOrSkip(t)
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var le *PGLimitEngine
+	if le, err = CreatePGLimEngineStructWithConf(&testLimitOrder.TradingPair, tc.conf); err != nil {
+		t.Errorf("Error creating limit engine for pair: %s", err)
+		return
+	}
+
+	var idRes *match.LimitOrderIDPair
+	if idRes, err = le.PlaceLimitOrder(testLimitOrder); err != nil {
+		t.Errorf("Error placing limit order: %s", err)
+		return
+	}
+
+	if _, _, err = le.CancelLimitOrder(idRes.OrderID); err != nil {
+		t.Errorf("Error cancelling limit order: %s", err)
+		return
+	}
+
+	// cancelling twice should fail because the order is gone
+	if _, _, err = le.CancelLimitOrder(idRes.OrderID); err == nil {
+		t.Errorf("Cancelling an order twice should have failed")
+	}
+
+	if err = le.DestroyHandler(); err != nil {
+		t.Errorf("Error destroying handler for limit engine: %s", err)
+	}
+}
+
+func TestPGSettlementCheckValid(t *testing.T) {
+	var err error
+
+	tc := pgTesterOrSkip(t)
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var se *PGSettlementEngine
+	if se, err = CreatePGSettlementEngineStructWithConf(constCoinParams()[0], tc.conf); err != nil {
+		t.Errorf("Error creating settlement engine for coin: %s", err)
+		return
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(constCoinParams()[0]); err != nil {
+		t.Errorf("Error getting asset from coin: %s", err)
+		return
+	}
+
+	debit := &match.SettlementExecution{
+		Pubkey: testLimitOrder.Pubkey,
+		Amount: 1000,
+		Asset:  asset,
+		Type:   match.Debit,
+	}
+	credit := &match.SettlementExecution{
+		Pubkey: testLimitOrder.Pubkey,
+		Amount: 1000,
+		Asset:  asset,
+		Type:   match.Credit,
+	}
+
+	var valid bool
+	if valid, err = se.CheckValid(credit); err != nil {
+		t.Errorf("Error checking credit before debit: %s", err)
+		return
+	}
+	if valid {
+		t.Errorf("Credit should not be valid for a user with no balance")
+	}
+
+	var setRes *match.SettlementResult
+	if setRes, err = se.ApplySettlementExecution(debit); err != nil {
+		t.Errorf("Error applying debit: %s", err)
+		return
+	}
+	if setRes.NewBal != debit.Amount {
+		t.Errorf("Balance should be %d after debit, got %d", debit.Amount, setRes.NewBal)
+	}
+
+	if valid, err = se.CheckValid(credit); err != nil {
+		t.Errorf("Error checking credit after debit: %s", err)
+		return
+	}
+	if !valid {
+		t.Errorf("Credit for the entire balance should be valid")
+	}
+
+	if err = se.DestroyHandler(); err != nil {
+		t.Errorf("Error destroying handler for settlement engine: %s", err)
+	}
+}
@@ -0,0 +1,486 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGDepositStore is a deposit store representation for a postgres database
+type PGDepositStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// deposit addr schema name
+	depositAddrSchemaName string
+
+	// pending deposit schema name
+	pendingDepositSchemaName string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// The postgres schema for the deposit store
+const (
+	pgDepositAddrStoreSchema    = "pubkey VARCHAR(66), address VARCHAR(90), CONSTRAINT unique_pubkeys UNIQUE (pubkey, address)"
+	pgPendingDepositStoreSchema = "pubkey VARCHAR(66), expectedConfirmHeight BIGINT, depositHeight BIGINT, amount BIGINT, txid TEXT, blockHash VARCHAR(64), status VARCHAR(16) NOT NULL DEFAULT 'pending'"
+	pgDepositDebtStoreSchema    = "pubkey VARCHAR(66) NOT NULL, debt BIGINT, PRIMARY KEY (pubkey)"
+)
+
+// CreatePGDepositStoreStructWithConf creates a postgres deposit store for a coin, returning the struct.
+func CreatePGDepositStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (ds *PGDepositStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Set values for deposit store
+	ds = &PGDepositStore{
+		dbUsername:               conf.DBUsername,
+		dbPassword:               conf.DBPassword,
+		dbName:                   conf.DBName,
+		dbSSLMode:                conf.DBSSLMode,
+		depositAddrSchemaName:    conf.DepositSchemaName,
+		pendingDepositSchemaName: conf.PendingDepositSchemaName,
+
+		dbAddr: addr,
+		coin:   coin,
+	}
+
+	if err = ds.setupDepositTables(); err != nil {
+		err = fmt.Errorf("Error setting up deposit tables for CreatePGDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	if ds.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ds.dbUsername, ds.dbPassword, ds.dbAddr, ds.dbName, ds.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ds.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running? Did you set the correct username and password in sqldb.conf: %s", err)
+		return
+	}
+
+	return
+}
+
+// setupDepositTables sets up the tables needed to keep track of pending deposits and deposit addresses.
+// This assumes everything else is set
+func (ds *PGDepositStore) setupDepositTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ds.dbUsername, ds.dbPassword, ds.dbAddr, ds.dbName, ds.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup deposit tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup deposit tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while matching setup deposit tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the first schema (keeping track of deposit addresses)
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ds.depositAddrSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup deposit addr tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ds.depositAddrSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ds.depositAddrSchemaName, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ds.coin.Name, pgDepositAddrStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating deposit addr table: %s", err)
+		return
+	}
+
+	// Now create the other schema (keeping track of pending deposits)
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ds.pendingDepositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup pending deposit tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ds.pendingDepositSchemaName, err)
+		return
+	}
+
+	createTableQuery = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ds.coin.Name, pgPendingDepositStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating pending deposit table: %s", err)
+		return
+	}
+
+	createTableQuery = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", depositDebtTable(ds.coin), pgDepositDebtStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating deposit debt table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ds *PGDepositStore) DestroyHandler() (err error) {
+	if ds.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new deposit store")
+		return
+	}
+	if err = ds.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing deposit store handler for DestroyHandler: %s", err)
+		return
+	}
+	ds.DBHandler = nil
+	return
+}
+
+// UpdateDeposits updates the deposits when a block comes in, and returns execs for deposits that are
+// now confirmed
+func (ds *PGDepositStore) UpdateDeposits(deposits []match.Deposit, blockheight uint64) (depositExecs []*match.SettlementExecution, err error) {
+
+	// first get debit asset
+	var depositAsset match.Asset
+	if depositAsset, err = match.AssetFromCoinParam(ds.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for UpdateDeposits: %s", err)
+		return
+	}
+
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateDeposits: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for UpdateDeposits: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the pending deposit schema
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for UpdateDeposits: %s", err)
+		return
+	}
+
+	depositExecs, err = updatePendingDeposits(tx, ds.coin.Name, depositAsset, deposits, blockheight)
+	return
+}
+
+// DisconnectBlocks rolls deposits back after a reorg, when every block above blockheight has been
+// disconnected, and returns execs taking back whatever had already been credited.
+func (ds *PGDepositStore) DisconnectBlocks(blockheight uint64) (rollbackExecs []*match.SettlementExecution, err error) {
+
+	// first get the asset we're taking back
+	var depositAsset match.Asset
+	if depositAsset, err = match.AssetFromCoinParam(ds.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for DisconnectBlocks: %s", err)
+		return
+	}
+
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for DisconnectBlocks: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for DisconnectBlocks: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for DisconnectBlocks: %s", err)
+		return
+	}
+
+	rollbackExecs, err = disconnectPendingDeposits(tx, ds.coin.Name, depositAsset, blockheight)
+	return
+}
+
+// GetDeposits gets every deposit for a pubkey, oldest first, including orphaned ones
+func (ds *PGDepositStore) GetDeposits(pubkey *koblitz.PublicKey) (deposits []*match.DepositStatus, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetDeposits: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for GetDeposits: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for GetDeposits: %s", err)
+		return
+	}
+
+	deposits, err = queryDeposits(tx, ds.coin.Name, pubkey)
+	return
+}
+
+// AddDepositDebt adds to what a pubkey owes for deposits that were reorged out after they were spent
+func (ds *PGDepositStore) AddDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for AddDepositDebt: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for AddDepositDebt: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for AddDepositDebt: %s", err)
+		return
+	}
+
+	err = addDepositDebt(tx, depositDebtTable(ds.coin), pubkey, amount)
+	return
+}
+
+// PayDepositDebt takes amount off what a pubkey owes for reorged deposits
+func (ds *PGDepositStore) PayDepositDebt(pubkey *koblitz.PublicKey, amount uint64) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PayDepositDebt: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PayDepositDebt: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for PayDepositDebt: %s", err)
+		return
+	}
+
+	err = payDepositDebt(tx, depositDebtTable(ds.coin), pubkey, amount)
+	return
+}
+
+// GetDepositDebt gets what a pubkey owes for reorged deposits
+func (ds *PGDepositStore) GetDepositDebt(pubkey *koblitz.PublicKey) (debt uint64, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetDepositDebt: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for GetDepositDebt: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(ds.pendingDepositSchemaName)); err != nil {
+		err = fmt.Errorf("Error using pending deposit schema for GetDepositDebt: %s", err)
+		return
+	}
+
+	debt, err = queryDepositDebt(tx, depositDebtTable(ds.coin), pubkey)
+	return
+}
+
+// GetDepositAddressMap gets a map of the deposit addresses we own to pubkeys
+func (ds *PGDepositStore) GetDepositAddressMap() (depAddrMap map[string]*koblitz.PublicKey, err error) {
+	depAddrMap = make(map[string]*koblitz.PublicKey)
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetDepositAddressMap: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for GetDepositAddressMap: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the deposit address schema
+	if _, err = tx.Exec(pgUseSchema(ds.depositAddrSchemaName)); err != nil {
+		err = fmt.Errorf("Error using deposit schema for GetDepositAddressMap: %s", err)
+		return
+	}
+
+	var rows *sql.Rows
+	selectAddrQuery := fmt.Sprintf("SELECT pubkey, address FROM %s;", ds.coin.Name)
+	if rows, err = tx.Query(selectAddrQuery); err != nil {
+		err = fmt.Errorf("Error querying for pubkey address map for GetDepositAddressMap: %s", err)
+		return
+	}
+
+	var currAddr string
+	var currPubkeyBytes []byte
+	for rows.Next() {
+		if err = rows.Scan(&currPubkeyBytes, &currAddr); err != nil {
+			err = fmt.Errorf("Error scanning for address for GetDepositAddressMap: %s", err)
+			return
+		}
+
+		if currPubkeyBytes, err = hex.DecodeString(string(currPubkeyBytes)); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for GetDepositAddressMap: %s", err)
+			return
+		}
+
+		if depAddrMap[currAddr], err = koblitz.ParsePubKey(currPubkeyBytes, koblitz.S256()); err != nil {
+			err = fmt.Errorf("Error parsing pub key from bytes for GetDepositAddressMap: %s", err)
+			return
+		}
+	}
+	if err = rows.Close(); err != nil {
+		err = fmt.Errorf("Error closing rows for GetDepositAddressMap: %s", err)
+		return
+	}
+	return
+}
+
+// GetDepositAddress gets the deposit address for a pubkey and an asset.
+func (ds *PGDepositStore) GetDepositAddress(pubkey *koblitz.PublicKey) (addr string, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetDepositAddress: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for GetDepositAddress: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the deposit address schema
+	if _, err = tx.Exec(pgUseSchema(ds.depositAddrSchemaName)); err != nil {
+		err = fmt.Errorf("Error using deposit schema for GetDepositAddress: %s", err)
+		return
+	}
+
+	selectAddrQuery := fmt.Sprintf("SELECT address FROM %s WHERE pubkey='%x';", ds.coin.Name, pubkey.SerializeCompressed())
+	// errors deferred to scan
+	if err = tx.QueryRow(selectAddrQuery).Scan(&addr); err != nil {
+		err = fmt.Errorf("Error scanning for address for GetDepositAddress: %s", err)
+		return
+	}
+
+	return
+}
+
+// RegisterUser takes in a pubkey, and an address for the pubkey, and puts the deposit address as the
+// value for the user's pubkey key
+func (ds *PGDepositStore) RegisterUser(pubkey *koblitz.PublicKey, address string) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = ds.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for RegisterUser: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for RegisterUser: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the deposit address schema
+	if _, err = tx.Exec(pgUseSchema(ds.depositAddrSchemaName)); err != nil {
+		err = fmt.Errorf("Error using deposit schema for RegisterUser: %s", err)
+		return
+	}
+
+	insertUserQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%s');", ds.coin.Name, pubkey.SerializeCompressed(), address)
+	if _, err = tx.Exec(insertUserQuery); err != nil {
+		err = fmt.Errorf("Error adding user and address for RegisterUser: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,263 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGHistoryStore is the postgres version of SQLHistoryStore, it keeps the history of every limit
+// order and every fill.
+type PGHistoryStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// history schema name
+	historySchema string
+}
+
+// The columns are the same as the mysql history tables, postgres just doesn't have unsigned
+// integers or inline indexes.
+const (
+	pgOrderHistorySchema = "seq BIGSERIAL PRIMARY KEY, orderID VARCHAR(64) NOT NULL UNIQUE, pubkey VARCHAR(66) NOT NULL, assetWant SMALLINT, assetHave SMALLINT, buy BOOLEAN, amountHave BIGINT, amountWant BIGINT, amountHaveFilled BIGINT, amountWantFilled BIGINT, status VARCHAR(32), placed BIGINT, updated BIGINT"
+	pgFillHistorySchema  = "seq BIGSERIAL PRIMARY KEY, orderID VARCHAR(64) NOT NULL, pubkey VARCHAR(66) NOT NULL, assetWant SMALLINT, assetHave SMALLINT, buy BOOLEAN, amountHave BIGINT, amountWant BIGINT, time BIGINT"
+)
+
+// CreatePGHistoryStoreStructWithConf creates a postgres history store, returning the struct
+// rather than the interface.
+func CreatePGHistoryStoreStructWithConf(conf *dbsqlConfig) (hs *PGHistoryStore, err error) {
+
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGHistoryStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Set values
+	hs = &PGHistoryStore{
+		dbUsername:    conf.DBUsername,
+		dbPassword:    conf.DBPassword,
+		dbName:        conf.DBName,
+		dbSSLMode:     conf.DBSSLMode,
+		historySchema: conf.HistorySchemaName,
+		dbAddr:        addr,
+	}
+
+	if err = hs.setupHistoryTables(); err != nil {
+		err = fmt.Errorf("Error setting up history tables while creating store: %s", err)
+		return
+	}
+
+	if hs.DBHandler, err = sql.Open(postgresDriver, pgOpenString(hs.dbUsername, hs.dbPassword, hs.dbAddr, hs.dbName, hs.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGHistoryStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = hs.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+	return
+}
+
+// setupHistoryTables sets up the tables needed for the history store.
+// This assumes the schema name is set
+func (hs *PGHistoryStore) setupHistoryTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(hs.dbUsername, hs.dbPassword, hs.dbAddr, hs.dbName, hs.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup history tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup history tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating history tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + hs.historySchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup history tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(hs.historySchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", hs.historySchema, err)
+		return
+	}
+
+	createOrdersQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", orderHistoryTable, pgOrderHistorySchema)
+	if _, err = tx.Exec(createOrdersQuery); err != nil {
+		err = fmt.Errorf("Error creating order history table: %s", err)
+		return
+	}
+
+	createFillsQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", fillHistoryTable, pgFillHistorySchema)
+	if _, err = tx.Exec(createFillsQuery); err != nil {
+		err = fmt.Errorf("Error creating fill history table: %s", err)
+		return
+	}
+
+	// history is always queried by pubkey, newest first
+	for _, table := range []string{orderHistoryTable, fillHistoryTable} {
+		createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_pubkey_seq ON %[1]s (pubkey, seq);", table)
+		if _, err = tx.Exec(createIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating index on %s: %s", table, err)
+			return
+		}
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (hs *PGHistoryStore) DestroyHandler() (err error) {
+	if hs.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new history store")
+		return
+	}
+	if err = hs.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing history store handler for DestroyHandler: %s", err)
+		return
+	}
+	hs.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the history schema. If the returned error is nil, the
+// caller has to call finishHistoryTx with its own error, which commits or rolls back.
+func (hs *PGHistoryStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = hs.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(hs.historySchema)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using history schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// RecordPlace adds a newly placed order to the history
+func (hs *PGHistoryStore) RecordPlace(limitIDPair *match.LimitOrderIDPair) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordPlace"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordPlace", err)
+	}()
+
+	err = recordHistoryPlace(tx, limitIDPair)
+	return
+}
+
+// RecordExec updates an order with an execution and adds the fill to the history
+func (hs *PGHistoryStore) RecordExec(orderExec *match.OrderExecution, execTime time.Time) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordExec"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordExec", err)
+	}()
+
+	err = recordHistoryExec(tx, orderExec, execTime)
+	return
+}
+
+// RecordCancel marks an order as cancelled
+func (hs *PGHistoryStore) RecordCancel(cancel *match.CancelledOrder, cancelTime time.Time) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordCancel"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordCancel", err)
+	}()
+
+	err = recordHistoryFinal(tx, cancel.OrderID, cancelTime, false)
+	return
+}
+
+// RecordExpire marks an order as expired
+func (hs *PGHistoryStore) RecordExpire(orderID *match.OrderID, expireTime time.Time) (err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("RecordExpire"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "RecordExpire", err)
+	}()
+
+	err = recordHistoryFinal(tx, orderID, expireTime, true)
+	return
+}
+
+// GetOrderHistory gets orders for a pubkey, newest first, along with the cursor for the next page.
+func (hs *PGHistoryStore) GetOrderHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("GetOrderHistory"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "GetOrderHistory", err)
+	}()
+
+	orders, nextCursor, err = queryOrderHistory(tx, pubkey, query)
+	return
+}
+
+// GetFillHistory gets fills for a pubkey, newest first, along with the cursor for the next page.
+func (hs *PGHistoryStore) GetFillHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error) {
+	var tx *sql.Tx
+	if tx, err = hs.begin("GetFillHistory"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishHistoryTx(tx, "GetFillHistory", err)
+	}()
+
+	fills, nextCursor, err = queryFillHistory(tx, pubkey, query)
+	return
+}
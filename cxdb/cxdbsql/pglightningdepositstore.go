@@ -0,0 +1,299 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGLightningDepositStore is the postgres version of SQLLightningDepositStore, it keeps
+// lightning deposits and invoices for every coin.
+type PGLightningDepositStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// deposit schema name
+	depositSchemaName string
+}
+
+// The columns are the same as the mysql lightning deposit and invoice tables, postgres just doesn't have
+// unsigned integers or inline indexes.
+const (
+	pgLightningDepositsSchema = "seq BIGSERIAL PRIMARY KEY, outpoint VARCHAR(160) NOT NULL, stateIdx BIGINT NOT NULL, pubkey VARCHAR(66) NOT NULL, asset SMALLINT, amount BIGINT, chanIdx BIGINT, channelAmount BIGINT, received BIGINT, UNIQUE (outpoint, stateIdx)"
+	pgInvoicesSchema          = "seq BIGSERIAL PRIMARY KEY, rhash VARCHAR(64) NOT NULL UNIQUE, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, asset SMALLINT, amount BIGINT, node VARCHAR(128) NOT NULL, expiry BIGINT, amountPaid BIGINT, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT"
+)
+
+// CreatePGLightningDepositStoreStructWithConf creates a postgres lightning deposit store,
+// returning the struct rather than the interface.
+func CreatePGLightningDepositStoreStructWithConf(conf *dbsqlConfig) (ls *PGLightningDepositStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGLightningDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	ls = &PGLightningDepositStore{
+		dbUsername:        conf.DBUsername,
+		dbPassword:        conf.DBPassword,
+		dbName:            conf.DBName,
+		dbSSLMode:         conf.DBSSLMode,
+		depositSchemaName: conf.DepositSchemaName,
+		dbAddr:            addr,
+	}
+
+	if err = ls.setupLightningDepositTables(); err != nil {
+		err = fmt.Errorf("Error setting up lightning deposit tables for CreatePGLightningDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	if ls.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ls.dbUsername, ls.dbPassword, ls.dbAddr, ls.dbName, ls.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGLightningDepositStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ls.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+	return
+}
+
+// setupLightningDepositTables sets up the lightning deposit table.
+// This assumes everything else is set
+func (ls *PGLightningDepositStore) setupLightningDepositTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ls.dbUsername, ls.dbPassword, ls.dbAddr, ls.dbName, ls.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup lightning deposit tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup lightning deposit tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating lightning deposit tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ls.depositSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup lightning deposit tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ls.depositSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ls.depositSchemaName, err)
+		return
+	}
+
+	createQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", lightningDepositsTable, pgLightningDepositsSchema)
+	if _, err = tx.Exec(createQuery); err != nil {
+		err = fmt.Errorf("Error creating lightning deposit table: %s", err)
+		return
+	}
+
+	// deposits are looked up by pubkey for users
+	createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_pubkey ON %[1]s (pubkey);", lightningDepositsTable)
+	if _, err = tx.Exec(createIndexQuery); err != nil {
+		err = fmt.Errorf("Error creating pubkey index on lightning deposit table: %s", err)
+		return
+	}
+
+	createInvoicesQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", invoicesTable, pgInvoicesSchema)
+	if _, err = tx.Exec(createInvoicesQuery); err != nil {
+		err = fmt.Errorf("Error creating invoice table: %s", err)
+		return
+	}
+
+	// invoices are looked up by pubkey for users, and by state every block
+	for _, column := range []string{"pubkey", "state"} {
+		createInvoiceIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_%[2]s ON %[1]s (%[2]s);", invoicesTable, column)
+		if _, err = tx.Exec(createInvoiceIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating %s index on invoice table: %s", column, err)
+			return
+		}
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ls *PGLightningDepositStore) DestroyHandler() (err error) {
+	if ls.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new lightning deposit store")
+		return
+	}
+	if err = ls.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing lightning deposit store handler for DestroyHandler: %s", err)
+		return
+	}
+	ls.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the deposit schema. If the returned error is nil, the
+// caller has to call finishLightningDepositTx with its own error, which commits or rolls back.
+func (ls *PGLightningDepositStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ls.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(ls.depositSchemaName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using deposit schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddLightningDeposit stores a deposit, added is false if there's already one for the channel and
+// state
+func (ls *PGLightningDepositStore) AddLightningDeposit(deposit *match.LightningDeposit) (added bool, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("AddLightningDeposit"); err != nil {
+		return
+	}
+	defer func() {
+		if err = finishLightningDepositTx(tx, "AddLightningDeposit", err); err != nil {
+			added = false
+		}
+	}()
+
+	added, err = insertLightningDeposit(tx, "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (outpoint, stateIdx) DO NOTHING;", deposit)
+	return
+}
+
+// GetLightningDeposits gets every deposit for a pubkey, oldest first
+func (ls *PGLightningDepositStore) GetLightningDeposits(pubkey *koblitz.PublicKey) (deposits []*match.LightningDeposit, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetLightningDeposits"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetLightningDeposits", err)
+	}()
+
+	deposits, err = queryLightningDeposits(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetChannelDeposits gets every deposit over a channel, oldest first
+func (ls *PGLightningDepositStore) GetChannelDeposits(outpoint string) (deposits []*match.LightningDeposit, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetChannelDeposits"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetChannelDeposits", err)
+	}()
+
+	deposits, err = queryLightningDeposits(tx, fmt.Sprintf("outpoint='%x'", outpoint))
+	return
+}
+
+// AddInvoice stores a new invoice
+func (ls *PGLightningDepositStore) AddInvoice(invoice *match.Invoice) (err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("AddInvoice"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "AddInvoice", err)
+	}()
+
+	err = insertInvoice(tx, invoice)
+	return
+}
+
+// UpdateInvoice saves the amount paid, state, reason and update time of a stored invoice
+func (ls *PGLightningDepositStore) UpdateInvoice(invoice *match.Invoice) (err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("UpdateInvoice"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "UpdateInvoice", err)
+	}()
+
+	err = updateInvoice(tx, invoice)
+	return
+}
+
+// GetInvoice gets an invoice by its hash
+func (ls *PGLightningDepositStore) GetInvoice(rhash [32]byte) (invoice *match.Invoice, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetInvoice"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetInvoice", err)
+	}()
+
+	invoice, err = getInvoice(tx, rhash)
+	return
+}
+
+// GetInvoices gets every invoice for a pubkey, oldest first
+func (ls *PGLightningDepositStore) GetInvoices(pubkey *koblitz.PublicKey) (invoices []*match.Invoice, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetInvoices"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetInvoices", err)
+	}()
+
+	invoices, err = queryInvoices(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetInvoicesByState gets every invoice in a state, oldest first
+func (ls *PGLightningDepositStore) GetInvoicesByState(state match.InvoiceState) (invoices []*match.Invoice, err error) {
+	var tx *sql.Tx
+	if tx, err = ls.begin("GetInvoicesByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishLightningDepositTx(tx, "GetInvoicesByState", err)
+	}()
+
+	invoices, err = queryInvoicesByState(tx, state)
+	return
+}
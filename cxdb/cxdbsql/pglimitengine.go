@@ -0,0 +1,464 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/crypto/sha3"
+)
+
+// PGLimitEngine is a struct that represents a limit matching engine with postgres as a db backend
+type PGLimitEngine struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// orderbook schema name
+	orderSchema string
+
+	// this pair
+	pair *match.Pair
+}
+
+// The postgres schema for the limit orderbook. The price is calculated from priceWant and priceHave.
+const (
+	pgLimitEngineSchema = "pubkey VARCHAR(66), orderID VARCHAR(64), side TEXT, priceWant BIGINT, priceHave BIGINT, amountHave BIGINT, amountWant BIGINT, time TIMESTAMP, PRIMARY KEY (orderID)"
+)
+
+// CreatePGLimEngineStructWithConf creates a postgres limit engine, returning the struct rather than the interface.
+func CreatePGLimEngineStructWithConf(pair *match.Pair, conf *dbsqlConfig) (engine *PGLimitEngine, err error) {
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGLimEngineStructWithConf: %s", err)
+		return
+	}
+
+	// Set values
+	le := &PGLimitEngine{
+		dbUsername:  conf.DBUsername,
+		dbPassword:  conf.DBPassword,
+		dbName:      conf.DBName,
+		dbSSLMode:   conf.DBSSLMode,
+		orderSchema: conf.OrderSchemaName,
+		dbAddr:      addr,
+		pair:        pair,
+	}
+
+	if err = le.setupLimitOrderbookTables(); err != nil {
+		err = fmt.Errorf("Error setting up limit orderbook tables while creating pg engine: %s", err)
+		return
+	}
+
+	if le.DBHandler, err = sql.Open(postgresDriver, pgOpenString(le.dbUsername, le.dbPassword, le.dbAddr, le.dbName, le.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGLimEngineStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = le.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running? Did you set the username and password in sqldb.conf: %s", err)
+		return
+	}
+
+	// now we actually set the return, all checks have passed
+	engine = le
+	return
+}
+
+// setupLimitOrderbookTables sets up the tables needed for the limit orderbook.
+// This assumes everything else is set
+func (le *PGLimitEngine) setupLimitOrderbookTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(le.dbUsername, le.dbPassword, le.dbAddr, le.dbName, le.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup limit tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup limit tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while matching setup limit tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + le.orderSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup limit order tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(le.orderSchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", le.orderSchema, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", le.pair.String(), pgLimitEngineSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating limit orderbook table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (le *PGLimitEngine) DestroyHandler() (err error) {
+	if le.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new engine")
+		return
+	}
+	if err = le.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing engine handler for DestroyHandler: %s", err)
+		return
+	}
+	le.DBHandler = nil
+	return
+}
+
+// PlaceLimitOrder places an order in the limit matching engine.
+// This assumes that the order is valid and is for the same pair as the matching engine
+func (le *PGLimitEngine) PlaceLimitOrder(order *match.LimitOrder) (idRes *match.LimitOrderIDPair, err error) {
+	return le.PlaceLimitOrderAt(order, time.Now())
+}
+
+// PlaceLimitOrderAt places an order in the limit matching engine as if it were placed at
+// placementTime.
+func (le *PGLimitEngine) PlaceLimitOrderAt(order *match.LimitOrder, placementTime time.Time) (idRes *match.LimitOrderIDPair, err error) {
+	if order == nil {
+		err = fmt.Errorf("Cannot place nil order, please enter valid input")
+		return
+	}
+
+	if le.pair == nil {
+		err = fmt.Errorf("Cannot place order with nil pair, please enter valid input")
+		return
+	}
+
+	if le.DBHandler == nil {
+		err = fmt.Errorf("Cannot place order with nil DBHandler, please set up limit engine correctly")
+		return
+	}
+
+	placementTimeFormatted := placementTime.Format(sqlTimeFormat)
+
+	// Do these first so we don't have to rollback any tx's if they're wrong
+	// hash order so we can use that as a primary key
+	hasher := sha3.New256()
+	var orderBytes []byte
+	if orderBytes, err = order.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing while placing order: %s", err)
+		return
+	}
+	hasher.Write(orderBytes)
+	hashedOrder := hasher.Sum(nil)
+
+	// calculate price, this also makes sure neither amount is zero
+	var price float64
+	if price, err = order.Price(); err != nil {
+		err = fmt.Errorf("Error getting price from order while placing order: %s", err)
+		return
+	}
+
+	// Finally, set the auction order / id pair
+	loid := &match.LimitOrderIDPair{
+		OrderID:   new(match.OrderID),
+		Order:     order,
+		Price:     price,
+		Timestamp: placementTime,
+	}
+
+	if err = loid.OrderID.UnmarshalBinary(hashedOrder); err != nil {
+		err = fmt.Errorf("Could not unmarshal orderid for PlaceLimitOrder: %s", err)
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = le.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while placing order: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while placing order: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(le.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema while placing limit order: %s", err)
+		return
+	}
+
+	placeOrderQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%x', '%s', %d, %d, %d, %d, '%s');", le.pair.String(), order.Pubkey[:], hashedOrder, order.Side.String(), order.AmountWant, order.AmountHave, order.AmountHave, order.AmountWant, placementTimeFormatted)
+	if _, err = tx.Exec(placeOrderQuery); err != nil {
+		err = fmt.Errorf("Error placing order into db for PlaceLimitOrder: %s", err)
+		return
+	}
+
+	idRes = loid
+	return
+}
+
+// CancelLimitOrder cancels a limit order, this assumes that the limit order actually exists
+func (le *PGLimitEngine) CancelLimitOrder(orderID *match.OrderID) (cancelled *match.CancelledOrder, cancelSettlement *match.SettlementExecution, err error) {
+
+	var tx *sql.Tx
+	if tx, err = le.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for CancelLimitOrder: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for CancelLimitOrder: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+		return
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(le.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema while cancelling limit order: %s", err)
+		return
+	}
+
+	var row *sql.Row
+	selectOrderQuery := fmt.Sprintf("SELECT pubkey, side, amountHave FROM %s WHERE orderID = '%x' FOR UPDATE;", le.pair.String(), orderID[:])
+	// errors deferred to scan
+	row = tx.QueryRow(selectOrderQuery)
+
+	var pkBytes []byte
+	var orderSide string
+	var remainingHave uint64
+	if err = row.Scan(&pkBytes, &orderSide, &remainingHave); err != nil {
+		err = fmt.Errorf("Error scanning for order for CancelLimitOrder: %s", err)
+		return
+	}
+
+	// decode them all weirdly because we store the bytes as hex
+	if pkBytes, err = hex.DecodeString(string(pkBytes)); err != nil {
+		err = fmt.Errorf("Error decoding pkBytes for CancelLimitOrder: %s", err)
+		return
+	}
+
+	deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE orderID = '%x';", le.pair.String(), orderID[:])
+	if _, err = tx.Exec(deleteOrderQuery); err != nil {
+		err = fmt.Errorf("Error deleting order for CancelLimitOrder: %s", err)
+		return
+	}
+
+	cancelled = &match.CancelledOrder{
+		OrderID: orderID,
+	}
+	var debitAsset match.Asset
+	if orderSide == match.Buy.String() {
+		debitAsset = le.pair.AssetHave
+	} else {
+		debitAsset = le.pair.AssetWant
+	}
+	cancelSettlement = &match.SettlementExecution{
+		Amount: remainingHave,
+		Type:   match.Debit,
+		Asset:  debitAsset,
+	}
+	copy(cancelSettlement.Pubkey[:], pkBytes)
+
+	return
+}
+
+// MatchLimitOrders matches limit orders based on price/time priority
+func (le *PGLimitEngine) MatchLimitOrders() (orderExecs []*match.OrderExecution, settlementExecs []*match.SettlementExecution, err error) {
+	if le.DBHandler == nil {
+		err = fmt.Errorf("Cannot match orders for nil handler, please recreate engine")
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = le.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for MatchLimitOrders: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for MatchLimitOrders: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+		return
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(le.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema while matching limit orders: %s", err)
+		return
+	}
+
+	// We can't FOR UPDATE the aggregates, so lock out every other writer for the whole match
+	if _, err = tx.Exec(fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE;", le.pair.String())); err != nil {
+		err = fmt.Errorf("Error locking orderbook table for MatchLimitOrders: %s", err)
+		return
+	}
+
+	sellSide := match.Sell
+	buySide := match.Buy
+
+	// First get the max sell price and min buy price
+	var maxSellSqlNullable sql.NullFloat64
+	getMaxSellPrice := fmt.Sprintf("SELECT MAX%s FROM %s WHERE side='%s';", pgPriceExpr, le.pair.String(), sellSide.String())
+	if err = tx.QueryRow(getMaxSellPrice).Scan(&maxSellSqlNullable); err != nil {
+		err = fmt.Errorf("Error scanning max sell row: %s", err)
+		return
+	}
+
+	// if nothing came back (no max) then there's nothing to match
+	if !maxSellSqlNullable.Valid {
+		return
+	}
+
+	var minBuySqlNullable sql.NullFloat64
+	getMinBuyPrice := fmt.Sprintf("SELECT MIN%s FROM %s WHERE side='%s';", pgPriceExpr, le.pair.String(), buySide.String())
+	if err = tx.QueryRow(getMinBuyPrice).Scan(&minBuySqlNullable); err != nil {
+		err = fmt.Errorf("Error scanning min buy row: %s", err)
+		return
+	}
+
+	// if nothing came back (no min) then there's nothing to match
+	if !minBuySqlNullable.Valid {
+		return
+	}
+
+	// In our prices, if the min buy < max sell, we start to match orders. Otherwise, we can just quit.
+	if minBuySqlNullable.Float64 > maxSellSqlNullable.Float64 {
+		return
+	}
+
+	// this will select all sell side, ordered by price descending and time ascending.
+	// The bounds are subqueries so the prices never have to make a round trip through a float.
+	getSellSideQuery := fmt.Sprintf("SELECT pubkey, %[1]s AS price, orderID, amountHave, amountWant, time FROM %[2]s WHERE side='%[3]s' AND %[1]s >= (SELECT MIN%[1]s FROM %[2]s WHERE side='%[4]s') ORDER BY price DESC, time ASC;", pgPriceExpr, le.pair.String(), sellSide.String(), buySide.String())
+	var sellOrders []*match.LimitOrderIDPair
+	if sellOrders, err = le.getOrderSideTx(tx, getSellSideQuery, sellSide); err != nil {
+		err = fmt.Errorf("Error getting sell orders for MatchLimitOrders: %s", err)
+		return
+	}
+
+	// this will select all buy side, ordered by price ascending and time ascending.
+	getBuySideQuery := fmt.Sprintf("SELECT pubkey, %[1]s AS price, orderID, amountHave, amountWant, time FROM %[2]s WHERE side='%[3]s' AND %[1]s <= (SELECT MAX%[1]s FROM %[2]s WHERE side='%[4]s') ORDER BY price ASC, time ASC;", pgPriceExpr, le.pair.String(), buySide.String(), sellSide.String())
+	var buyOrders []*match.LimitOrderIDPair
+	if buyOrders, err = le.getOrderSideTx(tx, getBuySideQuery, buySide); err != nil {
+		err = fmt.Errorf("Error getting buy orders for MatchLimitOrders: %s", err)
+		return
+	}
+
+	if orderExecs, settlementExecs, err = match.MatchPrioritizedOrders(buyOrders, sellOrders); err != nil {
+		err = fmt.Errorf("Error matching prioritized orders for MatchLimitOrders: %s", err)
+		return
+	}
+
+	// Update the matching engine with the new state because that's what we do
+	for _, orderExec := range orderExecs {
+		if orderExec.Filled {
+			deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE orderID='%x';", le.pair.String(), orderExec.OrderID[:])
+			if _, err = tx.Exec(deleteOrderQuery); err != nil {
+				err = fmt.Errorf("Error deleting filled order for MatchLimitOrders: %s", err)
+				return
+			}
+		} else {
+			updateOrderExecQuery := fmt.Sprintf("UPDATE %s SET amountWant=%d, amountHave=%d WHERE orderID='%x';", le.pair.String(), orderExec.NewAmountWant, orderExec.NewAmountHave, orderExec.OrderID[:])
+			if _, err = tx.Exec(updateOrderExecQuery); err != nil {
+				err = fmt.Errorf("Error updating order for order exec for MatchLimitOrders: %s", err)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// getOrderSideTx runs a query that selects pubkey, price, orderID, amountHave, amountWant, time for
+// a single side, and returns the orders in the order that they were returned.
+func (le *PGLimitEngine) getOrderSideTx(tx *sql.Tx, query string, side match.Side) (orders []*match.LimitOrderIDPair, err error) {
+	var rows *sql.Rows
+	if rows, err = tx.Query(query); err != nil {
+		err = fmt.Errorf("Error querying for %s orders: %s", side.String(), err)
+		return
+	}
+
+	defer func() {
+		var newErr error
+		if newErr = rows.Close(); newErr != nil && err == nil {
+			err = fmt.Errorf("Error closing %s rows: %s", side.String(), newErr)
+			return
+		}
+		return
+	}()
+
+	for rows.Next() {
+		var pubkeyBytes []byte
+		var orderIDBytes []byte
+		orderIDPair := &match.LimitOrderIDPair{
+			Order:   new(match.LimitOrder),
+			OrderID: new(match.OrderID),
+		}
+		if err = rows.Scan(&pubkeyBytes, &orderIDPair.Price, &orderIDBytes, &orderIDPair.Order.AmountHave, &orderIDPair.Order.AmountWant, &orderIDPair.Timestamp); err != nil {
+			err = fmt.Errorf("Error scanning %s rows: %s", side.String(), err)
+			return
+		}
+
+		if pubkeyBytes, err = hex.DecodeString(string(pubkeyBytes)); err != nil {
+			err = fmt.Errorf("Error decoding hex for %s pubkey: %s", side.String(), err)
+			return
+		}
+
+		if err = orderIDPair.OrderID.UnmarshalText(orderIDBytes); err != nil {
+			err = fmt.Errorf("Error unmarshalling %s order id: %s", side.String(), err)
+			return
+		}
+
+		orderIDPair.Order.TradingPair = *le.pair
+		orderIDPair.Order.Side = side
+		copy(orderIDPair.Order.Pubkey[:], pubkeyBytes)
+		orders = append(orders, orderIDPair)
+	}
+
+	return
+}
@@ -0,0 +1,482 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGLimitOrderbook is the representation of a limit orderbook for postgres
+type PGLimitOrderbook struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// orderbook schema name
+	orderSchema string
+
+	// this pair
+	pair *match.Pair
+}
+
+// The postgres schema for the limit orderbook
+const (
+	pgLimitOrderbookSchema = "pubkey VARCHAR(66), orderID VARCHAR(64), side TEXT, priceWant BIGINT, priceHave BIGINT, amountHave BIGINT, amountWant BIGINT, time TIMESTAMP, PRIMARY KEY (orderID)"
+)
+
+// CreatePGLimitOrderbookStructWithConf creates a postgres limit orderbook based on a pair, returning the struct.
+func CreatePGLimitOrderbookStructWithConf(pair *match.Pair, conf *dbsqlConfig) (book *PGLimitOrderbook, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGLimitOrderbookStructWithConf: %s", err)
+		return
+	}
+
+	// Set values for limit orderbook
+	lo := &PGLimitOrderbook{
+		dbUsername:  conf.DBUsername,
+		dbPassword:  conf.DBPassword,
+		dbName:      conf.DBName,
+		dbSSLMode:   conf.DBSSLMode,
+		orderSchema: conf.ReadOnlyOrderSchemaName,
+		dbAddr:      addr,
+		pair:        pair,
+	}
+
+	if err = lo.setupLimitOrderbookTables(); err != nil {
+		err = fmt.Errorf("Error setting up limit orderbook tables while creating pg orderbook: %s", err)
+		return
+	}
+
+	if lo.DBHandler, err = sql.Open(postgresDriver, pgOpenString(lo.dbUsername, lo.dbPassword, lo.dbAddr, lo.dbName, lo.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGLimitOrderbookStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = lo.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// Actually set the return
+	book = lo
+	return
+}
+
+// setupLimitOrderbookTables sets up the tables needed for the limit orderbook.
+// This assumes everything else is set
+func (lo *PGLimitOrderbook) setupLimitOrderbookTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(lo.dbUsername, lo.dbPassword, lo.dbAddr, lo.dbName, lo.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup limit tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup limit tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while matching setup limit tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + lo.orderSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup limit order tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", lo.orderSchema, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", lo.pair.String(), pgLimitOrderbookSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating limit orderbook table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (lo *PGLimitOrderbook) DestroyHandler() (err error) {
+	if lo.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new orderbook")
+		return
+	}
+	if err = lo.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing orderbook handler for DestroyHandler: %s", err)
+		return
+	}
+	lo.DBHandler = nil
+	return
+}
+
+// UpdateBookExec takes in an order execution and updates the orderbook.
+func (lo *PGLimitOrderbook) UpdateBookExec(orderExec *match.OrderExecution) (err error) {
+	// doing a bunch of stuff in a tx because ACID
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateBookExec: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while running UpdateBookExec: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the limit schema
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using limit schema to UpdateBookExec: %s", err)
+		return
+	}
+
+	// If the order was filled then delete it. If not then update it.
+	if orderExec.Filled {
+		deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE orderID='%x';", lo.pair.String(), orderExec.OrderID[:])
+		if _, err = tx.Exec(deleteOrderQuery); err != nil {
+			err = fmt.Errorf("Error deleting order within tx for UpdateBookExec: %s", err)
+			return
+		}
+	} else {
+		updateOrderQuery := fmt.Sprintf("UPDATE %s SET amountHave=%d, amountWant=%d WHERE orderID='%x';", lo.pair.String(), orderExec.NewAmountHave, orderExec.NewAmountWant, orderExec.OrderID[:])
+		if _, err = tx.Exec(updateOrderQuery); err != nil {
+			err = fmt.Errorf("Error updating order within tx for UpdateBookExec: %s", err)
+			return
+		}
+	}
+	return
+}
+
+// UpdateBookCancel takes in an order cancellation and updates the orderbook.
+func (lo *PGLimitOrderbook) UpdateBookCancel(cancel *match.CancelledOrder) (err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateBookCancel: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with UpdateBookCancel: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the limit schema
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using limit schema for UpdateBookCancel: %s", err)
+		return
+	}
+
+	deleteOrderQuery := fmt.Sprintf("DELETE FROM %s WHERE orderID='%x';", lo.pair.String(), cancel.OrderID[:])
+	var res sql.Result
+	if res, err = tx.Exec(deleteOrderQuery); err != nil {
+		err = fmt.Errorf("Error deleting order within tx for cancel: %s", err)
+		return
+	}
+
+	var rowsAffected int64
+	if rowsAffected, err = res.RowsAffected(); err != nil {
+		err = fmt.Errorf("Error while getting rows affected for cancel: %s", err)
+		return
+	}
+	if rowsAffected != 1 {
+		err = fmt.Errorf("Error: Order cancel should only have affected one row. Instead, it affected %d", rowsAffected)
+		return
+	}
+	return
+}
+
+// UpdateBookPlace takes in an order, ID, timestamp, and adds the order to the orderbook.
+func (lo *PGLimitOrderbook) UpdateBookPlace(limitIDPair *match.LimitOrderIDPair) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for UpdateBookPlace: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for UpdateBookPlace: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the order schema
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema for UpdateBookPlace: %s", err)
+		return
+	}
+
+	insertOrderQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%x', '%s', %d, %d, %d, %d, '%s');", lo.pair.String(), limitIDPair.Order.Pubkey[:], limitIDPair.OrderID[:], limitIDPair.Order.Side.String(), limitIDPair.Order.AmountWant, limitIDPair.Order.AmountHave, limitIDPair.Order.AmountHave, limitIDPair.Order.AmountWant, limitIDPair.Timestamp.Format(sqlTimeFormat))
+	if _, err = tx.Exec(insertOrderQuery); err != nil {
+		err = fmt.Errorf("Error placing order into db for UpdateBookPlace: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetOrder gets an order from an OrderID
+func (lo *PGLimitOrderbook) GetOrder(orderID *match.OrderID) (limOrder *match.LimitOrderIDPair, err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetOrder: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with GetOrder: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema for GetOrder: %s", err)
+		return
+	}
+
+	var orders []*match.LimitOrderIDPair
+	getOrderQuery := fmt.Sprintf("SELECT pubkey, side, %s, orderID, amountHave, amountWant, time FROM %s WHERE orderID='%x';", pgPriceExpr, lo.pair.String(), orderID[:])
+	if orders, err = lo.getOrdersTx(tx, getOrderQuery); err != nil {
+		err = fmt.Errorf("Error getting order for GetOrder: %s", err)
+		return
+	}
+
+	if len(orders) != 1 {
+		err = fmt.Errorf("Error, expected one order for id %x but found %d", orderID[:], len(orders))
+		return
+	}
+
+	limOrder = orders[0]
+	return
+}
+
+// CalculatePrice returns the calculated price based on the orderbook. This is based on the midpoint of the spread.
+func (lo *PGLimitOrderbook) CalculatePrice() (price float64, err error) {
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for CalculatePrice: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with CalculatePrice: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the order schema
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema for limit CalculatePrice: %s", err)
+		return
+	}
+
+	// First get the max sell price and min buy price
+	var maxSell sql.NullFloat64
+	getMaxSellPrice := fmt.Sprintf("SELECT MAX%s FROM %s WHERE side='%s';", pgPriceExpr, lo.pair.String(), match.Sell.String())
+	if err = tx.QueryRow(getMaxSellPrice).Scan(&maxSell); err != nil {
+		err = fmt.Errorf("Error scanning max sell row for limit CalculatePrice: %s", err)
+		return
+	}
+
+	var minBuy sql.NullFloat64
+	getMinBuyPrice := fmt.Sprintf("SELECT MIN%s FROM %s WHERE side='%s';", pgPriceExpr, lo.pair.String(), match.Buy.String())
+	if err = tx.QueryRow(getMinBuyPrice).Scan(&minBuy); err != nil {
+		err = fmt.Errorf("Error scanning min buy row for limit CalculatePrice: %s", err)
+		return
+	}
+
+	// an empty side is treated as zero, same as the mysql orderbook
+	price = (minBuy.Float64 + maxSell.Float64) / 2
+	return
+}
+
+// GetOrdersForPubkey gets orders for a specific pubkey.
+func (lo *PGLimitOrderbook) GetOrdersForPubkey(pubkey *koblitz.PublicKey) (orders map[float64][]*match.LimitOrderIDPair, err error) {
+	// Make the book!!!!
+	orders = make(map[float64][]*match.LimitOrderIDPair)
+
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with GetOrdersForPubkey: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	var orderList []*match.LimitOrderIDPair
+	getOrdersQuery := fmt.Sprintf("SELECT pubkey, side, %s, orderID, amountHave, amountWant, time FROM %s WHERE pubkey='%x';", pgPriceExpr, lo.pair.String(), pubkey.SerializeCompressed())
+	if orderList, err = lo.getOrdersTx(tx, getOrdersQuery); err != nil {
+		err = fmt.Errorf("Error getting orders for GetOrdersForPubkey: %s", err)
+		return
+	}
+
+	for _, order := range orderList {
+		orders[order.Price] = append(orders[order.Price], order)
+	}
+	return
+}
+
+// ViewLimitOrderBook returns the orderbook as a map
+func (lo *PGLimitOrderbook) ViewLimitOrderBook() (book map[float64][]*match.LimitOrderIDPair, err error) {
+	// Make the book!!!!
+	book = make(map[float64][]*match.LimitOrderIDPair)
+
+	// Transaction so we're acid
+	var tx *sql.Tx
+	if tx, err = lo.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewLimitOrderBook: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error with ViewLimitOrderBook: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(lo.orderSchema)); err != nil {
+		err = fmt.Errorf("Error using order schema for ViewLimitOrderBook: %s", err)
+		return
+	}
+
+	var orderList []*match.LimitOrderIDPair
+	getOrdersQuery := fmt.Sprintf("SELECT pubkey, side, %s, orderID, amountHave, amountWant, time FROM %s;", pgPriceExpr, lo.pair.String())
+	if orderList, err = lo.getOrdersTx(tx, getOrdersQuery); err != nil {
+		err = fmt.Errorf("Error getting orders for ViewLimitOrderBook: %s", err)
+		return
+	}
+
+	for _, order := range orderList {
+		book[order.Price] = append(book[order.Price], order)
+	}
+	return
+}
+
+// getOrdersTx runs a query that selects pubkey, side, price, orderID, amountHave, amountWant, time
+// and turns the rows into orders.
+func (lo *PGLimitOrderbook) getOrdersTx(tx *sql.Tx, query string) (orders []*match.LimitOrderIDPair, err error) {
+	var rows *sql.Rows
+	if rows, err = tx.Query(query); err != nil {
+		err = fmt.Errorf("Error querying for orders: %s", err)
+		return
+	}
+
+	defer func() {
+		var newErr error
+		if newErr = rows.Close(); newErr != nil && err == nil {
+			err = fmt.Errorf("Error closing rows: %s", newErr)
+			return
+		}
+		return
+	}()
+
+	// we create these here so we don't take up a ton of memory allocating space for new intermediate arrays
+	var pkBytes []byte
+	var hashedOrderBytes []byte
+	var sideString string
+	for rows.Next() {
+		thisOrderPair := &match.LimitOrderIDPair{
+			Order:   new(match.LimitOrder),
+			OrderID: new(match.OrderID),
+		}
+		if err = rows.Scan(&pkBytes, &sideString, &thisOrderPair.Price, &hashedOrderBytes, &thisOrderPair.Order.AmountHave, &thisOrderPair.Order.AmountWant, &thisOrderPair.Timestamp); err != nil {
+			err = fmt.Errorf("Error scanning into order: %s", err)
+			return
+		}
+
+		// decode them all weirdly because we store the bytes as hex
+		if pkBytes, err = hex.DecodeString(string(pkBytes)); err != nil {
+			err = fmt.Errorf("Error decoding pubkey bytes: %s", err)
+			return
+		}
+
+		if err = thisOrderPair.OrderID.UnmarshalText(hashedOrderBytes); err != nil {
+			err = fmt.Errorf("Error unmarshalling order ID: %s", err)
+			return
+		}
+
+		// Copy all of the bytes and values
+		copy(thisOrderPair.Order.Pubkey[:], pkBytes)
+		thisOrderPair.Order.TradingPair = *lo.pair
+		thisOrderPair.Order.Side = sideString == match.Buy.String()
+		orders = append(orders, thisOrderPair)
+	}
+
+	return
+}
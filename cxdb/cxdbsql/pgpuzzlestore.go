@@ -0,0 +1,583 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGPuzzleStore is a puzzle store representation for a postgres database
+type PGPuzzleStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// puzzle schema name
+	puzzleSchema string
+
+	// the pair for this puzzle store
+	pair *match.Pair
+}
+
+const (
+	pgPuzzleStoreSchema   = "encodedOrder TEXT, auctionID VARCHAR(64), selected BOOLEAN"
+	pgTranscriptSchema    = "auctionID VARCHAR(64) PRIMARY KEY, encodedTranscript TEXT"
+	pgAuctionStatusSchema = "receiptID VARCHAR(64) PRIMARY KEY, state VARCHAR(32), updated BIGINT, encodedStatus TEXT"
+	pgAuctionResultSchema = "auctionID VARCHAR(64) PRIMARY KEY, ended BIGINT, encodedResult TEXT"
+)
+
+// CreatePGPuzzleStoreStructWithConf creates a postgres puzzle store for a specific pair, returning
+// the struct rather than the interface.
+func CreatePGPuzzleStoreStructWithConf(pair *match.Pair, conf *dbsqlConfig) (sp *PGPuzzleStore, err error) {
+
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGPuzzleStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Set values
+	sp = &PGPuzzleStore{
+		dbUsername:   conf.DBUsername,
+		dbPassword:   conf.DBPassword,
+		dbName:       conf.DBName,
+		dbSSLMode:    conf.DBSSLMode,
+		puzzleSchema: conf.PuzzleSchemaName,
+		dbAddr:       addr,
+		pair:         pair,
+	}
+
+	if err = sp.setupPuzzleStoreTables(); err != nil {
+		err = fmt.Errorf("Error setting up puzzle store tables while creating store: %s", err)
+		return
+	}
+
+	if sp.DBHandler, err = sql.Open(postgresDriver, pgOpenString(sp.dbUsername, sp.dbPassword, sp.dbAddr, sp.dbName, sp.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGPuzzleStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = sp.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// ViewAuctionPuzzleBook takes in an auction ID, and returns encrypted auction orders, and puzzles.
+// This only selects "selected" orders, so while some could be censored and not shown, there is currently
+// no functionality for censoring orders and setting the 'selected' flag to false.
+func (sp *PGPuzzleStore) ViewAuctionPuzzleBook(auctionID *match.AuctionID) (puzzles []*match.EncryptedAuctionOrder, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewAuctionPuzzleBook: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewAuctionPuzzleBook: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the puzzle schema
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewAuctionPuzzleBook: %s", err)
+		return
+	}
+
+	var serializedPuzzle []byte
+	var rows *sql.Rows
+	getPuzzleBookQuery := fmt.Sprintf("SELECT encodedOrder FROM %s WHERE auctionID='%x' AND selected=%t;", sp.pair.String(), auctionID[:], true)
+	if rows, err = tx.Query(getPuzzleBookQuery); err != nil {
+		err = fmt.Errorf("Error querying for puzzles for ViewAuctionPuzzleBook: %s", err)
+		return
+	}
+
+	var currPuzzle *match.EncryptedAuctionOrder
+	for rows.Next() {
+		if err = rows.Scan(&serializedPuzzle); err != nil {
+			err = fmt.Errorf("Error scanning puzzle for ViewAuctionPuzzleBook: %s", err)
+			return
+		}
+
+		if serializedPuzzle, err = hex.DecodeString(string(serializedPuzzle)); err != nil {
+			err = fmt.Errorf("Error decoding hex string serializedPuzzle for ViewAuctionPuzzleBook: %s", err)
+			return
+		}
+
+		// Just deserialize
+		currPuzzle = new(match.EncryptedAuctionOrder)
+		if err = currPuzzle.Deserialize(serializedPuzzle); err != nil {
+			err = fmt.Errorf("Error deserializing current puzzle for ViewAuctionPuzzleBook: %s", err)
+			return
+		}
+
+		puzzles = append(puzzles, currPuzzle)
+	}
+	if err = rows.Close(); err != nil {
+		err = fmt.Errorf("Error closing rows for ViewAuctionPuzzleBook: %s", err)
+		return
+	}
+
+	return
+}
+
+// PlaceAuctionPuzzle puts an encrypted auction order in the datastore.
+func (sp *PGPuzzleStore) PlaceAuctionPuzzle(puzzledOrder *match.EncryptedAuctionOrder) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PlaceAuctionPuzzle: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// First use the puzzle schema
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+
+	var pzOrderBytes []byte
+	if pzOrderBytes, err = puzzledOrder.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing puzzled order for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+
+	defaultSelected := true
+	insertPuzzleQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%x', %t);", sp.pair.String(), pzOrderBytes, puzzledOrder.IntendedAuction[:], defaultSelected)
+	if _, err = tx.Exec(insertPuzzleQuery); err != nil {
+		err = fmt.Errorf("Error placing puzzle into db for PlaceAuctionPuzzle: %s", err)
+		return
+	}
+	return
+}
+
+// PutTranscript stores the transcript for an auction, replacing the one that was there.
+func (sp *PGPuzzleStore) PutTranscript(transcript *match.Transcript) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PutTranscript: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PutTranscript: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PutTranscript: %s", err)
+		return
+	}
+
+	var transcriptBytes []byte
+	if transcriptBytes, err = transcript.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing transcript for PutTranscript: %s", err)
+		return
+	}
+
+	putTranscriptQuery := fmt.Sprintf("INSERT INTO %s (auctionID, encodedTranscript) VALUES ('%x', '%x') ON CONFLICT (auctionID) DO UPDATE SET encodedTranscript = EXCLUDED.encodedTranscript;", sp.transcriptTable(), transcript.BatchId[:], transcriptBytes)
+	if _, err = tx.Exec(putTranscriptQuery); err != nil {
+		err = fmt.Errorf("Error putting transcript into db for PutTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// ViewTranscript returns the transcript for an auction, or an error if there isn't one
+func (sp *PGPuzzleStore) ViewTranscript(auctionID *match.AuctionID) (transcript *match.Transcript, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewTranscript: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewTranscript: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewTranscript: %s", err)
+		return
+	}
+
+	var encodedTranscript string
+	getTranscriptQuery := fmt.Sprintf("SELECT encodedTranscript FROM %s WHERE auctionID='%x';", sp.transcriptTable(), auctionID[:])
+	if err = tx.QueryRow(getTranscriptQuery).Scan(&encodedTranscript); err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("No transcript for auction %x", auctionID[:])
+			return
+		}
+		err = fmt.Errorf("Error querying for transcript for ViewTranscript: %s", err)
+		return
+	}
+
+	var transcriptBytes []byte
+	if transcriptBytes, err = hex.DecodeString(encodedTranscript); err != nil {
+		err = fmt.Errorf("Error decoding hex transcript for ViewTranscript: %s", err)
+		return
+	}
+
+	transcript = new(match.Transcript)
+	if err = transcript.Deserialize(transcriptBytes); err != nil {
+		err = fmt.Errorf("Error deserializing transcript for ViewTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionOrderStatus stores the status of an auction order, replacing the one that was there
+func (sp *PGPuzzleStore) PutAuctionOrderStatus(status *match.AuctionOrderStatus) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PutAuctionOrderStatus: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	var statusBytes []byte
+	if statusBytes, err = status.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing status for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	putStatusQuery := fmt.Sprintf("INSERT INTO %s (receiptID, state, updated, encodedStatus) VALUES ('%x', '%s', %d, '%x') ON CONFLICT (receiptID) DO UPDATE SET state = EXCLUDED.state, updated = EXCLUDED.updated, encodedStatus = EXCLUDED.encodedStatus;", sp.auctionStatusTable(), status.Receipt.ID[:], status.State, status.Updated.UnixNano(), statusBytes)
+	if _, err = tx.Exec(putStatusQuery); err != nil {
+		err = fmt.Errorf("Error putting status into db for PutAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionOrderStatus returns the status of the auction order with a receipt ID, or nil if
+// there isn't one
+func (sp *PGPuzzleStore) ViewAuctionOrderStatus(receiptID [32]byte) (status *match.AuctionOrderStatus, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewAuctionOrderStatus: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	var encodedStatus string
+	getStatusQuery := fmt.Sprintf("SELECT encodedStatus FROM %s WHERE receiptID='%x';", sp.auctionStatusTable(), receiptID[:])
+	if err = tx.QueryRow(getStatusQuery).Scan(&encodedStatus); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Error querying for status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	var statusBytes []byte
+	if statusBytes, err = hex.DecodeString(encodedStatus); err != nil {
+		err = fmt.Errorf("Error decoding hex status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	status = new(match.AuctionOrderStatus)
+	if err = status.Deserialize(statusBytes); err != nil {
+		status = nil
+		err = fmt.Errorf("Error deserializing status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionResult stores the result of an auction, replacing the one that was there
+func (sp *PGPuzzleStore) PutAuctionResult(result *match.AuctionResult) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PutAuctionResult: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PutAuctionResult: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PutAuctionResult: %s", err)
+		return
+	}
+
+	var resultBytes []byte
+	if resultBytes, err = result.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing result for PutAuctionResult: %s", err)
+		return
+	}
+
+	putResultQuery := fmt.Sprintf("INSERT INTO %s (auctionID, ended, encodedResult) VALUES ('%x', %d, '%x') ON CONFLICT (auctionID) DO UPDATE SET ended = EXCLUDED.ended, encodedResult = EXCLUDED.encodedResult;", sp.auctionResultTable(), result.AuctionID[:], result.Ended.UnixNano(), resultBytes)
+	if _, err = tx.Exec(putResultQuery); err != nil {
+		err = fmt.Errorf("Error putting result into db for PutAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionResult returns the result of an auction, or nil if it doesn't have one
+func (sp *PGPuzzleStore) ViewAuctionResult(auctionID *match.AuctionID) (result *match.AuctionResult, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewAuctionResult: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewAuctionResult: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewAuctionResult: %s", err)
+		return
+	}
+
+	var encodedResult string
+	getResultQuery := fmt.Sprintf("SELECT encodedResult FROM %s WHERE auctionID='%x';", sp.auctionResultTable(), auctionID[:])
+	if err = tx.QueryRow(getResultQuery).Scan(&encodedResult); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Error querying for result for ViewAuctionResult: %s", err)
+		return
+	}
+
+	var resultBytes []byte
+	if resultBytes, err = hex.DecodeString(encodedResult); err != nil {
+		err = fmt.Errorf("Error decoding hex result for ViewAuctionResult: %s", err)
+		return
+	}
+
+	result = new(match.AuctionResult)
+	if err = result.Deserialize(resultBytes); err != nil {
+		result = nil
+		err = fmt.Errorf("Error deserializing result for ViewAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// PruneAuctions removes the results of auctions that ended before a time, and the statuses of
+// decided orders that haven't been updated since then
+func (sp *PGPuzzleStore) PruneAuctions(before time.Time) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PruneAuctions: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PruneAuctions: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PruneAuctions: %s", err)
+		return
+	}
+
+	pruneStatusesQuery := fmt.Sprintf("DELETE FROM %s WHERE state<>'%s' AND updated<%d;", sp.auctionStatusTable(), match.AuctionOrderSubmitted, before.UnixNano())
+	if _, err = tx.Exec(pruneStatusesQuery); err != nil {
+		err = fmt.Errorf("Error pruning statuses for PruneAuctions: %s", err)
+		return
+	}
+
+	pruneResultsQuery := fmt.Sprintf("DELETE FROM %s WHERE ended<%d;", sp.auctionResultTable(), before.UnixNano())
+	if _, err = tx.Exec(pruneResultsQuery); err != nil {
+		err = fmt.Errorf("Error pruning results for PruneAuctions: %s", err)
+		return
+	}
+	return
+}
+
+// auctionStatusTable is the name of the table the pair's auction order statuses are kept in
+func (sp *PGPuzzleStore) auctionStatusTable() string {
+	return sp.pair.String() + "_auctionstatuses"
+}
+
+// auctionResultTable is the name of the table the pair's auction results are kept in
+func (sp *PGPuzzleStore) auctionResultTable() string {
+	return sp.pair.String() + "_auctionresults"
+}
+
+// transcriptTable is the name of the table the pair's transcripts are kept in
+func (sp *PGPuzzleStore) transcriptTable() string {
+	return sp.pair.String() + "_transcripts"
+}
+
+// setupPuzzleStoreTables sets up the tables needed for the puzzle store.
+// This assumes the schema name is set
+func (sp *PGPuzzleStore) setupPuzzleStoreTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(sp.dbUsername, sp.dbPassword, sp.dbAddr, sp.dbName, sp.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup puzzle store tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup puzzle store tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while setting up puzzle store tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup puzzle store tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(sp.puzzleSchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", sp.puzzleSchema, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.pair.String(), pgPuzzleStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating puzzle store table: %s", err)
+		return
+	}
+
+	createTranscriptTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.transcriptTable(), pgTranscriptSchema)
+	if _, err = tx.Exec(createTranscriptTableQuery); err != nil {
+		err = fmt.Errorf("Error creating transcript table: %s", err)
+		return
+	}
+
+	createStatusTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.auctionStatusTable(), pgAuctionStatusSchema)
+	if _, err = tx.Exec(createStatusTableQuery); err != nil {
+		err = fmt.Errorf("Error creating auction status table: %s", err)
+		return
+	}
+
+	createResultTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.auctionResultTable(), pgAuctionResultSchema)
+	if _, err = tx.Exec(createResultTableQuery); err != nil {
+		err = fmt.Errorf("Error creating auction result table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (sp *PGPuzzleStore) DestroyHandler() (err error) {
+	if sp.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new puzzle store")
+		return
+	}
+	if err = sp.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing puzzle store handler for DestroyHandler: %s", err)
+		return
+	}
+	sp.DBHandler = nil
+	return
+}
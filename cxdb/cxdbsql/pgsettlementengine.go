@@ -0,0 +1,268 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGSettlementEngine is a settlement engine with postgres as a db backend
+type PGSettlementEngine struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// balance schema name
+	balanceSchema string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+const (
+	pgSettlementEngineSchema = "pubkey VARCHAR(66), balance BIGINT, PRIMARY KEY (pubkey)"
+)
+
+// CreatePGSettlementEngineStructWithConf creates a postgres settlement engine for a specific coin,
+// returning the struct rather than the interface.
+func CreatePGSettlementEngineStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (se *PGSettlementEngine, err error) {
+
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGSettlementEngineStructWithConf: %s", err)
+		return
+	}
+
+	// Set values
+	se = &PGSettlementEngine{
+		dbUsername:    conf.DBUsername,
+		dbPassword:    conf.DBPassword,
+		dbName:        conf.DBName,
+		dbSSLMode:     conf.DBSSLMode,
+		balanceSchema: conf.BalanceSchemaName,
+		dbAddr:        addr,
+		coin:          coin,
+	}
+
+	if err = se.setupSettlementTables(); err != nil {
+		err = fmt.Errorf("Error setting up settlement engine tables while creating engine: %s", err)
+		return
+	}
+
+	if se.DBHandler, err = sql.Open(postgresDriver, pgOpenString(se.dbUsername, se.dbPassword, se.dbAddr, se.dbName, se.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGSettlementEngineStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = se.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// ApplySettlementExecution applies the settlementExecution, this assumes that the settlement execution is
+// valid
+func (se *PGSettlementEngine) ApplySettlementExecution(setExec *match.SettlementExecution) (setRes *match.SettlementResult, err error) {
+
+	// First create transaction
+	var tx *sql.Tx
+	if tx, err = se.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while applying settlement exec: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while applying settlement exec: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use balance schema
+	if _, err = tx.Exec(pgUseSchema(se.balanceSchema)); err != nil {
+		err = fmt.Errorf("Error using balance schema for ApplySettlementExecution: %s", err)
+		return
+	}
+
+	var curBal uint64
+	if curBal, err = se.getBalanceTx(tx, setExec.Pubkey); err != nil {
+		err = fmt.Errorf("Error getting balance for ApplySettlementExecution: %s", err)
+		return
+	}
+
+	var newBal uint64
+	if setExec.Type == match.Debit {
+		newBal = curBal + setExec.Amount
+	} else if setExec.Type == match.Credit {
+		newBal = curBal - setExec.Amount
+	}
+	newBalQuery := fmt.Sprintf("INSERT INTO %s (balance, pubkey) VALUES (%d, '%x') ON CONFLICT (pubkey) DO UPDATE SET balance = EXCLUDED.balance;", se.coin.Name, newBal, setExec.Pubkey)
+	if _, err = tx.Exec(newBalQuery); err != nil {
+		err = fmt.Errorf("Error applying settlement exec new bal query: %s", err)
+		return
+	}
+
+	// Finally set return value
+	setRes = &match.SettlementResult{
+		NewBal:         newBal,
+		SuccessfulExec: setExec,
+	}
+
+	return
+}
+
+// CheckValid returns true if the settlement execution would be valid
+func (se *PGSettlementEngine) CheckValid(setExec *match.SettlementExecution) (valid bool, err error) {
+	if setExec.Type == match.Debit {
+		// No settlement will be an invalid debit
+		valid = true
+		return
+	}
+	// since we just returned, the setExec type == match.Credit
+
+	// First create transaction
+	var tx *sql.Tx
+	if tx, err = se.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while checking settlement exec: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while checking settlement exec: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use balance schema
+	if _, err = tx.Exec(pgUseSchema(se.balanceSchema)); err != nil {
+		err = fmt.Errorf("Error using balance schema for CheckValid: %s", err)
+		return
+	}
+
+	var curBal uint64
+	if curBal, err = se.getBalanceTx(tx, setExec.Pubkey); err != nil {
+		err = fmt.Errorf("Error getting balance for CheckValid: %s", err)
+		return
+	}
+
+	logging.Infof("User with %d %s trying to complete action costing %d %[2]s.", curBal, se.coin.Name, setExec.Amount)
+	valid = setExec.Amount <= curBal
+	return
+}
+
+// getBalanceTx gets the balance for a pubkey in this engine's coin table, locking the row. Users
+// without a row have a balance of zero.
+func (se *PGSettlementEngine) getBalanceTx(tx *sql.Tx, pubkey [33]byte) (balance uint64, err error) {
+	var rows *sql.Rows
+	curBalQuery := fmt.Sprintf("SELECT balance FROM %s WHERE pubkey='%x' FOR UPDATE;", se.coin.Name, pubkey)
+	if rows, err = tx.Query(curBalQuery); err != nil {
+		err = fmt.Errorf("Error querying for balance: %s", err)
+		return
+	}
+
+	if rows.Next() {
+		if err = rows.Scan(&balance); err != nil {
+			err = fmt.Errorf("Error scanning balance: %s", err)
+			return
+		}
+	}
+
+	if err = rows.Close(); err != nil {
+		err = fmt.Errorf("Error closing balance rows: %s", err)
+		return
+	}
+	return
+}
+
+// setupSettlementTables sets up the tables needed for the settlement engine.
+// This assumes the schema name is set
+func (se *PGSettlementEngine) setupSettlementTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(se.dbUsername, se.dbPassword, se.dbAddr, se.dbName, se.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup settlement tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup settlement tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while setting up settlement tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + se.balanceSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup settlement tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(se.balanceSchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", se.balanceSchema, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", se.coin.Name, pgSettlementEngineSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating settlement table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (se *PGSettlementEngine) DestroyHandler() (err error) {
+	if se.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new settlement engine")
+		return
+	}
+	if err = se.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing settlement engine handler for DestroyHandler: %s", err)
+		return
+	}
+	se.DBHandler = nil
+	return
+}
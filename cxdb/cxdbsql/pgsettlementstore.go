@@ -0,0 +1,242 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGSettlementStore is the postgres version of SQLSettlementStore, it handles all client-viewable
+// balances and is updated when the settlement engine returns.
+type PGSettlementStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// balance schema name
+	balanceReadOnlySchema string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+const (
+	pgSettlementStoreSchema = "pubkey VARCHAR(66), balance BIGINT, PRIMARY KEY (pubkey)"
+)
+
+// CreatePGSettlementStoreStructWithConf creates a postgres settlement store for a specific coin,
+// returning the struct rather than the interface.
+func CreatePGSettlementStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (ss *PGSettlementStore, err error) {
+
+	// Set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGSettlementStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Set values
+	ss = &PGSettlementStore{
+		dbUsername:            conf.DBUsername,
+		dbPassword:            conf.DBPassword,
+		dbName:                conf.DBName,
+		dbSSLMode:             conf.DBSSLMode,
+		balanceReadOnlySchema: conf.ReadOnlyBalanceSchemaName,
+		dbAddr:                addr,
+		coin:                  coin,
+	}
+
+	if err = ss.setupSettlementStoreTables(); err != nil {
+		err = fmt.Errorf("Error setting up settlement store tables while creating store: %s", err)
+		return
+	}
+
+	if ss.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGSettlementStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// setupSettlementStoreTables sets up the tables needed for the settlement store.
+// This assumes the schema name is set
+func (ss *PGSettlementStore) setupSettlementStoreTables() (err error) {
+
+	var assetForBal match.Asset
+	if assetForBal, err = match.AssetFromCoinParam(ss.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for setupSettlementStoreTables: %s", err)
+		return
+	}
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup settlement store tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup settlement store tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while setting up settlement store tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.balanceReadOnlySchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup settlement store tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ss.balanceReadOnlySchema)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.balanceReadOnlySchema, err)
+		return
+	}
+
+	// The store reads and writes the table named after the asset, so that's the one we create
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", assetForBal, pgSettlementStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating settlement store table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *PGSettlementStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new settlement store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing settlement store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// UpdateBalances updates the balances from the settlement executions
+func (ss *PGSettlementStore) UpdateBalances(settlementResults []*match.SettlementResult) (err error) {
+	// Now get asset from coin
+	var assetForBal match.Asset
+	if assetForBal, err = match.AssetFromCoinParam(ss.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param: %s", err)
+		return
+	}
+
+	// First create transaction
+	var tx *sql.Tx
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while updating balances: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while updating balances: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use balance schema
+	if _, err = tx.Exec(pgUseSchema(ss.balanceReadOnlySchema)); err != nil {
+		err = fmt.Errorf("Error using balance schema for UpdateBalances: %s", err)
+		return
+	}
+
+	for _, setResult := range settlementResults {
+		newBalQuery := fmt.Sprintf("INSERT INTO %s (balance, pubkey) VALUES (%d, '%x') ON CONFLICT (pubkey) DO UPDATE SET balance = EXCLUDED.balance;", assetForBal, setResult.NewBal, setResult.SuccessfulExec.Pubkey[:])
+		if _, err = tx.Exec(newBalQuery); err != nil {
+			err = fmt.Errorf("Error applying insert for UpdateBalances: %s", err)
+			return
+		}
+	}
+	return
+}
+
+// GetBalance gets the balance for a pubkey and an asset.
+func (ss *PGSettlementStore) GetBalance(pubkey *koblitz.PublicKey) (balance uint64, err error) {
+	// Get asset from coin
+	var assetForBal match.Asset
+	if assetForBal, err = match.AssetFromCoinParam(ss.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param: %s", err)
+		return
+	}
+
+	// Then create transaction
+	var tx *sql.Tx
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while getting balance: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while getting balance: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use balance schema
+	if _, err = tx.Exec(pgUseSchema(ss.balanceReadOnlySchema)); err != nil {
+		err = fmt.Errorf("Error using balance schema for GetBalance: %s", err)
+		return
+	}
+
+	var row *sql.Row
+	curBalQuery := fmt.Sprintf("SELECT balance FROM %s WHERE pubkey='%x';", assetForBal, pubkey.SerializeCompressed())
+	// errs deferred until scan
+	row = tx.QueryRow(curBalQuery)
+
+	if err = row.Scan(&balance); err != nil {
+		err = fmt.Errorf("Error scanning when getting balance: %s", err)
+		return
+	}
+
+	return
+}
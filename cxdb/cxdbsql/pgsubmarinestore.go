@@ -0,0 +1,241 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGSubmarineStore is the postgres version of SQLSubmarineStore, it keeps submarine swaps for
+// every coin.
+type PGSubmarineStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// swap schema name
+	swapSchemaName string
+}
+
+// The columns are the same as the mysql submarine swap table, postgres just doesn't have
+// unsigned integers or inline indexes.
+const (
+	pgSubmarineSwapsSchema = "seq BIGSERIAL PRIMARY KEY, rhash VARCHAR(64) NOT NULL UNIQUE, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, exchangeKey VARCHAR(66) NOT NULL, direction VARCHAR(8) NOT NULL, asset SMALLINT, amount BIGINT, chainLocktime BIGINT, channelLocktime BIGINT, outpoint VARCHAR(160) NOT NULL, fundAmount BIGINT, fundHeight BIGINT, spendTxid VARCHAR(128) NOT NULL, spendHeight BIGINT, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT"
+)
+
+// CreatePGSubmarineStoreStructWithConf creates a postgres submarine swap store, returning the
+// struct rather than the interface.
+func CreatePGSubmarineStoreStructWithConf(conf *dbsqlConfig) (ss *PGSubmarineStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGSubmarineStoreStructWithConf: %s", err)
+		return
+	}
+
+	ss = &PGSubmarineStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		dbName:         conf.DBName,
+		dbSSLMode:      conf.DBSSLMode,
+		swapSchemaName: conf.SwapSchemaName,
+		dbAddr:         addr,
+	}
+
+	if err = ss.setupSubmarineTables(); err != nil {
+		err = fmt.Errorf("Error setting up submarine swap tables for CreatePGSubmarineStoreStructWithConf: %s", err)
+		return
+	}
+
+	if ss.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGSubmarineStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+	return
+}
+
+// setupSubmarineTables sets up the table for submarine swaps.
+// This assumes everything else is set
+func (ss *PGSubmarineStore) setupSubmarineTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup submarine swap tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup submarine swap tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating submarine swap tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup submarine swap tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ss.swapSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.swapSchemaName, err)
+		return
+	}
+
+	createQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", submarineSwapsTable, pgSubmarineSwapsSchema)
+	if _, err = tx.Exec(createQuery); err != nil {
+		err = fmt.Errorf("Error creating submarine swap table: %s", err)
+		return
+	}
+
+	// submarine swaps are looked up by pubkey for users and by state when blocks come in
+	for _, column := range []string{"pubkey", "state"} {
+		createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_%[2]s ON %[1]s (%[2]s);", submarineSwapsTable, column)
+		if _, err = tx.Exec(createIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating %s index on submarine swap table: %s", column, err)
+			return
+		}
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *PGSubmarineStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new submarine swap store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing submarine swap store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the swap schema. If the returned error is nil, the
+// caller has to call finishSwapTx with its own error, which commits or rolls back.
+func (ss *PGSubmarineStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(ss.swapSchemaName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using swap schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddSubmarineSwap stores a new submarine swap
+func (ss *PGSubmarineStore) AddSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddSubmarineSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddSubmarineSwap", err)
+	}()
+
+	err = insertSubmarineSwap(tx, swap)
+	return
+}
+
+// UpdateSubmarineSwap saves everything about a stored submarine swap that changes as it moves
+// along
+func (ss *PGSubmarineStore) UpdateSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateSubmarineSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateSubmarineSwap", err)
+	}()
+
+	err = updateSubmarineSwap(tx, swap)
+	return
+}
+
+// GetSubmarineSwap gets a submarine swap by its hash
+func (ss *PGSubmarineStore) GetSubmarineSwap(rhash [32]byte) (swap *match.SubmarineSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSubmarineSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSubmarineSwap", err)
+	}()
+
+	swap, err = getSubmarineSwap(tx, rhash)
+	return
+}
+
+// GetSubmarineSwaps gets every submarine swap for a pubkey, oldest first
+func (ss *PGSubmarineStore) GetSubmarineSwaps(pubkey *koblitz.PublicKey) (swaps []*match.SubmarineSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSubmarineSwaps"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSubmarineSwaps", err)
+	}()
+
+	swaps, err = querySubmarineSwaps(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetSubmarineSwapsByState gets every submarine swap in a state, oldest first
+func (ss *PGSubmarineStore) GetSubmarineSwapsByState(state match.SubmarineState) (swaps []*match.SubmarineSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSubmarineSwapsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSubmarineSwapsByState", err)
+	}()
+
+	swaps, err = querySubmarineSwapsByState(tx, state)
+	return
+}
@@ -0,0 +1,379 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGSwapStore is the postgres version of SQLSwapStore, it keeps swaps, swap orders and escrows
+// for every pair.
+type PGSwapStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// swap schema name
+	swapSchemaName string
+}
+
+// The columns are the same as the mysql swap tables, postgres just doesn't have unsigned
+// integers or inline indexes.
+const (
+	pgSwapsSchema      = "seq BIGSERIAL PRIMARY KEY, rhash VARCHAR(64) NOT NULL UNIQUE, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, orderID VARCHAR(64) NOT NULL, assetWant SMALLINT, assetHave SMALLINT, buy BOOLEAN, sendAsset SMALLINT, amountSend BIGINT, sendLocktime BIGINT, receiveAsset SMALLINT, amountReceive BIGINT, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT"
+	pgSwapOrdersSchema = "orderID VARCHAR(64) PRIMARY KEY"
+	pgEscrowsSchema    = "seq BIGSERIAL PRIMARY KEY, rhash VARCHAR(64) NOT NULL UNIQUE, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, assetWant SMALLINT, assetHave SMALLINT, buy BOOLEAN, amountHave BIGINT, amountWant BIGINT, orderID VARCHAR(64) NOT NULL, lockAsset SMALLINT, amountLock BIGINT, locktime BIGINT, amountFilled BIGINT, receiveAsset SMALLINT, amountReceive BIGINT, receiveLocktime BIGINT, amountRefund BIGINT, refundLocktime BIGINT, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT"
+)
+
+// CreatePGSwapStoreStructWithConf creates a postgres swap store, returning the struct rather
+// than the interface.
+func CreatePGSwapStoreStructWithConf(conf *dbsqlConfig) (ss *PGSwapStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	ss = &PGSwapStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		dbName:         conf.DBName,
+		dbSSLMode:      conf.DBSSLMode,
+		swapSchemaName: conf.SwapSchemaName,
+		dbAddr:         addr,
+	}
+
+	if err = ss.setupSwapTables(); err != nil {
+		err = fmt.Errorf("Error setting up swap tables for CreatePGSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	if ss.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+	return
+}
+
+// setupSwapTables sets up the tables for swaps, swap orders and escrows.
+// This assumes everything else is set
+func (ss *PGSwapStore) setupSwapTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ss.dbUsername, ss.dbPassword, ss.dbAddr, ss.dbName, ss.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup swap tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup swap tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating swap tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup swap tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ss.swapSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.swapSchemaName, err)
+		return
+	}
+
+	createSwapsQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", swapsTable, pgSwapsSchema)
+	if _, err = tx.Exec(createSwapsQuery); err != nil {
+		err = fmt.Errorf("Error creating swap table: %s", err)
+		return
+	}
+
+	createOrdersQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", swapOrdersTable, pgSwapOrdersSchema)
+	if _, err = tx.Exec(createOrdersQuery); err != nil {
+		err = fmt.Errorf("Error creating swap order table: %s", err)
+		return
+	}
+
+	createEscrowsQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", escrowsTable, pgEscrowsSchema)
+	if _, err = tx.Exec(createEscrowsQuery); err != nil {
+		err = fmt.Errorf("Error creating escrow table: %s", err)
+		return
+	}
+
+	// swaps are looked up by pubkey for users and by state when blocks come in
+	for _, column := range []string{"pubkey", "state"} {
+		createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_%[2]s ON %[1]s (%[2]s);", swapsTable, column)
+		if _, err = tx.Exec(createIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating %s index on swap table: %s", column, err)
+			return
+		}
+	}
+
+	// escrows are also looked up by order when their orders are filled or cancelled
+	for _, column := range []string{"pubkey", "orderID", "state"} {
+		createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_%[2]s ON %[1]s (%[2]s);", escrowsTable, column)
+		if _, err = tx.Exec(createIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating %s index on escrow table: %s", column, err)
+			return
+		}
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *PGSwapStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new swap store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing swap store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the swap schema. If the returned error is nil, the
+// caller has to call finishSwapTx with its own error, which commits or rolls back.
+func (ss *PGSwapStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(ss.swapSchemaName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using swap schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddSwap stores a new swap
+func (ss *PGSwapStore) AddSwap(swap *match.Swap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddSwap", err)
+	}()
+
+	err = insertSwap(tx, swap)
+	return
+}
+
+// UpdateSwap saves the state, reason and update time of a stored swap
+func (ss *PGSwapStore) UpdateSwap(swap *match.Swap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateSwap", err)
+	}()
+
+	err = updateSwap(tx, swap)
+	return
+}
+
+// GetSwap gets a swap by its hash
+func (ss *PGSwapStore) GetSwap(rhash [32]byte) (swap *match.Swap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSwap", err)
+	}()
+
+	swap, err = getSwap(tx, rhash)
+	return
+}
+
+// GetSwaps gets every swap for a pubkey, oldest first
+func (ss *PGSwapStore) GetSwaps(pubkey *koblitz.PublicKey) (swaps []*match.Swap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSwaps"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSwaps", err)
+	}()
+
+	swaps, err = querySwaps(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetSwapsByState gets every swap in a state, oldest first
+func (ss *PGSwapStore) GetSwapsByState(state match.SwapState) (swaps []*match.Swap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSwapsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSwapsByState", err)
+	}()
+
+	swaps, err = querySwapsByState(tx, state)
+	return
+}
+
+// AddSwapOrder marks an order as a swap order
+func (ss *PGSwapStore) AddSwapOrder(orderID *match.OrderID) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddSwapOrder"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddSwapOrder", err)
+	}()
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s (orderID) VALUES ('%x') ON CONFLICT DO NOTHING;", swapOrdersTable, orderID[:])
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting swap order: %s", err)
+		return
+	}
+	return
+}
+
+// IsSwapOrder returns true if an order is a swap order
+func (ss *PGSwapStore) IsSwapOrder(orderID *match.OrderID) (swapOrder bool, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("IsSwapOrder"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "IsSwapOrder", err)
+	}()
+
+	swapOrder, err = isSwapOrder(tx, orderID)
+	return
+}
+
+// AddEscrow stores a new escrow
+func (ss *PGSwapStore) AddEscrow(escrow *match.OrderEscrow) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddEscrow", err)
+	}()
+
+	err = insertEscrow(tx, escrow)
+	return
+}
+
+// UpdateEscrow saves the order ID, release, state, reason and update time of a stored escrow
+func (ss *PGSwapStore) UpdateEscrow(escrow *match.OrderEscrow) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateEscrow", err)
+	}()
+
+	err = updateEscrow(tx, escrow)
+	return
+}
+
+// GetEscrow gets an escrow by its hash
+func (ss *PGSwapStore) GetEscrow(rhash [32]byte) (escrow *match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetEscrow", err)
+	}()
+
+	escrow, err = getEscrow(tx, rhash)
+	return
+}
+
+// GetOrderEscrow gets the escrow of a channel order, escrow is nil if the order doesn't have one
+func (ss *PGSwapStore) GetOrderEscrow(orderID *match.OrderID) (escrow *match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetOrderEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetOrderEscrow", err)
+	}()
+
+	escrow, err = getOrderEscrow(tx, orderID)
+	return
+}
+
+// GetEscrows gets every escrow for a pubkey, oldest first
+func (ss *PGSwapStore) GetEscrows(pubkey *koblitz.PublicKey) (escrows []*match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetEscrows"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetEscrows", err)
+	}()
+
+	escrows, err = queryEscrows(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetEscrowsByState gets every escrow in a state, oldest first
+func (ss *PGSwapStore) GetEscrowsByState(state match.EscrowState) (escrows []*match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetEscrowsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetEscrowsByState", err)
+	}()
+
+	escrows, err = queryEscrowsByState(tx, state)
+	return
+}
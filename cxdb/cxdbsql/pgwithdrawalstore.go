@@ -0,0 +1,315 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PGWithdrawalStore is the postgres version of SQLWithdrawalStore, it keeps withdrawal requests
+// for a coin.
+type PGWithdrawalStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// database name and ssl mode
+	dbName    string
+	dbSSLMode string
+
+	// withdrawal schema name
+	withdrawalSchemaName string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// The columns are the same as the mysql withdrawal table, postgres just doesn't have unsigned
+// integers or inline indexes.
+const (
+	pgWithdrawalStoreSchema = "id BIGSERIAL PRIMARY KEY, pubkey VARCHAR(66) NOT NULL, amount BIGINT, address TEXT, state VARCHAR(16) NOT NULL, txid VARCHAR(64), reason TEXT, requested BIGINT, updated BIGINT, lightning BOOLEAN, nonce NUMERIC(20), domain TEXT, signature TEXT, priority SMALLINT, payfee BOOLEAN, fee BIGINT"
+)
+
+// CreatePGWithdrawalStoreStructWithConf creates a postgres withdrawal store for a coin, returning
+// the struct rather than the interface.
+func CreatePGWithdrawalStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (ws *PGWithdrawalStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreatePGWithdrawalStoreStructWithConf: %s", err)
+		return
+	}
+
+	ws = &PGWithdrawalStore{
+		dbUsername:           conf.DBUsername,
+		dbPassword:           conf.DBPassword,
+		dbName:               conf.DBName,
+		dbSSLMode:            conf.DBSSLMode,
+		withdrawalSchemaName: conf.WithdrawalSchemaName,
+
+		dbAddr: addr,
+		coin:   coin,
+	}
+
+	if err = ws.setupWithdrawalTables(); err != nil {
+		err = fmt.Errorf("Error setting up withdrawal tables for CreatePGWithdrawalStoreStructWithConf: %s", err)
+		return
+	}
+
+	if ws.DBHandler, err = sql.Open(postgresDriver, pgOpenString(ws.dbUsername, ws.dbPassword, ws.dbAddr, ws.dbName, ws.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for CreatePGWithdrawalStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ws.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// setupWithdrawalTables sets up the table for this coin's withdrawals.
+// This assumes everything else is set
+func (ws *PGWithdrawalStore) setupWithdrawalTables() (err error) {
+
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open(postgresDriver, pgOpenString(ws.dbUsername, ws.dbPassword, ws.dbAddr, ws.dbName, ws.dbSSLMode)); err != nil {
+		err = fmt.Errorf("Error opening database for setup withdrawal tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup withdrawal tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating withdrawal tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ws.withdrawalSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup withdrawal tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec(pgUseSchema(ws.withdrawalSchemaName)); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ws.withdrawalSchemaName, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ws.coin.Name, pgWithdrawalStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating withdrawal table: %s", err)
+		return
+	}
+
+	// withdrawals are looked up by pubkey for users and by state for the batcher
+	for _, column := range []string{"pubkey", "state"} {
+		createIndexQuery := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_%[2]s ON %[1]s (%[2]s);", ws.coin.Name, column)
+		if _, err = tx.Exec(createIndexQuery); err != nil {
+			err = fmt.Errorf("Error creating %s index on withdrawal table: %s", column, err)
+			return
+		}
+	}
+
+	createBatchTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", withdrawalBatchTable(ws.coin), withdrawalBatchSchema)
+	if _, err = tx.Exec(createBatchTableQuery); err != nil {
+		err = fmt.Errorf("Error creating withdrawal batch table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ws *PGWithdrawalStore) DestroyHandler() (err error) {
+	if ws.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new withdrawal store")
+		return
+	}
+	if err = ws.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing withdrawal store handler for DestroyHandler: %s", err)
+		return
+	}
+	ws.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the withdrawal schema. If the returned error is nil, the
+// caller has to call finishWithdrawalTx with its own error, which commits or rolls back.
+func (ws *PGWithdrawalStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ws.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec(pgUseSchema(ws.withdrawalSchemaName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using withdrawal schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddWithdrawal stores a new withdrawal request and sets its ID
+func (ws *PGWithdrawalStore) AddWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("AddWithdrawal"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "AddWithdrawal", err)
+	}()
+
+	var insertQuery string
+	if insertQuery, err = insertWithdrawalQuery(ws.coin.Name, withdrawal); err != nil {
+		return
+	}
+
+	// postgres doesn't have LastInsertId, so we ask for the ID back
+	if err = tx.QueryRow(insertQuery + " RETURNING id;").Scan(&withdrawal.ID); err != nil {
+		err = fmt.Errorf("Error inserting withdrawal: %s", err)
+		return
+	}
+	return
+}
+
+// UpdateWithdrawal saves the state, txid, reason, fee and update time of a stored withdrawal request
+func (ws *PGWithdrawalStore) UpdateWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("UpdateWithdrawal"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "UpdateWithdrawal", err)
+	}()
+
+	err = updateWithdrawal(tx, ws.coin.Name, withdrawal)
+	return
+}
+
+// GetWithdrawal gets a withdrawal request by ID
+func (ws *PGWithdrawalStore) GetWithdrawal(id uint64) (withdrawal *match.WithdrawalRequest, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawal"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawal", err)
+	}()
+
+	withdrawal, err = getWithdrawal(tx, ws.coin, id)
+	return
+}
+
+// GetWithdrawals gets every withdrawal request for a pubkey, oldest first
+func (ws *PGWithdrawalStore) GetWithdrawals(pubkey *koblitz.PublicKey) (withdrawals []*match.WithdrawalRequest, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawals"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawals", err)
+	}()
+
+	withdrawals, err = queryWithdrawals(tx, ws.coin, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetWithdrawalsByState gets every withdrawal request in a state, oldest first
+func (ws *PGWithdrawalStore) GetWithdrawalsByState(state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawalsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawalsByState", err)
+	}()
+
+	withdrawals, err = queryWithdrawalsByState(tx, ws.coin, state)
+	return
+}
+
+// PutWithdrawalBatch stores a withdrawal transaction that hasn't confirmed yet, replacing it if
+// it's already stored
+func (ws *PGWithdrawalStore) PutWithdrawalBatch(batch *match.WithdrawalBatch) (err error) {
+	var encodedBatch string
+	if encodedBatch, err = encodeWithdrawalBatch(batch); err != nil {
+		err = fmt.Errorf("Error encoding withdrawal batch for PutWithdrawalBatch: %s", err)
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = ws.begin("PutWithdrawalBatch"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "PutWithdrawalBatch", err)
+	}()
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%s', '%s') ON CONFLICT (txid) DO UPDATE SET encodedBatch = EXCLUDED.encodedBatch;", withdrawalBatchTable(ws.coin), batch.Txid, encodedBatch)
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting withdrawal batch %s: %s", batch.Txid, err)
+		return
+	}
+	return
+}
+
+// GetWithdrawalBatches gets every withdrawal transaction that hasn't confirmed yet, by txid
+func (ws *PGWithdrawalStore) GetWithdrawalBatches() (batches []*match.WithdrawalBatch, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawalBatches"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawalBatches", err)
+	}()
+
+	batches, err = queryWithdrawalBatches(tx, withdrawalBatchTable(ws.coin))
+	return
+}
+
+// RemoveWithdrawalBatch removes a batch once a version of its transaction confirms
+func (ws *PGWithdrawalStore) RemoveWithdrawalBatch(txid string) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("RemoveWithdrawalBatch"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "RemoveWithdrawalBatch", err)
+	}()
+
+	err = removeWithdrawalBatch(tx, withdrawalBatchTable(ws.coin), txid)
+	return
+}
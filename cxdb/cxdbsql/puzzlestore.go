@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/mit-dci/opencx/cxdb"
@@ -34,6 +35,11 @@ type SQLPuzzleStore struct {
 
 const (
 	puzzleStoreSchema = "encodedOrder TEXT, auctionID VARBINARY(64), selected BOOLEAN"
+	// transcripts are hex encoded and have every puzzle in the auction, so they can be big
+	transcriptSchema    = "auctionID VARBINARY(64), encodedTranscript LONGTEXT, PRIMARY KEY (auctionID)"
+	auctionStatusSchema = "receiptID VARBINARY(64), state VARCHAR(32), updated BIGINT, encodedStatus TEXT, PRIMARY KEY (receiptID)"
+	// results have every fill in the auction
+	auctionResultSchema = "auctionID VARBINARY(64), ended BIGINT, encodedResult LONGTEXT, PRIMARY KEY (auctionID)"
 )
 
 // CreatePuzzleStore creates a puzzle store for a specific coin.
@@ -45,6 +51,14 @@ func CreatePuzzleStore(pair *match.Pair) (store cxdb.PuzzleStore, err error) {
 	// Set the default conf
 	dbConfigSetup(conf)
 
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGPuzzleStoreStructWithConf(pair, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres puzzle store for CreatePuzzleStore: %s", err)
+			return
+		}
+		return
+	}
+
 	// Resolve new address
 	var addr net.Addr
 	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
@@ -187,6 +201,317 @@ func (sp *SQLPuzzleStore) PlaceAuctionPuzzle(puzzledOrder *match.EncryptedAuctio
 	return
 }
 
+// PutTranscript stores the transcript for an auction, replacing the one that was there.
+func (sp *SQLPuzzleStore) PutTranscript(transcript *match.Transcript) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PutTranscript: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PutTranscript: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PutTranscript: %s", err)
+		return
+	}
+
+	var transcriptBytes []byte
+	if transcriptBytes, err = transcript.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing transcript for PutTranscript: %s", err)
+		return
+	}
+
+	putTranscriptQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%x') ON DUPLICATE KEY UPDATE encodedTranscript='%[3]x';", sp.transcriptTable(), transcript.BatchId[:], transcriptBytes)
+	if _, err = tx.Exec(putTranscriptQuery); err != nil {
+		err = fmt.Errorf("Error putting transcript into db for PutTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// ViewTranscript returns the transcript for an auction, or an error if there isn't one
+func (sp *SQLPuzzleStore) ViewTranscript(auctionID *match.AuctionID) (transcript *match.Transcript, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewTranscript: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewTranscript: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewTranscript: %s", err)
+		return
+	}
+
+	var encodedTranscript string
+	getTranscriptQuery := fmt.Sprintf("SELECT encodedTranscript FROM %s WHERE auctionID='%x';", sp.transcriptTable(), auctionID[:])
+	if err = tx.QueryRow(getTranscriptQuery).Scan(&encodedTranscript); err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("No transcript for auction %x", auctionID[:])
+			return
+		}
+		err = fmt.Errorf("Error querying for transcript for ViewTranscript: %s", err)
+		return
+	}
+
+	var transcriptBytes []byte
+	if transcriptBytes, err = hex.DecodeString(encodedTranscript); err != nil {
+		err = fmt.Errorf("Error decoding hex transcript for ViewTranscript: %s", err)
+		return
+	}
+
+	transcript = new(match.Transcript)
+	if err = transcript.Deserialize(transcriptBytes); err != nil {
+		err = fmt.Errorf("Error deserializing transcript for ViewTranscript: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionOrderStatus stores the status of an auction order, replacing the one that was there
+func (sp *SQLPuzzleStore) PutAuctionOrderStatus(status *match.AuctionOrderStatus) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PutAuctionOrderStatus: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	var statusBytes []byte
+	if statusBytes, err = status.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing status for PutAuctionOrderStatus: %s", err)
+		return
+	}
+
+	putStatusQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', '%s', %d, '%x') ON DUPLICATE KEY UPDATE state='%[3]s', updated=%[4]d, encodedStatus='%[5]x';", sp.auctionStatusTable(), status.Receipt.ID[:], status.State, status.Updated.UnixNano(), statusBytes)
+	if _, err = tx.Exec(putStatusQuery); err != nil {
+		err = fmt.Errorf("Error putting status into db for PutAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionOrderStatus returns the status of the auction order with a receipt ID, or nil if
+// there isn't one
+func (sp *SQLPuzzleStore) ViewAuctionOrderStatus(receiptID [32]byte) (status *match.AuctionOrderStatus, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewAuctionOrderStatus: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	var encodedStatus string
+	getStatusQuery := fmt.Sprintf("SELECT encodedStatus FROM %s WHERE receiptID='%x';", sp.auctionStatusTable(), receiptID[:])
+	if err = tx.QueryRow(getStatusQuery).Scan(&encodedStatus); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Error querying for status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	var statusBytes []byte
+	if statusBytes, err = hex.DecodeString(encodedStatus); err != nil {
+		err = fmt.Errorf("Error decoding hex status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+
+	status = new(match.AuctionOrderStatus)
+	if err = status.Deserialize(statusBytes); err != nil {
+		status = nil
+		err = fmt.Errorf("Error deserializing status for ViewAuctionOrderStatus: %s", err)
+		return
+	}
+	return
+}
+
+// PutAuctionResult stores the result of an auction, replacing the one that was there
+func (sp *SQLPuzzleStore) PutAuctionResult(result *match.AuctionResult) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PutAuctionResult: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PutAuctionResult: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PutAuctionResult: %s", err)
+		return
+	}
+
+	var resultBytes []byte
+	if resultBytes, err = result.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing result for PutAuctionResult: %s", err)
+		return
+	}
+
+	putResultQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%x', %d, '%x') ON DUPLICATE KEY UPDATE ended=%[3]d, encodedResult='%[4]x';", sp.auctionResultTable(), result.AuctionID[:], result.Ended.UnixNano(), resultBytes)
+	if _, err = tx.Exec(putResultQuery); err != nil {
+		err = fmt.Errorf("Error putting result into db for PutAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// ViewAuctionResult returns the result of an auction, or nil if it doesn't have one
+func (sp *SQLPuzzleStore) ViewAuctionResult(auctionID *match.AuctionID) (result *match.AuctionResult, err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for ViewAuctionResult: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for ViewAuctionResult: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for ViewAuctionResult: %s", err)
+		return
+	}
+
+	var encodedResult string
+	getResultQuery := fmt.Sprintf("SELECT encodedResult FROM %s WHERE auctionID='%x';", sp.auctionResultTable(), auctionID[:])
+	if err = tx.QueryRow(getResultQuery).Scan(&encodedResult); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Error querying for result for ViewAuctionResult: %s", err)
+		return
+	}
+
+	var resultBytes []byte
+	if resultBytes, err = hex.DecodeString(encodedResult); err != nil {
+		err = fmt.Errorf("Error decoding hex result for ViewAuctionResult: %s", err)
+		return
+	}
+
+	result = new(match.AuctionResult)
+	if err = result.Deserialize(resultBytes); err != nil {
+		result = nil
+		err = fmt.Errorf("Error deserializing result for ViewAuctionResult: %s", err)
+		return
+	}
+	return
+}
+
+// PruneAuctions removes the results of auctions that ended before a time, and the statuses of
+// decided orders that haven't been updated since then
+func (sp *SQLPuzzleStore) PruneAuctions(before time.Time) (err error) {
+	// ACID
+	var tx *sql.Tx
+	if tx, err = sp.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for PruneAuctions: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error for PruneAuctions: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if _, err = tx.Exec("USE " + sp.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using puzzle schema for PruneAuctions: %s", err)
+		return
+	}
+
+	pruneStatusesQuery := fmt.Sprintf("DELETE FROM %s WHERE state<>'%s' AND updated<%d;", sp.auctionStatusTable(), match.AuctionOrderSubmitted, before.UnixNano())
+	if _, err = tx.Exec(pruneStatusesQuery); err != nil {
+		err = fmt.Errorf("Error pruning statuses for PruneAuctions: %s", err)
+		return
+	}
+
+	pruneResultsQuery := fmt.Sprintf("DELETE FROM %s WHERE ended<%d;", sp.auctionResultTable(), before.UnixNano())
+	if _, err = tx.Exec(pruneResultsQuery); err != nil {
+		err = fmt.Errorf("Error pruning results for PruneAuctions: %s", err)
+		return
+	}
+	return
+}
+
+// auctionStatusTable is the name of the table the pair's auction order statuses are kept in
+func (sp *SQLPuzzleStore) auctionStatusTable() string {
+	return sp.pair.String() + "_auctionstatuses"
+}
+
+// auctionResultTable is the name of the table the pair's auction results are kept in
+func (sp *SQLPuzzleStore) auctionResultTable() string {
+	return sp.pair.String() + "_auctionresults"
+}
+
+// transcriptTable is the name of the table the pair's transcripts are kept in
+func (sp *SQLPuzzleStore) transcriptTable() string {
+	return sp.pair.String() + "_transcripts"
+}
+
 // setupPuzzleStoreTables sets up the tables needed for the auction orderbook.
 // This assumes the schema name is set
 func (sp *SQLPuzzleStore) setupPuzzleStoreTables() (err error) {
@@ -239,6 +564,24 @@ func (sp *SQLPuzzleStore) setupPuzzleStoreTables() (err error) {
 		err = fmt.Errorf("Error creating puzzle store table: %s", err)
 		return
 	}
+
+	createTranscriptTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.transcriptTable(), transcriptSchema)
+	if _, err = tx.Exec(createTranscriptTableQuery); err != nil {
+		err = fmt.Errorf("Error creating transcript table: %s", err)
+		return
+	}
+
+	createStatusTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.auctionStatusTable(), auctionStatusSchema)
+	if _, err = tx.Exec(createStatusTableQuery); err != nil {
+		err = fmt.Errorf("Error creating auction status table: %s", err)
+		return
+	}
+
+	createResultTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", sp.auctionResultTable(), auctionResultSchema)
+	if _, err = tx.Exec(createResultTableQuery); err != nil {
+		err = fmt.Errorf("Error creating auction result table: %s", err)
+		return
+	}
 	return
 }
 
@@ -7,7 +7,16 @@ import (
 
 func TestSchemasUseIntegers(t *testing.T) {
 	if strings.Contains(auctionOrderbookSchema, "DOUBLE") || strings.Contains(auctionEngineSchema, "DOUBLE") ||
-		strings.Contains(limitOrderbookSchema, "DOUBLE") || strings.Contains(limitEngineSchema, "DOUBLE") {
+		strings.Contains(limitOrderbookSchema, "DOUBLE") || strings.Contains(limitEngineSchema, "DOUBLE") ||
+		strings.Contains(orderHistorySchema, "DOUBLE") || strings.Contains(fillHistorySchema, "DOUBLE") {
 		t.Errorf("schemas should not use floating point price columns")
 	}
 }
+
+func TestPGSchemasUseIntegers(t *testing.T) {
+	for _, schema := range []string{pgAuctionOrderbookSchema, pgAuctionEngineSchema, pgLimitOrderbookSchema, pgLimitEngineSchema, pgOrderHistorySchema, pgFillHistorySchema} {
+		if strings.Contains(schema, "DOUBLE") || strings.Contains(schema, "REAL") || strings.Contains(schema, "FLOAT") {
+			t.Errorf("postgres schemas should not use floating point price columns")
+		}
+	}
+}
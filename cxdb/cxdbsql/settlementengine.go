@@ -42,6 +42,14 @@ func CreateSettlementEngine(coin *coinparam.Params) (engine match.SettlementEngi
 	// Set the default conf
 	dbConfigSetup(conf)
 
+	if conf.DBDriver == postgresDriver {
+		if engine, err = CreatePGSettlementEngineStructWithConf(coin, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres settlement engine for CreateSettlementEngine: %s", err)
+			return
+		}
+		return
+	}
+
 	// Resolve new address
 	var addr net.Addr
 	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
@@ -47,6 +47,14 @@ func CreateSettlementStore(coin *coinparam.Params) (store cxdb.SettlementStore,
 	// Set the default conf
 	dbConfigSetup(conf)
 
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGSettlementStoreStructWithConf(coin, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres settlement store for CreateSettlementStore: %s", err)
+			return
+		}
+		return
+	}
+
 	// Resolve new address
 	var addr net.Addr
 	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
@@ -0,0 +1,404 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLSubmarineStore keeps submarine swaps for every coin in SQL. It uses the swap schema, and
+// like swaps rows are never deleted.
+type SQLSubmarineStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// swap schema name
+	swapSchemaName string
+}
+
+// Times are unix nanoseconds like the swap table. The outpoint, spend txid and reason are stored
+// as hex so nothing a user sends ends up in a query.
+const (
+	submarineSwapsTable  = "submarineswaps"
+	submarineSwapsSchema = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, rhash VARCHAR(64) NOT NULL, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, exchangeKey VARCHAR(66) NOT NULL, direction VARCHAR(8) NOT NULL, asset TINYINT UNSIGNED, amount BIGINT UNSIGNED, chainLocktime INT UNSIGNED, channelLocktime INT UNSIGNED, outpoint VARCHAR(160) NOT NULL, fundAmount BIGINT UNSIGNED, fundHeight BIGINT UNSIGNED, spendTxid VARCHAR(128) NOT NULL, spendHeight BIGINT UNSIGNED, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT, PRIMARY KEY (seq), UNIQUE KEY (rhash), KEY (pubkey), KEY (state)"
+
+	// the columns we select for submarine swaps, in the order querySubmarineSwaps scans them
+	submarineSwapColumns = "rhash, preimage, pubkey, exchangeKey, direction, asset, amount, chainLocktime, channelLocktime, outpoint, fundAmount, fundHeight, spendTxid, spendHeight, state, reason, created, updated"
+)
+
+// CreateSubmarineStoreStructWithConf creates a submarine swap store, returning the struct rather
+// than the interface.
+func CreateSubmarineStoreStructWithConf(conf *dbsqlConfig) (ss *SQLSubmarineStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateSubmarineStoreStructWithConf: %s", err)
+		return
+	}
+
+	ss = &SQLSubmarineStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		swapSchemaName: conf.SwapSchemaName,
+		dbAddr:         addr,
+	}
+
+	if err = ss.setupSubmarineTables(); err != nil {
+		err = fmt.Errorf("Error setting up submarine swap tables for CreateSubmarineStoreStructWithConf: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ss.dbUsername, ss.dbPassword, ss.dbAddr.Network(), ss.dbAddr.String())
+	if ss.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateSubmarineStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// CreateSubmarineStore creates a submarine swap store for every coin
+func CreateSubmarineStore() (store cxdb.SubmarineStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGSubmarineStoreStructWithConf(conf); err != nil {
+			err = fmt.Errorf("Error creating postgres submarine swap store struct for CreateSubmarineStore: %s", err)
+			return
+		}
+		return
+	}
+
+	if store, err = CreateSubmarineStoreStructWithConf(conf); err != nil {
+		err = fmt.Errorf("Error creating submarine swap store struct for CreateSubmarineStore: %s", err)
+		return
+	}
+	return
+}
+
+// setupSubmarineTables sets up the table for submarine swaps.
+// This assumes everything else is set
+func (ss *SQLSubmarineStore) setupSubmarineTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ss.dbUsername, ss.dbPassword, ss.dbAddr.Network(), ss.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup submarine swap tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup submarine swap tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating submarine swap tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup submarine swap tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.swapSchemaName, err)
+		return
+	}
+
+	createQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", submarineSwapsTable, submarineSwapsSchema)
+	if _, err = tx.Exec(createQuery); err != nil {
+		err = fmt.Errorf("Error creating submarine swap table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *SQLSubmarineStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new submarine swap store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing submarine swap store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the swap schema. If the returned error is nil, the
+// caller has to call finishSwapTx with its own error, which commits or rolls back.
+func (ss *SQLSubmarineStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + ss.swapSchemaName + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using swap schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// AddSubmarineSwap stores a new submarine swap
+func (ss *SQLSubmarineStore) AddSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddSubmarineSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddSubmarineSwap", err)
+	}()
+
+	err = insertSubmarineSwap(tx, swap)
+	return
+}
+
+// UpdateSubmarineSwap saves everything about a stored submarine swap that changes as it moves
+// along
+func (ss *SQLSubmarineStore) UpdateSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateSubmarineSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateSubmarineSwap", err)
+	}()
+
+	err = updateSubmarineSwap(tx, swap)
+	return
+}
+
+// GetSubmarineSwap gets a submarine swap by its hash
+func (ss *SQLSubmarineStore) GetSubmarineSwap(rhash [32]byte) (swap *match.SubmarineSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSubmarineSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSubmarineSwap", err)
+	}()
+
+	swap, err = getSubmarineSwap(tx, rhash)
+	return
+}
+
+// GetSubmarineSwaps gets every submarine swap for a pubkey, oldest first
+func (ss *SQLSubmarineStore) GetSubmarineSwaps(pubkey *koblitz.PublicKey) (swaps []*match.SubmarineSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSubmarineSwaps"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSubmarineSwaps", err)
+	}()
+
+	swaps, err = querySubmarineSwaps(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetSubmarineSwapsByState gets every submarine swap in a state, oldest first
+func (ss *SQLSubmarineStore) GetSubmarineSwapsByState(state match.SubmarineState) (swaps []*match.SubmarineSwap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSubmarineSwapsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSubmarineSwapsByState", err)
+	}()
+
+	swaps, err = querySubmarineSwapsByState(tx, state)
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres submarine swap stores, the queries
+// are the same once the transaction is using the swap schema.
+
+// insertSubmarineSwap inserts a new submarine swap into the submarine swap table
+func insertSubmarineSwap(tx *sql.Tx, swap *match.SubmarineSwap) (err error) {
+	if _, err = match.SubmarineStateFromString(string(swap.State)); err != nil {
+		err = fmt.Errorf("Error with submarine swap state: %s", err)
+		return
+	}
+	if _, err = match.SubmarineDirectionFromString(string(swap.Direction)); err != nil {
+		err = fmt.Errorf("Error with submarine swap direction: %s", err)
+		return
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES ('%x', '%x', '%x', '%x', '%s', %d, %d, %d, %d, '%x', %d, %d, '%x', %d, '%s', '%x', %d, %d);",
+		submarineSwapsTable, submarineSwapColumns, swap.RHash[:], swap.Preimage[:], swap.Pubkey[:], swap.ExchangeKey[:], swap.Direction, swap.Asset, swap.Amount,
+		swap.ChainLocktime, swap.ChannelLocktime, swap.Outpoint, swap.FundAmount, swap.FundHeight, swap.SpendTxid, swap.SpendHeight, swap.State, swap.Reason,
+		swap.Created.UnixNano(), swap.Updated.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting submarine swap %s: %s", swap.ID(), err)
+		return
+	}
+	return
+}
+
+// updateSubmarineSwap writes everything about a submarine swap that changes as it moves along
+func updateSubmarineSwap(tx *sql.Tx, swap *match.SubmarineSwap) (err error) {
+	if _, err = match.SubmarineStateFromString(string(swap.State)); err != nil {
+		err = fmt.Errorf("Error with submarine swap state: %s", err)
+		return
+	}
+
+	// check the row is there first, mysql only counts rows that changed
+	if _, err = getSubmarineSwap(tx, swap.RHash); err != nil {
+		return
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET preimage='%x', channelLocktime=%d, outpoint='%x', fundAmount=%d, fundHeight=%d, spendTxid='%x', spendHeight=%d, state='%s', reason='%x', updated=%d WHERE rhash='%x';",
+		submarineSwapsTable, swap.Preimage[:], swap.ChannelLocktime, swap.Outpoint, swap.FundAmount, swap.FundHeight, swap.SpendTxid, swap.SpendHeight,
+		swap.State, swap.Reason, swap.Updated.UnixNano(), swap.RHash[:])
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating submarine swap %s: %s", swap.ID(), err)
+		return
+	}
+	return
+}
+
+// getSubmarineSwap gets a single submarine swap by its hash
+func getSubmarineSwap(tx *sql.Tx, rhash [32]byte) (swap *match.SubmarineSwap, err error) {
+	var swaps []*match.SubmarineSwap
+	if swaps, err = querySubmarineSwaps(tx, fmt.Sprintf("rhash='%x'", rhash)); err != nil {
+		return
+	}
+	if len(swaps) == 0 {
+		err = fmt.Errorf("No submarine swap %x", rhash)
+		return
+	}
+	swap = swaps[0]
+	return
+}
+
+// querySubmarineSwapsByState gets every submarine swap in a state, oldest first
+func querySubmarineSwapsByState(tx *sql.Tx, state match.SubmarineState) (swaps []*match.SubmarineSwap, err error) {
+	if _, err = match.SubmarineStateFromString(string(state)); err != nil {
+		err = fmt.Errorf("Error with state for querying submarine swaps: %s", err)
+		return
+	}
+	swaps, err = querySubmarineSwaps(tx, fmt.Sprintf("state='%s'", state))
+	return
+}
+
+// querySubmarineSwaps gets the submarine swaps matching a condition, oldest first
+func querySubmarineSwaps(tx *sql.Tx, condition string) (swaps []*match.SubmarineSwap, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq;", submarineSwapColumns, submarineSwapsTable, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying submarine swaps: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		swap := new(match.SubmarineSwap)
+		var rhashString, preimageString, pkString, exchangeKeyString, directionString, outpointString, spendTxidString, stateString, reasonString string
+		var created, updated int64
+		if err = rows.Scan(&rhashString, &preimageString, &pkString, &exchangeKeyString, &directionString, &swap.Asset, &swap.Amount, &swap.ChainLocktime,
+			&swap.ChannelLocktime, &outpointString, &swap.FundAmount, &swap.FundHeight, &spendTxidString, &swap.SpendHeight, &stateString, &reasonString,
+			&created, &updated); err != nil {
+			err = fmt.Errorf("Error scanning submarine swap: %s", err)
+			return
+		}
+
+		var rhashBytes, preimageBytes, pkBytes, exchangeKeyBytes, outpointBytes, spendTxidBytes, reasonBytes []byte
+		if rhashBytes, err = hex.DecodeString(rhashString); err != nil {
+			err = fmt.Errorf("Error decoding submarine swap hash: %s", err)
+			return
+		}
+		if preimageBytes, err = hex.DecodeString(preimageString); err != nil {
+			err = fmt.Errorf("Error decoding preimage for submarine swap %s: %s", rhashString, err)
+			return
+		}
+		if pkBytes, err = hex.DecodeString(pkString); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for submarine swap %s: %s", rhashString, err)
+			return
+		}
+		if exchangeKeyBytes, err = hex.DecodeString(exchangeKeyString); err != nil {
+			err = fmt.Errorf("Error decoding exchange key for submarine swap %s: %s", rhashString, err)
+			return
+		}
+		if outpointBytes, err = hex.DecodeString(outpointString); err != nil {
+			err = fmt.Errorf("Error decoding outpoint for submarine swap %s: %s", rhashString, err)
+			return
+		}
+		if spendTxidBytes, err = hex.DecodeString(spendTxidString); err != nil {
+			err = fmt.Errorf("Error decoding spend txid for submarine swap %s: %s", rhashString, err)
+			return
+		}
+		if reasonBytes, err = hex.DecodeString(reasonString); err != nil {
+			err = fmt.Errorf("Error decoding reason for submarine swap %s: %s", rhashString, err)
+			return
+		}
+		if swap.Direction, err = match.SubmarineDirectionFromString(directionString); err != nil {
+			return
+		}
+		if swap.State, err = match.SubmarineStateFromString(stateString); err != nil {
+			return
+		}
+
+		copy(swap.RHash[:], rhashBytes)
+		copy(swap.Preimage[:], preimageBytes)
+		copy(swap.Pubkey[:], pkBytes)
+		copy(swap.ExchangeKey[:], exchangeKeyBytes)
+		swap.Outpoint = string(outpointBytes)
+		swap.SpendTxid = string(spendTxidBytes)
+		swap.Reason = string(reasonBytes)
+		swap.Created = time.Unix(0, created)
+		swap.Updated = time.Unix(0, updated)
+		swaps = append(swaps, swap)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading submarine swap rows: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package cxdbsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestSubmarineStoreStates adds submarine swaps, moves one along, and checks they can be looked
+// up by hash, by pubkey and by state
+func TestSubmarineStoreStates(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var ss *SQLSubmarineStore
+	if ss, err = CreateSubmarineStoreStructWithConf(testConfig()); err != nil {
+		t.Errorf("Error creating submarine swap store: %s", err)
+		return
+	}
+	defer ss.DestroyHandler()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+	var pubkey [33]byte
+	copy(pubkey[:], priv.PubKey().SerializeCompressed())
+
+	created := time.Unix(1500000000, 0)
+	var swaps []*match.SubmarineSwap
+	for i, direction := range []match.SubmarineDirection{match.SubmarineIn, match.SubmarineOut} {
+		request := &match.SubmarineRequest{Direction: direction, Asset: match.BTCTest, Amount: 100000, RHash: [32]byte{byte(i + 1)}}
+		var swap *match.SubmarineSwap
+		if swap, err = match.NewSubmarineSwap(request, pubkey, [33]byte{0x02}, 100, match.DefaultSwapPolicy(), created); err != nil {
+			t.Errorf("Error creating submarine swap: %s", err)
+			return
+		}
+		if err = ss.AddSubmarineSwap(swap); err != nil {
+			t.Errorf("Error adding submarine swap: %s", err)
+			return
+		}
+		swaps = append(swaps, swap)
+	}
+
+	swaps[0].Outpoint = "0011:1"
+	swaps[0].FundAmount = 100000
+	swaps[0].FundHeight = 110
+	swaps[0].SetState(match.SubmarineRefunded, "user's payment never came", created.Add(time.Minute))
+	if err = ss.UpdateSubmarineSwap(swaps[0]); err != nil {
+		t.Errorf("Error updating submarine swap: %s", err)
+		return
+	}
+
+	var swap *match.SubmarineSwap
+	if swap, err = ss.GetSubmarineSwap(swaps[0].RHash); err != nil {
+		t.Errorf("Error getting submarine swap: %s", err)
+		return
+	}
+	if swap.State != match.SubmarineRefunded || swap.Reason != swaps[0].Reason || swap.Outpoint != "0011:1" || swap.FundHeight != 110 || swap.Direction != match.SubmarineIn {
+		t.Errorf("Submarine swap should be refunded with its reason and keep its outpoint, got %s", swap)
+	}
+
+	var waiting []*match.SubmarineSwap
+	if waiting, err = ss.GetSubmarineSwapsByState(match.SubmarineCreated); err != nil {
+		t.Errorf("Error getting created submarine swaps: %s", err)
+		return
+	}
+	if len(waiting) != 1 || waiting[0].RHash != swaps[1].RHash || waiting[0].ChannelLocktime != swaps[1].ChannelLocktime {
+		t.Errorf("Only the second submarine swap should be created, got %d created", len(waiting))
+	}
+
+	var all []*match.SubmarineSwap
+	if all, err = ss.GetSubmarineSwaps(priv.PubKey()); err != nil {
+		t.Errorf("Error getting submarine swaps: %s", err)
+		return
+	}
+	if len(all) != 2 {
+		t.Errorf("Pubkey should have 2 submarine swaps, got %d", len(all))
+	}
+
+	if err = ss.UpdateSubmarineSwap(&match.SubmarineSwap{State: match.SubmarineExpired}); err == nil {
+		t.Errorf("Updating a submarine swap that doesn't exist should fail")
+	}
+}
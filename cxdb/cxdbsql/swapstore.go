@@ -0,0 +1,692 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLSwapStore keeps swaps, swap orders and escrows for every pair in SQL. Rows are never
+// deleted, swaps just end up completed, refunded or failed, and escrows completed, refunded or
+// expired.
+type SQLSwapStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// swap schema name
+	swapSchemaName string
+}
+
+// The swap tables are shared between every pair. Times are unix nanoseconds like the history
+// tables, and the reason is stored as hex like it is for withdrawals.
+const (
+	swapsTable       = "swaps"
+	swapOrdersTable  = "swaporders"
+	swapsSchema      = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, rhash VARCHAR(64) NOT NULL, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, orderID VARCHAR(64) NOT NULL, assetWant TINYINT UNSIGNED, assetHave TINYINT UNSIGNED, buy BOOLEAN, sendAsset TINYINT UNSIGNED, amountSend BIGINT UNSIGNED, sendLocktime INT UNSIGNED, receiveAsset TINYINT UNSIGNED, amountReceive BIGINT UNSIGNED, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT, PRIMARY KEY (seq), UNIQUE KEY (rhash), KEY (pubkey), KEY (state)"
+	swapOrdersSchema = "orderID VARCHAR(64) NOT NULL, PRIMARY KEY (orderID)"
+
+	// the columns we select for swaps, in the order querySwaps scans them
+	swapColumns = "rhash, preimage, pubkey, orderID, assetWant, assetHave, buy, sendAsset, amountSend, sendLocktime, receiveAsset, amountReceive, state, reason, created, updated"
+
+	// escrows have an empty order ID until their order is on the book
+	escrowsTable  = "escrows"
+	escrowsSchema = "seq BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, rhash VARCHAR(64) NOT NULL, preimage VARCHAR(32) NOT NULL, pubkey VARCHAR(66) NOT NULL, assetWant TINYINT UNSIGNED, assetHave TINYINT UNSIGNED, buy BOOLEAN, amountHave BIGINT UNSIGNED, amountWant BIGINT UNSIGNED, orderID VARCHAR(64) NOT NULL, lockAsset TINYINT UNSIGNED, amountLock BIGINT UNSIGNED, locktime INT UNSIGNED, amountFilled BIGINT UNSIGNED, receiveAsset TINYINT UNSIGNED, amountReceive BIGINT UNSIGNED, receiveLocktime INT UNSIGNED, amountRefund BIGINT UNSIGNED, refundLocktime INT UNSIGNED, state VARCHAR(16) NOT NULL, reason TEXT, created BIGINT, updated BIGINT, PRIMARY KEY (seq), UNIQUE KEY (rhash), KEY (pubkey), KEY (orderID), KEY (state)"
+
+	// the columns we select for escrows, in the order queryEscrows scans them
+	escrowColumns = "rhash, preimage, pubkey, assetWant, assetHave, buy, amountHave, amountWant, orderID, lockAsset, amountLock, locktime, amountFilled, receiveAsset, amountReceive, receiveLocktime, amountRefund, refundLocktime, state, reason, created, updated"
+)
+
+// CreateSwapStoreStructWithConf creates a swap store, returning the struct rather than the
+// interface.
+func CreateSwapStoreStructWithConf(conf *dbsqlConfig) (ss *SQLSwapStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	ss = &SQLSwapStore{
+		dbUsername:     conf.DBUsername,
+		dbPassword:     conf.DBPassword,
+		swapSchemaName: conf.SwapSchemaName,
+		dbAddr:         addr,
+	}
+
+	if err = ss.setupSwapTables(); err != nil {
+		err = fmt.Errorf("Error setting up swap tables for CreateSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ss.dbUsername, ss.dbPassword, ss.dbAddr.Network(), ss.dbAddr.String())
+	if ss.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateSwapStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ss.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// CreateSwapStore creates a swap store for every pair
+func CreateSwapStore() (store cxdb.SwapStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGSwapStoreStructWithConf(conf); err != nil {
+			err = fmt.Errorf("Error creating postgres swap store struct for CreateSwapStore: %s", err)
+			return
+		}
+		return
+	}
+
+	if store, err = CreateSwapStoreStructWithConf(conf); err != nil {
+		err = fmt.Errorf("Error creating swap store struct for CreateSwapStore: %s", err)
+		return
+	}
+	return
+}
+
+// setupSwapTables sets up the tables for swaps, swap orders and escrows.
+// This assumes everything else is set
+func (ss *SQLSwapStore) setupSwapTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ss.dbUsername, ss.dbPassword, ss.dbAddr.Network(), ss.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup swap tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup swap tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating swap tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup swap tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + ss.swapSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.swapSchemaName, err)
+		return
+	}
+
+	createSwapsQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", swapsTable, swapsSchema)
+	if _, err = tx.Exec(createSwapsQuery); err != nil {
+		err = fmt.Errorf("Error creating swap table: %s", err)
+		return
+	}
+
+	createOrdersQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", swapOrdersTable, swapOrdersSchema)
+	if _, err = tx.Exec(createOrdersQuery); err != nil {
+		err = fmt.Errorf("Error creating swap order table: %s", err)
+		return
+	}
+
+	createEscrowsQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", escrowsTable, escrowsSchema)
+	if _, err = tx.Exec(createEscrowsQuery); err != nil {
+		err = fmt.Errorf("Error creating escrow table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *SQLSwapStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new swap store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing swap store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the swap schema. If the returned error is nil, the
+// caller has to call finishSwapTx with its own error, which commits or rolls back.
+func (ss *SQLSwapStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + ss.swapSchemaName + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using swap schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// finishSwapTx commits the transaction if there was no error and rolls it back if there was
+func finishSwapTx(tx *sql.Tx, funcName string, err error) error {
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error with %s: \n%s", funcName, err)
+	}
+	return tx.Commit()
+}
+
+// AddSwap stores a new swap
+func (ss *SQLSwapStore) AddSwap(swap *match.Swap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddSwap", err)
+	}()
+
+	err = insertSwap(tx, swap)
+	return
+}
+
+// UpdateSwap saves the state, reason and update time of a stored swap
+func (ss *SQLSwapStore) UpdateSwap(swap *match.Swap) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateSwap", err)
+	}()
+
+	err = updateSwap(tx, swap)
+	return
+}
+
+// GetSwap gets a swap by its hash
+func (ss *SQLSwapStore) GetSwap(rhash [32]byte) (swap *match.Swap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSwap"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSwap", err)
+	}()
+
+	swap, err = getSwap(tx, rhash)
+	return
+}
+
+// GetSwaps gets every swap for a pubkey, oldest first
+func (ss *SQLSwapStore) GetSwaps(pubkey *koblitz.PublicKey) (swaps []*match.Swap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSwaps"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSwaps", err)
+	}()
+
+	swaps, err = querySwaps(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetSwapsByState gets every swap in a state, oldest first
+func (ss *SQLSwapStore) GetSwapsByState(state match.SwapState) (swaps []*match.Swap, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetSwapsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetSwapsByState", err)
+	}()
+
+	swaps, err = querySwapsByState(tx, state)
+	return
+}
+
+// AddSwapOrder marks an order as a swap order
+func (ss *SQLSwapStore) AddSwapOrder(orderID *match.OrderID) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddSwapOrder"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddSwapOrder", err)
+	}()
+
+	insertQuery := fmt.Sprintf("INSERT IGNORE INTO %s (orderID) VALUES ('%x');", swapOrdersTable, orderID[:])
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting swap order: %s", err)
+		return
+	}
+	return
+}
+
+// IsSwapOrder returns true if an order is a swap order
+func (ss *SQLSwapStore) IsSwapOrder(orderID *match.OrderID) (swapOrder bool, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("IsSwapOrder"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "IsSwapOrder", err)
+	}()
+
+	swapOrder, err = isSwapOrder(tx, orderID)
+	return
+}
+
+// AddEscrow stores a new escrow
+func (ss *SQLSwapStore) AddEscrow(escrow *match.OrderEscrow) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("AddEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "AddEscrow", err)
+	}()
+
+	err = insertEscrow(tx, escrow)
+	return
+}
+
+// UpdateEscrow saves the order ID, release, state, reason and update time of a stored escrow
+func (ss *SQLSwapStore) UpdateEscrow(escrow *match.OrderEscrow) (err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("UpdateEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "UpdateEscrow", err)
+	}()
+
+	err = updateEscrow(tx, escrow)
+	return
+}
+
+// GetEscrow gets an escrow by its hash
+func (ss *SQLSwapStore) GetEscrow(rhash [32]byte) (escrow *match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetEscrow", err)
+	}()
+
+	escrow, err = getEscrow(tx, rhash)
+	return
+}
+
+// GetOrderEscrow gets the escrow of a channel order, escrow is nil if the order doesn't have one
+func (ss *SQLSwapStore) GetOrderEscrow(orderID *match.OrderID) (escrow *match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetOrderEscrow"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetOrderEscrow", err)
+	}()
+
+	escrow, err = getOrderEscrow(tx, orderID)
+	return
+}
+
+// GetEscrows gets every escrow for a pubkey, oldest first
+func (ss *SQLSwapStore) GetEscrows(pubkey *koblitz.PublicKey) (escrows []*match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetEscrows"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetEscrows", err)
+	}()
+
+	escrows, err = queryEscrows(tx, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetEscrowsByState gets every escrow in a state, oldest first
+func (ss *SQLSwapStore) GetEscrowsByState(state match.EscrowState) (escrows []*match.OrderEscrow, err error) {
+	var tx *sql.Tx
+	if tx, err = ss.begin("GetEscrowsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishSwapTx(tx, "GetEscrowsByState", err)
+	}()
+
+	escrows, err = queryEscrowsByState(tx, state)
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres swap stores, the queries are the same
+// once the transaction is using the swap schema.
+
+// insertSwap inserts a new swap into the swap table
+func insertSwap(tx *sql.Tx, swap *match.Swap) (err error) {
+	if _, err = match.SwapStateFromString(string(swap.State)); err != nil {
+		err = fmt.Errorf("Error with swap state: %s", err)
+		return
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES ('%x', '%x', '%x', '%x', %d, %d, %t, %d, %d, %d, %d, %d, '%s', '%x', %d, %d);",
+		swapsTable, swapColumns, swap.RHash[:], swap.Preimage[:], swap.Pubkey[:], swap.OrderID[:], swap.TradingPair.AssetWant, swap.TradingPair.AssetHave, swap.Side == match.Buy,
+		swap.SendAsset, swap.AmountSend, swap.SendLocktime, swap.ReceiveAsset, swap.AmountReceive, swap.State, swap.Reason, swap.Created.UnixNano(), swap.Updated.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting swap %s: %s", swap.ID(), err)
+		return
+	}
+	return
+}
+
+// updateSwap writes the state, reason and update time of a swap
+func updateSwap(tx *sql.Tx, swap *match.Swap) (err error) {
+	if _, err = match.SwapStateFromString(string(swap.State)); err != nil {
+		err = fmt.Errorf("Error with swap state: %s", err)
+		return
+	}
+
+	// check the row is there first, mysql only counts rows that changed
+	if _, err = getSwap(tx, swap.RHash); err != nil {
+		return
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET state='%s', reason='%x', updated=%d WHERE rhash='%x';",
+		swapsTable, swap.State, swap.Reason, swap.Updated.UnixNano(), swap.RHash[:])
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating swap %s: %s", swap.ID(), err)
+		return
+	}
+	return
+}
+
+// getSwap gets a single swap by its hash
+func getSwap(tx *sql.Tx, rhash [32]byte) (swap *match.Swap, err error) {
+	var swaps []*match.Swap
+	if swaps, err = querySwaps(tx, fmt.Sprintf("rhash='%x'", rhash)); err != nil {
+		return
+	}
+	if len(swaps) == 0 {
+		err = fmt.Errorf("No swap %x", rhash)
+		return
+	}
+	swap = swaps[0]
+	return
+}
+
+// isSwapOrder checks the swap order table for an order
+func isSwapOrder(tx *sql.Tx, orderID *match.OrderID) (swapOrder bool, err error) {
+	var count uint64
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE orderID='%x';", swapOrdersTable, orderID[:])
+	if err = tx.QueryRow(countQuery).Scan(&count); err != nil {
+		err = fmt.Errorf("Error checking for swap order %x: %s", orderID[:], err)
+		return
+	}
+	swapOrder = count != 0
+	return
+}
+
+// querySwapsByState gets every swap in a state, oldest first
+func querySwapsByState(tx *sql.Tx, state match.SwapState) (swaps []*match.Swap, err error) {
+	if _, err = match.SwapStateFromString(string(state)); err != nil {
+		err = fmt.Errorf("Error with state for querying swaps: %s", err)
+		return
+	}
+	swaps, err = querySwaps(tx, fmt.Sprintf("state='%s'", state))
+	return
+}
+
+// querySwaps gets the swaps matching a condition, oldest first
+func querySwaps(tx *sql.Tx, condition string) (swaps []*match.Swap, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq;", swapColumns, swapsTable, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying swaps: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		swap := new(match.Swap)
+		var rhashString, preimageString, pkString, orderIDString, stateString, reasonString string
+		var buy bool
+		var created, updated int64
+		if err = rows.Scan(&rhashString, &preimageString, &pkString, &orderIDString, &swap.TradingPair.AssetWant, &swap.TradingPair.AssetHave, &buy,
+			&swap.SendAsset, &swap.AmountSend, &swap.SendLocktime, &swap.ReceiveAsset, &swap.AmountReceive, &stateString, &reasonString, &created, &updated); err != nil {
+			err = fmt.Errorf("Error scanning swap: %s", err)
+			return
+		}
+
+		var rhashBytes, preimageBytes, pkBytes, reasonBytes []byte
+		if rhashBytes, err = hex.DecodeString(rhashString); err != nil {
+			err = fmt.Errorf("Error decoding swap hash: %s", err)
+			return
+		}
+		if preimageBytes, err = hex.DecodeString(preimageString); err != nil {
+			err = fmt.Errorf("Error decoding preimage for swap %s: %s", rhashString, err)
+			return
+		}
+		if pkBytes, err = hex.DecodeString(pkString); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for swap %s: %s", rhashString, err)
+			return
+		}
+		if reasonBytes, err = hex.DecodeString(reasonString); err != nil {
+			err = fmt.Errorf("Error decoding reason for swap %s: %s", rhashString, err)
+			return
+		}
+		if err = swap.OrderID.UnmarshalText([]byte(orderIDString)); err != nil {
+			return
+		}
+		if swap.State, err = match.SwapStateFromString(stateString); err != nil {
+			return
+		}
+
+		copy(swap.RHash[:], rhashBytes)
+		copy(swap.Preimage[:], preimageBytes)
+		copy(swap.Pubkey[:], pkBytes)
+		swap.Side = match.Side(buy)
+		swap.Reason = string(reasonBytes)
+		swap.Created = time.Unix(0, created)
+		swap.Updated = time.Unix(0, updated)
+		swaps = append(swaps, swap)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading swap rows: %s", err)
+		return
+	}
+	return
+}
+
+// escrowOrderID is the order ID column for an escrow, which is empty until its order is on the
+// book
+func escrowOrderID(escrow *match.OrderEscrow) string {
+	if escrow.OrderID == (match.OrderID{}) {
+		return ""
+	}
+	return hex.EncodeToString(escrow.OrderID[:])
+}
+
+// insertEscrow inserts a new escrow into the escrow table
+func insertEscrow(tx *sql.Tx, escrow *match.OrderEscrow) (err error) {
+	if _, err = match.EscrowStateFromString(string(escrow.State)); err != nil {
+		err = fmt.Errorf("Error with escrow state: %s", err)
+		return
+	}
+	order := escrow.Order
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES ('%x', '%x', '%x', %d, %d, %t, %d, %d, '%s', %d, %d, %d, %d, %d, %d, %d, %d, %d, '%s', '%x', %d, %d);",
+		escrowsTable, escrowColumns, escrow.RHash[:], escrow.Preimage[:], escrow.Pubkey[:], order.TradingPair.AssetWant, order.TradingPair.AssetHave, order.Side == match.Buy, order.AmountHave, order.AmountWant,
+		escrowOrderID(escrow), escrow.LockAsset, escrow.AmountLock, escrow.Locktime, escrow.AmountFilled, escrow.ReceiveAsset, escrow.AmountReceive, escrow.ReceiveLocktime, escrow.AmountRefund, escrow.RefundLocktime,
+		escrow.State, escrow.Reason, escrow.Created.UnixNano(), escrow.Updated.UnixNano())
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting escrow %s: %s", escrow.ID(), err)
+		return
+	}
+	return
+}
+
+// updateEscrow writes the order ID, release, state, reason and update time of an escrow
+func updateEscrow(tx *sql.Tx, escrow *match.OrderEscrow) (err error) {
+	if _, err = match.EscrowStateFromString(string(escrow.State)); err != nil {
+		err = fmt.Errorf("Error with escrow state: %s", err)
+		return
+	}
+
+	// check the row is there first, mysql only counts rows that changed
+	if _, err = getEscrow(tx, escrow.RHash); err != nil {
+		return
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET orderID='%s', amountFilled=%d, amountReceive=%d, receiveLocktime=%d, amountRefund=%d, refundLocktime=%d, state='%s', reason='%x', updated=%d WHERE rhash='%x';",
+		escrowsTable, escrowOrderID(escrow), escrow.AmountFilled, escrow.AmountReceive, escrow.ReceiveLocktime, escrow.AmountRefund, escrow.RefundLocktime,
+		escrow.State, escrow.Reason, escrow.Updated.UnixNano(), escrow.RHash[:])
+	if _, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating escrow %s: %s", escrow.ID(), err)
+		return
+	}
+	return
+}
+
+// getEscrow gets a single escrow by its hash
+func getEscrow(tx *sql.Tx, rhash [32]byte) (escrow *match.OrderEscrow, err error) {
+	var escrows []*match.OrderEscrow
+	if escrows, err = queryEscrows(tx, fmt.Sprintf("rhash='%x'", rhash)); err != nil {
+		return
+	}
+	if len(escrows) == 0 {
+		err = fmt.Errorf("No escrow %x", rhash)
+		return
+	}
+	escrow = escrows[0]
+	return
+}
+
+// getOrderEscrow gets the escrow of an order, escrow is nil if there isn't one
+func getOrderEscrow(tx *sql.Tx, orderID *match.OrderID) (escrow *match.OrderEscrow, err error) {
+	var escrows []*match.OrderEscrow
+	if escrows, err = queryEscrows(tx, fmt.Sprintf("orderID='%x'", orderID[:])); err != nil {
+		return
+	}
+	if len(escrows) != 0 {
+		escrow = escrows[0]
+	}
+	return
+}
+
+// queryEscrowsByState gets every escrow in a state, oldest first
+func queryEscrowsByState(tx *sql.Tx, state match.EscrowState) (escrows []*match.OrderEscrow, err error) {
+	if _, err = match.EscrowStateFromString(string(state)); err != nil {
+		err = fmt.Errorf("Error with state for querying escrows: %s", err)
+		return
+	}
+	escrows, err = queryEscrows(tx, fmt.Sprintf("state='%s'", state))
+	return
+}
+
+// queryEscrows gets the escrows matching a condition, oldest first
+func queryEscrows(tx *sql.Tx, condition string) (escrows []*match.OrderEscrow, err error) {
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY seq;", escrowColumns, escrowsTable, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying escrows: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		escrow := new(match.OrderEscrow)
+		order := &escrow.Order
+		var rhashString, preimageString, pkString, orderIDString, stateString, reasonString string
+		var buy bool
+		var created, updated int64
+		if err = rows.Scan(&rhashString, &preimageString, &pkString, &order.TradingPair.AssetWant, &order.TradingPair.AssetHave, &buy, &order.AmountHave, &order.AmountWant,
+			&orderIDString, &escrow.LockAsset, &escrow.AmountLock, &escrow.Locktime, &escrow.AmountFilled, &escrow.ReceiveAsset, &escrow.AmountReceive, &escrow.ReceiveLocktime,
+			&escrow.AmountRefund, &escrow.RefundLocktime, &stateString, &reasonString, &created, &updated); err != nil {
+			err = fmt.Errorf("Error scanning escrow: %s", err)
+			return
+		}
+
+		var rhashBytes, preimageBytes, pkBytes, reasonBytes []byte
+		if rhashBytes, err = hex.DecodeString(rhashString); err != nil {
+			err = fmt.Errorf("Error decoding escrow hash: %s", err)
+			return
+		}
+		if preimageBytes, err = hex.DecodeString(preimageString); err != nil {
+			err = fmt.Errorf("Error decoding preimage for escrow %s: %s", rhashString, err)
+			return
+		}
+		if pkBytes, err = hex.DecodeString(pkString); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for escrow %s: %s", rhashString, err)
+			return
+		}
+		if reasonBytes, err = hex.DecodeString(reasonString); err != nil {
+			err = fmt.Errorf("Error decoding reason for escrow %s: %s", rhashString, err)
+			return
+		}
+		if orderIDString != "" {
+			if err = escrow.OrderID.UnmarshalText([]byte(orderIDString)); err != nil {
+				return
+			}
+		}
+		if escrow.State, err = match.EscrowStateFromString(stateString); err != nil {
+			return
+		}
+
+		copy(escrow.RHash[:], rhashBytes)
+		copy(escrow.Preimage[:], preimageBytes)
+		copy(escrow.Pubkey[:], pkBytes)
+		order.Pubkey = escrow.Pubkey
+		order.Side = match.Side(buy)
+		escrow.Reason = string(reasonBytes)
+		escrow.Created = time.Unix(0, created)
+		escrow.Updated = time.Unix(0, updated)
+		escrows = append(escrows, escrow)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading escrow rows: %s", err)
+		return
+	}
+	return
+}
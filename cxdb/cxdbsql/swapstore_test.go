@@ -0,0 +1,204 @@
+package cxdbsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestSwapStoreStates adds swaps, moves one along, and checks they can be looked up by hash, by
+// pubkey and by state
+func TestSwapStoreStates(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var ss *SQLSwapStore
+	if ss, err = CreateSwapStoreStructWithConf(testConfig()); err != nil {
+		t.Errorf("Error creating swap store: %s", err)
+		return
+	}
+	defer ss.DestroyHandler()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+
+	created := time.Unix(1500000000, 0)
+	var swaps []*match.Swap
+	for i := 0; i < 2; i++ {
+		fill := &match.FillEntry{
+			OrderID:     match.OrderID{byte(i + 1)},
+			TradingPair: match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest},
+			Side:        match.Buy,
+			AmountHave:  4000,
+			AmountWant:  1000,
+		}
+		copy(fill.Pubkey[:], priv.PubKey().SerializeCompressed())
+		var swap *match.Swap
+		if swap, err = match.NewSwap(fill, 100, match.DefaultSwapPolicy(), created); err != nil {
+			t.Errorf("Error creating swap: %s", err)
+			return
+		}
+		if err = ss.AddSwap(swap); err != nil {
+			t.Errorf("Error adding swap: %s", err)
+			return
+		}
+		swaps = append(swaps, swap)
+	}
+
+	swaps[0].SetState(match.SwapRefunded, "user's HTLCs never came", created.Add(time.Minute))
+	if err = ss.UpdateSwap(swaps[0]); err != nil {
+		t.Errorf("Error updating swap: %s", err)
+		return
+	}
+
+	var swap *match.Swap
+	if swap, err = ss.GetSwap(swaps[0].RHash); err != nil {
+		t.Errorf("Error getting swap: %s", err)
+		return
+	}
+	if swap.State != match.SwapRefunded || swap.Reason != swaps[0].Reason || swap.Preimage != swaps[0].Preimage || swap.OrderID != swaps[0].OrderID || swap.Side != match.Buy {
+		t.Errorf("Swap should be refunded with its reason and keep its preimage and order, got %s", swap)
+	}
+
+	var pending []*match.Swap
+	if pending, err = ss.GetSwapsByState(match.SwapPending); err != nil {
+		t.Errorf("Error getting pending swaps: %s", err)
+		return
+	}
+	if len(pending) != 1 || pending[0].RHash != swaps[1].RHash {
+		t.Errorf("Only the second swap should be pending, got %d pending", len(pending))
+	}
+
+	var all []*match.Swap
+	if all, err = ss.GetSwaps(priv.PubKey()); err != nil {
+		t.Errorf("Error getting swaps: %s", err)
+		return
+	}
+	if len(all) != 2 {
+		t.Errorf("Pubkey should have 2 swaps, got %d", len(all))
+	}
+
+	if err = ss.AddSwapOrder(&swaps[0].OrderID); err != nil {
+		t.Errorf("Error adding swap order: %s", err)
+		return
+	}
+	var swapOrder bool
+	if swapOrder, err = ss.IsSwapOrder(&swaps[0].OrderID); err != nil || !swapOrder {
+		t.Errorf("First order should be a swap order, got %t, %v", swapOrder, err)
+	}
+
+	if err = ss.UpdateSwap(&match.Swap{State: match.SwapFailed}); err == nil {
+		t.Errorf("Updating a swap that doesn't exist should fail")
+	}
+}
+
+// TestSwapStoreEscrows adds an escrow, commits and releases it, and checks it can be looked up by
+// hash, by order, by pubkey and by state
+func TestSwapStoreEscrows(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	var ss *SQLSwapStore
+	if ss, err = CreateSwapStoreStructWithConf(testConfig()); err != nil {
+		t.Errorf("Error creating swap store: %s", err)
+		return
+	}
+	defer ss.DestroyHandler()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+
+	created := time.Unix(1500000000, 0)
+	order := &match.LimitOrder{
+		TradingPair: match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest},
+		Side:        match.Buy,
+		AmountHave:  4000,
+		AmountWant:  1000,
+	}
+	copy(order.Pubkey[:], priv.PubKey().SerializeCompressed())
+	var escrow *match.OrderEscrow
+	if escrow, err = match.NewOrderEscrow(order, 100, match.DefaultSwapPolicy(), created); err != nil {
+		t.Errorf("Error creating escrow: %s", err)
+		return
+	}
+	if err = ss.AddEscrow(escrow); err != nil {
+		t.Errorf("Error adding escrow: %s", err)
+		return
+	}
+
+	var stored *match.OrderEscrow
+	if stored, err = ss.GetEscrow(escrow.RHash); err != nil {
+		t.Errorf("Error getting escrow: %s", err)
+		return
+	}
+	if stored.State != match.EscrowPending || stored.OrderID != (match.OrderID{}) || stored.Order != *order {
+		t.Errorf("Escrow should be pending without an order ID and keep its order, got %s", stored)
+	}
+
+	escrow.OrderID = match.OrderID{0x01}
+	escrow.SetState(match.EscrowCommitted, "", created.Add(time.Minute))
+	if err = escrow.Release(1000, 250, 200, 110, match.DefaultSwapPolicy(), created.Add(2*time.Minute)); err != nil {
+		t.Errorf("Error releasing escrow: %s", err)
+		return
+	}
+	if err = ss.UpdateEscrow(escrow); err != nil {
+		t.Errorf("Error updating escrow: %s", err)
+		return
+	}
+
+	if stored, err = ss.GetOrderEscrow(&escrow.OrderID); err != nil || stored == nil {
+		t.Errorf("Error getting escrow for order: %v", err)
+		return
+	}
+	if stored.State != match.EscrowReleasing || stored.AmountReceive != 250 || stored.AmountRefund != 3000 || stored.Preimage != escrow.Preimage {
+		t.Errorf("Escrow should be releasing 250 and refunding 3000, got %s", stored)
+	}
+	if stored, err = ss.GetOrderEscrow(&match.OrderID{0x02}); err != nil || stored != nil {
+		t.Errorf("Other order shouldn't have an escrow, got %v, %v", stored, err)
+	}
+
+	var releasing []*match.OrderEscrow
+	if releasing, err = ss.GetEscrowsByState(match.EscrowReleasing); err != nil || len(releasing) != 1 {
+		t.Errorf("Escrow should be releasing, got %d, %v", len(releasing), err)
+	}
+	var all []*match.OrderEscrow
+	if all, err = ss.GetEscrows(priv.PubKey()); err != nil || len(all) != 1 {
+		t.Errorf("Pubkey should have 1 escrow, got %d, %v", len(all), err)
+	}
+
+	if err = ss.UpdateEscrow(&match.OrderEscrow{State: match.EscrowExpired}); err == nil {
+		t.Errorf("Updating an escrow that doesn't exist should fail")
+	}
+}
@@ -0,0 +1,594 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SQLWithdrawalStore keeps withdrawal requests for a coin in SQL. Rows are never deleted,
+// withdrawals just end up confirmed or failed.
+type SQLWithdrawalStore struct {
+	DBHandler *sql.DB
+
+	// db username and password
+	dbUsername string
+	dbPassword string
+
+	// db host and port
+	dbAddr net.Addr
+
+	// withdrawal schema name
+	withdrawalSchemaName string
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// Each coin gets a table in the withdrawal schema. Times are unix nanoseconds like the history
+// tables. The address and reason come from users and error messages, so they're stored as hex.
+const (
+	withdrawalStoreSchema = "id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, pubkey VARCHAR(66) NOT NULL, amount BIGINT UNSIGNED, address TEXT, state VARCHAR(16) NOT NULL, txid VARCHAR(64), reason TEXT, requested BIGINT, updated BIGINT, lightning BOOLEAN, nonce BIGINT UNSIGNED, domain TEXT, signature TEXT, priority TINYINT UNSIGNED, payfee BOOLEAN, fee BIGINT UNSIGNED, PRIMARY KEY (id), KEY (pubkey), KEY (state)"
+
+	// the columns we select for withdrawals, in the order queryWithdrawals scans them
+	withdrawalColumns = "id, pubkey, amount, address, state, txid, reason, requested, updated, lightning, nonce, domain, signature, priority, payfee, fee"
+
+	// withdrawal transactions that haven't confirmed yet are in another table for the coin, keyed
+	// by the txid of their first version. This is the same for mysql and postgres.
+	withdrawalBatchSchema = "txid VARCHAR(64) NOT NULL, encodedBatch TEXT, PRIMARY KEY (txid)"
+)
+
+// CreateWithdrawalStoreStructWithConf creates a withdrawal store for a coin, returning the struct
+// rather than the interface.
+func CreateWithdrawalStoreStructWithConf(coin *coinparam.Params, conf *dbsqlConfig) (ws *SQLWithdrawalStore, err error) {
+
+	// set the default conf
+	dbConfigSetup(conf)
+
+	// Resolve new address
+	var addr net.Addr
+	if addr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(conf.DBHost, fmt.Sprintf("%d", conf.DBPort))); err != nil {
+		err = fmt.Errorf("Couldn't resolve db address for CreateWithdrawalStoreStructWithConf: %s", err)
+		return
+	}
+
+	ws = &SQLWithdrawalStore{
+		dbUsername:           conf.DBUsername,
+		dbPassword:           conf.DBPassword,
+		withdrawalSchemaName: conf.WithdrawalSchemaName,
+
+		dbAddr: addr,
+		coin:   coin,
+	}
+
+	if err = ws.setupWithdrawalTables(); err != nil {
+		err = fmt.Errorf("Error setting up withdrawal tables for CreateWithdrawalStoreStructWithConf: %s", err)
+		return
+	}
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ws.dbUsername, ws.dbPassword, ws.dbAddr.Network(), ws.dbAddr.String())
+	if ws.DBHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for CreateWithdrawalStoreStructWithConf: %s", err)
+		return
+	}
+
+	// Make sure we can actually connect
+	if err = ws.DBHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	return
+}
+
+// CreateWithdrawalStore creates a withdrawal store for a coin
+func CreateWithdrawalStore(coin *coinparam.Params) (store cxdb.WithdrawalStore, err error) {
+
+	conf := new(dbsqlConfig)
+	*conf = *defaultConf
+
+	// Set the default conf so we know which driver to use
+	dbConfigSetup(conf)
+
+	if conf.DBDriver == postgresDriver {
+		if store, err = CreatePGWithdrawalStoreStructWithConf(coin, conf); err != nil {
+			err = fmt.Errorf("Error creating postgres withdrawal store struct for CreateWithdrawalStore: %s", err)
+			return
+		}
+		return
+	}
+
+	if store, err = CreateWithdrawalStoreStructWithConf(coin, conf); err != nil {
+		err = fmt.Errorf("Error creating withdrawal store struct for CreateWithdrawalStore: %s", err)
+		return
+	}
+	return
+}
+
+// setupWithdrawalTables sets up the table for this coin's withdrawals.
+// This assumes everything else is set
+func (ws *SQLWithdrawalStore) setupWithdrawalTables() (err error) {
+
+	openString := fmt.Sprintf("%s:%s@%s(%s)/", ws.dbUsername, ws.dbPassword, ws.dbAddr.Network(), ws.dbAddr.String())
+	var rootHandler *sql.DB
+	if rootHandler, err = sql.Open("mysql", openString); err != nil {
+		err = fmt.Errorf("Error opening database for setup withdrawal tables: %s", err)
+		return
+	}
+
+	// when we're done close please
+	defer rootHandler.Close()
+
+	if err = rootHandler.Ping(); err != nil {
+		err = fmt.Errorf("Could not ping the database, is it running: %s", err)
+		return
+	}
+
+	// We do this in a transaction because it's more than one operation
+	var tx *sql.Tx
+	if tx, err = rootHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for setup withdrawal tables: %s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while creating withdrawal tables: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// Now create the schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ws.withdrawalSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Error creating schema for setup withdrawal tables: %s", err)
+		return
+	}
+
+	// use the schema
+	if _, err = tx.Exec("USE " + ws.withdrawalSchemaName + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ws.withdrawalSchemaName, err)
+		return
+	}
+
+	createTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ws.coin.Name, withdrawalStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating withdrawal table: %s", err)
+		return
+	}
+
+	createBatchTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", withdrawalBatchTable(ws.coin), withdrawalBatchSchema)
+	if _, err = tx.Exec(createBatchTableQuery); err != nil {
+		err = fmt.Errorf("Error creating withdrawal batch table: %s", err)
+		return
+	}
+	return
+}
+
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ws *SQLWithdrawalStore) DestroyHandler() (err error) {
+	if ws.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new withdrawal store")
+		return
+	}
+	if err = ws.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing withdrawal store handler for DestroyHandler: %s", err)
+		return
+	}
+	ws.DBHandler = nil
+	return
+}
+
+// begin starts a transaction that uses the withdrawal schema. If the returned error is nil, the
+// caller has to call finishWithdrawalTx with its own error, which commits or rolls back.
+func (ws *SQLWithdrawalStore) begin(funcName string) (tx *sql.Tx, err error) {
+	if tx, err = ws.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error when beginning transaction for %s: %s", funcName, err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + ws.withdrawalSchemaName + ";"); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Error using withdrawal schema for %s: %s", funcName, err)
+		return
+	}
+	return
+}
+
+// finishWithdrawalTx commits the transaction if there was no error and rolls it back if there was
+func finishWithdrawalTx(tx *sql.Tx, funcName string, err error) error {
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error with %s: \n%s", funcName, err)
+	}
+	return tx.Commit()
+}
+
+// AddWithdrawal stores a new withdrawal request and sets its ID
+func (ws *SQLWithdrawalStore) AddWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("AddWithdrawal"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "AddWithdrawal", err)
+	}()
+
+	var insertQuery string
+	if insertQuery, err = insertWithdrawalQuery(ws.coin.Name, withdrawal); err != nil {
+		return
+	}
+
+	var res sql.Result
+	if res, err = tx.Exec(insertQuery + ";"); err != nil {
+		err = fmt.Errorf("Error inserting withdrawal: %s", err)
+		return
+	}
+
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		err = fmt.Errorf("Error getting ID of inserted withdrawal: %s", err)
+		return
+	}
+	withdrawal.ID = uint64(id)
+	return
+}
+
+// UpdateWithdrawal saves the state, txid, reason, fee and update time of a stored withdrawal request
+func (ws *SQLWithdrawalStore) UpdateWithdrawal(withdrawal *match.WithdrawalRequest) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("UpdateWithdrawal"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "UpdateWithdrawal", err)
+	}()
+
+	err = updateWithdrawal(tx, ws.coin.Name, withdrawal)
+	return
+}
+
+// GetWithdrawal gets a withdrawal request by ID
+func (ws *SQLWithdrawalStore) GetWithdrawal(id uint64) (withdrawal *match.WithdrawalRequest, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawal"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawal", err)
+	}()
+
+	withdrawal, err = getWithdrawal(tx, ws.coin, id)
+	return
+}
+
+// GetWithdrawals gets every withdrawal request for a pubkey, oldest first
+func (ws *SQLWithdrawalStore) GetWithdrawals(pubkey *koblitz.PublicKey) (withdrawals []*match.WithdrawalRequest, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawals"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawals", err)
+	}()
+
+	withdrawals, err = queryWithdrawals(tx, ws.coin, fmt.Sprintf("pubkey='%x'", pubkey.SerializeCompressed()))
+	return
+}
+
+// GetWithdrawalsByState gets every withdrawal request in a state, oldest first
+func (ws *SQLWithdrawalStore) GetWithdrawalsByState(state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawalsByState"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawalsByState", err)
+	}()
+
+	withdrawals, err = queryWithdrawalsByState(tx, ws.coin, state)
+	return
+}
+
+// PutWithdrawalBatch stores a withdrawal transaction that hasn't confirmed yet, replacing it if
+// it's already stored
+func (ws *SQLWithdrawalStore) PutWithdrawalBatch(batch *match.WithdrawalBatch) (err error) {
+	var encodedBatch string
+	if encodedBatch, err = encodeWithdrawalBatch(batch); err != nil {
+		err = fmt.Errorf("Error encoding withdrawal batch for PutWithdrawalBatch: %s", err)
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = ws.begin("PutWithdrawalBatch"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "PutWithdrawalBatch", err)
+	}()
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s VALUES ('%s', '%s') ON DUPLICATE KEY UPDATE encodedBatch='%[3]s';", withdrawalBatchTable(ws.coin), batch.Txid, encodedBatch)
+	if _, err = tx.Exec(insertQuery); err != nil {
+		err = fmt.Errorf("Error inserting withdrawal batch %s: %s", batch.Txid, err)
+		return
+	}
+	return
+}
+
+// GetWithdrawalBatches gets every withdrawal transaction that hasn't confirmed yet, by txid
+func (ws *SQLWithdrawalStore) GetWithdrawalBatches() (batches []*match.WithdrawalBatch, err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("GetWithdrawalBatches"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "GetWithdrawalBatches", err)
+	}()
+
+	batches, err = queryWithdrawalBatches(tx, withdrawalBatchTable(ws.coin))
+	return
+}
+
+// RemoveWithdrawalBatch removes a batch once a version of its transaction confirms
+func (ws *SQLWithdrawalStore) RemoveWithdrawalBatch(txid string) (err error) {
+	var tx *sql.Tx
+	if tx, err = ws.begin("RemoveWithdrawalBatch"); err != nil {
+		return
+	}
+	defer func() {
+		err = finishWithdrawalTx(tx, "RemoveWithdrawalBatch", err)
+	}()
+
+	err = removeWithdrawalBatch(tx, withdrawalBatchTable(ws.coin), txid)
+	return
+}
+
+// CreateWithdrawalStoreMap creates a map of coin to withdrawal store, given a list of coins.
+func CreateWithdrawalStoreMap(coinList []*coinparam.Params) (withdrawalMap map[*coinparam.Params]cxdb.WithdrawalStore, err error) {
+
+	withdrawalMap = make(map[*coinparam.Params]cxdb.WithdrawalStore)
+	var curWithdrawalStore cxdb.WithdrawalStore
+	for _, coin := range coinList {
+		if curWithdrawalStore, err = CreateWithdrawalStore(coin); err != nil {
+			err = fmt.Errorf("Error creating single withdrawal store while creating withdrawal store map: %s", err)
+			return
+		}
+		withdrawalMap[coin] = curWithdrawalStore
+	}
+
+	return
+}
+
+// The rest of this file is shared by the mysql and postgres withdrawal stores, the queries are the
+// same once the transaction is using the withdrawal schema.
+
+// checkWithdrawalQueryFields makes sure the state and txid of a withdrawal are what they should
+// be, since they're the only strings that go in queries without being hex encoded
+func checkWithdrawalQueryFields(withdrawal *match.WithdrawalRequest) (err error) {
+	if _, err = match.WithdrawalStateFromString(string(withdrawal.State)); err != nil {
+		err = fmt.Errorf("Error with withdrawal state: %s", err)
+		return
+	}
+	if _, err = hex.DecodeString(withdrawal.Txid); err != nil {
+		err = fmt.Errorf("Error with withdrawal txid, should be hex: %s", err)
+		return
+	}
+	return
+}
+
+// insertWithdrawalQuery creates the query that inserts a new withdrawal into a coin's table. The
+// ID is left for the database to set.
+func insertWithdrawalQuery(table string, withdrawal *match.WithdrawalRequest) (insertQuery string, err error) {
+	if err = checkWithdrawalQueryFields(withdrawal); err != nil {
+		return
+	}
+	insertQuery = fmt.Sprintf("INSERT INTO %s (pubkey, amount, address, state, txid, reason, requested, updated, lightning, nonce, domain, signature, priority, payfee, fee) VALUES ('%x', %d, '%x', '%s', '%s', '%x', %d, %d, %t, %d, '%x', '%x', %d, %t, %d)",
+		table, withdrawal.Pubkey[:], withdrawal.Amount, withdrawal.Address, withdrawal.State, withdrawal.Txid, withdrawal.Reason, withdrawal.Requested.UnixNano(), withdrawal.Updated.UnixNano(), withdrawal.Lightning, withdrawal.Nonce, withdrawal.Domain, withdrawal.Signature, withdrawal.Priority, withdrawal.PayFee, withdrawal.Fee)
+	return
+}
+
+// updateWithdrawal writes the state, txid, reason, fee and update time of a withdrawal
+func updateWithdrawal(tx *sql.Tx, table string, withdrawal *match.WithdrawalRequest) (err error) {
+	if err = checkWithdrawalQueryFields(withdrawal); err != nil {
+		return
+	}
+
+	updateQuery := fmt.Sprintf("UPDATE %s SET state='%s', txid='%s', reason='%x', fee=%d, updated=%d WHERE id=%d;",
+		table, withdrawal.State, withdrawal.Txid, withdrawal.Reason, withdrawal.Fee, withdrawal.Updated.UnixNano(), withdrawal.ID)
+	var res sql.Result
+	if res, err = tx.Exec(updateQuery); err != nil {
+		err = fmt.Errorf("Error updating withdrawal %d: %s", withdrawal.ID, err)
+		return
+	}
+
+	// mysql only counts rows that changed, so check that the row is there rather than trusting
+	// the number of rows affected
+	var affected int64
+	if affected, err = res.RowsAffected(); err != nil {
+		err = fmt.Errorf("Error getting rows affected updating withdrawal %d: %s", withdrawal.ID, err)
+		return
+	}
+	if affected == 0 {
+		var count uint64
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id=%d;", table, withdrawal.ID)
+		if err = tx.QueryRow(countQuery).Scan(&count); err != nil {
+			err = fmt.Errorf("Error checking withdrawal %d exists: %s", withdrawal.ID, err)
+			return
+		}
+		if count == 0 {
+			err = fmt.Errorf("No withdrawal with ID %d", withdrawal.ID)
+			return
+		}
+	}
+	return
+}
+
+// getWithdrawal gets a single withdrawal from a coin's table by ID
+func getWithdrawal(tx *sql.Tx, coin *coinparam.Params, id uint64) (withdrawal *match.WithdrawalRequest, err error) {
+	var withdrawals []*match.WithdrawalRequest
+	if withdrawals, err = queryWithdrawals(tx, coin, fmt.Sprintf("id=%d", id)); err != nil {
+		return
+	}
+	if len(withdrawals) == 0 {
+		err = fmt.Errorf("No withdrawal with ID %d", id)
+		return
+	}
+	withdrawal = withdrawals[0]
+	return
+}
+
+// queryWithdrawalsByState gets every withdrawal in a state from a coin's table, oldest first
+func queryWithdrawalsByState(tx *sql.Tx, coin *coinparam.Params, state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error) {
+	if _, err = match.WithdrawalStateFromString(string(state)); err != nil {
+		err = fmt.Errorf("Error with state for querying withdrawals: %s", err)
+		return
+	}
+	withdrawals, err = queryWithdrawals(tx, coin, fmt.Sprintf("state='%s'", state))
+	return
+}
+
+// queryWithdrawals gets the withdrawals matching a condition from a coin's table, oldest first
+func queryWithdrawals(tx *sql.Tx, coin *coinparam.Params, condition string) (withdrawals []*match.WithdrawalRequest, err error) {
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for querying withdrawals: %s", err)
+		return
+	}
+
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id;", withdrawalColumns, coin.Name, condition)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying withdrawals: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		withdrawal := &match.WithdrawalRequest{Asset: asset}
+		var pkString, addrString, stateString, reasonString, domainString, sigString string
+		var txid sql.NullString
+		var requested, updated int64
+		var priority uint8
+		if err = rows.Scan(&withdrawal.ID, &pkString, &withdrawal.Amount, &addrString, &stateString, &txid, &reasonString, &requested, &updated, &withdrawal.Lightning, &withdrawal.Nonce, &domainString, &sigString, &priority, &withdrawal.PayFee, &withdrawal.Fee); err != nil {
+			err = fmt.Errorf("Error scanning withdrawal: %s", err)
+			return
+		}
+
+		var pkBytes, addrBytes, reasonBytes, domainBytes []byte
+		if pkBytes, err = hex.DecodeString(pkString); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for withdrawal %d: %s", withdrawal.ID, err)
+			return
+		}
+		if addrBytes, err = hex.DecodeString(addrString); err != nil {
+			err = fmt.Errorf("Error decoding address for withdrawal %d: %s", withdrawal.ID, err)
+			return
+		}
+		if reasonBytes, err = hex.DecodeString(reasonString); err != nil {
+			err = fmt.Errorf("Error decoding reason for withdrawal %d: %s", withdrawal.ID, err)
+			return
+		}
+		if domainBytes, err = hex.DecodeString(domainString); err != nil {
+			err = fmt.Errorf("Error decoding domain for withdrawal %d: %s", withdrawal.ID, err)
+			return
+		}
+		if withdrawal.Signature, err = hex.DecodeString(sigString); err != nil {
+			err = fmt.Errorf("Error decoding signature for withdrawal %d: %s", withdrawal.ID, err)
+			return
+		}
+		if withdrawal.State, err = match.WithdrawalStateFromString(stateString); err != nil {
+			return
+		}
+
+		withdrawal.Priority = match.FeePriority(priority)
+		copy(withdrawal.Pubkey[:], pkBytes)
+		withdrawal.Address = string(addrBytes)
+		withdrawal.Reason = string(reasonBytes)
+		withdrawal.Domain = string(domainBytes)
+		withdrawal.Txid = txid.String
+		withdrawal.Requested = time.Unix(0, requested)
+		withdrawal.Updated = time.Unix(0, updated)
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading withdrawal rows: %s", err)
+		return
+	}
+	return
+}
+
+// withdrawalBatchTable is the name of the table a coin's unconfirmed withdrawal transactions are
+// kept in
+func withdrawalBatchTable(coin *coinparam.Params) string {
+	return coin.Name + "_batches"
+}
+
+// encodeWithdrawalBatch serializes a batch as hex so it can go in a query, making sure its txid
+// is hex too
+func encodeWithdrawalBatch(batch *match.WithdrawalBatch) (encodedBatch string, err error) {
+	if _, err = hex.DecodeString(batch.Txid); err != nil {
+		err = fmt.Errorf("Error with withdrawal batch txid, should be hex: %s", err)
+		return
+	}
+	var raw []byte
+	if raw, err = batch.Serialize(); err != nil {
+		return
+	}
+	encodedBatch = hex.EncodeToString(raw)
+	return
+}
+
+// queryWithdrawalBatches gets every batch from a coin's withdrawal batch table, by txid
+func queryWithdrawalBatches(tx *sql.Tx, table string) (batches []*match.WithdrawalBatch, err error) {
+	selectQuery := fmt.Sprintf("SELECT encodedBatch FROM %s ORDER BY txid;", table)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		err = fmt.Errorf("Error querying withdrawal batches: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var encodedBatch string
+		if err = rows.Scan(&encodedBatch); err != nil {
+			err = fmt.Errorf("Error scanning withdrawal batch: %s", err)
+			return
+		}
+		var raw []byte
+		if raw, err = hex.DecodeString(encodedBatch); err != nil {
+			err = fmt.Errorf("Error decoding hex withdrawal batch: %s", err)
+			return
+		}
+		batch := new(match.WithdrawalBatch)
+		if err = batch.Deserialize(raw); err != nil {
+			return
+		}
+		batches = append(batches, batch)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error reading withdrawal batch rows: %s", err)
+		return
+	}
+	return
+}
+
+// removeWithdrawalBatch deletes the batch whose first version has a txid
+func removeWithdrawalBatch(tx *sql.Tx, table string, txid string) (err error) {
+	if _, err = hex.DecodeString(txid); err != nil {
+		err = fmt.Errorf("Error with withdrawal batch txid, should be hex: %s", err)
+		return
+	}
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE txid='%s';", table, txid)
+	if _, err = tx.Exec(deleteQuery); err != nil {
+		err = fmt.Errorf("Error deleting withdrawal batch %s: %s", txid, err)
+		return
+	}
+	return
+}
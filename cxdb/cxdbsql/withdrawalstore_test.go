@@ -0,0 +1,106 @@
+package cxdbsql
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestWithdrawalStoreStates adds withdrawals, moves one along, and checks they can be looked up
+// by pubkey and by state
+func TestWithdrawalStoreStates(t *testing.T) {
+	var err error
+
+	var tc *testerContainer
+	if tc, err = CreateTesterContainer(); err != nil {
+		t.Errorf("Error creating tester container: %s", err)
+		return
+	}
+
+	defer func() {
+		if err = tc.Kill(); err != nil {
+			t.Errorf("Error killing tester container: %s", err)
+			return
+		}
+	}()
+
+	coin := &coinparam.RegressionNetParams
+	var ws *SQLWithdrawalStore
+	if ws, err = CreateWithdrawalStoreStructWithConf(coin, testConfig()); err != nil {
+		t.Errorf("Error creating withdrawal store: %s", err)
+		return
+	}
+	defer ws.DestroyHandler()
+
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+
+	requested := time.Unix(1500000000, 0)
+	var ids []uint64
+	for i := 0; i < 2; i++ {
+		withdrawal := &match.WithdrawalRequest{
+			Amount:    5000,
+			Address:   "bcrt1qtestaddress",
+			State:     match.WithdrawalApproved,
+			Requested: requested,
+			Updated:   requested,
+			Nonce:     uint64(i),
+			Domain:    "exchange's domain",
+			Signature: []byte{0x1f, 0x00, byte(i)},
+		}
+		copy(withdrawal.Pubkey[:], priv.PubKey().SerializeCompressed())
+		if err = ws.AddWithdrawal(withdrawal); err != nil {
+			t.Errorf("Error adding withdrawal: %s", err)
+			return
+		}
+		ids = append(ids, withdrawal.ID)
+	}
+
+	failed := &match.WithdrawalRequest{ID: ids[0], Reason: "rejected by 'operator'"}
+	failed.SetState(match.WithdrawalFailed, requested.Add(time.Minute))
+	if err = ws.UpdateWithdrawal(failed); err != nil {
+		t.Errorf("Error updating withdrawal: %s", err)
+		return
+	}
+
+	var withdrawal *match.WithdrawalRequest
+	if withdrawal, err = ws.GetWithdrawal(ids[0]); err != nil {
+		t.Errorf("Error getting withdrawal: %s", err)
+		return
+	}
+	if withdrawal.State != match.WithdrawalFailed || withdrawal.Reason != failed.Reason || withdrawal.Address != "bcrt1qtestaddress" || !withdrawal.Requested.Equal(requested) {
+		t.Errorf("Withdrawal should have failed with its reason and kept its address, got %s", withdrawal)
+	}
+	if withdrawal.Nonce != 0 || withdrawal.Domain != "exchange's domain" || !bytes.Equal(withdrawal.Signature, []byte{0x1f, 0x00, 0x00}) {
+		t.Errorf("Withdrawal should have kept its nonce, domain and signature, got %d %s %x", withdrawal.Nonce, withdrawal.Domain, withdrawal.Signature)
+	}
+
+	var approved []*match.WithdrawalRequest
+	if approved, err = ws.GetWithdrawalsByState(match.WithdrawalApproved); err != nil {
+		t.Errorf("Error getting approved withdrawals: %s", err)
+		return
+	}
+	if len(approved) != 1 || approved[0].ID != ids[1] {
+		t.Errorf("Only withdrawal %d should be approved, got %d approved", ids[1], len(approved))
+	}
+
+	var all []*match.WithdrawalRequest
+	if all, err = ws.GetWithdrawals(priv.PubKey()); err != nil {
+		t.Errorf("Error getting withdrawals: %s", err)
+		return
+	}
+	if len(all) != 2 {
+		t.Errorf("Pubkey should have 2 withdrawals, got %d", len(all))
+	}
+
+	if err = ws.UpdateWithdrawal(&match.WithdrawalRequest{ID: ids[1] + 100, State: match.WithdrawalFailed}); err == nil {
+		t.Errorf("Updating a withdrawal that doesn't exist should fail")
+	}
+}
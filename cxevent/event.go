@@ -0,0 +1,143 @@
+// Package cxevent records every input to the exchange as a sequenced event, and replays those
+// events against matching engines. The matching engines are a deterministic function of the
+// event stream, so replaying a log rebuilds the exact state of the engines, and the executions
+// from a replay can be compared against what the exchange did live.
+package cxevent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// EventType is the kind of input an event represents
+type EventType string
+
+const (
+	// PlaceOrderEvent is a limit order being placed, the limit engine is matched right after
+	PlaceOrderEvent EventType = "placeorder"
+	// CancelOrderEvent is a limit order being cancelled
+	CancelOrderEvent EventType = "cancelorder"
+	// DepositEvent is a user's balance going up because of a deposit
+	DepositEvent EventType = "deposit"
+	// WithdrawalEvent is a user's balance going down because of a withdrawal
+	WithdrawalEvent EventType = "withdrawal"
+	// DepositRollbackEvent is a user's balance going down because a deposit was reorged out
+	DepositRollbackEvent EventType = "depositrollback"
+	// WithdrawalRefundEvent is a user's balance going back up because a withdrawal failed
+	WithdrawalRefundEvent EventType = "withdrawalrefund"
+	// AuctionOrderEvent is an auction order being placed in an auction
+	AuctionOrderEvent EventType = "auctionorder"
+	// AuctionCancelEvent is an auction order being cancelled
+	AuctionCancelEvent EventType = "auctioncancel"
+	// AuctionTickEvent is an auction being matched
+	AuctionTickEvent EventType = "auctiontick"
+)
+
+// Event is a single input to the exchange. Only the fields for the event's type are set.
+type Event struct {
+	// Seq is the position of the event in the stream, starting at 1
+	Seq uint64 `json:"seq"`
+	// Type is what kind of input this is
+	Type EventType `json:"type"`
+	// Time is when the event was sequenced, in unix nanoseconds. It always goes up, and engines
+	// use it as the placement time rather than reading the clock.
+	Time int64 `json:"time"`
+
+	// Pair is the pair of the engine the event goes to
+	Pair *match.Pair `json:"pair,omitempty"`
+	// Order is the limit order for place order events
+	Order *LimitOrder `json:"order,omitempty"`
+	// AuctionOrder is the serialized auction order for auction order events
+	AuctionOrder []byte `json:"auctionorder,omitempty"`
+	// AuctionID is the auction for auction order and auction tick events
+	AuctionID *match.AuctionID `json:"auctionid,omitempty"`
+	// OrderID is the ID the live engine gave the order, for place and cancel events
+	OrderID *match.OrderID `json:"orderid,omitempty"`
+	// OrderSeq is the seq of the event that placed the order, for cancel events. Different
+	// engines give orders different IDs, so this is what's used to find the order on replay.
+	OrderSeq uint64 `json:"orderseq,omitempty"`
+
+	// Pubkey, Asset and Amount are set for deposit, withdrawal, deposit rollback, and withdrawal
+	// refund events
+	Pubkey []byte      `json:"pubkey,omitempty"`
+	Asset  match.Asset `json:"asset"`
+	Amount uint64      `json:"amount,omitempty"`
+
+	// Result is what the exchange did with the event, if it was recorded live
+	Result *Result `json:"result,omitempty"`
+}
+
+// Timestamp returns the time the event was sequenced
+func (e *Event) Timestamp() time.Time {
+	return time.Unix(0, e.Time)
+}
+
+// String returns a short description of the event
+func (e *Event) String() string {
+	return fmt.Sprintf("event %d (%s)", e.Seq, e.Type)
+}
+
+// LimitOrder is a limit order in an event. The side of a match.LimitOrder doesn't survive json,
+// so it's a bool here.
+type LimitOrder struct {
+	Pubkey     [33]byte   `json:"pubkey"`
+	Buy        bool       `json:"buy"`
+	Pair       match.Pair `json:"pair"`
+	AmountHave uint64     `json:"amounthave"`
+	AmountWant uint64     `json:"amountwant"`
+}
+
+// NewLimitOrder creates an event limit order from a limit order
+func NewLimitOrder(order *match.LimitOrder) (eventOrder *LimitOrder) {
+	eventOrder = &LimitOrder{
+		Pubkey:     order.Pubkey,
+		Buy:        order.Side == match.Buy,
+		Pair:       order.TradingPair,
+		AmountHave: order.AmountHave,
+		AmountWant: order.AmountWant,
+	}
+	return
+}
+
+// Order returns a new limit order with the same values as the event limit order
+func (o *LimitOrder) Order() (order *match.LimitOrder) {
+	order = &match.LimitOrder{
+		Pubkey:      o.Pubkey,
+		Side:        match.Side(o.Buy),
+		TradingPair: o.Pair,
+		AmountHave:  o.AmountHave,
+		AmountWant:  o.AmountWant,
+	}
+	return
+}
+
+// PlaceSettlement returns the settlement execution that takes the funds for an order out of the
+// user's balance when it's placed.
+func PlaceSettlement(order *match.LimitOrder) (setExec *match.SettlementExecution) {
+	// If we are buy then we want to credit assethave
+	// If we are sell then we want to credit assetwant
+	var assetToCredit match.Asset
+	if order.Side == match.Buy {
+		assetToCredit = order.TradingPair.AssetHave
+	} else {
+		assetToCredit = order.TradingPair.AssetWant
+	}
+	setExec = &match.SettlementExecution{
+		Pubkey: order.Pubkey,
+		Type:   match.Credit,
+		Asset:  assetToCredit,
+		Amount: order.AmountHave,
+	}
+	return
+}
+
+// PlaceLimitOrderAt places an order at a specific time if the engine is a
+// match.DeterministicLimitEngine, otherwise the engine decides the time.
+func PlaceLimitOrderAt(engine match.LimitEngine, order *match.LimitOrder, placementTime time.Time) (idRes *match.LimitOrderIDPair, err error) {
+	if detEngine, ok := engine.(match.DeterministicLimitEngine); ok {
+		return detEngine.PlaceLimitOrderAt(order, placementTime)
+	}
+	return engine.PlaceLimitOrder(order)
+}
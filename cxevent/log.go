@@ -0,0 +1,244 @@
+package cxevent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// EventLog is an append-only file of sequenced events, one json event per line. Events get a
+// seq and time with Sequence and are written with Append before the engines see them, so the log
+// has every input the engines have. Record writes the event again once the engines are done, with
+// what they did, and that line replaces the appended one when the log is read. An event that gets
+// sequenced but never written just leaves a gap in the seqs.
+type EventLog struct {
+	path    string
+	logFile *os.File
+	logMtx  *sync.Mutex
+
+	lastSeq  uint64
+	lastTime int64
+
+	// orderSeqs maps the IDs of orders placed live to the seq of the event that placed them
+	orderSeqs map[match.OrderID]uint64
+}
+
+// OpenEventLog opens (or creates) an event log, and reads the events that are already in it so
+// new events continue the sequence.
+func OpenEventLog(path string) (eventLog *EventLog, err error) {
+	eventLog = &EventLog{
+		path:      path,
+		logMtx:    new(sync.Mutex),
+		orderSeqs: make(map[match.OrderID]uint64),
+	}
+
+	var events []*Event
+	var goodOffset int64
+	if events, goodOffset, err = readEvents(path); err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("Error reading existing events for OpenEventLog: %s", err)
+		return
+	}
+	err = nil
+
+	for _, event := range events {
+		if event.Seq > eventLog.lastSeq {
+			eventLog.lastSeq = event.Seq
+		}
+		if event.Time > eventLog.lastTime {
+			eventLog.lastTime = event.Time
+		}
+		eventLog.trackOrder(event)
+	}
+
+	if eventLog.logFile, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		err = fmt.Errorf("Error opening event log %s for OpenEventLog: %s", path, err)
+		return
+	}
+
+	// cut off an incomplete event so new events don't get appended to it
+	if err = eventLog.logFile.Truncate(goodOffset); err != nil {
+		err = fmt.Errorf("Error truncating incomplete event from %s for OpenEventLog: %s", path, err)
+		return
+	}
+	return
+}
+
+// Sequence gives an event the next seq and a time after every other event's time. Engines should
+// use the event's time as the placement time for anything the event places.
+func (l *EventLog) Sequence(event *Event) {
+	l.logMtx.Lock()
+	defer l.logMtx.Unlock()
+
+	l.lastSeq++
+	event.Seq = l.lastSeq
+
+	now := time.Now().UnixNano()
+	if now <= l.lastTime {
+		now = l.lastTime + 1
+	}
+	l.lastTime = now
+	event.Time = now
+}
+
+// Append writes a sequenced event to the log without a result, before the engines see it. If this
+// fails, the engines shouldn't see the event either. For cancel events, OrderID is used to fill in
+// OrderSeq.
+func (l *EventLog) Append(event *Event) (err error) {
+	if event.Seq == 0 {
+		err = fmt.Errorf("Cannot append an event that hasn't been sequenced")
+		return
+	}
+
+	l.logMtx.Lock()
+	defer l.logMtx.Unlock()
+
+	l.trackOrder(event)
+	if err = l.write(event); err != nil {
+		err = fmt.Errorf("Error writing event for Append: %s", err)
+		return
+	}
+	return
+}
+
+// Record writes a sequenced event to the log along with what the engines did with it. For place
+// events, OrderID should be the ID the engine gave the order, and for cancel events, OrderID is
+// used to fill in OrderSeq. If the event was appended already, this replaces it.
+func (l *EventLog) Record(event *Event, orderExecs []*match.OrderExecution, setExecs []*match.SettlementExecution) (err error) {
+	if event.Seq == 0 {
+		err = fmt.Errorf("Cannot record an event that hasn't been sequenced")
+		return
+	}
+
+	l.logMtx.Lock()
+	defer l.logMtx.Unlock()
+
+	l.trackOrder(event)
+	event.Result = NewResult(orderExecs, setExecs, l.orderSeqs)
+
+	if err = l.write(event); err != nil {
+		err = fmt.Errorf("Error writing event for Record: %s", err)
+		return
+	}
+	return
+}
+
+// write writes an event as a line at the end of the log and syncs it. If the line can't be
+// written, whatever part of it was written is cut off. The lock should be held.
+func (l *EventLog) write(event *Event) (err error) {
+	var line []byte
+	if line, err = json.Marshal(event); err != nil {
+		err = fmt.Errorf("Error marshalling %s: %s", event, err)
+		return
+	}
+
+	var logInfo os.FileInfo
+	if logInfo, err = l.logFile.Stat(); err != nil {
+		err = fmt.Errorf("Error getting size of event log: %s", err)
+		return
+	}
+	if _, err = l.logFile.Write(append(line, '\n')); err != nil {
+		err = fmt.Errorf("Error writing %s to event log: %s", event, err)
+		if truncErr := l.logFile.Truncate(logInfo.Size()); truncErr != nil {
+			err = fmt.Errorf("%s, and error removing partial event: %s", err, truncErr)
+		}
+		return
+	}
+	if err = l.logFile.Sync(); err != nil {
+		err = fmt.Errorf("Error syncing event log: %s", err)
+		return
+	}
+	return
+}
+
+// trackOrder remembers which event placed an order, and for cancels, finds the event that placed
+// the order being cancelled. The lock should be held.
+func (l *EventLog) trackOrder(event *Event) {
+	if event.OrderID == nil {
+		return
+	}
+	switch event.Type {
+	case PlaceOrderEvent, AuctionOrderEvent:
+		l.orderSeqs[*event.OrderID] = event.Seq
+	case CancelOrderEvent, AuctionCancelEvent:
+		if event.OrderSeq == 0 {
+			event.OrderSeq = l.orderSeqs[*event.OrderID]
+		}
+	}
+}
+
+// Close closes the event log file
+func (l *EventLog) Close() (err error) {
+	l.logMtx.Lock()
+	defer l.logMtx.Unlock()
+	if err = l.logFile.Close(); err != nil {
+		err = fmt.Errorf("Error closing event log %s: %s", l.path, err)
+		return
+	}
+	return
+}
+
+// ReadEventLog reads every event in an event log, sorted by seq. If the last line is incomplete,
+// because we crashed while writing it, it's ignored. Events that were appended but never recorded
+// have no result.
+func ReadEventLog(path string) (events []*Event, err error) {
+	events, _, err = readEvents(path)
+	return
+}
+
+// readEvents reads every event in an event log, and returns the offset of the end of the last
+// complete event.
+func readEvents(path string) (events []*Event, goodOffset int64, err error) {
+	var logFile *os.File
+	if logFile, err = os.Open(path); err != nil {
+		return
+	}
+	defer logFile.Close()
+
+	reader := bufio.NewReader(logFile)
+	for {
+		var line []byte
+		if line, err = reader.ReadBytes('\n'); err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("Error reading event log %s: %s", path, err)
+				return
+			}
+			err = nil
+			if len(line) != 0 {
+				logging.Warnf("Ignoring incomplete event at the end of %s", path)
+			}
+			break
+		}
+
+		event := new(Event)
+		if err = json.Unmarshal(line, event); err != nil {
+			err = fmt.Errorf("Error unmarshalling event %d of %s: %s", len(events)+1, path, err)
+			return
+		}
+		events = append(events, event)
+		goodOffset += int64(len(line))
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Seq < events[j].Seq
+	})
+
+	// a recorded event comes after the line it was appended with, and replaces it
+	deduped := events[:0]
+	for _, event := range events {
+		if len(deduped) != 0 && deduped[len(deduped)-1].Seq == event.Seq {
+			deduped[len(deduped)-1] = event
+			continue
+		}
+		deduped = append(deduped, event)
+	}
+	events = deduped
+	return
+}
@@ -0,0 +1,168 @@
+package cxevent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func createTestLogPath(t *testing.T) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cxevent")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	path = filepath.Join(dir, "events.log")
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+func recordTestDeposit(t *testing.T, eventLog *EventLog, amount uint64) (event *Event) {
+	event = &Event{Type: DepositEvent, Pubkey: []byte{0x02, 0x01}, Amount: amount}
+	eventLog.Sequence(event)
+	setExec := &match.SettlementExecution{Amount: amount, Type: match.Debit}
+	copy(setExec.Pubkey[:], event.Pubkey)
+	if err := eventLog.Record(event, nil, []*match.SettlementExecution{setExec}); err != nil {
+		t.Fatalf("Error recording event: %s", err)
+	}
+	return
+}
+
+func TestEventLogReopen(t *testing.T) {
+	path, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	eventLog, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("Error opening event log: %s", err)
+	}
+	first := recordTestDeposit(t, eventLog, 100)
+	second := recordTestDeposit(t, eventLog, 200)
+	if second.Seq != first.Seq+1 || second.Time <= first.Time {
+		t.Errorf("Events should have increasing seqs and times, got %d at %d then %d at %d", first.Seq, first.Time, second.Seq, second.Time)
+	}
+	if err = eventLog.Close(); err != nil {
+		t.Fatalf("Error closing event log: %s", err)
+	}
+
+	if eventLog, err = OpenEventLog(path); err != nil {
+		t.Fatalf("Error reopening event log: %s", err)
+	}
+	third := recordTestDeposit(t, eventLog, 300)
+	if third.Seq != 3 || third.Time <= second.Time {
+		t.Errorf("Reopened log should continue the sequence, got seq %d", third.Seq)
+	}
+	if err = eventLog.Close(); err != nil {
+		t.Fatalf("Error closing event log: %s", err)
+	}
+
+	events, err := ReadEventLog(path)
+	if err != nil {
+		t.Fatalf("Error reading event log: %s", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+1) {
+			t.Errorf("Event %d has seq %d", i, event.Seq)
+		}
+		if event.Result == nil || len(event.Result.Settlements) != 1 || !event.Result.Settlements[0].Debit {
+			t.Errorf("Event %d should have one debit settlement, got %s", i, event.Result)
+		}
+	}
+}
+
+func TestEventLogIncompleteEvent(t *testing.T) {
+	path, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	eventLog, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("Error opening event log: %s", err)
+	}
+	recordTestDeposit(t, eventLog, 100)
+	if err = eventLog.Close(); err != nil {
+		t.Fatalf("Error closing event log: %s", err)
+	}
+
+	// pretend we crashed halfway through writing an event
+	logFile, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Error opening log file: %s", err)
+	}
+	if _, err = logFile.Write([]byte(`{"seq":2,"type":"dep`)); err != nil {
+		t.Fatalf("Error writing incomplete event: %s", err)
+	}
+	logFile.Close()
+
+	events, err := ReadEventLog(path)
+	if err != nil {
+		t.Fatalf("Error reading log with incomplete event: %s", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Incomplete event should be ignored, got %d events", len(events))
+	}
+
+	if eventLog, err = OpenEventLog(path); err != nil {
+		t.Fatalf("Error reopening event log: %s", err)
+	}
+	if event := recordTestDeposit(t, eventLog, 200); event.Seq != 2 {
+		t.Errorf("Incomplete event shouldn't take up a seq, got seq %d", event.Seq)
+	}
+	eventLog.Close()
+
+	if events, err = ReadEventLog(path); err != nil {
+		t.Fatalf("Error reading event log after incomplete event was cut off: %s", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(events))
+	}
+}
+
+func TestEventLogAppendThenRecord(t *testing.T) {
+	path, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	eventLog, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("Error opening event log: %s", err)
+	}
+
+	// one event the engines finished with, and one that was logged but never finished
+	recorded := &Event{Type: DepositEvent, Pubkey: []byte{0x02, 0x01}, Amount: 100}
+	eventLog.Sequence(recorded)
+	if err = eventLog.Append(recorded); err != nil {
+		t.Fatalf("Error appending event: %s", err)
+	}
+	unfinished := &Event{Type: DepositEvent, Pubkey: []byte{0x02, 0x01}, Amount: 200}
+	eventLog.Sequence(unfinished)
+	if err = eventLog.Append(unfinished); err != nil {
+		t.Fatalf("Error appending event: %s", err)
+	}
+	setExec := &match.SettlementExecution{Amount: 100, Type: match.Debit}
+	if err = eventLog.Record(recorded, nil, []*match.SettlementExecution{setExec}); err != nil {
+		t.Fatalf("Error recording event: %s", err)
+	}
+	if err = eventLog.Close(); err != nil {
+		t.Fatalf("Error closing event log: %s", err)
+	}
+
+	events, err := ReadEventLog(path)
+	if err != nil {
+		t.Fatalf("Error reading event log: %s", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Recorded event should replace the appended one, got %d events", len(events))
+	}
+	if events[0].Seq != recorded.Seq || events[0].Result == nil || len(events[0].Result.Settlements) != 1 {
+		t.Errorf("First event should have its recorded result, got %s", events[0].Result)
+	}
+	if events[1].Seq != unfinished.Seq || events[1].Amount != 200 || events[1].Result != nil {
+		t.Errorf("Unfinished event should be in the log without a result")
+	}
+}
@@ -0,0 +1,242 @@
+package cxevent
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// Replayer runs events against a set of matching engines. Any LimitEngine or AuctionEngine works,
+// but only engines that implement match.DeterministicLimitEngine use the event times, so other
+// engines may break time priority ties differently than the live exchange did.
+type Replayer struct {
+	LimitEngines   map[match.Pair]match.LimitEngine
+	AuctionEngines map[match.Pair]match.AuctionEngine
+
+	// orderIDs maps the seq of the event that placed an order to the ID these engines gave it
+	orderIDs map[uint64]match.OrderID
+	// orderSeqs is the other way around, for turning executions into results
+	orderSeqs map[match.OrderID]uint64
+}
+
+// NewReplayer creates a replayer for a set of engines. The engines should be empty, since the
+// events will rebuild their state.
+func NewReplayer(limitEngines map[match.Pair]match.LimitEngine, auctionEngines map[match.Pair]match.AuctionEngine) (replayer *Replayer) {
+	replayer = &Replayer{
+		LimitEngines:   limitEngines,
+		AuctionEngines: auctionEngines,
+		orderIDs:       make(map[uint64]match.OrderID),
+		orderSeqs:      make(map[match.OrderID]uint64),
+	}
+	return
+}
+
+// Apply runs a single event against the engines. An error from an engine is part of the result,
+// the returned error is for events that can't be applied at all, like ones for a pair there's no
+// engine for.
+func (r *Replayer) Apply(event *Event) (result *Result, err error) {
+	var orderExecs []*match.OrderExecution
+	var setExecs []*match.SettlementExecution
+	var engineErr error
+
+	switch event.Type {
+	case PlaceOrderEvent:
+		if event.Order == nil {
+			err = fmt.Errorf("Error, %s has no order", event)
+			return
+		}
+		var engine match.LimitEngine
+		if engine, err = r.limitEngine(&event.Order.Pair, event); err != nil {
+			return
+		}
+		order := event.Order.Order()
+		var idRes *match.LimitOrderIDPair
+		if idRes, engineErr = PlaceLimitOrderAt(engine, order, event.Timestamp()); engineErr != nil {
+			break
+		}
+		r.trackOrder(event.Seq, idRes.OrderID)
+		setExecs = append(setExecs, PlaceSettlement(event.Order.Order()))
+
+		var matchSetExecs []*match.SettlementExecution
+		if orderExecs, matchSetExecs, engineErr = engine.MatchLimitOrders(); engineErr != nil {
+			break
+		}
+		setExecs = append(setExecs, matchSetExecs...)
+
+	case CancelOrderEvent:
+		var engine match.LimitEngine
+		if engine, err = r.limitEngine(event.Pair, event); err != nil {
+			return
+		}
+		orderID, ok := r.orderIDs[event.OrderSeq]
+		if !ok {
+			engineErr = fmt.Errorf("No order placed by event %d", event.OrderSeq)
+			break
+		}
+		var cancelSettlement *match.SettlementExecution
+		if _, cancelSettlement, engineErr = engine.CancelLimitOrder(&orderID); engineErr != nil {
+			break
+		}
+		setExecs = append(setExecs, cancelSettlement)
+
+	case DepositEvent, WithdrawalEvent, DepositRollbackEvent, WithdrawalRefundEvent:
+		setExec := &match.SettlementExecution{
+			Asset:  event.Asset,
+			Amount: event.Amount,
+			Type:   match.Debit,
+		}
+		if event.Type == WithdrawalEvent || event.Type == DepositRollbackEvent {
+			setExec.Type = match.Credit
+		}
+		copy(setExec.Pubkey[:], event.Pubkey)
+		setExecs = append(setExecs, setExec)
+
+	case AuctionOrderEvent:
+		var engine match.AuctionEngine
+		if engine, err = r.auctionEngine(event.Pair, event); err != nil {
+			return
+		}
+		if event.AuctionID == nil {
+			err = fmt.Errorf("Error, %s has no auction ID", event)
+			return
+		}
+		order := new(match.AuctionOrder)
+		if err = order.Deserialize(append([]byte{}, event.AuctionOrder...)); err != nil {
+			err = fmt.Errorf("Error deserializing auction order for %s: %s", event, err)
+			return
+		}
+		var idRes *match.AuctionOrderIDPair
+		if idRes, engineErr = engine.PlaceAuctionOrder(order, event.AuctionID); engineErr != nil {
+			break
+		}
+		r.trackOrder(event.Seq, &idRes.OrderID)
+
+	case AuctionCancelEvent:
+		var engine match.AuctionEngine
+		if engine, err = r.auctionEngine(event.Pair, event); err != nil {
+			return
+		}
+		orderID, ok := r.orderIDs[event.OrderSeq]
+		if !ok {
+			engineErr = fmt.Errorf("No order placed by event %d", event.OrderSeq)
+			break
+		}
+		var cancelSettlement *match.SettlementExecution
+		if _, cancelSettlement, engineErr = engine.CancelAuctionOrder(&orderID); engineErr != nil {
+			break
+		}
+		setExecs = append(setExecs, cancelSettlement)
+
+	case AuctionTickEvent:
+		var engine match.AuctionEngine
+		if engine, err = r.auctionEngine(event.Pair, event); err != nil {
+			return
+		}
+		if event.AuctionID == nil {
+			err = fmt.Errorf("Error, %s has no auction ID", event)
+			return
+		}
+		orderExecs, setExecs, engineErr = engine.MatchAuctionOrders(event.AuctionID)
+
+	default:
+		err = fmt.Errorf("Error, %s has unknown type", event)
+		return
+	}
+
+	if engineErr != nil {
+		result = &Result{Err: engineErr.Error()}
+		return
+	}
+	result = NewResult(orderExecs, setExecs, r.orderSeqs)
+	return
+}
+
+// trackOrder remembers the ID the engine gave the order placed by an event
+func (r *Replayer) trackOrder(seq uint64, orderID *match.OrderID) {
+	r.orderIDs[seq] = *orderID
+	r.orderSeqs[*orderID] = seq
+}
+
+// limitEngine gets the limit engine for an event's pair
+func (r *Replayer) limitEngine(pair *match.Pair, event *Event) (engine match.LimitEngine, err error) {
+	if pair == nil {
+		err = fmt.Errorf("Error, %s has no pair", event)
+		return
+	}
+	var ok bool
+	if engine, ok = r.LimitEngines[*pair]; !ok {
+		err = fmt.Errorf("Error, no limit engine for pair %s for %s", pair, event)
+		return
+	}
+	return
+}
+
+// auctionEngine gets the auction engine for an event's pair
+func (r *Replayer) auctionEngine(pair *match.Pair, event *Event) (engine match.AuctionEngine, err error) {
+	if pair == nil {
+		err = fmt.Errorf("Error, %s has no pair", event)
+		return
+	}
+	var ok bool
+	if engine, ok = r.AuctionEngines[*pair]; !ok {
+		err = fmt.Errorf("Error, no auction engine for pair %s for %s", pair, event)
+		return
+	}
+	return
+}
+
+// Replay runs every event against the engines, in order, and returns a result for each event.
+func Replay(events []*Event, limitEngines map[match.Pair]match.LimitEngine, auctionEngines map[match.Pair]match.AuctionEngine) (results []*Result, err error) {
+	replayer := NewReplayer(limitEngines, auctionEngines)
+	for _, event := range events {
+		var result *Result
+		if result, err = replayer.Apply(event); err != nil {
+			err = fmt.Errorf("Error replaying events: %s", err)
+			return
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// Difference is an event where two sets of results don't agree
+type Difference struct {
+	Event    *Event
+	Expected *Result
+	Got      *Result
+}
+
+// String returns a description of the difference
+func (d *Difference) String() string {
+	return fmt.Sprintf("%s:\n\texpected: %s\n\tgot:      %s", d.Event, d.Expected, d.Got)
+}
+
+// Diff compares two sets of results for the same events, and returns every event where they
+// differ. Missing results count as differences.
+func Diff(events []*Event, expected []*Result, got []*Result) (diffs []*Difference) {
+	for i, event := range events {
+		var expectedRes, gotRes *Result
+		if i < len(expected) {
+			expectedRes = expected[i]
+		}
+		if i < len(got) {
+			gotRes = got[i]
+		}
+		if !expectedRes.Equal(gotRes) {
+			diffs = append(diffs, &Difference{
+				Event:    event,
+				Expected: expectedRes,
+				Got:      gotRes,
+			})
+		}
+	}
+	return
+}
+
+// RecordedResults returns the results that were recorded live for each event
+func RecordedResults(events []*Event) (results []*Result) {
+	for _, event := range events {
+		results = append(results, event.Result)
+	}
+	return
+}
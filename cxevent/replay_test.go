@@ -0,0 +1,223 @@
+package cxevent
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb/cxdbbolt"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	testBTC, _ = match.AssetFromCoinParam(&coinparam.RegressionNetParams)
+	testLTC, _ = match.AssetFromCoinParam(&coinparam.LiteRegNetParams)
+	testPair   = &match.Pair{AssetWant: testBTC, AssetHave: testLTC}
+)
+
+func createTestLimitOrder(side match.Side, have uint64, want uint64, pubByte byte) *match.LimitOrder {
+	order := &match.LimitOrder{
+		Side:        side,
+		TradingPair: *testPair,
+		AmountHave:  have,
+		AmountWant:  want,
+	}
+	order.Pubkey[0] = 0x02
+	order.Pubkey[1] = pubByte
+	return order
+}
+
+func createTestAuctionOrder(side match.Side, have uint64, want uint64, pubByte byte, auctionID *match.AuctionID) *match.AuctionOrder {
+	order := &match.AuctionOrder{
+		Side:        side,
+		TradingPair: *testPair,
+		AmountHave:  have,
+		AmountWant:  want,
+		AuctionID:   *auctionID,
+		Nonce:       [2]byte{pubByte, 0x01},
+	}
+	order.Pubkey[0] = 0x02
+	order.Pubkey[1] = pubByte
+	return order
+}
+
+// recordTestSession runs some inputs through live engines the same way the servers do, recording
+// every event, and returns the events that were recorded.
+func recordTestSession(t *testing.T) (events []*Event) {
+	path, cleanup := createTestLogPath(t)
+	defer cleanup()
+
+	eventLog, err := OpenEventLog(path)
+	if err != nil {
+		t.Fatalf("Error opening event log: %s", err)
+	}
+
+	limitEngine, err := cxdbmemory.CreateLimitEngine(testPair)
+	if err != nil {
+		t.Fatalf("Error creating limit engine: %s", err)
+	}
+	auctionEngine, err := cxdbmemory.CreateAuctionEngine(testPair)
+	if err != nil {
+		t.Fatalf("Error creating auction engine: %s", err)
+	}
+
+	recordTestDeposit(t, eventLog, 10000)
+
+	var restingID *match.OrderID
+	limitOrders := []*match.LimitOrder{
+		createTestLimitOrder(match.Buy, 1000, 1000, 0x01),
+		createTestLimitOrder(match.Buy, 1000, 1000, 0x02),
+		createTestLimitOrder(match.Buy, 500, 1000, 0x03),
+		createTestLimitOrder(match.Sell, 1500, 1500, 0x04),
+	}
+	for i, order := range limitOrders {
+		event := &Event{Type: PlaceOrderEvent, Order: NewLimitOrder(order)}
+		eventLog.Sequence(event)
+		idRes, err := PlaceLimitOrderAt(limitEngine, order, event.Timestamp())
+		if err != nil {
+			t.Fatalf("Error placing order: %s", err)
+		}
+		orderExecs, setExecs, err := limitEngine.MatchLimitOrders()
+		if err != nil {
+			t.Fatalf("Error matching orders: %s", err)
+		}
+		event.OrderID = idRes.OrderID
+		if err = eventLog.Record(event, orderExecs, append([]*match.SettlementExecution{PlaceSettlement(order)}, setExecs...)); err != nil {
+			t.Fatalf("Error recording event: %s", err)
+		}
+		if i == 2 {
+			restingID = idRes.OrderID
+		}
+	}
+
+	cancelEvent := &Event{Type: CancelOrderEvent, Pair: testPair, OrderID: restingID}
+	eventLog.Sequence(cancelEvent)
+	_, cancelSettlement, err := limitEngine.CancelLimitOrder(restingID)
+	if err != nil {
+		t.Fatalf("Error cancelling order: %s", err)
+	}
+	if err = eventLog.Record(cancelEvent, nil, []*match.SettlementExecution{cancelSettlement}); err != nil {
+		t.Fatalf("Error recording event: %s", err)
+	}
+
+	auctionID := &match.AuctionID{0x01}
+	auctionOrders := []*match.AuctionOrder{
+		createTestAuctionOrder(match.Buy, 1000, 1000, 0x05, auctionID),
+		createTestAuctionOrder(match.Sell, 1000, 1000, 0x06, auctionID),
+	}
+	for _, order := range auctionOrders {
+		event := &Event{Type: AuctionOrderEvent, Pair: testPair, AuctionID: auctionID, AuctionOrder: order.Serialize()}
+		eventLog.Sequence(event)
+		idRes, err := auctionEngine.PlaceAuctionOrder(order, auctionID)
+		if err != nil {
+			t.Fatalf("Error placing auction order: %s", err)
+		}
+		event.OrderID = &idRes.OrderID
+		if err = eventLog.Record(event, nil, nil); err != nil {
+			t.Fatalf("Error recording event: %s", err)
+		}
+	}
+
+	tickEvent := &Event{Type: AuctionTickEvent, Pair: testPair, AuctionID: auctionID}
+	eventLog.Sequence(tickEvent)
+	orderExecs, setExecs, err := auctionEngine.MatchAuctionOrders(auctionID)
+	if err != nil {
+		t.Fatalf("Error matching auction: %s", err)
+	}
+	if err = eventLog.Record(tickEvent, orderExecs, setExecs); err != nil {
+		t.Fatalf("Error recording event: %s", err)
+	}
+
+	if err = eventLog.Close(); err != nil {
+		t.Fatalf("Error closing event log: %s", err)
+	}
+	if events, err = ReadEventLog(path); err != nil {
+		t.Fatalf("Error reading event log: %s", err)
+	}
+	return
+}
+
+func TestReplayMatchesRecorded(t *testing.T) {
+	events := recordTestSession(t)
+
+	var fills int
+	for _, event := range events {
+		fills += len(event.Result.Fills)
+	}
+	if fills == 0 {
+		t.Fatalf("Test session should have produced fills")
+	}
+
+	limitEngines, err := cxdbmemory.CreateLimitEngineMap([]*match.Pair{testPair})
+	if err != nil {
+		t.Fatalf("Error creating limit engines: %s", err)
+	}
+	auctionEngines, err := cxdbmemory.CreateAuctionEngineMap([]*match.Pair{testPair})
+	if err != nil {
+		t.Fatalf("Error creating auction engines: %s", err)
+	}
+
+	results, err := Replay(events, limitEngines, auctionEngines)
+	if err != nil {
+		t.Fatalf("Error replaying events: %s", err)
+	}
+	for _, diff := range Diff(events, RecordedResults(events), results) {
+		t.Errorf("Replay differs from recorded results for %s", diff)
+	}
+}
+
+func TestReplayBoltMatchesMemory(t *testing.T) {
+	events := recordTestSession(t)
+
+	dataDir, err := ioutil.TempDir("", "cxevent")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	limitEngines, err := cxdbbolt.CreateLimitEngineMap([]*match.Pair{testPair}, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating limit engines: %s", err)
+	}
+	auctionEngines, err := cxdbbolt.CreateAuctionEngineMap([]*match.Pair{testPair}, dataDir)
+	if err != nil {
+		t.Fatalf("Error creating auction engines: %s", err)
+	}
+
+	results, err := Replay(events, limitEngines, auctionEngines)
+	if err != nil {
+		t.Fatalf("Error replaying events: %s", err)
+	}
+	for _, diff := range Diff(events, RecordedResults(events), results) {
+		t.Errorf("Bolt replay differs from recorded results for %s", diff)
+	}
+}
+
+func TestDiffFindsChangedFill(t *testing.T) {
+	events := recordTestSession(t)
+
+	expected := RecordedResults(events)
+	got := make([]*Result, len(expected))
+	copy(got, expected)
+
+	for i, result := range got {
+		if len(result.Fills) == 0 {
+			continue
+		}
+		changed := *result
+		changed.Fills = append([]*Fill{}, result.Fills...)
+		changedFill := *changed.Fills[0]
+		changedFill.NewAmountHave++
+		changed.Fills[0] = &changedFill
+		got[i] = &changed
+
+		diffs := Diff(events, expected, got)
+		if len(diffs) != 1 || diffs[0].Event.Seq != events[i].Seq {
+			t.Fatalf("Diff should find exactly the changed fill, found %d differences", len(diffs))
+		}
+		return
+	}
+	t.Fatalf("Test session should have produced fills")
+}
@@ -0,0 +1,109 @@
+package cxevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// Result is what the engines did with an event. Order IDs depend on the engine, so fills refer to
+// orders by the seq of the event that placed them, which makes results from different engines
+// comparable.
+type Result struct {
+	Fills       []*Fill       `json:"fills,omitempty"`
+	Settlements []*Settlement `json:"settlements,omitempty"`
+	// Err is set if the engine returned an error for the event
+	Err string `json:"err,omitempty"`
+}
+
+// Fill is an order execution
+type Fill struct {
+	// OrderSeq is the seq of the event that placed the order
+	OrderSeq      uint64 `json:"orderseq"`
+	NewAmountHave uint64 `json:"newamounthave"`
+	NewAmountWant uint64 `json:"newamountwant"`
+	Filled        bool   `json:"filled"`
+}
+
+// Settlement is a settlement execution. SettleType doesn't survive json, so it's a bool here.
+type Settlement struct {
+	Pubkey [33]byte    `json:"pubkey"`
+	Asset  match.Asset `json:"asset"`
+	Amount uint64      `json:"amount"`
+	Debit  bool        `json:"debit"`
+}
+
+// NewResult creates a result from the executions an engine returned. orderSeqs maps the engine's
+// order IDs to the seq of the event that placed each order. Fills and settlements are sorted, since
+// the order engines return them in can depend on map ordering.
+func NewResult(orderExecs []*match.OrderExecution, setExecs []*match.SettlementExecution, orderSeqs map[match.OrderID]uint64) (result *Result) {
+	result = new(Result)
+	for _, orderExec := range orderExecs {
+		result.Fills = append(result.Fills, &Fill{
+			OrderSeq:      orderSeqs[orderExec.OrderID],
+			NewAmountHave: orderExec.NewAmountHave,
+			NewAmountWant: orderExec.NewAmountWant,
+			Filled:        orderExec.Filled,
+		})
+	}
+	for _, setExec := range setExecs {
+		result.Settlements = append(result.Settlements, &Settlement{
+			Pubkey: setExec.Pubkey,
+			Asset:  setExec.Asset,
+			Amount: setExec.Amount,
+			Debit:  setExec.Type == match.Debit,
+		})
+	}
+	result.sort()
+	return
+}
+
+// sort puts fills and settlements in a canonical order
+func (r *Result) sort() {
+	sort.SliceStable(r.Fills, func(i, j int) bool {
+		left, right := r.Fills[i], r.Fills[j]
+		if left.OrderSeq != right.OrderSeq {
+			return left.OrderSeq < right.OrderSeq
+		}
+		if left.NewAmountHave != right.NewAmountHave {
+			return left.NewAmountHave < right.NewAmountHave
+		}
+		if left.NewAmountWant != right.NewAmountWant {
+			return left.NewAmountWant < right.NewAmountWant
+		}
+		return !left.Filled && right.Filled
+	})
+	sort.SliceStable(r.Settlements, func(i, j int) bool {
+		left, right := r.Settlements[i], r.Settlements[j]
+		if cmp := bytes.Compare(left.Pubkey[:], right.Pubkey[:]); cmp != 0 {
+			return cmp < 0
+		}
+		if left.Asset != right.Asset {
+			return left.Asset < right.Asset
+		}
+		if left.Debit != right.Debit {
+			return !left.Debit && right.Debit
+		}
+		return left.Amount < right.Amount
+	})
+}
+
+// Equal returns true if both results have the same fills, settlements, and error
+func (r *Result) Equal(other *Result) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return reflect.DeepEqual(r.Fills, other.Fills) &&
+		reflect.DeepEqual(r.Settlements, other.Settlements) &&
+		r.Err == other.Err
+}
+
+// String returns the json representation of the result
+func (r *Result) String() string {
+	// we are ignoring this error because we know that the struct is marshallable.
+	jsonRepresentation, _ := json.Marshal(r)
+	return string(jsonRepresentation)
+}
@@ -0,0 +1,318 @@
+package cxrpc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/crypto/sha3"
+)
+
+// AdminCommandHash is what gets signed for an admin command, it's the hash of the command name
+// and its arguments. Admin commands are only run if they're signed by the exchange's admin key.
+func AdminCommandHash(command string, args ...string) (e []byte) {
+	// e = h(command || args)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(strings.Join(append([]string{command}, args...), "\x00")))
+	e = sha3.Sum(nil)
+	return
+}
+
+// verifyAdmin recovers the pubkey that signed an admin command and makes sure it's the admin
+func (cl *OpencxRPC) verifyAdmin(signature []byte, command string, args ...string) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, AdminCommandHash(command, args...)); err != nil {
+		err = fmt.Errorf("Error, invalid signature with %s admin command: %s", command, err)
+		return
+	}
+
+	if err = cl.Server.CheckAdmin(pubkey); err != nil {
+		err = fmt.Errorf("Error, %s admin command not signed by admin: %s", command, err)
+		return
+	}
+	return
+}
+
+// ListWithdrawalsArgs holds the args for ListWithdrawals
+type ListWithdrawalsArgs struct {
+	Asset     string
+	State     string
+	Signature []byte
+}
+
+// ListWithdrawalsReply holds the reply for ListWithdrawals
+type ListWithdrawalsReply struct {
+	Withdrawals []*match.WithdrawalRequest
+}
+
+// ListWithdrawals is the RPC Interface for ListWithdrawals, it lists every withdrawal of an asset
+// in a state, for every user
+func (cl *OpencxRPC) ListWithdrawals(args ListWithdrawalsArgs, reply *ListWithdrawalsReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "listwithdrawals", args.Asset, args.State); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	var state match.WithdrawalState
+	if state, err = match.WithdrawalStateFromString(args.State); err != nil {
+		err = fmt.Errorf("Error getting state for ListWithdrawals RPC: %s", err)
+		return
+	}
+
+	if reply.Withdrawals, err = cl.Server.ListWithdrawals(param, state); err != nil {
+		err = fmt.Errorf("Error listing withdrawals for ListWithdrawals RPC: %s", err)
+		return
+	}
+	return
+}
+
+// ApproveWithdrawalArgs holds the args for ApproveWithdrawal
+type ApproveWithdrawalArgs struct {
+	Asset     string
+	ID        uint64
+	Signature []byte
+}
+
+// ApproveWithdrawalReply holds the reply for ApproveWithdrawal
+type ApproveWithdrawalReply struct {
+	Withdrawal *match.WithdrawalRequest
+}
+
+// ApproveWithdrawal is the RPC Interface for ApproveWithdrawal
+func (cl *OpencxRPC) ApproveWithdrawal(args ApproveWithdrawalArgs, reply *ApproveWithdrawalReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "approvewithdrawal", args.Asset, fmt.Sprintf("%d", args.ID)); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Withdrawal, err = cl.Server.ApproveWithdrawal(args.ID, param); err != nil {
+		err = fmt.Errorf("Error approving withdrawal for ApproveWithdrawal RPC: %s", err)
+		return
+	}
+	return
+}
+
+// RejectWithdrawalArgs holds the args for RejectWithdrawal
+type RejectWithdrawalArgs struct {
+	Asset     string
+	ID        uint64
+	Reason    string
+	Signature []byte
+}
+
+// RejectWithdrawalReply holds the reply for RejectWithdrawal
+type RejectWithdrawalReply struct {
+	Withdrawal *match.WithdrawalRequest
+}
+
+// RejectWithdrawal is the RPC Interface for RejectWithdrawal
+func (cl *OpencxRPC) RejectWithdrawal(args RejectWithdrawalArgs, reply *RejectWithdrawalReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "rejectwithdrawal", args.Asset, fmt.Sprintf("%d", args.ID), args.Reason); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Withdrawal, err = cl.Server.RejectWithdrawal(args.ID, param, args.Reason); err != nil {
+		err = fmt.Errorf("Error rejecting withdrawal for RejectWithdrawal RPC: %s", err)
+		return
+	}
+	return
+}
+
+// WalletBalancesArgs holds the args for WalletBalances
+type WalletBalancesArgs struct {
+	Asset     string
+	Signature []byte
+}
+
+// WalletBalancesReply holds the reply for WalletBalances
+type WalletBalancesReply struct {
+	Balances *match.WalletBalances
+}
+
+// WalletBalances is the RPC Interface for WalletBalances, it gets how much the exchange holds in
+// its hot and cold wallets for an asset
+func (cl *OpencxRPC) WalletBalances(args WalletBalancesArgs, reply *WalletBalancesReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "walletbalances", args.Asset); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Balances, err = cl.Server.GetWalletBalances(param); err != nil {
+		err = fmt.Errorf("Error getting wallet balances for WalletBalances RPC: %s", err)
+		return
+	}
+	return
+}
+
+// SweepToColdArgs holds the args for SweepToCold
+type SweepToColdArgs struct {
+	Asset     string
+	Signature []byte
+}
+
+// SweepToColdReply holds the reply for SweepToCold
+type SweepToColdReply struct {
+	Txid string
+}
+
+// SweepToCold is the RPC Interface for SweepToCold, it sweeps the hot wallet down to its ceiling
+// now rather than waiting for the sweeper
+func (cl *OpencxRPC) SweepToCold(args SweepToColdArgs, reply *SweepToColdReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "sweeptocold", args.Asset); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Txid, err = cl.Server.SweepToCold(param); err != nil {
+		err = fmt.Errorf("Error sweeping to cold wallet for SweepToCold RPC: %s", err)
+		return
+	}
+	return
+}
+
+// CreateRefillArgs holds the args for CreateRefill
+type CreateRefillArgs struct {
+	Asset     string
+	Amount    uint64
+	Signature []byte
+}
+
+// CreateRefillReply holds the reply for CreateRefill
+type CreateRefillReply struct {
+	Refill *match.RefillTx
+}
+
+// CreateRefill is the RPC Interface for CreateRefill, it creates an unsigned transaction moving
+// funds from the cold wallet to the hot wallet
+func (cl *OpencxRPC) CreateRefill(args CreateRefillArgs, reply *CreateRefillReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "createrefill", args.Asset, fmt.Sprintf("%d", args.Amount)); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Refill, err = cl.Server.CreateRefill(param, args.Amount); err != nil {
+		err = fmt.Errorf("Error creating refill for CreateRefill RPC: %s", err)
+		return
+	}
+	return
+}
+
+// SubmitRefillArgs holds the args for SubmitRefill
+type SubmitRefillArgs struct {
+	Asset     string
+	Tx        []byte
+	Signature []byte
+}
+
+// SubmitRefillReply holds the reply for SubmitRefill
+type SubmitRefillReply struct {
+	Txid string
+}
+
+// SubmitRefill is the RPC Interface for SubmitRefill, it sends out a refill the operator signed
+func (cl *OpencxRPC) SubmitRefill(args SubmitRefillArgs, reply *SubmitRefillReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "submitrefill", args.Asset, fmt.Sprintf("%x", args.Tx)); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Txid, err = cl.Server.SubmitRefill(param, args.Tx); err != nil {
+		err = fmt.Errorf("Error submitting refill for SubmitRefill RPC: %s", err)
+		return
+	}
+	return
+}
+
+// ReconcileLightningArgs holds the args for ReconcileLightning
+type ReconcileLightningArgs struct {
+	Asset     string
+	Signature []byte
+}
+
+// ReconcileLightningReply holds the reply for ReconcileLightning
+type ReconcileLightningReply struct {
+	Channels []*match.ChannelReconciliation
+}
+
+// ReconcileLightning is the RPC Interface for ReconcileLightning, it compares the deposits
+// credited over each open channel for an asset with what the exchange has in the channel
+func (cl *OpencxRPC) ReconcileLightning(args ReconcileLightningArgs, reply *ReconcileLightningReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "reconcilelightning", args.Asset); err != nil {
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Channels, err = cl.Server.ReconcileLightning(param); err != nil {
+		err = fmt.Errorf("Error reconciling channels for ReconcileLightning RPC: %s", err)
+		return
+	}
+	return
+}
+
+// GetBreachAlertsArgs holds the args for GetBreachAlerts
+type GetBreachAlertsArgs struct {
+	Signature []byte
+}
+
+// GetBreachAlertsReply holds the reply for GetBreachAlerts
+type GetBreachAlertsReply struct {
+	Alerts []*match.BreachAlert
+}
+
+// GetBreachAlerts is the RPC Interface for GetBreachAlerts, it returns the breaches the exchange's
+// watchtower has seen
+func (cl *OpencxRPC) GetBreachAlerts(args GetBreachAlertsArgs, reply *GetBreachAlertsReply) (err error) {
+	if err = cl.verifyAdmin(args.Signature, "getbreachalerts"); err != nil {
+		return
+	}
+
+	if reply.Alerts, err = cl.Server.GetBreachAlerts(); err != nil {
+		err = fmt.Errorf("Error getting breach alerts for GetBreachAlerts RPC: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package cxrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SubmitAtomicOrderArgs holds the args for the submitatomicorder command
+type SubmitAtomicOrderArgs struct {
+	Order *match.LimitOrder
+	// Signature is a compact signature so we can do pubkey recovery
+	Signature []byte
+}
+
+// SubmitAtomicOrderReply holds the reply for the submitatomicorder command
+type SubmitAtomicOrderReply struct {
+	OrderID *match.OrderID
+}
+
+// SubmitAtomicOrder submits an order on an atomic swap pair, which is settled by atomic swap
+// between the user's own wallet and the other side's. The order is signed the same way as for
+// SubmitOrder.
+func (cl *OpencxRPC) SubmitAtomicOrder(args SubmitAtomicOrderArgs, reply *SubmitAtomicOrderReply) (err error) {
+
+	var sigPubKey *koblitz.PublicKey
+	if sigPubKey, err = verifyOrderSignature(args.Order, args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying order for SubmitAtomicOrder RPC command: %s", err)
+		return
+	}
+
+	if reply.OrderID, err = cl.Server.PlaceAtomicOrder(args.Order); err != nil {
+		err = fmt.Errorf("Error placing atomic swap order for SubmitAtomicOrder RPC command: %s", err)
+		return
+	}
+
+	var text []byte
+	if text, err = reply.OrderID.MarshalText(); err != nil {
+		err = fmt.Errorf("Could not marshal text for some reason: %s", err)
+		return
+	}
+
+	logging.Infof("User %x submitted atomic swap OrderID %s", sigPubKey.SerializeCompressed(), text)
+
+	return
+}
+
+// InitiateAtomicSwapArgs holds the args for the initiateatomicswap command
+type InitiateAtomicSwapArgs struct {
+	ID    [32]byte
+	RHash [32]byte
+	// Signature is a compact signature of match.AtomicInitiationSigHash, by the initiator
+	Signature []byte
+}
+
+// InitiateAtomicSwapReply holds the reply for the initiateatomicswap command
+type InitiateAtomicSwapReply struct {
+	Swap *match.AtomicSwap
+	// Address is the address the initiator locks their side in
+	Address string
+}
+
+// InitiateAtomicSwap sets the hash of an atomic swap, for its initiator
+func (cl *OpencxRPC) InitiateAtomicSwap(args InitiateAtomicSwapArgs, reply *InitiateAtomicSwapReply) (err error) {
+	if reply.Swap, reply.Address, err = cl.Server.InitiateAtomicSwap(args.ID, args.RHash, args.Signature); err != nil {
+		err = fmt.Errorf("Error initiating atomic swap for InitiateAtomicSwap RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetAtomicSwapsArgs holds the args for the getatomicswaps command
+type GetAtomicSwapsArgs struct {
+	// Signature is a compact signature of the getAtomicSwapsString
+	Signature []byte
+}
+
+// GetAtomicSwapsReply holds the reply for the getatomicswaps command
+type GetAtomicSwapsReply struct {
+	Swaps []*match.AtomicSwap
+}
+
+// GetAtomicSwaps gets the atomic swaps for the pubkey which has signed the getAtomicSwapsString
+func (cl *OpencxRPC) GetAtomicSwaps(args GetAtomicSwapsArgs, reply *GetAtomicSwapsReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.Server.GetAtomicSwapsStringVerify(args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for GetAtomicSwaps RPC command: %s", err)
+		return
+	}
+
+	if reply.Swaps, err = cl.Server.GetAtomicSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting atomic swaps for GetAtomicSwaps RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// SubmitAtomicSpendArgs holds the args for the submitatomicspend command
+type SubmitAtomicSpendArgs struct {
+	Asset string
+	// Tx is the serialized transaction spending a side of an atomic swap
+	Tx []byte
+}
+
+// SubmitAtomicSpendReply holds the reply for the submitatomicspend command
+type SubmitAtomicSpendReply struct {
+	Txid string
+}
+
+// SubmitAtomicSpend sends out a transaction that redeems or refunds a side of an atomic swap. It
+// doesn't need a signature, the transaction is only sent if it spends an atomic swap properly.
+func (cl *OpencxRPC) SubmitAtomicSpend(args SubmitAtomicSpendArgs, reply *SubmitAtomicSpendReply) (err error) {
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Txid, err = cl.Server.SubmitAtomicSpend(param, args.Tx); err != nil {
+		err = fmt.Errorf("Error submitting spend for SubmitAtomicSpend RPC command: %s", err)
+		return
+	}
+
+	return
+}
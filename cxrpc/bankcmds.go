@@ -88,28 +88,66 @@ func (cl *OpencxRPC) GetDepositAddress(args GetDepositAddressArgs, reply *GetDep
 	return
 }
 
+// GetDepositsArgs holds the arguments for GetDeposits
+type GetDepositsArgs struct {
+	Asset     string
+	Signature []byte
+}
+
+// GetDepositsReply holds the reply for GetDeposits
+type GetDepositsReply struct {
+	Deposits []*match.DepositStatus
+}
+
+// GetDeposits is the RPC Interface for GetDeposits
+func (cl *OpencxRPC) GetDeposits(args GetDepositsArgs, reply *GetDepositsReply) (err error) {
+
+	// e = h(asset)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(args.Asset))
+	e := sha3.Sum(nil)
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
+		err = fmt.Errorf("Error, invalid signature with GetDeposits RPC command: %s", err)
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Deposits, err = cl.Server.GetDeposits(pubkey, param); err != nil {
+		err = fmt.Errorf("Error getting deposits from server for GetDeposits RPC: %s", err)
+		return
+	}
+
+	return
+}
+
 // WithdrawArgs holds the args for Withdraw
 type WithdrawArgs struct {
 	Withdrawal *match.Withdrawal
 	Signature  []byte
 }
 
-// WithdrawReply holds the reply for Withdraw
+// WithdrawReply holds the reply for Withdraw. Lightning withdrawals to a new channel happen right
+// away and have a txid, on-chain withdrawals are queued and the txid is set on the withdrawal once
+// it's broadcast. Lightning withdrawals that pay a payment request are returned as broadcast, and
+// the payment hash is set as their txid once the payee gives one.
 type WithdrawReply struct {
-	Txid string
+	Txid       string
+	Withdrawal *match.WithdrawalRequest
 }
 
-// Withdraw is the RPC Interface for Withdraw
+// Withdraw is the RPC Interface for Withdraw. The withdrawal has to be signed by the user, for
+// this exchange's withdrawal domain, with a nonce the user hasn't used before.
 func (cl *OpencxRPC) Withdraw(args WithdrawArgs, reply *WithdrawReply) (err error) {
 
-	// e = h(asset)
-	sha3 := sha3.New256()
-	sha3.Write(args.Withdrawal.Serialize())
-	e := sha3.Sum(nil)
-
-	pubkey, _, err := koblitz.RecoverCompact(koblitz.S256(), args.Signature, e)
-	if err != nil {
-		err = fmt.Errorf("Error verifying order, invalid signature: \n%s", err)
+	if args.Withdrawal == nil {
+		err = fmt.Errorf("Error, no withdrawal for withdraw command")
 		return
 	}
 
@@ -118,18 +156,26 @@ func (cl *OpencxRPC) Withdraw(args WithdrawArgs, reply *WithdrawReply) (err erro
 		return
 	}
 
-	// We just ignore the address if they specify lightning.
+	// Lightning withdrawals with an address pay it as a payment request, the rest push to the
+	// user in a new channel and ignore the address.
 
-	if args.Withdrawal.Lightning {
+	if args.Withdrawal.Lightning && args.Withdrawal.Address != "" {
 
-		if reply.Txid, err = cl.Server.WithdrawLightning(pubkey, args.Withdrawal.Amount, coinType); err != nil {
+		if reply.Withdrawal, err = cl.Server.PayPaymentRequest(args.Withdrawal, args.Signature, coinType); err != nil {
+			err = fmt.Errorf("Error with withdraw command (pay payment request): \n%s", err)
+			return
+		}
+
+	} else if args.Withdrawal.Lightning {
+
+		if reply.Txid, err = cl.Server.WithdrawLightning(args.Withdrawal, args.Signature, coinType); err != nil {
 			err = fmt.Errorf("Error with withdraw command (withdraw from lightning): \n%s", err)
 			return
 		}
 
 	} else {
 
-		if reply.Txid, err = cl.Server.WithdrawCoins(args.Withdrawal.Address, pubkey, args.Withdrawal.Amount, coinType); err != nil {
+		if reply.Withdrawal, err = cl.Server.RequestWithdrawal(args.Withdrawal, args.Signature, coinType); err != nil {
 			err = fmt.Errorf("Error with withdraw command (withdraw from chain): \n%s", err)
 			return
 		}
@@ -138,3 +184,85 @@ func (cl *OpencxRPC) Withdraw(args WithdrawArgs, reply *WithdrawReply) (err erro
 
 	return
 }
+
+// GetFeeEstimatesArgs holds the args for GetFeeEstimates
+type GetFeeEstimatesArgs struct {
+	Asset string
+}
+
+// GetFeeEstimatesReply holds the reply for GetFeeEstimates
+type GetFeeEstimatesReply struct {
+	Estimates *match.FeeEstimates
+}
+
+// GetFeeEstimates is the RPC Interface for GetFeeEstimates, it returns the fee rates withdrawals
+// of an asset pay at each priority, so users can pick one before they withdraw
+func (cl *OpencxRPC) GetFeeEstimates(args GetFeeEstimatesArgs, reply *GetFeeEstimatesReply) (err error) {
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Estimates, err = cl.Server.GetFeeEstimates(param); err != nil {
+		err = fmt.Errorf("Error getting fee estimates from server for GetFeeEstimates RPC: %s", err)
+		return
+	}
+	return
+}
+
+// GetWithdrawalDomainArgs holds the args for GetWithdrawalDomain
+type GetWithdrawalDomainArgs struct {
+	// empty
+}
+
+// GetWithdrawalDomainReply holds the reply for GetWithdrawalDomain
+type GetWithdrawalDomainReply struct {
+	Domain string
+}
+
+// GetWithdrawalDomain is the RPC Interface for GetWithdrawalDomain, it returns the domain
+// withdrawals have to be signed for
+func (cl *OpencxRPC) GetWithdrawalDomain(args GetWithdrawalDomainArgs, reply *GetWithdrawalDomainReply) (err error) {
+	reply.Domain = cl.Server.GetWithdrawalDomain()
+	return
+}
+
+// GetWithdrawalsArgs holds the args for GetWithdrawals
+type GetWithdrawalsArgs struct {
+	Asset     string
+	Signature []byte
+}
+
+// GetWithdrawalsReply holds the reply for GetWithdrawals
+type GetWithdrawalsReply struct {
+	Withdrawals []*match.WithdrawalRequest
+}
+
+// GetWithdrawals is the RPC Interface for GetWithdrawals
+func (cl *OpencxRPC) GetWithdrawals(args GetWithdrawalsArgs, reply *GetWithdrawalsReply) (err error) {
+
+	// e = h(asset)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(args.Asset))
+	e := sha3.Sum(nil)
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
+		err = fmt.Errorf("Error, invalid signature with GetWithdrawals RPC command: %s", err)
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Withdrawals, err = cl.Server.GetWithdrawals(pubkey, param); err != nil {
+		err = fmt.Errorf("Error getting withdrawals from server for GetWithdrawals RPC: %s", err)
+		return
+	}
+
+	return
+}
@@ -5,6 +5,8 @@ import (
 	"net"
 	"strconv"
 
+	"github.com/mit-dci/lit/coinparam"
+	util "github.com/mit-dci/opencx/chainutils"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -62,7 +64,23 @@ type WithdrawToLightningNodeReply struct {
 }
 
 // WithdrawToLightningNode creates a channel that pushes a certain amount to a lightning node through a lightning channel.
+// The withdrawal has to be a signed lightning withdrawal.
 func (cl *OpencxRPC) WithdrawToLightningNode(args WithdrawToLightningNodeArgs, reply *WithdrawToLightningNodeReply) (err error) {
 
+	if args.Withdrawal == nil || !args.Withdrawal.Lightning {
+		err = fmt.Errorf("Error, withdrawtolightning needs a lightning withdrawal")
+		return
+	}
+
+	var coinType *coinparam.Params
+	if coinType, err = util.GetParamFromName(args.Withdrawal.Asset.String()); err != nil {
+		return
+	}
+
+	if reply.Txid, err = cl.Server.WithdrawLightning(args.Withdrawal, args.Signature, coinType); err != nil {
+		err = fmt.Errorf("Error with withdrawtolightning command: \n%s", err)
+		return
+	}
+
 	return
 }
@@ -0,0 +1,65 @@
+package cxrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// CreateInvoiceArgs holds the args for the createinvoice command
+type CreateInvoiceArgs struct {
+	Request *match.InvoiceRequest
+	// Signature is a compact signature of the request so we can do pubkey recovery
+	Signature []byte
+}
+
+// CreateInvoiceReply holds the reply for the createinvoice command
+type CreateInvoiceReply struct {
+	Invoice *match.Invoice
+	// PaymentRequest is what the payer needs to pay the invoice
+	PaymentRequest string
+}
+
+// CreateInvoice creates an invoice that credits the user that signed the request once it's paid
+// over lightning, from any node that can route to the exchange
+func (cl *OpencxRPC) CreateInvoice(args CreateInvoiceArgs, reply *CreateInvoiceReply) (err error) {
+	if args.Request == nil {
+		err = fmt.Errorf("No request for CreateInvoice RPC command")
+		return
+	}
+
+	if reply.Invoice, reply.PaymentRequest, err = cl.Server.CreateInvoice(args.Request, args.Signature); err != nil {
+		err = fmt.Errorf("Error creating invoice for CreateInvoice RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetInvoicesArgs holds the args for the getinvoices command
+type GetInvoicesArgs struct {
+	// Signature is a compact signature of the getInvoicesString
+	Signature []byte
+}
+
+// GetInvoicesReply holds the reply for the getinvoices command
+type GetInvoicesReply struct {
+	Invoices []*match.Invoice
+}
+
+// GetInvoices gets the invoices for the pubkey which has signed the getInvoicesString
+func (cl *OpencxRPC) GetInvoices(args GetInvoicesArgs, reply *GetInvoicesReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.Server.GetInvoicesStringVerify(args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for GetInvoices RPC command: %s", err)
+		return
+	}
+
+	if reply.Invoices, err = cl.Server.GetInvoices(pubkey); err != nil {
+		err = fmt.Errorf("Error getting invoices for GetInvoices RPC command: %s", err)
+		return
+	}
+
+	return
+}
@@ -24,9 +24,37 @@ type SubmitOrderReply struct {
 // SubmitOrder submits an order to the order book or throws an error
 func (cl *OpencxRPC) SubmitOrder(args SubmitOrderArgs, reply *SubmitOrderReply) (err error) {
 
+	var sigPubKey *koblitz.PublicKey
+	if sigPubKey, err = verifyOrderSignature(args.Order, args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying order for SubmitOrder RPC command: %s", err)
+		return
+	}
+
+	// possible replay attack: if we're using the same pubkey for two exchanges and this is like a feature on the exchange, then an exchange could have you
+	// place an order on their exchange, even with a nonce, and then send it over to the other exchange. When you submit an order on one exchange,
+	// you essentially submit an order to all of them. Channel orders (SubmitChannelOrder) don't have this problem because they aren't placed until
+	// the user locks HTLCs to a hash only this exchange knows the preimage of
+	if reply.OrderID, err = cl.Server.PlaceOrder(args.Order); err != nil {
+		err = fmt.Errorf("Error placing order for PlaceOrder RPC command: %s", err)
+		return
+	}
+
+	var text []byte
+	if text, err = reply.OrderID.MarshalText(); err != nil {
+		err = fmt.Errorf("Could not marshal text for some reason: %s", err)
+		return
+	}
+
+	logging.Infof("User %x submitted OrderID %s", sigPubKey.SerializeCompressed(), text)
+
+	return
+}
+
+// verifyOrderSignature checks that an order was signed by its own pubkey, and returns the pubkey
+func verifyOrderSignature(order *match.LimitOrder, signature []byte) (sigPubKey *koblitz.PublicKey, err error) {
 	var orderBytes []byte
-	if orderBytes, err = args.Order.Serialize(); err != nil {
-		err = fmt.Errorf("Error serializing order for SubmitOrder RPC command: %s", err)
+	if orderBytes, err = order.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing order: %s", err)
 		return
 	}
 
@@ -35,15 +63,14 @@ func (cl *OpencxRPC) SubmitOrder(args SubmitOrderArgs, reply *SubmitOrderReply)
 	sha3.Write(orderBytes)
 	e := sha3.Sum(nil)
 
-	var sigPubKey *koblitz.PublicKey
-	if sigPubKey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
+	if sigPubKey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, e); err != nil {
 		err = fmt.Errorf("Error verifying order, invalid signature: \n%s", err)
 		return
 	}
 
 	// try to parse the order pubkey into koblitz
 	var orderPubkey *koblitz.PublicKey
-	if orderPubkey, err = koblitz.ParsePubKey(args.Order.Pubkey[:], koblitz.S256()); err != nil {
+	if orderPubkey, err = koblitz.ParsePubKey(order.Pubkey[:], koblitz.S256()); err != nil {
 		err = fmt.Errorf("Public Key failed parsing check: \n%s", err)
 		return
 	}
@@ -52,24 +79,6 @@ func (cl *OpencxRPC) SubmitOrder(args SubmitOrderArgs, reply *SubmitOrderReply)
 		err = fmt.Errorf("Pubkey used with signature not equal to the one passed")
 		return
 	}
-
-	// possible replay attack: if we're using the same pubkey for two exchanges and this is like a feature on the exchange, then an exchange could have you
-	// place an order on their exchange, even with a nonce, and then send it over to the other exchange. When you submit an order on one exchange,
-	// you essentially submit an order to all of them. But like once we have channels for orders then this isn't a thing anymore because the channel
-	// tx's are signed and funding stuff is published on chain
-	if reply.OrderID, err = cl.Server.PlaceOrder(args.Order); err != nil {
-		err = fmt.Errorf("Error placing order for PlaceOrder RPC command: %s", err)
-		return
-	}
-
-	var text []byte
-	if text, err = reply.OrderID.MarshalText(); err != nil {
-		err = fmt.Errorf("Could not marshal text for some reason: %s", err)
-		return
-	}
-
-	logging.Infof("User %x submitted OrderID %s", sigPubKey.SerializeCompressed(), text)
-
 	return
 }
 
@@ -201,6 +210,26 @@ func (cl *OpencxRPC) GetPairs(args GetPairsArgs, reply *GetPairsReply) (err erro
 	return
 }
 
+// GetAssetsArgs holds the args for the GetAssets command
+type GetAssetsArgs struct {
+	// empty
+}
+
+// GetAssetsReply holds the reply for the GetAssets command
+type GetAssetsReply struct {
+	// Assets are in the form match.ParseAssetInfo takes
+	Assets []string
+}
+
+// GetAssets gets every asset the exchange knows about, with their decimals, limits, and lot and
+// tick sizes
+func (cl *OpencxRPC) GetAssets(args GetAssetsArgs, reply *GetAssetsReply) (err error) {
+	for _, info := range match.RegisteredAssets() {
+		reply.Assets = append(reply.Assets, info.String())
+	}
+	return
+}
+
 // GetOrderArgs holds the args for the GetOrder command
 type GetOrderArgs struct {
 	OrderID   string
@@ -275,3 +304,88 @@ func (cl *OpencxRPC) GetOrdersForPubkey(args GetOrdersForPubkeyArgs, reply *GetO
 
 	return
 }
+
+// GetOrderHistoryArgs holds the args for the GetOrderHistory command
+type GetOrderHistoryArgs struct {
+	Query *match.HistoryQuery
+	// Signature is a compact signature of the serialized query, the history is for the signer
+	Signature []byte
+}
+
+// GetOrderHistoryReply holds the reply for the GetOrderHistory command
+type GetOrderHistoryReply struct {
+	Orders []*match.OrderHistoryEntry
+	// NextCursor is the cursor for the next page, 0 if there are no more pages
+	NextCursor uint64
+}
+
+// GetOrderHistory gets a page of order history for the pubkey that signed the query
+func (cl *OpencxRPC) GetOrderHistory(args GetOrderHistoryArgs, reply *GetOrderHistoryReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = recoverHistoryQueryPubkey(args.Query, args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying query for GetOrderHistory RPC command: %s", err)
+		return
+	}
+
+	if reply.Orders, reply.NextCursor, err = cl.Server.GetOrderHistory(pubkey, args.Query); err != nil {
+		err = fmt.Errorf("Error getting order history for GetOrderHistory RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetFillHistoryArgs holds the args for the GetFillHistory command
+type GetFillHistoryArgs struct {
+	Query *match.HistoryQuery
+	// Signature is a compact signature of the serialized query, the history is for the signer
+	Signature []byte
+}
+
+// GetFillHistoryReply holds the reply for the GetFillHistory command
+type GetFillHistoryReply struct {
+	Fills []*match.FillEntry
+	// NextCursor is the cursor for the next page, 0 if there are no more pages
+	NextCursor uint64
+}
+
+// GetFillHistory gets a page of fills for the pubkey that signed the query
+func (cl *OpencxRPC) GetFillHistory(args GetFillHistoryArgs, reply *GetFillHistoryReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = recoverHistoryQueryPubkey(args.Query, args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying query for GetFillHistory RPC command: %s", err)
+		return
+	}
+
+	if reply.Fills, reply.NextCursor, err = cl.Server.GetFillHistory(pubkey, args.Query); err != nil {
+		err = fmt.Errorf("Error getting fill history for GetFillHistory RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// recoverHistoryQueryPubkey recovers the pubkey that signed a history query
+func recoverHistoryQueryPubkey(query *match.HistoryQuery, sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	if query == nil {
+		err = fmt.Errorf("Error, history query cannot be nil")
+		return
+	}
+
+	var queryBytes []byte
+	if queryBytes, err = query.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing history query: %s", err)
+		return
+	}
+
+	// hash query
+	sha3 := sha3.New256()
+	sha3.Write(queryBytes)
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying history query, invalid signature: \n%s", err)
+		return
+	}
+	return
+}
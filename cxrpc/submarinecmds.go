@@ -0,0 +1,98 @@
+package cxrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/match"
+)
+
+// CreateSubmarineSwapArgs holds the args for the createsubmarineswap command
+type CreateSubmarineSwapArgs struct {
+	Request *match.SubmarineRequest
+	// Signature is a compact signature of the request so we can do pubkey recovery
+	Signature []byte
+}
+
+// CreateSubmarineSwapReply holds the reply for the createsubmarineswap command
+type CreateSubmarineSwapReply struct {
+	Swap *match.SubmarineSwap
+	// Address is the address of the submarine swap's on-chain HTLC
+	Address string
+}
+
+// CreateSubmarineSwap creates a submarine swap between the user's on-chain coins and their
+// lightning channels with the exchange, for a request the user signed
+func (cl *OpencxRPC) CreateSubmarineSwap(args CreateSubmarineSwapArgs, reply *CreateSubmarineSwapReply) (err error) {
+	if args.Request == nil {
+		err = fmt.Errorf("No request for CreateSubmarineSwap RPC command")
+		return
+	}
+
+	if reply.Swap, reply.Address, err = cl.Server.CreateSubmarineSwap(args.Request, args.Signature); err != nil {
+		err = fmt.Errorf("Error creating submarine swap for CreateSubmarineSwap RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetSubmarineSwapsArgs holds the args for the getsubmarineswaps command
+type GetSubmarineSwapsArgs struct {
+	// Signature is a compact signature of the getSubmarineSwapsString
+	Signature []byte
+}
+
+// GetSubmarineSwapsReply holds the reply for the getsubmarineswaps command
+type GetSubmarineSwapsReply struct {
+	Swaps []*match.SubmarineSwap
+}
+
+// GetSubmarineSwaps gets the submarine swaps for the pubkey which has signed the
+// getSubmarineSwapsString
+func (cl *OpencxRPC) GetSubmarineSwaps(args GetSubmarineSwapsArgs, reply *GetSubmarineSwapsReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.Server.GetSubmarineSwapsStringVerify(args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for GetSubmarineSwaps RPC command: %s", err)
+		return
+	}
+
+	if reply.Swaps, err = cl.Server.GetSubmarineSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting submarine swaps for GetSubmarineSwaps RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// SubmitSubmarineSpendArgs holds the args for the submitsubmarinespend command
+type SubmitSubmarineSpendArgs struct {
+	Asset string
+	// Tx is the serialized transaction spending the on-chain HTLC
+	Tx []byte
+}
+
+// SubmitSubmarineSpendReply holds the reply for the submitsubmarinespend command
+type SubmitSubmarineSpendReply struct {
+	Txid string
+}
+
+// SubmitSubmarineSpend sends out a transaction that claims a swap out's on-chain HTLC or refunds
+// a swap in's. It doesn't need a signature, the transaction is only sent if it spends a
+// submarine swap properly.
+func (cl *OpencxRPC) SubmitSubmarineSpend(args SubmitSubmarineSpendArgs, reply *SubmitSubmarineSpendReply) (err error) {
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Txid, err = cl.Server.SubmitSubmarineSpend(param, args.Tx); err != nil {
+		err = fmt.Errorf("Error submitting spend for SubmitSubmarineSpend RPC command: %s", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,163 @@
+package cxrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SubmitSwapOrderArgs holds the args for the submitswaporder command
+type SubmitSwapOrderArgs struct {
+	Order *match.LimitOrder
+	// Signature is a compact signature so we can do pubkey recovery
+	Signature []byte
+}
+
+// SubmitSwapOrderReply holds the reply for the submitswaporder command
+type SubmitSwapOrderReply struct {
+	OrderID *match.OrderID
+}
+
+// SubmitSwapOrder submits an order that's settled with HTLCs over the user's lightning channels
+// rather than out of their balance. The order is signed the same way as for SubmitOrder.
+func (cl *OpencxRPC) SubmitSwapOrder(args SubmitSwapOrderArgs, reply *SubmitSwapOrderReply) (err error) {
+
+	var sigPubKey *koblitz.PublicKey
+	if sigPubKey, err = verifyOrderSignature(args.Order, args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying order for SubmitSwapOrder RPC command: %s", err)
+		return
+	}
+
+	if reply.OrderID, err = cl.Server.PlaceSwapOrder(args.Order); err != nil {
+		err = fmt.Errorf("Error placing swap order for SubmitSwapOrder RPC command: %s", err)
+		return
+	}
+
+	var text []byte
+	if text, err = reply.OrderID.MarshalText(); err != nil {
+		err = fmt.Errorf("Could not marshal text for some reason: %s", err)
+		return
+	}
+
+	logging.Infof("User %x submitted swap OrderID %s", sigPubKey.SerializeCompressed(), text)
+
+	return
+}
+
+// SubmitChannelOrderArgs holds the args for the submitchannelorder command
+type SubmitChannelOrderArgs struct {
+	Order *match.LimitOrder
+	// Signature is a compact signature so we can do pubkey recovery
+	Signature []byte
+}
+
+// SubmitChannelOrderReply holds the reply for the submitchannelorder command
+type SubmitChannelOrderReply struct {
+	// Escrow says what the user has to lock in HTLCs for the order to go on the book
+	Escrow *match.OrderEscrow
+}
+
+// SubmitChannelOrder submits an order that's committed to the user's lightning channels. The
+// order goes on the book once the user has offered HTLCs for the escrow in the reply. The order
+// is signed the same way as for SubmitOrder.
+func (cl *OpencxRPC) SubmitChannelOrder(args SubmitChannelOrderArgs, reply *SubmitChannelOrderReply) (err error) {
+
+	var sigPubKey *koblitz.PublicKey
+	if sigPubKey, err = verifyOrderSignature(args.Order, args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying order for SubmitChannelOrder RPC command: %s", err)
+		return
+	}
+
+	if reply.Escrow, err = cl.Server.PlaceChannelOrder(args.Order); err != nil {
+		err = fmt.Errorf("Error placing channel order for SubmitChannelOrder RPC command: %s", err)
+		return
+	}
+
+	logging.Infof("User %x submitted channel order with escrow %s", sigPubKey.SerializeCompressed(), reply.Escrow.ID())
+
+	return
+}
+
+// GetSwapsArgs holds the args for the getswaps command
+type GetSwapsArgs struct {
+	// Signature is a compact signature of the getSwapsString
+	Signature []byte
+}
+
+// GetSwapsReply holds the reply for the getswaps command
+type GetSwapsReply struct {
+	Swaps []*match.Swap
+}
+
+// GetSwaps gets the swaps for the pubkey which has signed the getSwapsString
+func (cl *OpencxRPC) GetSwaps(args GetSwapsArgs, reply *GetSwapsReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.Server.GetSwapsStringVerify(args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for GetSwaps RPC command: %s", err)
+		return
+	}
+
+	if reply.Swaps, err = cl.Server.GetSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting swaps for GetSwaps RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetEscrowsArgs holds the args for the getescrows command
+type GetEscrowsArgs struct {
+	// Signature is a compact signature of the getEscrowsString
+	Signature []byte
+}
+
+// GetEscrowsReply holds the reply for the getescrows command
+type GetEscrowsReply struct {
+	Escrows []*match.OrderEscrow
+}
+
+// GetEscrows gets the channel order escrows for the pubkey which has signed the getEscrowsString
+func (cl *OpencxRPC) GetEscrows(args GetEscrowsArgs, reply *GetEscrowsReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.Server.GetEscrowsStringVerify(args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for GetEscrows RPC command: %s", err)
+		return
+	}
+
+	if reply.Escrows, err = cl.Server.GetEscrows(pubkey); err != nil {
+		err = fmt.Errorf("Error getting escrows for GetEscrows RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetLiquidityArgs holds the args for the getliquidity command
+type GetLiquidityArgs struct {
+	// Signature is a compact signature of the getLiquidityString
+	Signature []byte
+}
+
+// GetLiquidityReply holds the reply for the getliquidity command
+type GetLiquidityReply struct {
+	Liquidity []*match.Liquidity
+}
+
+// GetLiquidity gets how much can be sent each way over the channels of the pubkey which has
+// signed the getLiquidityString, and how much of it is reserved for swap orders and swaps
+func (cl *OpencxRPC) GetLiquidity(args GetLiquidityArgs, reply *GetLiquidityReply) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.Server.GetLiquidityStringVerify(args.Signature); err != nil {
+		err = fmt.Errorf("Error verifying signature for GetLiquidity RPC command: %s", err)
+		return
+	}
+
+	if reply.Liquidity, err = cl.Server.GetLiquidity(pubkey); err != nil {
+		err = fmt.Errorf("Error getting liquidity for GetLiquidity RPC command: %s", err)
+		return
+	}
+
+	return
+}
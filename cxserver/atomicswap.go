@@ -0,0 +1,506 @@
+package cxserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetAtomicSwapStore makes every order on pairs settle by atomic swap, keeping the atomic swaps
+// in the store. It should be set before the exchange takes orders. dbLock should not be held.
+func (server *OpencxServer) SetAtomicSwapStore(store cxdb.AtomicSwapStore, policy *match.AtomicSwapPolicy, pairs []*match.Pair) (err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	atomicPairs := make(map[match.Pair]bool)
+	for _, pair := range pairs {
+		if _, ok := server.MatchingEngines[*pair]; !ok {
+			err = fmt.Errorf("Can't settle %s by atomic swap, the exchange doesn't trade it", pair.String())
+			return
+		}
+		atomicPairs[*pair] = true
+	}
+
+	server.AtomicSwapStore = store
+	server.AtomicSwapPolicy = policy
+	server.AtomicPairs = atomicPairs
+	return
+}
+
+// PlaceAtomicOrder places an order on an atomic swap pair. Nothing comes out of the user's
+// balance, each fill becomes an atomic swap between the user's wallet and the other side's.
+// Users who have abandoned too many atomic swaps recently can't place them.
+func (server *OpencxServer) PlaceAtomicOrder(order *match.LimitOrder) (orderID *match.OrderID, err error) {
+	if server.AtomicSwapStore == nil {
+		err = fmt.Errorf("Atomic swap orders aren't supported")
+		return
+	}
+	if !server.AtomicPairs[order.TradingPair] {
+		err = fmt.Errorf("Orders on %s aren't settled by atomic swap, place a regular order instead", order.TradingPair.String())
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(order.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for PlaceAtomicOrder: %s", err)
+		return
+	}
+
+	var bannedUntil time.Time
+	if bannedUntil, err = server.atomicBan(pubkey, time.Now()); err != nil {
+		err = fmt.Errorf("Error checking abandoned atomic swaps for PlaceAtomicOrder: %s", err)
+		return
+	}
+	if !bannedUntil.IsZero() {
+		err = fmt.Errorf("You've abandoned too many atomic swaps, you can place atomic swap orders again at %s", bannedUntil.Format(time.RFC3339))
+		return
+	}
+
+	return server.placeOrder(order, nil, nil)
+}
+
+// atomicBan returns when a user can place atomic swap orders again, which is zero if they can
+// now. A user is banned once they've abandoned policy.MaxAbandons atomic swaps within
+// policy.BanDuration, until the latest of them is policy.BanDuration old.
+func (server *OpencxServer) atomicBan(pubkey *koblitz.PublicKey, now time.Time) (bannedUntil time.Time, err error) {
+	var swaps []*match.AtomicSwap
+	if swaps, err = server.AtomicSwapStore.GetAtomicSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting atomic swaps for atomicBan: %s", err)
+		return
+	}
+
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+	abandons := 0
+	var latest time.Time
+	for _, swap := range swaps {
+		if swap.State != match.AtomicSwapAbandoned || swap.Abandoner != pkBytes {
+			continue
+		}
+		if now.Sub(swap.Updated) >= server.AtomicSwapPolicy.BanDuration {
+			continue
+		}
+		abandons++
+		if swap.Updated.After(latest) {
+			latest = swap.Updated
+		}
+	}
+
+	if abandons >= server.AtomicSwapPolicy.MaxAbandons {
+		bannedUntil = latest.Add(server.AtomicSwapPolicy.BanDuration)
+	}
+	return
+}
+
+// atomicSwapsForExecs creates and stores an atomic swap for each maker order filled by the
+// executions of the placed order, which initiates all of them. A maker order filled more than
+// once gets one atomic swap. dbLock should be held.
+func (server *OpencxServer) atomicSwapsForExecs(book match.LimitOrderbook, placed *match.LimitOrderIDPair, orderExecs []*match.OrderExecution) (err error) {
+	var fills []*execFill
+	if fills, err = fillsForExecs(book, placed, orderExecs, nil); err != nil {
+		err = fmt.Errorf("Error getting fills for atomicSwapsForExecs: %s", err)
+		return
+	}
+
+	for _, filled := range fills {
+		if filled.fill.OrderID == *placed.OrderID {
+			continue
+		}
+
+		var swap *match.AtomicSwap
+		if swap, err = match.NewAtomicSwap(*placed.OrderID, placed.Order.Pubkey, filled.fill, server.AtomicSwapPolicy, placed.Timestamp); err != nil {
+			err = fmt.Errorf("Error creating atomic swap for atomicSwapsForExecs: %s", err)
+			return
+		}
+		if err = server.AtomicSwapStore.AddAtomicSwap(swap); err != nil {
+			err = fmt.Errorf("Error storing atomic swap for atomicSwapsForExecs: %s", err)
+			return
+		}
+		logging.Infof("Created %s", swap)
+	}
+	return
+}
+
+// InitiateAtomicSwap sets the hash an atomic swap is locked to, for the initiator, who signed it.
+// It returns the atomic swap and the address the initiator locks their side in.
+func (server *OpencxServer) InitiateAtomicSwap(id [32]byte, rhash [32]byte, signature []byte) (swap *match.AtomicSwap, address string, err error) {
+	if server.AtomicSwapStore == nil {
+		err = fmt.Errorf("Atomic swap orders aren't supported")
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, match.AtomicInitiationSigHash(id, rhash)); err != nil {
+		err = fmt.Errorf("Error verifying signature for InitiateAtomicSwap: %s", err)
+		return
+	}
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+
+	server.atomicMtx.Lock()
+	defer server.atomicMtx.Unlock()
+
+	if swap, err = server.AtomicSwapStore.GetAtomicSwap(id); err != nil {
+		err = fmt.Errorf("Error getting atomic swap for InitiateAtomicSwap: %s", err)
+		return
+	}
+	if swap.Initiator.Pubkey != pkBytes {
+		err = fmt.Errorf("Only the initiator can initiate atomic swap %s", swap.IDString())
+		return
+	}
+
+	if err = swap.Initiate(rhash, server.AtomicSwapPolicy, time.Now()); err != nil {
+		return
+	}
+	if address, err = atomicSwapAddress(swap, match.AtomicInitiator); err != nil {
+		err = fmt.Errorf("Error getting address for InitiateAtomicSwap: %s", err)
+		return
+	}
+	if err = server.AtomicSwapStore.UpdateAtomicSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating atomic swap for InitiateAtomicSwap: %s", err)
+		return
+	}
+	logging.Infof("Initiator set hash for %s, locking in %s", swap, address)
+	return
+}
+
+// atomicSwapAddress returns the address of the HTLC for the side a role locks
+func atomicSwapAddress(swap *match.AtomicSwap, role match.AtomicSwapRole) (address string, err error) {
+	var coin *coinparam.Params
+	if coin, err = swap.Leg(role).Asset.CoinParamFromAsset(); err != nil {
+		return
+	}
+	var pkScript []byte
+	if pkScript, err = swap.PkScript(role); err != nil {
+		return
+	}
+	address, err = util.ScriptAddress(pkScript, coin)
+	return
+}
+
+// watchedAtomicSwaps gets the atomic swaps whose sides can still be funded or spent. Abandoned
+// atomic swaps are watched too, since what was locked in them still gets refunded or redeemed.
+// atomicMtx should be held.
+func (server *OpencxServer) watchedAtomicSwaps() (swaps []*match.AtomicSwap, err error) {
+	for _, state := range []match.AtomicSwapState{match.AtomicSwapMatched, match.AtomicSwapInitiated, match.AtomicSwapParticipated, match.AtomicSwapRedeemed, match.AtomicSwapAbandoned} {
+		var stateSwaps []*match.AtomicSwap
+		if stateSwaps, err = server.AtomicSwapStore.GetAtomicSwapsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s atomic swaps for watchedAtomicSwaps: %s", state, err)
+			return
+		}
+		swaps = append(swaps, stateSwaps...)
+	}
+	return
+}
+
+// ingestAtomicSwapTransactions records the transactions in a block that fund or spend either
+// side of an atomic swap, and moves the atomic swaps along. Users who abandon atomic swaps have
+// their atomic swap orders cancelled.
+func (server *OpencxServer) ingestAtomicSwapTransactions(txList []*wire.MsgTx, height uint64, coin *coinparam.Params) (err error) {
+	if server.AtomicSwapStore == nil {
+		return
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for ingestAtomicSwapTransactions: %s", err)
+		return
+	}
+
+	server.atomicMtx.Lock()
+	defer server.atomicMtx.Unlock()
+
+	var swaps []*match.AtomicSwap
+	if swaps, err = server.watchedAtomicSwaps(); err != nil {
+		err = fmt.Errorf("Error getting atomic swaps for ingestAtomicSwapTransactions: %s", err)
+		return
+	}
+
+	// one atomic swap that can't be updated shouldn't stop the others
+	var swapErrs []string
+	for _, swap := range swaps {
+		for _, role := range []match.AtomicSwapRole{match.AtomicInitiator, match.AtomicParticipant} {
+			if swap.Leg(role).Asset != asset {
+				continue
+			}
+			for _, tx := range txList {
+				if err = server.ingestAtomicSwapTransaction(swap, role, tx, height); err != nil {
+					swapErrs = append(swapErrs, fmt.Sprintf("%s: %s", swap.IDString(), err))
+					break
+				}
+			}
+		}
+	}
+	err = nil
+
+	if len(swapErrs) > 0 {
+		err = fmt.Errorf("Error ingesting atomic swap transactions for ingestAtomicSwapTransactions: %s", strings.Join(swapErrs, ", "))
+		return
+	}
+	return
+}
+
+// ingestAtomicSwapTransaction checks whether a transaction in a block at height funds or spends
+// the side of an atomic swap a role locks. atomicMtx should be held.
+func (server *OpencxServer) ingestAtomicSwapTransaction(swap *match.AtomicSwap, role match.AtomicSwapRole, tx *wire.MsgTx, height uint64) (err error) {
+	leg := swap.Leg(role)
+	txid := tx.TxHash().String()
+	now := time.Now()
+
+	// A side can only be funded once it's its turn, and the initiator's side once it's
+	// initiated, before that there's no hash or locktime to lock it to
+	canFund := leg.Outpoint == "" && leg.Locktime != 0 &&
+		((role == match.AtomicInitiator && swap.State == match.AtomicSwapMatched) || (role == match.AtomicParticipant && swap.State == match.AtomicSwapInitiated))
+	if canFund {
+		var index uint32
+		var found bool
+		if index, found, err = swap.FundingOutput(role, tx); err != nil || !found {
+			return
+		}
+		amount := uint64(tx.TxOut[index].Value)
+		if amount < leg.Amount {
+			logging.Warnf("%s side of %s funded with %d in %s, not %d, waiting for more", role, swap, amount, txid, leg.Amount)
+			return
+		}
+		leg.Outpoint = fmt.Sprintf("%s:%d", txid, index)
+		leg.FundAmount = amount
+		leg.FundHeight = height
+
+		if role == match.AtomicInitiator {
+			// the participant's side has to time out well before the initiator's
+			swap.Participant.Locktime = uint32(now.Add(server.AtomicSwapPolicy.Timeout).Unix())
+			swap.SetState(match.AtomicSwapInitiated, now.Add(server.AtomicSwapPolicy.LockWindow()), now)
+		} else {
+			// the initiator has to redeem before the participant can refund
+			swap.SetState(match.AtomicSwapParticipated, time.Unix(int64(swap.Participant.Locktime), 0), now)
+		}
+		if err = server.AtomicSwapStore.UpdateAtomicSwap(swap); err != nil {
+			err = fmt.Errorf("Error updating funded atomic swap: %s", err)
+			return
+		}
+		logging.Infof("%s side of %s locked in %s", role, swap, leg.Outpoint)
+		return
+	}
+
+	if leg.SpendTxid != "" {
+		return
+	}
+	var input int
+	var found bool
+	if input, found = swap.SpendingInput(role, tx); !found {
+		return
+	}
+	preimage, redeemed := swap.PreimageFromWitness(tx.TxIn[input].Witness)
+	leg.SpendTxid = txid
+	leg.SpendHeight = height
+	swap.Updated = now
+	if redeemed {
+		// the other side needs the preimage even if the atomic swap was abandoned
+		swap.Preimage = preimage
+	}
+
+	var abandoner [33]byte
+	switch {
+	case swap.State.Final():
+	case redeemed && role == match.AtomicParticipant:
+		// now the participant can redeem the initiator's side before it can be refunded
+		swap.SetState(match.AtomicSwapRedeemed, time.Unix(int64(swap.Initiator.Locktime), 0), now)
+	case redeemed:
+		swap.SetState(match.AtomicSwapCompleted, time.Time{}, now)
+	default:
+		swap.Abandon(fmt.Sprintf("the %s side was refunded", role), now)
+		abandoner = swap.Abandoner
+	}
+	if err = server.AtomicSwapStore.UpdateAtomicSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating spent atomic swap: %s", err)
+		return
+	}
+	logging.Infof("%s side of %s spent in %s", role, swap, txid)
+
+	if abandoner != ([33]byte{}) {
+		server.penalizeAtomicAbandoner(abandoner)
+	}
+	return
+}
+
+// updateAtomicSwapsAtTime abandons the atomic swaps whose deadline has passed, blaming whoever's
+// turn it was, and cancels their atomic swap orders. Locktimes are unix times, so this is done
+// whenever a block for any coin comes in.
+func (server *OpencxServer) updateAtomicSwapsAtTime(now time.Time) (err error) {
+	if server.AtomicSwapStore == nil {
+		return
+	}
+
+	server.atomicMtx.Lock()
+	defer server.atomicMtx.Unlock()
+
+	var swaps []*match.AtomicSwap
+	for _, state := range []match.AtomicSwapState{match.AtomicSwapMatched, match.AtomicSwapInitiated, match.AtomicSwapParticipated, match.AtomicSwapRedeemed} {
+		var stateSwaps []*match.AtomicSwap
+		if stateSwaps, err = server.AtomicSwapStore.GetAtomicSwapsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s atomic swaps for updateAtomicSwapsAtTime: %s", state, err)
+			return
+		}
+		swaps = append(swaps, stateSwaps...)
+	}
+
+	// one atomic swap that can't be updated shouldn't stop the others
+	var swapErrs []string
+	for _, swap := range swaps {
+		if now.Before(swap.Deadline) {
+			continue
+		}
+
+		var reason string
+		switch swap.State {
+		case match.AtomicSwapMatched:
+			reason = "the initiator didn't lock their side in time"
+		case match.AtomicSwapInitiated:
+			reason = "the participant didn't lock their side in time"
+		case match.AtomicSwapParticipated:
+			reason = "the initiator didn't redeem the participant's side in time"
+		default:
+			reason = "the participant didn't redeem the initiator's side in time"
+		}
+		swap.Abandon(reason, now)
+		if err = server.AtomicSwapStore.UpdateAtomicSwap(swap); err != nil {
+			swapErrs = append(swapErrs, fmt.Sprintf("%s: %s", swap.IDString(), err))
+			continue
+		}
+		logging.Infof("Abandoned %s", swap)
+		server.penalizeAtomicAbandoner(swap.Abandoner)
+	}
+	err = nil
+
+	if len(swapErrs) > 0 {
+		err = fmt.Errorf("Error abandoning atomic swaps for updateAtomicSwapsAtTime: %s", strings.Join(swapErrs, ", "))
+		return
+	}
+	return
+}
+
+// penalizeAtomicAbandoner cancels every order a user who abandoned an atomic swap has on atomic
+// swap pairs, so nobody else matches against them. Failing to cancel an order is logged, the
+// atomic swap is abandoned either way. atomicMtx should be held, dbLock should not be.
+func (server *OpencxServer) penalizeAtomicAbandoner(abandoner [33]byte) {
+	pubkey, err := koblitz.ParsePubKey(abandoner[:], koblitz.S256())
+	if err != nil {
+		logging.Errorf("Error parsing pubkey of atomic swap abandoner %x: %s", abandoner, err)
+		return
+	}
+
+	var orders []*match.LimitOrderIDPair
+	server.dbLock.Lock()
+	for pair := range server.AtomicPairs {
+		book, ok := server.Orderbooks[pair]
+		if !ok {
+			continue
+		}
+		var pairOrders map[float64][]*match.LimitOrderIDPair
+		if pairOrders, err = book.GetOrdersForPubkey(pubkey); err != nil {
+			logging.Errorf("Error getting %s orders of atomic swap abandoner %x: %s", pair.String(), abandoner, err)
+			continue
+		}
+		for _, priceOrders := range pairOrders {
+			orders = append(orders, priceOrders...)
+		}
+	}
+	server.dbLock.Unlock()
+
+	for _, order := range orders {
+		if err = server.CancelOrder(order); err != nil {
+			logging.Errorf("Error cancelling order %x of atomic swap abandoner %x: %s", order.OrderID[:], abandoner, err)
+			continue
+		}
+		logging.Infof("Cancelled order %x of atomic swap abandoner %x", order.OrderID[:], abandoner)
+	}
+	return
+}
+
+// SubmitAtomicSpend sends out a transaction a user signed that redeems or refunds a side of an
+// atomic swap, for users that don't have their own node. It returns the txid.
+func (server *OpencxServer) SubmitAtomicSpend(coin *coinparam.Params, signedTx []byte) (txid string, err error) {
+	if server.AtomicSwapStore == nil {
+		err = fmt.Errorf("Atomic swap orders aren't supported")
+		return
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for SubmitAtomicSpend: %s", err)
+		return
+	}
+
+	tx := wire.NewMsgTx()
+	if err = tx.Deserialize(bytes.NewReader(signedTx)); err != nil {
+		err = fmt.Errorf("Error deserializing spend for SubmitAtomicSpend: %s", err)
+		return
+	}
+
+	server.atomicMtx.Lock()
+	defer server.atomicMtx.Unlock()
+
+	var swaps []*match.AtomicSwap
+	if swaps, err = server.watchedAtomicSwaps(); err != nil {
+		err = fmt.Errorf("Error getting atomic swaps for SubmitAtomicSpend: %s", err)
+		return
+	}
+
+	// only spends of atomic swaps get sent, anything else would make us a relay
+	for _, swap := range swaps {
+		for _, role := range []match.AtomicSwapRole{match.AtomicInitiator, match.AtomicParticipant} {
+			if swap.Leg(role).Asset != asset {
+				continue
+			}
+			input, found := swap.SpendingInput(role, tx)
+			if !found {
+				continue
+			}
+			if err = swap.VerifySpend(role, tx, input); err != nil {
+				err = fmt.Errorf("Error verifying spend for SubmitAtomicSpend: %s", err)
+				return
+			}
+
+			// none of it is ours, so it's sent without being added to the wallet
+			server.walletMtx.Lock()
+			wallet, ok := server.WalletMap[coin]
+			server.walletMtx.Unlock()
+			if !ok {
+				err = fmt.Errorf("Could not find wallet for %s", coin.Name)
+				return
+			}
+			if err = wallet.DirectSendTx(tx); err != nil {
+				err = fmt.Errorf("Error sending spend for SubmitAtomicSpend: %s", err)
+				return
+			}
+			txid = tx.TxHash().String()
+			logging.Infof("Sent user's spend of %s side of %s in %s", role, swap, txid)
+			return
+		}
+	}
+	err = fmt.Errorf("Transaction %s doesn't spend a side of an atomic swap on %s", tx.TxHash().String(), coin.Name)
+	return
+}
+
+// GetAtomicSwaps gets every atomic swap a pubkey is on either side of
+func (server *OpencxServer) GetAtomicSwaps(pubkey *koblitz.PublicKey) (swaps []*match.AtomicSwap, err error) {
+	if server.AtomicSwapStore == nil {
+		err = fmt.Errorf("Atomic swap orders aren't supported")
+		return
+	}
+
+	if swaps, err = server.AtomicSwapStore.GetAtomicSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting atomic swaps for GetAtomicSwaps: %s", err)
+		return
+	}
+	return
+}
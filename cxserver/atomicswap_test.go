@@ -0,0 +1,111 @@
+package cxserver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestAtomicSwapOrders tests that matching orders on an atomic swap pair creates an atomic swap
+// the exchange follows on chain, and that a user who abandons it loses their orders and is banned
+func TestAtomicSwapOrders(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "atomicorders")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	server := createSwapServer(t, dataDir)
+
+	atomicStore, err := cxdbmemory.CreateAtomicSwapStore()
+	if err != nil {
+		t.Fatalf("create atomic swap store: %v", err)
+	}
+	policy := match.DefaultAtomicSwapPolicy()
+	policy.MaxAbandons = 1
+	if err = server.SetAtomicSwapStore(atomicStore, policy, []*match.Pair{&swapPair}); err != nil {
+		t.Fatalf("set atomic swap store: %v", err)
+	}
+
+	seller, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	buyer, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{2})
+
+	// neither user has a balance, atomic swap orders don't need one
+	if _, err = server.PlaceOrder(swapOrder(seller, match.Sell)); err == nil {
+		t.Errorf("Custodial order should be refused on an atomic swap pair")
+	}
+	if _, err = server.PlaceAtomicOrder(swapOrder(seller, match.Sell)); err != nil {
+		t.Fatalf("place atomic sell: %v", err)
+	}
+	if _, err = server.PlaceAtomicOrder(swapOrder(seller, match.Sell)); err != nil {
+		t.Fatalf("place second atomic sell: %v", err)
+	}
+	if _, err = server.PlaceAtomicOrder(swapOrder(buyer, match.Buy)); err != nil {
+		t.Fatalf("place atomic buy: %v", err)
+	}
+
+	swaps, err := server.GetAtomicSwaps(seller.PubKey())
+	if err != nil || len(swaps) != 1 {
+		t.Fatalf("Seller should have one atomic swap, got %d and %v", len(swaps), err)
+	}
+	swap := swaps[0]
+	var buyerPubkey [33]byte
+	copy(buyerPubkey[:], buyer.PubKey().SerializeCompressed())
+	if swap.Initiator.Pubkey != buyerPubkey || swap.State != match.AtomicSwapMatched {
+		t.Fatalf("The buyer took the order, so they should initiate, got %s", swap)
+	}
+	for _, asset := range []match.Asset{swapPair.AssetWant, swapPair.AssetHave} {
+		coin, _ := asset.CoinParamFromAsset()
+		if balance, err := server.GetBalance(seller.PubKey(), coin); err != nil || balance != 0 {
+			t.Errorf("Atomic swap shouldn't change the seller's %s balance, got %d and %v", asset, balance, err)
+		}
+	}
+
+	// only the initiator can set the hash
+	rhash := [32]byte{0x07}
+	sig, _ := koblitz.SignCompact(koblitz.S256(), seller, match.AtomicInitiationSigHash(swap.ID, rhash), false)
+	if _, _, err = server.InitiateAtomicSwap(swap.ID, rhash, sig); err == nil {
+		t.Errorf("The participant shouldn't be able to initiate")
+	}
+	sig, _ = koblitz.SignCompact(koblitz.S256(), buyer, match.AtomicInitiationSigHash(swap.ID, rhash), false)
+	if swap, _, err = server.InitiateAtomicSwap(swap.ID, rhash, sig); err != nil {
+		t.Fatalf("initiate atomic swap: %v", err)
+	}
+
+	pkScript, err := swap.PkScript(match.AtomicInitiator)
+	if err != nil {
+		t.Fatalf("initiator pkscript: %v", err)
+	}
+	fundTx := wire.NewMsgTx()
+	fundTx.AddTxOut(wire.NewTxOut(int64(swap.Initiator.Amount), pkScript))
+	coin, _ := swap.Initiator.Asset.CoinParamFromAsset()
+	if err = server.ingestAtomicSwapTransactions([]*wire.MsgTx{fundTx}, 101, coin); err != nil {
+		t.Fatalf("ingest funding: %v", err)
+	}
+	if swap, err = server.AtomicSwapStore.GetAtomicSwap(swap.ID); err != nil || swap.State != match.AtomicSwapInitiated || swap.Initiator.FundHeight != 101 {
+		t.Fatalf("Atomic swap should be initiated at height 101, got %s and %v", swap, err)
+	}
+
+	// the seller never locks their side
+	if err = server.updateAtomicSwapsAtTime(swap.Deadline); err != nil {
+		t.Fatalf("update atomic swaps: %v", err)
+	}
+	if swap, err = server.AtomicSwapStore.GetAtomicSwap(swap.ID); err != nil || swap.State != match.AtomicSwapAbandoned || swap.Abandoner != swap.Participant.Pubkey {
+		t.Fatalf("Atomic swap should be abandoned by the seller, got %s and %v", swap, err)
+	}
+	if orders, err := server.GetOrdersForPubkey(seller.PubKey()); err != nil || len(orders) != 0 {
+		t.Errorf("Seller's other order should be cancelled, got %d and %v", len(orders), err)
+	}
+	if _, err = server.PlaceAtomicOrder(swapOrder(seller, match.Sell)); err == nil {
+		t.Errorf("Seller should be banned from atomic swap orders")
+	}
+
+	// bans run out
+	if bannedUntil, err := server.atomicBan(seller.PubKey(), swap.Updated.Add(policy.BanDuration)); err != nil || !bannedUntil.IsZero() {
+		t.Errorf("Seller's ban should be over after the ban duration, got %s and %v", bannedUntil, err)
+	}
+}
@@ -0,0 +1,583 @@
+package cxserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wallit"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/chainutils/mockchain"
+	"github.com/mit-dci/opencx/cxdb/cxdbbolt"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/match"
+)
+
+var testCoin = &coinparam.RegressionNetParams
+
+// waitFor waits for cond to be true, the server ingests blocks on its own goroutine
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// createChainServer creates a server with in-memory stores and a wallet on a mock chain
+func createChainServer(t *testing.T, dataDir string) (server *OpencxServer, chain *mockchain.Chain, wallet *wallit.Wallit) {
+	t.Helper()
+	coins := []*coinparam.Params{testCoin}
+
+	setEngines, err := cxdbmemory.CreateSettlementEngineMap(coins)
+	if err != nil {
+		t.Fatalf("create settlement engines: %v", err)
+	}
+	depositStores, err := cxdbmemory.CreateDepositStoreMap(coins)
+	if err != nil {
+		t.Fatalf("create deposit stores: %v", err)
+	}
+	withdrawalStores, err := cxdbmemory.CreateWithdrawalStoreMap(coins)
+	if err != nil {
+		t.Fatalf("create withdrawal stores: %v", err)
+	}
+	setStores, err := cxdbbolt.CreateSettlementStoreMap(coins, dataDir)
+	if err != nil {
+		t.Fatalf("create settlement stores: %v", err)
+	}
+	historyStore, err := cxdbmemory.CreateHistoryStore()
+	if err != nil {
+		t.Fatalf("create history store: %v", err)
+	}
+
+	if server, err = InitServer(setEngines, nil, nil, depositStores, withdrawalStores, setStores, historyStore, dataDir); err != nil {
+		t.Fatalf("init server: %v", err)
+	}
+	var key [32]byte
+	key[0] = 1
+	if err = server.SetupSingleKey(&key, testCoin); err != nil {
+		t.Fatalf("setup key: %v", err)
+	}
+
+	chain = mockchain.NewChain(testCoin)
+	if wallet, err = mockchain.NewWallit(chain, server.PrivKeyMap[testCoin], dataDir); err != nil {
+		t.Fatalf("create wallit: %v", err)
+	}
+	server.AddWallet(wallet)
+	server.SetConfirmationPolicy(testCoin, &match.ConfirmationPolicy{Confirmations: 3})
+	return
+}
+
+// registerDepositor registers a user and returns their key and the script paying to their
+// deposit address
+func registerDepositor(t *testing.T, server *OpencxServer, seed byte) (priv *koblitz.PrivateKey, script []byte) {
+	t.Helper()
+	priv, _ = koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{seed})
+	if err := server.RegisterUser(priv.PubKey()); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	address, err := server.GetDepositAddress(priv.PubKey(), testCoin)
+	if err != nil {
+		t.Fatalf("get deposit address: %v", err)
+	}
+	if script, err = util.AddressScript(address, testCoin); err != nil {
+		t.Fatalf("deposit address script: %v", err)
+	}
+	return
+}
+
+// depositState returns the state of the user's only deposit, or "" if there isn't one
+func depositState(server *OpencxServer, pubkey *koblitz.PublicKey) match.DepositState {
+	deposits, err := server.GetDeposits(pubkey, testCoin)
+	if err != nil || len(deposits) != 1 {
+		return ""
+	}
+	return deposits[0].Status
+}
+
+func balanceIs(server *OpencxServer, pubkey *koblitz.PublicKey, amount uint64) func() bool {
+	return func() bool {
+		balance, err := server.GetBalance(pubkey, testCoin)
+		return err == nil && balance == amount
+	}
+}
+
+func TestMockChainDepositConfirmations(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, _ := createChainServer(t, dataDir)
+	priv, script := registerDepositor(t, server, 2)
+	pubkey := priv.PubKey()
+
+	if _, err = chain.MineBlock(mockchain.FundingTx(script, 100000000, 1)); err != nil {
+		t.Fatalf("mine deposit: %v", err)
+	}
+	waitFor(t, "pending deposit", func() bool { return depositState(server, pubkey) == match.DepositPending })
+
+	// deposits are credited once the chain is Confirmations blocks past the one they're in, so
+	// a deposit in block 1 isn't credited until block 4, when it has all 3 confirmations
+	if err = chain.MineBlocks(2); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "second confirmation", func() bool {
+		deposits, err := server.GetDeposits(pubkey, testCoin)
+		return err == nil && len(deposits) == 1 && deposits[0].Confirmations == 2
+	})
+	if balance, _ := server.GetBalance(pubkey, testCoin); balance != 0 {
+		t.Fatalf("deposit credited too early, balance %d", balance)
+	}
+
+	if err = chain.MineBlocks(1); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit", balanceIs(server, pubkey, 100000000))
+	deposits, err := server.GetDeposits(pubkey, testCoin)
+	if err != nil || len(deposits) != 1 {
+		t.Fatalf("get deposits: %v", err)
+	}
+	if deposits[0].Status != match.DepositCredited || deposits[0].Confirmations != deposits[0].ConfirmationsRequired {
+		t.Fatalf("deposit should be credited with %d confirmations, it's %s with %d", deposits[0].ConfirmationsRequired, deposits[0].Status, deposits[0].Confirmations)
+	}
+}
+
+func TestMockChainDepositReorg(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, _ := createChainServer(t, dataDir)
+	priv, script := registerDepositor(t, server, 3)
+	pubkey := priv.PubKey()
+
+	if err = chain.MineBlocks(1); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	if _, err = chain.MineBlock(mockchain.FundingTx(script, 50000000, 2)); err != nil {
+		t.Fatalf("mine deposit: %v", err)
+	}
+	waitFor(t, "pending deposit", func() bool { return depositState(server, pubkey) == match.DepositPending })
+
+	// take out the block with the deposit, it goes back in the mempool
+	if err = chain.Reorg(2); err != nil {
+		t.Fatalf("reorg: %v", err)
+	}
+	waitFor(t, "orphaned deposit", func() bool { return depositState(server, pubkey) == match.DepositOrphaned })
+	if len(chain.Mempool()) != 1 {
+		t.Fatalf("deposit should be back in the mempool, mempool has %d", len(chain.Mempool()))
+	}
+
+	// it's mined again in the next block, and credited 3 blocks after that
+	if err = chain.MineBlocks(4); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit", balanceIs(server, pubkey, 50000000))
+}
+
+func TestMockChainSpentDepositReorg(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, _ := createChainServer(t, dataDir)
+	priv, script := registerDepositor(t, server, 5)
+	pubkey := priv.PubKey()
+
+	if _, err = chain.MineBlock(mockchain.FundingTx(script, 100000000, 4)); err != nil {
+		t.Fatalf("mine deposit: %v", err)
+	}
+	if err = chain.MineBlocks(3); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit", balanceIs(server, pubkey, 100000000))
+
+	// spend most of it, then take out the block it was credited in
+	if err = server.CreditUser(pubkey, 60000000, testCoin); err != nil {
+		t.Fatalf("spend deposit: %v", err)
+	}
+	if err = chain.Reorg(4); err != nil {
+		t.Fatalf("reorg: %v", err)
+	}
+	waitFor(t, "pending deposit", func() bool { return depositState(server, pubkey) == match.DepositPending })
+	waitFor(t, "balance taken back", balanceIs(server, pubkey, 0))
+	debt, err := server.DepositStores[testCoin].GetDepositDebt(pubkey)
+	if err != nil {
+		t.Fatalf("get debt: %v", err)
+	}
+	if debt != 60000000 {
+		t.Fatalf("user should owe the 60000000 they spent, they owe %d", debt)
+	}
+
+	// when the deposit is credited again, what they owe comes out of it
+	if err = chain.MineBlocks(1); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit minus debt", balanceIs(server, pubkey, 40000000))
+	if debt, err = server.DepositStores[testCoin].GetDepositDebt(pubkey); err != nil {
+		t.Fatalf("get debt: %v", err)
+	}
+	if debt != 0 {
+		t.Fatalf("debt should be paid off, user still owes %d", debt)
+	}
+}
+
+func TestMockChainWithdrawal(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, wallet := createChainServer(t, dataDir)
+	priv, script := registerDepositor(t, server, 4)
+	pubkey := priv.PubKey()
+
+	if _, err = chain.MineBlock(mockchain.FundingTx(script, 100000000, 3)); err != nil {
+		t.Fatalf("mine deposit: %v", err)
+	}
+	if err = chain.MineBlocks(3); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit", balanceIs(server, pubkey, 100000000))
+	waitFor(t, "wallet sync", func() bool { return wallet.CurrentHeight() == chain.Height() })
+
+	address, err := util.EncodeSegWitAddress(testCoin.Bech32Prefix, 0, make([]byte, 20))
+	if err != nil {
+		t.Fatalf("encode address: %v", err)
+	}
+	signed := &match.Withdrawal{
+		Asset:   match.BTCReg,
+		Amount:  40000000,
+		Address: address,
+		Nonce:   1,
+		Domain:  server.GetWithdrawalDomain(),
+	}
+	signature, err := koblitz.SignCompact(koblitz.S256(), priv, signed.SigHash(), false)
+	if err != nil {
+		t.Fatalf("sign withdrawal: %v", err)
+	}
+	if _, err = server.RequestWithdrawal(signed, signature, testCoin); err != nil {
+		t.Fatalf("request withdrawal: %v", err)
+	}
+	if balance, _ := server.GetBalance(pubkey, testCoin); balance != 60000000 {
+		t.Fatalf("withdrawal should be held from the balance, balance is %d", balance)
+	}
+
+	txids, err := server.BatchWithdrawals(testCoin)
+	if err != nil {
+		t.Fatalf("batch withdrawals: %v", err)
+	}
+	mempool := chain.Mempool()
+	if len(txids) != 1 || len(mempool) != 1 || mempool[0].TxHash().String() != txids[0] {
+		t.Fatalf("withdrawal transaction wasn't broadcast, txids %v, mempool has %d", txids, len(mempool))
+	}
+	paid := false
+	for _, txOut := range mempool[0].TxOut {
+		if addr, err := util.ScriptAddress(txOut.PkScript, testCoin); err == nil && addr == address && txOut.Value == 40000000 {
+			paid = true
+		}
+	}
+	if !paid {
+		t.Fatalf("withdrawal transaction doesn't pay 40000000 to %s", address)
+	}
+
+	if err = chain.MineBlocks(1); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "confirmed withdrawal", func() bool {
+		withdrawals, err := server.GetWithdrawals(pubkey, testCoin)
+		return err == nil && len(withdrawals) == 1 && withdrawals[0].State == match.WithdrawalConfirmed
+	})
+	if balance, _ := server.GetBalance(pubkey, testCoin); balance != 60000000 {
+		t.Fatalf("balance should be 60000000 after the withdrawal confirms, it's %d", balance)
+	}
+}
+
+func TestMockChainSweepKeepsUnsentWithdrawals(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, wallet := createChainServer(t, dataDir)
+	priv, script := registerDepositor(t, server, 7)
+	pubkey := priv.PubKey()
+
+	coldAddress, err := util.EncodeSegWitAddress(testCoin.Bech32Prefix, 0, bytes.Repeat([]byte{0x01}, 20))
+	if err != nil {
+		t.Fatalf("encode cold address: %v", err)
+	}
+	coldStore, err := cxdbmemory.CreateColdStore(testCoin)
+	if err != nil {
+		t.Fatalf("create cold store: %v", err)
+	}
+	if err = server.SetColdWallet(testCoin, &match.ColdWalletPolicy{HotCeiling: 10000000, Destination: coldAddress}, coldStore); err != nil {
+		t.Fatalf("set cold wallet: %v", err)
+	}
+
+	if _, err = chain.MineBlock(mockchain.FundingTx(script, 100000000, 5)); err != nil {
+		t.Fatalf("mine deposit: %v", err)
+	}
+	if err = chain.MineBlocks(3); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit", balanceIs(server, pubkey, 100000000))
+	waitFor(t, "wallet sync", func() bool { return wallet.CurrentHeight() == chain.Height() })
+
+	address, err := util.EncodeSegWitAddress(testCoin.Bech32Prefix, 0, make([]byte, 20))
+	if err != nil {
+		t.Fatalf("encode address: %v", err)
+	}
+	signed := &match.Withdrawal{
+		Asset:   match.BTCReg,
+		Amount:  40000000,
+		Address: address,
+		Nonce:   1,
+		Domain:  server.GetWithdrawalDomain(),
+	}
+	signature, err := koblitz.SignCompact(koblitz.S256(), priv, signed.SigHash(), false)
+	if err != nil {
+		t.Fatalf("sign withdrawal: %v", err)
+	}
+	if _, err = server.RequestWithdrawal(signed, signature, testCoin); err != nil {
+		t.Fatalf("request withdrawal: %v", err)
+	}
+
+	// the hot wallet has 100000000, the ceiling is 10000000 and 40000000 hasn't been sent yet
+	txid, err := server.SweepToCold(testCoin)
+	if err != nil {
+		t.Fatalf("sweep to cold: %v", err)
+	}
+	mempool := chain.Mempool()
+	if len(mempool) != 1 || mempool[0].TxHash().String() != txid {
+		t.Fatalf("sweep transaction wasn't broadcast, txid %s, mempool has %d", txid, len(mempool))
+	}
+	var swept int64
+	for _, txOut := range mempool[0].TxOut {
+		if addr, err := util.ScriptAddress(txOut.PkScript, testCoin); err == nil && addr == coldAddress {
+			swept += txOut.Value
+		}
+	}
+	if swept != 50000000 {
+		t.Fatalf("sweep should leave the ceiling and the unsent withdrawal, it swept %d", swept)
+	}
+
+	if err = chain.MineBlocks(1); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "wallet sync", func() bool { return wallet.CurrentHeight() == chain.Height() })
+	if txids, err := server.BatchWithdrawals(testCoin); err != nil || len(txids) != 1 {
+		t.Fatalf("withdrawal should still be sendable after the sweep, txids %v: %v", txids, err)
+	}
+}
+
+func TestMockChainRefillSurvivesRestart(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, wallet := createChainServer(t, dataDir)
+
+	coldPriv, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Fatalf("create cold key: %v", err)
+	}
+	coldWIF, err := btcutil.NewWIF(coldPriv, testCoin, true)
+	if err != nil {
+		t.Fatalf("create cold wif: %v", err)
+	}
+	coldPKH := btcutil.Hash160(coldPriv.PubKey().SerializeCompressed())
+	coldAddress, err := util.EncodeSegWitAddress(testCoin.Bech32Prefix, 0, coldPKH)
+	if err != nil {
+		t.Fatalf("encode cold address: %v", err)
+	}
+	policy := &match.ColdWalletPolicy{HotCeiling: 10000000, Destination: coldAddress}
+	coldStore, err := cxdbbolt.CreateColdStore(testCoin, dataDir)
+	if err != nil {
+		t.Fatalf("create cold store: %v", err)
+	}
+	if err = server.SetColdWallet(testCoin, policy, coldStore); err != nil {
+		t.Fatalf("set cold wallet: %v", err)
+	}
+
+	if _, err = chain.MineBlock(mockchain.FundingTx(append([]byte{0x00, 0x14}, coldPKH...), 50000000, 8)); err != nil {
+		t.Fatalf("mine cold deposit: %v", err)
+	}
+	waitFor(t, "wallet sync", func() bool { return wallet.CurrentHeight() == chain.Height() })
+	waitFor(t, "cold utxo", func() bool {
+		utxos, err := coldStore.GetColdUtxos()
+		return err == nil && len(utxos) == 1
+	})
+
+	refill, err := server.CreateRefill(testCoin, 20000000)
+	if err != nil {
+		t.Fatalf("create refill: %v", err)
+	}
+
+	// the operator signs the refill while the exchange restarts with the same cold store
+	if err = coldStore.(*cxdbbolt.BoltColdStore).DestroyHandler(); err != nil {
+		t.Fatalf("close cold store: %v", err)
+	}
+	if coldStore, err = cxdbbolt.CreateColdStore(testCoin, dataDir); err != nil {
+		t.Fatalf("reopen cold store: %v", err)
+	}
+	defer coldStore.(*cxdbbolt.BoltColdStore).DestroyHandler()
+	if err = server.SetColdWallet(testCoin, policy, coldStore); err != nil {
+		t.Fatalf("set cold wallet after restart: %v", err)
+	}
+	if _, err = server.CreateRefill(testCoin, 20000000); err == nil {
+		t.Fatalf("cold outputs in a pending refill should not be used again after a restart")
+	}
+
+	if err = refill.Sign(coldWIF.String()); err != nil {
+		t.Fatalf("sign refill: %v", err)
+	}
+	txid, err := server.SubmitRefill(testCoin, refill.Tx)
+	if err != nil {
+		t.Fatalf("submit refill after restart: %v", err)
+	}
+	mempool := chain.Mempool()
+	if len(mempool) != 1 || mempool[0].TxHash().String() != txid {
+		t.Fatalf("refill wasn't broadcast, txid %s, mempool has %d", txid, len(mempool))
+	}
+	if pending, err := coldStore.GetRefills(); err != nil || len(pending) != 0 {
+		t.Fatalf("submitted refill should not be pending, %d pending: %v", len(pending), err)
+	}
+}
+
+// restartServer creates a server on the same stores and wallet as server, like the exchange
+// restarting. The wallet forgets which outputs are frozen, since it only keeps them in memory.
+func restartServer(t *testing.T, server *OpencxServer, wallet *wallit.Wallit) (restarted *OpencxServer) {
+	t.Helper()
+	var err error
+	if restarted, err = InitServer(server.SettlementEngines, nil, nil, server.DepositStores, server.WithdrawalStores, server.SettlementStores, server.HistoryStore, server.OpencxRoot); err != nil {
+		t.Fatalf("init restarted server: %v", err)
+	}
+	var key [32]byte
+	key[0] = 1
+	if err = restarted.SetupSingleKey(&key, testCoin); err != nil {
+		t.Fatalf("setup key after restart: %v", err)
+	}
+
+	wallet.FreezeMutex.Lock()
+	for op := range wallet.FreezeSet {
+		delete(wallet.FreezeSet, op)
+	}
+	wallet.FreezeMutex.Unlock()
+	restarted.AddWallet(wallet)
+	return
+}
+
+func TestMockChainBumpedWithdrawalSurvivesRestart(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, chain, wallet := createChainServer(t, dataDir)
+	priv, script := registerDepositor(t, server, 9)
+	pubkey := priv.PubKey()
+
+	if _, err = chain.MineBlock(mockchain.FundingTx(script, 100000000, 9)); err != nil {
+		t.Fatalf("mine deposit: %v", err)
+	}
+	if err = chain.MineBlocks(3); err != nil {
+		t.Fatalf("mine: %v", err)
+	}
+	waitFor(t, "credited deposit", balanceIs(server, pubkey, 100000000))
+	waitFor(t, "wallet sync", func() bool { return wallet.CurrentHeight() == chain.Height() })
+
+	address, err := util.EncodeSegWitAddress(testCoin.Bech32Prefix, 0, make([]byte, 20))
+	if err != nil {
+		t.Fatalf("encode address: %v", err)
+	}
+	signed := &match.Withdrawal{
+		Asset:   match.BTCReg,
+		Amount:  40000000,
+		Address: address,
+		Nonce:   1,
+		Domain:  server.GetWithdrawalDomain(),
+	}
+	signature, err := koblitz.SignCompact(koblitz.S256(), priv, signed.SigHash(), false)
+	if err != nil {
+		t.Fatalf("sign withdrawal: %v", err)
+	}
+	if _, err = server.RequestWithdrawal(signed, signature, testCoin); err != nil {
+		t.Fatalf("request withdrawal: %v", err)
+	}
+	if _, err = server.BatchWithdrawals(testCoin); err != nil {
+		t.Fatalf("batch withdrawals: %v", err)
+	}
+	mempool := chain.Mempool()
+	if len(mempool) != 1 {
+		t.Fatalf("withdrawal transaction wasn't broadcast, mempool has %d", len(mempool))
+	}
+	original := mempool[0]
+
+	// the transaction is stuck when the exchange restarts, it should still be bumped
+	server = restartServer(t, server, wallet)
+	if err = server.bumpStuckWithdrawals(uint64(chain.Height())+stuckWithdrawalBlocks, testCoin); err != nil {
+		t.Fatalf("bump after restart: %v", err)
+	}
+	mempool = chain.Mempool()
+	if len(mempool) != 1 || mempool[0].TxHash() == original.TxHash() {
+		t.Fatalf("withdrawal transaction wasn't replaced after a restart, mempool has %d", len(mempool))
+	}
+	replacement := mempool[0]
+	withdrawals, err := server.GetWithdrawals(pubkey, testCoin)
+	if err != nil || len(withdrawals) != 1 || withdrawals[0].Txid != replacement.TxHash().String() {
+		t.Fatalf("withdrawal should point to the replacement, got %v: %v", withdrawals, err)
+	}
+
+	// after another restart the replaced change can't be spent, and the original can still confirm
+	server = restartServer(t, server, wallet)
+	if !isFrozen(wallet, wire.OutPoint{Hash: original.TxHash(), Index: changeIndex(t, original, replacement)}) {
+		t.Fatalf("change of the replaced transaction should be frozen after a restart")
+	}
+	if err = server.confirmWithdrawals([]*wire.MsgTx{original}, testCoin); err != nil {
+		t.Fatalf("confirm original: %v", err)
+	}
+	withdrawals, err = server.GetWithdrawals(pubkey, testCoin)
+	if err != nil || len(withdrawals) != 1 || withdrawals[0].State != match.WithdrawalConfirmed || withdrawals[0].Txid != original.TxHash().String() {
+		t.Fatalf("withdrawal should be confirmed in the original transaction, got %v: %v", withdrawals, err)
+	}
+	if isFrozen(wallet, wire.OutPoint{Hash: original.TxHash(), Index: changeIndex(t, original, replacement)}) {
+		t.Fatalf("change of the confirmed transaction should not be frozen")
+	}
+	if batches, err := server.WithdrawalStores[testCoin].GetWithdrawalBatches(); err != nil || len(batches) != 0 {
+		t.Fatalf("confirmed batch should not be stored, %d stored: %v", len(batches), err)
+	}
+}
+
+// changeIndex returns the index of the change in a withdrawal transaction, the output that's
+// different in its replacement
+func changeIndex(t *testing.T, tx *wire.MsgTx, replacement *wire.MsgTx) uint32 {
+	t.Helper()
+	for i, txOut := range tx.TxOut {
+		if txOut.Value != replacement.TxOut[i].Value {
+			return uint32(i)
+		}
+	}
+	t.Fatalf("%s has no change", tx.TxHash())
+	return 0
+}
@@ -0,0 +1,590 @@
+package cxserver
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	// coldLookahead is how many children of a cold xpub's external chain we watch, sweeps go to
+	// each of them in turn
+	coldLookahead = 100
+	// refillInputSize is how many bytes we assume each cold input in a refill takes once it's
+	// signed, which is what a P2PKH input takes, so a refill never pays too little in fees
+	refillInputSize = 148
+	// refillBaseSize is how many bytes a refill takes without inputs, with both outputs
+	refillBaseSize = 10 + 2*34
+	// minColdChange is the smallest change a refill pays back to the cold wallet, anything less
+	// goes to fees
+	minColdChange = 1000
+)
+
+// coldWallet is a coin's cold wallet. The exchange only knows its address or xpub, so it can
+// watch it and send to it, but only the operator can spend from it.
+type coldWallet struct {
+	policy *match.ColdWalletPolicy
+	// store keeps the outputs paying to the cold wallet
+	store cxdb.ColdStore
+	// scripts are the scripts we watch for outputs to the cold wallet, and the child of the
+	// xpub each one pays to
+	scripts map[string]uint32
+	// sweepScripts are the scripts sweeps and refill change pay to, in turn
+	sweepScripts [][]byte
+	// nextSweep is the index of the next script in sweepScripts to pay to, it's protected by
+	// withdrawalMtx. It starts from 0 when the exchange restarts, which only means a cold address
+	// gets reused.
+	nextSweep int
+}
+
+// newColdWallet creates a cold wallet for a coin, working out which scripts pay to it from the
+// policy's destination
+func newColdWallet(coin *coinparam.Params, policy *match.ColdWalletPolicy, store cxdb.ColdStore) (cold *coldWallet, err error) {
+	cold = &coldWallet{
+		policy:  policy,
+		store:   store,
+		scripts: make(map[string]uint32),
+	}
+
+	var xpub *hdkeychain.ExtendedKey
+	if xpub, err = hdkeychain.NewKeyFromString(policy.Destination); err == nil {
+		if xpub.IsPrivate() {
+			err = fmt.Errorf("Cold wallet for %s has to be an xpub, the exchange shouldn't have its private key", coin.Name)
+			return
+		}
+
+		// xpub children are all p2wpkh
+		for i := uint32(0); i < coldLookahead; i++ {
+			var pubkey *koblitz.PublicKey
+			if pubkey, err = match.ColdChild(xpub, i); err != nil {
+				err = fmt.Errorf("Error deriving cold wallet key %d for %s: %s", i, coin.Name, err)
+				return
+			}
+			var pub [33]byte
+			copy(pub[:], pubkey.SerializeCompressed())
+			script := lnutil.DirectWPKHScript(pub)
+			cold.scripts[string(script)] = i
+			cold.sweepScripts = append(cold.sweepScripts, script)
+		}
+		return
+	}
+
+	var script []byte
+	if script, err = util.AddressScript(policy.Destination, coin); err != nil {
+		err = fmt.Errorf("Cold wallet for %s should be an xpub or address: %s", coin.Name, err)
+		return
+	}
+	// refills to anything else can't be signed
+	if scriptType, _ := util.ScriptType(script); scriptType != "P2PKH" && scriptType != "P2WPKH" {
+		err = fmt.Errorf("Cold wallet address for %s has to be P2PKH or P2WPKH, not %s", coin.Name, scriptType)
+		return
+	}
+	cold.scripts[string(script)] = 0
+	cold.sweepScripts = [][]byte{script}
+	return
+}
+
+// sweepScript returns the next script to pay to the cold wallet
+func (cold *coldWallet) sweepScript() (script []byte) {
+	script = cold.sweepScripts[cold.nextSweep%len(cold.sweepScripts)]
+	cold.nextSweep++
+	return
+}
+
+// SetColdWallet sets the hot wallet ceiling and cold wallet for a coin, along with the store for
+// the cold wallet's outputs. dbLock should not be held.
+func (server *OpencxServer) SetColdWallet(coin *coinparam.Params, policy *match.ColdWalletPolicy, store cxdb.ColdStore) (err error) {
+	var cold *coldWallet
+	if cold, err = newColdWallet(coin, policy, store); err != nil {
+		err = fmt.Errorf("Error creating cold wallet for SetColdWallet: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	server.coldWallets[coin] = cold
+	server.dbLock.Unlock()
+	return
+}
+
+// coldWallet gets the cold wallet for a coin. dbLock should not be held.
+func (server *OpencxServer) coldWallet(coin *coinparam.Params) (cold *coldWallet, err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+	var ok bool
+	if cold, ok = server.coldWallets[coin]; !ok {
+		err = fmt.Errorf("No cold wallet for %s", coin.Name)
+		return
+	}
+	return
+}
+
+// ingestColdTransactions records outputs paying to the cold wallet in a block, and cold outputs
+// the block spends
+func (server *OpencxServer) ingestColdTransactions(txList []*wire.MsgTx, height uint64, coinType *coinparam.Params) (err error) {
+	server.dbLock.Lock()
+	cold, ok := server.coldWallets[coinType]
+	server.dbLock.Unlock()
+	// coins without a cold wallet have nothing to watch
+	if !ok {
+		return
+	}
+
+	var newUtxos []*match.ColdUtxo
+	for _, tx := range txList {
+		txid := tx.TxHash().String()
+		for i, output := range tx.TxOut {
+			keyIndex, found := cold.scripts[string(output.PkScript)]
+			if !found {
+				continue
+			}
+			newUtxos = append(newUtxos, &match.ColdUtxo{
+				Txid:     txid,
+				Index:    uint32(i),
+				Amount:   uint64(output.Value),
+				PkScript: output.PkScript,
+				KeyIndex: keyIndex,
+				Height:   height,
+			})
+			logging.Infof("Received %d %s in cold wallet", output.Value, coinType.Name)
+		}
+	}
+	if len(newUtxos) != 0 {
+		if err = cold.store.AddColdUtxos(newUtxos); err != nil {
+			err = fmt.Errorf("Error adding cold utxos for ingestColdTransactions: %s", err)
+			return
+		}
+	}
+
+	// only look for spends of outputs we know about, rather than storing every input in the block
+	var unspent []*match.ColdUtxo
+	if unspent, err = cold.store.GetColdUtxos(); err != nil {
+		err = fmt.Errorf("Error getting cold utxos for ingestColdTransactions: %s", err)
+		return
+	}
+	if len(unspent) == 0 {
+		return
+	}
+	coldOutpoints := make(map[string]bool)
+	for _, utxo := range unspent {
+		coldOutpoints[utxo.OutPoint()] = true
+	}
+
+	var spent []string
+	for _, tx := range txList {
+		for _, input := range tx.TxIn {
+			outpoint := fmt.Sprintf("%s:%d", input.PreviousOutPoint.Hash.String(), input.PreviousOutPoint.Index)
+			if coldOutpoints[outpoint] {
+				spent = append(spent, outpoint)
+			}
+		}
+	}
+	if len(spent) != 0 {
+		if err = cold.store.SpendColdUtxos(spent, height); err != nil {
+			err = fmt.Errorf("Error spending cold utxos for ingestColdTransactions: %s", err)
+			return
+		}
+		logging.Infof("Spent %d %s cold outputs at height %d", len(spent), coinType.Name, height)
+	}
+	return
+}
+
+// disconnectColdAboveHeight rolls the cold wallet back after every block above height was
+// disconnected by a reorg
+func (server *OpencxServer) disconnectColdAboveHeight(height uint64, coinType *coinparam.Params) (err error) {
+	server.dbLock.Lock()
+	cold, ok := server.coldWallets[coinType]
+	server.dbLock.Unlock()
+	if !ok {
+		return
+	}
+
+	if err = cold.store.DisconnectBlocks(height); err != nil {
+		err = fmt.Errorf("Error disconnecting cold blocks for disconnectColdAboveHeight: %s", err)
+		return
+	}
+	return
+}
+
+// hotBalance is how much the exchange's wallet for a coin holds. Frozen outputs, like the change
+// of replaced withdrawal transactions, don't count.
+func (server *OpencxServer) hotBalance(coin *coinparam.Params) (balance uint64, err error) {
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s", coin.Name)
+		return
+	}
+
+	var utxos []*portxo.PorTxo
+	if utxos, err = wallet.GetAllUtxos(); err != nil {
+		err = fmt.Errorf("Error getting %s wallet utxos: %s", coin.Name, err)
+		return
+	}
+	for _, utxo := range utxos {
+		if isFrozen(wallet, utxo.Op) {
+			continue
+		}
+		balance += uint64(utxo.Value)
+	}
+	return
+}
+
+// GetWalletBalances gets how much the exchange holds in its hot and cold wallets for a coin. The
+// cold balance is 0 if the coin doesn't have a cold wallet.
+func (server *OpencxServer) GetWalletBalances(coin *coinparam.Params) (balances *match.WalletBalances, err error) {
+	balances = new(match.WalletBalances)
+	if balances.Asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for GetWalletBalances: %s", err)
+		return
+	}
+
+	if balances.Hot, err = server.hotBalance(coin); err != nil {
+		err = fmt.Errorf("Error getting hot balance for GetWalletBalances: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	cold, ok := server.coldWallets[coin]
+	server.dbLock.Unlock()
+	if !ok {
+		return
+	}
+	balances.HotCeiling = cold.policy.HotCeiling
+	balances.Destination = cold.policy.Destination
+
+	var utxos []*match.ColdUtxo
+	if utxos, err = cold.store.GetColdUtxos(); err != nil {
+		err = fmt.Errorf("Error getting cold utxos for GetWalletBalances: %s", err)
+		return
+	}
+	for _, utxo := range utxos {
+		balances.Cold += utxo.Amount
+	}
+	return
+}
+
+// SweepToCold sends everything in a coin's hot wallet above the hot ceiling to the cold wallet,
+// and returns the txid. Withdrawals that haven't been sent yet are kept on top of the ceiling, so
+// sweeping never leaves the hot wallet short of what it owes. The txid is empty if there's nothing
+// to sweep.
+func (server *OpencxServer) SweepToCold(coin *coinparam.Params) (txid string, err error) {
+	// the hot wallet is spent from while this is held
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var cold *coldWallet
+	if cold, err = server.coldWallet(coin); err != nil {
+		err = fmt.Errorf("Error getting cold wallet for SweepToCold: %s", err)
+		return
+	}
+
+	var hot uint64
+	if hot, err = server.hotBalance(coin); err != nil {
+		err = fmt.Errorf("Error getting hot balance for SweepToCold: %s", err)
+		return
+	}
+
+	var owed uint64
+	if owed, err = server.unsentWithdrawals(coin); err != nil {
+		err = fmt.Errorf("Error getting unsent withdrawals for SweepToCold: %s", err)
+		return
+	}
+	keep := cold.policy.HotCeiling + owed
+	if hot <= keep {
+		return
+	}
+
+	server.walletMtx.Lock()
+	wallet := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+
+	sweepOut := wire.NewTxOut(int64(hot-keep), cold.sweepScript())
+
+	// sweeps can wait, so they pay the low fee rate
+	var feeRate int64
+	if feeRate, err = server.feeRate(coin, match.FeePriorityLow); err != nil {
+		err = fmt.Errorf("Error getting fee rate for SweepToCold: %s", err)
+		return
+	}
+
+	// the fees come out of what stays in the hot wallet
+	var utxoSlice portxo.TxoSliceByBip69
+	var overshoot int64
+	if utxoSlice, overshoot, err = wallet.PickUtxos(sweepOut.Value, int64(sweepOut.SerializeSize()), feeRate, false); err != nil {
+		err = fmt.Errorf("Error picking utxos to sweep %d for SweepToCold: %s", sweepOut.Value, err)
+		return
+	}
+
+	var changeOut *wire.TxOut
+	if changeOut, err = wallet.NewChangeOut(overshoot); err != nil {
+		err = fmt.Errorf("Error creating change output for SweepToCold: %s", err)
+		return
+	}
+
+	var sweepTx *wire.MsgTx
+	if sweepTx, err = wallet.BuildAndSign(utxoSlice, []*wire.TxOut{sweepOut, changeOut}, 0); err != nil {
+		err = fmt.Errorf("Error building sweep transaction for SweepToCold: %s", err)
+		return
+	}
+
+	if err = wallet.NewOutgoingTx(sweepTx); err != nil {
+		err = fmt.Errorf("Error sending sweep transaction for SweepToCold: %s", err)
+		return
+	}
+	txid = sweepTx.TxHash().String()
+	logging.Infof("Swept %d %s to cold wallet in %s", sweepOut.Value, coin.Name, txid)
+	return
+}
+
+// unsentWithdrawals is how much the hot wallet owes for on-chain withdrawals that are waiting for
+// approval or for the next batch. withdrawalMtx should be held.
+func (server *OpencxServer) unsentWithdrawals(coin *coinparam.Params) (owed uint64, err error) {
+	var store cxdb.WithdrawalStore
+	if store, err = server.withdrawalStore(coin); err != nil {
+		// nothing is owed if we don't take withdrawals for this coin
+		err = nil
+		return
+	}
+
+	for _, state := range []match.WithdrawalState{match.WithdrawalRequested, match.WithdrawalApproved} {
+		var withdrawals []*match.WithdrawalRequest
+		if withdrawals, err = store.GetWithdrawalsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s withdrawals for unsentWithdrawals: %s", state, err)
+			return
+		}
+		for _, withdrawal := range withdrawals {
+			if !withdrawal.Lightning {
+				owed += withdrawal.Amount
+			}
+		}
+	}
+	return
+}
+
+// StartColdSweeper sweeps the hot wallet of every coin with a cold wallet down to its ceiling once
+// every interval, forever.
+func (server *OpencxServer) StartColdSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			server.dbLock.Lock()
+			var coins []*coinparam.Params
+			for coin := range server.coldWallets {
+				coins = append(coins, coin)
+			}
+			server.dbLock.Unlock()
+
+			for _, coin := range coins {
+				if _, err := server.SweepToCold(coin); err != nil {
+					logging.Errorf("Error sweeping %s to cold wallet: %s", coin.Name, err)
+				}
+			}
+		}
+	}()
+	return
+}
+
+// unsignedTxid returns the txid a transaction had before its inputs were signed
+func unsignedTxid(tx *wire.MsgTx) (txid string) {
+	unsigned := tx.Copy()
+	for _, txIn := range unsigned.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+	}
+	txid = unsigned.TxHash().String()
+	return
+}
+
+// CreateRefill creates an unsigned transaction moving amount from a coin's cold wallet to its hot
+// wallet, for the operator to sign with the cold keys. Cold outputs are spent largest first, and
+// the change goes back to the cold wallet. The refill is kept in the cold store until it's
+// submitted, and cold outputs in a refill that hasn't been submitted aren't used for another one.
+func (server *OpencxServer) CreateRefill(coin *coinparam.Params, amount uint64) (refill *match.RefillTx, err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var cold *coldWallet
+	if cold, err = server.coldWallet(coin); err != nil {
+		err = fmt.Errorf("Error getting cold wallet for CreateRefill: %s", err)
+		return
+	}
+
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s for CreateRefill", coin.Name)
+		return
+	}
+
+	var utxos []*match.ColdUtxo
+	if utxos, err = cold.store.GetColdUtxos(); err != nil {
+		err = fmt.Errorf("Error getting cold utxos for CreateRefill: %s", err)
+		return
+	}
+	var pendingRefills []*match.RefillTx
+	if pendingRefills, err = cold.store.GetRefills(); err != nil {
+		err = fmt.Errorf("Error getting pending refills for CreateRefill: %s", err)
+		return
+	}
+	reserved := make(map[string]bool)
+	for _, pending := range pendingRefills {
+		for _, input := range pending.Inputs {
+			reserved[input.OutPoint()] = true
+		}
+	}
+	sort.SliceStable(utxos, func(i, j int) bool {
+		return utxos[i].Amount > utxos[j].Amount
+	})
+
+	refill = &match.RefillTx{}
+	if refill.Asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for CreateRefill: %s", err)
+		return
+	}
+
+	var feeRate int64
+	if feeRate, err = server.feeRate(coin, match.FeePriorityNormal); err != nil {
+		err = fmt.Errorf("Error getting fee rate for CreateRefill: %s", err)
+		return
+	}
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	var total, fee uint64
+	for _, utxo := range utxos {
+		if reserved[utxo.OutPoint()] {
+			continue
+		}
+		var prevHash *chainhash.Hash
+		if prevHash, err = chainhash.NewHashFromStr(utxo.Txid); err != nil {
+			err = fmt.Errorf("Error parsing cold utxo txid for CreateRefill: %s", err)
+			return
+		}
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevHash, utxo.Index), nil, nil))
+		refill.Inputs = append(refill.Inputs, utxo)
+		total += utxo.Amount
+		fee = uint64(feeRate) * uint64(refillBaseSize+refillInputSize*len(refill.Inputs))
+		if total >= amount+fee {
+			break
+		}
+	}
+	if total < amount+fee {
+		err = fmt.Errorf("Cold wallet only has %d %s to refill %d plus %d in fees", total, coin.Name, amount, fee)
+		return
+	}
+
+	var hotOut *wire.TxOut
+	if hotOut, err = wallet.NewChangeOut(int64(amount)); err != nil {
+		err = fmt.Errorf("Error creating hot wallet output for CreateRefill: %s", err)
+		return
+	}
+	tx.AddTxOut(hotOut)
+	if change := total - amount - fee; change >= minColdChange {
+		tx.AddTxOut(wire.NewTxOut(int64(change), cold.sweepScript()))
+	}
+
+	var buf bytes.Buffer
+	if err = tx.Serialize(&buf); err != nil {
+		err = fmt.Errorf("Error serializing refill for CreateRefill: %s", err)
+		return
+	}
+	refill.Tx = buf.Bytes()
+
+	// the refill is stored so it can still be submitted once it's signed if the exchange restarts
+	if err = cold.store.AddRefill(tx.TxHash().String(), refill); err != nil {
+		refill = nil
+		err = fmt.Errorf("Error storing refill for CreateRefill: %s", err)
+		return
+	}
+	logging.Infof("Created refill of %d %s from %d cold outputs, waiting for it to be signed", amount, coin.Name, len(refill.Inputs))
+	return
+}
+
+// SubmitRefill takes a refill the operator signed, makes sure it's a refill the exchange created
+// and that it's signed properly, then sends it out and returns the txid.
+func (server *OpencxServer) SubmitRefill(coin *coinparam.Params, signedTx []byte) (txid string, err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	tx := wire.NewMsgTx()
+	if err = tx.Deserialize(bytes.NewReader(signedTx)); err != nil {
+		err = fmt.Errorf("Error deserializing signed refill for SubmitRefill: %s", err)
+		return
+	}
+
+	// the unsigned txid commits to everything but the signatures, so if it's the same as a
+	// refill we created then the operator only added signatures
+	var cold *coldWallet
+	if cold, err = server.coldWallet(coin); err != nil {
+		err = fmt.Errorf("Error getting cold wallet for SubmitRefill: %s", err)
+		return
+	}
+
+	unsigned := unsignedTxid(tx)
+	var pending *match.RefillTx
+	if pending, err = cold.store.GetRefill(unsigned); err != nil {
+		err = fmt.Errorf("Error getting refill for SubmitRefill: %s", err)
+		return
+	}
+	if pending == nil {
+		err = fmt.Errorf("Signed refill %s isn't a refill the exchange created", unsigned)
+		return
+	}
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for SubmitRefill: %s", err)
+		return
+	}
+	if pending.Asset != asset {
+		err = fmt.Errorf("Refill %s is for %s, not %s", unsigned, pending.Asset, asset)
+		return
+	}
+
+	signed := &match.RefillTx{
+		Asset:  pending.Asset,
+		Tx:     signedTx,
+		Inputs: pending.Inputs,
+	}
+	if err = signed.Verify(); err != nil {
+		err = fmt.Errorf("Error verifying signed refill for SubmitRefill: %s", err)
+		return
+	}
+
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s for SubmitRefill", coin.Name)
+		return
+	}
+
+	// the hot wallet sees the output paying it straight away
+	if err = wallet.NewOutgoingTx(tx); err != nil {
+		err = fmt.Errorf("Error sending refill for SubmitRefill: %s", err)
+		return
+	}
+	txid = tx.TxHash().String()
+	if err = cold.store.RemoveRefill(unsigned); err != nil {
+		// the refill is already sent, it just stays reserved until it's removed
+		logging.Errorf("Error removing refill %s after sending it in %s: %s", unsigned, txid, err)
+		err = nil
+	}
+	logging.Infof("Sent %s refill from cold wallet in %s", coin.Name, txid)
+	return
+}
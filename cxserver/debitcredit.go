@@ -6,6 +6,7 @@ import (
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/match"
 )
 
@@ -13,6 +14,11 @@ import (
 // all of the required data stores.
 // DebitUser acquires dbLock so it can just be called.
 func (server *OpencxServer) DebitUser(pubkey *koblitz.PublicKey, amount uint64, param *coinparam.Params) (err error) {
+	return server.debitUser(pubkey, amount, param, cxevent.DepositEvent)
+}
+
+// debitUser is DebitUser, but the debit is recorded in the event log as eventType
+func (server *OpencxServer) debitUser(pubkey *koblitz.PublicKey, amount uint64, param *coinparam.Params, eventType cxevent.EventType) (err error) {
 
 	var assetToDebit match.Asset
 	if assetToDebit, err = match.AssetFromCoinParam(param); err != nil {
@@ -56,6 +62,11 @@ func (server *OpencxServer) DebitUser(pubkey *koblitz.PublicKey, amount uint64,
 	var setRes *match.SettlementResult
 	var settlementResults []*match.SettlementResult
 	if valid {
+		if err = server.recordSettlementEvent(eventType, setExecForPush); err != nil {
+			err = fmt.Errorf("Error recording event for DebitUser: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
 		if setRes, err = currSettleEngine.ApplySettlementExecution(setExecForPush); err != nil {
 			err = fmt.Errorf("Error applying settlement exec for DebitUser: %s", err)
 			server.dbLock.Unlock()
@@ -126,6 +137,11 @@ func (server *OpencxServer) CreditUser(pubkey *koblitz.PublicKey, amount uint64,
 	var setRes *match.SettlementResult
 	var settlementResults []*match.SettlementResult
 	if valid {
+		if err = server.recordSettlementEvent(cxevent.WithdrawalEvent, setExecForPush); err != nil {
+			err = fmt.Errorf("Error recording event for CreditUser: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
 		if setRes, err = currSettleEngine.ApplySettlementExecution(setExecForPush); err != nil {
 			err = fmt.Errorf("Error applying settlement exec for CreditUser: %s", err)
 			server.dbLock.Unlock()
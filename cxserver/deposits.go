@@ -6,6 +6,7 @@ import (
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
 )
 
 // RegisterUser gives the user a balance of 0, and gives them deposit addresses. This acquires locks
@@ -74,3 +75,37 @@ func (server *OpencxServer) GetDepositAddress(pubkey *koblitz.PublicKey, coin *c
 
 	return
 }
+
+// SetConfirmationPolicy sets how many confirmations deposits of a coin need. It only applies to
+// deposits that come in after it's set. dbLock should not be held.
+func (server *OpencxServer) SetConfirmationPolicy(coin *coinparam.Params, policy *match.ConfirmationPolicy) {
+	server.dbLock.Lock()
+	server.ConfirmationPolicies[coin] = policy
+	server.dbLock.Unlock()
+	return
+}
+
+// GetDeposits gets every deposit a pubkey has made for a coin, with how many confirmations each
+// one has so far
+func (server *OpencxServer) GetDeposits(pubkey *koblitz.PublicKey, coin *coinparam.Params) (deposits []*match.DepositStatus, err error) {
+
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	var currDepositStore cxdb.DepositStore
+	var ok bool
+	if currDepositStore, ok = server.DepositStores[coin]; !ok {
+		err = fmt.Errorf("Could not find DepositStore for %s for GetDeposits", coin.Name)
+		return
+	}
+
+	if deposits, err = currDepositStore.GetDeposits(pubkey); err != nil {
+		err = fmt.Errorf("Error getting deposits from store for GetDeposits: %s", err)
+		return
+	}
+
+	for _, deposit := range deposits {
+		deposit.SetConfirmations(server.chainHeights[coin])
+	}
+	return
+}
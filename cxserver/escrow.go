@@ -0,0 +1,577 @@
+package cxserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PlaceChannelOrder creates the escrow for a channel order. The order is committed to the user's
+// channels rather than their balance: it goes on the book once the user has locked what it has
+// in HTLCs to the escrow's hash, and it's settled by releasing those HTLCs. The user needs to be
+// able to send what the order has, and we need to be able to send them what it wants and refund
+// what they lock. The returned escrow doesn't have the preimage.
+func (server *OpencxServer) PlaceChannelOrder(order *match.LimitOrder) (escrow *match.OrderEscrow, err error) {
+	if server.ExchangeNode == nil || server.SwapStore == nil {
+		err = fmt.Errorf("Channel orders aren't supported, lightning isn't set up")
+		return
+	}
+	if server.AtomicPairs[order.TradingPair] {
+		err = fmt.Errorf("Orders on %s are settled by atomic swap, place an atomic swap order instead", order.TradingPair.String())
+		return
+	}
+	if err = checkOrderAmounts(order); err != nil {
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(order.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for PlaceChannelOrder: %s", err)
+		return
+	}
+
+	have, want := order.TradingPair.HaveWantAssets(order.Side)
+	var haveCoin, wantCoin *coinparam.Params
+	if haveCoin, err = have.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin the order has for PlaceChannelOrder: %s", err)
+		return
+	}
+	if wantCoin, err = want.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin the order wants for PlaceChannelOrder: %s", err)
+		return
+	}
+
+	// the capacity is checked against what's already reserved once the lock is held
+	var haveLiquidity, wantLiquidity *match.Liquidity
+	if haveLiquidity, err = server.channelLiquidity(pubkey, haveCoin); err != nil {
+		err = fmt.Errorf("Error getting your %s channels for PlaceChannelOrder: %s", haveCoin.Name, err)
+		return
+	}
+	if wantLiquidity, err = server.channelLiquidity(pubkey, wantCoin); err != nil {
+		err = fmt.Errorf("Error getting your %s channels for PlaceChannelOrder: %s", wantCoin.Name, err)
+		return
+	}
+
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	var book match.LimitOrderbook
+	var ok bool
+	if book, ok = server.Orderbooks[order.TradingPair]; !ok {
+		err = fmt.Errorf("Could not find orderbooks for trading pair for PlaceChannelOrder")
+		return
+	}
+
+	// HTLC locktimes are heights, so we have to know where both chains are
+	haveHeight, wantHeight := server.chainHeights[haveCoin], server.chainHeights[wantCoin]
+	if haveHeight == 0 || wantHeight == 0 {
+		err = fmt.Errorf("Can't place channel orders on %s yet, still syncing", order.TradingPair.String())
+		return
+	}
+
+	haveLiquidity.ReservedOutbound, haveLiquidity.ReservedInbound = server.liquidity.Reserved(haveLiquidity.Pubkey, haveLiquidity.Asset)
+	wantLiquidity.ReservedOutbound, wantLiquidity.ReservedInbound = server.liquidity.Reserved(wantLiquidity.Pubkey, wantLiquidity.Asset)
+	if err = haveLiquidity.CheckInbound(order.AmountHave); err != nil {
+		err = fmt.Errorf("Error placing channel order: %s", err)
+		return
+	}
+	if err = haveLiquidity.CheckOutbound(order.AmountHave); err != nil {
+		err = fmt.Errorf("Error placing channel order, what you lock couldn't be refunded: %s", err)
+		return
+	}
+	if err = wantLiquidity.CheckOutbound(order.AmountWant); err != nil {
+		err = fmt.Errorf("Error placing channel order: %s", err)
+		return
+	}
+
+	// Channel orders are swap orders, so they can't rest next to custodial orders
+	if err = server.checkOrderKind(book, order, true); err != nil {
+		err = fmt.Errorf("Error placing channel order: %s", err)
+		return
+	}
+
+	var created *match.OrderEscrow
+	if created, err = match.NewOrderEscrow(order, uint32(haveHeight), server.SwapPolicy, time.Now()); err != nil {
+		err = fmt.Errorf("Error creating escrow for PlaceChannelOrder: %s", err)
+		return
+	}
+	if err = server.SwapStore.AddEscrow(created); err != nil {
+		err = fmt.Errorf("Error storing escrow for PlaceChannelOrder: %s", err)
+		return
+	}
+	server.reserveEscrow(created)
+	logging.Infof("Created %s", created)
+
+	escrow = created.Public()
+	return
+}
+
+// startRelease decides what a committed escrow releases for a fill and moves it to releasing.
+// Nothing is offered until releaseEscrow is called. dbLock should be held.
+func (server *OpencxServer) startRelease(escrow *match.OrderEscrow, amountFilled uint64, amountReceive uint64, releaseTime time.Time) (err error) {
+	var lockCoin, receiveCoin *coinparam.Params
+	if lockCoin, err = escrow.LockAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin locked for startRelease: %s", err)
+		return
+	}
+	if receiveCoin, err = escrow.ReceiveAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin to receive for startRelease: %s", err)
+		return
+	}
+
+	if err = escrow.Release(amountFilled, amountReceive, uint32(server.chainHeights[receiveCoin]), uint32(server.chainHeights[lockCoin]), server.SwapPolicy, releaseTime); err != nil {
+		err = fmt.Errorf("Error releasing escrow for startRelease: %s", err)
+		return
+	}
+	if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+		err = fmt.Errorf("Error updating escrow for startRelease: %s", err)
+		return
+	}
+	if escrow.AmountReceive != 0 {
+		server.liquidity.RecordFlow(escrow.Pubkey, escrow.ReceiveAsset, escrow.AmountReceive)
+	}
+	logging.Infof("Releasing %s", escrow)
+	return
+}
+
+// escrowsForExecs starts releasing the escrow of each channel order that was filled by the
+// executions, and returns the pubkeys of the channel orders so their settlement executions can be
+// skipped. An escrow is released all at once, so channel orders that were only partly filled are
+// returned too, to be cancelled. dbLock should be held.
+func (server *OpencxServer) escrowsForExecs(book match.LimitOrderbook, placed *match.LimitOrderIDPair, orderExecs []*match.OrderExecution) (escrows []*match.OrderEscrow, escrowPubkeys map[[33]byte]bool, partlyFilled []*match.OrderID, err error) {
+	if server.SwapStore == nil {
+		return
+	}
+
+	hasEscrow := func(orderID *match.OrderID) (escrowed bool, err error) {
+		var escrow *match.OrderEscrow
+		if escrow, err = server.SwapStore.GetOrderEscrow(orderID); err != nil {
+			return
+		}
+		escrowed = escrow != nil
+		return
+	}
+
+	var fills []*execFill
+	if fills, err = fillsForExecs(book, placed, orderExecs, hasEscrow); err != nil {
+		err = fmt.Errorf("Error getting channel order fills for escrowsForExecs: %s", err)
+		return
+	}
+
+	escrowPubkeys = make(map[[33]byte]bool)
+	for _, filled := range fills {
+		fill, entry := filled.fill, filled.entry
+		escrowPubkeys[fill.Pubkey] = true
+
+		var escrow *match.OrderEscrow
+		if escrow, err = server.SwapStore.GetOrderEscrow(&entry.OrderID); err != nil {
+			err = fmt.Errorf("Error getting escrow for escrowsForExecs: %s", err)
+			return
+		}
+		if err = server.startRelease(escrow, fill.AmountHave, fill.AmountWant, placed.Timestamp); err != nil {
+			err = fmt.Errorf("Error starting release for escrowsForExecs: %s", err)
+			return
+		}
+		escrows = append(escrows, escrow)
+
+		if entry.Status != match.OrderFilled {
+			orderID := entry.OrderID
+			partlyFilled = append(partlyFilled, &orderID)
+		}
+	}
+	return
+}
+
+// releaseEscrows releases escrows whose orders were filled or cancelled. Escrows that fail stay
+// releasing, and are tried again when the next block comes in.
+func (server *OpencxServer) releaseEscrows(escrows []*match.OrderEscrow) {
+	server.swapMtx.Lock()
+	defer server.swapMtx.Unlock()
+
+	for _, escrow := range escrows {
+		// a block may have come in and moved the escrow along already
+		stored, err := server.SwapStore.GetEscrow(escrow.RHash)
+		if err != nil {
+			logging.Errorf("Error getting %s to release: %s", escrow, err)
+			continue
+		}
+		if stored.State != match.EscrowReleasing {
+			continue
+		}
+		if err = server.releaseEscrow(stored); err != nil {
+			logging.Errorf("Error releasing %s: %s", stored, err)
+		}
+	}
+	return
+}
+
+// releaseEscrow offers HTLCs for what a releasing escrow's order received and the rest of what
+// was locked, then claims the user's HTLCs, which reveals the preimage so the user can claim
+// ours. Ours are offered first so the user is never left without their side. swapMtx should be
+// held.
+func (server *OpencxServer) releaseEscrow(escrow *match.OrderEscrow) (err error) {
+	if server.ExchangeNode == nil {
+		err = fmt.Errorf("Can't release escrow %s, lightning isn't set up", escrow.ID())
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(escrow.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for releaseEscrow: %s", err)
+		return
+	}
+
+	var lockCoin, receiveCoin *coinparam.Params
+	if lockCoin, err = escrow.LockAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin locked for releaseEscrow: %s", err)
+		return
+	}
+	if receiveCoin, err = escrow.ReceiveAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin to receive for releaseEscrow: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	lockHeight := server.chainHeights[lockCoin]
+	server.dbLock.Unlock()
+
+	// Claiming reveals the preimage, after that the user has to have time to claim our side
+	// before we can take it back. If it's too late the user's HTLCs time out and go back to
+	// them, so there's nothing to offer.
+	if !escrow.CanClaim(uint32(lockHeight), server.SwapPolicy) {
+		return
+	}
+
+	if escrow.AmountReceive != 0 {
+		if err = server.offerHTLCs(pubkey, receiveCoin, escrow.RHash, escrow.AmountReceive, escrow.ReceiveLocktime); err != nil {
+			err = fmt.Errorf("Error offering what the order received for releaseEscrow: %s", err)
+			return
+		}
+	}
+	if escrow.AmountRefund != 0 {
+		if err = server.offerHTLCs(pubkey, lockCoin, escrow.RHash, escrow.AmountRefund, escrow.RefundLocktime); err != nil {
+			err = fmt.Errorf("Error offering refund for releaseEscrow: %s", err)
+			return
+		}
+	}
+	server.releaseEscrowLiquidity(escrow)
+
+	if _, err = server.ExchangeNode.ClaimHTLC(escrow.Preimage); err != nil {
+		err = fmt.Errorf("Error claiming HTLCs for releaseEscrow: %s", err)
+		return
+	}
+	escrow.SetState(match.EscrowClaimed, "", time.Now())
+	if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+		err = fmt.Errorf("Error updating claimed escrow for releaseEscrow: %s", err)
+		return
+	}
+	logging.Infof("Claimed %s", escrow)
+
+	return server.completeEscrow(escrow)
+}
+
+// completeEscrow completes a claimed escrow once the user has claimed every HTLC we sent to
+// release it. swapMtx should be held.
+func (server *OpencxServer) completeEscrow(escrow *match.OrderEscrow) (err error) {
+	var htlcs []match.SwapHTLC
+	if htlcs, err = server.hashHTLCs(escrow.RHash); err != nil {
+		err = fmt.Errorf("Error getting HTLCs for completeEscrow: %s", err)
+		return
+	}
+	if !escrow.SentClaimed(htlcs) {
+		return
+	}
+
+	escrow.SetState(match.EscrowCompleted, "", time.Now())
+	if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+		err = fmt.Errorf("Error updating completed escrow for completeEscrow: %s", err)
+		return
+	}
+	logging.Infof("Completed %s", escrow)
+	return
+}
+
+// updateEscrows updates the escrows for HTLC hashes, hashes that aren't for escrows are ignored
+func (server *OpencxServer) updateEscrows(hashes [][32]byte) {
+	if server.SwapStore == nil {
+		return
+	}
+
+	server.swapMtx.Lock()
+	defer server.swapMtx.Unlock()
+
+	seen := make(map[[32]byte]bool)
+	for _, rhash := range hashes {
+		if seen[rhash] {
+			continue
+		}
+		seen[rhash] = true
+
+		escrow, err := server.SwapStore.GetEscrow(rhash)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+
+		switch escrow.State {
+		case match.EscrowPending:
+			err = server.commitEscrow(escrow)
+		case match.EscrowClaimed:
+			err = server.completeEscrow(escrow)
+		}
+		if err != nil {
+			logging.Errorf("Error updating %s: %s", escrow, err)
+		}
+	}
+	return
+}
+
+// commitEscrow puts a pending escrow's order on the book once the user's HTLCs are all locked.
+// If the order can't be placed the escrow expires, and the user's HTLCs go back to them when
+// they time out. swapMtx should be held.
+func (server *OpencxServer) commitEscrow(escrow *match.OrderEscrow) (err error) {
+	var htlcs []match.SwapHTLC
+	if htlcs, err = server.hashHTLCs(escrow.RHash); err != nil {
+		err = fmt.Errorf("Error getting HTLCs for commitEscrow: %s", err)
+		return
+	}
+
+	var lockCoin *coinparam.Params
+	if lockCoin, err = escrow.LockAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin locked for commitEscrow: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	lockHeight := server.chainHeights[lockCoin]
+	server.dbLock.Unlock()
+
+	// the rest of the HTLCs may not be there yet
+	if escrow.CheckLocked(htlcs, uint32(lockHeight), server.SwapPolicy) != nil {
+		return
+	}
+
+	var placeErr error
+	if _, placeErr = server.placeOrder(&escrow.Order, nil, escrow); placeErr != nil {
+		escrow.SetState(match.EscrowExpired, fmt.Sprintf("the order could not be placed: %s", placeErr), time.Now())
+		if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+			err = fmt.Errorf("Error updating expired escrow for commitEscrow: %s", err)
+			return
+		}
+		server.releaseEscrowLiquidity(escrow)
+		logging.Infof("Expired %s", escrow)
+		return
+	}
+	logging.Infof("Committed %s", escrow)
+	return
+}
+
+// updateEscrowsAtHeight moves along escrows that lock or receive a coin when a block for that
+// coin comes in. Orders whose escrows are close to timing out come off the book, escrows that
+// couldn't be released are released again, and escrows whose HTLCs have timed out are refunded
+// or expired.
+func (server *OpencxServer) updateEscrowsAtHeight(height uint64, coin *coinparam.Params) (err error) {
+	if server.SwapStore == nil || server.ExchangeNode == nil {
+		return
+	}
+
+	server.swapMtx.Lock()
+	defer server.swapMtx.Unlock()
+
+	var escrows []*match.OrderEscrow
+	for _, state := range []match.EscrowState{match.EscrowPending, match.EscrowCommitted, match.EscrowReleasing, match.EscrowClaimed} {
+		var stateEscrows []*match.OrderEscrow
+		if stateEscrows, err = server.SwapStore.GetEscrowsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s escrows for updateEscrowsAtHeight: %s", state, err)
+			return
+		}
+		escrows = append(escrows, stateEscrows...)
+	}
+
+	// one escrow that can't be updated shouldn't stop the others
+	var escrowErrs []string
+	for _, escrow := range escrows {
+		var lockCoin, receiveCoin *coinparam.Params
+		if lockCoin, err = escrow.LockAsset.CoinParamFromAsset(); err != nil {
+			err = fmt.Errorf("Error getting coin locked for updateEscrowsAtHeight: %s", err)
+			return
+		}
+		if receiveCoin, err = escrow.ReceiveAsset.CoinParamFromAsset(); err != nil {
+			err = fmt.Errorf("Error getting coin to receive for updateEscrowsAtHeight: %s", err)
+			return
+		}
+		if lockCoin != coin && receiveCoin != coin {
+			continue
+		}
+
+		if err = server.updateEscrowAtHeight(escrow); err != nil {
+			escrowErrs = append(escrowErrs, fmt.Sprintf("%s: %s", escrow.ID(), err))
+		}
+	}
+	err = nil
+
+	if len(escrowErrs) > 0 {
+		err = fmt.Errorf("Error updating escrows for updateEscrowsAtHeight: %s", strings.Join(escrowErrs, ", "))
+		return
+	}
+	return
+}
+
+// updateEscrowAtHeight expires, releases, completes or refunds a single escrow at the current
+// heights of the coins it locks and receives. swapMtx should be held.
+func (server *OpencxServer) updateEscrowAtHeight(escrow *match.OrderEscrow) (err error) {
+	var lockCoin, receiveCoin *coinparam.Params
+	if lockCoin, err = escrow.LockAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin locked for updateEscrowAtHeight: %s", err)
+		return
+	}
+	if receiveCoin, err = escrow.ReceiveAsset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin to receive for updateEscrowAtHeight: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	lockHeight, receiveHeight := uint32(server.chainHeights[lockCoin]), uint32(server.chainHeights[receiveCoin])
+	server.dbLock.Unlock()
+
+	switch escrow.State {
+	case match.EscrowPending:
+		// HTLCs may have come in that there was no event for
+		if err = server.commitEscrow(escrow); err != nil || escrow.State != match.EscrowPending {
+			return
+		}
+		if !escrow.MustRelease(lockHeight, server.SwapPolicy) {
+			return
+		}
+		// We never claimed anything, so the user's HTLCs just time out
+		escrow.SetState(match.EscrowExpired, "the user's HTLCs never arrived", time.Now())
+		if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+			err = fmt.Errorf("Error updating expired escrow for updateEscrowAtHeight: %s", err)
+			return
+		}
+		server.releaseEscrowLiquidity(escrow)
+		logging.Infof("Expired %s", escrow)
+		return
+	case match.EscrowCommitted:
+		if !escrow.MustRelease(lockHeight, server.SwapPolicy) {
+			return
+		}
+		if err = server.cancelEscrowOrder(escrow); err != nil {
+			return
+		}
+		return server.releaseEscrow(escrow)
+	case match.EscrowReleasing:
+		if escrow.CanClaim(lockHeight, server.SwapPolicy) {
+			return server.releaseEscrow(escrow)
+		}
+		if !escrow.TimedOut(receiveHeight, lockHeight) {
+			return
+		}
+		// We never claimed the user's HTLCs, so they go back to the user and ours come back to us
+		if err = server.claimEscrowTimeouts(escrow, receiveCoin, lockCoin, receiveHeight, lockHeight); err != nil {
+			return
+		}
+		server.releaseEscrowLiquidity(escrow)
+		escrow.SetState(match.EscrowExpired, "the escrow could not be released before it timed out", time.Now())
+		if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+			err = fmt.Errorf("Error updating expired escrow for updateEscrowAtHeight: %s", err)
+			return
+		}
+		logging.Infof("Expired %s", escrow)
+		return
+	case match.EscrowClaimed:
+		// Something might have changed that there was no event for, like HTLCs clearing on chain
+		if err = server.completeEscrow(escrow); err != nil || escrow.State.Final() {
+			return
+		}
+		if !escrow.TimedOut(receiveHeight, lockHeight) {
+			return
+		}
+		if err = server.claimEscrowTimeouts(escrow, receiveCoin, lockCoin, receiveHeight, lockHeight); err != nil {
+			return
+		}
+		escrow.SetState(match.EscrowRefunded, "the user never claimed our HTLCs", time.Now())
+		if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+			err = fmt.Errorf("Error updating refunded escrow for updateEscrowAtHeight: %s", err)
+			return
+		}
+		logging.Infof("Refunded %s", escrow)
+		return
+	}
+	return
+}
+
+// cancelEscrowOrder takes a committed escrow's order off the book because the escrow is about to
+// time out, and starts releasing the escrow. The order is recorded as expired. swapMtx should be held, dbLock should not be held.
+func (server *OpencxServer) cancelEscrowOrder(escrow *match.OrderEscrow) (err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	var book match.LimitOrderbook
+	var ok bool
+	if book, ok = server.Orderbooks[escrow.Order.TradingPair]; !ok {
+		err = fmt.Errorf("Could not find orderbooks for trading pair for cancelEscrowOrder")
+		return
+	}
+
+	var order *match.LimitOrderIDPair
+	if order, err = book.GetOrder(&escrow.OrderID); err != nil {
+		err = fmt.Errorf("Error getting order for cancelEscrowOrder: %s", err)
+		return
+	}
+
+	var cancelled *match.OrderEscrow
+	if cancelled, err = server.cancelOrder(order, true); err != nil {
+		err = fmt.Errorf("Error cancelling order for cancelEscrowOrder: %s", err)
+		return
+	}
+	if cancelled == nil {
+		err = fmt.Errorf("Escrow %s wasn't committed to order %x", escrow.ID(), escrow.OrderID[:])
+		return
+	}
+	*escrow = *cancelled
+	logging.Infof("Cancelled order for %s, it's about to time out", escrow)
+	return
+}
+
+// claimEscrowTimeouts takes back the HTLCs we sent to release an escrow once they've timed out.
+// swapMtx should be held.
+func (server *OpencxServer) claimEscrowTimeouts(escrow *match.OrderEscrow, receiveCoin *coinparam.Params, lockCoin *coinparam.Params, receiveHeight uint32, lockHeight uint32) (err error) {
+	if escrow.AmountReceive != 0 {
+		if _, err = server.ExchangeNode.ClaimHTLCTimeouts(receiveCoin.HDCoinType, int32(receiveHeight)); err != nil {
+			err = fmt.Errorf("Error claiming HTLC timeouts for what the order received for claimEscrowTimeouts: %s", err)
+			return
+		}
+	}
+	if escrow.AmountRefund != 0 {
+		if _, err = server.ExchangeNode.ClaimHTLCTimeouts(lockCoin.HDCoinType, int32(lockHeight)); err != nil {
+			err = fmt.Errorf("Error claiming HTLC timeouts for refund for claimEscrowTimeouts: %s", err)
+			return
+		}
+	}
+	return
+}
+
+// GetEscrows gets every escrow for a pubkey, without the preimages of escrows that haven't been
+// claimed
+func (server *OpencxServer) GetEscrows(pubkey *koblitz.PublicKey) (escrows []*match.OrderEscrow, err error) {
+	if server.SwapStore == nil {
+		err = fmt.Errorf("Channel orders aren't supported")
+		return
+	}
+
+	var stored []*match.OrderEscrow
+	if stored, err = server.SwapStore.GetEscrows(pubkey); err != nil {
+		err = fmt.Errorf("Error getting escrows for GetEscrows: %s", err)
+		return
+	}
+	for _, escrow := range stored {
+		escrows = append(escrows, escrow.Public())
+	}
+	return
+}
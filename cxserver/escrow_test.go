@@ -0,0 +1,127 @@
+package cxserver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestChannelOrderReleasedOnFill tests that filling part of a channel order releases its whole
+// escrow, takes the rest of the order off the book, and doesn't change balances
+func TestChannelOrderReleasedOnFill(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "channelorders")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	server := createSwapServer(t, dataDir)
+
+	seller, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	buyer, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{2})
+
+	escrow, err := match.NewOrderEscrow(swapOrder(seller, match.Sell), 100, server.SwapPolicy, time.Now())
+	if err != nil {
+		t.Fatalf("create escrow: %v", err)
+	}
+	if err = server.SwapStore.AddEscrow(escrow); err != nil {
+		t.Fatalf("add escrow: %v", err)
+	}
+
+	// this is what happens once the seller's HTLCs are all there
+	sellID, err := server.placeOrder(&escrow.Order, nil, escrow)
+	if err != nil {
+		t.Fatalf("place channel sell: %v", err)
+	}
+	if stored, err := server.SwapStore.GetOrderEscrow(sellID); err != nil || stored == nil || stored.State != match.EscrowCommitted {
+		t.Fatalf("Sell should have a committed escrow, got %v and %v", stored, err)
+	}
+	if _, err = server.PlaceOrder(swapOrder(seller, match.Sell)); err == nil {
+		t.Errorf("Custodial order should be refused while the user has a channel order on the pair")
+	}
+
+	// the buyer only takes half of the order
+	buy := swapOrder(buyer, match.Buy)
+	buy.AmountHave /= 2
+	buy.AmountWant /= 2
+	if _, err = server.placeOrder(buy, swapTestLiquidity(buyer, match.Buy), nil); err != nil {
+		t.Fatalf("place swap buy: %v", err)
+	}
+
+	escrows, err := server.GetEscrows(seller.PubKey())
+	if err != nil || len(escrows) != 1 {
+		t.Fatalf("Seller should have one escrow, got %d and %v", len(escrows), err)
+	}
+	released := escrows[0]
+	if released.State != match.EscrowReleasing || released.AmountReceive != 50000000 || released.AmountRefund != 50000000 {
+		t.Errorf("Escrow should be releasing 50000000 and refunding 50000000, got %s", released)
+	}
+	if released.ReceiveLocktime != 100+match.DefaultSwapTimeout || released.Preimage != [16]byte{} {
+		t.Errorf("Escrow should release until %d without a public preimage, got %s", 100+match.DefaultSwapTimeout, released)
+	}
+
+	// the seller is settled by the escrow, the buyer by a swap
+	if swaps, err := server.GetSwaps(seller.PubKey()); err != nil || len(swaps) != 0 {
+		t.Errorf("Seller shouldn't have a swap, got %d and %v", len(swaps), err)
+	}
+	if swaps, err := server.GetSwaps(buyer.PubKey()); err != nil || len(swaps) != 1 {
+		t.Errorf("Buyer should have one swap, got %d and %v", len(swaps), err)
+	}
+
+	orders, err := server.GetOrdersForPubkey(seller.PubKey())
+	if err != nil {
+		t.Fatalf("get seller orders: %v", err)
+	}
+	if len(orders) != 0 {
+		t.Errorf("Rest of the channel order should be off the book, got %d orders", len(orders))
+	}
+
+	for _, asset := range []match.Asset{swapPair.AssetWant, swapPair.AssetHave} {
+		coin, _ := asset.CoinParamFromAsset()
+		if balance, err := server.GetBalance(seller.PubKey(), coin); err != nil || balance != 0 {
+			t.Errorf("Escrow shouldn't change the %s balance of the seller, got %d and %v", asset, balance, err)
+		}
+	}
+}
+
+// TestChannelOrderExpiredInHistory tests that a channel order taken off the book because its
+// escrow is about to time out is recorded as expired, not cancelled
+func TestChannelOrderExpiredInHistory(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "channelorders")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	server := createSwapServer(t, dataDir)
+	if server.HistoryStore, err = cxdbmemory.CreateHistoryStore(); err != nil {
+		t.Fatalf("create history store: %v", err)
+	}
+
+	seller, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	escrow, err := match.NewOrderEscrow(swapOrder(seller, match.Sell), 100, server.SwapPolicy, time.Now())
+	if err != nil {
+		t.Fatalf("create escrow: %v", err)
+	}
+	if err = server.SwapStore.AddEscrow(escrow); err != nil {
+		t.Fatalf("add escrow: %v", err)
+	}
+	if _, err = server.placeOrder(&escrow.Order, nil, escrow); err != nil {
+		t.Fatalf("place channel sell: %v", err)
+	}
+
+	if err = server.cancelEscrowOrder(escrow); err != nil {
+		t.Fatalf("cancel escrow order: %v", err)
+	}
+
+	orders, _, err := server.HistoryStore.GetOrderHistory(seller.PubKey(), &match.HistoryQuery{})
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("Seller should have one order in history, got %d and %v", len(orders), err)
+	}
+	if orders[0].Status != match.OrderExpired {
+		t.Errorf("Order should be expired, it's %s", orders[0].Status)
+	}
+}
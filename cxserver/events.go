@@ -0,0 +1,83 @@
+package cxserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/cxevent"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetEventLog makes the server record every input to the exchange in an event log, so the
+// matching engines can be replayed offline. dbLock should not be held.
+func (server *OpencxServer) SetEventLog(eventLog *cxevent.EventLog) {
+	server.dbLock.Lock()
+	server.EventLog = eventLog
+	server.dbLock.Unlock()
+	return
+}
+
+// newEvent creates and sequences an event if there's an event log, otherwise it returns nil.
+// dbLock should be held so events are sequenced in the order the engines see them.
+func (server *OpencxServer) newEvent(eventType cxevent.EventType) (event *cxevent.Event) {
+	if server.EventLog == nil {
+		return
+	}
+	event = &cxevent.Event{Type: eventType}
+	server.EventLog.Sequence(event)
+	return
+}
+
+// placeLimitOrder places an order on a limit engine at the event's time, so a replay of the
+// event places it at the same time. Without an event the engine decides the time.
+func (server *OpencxServer) placeLimitOrder(engine match.LimitEngine, order *match.LimitOrder, event *cxevent.Event) (idRes *match.LimitOrderIDPair, err error) {
+	if event == nil {
+		return engine.PlaceLimitOrder(order)
+	}
+	return cxevent.PlaceLimitOrderAt(engine, order, event.Timestamp())
+}
+
+// appendEvent writes an event to the event log before the engines see it, so the log has every
+// change the engines make. If it can't be written the engines shouldn't be changed, so the error
+// is returned. A nil event means there's no event log.
+func (server *OpencxServer) appendEvent(event *cxevent.Event) (err error) {
+	if event == nil {
+		return
+	}
+	if err = server.EventLog.Append(event); err != nil {
+		err = fmt.Errorf("Error appending %s to event log: %s", event, err)
+		return
+	}
+	return
+}
+
+// recordEvent writes what the engines did with an appended event to the event log. A nil event
+// means there's no event log. The event itself is already in the log, so failing to record what
+// the engines did only loses the result, and it's logged rather than returned.
+func (server *OpencxServer) recordEvent(event *cxevent.Event, orderExecs []*match.OrderExecution, setExecs []*match.SettlementExecution) {
+	if event == nil {
+		return
+	}
+	if err := server.EventLog.Record(event, orderExecs, setExecs); err != nil {
+		logging.Errorf("Error recording %s: %s", event, err)
+	}
+	return
+}
+
+// recordSettlementEvent writes an event for a change to a user's balance to the event log. The
+// settlement exec is everything the event does, so it's recorded whole before the balance
+// changes, and if it can't be, the balance shouldn't change. dbLock should be held.
+func (server *OpencxServer) recordSettlementEvent(eventType cxevent.EventType, setExec *match.SettlementExecution) (err error) {
+	event := server.newEvent(eventType)
+	if event == nil {
+		return
+	}
+	event.Pubkey = setExec.Pubkey[:]
+	event.Asset = setExec.Asset
+	event.Amount = setExec.Amount
+	if err = server.EventLog.Record(event, nil, []*match.SettlementExecution{setExec}); err != nil {
+		err = fmt.Errorf("Error recording %s: %s", event, err)
+		return
+	}
+	return
+}
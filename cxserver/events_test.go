@@ -0,0 +1,53 @@
+package cxserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxevent"
+)
+
+// TestEventLogFailureStopsChange makes sure a balance doesn't change if its event can't be
+// written to the event log, so the log always has every change
+func TestEventLogFailureStopsChange(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, _, _ := createChainServer(t, dataDir)
+	logPath := filepath.Join(dataDir, "events.log")
+	eventLog, err := cxevent.OpenEventLog(logPath)
+	if err != nil {
+		t.Fatalf("open event log: %v", err)
+	}
+	server.SetEventLog(eventLog)
+
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{5})
+	if err = server.DebitUser(priv.PubKey(), 1000, testCoin); err != nil {
+		t.Fatalf("debit user: %v", err)
+	}
+
+	// the log can't be written to once it's closed
+	if err = eventLog.Close(); err != nil {
+		t.Fatalf("close event log: %v", err)
+	}
+	if err = server.DebitUser(priv.PubKey(), 500, testCoin); err == nil {
+		t.Fatalf("debit should fail when its event can't be logged")
+	}
+	if balance, _ := server.GetBalance(priv.PubKey(), testCoin); balance != 1000 {
+		t.Errorf("balance should only have the logged debit, it's %d", balance)
+	}
+
+	events, err := cxevent.ReadEventLog(logPath)
+	if err != nil {
+		t.Fatalf("read event log: %v", err)
+	}
+	if len(events) != 1 || events[0].Amount != 1000 {
+		t.Errorf("event log should have the one debit that happened, has %d events", len(events))
+	}
+}
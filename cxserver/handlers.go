@@ -24,7 +24,7 @@ func (server *OpencxServer) GetPushHandler() (hFunc func(event eventbus.Event) e
 
 		var err error
 		if !ee.State.Failed {
-			if err = server.ingestChannelPush(uint64(ee.State.Delta), &ee.TheirPub, ee.CoinType); err != nil {
+			if err = server.ingestChannelPush(ee.State, &ee.TheirPub, ee.CoinType, ee.ChanIdx); err != nil {
 				logging.Errorf("ingesting channel push error: %s", err)
 				return eventbus.EHANDLE_CANCEL
 			}
@@ -49,7 +49,7 @@ func (server *OpencxServer) GetOPConfirmHandler() (hFunc func(event eventbus.Eve
 
 		var err error
 		if !ee.State.Failed {
-			if err = server.ingestChannelConfirm(ee.State, &ee.TheirPub, ee.CoinType); err != nil {
+			if err = server.ingestChannelConfirm(ee.State, &ee.TheirPub, ee.CoinType, ee.ChanIdx); err != nil {
 				logging.Errorf("ingesting channel confirm error: %s", err)
 				return eventbus.EHANDLE_CANCEL
 			}
@@ -86,6 +86,36 @@ func (server *OpencxServer) GetSigProofHandler() (hFunc func(event eventbus.Even
 	return
 }
 
+// GetSwapHTLCHandler gets the handler func that moves swaps and submarine swaps along when HTLCs
+// are added or cleared on a channel. The handler runs before the channel can be used again, so the swaps are updated
+// in their own goroutine.
+func (server *OpencxServer) GetSwapHTLCHandler() (hFunc func(event eventbus.Event) eventbus.EventHandleResult) {
+	hFunc = func(event eventbus.Event) (res eventbus.EventHandleResult) {
+		// We know this is a channel state update event
+		ee, ok := event.(qln.ChannelStateUpdateEvent)
+		if !ok {
+			logging.Errorf("Wrong type of event, why are you making this the handler for that?")
+			return eventbus.EHANDLE_CANCEL
+		}
+
+		if ee.State == nil || ee.State.Failed || len(ee.State.HTLCs) == 0 {
+			return eventbus.EHANDLE_OK
+		}
+
+		var hashes [][32]byte
+		for _, htlc := range ee.State.HTLCs {
+			hashes = append(hashes, htlc.RHash)
+		}
+		go server.updateSwaps(hashes)
+		go server.updateEscrows(hashes)
+		go server.updateSubmarineSwaps(hashes)
+		go server.updateInvoices(hashes)
+
+		return eventbus.EHANDLE_OK
+	}
+	return
+}
+
 // HeightHandler is a handler for when there is a height and block event for the wallet. We need both channels to work and be synchronized, which I'm assuming is the case in the lit repos. Will need to double check.
 func (server *OpencxServer) HeightHandler(incomingBlockHeight chan lnutil.HeightEvent, blockChan chan *wire.MsgBlock, coinType *coinparam.Params) {
 	for {
@@ -96,24 +126,51 @@ func (server *OpencxServer) HeightHandler(incomingBlockHeight chan lnutil.Height
 }
 
 // ChainHookHeightHandler is a handler for when there is a height and block event. We need both channels to work and be synchronized, which I'm assuming is the case in the lit repos. Will need to double check.
+// The chainhook tells us about a reorg by sending the height the chain was reorged back to, without a block, so
+// a height that isn't above the last one we ingested means blocks were disconnected.
 func (server *OpencxServer) ChainHookHeightHandler(incomingBlockHeight chan int32, blockChan chan *wire.MsgBlock, coinType *coinparam.Params) {
+	var lastHeight int32
 	for {
 
 		// this used to be commented out. Since in lit the channels are buffered, we HAVE to make sure that this is cleared
 		// otherwise lit will just completely block and wait for us to pull from the channel, and we will stop getting
 		// headers and everything. IF it's needed, just always pulling from this would be fine if we don't care about it.
 		h := <-incomingBlockHeight
+		if lastHeight != 0 && h <= lastHeight {
+			logging.Infof("Reorg on %s back to height %d", coinType.Name, h)
+			server.CallDisconnect(h, coinType)
+			lastHeight = h - 1
+			continue
+		}
+
 		block := <-blockChan
 		logging.Infof("Block %s from %s", block.Header.BlockHash(), coinType.Name)
 		server.CallIngest(h, block, coinType)
+		lastHeight = h
 	}
 }
 
 // CallIngest calls the ingest function. This is so we can make a bunch of different handlers that call this depending on which way they use channels.
 func (server *OpencxServer) CallIngest(blockHeight int32, block *wire.MsgBlock, coinType *coinparam.Params) {
 	// logging.Debugf("Ingesting %d transactions at height %d\n", len(block.Transactions), blockHeight)
-	if err := server.ingestTransactionListAndHeight(block.Transactions, uint64(blockHeight), coinType); err != nil {
+	if err := server.ingestTransactionListAndHeight(block.Transactions, uint64(blockHeight), block.Header.BlockHash().String(), coinType); err != nil {
 		logging.Infof("something went horribly wrong with %s\n", coinType.Name)
 		logging.Errorf("Here's what went horribly wrong: %s\n", err)
 	}
 }
+
+// CallDisconnect rolls back deposits, the cold wallet and fee estimates after a reorg back to reorgHeight. The block at reorgHeight is the first one that
+// was disconnected, we would much rather not credit a deposit until the block comes back than credit it twice.
+func (server *OpencxServer) CallDisconnect(reorgHeight int32, coinType *coinparam.Params) {
+	if reorgHeight <= 0 {
+		logging.Errorf("Cannot disconnect the %s chain back to height %d", coinType.Name, reorgHeight)
+		return
+	}
+	if err := server.disconnectDepositsAboveHeight(uint64(reorgHeight-1), coinType); err != nil {
+		logging.Errorf("Error rolling back %s deposits for reorg to %d: %s", coinType.Name, reorgHeight, err)
+	}
+	if err := server.disconnectColdAboveHeight(uint64(reorgHeight-1), coinType); err != nil {
+		logging.Errorf("Error rolling back %s cold wallet for reorg to %d: %s", coinType.Name, reorgHeight, err)
+	}
+	server.disconnectFeesAboveHeight(uint64(reorgHeight-1), coinType)
+}
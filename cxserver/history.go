@@ -0,0 +1,46 @@
+package cxserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/match"
+)
+
+// GetOrderHistory returns a page of a pubkey's order history, newest first. nextCursor is 0 if
+// there are no more pages.
+func (server *OpencxServer) GetOrderHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (orders []*match.OrderHistoryEntry, nextCursor uint64, err error) {
+	if server.HistoryStore == nil {
+		err = fmt.Errorf("Error, this exchange does not keep order history")
+		return
+	}
+
+	server.dbLock.Lock()
+	if orders, nextCursor, err = server.HistoryStore.GetOrderHistory(pubkey, query); err != nil {
+		err = fmt.Errorf("Error getting order history for server GetOrderHistory: %s", err)
+		server.dbLock.Unlock()
+		return
+	}
+	server.dbLock.Unlock()
+
+	return
+}
+
+// GetFillHistory returns a page of a pubkey's fills, newest first. nextCursor is 0 if there are
+// no more pages.
+func (server *OpencxServer) GetFillHistory(pubkey *koblitz.PublicKey, query *match.HistoryQuery) (fills []*match.FillEntry, nextCursor uint64, err error) {
+	if server.HistoryStore == nil {
+		err = fmt.Errorf("Error, this exchange does not keep fill history")
+		return
+	}
+
+	server.dbLock.Lock()
+	if fills, nextCursor, err = server.HistoryStore.GetFillHistory(pubkey, query); err != nil {
+		err = fmt.Errorf("Error getting fill history for server GetFillHistory: %s", err)
+		server.dbLock.Unlock()
+		return
+	}
+	server.dbLock.Unlock()
+
+	return
+}
@@ -2,22 +2,69 @@ package cxserver
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/qln"
 
-	"github.com/mit-dci/lit/btcutil"
-
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/wire"
 	util "github.com/mit-dci/opencx/chainutils"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
 
-// IngestTransactionListAndHeight processes a transaction list and corresponding height
-func (server *OpencxServer) ingestTransactionListAndHeight(txList []*wire.MsgTx, height uint64, coinType *coinparam.Params) (err error) {
+// IngestTransactionListAndHeight processes a transaction list and corresponding height, for the block with blockHash.
+// Every step runs even if the ones before it fail, since the block's transactions won't be seen again, and the errors
+// are returned together.
+func (server *OpencxServer) ingestTransactionListAndHeight(txList []*wire.MsgTx, height uint64, blockHash string, coinType *coinparam.Params) (err error) {
+	server.dbLock.Lock()
+	server.chainHeights[coinType] = height
+	server.dbLock.Unlock()
+
+	var failures []string
+	step := func(what string, stepErr error) {
+		if stepErr != nil {
+			failures = append(failures, fmt.Sprintf("Error %s: %s", what, stepErr))
+		}
+	}
+
+	// The block's transactions go first, they're only seen once
+	step("confirming withdrawals", server.confirmWithdrawals(txList, coinType))
+	step("ingesting cold wallet transactions", server.ingestColdTransactions(txList, height, coinType))
+	step("ingesting submarine swap transactions", server.ingestSubmarineTransactions(txList, height, coinType))
+	step("ingesting atomic swap transactions", server.ingestAtomicSwapTransactions(txList, height, coinType))
+	step("ingesting deposits", server.ingestDeposits(txList, height, blockHash, coinType))
+	server.recordBlockFees(txList, height, coinType)
+
+	// Then everything that moves along with the height
+	step("bumping stuck withdrawals", server.bumpStuckWithdrawals(height, coinType))
+	step("updating swaps", server.updateSwapsAtHeight(height, coinType))
+	step("updating escrows", server.updateEscrowsAtHeight(height, coinType))
+	step("updating invoices", server.updateInvoicesAtHeight(height, coinType))
+	step("updating lightning payments", server.updateLightningPaymentsAtHeight(height, coinType))
+	step("updating submarine swaps", server.updateSubmarineSwapsAtHeight(height, coinType))
+	step("updating atomic swaps", server.updateAtomicSwapsAtTime(time.Now()))
+	step("managing liquidity", server.manageLiquidityAtHeight(height, coinType))
+
+	if len(failures) != 0 {
+		err = fmt.Errorf("Errors ingesting %s block at height %d for ingestTransactionListAndHeight: %s", coinType.Name, height, strings.Join(failures, "; "))
+		return
+	}
+
+	logging.Debugf("Finished ingesting %s block at height %d", coinType.Name, height)
+	if height%10000 == 0 {
+		logging.Infof("Finished ingesting %s block at height %d\n", coinType.Name, height)
+	}
+	return
+}
+
+// ingestDeposits finds the outputs in a block that pay to our deposit addresses, and updates the deposits at the
+// block's height
+func (server *OpencxServer) ingestDeposits(txList []*wire.MsgTx, height uint64, blockHash string, coinType *coinparam.Params) (err error) {
 	// get list of addresses we own
 	// check the sender, amounts, receiver of all the transactions
 	// check if the receiver is us
@@ -40,66 +87,70 @@ func (server *OpencxServer) ingestTransactionListAndHeight(txList []*wire.MsgTx,
 		server.dbLock.Unlock()
 		return
 	}
+
+	var policy *match.ConfirmationPolicy
+	if policy, ok = server.ConfirmationPolicies[coinType]; !ok {
+		policy = &match.ConfirmationPolicy{Confirmations: match.DefaultConfirmations}
+	}
 	server.dbLock.Unlock()
 
+	var info *match.AssetInfo
+	if info, err = match.AssetInfoFromCoinParam(coinType); err != nil {
+		err = fmt.Errorf("Error getting asset for ingestDeposits: %s", err)
+		return
+	}
+
 	var deposits []match.Deposit
 
 	for _, tx := range txList {
 		for _, output := range tx.TxOut {
 
-			// figure out if it's the right script type
-			scriptType, data := util.ScriptType(output.PkScript)
-			if scriptType == "P2PKH" {
-				// It's P2PKH, let's get the address
-				var addr *btcutil.AddressPubKeyHash
-				if addr, err = btcutil.NewAddressPubKeyHash(data, coinType); err != nil {
-					err = fmt.Errorf("Error deciding p2pkh while ingesting txs: %s", err)
-					return
+			// only outputs with an address can be deposits
+			var addr string
+			if addr, err = util.ScriptAddress(output.PkScript, coinType); err != nil {
+				err = nil
+				continue
+			}
+
+			if pubkey, found := addressesWeOwn[addr]; found {
+				// the coins are ours either way, but deposits this small aren't worth crediting
+				if uint64(output.Value) < info.MinDeposit {
+					logging.Warnf("Not crediting deposit of %d %s to %s in %s, the minimum is %d", output.Value, coinType.Name, addr, tx.TxHash().String(), info.MinDeposit)
+					continue
 				}
 
-				if pubkey, found := addressesWeOwn[addr.String()]; found {
-					newDeposit := match.Deposit{
-						Pubkey:              pubkey,
-						Address:             addr.String(),
-						Amount:              uint64(output.Value),
-						Txid:                tx.TxHash().String(),
-						CoinType:            coinType,
-						BlockHeightReceived: height,
-						Confirmations:       6,
-					}
-
-					logging.Infof("Received deposit for %d %s", newDeposit.Amount, newDeposit.CoinType.Name)
-					logging.Infof("%s\n", newDeposit.String())
-					deposits = append(deposits, newDeposit)
+				newDeposit := match.Deposit{
+					Pubkey:              pubkey,
+					Address:             addr,
+					Amount:              uint64(output.Value),
+					Txid:                tx.TxHash().String(),
+					CoinType:            coinType,
+					BlockHeightReceived: height,
+					BlockHash:           blockHash,
+					Confirmations:       policy.ConfirmationsFor(uint64(output.Value)),
 				}
+
+				logging.Infof("Received deposit for %d %s", newDeposit.Amount, newDeposit.CoinType.Name)
+				logging.Infof("%s\n", newDeposit.String())
+				deposits = append(deposits, newDeposit)
 			}
 		}
 	}
 
 	if err = server.updateDepositsAtHeight(deposits, height, coinType); err != nil {
-		err = fmt.Errorf("Error updating deposits at height for ingestTransactionListAndHeight: %s", err)
+		err = fmt.Errorf("Error updating deposits at height for ingestDeposits: %s", err)
 		return
 	}
-
-	logging.Debugf("Finished ingesting %s block at height %d", coinType.Name, height)
-	if height%10000 == 0 {
-		logging.Infof("Finished ingesting %s block at height %d\n", coinType.Name, height)
-	}
 	return
 }
 
 // ingestChannelPush changes the user's balance to reflect that a push on a channel happened
-func (server *OpencxServer) ingestChannelPush(pushAmt uint64, pubkey *koblitz.PublicKey, coinType uint32) (err error) {
-
+func (server *OpencxServer) ingestChannelPush(state *qln.StatCom, pubkey *koblitz.PublicKey, coinType uint32, chanIdx uint32) (err error) {
+	pushAmt := uint64(state.Delta)
 	logging.Infof("Confirmed push from %x to give me %d of cointype %d\n", pubkey.SerializeCompressed(), pushAmt, coinType)
-	var param *coinparam.Params
-	if param, err = util.GetParamFromHDCoinType(coinType); err != nil {
-		err = fmt.Errorf("Error getting param from cointype while ingesting channel push: %s", err)
-		return
-	}
 
-	if err = server.DebitUser(pubkey, pushAmt, param); err != nil {
-		err = fmt.Errorf("Error debiting user for ingestChannelPush: %s", err)
+	if err = server.creditLightningDeposit(pushAmt, state, pubkey, coinType, chanIdx); err != nil {
+		err = fmt.Errorf("Error crediting deposit for ingestChannelPush: %s", err)
 		return
 	}
 
@@ -107,17 +158,11 @@ func (server *OpencxServer) ingestChannelPush(pushAmt uint64, pubkey *koblitz.Pu
 }
 
 // ingestChannelConfirm changes the user's balance to reflect that a confirmation of a channel happened
-func (server *OpencxServer) ingestChannelConfirm(state *qln.StatCom, pubkey *koblitz.PublicKey, coinType uint32) (err error) {
+func (server *OpencxServer) ingestChannelConfirm(state *qln.StatCom, pubkey *koblitz.PublicKey, coinType uint32, chanIdx uint32) (err error) {
 	logging.Infof("Confirmed channel from %x to give me %d of cointype %d\n", pubkey.SerializeCompressed(), state.MyAmt, coinType)
 
-	var param *coinparam.Params
-	if param, err = util.GetParamFromHDCoinType(coinType); err != nil {
-		err = fmt.Errorf("Error getting param from hdcointype for ingestChannelConfirm")
-		return
-	}
-
-	if err = server.DebitUser(pubkey, uint64(state.MyAmt), param); err != nil {
-		err = fmt.Errorf("Error debiting user for ingestChannelConfirm: %s", err)
+	if err = server.creditLightningDeposit(uint64(state.MyAmt), state, pubkey, coinType, chanIdx); err != nil {
+		err = fmt.Errorf("Error crediting deposit for ingestChannelConfirm: %s", err)
 		return
 	}
 
@@ -162,6 +207,16 @@ func (server *OpencxServer) updateDepositsAtHeight(deposits []match.Deposit, hei
 
 	var settlementResults []*match.SettlementResult
 	for _, setExec := range depositExecs {
+		// Anything the user owes for reorged deposits comes out of this one first
+		if err = server.collectDepositDebt(setExec, currDepositStore, coinType); err != nil {
+			err = fmt.Errorf("Error collecting deposit debt for updateDepositsAtHeight: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+		if setExec.Amount == 0 {
+			continue
+		}
+
 		// We always check validity first
 		var valid bool
 		if valid, err = currSettleEngine.CheckValid(setExec); err != nil {
@@ -171,6 +226,12 @@ func (server *OpencxServer) updateDepositsAtHeight(deposits []match.Deposit, hei
 		}
 
 		if valid {
+			if err = server.recordSettlementEvent(cxevent.DepositEvent, setExec); err != nil {
+				err = fmt.Errorf("Error recording event for updateDepositsAtHeight: %s", err)
+				server.dbLock.Unlock()
+				return
+			}
+
 			var setRes *match.SettlementResult
 			if setRes, err = currSettleEngine.ApplySettlementExecution(setExec); err != nil {
 				err = fmt.Errorf("Error applying settlement exec for updateDepositsAtHeight: %s", err)
@@ -194,6 +255,140 @@ func (server *OpencxServer) updateDepositsAtHeight(deposits []match.Deposit, hei
 	return
 }
 
+// disconnectDepositsAboveHeight acquires locks and takes back deposits that were credited in blocks above height,
+// after those blocks were disconnected by a reorg
+func (server *OpencxServer) disconnectDepositsAboveHeight(height uint64, coinType *coinparam.Params) (err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	// First get the correct deposit store, settlement engine, and settlement store
+	var currDepositStore cxdb.DepositStore
+	var ok bool
+	if currDepositStore, ok = server.DepositStores[coinType]; !ok {
+		err = fmt.Errorf("Could not find deposit store for cointype %s", coinType.Name)
+		return
+	}
+
+	var currSettleStore cxdb.SettlementStore
+	if currSettleStore, ok = server.SettlementStores[coinType]; !ok {
+		err = fmt.Errorf("Could not find settlement store for cointype %s", coinType.Name)
+		return
+	}
+
+	var currSettleEngine match.SettlementEngine
+	if currSettleEngine, ok = server.SettlementEngines[coinType]; !ok {
+		err = fmt.Errorf("Could not find settlement engine for cointype %s", coinType.Name)
+		return
+	}
+
+	var rollbackExecs []*match.SettlementExecution
+	if rollbackExecs, err = currDepositStore.DisconnectBlocks(height); err != nil {
+		err = fmt.Errorf("Error disconnecting blocks for disconnectDepositsAboveHeight: %s", err)
+		return
+	}
+	server.chainHeights[coinType] = height
+
+	var settlementResults []*match.SettlementResult
+	for _, setExec := range rollbackExecs {
+		// We always check validity first
+		var valid bool
+		if valid, err = currSettleEngine.CheckValid(setExec); err != nil {
+			err = fmt.Errorf("Error checking exec validity for disconnectDepositsAboveHeight: %s", err)
+			return
+		}
+
+		// If they've already spent some of the deposit, we take back what's left of their balance
+		// and they owe us the rest
+		if !valid {
+			if err = server.takeBackSpentDeposit(setExec, currSettleStore, currDepositStore, coinType); err != nil {
+				err = fmt.Errorf("Error taking back spent deposit for disconnectDepositsAboveHeight: %s", err)
+				return
+			}
+			if setExec.Amount == 0 {
+				continue
+			}
+		}
+
+		if err = server.recordSettlementEvent(cxevent.DepositRollbackEvent, setExec); err != nil {
+			err = fmt.Errorf("Error recording event for disconnectDepositsAboveHeight: %s", err)
+			return
+		}
+
+		var setRes *match.SettlementResult
+		if setRes, err = currSettleEngine.ApplySettlementExecution(setExec); err != nil {
+			err = fmt.Errorf("Error applying settlement exec for disconnectDepositsAboveHeight: %s", err)
+			return
+		}
+		settlementResults = append(settlementResults, setRes)
+	}
+
+	if err = currSettleStore.UpdateBalances(settlementResults); err != nil {
+		err = fmt.Errorf("Error updating balances for disconnectDepositsAboveHeight: %s", err)
+		return
+	}
+	return
+}
+
+// takeBackSpentDeposit shrinks a rollback exec for a deposit the user has already spent some of
+// down to their balance, and stores the rest as a debt in the deposit store. dbLock should be held.
+func (server *OpencxServer) takeBackSpentDeposit(setExec *match.SettlementExecution, settleStore cxdb.SettlementStore, depositStore cxdb.DepositStore, coinType *coinparam.Params) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(setExec.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for takeBackSpentDeposit: %s", err)
+		return
+	}
+
+	var balance uint64
+	if balance, err = settleStore.GetBalance(pubkey); err != nil {
+		err = fmt.Errorf("Error getting balance for takeBackSpentDeposit: %s", err)
+		return
+	}
+	if balance >= setExec.Amount {
+		err = fmt.Errorf("Balance of %d %s is enough to take back %d, but the exec isn't valid", balance, coinType.Name, setExec.Amount)
+		return
+	}
+
+	shortfall := setExec.Amount - balance
+	if err = depositStore.AddDepositDebt(pubkey, shortfall); err != nil {
+		err = fmt.Errorf("Error storing debt for takeBackSpentDeposit: %s", err)
+		return
+	}
+	setExec.Amount = balance
+	logging.Warnf("%x already spent %d %s of a reorged deposit, it'll be taken out of their next deposits", setExec.Pubkey, shortfall, coinType.Name)
+	return
+}
+
+// collectDepositDebt takes whatever the user owes for reorged deposits out of a deposit exec, and
+// takes that off their debt in the deposit store. dbLock should be held.
+func (server *OpencxServer) collectDepositDebt(setExec *match.SettlementExecution, depositStore cxdb.DepositStore, coinType *coinparam.Params) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(setExec.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for collectDepositDebt: %s", err)
+		return
+	}
+
+	var debt uint64
+	if debt, err = depositStore.GetDepositDebt(pubkey); err != nil {
+		err = fmt.Errorf("Error getting debt for collectDepositDebt: %s", err)
+		return
+	}
+	if debt == 0 {
+		return
+	}
+
+	collected := debt
+	if collected > setExec.Amount {
+		collected = setExec.Amount
+	}
+	if err = depositStore.PayDepositDebt(pubkey, collected); err != nil {
+		err = fmt.Errorf("Error paying debt for collectDepositDebt: %s", err)
+		return
+	}
+	setExec.Amount -= collected
+	logging.Infof("Took %d %s %x owed for reorged deposits out of their deposit", collected, coinType.Name, setExec.Pubkey)
+	return
+}
+
 // ingestChannelFund only registers the user for deposit addresses because the fund channel hasn't
 // necessarily been confirmed yet
 func (server *OpencxServer) ingestChannelFund(state *qln.StatCom, pubkey *koblitz.PublicKey, coinType uint32, qchanID uint32) (err error) {
@@ -0,0 +1,255 @@
+package cxserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// exchangeLitAddress returns the lit address of the exchange's node, which is where invoices are
+// paid
+func (server *OpencxServer) exchangeLitAddress() (litAddr string) {
+	var pubkey [33]byte
+	copy(pubkey[:], server.ExchangeNode.IdKey().PubKey().SerializeCompressed())
+	litAddr = lnutil.LitAdrFromPubkey(pubkey)
+	return
+}
+
+// invoiceStore returns the store invoices are kept in, or an error if invoices aren't supported
+func (server *OpencxServer) invoiceStore() (store cxdb.LightningDepositStore, err error) {
+	server.dbLock.Lock()
+	store = server.LightningDepositStore
+	server.dbLock.Unlock()
+	if server.ExchangeNode == nil || store == nil {
+		err = fmt.Errorf("Invoices aren't supported, lightning deposits aren't being recorded")
+		return
+	}
+	return
+}
+
+// CreateInvoice creates an invoice for a signed request and returns it, without its preimage,
+// along with the payment request the payer needs. Whoever pays it, the user that signed the
+// request is credited.
+func (server *OpencxServer) CreateInvoice(request *match.InvoiceRequest, signature []byte) (invoice *match.Invoice, paymentRequest string, err error) {
+	var store cxdb.LightningDepositStore
+	if store, err = server.invoiceStore(); err != nil {
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = request.RecoverPubkey(signature); err != nil {
+		err = fmt.Errorf("Error verifying invoice request for CreateInvoice: %s", err)
+		return
+	}
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+
+	var coin *coinparam.Params
+	if coin, err = request.Asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin for CreateInvoice: %s", err)
+		return
+	}
+	if _, ok := server.ExchangeNode.SubWallet[coin.HDCoinType]; !ok {
+		err = fmt.Errorf("Can't take %s over lightning, the exchange's node doesn't have a wallet for it", coin.Name)
+		return
+	}
+	var info *match.AssetInfo
+	if info, err = match.AssetInfoFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset info for CreateInvoice: %s", err)
+		return
+	}
+	if request.Amount < info.MinDeposit {
+		err = fmt.Errorf("Invoices for %s have to be for at least %d", coin.Name, info.MinDeposit)
+		return
+	}
+
+	var created *match.Invoice
+	if created, err = match.NewInvoice(request, pkBytes, server.exchangeLitAddress(), time.Now()); err != nil {
+		err = fmt.Errorf("Error creating invoice for CreateInvoice: %s", err)
+		return
+	}
+
+	server.invoiceMtx.Lock()
+	defer server.invoiceMtx.Unlock()
+
+	if err = store.AddInvoice(created); err != nil {
+		err = fmt.Errorf("Error storing invoice for CreateInvoice: %s", err)
+		return
+	}
+	invoice = created.Public()
+	paymentRequest = created.PaymentRequest().String()
+	logging.Infof("Created %s", created)
+	return
+}
+
+// updateInvoices pays the invoices for HTLC hashes, hashes that aren't for invoices are ignored
+func (server *OpencxServer) updateInvoices(hashes [][32]byte) {
+	store, err := server.invoiceStore()
+	if err != nil {
+		return
+	}
+
+	server.invoiceMtx.Lock()
+	defer server.invoiceMtx.Unlock()
+
+	seen := make(map[[32]byte]bool)
+	for _, rhash := range hashes {
+		if seen[rhash] {
+			continue
+		}
+		seen[rhash] = true
+
+		var invoice *match.Invoice
+		if invoice, err = store.GetInvoice(rhash); err != nil {
+			// not one of ours
+			continue
+		}
+		if invoice.State != match.InvoiceOpen {
+			continue
+		}
+		if err = server.payInvoice(store, invoice); err != nil {
+			logging.Errorf("Error updating %s: %s", invoice, err)
+		}
+	}
+	return
+}
+
+// payInvoice claims the HTLCs for an open invoice once they pay it and credits the user, or
+// expires it if it's too late. The HTLCs are claimed before the invoice is marked paid, if we
+// stop in between the invoice is paid from the claimed HTLCs next time it's looked at.
+// invoiceMtx should be held.
+func (server *OpencxServer) payInvoice(store cxdb.LightningDepositStore, invoice *match.Invoice) (err error) {
+	if invoice.Expired(time.Now()) {
+		invoice.SetState(match.InvoiceExpired, "not paid before it expired", time.Now())
+		if err = store.UpdateInvoice(invoice); err != nil {
+			err = fmt.Errorf("Error updating expired invoice for payInvoice: %s", err)
+			return
+		}
+		logging.Infof("Expired %s", invoice)
+		return
+	}
+
+	var htlcs []match.SwapHTLC
+	if htlcs, err = server.hashHTLCs(invoice.RHash); err != nil {
+		err = fmt.Errorf("Error getting HTLCs for payInvoice: %s", err)
+		return
+	}
+
+	var coin *coinparam.Params
+	if coin, err = invoice.Asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin for payInvoice: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	height := server.chainHeights[coin]
+	server.dbLock.Unlock()
+
+	// the rest of the HTLCs may not be there yet
+	var amountPaid uint64
+	if amountPaid, err = invoice.CheckPaid(htlcs, uint32(height), server.SwapPolicy); err != nil {
+		err = nil
+		return
+	}
+
+	for _, htlc := range htlcs {
+		if htlc.Incoming && !htlc.Cleared {
+			if _, err = server.ExchangeNode.ClaimHTLC(invoice.Preimage); err != nil {
+				err = fmt.Errorf("Error claiming HTLCs for payInvoice: %s", err)
+				return
+			}
+			break
+		}
+	}
+
+	invoice.AmountPaid = amountPaid
+	invoice.SetState(match.InvoicePaid, "", time.Now())
+	if err = store.UpdateInvoice(invoice); err != nil {
+		// the HTLCs are claimed, so the invoice is paid next time it's looked at
+		err = fmt.Errorf("Error updating paid invoice for payInvoice: %s", err)
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(invoice.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for paid invoice, it has not been credited: %s", err)
+		return
+	}
+	if err = server.DebitUser(pubkey, amountPaid, coin); err != nil {
+		logging.Errorf("Marked %s paid but could not credit it: %s", invoice, err)
+		err = fmt.Errorf("Error debiting user for payInvoice: %s", err)
+		return
+	}
+
+	logging.Infof("Paid %s", invoice)
+	return
+}
+
+// updateInvoicesAtHeight pays or expires the open invoices for a coin when a block for that coin
+// comes in, so invoices whose HTLCs came in while we weren't watching are still paid
+func (server *OpencxServer) updateInvoicesAtHeight(height uint64, coin *coinparam.Params) (err error) {
+	var store cxdb.LightningDepositStore
+	if store, err = server.invoiceStore(); err != nil {
+		err = nil
+		return
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for updateInvoicesAtHeight: %s", err)
+		return
+	}
+
+	server.invoiceMtx.Lock()
+	defer server.invoiceMtx.Unlock()
+
+	var open []*match.Invoice
+	if open, err = store.GetInvoicesByState(match.InvoiceOpen); err != nil {
+		err = fmt.Errorf("Error getting open invoices for updateInvoicesAtHeight: %s", err)
+		return
+	}
+
+	// one invoice that can't be updated shouldn't stop the others
+	var invoiceErrs []string
+	for _, invoice := range open {
+		if invoice.Asset != asset {
+			continue
+		}
+		if err = server.payInvoice(store, invoice); err != nil {
+			invoiceErrs = append(invoiceErrs, fmt.Sprintf("%s: %s", invoice.ID(), err))
+		}
+	}
+	err = nil
+
+	if len(invoiceErrs) > 0 {
+		err = fmt.Errorf("Error updating invoices for updateInvoicesAtHeight: %s", strings.Join(invoiceErrs, ", "))
+		return
+	}
+	return
+}
+
+// GetInvoices gets every invoice for a pubkey, without the preimages of invoices that haven't
+// been paid
+func (server *OpencxServer) GetInvoices(pubkey *koblitz.PublicKey) (invoices []*match.Invoice, err error) {
+	var store cxdb.LightningDepositStore
+	if store, err = server.invoiceStore(); err != nil {
+		return
+	}
+
+	var stored []*match.Invoice
+	if stored, err = store.GetInvoices(pubkey); err != nil {
+		err = fmt.Errorf("Error getting invoices for GetInvoices: %s", err)
+		return
+	}
+	for _, invoice := range stored {
+		invoices = append(invoices, invoice.Public())
+	}
+	return
+}
@@ -0,0 +1,156 @@
+package cxserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/qln"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetLightningDepositStore makes the server record lightning deposits in the store, so channel
+// events that are seen more than once are only credited once. dbLock should not be held.
+func (server *OpencxServer) SetLightningDepositStore(store cxdb.LightningDepositStore) {
+	server.dbLock.Lock()
+	server.LightningDepositStore = store
+	server.dbLock.Unlock()
+	return
+}
+
+// creditLightningDeposit credits a user for a deposit made in a channel state. The deposit is
+// recorded before the user is credited, so if the same state comes in again, from a replayed or
+// reordered event, it's ignored.
+func (server *OpencxServer) creditLightningDeposit(amount uint64, state *qln.StatCom, pubkey *koblitz.PublicKey, coinType uint32, chanIdx uint32) (err error) {
+	var param *coinparam.Params
+	if param, err = util.GetParamFromHDCoinType(coinType); err != nil {
+		err = fmt.Errorf("Error getting param from cointype for creditLightningDeposit: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	store := server.LightningDepositStore
+	server.dbLock.Unlock()
+	if store == nil {
+		if err = server.DebitUser(pubkey, amount, param); err != nil {
+			err = fmt.Errorf("Error debiting user for creditLightningDeposit: %s", err)
+			return
+		}
+		return
+	}
+
+	deposit := &match.LightningDeposit{
+		Amount:        amount,
+		ChanIdx:       chanIdx,
+		StateIdx:      state.StateIdx,
+		ChannelAmount: uint64(state.MyAmt),
+		Received:      time.Now(),
+	}
+	copy(deposit.Pubkey[:], pubkey.SerializeCompressed())
+	if deposit.Asset, err = match.AssetFromCoinParam(param); err != nil {
+		err = fmt.Errorf("Error getting asset for creditLightningDeposit: %s", err)
+		return
+	}
+
+	var qchan *qln.Qchan
+	if qchan, err = server.ExchangeNode.GetQchanByIdx(chanIdx); err != nil {
+		err = fmt.Errorf("Error getting channel for creditLightningDeposit: %s", err)
+		return
+	}
+	deposit.Outpoint = qchan.Op.String()
+
+	var added bool
+	if added, err = store.AddLightningDeposit(deposit); err != nil {
+		err = fmt.Errorf("Error recording deposit for creditLightningDeposit: %s", err)
+		return
+	}
+	if !added {
+		logging.Infof("Already credited channel %s state %d, not crediting %x again", deposit.Outpoint, deposit.StateIdx, deposit.Pubkey)
+		return
+	}
+
+	if err = server.DebitUser(pubkey, amount, param); err != nil {
+		// the deposit is recorded so it won't be credited later, reconciliation will show the
+		// channel was credited for it
+		logging.Errorf("Recorded lightning %s but could not credit it: %s", deposit, err)
+		err = fmt.Errorf("Error debiting user for creditLightningDeposit: %s", err)
+		return
+	}
+
+	logging.Infof("Credited lightning %s", deposit)
+	return
+}
+
+// ReconcileLightning compares what's been credited for deposits over every open channel for a
+// coin with what the exchange has in the channel. Channels nobody has deposited over, like the
+// ones the exchange funded, aren't reconciled.
+func (server *OpencxServer) ReconcileLightning(coin *coinparam.Params) (recs []*match.ChannelReconciliation, err error) {
+	server.dbLock.Lock()
+	store := server.LightningDepositStore
+	server.dbLock.Unlock()
+	if server.ExchangeNode == nil || store == nil {
+		err = fmt.Errorf("Lightning deposits aren't being recorded")
+		return
+	}
+
+	var channels []*qln.Qchan
+	if channels, err = server.ExchangeNode.GetAllQchans(); err != nil {
+		err = fmt.Errorf("Error getting channels for ReconcileLightning: %s", err)
+		return
+	}
+
+	for _, channel := range channels {
+		if channel.Coin() != coin.HDCoinType || channel.CloseData.Closed {
+			continue
+		}
+
+		var deposits []*match.LightningDeposit
+		if deposits, err = store.GetChannelDeposits(channel.Op.String()); err != nil {
+			err = fmt.Errorf("Error getting deposits for ReconcileLightning: %s", err)
+			return
+		}
+		if len(deposits) == 0 {
+			continue
+		}
+		recs = append(recs, match.ReconcileChannel(deposits, channel.State.StateIdx, uint64(channel.State.MyAmt), uint64(channel.Value)))
+	}
+	return
+}
+
+// StartLightningReconciler reconciles the channels for every coin once every interval, forever,
+// and logs the ones that don't reconcile.
+func (server *OpencxServer) StartLightningReconciler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			server.dbLock.Lock()
+			var coins []*coinparam.Params
+			for coin := range server.SettlementEngines {
+				coins = append(coins, coin)
+			}
+			server.dbLock.Unlock()
+
+			for _, coin := range coins {
+				recs, err := server.ReconcileLightning(coin)
+				if err != nil {
+					logging.Errorf("Error reconciling %s channels: %s", coin.Name, err)
+					continue
+				}
+				var problems int
+				for _, rec := range recs {
+					if rec.Problem != "" {
+						logging.Errorf("Lightning deposits don't reconcile for %s", rec)
+						problems++
+					}
+				}
+				logging.Infof("Reconciled %d %s channels, %d with problems", len(recs), coin.Name, problems)
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,306 @@
+package cxserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// swapLiquidity is the capacity of a user's channels a swap order is placed against, for the
+// asset the order has and the asset it wants
+type swapLiquidity struct {
+	have *match.Liquidity
+	want *match.Liquidity
+}
+
+// SetLiquidityPolicy sets when the exchange opens channels to users. dbLock should not be held.
+func (server *OpencxServer) SetLiquidityPolicy(policy *match.LiquidityPolicy) {
+	server.dbLock.Lock()
+	server.LiquidityPolicy = policy
+	server.dbLock.Unlock()
+	return
+}
+
+// userChannels gets the open channels with a user for a coin
+func (server *OpencxServer) userChannels(pubkey *koblitz.PublicKey, coin *coinparam.Params) (channels []*qln.Qchan, err error) {
+	// TODO: do something else to manage peer stuff. We need an identity key to be
+	// associated with a channel somehow, but right now we're just using peers
+	var thisPeer uint32
+	if thisPeer, err = server.GetPeerFromPubkey(pubkey); err != nil {
+		err = fmt.Errorf("Error getting peer for userChannels: %s", err)
+		return
+	}
+
+	var allChannels []*qln.Qchan
+	if allChannels, err = server.ExchangeNode.GetAllQchans(); err != nil {
+		err = fmt.Errorf("Error getting channels for userChannels: %s", err)
+		return
+	}
+
+	// go through all of the channels, skip the ones that aren't associated with this peer
+	for _, channel := range allChannels {
+
+		// This is what we're doing to say "does the pubkey match?", the
+		// pubkey that gives commands does not have to be the pubkey for the channel. While
+		// this would probably be ideal if channels already existed, they don't always exist
+		// and sometimes there are multiple channels, each with different channel pubkeys,
+		// that belong to the same user that we need to use. So for this the identity key
+		// is what we should be using.
+		if channel.Peer() != thisPeer || channel.Coin() != coin.HDCoinType || channel.CloseData.Closed || channel.State.Failed {
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	return
+}
+
+// channelSendable is how much can be sent in one HTLC over a channel, by us if outbound is true
+// and by the user otherwise. We can't send so much as to bring an output below the minoutput.
+func channelSendable(channel *qln.Qchan, outbound bool) (sendable uint64) {
+	myAmt, theirAmt := channel.GetChannelBalances()
+	amt := theirAmt
+	if outbound {
+		amt = myAmt
+	}
+	if amt <= consts.MinOutput {
+		return
+	}
+	sendable = uint64(amt - consts.MinOutput)
+	if sendable >= consts.MaxSendAmt {
+		sendable = consts.MaxSendAmt - 1
+	}
+	return
+}
+
+// channelLiquidity gets the capacity of a user's channels for a coin, without reservations
+func (server *OpencxServer) channelLiquidity(pubkey *koblitz.PublicKey, coin *coinparam.Params) (liquidity *match.Liquidity, err error) {
+	liquidity = new(match.Liquidity)
+	copy(liquidity.Pubkey[:], pubkey.SerializeCompressed())
+	if liquidity.Asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for channelLiquidity: %s", err)
+		return
+	}
+
+	var channels []*qln.Qchan
+	if channels, err = server.userChannels(pubkey, coin); err != nil {
+		err = fmt.Errorf("Error getting channels for channelLiquidity: %s", err)
+		return
+	}
+	for _, channel := range channels {
+		liquidity.Channels++
+		liquidity.Outbound += channelSendable(channel, true)
+		liquidity.Inbound += channelSendable(channel, false)
+	}
+	return
+}
+
+// GetLiquidity gets the capacity of a user's channels for every coin, and how much of it is
+// reserved for their swap orders and swaps
+func (server *OpencxServer) GetLiquidity(pubkey *koblitz.PublicKey) (liquidity []*match.Liquidity, err error) {
+	if server.ExchangeNode == nil {
+		err = fmt.Errorf("Lightning isn't set up, there are no channels")
+		return
+	}
+
+	server.dbLock.Lock()
+	var coins []*coinparam.Params
+	for coin := range server.SettlementEngines {
+		coins = append(coins, coin)
+	}
+	server.dbLock.Unlock()
+
+	for _, coin := range coins {
+		var coinLiquidity *match.Liquidity
+		if coinLiquidity, err = server.channelLiquidity(pubkey, coin); err != nil {
+			err = fmt.Errorf("Error getting liquidity for GetLiquidity: %s", err)
+			return
+		}
+
+		server.dbLock.Lock()
+		coinLiquidity.ReservedOutbound, coinLiquidity.ReservedInbound = server.liquidity.Reserved(coinLiquidity.Pubkey, coinLiquidity.Asset)
+		server.dbLock.Unlock()
+
+		if coinLiquidity.Channels == 0 && coinLiquidity.ReservedOutbound == 0 && coinLiquidity.ReservedInbound == 0 {
+			continue
+		}
+		liquidity = append(liquidity, coinLiquidity)
+	}
+	return
+}
+
+// checkSwapLiquidity makes sure the capacity a swap order is placed against covers it, on top of
+// what's already reserved. dbLock should be held.
+func (server *OpencxServer) checkSwapLiquidity(order *match.LimitOrder, liquidity *swapLiquidity) (err error) {
+	liquidity.have.ReservedOutbound, liquidity.have.ReservedInbound = server.liquidity.Reserved(liquidity.have.Pubkey, liquidity.have.Asset)
+	liquidity.want.ReservedOutbound, liquidity.want.ReservedInbound = server.liquidity.Reserved(liquidity.want.Pubkey, liquidity.want.Asset)
+
+	if err = liquidity.have.CheckInbound(order.AmountHave); err != nil {
+		return
+	}
+	if err = liquidity.want.CheckOutbound(order.AmountWant); err != nil {
+		return
+	}
+	return
+}
+
+// reserveOrder reserves capacity for what's left of a resting swap order. dbLock should be held.
+func (server *OpencxServer) reserveOrder(orderID *match.OrderID, order *match.LimitOrder) {
+	have, want := order.TradingPair.HaveWantAssets(order.Side)
+	server.liquidity.Reserve(*orderID,
+		match.LiquidityReservation{Pubkey: order.Pubkey, Asset: have, Amount: order.AmountHave},
+		match.LiquidityReservation{Pubkey: order.Pubkey, Asset: want, Outbound: true, Amount: order.AmountWant},
+	)
+	return
+}
+
+// reserveEscrow reserves capacity for an escrow until it's released. We may have to send the user
+// what the order wants and refund everything that's locked, and a pending escrow also needs room
+// for the user's HTLCs. dbLock should be held.
+func (server *OpencxServer) reserveEscrow(escrow *match.OrderEscrow) {
+	reservations := []match.LiquidityReservation{
+		{Pubkey: escrow.Pubkey, Asset: escrow.ReceiveAsset, Outbound: true, Amount: escrow.Order.AmountWant},
+		{Pubkey: escrow.Pubkey, Asset: escrow.LockAsset, Outbound: true, Amount: escrow.AmountLock},
+	}
+	if escrow.State == match.EscrowPending {
+		reservations = append(reservations, match.LiquidityReservation{Pubkey: escrow.Pubkey, Asset: escrow.LockAsset, Amount: escrow.AmountLock})
+	}
+	server.liquidity.Reserve(escrow.RHash, reservations...)
+	return
+}
+
+// releaseEscrowLiquidity releases the capacity reserved for an escrow. dbLock should not be held.
+func (server *OpencxServer) releaseEscrowLiquidity(escrow *match.OrderEscrow) {
+	server.dbLock.Lock()
+	server.liquidity.Release(escrow.RHash)
+	server.dbLock.Unlock()
+	return
+}
+
+// reserveSwap reserves capacity for a swap until it's offered. dbLock should be held.
+func (server *OpencxServer) reserveSwap(swap *match.Swap) {
+	server.liquidity.Reserve(swap.RHash,
+		match.LiquidityReservation{Pubkey: swap.Pubkey, Asset: swap.ReceiveAsset, Amount: swap.AmountReceive},
+		match.LiquidityReservation{Pubkey: swap.Pubkey, Asset: swap.SendAsset, Outbound: true, Amount: swap.AmountSend},
+	)
+	return
+}
+
+// releaseSwap releases the capacity reserved for a swap. dbLock should not be held.
+func (server *OpencxServer) releaseSwap(swap *match.Swap) {
+	server.dbLock.Lock()
+	server.liquidity.Release(swap.RHash)
+	server.dbLock.Unlock()
+	return
+}
+
+// reserveStoredSwaps reserves capacity for the swap orders on the books, the swaps that haven't
+// been offered and the escrows that haven't been released, so reservations carry on after a
+// restart. dbLock should be held.
+func (server *OpencxServer) reserveStoredSwaps() (err error) {
+	for _, book := range server.Orderbooks {
+		var orders map[float64][]*match.LimitOrderIDPair
+		if orders, err = book.ViewLimitOrderBook(); err != nil {
+			err = fmt.Errorf("Error viewing orderbook for reserveStoredSwaps: %s", err)
+			return
+		}
+		for _, priceOrders := range orders {
+			for _, order := range priceOrders {
+				// channel orders are reserved for by their escrows
+				var swapOrder bool
+				if swapOrder, err = server.isSwapOrderWithoutEscrow(order.OrderID); err != nil {
+					err = fmt.Errorf("Error checking for swap order for reserveStoredSwaps: %s", err)
+					return
+				}
+				if swapOrder {
+					server.reserveOrder(order.OrderID, order.Order)
+				}
+			}
+		}
+	}
+
+	var pending []*match.Swap
+	if pending, err = server.SwapStore.GetSwapsByState(match.SwapPending); err != nil {
+		err = fmt.Errorf("Error getting pending swaps for reserveStoredSwaps: %s", err)
+		return
+	}
+	for _, swap := range pending {
+		server.reserveSwap(swap)
+	}
+
+	for _, state := range []match.EscrowState{match.EscrowPending, match.EscrowCommitted, match.EscrowReleasing} {
+		var escrows []*match.OrderEscrow
+		if escrows, err = server.SwapStore.GetEscrowsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s escrows for reserveStoredSwaps: %s", state, err)
+			return
+		}
+		for _, escrow := range escrows {
+			server.reserveEscrow(escrow)
+		}
+	}
+	return
+}
+
+// manageLiquidityAtHeight opens channels to users whose outbound capacity for a coin doesn't
+// cover what's reserved for them plus headroom for their recent flow. It's called for every
+// block of the coin. Lit can't resize channels, so users that need more get another channel.
+func (server *OpencxServer) manageLiquidityAtHeight(height uint64, coin *coinparam.Params) (err error) {
+	if server.ExchangeNode == nil {
+		return
+	}
+
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for manageLiquidityAtHeight: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	policy := server.LiquidityPolicy
+	server.liquidity.DecayFlows(asset, policy.FlowDecay)
+	pubkeys := server.liquidity.Pubkeys(asset)
+	server.dbLock.Unlock()
+
+	// one user we can't open a channel to shouldn't stop the others
+	var openErrs []string
+	for _, pkBytes := range pubkeys {
+		var pubkey *koblitz.PublicKey
+		if pubkey, err = koblitz.ParsePubKey(pkBytes[:], koblitz.S256()); err != nil {
+			err = fmt.Errorf("Error parsing pubkey for manageLiquidityAtHeight: %s", err)
+			return
+		}
+
+		var liquidity *match.Liquidity
+		if liquidity, err = server.channelLiquidity(pubkey, coin); err != nil {
+			openErrs = append(openErrs, fmt.Sprintf("%x: %s", pkBytes, err))
+			continue
+		}
+
+		server.dbLock.Lock()
+		liquidity.ReservedOutbound, liquidity.ReservedInbound = server.liquidity.Reserved(pkBytes, asset)
+		flow := server.liquidity.Flow(pkBytes, asset)
+		server.dbLock.Unlock()
+
+		var capacity uint64
+		if capacity = policy.ChannelToOpen(liquidity, flow); capacity == 0 {
+			continue
+		}
+
+		logging.Infof("Opening %d %s channel to %x at height %d, have %s", capacity, coin.Name, pkBytes, height, liquidity)
+		if _, err = server.CreateChannel(pubkey, 0, int64(capacity), coin); err != nil {
+			openErrs = append(openErrs, fmt.Sprintf("%x: %s", pkBytes, err))
+		}
+	}
+	err = nil
+
+	if len(openErrs) > 0 {
+		err = fmt.Errorf("Error opening channels for manageLiquidityAtHeight: %s", strings.Join(openErrs, ", "))
+		return
+	}
+	return
+}
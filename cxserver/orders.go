@@ -2,10 +2,12 @@ package cxserver
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/match"
 )
 
@@ -33,6 +35,23 @@ func (server *OpencxServer) GetOrder(orderID *match.OrderID) (order *match.Limit
 // PlaceOrder places an order by first checking if we can credit the user, then calling the appropriate
 // database calls
 func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.OrderID, err error) {
+	if server.AtomicPairs[order.TradingPair] {
+		err = fmt.Errorf("Orders on %s are settled by atomic swap, place an atomic swap order instead", order.TradingPair.String())
+		return
+	}
+	return server.placeOrder(order, nil, nil)
+}
+
+// placeOrder places an order. Custodial orders are paid for out of the user's balance, swap
+// orders are paid for with HTLCs once they match, so their fills become swaps rather than
+// balance changes. Swap orders are placed against the liquidity of the user's channels, which
+// is nil for custodial orders. Channel orders are swap orders whose side is already locked in
+// the escrow, which is nil for every other order, and their fills release the escrow. Every
+// order on an atomic swap pair is an atomic swap order, whose fills become atomic swaps between
+// the users' own wallets.
+func (server *OpencxServer) placeOrder(order *match.LimitOrder, liquidity *swapLiquidity, escrow *match.OrderEscrow) (orderID *match.OrderID, err error) {
+	swap := liquidity != nil || escrow != nil
+	atomic := server.AtomicPairs[order.TradingPair]
 
 	var assetToCredit match.Asset
 	// If we are buy then we want to credit assethave
@@ -52,20 +71,7 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 		return
 	}
 
-	// make sure that putting it in the db will be an accurate and good idea because calculating prices is frustrating
-	var pr float64
-	if pr, err = order.Price(); err != nil {
-		err = fmt.Errorf("Error calculating price while Placing: %s", err)
-		return
-	}
-
-	// TODO: this is to protect the database, this is why switching to a better price system would be a good idea
-	if pr > float64(10000000000000000000000) {
-		err = fmt.Errorf("Price too high, complain online if you want the maximum price increased, or lower your price")
-		return
-	}
-	if pr < float64(1)/float64(1000000) {
-		err = fmt.Errorf("Price too low, complain online if you want the minimum price decreased, or increase your price")
+	if err = checkOrderAmounts(order); err != nil {
 		return
 	}
 
@@ -101,6 +107,27 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 		return
 	}
 
+	// A user can't have custodial and swap orders on the same pair, they would match each other
+	// without either side being settled. Atomic swap pairs only have atomic swap orders.
+	if !atomic {
+		if err = server.checkOrderKind(currOrderbook, order, swap); err != nil {
+			err = fmt.Errorf("Error placing order: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+	}
+
+	// Swap orders hold capacity on the user's channels until they're filled or cancelled, so
+	// the swaps they turn into can be settled. Capacity for channel orders is held by their
+	// escrow.
+	if liquidity != nil {
+		if err = server.checkSwapLiquidity(order, liquidity); err != nil {
+			err = fmt.Errorf("Error placing swap order: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+	}
+
 	orderCreditExec := &match.SettlementExecution{
 		Pubkey: order.Pubkey,
 		Type:   match.Credit,
@@ -110,18 +137,21 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 	// Let's hope that since they're both [33]byte their value can just be copied over through assignment
 	// copy(orderCreditExec.Pubkey[:], order.Pubkey[:])
 
-	// Okay now that we have these, check the validity
+	// Okay now that we have these, check the validity. Swap and atomic swap orders don't use the
+	// user's balance, but the engines still see the credit so it's recorded like any other order.
 	var valid bool
-	if valid, err = currSetEng.CheckValid(orderCreditExec); err != nil {
-		err = fmt.Errorf("Error checking valid settlement exec: %s", err)
-		server.dbLock.Unlock()
-		return
-	}
+	if !swap && !atomic {
+		if valid, err = currSetEng.CheckValid(orderCreditExec); err != nil {
+			err = fmt.Errorf("Error checking valid settlement exec: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
 
-	if !valid {
-		err = fmt.Errorf("Error placing order, not enough balance or you are not allowed to place orders")
-		server.dbLock.Unlock()
-		return
+		if !valid {
+			err = fmt.Errorf("Error placing order, not enough balance or you are not allowed to place orders")
+			server.dbLock.Unlock()
+			return
+		}
 	}
 
 	// Now we do these two operations. !!! IMPORTANT: THESE TWO CALLS NEED TO BE ATOMIC !!!
@@ -134,18 +164,33 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 	// if we detect a crash.
 
 	// Long story short, distributed systems are hard.
-	var settlementResults []*match.SettlementResult
-	var setRes *match.SettlementResult
-	if setRes, err = currSetEng.ApplySettlementExecution(orderCreditExec); err != nil {
-		err = fmt.Errorf("Error applying settlement execution when placing order: %s", err)
+
+	// Sequence the order and put it in the event log before anything sees it, so it gets placed
+	// at the event's time and the log has it even if something fails after this
+	event := server.newEvent(cxevent.PlaceOrderEvent)
+	if event != nil {
+		event.Order = cxevent.NewLimitOrder(order)
+	}
+	if err = server.appendEvent(event); err != nil {
+		err = fmt.Errorf("Error logging order for PlaceOrder: %s", err)
 		server.dbLock.Unlock()
 		return
 	}
 
-	settlementResults = append(settlementResults, setRes)
+	var settlementResults []*match.SettlementResult
+	var setRes *match.SettlementResult
+	if !swap && !atomic {
+		if setRes, err = currSetEng.ApplySettlementExecution(orderCreditExec); err != nil {
+			err = fmt.Errorf("Error applying settlement execution when placing order: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+
+		settlementResults = append(settlementResults, setRes)
+	}
 
 	var idRes *match.LimitOrderIDPair
-	if idRes, err = currMatchEng.PlaceLimitOrder(order); err != nil {
+	if idRes, err = server.placeLimitOrder(currMatchEng, order, event); err != nil {
 		err = fmt.Errorf("Error placing limit order for limit matching engine for PlaceOrder: %s", err)
 		server.dbLock.Unlock()
 		return
@@ -154,6 +199,26 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 	// This may not need to be atomic because we can rebuild the previous state using the messages
 	// we have, we can worry less now about things crashing but should still worry
 
+	if swap {
+		if err = server.SwapStore.AddSwapOrder(idRes.OrderID); err != nil {
+			err = fmt.Errorf("Error marking swap order for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+	}
+	if escrow != nil {
+		escrow.OrderID = *idRes.OrderID
+		escrow.SetState(match.EscrowCommitted, "", idRes.Timestamp)
+		if err = server.SwapStore.UpdateEscrow(escrow); err != nil {
+			err = fmt.Errorf("Error committing escrow for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+		server.reserveEscrow(escrow)
+	} else if swap {
+		server.reserveOrder(idRes.OrderID, order)
+	}
+
 	var orderExecs []*match.OrderExecution
 	var settlementExecs []*match.SettlementExecution
 	if orderExecs, settlementExecs, err = currMatchEng.MatchLimitOrders(); err != nil {
@@ -162,7 +227,37 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 		return
 	}
 
+	// Fills of swap orders are settled with HTLCs, fills of channel orders release their
+	// escrows, and fills on atomic swap pairs are settled between the users' own wallets, so
+	// their settlement executions aren't applied
+	var swaps []*match.Swap
+	var swapPubkeys map[[33]byte]bool
+	var escrows []*match.OrderEscrow
+	var escrowPubkeys map[[33]byte]bool
+	var partlyFilled []*match.OrderID
+	if atomic {
+		if err = server.atomicSwapsForExecs(currOrderbook, idRes, orderExecs); err != nil {
+			err = fmt.Errorf("Error creating atomic swaps for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+	} else {
+		if swaps, swapPubkeys, err = server.swapsForExecs(currOrderbook, idRes, orderExecs); err != nil {
+			err = fmt.Errorf("Error creating swaps for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+		if escrows, escrowPubkeys, partlyFilled, err = server.escrowsForExecs(currOrderbook, idRes, orderExecs); err != nil {
+			err = fmt.Errorf("Error releasing escrows for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+	}
+
 	for _, setExec := range settlementExecs {
+		if atomic || swapPubkeys[setExec.Pubkey] || escrowPubkeys[setExec.Pubkey] {
+			continue
+		}
 
 		var thisCoin *coinparam.Params
 		if thisCoin, err = setExec.Asset.CoinParamFromAsset(); err != nil {
@@ -202,6 +297,10 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 
 	// Now we don't worry any more. The matching engine and settlement engine have both responded.
 	// If we needed to we could rebuild the state.
+	if event != nil {
+		event.OrderID = idRes.OrderID
+		server.recordEvent(event, orderExecs, append([]*match.SettlementExecution{orderCreditExec}, settlementExecs...))
+	}
 
 	// update orderbook
 	if err = currOrderbook.UpdateBookPlace(idRes); err != nil {
@@ -218,6 +317,23 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 		}
 	}
 
+	// update history, the executions all happened when this order was placed
+	if server.HistoryStore != nil {
+		if err = server.HistoryStore.RecordPlace(idRes); err != nil {
+			err = fmt.Errorf("Error recording order in history for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+
+		for _, orderExec := range orderExecs {
+			if err = server.HistoryStore.RecordExec(orderExec, idRes.Timestamp); err != nil {
+				err = fmt.Errorf("Error recording execution in history for PlaceOrder: %s", err)
+				server.dbLock.Unlock()
+				return
+			}
+		}
+	}
+
 	// update what the client sees
 	if err = currSetStore.UpdateBalances(settlementResults); err != nil {
 		err = fmt.Errorf("Error updating balances with settlement results for PlaceOrder: %s", err)
@@ -225,13 +341,66 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 		return
 	}
 
+	// An escrow is released all at once, so what's left of a partly filled channel order comes
+	// off the book
+	for _, partOrderID := range partlyFilled {
+		var partOrder *match.LimitOrderIDPair
+		if partOrder, err = currOrderbook.GetOrder(partOrderID); err != nil {
+			err = fmt.Errorf("Error getting partly filled channel order for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+		if _, err = server.cancelOrder(partOrder, false); err != nil {
+			err = fmt.Errorf("Error cancelling rest of channel order for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+	}
+
 	server.dbLock.Unlock()
 
+	// Offering HTLCs talks to peers, so it's done without holding the lock. Swaps that can't be
+	// offered yet are retried every block until they time out, and so are escrows that can't be
+	// released yet.
+	if len(swaps) > 0 {
+		go server.offerSwaps(swaps)
+	}
+	if len(escrows) > 0 {
+		go server.releaseEscrows(escrows)
+	}
+
 	// Now we return thing
 	orderID = idRes.OrderID
 	return
 }
 
+// checkOrderAmounts makes sure an order's price is in range and its amounts are in whole
+// increments
+func checkOrderAmounts(order *match.LimitOrder) (err error) {
+	// make sure that putting it in the db will be an accurate and good idea because calculating prices is frustrating
+	var pr float64
+	if pr, err = order.Price(); err != nil {
+		err = fmt.Errorf("Error calculating price while Placing: %s", err)
+		return
+	}
+
+	// TODO: this is to protect the database, this is why switching to a better price system would be a good idea
+	if pr > float64(10000000000000000000000) {
+		err = fmt.Errorf("Price too high, complain online if you want the maximum price increased, or lower your price")
+		return
+	}
+	if pr < float64(1)/float64(1000000) {
+		err = fmt.Errorf("Price too low, complain online if you want the minimum price decreased, or increase your price")
+		return
+	}
+
+	if err = order.CheckIncrements(); err != nil {
+		err = fmt.Errorf("Invalid order amounts for PlaceOrder: %s", err)
+		return
+	}
+	return
+}
+
 // ViewOrderbook returns a view of the orderbook for the user
 func (server *OpencxServer) ViewOrderbook(pair *match.Pair) (book map[float64][]*match.LimitOrderIDPair, err error) {
 
@@ -281,6 +450,26 @@ func (server *OpencxServer) GetOrdersForPubkey(pubkey *koblitz.PublicKey) (order
 // CancelOrder places an order by first checking if we can credit the user, then calling the appropriate
 // database calls
 func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err error) {
+	server.dbLock.Lock()
+	var escrow *match.OrderEscrow
+	if escrow, err = server.cancelOrder(order, false); err != nil {
+		server.dbLock.Unlock()
+		return
+	}
+	server.dbLock.Unlock()
+
+	// Releasing the escrow of a channel order talks to peers, so it's done without the lock
+	if escrow != nil {
+		go server.releaseEscrows([]*match.OrderEscrow{escrow})
+	}
+	return
+}
+
+// cancelOrder takes an order off the book and gives back what's left of it. The escrow of a
+// committed channel order is returned so it can be released once the lock isn't held, escrow is
+// nil otherwise. Orders taken off because they expired are recorded in history as expired rather
+// than cancelled. dbLock should be held.
+func (server *OpencxServer) cancelOrder(order *match.LimitOrderIDPair, expired bool) (escrow *match.OrderEscrow, err error) {
 
 	var assetToDebit match.Asset
 	// If we are buy then we want to credit assethave
@@ -298,38 +487,51 @@ func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err erro
 		return
 	}
 
-	server.dbLock.Lock()
-
 	// first we need to get the settlement engine, limit engine, orderbook, and settlement store
 	var currSetEng match.SettlementEngine
 	var ok bool
 	if currSetEng, ok = server.SettlementEngines[param]; !ok {
 		err = fmt.Errorf("Could not find correct settlement engine for CancelOrder")
-		server.dbLock.Unlock()
 		return
 	}
 
 	var currMatchEng match.LimitEngine
 	if currMatchEng, ok = server.MatchingEngines[order.Order.TradingPair]; !ok {
 		err = fmt.Errorf("Could not find matching engine for trading pair for CancelOrder")
-		server.dbLock.Unlock()
 		return
 	}
 
 	var currOrderbook match.LimitOrderbook
 	if currOrderbook, ok = server.Orderbooks[order.Order.TradingPair]; !ok {
 		err = fmt.Errorf("Could not find orderbooks for trading pair for CancelOrder")
-		server.dbLock.Unlock()
 		return
 	}
 
 	var currSetStore cxdb.SettlementStore
 	if currSetStore, ok = server.SettlementStores[param]; !ok {
 		err = fmt.Errorf("Could not find settlement store for asset for CancelOrder")
-		server.dbLock.Unlock()
 		return
 	}
 
+	// Swap and atomic swap orders never took anything from the user's balance, so there's
+	// nothing to give back
+	swapOrder := server.AtomicPairs[order.Order.TradingPair]
+	if server.SwapStore != nil && !swapOrder {
+		if swapOrder, err = server.SwapStore.IsSwapOrder(order.OrderID); err != nil {
+			err = fmt.Errorf("Error checking for swap order for CancelOrder: %s", err)
+			return
+		}
+	}
+
+	// Channel orders are swap orders with an escrow
+	var orderEscrow *match.OrderEscrow
+	if swapOrder && server.SwapStore != nil {
+		if orderEscrow, err = server.SwapStore.GetOrderEscrow(order.OrderID); err != nil {
+			err = fmt.Errorf("Error getting escrow for CancelOrder: %s", err)
+			return
+		}
+	}
+
 	// Now we do these two operations. !!! IMPORTANT: THESE TWO CALLS NEED TO BE ATOMIC !!!
 	// TODO: ensure atomicity. Currently the matching engine is the one thing that must either be
 	// redundant or resistant to crashes / failure.
@@ -340,11 +542,22 @@ func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err erro
 	// if we detect a crash.
 
 	// Long story short, distributed systems are hard.
+
+	// The cancel goes in the event log before the engine sees it
+	event := server.newEvent(cxevent.CancelOrderEvent)
+	if event != nil {
+		event.Pair = &order.Order.TradingPair
+		event.OrderID = order.OrderID
+	}
+	if err = server.appendEvent(event); err != nil {
+		err = fmt.Errorf("Error logging cancel for CancelOrder: %s", err)
+		return
+	}
+
 	var cancelled *match.CancelledOrder
 	var cancelSettlement *match.SettlementExecution
 	if cancelled, cancelSettlement, err = currMatchEng.CancelLimitOrder(order.OrderID); err != nil {
 		err = fmt.Errorf("Error cancelling limit order for limit matching engine for CancelOrder: %s", err)
-		server.dbLock.Unlock()
 		return
 	}
 
@@ -354,22 +567,22 @@ func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err erro
 	var setRes *match.SettlementResult
 	var valid bool
 	for _, setExec := range settlementExecs {
+		if swapOrder {
+			continue
+		}
 
 		if valid, err = currSetEng.CheckValid(setExec); err != nil {
 			err = fmt.Errorf("Error checking valid settlement exec after match for CancelOrder: %s", err)
-			server.dbLock.Unlock()
 			return
 		}
 
 		if !valid {
 			err = fmt.Errorf("Error with matching engine output settlement validity, exec: \n%s", setExec.String())
-			server.dbLock.Unlock()
 			return
 		}
 
 		if setRes, err = currSetEng.ApplySettlementExecution(setExec); err != nil {
 			err = fmt.Errorf("Error applying settlement execution after match for CancelOrder: %s", err)
-			server.dbLock.Unlock()
 			return
 		}
 		settlementResults = append(settlementResults, setRes)
@@ -377,21 +590,46 @@ func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err erro
 
 	// Now we don't worry any more. The matching engine and settlement engine have both responded.
 	// If we needed to we could rebuild the state.
+	server.recordEvent(event, nil, settlementExecs)
+
+	if swapOrder {
+		server.liquidity.Release(*order.OrderID)
+	}
+
+	// Everything locked for an unfilled channel order goes back to the user. The escrow of a
+	// partly filled one is already being released, the rest of the order just comes off the book.
+	if orderEscrow != nil && orderEscrow.State == match.EscrowCommitted {
+		if err = server.startRelease(orderEscrow, 0, 0, time.Now()); err != nil {
+			err = fmt.Errorf("Error releasing escrow for CancelOrder: %s", err)
+			return
+		}
+		escrow = orderEscrow
+	}
 
 	// update orderbook
 	if err = currOrderbook.UpdateBookCancel(cancelled); err != nil {
 		err = fmt.Errorf("Error updating orderbook cancel for CancelOrder: %s", err)
-		server.dbLock.Unlock()
 		return
 	}
 
+	// update history
+	if server.HistoryStore != nil && expired {
+		if err = server.HistoryStore.RecordExpire(cancelled.OrderID, time.Now()); err != nil {
+			err = fmt.Errorf("Error recording expiry in history for CancelOrder: %s", err)
+			return
+		}
+	} else if server.HistoryStore != nil {
+		if err = server.HistoryStore.RecordCancel(cancelled, time.Now()); err != nil {
+			err = fmt.Errorf("Error recording cancel in history for CancelOrder: %s", err)
+			return
+		}
+	}
+
 	// update what the client sees
 	if err = currSetStore.UpdateBalances(settlementResults); err != nil {
 		err = fmt.Errorf("Error updating balances with settlement results for CancelOrder: %s", err)
-		server.dbLock.Unlock()
 		return
 	}
 
-	server.dbLock.Unlock()
 	return
 }
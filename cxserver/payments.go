@@ -0,0 +1,348 @@
+package cxserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// lightningPaymentTimeout is how long a payment the exchange started can go without the payee
+// giving a hash or without an HTLC being offered for it before the withdrawal fails
+const lightningPaymentTimeout = 10 * time.Minute
+
+// PayPaymentRequest withdraws a signed lightning withdrawal by paying the payment request in its
+// address through the exchange's lit node, so the user doesn't need a channel with the exchange.
+// The amount is taken out of the user's balance right away and the withdrawal is stored as
+// broadcast until the payment is claimed, or given back if it can't be routed or times out.
+func (server *OpencxServer) PayPaymentRequest(signed *match.Withdrawal, signature []byte, params *coinparam.Params) (withdrawal *match.WithdrawalRequest, err error) {
+	if !signed.Lightning {
+		err = fmt.Errorf("Only lightning withdrawals can pay a payment request")
+		return
+	}
+	if server.ExchangeNode == nil {
+		err = fmt.Errorf("Can't pay payment requests, the exchange doesn't have a lit node")
+		return
+	}
+
+	var request *match.PaymentRequest
+	if request, err = match.ParsePaymentRequest(signed.Address); err != nil {
+		err = fmt.Errorf("Error parsing payment request for PayPaymentRequest: %s", err)
+		return
+	}
+	if request.Asset != signed.Asset || request.Amount != signed.Amount {
+		err = fmt.Errorf("Payment request is for %d %s, the withdrawal is for %d %s", request.Amount, request.Asset, signed.Amount, signed.Asset)
+		return
+	}
+	if request.Expired(time.Now()) {
+		err = fmt.Errorf("Payment request expired at %s", request.Expiry)
+		return
+	}
+	// lit's multihop payments have the payee pick the preimage, so we can't lock to a given hash
+	if request.RHash != [32]byte{} {
+		err = fmt.Errorf("Payment request has a hash, the exchange can only pay lit nodes that pick their own preimage")
+		return
+	}
+	if _, _, err = bech32.Decode(request.Node); err != nil {
+		err = fmt.Errorf("Payment request node %s isn't a lit address: %s", request.Node, err)
+		return
+	}
+	if request.Node == server.exchangeLitAddress() {
+		err = fmt.Errorf("Payment request is for the exchange's own node")
+		return
+	}
+	if int64(signed.Amount) <= 0 {
+		err = fmt.Errorf("That amount would have caused an overflow, enter something lower")
+		return
+	}
+	if _, ok := server.ExchangeNode.SubWallet[params.HDCoinType]; !ok {
+		err = fmt.Errorf("Can't pay %s over lightning, the exchange's node doesn't have a wallet for it", params.Name)
+		return
+	}
+
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	server.dbLock.Lock()
+	currWithdrawalStore, ok := server.WithdrawalStores[params]
+	policy, hasPolicy := server.WithdrawalPolicies[params]
+	server.dbLock.Unlock()
+	if !ok {
+		err = fmt.Errorf("Could not find withdrawal store for %s for PayPaymentRequest", params.Name)
+		return
+	}
+	if !hasPolicy {
+		policy = new(match.WithdrawalPolicy)
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = server.verifyWithdrawal(currWithdrawalStore, signed, signature, params); err != nil {
+		err = fmt.Errorf("Error verifying withdrawal for PayPaymentRequest: %s", err)
+		return
+	}
+
+	now := time.Now()
+	var withdrawn uint64
+	if withdrawn, err = server.withdrawnSince(currWithdrawalStore, pubkey, now.Add(-match.WithdrawalLimitWindow)); err != nil {
+		err = fmt.Errorf("Error getting recent withdrawals for PayPaymentRequest: %s", err)
+		return
+	}
+	if !policy.WithinLimit(withdrawn, signed.Amount) {
+		err = fmt.Errorf("Withdrawing %d %s would go over the daily limit of %d, you've withdrawn %d in the last %s", signed.Amount, params.Name, policy.DailyLimit, withdrawn, match.WithdrawalLimitWindow)
+		return
+	}
+	// payments go out right away, so they can't wait for an operator
+	if policy.NeedsApproval(signed.Amount) {
+		err = fmt.Errorf("Paying %d %s needs to be approved, which lightning payments can't wait for, withdraw on-chain instead", signed.Amount, params.Name)
+		return
+	}
+
+	// hold the amount in the settlement layer until the payment is claimed
+	if err = server.CreditUser(pubkey, signed.Amount, params); err != nil {
+		err = fmt.Errorf("Error reserving withdrawal amount for PayPaymentRequest: %s", err)
+		return
+	}
+
+	// lit doesn't tell us which payment it started, so look for the one that wasn't there before
+	server.ExchangeNode.MultihopMutex.Lock()
+	before := make(map[*qln.InFlightMultihop]bool)
+	for _, inFlight := range server.ExchangeNode.InProgMultihop {
+		before[inFlight] = true
+	}
+	server.ExchangeNode.MultihopMutex.Unlock()
+
+	if _, err = server.ExchangeNode.PayMultihop(request.Node, params.HDCoinType, params.HDCoinType, int64(signed.Amount)); err != nil {
+		err = fmt.Errorf("Error paying %s for PayPaymentRequest: %s", request.Node, err)
+		if refundErr := server.debitUser(pubkey, signed.Amount, params, cxevent.WithdrawalRefundEvent); refundErr != nil {
+			logging.Errorf("Error giving back %d %s to %x after failing to pay %s: %s", signed.Amount, params.Name, pubkey.SerializeCompressed(), request.Node, refundErr)
+		}
+		return
+	}
+
+	var payment *qln.InFlightMultihop
+	server.ExchangeNode.MultihopMutex.Lock()
+	for _, inFlight := range server.ExchangeNode.InProgMultihop {
+		if !before[inFlight] && inFlight.Amt == int64(signed.Amount) && multihopDestination(inFlight) == request.Node {
+			payment = inFlight
+		}
+	}
+	server.ExchangeNode.MultihopMutex.Unlock()
+
+	withdrawal = newWithdrawalRequest(signed, signature, pubkey, match.WithdrawalBroadcast, now)
+	if err = currWithdrawalStore.AddWithdrawal(withdrawal); err != nil {
+		// the payment is already started, so all we can do is make sure someone sees this
+		logging.Errorf("Error storing lightning withdrawal %s with signature %x after paying it: %s", withdrawal, signature, err)
+		err = fmt.Errorf("Payment to %s was started but could not be stored: %s", request.Node, err)
+		return
+	}
+
+	if payment != nil {
+		if _, ok := server.lightningPayments[params]; !ok {
+			server.lightningPayments[params] = make(map[uint64]*qln.InFlightMultihop)
+		}
+		server.lightningPayments[params][withdrawal.ID] = payment
+	}
+
+	logging.Infof("Paying lightning %s", withdrawal)
+	return
+}
+
+// multihopDestination returns the lit address a multihop payment is paying
+func multihopDestination(inFlight *qln.InFlightMultihop) (litAddr string) {
+	if len(inFlight.Path) == 0 {
+		return
+	}
+	dest := inFlight.Path[len(inFlight.Path)-1]
+	litAddr = bech32.Encode("ln", dest.Node[:])
+	return
+}
+
+// lightningPayment finds the multihop payment for a lightning withdrawal. If we don't know it,
+// like after a restart, it's the payment with the withdrawal's hash, or one to the same node for
+// the same amount that no other withdrawal has. withdrawalMtx should be held.
+func (server *OpencxServer) lightningPayment(withdrawal *match.WithdrawalRequest, request *match.PaymentRequest, withdrawals []*match.WithdrawalRequest, coin *coinparam.Params) (payment *qln.InFlightMultihop) {
+	if payment = server.lightningPayments[coin][withdrawal.ID]; payment != nil {
+		return
+	}
+
+	taken := make(map[string]bool)
+	for _, other := range withdrawals {
+		if other.Txid != "" {
+			taken[other.Txid] = true
+		}
+	}
+
+	server.ExchangeNode.MultihopMutex.Lock()
+	defer server.ExchangeNode.MultihopMutex.Unlock()
+	for _, inFlight := range server.ExchangeNode.InProgMultihop {
+		hash := hex.EncodeToString(inFlight.HHash[:])
+		if withdrawal.Txid != "" {
+			if hash == withdrawal.Txid {
+				payment = inFlight
+				break
+			}
+			continue
+		}
+		if inFlight.Amt == int64(withdrawal.Amount) && multihopDestination(inFlight) == request.Node && (inFlight.HHash == [32]byte{} || !taken[hash]) {
+			payment = inFlight
+			break
+		}
+	}
+
+	if payment != nil {
+		if _, ok := server.lightningPayments[coin]; !ok {
+			server.lightningPayments[coin] = make(map[uint64]*qln.InFlightMultihop)
+		}
+		server.lightningPayments[coin][withdrawal.ID] = payment
+	}
+	return
+}
+
+// updateLightningPaymentsAtHeight confirms lightning withdrawals once their payment is claimed,
+// and fails and refunds the ones whose HTLCs timed out or that never got started
+func (server *OpencxServer) updateLightningPaymentsAtHeight(height uint64, coin *coinparam.Params) (err error) {
+	if server.ExchangeNode == nil {
+		return
+	}
+
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var store cxdb.WithdrawalStore
+	if store, err = server.withdrawalStore(coin); err != nil {
+		// nothing to update if we don't take withdrawals for this coin
+		err = nil
+		return
+	}
+
+	var broadcast []*match.WithdrawalRequest
+	if broadcast, err = store.GetWithdrawalsByState(match.WithdrawalBroadcast); err != nil {
+		err = fmt.Errorf("Error getting broadcast withdrawals for updateLightningPaymentsAtHeight: %s", err)
+		return
+	}
+
+	// one payment that can't be updated shouldn't stop the others
+	var paymentErrs []string
+	for _, withdrawal := range broadcast {
+		if !withdrawal.Lightning {
+			continue
+		}
+		if err = server.updateLightningPayment(store, withdrawal, broadcast, uint32(height), coin); err != nil {
+			paymentErrs = append(paymentErrs, fmt.Sprintf("%d: %s", withdrawal.ID, err))
+		}
+	}
+	err = nil
+
+	if len(paymentErrs) > 0 {
+		err = fmt.Errorf("Error updating lightning payments for updateLightningPaymentsAtHeight: %s", strings.Join(paymentErrs, ", "))
+		return
+	}
+	return
+}
+
+// updateLightningPayment moves a lightning withdrawal along with its payment. withdrawalMtx
+// should be held.
+func (server *OpencxServer) updateLightningPayment(store cxdb.WithdrawalStore, withdrawal *match.WithdrawalRequest, withdrawals []*match.WithdrawalRequest, height uint32, coin *coinparam.Params) (err error) {
+	var request *match.PaymentRequest
+	if request, err = match.ParsePaymentRequest(withdrawal.Address); err != nil {
+		err = server.failWithdrawal(store, withdrawal, coin, fmt.Sprintf("invalid payment request: %s", err))
+		return
+	}
+
+	timedOut := time.Since(withdrawal.Requested) > lightningPaymentTimeout
+
+	payment := server.lightningPayment(withdrawal, request, withdrawals, coin)
+	var hash [32]byte
+	var succeeded bool
+	if payment != nil {
+		server.ExchangeNode.MultihopMutex.Lock()
+		hash = payment.HHash
+		succeeded = payment.Succeeded
+		server.ExchangeNode.MultihopMutex.Unlock()
+	}
+
+	if hash == [32]byte{} {
+		if !timedOut {
+			return
+		}
+		// the payee never gave us a hash, so nothing was offered and lit shouldn't try any more
+		server.forgetLightningPayment(withdrawal, payment, coin)
+		err = server.failWithdrawal(store, withdrawal, coin, "payee didn't accept the payment")
+		return
+	}
+
+	if withdrawal.Txid == "" {
+		withdrawal.Txid = hex.EncodeToString(hash[:])
+		withdrawal.Updated = time.Now()
+		if err = store.UpdateWithdrawal(withdrawal); err != nil {
+			err = fmt.Errorf("Error recording payment hash for updateLightningPayment: %s", err)
+			return
+		}
+	}
+
+	var htlcs []match.SwapHTLC
+	if htlcs, err = server.hashHTLCs(hash); err != nil {
+		err = fmt.Errorf("Error getting HTLCs for updateLightningPayment: %s", err)
+		return
+	}
+
+	paid, refundable := match.CheckPayment(htlcs, hash, height)
+	if succeeded || paid {
+		withdrawal.SetState(match.WithdrawalConfirmed, time.Now())
+		if err = store.UpdateWithdrawal(withdrawal); err != nil {
+			err = fmt.Errorf("Error confirming withdrawal for updateLightningPayment: %s", err)
+			return
+		}
+		delete(server.lightningPayments[coin], withdrawal.ID)
+		logging.Infof("Paid lightning %s", withdrawal)
+		return
+	}
+
+	var offered bool
+	for _, htlc := range htlcs {
+		if !htlc.Incoming {
+			offered = true
+		}
+	}
+
+	switch {
+	case refundable:
+		// take back whatever timed out before giving the user their balance back
+		if _, err = server.ExchangeNode.ClaimHTLCTimeouts(coin.HDCoinType, int32(height)); err != nil {
+			err = fmt.Errorf("Error claiming HTLC timeouts for updateLightningPayment: %s", err)
+			return
+		}
+		server.forgetLightningPayment(withdrawal, payment, coin)
+		err = server.failWithdrawal(store, withdrawal, coin, "payment timed out")
+	case !offered && timedOut:
+		// lit can't be left to find a route once the user has their balance back
+		server.forgetLightningPayment(withdrawal, payment, coin)
+		err = server.failWithdrawal(store, withdrawal, coin, "no route to the payee")
+	}
+	return
+}
+
+// forgetLightningPayment stops lit from trying to send a lightning withdrawal's payment, before
+// the withdrawal is failed and refunded. withdrawalMtx should be held.
+func (server *OpencxServer) forgetLightningPayment(withdrawal *match.WithdrawalRequest, payment *qln.InFlightMultihop, coin *coinparam.Params) {
+	if payment != nil {
+		server.ExchangeNode.MultihopMutex.Lock()
+		for idx, inFlight := range server.ExchangeNode.InProgMultihop {
+			if inFlight == payment {
+				server.ExchangeNode.InProgMultihop = append(server.ExchangeNode.InProgMultihop[:idx], server.ExchangeNode.InProgMultihop[idx+1:]...)
+				break
+			}
+		}
+		server.ExchangeNode.MultihopMutex.Unlock()
+	}
+	delete(server.lightningPayments[coin], withdrawal.ID)
+}
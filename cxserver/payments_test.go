@@ -0,0 +1,154 @@
+package cxserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestLightningPaymentWithoutRouteIsForgotten(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, _, _ := createChainServer(t, dataDir)
+	priv, _ := registerDepositor(t, server, 6)
+
+	// a lit node without any channels, so the payment never gets offered
+	litDB, err := bolt.Open(filepath.Join(dataDir, "lit.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("open lit db: %v", err)
+	}
+	defer litDB.Close()
+	if err = litDB.Update(func(btx *bolt.Tx) (err error) {
+		_, err = btx.CreateBucket(qln.BKTChannelData)
+		return
+	}); err != nil {
+		t.Fatalf("create channel bucket: %v", err)
+	}
+	server.ExchangeNode = &qln.LitNode{LitDB: litDB}
+
+	asset, err := match.AssetFromCoinParam(testCoin)
+	if err != nil {
+		t.Fatalf("asset: %v", err)
+	}
+	request := &match.PaymentRequest{Node: "ln1payee", Asset: asset, Amount: 50000}
+	withdrawal := &match.WithdrawalRequest{
+		Asset:     asset,
+		Amount:    50000,
+		Address:   request.String(),
+		State:     match.WithdrawalBroadcast,
+		Requested: time.Now().Add(-2 * lightningPaymentTimeout),
+		Lightning: true,
+	}
+	copy(withdrawal.Pubkey[:], priv.PubKey().SerializeCompressed())
+	if err = server.WithdrawalStores[testCoin].AddWithdrawal(withdrawal); err != nil {
+		t.Fatalf("add withdrawal: %v", err)
+	}
+
+	// the payee gave us a hash, but lit never found a route
+	payment := &qln.InFlightMultihop{Amt: 50000, HHash: [32]byte{0x01}}
+	server.ExchangeNode.InProgMultihop = []*qln.InFlightMultihop{payment}
+	server.lightningPayments[testCoin] = map[uint64]*qln.InFlightMultihop{withdrawal.ID: payment}
+
+	if err = server.updateLightningPaymentsAtHeight(1, testCoin); err != nil {
+		t.Fatalf("update lightning payments: %v", err)
+	}
+
+	if len(server.ExchangeNode.InProgMultihop) != 0 {
+		t.Fatalf("lit should stop trying to send a refunded payment, it has %d in flight", len(server.ExchangeNode.InProgMultihop))
+	}
+	stored, err := server.WithdrawalStores[testCoin].GetWithdrawal(withdrawal.ID)
+	if err != nil {
+		t.Fatalf("get withdrawal: %v", err)
+	}
+	if stored.State != match.WithdrawalFailed {
+		t.Fatalf("withdrawal should have failed, it's %s", stored.State)
+	}
+	if balance, _ := server.GetBalance(priv.PubKey(), testCoin); balance != 50000 {
+		t.Fatalf("withdrawal should be refunded, balance is %d", balance)
+	}
+}
+
+func TestLightningWithdrawalsNeedingApprovalAreRejected(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "cxserver")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	server, _, _ := createChainServer(t, dataDir)
+	priv, _ := registerDepositor(t, server, 6)
+	server.SetWithdrawalPolicy(testCoin, &match.WithdrawalPolicy{DailyLimit: 100000, ApprovalThreshold: 40000})
+
+	// nothing should get far enough to use the node, it only needs a wallet for the coin
+	nodeKey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x05})
+	server.ExchangeNode = &qln.LitNode{
+		IdentityKey: nodeKey,
+		SubWallet:   map[uint32]qln.UWallet{testCoin.HDCoinType: nil},
+	}
+
+	asset, err := match.AssetFromCoinParam(testCoin)
+	if err != nil {
+		t.Fatalf("asset: %v", err)
+	}
+	sign := func(signed *match.Withdrawal) (signature []byte) {
+		if signature, err = koblitz.SignCompact(koblitz.S256(), priv, signed.SigHash(), false); err != nil {
+			t.Fatalf("sign withdrawal: %v", err)
+		}
+		return
+	}
+
+	overLimit := &match.Withdrawal{
+		Asset:     asset,
+		Amount:    200000,
+		Lightning: true,
+		Nonce:     1,
+		Domain:    server.GetWithdrawalDomain(),
+	}
+	if _, err = server.WithdrawLightning(overLimit, sign(overLimit), testCoin); err == nil {
+		t.Errorf("lightning withdrawal over the daily limit should be rejected")
+	}
+
+	needsApproval := &match.Withdrawal{
+		Asset:     asset,
+		Amount:    50000,
+		Lightning: true,
+		Nonce:     2,
+		Domain:    server.GetWithdrawalDomain(),
+	}
+	if _, err = server.WithdrawLightning(needsApproval, sign(needsApproval), testCoin); err == nil {
+		t.Errorf("lightning withdrawal that needs approval should be rejected")
+	}
+
+	request := &match.PaymentRequest{Node: bech32.Encode("ln", make([]byte, 20)), Asset: asset, Amount: 50000}
+	payment := &match.Withdrawal{
+		Asset:     asset,
+		Amount:    50000,
+		Address:   request.String(),
+		Lightning: true,
+		Nonce:     3,
+		Domain:    server.GetWithdrawalDomain(),
+	}
+	if _, err = server.PayPaymentRequest(payment, sign(payment), testCoin); err == nil {
+		t.Errorf("payment that needs approval should be rejected")
+	}
+
+	withdrawals, err := server.GetWithdrawals(priv.PubKey(), testCoin)
+	if err != nil {
+		t.Fatalf("get withdrawals: %v", err)
+	}
+	if len(withdrawals) != 0 {
+		t.Errorf("rejected withdrawals should not be stored, found %d", len(withdrawals))
+	}
+}
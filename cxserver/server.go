@@ -18,6 +18,7 @@ import (
 	"github.com/mit-dci/lit/wire"
 
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -29,11 +30,101 @@ type OpencxServer struct {
 	MatchingEngines   map[match.Pair]match.LimitEngine
 	Orderbooks        map[match.Pair]match.LimitOrderbook
 	DepositStores     map[*coinparam.Params]cxdb.DepositStore
+	WithdrawalStores  map[*coinparam.Params]cxdb.WithdrawalStore
 	SettlementStores  map[*coinparam.Params]cxdb.SettlementStore
+	HistoryStore      cxdb.HistoryStore
 	dbLock            *sync.Mutex
 
+	// ConfirmationPolicies is how many confirmations each coin's deposits need, coins without one
+	// use match.DefaultConfirmations
+	ConfirmationPolicies map[*coinparam.Params]*match.ConfirmationPolicy
+	// chainHeights is the height of the last block ingested for each coin, 0 if there hasn't
+	// been one yet. It's protected by dbLock.
+	chainHeights map[*coinparam.Params]uint64
+
+	// WithdrawalPolicies are the daily limits and approval thresholds for each coin's withdrawals,
+	// coins without one have no limit and don't need approval
+	WithdrawalPolicies map[*coinparam.Params]*match.WithdrawalPolicy
+	// AdminPubkey is the only key that can approve and reject withdrawals, nil if there isn't one
+	AdminPubkey *koblitz.PublicKey
+	// WithdrawalDomain is the domain signed withdrawals have to be for, so withdrawals signed for
+	// another exchange can't be used on this one
+	WithdrawalDomain string
+	// withdrawalMtx is held while withdrawals are requested or move between states, so a
+	// withdrawal can't be batched twice and a user can't go over their limit with two requests.
+	// It's acquired before dbLock.
+	withdrawalMtx *sync.Mutex
+	// feeEstimators estimate each coin's fee rates from the blocks we've ingested. It's protected
+	// by dbLock.
+	feeEstimators map[*coinparam.Params]*match.FeeEstimator
+
+	// coldWallets are the hot wallet ceiling and cold wallet for each coin, coins without one
+	// keep everything in the hot wallet. It's protected by dbLock.
+	coldWallets map[*coinparam.Params]*coldWallet
+	// lightningPayments are the lit payments for each coin's lightning withdrawals that haven't
+	// been claimed yet, by withdrawal id. It's protected by withdrawalMtx.
+	lightningPayments map[*coinparam.Params]map[uint64]*qln.InFlightMultihop
+
+	// LightningDepositStore keeps the deposits credited from lightning channels, so a channel
+	// state is only ever credited once, and the invoices users are paid through. It's nil if
+	// lightning deposits aren't recorded.
+	LightningDepositStore cxdb.LightningDepositStore
+	// invoiceMtx is held while invoices move between states, so an invoice isn't credited twice.
+	// It's acquired before dbLock.
+	invoiceMtx *sync.Mutex
+
+	// EventLog records every input to the exchange, it's nil if events aren't being recorded
+	EventLog *cxevent.EventLog
+
+	// SwapStore keeps swaps and which orders settle by swap, it's nil if swaps aren't supported
+	SwapStore cxdb.SwapStore
+	// SwapPolicy is how long our side of a swap is locked for and how much longer the user's
+	// side has to be locked for
+	SwapPolicy *match.SwapPolicy
+	// swapMtx is held while swaps move between states, so HTLCs aren't offered or claimed twice.
+	// It's acquired before dbLock.
+	swapMtx *sync.Mutex
+	// liquidity is the capacity reserved on users' channels for swap orders and swaps, and how
+	// much each user has recently needed us to send them. It's protected by dbLock.
+	liquidity *match.LiquidityLedger
+	// LiquidityPolicy decides when we open channels to users. It's protected by dbLock.
+	LiquidityPolicy *match.LiquidityPolicy
+
+	// SubmarineStore keeps submarine swaps, it's nil if submarine swaps aren't supported
+	SubmarineStore cxdb.SubmarineStore
+	// submarineMtx is held while submarine swaps move between states, so on-chain HTLCs aren't
+	// funded or spent twice. It's acquired before withdrawalMtx and dbLock.
+	submarineMtx *sync.Mutex
+
+	// AtomicSwapStore keeps atomic swaps, it's nil if atomic swap orders aren't supported
+	AtomicSwapStore cxdb.AtomicSwapStore
+	// AtomicSwapPolicy is how long each side of an atomic swap is locked for and how users who
+	// abandon atomic swaps are banned
+	AtomicSwapPolicy *match.AtomicSwapPolicy
+	// AtomicPairs are the pairs whose orders are settled by atomic swap between the users' own
+	// wallets, rather than out of their balances
+	AtomicPairs map[match.Pair]bool
+	// atomicMtx is held while atomic swaps move between states, so an atomic swap isn't moved
+	// along twice by the same block. It's acquired before dbLock.
+	atomicMtx *sync.Mutex
+
+	// tower is the exchange's watchtower, it's nil if the exchange isn't running one
+	tower *exchangeTower
+	// towerPeer is the address of another watchtower the exchange sends its channel states to,
+	// so breaches are still punished while the exchange is down. It's empty if there isn't one.
+	towerPeer string
+	// breachAlerts are the breaches the tower has seen since the exchange started
+	breachAlerts []*match.BreachAlert
+	breachMtx    *sync.Mutex
+
 	registrationString string
 	getOrdersString    string
+	getSwapsString     string
+	getLiquidityString string
+	getSubmarineString string
+	getAtomicString    string
+	getEscrowsString   string
+	getInvoicesString  string
 
 	ExchangeNode *qln.LitNode
 
@@ -59,18 +150,45 @@ type OpencxServer struct {
 }
 
 // InitServer creates a new server
-func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEngines map[match.Pair]match.LimitEngine, books map[match.Pair]match.LimitOrderbook, depositStores map[*coinparam.Params]cxdb.DepositStore, settleStores map[*coinparam.Params]cxdb.SettlementStore, rootDir string) (server *OpencxServer, err error) {
+func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEngines map[match.Pair]match.LimitEngine, books map[match.Pair]match.LimitOrderbook, depositStores map[*coinparam.Params]cxdb.DepositStore, withdrawalStores map[*coinparam.Params]cxdb.WithdrawalStore, settleStores map[*coinparam.Params]cxdb.SettlementStore, historyStore cxdb.HistoryStore, rootDir string) (server *OpencxServer, err error) {
 	server = &OpencxServer{
 		SettlementEngines: setEngines,
 		MatchingEngines:   matchEngines,
 		Orderbooks:        books,
 		DepositStores:     depositStores,
+		WithdrawalStores:  withdrawalStores,
 		SettlementStores:  settleStores,
+		HistoryStore:      historyStore,
 		dbLock:            new(sync.Mutex),
 		OpencxRoot:        rootDir,
 
+		ConfirmationPolicies: make(map[*coinparam.Params]*match.ConfirmationPolicy),
+		chainHeights:         make(map[*coinparam.Params]uint64),
+		WithdrawalPolicies:   make(map[*coinparam.Params]*match.WithdrawalPolicy),
+		WithdrawalDomain:     match.DefaultWithdrawalDomain,
+		withdrawalMtx:        new(sync.Mutex),
+		feeEstimators:        make(map[*coinparam.Params]*match.FeeEstimator),
+		coldWallets:          make(map[*coinparam.Params]*coldWallet),
+		lightningPayments:    make(map[*coinparam.Params]map[uint64]*qln.InFlightMultihop),
+		invoiceMtx:           new(sync.Mutex),
+		SwapPolicy:           match.DefaultSwapPolicy(),
+		swapMtx:              new(sync.Mutex),
+		liquidity:            match.NewLiquidityLedger(),
+		LiquidityPolicy:      match.DefaultLiquidityPolicy(),
+		submarineMtx:         new(sync.Mutex),
+		AtomicSwapPolicy:     match.DefaultAtomicSwapPolicy(),
+		AtomicPairs:          make(map[match.Pair]bool),
+		atomicMtx:            new(sync.Mutex),
+		breachMtx:            new(sync.Mutex),
+
 		registrationString: "opencx-register",
 		getOrdersString:    "opencx-getorders",
+		getSwapsString:     "opencx-getswaps",
+		getLiquidityString: "opencx-getliquidity",
+		getSubmarineString: "opencx-getsubmarineswaps",
+		getAtomicString:    "opencx-getatomicswaps",
+		getEscrowsString:   "opencx-getescrows",
+		getInvoicesString:  "opencx-getinvoices",
 		ingestMutex:        *new(sync.Mutex),
 		BlockChanMap:       make(map[int]chan *wire.MsgBlock),
 		HeightEventChanMap: make(map[int]chan lnutil.HeightEvent),
@@ -175,3 +293,131 @@ func (server *OpencxServer) GetPairs() (pairs []*match.Pair) {
 	server.dbLock.Unlock()
 	return
 }
+
+// GetSwapsString gets a string that should be signed in order to get a user's swaps
+func (server *OpencxServer) GetSwapsString() (getSwapsStr string) {
+	getSwapsStr = server.getSwapsString
+	return
+}
+
+// GetSwapsStringVerify verifies a signature for the getSwapsString
+func (server *OpencxServer) GetSwapsStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	// e = h(getSwaps)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(server.GetSwapsString()))
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying getSwaps string, invalid signature: \n%s", err)
+		return
+	}
+
+	return
+}
+
+// GetLiquidityString gets a string that should be signed in order to get a user's liquidity
+func (server *OpencxServer) GetLiquidityString() (getLiquidityStr string) {
+	getLiquidityStr = server.getLiquidityString
+	return
+}
+
+// GetLiquidityStringVerify verifies a signature for the getLiquidityString
+func (server *OpencxServer) GetLiquidityStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	// e = h(getLiquidity)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(server.GetLiquidityString()))
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying getLiquidity string, invalid signature: \n%s", err)
+		return
+	}
+
+	return
+}
+
+// GetSubmarineSwapsString gets a string that should be signed in order to get a user's submarine
+// swaps
+func (server *OpencxServer) GetSubmarineSwapsString() (getSubmarineStr string) {
+	getSubmarineStr = server.getSubmarineString
+	return
+}
+
+// GetSubmarineSwapsStringVerify verifies a signature for the getSubmarineString
+func (server *OpencxServer) GetSubmarineSwapsStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	// e = h(getSubmarineSwaps)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(server.GetSubmarineSwapsString()))
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying getSubmarineSwaps string, invalid signature: \n%s", err)
+		return
+	}
+
+	return
+}
+
+// GetAtomicSwapsString gets a string that should be signed in order to get a user's atomic swaps
+func (server *OpencxServer) GetAtomicSwapsString() (getAtomicStr string) {
+	getAtomicStr = server.getAtomicString
+	return
+}
+
+// GetAtomicSwapsStringVerify verifies a signature for the getAtomicString
+func (server *OpencxServer) GetAtomicSwapsStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	// e = h(getAtomicSwaps)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(server.GetAtomicSwapsString()))
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying getAtomicSwaps string, invalid signature: \n%s", err)
+		return
+	}
+
+	return
+}
+
+// GetEscrowsString gets a string that should be signed in order to get a user's channel order
+// escrows
+func (server *OpencxServer) GetEscrowsString() (getEscrowsStr string) {
+	getEscrowsStr = server.getEscrowsString
+	return
+}
+
+// GetEscrowsStringVerify verifies a signature for the getEscrowsString
+func (server *OpencxServer) GetEscrowsStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	// e = h(getEscrows)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(server.GetEscrowsString()))
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying getEscrows string, invalid signature: \n%s", err)
+		return
+	}
+
+	return
+}
+
+// GetInvoicesString gets a string that should be signed in order to get a user's invoices
+func (server *OpencxServer) GetInvoicesString() (getInvoicesStr string) {
+	getInvoicesStr = server.getInvoicesString
+	return
+}
+
+// GetInvoicesStringVerify verifies a signature for the getInvoicesString
+func (server *OpencxServer) GetInvoicesStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
+	// e = h(getInvoices)
+	sha3 := sha3.New256()
+	sha3.Write([]byte(server.GetInvoicesString()))
+	e := sha3.Sum(nil)
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
+		err = fmt.Errorf("Error verifying getInvoices string, invalid signature: \n%s", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,763 @@
+package cxserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wallit"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// submarineKeyUse is the use in the keypath of the exchange's keys in submarine swaps' on-chain
+// HTLCs. Each submarine swap gets its own key, picked by its hash, so nothing but the hash has to
+// be stored to sign for it.
+const submarineKeyUse = 50 | hdkeychain.HardenedKeyStart
+
+// SetSubmarineStore makes the server support submarine swaps, keeping them in the store.
+// Capacity is reserved again for the submarine swaps that haven't been offered. dbLock should not
+// be held.
+func (server *OpencxServer) SetSubmarineStore(store cxdb.SubmarineStore) (err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	server.SubmarineStore = store
+	for _, state := range []match.SubmarineState{match.SubmarineCreated, match.SubmarineFunded} {
+		var swaps []*match.SubmarineSwap
+		if swaps, err = store.GetSubmarineSwapsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s submarine swaps for SetSubmarineStore: %s", state, err)
+			return
+		}
+		for _, swap := range swaps {
+			// a swap out's capacity is used up once the user's HTLCs are there
+			if swap.Direction == match.SubmarineIn || swap.State == match.SubmarineCreated {
+				server.reserveSubmarineSwap(swap)
+			}
+		}
+	}
+	return
+}
+
+// reserveSubmarineSwap reserves the capacity a submarine swap needs on the user's channels,
+// outbound for swaps in and inbound for swaps out. dbLock should be held.
+func (server *OpencxServer) reserveSubmarineSwap(swap *match.SubmarineSwap) {
+	server.liquidity.Reserve(swap.RHash,
+		match.LiquidityReservation{Pubkey: swap.Pubkey, Asset: swap.Asset, Outbound: swap.Direction == match.SubmarineIn, Amount: swap.Amount},
+	)
+	return
+}
+
+// releaseSubmarineSwap releases the capacity reserved for a submarine swap. dbLock should not be
+// held.
+func (server *OpencxServer) releaseSubmarineSwap(swap *match.SubmarineSwap) {
+	server.dbLock.Lock()
+	server.liquidity.Release(swap.RHash)
+	server.dbLock.Unlock()
+	return
+}
+
+// submarineWallet gets the wallet for a submarine swap's coin, and the exchange's key in its
+// on-chain HTLC
+func (server *OpencxServer) submarineWallet(rhash [32]byte, coin *coinparam.Params) (wallet *wallit.Wallit, priv *koblitz.PrivateKey, err error) {
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s", coin.Name)
+		return
+	}
+	priv = wallet.GetUsePriv(wallit.GetWalletKeygen(binary.BigEndian.Uint32(rhash[:4]), coin.HDCoinType), submarineKeyUse)
+	return
+}
+
+// CreateSubmarineSwap creates a submarine swap for a signed request, and returns it along with
+// the address of its on-chain HTLC. For a swap in the user funds the address, for a swap out the
+// user offers HTLCs locked to the hash and the exchange funds the address.
+func (server *OpencxServer) CreateSubmarineSwap(request *match.SubmarineRequest, signature []byte) (swap *match.SubmarineSwap, address string, err error) {
+	if server.ExchangeNode == nil || server.SubmarineStore == nil {
+		err = fmt.Errorf("Submarine swaps aren't supported, lightning isn't set up")
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = request.RecoverPubkey(signature); err != nil {
+		err = fmt.Errorf("Error verifying submarine swap request for CreateSubmarineSwap: %s", err)
+		return
+	}
+	var pkBytes [33]byte
+	copy(pkBytes[:], pubkey.SerializeCompressed())
+
+	var coin *coinparam.Params
+	if coin, err = request.Asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin for CreateSubmarineSwap: %s", err)
+		return
+	}
+	var info *match.AssetInfo
+	if info, err = match.AssetInfoFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset info for CreateSubmarineSwap: %s", err)
+		return
+	}
+
+	// the on-chain side is a deposit for swaps in and a withdrawal for swaps out
+	minimum := info.MinDeposit
+	if request.Direction == match.SubmarineOut {
+		minimum = info.MinWithdrawal
+	}
+	if minimum < dustLimit {
+		minimum = dustLimit
+	}
+	if request.Amount < minimum {
+		err = fmt.Errorf("Submarine swaps of %s have to be at least %d", coin.Name, minimum)
+		return
+	}
+
+	var exchangePriv *koblitz.PrivateKey
+	if _, exchangePriv, err = server.submarineWallet(request.RHash, coin); err != nil {
+		err = fmt.Errorf("Error getting exchange key for CreateSubmarineSwap: %s", err)
+		return
+	}
+	var exchangeKey [33]byte
+	copy(exchangeKey[:], exchangePriv.PubKey().SerializeCompressed())
+
+	// the capacity is checked against what's already reserved once the lock is held
+	var liquidity *match.Liquidity
+	if liquidity, err = server.channelLiquidity(pubkey, coin); err != nil {
+		err = fmt.Errorf("Error getting your %s channels for CreateSubmarineSwap: %s", coin.Name, err)
+		return
+	}
+
+	server.submarineMtx.Lock()
+	defer server.submarineMtx.Unlock()
+
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	// the locktimes are heights, so we have to know where the chain is
+	height := server.chainHeights[coin]
+	if height == 0 {
+		err = fmt.Errorf("Can't create %s submarine swaps yet, still syncing", coin.Name)
+		return
+	}
+
+	liquidity.ReservedOutbound, liquidity.ReservedInbound = server.liquidity.Reserved(pkBytes, request.Asset)
+	if request.Direction == match.SubmarineIn {
+		err = liquidity.CheckOutbound(request.Amount)
+	} else {
+		err = liquidity.CheckInbound(request.Amount)
+	}
+	if err != nil {
+		return
+	}
+
+	if swap, err = match.NewSubmarineSwap(request, pkBytes, exchangeKey, uint32(height), server.SwapPolicy, time.Now()); err != nil {
+		err = fmt.Errorf("Error creating submarine swap for CreateSubmarineSwap: %s", err)
+		return
+	}
+
+	var pkScript []byte
+	if pkScript, err = swap.PkScript(); err != nil {
+		err = fmt.Errorf("Error getting on-chain HTLC script for CreateSubmarineSwap: %s", err)
+		return
+	}
+	if address, err = util.ScriptAddress(pkScript, coin); err != nil {
+		err = fmt.Errorf("Error getting on-chain HTLC address for CreateSubmarineSwap: %s", err)
+		return
+	}
+
+	if err = server.SubmarineStore.AddSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error storing submarine swap for CreateSubmarineSwap: %s", err)
+		return
+	}
+	server.reserveSubmarineSwap(swap)
+	logging.Infof("Created %s, on-chain HTLC %s", swap, address)
+	return
+}
+
+// activeSubmarineSwaps gets the submarine swaps for a coin that aren't in a final state.
+// submarineMtx should be held.
+func (server *OpencxServer) activeSubmarineSwaps(coin *coinparam.Params) (swaps []*match.SubmarineSwap, err error) {
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for activeSubmarineSwaps: %s", err)
+		return
+	}
+	for _, state := range []match.SubmarineState{match.SubmarineCreated, match.SubmarineFunded, match.SubmarineOffered, match.SubmarineClaimed} {
+		var stateSwaps []*match.SubmarineSwap
+		if stateSwaps, err = server.SubmarineStore.GetSubmarineSwapsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s submarine swaps for activeSubmarineSwaps: %s", state, err)
+			return
+		}
+		for _, swap := range stateSwaps {
+			if swap.Asset == asset {
+				swaps = append(swaps, swap)
+			}
+		}
+	}
+	return
+}
+
+// ingestSubmarineTransactions records the transactions in a block that fund or spend submarine
+// swaps' on-chain HTLCs, and moves the swaps along
+func (server *OpencxServer) ingestSubmarineTransactions(txList []*wire.MsgTx, height uint64, coin *coinparam.Params) (err error) {
+	if server.SubmarineStore == nil {
+		return
+	}
+
+	server.submarineMtx.Lock()
+	defer server.submarineMtx.Unlock()
+
+	var swaps []*match.SubmarineSwap
+	if swaps, err = server.activeSubmarineSwaps(coin); err != nil {
+		err = fmt.Errorf("Error getting submarine swaps for ingestSubmarineTransactions: %s", err)
+		return
+	}
+
+	// one swap that can't be updated shouldn't stop the others
+	var swapErrs []string
+	for _, swap := range swaps {
+		for _, tx := range txList {
+			if err = server.ingestSubmarineTransaction(swap, tx, height); err != nil {
+				swapErrs = append(swapErrs, fmt.Sprintf("%s: %s", swap.ID(), err))
+				break
+			}
+		}
+	}
+	err = nil
+
+	if len(swapErrs) > 0 {
+		err = fmt.Errorf("Error ingesting submarine swap transactions for ingestSubmarineTransactions: %s", strings.Join(swapErrs, ", "))
+		return
+	}
+	return
+}
+
+// ingestSubmarineTransaction checks whether a transaction in a block at height funds or spends
+// a submarine swap's on-chain HTLC. submarineMtx should be held.
+func (server *OpencxServer) ingestSubmarineTransaction(swap *match.SubmarineSwap, tx *wire.MsgTx, height uint64) (err error) {
+	txid := tx.TxHash().String()
+
+	var index uint32
+	var found bool
+	if index, found, err = swap.FundingOutput(tx); err != nil {
+		return
+	}
+	if found && swap.FundHeight == 0 {
+		amount := uint64(tx.TxOut[index].Value)
+		if swap.Direction == match.SubmarineIn && swap.State == match.SubmarineCreated {
+			// the user can take back what they sent once the on-chain HTLC times out
+			if amount < swap.Amount {
+				logging.Warnf("%s funded with %d in %s, not %d, waiting for more", swap, amount, txid, swap.Amount)
+				return
+			}
+			swap.Outpoint = fmt.Sprintf("%s:%d", txid, index)
+			swap.FundAmount = amount
+			swap.SetState(match.SubmarineFunded, "", time.Now())
+		}
+		if swap.Outpoint == fmt.Sprintf("%s:%d", txid, index) {
+			swap.FundHeight = height
+			if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+				err = fmt.Errorf("Error updating funded submarine swap: %s", err)
+				return
+			}
+			logging.Infof("On-chain HTLC for %s confirmed at height %d", swap, height)
+		}
+		return
+	}
+
+	var input int
+	if input, found = swap.SpendingInput(tx); !found {
+		return
+	}
+	preimage, claimed := swap.PreimageFromWitness(tx.TxIn[input].Witness)
+	swap.SpendTxid = txid
+	swap.SpendHeight = height
+
+	if swap.Direction == match.SubmarineOut {
+		if claimed {
+			// the user claimed on chain, so now we can claim their HTLCs
+			swap.Preimage = preimage
+			swap.SetState(match.SubmarineClaimed, "", time.Now())
+			if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+				err = fmt.Errorf("Error updating claimed submarine swap: %s", err)
+				return
+			}
+			logging.Infof("User claimed %s on chain in %s", swap, txid)
+			return server.claimSubmarineHTLCs(swap)
+		}
+		swap.SetState(match.SubmarineRefunded, "the user never claimed the on-chain HTLC", time.Now())
+	} else {
+		switch {
+		case claimed:
+			swap.SetState(match.SubmarineCompleted, "", time.Now())
+		case swap.State == match.SubmarineOffered || swap.State == match.SubmarineClaimed:
+			// the user could only do this if we were too slow to claim on chain
+			logging.Errorf("User refunded %s on chain after we offered our HTLCs", swap)
+			swap.SetState(match.SubmarineRefunded, "the user took back the on-chain HTLC", time.Now())
+		default:
+			swap.SetState(match.SubmarineExpired, "the user took back the on-chain HTLC", time.Now())
+		}
+	}
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating spent submarine swap: %s", err)
+		return
+	}
+	if swap.State == match.SubmarineExpired {
+		server.releaseSubmarineSwap(swap)
+	}
+	logging.Infof("On-chain HTLC for %s spent in %s", swap, txid)
+	return
+}
+
+// updateSubmarineSwaps updates the submarine swaps for HTLC hashes, hashes that aren't for
+// submarine swaps are ignored
+func (server *OpencxServer) updateSubmarineSwaps(hashes [][32]byte) {
+	if server.SubmarineStore == nil {
+		return
+	}
+
+	server.submarineMtx.Lock()
+	defer server.submarineMtx.Unlock()
+
+	seen := make(map[[32]byte]bool)
+	for _, rhash := range hashes {
+		if seen[rhash] {
+			continue
+		}
+		seen[rhash] = true
+
+		swap, err := server.SubmarineStore.GetSubmarineSwap(rhash)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		if err = server.updateSubmarineSwap(swap); err != nil {
+			logging.Errorf("Error updating %s: %s", swap, err)
+		}
+	}
+	return
+}
+
+// updateSubmarineSwap moves a submarine swap along from its lightning HTLCs. A swap out is
+// funded once the user's HTLCs are all there, and a swap in is claimed once the user has
+// claimed our HTLCs. submarineMtx should be held.
+func (server *OpencxServer) updateSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	inOffered := swap.Direction == match.SubmarineIn && swap.State == match.SubmarineOffered
+	outCreated := swap.Direction == match.SubmarineOut && swap.State == match.SubmarineCreated
+	if !inOffered && !outCreated {
+		return
+	}
+
+	var htlcs []match.SwapHTLC
+	if htlcs, err = server.hashHTLCs(swap.RHash); err != nil {
+		err = fmt.Errorf("Error getting HTLCs for updateSubmarineSwap: %s", err)
+		return
+	}
+
+	if outCreated {
+		if swap.CheckHTLCs(htlcs) != nil {
+			return
+		}
+		swap.SetState(match.SubmarineFunded, "", time.Now())
+		if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+			err = fmt.Errorf("Error updating funded submarine swap for updateSubmarineSwap: %s", err)
+			return
+		}
+		server.releaseSubmarineSwap(swap)
+		logging.Infof("User's HTLCs for %s are there", swap)
+		return server.fundSubmarineSwap(swap)
+	}
+
+	preimage, claimed := swap.ClaimedPreimage(htlcs)
+	if !claimed {
+		return
+	}
+	swap.Preimage = preimage
+	swap.SetState(match.SubmarineClaimed, "", time.Now())
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating claimed submarine swap for updateSubmarineSwap: %s", err)
+		return
+	}
+	logging.Infof("User claimed our HTLCs for %s", swap)
+	return server.spendSubmarineSwap(swap)
+}
+
+// updateSubmarineSwapsAtHeight moves along submarine swaps for a coin when a block for that coin
+// comes in, locking our side, claiming or refunding as the locktimes come up
+func (server *OpencxServer) updateSubmarineSwapsAtHeight(height uint64, coin *coinparam.Params) (err error) {
+	if server.SubmarineStore == nil || server.ExchangeNode == nil {
+		return
+	}
+
+	server.submarineMtx.Lock()
+	defer server.submarineMtx.Unlock()
+
+	var swaps []*match.SubmarineSwap
+	if swaps, err = server.activeSubmarineSwaps(coin); err != nil {
+		err = fmt.Errorf("Error getting submarine swaps for updateSubmarineSwapsAtHeight: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	policy, ok := server.ConfirmationPolicies[coin]
+	server.dbLock.Unlock()
+	if !ok {
+		policy = &match.ConfirmationPolicy{Confirmations: match.DefaultConfirmations}
+	}
+
+	// one swap that can't be updated shouldn't stop the others
+	var swapErrs []string
+	for _, swap := range swaps {
+		if err = server.updateSubmarineSwapAtHeight(swap, height, policy, coin); err != nil {
+			swapErrs = append(swapErrs, fmt.Sprintf("%s: %s", swap.ID(), err))
+		}
+	}
+	err = nil
+
+	if len(swapErrs) > 0 {
+		err = fmt.Errorf("Error updating submarine swaps for updateSubmarineSwapsAtHeight: %s", strings.Join(swapErrs, ", "))
+		return
+	}
+	return
+}
+
+// updateSubmarineSwapAtHeight locks our side, claims, refunds or expires a single submarine swap
+// at height. submarineMtx should be held.
+func (server *OpencxServer) updateSubmarineSwapAtHeight(swap *match.SubmarineSwap, height uint64, policy *match.ConfirmationPolicy, coin *coinparam.Params) (err error) {
+	// Something might have changed that there was no event for, like HTLCs clearing on chain
+	if err = server.updateSubmarineSwap(swap); err != nil {
+		return
+	}
+
+	canLock := swap.CanLock(uint32(height), server.SwapPolicy)
+	switch {
+	case swap.State == match.SubmarineCreated && !canLock:
+		reason := "the on-chain HTLC wasn't funded in time"
+		if swap.Direction == match.SubmarineOut {
+			reason = "the user's HTLCs didn't arrive in time"
+		}
+		return server.expireSubmarineSwap(swap, reason)
+
+	case swap.State == match.SubmarineFunded && swap.Direction == match.SubmarineIn:
+		// We don't lock anything until the user's side is buried
+		if height+1-swap.FundHeight < policy.ConfirmationsFor(swap.FundAmount) {
+			if !canLock {
+				return server.expireSubmarineSwap(swap, "the on-chain HTLC didn't confirm in time")
+			}
+			return
+		}
+		return server.offerSubmarineHTLCs(swap, height)
+
+	case swap.State == match.SubmarineFunded:
+		// Their HTLCs time out back to them if we don't lock the on-chain side
+		if !canLock {
+			return server.expireSubmarineSwap(swap, "the on-chain HTLC couldn't be funded in time")
+		}
+		return server.fundSubmarineSwap(swap)
+
+	case swap.State == match.SubmarineOffered && swap.Direction == match.SubmarineIn:
+		if uint32(height) < swap.ChannelLocktime {
+			return
+		}
+		if _, err = server.ExchangeNode.ClaimHTLCTimeouts(coin.HDCoinType, int32(height)); err != nil {
+			err = fmt.Errorf("Error claiming HTLC timeouts for updateSubmarineSwapAtHeight: %s", err)
+			return
+		}
+		swap.SetState(match.SubmarineRefunded, "the user never claimed our HTLCs", time.Now())
+		if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+			err = fmt.Errorf("Error updating refunded submarine swap for updateSubmarineSwapAtHeight: %s", err)
+			return
+		}
+		logging.Infof("Refunded %s", swap)
+
+	case swap.State == match.SubmarineOffered:
+		// the refund is marked once it confirms, the user can still claim until then
+		if uint32(height) < swap.ChainLocktime || swap.SpendTxid != "" {
+			return
+		}
+		return server.spendSubmarineSwap(swap)
+
+	case swap.State == match.SubmarineClaimed && swap.Direction == match.SubmarineIn:
+		if swap.SpendTxid != "" {
+			return
+		}
+		return server.spendSubmarineSwap(swap)
+
+	case swap.State == match.SubmarineClaimed:
+		return server.claimSubmarineHTLCs(swap)
+	}
+	return
+}
+
+// expireSubmarineSwap expires a submarine swap we never locked our side of. submarineMtx should
+// be held.
+func (server *OpencxServer) expireSubmarineSwap(swap *match.SubmarineSwap, reason string) (err error) {
+	swap.SetState(match.SubmarineExpired, reason, time.Now())
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating expired submarine swap for expireSubmarineSwap: %s", err)
+		return
+	}
+	server.releaseSubmarineSwap(swap)
+	logging.Infof("Expired %s", swap)
+	return
+}
+
+// offerSubmarineHTLCs offers our HTLCs for a funded swap in and moves it to offered. They time
+// out policy.Timeout blocks after height. submarineMtx should be held.
+func (server *OpencxServer) offerSubmarineHTLCs(swap *match.SubmarineSwap, height uint64) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(swap.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for offerSubmarineHTLCs: %s", err)
+		return
+	}
+	var coin *coinparam.Params
+	if coin, err = swap.Asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin for offerSubmarineHTLCs: %s", err)
+		return
+	}
+
+	// The locktime is only picked once, HTLCs that were offered before a failure keep theirs
+	if swap.ChannelLocktime == 0 {
+		if !swap.CanLock(uint32(height), server.SwapPolicy) {
+			return server.expireSubmarineSwap(swap, "the on-chain HTLC didn't confirm in time")
+		}
+		swap.ChannelLocktime = uint32(height) + server.SwapPolicy.Timeout
+		if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+			err = fmt.Errorf("Error saving HTLC locktime for offerSubmarineHTLCs: %s", err)
+			return
+		}
+	}
+
+	if err = server.offerHTLCs(pubkey, coin, swap.RHash, swap.Amount, swap.ChannelLocktime); err != nil {
+		err = fmt.Errorf("Error offering HTLCs for offerSubmarineHTLCs: %s", err)
+		return
+	}
+	swap.SetState(match.SubmarineOffered, "", time.Now())
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating offered submarine swap for offerSubmarineHTLCs: %s", err)
+		return
+	}
+	server.releaseSubmarineSwap(swap)
+	server.dbLock.Lock()
+	server.liquidity.RecordFlow(swap.Pubkey, swap.Asset, swap.Amount)
+	server.dbLock.Unlock()
+	logging.Infof("Offered %s", swap)
+	return
+}
+
+// fundSubmarineSwap pays a funded swap out's on-chain HTLC from the hot wallet and moves it to
+// offered. submarineMtx should be held.
+func (server *OpencxServer) fundSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	var coin *coinparam.Params
+	if coin, err = swap.Asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin for fundSubmarineSwap: %s", err)
+		return
+	}
+	var pkScript []byte
+	if pkScript, err = swap.PkScript(); err != nil {
+		err = fmt.Errorf("Error getting on-chain HTLC script for fundSubmarineSwap: %s", err)
+		return
+	}
+
+	// the hot wallet is spent from while this is held
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var wallet *wallit.Wallit
+	if wallet, _, err = server.submarineWallet(swap.RHash, coin); err != nil {
+		err = fmt.Errorf("Error getting wallet for fundSubmarineSwap: %s", err)
+		return
+	}
+
+	var feeRate int64
+	if feeRate, err = server.feeRate(coin, match.FeePriorityNormal); err != nil {
+		err = fmt.Errorf("Error getting fee rate for fundSubmarineSwap: %s", err)
+		return
+	}
+
+	htlcOut := wire.NewTxOut(int64(swap.Amount), pkScript)
+	var utxoSlice portxo.TxoSliceByBip69
+	var overshoot int64
+	if utxoSlice, overshoot, err = wallet.PickUtxos(htlcOut.Value, int64(htlcOut.SerializeSize()), feeRate, false); err != nil {
+		err = fmt.Errorf("Error picking utxos for fundSubmarineSwap: %s", err)
+		return
+	}
+
+	var changeOut *wire.TxOut
+	if changeOut, err = wallet.NewChangeOut(overshoot); err != nil {
+		err = fmt.Errorf("Error creating change output for fundSubmarineSwap: %s", err)
+		return
+	}
+
+	var fundTx *wire.MsgTx
+	if fundTx, err = wallet.BuildAndSign(utxoSlice, []*wire.TxOut{htlcOut, changeOut}, 0); err != nil {
+		err = fmt.Errorf("Error building funding transaction for fundSubmarineSwap: %s", err)
+		return
+	}
+
+	// the outputs may have been sorted
+	var index uint32
+	var found bool
+	if index, found, err = swap.FundingOutput(fundTx); err != nil || !found {
+		err = fmt.Errorf("Funding transaction for submarine swap %s doesn't pay its on-chain HTLC: %v", swap.ID(), err)
+		return
+	}
+
+	if err = wallet.NewOutgoingTx(fundTx); err != nil {
+		err = fmt.Errorf("Error sending funding transaction for fundSubmarineSwap: %s", err)
+		return
+	}
+	swap.Outpoint = fmt.Sprintf("%s:%d", fundTx.TxHash().String(), index)
+	swap.FundAmount = swap.Amount
+	swap.SetState(match.SubmarineOffered, "", time.Now())
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating offered submarine swap for fundSubmarineSwap: %s", err)
+		return
+	}
+	logging.Infof("Offered %s on chain in %s", swap, swap.Outpoint)
+	return
+}
+
+// spendSubmarineSwap spends a submarine swap's on-chain HTLC back to the hot wallet, claiming
+// it with the preimage for a claimed swap in and refunding it for a swap out. The swap is marked
+// completed or refunded once the spend confirms. submarineMtx should be held.
+func (server *OpencxServer) spendSubmarineSwap(swap *match.SubmarineSwap) (err error) {
+	var coin *coinparam.Params
+	if coin, err = swap.Asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin for spendSubmarineSwap: %s", err)
+		return
+	}
+
+	var wallet *wallit.Wallit
+	var priv *koblitz.PrivateKey
+	if wallet, priv, err = server.submarineWallet(swap.RHash, coin); err != nil {
+		err = fmt.Errorf("Error getting exchange key for spendSubmarineSwap: %s", err)
+		return
+	}
+
+	var feeRate int64
+	if feeRate, err = server.feeRate(coin, match.FeePriorityNormal); err != nil {
+		err = fmt.Errorf("Error getting fee rate for spendSubmarineSwap: %s", err)
+		return
+	}
+
+	// the output is just somewhere in the wallet to send to
+	var walletOut *wire.TxOut
+	if walletOut, err = wallet.NewChangeOut(0); err != nil {
+		err = fmt.Errorf("Error getting wallet address for spendSubmarineSwap: %s", err)
+		return
+	}
+
+	var preimage *[16]byte
+	if swap.Direction == match.SubmarineIn {
+		preimage = &swap.Preimage
+	}
+	var spendTx *wire.MsgTx
+	if spendTx, err = swap.SpendTx(walletOut.PkScript, uint64(feeRate)*match.SubmarineSpendSize, priv, preimage); err != nil {
+		err = fmt.Errorf("Error creating spend for spendSubmarineSwap: %s", err)
+		return
+	}
+	if err = wallet.NewOutgoingTx(spendTx); err != nil {
+		err = fmt.Errorf("Error sending spend for spendSubmarineSwap: %s", err)
+		return
+	}
+
+	swap.SpendTxid = spendTx.TxHash().String()
+	swap.Updated = time.Now()
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating spent submarine swap for spendSubmarineSwap: %s", err)
+		return
+	}
+	logging.Infof("Spent on-chain HTLC for %s in %s", swap, swap.SpendTxid)
+	return
+}
+
+// claimSubmarineHTLCs claims the user's HTLCs for a claimed swap out and completes it.
+// submarineMtx should be held.
+func (server *OpencxServer) claimSubmarineHTLCs(swap *match.SubmarineSwap) (err error) {
+	if _, err = server.ExchangeNode.ClaimHTLC(swap.Preimage); err != nil {
+		err = fmt.Errorf("Error claiming HTLCs for claimSubmarineHTLCs: %s", err)
+		return
+	}
+	swap.SetState(match.SubmarineCompleted, "", time.Now())
+	if err = server.SubmarineStore.UpdateSubmarineSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating completed submarine swap for claimSubmarineHTLCs: %s", err)
+		return
+	}
+	logging.Infof("Completed %s", swap)
+	return
+}
+
+// SubmitSubmarineSpend sends out a transaction the user signed that claims a swap out's on-chain
+// HTLC or refunds a swap in's, for users that don't have their own node. It returns the txid.
+func (server *OpencxServer) SubmitSubmarineSpend(coin *coinparam.Params, signedTx []byte) (txid string, err error) {
+	if server.SubmarineStore == nil {
+		err = fmt.Errorf("Submarine swaps aren't supported")
+		return
+	}
+
+	tx := wire.NewMsgTx()
+	if err = tx.Deserialize(bytes.NewReader(signedTx)); err != nil {
+		err = fmt.Errorf("Error deserializing spend for SubmitSubmarineSpend: %s", err)
+		return
+	}
+
+	server.submarineMtx.Lock()
+	defer server.submarineMtx.Unlock()
+
+	var swaps []*match.SubmarineSwap
+	if swaps, err = server.activeSubmarineSwaps(coin); err != nil {
+		err = fmt.Errorf("Error getting submarine swaps for SubmitSubmarineSpend: %s", err)
+		return
+	}
+
+	// only spends of submarine swaps get sent, anything else would make us a relay
+	for _, swap := range swaps {
+		input, found := swap.SpendingInput(tx)
+		if !found {
+			continue
+		}
+		if err = swap.VerifySpend(tx, input); err != nil {
+			err = fmt.Errorf("Error verifying spend for SubmitSubmarineSpend: %s", err)
+			return
+		}
+
+		var wallet *wallit.Wallit
+		if wallet, _, err = server.submarineWallet(swap.RHash, coin); err != nil {
+			err = fmt.Errorf("Error getting wallet for SubmitSubmarineSpend: %s", err)
+			return
+		}
+		if err = wallet.DirectSendTx(tx); err != nil {
+			err = fmt.Errorf("Error sending spend for SubmitSubmarineSpend: %s", err)
+			return
+		}
+		txid = tx.TxHash().String()
+		logging.Infof("Sent user's spend of %s in %s", swap, txid)
+		return
+	}
+	err = fmt.Errorf("Transaction %s doesn't spend an active %s submarine swap", tx.TxHash().String(), coin.Name)
+	return
+}
+
+// GetSubmarineSwaps gets every submarine swap for a pubkey
+func (server *OpencxServer) GetSubmarineSwaps(pubkey *koblitz.PublicKey) (swaps []*match.SubmarineSwap, err error) {
+	if server.SubmarineStore == nil {
+		err = fmt.Errorf("Submarine swaps aren't supported")
+		return
+	}
+
+	if swaps, err = server.SubmarineStore.GetSubmarineSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting submarine swaps for GetSubmarineSwaps: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,472 @@
+package cxserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetSwapStore makes the server support swap orders, keeping swaps in the store. Capacity is
+// reserved again for the swap orders on the books and the swaps that haven't been offered.
+// dbLock should not be held.
+func (server *OpencxServer) SetSwapStore(store cxdb.SwapStore) (err error) {
+	server.dbLock.Lock()
+	server.SwapStore = store
+	if err = server.reserveStoredSwaps(); err != nil {
+		err = fmt.Errorf("Error reserving liquidity for SetSwapStore: %s", err)
+		server.dbLock.Unlock()
+		return
+	}
+	server.dbLock.Unlock()
+	return
+}
+
+// PlaceSwapOrder places an order that's settled with HTLCs over the user's lightning channels
+// rather than out of their balance. The user needs to be able to send what the order has, and we
+// need to be able to send them what it wants, when it's placed.
+func (server *OpencxServer) PlaceSwapOrder(order *match.LimitOrder) (orderID *match.OrderID, err error) {
+	if server.ExchangeNode == nil || server.SwapStore == nil {
+		err = fmt.Errorf("Swap orders aren't supported, lightning isn't set up")
+		return
+	}
+	if server.AtomicPairs[order.TradingPair] {
+		err = fmt.Errorf("Orders on %s are settled by atomic swap, place an atomic swap order instead", order.TradingPair.String())
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(order.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for PlaceSwapOrder: %s", err)
+		return
+	}
+
+	have, want := order.TradingPair.HaveWantAssets(order.Side)
+	var haveCoin, wantCoin *coinparam.Params
+	if haveCoin, err = have.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin the order has for PlaceSwapOrder: %s", err)
+		return
+	}
+	if wantCoin, err = want.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin the order wants for PlaceSwapOrder: %s", err)
+		return
+	}
+
+	// HTLC locktimes are heights, so we have to know where both chains are
+	server.dbLock.Lock()
+	haveHeight, wantHeight := server.chainHeights[haveCoin], server.chainHeights[wantCoin]
+	server.dbLock.Unlock()
+	if haveHeight == 0 || wantHeight == 0 {
+		err = fmt.Errorf("Can't place swap orders on %s yet, still syncing", order.TradingPair.String())
+		return
+	}
+
+	// the capacity is checked against what's already reserved once the lock is held
+	liquidity := new(swapLiquidity)
+	if liquidity.have, err = server.channelLiquidity(pubkey, haveCoin); err != nil {
+		err = fmt.Errorf("Error getting your %s channels for PlaceSwapOrder: %s", haveCoin.Name, err)
+		return
+	}
+	if liquidity.want, err = server.channelLiquidity(pubkey, wantCoin); err != nil {
+		err = fmt.Errorf("Error getting your %s channels for PlaceSwapOrder: %s", wantCoin.Name, err)
+		return
+	}
+
+	return server.placeOrder(order, liquidity, nil)
+}
+
+// checkOrderKind makes sure the user doesn't already have orders of the other kind on the book.
+// dbLock should be held.
+func (server *OpencxServer) checkOrderKind(book match.LimitOrderbook, order *match.LimitOrder, swap bool) (err error) {
+	if server.SwapStore == nil {
+		if swap {
+			err = fmt.Errorf("Swap orders aren't supported")
+		}
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(order.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for checkOrderKind: %s", err)
+		return
+	}
+
+	var orders map[float64][]*match.LimitOrderIDPair
+	if orders, err = book.GetOrdersForPubkey(pubkey); err != nil {
+		err = fmt.Errorf("Error getting orders for checkOrderKind: %s", err)
+		return
+	}
+
+	for _, priceOrders := range orders {
+		for _, bookOrder := range priceOrders {
+			var swapOrder bool
+			if swapOrder, err = server.SwapStore.IsSwapOrder(bookOrder.OrderID); err != nil {
+				err = fmt.Errorf("Error checking for swap order for checkOrderKind: %s", err)
+				return
+			}
+			if swapOrder != swap {
+				err = fmt.Errorf("You can't have swap and custodial orders on %s at the same time, cancel order %x first", order.TradingPair.String(), bookOrder.OrderID[:])
+				return
+			}
+		}
+	}
+	return
+}
+
+// execFill is everything an order was filled for by the executions of one placed order
+type execFill struct {
+	// entry keeps track of what's left on the order after the executions
+	entry *match.OrderHistoryEntry
+	// fill is what the order gave up and received across all of the executions
+	fill *match.FillEntry
+}
+
+// fillsForExecs sums up what each order was filled for by the executions, in the order they were
+// first filled. Orders that include returns false for are left out, a nil include keeps them
+// all. The placed order isn't on the book yet, the rest are. dbLock should be held.
+func fillsForExecs(book match.LimitOrderbook, placed *match.LimitOrderIDPair, orderExecs []*match.OrderExecution, include func(orderID *match.OrderID) (bool, error)) (fills []*execFill, err error) {
+	byOrder := make(map[match.OrderID]*execFill)
+	for _, orderExec := range orderExecs {
+		if include != nil {
+			var included bool
+			if included, err = include(&orderExec.OrderID); err != nil {
+				return
+			}
+			if !included {
+				continue
+			}
+		}
+
+		// the entry keeps track of what's left on the order between executions
+		total, ok := byOrder[orderExec.OrderID]
+		if !ok {
+			idPair := placed
+			if orderExec.OrderID != *placed.OrderID {
+				if idPair, err = book.GetOrder(&orderExec.OrderID); err != nil {
+					err = fmt.Errorf("Error getting order for fillsForExecs: %s", err)
+					return
+				}
+			}
+			total = &execFill{entry: match.NewOrderHistoryEntry(idPair)}
+			byOrder[orderExec.OrderID] = total
+		}
+
+		fill := total.entry.ApplyExec(orderExec, placed.Timestamp)
+		if total.fill != nil {
+			total.fill.AmountHave += fill.AmountHave
+			total.fill.AmountWant += fill.AmountWant
+			continue
+		}
+		total.fill = fill
+		fills = append(fills, total)
+	}
+	return
+}
+
+// isSwapOrderWithoutEscrow returns true if an order is a swap order but not a channel order,
+// channel orders already have their side locked so they don't need swaps. dbLock should be held.
+func (server *OpencxServer) isSwapOrderWithoutEscrow(orderID *match.OrderID) (swapOrder bool, err error) {
+	if swapOrder, err = server.SwapStore.IsSwapOrder(orderID); err != nil || !swapOrder {
+		return
+	}
+
+	var escrow *match.OrderEscrow
+	if escrow, err = server.SwapStore.GetOrderEscrow(orderID); err != nil {
+		return
+	}
+	swapOrder = escrow == nil
+	return
+}
+
+// swapsForExecs creates and stores a swap for each swap order that was filled by the executions,
+// and returns the pubkeys of the swap orders so their settlement executions can be skipped. An
+// order filled more than once by the executions gets one swap. dbLock should be held.
+func (server *OpencxServer) swapsForExecs(book match.LimitOrderbook, placed *match.LimitOrderIDPair, orderExecs []*match.OrderExecution) (swaps []*match.Swap, swapPubkeys map[[33]byte]bool, err error) {
+	if server.SwapStore == nil {
+		return
+	}
+
+	var fills []*execFill
+	if fills, err = fillsForExecs(book, placed, orderExecs, server.isSwapOrderWithoutEscrow); err != nil {
+		err = fmt.Errorf("Error getting swap order fills for swapsForExecs: %s", err)
+		return
+	}
+
+	swapPubkeys = make(map[[33]byte]bool)
+	for _, filled := range fills {
+		fill, entry := filled.fill, filled.entry
+		swapPubkeys[fill.Pubkey] = true
+
+		// what's reserved for the order moves to its swap, the rest stays with the order
+		if entry.Status == match.OrderFilled {
+			server.liquidity.Release(entry.OrderID)
+		} else {
+			remaining := *entry.Order
+			remaining.AmountHave -= entry.AmountHaveFilled
+			remaining.AmountWant -= entry.AmountWantFilled
+			server.reserveOrder(&entry.OrderID, &remaining)
+		}
+
+		_, want := fill.TradingPair.HaveWantAssets(fill.Side)
+		var sendCoin *coinparam.Params
+		if sendCoin, err = want.CoinParamFromAsset(); err != nil {
+			err = fmt.Errorf("Error getting coin to send for swapsForExecs: %s", err)
+			return
+		}
+
+		var swap *match.Swap
+		if swap, err = match.NewSwap(fill, uint32(server.chainHeights[sendCoin]), server.SwapPolicy, placed.Timestamp); err != nil {
+			err = fmt.Errorf("Error creating swap for swapsForExecs: %s", err)
+			return
+		}
+		if err = server.SwapStore.AddSwap(swap); err != nil {
+			err = fmt.Errorf("Error storing swap for swapsForExecs: %s", err)
+			return
+		}
+		server.reserveSwap(swap)
+		server.liquidity.RecordFlow(swap.Pubkey, swap.SendAsset, swap.AmountSend)
+		logging.Infof("Created %s", swap)
+		swaps = append(swaps, swap)
+	}
+	return
+}
+
+// offerSwaps offers our side of new swaps. Swaps that fail stay pending, and are tried again
+// when the next block comes in.
+func (server *OpencxServer) offerSwaps(swaps []*match.Swap) {
+	server.swapMtx.Lock()
+	defer server.swapMtx.Unlock()
+
+	for _, swap := range swaps {
+		// a block may have come in and moved the swap along already
+		stored, err := server.SwapStore.GetSwap(swap.RHash)
+		if err != nil {
+			logging.Errorf("Error getting %s to offer: %s", swap, err)
+			continue
+		}
+		if stored.State != match.SwapPending {
+			continue
+		}
+		if err = server.offerSwap(stored); err != nil {
+			logging.Errorf("Error offering %s: %s", stored, err)
+		}
+	}
+	return
+}
+
+// offerSwap offers our side of a pending swap and moves it to offered. swapMtx should be held.
+func (server *OpencxServer) offerSwap(swap *match.Swap) (err error) {
+	if err = server.CreateSwap(swap); err != nil {
+		err = fmt.Errorf("Error creating swap for offerSwap: %s", err)
+		return
+	}
+	swap.SetState(match.SwapOffered, "", time.Now())
+	if err = server.SwapStore.UpdateSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating swap for offerSwap: %s", err)
+		return
+	}
+	server.releaseSwap(swap)
+	logging.Infof("Offered %s", swap)
+	return
+}
+
+// updateSwaps updates the swaps for HTLC hashes, hashes that aren't for swaps are ignored
+func (server *OpencxServer) updateSwaps(hashes [][32]byte) {
+	if server.SwapStore == nil {
+		return
+	}
+
+	server.swapMtx.Lock()
+	defer server.swapMtx.Unlock()
+
+	seen := make(map[[32]byte]bool)
+	for _, rhash := range hashes {
+		if seen[rhash] {
+			continue
+		}
+		seen[rhash] = true
+
+		swap, err := server.SwapStore.GetSwap(rhash)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		if err = server.updateSwap(swap); err != nil {
+			logging.Errorf("Error updating %s: %s", swap, err)
+		}
+	}
+	return
+}
+
+// updateSwap claims the user's side of an offered swap once it's all there, and completes a
+// claimed swap once the user has claimed our side. swapMtx should be held.
+func (server *OpencxServer) updateSwap(swap *match.Swap) (err error) {
+	if swap.State != match.SwapOffered && swap.State != match.SwapClaimed {
+		return
+	}
+
+	var htlcs []match.SwapHTLC
+	if htlcs, err = server.swapHTLCs(swap); err != nil {
+		err = fmt.Errorf("Error getting HTLCs for updateSwap: %s", err)
+		return
+	}
+
+	if swap.State == match.SwapOffered {
+		var sendCoin, receiveCoin *coinparam.Params
+		if sendCoin, err = swap.SendAsset.CoinParamFromAsset(); err != nil {
+			err = fmt.Errorf("Error getting coin to send for updateSwap: %s", err)
+			return
+		}
+		if receiveCoin, err = swap.ReceiveAsset.CoinParamFromAsset(); err != nil {
+			err = fmt.Errorf("Error getting coin to receive for updateSwap: %s", err)
+			return
+		}
+
+		server.dbLock.Lock()
+		sendHeight, receiveHeight := server.chainHeights[sendCoin], server.chainHeights[receiveCoin]
+		server.dbLock.Unlock()
+
+		// Claiming reveals the preimage, after that the user has to have time to claim our side
+		// before we can take it back
+		if swap.TimedOut(uint32(sendHeight) + server.SwapPolicy.ClaimMargin) {
+			return
+		}
+		if swap.CheckClaim(htlcs, uint32(receiveHeight), server.SwapPolicy) != nil {
+			return
+		}
+
+		if _, err = server.ExchangeNode.ClaimHTLC(swap.Preimage); err != nil {
+			err = fmt.Errorf("Error claiming HTLCs for updateSwap: %s", err)
+			return
+		}
+		swap.SetState(match.SwapClaimed, "", time.Now())
+		if err = server.SwapStore.UpdateSwap(swap); err != nil {
+			err = fmt.Errorf("Error updating claimed swap for updateSwap: %s", err)
+			return
+		}
+		logging.Infof("Claimed %s", swap)
+
+		// the user may not have claimed our side yet
+		if htlcs, err = server.swapHTLCs(swap); err != nil {
+			err = fmt.Errorf("Error getting HTLCs after claim for updateSwap: %s", err)
+			return
+		}
+	}
+
+	if swap.SentClaimed(htlcs) {
+		swap.SetState(match.SwapCompleted, "", time.Now())
+		if err = server.SwapStore.UpdateSwap(swap); err != nil {
+			err = fmt.Errorf("Error updating completed swap for updateSwap: %s", err)
+			return
+		}
+		logging.Infof("Completed %s", swap)
+	}
+	return
+}
+
+// updateSwapsAtHeight moves along swaps that send a coin when a block for that coin comes in.
+// Pending swaps are offered again, and offered or claimed swaps whose locktime has passed are
+// refunded.
+func (server *OpencxServer) updateSwapsAtHeight(height uint64, coin *coinparam.Params) (err error) {
+	if server.SwapStore == nil || server.ExchangeNode == nil {
+		return
+	}
+
+	server.swapMtx.Lock()
+	defer server.swapMtx.Unlock()
+
+	var swaps []*match.Swap
+	for _, state := range []match.SwapState{match.SwapPending, match.SwapOffered, match.SwapClaimed} {
+		var stateSwaps []*match.Swap
+		if stateSwaps, err = server.SwapStore.GetSwapsByState(state); err != nil {
+			err = fmt.Errorf("Error getting %s swaps for updateSwapsAtHeight: %s", state, err)
+			return
+		}
+		swaps = append(swaps, stateSwaps...)
+	}
+
+	// one swap that can't be updated shouldn't stop the others, or the rest of the block. Pending
+	// swaps are offered again every block until they time out, so their errors are only logged.
+	for _, swap := range swaps {
+		var sendCoin *coinparam.Params
+		if sendCoin, err = swap.SendAsset.CoinParamFromAsset(); err != nil {
+			logging.Errorf("Error getting coin to send for %s: %s", swap, err)
+			continue
+		}
+		if sendCoin != coin {
+			continue
+		}
+
+		if err = server.updateSwapAtHeight(swap, height, coin); err != nil {
+			logging.Errorf("Error updating %s at height %d: %s", swap, height, err)
+		}
+	}
+	err = nil
+	return
+}
+
+// updateSwapAtHeight offers, updates or refunds a single swap that sends coin. swapMtx should be
+// held.
+func (server *OpencxServer) updateSwapAtHeight(swap *match.Swap, height uint64, coin *coinparam.Params) (err error) {
+	if swap.State == match.SwapPending {
+		// We never offered anything, so there's nothing to take back
+		if swap.TimedOut(uint32(height)) {
+			swap.SetState(match.SwapFailed, "our HTLCs could not be offered before the swap timed out", time.Now())
+			if err = server.SwapStore.UpdateSwap(swap); err != nil {
+				err = fmt.Errorf("Error updating failed swap for updateSwapAtHeight: %s", err)
+				return
+			}
+			server.releaseSwap(swap)
+			logging.Infof("Failed %s", swap)
+			return
+		}
+		return server.offerSwap(swap)
+	}
+
+	// Something might have changed that there was no event for, like HTLCs clearing on chain
+	if err = server.updateSwap(swap); err != nil {
+		return
+	}
+	if swap.State.Final() || !swap.TimedOut(uint32(height)) {
+		return
+	}
+
+	if _, err = server.ExchangeNode.ClaimHTLCTimeouts(coin.HDCoinType, int32(height)); err != nil {
+		err = fmt.Errorf("Error claiming HTLC timeouts for updateSwapAtHeight: %s", err)
+		return
+	}
+
+	reason := "the user's HTLCs never arrived"
+	if swap.State == match.SwapClaimed {
+		reason = "the user never claimed our HTLCs"
+	}
+	swap.SetState(match.SwapRefunded, reason, time.Now())
+	if err = server.SwapStore.UpdateSwap(swap); err != nil {
+		err = fmt.Errorf("Error updating refunded swap for updateSwapAtHeight: %s", err)
+		return
+	}
+	logging.Infof("Refunded %s", swap)
+	return
+}
+
+// GetSwaps gets every swap for a pubkey, without the preimages of swaps that haven't been claimed
+func (server *OpencxServer) GetSwaps(pubkey *koblitz.PublicKey) (swaps []*match.Swap, err error) {
+	if server.SwapStore == nil {
+		err = fmt.Errorf("Swap orders aren't supported")
+		return
+	}
+
+	var stored []*match.Swap
+	if stored, err = server.SwapStore.GetSwaps(pubkey); err != nil {
+		err = fmt.Errorf("Error getting swaps for GetSwaps: %s", err)
+		return
+	}
+	for _, swap := range stored {
+		swaps = append(swaps, swap.Public())
+	}
+	return
+}
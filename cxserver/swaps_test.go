@@ -0,0 +1,164 @@
+package cxserver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb/cxdbbolt"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/match"
+)
+
+var swapPair = match.Pair{AssetWant: match.BTCTest, AssetHave: match.LTCTest}
+
+// createSwapServer creates a server that trades one pair and supports swap orders, without a
+// lit node so swaps are never offered
+func createSwapServer(t *testing.T, dataDir string) (server *OpencxServer) {
+	t.Helper()
+	var coins []*coinparam.Params
+	for _, asset := range []match.Asset{swapPair.AssetWant, swapPair.AssetHave} {
+		coin, err := asset.CoinParamFromAsset()
+		if err != nil {
+			t.Fatalf("coin for %s: %v", asset, err)
+		}
+		coins = append(coins, coin)
+	}
+
+	setEngines, err := cxdbmemory.CreateSettlementEngineMap(coins)
+	if err != nil {
+		t.Fatalf("create settlement engines: %v", err)
+	}
+	setStores, err := cxdbbolt.CreateSettlementStoreMap(coins, dataDir)
+	if err != nil {
+		t.Fatalf("create settlement stores: %v", err)
+	}
+	matchEngines, err := cxdbmemory.CreateLimitEngineMap([]*match.Pair{&swapPair})
+	if err != nil {
+		t.Fatalf("create limit engines: %v", err)
+	}
+	books, err := cxdbbolt.CreateLimitOrderbookMap([]*match.Pair{&swapPair}, dataDir)
+	if err != nil {
+		t.Fatalf("create orderbooks: %v", err)
+	}
+	swapStore, err := cxdbmemory.CreateSwapStore()
+	if err != nil {
+		t.Fatalf("create swap store: %v", err)
+	}
+
+	if server, err = InitServer(setEngines, matchEngines, books, nil, nil, setStores, nil, dataDir); err != nil {
+		t.Fatalf("init server: %v", err)
+	}
+	if err = server.SetSwapStore(swapStore); err != nil {
+		t.Fatalf("set swap store: %v", err)
+	}
+	for _, coin := range coins {
+		server.chainHeights[coin] = 100
+	}
+	return
+}
+
+func swapOrder(priv *koblitz.PrivateKey, side match.Side) (order *match.LimitOrder) {
+	order = &match.LimitOrder{
+		TradingPair: swapPair,
+		Side:        side,
+		AmountHave:  100000000,
+		AmountWant:  100000000,
+	}
+	copy(order.Pubkey[:], priv.PubKey().SerializeCompressed())
+	return
+}
+
+// swapTestLiquidity is exactly enough capacity for a swapOrder
+func swapTestLiquidity(priv *koblitz.PrivateKey, side match.Side) (liquidity *swapLiquidity) {
+	have, want := swapPair.HaveWantAssets(side)
+	liquidity = &swapLiquidity{
+		have: &match.Liquidity{Asset: have, Channels: 1, Inbound: 100000000},
+		want: &match.Liquidity{Asset: want, Channels: 1, Outbound: 100000000},
+	}
+	copy(liquidity.have.Pubkey[:], priv.PubKey().SerializeCompressed())
+	liquidity.want.Pubkey = liquidity.have.Pubkey
+	return
+}
+
+// TestSwapOrdersMatch tests that matching swap orders creates a swap for each side instead of
+// changing balances
+func TestSwapOrdersMatch(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "swaporders")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	server := createSwapServer(t, dataDir)
+
+	seller, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	buyer, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{2})
+
+	sellID, err := server.placeOrder(swapOrder(seller, match.Sell), swapTestLiquidity(seller, match.Sell), nil)
+	if err != nil {
+		t.Fatalf("place swap sell: %v", err)
+	}
+	if swapOrder, err := server.SwapStore.IsSwapOrder(sellID); err != nil || !swapOrder {
+		t.Errorf("Sell should be a swap order, got %t and %v", swapOrder, err)
+	}
+
+	// the seller has no balance, but a custodial order is refused before that's checked
+	if _, err = server.PlaceOrder(swapOrder(seller, match.Sell)); err == nil {
+		t.Errorf("Custodial order should be refused while the user has a swap order on the pair")
+	}
+
+	// the first order has all the seller's capacity reserved
+	if _, err = server.placeOrder(swapOrder(seller, match.Sell), swapTestLiquidity(seller, match.Sell), nil); err == nil {
+		t.Errorf("Swap order should be refused when its capacity is reserved for another order")
+	}
+
+	if _, err = server.placeOrder(swapOrder(buyer, match.Buy), swapTestLiquidity(buyer, match.Buy), nil); err != nil {
+		t.Fatalf("place swap buy: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		priv    *koblitz.PrivateKey
+		send    match.Asset
+		receive match.Asset
+	}{
+		{"seller", seller, swapPair.AssetHave, swapPair.AssetWant},
+		{"buyer", buyer, swapPair.AssetWant, swapPair.AssetHave},
+	} {
+		swaps, err := server.GetSwaps(tc.priv.PubKey())
+		if err != nil || len(swaps) != 1 {
+			t.Fatalf("%s should have one swap, got %d and %v", tc.name, len(swaps), err)
+		}
+		swap := swaps[0]
+		if swap.SendAsset != tc.send || swap.ReceiveAsset != tc.receive || swap.AmountSend != 100000000 || swap.AmountReceive != 100000000 {
+			t.Errorf("Wrong swap for %s: %s", tc.name, swap)
+		}
+		if swap.SendLocktime != 100+match.DefaultSwapTimeout {
+			t.Errorf("Swap for %s should time out at %d, got %d", tc.name, 100+match.DefaultSwapTimeout, swap.SendLocktime)
+		}
+		// without a lit node nothing can be offered, so the swap waits for the next block
+		if swap.State != match.SwapPending || swap.Preimage != [16]byte{} {
+			t.Errorf("Swap for %s should be pending without a public preimage, got %s", tc.name, swap)
+		}
+
+		// the orders are filled, so what was reserved for them is now reserved for the swap
+		// until it's offered
+		outbound, _ := server.liquidity.Reserved(swap.Pubkey, tc.send)
+		_, inbound := server.liquidity.Reserved(swap.Pubkey, tc.receive)
+		if outbound != 100000000 || inbound != 100000000 {
+			t.Errorf("Swap for %s should have 100000000 reserved each way, got %d out and %d in", tc.name, outbound, inbound)
+		}
+		if flow := server.liquidity.Flow(swap.Pubkey, tc.send); flow != 100000000 {
+			t.Errorf("Swap for %s should be recorded as flow, got %d", tc.name, flow)
+		}
+
+		for _, asset := range []match.Asset{tc.send, tc.receive} {
+			coin, _ := asset.CoinParamFromAsset()
+			if balance, err := server.GetBalance(tc.priv.PubKey(), coin); err != nil || balance != 0 {
+				t.Errorf("Swap shouldn't change the %s balance of %s, got %d and %v", asset, tc.name, balance, err)
+			}
+		}
+	}
+}
@@ -43,15 +43,26 @@ func (server *OpencxServer) SetupWallet(errChan chan error, subDirName string, p
 		return
 	}
 
+	logging.Infof("%s wallet Started, cointype: %d\n", param.Name, coinType)
+	// figure out whether or not to do this if merged
+
+	server.AddWallet(wallet)
+
+	return
+}
+
+// AddWallet makes a running wallet the exchange's wallet for its coin, and starts ingesting blocks
+// from its chainhook. The wallet can be on any chainhook, for example a mockchain in tests.
+func (server *OpencxServer) AddWallet(wallet *wallit.Wallit) {
 	server.walletMtx.Lock()
-	server.WalletMap[param] = wallet
+	server.WalletMap[wallet.Param] = wallet
 	server.walletMtx.Unlock()
 
-	logging.Infof("%s wallet Started, cointype: %d\n", param.Name, coinType)
-	// figure out whether or not to do this if merged
+	if err := server.freezeReplacedChange(wallet); err != nil {
+		logging.Errorf("Error freezing change of replaced %s withdrawal transactions: %s", wallet.Param.Name, err)
+	}
 
 	server.StartChainhookHandlers(wallet)
-
 	return
 }
 
@@ -71,7 +82,8 @@ func (server *OpencxServer) SetupAllWallets(hostParamList util.HostParamList, su
 	return
 }
 
-// LinkAllWallets will link the exchanges' wallets with the lit node running. Defaults to false for running tower.
+// LinkAllWallets will link the exchanges' wallets with the lit node running. The wallets feed the
+// exchange's watchtower if it's set up.
 func (server *OpencxServer) LinkAllWallets() (err error) {
 
 	// Not sure whether or not this should just assume that everything in the map is what you want, but I'm going to
@@ -87,8 +99,8 @@ func (server *OpencxServer) LinkAllWallets() (err error) {
 			err = fmt.Errorf("Wallet in Coin List not being tracked by exchange in map, start it please")
 		}
 
-		// Idk if I should run a tower with these, probably. It's an exchange
-		if err = server.LinkOneWallet(wallet, false); err != nil {
+		// the wallets only feed the tower if the exchange is running one
+		if err = server.LinkOneWallet(wallet, server.tower != nil); err != nil {
 			return
 		}
 	}
@@ -173,6 +185,12 @@ func (server *OpencxServer) LinkOneWallet(wallet *wallit.Wallit, tower bool) (er
 		if err != nil {
 			return err
 		}
+
+		// the tower punishes breaches on its own, the exchange watches the same blocks to
+		// raise alerts for them
+		if server.tower != nil {
+			go server.watchBreaches(wallet.Param, server.ExchangeNode.SubWallet[WallitIdx].ExportHook().NewRawBlocksChannel())
+		}
 	}
 
 	return nil
@@ -0,0 +1,365 @@
+package cxserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/lnp2p"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/lit/watchtower"
+	"github.com/mit-dci/lit/wire"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// exchangeTower is the watchtower the exchange runs. It watches the exchange's own channels, and
+// the channels users have with the exchange for users who ask it to, but not channels that have
+// nothing to do with the exchange.
+type exchangeTower struct {
+	*watchtower.WatchTower
+	server *OpencxServer
+}
+
+// NewChannel starts watching a channel for a peer, as long as the peer has an open channel with
+// the exchange on the same coin
+func (et *exchangeTower) NewChannel(m lnutil.WatchDescMsg) (err error) {
+	var channels []*qln.Qchan
+	if channels, err = et.server.ExchangeNode.GetAllQchans(); err != nil {
+		err = fmt.Errorf("Error getting channels for NewChannel: %s", err)
+		return
+	}
+
+	found := false
+	for _, channel := range channels {
+		if channel.Peer() == m.PeerIdx && channel.Coin() == m.CoinType && !channel.CloseData.Closed {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = fmt.Errorf("Peer %d has no open channel with the exchange for coin type %d, not watching %x", m.PeerIdx, m.CoinType, m.DestPKHScript)
+		return
+	}
+
+	if err = et.WatchTower.NewChannel(m); err != nil {
+		err = fmt.Errorf("Error adding channel for NewChannel: %s", err)
+		return
+	}
+	logging.Infof("Watching channel %x for peer %d", m.DestPKHScript, m.PeerIdx)
+	return
+}
+
+// nextState returns the first state of the channel watched under pkh that the tower doesn't
+// have, and whether the tower is watching the channel at all
+func (et *exchangeTower) nextState(pkh [20]byte) (watched bool, next uint64, err error) {
+	err = et.WatchDB.View(func(btx *bolt.Tx) (err error) {
+		allChanBucket := btx.Bucket(watchtower.BUCKETChandata)
+		if allChanBucket == nil {
+			err = fmt.Errorf("No channel bucket in tower")
+			return
+		}
+		chanBucket := allChanBucket.Bucket(pkh[:])
+		if chanBucket == nil {
+			return
+		}
+		watched = true
+
+		var elkRcv *elkrem.ElkremReceiver
+		if elkRcv, err = elkrem.ElkremReceiverFromBytes(chanBucket.Get(watchtower.KEYElkRcv)); err != nil {
+			return
+		}
+		if len(elkRcv.Nodes) != 0 {
+			next = elkRcv.UpTo() + 1
+		}
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("Error reading tower for nextState: %s", err)
+		return
+	}
+	return
+}
+
+// watchedState returns the channel and state a revoked commitment the tower matched is for
+func (et *exchangeTower) watchedState(txid chainhash.Hash) (pkh [20]byte, stateIdx uint64, err error) {
+	err = et.WatchDB.View(func(btx *bolt.Tx) (err error) {
+		txidBucket := btx.Bucket(watchtower.BUCKETTxid)
+		mapBucket := btx.Bucket(watchtower.BUCKETPKHMap)
+		if txidBucket == nil || mapBucket == nil {
+			err = fmt.Errorf("No txid or channel index bucket in tower")
+			return
+		}
+
+		var idxSig *watchtower.IdxSig
+		if idxSig, err = watchtower.IdxSigFromBytes(txidBucket.Get(txid[:16])); err != nil {
+			return
+		}
+		pkhBytes := mapBucket.Get(lnutil.U32tB(idxSig.PKHIdx))
+		if len(pkhBytes) != len(pkh) {
+			err = fmt.Errorf("No channel at index %d", idxSig.PKHIdx)
+			return
+		}
+		copy(pkh[:], pkhBytes)
+		stateIdx = idxSig.StateIdx
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("Error reading tower for watchedState: %s", err)
+		return
+	}
+	return
+}
+
+// SetupWatchtower makes the exchange's lit node a watchtower, for the exchange's own channels and
+// for the channels users have with it. It has to be called after SetupLitNode and before the
+// wallets are linked.
+func (server *OpencxServer) SetupWatchtower() (err error) {
+	if server.ExchangeNode == nil {
+		err = fmt.Errorf("Lit node isn't set up, can't run a watchtower")
+		return
+	}
+
+	server.tower = &exchangeTower{
+		WatchTower: new(watchtower.WatchTower),
+		server:     server,
+	}
+	server.ExchangeNode.Tower = server.tower
+	return
+}
+
+// SetTowerPeer makes the exchange send its channel states to another watchtower as well, so
+// breaches are punished while the exchange is down. The address is a lightning address with a
+// host, like ln1...@host:port.
+func (server *OpencxServer) SetTowerPeer(address string) {
+	server.towerPeer = address
+	return
+}
+
+// towerPeerIdx connects to the tower peer if the exchange isn't connected already, and returns
+// its peer index
+func (server *OpencxServer) towerPeerIdx() (peerIdx uint32, err error) {
+	lnAdr := strings.Split(server.towerPeer, "@")[0]
+	if peerIdx, err = server.ExchangeNode.FindPeerIndexByAddress(lnAdr); err == nil && server.ExchangeNode.ConnectedToPeer(peerIdx) {
+		return
+	}
+
+	var peer *lnp2p.Peer
+	if peer, err = server.ExchangeNode.PeerMan.TryConnectAddress(server.towerPeer); err != nil {
+		err = fmt.Errorf("Error connecting to tower %s: %s", server.towerPeer, err)
+		return
+	}
+	peerIdx = peer.GetIdx()
+	return
+}
+
+// registerChannel gives the exchange's tower every revoked state of one of the exchange's
+// channels it doesn't have yet. Like lit's SyncWatch, a channel is only registered once it's
+// past state 1, and the tower gets every state up to the one before the current state.
+func (server *OpencxServer) registerChannel(qc *qln.Qchan) (err error) {
+	if qc.State.StateIdx < 2 {
+		return
+	}
+
+	var watched bool
+	var next uint64
+	if watched, next, err = server.tower.nextState(qc.WatchRefundAdr); err != nil {
+		err = fmt.Errorf("Error getting tower state for registerChannel: %s", err)
+		return
+	}
+
+	// the exchange's own states go straight to the tower rather than through NewChannel, they
+	// don't come from a peer
+	if !watched {
+		desc := lnutil.NewWatchDescMsg(0, qc.Coin(), qc.WatchRefundAdr, qc.Delay, consts.JusticeFee, qc.TheirHAKDBase, qc.MyHAKDBase)
+		if err = server.tower.WatchTower.NewChannel(desc); err != nil {
+			err = fmt.Errorf("Error adding channel to tower for registerChannel: %s", err)
+			return
+		}
+	}
+
+	for ; next < qc.State.StateIdx; next++ {
+		var justice qln.JusticeTx
+		if justice, err = server.ExchangeNode.LoadJusticeSig(next, qc.WatchRefundAdr); err != nil {
+			err = fmt.Errorf("Error loading justice signature for registerChannel: %s", err)
+			return
+		}
+		var elk *chainhash.Hash
+		if elk, err = qc.ElkRcv.AtIndex(next); err != nil {
+			err = fmt.Errorf("Error getting elkrem for registerChannel: %s", err)
+			return
+		}
+		if err = server.tower.UpdateChannel(lnutil.NewComMsg(0, qc.Coin(), qc.WatchRefundAdr, *elk, justice.Txid, justice.Sig)); err != nil {
+			err = fmt.Errorf("Error adding state to tower for registerChannel: %s", err)
+			return
+		}
+	}
+	return
+}
+
+// SyncTowers gives the exchange's tower and the tower peer, whichever are set up, every revoked
+// state of the exchange's open channels they don't have yet. A channel that can't be synced is
+// logged and skipped.
+func (server *OpencxServer) SyncTowers() (err error) {
+	if server.tower == nil && server.towerPeer == "" {
+		err = fmt.Errorf("No watchtower to sync channels with")
+		return
+	}
+
+	// the exchange's own tower still gets synced if the tower peer can't be reached
+	var peerIdx uint32
+	syncPeer := server.towerPeer != ""
+	if syncPeer {
+		if peerIdx, err = server.towerPeerIdx(); err != nil {
+			logging.Errorf("Error getting tower peer for SyncTowers: %s", err)
+			syncPeer = false
+		}
+	}
+
+	var channels []*qln.Qchan
+	if channels, err = server.ExchangeNode.GetAllQchans(); err != nil {
+		err = fmt.Errorf("Error getting channels for SyncTowers: %s", err)
+		return
+	}
+
+	for _, channel := range channels {
+		if channel.CloseData.Closed || server.ExchangeNode.SubWallet[channel.Coin()] == nil {
+			continue
+		}
+
+		if server.tower != nil {
+			if err = server.registerChannel(channel); err != nil {
+				logging.Errorf("Error registering channel %s with tower: %s", channel.Op, err)
+			}
+		}
+
+		// SyncWatch errors if there's nothing new, so only call it when there is
+		if syncPeer && channel.State.StateIdx >= 2 && channel.State.WatchUpTo+2 <= channel.State.StateIdx {
+			if err = server.ExchangeNode.SyncWatch(channel, peerIdx); err != nil {
+				logging.Errorf("Error sending channel %s to tower %s: %s", channel.Op, server.towerPeer, err)
+			}
+		}
+	}
+	err = nil
+	return
+}
+
+// StartTowerSync syncs the exchange's channels with its towers once every interval, forever
+func (server *OpencxServer) StartTowerSync(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := server.SyncTowers(); err != nil {
+				logging.Errorf("Error syncing towers: %s", err)
+			}
+		}
+	}()
+	return
+}
+
+// watchBreaches checks every block for a coin for the revoked states the tower is watching for,
+// and raises an alert for each one it finds. The tower sends out the justice transactions
+// itself.
+func (server *OpencxServer) watchBreaches(coin *coinparam.Params, blocks chan *wire.MsgBlock) {
+	for block := range blocks {
+		txids, err := block.TxHashes()
+		if err != nil {
+			logging.Errorf("Error getting txids for %s breaches: %s", coin.Name, err)
+			continue
+		}
+		hits, err := server.tower.MatchTxids(coin.HDCoinType, txids)
+		if err != nil {
+			logging.Errorf("Error matching txids for %s breaches: %s", coin.Name, err)
+			continue
+		}
+		if len(hits) == 0 {
+			continue
+		}
+
+		channels, err := server.ExchangeNode.GetAllQchans()
+		if err != nil {
+			logging.Errorf("Error getting channels for %s breaches: %s", coin.Name, err)
+			continue
+		}
+		alerts, err := server.alertsForBreaches(coin, block, hits, channels)
+		if err != nil {
+			logging.Errorf("Error making %s breach alerts: %s", coin.Name, err)
+			continue
+		}
+		for _, alert := range alerts {
+			logging.Errorf("Breach! %s", alert)
+		}
+
+		server.breachMtx.Lock()
+		server.breachAlerts = append(server.breachAlerts, alerts...)
+		server.breachMtx.Unlock()
+	}
+	return
+}
+
+// alertsForBreaches makes an alert for each transaction in a block the tower matched. The exchange's
+// own channel states are watched under the channel's refund address, so if the revoked state
+// the tower matched is watched under anything else it's a user's, and the exchange broadcast it.
+func (server *OpencxServer) alertsForBreaches(coin *coinparam.Params, block *wire.MsgBlock, hits []chainhash.Hash, channels []*qln.Qchan) (alerts []*match.BreachAlert, err error) {
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for alertsForBreaches: %s", err)
+		return
+	}
+
+	isHit := make(map[chainhash.Hash]bool)
+	for _, hit := range hits {
+		isHit[hit] = true
+	}
+
+	for _, tx := range block.Transactions {
+		txid := tx.TxHash()
+		if !isHit[txid] {
+			continue
+		}
+
+		alert := &match.BreachAlert{
+			Asset:     asset,
+			Txid:      txid.String(),
+			BlockHash: block.BlockHash().String(),
+			Detected:  time.Now(),
+		}
+		var pkh [20]byte
+		if pkh, alert.StateIdx, err = server.tower.watchedState(txid); err != nil {
+			err = fmt.Errorf("Error getting watched state for alertsForBreaches: %s", err)
+			return
+		}
+
+		// commitments spend the channel's funding outpoint
+		for _, channel := range channels {
+			if len(tx.TxIn) != 0 && tx.TxIn[0].PreviousOutPoint == channel.Op {
+				alert.Outpoint = channel.Op.String()
+				alert.Peer = channel.Peer()
+				alert.ByExchange = pkh != channel.WatchRefundAdr
+				break
+			}
+		}
+		alerts = append(alerts, alert)
+	}
+	return
+}
+
+// GetBreachAlerts returns the breaches the exchange's tower has seen since the exchange started
+func (server *OpencxServer) GetBreachAlerts() (alerts []*match.BreachAlert, err error) {
+	if server.tower == nil {
+		err = fmt.Errorf("The exchange isn't running a watchtower")
+		return
+	}
+
+	server.breachMtx.Lock()
+	alerts = append(alerts, server.breachAlerts...)
+	server.breachMtx.Unlock()
+	return
+}
@@ -0,0 +1,96 @@
+package cxserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/lit/uspv"
+	"github.com/mit-dci/lit/watchtower"
+	"github.com/mit-dci/lit/wire"
+)
+
+// TestBreachAlerts tests that revoked states the tower is watching raise alerts that say whether
+// the user or the exchange broadcast them
+func TestBreachAlerts(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "breachalerts")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	coin, err := swapPair.AssetWant.CoinParamFromAsset()
+	if err != nil {
+		t.Fatalf("coin for %s: %v", swapPair.AssetWant, err)
+	}
+	tower := new(watchtower.WatchTower)
+	if err = tower.OpenDB(filepath.Join(dataDir, "watch.db")); err != nil {
+		t.Fatalf("open tower db: %v", err)
+	}
+	tower.Hooks = map[uint32]uspv.ChainHook{coin.HDCoinType: nil}
+	server := &OpencxServer{breachMtx: new(sync.Mutex)}
+	server.tower = &exchangeTower{WatchTower: tower, server: server}
+
+	// the exchange watches its side of one channel, and the user watches their side of another
+	exchangeChan := &qln.Qchan{WatchRefundAdr: [20]byte{0x01}}
+	exchangeChan.Op = wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	exchangeChan.KeyGen = portxo.KeyGen{Step: [5]uint32{0, 0, 0, 3 | 1<<31, 0}}
+	userChan := &qln.Qchan{WatchRefundAdr: [20]byte{0x02}}
+	userChan.Op = wire.OutPoint{Hash: chainhash.Hash{0x02}}
+	userChan.KeyGen = portxo.KeyGen{Step: [5]uint32{0, 0, 0, 4 | 1<<31, 0}}
+	userPKH := [20]byte{0x03}
+
+	exchangeBreach := wire.NewMsgTx()
+	exchangeBreach.AddTxIn(wire.NewTxIn(&userChan.Op, nil, nil))
+	userBreach := wire.NewMsgTx()
+	userBreach.AddTxIn(wire.NewTxIn(&exchangeChan.Op, nil, nil))
+
+	if watched, _, err := server.tower.nextState(exchangeChan.WatchRefundAdr); err != nil || watched {
+		t.Fatalf("Channel shouldn't be watched yet, got %t and %v", watched, err)
+	}
+	for pkh, breach := range map[[20]byte]*wire.MsgTx{exchangeChan.WatchRefundAdr: userBreach, userPKH: exchangeBreach} {
+		if err = tower.NewChannel(lnutil.NewWatchDescMsg(0, coin.HDCoinType, pkh, 5, 5000, [33]byte{}, [33]byte{})); err != nil {
+			t.Fatalf("watch channel: %v", err)
+		}
+		var parTxid [16]byte
+		txid := breach.TxHash()
+		copy(parTxid[:], txid[:16])
+		if err = tower.UpdateChannel(lnutil.NewComMsg(0, coin.HDCoinType, pkh, chainhash.Hash{0x07}, parTxid, [64]byte{})); err != nil {
+			t.Fatalf("watch state: %v", err)
+		}
+	}
+	if watched, next, err := server.tower.nextState(exchangeChan.WatchRefundAdr); err != nil || !watched || next != 1 {
+		t.Fatalf("Tower should want state 1 next, got %t, %d and %v", watched, next, err)
+	}
+
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0xffffffff}, nil, nil))
+	unrelated := wire.NewMsgTx()
+	unrelated.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{0x09}}, nil, nil))
+	block := &wire.MsgBlock{Transactions: []*wire.MsgTx{coinbase, userBreach, unrelated, exchangeBreach}}
+
+	txids, err := block.TxHashes()
+	if err != nil {
+		t.Fatalf("txids: %v", err)
+	}
+	hits, err := tower.MatchTxids(coin.HDCoinType, txids)
+	if err != nil || len(hits) != 2 {
+		t.Fatalf("Tower should match both breaches, got %d and %v", len(hits), err)
+	}
+	alerts, err := server.alertsForBreaches(coin, block, hits, []*qln.Qchan{exchangeChan, userChan})
+	if err != nil || len(alerts) != 2 {
+		t.Fatalf("Should get two alerts, got %d and %v", len(alerts), err)
+	}
+	if alerts[0].ByExchange || alerts[0].Peer != 3 || alerts[0].Outpoint != exchangeChan.Op.String() {
+		t.Errorf("First breach should be by peer 3 on the exchange's channel, got %s", alerts[0])
+	}
+	if !alerts[1].ByExchange || alerts[1].Peer != 4 || alerts[1].Outpoint != userChan.Op.String() {
+		t.Errorf("Second breach should be by the exchange against peer 4, got %s", alerts[1])
+	}
+}
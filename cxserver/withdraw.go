@@ -1,151 +1,288 @@
 package cxserver
 
 import (
-	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/lnp2p"
 	"github.com/mit-dci/lit/qln"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
 	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
 
 	"github.com/mit-dci/lit/lnutil"
 
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
-	"github.com/mit-dci/lit/portxo"
-
-	"github.com/mit-dci/lit/wire"
-
-	"github.com/mit-dci/lit/btcutil/txscript"
-
-	"github.com/mit-dci/lit/btcutil"
 )
 
 // TODO: refactor entire database, match, and asset stuff to support our new automated way of hooks and wallets
 
-// WithdrawCoins inputs the correct parameters to return a withdraw txid
-func (server *OpencxServer) WithdrawCoins(address string, pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) (txid string, err error) {
+// RequestWithdrawal queues a signed on-chain withdrawal. The amount is taken out of the user's
+// balance right away and held until the withdrawal is confirmed, or given back if it fails.
+// Withdrawals over the coin's approval threshold wait for an operator, the rest go out in the next
+// batch. The signature is stored with the request so the withdrawal can always be traced to the
+// user that signed it.
+func (server *OpencxServer) RequestWithdrawal(signed *match.Withdrawal, signature []byte, params *coinparam.Params) (withdrawal *match.WithdrawalRequest, err error) {
+
+	if signed.Lightning {
+		err = fmt.Errorf("Lightning withdrawals can't be queued, use WithdrawLightning")
+		return
+	}
 
 	// TODO: change everything to int64 and just deal with the negatives in error handling. Casting is probably more dangerous
 	// if you try to withdraw an overflow amount then get out
-	if int64(amount) < 0 {
+	if int64(signed.Amount) < 0 {
 		err = fmt.Errorf("That amount would have caused an overflow, enter something lower")
 		return
 	}
 
-	// Create the function, basically make sure the wallet stuff is alright
-	var withdrawFunction func(string, *koblitz.PublicKey, uint64) (string, error)
-	if withdrawFunction, err = server.withdrawFromChain(params); err != nil {
-		err = fmt.Errorf("Error creating withdraw function: \n%s", err)
+	if signed.Amount == 0 {
+		err = fmt.Errorf("You can't withdraw 0 %s", params.Name)
 		return
 	}
-	// Actually try to withdraw
-	if txid, err = withdrawFunction(address, pubkey, amount); err != nil {
-		err = fmt.Errorf("Error withdrawing coins: \n%s", err)
+
+	var info *match.AssetInfo
+	if info, err = match.AssetInfoFromCoinParam(params); err != nil {
+		err = fmt.Errorf("Error getting asset for RequestWithdrawal: %s", err)
+		return
+	}
+	if signed.Amount < info.MinWithdrawal {
+		err = fmt.Errorf("You can't withdraw less than %d %s", info.MinWithdrawal, params.Name)
 		return
 	}
-	return
-}
 
-// WithdrawLightning inputs the correct parameters to return a correct txid associated with a channel outpoint
-func (server *OpencxServer) WithdrawLightning(pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) (txid string, err error) {
+	if !signed.Priority.Valid() {
+		err = fmt.Errorf("Unknown fee priority %d", signed.Priority)
+		return
+	}
 
-	// TODO: change everything to int64 and just deal with the negatives in error handling. Casting is probably more dangerous
-	// if you try to withdraw an overflow amount then get out
-	if int64(amount) < 0 {
-		err = fmt.Errorf("That amount would have caused an overflow, enter something lower")
+	// Make sure the address is real before we take anything out of the user's balance, the
+	// batcher can't do anything about it later
+	if err = util.ValidateAddress(signed.Address, params); err != nil {
+		err = fmt.Errorf("Invalid address %s for RequestWithdrawal: %s", signed.Address, err)
 		return
 	}
 
-	// Create the function, basically make sure the wallet stuff is alright
-	var withdrawFunction func(*koblitz.PublicKey, int64) (string, error)
-	if withdrawFunction, err = server.withdrawFromLightning(params); err != nil {
-		err = fmt.Errorf("Error creating withdraw function: \n%s", err)
+	// We can't send anything for a coin we don't have a wallet for
+	server.walletMtx.Lock()
+	_, found := server.WalletMap[params]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s, can't withdraw", params.Name)
 		return
 	}
 
-	// Actually try to withdraw
-	if txid, err = withdrawFunction(pubkey, int64(amount)); err != nil {
-		err = fmt.Errorf("Error withdrawing coins: \n%s", err)
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	server.dbLock.Lock()
+	currWithdrawalStore, ok := server.WithdrawalStores[params]
+	policy, hasPolicy := server.WithdrawalPolicies[params]
+	server.dbLock.Unlock()
+	if !ok {
+		err = fmt.Errorf("Could not find withdrawal store for %s for RequestWithdrawal", params.Name)
+		return
+	}
+	if !hasPolicy {
+		policy = new(match.WithdrawalPolicy)
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = server.verifyWithdrawal(currWithdrawalStore, signed, signature, params); err != nil {
+		err = fmt.Errorf("Error verifying withdrawal for RequestWithdrawal: %s", err)
+		return
+	}
+
+	now := time.Now()
+	var withdrawn uint64
+	if withdrawn, err = server.withdrawnSince(currWithdrawalStore, pubkey, now.Add(-match.WithdrawalLimitWindow)); err != nil {
+		err = fmt.Errorf("Error getting recent withdrawals for RequestWithdrawal: %s", err)
+		return
+	}
+	if !policy.WithinLimit(withdrawn, signed.Amount) {
+		err = fmt.Errorf("Withdrawing %d %s would go over the daily limit of %d, you've withdrawn %d in the last %s", signed.Amount, params.Name, policy.DailyLimit, withdrawn, match.WithdrawalLimitWindow)
 		return
 	}
+
+	withdrawal = newWithdrawalRequest(signed, signature, pubkey, match.WithdrawalApproved, now)
+	if policy.NeedsApproval(signed.Amount) {
+		withdrawal.State = match.WithdrawalRequested
+	}
+
+	// hold the amount in the settlement layer until the withdrawal is final
+	if err = server.CreditUser(pubkey, signed.Amount, params); err != nil {
+		err = fmt.Errorf("Error reserving withdrawal amount for RequestWithdrawal: %s", err)
+		return
+	}
+
+	if err = currWithdrawalStore.AddWithdrawal(withdrawal); err != nil {
+		err = fmt.Errorf("Error adding withdrawal for RequestWithdrawal: %s", err)
+		// the withdrawal was never stored so it will never go out, give the amount back
+		if refundErr := server.debitUser(pubkey, signed.Amount, params, cxevent.WithdrawalRefundEvent); refundErr != nil {
+			logging.Errorf("Error giving back %d %s to %x after failing to store withdrawal: %s", signed.Amount, params.Name, withdrawal.Pubkey, refundErr)
+		}
+		return
+	}
+
+	logging.Infof("Queued %s", withdrawal)
 	return
 }
 
-// withdrawFromChain returns a function that we'll then call from the vtc stuff -- this is a closure that's also a method for server, don't worry about it lol
-func (server *OpencxServer) withdrawFromChain(params *coinparam.Params) (withdrawFunction func(string, *koblitz.PublicKey, uint64) (string, error), err error) {
+// verifyWithdrawal checks that a signed withdrawal is for this exchange and this coin, and that
+// the user hasn't used its nonce before, then returns the pubkey that signed it. withdrawalMtx
+// should be held.
+func (server *OpencxServer) verifyWithdrawal(store cxdb.WithdrawalStore, signed *match.Withdrawal, signature []byte, params *coinparam.Params) (pubkey *koblitz.PublicKey, err error) {
+	if domain := server.GetWithdrawalDomain(); signed.Domain != domain {
+		err = fmt.Errorf("Withdrawal was signed for %s, this exchange is %s", signed.Domain, domain)
+		return
+	}
 
-	// Try to get correct wallet
-	wallet, found := server.WalletMap[params]
-	if !found {
-		err = fmt.Errorf("Could not find wallet for those coin params")
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(params); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param: %s", err)
+		return
+	}
+	if signed.Asset != asset {
+		err = fmt.Errorf("Withdrawal was signed for %s, not %s", signed.Asset, asset)
 		return
 	}
 
-	withdrawFunction = func(address string, pubkey *koblitz.PublicKey, amount uint64) (txid string, err error) {
+	if pubkey, err = signed.RecoverPubkey(signature); err != nil {
+		return
+	}
 
-		if amount == 0 {
-			err = fmt.Errorf("You can't withdraw 0 %s", params.Name)
+	var withdrawals []*match.WithdrawalRequest
+	if withdrawals, err = store.GetWithdrawals(pubkey); err != nil {
+		err = fmt.Errorf("Error getting withdrawals to check nonce: %s", err)
+		return
+	}
+	for _, withdrawal := range withdrawals {
+		if withdrawal.Nonce == signed.Nonce {
+			err = fmt.Errorf("Nonce %d was already used for withdrawal %d", signed.Nonce, withdrawal.ID)
 			return
 		}
+	}
+	return
+}
 
-		// clearing settlement layer
-		if err = server.CreditUser(pubkey, amount, params); err != nil {
-			err = fmt.Errorf("Error while crediting user for CreateChannel: %s\n", err)
-			return
-		}
+// newWithdrawalRequest creates the request that gets stored for a signed withdrawal
+func newWithdrawalRequest(signed *match.Withdrawal, signature []byte, pubkey *koblitz.PublicKey, state match.WithdrawalState, requestTime time.Time) (withdrawal *match.WithdrawalRequest) {
+	withdrawal = &match.WithdrawalRequest{
+		Asset:     signed.Asset,
+		Amount:    signed.Amount,
+		Address:   signed.Address,
+		State:     state,
+		Requested: requestTime,
+		Updated:   requestTime,
+		Lightning: signed.Lightning,
+		Nonce:     signed.Nonce,
+		Domain:    signed.Domain,
+		Signature: signature,
+		Priority:  signed.Priority,
+		PayFee:    signed.PayFee,
+	}
+	copy(withdrawal.Pubkey[:], pubkey.SerializeCompressed())
+	return
+}
 
-		// Decoding given address
-		var addr btcutil.Address
-		if addr, err = btcutil.DecodeAddress(address, params); err != nil {
-			return
+// withdrawnSince adds up how much a pubkey has withdrawn since a time, not counting withdrawals
+// that failed.
+func (server *OpencxServer) withdrawnSince(store cxdb.WithdrawalStore, pubkey *koblitz.PublicKey, since time.Time) (withdrawn uint64, err error) {
+	var withdrawals []*match.WithdrawalRequest
+	if withdrawals, err = store.GetWithdrawals(pubkey); err != nil {
+		return
+	}
+	for _, withdrawal := range withdrawals {
+		if withdrawal.State != match.WithdrawalFailed && !withdrawal.Requested.Before(since) {
+			withdrawn += withdrawal.Amount
 		}
+	}
+	return
+}
 
-		// for paying the other person
-		var payToUserScript []byte
-		if payToUserScript, err = txscript.PayToAddrScript(addr); err != nil {
-			return
-		}
+// WithdrawLightning withdraws a signed lightning withdrawal by pushing the amount to the user in
+// a new channel, and returns the channel's funding txid. Lightning withdrawals happen right away,
+// so they're stored as confirmed along with their signature. They count towards the daily limit,
+// and amounts that would need approval are rejected.
+func (server *OpencxServer) WithdrawLightning(signed *match.Withdrawal, signature []byte, params *coinparam.Params) (txid string, err error) {
 
-		// pick inputs for transaction, idk what the fee shoud be, I think this is the correct byte size but I'm not too sure
-		var utxoSlice portxo.TxoSliceByBip69
-		var overshoot int64
-		if utxoSlice, overshoot, err = wallet.PickUtxos(int64(amount), int64(len(payToUserScript)), 1000, false); err != nil {
-			return
-		}
+	if !signed.Lightning {
+		err = fmt.Errorf("On-chain withdrawals have to be queued, use RequestWithdrawal")
+		return
+	}
 
-		// for giving back the wallet change
-		var changeOut *wire.TxOut
-		if changeOut, err = wallet.NewChangeOut(overshoot); err != nil {
-			return
-		}
+	// TODO: change everything to int64 and just deal with the negatives in error handling. Casting is probably more dangerous
+	// if you try to withdraw an overflow amount then get out
+	if int64(signed.Amount) < 0 {
+		err = fmt.Errorf("That amount would have caused an overflow, enter something lower")
+		return
+	}
 
-		// create paytouser txout, we already have change txout from newchangeout
-		payToUserTxOut := wire.NewTxOut(int64(amount), payToUserScript)
+	// the nonce can't be used by anyone else until the withdrawal is stored
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
 
-		// build the transaction
-		var withdrawTx *wire.MsgTx
-		if withdrawTx, err = wallet.BuildAndSign(utxoSlice, []*wire.TxOut{changeOut, payToUserTxOut}, 0); err != nil {
-			return
-		}
+	server.dbLock.Lock()
+	currWithdrawalStore, ok := server.WithdrawalStores[params]
+	policy, hasPolicy := server.WithdrawalPolicies[params]
+	server.dbLock.Unlock()
+	if !ok {
+		err = fmt.Errorf("Could not find withdrawal store for %s for WithdrawLightning", params.Name)
+		return
+	}
+	if !hasPolicy {
+		policy = new(match.WithdrawalPolicy)
+	}
 
-		buf := new(bytes.Buffer)
-		if err = withdrawTx.Serialize(buf); err != nil {
-			return
-		}
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = server.verifyWithdrawal(currWithdrawalStore, signed, signature, params); err != nil {
+		err = fmt.Errorf("Error verifying withdrawal for WithdrawLightning: %s", err)
+		return
+	}
 
-		// Copying and pasting this into the node and sending works
-		// The issue where the nodes weren't really adding the tx to the mempool was weird
-		// logging.Infof("Serialized transaction: %x\n", buf.Bytes())
+	now := time.Now()
+	var withdrawn uint64
+	if withdrawn, err = server.withdrawnSince(currWithdrawalStore, pubkey, now.Add(-match.WithdrawalLimitWindow)); err != nil {
+		err = fmt.Errorf("Error getting recent withdrawals for WithdrawLightning: %s", err)
+		return
+	}
+	if !policy.WithinLimit(withdrawn, signed.Amount) {
+		err = fmt.Errorf("Withdrawing %d %s would go over the daily limit of %d, you've withdrawn %d in the last %s", signed.Amount, params.Name, policy.DailyLimit, withdrawn, match.WithdrawalLimitWindow)
+		return
+	}
+	// lightning withdrawals go out right away, so they can't wait for an operator
+	if policy.NeedsApproval(signed.Amount) {
+		err = fmt.Errorf("Withdrawing %d %s needs to be approved, which lightning withdrawals can't wait for, withdraw on-chain instead", signed.Amount, params.Name)
+		return
+	}
 
-		// send out the transaction
-		if err = wallet.NewOutgoingTx(withdrawTx); err != nil {
-			return
-		}
+	// Create the function, basically make sure the wallet stuff is alright
+	var withdrawFunction func(*koblitz.PublicKey, int64) (string, error)
+	if withdrawFunction, err = server.withdrawFromLightning(params); err != nil {
+		err = fmt.Errorf("Error creating withdraw function: \n%s", err)
+		return
+	}
 
-		return withdrawTx.TxHash().String(), nil
+	// Actually try to withdraw
+	if txid, err = withdrawFunction(pubkey, int64(signed.Amount)); err != nil {
+		err = fmt.Errorf("Error withdrawing coins: \n%s", err)
+		return
 	}
+
+	withdrawal := newWithdrawalRequest(signed, signature, pubkey, match.WithdrawalConfirmed, now)
+	withdrawal.Txid = txid
+	if err = currWithdrawalStore.AddWithdrawal(withdrawal); err != nil {
+		// the channel is already funded, so all we can do is make sure someone sees this
+		logging.Errorf("Error storing lightning withdrawal %s with signature %x after sending it: %s", withdrawal, signature, err)
+		err = fmt.Errorf("Withdrawal was sent in %s but could not be stored: %s", txid, err)
+		return
+	}
+
+	logging.Infof("Sent lightning %s", withdrawal)
 	return
 }
 
@@ -0,0 +1,456 @@
+package cxserver
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wallit"
+	"github.com/mit-dci/lit/wire"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	// stuckWithdrawalBlocks is how many blocks a withdrawal transaction can go without confirming
+	// before it's replaced with one paying a higher fee
+	stuckWithdrawalBlocks = 3
+	// rbfSequence is the sequence withdrawal inputs have, which signals they can be replaced
+	rbfSequence = wire.MaxTxInSequenceNum - 2
+	// minFeeRateBump is how much higher, in satoshis per vbyte, a replacement's fee rate has to be
+	// than the transaction it replaces. It's the default incremental relay fee.
+	minFeeRateBump = 1
+	// dustLimit is the smallest output nodes will relay
+	dustLimit = 546
+)
+
+// withdrawalBatch is a withdrawal transaction that hasn't confirmed yet, along with what it takes
+// to replace it. Every version of the transaction spends the same inputs and pays the same
+// withdrawal outputs, only the change is different. Batches are kept in the withdrawal store as a
+// match.WithdrawalBatch, so transactions sent before a restart can still be replaced.
+type withdrawalBatch struct {
+	priority match.FeePriority
+	// ids are the withdrawals in the batch
+	ids          []uint64
+	inputs       portxo.TxoSliceByBip69
+	inputTotal   int64
+	txOuts       []*wire.TxOut
+	changeScript []byte
+	feeRate      int64
+	// sentHeight is the height of the chain when the current version was sent
+	sentHeight uint64
+	// txs are every version of the transaction, the current one last
+	txs []*wire.MsgTx
+}
+
+// current returns the latest version of the batch's transaction
+func (batch *withdrawalBatch) current() (tx *wire.MsgTx) {
+	tx = batch.txs[len(batch.txs)-1]
+	return
+}
+
+// stored returns the batch the way it's kept in the withdrawal store
+func (batch *withdrawalBatch) stored() (stored *match.WithdrawalBatch, err error) {
+	stored = &match.WithdrawalBatch{
+		Txid:         batch.txs[0].TxHash().String(),
+		Priority:     batch.priority,
+		IDs:          batch.ids,
+		InputTotal:   batch.inputTotal,
+		Outputs:      batch.txOuts,
+		ChangeScript: batch.changeScript,
+		FeeRate:      batch.feeRate,
+		SentHeight:   batch.sentHeight,
+	}
+	for _, input := range batch.inputs {
+		var raw []byte
+		if raw, err = input.Bytes(); err != nil {
+			err = fmt.Errorf("Error serializing input %s of withdrawal batch: %s", input.Op.String(), err)
+			return
+		}
+		stored.Inputs = append(stored.Inputs, raw)
+	}
+	for _, tx := range batch.txs {
+		var buf bytes.Buffer
+		if err = tx.Serialize(&buf); err != nil {
+			err = fmt.Errorf("Error serializing withdrawal transaction %s: %s", tx.TxHash(), err)
+			return
+		}
+		stored.Txs = append(stored.Txs, buf.Bytes())
+	}
+	return
+}
+
+// withdrawalBatchFromStore turns a batch from the withdrawal store back into one that can be
+// replaced
+func withdrawalBatchFromStore(stored *match.WithdrawalBatch) (batch *withdrawalBatch, err error) {
+	batch = &withdrawalBatch{
+		priority:     stored.Priority,
+		ids:          stored.IDs,
+		inputTotal:   stored.InputTotal,
+		txOuts:       stored.Outputs,
+		changeScript: stored.ChangeScript,
+		feeRate:      stored.FeeRate,
+		sentHeight:   stored.SentHeight,
+	}
+	for _, raw := range stored.Inputs {
+		var input *portxo.PorTxo
+		if input, err = portxo.PorTxoFromBytes(raw); err != nil {
+			err = fmt.Errorf("Error deserializing input of withdrawal batch %s: %s", stored.Txid, err)
+			return
+		}
+		batch.inputs = append(batch.inputs, input)
+	}
+	for _, raw := range stored.Txs {
+		tx := wire.NewMsgTx()
+		if err = tx.Deserialize(bytes.NewReader(raw)); err != nil {
+			err = fmt.Errorf("Error deserializing transaction of withdrawal batch %s: %s", stored.Txid, err)
+			return
+		}
+		batch.txs = append(batch.txs, tx)
+	}
+	if len(batch.txs) == 0 {
+		err = fmt.Errorf("Withdrawal batch %s has no transactions", stored.Txid)
+		return
+	}
+	return
+}
+
+// putWithdrawalBatch stores a batch in the withdrawal store
+func putWithdrawalBatch(store cxdb.WithdrawalStore, batch *withdrawalBatch) (err error) {
+	var stored *match.WithdrawalBatch
+	if stored, err = batch.stored(); err != nil {
+		return
+	}
+	if err = store.PutWithdrawalBatch(stored); err != nil {
+		return
+	}
+	return
+}
+
+// getWithdrawalBatches gets every batch of a coin that hasn't confirmed yet from the withdrawal
+// store
+func getWithdrawalBatches(store cxdb.WithdrawalStore) (batches []*withdrawalBatch, err error) {
+	var stored []*match.WithdrawalBatch
+	if stored, err = store.GetWithdrawalBatches(); err != nil {
+		return
+	}
+	for _, storedBatch := range stored {
+		var batch *withdrawalBatch
+		if batch, err = withdrawalBatchFromStore(storedBatch); err != nil {
+			return
+		}
+		batches = append(batches, batch)
+	}
+	return
+}
+
+// changeOutPoint returns the outpoint of the change in a version of the batch's transaction
+func (batch *withdrawalBatch) changeOutPoint(tx *wire.MsgTx) (op wire.OutPoint, err error) {
+	for i, txOut := range tx.TxOut {
+		if bytes.Equal(txOut.PkScript, batch.changeScript) {
+			op = wire.OutPoint{Hash: tx.TxHash(), Index: uint32(i)}
+			return
+		}
+	}
+	err = fmt.Errorf("Transaction %s has no change output", tx.TxHash())
+	return
+}
+
+// recordBlockFees adds the fee rate of a block to the coin's fee estimates
+func (server *OpencxServer) recordBlockFees(txList []*wire.MsgTx, height uint64, coin *coinparam.Params) {
+	feeRate := match.BlockFeeRate(txList, height, coin.SubsidyReductionInterval)
+
+	server.dbLock.Lock()
+	estimator, ok := server.feeEstimators[coin]
+	if !ok {
+		estimator = match.NewFeeEstimator()
+		server.feeEstimators[coin] = estimator
+	}
+	estimator.AddBlock(height, feeRate)
+	server.dbLock.Unlock()
+	return
+}
+
+// disconnectFeesAboveHeight stops estimating fees from blocks that were disconnected in a reorg
+func (server *OpencxServer) disconnectFeesAboveHeight(height uint64, coin *coinparam.Params) {
+	server.dbLock.Lock()
+	if estimator, ok := server.feeEstimators[coin]; ok {
+		estimator.DisconnectBlocks(height)
+	}
+	server.dbLock.Unlock()
+	return
+}
+
+// feeRate returns the fee rate, in satoshis per vbyte, that a transaction of a coin pays at a
+// priority. It's estimated from recent blocks, and is the wallet's fixed fee rate until there
+// have been some.
+func (server *OpencxServer) feeRate(coin *coinparam.Params, priority match.FeePriority) (feeRate int64, err error) {
+	server.dbLock.Lock()
+	if estimator, ok := server.feeEstimators[coin]; ok {
+		feeRate, ok = estimator.Estimate(priority)
+		if ok {
+			server.dbLock.Unlock()
+			return
+		}
+	}
+	server.dbLock.Unlock()
+
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s", coin.Name)
+		return
+	}
+	feeRate = wallet.Fee()
+	return
+}
+
+// GetFeeEstimates returns the fee rates withdrawals of a coin pay at each priority
+func (server *OpencxServer) GetFeeEstimates(coin *coinparam.Params) (estimates *match.FeeEstimates, err error) {
+	estimates = new(match.FeeEstimates)
+	if estimates.Asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param for GetFeeEstimates: %s", err)
+		return
+	}
+
+	if estimates.Low, err = server.feeRate(coin, match.FeePriorityLow); err != nil {
+		err = fmt.Errorf("Error getting low fee rate for GetFeeEstimates: %s", err)
+		return
+	}
+	if estimates.Normal, err = server.feeRate(coin, match.FeePriorityNormal); err != nil {
+		err = fmt.Errorf("Error getting normal fee rate for GetFeeEstimates: %s", err)
+		return
+	}
+	if estimates.High, err = server.feeRate(coin, match.FeePriorityHigh); err != nil {
+		err = fmt.Errorf("Error getting high fee rate for GetFeeEstimates: %s", err)
+		return
+	}
+
+	server.dbLock.Lock()
+	if estimator, ok := server.feeEstimators[coin]; ok {
+		estimates.Blocks = estimator.Blocks()
+	}
+	server.dbLock.Unlock()
+	return
+}
+
+// bumpStuckWithdrawals replaces the withdrawal transactions of a coin that have gone
+// stuckWithdrawalBlocks without confirming with ones paying a higher fee. The exchange pays for
+// the bump, it comes out of the change.
+func (server *OpencxServer) bumpStuckWithdrawals(height uint64, coin *coinparam.Params) (err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	server.dbLock.Lock()
+	currWithdrawalStore, ok := server.WithdrawalStores[coin]
+	server.dbLock.Unlock()
+	// coins without a withdrawal store can't have any withdrawals to bump
+	if !ok {
+		return
+	}
+
+	var batches []*withdrawalBatch
+	if batches, err = getWithdrawalBatches(currWithdrawalStore); err != nil {
+		err = fmt.Errorf("Error getting withdrawal batches for bumpStuckWithdrawals: %s", err)
+		return
+	}
+	if len(batches) == 0 {
+		return
+	}
+
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s for bumpStuckWithdrawals", coin.Name)
+		return
+	}
+
+	// one batch that can't be bumped shouldn't stop the others
+	var bumpErrs []string
+	for _, batch := range batches {
+		if height < batch.sentHeight+stuckWithdrawalBlocks {
+			continue
+		}
+		if bumpErr := server.bumpWithdrawalBatch(currWithdrawalStore, wallet, batch, height, coin); bumpErr != nil {
+			bumpErrs = append(bumpErrs, bumpErr.Error())
+		}
+	}
+	if len(bumpErrs) != 0 {
+		err = fmt.Errorf("Could not bump %d %s withdrawal transactions: %v", len(bumpErrs), coin.Name, bumpErrs)
+		return
+	}
+	return
+}
+
+// bumpWithdrawalBatch replaces a batch's transaction with one paying the current fee rate for its
+// priority, or at least minFeeRateBump more than it pays now. withdrawalMtx should be held.
+func (server *OpencxServer) bumpWithdrawalBatch(store cxdb.WithdrawalStore, wallet *wallit.Wallit, batch *withdrawalBatch, height uint64, coin *coinparam.Params) (err error) {
+	stuckTx := batch.current()
+	stuckTxid := stuckTx.TxHash().String()
+
+	// replacing the transaction would throw out anything spending its change
+	var changeOp wire.OutPoint
+	if changeOp, err = batch.changeOutPoint(stuckTx); err != nil {
+		return
+	}
+	var utxos []*portxo.PorTxo
+	if utxos, err = wallet.GetAllUtxos(); err != nil {
+		err = fmt.Errorf("Error getting wallet utxos to bump %s: %s", stuckTxid, err)
+		return
+	}
+	unspent := false
+	for _, utxo := range utxos {
+		unspent = unspent || utxo.Op == changeOp
+	}
+	if !unspent {
+		err = fmt.Errorf("The change of %s has been spent, it can't be replaced", stuckTxid)
+		return
+	}
+
+	var feeRate int64
+	if feeRate, err = server.feeRate(coin, batch.priority); err != nil {
+		err = fmt.Errorf("Error getting fee rate to bump %s: %s", stuckTxid, err)
+		return
+	}
+	if feeRate < batch.feeRate+minFeeRateBump {
+		feeRate = batch.feeRate + minFeeRateBump
+	}
+
+	// the replacement is the same size, so the new fee is the new rate times the old size
+	change := batch.inputTotal - match.TxVsize(stuckTx)*feeRate
+	for _, txOut := range batch.txOuts {
+		change -= txOut.Value
+	}
+	if change < dustLimit {
+		err = fmt.Errorf("There isn't enough change in %s to pay %d per vbyte", stuckTxid, feeRate)
+		return
+	}
+
+	txOuts := append([]*wire.TxOut{}, batch.txOuts...)
+	txOuts = append(txOuts, wire.NewTxOut(change, batch.changeScript))
+
+	var replacement *wire.MsgTx
+	if replacement, err = wallet.BuildAndSign(batch.inputs, txOuts, 0); err != nil {
+		err = fmt.Errorf("Error building replacement for %s: %s", stuckTxid, err)
+		return
+	}
+	if err = wallet.NewOutgoingTx(replacement); err != nil {
+		err = fmt.Errorf("Error sending replacement for %s: %s", stuckTxid, err)
+		return
+	}
+	txid := replacement.TxHash().String()
+	logging.Infof("Replaced %s withdrawal transaction %s with %s paying %d per vbyte", coin.Name, stuckTxid, txid, feeRate)
+
+	// the wallet doesn't forget outputs of replaced transactions, so it can't spend the old change
+	setFrozen(wallet, changeOp, true)
+
+	batch.txs = append(batch.txs, replacement)
+	batch.feeRate = feeRate
+	batch.sentHeight = height
+
+	// The replacement is out, so the batch and every withdrawal in it have to point to it even if
+	// one of them can't be updated
+	var updateErrs []string
+	if updateErr := putWithdrawalBatch(store, batch); updateErr != nil {
+		logging.Errorf("Error storing replacement %s of %s: %s", txid, stuckTxid, updateErr)
+		updateErrs = append(updateErrs, updateErr.Error())
+	}
+	now := time.Now()
+	for _, id := range batch.ids {
+		var withdrawal *match.WithdrawalRequest
+		var updateErr error
+		if withdrawal, updateErr = store.GetWithdrawal(id); updateErr == nil {
+			withdrawal.Txid = txid
+			withdrawal.Updated = now
+			updateErr = store.UpdateWithdrawal(withdrawal)
+		}
+		if updateErr != nil {
+			logging.Errorf("Error moving withdrawal %d from %s to %s: %s", id, stuckTxid, txid, updateErr)
+			updateErrs = append(updateErrs, updateErr.Error())
+		}
+	}
+	if len(updateErrs) != 0 {
+		err = fmt.Errorf("Sent %s but could not move the batch and its withdrawals to it: %v", txid, updateErrs)
+		return
+	}
+	return
+}
+
+// settleWithdrawalBatch is called when one version of a batch's transaction confirms. Only that
+// version's change is real, so the change of every other version is frozen. withdrawalMtx should
+// be held.
+func (server *OpencxServer) settleWithdrawalBatch(batch *withdrawalBatch, confirmed *wire.MsgTx, coin *coinparam.Params) {
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		return
+	}
+
+	for _, tx := range batch.txs {
+		changeOp, err := batch.changeOutPoint(tx)
+		if err != nil {
+			logging.Errorf("Error finding change of withdrawal transaction: %s", err)
+			continue
+		}
+		setFrozen(wallet, changeOp, tx != confirmed)
+	}
+	return
+}
+
+// freezeReplacedChange freezes the change of every replaced version of a coin's unconfirmed
+// withdrawal transactions. The wallet only keeps frozen outputs in memory, so this is done again
+// whenever the wallet is added, otherwise it could spend change that will never confirm.
+func (server *OpencxServer) freezeReplacedChange(wallet *wallit.Wallit) (err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	server.dbLock.Lock()
+	currWithdrawalStore, ok := server.WithdrawalStores[wallet.Param]
+	server.dbLock.Unlock()
+	if !ok {
+		return
+	}
+
+	var batches []*withdrawalBatch
+	if batches, err = getWithdrawalBatches(currWithdrawalStore); err != nil {
+		err = fmt.Errorf("Error getting withdrawal batches for freezeReplacedChange: %s", err)
+		return
+	}
+	for _, batch := range batches {
+		for _, tx := range batch.txs[:len(batch.txs)-1] {
+			var changeOp wire.OutPoint
+			if changeOp, err = batch.changeOutPoint(tx); err != nil {
+				err = fmt.Errorf("Error finding change of replaced withdrawal transaction for freezeReplacedChange: %s", err)
+				return
+			}
+			setFrozen(wallet, changeOp, true)
+		}
+	}
+	return
+}
+
+// setFrozen freezes or unfreezes an output of the wallet, the wallet never spends frozen outputs
+func setFrozen(wallet *wallit.Wallit, op wire.OutPoint, frozen bool) {
+	wallet.FreezeMutex.Lock()
+	if frozen {
+		wallet.FreezeSet[op] = &wallit.FrozenTx{Txid: op.Hash}
+	} else {
+		delete(wallet.FreezeSet, op)
+	}
+	wallet.FreezeMutex.Unlock()
+	return
+}
+
+// isFrozen returns true if the wallet won't spend an output
+func isFrozen(wallet *wallit.Wallit, op wire.OutPoint) (frozen bool) {
+	wallet.FreezeMutex.Lock()
+	_, frozen = wallet.FreezeSet[op]
+	wallet.FreezeMutex.Unlock()
+	return
+}
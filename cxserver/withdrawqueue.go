@@ -0,0 +1,521 @@
+package cxserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wallit"
+	"github.com/mit-dci/lit/wire"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxevent"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// maxWithdrawalBatch is the most withdrawals that go in one transaction, the rest wait for the
+// next batch
+const maxWithdrawalBatch = 250
+
+// SetWithdrawalPolicy sets the daily limit and approval threshold for withdrawals of a coin. It
+// only applies to withdrawals requested after it's set. dbLock should not be held.
+func (server *OpencxServer) SetWithdrawalPolicy(coin *coinparam.Params, policy *match.WithdrawalPolicy) {
+	server.dbLock.Lock()
+	server.WithdrawalPolicies[coin] = policy
+	server.dbLock.Unlock()
+	return
+}
+
+// SetAdminPubkey sets the key that can approve and reject withdrawals. dbLock should not be held.
+func (server *OpencxServer) SetAdminPubkey(pubkey *koblitz.PublicKey) {
+	server.dbLock.Lock()
+	server.AdminPubkey = pubkey
+	server.dbLock.Unlock()
+	return
+}
+
+// SetWithdrawalDomain sets the domain signed withdrawals have to be for. dbLock should not be held.
+func (server *OpencxServer) SetWithdrawalDomain(domain string) {
+	server.dbLock.Lock()
+	server.WithdrawalDomain = domain
+	server.dbLock.Unlock()
+	return
+}
+
+// GetWithdrawalDomain returns the domain signed withdrawals have to be for
+func (server *OpencxServer) GetWithdrawalDomain() (domain string) {
+	server.dbLock.Lock()
+	domain = server.WithdrawalDomain
+	server.dbLock.Unlock()
+	return
+}
+
+// CheckAdmin returns an error if pubkey isn't the admin pubkey
+func (server *OpencxServer) CheckAdmin(pubkey *koblitz.PublicKey) (err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	if server.AdminPubkey == nil {
+		err = fmt.Errorf("This exchange doesn't have an admin key, admin commands are disabled")
+		return
+	}
+	if !server.AdminPubkey.IsEqual(pubkey) {
+		err = fmt.Errorf("Pubkey %x is not the admin pubkey", pubkey.SerializeCompressed())
+		return
+	}
+	return
+}
+
+// withdrawalStore gets the withdrawal store for a coin
+func (server *OpencxServer) withdrawalStore(coin *coinparam.Params) (store cxdb.WithdrawalStore, err error) {
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+
+	var ok bool
+	if store, ok = server.WithdrawalStores[coin]; !ok {
+		err = fmt.Errorf("Could not find withdrawal store for %s", coin.Name)
+		return
+	}
+	return
+}
+
+// GetWithdrawals gets every withdrawal a pubkey has requested for a coin, oldest first
+func (server *OpencxServer) GetWithdrawals(pubkey *koblitz.PublicKey, coin *coinparam.Params) (withdrawals []*match.WithdrawalRequest, err error) {
+	var currWithdrawalStore cxdb.WithdrawalStore
+	if currWithdrawalStore, err = server.withdrawalStore(coin); err != nil {
+		err = fmt.Errorf("Error getting withdrawal store for GetWithdrawals: %s", err)
+		return
+	}
+
+	if withdrawals, err = currWithdrawalStore.GetWithdrawals(pubkey); err != nil {
+		err = fmt.Errorf("Error getting withdrawals from store for GetWithdrawals: %s", err)
+		return
+	}
+	return
+}
+
+// ListWithdrawals gets every withdrawal of a coin in a state, oldest first. It's for operators,
+// so they can see what's waiting for approval.
+func (server *OpencxServer) ListWithdrawals(coin *coinparam.Params, state match.WithdrawalState) (withdrawals []*match.WithdrawalRequest, err error) {
+	var currWithdrawalStore cxdb.WithdrawalStore
+	if currWithdrawalStore, err = server.withdrawalStore(coin); err != nil {
+		err = fmt.Errorf("Error getting withdrawal store for ListWithdrawals: %s", err)
+		return
+	}
+
+	if withdrawals, err = currWithdrawalStore.GetWithdrawalsByState(state); err != nil {
+		err = fmt.Errorf("Error getting withdrawals from store for ListWithdrawals: %s", err)
+		return
+	}
+	return
+}
+
+// ApproveWithdrawal lets a withdrawal that was waiting for an operator go out in the next batch
+func (server *OpencxServer) ApproveWithdrawal(id uint64, coin *coinparam.Params) (withdrawal *match.WithdrawalRequest, err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var currWithdrawalStore cxdb.WithdrawalStore
+	if currWithdrawalStore, err = server.withdrawalStore(coin); err != nil {
+		err = fmt.Errorf("Error getting withdrawal store for ApproveWithdrawal: %s", err)
+		return
+	}
+
+	if withdrawal, err = currWithdrawalStore.GetWithdrawal(id); err != nil {
+		err = fmt.Errorf("Error getting withdrawal for ApproveWithdrawal: %s", err)
+		return
+	}
+
+	if withdrawal.State != match.WithdrawalRequested {
+		err = fmt.Errorf("Withdrawal %d is %s, only requested withdrawals can be approved", id, withdrawal.State)
+		return
+	}
+
+	withdrawal.SetState(match.WithdrawalApproved, time.Now())
+	if err = currWithdrawalStore.UpdateWithdrawal(withdrawal); err != nil {
+		err = fmt.Errorf("Error updating withdrawal for ApproveWithdrawal: %s", err)
+		return
+	}
+
+	logging.Infof("Approved %s", withdrawal)
+	return
+}
+
+// RejectWithdrawal fails a withdrawal that hasn't been broadcast yet, and gives the amount back
+// to the user
+func (server *OpencxServer) RejectWithdrawal(id uint64, coin *coinparam.Params, reason string) (withdrawal *match.WithdrawalRequest, err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var currWithdrawalStore cxdb.WithdrawalStore
+	if currWithdrawalStore, err = server.withdrawalStore(coin); err != nil {
+		err = fmt.Errorf("Error getting withdrawal store for RejectWithdrawal: %s", err)
+		return
+	}
+
+	if withdrawal, err = currWithdrawalStore.GetWithdrawal(id); err != nil {
+		err = fmt.Errorf("Error getting withdrawal for RejectWithdrawal: %s", err)
+		return
+	}
+
+	if withdrawal.State != match.WithdrawalRequested && withdrawal.State != match.WithdrawalApproved {
+		err = fmt.Errorf("Withdrawal %d is %s, it can't be rejected", id, withdrawal.State)
+		return
+	}
+
+	if err = server.failWithdrawal(currWithdrawalStore, withdrawal, coin, fmt.Sprintf("rejected: %s", reason)); err != nil {
+		err = fmt.Errorf("Error failing withdrawal for RejectWithdrawal: %s", err)
+		return
+	}
+	return
+}
+
+// failWithdrawal marks a withdrawal as failed and gives the amount back to the user. The
+// withdrawal is marked first, so if something goes wrong it's never sent and refunded both.
+// withdrawalMtx should be held.
+func (server *OpencxServer) failWithdrawal(store cxdb.WithdrawalStore, withdrawal *match.WithdrawalRequest, coin *coinparam.Params, reason string) (err error) {
+	withdrawal.Reason = reason
+	withdrawal.SetState(match.WithdrawalFailed, time.Now())
+	if err = store.UpdateWithdrawal(withdrawal); err != nil {
+		err = fmt.Errorf("Error marking withdrawal %d as failed: %s", withdrawal.ID, err)
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(withdrawal.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing pubkey for failed withdrawal %d, it has not been refunded: %s", withdrawal.ID, err)
+		return
+	}
+
+	if err = server.debitUser(pubkey, withdrawal.Amount, coin, cxevent.WithdrawalRefundEvent); err != nil {
+		err = fmt.Errorf("Error refunding failed withdrawal %d, it has not been refunded: %s", withdrawal.ID, err)
+		return
+	}
+
+	logging.Infof("Failed and refunded %s", withdrawal)
+	return
+}
+
+// BatchWithdrawals sends the approved withdrawals for a coin, one transaction for each fee
+// priority, and returns the txids. If a transaction can't be made, for example because the wallet
+// doesn't have enough, its withdrawals stay approved and go in the next batch. There are no txids
+// if there was nothing to send.
+func (server *OpencxServer) BatchWithdrawals(coin *coinparam.Params) (txids []string, err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	var currWithdrawalStore cxdb.WithdrawalStore
+	if currWithdrawalStore, err = server.withdrawalStore(coin); err != nil {
+		err = fmt.Errorf("Error getting withdrawal store for BatchWithdrawals: %s", err)
+		return
+	}
+
+	var approved []*match.WithdrawalRequest
+	if approved, err = currWithdrawalStore.GetWithdrawalsByState(match.WithdrawalApproved); err != nil {
+		err = fmt.Errorf("Error getting approved withdrawals for BatchWithdrawals: %s", err)
+		return
+	}
+	if len(approved) > maxWithdrawalBatch {
+		approved = approved[:maxWithdrawalBatch]
+	}
+
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s for BatchWithdrawals", coin.Name)
+		return
+	}
+
+	// each priority pays a different fee rate, so it gets its own transaction
+	var priorities []match.FeePriority
+	byPriority := make(map[match.FeePriority][]*match.WithdrawalRequest)
+	for _, withdrawal := range approved {
+		if _, ok := byPriority[withdrawal.Priority]; !ok {
+			priorities = append(priorities, withdrawal.Priority)
+		}
+		byPriority[withdrawal.Priority] = append(byPriority[withdrawal.Priority], withdrawal)
+	}
+
+	// one priority that can't be sent shouldn't stop the others
+	var sendErrs []string
+	for _, priority := range priorities {
+		var txid string
+		if txid, err = server.sendWithdrawalBatch(currWithdrawalStore, wallet, byPriority[priority], priority, coin); err != nil {
+			sendErrs = append(sendErrs, fmt.Sprintf("%s priority: %s", priority, err))
+			err = nil
+			continue
+		}
+		if txid != "" {
+			txids = append(txids, txid)
+		}
+	}
+	if len(sendErrs) != 0 {
+		err = fmt.Errorf("Error sending withdrawals for BatchWithdrawals: %v", sendErrs)
+		return
+	}
+	return
+}
+
+// sendWithdrawalBatch sends withdrawals with the same priority in one transaction, paying the fee
+// rate for their priority, and returns the txid. Withdrawals that pay their own fee each pay an
+// even share of it. The txid is empty if none of the withdrawals could be sent. withdrawalMtx
+// should be held.
+func (server *OpencxServer) sendWithdrawalBatch(store cxdb.WithdrawalStore, wallet *wallit.Wallit, withdrawals []*match.WithdrawalRequest, priority match.FeePriority, coin *coinparam.Params) (txid string, err error) {
+	var feeRate int64
+	if feeRate, err = server.feeRate(coin, priority); err != nil {
+		err = fmt.Errorf("Error getting fee rate for sendWithdrawalBatch: %s", err)
+		return
+	}
+
+	// make the outputs, addresses are checked when withdrawals are requested so this shouldn't
+	// fail, but if it does that withdrawal can't ever go out
+	var batch []*match.WithdrawalRequest
+	var txOuts []*wire.TxOut
+	for _, withdrawal := range withdrawals {
+		var txOut *wire.TxOut
+		if txOut, err = withdrawalTxOut(withdrawal, coin); err != nil {
+			if err = server.failWithdrawal(store, withdrawal, coin, err.Error()); err != nil {
+				err = fmt.Errorf("Error failing withdrawal with bad address for sendWithdrawalBatch: %s", err)
+				return
+			}
+			continue
+		}
+		batch = append(batch, withdrawal)
+		txOuts = append(txOuts, txOut)
+	}
+
+	// Pick inputs for the whole batch, the overshoot is after fees so it's the change. If a
+	// withdrawal can't cover its share of the fee it fails, and the inputs are picked again for
+	// the rest.
+	var utxoSlice portxo.TxoSliceByBip69
+	var overshoot, inputTotal int64
+	for {
+		if len(batch) == 0 {
+			return
+		}
+
+		var total, outputByteSize int64
+		for _, txOut := range txOuts {
+			total += txOut.Value
+			outputByteSize += int64(txOut.SerializeSize())
+		}
+		if utxoSlice, overshoot, err = wallet.PickUtxos(total, outputByteSize, feeRate, false); err != nil {
+			err = fmt.Errorf("Error picking utxos for %d withdrawals for sendWithdrawalBatch: %s", len(batch), err)
+			return
+		}
+
+		inputTotal = 0
+		for _, utxo := range utxoSlice {
+			inputTotal += utxo.Value
+		}
+		fee := inputTotal - total - overshoot
+		share := (fee + int64(len(batch)) - 1) / int64(len(batch))
+
+		var keptBatch []*match.WithdrawalRequest
+		var keptOuts []*wire.TxOut
+		for i, withdrawal := range batch {
+			if withdrawal.PayFee && txOuts[i].Value-share < dustLimit {
+				if err = server.failWithdrawal(store, withdrawal, coin, fmt.Sprintf("%d doesn't cover its share of the network fee, %d", withdrawal.Amount, share)); err != nil {
+					err = fmt.Errorf("Error failing withdrawal that can't pay its fee for sendWithdrawalBatch: %s", err)
+					return
+				}
+				continue
+			}
+			keptBatch = append(keptBatch, withdrawal)
+			keptOuts = append(keptOuts, txOuts[i])
+		}
+		if len(keptBatch) != len(batch) {
+			batch, txOuts = keptBatch, keptOuts
+			continue
+		}
+
+		// what's taken out of the withdrawals goes back to the wallet
+		for i, withdrawal := range batch {
+			withdrawal.Fee = 0
+			if withdrawal.PayFee {
+				withdrawal.Fee = uint64(share)
+				txOuts[i].Value -= share
+				overshoot += share
+			}
+		}
+		break
+	}
+
+	// for giving back the wallet change
+	var changeOut *wire.TxOut
+	if changeOut, err = wallet.NewChangeOut(overshoot); err != nil {
+		err = fmt.Errorf("Error creating change output for sendWithdrawalBatch: %s", err)
+		return
+	}
+
+	// signal that the transaction can be replaced, in case it gets stuck
+	for _, utxo := range utxoSlice {
+		if utxo.Seq <= 1 {
+			utxo.Seq = rbfSequence
+		}
+	}
+
+	var withdrawTx *wire.MsgTx
+	if withdrawTx, err = wallet.BuildAndSign(utxoSlice, append(append([]*wire.TxOut{}, txOuts...), changeOut), 0); err != nil {
+		err = fmt.Errorf("Error building withdrawal transaction for sendWithdrawalBatch: %s", err)
+		return
+	}
+
+	// send out the transaction
+	if err = wallet.NewOutgoingTx(withdrawTx); err != nil {
+		err = fmt.Errorf("Error sending withdrawal transaction for sendWithdrawalBatch: %s", err)
+		return
+	}
+	txid = withdrawTx.TxHash().String()
+	logging.Infof("Sent %d %s %s priority withdrawals in %s paying %d per vbyte", len(batch), coin.Name, priority, txid, feeRate)
+
+	// keep what it takes to replace the transaction if it doesn't confirm
+	sent := &withdrawalBatch{
+		priority:     priority,
+		inputs:       utxoSlice,
+		inputTotal:   inputTotal,
+		txOuts:       txOuts,
+		changeScript: changeOut.PkScript,
+		feeRate:      feeRate,
+		txs:          []*wire.MsgTx{withdrawTx},
+	}
+	for _, withdrawal := range batch {
+		sent.ids = append(sent.ids, withdrawal.ID)
+	}
+	server.dbLock.Lock()
+	sent.sentHeight = server.chainHeights[coin]
+	server.dbLock.Unlock()
+
+	// The transaction is out, so the batch has to be stored and every withdrawal in it has to be
+	// marked as broadcast even if one of them can't be
+	var updateErrs []string
+	if updateErr := putWithdrawalBatch(store, sent); updateErr != nil {
+		logging.Errorf("Error storing withdrawal batch %s, it can't be replaced if it gets stuck: %s", txid, updateErr)
+		updateErrs = append(updateErrs, updateErr.Error())
+	}
+	now := time.Now()
+	for _, withdrawal := range batch {
+		withdrawal.Txid = txid
+		withdrawal.SetState(match.WithdrawalBroadcast, now)
+		if updateErr := store.UpdateWithdrawal(withdrawal); updateErr != nil {
+			logging.Errorf("Error marking withdrawal %d as broadcast in %s, it must not be sent again: %s", withdrawal.ID, txid, updateErr)
+			updateErrs = append(updateErrs, updateErr.Error())
+		}
+	}
+	if len(updateErrs) != 0 {
+		err = fmt.Errorf("Sent %s but could not store the batch and mark its withdrawals as broadcast: %v", txid, updateErrs)
+		return
+	}
+	return
+}
+
+// withdrawalTxOut creates the output paying a withdrawal
+func withdrawalTxOut(withdrawal *match.WithdrawalRequest, coin *coinparam.Params) (txOut *wire.TxOut, err error) {
+	var payToUserScript []byte
+	if payToUserScript, err = util.AddressScript(withdrawal.Address, coin); err != nil {
+		err = fmt.Errorf("Could not create script for address %s: %s", withdrawal.Address, err)
+		return
+	}
+
+	txOut = wire.NewTxOut(int64(withdrawal.Amount), payToUserScript)
+	return
+}
+
+// StartWithdrawalBatcher sends the approved withdrawals for every coin with a wallet once every
+// interval, forever.
+func (server *OpencxServer) StartWithdrawalBatcher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			server.walletMtx.Lock()
+			var coins []*coinparam.Params
+			for coin := range server.WalletMap {
+				coins = append(coins, coin)
+			}
+			server.walletMtx.Unlock()
+
+			for _, coin := range coins {
+				if _, err := server.BatchWithdrawals(coin); err != nil {
+					logging.Errorf("Error batching %s withdrawals: %s", coin.Name, err)
+				}
+			}
+		}
+	}()
+	return
+}
+
+// confirmWithdrawals marks broadcast withdrawals as confirmed when their transaction shows up in
+// a block. Once a withdrawal is confirmed the amount that was held for it is gone for good.
+func (server *OpencxServer) confirmWithdrawals(txList []*wire.MsgTx, coinType *coinparam.Params) (err error) {
+	server.withdrawalMtx.Lock()
+	defer server.withdrawalMtx.Unlock()
+
+	server.dbLock.Lock()
+	currWithdrawalStore, ok := server.WithdrawalStores[coinType]
+	server.dbLock.Unlock()
+	// coins without a withdrawal store can't have any withdrawals to confirm
+	if !ok {
+		return
+	}
+
+	var broadcast []*match.WithdrawalRequest
+	if broadcast, err = currWithdrawalStore.GetWithdrawalsByState(match.WithdrawalBroadcast); err != nil {
+		err = fmt.Errorf("Error getting broadcast withdrawals for confirmWithdrawals: %s", err)
+		return
+	}
+	if len(broadcast) == 0 {
+		return
+	}
+
+	txids := make(map[string]bool)
+	for _, tx := range txList {
+		txids[tx.TxHash().String()] = true
+	}
+
+	// a batch that was bumped can confirm as any version of its transaction, so the withdrawals
+	// pointing to the current version get moved to the one that confirmed
+	var batches []*withdrawalBatch
+	if batches, err = getWithdrawalBatches(currWithdrawalStore); err != nil {
+		err = fmt.Errorf("Error getting withdrawal batches for confirmWithdrawals: %s", err)
+		return
+	}
+	confirmedAs := make(map[string]string)
+	for _, batch := range batches {
+		var confirmed *wire.MsgTx
+		for _, tx := range batch.txs {
+			if txids[tx.TxHash().String()] {
+				confirmed = tx
+			}
+		}
+		if confirmed == nil {
+			continue
+		}
+		server.settleWithdrawalBatch(batch, confirmed, coinType)
+		confirmedAs[batch.current().TxHash().String()] = confirmed.TxHash().String()
+		if err = currWithdrawalStore.RemoveWithdrawalBatch(batch.txs[0].TxHash().String()); err != nil {
+			err = fmt.Errorf("Error removing confirmed withdrawal batch for confirmWithdrawals: %s", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	for _, withdrawal := range broadcast {
+		if confirmedTxid, ok := confirmedAs[withdrawal.Txid]; ok {
+			withdrawal.Txid = confirmedTxid
+		} else if !txids[withdrawal.Txid] {
+			continue
+		}
+		withdrawal.SetState(match.WithdrawalConfirmed, now)
+		if err = currWithdrawalStore.UpdateWithdrawal(withdrawal); err != nil {
+			err = fmt.Errorf("Error marking withdrawal %d as confirmed: %s", withdrawal.ID, err)
+			return
+		}
+		logging.Infof("Confirmed %s", withdrawal)
+	}
+	return
+}
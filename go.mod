@@ -6,6 +6,7 @@ require (
 	github.com/Rjected/gmp v1.0.4-0.20190521043342-9c9965578e96
 	github.com/awalterschulze/gographviz v2.0.1+incompatible // indirect
 	github.com/awnumar/memguard v0.22.5
+	github.com/boltdb/bolt v1.3.1
 	github.com/btcsuite/fastsha256 v0.0.0-20160815193821-637e65642941
 	github.com/btcsuite/golangcrypto v0.0.0-20150304025918-53f62d9b43e8
 	github.com/dchest/siphash v1.2.1
@@ -16,6 +17,7 @@ require (
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/jackpal/gateway v1.0.6 // indirect
 	github.com/jessevdk/go-flags v1.4.1-0.20181221193153-c0795c8afcf4
+	github.com/lib/pq v1.10.9
 	github.com/minio/highwayhash v1.0.0
 	github.com/mit-dci/lit v0.0.0-20200512190823-511d703a128d
 	github.com/mit-dci/zksigma v0.0.0-20190313133734-a6a19e83b9cc
@@ -0,0 +1,227 @@
+package match
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ParseAmount parses a decimal amount like 1.5 into base units of an asset with decimals decimal
+// places, without going through a float. It's an error for the amount to be more precise than
+// the asset, or too big for a uint64.
+func ParseAmount(amountStr string, decimals uint8) (amount uint64, err error) {
+	whole, frac := amountStr, ""
+	if point := strings.Index(amountStr, "."); point != -1 {
+		whole, frac = amountStr[:point], amountStr[point+1:]
+	}
+	if whole == "" && frac == "" {
+		err = fmt.Errorf("Amount %s has no digits", amountStr)
+		return
+	}
+	if len(frac) > int(decimals) {
+		err = fmt.Errorf("Amount %s has more than %d decimal places", amountStr, decimals)
+		return
+	}
+
+	// pad the fraction out to the asset's decimals, then it's just an integer
+	digits := whole + frac + strings.Repeat("0", int(decimals)-len(frac))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			err = fmt.Errorf("Amount %s isn't a decimal number", amountStr)
+			return
+		}
+	}
+	if amount, err = strconv.ParseUint(digits, 10, 64); err != nil {
+		err = fmt.Errorf("Amount %s is too big", amountStr)
+		return
+	}
+	return
+}
+
+// FormatAmount formats base units of an asset with decimals decimal places as a decimal amount,
+// without trailing zeros, so 150000000 with 8 decimals is 1.5
+func FormatAmount(amount uint64, decimals uint8) string {
+	digits := strconv.FormatUint(amount, 10)
+	if decimals == 0 {
+		return digits
+	}
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+
+	point := len(digits) - int(decimals)
+	frac := strings.TrimRight(digits[point:], "0")
+	if frac == "" {
+		return digits[:point]
+	}
+	return digits[:point] + "." + frac
+}
+
+// ParseAmount parses a decimal amount of the asset into base units
+func (info *AssetInfo) ParseAmount(amountStr string) (amount uint64, err error) {
+	return ParseAmount(amountStr, info.Decimals)
+}
+
+// FormatAmount formats base units of the asset as a decimal amount
+func (info *AssetInfo) FormatAmount(amount uint64) string {
+	return FormatAmount(amount, info.Decimals)
+}
+
+// ParseAmount parses a decimal amount of the asset into base units, using the asset's registered
+// decimals
+func (a Asset) ParseAmount(amountStr string) (amount uint64, err error) {
+	var info *AssetInfo
+	if info, err = a.Info(); err != nil {
+		return
+	}
+	amount, err = info.ParseAmount(amountStr)
+	return
+}
+
+// FormatAmount formats base units of the asset as a decimal amount, or just the base units if the
+// asset isn't registered
+func (a Asset) FormatAmount(amount uint64) string {
+	info, err := a.Info()
+	if err != nil {
+		return strconv.FormatUint(amount, 10)
+	}
+	return info.FormatAmount(amount)
+}
+
+// pow10 returns 10^exp as a big int
+func pow10(exp uint8) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
+
+// BaseQuote returns how much of the pair's base asset (AssetWant) and quote asset (AssetHave) the
+// order is for. Buy orders give up the quote asset for the base asset, and sell orders the other
+// way around.
+func (l *LimitOrder) BaseQuote() (base uint64, quote uint64) {
+	if l.Side == Buy {
+		return l.AmountWant, l.AmountHave
+	}
+	return l.AmountHave, l.AmountWant
+}
+
+// HaveWantAssets returns the asset an order on the side gives up and the asset it gets. Buy orders
+// give up the quote asset (AssetHave), sell orders the base asset (AssetWant).
+func (p *Pair) HaveWantAssets(side Side) (have Asset, want Asset) {
+	if side == Buy {
+		return p.AssetHave, p.AssetWant
+	}
+	return p.AssetWant, p.AssetHave
+}
+
+// AmountWantAtPrice returns the amount an order giving up amountHave should want at a price,
+// which is in base units of the quote asset for a whole unit of the base asset. Whatever doesn't
+// divide evenly is rounded down, so the order never asks for more than the price.
+func (p *Pair) AmountWantAtPrice(side Side, amountHave uint64, price uint64) (amountWant uint64, err error) {
+	if price == 0 {
+		err = fmt.Errorf("Price can't be 0")
+		return
+	}
+
+	var baseInfo *AssetInfo
+	if baseInfo, err = p.AssetWant.Info(); err != nil {
+		return
+	}
+	wholeBase := pow10(baseInfo.Decimals)
+
+	want := new(big.Int).SetUint64(amountHave)
+	if side == Buy {
+		// giving up quote, so the base wanted is quote * 10^decimals / price
+		want.Mul(want, wholeBase)
+		want.Div(want, new(big.Int).SetUint64(price))
+	} else {
+		// giving up base, so the quote wanted is base * price / 10^decimals
+		want.Mul(want, new(big.Int).SetUint64(price))
+		want.Div(want, wholeBase)
+	}
+	if !want.IsUint64() {
+		err = fmt.Errorf("Amount wanted at that price is too big")
+		return
+	}
+	amountWant = want.Uint64()
+	return
+}
+
+// QuotePrice returns the price of the order, in base units of the quote asset for a whole unit
+// of the base asset, rounded down
+func (l *LimitOrder) QuotePrice() (price uint64, err error) {
+	base, quote := l.BaseQuote()
+	if base == 0 {
+		err = fmt.Errorf("Cannot calculate the price of an order for no base asset")
+		return
+	}
+
+	var baseInfo *AssetInfo
+	if baseInfo, err = l.TradingPair.AssetWant.Info(); err != nil {
+		return
+	}
+
+	bigPrice := new(big.Int).SetUint64(quote)
+	bigPrice.Mul(bigPrice, pow10(baseInfo.Decimals))
+	bigPrice.Div(bigPrice, new(big.Int).SetUint64(base))
+	if !bigPrice.IsUint64() {
+		err = fmt.Errorf("Order price is too big")
+		return
+	}
+	price = bigPrice.Uint64()
+	return
+}
+
+// CheckIncrements returns an error if the order's base amount isn't a multiple of the base asset's
+// lot size, or if it couldn't have come from AmountWantAtPrice with a price that's a multiple of
+// the quote asset's tick size. A lot or tick size of 0 allows anything.
+func (l *LimitOrder) CheckIncrements() (err error) {
+	var baseInfo, quoteInfo *AssetInfo
+	if baseInfo, err = l.TradingPair.AssetWant.Info(); err != nil {
+		return
+	}
+	if quoteInfo, err = l.TradingPair.AssetHave.Info(); err != nil {
+		return
+	}
+
+	base, quote := l.BaseQuote()
+	if baseInfo.LotSize != 0 && base%baseInfo.LotSize != 0 {
+		err = fmt.Errorf("Order is for %s %s, which isn't a multiple of the lot size %s", baseInfo.FormatAmount(base), l.TradingPair.AssetWant, baseInfo.FormatAmount(baseInfo.LotSize))
+		return
+	}
+	if quoteInfo.TickSize == 0 {
+		return
+	}
+	if base == 0 || quote == 0 {
+		err = fmt.Errorf("Order can't be for nothing")
+		return
+	}
+
+	// exact is quote * 10^decimals / base, the tick multiple closest to it on the side amounts
+	// get rounded towards is the only one that could have made this order
+	tick := new(big.Int).SetUint64(quoteInfo.TickSize)
+	numerator := new(big.Int).SetUint64(quote)
+	numerator.Mul(numerator, pow10(baseInfo.Decimals))
+	denominator := new(big.Int).SetUint64(base)
+	denominator.Mul(denominator, tick)
+
+	ticks, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if l.Side == Sell && remainder.Sign() != 0 {
+		// sells round the quote wanted down, so the price is at or above exact
+		ticks.Add(ticks, big.NewInt(1))
+	}
+	price := ticks.Mul(ticks, tick)
+	if price.Sign() == 0 || !price.IsUint64() {
+		err = fmt.Errorf("Order price isn't a multiple of the tick size %s", quoteInfo.FormatAmount(quoteInfo.TickSize))
+		return
+	}
+
+	var amountWant uint64
+	if amountWant, err = l.TradingPair.AmountWantAtPrice(l.Side, l.AmountHave, price.Uint64()); err != nil {
+		return
+	}
+	if amountWant != l.AmountWant {
+		err = fmt.Errorf("Order price isn't a multiple of the tick size %s %s", quoteInfo.FormatAmount(quoteInfo.TickSize), l.TradingPair.AssetHave)
+		return
+	}
+	return
+}
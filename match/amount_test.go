@@ -0,0 +1,99 @@
+package match
+
+import (
+	"testing"
+)
+
+// TestParseFormatAmount parses decimal amounts and formats them back
+func TestParseFormatAmount(t *testing.T) {
+	for _, test := range []struct {
+		str       string
+		decimals  uint8
+		amount    uint64
+		formatted string
+	}{
+		{"1.5", 8, 150000000, "1.5"},
+		{"0.00000001", 8, 1, "0.00000001"},
+		{".25", 8, 25000000, "0.25"},
+		{"21000000", 8, 2100000000000000, "21000000"},
+		{"3.", 8, 300000000, "3"},
+		{"0", 8, 0, "0"},
+		{"42", 0, 42, "42"},
+		{"184467440737.09551615", 8, 18446744073709551615, "184467440737.09551615"},
+	} {
+		amount, err := ParseAmount(test.str, test.decimals)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", test.str, err)
+			continue
+		}
+		if amount != test.amount {
+			t.Errorf("%s with %d decimals should be %d, got %d", test.str, test.decimals, test.amount, amount)
+		}
+		if formatted := FormatAmount(amount, test.decimals); formatted != test.formatted {
+			t.Errorf("%d with %d decimals should format as %s, got %s", amount, test.decimals, test.formatted, formatted)
+		}
+	}
+
+	for _, bad := range []string{"", ".", "1.000000001", "-1", "1e8", "1,5", "184467440737.09551616", " 1"} {
+		if _, err := ParseAmount(bad, 8); err == nil {
+			t.Errorf("Amount %q should not parse", bad)
+		}
+	}
+}
+
+// TestCheckIncrements places orders at prices on and off the tick, and for amounts on and off the
+// lot
+func TestCheckIncrements(t *testing.T) {
+	pair := Pair{AssetWant: BTCReg, AssetHave: LTCReg}
+
+	baseInfo, _ := BTCReg.Info()
+	quoteInfo, _ := LTCReg.Info()
+	defer RegisterAsset(baseInfo)
+	defer RegisterAsset(quoteInfo)
+
+	lotted := *baseInfo
+	lotted.LotSize = 1000
+	ticked := *quoteInfo
+	ticked.TickSize = 1000000
+	if err := RegisterAsset(&lotted); err != nil {
+		t.Fatalf("Error setting lot size: %s", err)
+	}
+	if err := RegisterAsset(&ticked); err != nil {
+		t.Fatalf("Error setting tick size: %s", err)
+	}
+
+	// 0.01, 0.02 and 1.23 ltc for every btc
+	for _, price := range []uint64{1000000, 2000000, 123000000} {
+		for _, side := range []Side{Buy, Sell} {
+			// sells give up base, so need to be a multiple of the lot, buys get base so the
+			// amount given up has to be picked so the base comes out a multiple too
+			amountHave := uint64(333000)
+			if side == Buy {
+				amountHave = 333000 * price / 100000000
+			}
+			amountWant, err := pair.AmountWantAtPrice(side, amountHave, price)
+			if err != nil {
+				t.Errorf("Error getting amount want: %s", err)
+				continue
+			}
+			order := &LimitOrder{Side: side, TradingPair: pair, AmountHave: amountHave, AmountWant: amountWant}
+			if err = order.CheckIncrements(); err != nil {
+				t.Errorf("%s order %d for %d at %d should be fine: %s", side, amountHave, amountWant, price, err)
+			}
+			if quotePrice, err := order.QuotePrice(); err != nil || quotePrice != price {
+				t.Errorf("%s order price should be %d, got %d (%v)", side, price, quotePrice, err)
+			}
+		}
+	}
+
+	// off the tick
+	offTick := &LimitOrder{Side: Sell, TradingPair: pair, AmountHave: 100000000, AmountWant: 1500000}
+	if err := offTick.CheckIncrements(); err == nil {
+		t.Errorf("Price of 0.015 shouldn't be allowed with a tick of 0.01")
+	}
+	// off the lot
+	offLot := &LimitOrder{Side: Sell, TradingPair: pair, AmountHave: 100000001, AmountWant: 1000000}
+	if err := offLot.CheckIncrements(); err == nil {
+		t.Errorf("Amount of 1.00000001 shouldn't be allowed with a lot of 0.00001")
+	}
+}
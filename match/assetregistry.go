@@ -0,0 +1,212 @@
+package match
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/chainutils"
+)
+
+// AssetInfo is everything the exchange knows about an asset: the byte clients refer to it by, the
+// chain it's on, and how it's shown and limited. Supporting a new chain only takes registering
+// its coinparam and an AssetInfo for it.
+type AssetInfo struct {
+	// Asset is the byte the asset is identified by
+	Asset Asset `json:"asset"`
+	// Coin is the chain the asset is on
+	Coin *coinparam.Params `json:"-"`
+	// Name is the name the asset is shown with, like Bitcoin
+	Name string `json:"name"`
+	// Decimals is how many decimal places a whole unit of the asset has, 8 for bitcoin
+	Decimals uint8 `json:"decimals"`
+	// MinDeposit is the smallest deposit that gets credited, in base units
+	MinDeposit uint64 `json:"mindeposit"`
+	// MinWithdrawal is the smallest withdrawal that can be requested, in base units
+	MinWithdrawal uint64 `json:"minwithdrawal"`
+	// LotSize is what orders for the asset have to be a multiple of, in base units, when it's
+	// the base asset of a pair. 0 allows any amount.
+	LotSize uint64 `json:"lotsize"`
+	// TickSize is what prices in the asset have to be a multiple of, in base units for a whole unit
+	// of the base asset, when it's the quote asset of a pair. 0 allows any price.
+	TickSize uint64 `json:"ticksize"`
+}
+
+const (
+	// defaultDecimals is the decimals of every built in asset, they're all bitcoin forks
+	defaultDecimals = 8
+	// maxDecimals is the most decimals an asset can have, any more and a whole unit wouldn't fit
+	// in a uint64
+	maxDecimals = 19
+)
+
+var (
+	// assetRegistryMtx protects the registry
+	assetRegistryMtx sync.RWMutex
+	// assetsByByte are the registered assets by their byte
+	assetsByByte = make(map[Asset]*AssetInfo)
+	// assetsByCoin are the registered assets by their chain
+	assetsByCoin = make(map[*coinparam.Params]*AssetInfo)
+)
+
+// defaultAssets are the assets every exchange knows about without being configured
+func defaultAssets() []*AssetInfo {
+	return []*AssetInfo{
+		{Asset: BTC, Coin: &coinparam.BitcoinParams, Name: "Bitcoin"},
+		{Asset: VTC, Coin: &coinparam.VertcoinParams, Name: "Vertcoin"},
+		{Asset: LTC, Coin: &chainutils.LitecoinParams, Name: "Litecoin"},
+		{Asset: BTCTest, Coin: &coinparam.TestNet3Params, Name: "Bitcoin Testnet"},
+		{Asset: VTCTest, Coin: &coinparam.VertcoinTestNetParams, Name: "Vertcoin Testnet"},
+		{Asset: LTCTest, Coin: &coinparam.LiteCoinTestNet4Params, Name: "Litecoin Testnet"},
+		{Asset: BTCReg, Coin: &coinparam.RegressionNetParams, Name: "Bitcoin Regtest"},
+		{Asset: VTCReg, Coin: &coinparam.VertcoinRegTestParams, Name: "Vertcoin Regtest"},
+		{Asset: LTCReg, Coin: &coinparam.LiteRegNetParams, Name: "Litecoin Regtest"},
+	}
+}
+
+func init() {
+	for _, info := range defaultAssets() {
+		info.Decimals = defaultDecimals
+		if err := RegisterAsset(info); err != nil {
+			panic("failed to register default asset: " + err.Error())
+		}
+	}
+}
+
+// RegisterAsset adds an asset to the registry, or replaces what's registered for its coin if it
+// has the same byte. It's an error for the byte or the coin to already belong to another asset.
+func RegisterAsset(info *AssetInfo) (err error) {
+	if info.Coin == nil {
+		err = fmt.Errorf("Asset %d needs a coin to be registered", info.Asset)
+		return
+	}
+	if info.Decimals > maxDecimals {
+		err = fmt.Errorf("Asset %s can't have %d decimals, the most is %d", info.Coin.Name, info.Decimals, maxDecimals)
+		return
+	}
+
+	assetRegistryMtx.Lock()
+	defer assetRegistryMtx.Unlock()
+
+	if existing, found := assetsByByte[info.Asset]; found && existing.Coin != info.Coin {
+		err = fmt.Errorf("Asset %d is already %s, can't register it for %s", info.Asset, existing.Coin.Name, info.Coin.Name)
+		return
+	}
+	if existing, found := assetsByCoin[info.Coin]; found && existing.Asset != info.Asset {
+		err = fmt.Errorf("Coin %s is already asset %d, can't register it as %d", info.Coin.Name, existing.Asset, info.Asset)
+		return
+	}
+
+	registered := *info
+	assetsByByte[info.Asset] = &registered
+	assetsByCoin[info.Coin] = &registered
+	return
+}
+
+// Info returns what's registered for an asset
+func (a Asset) Info() (info *AssetInfo, err error) {
+	assetRegistryMtx.RLock()
+	registered, found := assetsByByte[a]
+	assetRegistryMtx.RUnlock()
+
+	if !found {
+		err = fmt.Errorf("Asset %d isn't registered", a)
+		return
+	}
+	infoCopy := *registered
+	info = &infoCopy
+	return
+}
+
+// AssetInfoFromCoinParam returns what's registered for the asset on a chain
+func AssetInfoFromCoinParam(cpm *coinparam.Params) (info *AssetInfo, err error) {
+	var a Asset
+	if a, err = AssetFromCoinParam(cpm); err != nil {
+		return
+	}
+	info, err = a.Info()
+	return
+}
+
+// RegisteredAssets returns every registered asset, in order of their bytes
+func RegisteredAssets() (infos []*AssetInfo) {
+	assetRegistryMtx.RLock()
+	for _, registered := range assetsByByte {
+		infoCopy := *registered
+		infos = append(infos, &infoCopy)
+	}
+	assetRegistryMtx.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Asset < infos[j].Asset })
+	return
+}
+
+// String returns the asset info in the same form ParseAssetInfo takes
+func (info *AssetInfo) String() string {
+	return fmt.Sprintf("%s=%d:%d:%d:%d:%d:%d:%s", info.Coin.Name, info.Asset, info.Decimals, info.MinDeposit, info.MinWithdrawal, info.LotSize, info.TickSize, info.Name)
+}
+
+// ParseAssetInfo parses asset info of the form
+// coin=asset:decimals:mindeposit:minwithdrawal:lotsize:ticksize:name, for example
+// litecoin=2:8:100000:1000000:0:0:Litecoin. The coin is the name of a registered coinparam and the
+// asset is its byte, which can be hex like 0x02. Amounts are in base units. The name is everything
+// after the sixth colon, so it can have spaces and colons.
+func ParseAssetInfo(infoStr string) (info *AssetInfo, err error) {
+	parts := strings.SplitN(infoStr, "=", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("Asset %s should look like coin=asset:decimals:mindeposit:minwithdrawal:lotsize:ticksize:name", infoStr)
+		return
+	}
+
+	fields := strings.SplitN(parts[1], ":", 7)
+	if len(fields) != 7 {
+		err = fmt.Errorf("Asset %s should look like coin=asset:decimals:mindeposit:minwithdrawal:lotsize:ticksize:name", infoStr)
+		return
+	}
+
+	info = new(AssetInfo)
+	if info.Coin, err = chainutils.GetParamFromName(parts[0]); err != nil {
+		err = fmt.Errorf("Error getting coin for asset %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+
+	var assetByte, decimals uint64
+	if assetByte, err = strconv.ParseUint(fields[0], 0, 8); err != nil {
+		err = fmt.Errorf("Error parsing asset byte for %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+	if decimals, err = strconv.ParseUint(fields[1], 10, 8); err != nil {
+		err = fmt.Errorf("Error parsing decimals for %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+	if info.MinDeposit, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing min deposit for %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+	if info.MinWithdrawal, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing min withdrawal for %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+	if info.LotSize, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing lot size for %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+	if info.TickSize, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing tick size for %s: %s", infoStr, err)
+		info = nil
+		return
+	}
+	info.Asset = Asset(assetByte)
+	info.Decimals = uint8(decimals)
+	info.Name = fields[6]
+	return
+}
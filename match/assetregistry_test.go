@@ -0,0 +1,94 @@
+package match
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/chainutils"
+)
+
+// TestDefaultAssets makes sure litecoin mainnet is an asset and that every built in asset goes
+// both ways
+func TestDefaultAssets(t *testing.T) {
+	ltc, err := AssetFromCoinParam(&chainutils.LitecoinParams)
+	if err != nil {
+		t.Fatalf("Litecoin should be an asset: %s", err)
+	}
+	if ltc != LTC {
+		t.Errorf("Litecoin should be asset %d, got %d", LTC, ltc)
+	}
+
+	for _, info := range RegisteredAssets() {
+		coin, err := info.Asset.CoinParamFromAsset()
+		if err != nil {
+			t.Errorf("Error getting coin for asset %d: %s", info.Asset, err)
+			continue
+		}
+		if coin != info.Coin {
+			t.Errorf("Asset %d should be %s, got %s", info.Asset, info.Coin.Name, coin.Name)
+		}
+		if fromString, err := AssetFromString(coin.Name); err != nil || fromString != info.Asset {
+			t.Errorf("Asset from %s should be %d, got %d (%v)", coin.Name, info.Asset, fromString, err)
+		}
+	}
+}
+
+// TestParseAssetInfo parses asset info and makes sure it comes back out the same
+func TestParseAssetInfo(t *testing.T) {
+	infoStr := "litecoin=0x02:8:100000:1000000:1000:10:Litecoin: the silver to bitcoin's gold"
+	info, err := ParseAssetInfo(infoStr)
+	if err != nil {
+		t.Fatalf("Error parsing asset info: %s", err)
+	}
+	if info.Asset != LTC || info.Coin != &chainutils.LitecoinParams || info.Decimals != 8 || info.MinDeposit != 100000 || info.MinWithdrawal != 1000000 || info.LotSize != 1000 || info.TickSize != 10 {
+		t.Errorf("Parsed the wrong asset info: %+v", info)
+	}
+	if info.Name != "Litecoin: the silver to bitcoin's gold" {
+		t.Errorf("Name should be everything after the sixth colon, got %s", info.Name)
+	}
+	if reparsed, err := ParseAssetInfo(info.String()); err != nil || *reparsed != *info {
+		t.Errorf("Asset info %s didn't parse back to itself: %+v (%v)", info, reparsed, err)
+	}
+
+	for _, bad := range []string{
+		"litecoin",
+		"litecoin=2:8:0:0:Litecoin",
+		"notacoin=2:8:0:0:0:0:Nothing",
+		"litecoin=256:8:0:0:0:0:Litecoin",
+		"litecoin=2:eight:0:0:0:0:Litecoin",
+		"litecoin=2:8:0:0:lot:0:Litecoin",
+	} {
+		if _, err := ParseAssetInfo(bad); err == nil {
+			t.Errorf("Asset info %s should not parse", bad)
+		}
+	}
+}
+
+// TestRegisterAsset checks that an asset's info can be changed, but that bytes and coins can't be
+// taken from another asset
+func TestRegisterAsset(t *testing.T) {
+	original, err := BTCReg.Info()
+	if err != nil {
+		t.Fatalf("Error getting regtest info: %s", err)
+	}
+	defer RegisterAsset(original)
+
+	changed := *original
+	changed.MinWithdrawal = 5000
+	if err = RegisterAsset(&changed); err != nil {
+		t.Fatalf("Error changing regtest info: %s", err)
+	}
+	if info, _ := BTCReg.Info(); info.MinWithdrawal != 5000 {
+		t.Errorf("Min withdrawal should be changed to 5000, got %d", info.MinWithdrawal)
+	}
+
+	if err = RegisterAsset(&AssetInfo{Asset: BTCReg, Coin: &coinparam.LiteRegNetParams}); err == nil {
+		t.Errorf("Shouldn't be able to register another coin with regtest's byte")
+	}
+	if err = RegisterAsset(&AssetInfo{Asset: 0x20, Coin: &coinparam.RegressionNetParams}); err == nil {
+		t.Errorf("Shouldn't be able to register regtest with another byte")
+	}
+	if err = RegisterAsset(&AssetInfo{Asset: 0x20, Coin: &coinparam.BC2NetParams, Decimals: 20}); err == nil {
+		t.Errorf("Shouldn't be able to register an asset with more than %d decimals", maxDecimals)
+	}
+}
@@ -0,0 +1,359 @@
+package match
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+	"golang.org/x/crypto/sha3"
+)
+
+// AtomicSwapState is where an atomic swap is in its life. An atomic swap starts out matched, and
+// ends up completed or abandoned.
+type AtomicSwapState string
+
+const (
+	// AtomicSwapMatched is an atomic swap waiting for the initiator to lock their side
+	AtomicSwapMatched AtomicSwapState = "matched"
+	// AtomicSwapInitiated is an atomic swap whose initiator side is locked, waiting for the
+	// participant to lock theirs
+	AtomicSwapInitiated AtomicSwapState = "initiated"
+	// AtomicSwapParticipated is an atomic swap with both sides locked, waiting for the initiator
+	// to redeem the participant's side
+	AtomicSwapParticipated AtomicSwapState = "participated"
+	// AtomicSwapRedeemed is an atomic swap where the initiator redeemed the participant's side,
+	// which revealed the preimage, waiting for the participant to redeem the initiator's side
+	AtomicSwapRedeemed AtomicSwapState = "redeemed"
+	// AtomicSwapCompleted is an atomic swap where both sides were redeemed
+	AtomicSwapCompleted AtomicSwapState = "completed"
+	// AtomicSwapAbandoned is an atomic swap where whoever's turn it was didn't do their part in
+	// time. Whatever was locked can be refunded once it times out.
+	AtomicSwapAbandoned AtomicSwapState = "abandoned"
+)
+
+// Final returns true if nothing else will happen to the atomic swap
+func (s AtomicSwapState) Final() bool {
+	return s == AtomicSwapCompleted || s == AtomicSwapAbandoned
+}
+
+// AtomicSwapStateFromString returns the state for a string, or an error if it isn't a state
+func AtomicSwapStateFromString(str string) (state AtomicSwapState, err error) {
+	switch AtomicSwapState(str) {
+	case AtomicSwapMatched, AtomicSwapInitiated, AtomicSwapParticipated, AtomicSwapRedeemed, AtomicSwapCompleted, AtomicSwapAbandoned:
+		state = AtomicSwapState(str)
+	default:
+		err = fmt.Errorf("Unknown atomic swap state %s", str)
+	}
+	return
+}
+
+// AtomicSwapRole is which side of an atomic swap a user is on
+type AtomicSwapRole string
+
+const (
+	// AtomicInitiator picks the preimage and locks their side first. The taker of a fill is the
+	// initiator, since they've just placed their order.
+	AtomicInitiator AtomicSwapRole = "initiator"
+	// AtomicParticipant locks their side once the initiator's is locked. The maker of a fill is
+	// the participant.
+	AtomicParticipant AtomicSwapRole = "participant"
+)
+
+const (
+	// DefaultAtomicSwapTimeout is how long the participant's side is locked for if there's no
+	// atomic swap policy
+	DefaultAtomicSwapTimeout = 24 * time.Hour
+	// DefaultAtomicSwapMaxAbandons is how many atomic swaps a user can abandon within the ban
+	// duration if there's no atomic swap policy
+	DefaultAtomicSwapMaxAbandons = 3
+	// DefaultAtomicSwapBanDuration is how long abandoned atomic swaps count against a user if
+	// there's no atomic swap policy
+	DefaultAtomicSwapBanDuration = 7 * 24 * time.Hour
+)
+
+// AtomicSwapSpendSize is the most vbytes a transaction spending one side of an atomic swap to one
+// output takes. It's the same HTLC as a submarine swap's.
+const AtomicSwapSpendSize = SubmarineSpendSize
+
+// AtomicSwapPolicy is how long each side of an atomic swap is locked for, and how the exchange
+// treats users who abandon atomic swaps. Locktimes are unix times rather than heights, since the
+// two sides are on chains with different block times.
+type AtomicSwapPolicy struct {
+	// Timeout is how long the participant's side is locked for. The initiator's side is locked
+	// for twice as long, so the participant has time to redeem it once the initiator has
+	// redeemed theirs. Each side has to be locked within a quarter of Timeout of it being its
+	// turn.
+	Timeout time.Duration
+	// MaxAbandons is how many atomic swaps a user can abandon within BanDuration before they
+	// can't place atomic swap orders
+	MaxAbandons int
+	// BanDuration is how long an abandoned atomic swap counts against the user who abandoned it
+	BanDuration time.Duration
+}
+
+// DefaultAtomicSwapPolicy returns the policy used if the exchange doesn't set one
+func DefaultAtomicSwapPolicy() *AtomicSwapPolicy {
+	return &AtomicSwapPolicy{
+		Timeout:     DefaultAtomicSwapTimeout,
+		MaxAbandons: DefaultAtomicSwapMaxAbandons,
+		BanDuration: DefaultAtomicSwapBanDuration,
+	}
+}
+
+// LockWindow returns how long each side has to lock its coins once it's its turn
+func (p *AtomicSwapPolicy) LockWindow() time.Duration {
+	return p.Timeout / 4
+}
+
+// AtomicSwapLeg is one side of an atomic swap, an on-chain HTLC locked by one user and redeemed
+// by the other
+type AtomicSwapLeg struct {
+	// OrderID is the order of the user who locks this side
+	OrderID OrderID `json:"orderid"`
+	// Pubkey is the user who locks this side, and can refund it once it times out
+	Pubkey [33]byte `json:"pubkey"`
+	Asset  Asset    `json:"asset"`
+	Amount uint64   `json:"amount"`
+	// Locktime is the unix time the HTLC can be refunded at, it's set once it's this side's turn
+	Locktime uint32 `json:"locktime"`
+	// Outpoint is the HTLC, as txid:index, once it's been funded
+	Outpoint   string `json:"outpoint,omitempty"`
+	FundAmount uint64 `json:"fundamount"`
+	FundHeight uint64 `json:"fundheight"`
+	// SpendTxid is the transaction that spent the HTLC
+	SpendTxid   string `json:"spendtxid,omitempty"`
+	SpendHeight uint64 `json:"spendheight"`
+}
+
+// AtomicSwap settles a fill between two atomic swap orders with on-chain HTLCs between the
+// users' own wallets, so the exchange never holds either side. The initiator locks what they
+// give up to the participant, the participant locks what they give up to the initiator with the
+// same hash, the initiator redeems the participant's side, which reveals the preimage, and the
+// participant redeems the initiator's side with it. The exchange only watches the chains.
+type AtomicSwap struct {
+	// ID is the hash of the two orders, it identifies the atomic swap
+	ID [32]byte `json:"id"`
+	// RHash is the hash both sides are locked to. The initiator picks the preimage, and only
+	// sends the hash.
+	RHash [32]byte `json:"rhash"`
+	// Preimage is empty until the initiator reveals it by redeeming the participant's side
+	Preimage    [16]byte        `json:"preimage"`
+	TradingPair Pair            `json:"pair"`
+	Initiator   AtomicSwapLeg   `json:"initiator"`
+	Participant AtomicSwapLeg   `json:"participant"`
+	State       AtomicSwapState `json:"state"`
+	// Deadline is when whoever's turn it is has to have done their part by
+	Deadline time.Time `json:"deadline"`
+	// Abandoner is who didn't do their part in time, if the atomic swap was abandoned
+	Abandoner [33]byte `json:"abandoner"`
+	// Reason is why the atomic swap was abandoned
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// AtomicSwapID returns the ID of the atomic swap between a taker's order and a maker's order
+func AtomicSwapID(takerOrderID OrderID, makerOrderID OrderID) (id [32]byte) {
+	return sha256.Sum256(append(append([]byte{}, takerOrderID[:]...), makerOrderID[:]...))
+}
+
+// NewAtomicSwap creates a matched atomic swap for a maker's fill against a taker's order. The
+// taker initiates, giving up what the maker received in the fill, and the maker participates,
+// giving up what the maker gave up. The initiator has policy.LockWindow() to lock their side.
+func NewAtomicSwap(takerOrderID OrderID, takerPubkey [33]byte, makerFill *FillEntry, policy *AtomicSwapPolicy, createTime time.Time) (swap *AtomicSwap, err error) {
+	if makerFill.AmountHave == 0 || makerFill.AmountWant == 0 {
+		err = fmt.Errorf("Can't swap an empty fill of order %s", hex.EncodeToString(makerFill.OrderID[:]))
+		return
+	}
+
+	have, want := makerFill.TradingPair.HaveWantAssets(makerFill.Side)
+	swap = &AtomicSwap{
+		ID:          AtomicSwapID(takerOrderID, makerFill.OrderID),
+		TradingPair: makerFill.TradingPair,
+		Initiator: AtomicSwapLeg{
+			OrderID: takerOrderID,
+			Pubkey:  takerPubkey,
+			Asset:   want,
+			Amount:  makerFill.AmountWant,
+		},
+		Participant: AtomicSwapLeg{
+			OrderID: makerFill.OrderID,
+			Pubkey:  makerFill.Pubkey,
+			Asset:   have,
+			Amount:  makerFill.AmountHave,
+		},
+		State:    AtomicSwapMatched,
+		Deadline: createTime.Add(policy.LockWindow()),
+		Created:  createTime,
+		Updated:  createTime,
+	}
+	return
+}
+
+// IDString returns the hex ID of the atomic swap, which is how users refer to it
+func (s *AtomicSwap) IDString() string {
+	return hex.EncodeToString(s.ID[:])
+}
+
+// String returns a short description of the atomic swap
+func (s *AtomicSwap) String() string {
+	str := fmt.Sprintf("atomic swap %s: %d %s for %d %s, %s", s.IDString(), s.Initiator.Amount, s.Initiator.Asset, s.Participant.Amount, s.Participant.Asset, s.State)
+	if s.Reason != "" {
+		str += fmt.Sprintf(" (%s)", s.Reason)
+	}
+	return str
+}
+
+// Leg returns the side of the atomic swap that a role locks
+func (s *AtomicSwap) Leg(role AtomicSwapRole) (leg *AtomicSwapLeg) {
+	if role == AtomicInitiator {
+		return &s.Initiator
+	}
+	return &s.Participant
+}
+
+// Roles returns the roles a pubkey has in the atomic swap, which is both if the user filled
+// their own order
+func (s *AtomicSwap) Roles(pubkey [33]byte) (roles []AtomicSwapRole) {
+	if s.Initiator.Pubkey == pubkey {
+		roles = append(roles, AtomicInitiator)
+	}
+	if s.Participant.Pubkey == pubkey {
+		roles = append(roles, AtomicParticipant)
+	}
+	return
+}
+
+// Turn returns the role that has to act next, ok is false if the atomic swap is final
+func (s *AtomicSwap) Turn() (role AtomicSwapRole, ok bool) {
+	switch s.State {
+	case AtomicSwapMatched, AtomicSwapParticipated:
+		return AtomicInitiator, true
+	case AtomicSwapInitiated, AtomicSwapRedeemed:
+		return AtomicParticipant, true
+	}
+	return
+}
+
+// SetState moves the atomic swap to a new state at a time, with the deadline for the next step
+func (s *AtomicSwap) SetState(state AtomicSwapState, deadline time.Time, updateTime time.Time) {
+	s.State = state
+	s.Deadline = deadline
+	s.Updated = updateTime
+	return
+}
+
+// Abandon marks the atomic swap abandoned by whoever's turn it was, with the reason
+func (s *AtomicSwap) Abandon(reason string, updateTime time.Time) {
+	if role, ok := s.Turn(); ok {
+		s.Abandoner = s.Leg(role).Pubkey
+	}
+	s.State = AtomicSwapAbandoned
+	s.Reason = reason
+	s.Deadline = time.Time{}
+	s.Updated = updateTime
+	return
+}
+
+// Initiate sets the hash the initiator locked their side to, and when it can be refunded,
+// policy.Timeout twice over from now. It can be set again until the initiator's side is funded.
+func (s *AtomicSwap) Initiate(rhash [32]byte, policy *AtomicSwapPolicy, now time.Time) (err error) {
+	if s.State != AtomicSwapMatched || s.Initiator.Outpoint != "" {
+		err = fmt.Errorf("Atomic swap %s is already initiated", s.IDString())
+		return
+	}
+	if !now.Before(s.Deadline) {
+		err = fmt.Errorf("Atomic swap %s had to be initiated by %s", s.IDString(), s.Deadline.Format(time.RFC3339))
+		return
+	}
+	s.RHash = rhash
+	s.Initiator.Locktime = uint32(now.Add(2 * policy.Timeout).Unix())
+	s.Updated = now
+	return
+}
+
+// UpdateFrom copies everything that changes as an atomic swap moves along from another copy of
+// it, which is what stores save when an atomic swap is updated
+func (s *AtomicSwap) UpdateFrom(other *AtomicSwap) {
+	s.RHash = other.RHash
+	s.Preimage = other.Preimage
+	s.Initiator = other.Initiator
+	s.Participant = other.Participant
+	s.State = other.State
+	s.Deadline = other.Deadline
+	s.Abandoner = other.Abandoner
+	s.Reason = other.Reason
+	s.Updated = other.Updated
+	return
+}
+
+// htlc returns the HTLC for the side a role locks. It pays the other side with the preimage, or
+// the role back once the side's locktime has passed.
+func (s *AtomicSwap) htlc(role AtomicSwapRole) (h *chainHTLC) {
+	leg, other := &s.Initiator, &s.Participant
+	if role == AtomicParticipant {
+		leg, other = other, leg
+	}
+	h = &chainHTLC{
+		name:      fmt.Sprintf("%s side of atomic swap %s", role, s.IDString()),
+		rhash:     s.RHash,
+		claimKey:  other.Pubkey,
+		refundKey: leg.Pubkey,
+		locktime:  leg.Locktime,
+		outpoint:  leg.Outpoint,
+		amount:    leg.FundAmount,
+	}
+	return
+}
+
+// Script returns the witness script of the HTLC for the side a role locks
+func (s *AtomicSwap) Script(role AtomicSwapRole) (script []byte, err error) {
+	return s.htlc(role).script()
+}
+
+// PkScript returns the P2WSH script the HTLC for the side a role locks pays to
+func (s *AtomicSwap) PkScript(role AtomicSwapRole) (pkScript []byte, err error) {
+	return s.htlc(role).pkScript()
+}
+
+// FundingOutput returns the index of the output of tx that funds the side a role locks, found is
+// false if there isn't one
+func (s *AtomicSwap) FundingOutput(role AtomicSwapRole, tx *wire.MsgTx) (index uint32, found bool, err error) {
+	return s.htlc(role).fundingOutput(tx)
+}
+
+// SpendingInput returns the index of the input of tx that spends the side a role locks, found is
+// false if there isn't one or the side hasn't been funded
+func (s *AtomicSwap) SpendingInput(role AtomicSwapRole, tx *wire.MsgTx) (index int, found bool) {
+	return s.htlc(role).spendingInput(tx)
+}
+
+// PreimageFromWitness returns the preimage in the witness of an input that spends either side,
+// ok is false if it was refunded rather than redeemed
+func (s *AtomicSwap) PreimageFromWitness(witness wire.TxWitness) (preimage [16]byte, ok bool) {
+	return s.htlc(AtomicInitiator).preimageFromWitness(witness)
+}
+
+// SpendTx creates a transaction spending the side a role locks to outScript, paying fee. The
+// other side redeems it with the preimage, or the role refunds it if preimage is nil, which can't
+// be mined until the side's locktime.
+func (s *AtomicSwap) SpendTx(role AtomicSwapRole, outScript []byte, fee uint64, priv *koblitz.PrivateKey, preimage *[16]byte) (tx *wire.MsgTx, err error) {
+	return s.htlc(role).spendTx(outScript, fee, priv, preimage)
+}
+
+// VerifySpend checks that input index of tx spends the side a role locks properly
+func (s *AtomicSwap) VerifySpend(role AtomicSwapRole, tx *wire.MsgTx, index int) (err error) {
+	return s.htlc(role).verifySpend(tx, index)
+}
+
+// AtomicInitiationSigHash returns what the initiator of an atomic swap signs to set its hash
+func AtomicInitiationSigHash(id [32]byte, rhash [32]byte) (e []byte) {
+	sha3 := sha3.New256()
+	sha3.Write(id[:])
+	sha3.Write(rhash[:])
+	e = sha3.Sum(nil)
+	return
+}
@@ -0,0 +1,175 @@
+package match
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+// newAtomicSwap creates an atomic swap between a taker selling 1 btc and a maker buying it for 150
+// ltc
+func newAtomicSwap(t *testing.T, taker *koblitz.PrivateKey, maker *koblitz.PrivateKey, created time.Time) (swap *AtomicSwap) {
+	var takerPubkey, makerPubkey [33]byte
+	copy(takerPubkey[:], taker.PubKey().SerializeCompressed())
+	copy(makerPubkey[:], maker.PubKey().SerializeCompressed())
+
+	makerFill := &FillEntry{
+		OrderID:     OrderID{0x02},
+		Pubkey:      makerPubkey,
+		TradingPair: Pair{AssetWant: BTCReg, AssetHave: LTCReg},
+		Side:        Buy,
+		AmountHave:  15000000000,
+		AmountWant:  100000000,
+	}
+	var err error
+	if swap, err = NewAtomicSwap(OrderID{0x01}, takerPubkey, makerFill, DefaultAtomicSwapPolicy(), created); err != nil {
+		t.Fatalf("Error creating atomic swap: %s", err)
+	}
+	return
+}
+
+// fundAtomicLeg funds the side a role locks with a made up transaction
+func fundAtomicLeg(t *testing.T, swap *AtomicSwap, role AtomicSwapRole) {
+	pkScript, err := swap.PkScript(role)
+	if err != nil {
+		t.Fatalf("Error getting %s pkscript: %s", role, err)
+	}
+	leg := swap.Leg(role)
+	fundTx := wire.NewMsgTx()
+	prevHash := chainhash.DoubleHashH([]byte(role))
+	fundTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	fundTx.AddTxOut(wire.NewTxOut(int64(leg.Amount), pkScript))
+
+	index, found, err := swap.FundingOutput(role, fundTx)
+	if err != nil || !found || index != 0 {
+		t.Fatalf("Funding output of %s side should be 0, got %d, %t, %v", role, index, found, err)
+	}
+	leg.Outpoint = fundTx.TxHash().String() + ":0"
+	leg.FundAmount = leg.Amount
+	return
+}
+
+// TestAtomicSwapSides makes sure the taker initiates with what the maker wants, and that each
+// side can only be redeemed by the other user and refunded by the user who locked it
+func TestAtomicSwapSides(t *testing.T) {
+	taker, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x01})
+	maker, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x02})
+	created := time.Unix(1500000000, 0)
+	policy := DefaultAtomicSwapPolicy()
+	outScript := []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+
+	swap := newAtomicSwap(t, taker, maker, created)
+	if swap.Initiator.Asset != BTCReg || swap.Initiator.Amount != 100000000 || swap.Participant.Asset != LTCReg || swap.Participant.Amount != 15000000000 {
+		t.Fatalf("Taker should send the btc and maker the ltc, got %s", swap)
+	}
+	if swap.ID != AtomicSwapID(OrderID{0x01}, OrderID{0x02}) || !swap.Deadline.Equal(created.Add(6*time.Hour)) {
+		t.Errorf("Atomic swap ID or deadline is wrong, got %s and %s", swap.IDString(), swap.Deadline)
+	}
+	if role, ok := swap.Turn(); !ok || role != AtomicInitiator {
+		t.Errorf("Initiator should go first, got %s", role)
+	}
+
+	preimage := [16]byte{0x42}
+	if err := swap.Initiate(sha256.Sum256(preimage[:]), policy, created.Add(7*time.Hour)); err == nil {
+		t.Errorf("Initiating after the deadline should fail")
+	}
+	if err := swap.Initiate(sha256.Sum256(preimage[:]), policy, created.Add(time.Hour)); err != nil {
+		t.Fatalf("Error initiating atomic swap: %s", err)
+	}
+	if swap.Initiator.Locktime != uint32(created.Add(49*time.Hour).Unix()) {
+		t.Errorf("Initiator side should be locked for twice the timeout, got %d", swap.Initiator.Locktime)
+	}
+	swap.Participant.Locktime = uint32(created.Add(25 * time.Hour).Unix())
+
+	for _, tc := range []struct {
+		role     AtomicSwapRole
+		redeemer *koblitz.PrivateKey
+		refunder *koblitz.PrivateKey
+	}{
+		{AtomicInitiator, maker, taker},
+		{AtomicParticipant, taker, maker},
+	} {
+		fundAtomicLeg(t, swap, tc.role)
+		leg := swap.Leg(tc.role)
+
+		redeem, err := swap.SpendTx(tc.role, outScript, 1000, tc.redeemer, &preimage)
+		if err != nil {
+			t.Fatalf("Error redeeming %s side: %s", tc.role, err)
+		}
+		if err = swap.VerifySpend(tc.role, redeem, 0); err != nil {
+			t.Errorf("Redeem of %s side should be valid: %s", tc.role, err)
+		}
+		if index, found := swap.SpendingInput(tc.role, redeem); !found || index != 0 {
+			t.Errorf("Redeem of %s side should spend it", tc.role)
+		}
+		if got, ok := swap.PreimageFromWitness(redeem.TxIn[0].Witness); !ok || got != preimage {
+			t.Errorf("Redeem of %s side should reveal the preimage, got %x", tc.role, got)
+		}
+
+		// the user who locked a side can't redeem it
+		stolen, err := swap.SpendTx(tc.role, outScript, 1000, tc.refunder, &preimage)
+		if err != nil {
+			t.Fatalf("Error signing redeem of %s side with the wrong key: %s", tc.role, err)
+		}
+		if err = swap.VerifySpend(tc.role, stolen, 0); err == nil {
+			t.Errorf("Redeem of %s side by the user who locked it should be invalid", tc.role)
+		}
+
+		refund, err := swap.SpendTx(tc.role, outScript, 1000, tc.refunder, nil)
+		if err != nil {
+			t.Fatalf("Error refunding %s side: %s", tc.role, err)
+		}
+		if refund.LockTime != leg.Locktime {
+			t.Errorf("Refund of %s side should be locked until %d, got %d", tc.role, leg.Locktime, refund.LockTime)
+		}
+		if err = swap.VerifySpend(tc.role, refund, 0); err != nil {
+			t.Errorf("Refund of %s side should be valid: %s", tc.role, err)
+		}
+		if _, ok := swap.PreimageFromWitness(refund.TxIn[0].Witness); ok {
+			t.Errorf("Refund of %s side shouldn't reveal a preimage", tc.role)
+		}
+	}
+
+	if err := swap.Initiate([32]byte{0x01}, policy, created.Add(2*time.Hour)); err == nil {
+		t.Errorf("Initiating a funded atomic swap again should fail")
+	}
+}
+
+// TestAtomicSwapAbandon makes sure an abandoned atomic swap blames whoever's turn it was
+func TestAtomicSwapAbandon(t *testing.T) {
+	taker, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x01})
+	maker, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x02})
+	created := time.Unix(1500000000, 0)
+
+	for _, tc := range []struct {
+		state     AtomicSwapState
+		abandoner [33]byte
+	}{
+		{AtomicSwapMatched, newAtomicSwap(t, taker, maker, created).Initiator.Pubkey},
+		{AtomicSwapInitiated, newAtomicSwap(t, taker, maker, created).Participant.Pubkey},
+		{AtomicSwapParticipated, newAtomicSwap(t, taker, maker, created).Initiator.Pubkey},
+		{AtomicSwapRedeemed, newAtomicSwap(t, taker, maker, created).Participant.Pubkey},
+	} {
+		swap := newAtomicSwap(t, taker, maker, created)
+		swap.SetState(tc.state, created.Add(time.Hour), created)
+		swap.Abandon("too slow", created.Add(2*time.Hour))
+		if swap.State != AtomicSwapAbandoned || swap.Abandoner != tc.abandoner || !swap.State.Final() {
+			t.Errorf("Atomic swap abandoned while %s should blame the other user, got %x", tc.state, swap.Abandoner)
+		}
+		if _, ok := swap.Turn(); ok {
+			t.Errorf("Abandoned atomic swap shouldn't be anyone's turn")
+		}
+	}
+
+	swap := newAtomicSwap(t, taker, maker, created)
+	if roles := swap.Roles(swap.Participant.Pubkey); len(roles) != 1 || roles[0] != AtomicParticipant {
+		t.Errorf("Maker should only be the participant, got %v", roles)
+	}
+	if _, err := NewAtomicSwap(OrderID{0x01}, swap.Initiator.Pubkey, &FillEntry{TradingPair: swap.TradingPair}, DefaultAtomicSwapPolicy(), created); err == nil {
+		t.Errorf("Empty atomic swap should be refused")
+	}
+}
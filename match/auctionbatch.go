@@ -34,7 +34,9 @@ type AuctionBatcher interface {
 // BatchResult is a struct that represents the result of a batch auction.
 type BatchResult struct {
 	OriginalBatch *AuctionBatch
-	// RejectedResults and AcceptedResults should be disjoint sets
+	// RejectedResults, AcceptedResults, and CancelledResults should be disjoint sets
 	RejectedResults []*OrderPuzzleResult
 	AcceptedResults []*OrderPuzzleResult
+	// CancelledResults are valid orders that were cancelled before the auction ended
+	CancelledResults []*OrderPuzzleResult
 }
@@ -0,0 +1,218 @@
+package match
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+)
+
+// AuctionOrderState is where an encrypted auction order is in its lifecycle
+type AuctionOrderState string
+
+const (
+	// AuctionOrderSubmitted means the order is waiting for its auction to end and its puzzle to
+	// be solved
+	AuctionOrderSubmitted AuctionOrderState = "submitted"
+	// AuctionOrderCancelled means the user cancelled the order before its auction ended
+	AuctionOrderCancelled AuctionOrderState = "cancelled"
+	// AuctionOrderRejected means the order was invalid once its puzzle was solved
+	AuctionOrderRejected AuctionOrderState = "rejected"
+	// AuctionOrderPlaced means the order was valid and placed, but nothing was filled in its
+	// auction
+	AuctionOrderPlaced AuctionOrderState = "placed"
+	// AuctionOrderPartiallyFilled means some of the order was filled in its auction
+	AuctionOrderPartiallyFilled AuctionOrderState = "partiallyfilled"
+	// AuctionOrderFilled means all of the order was filled in its auction
+	AuctionOrderFilled AuctionOrderState = "filled"
+)
+
+// MaxAuctionCancels is how many different pubkeys can ask for an auction order to be cancelled.
+// Receipt IDs aren't public until the auction ends, so only the user that submitted the order
+// should be sending cancels for it, and more than this is just someone filling up our storage.
+const MaxAuctionCancels = 8
+
+// auctionCancelPrefix is signed along with a receipt ID to cancel an auction order, so the
+// signature can't be mistaken for anything else
+const auctionCancelPrefix = "opencx-cancelauctionorder"
+
+// AuctionReceipt is what a user gets back for an encrypted auction order. The exchange can't
+// read the order until its puzzle is solved, so the receipt is for the encrypted order: its ID
+// is the hash of the encrypted order.
+type AuctionReceipt struct {
+	ID        [32]byte  `json:"id"`
+	AuctionID AuctionID `json:"auctionid"`
+	Pair      Pair      `json:"pair"`
+	Received  time.Time `json:"received"`
+}
+
+// NewAuctionReceipt creates the receipt for an encrypted order received at receiveTime
+func NewAuctionReceipt(order *EncryptedAuctionOrder, receiveTime time.Time) (receipt *AuctionReceipt, err error) {
+	receipt = &AuctionReceipt{
+		AuctionID: order.IntendedAuction,
+		Pair:      order.IntendedPair,
+		Received:  receiveTime,
+	}
+	if receipt.ID, err = order.ReceiptID(); err != nil {
+		receipt = nil
+		return
+	}
+	return
+}
+
+// ReceiptID returns the ID of the receipt for the encrypted order, the hash of the serialized
+// order
+func (e *EncryptedAuctionOrder) ReceiptID() (id [32]byte, err error) {
+	var raw []byte
+	if raw, err = e.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing encrypted order for receipt ID: %s", err)
+		return
+	}
+	hash := sha3.New256()
+	hash.Write(raw)
+	copy(id[:], hash.Sum(nil))
+	return
+}
+
+// AuctionOrderStatus is where an encrypted auction order is, along with the order once its
+// puzzle is solved
+type AuctionOrderStatus struct {
+	Receipt AuctionReceipt    `json:"receipt"`
+	State   AuctionOrderState `json:"state"`
+	// Reason is why the order was rejected
+	Reason string `json:"reason,omitempty"`
+	// Order is the decrypted order, it's nil until the auction ends and the puzzle is solved
+	Order *AuctionOrder `json:"order,omitempty"`
+	// OrderID is the ID the order was placed with, it's empty unless the order was placed
+	OrderID OrderID `json:"orderid"`
+	// Execution is what happened to the order in its auction, it's nil if nothing was filled
+	Execution *OrderExecution `json:"execution,omitempty"`
+	Updated   time.Time       `json:"updated"`
+	// Cancels are the pubkeys that asked for the order to be cancelled while it was submitted.
+	// Only the exchange needs them, and they're cleared once the order is no longer submitted.
+	Cancels [][33]byte `json:"-"`
+	// Signature is the user's signature on the solution form of the encrypted order, which the
+	// order is put in its auction's transcript with. It's empty if the order wasn't signed.
+	Signature []byte `json:"-"`
+}
+
+// SetState moves the order to a new state, with why if it was rejected
+func (aos *AuctionOrderStatus) SetState(state AuctionOrderState, reason string, updateTime time.Time) {
+	aos.State = state
+	aos.Reason = reason
+	aos.Updated = updateTime
+	return
+}
+
+// Decided returns whether the order's auction is over for it, so its status won't change any more
+// unless it was placed and gets filled
+func (aos *AuctionOrderStatus) Decided() bool {
+	return aos.State != AuctionOrderSubmitted
+}
+
+// Serialize uses gob encoding to turn the order status into bytes
+func (aos *AuctionOrderStatus) Serialize() (raw []byte, err error) {
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(aos); err != nil {
+		err = fmt.Errorf("Error encoding auction order status: %s", err)
+		return
+	}
+	raw = b.Bytes()
+	return
+}
+
+// Deserialize turns the order status from bytes into a usable struct
+func (aos *AuctionOrderStatus) Deserialize(raw []byte) (err error) {
+	if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(aos); err != nil {
+		err = fmt.Errorf("Error decoding auction order status: %s", err)
+		return
+	}
+	return
+}
+
+// String returns a short description of the order's status
+func (aos *AuctionOrderStatus) String() string {
+	desc := fmt.Sprintf("auction order %x in auction %x on %s: %s", aos.Receipt.ID, aos.Receipt.AuctionID, aos.Receipt.Pair.String(), aos.State)
+	if aos.Reason != "" {
+		desc += ", " + aos.Reason
+	}
+	return desc
+}
+
+// AuctionCancelSigHash returns the hash a user signs to cancel the auction order with a
+// receipt ID
+func AuctionCancelSigHash(receiptID [32]byte) (e []byte) {
+	hash := sha3.New256()
+	hash.Write([]byte(auctionCancelPrefix))
+	hash.Write(receiptID[:])
+	e = hash.Sum(nil)
+	return
+}
+
+// RecoverAuctionCancelPubkey returns the pubkey that signed a cancel for the auction order with
+// a receipt ID
+func RecoverAuctionCancelPubkey(receiptID [32]byte, signature []byte) (pubkey *koblitz.PublicKey, err error) {
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, AuctionCancelSigHash(receiptID)); err != nil {
+		err = fmt.Errorf("Error recovering pubkey from auction cancel signature: %s", err)
+		return
+	}
+	return
+}
+
+// AuctionRejection is an order that was rejected in an auction and why
+type AuctionRejection struct {
+	ReceiptID [32]byte `json:"receiptid"`
+	Reason    string   `json:"reason"`
+}
+
+// AuctionResult is what happened in an auction once it ended: the price orders cleared at, what
+// was filled, and which orders were rejected or cancelled
+type AuctionResult struct {
+	AuctionID AuctionID `json:"auctionid"`
+	Pair      Pair      `json:"pair"`
+	// ClearingPrice is the price every fill was at, it's 0 if no orders crossed
+	ClearingPrice float64             `json:"clearingprice"`
+	Placed        uint64              `json:"placed"`
+	Fills         []*OrderExecution   `json:"fills"`
+	Rejections    []*AuctionRejection `json:"rejections"`
+	Cancelled     [][32]byte          `json:"cancelled"`
+	Ended         time.Time           `json:"ended"`
+}
+
+// Serialize uses gob encoding to turn the auction result into bytes
+func (ar *AuctionResult) Serialize() (raw []byte, err error) {
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(ar); err != nil {
+		err = fmt.Errorf("Error encoding auction result: %s", err)
+		return
+	}
+	raw = b.Bytes()
+	return
+}
+
+// Deserialize turns the auction result from bytes into a usable struct. Gob doesn't keep empty
+// lists, so they're made empty again rather than nil.
+func (ar *AuctionResult) Deserialize(raw []byte) (err error) {
+	if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(ar); err != nil {
+		err = fmt.Errorf("Error decoding auction result: %s", err)
+		return
+	}
+	if ar.Fills == nil {
+		ar.Fills = []*OrderExecution{}
+	}
+	if ar.Rejections == nil {
+		ar.Rejections = []*AuctionRejection{}
+	}
+	if ar.Cancelled == nil {
+		ar.Cancelled = [][32]byte{}
+	}
+	return
+}
+
+// String returns a short description of the auction's result
+func (ar *AuctionResult) String() string {
+	return fmt.Sprintf("auction %x on %s: %d placed, %d filled at %f, %d rejected, %d cancelled", ar.AuctionID, ar.Pair.String(), ar.Placed, len(ar.Fills), ar.ClearingPrice, len(ar.Rejections), len(ar.Cancelled))
+}
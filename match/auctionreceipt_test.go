@@ -0,0 +1,70 @@
+package match
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+// TestAuctionCancelSignature tests that the signer of an auction cancel can be recovered, and
+// only for the receipt it was signed for
+func TestAuctionCancelSignature(t *testing.T) {
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	receiptID := [32]byte{1}
+	sig, err := koblitz.SignCompact(koblitz.S256(), priv, AuctionCancelSigHash(receiptID), false)
+	if err != nil {
+		t.Fatalf("Error signing cancel: %s", err)
+	}
+	pubkey, err := RecoverAuctionCancelPubkey(receiptID, sig)
+	if err != nil || !pubkey.IsEqual(priv.PubKey()) {
+		t.Errorf("Should recover the signer, got %v", err)
+	}
+	if pubkey, err = RecoverAuctionCancelPubkey([32]byte{2}, sig); err == nil && pubkey.IsEqual(priv.PubKey()) {
+		t.Errorf("Cancel for another receipt should not recover the signer")
+	}
+}
+
+// TestAuctionOrderStatusSerialize tests that an order status, including the pending cancels, and
+// an auction result can be stored and read back
+func TestAuctionOrderStatusSerialize(t *testing.T) {
+	pair := Pair{AssetWant: BTCTest, AssetHave: LTCTest}
+	status := &AuctionOrderStatus{
+		Receipt: AuctionReceipt{ID: [32]byte{1}, AuctionID: AuctionID{2}, Pair: pair, Received: time.Unix(100, 0)},
+		State:   AuctionOrderPartiallyFilled,
+		Order:   &AuctionOrder{Pubkey: [33]byte{3}, Side: Buy, TradingPair: pair, AmountHave: 10, AmountWant: 20},
+		OrderID: OrderID{4},
+		Execution: &OrderExecution{
+			OrderID:       OrderID{4},
+			NewAmountHave: 5,
+			NewAmountWant: 10,
+		},
+		Updated: time.Unix(200, 0),
+		Cancels: [][33]byte{{5}},
+	}
+	raw, err := status.Serialize()
+	if err != nil {
+		t.Fatalf("Error serializing status: %s", err)
+	}
+	stored := new(AuctionOrderStatus)
+	if err = stored.Deserialize(raw); err != nil {
+		t.Fatalf("Error deserializing status: %s", err)
+	}
+	if !reflect.DeepEqual(stored.Order, status.Order) || !stored.Execution.Equal(status.Execution) || stored.OrderID != status.OrderID ||
+		stored.State != status.State || !stored.Updated.Equal(status.Updated) || len(stored.Cancels) != 1 || stored.Cancels[0] != status.Cancels[0] {
+		t.Errorf("Stored status should be the same as the one put, got %s", stored)
+	}
+
+	result := &AuctionResult{AuctionID: AuctionID{2}, Pair: pair, Fills: []*OrderExecution{}, Rejections: []*AuctionRejection{}, Cancelled: [][32]byte{{1}}, Ended: time.Unix(300, 0)}
+	if raw, err = result.Serialize(); err != nil {
+		t.Fatalf("Error serializing result: %s", err)
+	}
+	storedResult := new(AuctionResult)
+	if err = storedResult.Deserialize(raw); err != nil {
+		t.Fatalf("Error deserializing result: %s", err)
+	}
+	if storedResult.Fills == nil || storedResult.Rejections == nil || len(storedResult.Cancelled) != 1 || !storedResult.Ended.Equal(result.Ended) {
+		t.Errorf("Stored result should be the same as the one put, got %s", storedResult)
+	}
+}
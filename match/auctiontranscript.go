@@ -0,0 +1,208 @@
+package match
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"golang.org/x/crypto/sha3"
+)
+
+// SolutionForm returns the encrypted order as an encrypted solution order, which is what users
+// sign and what the exchange commits to in a transcript. Only orders with RSW puzzles have one.
+func (e *EncryptedAuctionOrder) SolutionForm() (encSolOrder EncryptedSolutionOrder, err error) {
+	var rswPuzzle *rsw.PuzzleRSW
+	var ok bool
+	if rswPuzzle, ok = e.OrderPuzzle.(*rsw.PuzzleRSW); !ok || rswPuzzle == nil {
+		err = fmt.Errorf("Error getting solution form of encrypted order: only RSW puzzles can be put in a transcript")
+		return
+	}
+
+	encSolOrder = EncryptedSolutionOrder{
+		OrderCiphertext: e.OrderCiphertext,
+		OrderPuzzle:     *rswPuzzle,
+		IntendedAuction: e.IntendedAuction,
+		IntendedPair:    e.IntendedPair,
+	}
+	return
+}
+
+// SigHash returns the hash that users sign for an encrypted solution order to be in a transcript
+func (es *EncryptedSolutionOrder) SigHash() (e []byte, err error) {
+	var rawOrder []byte
+	if rawOrder, err = es.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing encrypted solution order for SigHash: %s", err)
+		return
+	}
+
+	hasher := sha3.New256()
+	hasher.Write(rawOrder)
+	e = hasher.Sum(nil)
+	return
+}
+
+// Signer returns the pubkey that signed the encrypted solution order
+func (se *SignedEncSolOrder) Signer() (pubkey *koblitz.PublicKey, err error) {
+	var e []byte
+	if e, err = se.EncSolOrder.SigHash(); err != nil {
+		err = fmt.Errorf("Error getting hash of order for Signer: %s", err)
+		return
+	}
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), se.Signature, e); err != nil {
+		err = fmt.Errorf("Error recovering user pubkey from sig: %s", err)
+		return
+	}
+	return
+}
+
+// SignBatchID signs the transcript's batch ID with the exchange's key
+func (tr *Transcript) SignBatchID(exchangeKey *koblitz.PrivateKey) (err error) {
+	hasher := sha3.New256()
+	hasher.Write(tr.BatchId[:])
+	if tr.BatchIdSig, err = koblitz.SignCompact(koblitz.S256(), exchangeKey, hasher.Sum(nil), false); err != nil {
+		err = fmt.Errorf("Error signing batch id for transcript: %s", err)
+		return
+	}
+	return
+}
+
+// Commit sets the commitment to the hash of the transcript's puzzled orders, and signs it with the
+// exchange's key. Nothing can be added to the puzzled orders after this.
+func (tr *Transcript) Commit(exchangeKey *koblitz.PrivateKey) (err error) {
+	hasher := sha3.New256()
+	for _, pzOrder := range tr.PuzzledOrders {
+		var pzBuf []byte
+		if pzBuf, err = pzOrder.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing puzzle order for commitment: %s", err)
+			return
+		}
+		hasher.Write(pzBuf)
+	}
+	copy(tr.Commitment[:], hasher.Sum(nil))
+
+	if tr.CommitSig, err = koblitz.SignCompact(koblitz.S256(), exchangeKey, tr.Commitment[:], false); err != nil {
+		err = fmt.Errorf("Error signing commitment for transcript: %s", err)
+		return
+	}
+	return
+}
+
+// ResponseSigHash returns the hash that a user signs to respond to the transcript's commitment
+// with the factors of their order's puzzle
+func (tr *Transcript) ResponseSigHash(answer SolutionOrder) (e []byte, err error) {
+	var answerBytes []byte
+	if answerBytes, err = answer.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing answer for ResponseSigHash: %s", err)
+		return
+	}
+
+	// h(comm + sig + answer) = e
+	hasher := sha3.New256()
+	hasher.Write(tr.Commitment[:])
+	hasher.Write(tr.CommitSig)
+	hasher.Write(answerBytes)
+	e = hasher.Sum(nil)
+	return
+}
+
+// CheckResponse checks that a response is signed by the signer of one of the puzzled orders, and
+// that it reveals the factors of that order's puzzle
+func (tr *Transcript) CheckResponse(response CommitResponse) (err error) {
+	if response.PuzzleAnswerReveal.P == nil || response.PuzzleAnswerReveal.Q == nil {
+		err = fmt.Errorf("Response must reveal both factors of the puzzle")
+		return
+	}
+
+	var e []byte
+	if e, err = tr.ResponseSigHash(response.PuzzleAnswerReveal); err != nil {
+		err = fmt.Errorf("Error getting hash of response for CheckResponse: %s", err)
+		return
+	}
+
+	var userPubKey *koblitz.PublicKey
+	if userPubKey, _, err = koblitz.RecoverCompact(koblitz.S256(), response.CommResponseSig[:], e); err != nil {
+		err = fmt.Errorf("Error recovering user pubkey from response signature: %s", err)
+		return
+	}
+
+	N := new(big.Int).Mul(response.PuzzleAnswerReveal.P, response.PuzzleAnswerReveal.Q)
+	for _, pzOrder := range tr.PuzzledOrders {
+		var signer *koblitz.PublicKey
+		if signer, err = pzOrder.Signer(); err != nil {
+			err = fmt.Errorf("Error getting signer of puzzled order for CheckResponse: %s", err)
+			return
+		}
+		if signer.IsEqual(userPubKey) && pzOrder.EncSolOrder.OrderPuzzle.N != nil && N.Cmp(pzOrder.EncSolOrder.OrderPuzzle.N) == 0 {
+			return
+		}
+	}
+
+	err = fmt.Errorf("Response does not reveal the puzzle of any order signed by %x", userPubKey.SerializeCompressed())
+	return
+}
+
+// ExchangePubkey returns the pubkey that signed the transcript's batch ID
+func (tr *Transcript) ExchangePubkey() (pubkey *koblitz.PublicKey, err error) {
+	hasher := sha3.New256()
+	hasher.Write(tr.BatchId[:])
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), tr.BatchIdSig, hasher.Sum(nil)); err != nil {
+		err = fmt.Errorf("Error recovering pubkey from batch sig: %s", err)
+		return
+	}
+	return
+}
+
+// VerifySolutions solves every puzzle in the transcript and checks that the solutions are
+// exactly the orders that the puzzles decrypt to. Puzzles that don't decrypt to an order have no
+// solution. Verify doesn't check solutions, and this can take a while, since it doesn't rely on
+// anyone revealing the factors of their puzzles.
+func (tr *Transcript) VerifySolutions() (err error) {
+	puzzleResChan := make(chan *OrderPuzzleResult, len(tr.PuzzledOrders))
+	for _, pzOrder := range tr.PuzzledOrders {
+		if pzOrder.EncSolOrder.IntendedAuction != tr.BatchId {
+			err = fmt.Errorf("Puzzled order intended for auction %x is in transcript for auction %x", pzOrder.EncSolOrder.IntendedAuction, tr.BatchId)
+			return
+		}
+
+		puzzle := pzOrder.EncSolOrder.OrderPuzzle
+		go SolveRC5AuctionOrderAsync(&EncryptedAuctionOrder{
+			OrderCiphertext: pzOrder.EncSolOrder.OrderCiphertext,
+			OrderPuzzle:     &puzzle,
+			IntendedAuction: pzOrder.EncSolOrder.IntendedAuction,
+			IntendedPair:    pzOrder.EncSolOrder.IntendedPair,
+		}, puzzleResChan)
+	}
+
+	// count how many times each order is in the solutions, then take away the ones we decrypt
+	unmatched := make(map[string]int)
+	for _, solution := range tr.Solutions {
+		unmatched[string(solution.Serialize())]++
+	}
+	for i := 0; i < len(tr.PuzzledOrders); i++ {
+		result := <-puzzleResChan
+		if result.Err != nil {
+			continue
+		}
+		rawOrder := string(result.Auction.Serialize())
+		if unmatched[rawOrder] == 0 {
+			err = fmt.Errorf("Puzzle decrypts to an order that isn't in the solutions: %s", result.Auction)
+			return
+		}
+		unmatched[rawOrder]--
+	}
+
+	for _, solution := range tr.Solutions {
+		if unmatched[string(solution.Serialize())] != 0 {
+			err = fmt.Errorf("Solution isn't the solution to any puzzle: %s", &solution)
+			return
+		}
+	}
+	return
+}
+
+// IsCommitted returns true if the exchange has committed to the transcript's puzzled orders
+func (tr *Transcript) IsCommitted() bool {
+	return len(tr.CommitSig) != 0
+}
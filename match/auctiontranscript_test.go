@@ -0,0 +1,160 @@
+package match
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+// TestBuildTranscript tests that a transcript built with the exchange and user helpers verifies,
+// and that its solutions are checked against the puzzles
+func TestBuildTranscript(t *testing.T) {
+	var err error
+	exchangeKey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	userKeys := []*koblitz.PrivateKey{}
+	for _, keyByte := range []byte{2, 3} {
+		userKey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{keyByte})
+		userKeys = append(userKeys, userKey)
+	}
+
+	transcript := &Transcript{BatchId: AuctionID{0x01}}
+	var orders []AuctionOrder
+	var answers []SolutionOrder
+	for i, userKey := range userKeys {
+		order := AuctionOrder{AuctionID: transcript.BatchId, AmountHave: uint64(100 * (i + 1)), AmountWant: 100}
+		copy(order.Pubkey[:], userKey.PubKey().SerializeCompressed())
+
+		var answer SolutionOrder
+		if answer, err = NewSolutionOrder(1024); err != nil {
+			t.Fatalf("Error creating solution order: %s", err)
+		}
+		signedOrder := SignedEncSolOrder{}
+		if signedOrder.EncSolOrder, err = answer.EncryptSolutionOrder(order, 1000); err != nil {
+			t.Fatalf("Error encrypting order: %s", err)
+		}
+
+		var e []byte
+		if e, err = signedOrder.EncSolOrder.SigHash(); err != nil {
+			t.Fatalf("Error getting order sighash: %s", err)
+		}
+		if signedOrder.Signature, err = koblitz.SignCompact(koblitz.S256(), userKey, e, false); err != nil {
+			t.Fatalf("Error signing order: %s", err)
+		}
+
+		// the order has to come out of the wire the same way it went in, or the signature and
+		// commitment can't be checked
+		var raw []byte
+		if raw, err = signedOrder.Serialize(); err != nil {
+			t.Fatalf("Error serializing order: %s", err)
+		}
+		var deserialized SignedEncSolOrder
+		if err = deserialized.Deserialize(raw); err != nil {
+			t.Fatalf("Error deserializing order: %s", err)
+		}
+		if reserialized, _ := deserialized.Serialize(); !bytes.Equal(raw, reserialized) {
+			t.Fatalf("Order should serialize the same after deserializing")
+		}
+
+		transcript.PuzzledOrders = append(transcript.PuzzledOrders, deserialized)
+		orders = append(orders, order)
+		answers = append(answers, answer)
+	}
+
+	if err = transcript.SignBatchID(exchangeKey); err != nil {
+		t.Fatalf("Error signing batch ID: %s", err)
+	}
+	if err = transcript.Commit(exchangeKey); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+
+	var e []byte
+	if e, err = transcript.ResponseSigHash(answers[0]); err != nil {
+		t.Fatalf("Error getting response sighash: %s", err)
+	}
+	for i, userKey := range userKeys {
+		response := CommitResponse{PuzzleAnswerReveal: answers[0]}
+		sig, _ := koblitz.SignCompact(koblitz.S256(), userKey, e, false)
+		copy(response.CommResponseSig[:], sig)
+		err = transcript.CheckResponse(response)
+		if i == 0 && err != nil {
+			t.Fatalf("Response should be valid: %s", err)
+		}
+		if i != 0 && err == nil {
+			t.Errorf("Response for someone else's puzzle should be invalid")
+		}
+		if i == 0 {
+			transcript.Responses = append(transcript.Responses, response)
+		}
+	}
+
+	var valid bool
+	if valid, err = transcript.Verify(); !valid || err != nil {
+		t.Fatalf("Transcript should be valid: %v", err)
+	}
+	var exchangePubkey *koblitz.PublicKey
+	if exchangePubkey, err = transcript.ExchangePubkey(); err != nil || !exchangePubkey.IsEqual(exchangeKey.PubKey()) {
+		t.Errorf("Transcript should be signed by the exchange, got %v", err)
+	}
+
+	if err = transcript.VerifySolutions(); err == nil {
+		t.Errorf("Transcript without solutions should be invalid")
+	}
+	transcript.Solutions = []AuctionOrder{orders[1], orders[0]}
+	if err = transcript.VerifySolutions(); err != nil {
+		t.Errorf("Solutions should be valid: %s", err)
+	}
+	transcript.Solutions = append(transcript.Solutions, orders[0])
+	if err = transcript.VerifySolutions(); err == nil {
+		t.Errorf("Solution without a puzzle should be invalid")
+	}
+}
+
+// TestSolutionOrderGobCompat tests that solution orders and signed puzzled orders that were gob
+// encoded before they had a version byte can still be read
+func TestSolutionOrderGobCompat(t *testing.T) {
+	var err error
+	var answer SolutionOrder
+	if answer, err = NewSolutionOrder(1024); err != nil {
+		t.Fatalf("Error creating solution order: %s", err)
+	}
+	signedOrder := SignedEncSolOrder{Signature: []byte{0x01, 0x02}}
+	if signedOrder.EncSolOrder, err = answer.EncryptSolutionOrder(AuctionOrder{AuctionID: AuctionID{0x01}, AmountHave: 100, AmountWant: 100}, 1000); err != nil {
+		t.Fatalf("Error encrypting order: %s", err)
+	}
+
+	var gobAnswer bytes.Buffer
+	if err = gob.NewEncoder(&gobAnswer).Encode(&answer); err != nil {
+		t.Fatalf("Error gob encoding solution order: %s", err)
+	}
+	var decodedAnswer SolutionOrder
+	if err = decodedAnswer.Deserialize(gobAnswer.Bytes()); err != nil {
+		t.Fatalf("Error deserializing gob solution order: %s", err)
+	}
+	if decodedAnswer.P.Cmp(answer.P) != 0 || decodedAnswer.Q.Cmp(answer.Q) != 0 {
+		t.Errorf("Gob solution order should decode to the same factors")
+	}
+
+	var gobOrder bytes.Buffer
+	if err = gob.NewEncoder(&gobOrder).Encode(&signedOrder); err != nil {
+		t.Fatalf("Error gob encoding signed order: %s", err)
+	}
+	var decodedOrder SignedEncSolOrder
+	if err = decodedOrder.Deserialize(gobOrder.Bytes()); err != nil {
+		t.Fatalf("Error deserializing gob signed order: %s", err)
+	}
+	var raw, decodedRaw []byte
+	if raw, err = signedOrder.Serialize(); err != nil {
+		t.Fatalf("Error serializing signed order: %s", err)
+	}
+	if decodedRaw, err = decodedOrder.Serialize(); err != nil {
+		t.Fatalf("Error serializing decoded signed order: %s", err)
+	}
+	if !bytes.Equal(raw, decodedRaw) {
+		t.Errorf("Gob signed order should decode to the same order")
+	}
+	if raw[0] != solutionEncodingVersion {
+		t.Errorf("Serialized orders should start with the version byte, got %x", raw[0])
+	}
+}
@@ -0,0 +1,38 @@
+package match
+
+import (
+	"fmt"
+	"time"
+)
+
+// BreachAlert is a revoked channel state the exchange's watchtower saw broadcast. The tower
+// sends out the justice transaction on its own, the alert is so the operator knows it happened.
+type BreachAlert struct {
+	Asset Asset `json:"asset"`
+	// Txid is the revoked commitment transaction, and BlockHash the block it was in
+	Txid      string `json:"txid"`
+	BlockHash string `json:"blockhash"`
+	// StateIdx is the state the revoked commitment was for
+	StateIdx uint64 `json:"stateidx"`
+	// Outpoint and Peer are the channel the commitment spends and the peer it's with, Outpoint
+	// is empty if it isn't one of the exchange's channels
+	Outpoint string `json:"outpoint"`
+	Peer     uint32 `json:"peer"`
+	// ByExchange is whether the exchange broadcast the revoked state, against a user who has the
+	// exchange watching their channel, rather than the user against the exchange
+	ByExchange bool      `json:"byexchange"`
+	Detected   time.Time `json:"detected"`
+}
+
+// String returns a short description of the breach
+func (ba *BreachAlert) String() string {
+	desc := fmt.Sprintf("%s breach: revoked state %d broadcast in %s, block %s, detected %s", ba.Asset, ba.StateIdx, ba.Txid, ba.BlockHash, ba.Detected.Format(time.RFC3339))
+	if ba.Outpoint == "" {
+		return desc + ", not on a channel with the exchange"
+	}
+	breacher := fmt.Sprintf("peer %d", ba.Peer)
+	if ba.ByExchange {
+		breacher = fmt.Sprintf("the exchange against peer %d", ba.Peer)
+	}
+	return desc + fmt.Sprintf(", channel %s, by %s", ba.Outpoint, breacher)
+}
@@ -0,0 +1,242 @@
+package match
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+// ColdWalletPolicy is how much of a coin the exchange keeps in its hot wallet, and where the rest
+// goes. Destination is either an address or an xpub, the exchange only ever has a watch-only view
+// of it.
+type ColdWalletPolicy struct {
+	// HotCeiling is the most the hot wallet should hold, anything above it is swept to cold
+	HotCeiling  uint64
+	Destination string
+}
+
+// String returns the policy in the same format ParseColdWalletPolicy takes
+func (cp *ColdWalletPolicy) String() string {
+	return fmt.Sprintf("%d:%s", cp.HotCeiling, cp.Destination)
+}
+
+// ParseColdWalletPolicy parses a policy that looks like "hotceiling:destination", where the
+// destination is a cold address or xpub.
+func ParseColdWalletPolicy(str string) (policy *ColdWalletPolicy, err error) {
+	parts := strings.SplitN(str, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		err = fmt.Errorf("Cold wallet policy %s should look like hotceiling:destination", str)
+		return
+	}
+
+	policy = &ColdWalletPolicy{Destination: parts[1]}
+	if policy.HotCeiling, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing hot ceiling in cold wallet policy %s: %s", str, err)
+		return
+	}
+	return
+}
+
+// ColdUtxo is an output paying to the exchange's cold wallet
+type ColdUtxo struct {
+	Txid     string `json:"txid"`
+	Index    uint32 `json:"index"`
+	Amount   uint64 `json:"amount"`
+	PkScript []byte `json:"pkscript"`
+	// KeyIndex is the child of the cold xpub's external chain the output pays to, 0 if the cold
+	// wallet is an address
+	KeyIndex uint32 `json:"keyindex"`
+	// Height is the height of the block the output was seen in
+	Height uint64 `json:"height"`
+	// SpentHeight is the height of the block the output was spent in, 0 if it's unspent
+	SpentHeight uint64 `json:"spentheight"`
+}
+
+// OutPoint returns the output's outpoint as txid:index
+func (cu *ColdUtxo) OutPoint() string {
+	return fmt.Sprintf("%s:%d", cu.Txid, cu.Index)
+}
+
+// WalletBalances is how much of a coin the exchange holds in its hot and cold wallets
+type WalletBalances struct {
+	Asset      Asset  `json:"asset"`
+	Hot        uint64 `json:"hot"`
+	Cold       uint64 `json:"cold"`
+	HotCeiling uint64 `json:"hotceiling"`
+	// Destination is the cold address or xpub, empty if the coin doesn't have a cold wallet
+	Destination string `json:"destination"`
+}
+
+// RefillTx is a transaction moving funds from the cold wallet back to the hot wallet. The exchange
+// creates it unsigned, along with the cold outputs it spends, so an operator can sign it offline
+// with the cold keys, a bit like a PSBT.
+type RefillTx struct {
+	Asset Asset `json:"asset"`
+	// Tx is the serialized transaction, it's unsigned until the operator signs it
+	Tx []byte `json:"tx"`
+	// Inputs are the cold outputs the transaction spends, in the same order as its inputs
+	Inputs []*ColdUtxo `json:"inputs"`
+}
+
+// Serialize uses gob encoding to turn the refill into bytes
+func (rt *RefillTx) Serialize() (raw []byte, err error) {
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(rt); err != nil {
+		err = fmt.Errorf("Error encoding refill: %s", err)
+		return
+	}
+	raw = b.Bytes()
+	return
+}
+
+// Deserialize turns the refill from bytes into a usable struct
+func (rt *RefillTx) Deserialize(raw []byte) (err error) {
+	if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(rt); err != nil {
+		err = fmt.Errorf("Error decoding refill: %s", err)
+		return
+	}
+	return
+}
+
+// MsgTx deserializes the refill transaction
+func (rt *RefillTx) MsgTx() (tx *wire.MsgTx, err error) {
+	tx = wire.NewMsgTx()
+	if err = tx.Deserialize(bytes.NewReader(rt.Tx)); err != nil {
+		err = fmt.Errorf("Error deserializing refill transaction: %s", err)
+		return
+	}
+	if len(tx.TxIn) != len(rt.Inputs) {
+		err = fmt.Errorf("Refill transaction has %d inputs but %d cold outputs", len(tx.TxIn), len(rt.Inputs))
+		return
+	}
+	for i, txIn := range tx.TxIn {
+		if op := fmt.Sprintf("%s:%d", txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index); op != rt.Inputs[i].OutPoint() {
+			err = fmt.Errorf("Refill transaction input %d spends %s, not cold output %s", i, op, rt.Inputs[i].OutPoint())
+			return
+		}
+	}
+	return
+}
+
+// Sign signs every input of the refill transaction. Key is either a WIF for a cold address, or
+// the xprv for a cold xpub, in which case each input is signed with the child it pays to.
+func (rt *RefillTx) Sign(key string) (err error) {
+	var tx *wire.MsgTx
+	if tx, err = rt.MsgTx(); err != nil {
+		return
+	}
+
+	// figure out how to get the key for each input
+	var keyFor func(input *ColdUtxo) (*koblitz.PrivateKey, error)
+	var xprv *hdkeychain.ExtendedKey
+	if xprv, err = hdkeychain.NewKeyFromString(key); err == nil {
+		if !xprv.IsPrivate() {
+			err = fmt.Errorf("Refill has to be signed with an xprv, not an xpub")
+			return
+		}
+		keyFor = func(input *ColdUtxo) (priv *koblitz.PrivateKey, err error) {
+			var child *hdkeychain.ExtendedKey
+			if child, err = coldChild(xprv, input.KeyIndex); err != nil {
+				return
+			}
+			return child.ECPrivKey()
+		}
+	} else {
+		var wif *btcutil.WIF
+		if wif, err = btcutil.DecodeWIF(key); err != nil {
+			err = fmt.Errorf("Cold key should be an xprv or WIF: %s", err)
+			return
+		}
+		keyFor = func(input *ColdUtxo) (*koblitz.PrivateKey, error) {
+			return wif.PrivKey, nil
+		}
+	}
+
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i, input := range rt.Inputs {
+		var priv *koblitz.PrivateKey
+		if priv, err = keyFor(input); err != nil {
+			err = fmt.Errorf("Error getting key for refill input %d: %s", i, err)
+			return
+		}
+
+		switch {
+		case len(input.PkScript) == 22 && input.PkScript[0] == 0x00 && input.PkScript[1] == 0x14:
+			if tx.TxIn[i].Witness, err = txscript.WitnessScript(tx, sigHashes, i, int64(input.Amount), input.PkScript, txscript.SigHashAll, priv, true); err != nil {
+				err = fmt.Errorf("Error signing refill input %d: %s", i, err)
+				return
+			}
+		case len(input.PkScript) == 25 && input.PkScript[0] == 0x76 && input.PkScript[1] == 0xa9:
+			if tx.TxIn[i].SignatureScript, err = txscript.SignatureScript(tx, i, input.PkScript, txscript.SigHashAll, priv, true); err != nil {
+				err = fmt.Errorf("Error signing refill input %d: %s", i, err)
+				return
+			}
+		default:
+			err = fmt.Errorf("Refill input %d pays to a script that can't be signed, only P2WPKH and P2PKH can", i)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = tx.Serialize(&buf); err != nil {
+		err = fmt.Errorf("Error serializing signed refill transaction: %s", err)
+		return
+	}
+	rt.Tx = buf.Bytes()
+	return
+}
+
+// Verify checks that every input of the refill transaction is signed, and spends the cold output
+// it says it does.
+func (rt *RefillTx) Verify() (err error) {
+	var tx *wire.MsgTx
+	if tx, err = rt.MsgTx(); err != nil {
+		return
+	}
+
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i, input := range rt.Inputs {
+		var engine *txscript.Engine
+		if engine, err = txscript.NewEngine(input.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, int64(input.Amount)); err != nil {
+			err = fmt.Errorf("Error creating script engine for refill input %d: %s", i, err)
+			return
+		}
+		if err = engine.Execute(); err != nil {
+			err = fmt.Errorf("Refill input %d isn't signed correctly: %s", i, err)
+			return
+		}
+	}
+	return
+}
+
+// ColdChild returns the pubkey of the child of a cold xpub's external chain that outputs with
+// KeyIndex i pay to.
+func ColdChild(xpub *hdkeychain.ExtendedKey, i uint32) (pubkey *koblitz.PublicKey, err error) {
+	var child *hdkeychain.ExtendedKey
+	if child, err = coldChild(xpub, i); err != nil {
+		return
+	}
+	return child.ECPubKey()
+}
+
+// coldChild derives child i of the external chain, 0/i, of a cold extended key
+func coldChild(key *hdkeychain.ExtendedKey, i uint32) (child *hdkeychain.ExtendedKey, err error) {
+	var external *hdkeychain.ExtendedKey
+	if external, err = key.Child(0); err != nil {
+		err = fmt.Errorf("Error deriving external chain of cold key: %s", err)
+		return
+	}
+	if child, err = external.Child(i); err != nil {
+		err = fmt.Errorf("Error deriving child %d of cold key: %s", i, err)
+		return
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package match
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+// TestParseColdWalletPolicy makes sure policies parse back to what they were printed as
+func TestParseColdWalletPolicy(t *testing.T) {
+	policy, err := ParseColdWalletPolicy("100000:bcrt1qcoldaddress")
+	if err != nil {
+		t.Errorf("Error parsing cold wallet policy: %s", err)
+		return
+	}
+	if policy.HotCeiling != 100000 || policy.Destination != "bcrt1qcoldaddress" {
+		t.Errorf("Parsed cold wallet policy %+v is wrong", policy)
+	}
+	if policy.String() != "100000:bcrt1qcoldaddress" {
+		t.Errorf("Cold wallet policy printed as %s", policy.String())
+	}
+
+	for _, bad := range []string{"100000", "100000:", "lots:bcrt1qcoldaddress"} {
+		if _, err = ParseColdWalletPolicy(bad); err == nil {
+			t.Errorf("Parsing cold wallet policy %s should fail", bad)
+		}
+	}
+}
+
+// unsignedRefill creates a refill spending one made up output to each of the pkscripts
+func unsignedRefill(t *testing.T, pkScripts [][]byte, keyIndexes []uint32) (refill *RefillTx) {
+	refill = &RefillTx{Asset: BTCReg}
+	tx := wire.NewMsgTx()
+	for i, pkScript := range pkScripts {
+		prevHash := chainhash.DoubleHashH([]byte{byte(i)})
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, uint32(i)), nil, nil))
+		refill.Inputs = append(refill.Inputs, &ColdUtxo{
+			Txid:     prevHash.String(),
+			Index:    uint32(i),
+			Amount:   50000,
+			PkScript: pkScript,
+			KeyIndex: keyIndexes[i],
+		})
+	}
+	tx.AddTxOut(wire.NewTxOut(90000, pkScripts[0]))
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatalf("Error serializing refill: %s", err)
+	}
+	refill.Tx = buf.Bytes()
+	return
+}
+
+// TestRefillSignXprv signs a refill spending P2WPKH outputs to two children of a cold xpub
+func TestRefillSignXprv(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x42}, 32)
+	xprv, err := hdkeychain.NewMaster(seed, &coinparam.RegressionNetParams)
+	if err != nil {
+		t.Errorf("Error creating cold xprv: %s", err)
+		return
+	}
+	var xpub *hdkeychain.ExtendedKey
+	if xpub, err = xprv.Neuter(); err != nil {
+		t.Errorf("Error getting cold xpub: %s", err)
+		return
+	}
+
+	var pkScripts [][]byte
+	keyIndexes := []uint32{3, 7}
+	for _, i := range keyIndexes {
+		var pubkey *koblitz.PublicKey
+		if pubkey, err = ColdChild(xpub, i); err != nil {
+			t.Errorf("Error deriving cold child: %s", err)
+			return
+		}
+		pkScripts = append(pkScripts, append([]byte{0x00, 0x14}, btcutil.Hash160(pubkey.SerializeCompressed())...))
+	}
+
+	refill := unsignedRefill(t, pkScripts, keyIndexes)
+	if err = refill.Verify(); err == nil {
+		t.Errorf("Unsigned refill should not verify")
+	}
+	if err = refill.Sign(xpub.String()); err == nil {
+		t.Errorf("Signing a refill with an xpub should fail")
+	}
+	if err = refill.Sign(xprv.String()); err != nil {
+		t.Errorf("Error signing refill: %s", err)
+		return
+	}
+	if err = refill.Verify(); err != nil {
+		t.Errorf("Signed refill should verify: %s", err)
+	}
+
+	// swapping the key indexes means the wrong keys sign
+	wrong := unsignedRefill(t, pkScripts, []uint32{7, 3})
+	if err = wrong.Sign(xprv.String()); err != nil {
+		t.Errorf("Error signing refill: %s", err)
+		return
+	}
+	if err = wrong.Verify(); err == nil {
+		t.Errorf("Refill signed with the wrong children should not verify")
+	}
+}
+
+// TestRefillSignWIF signs a refill spending a P2PKH output to a cold address
+func TestRefillSignWIF(t *testing.T) {
+	priv, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Errorf("Error creating cold key: %s", err)
+		return
+	}
+	var wif *btcutil.WIF
+	if wif, err = btcutil.NewWIF(priv, &coinparam.RegressionNetParams, true); err != nil {
+		t.Errorf("Error creating WIF: %s", err)
+		return
+	}
+
+	pkh := btcutil.Hash160(priv.PubKey().SerializeCompressed())
+	pkScript := append(append([]byte{0x76, 0xa9, 0x14}, pkh...), 0x88, 0xac)
+	refill := unsignedRefill(t, [][]byte{pkScript}, []uint32{0})
+	if err = refill.Sign(wif.String()); err != nil {
+		t.Errorf("Error signing refill: %s", err)
+		return
+	}
+	if err = refill.Verify(); err != nil {
+		t.Errorf("Signed refill should verify: %s", err)
+	}
+
+	refill.Inputs[0].Amount++
+	if _, err = refill.MsgTx(); err != nil {
+		t.Errorf("Changing an input amount shouldn't break deserializing: %s", err)
+	}
+	refill.Inputs[0].Txid = refill.Inputs[0].Txid[1:] + "0"
+	if _, err = refill.MsgTx(); err == nil {
+		t.Errorf("Refill with an input that doesn't match its cold output should fail")
+	}
+}
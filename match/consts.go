@@ -13,10 +13,8 @@ const (
 	BTC Asset = 0x00
 	// VTC is a constant used to represent a VTC token
 	VTC Asset = 0x01
-
-	// Wait until you have a LTC coinparam
 	// LTC is a constant used to represent a LTC token
-	// LTC Asset = 0x02
+	LTC Asset = 0x02
 
 	// BTCTest is a constant used to represent a BTC Test net token
 	BTCTest Asset = 0x03
@@ -39,61 +37,35 @@ func largeAssetList() []Asset {
 
 // AssetFromCoinParam gets a byte representation of an asset from a coinparam
 func AssetFromCoinParam(cpm *coinparam.Params) (a Asset, err error) {
+	assetRegistryMtx.RLock()
+	info, found := assetsByCoin[cpm]
+	assetRegistryMtx.RUnlock()
 
-	// create map for that O(1) access
-	assetMap := map[*coinparam.Params]Asset{
-		&coinparam.BitcoinParams:  BTC,
-		&coinparam.VertcoinParams: VTC,
-		// &coinparam.LitecoinParams: LTC,
-		&coinparam.TestNet3Params:         BTCTest,
-		&coinparam.VertcoinTestNetParams:  VTCTest,
-		&coinparam.LiteCoinTestNet4Params: LTCTest,
-		&coinparam.RegressionNetParams:    BTCReg,
-		&coinparam.VertcoinRegTestParams:  VTCReg,
-		&coinparam.LiteRegNetParams:       LTCReg,
-	}
-
-	// grab from map
-	var found bool
-	if a, found = assetMap[cpm]; !found {
+	if !found {
 		err = fmt.Errorf("Could not get an asset for that coin param")
 		return
 	}
-
+	a = info.Asset
 	return
 }
 
-// CoinParamFromAsset is the reverse of AssetFromCoinParam. TODO: change the coinparam so it has an inherent ID anyways?
+// CoinParamFromAsset is the reverse of AssetFromCoinParam.
 func (a Asset) CoinParamFromAsset() (coinType *coinparam.Params, err error) {
-	// create map for that O(1) access
-	assetMap := map[Asset]*coinparam.Params{
-		BTC: &coinparam.BitcoinParams,
-		VTC: &coinparam.VertcoinParams,
-		// LTC: &coinparam.LitecoinParams,
-		BTCTest: &coinparam.TestNet3Params,
-		VTCTest: &coinparam.VertcoinTestNetParams,
-		LTCTest: &coinparam.LiteCoinTestNet4Params,
-		BTCReg:  &coinparam.RegressionNetParams,
-		LTCReg:  &coinparam.LiteRegNetParams,
-		VTCReg:  &coinparam.VertcoinRegTestParams,
-	}
+	assetRegistryMtx.RLock()
+	info, found := assetsByByte[a]
+	assetRegistryMtx.RUnlock()
 
-	// grab from map
-	var found bool
-	if coinType, found = assetMap[a]; !found {
+	if !found {
 		err = fmt.Errorf("Could not get a coin param for that asset")
 		return
 	}
-
+	coinType = info.Coin
 	return
-
 }
 
 // AssetFromString returns an asset from a string
 func AssetFromString(name string) (a Asset, err error) {
-	// we do this to enforce consistency sorta. I don't want this asset thing and the coin params that we support to be separated. They are right now, though.
-	// the main reason they're separated is that I want the coin params to have their own unique byte so the client only sends a byte to indicate which asset
-	// they want. Like an asset ID. TODO: Change this to HDCoinType, or magic bytes in the future. We need an asset id.
+	// the coin params have their own unique byte in the asset registry, so the client only sends a byte to indicate which asset they want
 	var cpm *coinparam.Params
 	if cpm, err = chainutils.GetParamFromName(name); err != nil {
 		return
@@ -2,6 +2,10 @@ package match
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 
@@ -16,22 +20,230 @@ type Deposit struct {
 	Txid                string
 	CoinType            *coinparam.Params
 	BlockHeightReceived uint64
-	Confirmations       uint64
+	// BlockHash is the hash of the block the deposit was seen in, so we know which chain it's on
+	BlockHash     string
+	Confirmations uint64
 }
 
 func (d *Deposit) String() string {
-	return fmt.Sprintf("Deposit: {\n\tPubkey: %x\n\tAddress: %s\n\tAmount: %d\n\tTxid: %s\n\tCoinType: %s\n\tBlockHeightReceived: %d\n\tConfirmations: %d\n}",
-		d.Pubkey.SerializeCompressed(), d.Address, d.Amount, d.Txid, d.CoinType.Name, d.BlockHeightReceived, d.Confirmations)
+	return fmt.Sprintf("Deposit: {\n\tPubkey: %x\n\tAddress: %s\n\tAmount: %d\n\tTxid: %s\n\tCoinType: %s\n\tBlockHeightReceived: %d\n\tBlockHash: %s\n\tConfirmations: %d\n}",
+		d.Pubkey.SerializeCompressed(), d.Address, d.Amount, d.Txid, d.CoinType.Name, d.BlockHeightReceived, d.BlockHash, d.Confirmations)
 }
 
-// LightningDeposit is a struct that represents a deposit made with lightning
+// LightningDeposit is a struct that represents a deposit made with lightning. A channel state
+// can only be credited once, so the channel's outpoint and the state index identify the deposit.
 type LightningDeposit struct {
-	Pubkey   *koblitz.PublicKey // maybe switch to real pubkey later
-	Amount   uint64
-	CoinType *coinparam.Params
-	ChanIdx  uint32
+	Pubkey  [33]byte `json:"pubkey"`
+	Amount  uint64   `json:"amount"`
+	Asset   Asset    `json:"asset"`
+	ChanIdx uint32   `json:"chanidx"`
+	// Outpoint is the channel's funding outpoint, txid:index
+	Outpoint string `json:"outpoint"`
+	StateIdx uint64 `json:"stateidx"`
+	// ChannelAmount is what the exchange had in the channel once the deposit was made
+	ChannelAmount uint64    `json:"channelamount"`
+	Received      time.Time `json:"received"`
 }
 
 func (ld *LightningDeposit) String() string {
-	return fmt.Sprintf("Deposit: {\n\tPubkey: %x\n\tAmount: %d\n\tCoinType: %s\n\tChannelIdx: %d\n\t\n}", ld.Pubkey.SerializeCompressed(), ld.Amount, ld.CoinType.Name, ld.ChanIdx)
+	return fmt.Sprintf("Deposit: {\n\tPubkey: %x\n\tAmount: %d\n\tAsset: %s\n\tChannelIdx: %d\n\tOutpoint: %s\n\tStateIdx: %d\n\tChannelAmount: %d\n}", ld.Pubkey, ld.Amount, ld.Asset, ld.ChanIdx, ld.Outpoint, ld.StateIdx, ld.ChannelAmount)
+}
+
+// ChannelReconciliation compares what's been credited for deposits over a channel with the
+// channel's balance
+type ChannelReconciliation struct {
+	Outpoint string   `json:"outpoint"`
+	Pubkey   [33]byte `json:"pubkey"`
+	Asset    Asset    `json:"asset"`
+	// Credited is the total of the deposits over the channel, made in Deposits states up to
+	// CreditedState
+	Credited      uint64 `json:"credited"`
+	Deposits      int    `json:"deposits"`
+	CreditedState uint64 `json:"creditedstate"`
+	// ChannelState and ChannelAmount are the channel's state index and what the exchange has in it
+	ChannelState  uint64 `json:"channelstate"`
+	ChannelAmount uint64 `json:"channelamount"`
+	Capacity      uint64 `json:"capacity"`
+	// Moved is how much the exchange's side of the channel has changed since the last credited
+	// state, from swaps, withdrawals, invoices or pushes that weren't credited
+	Moved int64 `json:"moved"`
+	// Withdrawn is how much the exchange's side of the channel went down between credited states
+	// and since the last one, which is what went back to the user over the channel
+	Withdrawn uint64 `json:"withdrawn"`
+	// Problem is why the channel doesn't reconcile, it's empty if it does
+	Problem string `json:"problem,omitempty"`
+}
+
+// String returns a short description of the reconciliation
+func (cr *ChannelReconciliation) String() string {
+	desc := fmt.Sprintf("channel %s of %x: %d %s credited over %d deposits up to state %d, %d sent back, has %d at state %d, moved %d since", cr.Outpoint, cr.Pubkey, cr.Credited, cr.Asset, cr.Deposits, cr.CreditedState, cr.Withdrawn, cr.ChannelAmount, cr.ChannelState, cr.Moved)
+	if cr.Problem != "" {
+		desc += ", " + cr.Problem
+	}
+	return desc
+}
+
+// ReconcileChannel checks the deposits credited over a channel against its current state. What
+// was credited, less what the exchange has sent back over the channel since, can't be more than
+// the exchange has in it, no deposit can be for a state the channel hasn't reached, and if no
+// state has happened since the last deposit the exchange has to have exactly what it had then.
+func ReconcileChannel(deposits []*LightningDeposit, stateIdx uint64, channelAmount uint64, capacity uint64) (rec *ChannelReconciliation) {
+	rec = &ChannelReconciliation{
+		Deposits:      len(deposits),
+		ChannelState:  stateIdx,
+		ChannelAmount: channelAmount,
+		Capacity:      capacity,
+	}
+	if len(deposits) == 0 {
+		return
+	}
+
+	byState := make([]*LightningDeposit, len(deposits))
+	copy(byState, deposits)
+	sort.SliceStable(byState, func(i, j int) bool {
+		return byState[i].StateIdx < byState[j].StateIdx
+	})
+
+	// anything the exchange's side went down by between deposits went back to the user. A deposit
+	// for more than the exchange had can't have come out of the channel, so none of it counts.
+	var last *LightningDeposit
+	for _, deposit := range byState {
+		rec.Credited += deposit.Amount
+		var before uint64
+		if deposit.ChannelAmount > deposit.Amount {
+			before = deposit.ChannelAmount - deposit.Amount
+		}
+		if last != nil && before < last.ChannelAmount {
+			rec.Withdrawn += last.ChannelAmount - before
+		}
+		last = deposit
+	}
+	rec.Outpoint = last.Outpoint
+	rec.Pubkey = last.Pubkey
+	rec.Asset = last.Asset
+	rec.CreditedState = last.StateIdx
+	rec.Moved = int64(channelAmount) - int64(last.ChannelAmount)
+	if rec.Moved < 0 {
+		rec.Withdrawn += uint64(-rec.Moved)
+	}
+
+	switch {
+	case last.StateIdx > stateIdx:
+		rec.Problem = fmt.Sprintf("a deposit was credited for state %d but the channel is at state %d", last.StateIdx, stateIdx)
+	case last.StateIdx == stateIdx && rec.Moved != 0:
+		rec.Problem = fmt.Sprintf("the channel is still at credited state %d but the exchange has %d instead of %d", stateIdx, channelAmount, last.ChannelAmount)
+	case rec.Credited > rec.Withdrawn && rec.Credited-rec.Withdrawn > channelAmount:
+		rec.Problem = fmt.Sprintf("%d was credited and %d sent back, more than the %d the exchange has in the channel", rec.Credited, rec.Withdrawn, channelAmount)
+	}
+	return
+}
+
+// DefaultConfirmations is how many confirmations a deposit needs if there's no policy for its coin
+const DefaultConfirmations = 6
+
+// ConfirmationPolicy is how many confirmations a coin's deposits need before they're credited.
+// Bigger deposits can be made to wait longer, since they're worth more to double spend.
+type ConfirmationPolicy struct {
+	// Confirmations is how many confirmations every deposit needs
+	Confirmations uint64 `json:"confirmations"`
+	// ScaleAmount adds a confirmation for every ScaleAmount in the deposit, if it's set
+	ScaleAmount uint64 `json:"scaleamount,omitempty"`
+	// MaxConfirmations caps the confirmations a scaled deposit needs, if it's set
+	MaxConfirmations uint64 `json:"maxconfirmations,omitempty"`
+}
+
+// ConfirmationsFor returns how many confirmations a deposit of amount needs
+func (cp *ConfirmationPolicy) ConfirmationsFor(amount uint64) (confirmations uint64) {
+	confirmations = cp.Confirmations
+	if cp.ScaleAmount != 0 {
+		confirmations += amount / cp.ScaleAmount
+		if cp.MaxConfirmations != 0 && confirmations > cp.MaxConfirmations {
+			confirmations = cp.MaxConfirmations
+		}
+	}
+	// a deposit always needs to be in a block
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	return
+}
+
+// String returns the policy in the same form ParseConfirmationPolicy takes
+func (cp *ConfirmationPolicy) String() string {
+	if cp.ScaleAmount == 0 {
+		return fmt.Sprintf("%d", cp.Confirmations)
+	}
+	return fmt.Sprintf("%d:%d:%d", cp.Confirmations, cp.ScaleAmount, cp.MaxConfirmations)
+}
+
+// ParseConfirmationPolicy parses a policy of the form confirmations, or
+// confirmations:scaleamount:maxconfirmations if it scales with the amount.
+func ParseConfirmationPolicy(policyStr string) (policy *ConfirmationPolicy, err error) {
+	parts := strings.Split(policyStr, ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		err = fmt.Errorf("Confirmation policy %s should be confirmations or confirmations:scaleamount:maxconfirmations", policyStr)
+		return
+	}
+
+	var values [3]uint64
+	for i, part := range parts {
+		if values[i], err = strconv.ParseUint(part, 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing confirmation policy %s: %s", policyStr, err)
+			return
+		}
+	}
+
+	policy = &ConfirmationPolicy{
+		Confirmations:    values[0],
+		ScaleAmount:      values[1],
+		MaxConfirmations: values[2],
+	}
+	if policy.MaxConfirmations != 0 && policy.MaxConfirmations < policy.Confirmations {
+		err = fmt.Errorf("Max confirmations %d can't be less than confirmations %d", policy.MaxConfirmations, policy.Confirmations)
+		policy = nil
+		return
+	}
+	return
+}
+
+// DepositState is where a deposit is in being credited
+type DepositState string
+
+const (
+	// DepositPending is a deposit that doesn't have enough confirmations yet
+	DepositPending DepositState = "pending"
+	// DepositCredited is a deposit that's been added to the user's balance
+	DepositCredited DepositState = "credited"
+	// DepositOrphaned is a deposit whose block was reorged out of the chain
+	DepositOrphaned DepositState = "orphaned"
+)
+
+// DepositStatus is a deposit as its user sees it
+type DepositStatus struct {
+	Txid      string `json:"txid"`
+	Amount    uint64 `json:"amount"`
+	BlockHash string `json:"blockhash"`
+	// BlockHeight is the height the deposit was received at
+	BlockHeight uint64 `json:"blockheight"`
+	// Confirmations is how many confirmations the deposit has so far, 0 if it's orphaned
+	Confirmations uint64 `json:"confirmations"`
+	// ConfirmationsRequired is how many confirmations the deposit needs to be credited
+	ConfirmationsRequired uint64       `json:"confirmationsrequired"`
+	Status                DepositState `json:"status"`
+}
+
+// SetConfirmations sets how many confirmations the deposit has, given the height of the chain.
+// They're counted the same way deposits are credited, as the blocks on top of the deposit's
+// block, so a deposit is credited once it has ConfirmationsRequired. A height of 0 means we
+// don't know the height yet.
+func (ds *DepositStatus) SetConfirmations(chainHeight uint64) {
+	ds.Confirmations = 0
+	switch {
+	case ds.Status == DepositOrphaned:
+	case chainHeight >= ds.BlockHeight && chainHeight != 0:
+		ds.Confirmations = chainHeight - ds.BlockHeight
+	case ds.Status == DepositCredited:
+		ds.Confirmations = ds.ConfirmationsRequired
+	}
+	return
 }
@@ -0,0 +1,135 @@
+package match
+
+import "testing"
+
+// TestConfirmationPolicyScaling tests that bigger deposits need more confirmations, up to the max
+func TestConfirmationPolicyScaling(t *testing.T) {
+	policy, err := ParseConfirmationPolicy("3:1000:10")
+	if err != nil {
+		t.Errorf("Error parsing policy: %s", err)
+		return
+	}
+
+	for _, tc := range []struct {
+		amount   uint64
+		expected uint64
+	}{
+		{0, 3},
+		{999, 3},
+		{2500, 5},
+		{1000000, 10},
+	} {
+		if got := policy.ConfirmationsFor(tc.amount); got != tc.expected {
+			t.Errorf("Deposit of %d should need %d confirmations, got %d", tc.amount, tc.expected, got)
+		}
+	}
+
+	if policy, err = ParseConfirmationPolicy("0"); err != nil {
+		t.Errorf("Error parsing policy: %s", err)
+		return
+	}
+	if got := policy.ConfirmationsFor(100); got != 1 {
+		t.Errorf("Deposits should always need at least one confirmation, got %d", got)
+	}
+
+	for _, bad := range []string{"", "3:1000", "3:1000:2", "three"} {
+		if _, err = ParseConfirmationPolicy(bad); err == nil {
+			t.Errorf("Policy %q should not parse", bad)
+		}
+	}
+}
+
+// TestDepositStatusConfirmations tests confirmations for each deposit state
+func TestDepositStatusConfirmations(t *testing.T) {
+	status := &DepositStatus{BlockHeight: 100, ConfirmationsRequired: 6, Status: DepositPending}
+	if status.SetConfirmations(103); status.Confirmations != 3 {
+		t.Errorf("Deposit at 100 should have 3 confirmations at 103, got %d", status.Confirmations)
+	}
+	// it's credited at 106, so that's when it has all 6
+	if status.SetConfirmations(105); status.Confirmations != 5 {
+		t.Errorf("Deposit at 100 should be one confirmation short at 105, got %d", status.Confirmations)
+	}
+	if status.SetConfirmations(106); status.Confirmations != 6 {
+		t.Errorf("Deposit at 100 should have all 6 confirmations at 106, got %d", status.Confirmations)
+	}
+	if status.SetConfirmations(0); status.Confirmations != 0 {
+		t.Errorf("Pending deposit should have no confirmations if the height isn't known, got %d", status.Confirmations)
+	}
+
+	status.Status = DepositCredited
+	if status.SetConfirmations(0); status.Confirmations != 6 {
+		t.Errorf("Credited deposit should have its required confirmations if the height isn't known, got %d", status.Confirmations)
+	}
+
+	status.Status = DepositOrphaned
+	if status.SetConfirmations(110); status.Confirmations != 0 {
+		t.Errorf("Orphaned deposit should have no confirmations, got %d", status.Confirmations)
+	}
+}
+
+// TestReconcileChannel tests that credits over a channel only reconcile if the channel could have
+// received them
+func TestReconcileChannel(t *testing.T) {
+	deposits := []*LightningDeposit{
+		{Outpoint: "ab:0", Asset: BTCTest, StateIdx: 1, Amount: 5000, ChannelAmount: 5000},
+		{Outpoint: "ab:0", Asset: BTCTest, StateIdx: 3, Amount: 2000, ChannelAmount: 7000},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		stateIdx      uint64
+		channelAmount uint64
+		capacity      uint64
+		moved         int64
+		ok            bool
+	}{
+		{"same state", 3, 7000, 100000, 0, true},
+		{"swapped since", 5, 4000, 100000, -3000, true},
+		{"same state, different amount", 3, 9000, 100000, 2000, false},
+		{"credited ahead of the channel", 2, 7000, 100000, 0, false},
+	} {
+		rec := ReconcileChannel(deposits, tc.stateIdx, tc.channelAmount, tc.capacity)
+		if rec.Credited != 7000 || rec.CreditedState != 3 || rec.Deposits != 2 {
+			t.Errorf("%s should have 7000 credited over 2 deposits up to state 3, got %s", tc.name, rec)
+		}
+		if rec.Moved != tc.moved {
+			t.Errorf("%s should have moved %d, got %d", tc.name, tc.moved, rec.Moved)
+		}
+		if (rec.Problem == "") != tc.ok {
+			t.Errorf("%s should reconcile: %t, got %s", tc.name, tc.ok, rec)
+		}
+	}
+}
+
+// TestReconcileChannelNetCredits tests that channels reconcile against what was credited less
+// what went back to the user, not against everything ever credited
+func TestReconcileChannelNetCredits(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		deposits      []*LightningDeposit
+		stateIdx      uint64
+		channelAmount uint64
+		withdrawn     uint64
+		ok            bool
+	}{
+		{"deposited more than capacity after sending some back", []*LightningDeposit{
+			{StateIdx: 1, Amount: 5000, ChannelAmount: 5000},
+			{StateIdx: 4, Amount: 4000, ChannelAmount: 5000},
+		}, 6, 3000, 6000, true},
+		{"credited more than the channel had", []*LightningDeposit{
+			{StateIdx: 1, Amount: 5000, ChannelAmount: 3000},
+		}, 1, 3000, 0, false},
+		{"credited more than the channel had after sending some back", []*LightningDeposit{
+			{StateIdx: 1, Amount: 5000, ChannelAmount: 5000},
+			{StateIdx: 2, Amount: 4000, ChannelAmount: 3000},
+		}, 2, 3000, 5000, false},
+	} {
+		rec := ReconcileChannel(tc.deposits, tc.stateIdx, tc.channelAmount, 6000)
+		if rec.Withdrawn != tc.withdrawn {
+			t.Errorf("%s should have %d sent back, got %s", tc.name, tc.withdrawn, rec)
+		}
+		if (rec.Problem == "") != tc.ok {
+			t.Errorf("%s should reconcile: %t, got %s", tc.name, tc.ok, rec)
+		}
+	}
+}
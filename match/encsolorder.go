@@ -2,8 +2,10 @@ package match
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"math/big"
 
 	"github.com/mit-dci/opencx/crypto/rsw"
 )
@@ -23,88 +25,163 @@ type SignedEncSolOrder struct {
 	Signature   []byte                 `json:"signature"`
 }
 
-// Serialize uses gob encoding to turn the encrypted solution order
-// into bytes.
+// solutionEncodingVersion is the first byte of serialized encrypted
+// solution orders and solution orders. They used to be gob encoded,
+// and a gob stream starts with the length of its first message, which
+// is never 1, so anything that doesn't start with this is decoded as
+// gob.
+const solutionEncodingVersion byte = 0x01
+
+// Serialize turns the encrypted solution order into bytes. Users sign
+// the hash of these bytes and the exchange commits to them, so unlike
+// gob, the encoding doesn't depend on what else the process has
+// encoded:
+// [1 byte version] [8 byte len] ciphertext [8 byte len] N [8 byte len] A [8 byte len] T [8 byte len] CK [32 byte auctionid] [2 byte pair]
 func (es *EncryptedSolutionOrder) Serialize() (raw []byte, err error) {
-	var b bytes.Buffer
-
-	// register SolutionOrder interface
-	gob.Register(EncryptedSolutionOrder{})
-
-	// create a new encoder writing to the buffer
-	enc := gob.NewEncoder(&b)
-
-	// encode the puzzle in the buffer
-	if err = enc.Encode(es); err != nil {
-		err = fmt.Errorf("Error encoding encryptedsolutionorder: %s", err)
-		return
+	raw = append(raw, solutionEncodingVersion)
+	raw = appendVarBytes(raw, es.OrderCiphertext)
+	for _, puzzleInt := range []*big.Int{es.OrderPuzzle.N, es.OrderPuzzle.A, es.OrderPuzzle.T, es.OrderPuzzle.CK} {
+		raw = appendBigInt(raw, puzzleInt)
 	}
-
-	// Get the bytes from the buffer
-	raw = b.Bytes()
+	raw = append(raw, es.IntendedAuction[:]...)
+	raw = append(raw, es.IntendedPair.Serialize()...)
 	return
 }
 
 // Deserialize turns the encrypted solution order from bytes into a
-// usable struct.
+// usable struct. Gob encoded ones from before there was a version byte
+// can still be read.
 func (es *EncryptedSolutionOrder) Deserialize(raw []byte) (err error) {
-	var b *bytes.Buffer
-	b = bytes.NewBuffer(raw)
-
-	// register SolutionOrder
-	gob.Register(EncryptedSolutionOrder{})
-
-	// create a new decoder writing to the buffer
-	dec := gob.NewDecoder(b)
-
-	// decode the solutionorder in the buffer
-	if err = dec.Decode(es); err != nil {
-		err = fmt.Errorf("Error decoding encryptedsolutionorder: %s", err)
+	if len(raw) == 0 || raw[0] != solutionEncodingVersion {
+		if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(es); err != nil {
+			err = fmt.Errorf("Error decoding gob encryptedsolutionorder: %s", err)
+			return
+		}
+		return
+	}
+	if raw, err = es.deserializeFrom(raw); err != nil {
 		return
 	}
+	if len(raw) != 0 {
+		err = fmt.Errorf("Error decoding encryptedsolutionorder: %d extra bytes", len(raw))
+		return
+	}
+	return
+}
 
+// deserializeFrom reads the encrypted solution order from the start of
+// raw, version byte and all, and returns what's left
+func (es *EncryptedSolutionOrder) deserializeFrom(raw []byte) (rest []byte, err error) {
+	if len(raw) == 0 || raw[0] != solutionEncodingVersion {
+		err = fmt.Errorf("Error decoding encryptedsolutionorder: unknown version")
+		return
+	}
+	raw = raw[1:]
+	if raw, es.OrderCiphertext, err = readVarBytes(raw); err != nil {
+		err = fmt.Errorf("Error decoding encryptedsolutionorder ciphertext: %s", err)
+		return
+	}
+	for _, puzzleInt := range []**big.Int{&es.OrderPuzzle.N, &es.OrderPuzzle.A, &es.OrderPuzzle.T, &es.OrderPuzzle.CK} {
+		if raw, *puzzleInt, err = readBigInt(raw); err != nil {
+			err = fmt.Errorf("Error decoding encryptedsolutionorder puzzle: %s", err)
+			return
+		}
+	}
+	if len(raw) < len(es.IntendedAuction)+2 {
+		err = fmt.Errorf("Error decoding encryptedsolutionorder: too short for auction and pair")
+		return
+	}
+	copy(es.IntendedAuction[:], raw[:len(es.IntendedAuction)])
+	raw = raw[len(es.IntendedAuction):]
+	if err = es.IntendedPair.Deserialize(raw[:2]); err != nil {
+		err = fmt.Errorf("Error decoding encryptedsolutionorder pair: %s", err)
+		return
+	}
+	rest = raw[2:]
 	return
 }
 
-// Serialize uses gob encoding to turn the encrypted solution order
-// into bytes.
+// Serialize turns the signed encrypted solution order into bytes, the
+// encrypted solution order followed by [8 byte len] signature
 func (se *SignedEncSolOrder) Serialize() (raw []byte, err error) {
-	var b bytes.Buffer
-
-	// register SolutionOrder interface
-	gob.Register(SignedEncSolOrder{})
-
-	// create a new encoder writing to the buffer
-	enc := gob.NewEncoder(&b)
-
-	// encode the puzzle in the buffer
-	if err = enc.Encode(se); err != nil {
+	if raw, err = se.EncSolOrder.Serialize(); err != nil {
 		err = fmt.Errorf("Error encoding encsolorder: %s", err)
 		return
 	}
-
-	// Get the bytes from the buffer
-	raw = b.Bytes()
+	raw = appendVarBytes(raw, se.Signature)
 	return
 }
 
 // Deserialize turns the signed encrypted solution order from bytes
-// into a usable struct.
+// into a usable struct. Gob encoded ones from before there was a
+// version byte can still be read.
 func (se *SignedEncSolOrder) Deserialize(raw []byte) (err error) {
-	var b *bytes.Buffer
-	b = bytes.NewBuffer(raw)
-
-	// register SolutionOrder
-	gob.Register(SignedEncSolOrder{})
+	if len(raw) == 0 || raw[0] != solutionEncodingVersion {
+		if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(se); err != nil {
+			err = fmt.Errorf("Error decoding gob encsolorder: %s", err)
+			return
+		}
+		return
+	}
+	if raw, err = se.EncSolOrder.deserializeFrom(raw); err != nil {
+		err = fmt.Errorf("Error decoding encsolorder: %s", err)
+		return
+	}
+	if raw, se.Signature, err = readVarBytes(raw); err != nil {
+		err = fmt.Errorf("Error decoding encsolorder signature: %s", err)
+		return
+	}
+	if len(raw) != 0 {
+		err = fmt.Errorf("Error decoding encsolorder: %d extra bytes", len(raw))
+		return
+	}
+	return
+}
 
-	// create a new decoder writing to the buffer
-	dec := gob.NewDecoder(b)
+// appendVarBytes appends the 8 byte little endian length of data and
+// then data to buf
+func appendVarBytes(buf []byte, data []byte) []byte {
+	lenBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenBytes, uint64(len(data)))
+	buf = append(buf, lenBytes...)
+	return append(buf, data...)
+}
 
-	// decode the solutionorder in the buffer
-	if err = dec.Decode(se); err != nil {
-		err = fmt.Errorf("Error decoding encsolorder: %s", err)
+// readVarBytes reads bytes written by appendVarBytes from the start of
+// buf, and returns what's left
+func readVarBytes(buf []byte) (rest []byte, data []byte, err error) {
+	if len(buf) < 8 {
+		err = fmt.Errorf("Not enough bytes for length")
 		return
 	}
+	dataLen := binary.LittleEndian.Uint64(buf[:8])
+	buf = buf[8:]
+	if uint64(len(buf)) < dataLen {
+		err = fmt.Errorf("Length %d is longer than the %d bytes left", dataLen, len(buf))
+		return
+	}
+	data = make([]byte, dataLen)
+	copy(data, buf[:dataLen])
+	rest = buf[dataLen:]
+	return
+}
+
+// appendBigInt appends the big endian bytes of a nonnegative int to buf
+// the same way as appendVarBytes. A nil int is written as zero.
+func appendBigInt(buf []byte, num *big.Int) []byte {
+	if num == nil {
+		return appendVarBytes(buf, nil)
+	}
+	return appendVarBytes(buf, num.Bytes())
+}
 
+// readBigInt reads an int written by appendBigInt from the start of
+// buf, and returns what's left
+func readBigInt(buf []byte) (rest []byte, num *big.Int, err error) {
+	var numBytes []byte
+	if rest, numBytes, err = readVarBytes(buf); err != nil {
+		return
+	}
+	num = new(big.Int).SetBytes(numBytes)
 	return
 }
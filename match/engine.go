@@ -1,5 +1,7 @@
 package match
 
+import "time"
+
 // The LimitEngine is the interface for the internal matching engine. This should be the lowest level
 // interface for the representation of a matching engine.
 // One of these should be made for every pair.
@@ -9,6 +11,15 @@ type LimitEngine interface {
 	MatchLimitOrders() (orderExecs []*OrderExecution, settlementExecs []*SettlementExecution, err error)
 }
 
+// DeterministicLimitEngine is a LimitEngine where the caller decides when an order was placed,
+// rather than the engine reading the clock. Given the same orders with the same placement times,
+// it should always produce the same order IDs and the same executions, so the engine state can be
+// rebuilt from a log of inputs.
+type DeterministicLimitEngine interface {
+	LimitEngine
+	PlaceLimitOrderAt(order *LimitOrder, placementTime time.Time) (idRes *LimitOrderIDPair, err error)
+}
+
 // The AuctionEngine is the interface for the internal matching engine. This should be the lowest level
 // interface for the representation of a matching engine.
 // One of these should be made for every pair.
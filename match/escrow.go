@@ -0,0 +1,252 @@
+package match
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// EscrowState is where an order escrow is in its life. An escrow starts out pending, and ends up
+// completed, refunded or expired.
+type EscrowState string
+
+const (
+	// EscrowPending is an escrow waiting for the user's HTLCs, its order isn't on the book yet
+	EscrowPending EscrowState = "pending"
+	// EscrowCommitted is an escrow whose HTLCs are locked in the user's channels, its order is on
+	// the book
+	EscrowCommitted EscrowState = "committed"
+	// EscrowReleasing is an escrow whose order was filled or cancelled, the exchange is offering
+	// HTLCs for what the order got and the rest of what was locked
+	EscrowReleasing EscrowState = "releasing"
+	// EscrowClaimed is an escrow whose HTLCs the exchange claimed, which reveals the preimage, so
+	// the user can claim the exchange's
+	EscrowClaimed EscrowState = "claimed"
+	// EscrowCompleted is an escrow where the user claimed the exchange's HTLCs
+	EscrowCompleted EscrowState = "completed"
+	// EscrowRefunded is an escrow whose exchange HTLCs timed out and were taken back
+	EscrowRefunded EscrowState = "refunded"
+	// EscrowExpired is an escrow that timed out before the exchange claimed it, so the user's
+	// HTLCs go back to them
+	EscrowExpired EscrowState = "expired"
+)
+
+// DefaultEscrowTimeout is how many blocks the user's HTLCs for a channel order have to last if
+// there's no swap policy, it's as long as the order can rest on the book
+const DefaultEscrowTimeout = 1008
+
+// Final returns true if nothing else will happen to the escrow
+func (s EscrowState) Final() bool {
+	return s == EscrowCompleted || s == EscrowRefunded || s == EscrowExpired
+}
+
+// EscrowStateFromString returns the state for a string, or an error if it isn't a state
+func EscrowStateFromString(str string) (state EscrowState, err error) {
+	switch EscrowState(str) {
+	case EscrowPending, EscrowCommitted, EscrowReleasing, EscrowClaimed, EscrowCompleted, EscrowRefunded, EscrowExpired:
+		state = EscrowState(str)
+	default:
+		err = fmt.Errorf("Unknown escrow state %s", str)
+	}
+	return
+}
+
+// OrderEscrow commits a channel order to the user's lightning channels. The user locks what the
+// order has in HTLCs to a hash only the exchange knows the preimage of, and the order goes on the
+// book once they're all there. Lit HTLCs can't be cancelled, so the escrow is released by the
+// exchange offering HTLCs with the same hash for what the order got and the rest of what was
+// locked, then claiming the user's HTLCs, which reveals the preimage the user needs to claim the
+// exchange's. The exchange never holds the user's coins outside of a channel.
+type OrderEscrow struct {
+	// RHash is the hash every HTLC in the escrow is locked to, it identifies the escrow
+	RHash [32]byte `json:"rhash"`
+	// Preimage unlocks the HTLCs, only the exchange knows it until the escrow is claimed
+	Preimage [16]byte `json:"preimage"`
+	Pubkey   [33]byte `json:"pubkey"`
+	// Order is the channel order, it's placed on the book once the escrow is committed, and
+	// OrderID is what it's on the book as
+	Order   LimitOrder `json:"order"`
+	OrderID OrderID    `json:"orderid"`
+	// LockAsset is what the order has. The user locks AmountLock of it, in HTLCs that last until
+	// at least Locktime on its chain.
+	LockAsset  Asset  `json:"lockasset"`
+	AmountLock uint64 `json:"amountlock"`
+	Locktime   uint32 `json:"locktime"`
+	// AmountFilled is how much of AmountLock the order gave up when it was filled, the exchange
+	// sends AmountReceive of ReceiveAsset for it in HTLCs that time out at ReceiveLocktime
+	AmountFilled    uint64 `json:"amountfilled"`
+	ReceiveAsset    Asset  `json:"receiveasset"`
+	AmountReceive   uint64 `json:"amountreceive"`
+	ReceiveLocktime uint32 `json:"receivelocktime"`
+	// AmountRefund is the rest of AmountLock, the exchange sends it back in HTLCs of LockAsset
+	// that time out at RefundLocktime
+	AmountRefund   uint64      `json:"amountrefund"`
+	RefundLocktime uint32      `json:"refundlocktime"`
+	State          EscrowState `json:"state"`
+	// Reason is why the escrow was refunded or expired
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// NewOrderEscrow creates a pending escrow for a channel order, with a new random preimage. The
+// user's HTLCs have to last policy.EscrowTimeout blocks past lockHeight, the height of the chain
+// of the asset the order has.
+func NewOrderEscrow(order *LimitOrder, lockHeight uint32, policy *SwapPolicy, createTime time.Time) (escrow *OrderEscrow, err error) {
+	if order.AmountHave == 0 || order.AmountWant == 0 {
+		err = fmt.Errorf("Can't escrow an empty order")
+		return
+	}
+
+	have, want := order.TradingPair.HaveWantAssets(order.Side)
+	escrow = &OrderEscrow{
+		Pubkey:       order.Pubkey,
+		Order:        *order,
+		LockAsset:    have,
+		AmountLock:   order.AmountHave,
+		Locktime:     lockHeight + policy.EscrowTimeout,
+		ReceiveAsset: want,
+		State:        EscrowPending,
+		Created:      createTime,
+		Updated:      createTime,
+	}
+	if _, err = rand.Read(escrow.Preimage[:]); err != nil {
+		err = fmt.Errorf("Error reading random bytes into preimage for NewOrderEscrow: %s", err)
+		escrow = nil
+		return
+	}
+	escrow.RHash = sha256.Sum256(escrow.Preimage[:])
+	return
+}
+
+// ID returns the hex hash of the escrow, which is how users refer to it
+func (e *OrderEscrow) ID() string {
+	return hex.EncodeToString(e.RHash[:])
+}
+
+// String returns a short description of the escrow
+func (e *OrderEscrow) String() string {
+	str := fmt.Sprintf("escrow %s: lock %d %s until %d, %s", e.ID(), e.AmountLock, e.LockAsset, e.Locktime, e.State)
+	if e.State != EscrowPending && e.State != EscrowCommitted {
+		str += fmt.Sprintf(", release %d %s and refund %d %s", e.AmountReceive, e.ReceiveAsset, e.AmountRefund, e.LockAsset)
+	}
+	if e.Reason != "" {
+		str += fmt.Sprintf(" (%s)", e.Reason)
+	}
+	return str
+}
+
+// SetState moves the escrow to a new state at a time, with the reason it was refunded or expired
+func (e *OrderEscrow) SetState(state EscrowState, reason string, updateTime time.Time) {
+	e.State = state
+	e.Reason = reason
+	e.Updated = updateTime
+	return
+}
+
+// Public returns a copy of the escrow that's safe to show the user. The preimage is left out
+// until the exchange has revealed it by claiming the user's HTLCs.
+func (e *OrderEscrow) Public() (public *OrderEscrow) {
+	public = new(OrderEscrow)
+	*public = *e
+	if e.State != EscrowClaimed && e.State != EscrowCompleted {
+		public.Preimage = [16]byte{}
+	}
+	return
+}
+
+// CheckLocked returns an error if the user's HTLCs don't commit the escrow at lockHeight, the
+// height of the chain of the asset locked. The user's uncleared HTLCs have to add up to at least
+// AmountLock, each of them has to last until Locktime, and the escrow can't be so close to timing
+// out that its order would have to come straight off the book.
+func (e *OrderEscrow) CheckLocked(htlcs []SwapHTLC, lockHeight uint32, policy *SwapPolicy) (err error) {
+	if e.MustRelease(lockHeight, policy) {
+		err = fmt.Errorf("Escrow %s times out at %d, it's too late to commit it at %d", e.ID(), e.Locktime, lockHeight)
+		return
+	}
+	var total uint64
+	for _, htlc := range htlcs {
+		if !htlc.Incoming || htlc.Cleared {
+			continue
+		}
+		if htlc.Locktime < e.Locktime {
+			err = fmt.Errorf("HTLC for escrow %s times out at %d, it has to last until at least %d", e.ID(), htlc.Locktime, e.Locktime)
+			return
+		}
+		total += htlc.Amount
+	}
+	if total < e.AmountLock {
+		err = fmt.Errorf("HTLCs for escrow %s only add up to %d, need %d", e.ID(), total, e.AmountLock)
+		return
+	}
+	return
+}
+
+// Release decides what the exchange sends when a committed escrow's order is filled or
+// cancelled. The order gave up amountFilled of what was locked for amountReceive, the rest is
+// refunded. The exchange's HTLCs time out policy.Timeout blocks after receiveHeight and
+// lockHeight, the heights of the chains of the asset received and the asset locked.
+func (e *OrderEscrow) Release(amountFilled uint64, amountReceive uint64, receiveHeight uint32, lockHeight uint32, policy *SwapPolicy, releaseTime time.Time) (err error) {
+	if e.State != EscrowCommitted {
+		err = fmt.Errorf("Can't release escrow %s, it's %s", e.ID(), e.State)
+		return
+	}
+	if amountFilled > e.AmountLock {
+		err = fmt.Errorf("Can't release escrow %s for a fill of %d, only %d is locked", e.ID(), amountFilled, e.AmountLock)
+		return
+	}
+
+	e.AmountFilled = amountFilled
+	e.AmountReceive = amountReceive
+	e.AmountRefund = e.AmountLock - amountFilled
+	if e.AmountReceive != 0 {
+		e.ReceiveLocktime = receiveHeight + policy.Timeout
+	}
+	if e.AmountRefund != 0 {
+		e.RefundLocktime = lockHeight + policy.Timeout
+	}
+	e.SetState(EscrowReleasing, "", releaseTime)
+	return
+}
+
+// CanClaim returns true if the user's HTLCs last long enough past lockHeight, the height of the
+// chain of the asset locked, for the exchange to claim them
+func (e *OrderEscrow) CanClaim(lockHeight uint32, policy *SwapPolicy) bool {
+	return lockHeight+policy.ClaimMargin < e.Locktime
+}
+
+// MustRelease returns true if the escrow's order has to come off the book at lockHeight, so
+// there's still a claim margin left to release it in
+func (e *OrderEscrow) MustRelease(lockHeight uint32, policy *SwapPolicy) bool {
+	return !e.CanClaim(lockHeight+policy.ClaimMargin, policy)
+}
+
+// SentClaimed returns true if the user claimed every HTLC the exchange sent to release the escrow
+func (e *OrderEscrow) SentClaimed(htlcs []SwapHTLC) bool {
+	var sent int
+	for _, htlc := range htlcs {
+		if htlc.Incoming {
+			continue
+		}
+		if !htlc.Cleared || sha256.Sum256(htlc.Preimage[:]) != e.RHash {
+			return false
+		}
+		sent++
+	}
+	return sent != 0
+}
+
+// TimedOut returns true if every HTLC the exchange sends to release the escrow has timed out at
+// receiveHeight and lockHeight, the heights of the chains of the asset received and the asset
+// locked
+func (e *OrderEscrow) TimedOut(receiveHeight uint32, lockHeight uint32) bool {
+	if e.AmountReceive != 0 && receiveHeight < e.ReceiveLocktime {
+		return false
+	}
+	if e.AmountRefund != 0 && lockHeight < e.RefundLocktime {
+		return false
+	}
+	return true
+}
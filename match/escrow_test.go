@@ -0,0 +1,124 @@
+package match
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+var testEscrowPolicy = &SwapPolicy{Timeout: 50, ClaimMargin: 6, EscrowTimeout: 100}
+
+func testEscrow(t *testing.T) (escrow *OrderEscrow) {
+	order := &LimitOrder{
+		TradingPair: Pair{AssetWant: BTCTest, AssetHave: LTCTest},
+		Side:        Buy,
+		AmountHave:  1000,
+		AmountWant:  4000,
+	}
+	var err error
+	if escrow, err = NewOrderEscrow(order, 200, testEscrowPolicy, time.Unix(1, 0)); err != nil {
+		t.Fatalf("Error creating escrow: %s", err)
+	}
+	return
+}
+
+// TestNewOrderEscrow tests that an escrow locks what the order has until the escrow timeout
+func TestNewOrderEscrow(t *testing.T) {
+	escrow := testEscrow(t)
+	if escrow.LockAsset != LTCTest || escrow.AmountLock != 1000 || escrow.ReceiveAsset != BTCTest {
+		t.Errorf("Buy order should lock 1000 %s and receive %s, got %s", LTCTest, BTCTest, escrow)
+	}
+	if escrow.Locktime != 300 || escrow.State != EscrowPending {
+		t.Errorf("Escrow should be pending and lock until 300, got %d and %s", escrow.Locktime, escrow.State)
+	}
+	if sha256.Sum256(escrow.Preimage[:]) != escrow.RHash {
+		t.Errorf("RHash should be the hash of the preimage")
+	}
+	if escrow.Public().Preimage != [16]byte{} {
+		t.Errorf("Preimage shouldn't be public before the escrow is claimed")
+	}
+	escrow.SetState(EscrowClaimed, "", time.Unix(2, 0))
+	if escrow.Public().Preimage != escrow.Preimage {
+		t.Errorf("Preimage should be public once the escrow is claimed")
+	}
+
+	if _, err := NewOrderEscrow(&LimitOrder{}, 200, testEscrowPolicy, time.Unix(1, 0)); err == nil {
+		t.Errorf("Empty order should not make an escrow")
+	}
+	if state, err := EscrowStateFromString("releasing"); err != nil || state != EscrowReleasing {
+		t.Errorf("Should parse releasing, got %s and %v", state, err)
+	}
+	if _, err := EscrowStateFromString("held"); err == nil {
+		t.Errorf("Should not parse unknown state")
+	}
+}
+
+// TestEscrowCheckLocked tests when the user's HTLCs commit an escrow
+func TestEscrowCheckLocked(t *testing.T) {
+	escrow := testEscrow(t)
+	outgoing := SwapHTLC{Amount: 1000, Locktime: 300}
+
+	for _, tc := range []struct {
+		name   string
+		htlcs  []SwapHTLC
+		height uint32
+		locked bool
+	}{
+		{"no htlcs", []SwapHTLC{outgoing}, 200, false},
+		{"too little", []SwapHTLC{{Incoming: true, Amount: 999, Locktime: 300}}, 200, false},
+		{"split", []SwapHTLC{{Incoming: true, Amount: 600, Locktime: 300}, {Incoming: true, Amount: 400, Locktime: 400}}, 200, true},
+		{"too short", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 299}}, 200, false},
+		{"cleared", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 300, Cleared: true}}, 200, false},
+		{"too late", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 300}}, 288, false},
+	} {
+		if err := escrow.CheckLocked(tc.htlcs, tc.height, testEscrowPolicy); (err == nil) != tc.locked {
+			t.Errorf("Locked for %s should be %t, got error %v", tc.name, tc.locked, err)
+		}
+	}
+}
+
+// TestEscrowRelease tests that a released escrow sends what the order got and refunds the rest
+func TestEscrowRelease(t *testing.T) {
+	escrow := testEscrow(t)
+	if err := escrow.Release(400, 1600, 500, 210, testEscrowPolicy, time.Unix(2, 0)); err == nil {
+		t.Errorf("Pending escrow should not be released")
+	}
+
+	escrow.SetState(EscrowCommitted, "", time.Unix(2, 0))
+	if err := escrow.Release(1001, 4004, 500, 210, testEscrowPolicy, time.Unix(3, 0)); err == nil {
+		t.Errorf("Escrow should not be released for more than is locked")
+	}
+	if err := escrow.Release(400, 1600, 500, 210, testEscrowPolicy, time.Unix(3, 0)); err != nil {
+		t.Fatalf("Error releasing escrow: %s", err)
+	}
+	if escrow.State != EscrowReleasing || escrow.AmountReceive != 1600 || escrow.AmountRefund != 600 {
+		t.Errorf("Escrow should be releasing 1600 and refunding 600, got %s", escrow)
+	}
+	if escrow.ReceiveLocktime != 550 || escrow.RefundLocktime != 260 {
+		t.Errorf("Release should time out at 550 and refund at 260, got %d and %d", escrow.ReceiveLocktime, escrow.RefundLocktime)
+	}
+	if escrow.TimedOut(549, 300) || escrow.TimedOut(550, 259) || !escrow.TimedOut(550, 260) {
+		t.Errorf("Release should time out once both sides have")
+	}
+
+	// an unfilled order is only refunded
+	cancelled := testEscrow(t)
+	cancelled.SetState(EscrowCommitted, "", time.Unix(2, 0))
+	if err := cancelled.Release(0, 0, 500, 210, testEscrowPolicy, time.Unix(3, 0)); err != nil {
+		t.Fatalf("Error releasing cancelled escrow: %s", err)
+	}
+	if cancelled.AmountRefund != 1000 || cancelled.ReceiveLocktime != 0 || !cancelled.TimedOut(0, 260) {
+		t.Errorf("Cancelled escrow should only refund 1000 until 260, got %s", cancelled)
+	}
+}
+
+// TestEscrowMustRelease tests that an escrow's order comes off the book with a claim margin left
+func TestEscrowMustRelease(t *testing.T) {
+	escrow := testEscrow(t)
+	if escrow.MustRelease(287, testEscrowPolicy) || !escrow.MustRelease(288, testEscrowPolicy) {
+		t.Errorf("Escrow locked until 300 should be released from 288")
+	}
+	if !escrow.CanClaim(293, testEscrowPolicy) || escrow.CanClaim(294, testEscrowPolicy) {
+		t.Errorf("Escrow locked until 300 should be claimable until 293")
+	}
+}
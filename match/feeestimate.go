@@ -0,0 +1,194 @@
+package match
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mit-dci/lit/wire"
+)
+
+// FeePriority is how quickly a user wants their withdrawal to confirm, which decides the fee rate
+// its transaction pays. The zero value is normal priority.
+type FeePriority uint8
+
+const (
+	// FeePriorityNormal pays the median fee rate of recent blocks
+	FeePriorityNormal FeePriority = iota
+	// FeePriorityLow pays a fee rate most recent blocks were above, for withdrawals that can wait
+	FeePriorityLow
+	// FeePriorityHigh pays a fee rate almost every recent block was below
+	FeePriorityHigh
+)
+
+const (
+	// FeeEstimateWindow is how many of the most recent blocks fee estimates come from
+	FeeEstimateWindow = 24
+	// MinFeeRate is the lowest fee rate that's ever estimated, in satoshis per vbyte. It's the
+	// default minimum relay fee, so anything lower wouldn't be relayed.
+	MinFeeRate = 1
+	// initialSubsidy is the block subsidy before the first halving, which is 50 coins for every
+	// coin we support
+	initialSubsidy = 50 * 100000000
+)
+
+// String returns the name of the priority
+func (fp FeePriority) String() string {
+	switch fp {
+	case FeePriorityNormal:
+		return "normal"
+	case FeePriorityLow:
+		return "low"
+	case FeePriorityHigh:
+		return "high"
+	}
+	return "UNKNOWN PRIORITY"
+}
+
+// Valid returns true if the priority is one we know about
+func (fp FeePriority) Valid() bool {
+	return fp == FeePriorityNormal || fp == FeePriorityLow || fp == FeePriorityHigh
+}
+
+// percentile is how far through the sorted fee rates of recent blocks the priority's rate is
+func (fp FeePriority) percentile() int {
+	switch fp {
+	case FeePriorityLow:
+		return 25
+	case FeePriorityHigh:
+		return 90
+	}
+	return 50
+}
+
+// FeePriorityFromString returns the priority for a string, or an error if it isn't a priority
+func FeePriorityFromString(str string) (priority FeePriority, err error) {
+	switch str {
+	case "normal":
+		priority = FeePriorityNormal
+	case "low":
+		priority = FeePriorityLow
+	case "high":
+		priority = FeePriorityHigh
+	default:
+		err = fmt.Errorf("Unknown fee priority %s, should be low, normal or high", str)
+	}
+	return
+}
+
+// TxVsize returns the virtual size of a transaction, which is what fee rates are per
+func TxVsize(tx *wire.MsgTx) (vsize int64) {
+	weight := int64(tx.SerializeSizeStripped())*3 + int64(tx.SerializeSize())
+	vsize = (weight + 3) / 4
+	return
+}
+
+// BlockFeeRate returns the average fee rate paid by the transactions in a block, in satoshis per
+// vbyte. A block's transactions don't say how much their inputs were worth, so the fees are how
+// much more the coinbase claims than the subsidy at that height. subsidyInterval is the coin's
+// SubsidyReductionInterval. The rate is 0 for a block with nothing but a coinbase.
+func BlockFeeRate(txs []*wire.MsgTx, height uint64, subsidyInterval int32) (feeRate int64) {
+	if len(txs) < 2 {
+		return
+	}
+
+	var subsidy int64 = initialSubsidy
+	if subsidyInterval > 0 {
+		halvings := height / uint64(subsidyInterval)
+		if halvings >= 64 {
+			subsidy = 0
+		} else {
+			subsidy >>= halvings
+		}
+	}
+
+	var claimed int64
+	for _, txOut := range txs[0].TxOut {
+		claimed += txOut.Value
+	}
+
+	var vsize int64
+	for _, tx := range txs[1:] {
+		vsize += TxVsize(tx)
+	}
+
+	// miners can claim less than they're allowed to, then the fees are lost and we can't see them
+	if claimed <= subsidy || vsize == 0 {
+		return
+	}
+	feeRate = (claimed - subsidy) / vsize
+	return
+}
+
+// FeeEstimator estimates the fee rate for each priority from the fee rates of the last
+// FeeEstimateWindow blocks it's seen. It isn't safe to use from more than one goroutine.
+type FeeEstimator struct {
+	// blockRates are the fee rates of recent blocks by height
+	blockRates map[uint64]int64
+}
+
+// FeeEstimates are the fee rates, in satoshis per vbyte, withdrawals of an asset pay at each
+// priority. Blocks is how many blocks they're estimated from, if it's 0 they're the wallet's fixed
+// fee rate.
+type FeeEstimates struct {
+	Asset  Asset `json:"asset"`
+	Low    int64 `json:"low"`
+	Normal int64 `json:"normal"`
+	High   int64 `json:"high"`
+	Blocks int   `json:"blocks"`
+}
+
+// NewFeeEstimator creates a fee estimator that hasn't seen any blocks
+func NewFeeEstimator() (fe *FeeEstimator) {
+	fe = &FeeEstimator{
+		blockRates: make(map[uint64]int64),
+	}
+	return
+}
+
+// AddBlock adds the fee rate of the block at height, and forgets blocks that are no longer among
+// the most recent
+func (fe *FeeEstimator) AddBlock(height uint64, feeRate int64) {
+	fe.blockRates[height] = feeRate
+	for blockHeight := range fe.blockRates {
+		if blockHeight+FeeEstimateWindow <= height {
+			delete(fe.blockRates, blockHeight)
+		}
+	}
+	return
+}
+
+// DisconnectBlocks forgets the blocks above height, after a reorg
+func (fe *FeeEstimator) DisconnectBlocks(height uint64) {
+	for blockHeight := range fe.blockRates {
+		if blockHeight > height {
+			delete(fe.blockRates, blockHeight)
+		}
+	}
+	return
+}
+
+// Blocks returns how many blocks estimates are coming from
+func (fe *FeeEstimator) Blocks() int {
+	return len(fe.blockRates)
+}
+
+// Estimate returns the fee rate for a priority, in satoshis per vbyte, and false if there aren't
+// any blocks to estimate from.
+func (fe *FeeEstimator) Estimate(priority FeePriority) (feeRate int64, ok bool) {
+	if len(fe.blockRates) == 0 {
+		return
+	}
+
+	var rates []int64
+	for _, rate := range fe.blockRates {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i] < rates[j] })
+
+	feeRate = rates[(len(rates)-1)*priority.percentile()/100]
+	if feeRate < MinFeeRate {
+		feeRate = MinFeeRate
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,68 @@
+package match
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/wire"
+)
+
+// TestBlockFeeRate makes a block whose coinbase claims the subsidy plus some fees, and checks the
+// fee rate comes out as the fees over the size of the other transactions
+func TestBlockFeeRate(t *testing.T) {
+	spend := wire.NewMsgTx()
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{0x01}, 0), nil, nil))
+	spend.AddTxOut(wire.NewTxOut(1000, make([]byte, 22)))
+	vsize := TxVsize(spend)
+
+	// 150 blocks per halving, so height 300 has a quarter of the subsidy
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0xffffffff), nil, nil))
+	coinbase.AddTxOut(wire.NewTxOut(initialSubsidy/4+vsize*7, nil))
+
+	if rate := BlockFeeRate([]*wire.MsgTx{coinbase, spend}, 300, 150); rate != 7 {
+		t.Errorf("Block fee rate should be 7, got %d", rate)
+	}
+	if rate := BlockFeeRate([]*wire.MsgTx{coinbase}, 300, 150); rate != 0 {
+		t.Errorf("A block with only a coinbase should have a fee rate of 0, got %d", rate)
+	}
+	// at height 0 the coinbase claims less than the subsidy
+	if rate := BlockFeeRate([]*wire.MsgTx{coinbase, spend}, 0, 150); rate != 0 {
+		t.Errorf("A coinbase under the subsidy should have a fee rate of 0, got %d", rate)
+	}
+}
+
+// TestFeeEstimator checks that each priority gets its percentile of recent blocks, that old blocks
+// are forgotten, and that disconnected blocks stop counting
+func TestFeeEstimator(t *testing.T) {
+	fe := NewFeeEstimator()
+	if _, ok := fe.Estimate(FeePriorityNormal); ok {
+		t.Errorf("An estimator without any blocks shouldn't estimate anything")
+	}
+
+	// an old block with a huge fee rate that should fall out of the window
+	fe.AddBlock(1, 1000)
+	for height := uint64(2); height <= FeeEstimateWindow+1; height++ {
+		fe.AddBlock(height, int64(height))
+	}
+	if fe.Blocks() != FeeEstimateWindow {
+		t.Errorf("Estimator should have %d blocks, has %d", FeeEstimateWindow, fe.Blocks())
+	}
+
+	// rates are 2 through 25
+	for priority, expected := range map[FeePriority]int64{FeePriorityLow: 7, FeePriorityNormal: 13, FeePriorityHigh: 22} {
+		if rate, _ := fe.Estimate(priority); rate != expected {
+			t.Errorf("%s priority should be %d, got %d", priority, expected, rate)
+		}
+	}
+
+	fe.DisconnectBlocks(3)
+	if fe.Blocks() != 2 {
+		t.Errorf("After disconnecting everything above 3 the estimator should have 2 blocks, has %d", fe.Blocks())
+	}
+
+	fe.AddBlock(4, 0)
+	if rate, _ := fe.Estimate(FeePriorityLow); rate != MinFeeRate {
+		t.Errorf("Estimates shouldn't go under %d, got %d", MinFeeRate, rate)
+	}
+}
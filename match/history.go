@@ -0,0 +1,250 @@
+package match
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// OrderStatus is where a limit order is in its life. An order starts out open, and ends up filled,
+// cancelled, or expired.
+type OrderStatus string
+
+const (
+	// OrderOpen is an order on the book that hasn't been filled at all
+	OrderOpen OrderStatus = "open"
+	// OrderPartiallyFilled is an order on the book that has been partially filled
+	OrderPartiallyFilled OrderStatus = "partiallyfilled"
+	// OrderFilled is an order that was filled completely
+	OrderFilled OrderStatus = "filled"
+	// OrderCancelled is an order that was cancelled before anything was filled
+	OrderCancelled OrderStatus = "cancelled"
+	// OrderPartiallyCancelled is an order that was partially filled and then cancelled
+	OrderPartiallyCancelled OrderStatus = "partiallycancelled"
+	// OrderExpired is an order that was taken off the book because it expired
+	OrderExpired OrderStatus = "expired"
+)
+
+// Final returns true if the order is no longer on the book
+func (s OrderStatus) Final() bool {
+	return s != OrderOpen && s != OrderPartiallyFilled
+}
+
+// OrderStatusFromString returns the status for a string, or an error if it isn't a status
+func OrderStatusFromString(str string) (status OrderStatus, err error) {
+	switch OrderStatus(str) {
+	case OrderOpen, OrderPartiallyFilled, OrderFilled, OrderCancelled, OrderPartiallyCancelled, OrderExpired:
+		status = OrderStatus(str)
+	default:
+		err = fmt.Errorf("Unknown order status %s", str)
+	}
+	return
+}
+
+// OrderHistoryEntry is a limit order and what happened to it. The order has the amounts it was
+// placed with, and the filled amounts are how much has been traded so far.
+type OrderHistoryEntry struct {
+	// Seq is the position of the entry in the history, it's what cursors point to
+	Seq     uint64      `json:"seq"`
+	OrderID OrderID     `json:"orderid"`
+	Order   *LimitOrder `json:"order"`
+	Price   float64     `json:"price"`
+	// AmountHaveFilled is how much of AmountHave has been given up in fills
+	AmountHaveFilled uint64 `json:"amounthavefilled"`
+	// AmountWantFilled is how much of AmountWant has been received in fills
+	AmountWantFilled uint64      `json:"amountwantfilled"`
+	Status           OrderStatus `json:"status"`
+	Placed           time.Time   `json:"placed"`
+	Updated          time.Time   `json:"updated"`
+}
+
+// FillEntry is a single execution of a limit order
+type FillEntry struct {
+	// Seq is the position of the fill in the history, it's what cursors point to
+	Seq         uint64   `json:"seq"`
+	OrderID     OrderID  `json:"orderid"`
+	Pubkey      [33]byte `json:"pubkey"`
+	TradingPair Pair     `json:"pair"`
+	Side        Side     `json:"side"`
+	// AmountHave is how much of the order's AmountHave was given up in this fill
+	AmountHave uint64 `json:"amounthave"`
+	// AmountWant is how much of the order's AmountWant was received in this fill
+	AmountWant uint64    `json:"amountwant"`
+	Time       time.Time `json:"time"`
+}
+
+// HistoryQuery filters and pages through order or fill history. Entries are returned newest
+// first. The zero value returns the newest DefaultHistoryLimit entries for every pair.
+type HistoryQuery struct {
+	// Pair only returns entries for this pair, nil means every pair
+	Pair *Pair `json:"pair,omitempty"`
+	// Start and End only return entries from this time range, the zero time means no bound. For
+	// orders this is when the order was placed, for fills it's when the fill happened.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Statuses only returns orders with one of these statuses, empty means any status. It's
+	// ignored for fills.
+	Statuses []OrderStatus `json:"statuses,omitempty"`
+	// Cursor only returns entries older than the entry with this seq, 0 starts from the newest
+	Cursor uint64 `json:"cursor"`
+	// Limit is the most entries to return, 0 means DefaultHistoryLimit, and it's capped at
+	// MaxHistoryLimit
+	Limit uint64 `json:"limit"`
+}
+
+const (
+	// DefaultHistoryLimit is how many entries a history query returns if it doesn't set a limit
+	DefaultHistoryLimit = uint64(50)
+	// MaxHistoryLimit is the most entries a history query can return
+	MaxHistoryLimit = uint64(500)
+)
+
+// PageLimit returns how many entries should be returned for the query
+func (q *HistoryQuery) PageLimit() uint64 {
+	if q.Limit == 0 {
+		return DefaultHistoryLimit
+	}
+	if q.Limit > MaxHistoryLimit {
+		return MaxHistoryLimit
+	}
+	return q.Limit
+}
+
+// Serialize serializes the query so it can be signed. Times are written as unix nanoseconds, so
+// the same query serializes the same way no matter what location its times are in.
+func (q *HistoryQuery) Serialize() (buf []byte, err error) {
+	intermediate := new(bytes.Buffer)
+	if q.Pair != nil {
+		intermediate.WriteByte(0x01)
+		intermediate.Write(q.Pair.Serialize())
+	} else {
+		intermediate.WriteByte(0x00)
+	}
+
+	var start, end int64
+	if !q.Start.IsZero() {
+		start = q.Start.UnixNano()
+	}
+	if !q.End.IsZero() {
+		end = q.End.UnixNano()
+	}
+	for _, field := range []interface{}{start, end, q.Cursor, q.Limit, uint32(len(q.Statuses))} {
+		if err = binary.Write(intermediate, binary.LittleEndian, field); err != nil {
+			err = fmt.Errorf("Error writing history query to binary for serialize: %s", err)
+			return
+		}
+	}
+	for _, status := range q.Statuses {
+		intermediate.WriteByte(byte(len(status)))
+		intermediate.WriteString(string(status))
+	}
+	buf = intermediate.Bytes()
+	return
+}
+
+// MatchesPair returns true if the query includes entries for the pair
+func (q *HistoryQuery) MatchesPair(pair *Pair) bool {
+	return q.Pair == nil || *q.Pair == *pair
+}
+
+// MatchesTime returns true if the time is in the query's time range
+func (q *HistoryQuery) MatchesTime(t time.Time) bool {
+	if !q.Start.IsZero() && t.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && t.After(q.End) {
+		return false
+	}
+	return true
+}
+
+// MatchesStatus returns true if the query includes orders with the status
+func (q *HistoryQuery) MatchesStatus(status OrderStatus) bool {
+	if len(q.Statuses) == 0 {
+		return true
+	}
+	for _, queryStatus := range q.Statuses {
+		if queryStatus == status {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesCursor returns true if the entry with this seq comes after the cursor
+func (q *HistoryQuery) MatchesCursor(seq uint64) bool {
+	return q.Cursor == 0 || seq < q.Cursor
+}
+
+// MatchesOrder returns true if the query includes the order
+func (q *HistoryQuery) MatchesOrder(entry *OrderHistoryEntry) bool {
+	return q.MatchesCursor(entry.Seq) && q.MatchesPair(&entry.Order.TradingPair) && q.MatchesTime(entry.Placed) && q.MatchesStatus(entry.Status)
+}
+
+// MatchesFill returns true if the query includes the fill
+func (q *HistoryQuery) MatchesFill(entry *FillEntry) bool {
+	return q.MatchesCursor(entry.Seq) && q.MatchesPair(&entry.TradingPair) && q.MatchesTime(entry.Time)
+}
+
+// ApplyExec updates an order history entry with an execution, and returns the fill for it.
+// Executions have the amounts left on the order, so the fill is the difference between the
+// amounts left before and after.
+func (entry *OrderHistoryEntry) ApplyExec(orderExec *OrderExecution, execTime time.Time) (fill *FillEntry) {
+	remainingHave := entry.Order.AmountHave - entry.AmountHaveFilled
+	remainingWant := entry.Order.AmountWant - entry.AmountWantFilled
+	fill = &FillEntry{
+		OrderID:     entry.OrderID,
+		Pubkey:      entry.Order.Pubkey,
+		TradingPair: entry.Order.TradingPair,
+		Side:        entry.Order.Side,
+		Time:        execTime,
+	}
+	if orderExec.Filled {
+		fill.AmountHave = remainingHave
+		fill.AmountWant = remainingWant
+	} else {
+		if orderExec.NewAmountHave < remainingHave {
+			fill.AmountHave = remainingHave - orderExec.NewAmountHave
+		}
+		if orderExec.NewAmountWant < remainingWant {
+			fill.AmountWant = remainingWant - orderExec.NewAmountWant
+		}
+	}
+
+	entry.AmountHaveFilled += fill.AmountHave
+	entry.AmountWantFilled += fill.AmountWant
+	entry.Updated = execTime
+	if orderExec.Filled {
+		entry.Status = OrderFilled
+	} else if entry.AmountHaveFilled != 0 {
+		entry.Status = OrderPartiallyFilled
+	}
+	return
+}
+
+// ApplyCancel marks an order history entry as cancelled, or partially cancelled if some of it was
+// filled.
+func (entry *OrderHistoryEntry) ApplyCancel(cancelTime time.Time) {
+	if entry.AmountHaveFilled != 0 {
+		entry.Status = OrderPartiallyCancelled
+	} else {
+		entry.Status = OrderCancelled
+	}
+	entry.Updated = cancelTime
+	return
+}
+
+// NewOrderHistoryEntry creates an open order history entry for an order that was just placed
+func NewOrderHistoryEntry(idPair *LimitOrderIDPair) (entry *OrderHistoryEntry) {
+	entry = &OrderHistoryEntry{
+		OrderID: *idPair.OrderID,
+		Order:   new(LimitOrder),
+		Price:   idPair.Price,
+		Status:  OrderOpen,
+		Placed:  idPair.Timestamp,
+		Updated: idPair.Timestamp,
+	}
+	*entry.Order = *idPair.Order
+	return
+}
@@ -0,0 +1,65 @@
+package match
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestHistoryQuerySerializeLocation tests that a query serializes the same no matter what
+// location its times are in, since that changes when it's sent over RPC
+func TestHistoryQuerySerializeLocation(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	utcQuery := &HistoryQuery{Start: start.UTC(), Statuses: []OrderStatus{OrderFilled}}
+	localQuery := &HistoryQuery{Start: start.In(time.FixedZone("test", 3600)), Statuses: []OrderStatus{OrderFilled}}
+
+	utcBytes, err := utcQuery.Serialize()
+	if err != nil {
+		t.Errorf("Error serializing query: %s", err)
+		return
+	}
+	localBytes, err := localQuery.Serialize()
+	if err != nil {
+		t.Errorf("Error serializing query: %s", err)
+		return
+	}
+	if !bytes.Equal(utcBytes, localBytes) {
+		t.Errorf("Same query in different locations should serialize the same")
+		return
+	}
+
+	// a different status should change the serialization, or the signature wouldn't cover it
+	localQuery.Statuses = []OrderStatus{OrderCancelled}
+	if localBytes, err = localQuery.Serialize(); err != nil {
+		t.Errorf("Error serializing query: %s", err)
+		return
+	}
+	if bytes.Equal(utcBytes, localBytes) {
+		t.Errorf("Queries with different statuses should not serialize the same")
+		return
+	}
+}
+
+// TestHistoryEntryApplyExec tests that fills are the difference between executions
+func TestHistoryEntryApplyExec(t *testing.T) {
+	entry := NewOrderHistoryEntry(&LimitOrderIDPair{
+		OrderID: &OrderID{0x01},
+		Order:   &LimitOrder{AmountHave: 1000, AmountWant: 500},
+	})
+
+	fill := entry.ApplyExec(&OrderExecution{NewAmountHave: 600, NewAmountWant: 300}, time.Unix(1, 0))
+	if fill.AmountHave != 400 || fill.AmountWant != 200 || entry.Status != OrderPartiallyFilled {
+		t.Errorf("First fill should be 400/200 and partially fill the order, got %d/%d and %s", fill.AmountHave, fill.AmountWant, entry.Status)
+		return
+	}
+
+	fill = entry.ApplyExec(&OrderExecution{Filled: true}, time.Unix(2, 0))
+	if fill.AmountHave != 600 || fill.AmountWant != 300 || entry.Status != OrderFilled {
+		t.Errorf("Second fill should be the remaining 600/300 and fill the order, got %d/%d and %s", fill.AmountHave, fill.AmountWant, entry.Status)
+		return
+	}
+	if entry.AmountHaveFilled != 1000 || entry.AmountWantFilled != 500 {
+		t.Errorf("Filled amounts should be the whole order, got %d/%d", entry.AmountHaveFilled, entry.AmountWantFilled)
+		return
+	}
+}
@@ -0,0 +1,189 @@
+package match
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/wire"
+)
+
+// chainHTLC is an on-chain HTLC. It pays claimKey with the preimage of rhash, or refundKey once
+// locktime has passed. Submarine swaps and atomic swaps both lock coins in them.
+type chainHTLC struct {
+	// name is what the HTLC is for, for errors
+	name      string
+	rhash     [32]byte
+	claimKey  [33]byte
+	refundKey [33]byte
+	// locktime is a height or a unix time, like any other locktime
+	locktime uint32
+	// outpoint is txid:index once the HTLC has been funded with amount
+	outpoint string
+	amount   uint64
+}
+
+// script returns the HTLC's witness script
+func (h *chainHTLC) script() (script []byte, err error) {
+	builder := txscript.NewScriptBuilder()
+	builder.AddOp(txscript.OP_SIZE)
+	builder.AddInt64(16)
+	builder.AddOp(txscript.OP_EQUAL)
+	builder.AddOp(txscript.OP_IF)
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(h.rhash[:])
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddData(h.claimKey[:])
+	builder.AddOp(txscript.OP_ELSE)
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddInt64(int64(h.locktime))
+	builder.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(h.refundKey[:])
+	builder.AddOp(txscript.OP_ENDIF)
+	builder.AddOp(txscript.OP_CHECKSIG)
+	if script, err = builder.Script(); err != nil {
+		err = fmt.Errorf("Error building script for %s: %s", h.name, err)
+		return
+	}
+	return
+}
+
+// pkScript returns the P2WSH script the HTLC pays to
+func (h *chainHTLC) pkScript() (pkScript []byte, err error) {
+	var script []byte
+	if script, err = h.script(); err != nil {
+		return
+	}
+	pkScript = lnutil.P2WSHify(script)
+	return
+}
+
+// fundingOutput returns the index of the output of tx that funds the HTLC, found is false if
+// there isn't one
+func (h *chainHTLC) fundingOutput(tx *wire.MsgTx) (index uint32, found bool, err error) {
+	var pkScript []byte
+	if pkScript, err = h.pkScript(); err != nil {
+		return
+	}
+	for i, output := range tx.TxOut {
+		if string(output.PkScript) == string(pkScript) {
+			return uint32(i), true, nil
+		}
+	}
+	return
+}
+
+// spendingInput returns the index of the input of tx that spends the HTLC, found is false if
+// there isn't one or the HTLC hasn't been funded
+func (h *chainHTLC) spendingInput(tx *wire.MsgTx) (index int, found bool) {
+	if h.outpoint == "" {
+		return
+	}
+	for i, input := range tx.TxIn {
+		if fmt.Sprintf("%s:%d", input.PreviousOutPoint.Hash.String(), input.PreviousOutPoint.Index) == h.outpoint {
+			return i, true
+		}
+	}
+	return
+}
+
+// preimageFromWitness returns the preimage in the witness of an input that spends the HTLC, ok
+// is false if it was refunded rather than claimed
+func (h *chainHTLC) preimageFromWitness(witness wire.TxWitness) (preimage [16]byte, ok bool) {
+	if len(witness) != 3 || len(witness[1]) != len(preimage) {
+		return
+	}
+	if sha256.Sum256(witness[1]) != h.rhash {
+		return
+	}
+	copy(preimage[:], witness[1])
+	return preimage, true
+}
+
+// spendTx creates a transaction spending the HTLC to outScript, paying fee. It claims the HTLC
+// with the preimage, or refunds it if preimage is nil. The refund can't be mined until locktime.
+func (h *chainHTLC) spendTx(outScript []byte, fee uint64, priv *koblitz.PrivateKey, preimage *[16]byte) (tx *wire.MsgTx, err error) {
+	if h.outpoint == "" {
+		err = fmt.Errorf("%s hasn't been funded, there's nothing to spend", h.name)
+		return
+	}
+	if h.amount <= fee {
+		err = fmt.Errorf("%s only has %d on chain, which doesn't cover the %d fee", h.name, h.amount, fee)
+		return
+	}
+	var outpoint *wire.OutPoint
+	if outpoint, err = parseOutPoint(h.outpoint); err != nil {
+		return
+	}
+	var script []byte
+	if script, err = h.script(); err != nil {
+		return
+	}
+
+	tx = wire.NewMsgTx()
+	tx.Version = 2
+	tx.AddTxIn(wire.NewTxIn(outpoint, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(h.amount-fee), outScript))
+
+	// the refund path checks the locktime, which is only enforced if the input isn't final
+	branch := []byte{}
+	if preimage == nil {
+		tx.LockTime = h.locktime
+		tx.TxIn[0].Sequence = wire.MaxTxInSequenceNum - 1
+	} else {
+		branch = preimage[:]
+	}
+
+	var sig []byte
+	if sig, err = txscript.RawTxInWitnessSignature(tx, txscript.NewTxSigHashes(tx), 0, int64(h.amount), script, txscript.SigHashAll, priv); err != nil {
+		err = fmt.Errorf("Error signing spend of %s: %s", h.name, err)
+		return
+	}
+	tx.TxIn[0].Witness = wire.TxWitness{sig, branch, script}
+	return
+}
+
+// verifySpend checks that input index of tx spends the HTLC properly
+func (h *chainHTLC) verifySpend(tx *wire.MsgTx, index int) (err error) {
+	var pkScript []byte
+	if pkScript, err = h.pkScript(); err != nil {
+		return
+	}
+	var engine *txscript.Engine
+	if engine, err = txscript.NewEngine(pkScript, tx, index, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx), int64(h.amount)); err != nil {
+		err = fmt.Errorf("Error creating script engine for %s: %s", h.name, err)
+		return
+	}
+	if err = engine.Execute(); err != nil {
+		err = fmt.Errorf("Spend of %s isn't valid: %s", h.name, err)
+		return
+	}
+	return
+}
+
+// parseOutPoint parses an outpoint that looks like txid:index
+func parseOutPoint(str string) (outpoint *wire.OutPoint, err error) {
+	parts := strings.Split(str, ":")
+	if len(parts) != 2 {
+		err = fmt.Errorf("Outpoint %s should look like txid:index", str)
+		return
+	}
+	var hash *chainhash.Hash
+	if hash, err = chainhash.NewHashFromStr(parts[0]); err != nil {
+		err = fmt.Errorf("Error parsing txid of outpoint %s: %s", str, err)
+		return
+	}
+	var index uint64
+	if index, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+		err = fmt.Errorf("Error parsing index of outpoint %s: %s", str, err)
+		return
+	}
+	outpoint = wire.NewOutPoint(hash, uint32(index))
+	return
+}
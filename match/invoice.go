@@ -0,0 +1,351 @@
+package match
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+)
+
+// InvoiceState is where a lightning deposit invoice is in its life. An invoice starts out open,
+// and ends up paid or expired.
+type InvoiceState string
+
+const (
+	// InvoiceOpen is an invoice waiting for HTLCs locked to its hash
+	InvoiceOpen InvoiceState = "open"
+	// InvoicePaid is an invoice whose HTLCs the exchange claimed, the user has been credited
+	InvoicePaid InvoiceState = "paid"
+	// InvoiceExpired is an invoice that wasn't paid in time, HTLCs locked to its hash aren't
+	// claimed so they go back to the payer when they time out
+	InvoiceExpired InvoiceState = "expired"
+)
+
+const (
+	// DefaultInvoiceExpiry is how long an invoice is open for if the user doesn't pick
+	DefaultInvoiceExpiry = time.Hour
+	// MaxInvoiceExpiry is the longest an invoice can be open for
+	MaxInvoiceExpiry = 24 * time.Hour
+	// LightningPaymentTimeout is how long the exchange waits for a payment to a payment request
+	// to be set up, the payee has to send the hash and the exchange has to offer its HTLC
+	LightningPaymentTimeout = 10 * time.Minute
+)
+
+// Final returns true if nothing else will happen to the invoice
+func (s InvoiceState) Final() bool {
+	return s == InvoicePaid || s == InvoiceExpired
+}
+
+// InvoiceStateFromString returns the state for a string, or an error if it isn't a state
+func InvoiceStateFromString(str string) (state InvoiceState, err error) {
+	switch InvoiceState(str) {
+	case InvoiceOpen, InvoicePaid, InvoiceExpired:
+		state = InvoiceState(str)
+	default:
+		err = fmt.Errorf("Unknown invoice state %s", str)
+	}
+	return
+}
+
+// InvoiceRequest is what a user signs to ask for a lightning deposit invoice. Expiry is a unix
+// time, a signed request can't be used once it's passed.
+type InvoiceRequest struct {
+	Asset  Asset
+	Amount uint64
+	Expiry int64
+}
+
+// Serialize serializes the request. This is what gets signed, so every field is fixed size.
+func (ir *InvoiceRequest) Serialize() (buf []byte) {
+	// Asset [1 byte]
+	// Amount [8 bytes]
+	// Expiry [8 bytes]
+
+	buf = append(buf, byte(ir.Asset))
+
+	amountBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amountBytes, ir.Amount)
+	buf = append(buf, amountBytes[:]...)
+
+	expiryBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(expiryBytes, uint64(ir.Expiry))
+	buf = append(buf, expiryBytes[:]...)
+	return
+}
+
+// SigHash returns the hash of the serialized request, which is what the user signs
+func (ir *InvoiceRequest) SigHash() (e []byte) {
+	sha3 := sha3.New256()
+	sha3.Write(ir.Serialize())
+	e = sha3.Sum(nil)
+	return
+}
+
+// RecoverPubkey returns the pubkey that made a compact signature over the request
+func (ir *InvoiceRequest) RecoverPubkey(signature []byte) (pubkey *koblitz.PublicKey, err error) {
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, ir.SigHash()); err != nil {
+		err = fmt.Errorf("Error recovering pubkey from invoice request signature: %s", err)
+		return
+	}
+	return
+}
+
+// Invoice is a lightning deposit that doesn't need a channel to the exchange. The exchange picks
+// a preimage and the payer locks HTLCs to its hash, on any of the exchange's channels, directly
+// or routed through other nodes. The exchange claims them and credits the user the invoice is
+// for, so the hash binds the payment to the user.
+type Invoice struct {
+	// RHash is what the payer's HTLCs are locked to, it identifies the invoice
+	RHash [32]byte `json:"rhash"`
+	// Preimage unlocks the HTLCs, only the exchange knows it until the invoice is paid
+	Preimage [16]byte `json:"preimage"`
+	// Pubkey is the user that's credited when the invoice is paid
+	Pubkey [33]byte `json:"pubkey"`
+	Asset  Asset    `json:"asset"`
+	Amount uint64   `json:"amount"`
+	// Node is the exchange's lit address, which the payment has to end up at
+	Node   string    `json:"node"`
+	Expiry time.Time `json:"expiry"`
+	// AmountPaid is what the HTLCs the exchange claimed added up to, it's what the user was
+	// credited and can be more than Amount
+	AmountPaid uint64       `json:"amountpaid"`
+	State      InvoiceState `json:"state"`
+	// Reason is why the invoice expired
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// NewInvoice creates an open invoice for a signed request, with a new random preimage. node is
+// the exchange's lit address.
+func NewInvoice(request *InvoiceRequest, pubkey [33]byte, node string, createTime time.Time) (invoice *Invoice, err error) {
+	if request.Amount == 0 {
+		err = fmt.Errorf("Can't create an invoice for nothing")
+		return
+	}
+	expiry := time.Unix(request.Expiry, 0)
+	if !expiry.After(createTime) {
+		err = fmt.Errorf("Invoice request expired at %s", expiry)
+		return
+	}
+	if expiry.Sub(createTime) > MaxInvoiceExpiry {
+		err = fmt.Errorf("Invoice can't be open for more than %s", MaxInvoiceExpiry)
+		return
+	}
+
+	invoice = &Invoice{
+		Pubkey:  pubkey,
+		Asset:   request.Asset,
+		Amount:  request.Amount,
+		Node:    node,
+		Expiry:  expiry,
+		State:   InvoiceOpen,
+		Created: createTime,
+		Updated: createTime,
+	}
+	if _, err = rand.Read(invoice.Preimage[:]); err != nil {
+		err = fmt.Errorf("Error reading random bytes into preimage for NewInvoice: %s", err)
+		invoice = nil
+		return
+	}
+	invoice.RHash = sha256.Sum256(invoice.Preimage[:])
+	return
+}
+
+// ID returns the hex hash of the invoice, which is how users refer to it
+func (i *Invoice) ID() string {
+	return hex.EncodeToString(i.RHash[:])
+}
+
+// String returns a short description of the invoice
+func (i *Invoice) String() string {
+	str := fmt.Sprintf("invoice %s: %d %s to %x until %s, %s", i.ID(), i.Amount, i.Asset, i.Pubkey, i.Expiry.Format(time.RFC3339), i.State)
+	if i.State == InvoicePaid {
+		str += fmt.Sprintf(" with %d", i.AmountPaid)
+	}
+	if i.Reason != "" {
+		str += fmt.Sprintf(" (%s)", i.Reason)
+	}
+	return str
+}
+
+// SetState moves the invoice to a new state at a time, with the reason it expired
+func (i *Invoice) SetState(state InvoiceState, reason string, updateTime time.Time) {
+	i.State = state
+	i.Reason = reason
+	i.Updated = updateTime
+	return
+}
+
+// Public returns a copy of the invoice that's safe to show the user. The preimage is left out
+// until the exchange has revealed it by claiming the payer's HTLCs.
+func (i *Invoice) Public() (public *Invoice) {
+	public = new(Invoice)
+	*public = *i
+	if i.State != InvoicePaid {
+		public.Preimage = [16]byte{}
+	}
+	return
+}
+
+// Expired returns true if an open invoice can't be paid any more at now
+func (i *Invoice) Expired(now time.Time) bool {
+	return !now.Before(i.Expiry)
+}
+
+// PaymentRequest returns what the payer needs to pay the invoice
+func (i *Invoice) PaymentRequest() (request *PaymentRequest) {
+	request = &PaymentRequest{
+		Node:   i.Node,
+		Asset:  i.Asset,
+		Amount: i.Amount,
+		RHash:  i.RHash,
+		Expiry: i.Expiry,
+	}
+	return
+}
+
+// CheckPaid returns what the HTLCs locked to the invoice's hash add up to, or an error if they
+// don't pay it at height, the height of the invoice asset's chain. Incoming HTLCs the exchange
+// hasn't claimed yet have to last more than a claim margin past height, ones it has claimed
+// count as long as they were claimed with the invoice's preimage.
+func (i *Invoice) CheckPaid(htlcs []SwapHTLC, height uint32, policy *SwapPolicy) (amountPaid uint64, err error) {
+	var coin *coinparam.Params
+	if coin, err = i.Asset.CoinParamFromAsset(); err != nil {
+		return
+	}
+	for _, htlc := range htlcs {
+		if !htlc.Incoming || htlc.CoinType != coin.HDCoinType {
+			continue
+		}
+		if htlc.Cleared {
+			if sha256.Sum256(htlc.Preimage[:]) == i.RHash {
+				amountPaid += htlc.Amount
+			}
+			continue
+		}
+		if htlc.Locktime <= height+policy.ClaimMargin {
+			err = fmt.Errorf("HTLC for invoice %s times out at %d, too soon to claim at %d", i.ID(), htlc.Locktime, height)
+			amountPaid = 0
+			return
+		}
+		amountPaid += htlc.Amount
+	}
+	if amountPaid < i.Amount {
+		err = fmt.Errorf("HTLCs for invoice %s only add up to %d, need %d", i.ID(), amountPaid, i.Amount)
+		amountPaid = 0
+		return
+	}
+	return
+}
+
+// PaymentRequest is what a payee gives a payer to be paid over lightning: the lit node to pay,
+// how much of what, and until when. Lit's own multihop payments have the payee pick the
+// preimage, so a request for a lit node has no hash. An invoice's request does, and the payer's
+// HTLCs have to be locked to it.
+type PaymentRequest struct {
+	Node   string
+	Asset  Asset
+	Amount uint64
+	// RHash is empty if the payee picks the preimage when it's paid
+	RHash [32]byte
+	// Expiry is zero if the request doesn't expire
+	Expiry time.Time
+}
+
+// String encodes the request as node:asset:amount:expiry:rhash. The expiry is a unix time, 0 if
+// the request doesn't expire, and the hash is left off if there isn't one.
+func (pr *PaymentRequest) String() string {
+	var expiry int64
+	if !pr.Expiry.IsZero() {
+		expiry = pr.Expiry.Unix()
+	}
+	str := fmt.Sprintf("%s:%s:%d:%d", pr.Node, pr.Asset, pr.Amount, expiry)
+	if pr.RHash != [32]byte{} {
+		str += ":" + hex.EncodeToString(pr.RHash[:])
+	}
+	return str
+}
+
+// Expired returns true if the request can't be paid any more at now
+func (pr *PaymentRequest) Expired(now time.Time) bool {
+	return !pr.Expiry.IsZero() && !now.Before(pr.Expiry)
+}
+
+// CheckPayment looks at the HTLCs the exchange offered to pay rhash at height, the height of the
+// chain they're on. The payment is paid once one of them has been claimed with the preimage, and
+// can be refunded once every one of them has been cleared without it or has timed out. It's
+// neither while there aren't any.
+func CheckPayment(htlcs []SwapHTLC, rhash [32]byte, height uint32) (paid bool, refundable bool) {
+	var offered int
+	refundable = true
+	for _, htlc := range htlcs {
+		if htlc.Incoming {
+			continue
+		}
+		offered++
+		if htlc.Cleared {
+			if sha256.Sum256(htlc.Preimage[:]) == rhash {
+				return true, false
+			}
+			continue
+		}
+		if htlc.Locktime > height {
+			refundable = false
+		}
+	}
+	refundable = refundable && offered != 0
+	return
+}
+
+// ParsePaymentRequest parses a request encoded by String
+func ParsePaymentRequest(str string) (request *PaymentRequest, err error) {
+	parts := strings.Split(str, ":")
+	if len(parts) != 4 && len(parts) != 5 {
+		err = fmt.Errorf("Payment request %s should look like node:asset:amount:expiry or node:asset:amount:expiry:rhash", str)
+		return
+	}
+	if parts[0] == "" {
+		err = fmt.Errorf("Payment request %s doesn't have a node", str)
+		return
+	}
+
+	request = &PaymentRequest{Node: parts[0]}
+	if request.Asset, err = AssetFromString(parts[1]); err != nil {
+		err = fmt.Errorf("Error parsing asset of payment request %s: %s", str, err)
+		request = nil
+		return
+	}
+	if request.Amount, err = strconv.ParseUint(parts[2], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing amount of payment request %s: %s", str, err)
+		request = nil
+		return
+	}
+	var expiry int64
+	if expiry, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+		err = fmt.Errorf("Error parsing expiry of payment request %s: %s", str, err)
+		request = nil
+		return
+	}
+	if expiry != 0 {
+		request.Expiry = time.Unix(expiry, 0)
+	}
+	if len(parts) == 5 {
+		var rhashBytes []byte
+		if rhashBytes, err = hex.DecodeString(parts[4]); err != nil || len(rhashBytes) != len(request.RHash) {
+			err = fmt.Errorf("Hash of payment request %s should be %d bytes of hex", str, len(request.RHash))
+			request = nil
+			return
+		}
+		copy(request.RHash[:], rhashBytes)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package match
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+func testInvoice(t *testing.T) (invoice *Invoice) {
+	request := &InvoiceRequest{Asset: BTCTest, Amount: 1000, Expiry: 3600}
+	var err error
+	if invoice, err = NewInvoice(request, [33]byte{2}, "ln1exchange", time.Unix(1, 0)); err != nil {
+		t.Fatalf("Error creating invoice: %s", err)
+	}
+	return
+}
+
+// TestNewInvoice tests that an invoice is open until its expiry and keeps its preimage private
+func TestNewInvoice(t *testing.T) {
+	invoice := testInvoice(t)
+	if invoice.State != InvoiceOpen || invoice.Amount != 1000 || !invoice.Expiry.Equal(time.Unix(3600, 0)) {
+		t.Errorf("Invoice should be open for 1000 until 3600, got %s", invoice)
+	}
+	if sha256.Sum256(invoice.Preimage[:]) != invoice.RHash {
+		t.Errorf("RHash should be the hash of the preimage")
+	}
+	if invoice.Public().Preimage != [16]byte{} {
+		t.Errorf("Preimage shouldn't be public before the invoice is paid")
+	}
+	invoice.SetState(InvoicePaid, "", time.Unix(2, 0))
+	if invoice.Public().Preimage != invoice.Preimage {
+		t.Errorf("Preimage should be public once the invoice is paid")
+	}
+	if invoice.Expired(time.Unix(3599, 0)) || !invoice.Expired(time.Unix(3600, 0)) {
+		t.Errorf("Invoice should expire at 3600")
+	}
+
+	for _, request := range []*InvoiceRequest{
+		{Asset: BTCTest, Amount: 0, Expiry: 3600},
+		{Asset: BTCTest, Amount: 1000, Expiry: 1},
+		{Asset: BTCTest, Amount: 1000, Expiry: 1 + int64(MaxInvoiceExpiry/time.Second) + 1},
+	} {
+		if _, err := NewInvoice(request, [33]byte{2}, "ln1exchange", time.Unix(1, 0)); err == nil {
+			t.Errorf("Request for %d until %d should not make an invoice", request.Amount, request.Expiry)
+		}
+	}
+	if state, err := InvoiceStateFromString("expired"); err != nil || state != InvoiceExpired {
+		t.Errorf("Should parse expired, got %s and %v", state, err)
+	}
+}
+
+// TestInvoiceRequestSignature tests that the signer of an invoice request can be recovered
+func TestInvoiceRequestSignature(t *testing.T) {
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{1})
+	request := &InvoiceRequest{Asset: BTCTest, Amount: 1000, Expiry: 3600}
+	sig, err := koblitz.SignCompact(koblitz.S256(), priv, request.SigHash(), false)
+	if err != nil {
+		t.Fatalf("Error signing request: %s", err)
+	}
+	pubkey, err := request.RecoverPubkey(sig)
+	if err != nil || !pubkey.IsEqual(priv.PubKey()) {
+		t.Errorf("Should recover the signer, got %v", err)
+	}
+	request.Amount = 2000
+	if pubkey, err = request.RecoverPubkey(sig); err == nil && pubkey.IsEqual(priv.PubKey()) {
+		t.Errorf("Changed request should not recover the signer")
+	}
+}
+
+// TestInvoiceCheckPaid tests when HTLCs locked to an invoice's hash pay it
+func TestInvoiceCheckPaid(t *testing.T) {
+	invoice := testInvoice(t)
+	coin, _ := BTCTest.CoinParamFromAsset()
+	claimed := SwapHTLC{Incoming: true, Amount: 400, Locktime: 100, Cleared: true, Preimage: invoice.Preimage, CoinType: coin.HDCoinType}
+
+	for _, tc := range []struct {
+		name  string
+		htlcs []SwapHTLC
+		paid  uint64
+	}{
+		{"no htlcs", []SwapHTLC{{Amount: 1000, Locktime: 300, CoinType: coin.HDCoinType}}, 0},
+		{"too little", []SwapHTLC{{Incoming: true, Amount: 999, Locktime: 300, CoinType: coin.HDCoinType}}, 0},
+		{"split", []SwapHTLC{claimed, {Incoming: true, Amount: 700, Locktime: 300, CoinType: coin.HDCoinType}}, 1100},
+		{"other coin", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 300, CoinType: coin.HDCoinType + 1}}, 0},
+		{"timed out", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 100, Cleared: true, CoinType: coin.HDCoinType}}, 0},
+		{"too short", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 206, CoinType: coin.HDCoinType}}, 0},
+	} {
+		paid, err := invoice.CheckPaid(tc.htlcs, 200, testEscrowPolicy)
+		if paid != tc.paid || (err == nil) != (tc.paid != 0) {
+			t.Errorf("Paid for %s should be %d, got %d and %v", tc.name, tc.paid, paid, err)
+		}
+	}
+}
+
+// TestPaymentRequest tests that payment requests survive being encoded and parsed
+func TestPaymentRequest(t *testing.T) {
+	invoice := testInvoice(t)
+	for _, request := range []*PaymentRequest{
+		invoice.PaymentRequest(),
+		{Node: "ln1user", Asset: BTCTest, Amount: 5000},
+	} {
+		parsed, err := ParsePaymentRequest(request.String())
+		if err != nil {
+			t.Fatalf("Error parsing %s: %s", request, err)
+		}
+		if parsed.Node != request.Node || parsed.Asset != request.Asset || parsed.Amount != request.Amount || parsed.RHash != request.RHash || !parsed.Expiry.Equal(request.Expiry) {
+			t.Errorf("Parsed %s should be the same request, got %s", request, parsed)
+		}
+	}
+	if !invoice.PaymentRequest().Expired(time.Unix(3600, 0)) || (&PaymentRequest{}).Expired(time.Unix(3600, 0)) {
+		t.Errorf("Only requests with an expiry should expire")
+	}
+
+	for _, str := range []string{"ln1user:testnet3:5000", ":testnet3:5000:0", "ln1user:testnet3:lots:0", "ln1user:testnet3:5000:0:abcd"} {
+		if _, err := ParsePaymentRequest(str); err == nil {
+			t.Errorf("Should not parse %s", str)
+		}
+	}
+}
+
+// TestCheckPayment tests when the HTLCs the exchange offered for a payment are paid or can be
+// refunded
+func TestCheckPayment(t *testing.T) {
+	preimage := [16]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	for _, tc := range []struct {
+		name       string
+		htlcs      []SwapHTLC
+		paid       bool
+		refundable bool
+	}{
+		{"no htlcs", []SwapHTLC{{Incoming: true, Amount: 1000, Locktime: 100}}, false, false},
+		{"waiting", []SwapHTLC{{Amount: 1000, Locktime: 201}}, false, false},
+		{"claimed", []SwapHTLC{{Amount: 1000, Locktime: 100, Cleared: true, Preimage: preimage}}, true, false},
+		{"timed out", []SwapHTLC{{Amount: 1000, Locktime: 200}}, false, true},
+		{"taken back", []SwapHTLC{{Amount: 1000, Locktime: 150, Cleared: true}, {Amount: 1000, Locktime: 201}}, false, false},
+		{"all taken back", []SwapHTLC{{Amount: 1000, Locktime: 150, Cleared: true}, {Amount: 1000, Locktime: 180}}, false, true},
+	} {
+		if paid, refundable := CheckPayment(tc.htlcs, rhash, 200); paid != tc.paid || refundable != tc.refundable {
+			t.Errorf("Payment for %s should be paid %t and refundable %t, got %t and %t", tc.name, tc.paid, tc.refundable, paid, refundable)
+		}
+	}
+}
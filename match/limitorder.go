@@ -37,10 +37,7 @@ func (l *LimitOrder) Serialize() (buf []byte, err error) {
 		err = fmt.Errorf("Error writing limit order to binary for serialize: %s", err)
 		return
 	}
-	if _, err = intermediate.Read(buf); err != nil {
-		err = fmt.Errorf("Error reading from intermediate into buffer for serialize: %s", err)
-		return
-	}
+	buf = intermediate.Bytes()
 	return
 }
 
@@ -0,0 +1,34 @@
+package match
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestLimitOrderSerialize makes sure the serialized order actually has the order in it, since
+// order IDs are hashes of it
+func TestLimitOrderSerialize(t *testing.T) {
+	order := &LimitOrder{
+		Pubkey:     [33]byte{0x02, 0x01},
+		Side:       Buy,
+		AmountHave: 1000,
+		AmountWant: 2000,
+	}
+
+	first, err := order.Serialize()
+	if err != nil {
+		t.Fatalf("Error serializing order: %s", err)
+	}
+	if len(first) == 0 {
+		t.Fatalf("Serialized order should not be empty")
+	}
+
+	order.AmountHave = 1001
+	var second []byte
+	if second, err = order.Serialize(); err != nil {
+		t.Fatalf("Error serializing changed order: %s", err)
+	}
+	if bytes.Equal(first, second) {
+		t.Errorf("Orders with different amounts should serialize differently")
+	}
+}
@@ -0,0 +1,278 @@
+package match
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// DefaultMinChannel is the smallest channel the exchange opens to add capacity, in base units
+	DefaultMinChannel = 1000000
+	// DefaultMaxChannel is the largest channel the exchange opens to add capacity, in base units
+	DefaultMaxChannel = 100000000
+	// DefaultFlowHeadroom is how much of a user's recent flow, in percent, the exchange keeps
+	// spare capacity for on top of what's reserved
+	DefaultFlowHeadroom = 100
+	// DefaultFlowDecay is how much of a user's recent flow, in percent, is forgotten every block
+	DefaultFlowDecay = 1
+)
+
+// Liquidity is how much can be sent over the channels between the exchange and a user for an
+// asset, and how much of it is reserved. Outbound is what the exchange can send the user,
+// inbound is what the user can send the exchange.
+type Liquidity struct {
+	Pubkey   [33]byte `json:"pubkey"`
+	Asset    Asset    `json:"asset"`
+	Channels int      `json:"channels"`
+	Outbound uint64   `json:"outbound"`
+	Inbound  uint64   `json:"inbound"`
+	// ReservedOutbound and ReservedInbound are held for resting swap orders and swaps that
+	// haven't been offered yet
+	ReservedOutbound uint64 `json:"reservedoutbound"`
+	ReservedInbound  uint64 `json:"reservedinbound"`
+}
+
+// String returns a short description of the liquidity
+func (l *Liquidity) String() string {
+	return fmt.Sprintf("%s over %d channels: %d out (%d reserved), %d in (%d reserved)", l.Asset, l.Channels, l.Outbound, l.ReservedOutbound, l.Inbound, l.ReservedInbound)
+}
+
+// AvailableOutbound is how much more the exchange can promise to send the user
+func (l *Liquidity) AvailableOutbound() uint64 {
+	if l.ReservedOutbound >= l.Outbound {
+		return 0
+	}
+	return l.Outbound - l.ReservedOutbound
+}
+
+// AvailableInbound is how much more the user can promise to send the exchange
+func (l *Liquidity) AvailableInbound() uint64 {
+	if l.ReservedInbound >= l.Inbound {
+		return 0
+	}
+	return l.Inbound - l.ReservedInbound
+}
+
+// CheckOutbound returns an error saying how much is missing if the exchange can't promise to
+// send amount to the user
+func (l *Liquidity) CheckOutbound(amount uint64) (err error) {
+	if available := l.AvailableOutbound(); available < amount {
+		err = fmt.Errorf("The exchange can only send %d more %s over your channels, %d short", available, l.Asset, amount-available)
+	}
+	return
+}
+
+// CheckInbound returns an error saying how much is missing if the user can't promise to send
+// amount to the exchange
+func (l *Liquidity) CheckInbound(amount uint64) (err error) {
+	if available := l.AvailableInbound(); available < amount {
+		err = fmt.Errorf("You can only send %d more %s over your channels, %d short", available, l.Asset, amount-available)
+	}
+	return
+}
+
+// LiquidityReservation is capacity held on a user's channels for an asset
+type LiquidityReservation struct {
+	Pubkey [33]byte
+	Asset  Asset
+	// Outbound is true if the exchange sends with the capacity, false if the user does
+	Outbound bool
+	Amount   uint64
+}
+
+// liquidityKey is who and what asset liquidity is for
+type liquidityKey struct {
+	pubkey [33]byte
+	asset  Asset
+}
+
+// LiquidityLedger keeps track of reservations, by the order or swap they're for, and how much
+// each user has recently needed the exchange to send them. It isn't safe for concurrent use.
+type LiquidityLedger struct {
+	reservations map[[32]byte][]LiquidityReservation
+	flows        map[liquidityKey]uint64
+}
+
+// NewLiquidityLedger creates an empty ledger
+func NewLiquidityLedger() (ledger *LiquidityLedger) {
+	ledger = &LiquidityLedger{
+		reservations: make(map[[32]byte][]LiquidityReservation),
+		flows:        make(map[liquidityKey]uint64),
+	}
+	return
+}
+
+// Reserve replaces the reservations for an order or swap. Reserving nothing releases them.
+func (ll *LiquidityLedger) Reserve(id [32]byte, reservations ...LiquidityReservation) {
+	if len(reservations) == 0 {
+		delete(ll.reservations, id)
+		return
+	}
+	ll.reservations[id] = append([]LiquidityReservation{}, reservations...)
+	return
+}
+
+// Release releases the reservations for an order or swap
+func (ll *LiquidityLedger) Release(id [32]byte) {
+	delete(ll.reservations, id)
+	return
+}
+
+// Reserved returns how much outbound and inbound capacity is reserved on a user's channels for
+// an asset
+func (ll *LiquidityLedger) Reserved(pubkey [33]byte, asset Asset) (outbound uint64, inbound uint64) {
+	for _, reservations := range ll.reservations {
+		for _, res := range reservations {
+			if res.Pubkey != pubkey || res.Asset != asset {
+				continue
+			}
+			if res.Outbound {
+				outbound += res.Amount
+			} else {
+				inbound += res.Amount
+			}
+		}
+	}
+	return
+}
+
+// RecordFlow adds to how much the exchange has recently needed to send a user
+func (ll *LiquidityLedger) RecordFlow(pubkey [33]byte, asset Asset, amount uint64) {
+	ll.flows[liquidityKey{pubkey: pubkey, asset: asset}] += amount
+	return
+}
+
+// Flow returns how much the exchange has recently needed to send a user
+func (ll *LiquidityLedger) Flow(pubkey [33]byte, asset Asset) (flow uint64) {
+	return ll.flows[liquidityKey{pubkey: pubkey, asset: asset}]
+}
+
+// DecayFlows forgets decay percent of every user's recent flow for an asset. It's called for
+// every block of the asset's coin.
+func (ll *LiquidityLedger) DecayFlows(asset Asset, decay uint64) {
+	for key, flow := range ll.flows {
+		if key.asset != asset {
+			continue
+		}
+		if flow = flow - flow*decay/100; flow == 0 {
+			delete(ll.flows, key)
+			continue
+		}
+		ll.flows[key] = flow
+	}
+	return
+}
+
+// Pubkeys returns every user with reservations or recent flow for an asset
+func (ll *LiquidityLedger) Pubkeys(asset Asset) (pubkeys [][33]byte) {
+	seen := make(map[[33]byte]bool)
+	add := func(pubkey [33]byte) {
+		if !seen[pubkey] {
+			seen[pubkey] = true
+			pubkeys = append(pubkeys, pubkey)
+		}
+	}
+	for _, reservations := range ll.reservations {
+		for _, res := range reservations {
+			if res.Asset == asset {
+				add(res.Pubkey)
+			}
+		}
+	}
+	for key := range ll.flows {
+		if key.asset == asset {
+			add(key.pubkey)
+		}
+	}
+	return
+}
+
+// LiquidityPolicy decides when the exchange opens channels to users so it can keep settling
+// their swaps
+type LiquidityPolicy struct {
+	// MinChannel and MaxChannel are the smallest and largest channels opened, in base units
+	MinChannel uint64
+	MaxChannel uint64
+	// FlowHeadroom is how much of a user's recent flow, in percent, to keep spare capacity for
+	FlowHeadroom uint64
+	// FlowDecay is how much of a user's recent flow, in percent, is forgotten every block
+	FlowDecay uint64
+}
+
+// DefaultLiquidityPolicy returns the policy used when one isn't set
+func DefaultLiquidityPolicy() (policy *LiquidityPolicy) {
+	policy = &LiquidityPolicy{
+		MinChannel:   DefaultMinChannel,
+		MaxChannel:   DefaultMaxChannel,
+		FlowHeadroom: DefaultFlowHeadroom,
+		FlowDecay:    DefaultFlowDecay,
+	}
+	return
+}
+
+// String returns a short description of the policy
+func (lp *LiquidityPolicy) String() string {
+	return fmt.Sprintf("channels of %d to %d, headroom %d%% of flow, flow decays %d%% a block", lp.MinChannel, lp.MaxChannel, lp.FlowHeadroom, lp.FlowDecay)
+}
+
+// ChannelToOpen returns how big a channel to open to a user, 0 if their outbound capacity
+// already covers what's reserved plus headroom for their recent flow
+func (lp *LiquidityPolicy) ChannelToOpen(liquidity *Liquidity, flow uint64) (capacity uint64) {
+	need := liquidity.ReservedOutbound + flow*lp.FlowHeadroom/100
+	if liquidity.Outbound >= need {
+		return
+	}
+	capacity = need - liquidity.Outbound
+	if capacity < lp.MinChannel {
+		capacity = lp.MinChannel
+	}
+	if lp.MaxChannel != 0 && capacity > lp.MaxChannel {
+		capacity = lp.MaxChannel
+	}
+	return
+}
+
+// SplitAcrossChannels decides how much of amount to send over each channel, given how much can
+// be sent over each. If one channel can take all of it, the smallest one that can is used so
+// larger channels are kept for larger payments. Otherwise the largest channels are used first,
+// so the payment is split as few ways as possible.
+func SplitAcrossChannels(available []uint64, amount uint64) (amounts []uint64, err error) {
+	amounts = make([]uint64, len(available))
+	if amount == 0 {
+		return
+	}
+
+	order := make([]int, len(available))
+	var total uint64
+	for i := range available {
+		order[i] = i
+		total += available[i]
+	}
+	if total < amount {
+		err = fmt.Errorf("Can only send %d over the channels, need %d", total, amount)
+		amounts = nil
+		return
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return available[order[i]] < available[order[j]]
+	})
+	for _, i := range order {
+		if available[i] >= amount {
+			amounts[i] = amount
+			return
+		}
+	}
+
+	remaining := amount
+	for j := len(order) - 1; j >= 0 && remaining > 0; j-- {
+		i := order[j]
+		send := available[i]
+		if send > remaining {
+			send = remaining
+		}
+		amounts[i] = send
+		remaining -= send
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package match
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSplitAcrossChannels tests that payments use the smallest channel that fits, or as few
+// channels as they can
+func TestSplitAcrossChannels(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		available []uint64
+		amount    uint64
+		amounts   []uint64
+	}{
+		{"best fit", []uint64{500, 100, 300}, 250, []uint64{0, 0, 250}},
+		{"exact", []uint64{500, 100, 300}, 100, []uint64{0, 100, 0}},
+		{"split largest first", []uint64{500, 100, 300}, 700, []uint64{500, 0, 200}},
+		{"everything", []uint64{500, 100, 300}, 900, []uint64{500, 100, 300}},
+		{"nothing", []uint64{500}, 0, []uint64{0}},
+	} {
+		amounts, err := SplitAcrossChannels(tc.available, tc.amount)
+		if err != nil {
+			t.Errorf("Error splitting for %s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(amounts, tc.amounts) {
+			t.Errorf("Split for %s should be %v, got %v", tc.name, tc.amounts, amounts)
+		}
+	}
+
+	if _, err := SplitAcrossChannels([]uint64{500, 100}, 601); err == nil {
+		t.Errorf("Splitting more than the channels can send should fail")
+	}
+}
+
+// TestLiquidityLedger tests reserving capacity and deciding when to open a channel
+func TestLiquidityLedger(t *testing.T) {
+	user := [33]byte{0x02, 0x01}
+	ledger := NewLiquidityLedger()
+	ledger.Reserve([32]byte{1}, LiquidityReservation{Pubkey: user, Asset: BTCTest, Outbound: true, Amount: 3000}, LiquidityReservation{Pubkey: user, Asset: LTCTest, Amount: 9000})
+	ledger.Reserve([32]byte{2}, LiquidityReservation{Pubkey: user, Asset: BTCTest, Outbound: true, Amount: 1000})
+
+	liquidity := &Liquidity{Pubkey: user, Asset: BTCTest, Outbound: 5000, Inbound: 2000}
+	liquidity.ReservedOutbound, liquidity.ReservedInbound = ledger.Reserved(user, BTCTest)
+	if liquidity.ReservedOutbound != 4000 || liquidity.ReservedInbound != 0 {
+		t.Errorf("Should have 4000 outbound and nothing inbound reserved, got %s", liquidity)
+	}
+	if liquidity.CheckOutbound(1000) != nil || liquidity.CheckOutbound(1001) == nil {
+		t.Errorf("Should be able to promise exactly 1000 more, got %s", liquidity)
+	}
+
+	policy := &LiquidityPolicy{MinChannel: 2000, MaxChannel: 10000, FlowHeadroom: 50}
+	if capacity := policy.ChannelToOpen(liquidity, 2000); capacity != 0 {
+		t.Errorf("Reserved plus headroom fits, shouldn't open a channel, got %d", capacity)
+	}
+	if capacity := policy.ChannelToOpen(liquidity, 4000); capacity != 2000 {
+		t.Errorf("Short by 1000, should open the minimum channel, got %d", capacity)
+	}
+	if capacity := policy.ChannelToOpen(liquidity, 40000); capacity != 10000 {
+		t.Errorf("Should open at most the maximum channel, got %d", capacity)
+	}
+
+	ledger.Release([32]byte{1})
+	if outbound, _ := ledger.Reserved(user, BTCTest); outbound != 1000 {
+		t.Errorf("Should have 1000 outbound reserved after release, got %d", outbound)
+	}
+
+	ledger.RecordFlow(user, BTCTest, 1000)
+	ledger.DecayFlows(BTCTest, 10)
+	ledger.DecayFlows(LTCTest, 50)
+	if flow := ledger.Flow(user, BTCTest); flow != 900 {
+		t.Errorf("Flow should decay to 900, got %d", flow)
+	}
+	if pubkeys := ledger.Pubkeys(LTCTest); len(pubkeys) != 0 {
+		t.Errorf("Nobody should have LTC liquidity tracked, got %d", len(pubkeys))
+	}
+}
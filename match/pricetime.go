@@ -54,19 +54,22 @@ func MatchPrioritizedOrders(buyOrders []*LimitOrderIDPair, sellOrders []*LimitOr
 		sellOrders[0].Order.AmountHave = prSellExec.NewAmountHave
 		sellOrders[0].Order.AmountWant = prSellExec.NewAmountWant
 
-		if len(buyOrders) == 0 || len(sellOrders) == 0 || buyOrders[0].Price > sellOrders[0].Price {
-			// If we will be done, make sure to add the result
-			orderExecs = append(orderExecs, []*OrderExecution{&prBuyExec, &prSellExec}...)
-		} else {
-			// If we're gonna keep going, then only add if we're filled.
-			if prSellExec.Filled {
-				sellOrders = sellOrders[1:]
-				orderExecs = append(orderExecs, &prSellExec)
-			}
-			if prBuyExec.Filled {
-				buyOrders = buyOrders[1:]
-				orderExecs = append(orderExecs, &prBuyExec)
-			}
+		// Filled orders are done, we have to take them off before checking if we'll keep going
+		if prSellExec.Filled {
+			sellOrders = sellOrders[1:]
+		}
+		if prBuyExec.Filled {
+			buyOrders = buyOrders[1:]
+		}
+
+		// If we're gonna keep going, then only add if we're filled. If we will be done, make
+		// sure to add the result for the partly filled order too.
+		done := len(buyOrders) == 0 || len(sellOrders) == 0 || buyOrders[0].Price > sellOrders[0].Price
+		if prSellExec.Filled || done {
+			orderExecs = append(orderExecs, &prSellExec)
+		}
+		if prBuyExec.Filled || done {
+			orderExecs = append(orderExecs, &prBuyExec)
 		}
 
 		// we keep all of the settlements no matter what because the rates may be
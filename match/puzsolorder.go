@@ -57,44 +57,40 @@ func (so *SolutionOrder) EncryptSolutionOrder(auctionOrder AuctionOrder, t uint6
 	return
 }
 
-// Serialize uses gob encoding to turn the solution order into bytes.
+// Serialize turns the solution order into bytes. Users sign the hash
+// of these bytes when responding to a commitment, so this is
+// [1 byte version] [8 byte len] p [8 byte len] q rather than gob, which
+// depends on what else the process has encoded.
 func (so *SolutionOrder) Serialize() (raw []byte, err error) {
-	var b bytes.Buffer
-
-	// register SolutionOrder interface
-	gob.Register(SolutionOrder{})
-
-	// create a new encoder writing to the buffer
-	enc := gob.NewEncoder(&b)
-
-	// encode the puzzle in the buffer
-	if err = enc.Encode(so); err != nil {
-		err = fmt.Errorf("Error encoding solutionorder: %s", err)
-		return
-	}
-
-	// Get the bytes from the buffer
-	raw = b.Bytes()
+	raw = append(raw, solutionEncodingVersion)
+	raw = appendBigInt(raw, so.P)
+	raw = appendBigInt(raw, so.Q)
 	return
 }
 
 // Deserialize turns the solution order from bytes into a usable
-// struct.
+// struct. Gob encoded ones from before there was a version byte can
+// still be read.
 func (so *SolutionOrder) Deserialize(raw []byte) (err error) {
-	var b *bytes.Buffer
-	b = bytes.NewBuffer(raw)
-
-	// register SolutionOrder
-	gob.Register(SolutionOrder{})
-
-	// create a new decoder writing to the buffer
-	dec := gob.NewDecoder(b)
-
-	// decode the solutionorder in the buffer
-	if err = dec.Decode(so); err != nil {
-		err = fmt.Errorf("Error decoding solutionorder: %s", err)
+	if len(raw) == 0 || raw[0] != solutionEncodingVersion {
+		if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(so); err != nil {
+			err = fmt.Errorf("Error decoding gob solutionorder: %s", err)
+			return
+		}
+		return
+	}
+	raw = raw[1:]
+	if raw, so.P, err = readBigInt(raw); err != nil {
+		err = fmt.Errorf("Error decoding solutionorder p: %s", err)
+		return
+	}
+	if raw, so.Q, err = readBigInt(raw); err != nil {
+		err = fmt.Errorf("Error decoding solutionorder q: %s", err)
+		return
+	}
+	if len(raw) != 0 {
+		err = fmt.Errorf("Error decoding solutionorder: %d extra bytes", len(raw))
 		return
 	}
-
 	return
 }
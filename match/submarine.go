@@ -0,0 +1,348 @@
+package match
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+	"golang.org/x/crypto/sha3"
+)
+
+// SubmarineDirection is which way a submarine swap moves coins, between a user's on-chain coins
+// and their lightning channels with the exchange
+type SubmarineDirection string
+
+const (
+	// SubmarineIn moves on-chain coins into a channel. The user locks coins in an on-chain HTLC,
+	// and the exchange pays them over lightning with HTLCs locked to the same hash.
+	SubmarineIn SubmarineDirection = "in"
+	// SubmarineOut moves coins out of a channel on chain. The user offers the exchange lightning
+	// HTLCs, and the exchange locks coins in an on-chain HTLC with the same hash.
+	SubmarineOut SubmarineDirection = "out"
+)
+
+// SubmarineDirectionFromString returns the direction for a string, or an error if it isn't one
+func SubmarineDirectionFromString(str string) (direction SubmarineDirection, err error) {
+	switch SubmarineDirection(str) {
+	case SubmarineIn, SubmarineOut:
+		direction = SubmarineDirection(str)
+	default:
+		err = fmt.Errorf("Unknown submarine swap direction %s, should be in or out", str)
+	}
+	return
+}
+
+// SubmarineState is where a submarine swap is in its life. A submarine swap starts out created,
+// and ends up completed, expired or refunded.
+type SubmarineState string
+
+const (
+	// SubmarineCreated is a submarine swap waiting for the user to lock their side
+	SubmarineCreated SubmarineState = "created"
+	// SubmarineFunded is a submarine swap whose user side is locked, on chain for swaps in and
+	// in lightning HTLCs for swaps out, waiting for the exchange to lock its side
+	SubmarineFunded SubmarineState = "funded"
+	// SubmarineOffered is a submarine swap whose exchange side is locked, waiting for the user to
+	// claim it
+	SubmarineOffered SubmarineState = "offered"
+	// SubmarineClaimed is a submarine swap where the user claimed the exchange's side, which
+	// revealed the preimage, and the exchange is claiming the user's side
+	SubmarineClaimed SubmarineState = "claimed"
+	// SubmarineCompleted is a submarine swap where the exchange claimed the user's side
+	SubmarineCompleted SubmarineState = "completed"
+	// SubmarineExpired is a submarine swap the exchange never locked its side for, because the
+	// user's side wasn't there in time
+	SubmarineExpired SubmarineState = "expired"
+	// SubmarineRefunded is a submarine swap whose exchange side timed out and was taken back
+	SubmarineRefunded SubmarineState = "refunded"
+)
+
+// SubmarineSpendSize is the most vbytes a transaction spending a submarine swap's on-chain HTLC
+// to one output takes. Fees for claims and refunds are worked out from it.
+const SubmarineSpendSize = 160
+
+// Final returns true if nothing else will happen to the submarine swap
+func (s SubmarineState) Final() bool {
+	return s == SubmarineCompleted || s == SubmarineExpired || s == SubmarineRefunded
+}
+
+// SubmarineStateFromString returns the state for a string, or an error if it isn't a state
+func SubmarineStateFromString(str string) (state SubmarineState, err error) {
+	switch SubmarineState(str) {
+	case SubmarineCreated, SubmarineFunded, SubmarineOffered, SubmarineClaimed, SubmarineCompleted, SubmarineExpired, SubmarineRefunded:
+		state = SubmarineState(str)
+	default:
+		err = fmt.Errorf("Unknown submarine swap state %s", str)
+	}
+	return
+}
+
+// SubmarineRequest is what a user signs to ask for a submarine swap. The user picks the preimage
+// and only sends its hash, which has to be new, so a signed request can't be used twice.
+type SubmarineRequest struct {
+	Direction SubmarineDirection
+	Asset     Asset
+	Amount    uint64
+	RHash     [32]byte
+}
+
+// Serialize serializes the request. This is what gets signed, so every field is fixed size or
+// length prefixed.
+func (sr *SubmarineRequest) Serialize() (buf []byte) {
+	// len(direction) [8 bytes]
+	// Direction [len(direction)]
+	// Asset [1 byte]
+	// Amount [8 bytes]
+	// RHash [32 bytes]
+
+	lenDirectionBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenDirectionBytes, uint64(len(sr.Direction)))
+	buf = append(buf, lenDirectionBytes[:]...)
+	buf = append(buf, []byte(sr.Direction)...)
+
+	buf = append(buf, byte(sr.Asset))
+
+	amountBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amountBytes, sr.Amount)
+	buf = append(buf, amountBytes[:]...)
+
+	buf = append(buf, sr.RHash[:]...)
+	return
+}
+
+// SigHash returns the hash of the serialized request, which is what the user signs
+func (sr *SubmarineRequest) SigHash() (e []byte) {
+	sha3 := sha3.New256()
+	sha3.Write(sr.Serialize())
+	e = sha3.Sum(nil)
+	return
+}
+
+// RecoverPubkey returns the pubkey that made a compact signature over the request
+func (sr *SubmarineRequest) RecoverPubkey(signature []byte) (pubkey *koblitz.PublicKey, err error) {
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, sr.SigHash()); err != nil {
+		err = fmt.Errorf("Error recovering pubkey from submarine swap request signature: %s", err)
+		return
+	}
+	return
+}
+
+// SubmarineSwap moves coins between a user's on-chain coins and their lightning channels with
+// the exchange, without a deposit or withdrawal. One side is an on-chain HTLC, the other is
+// lightning HTLCs, and both are locked to the same hash. The user claims the exchange's side
+// first, which reveals the preimage the exchange needs to claim the user's side.
+type SubmarineSwap struct {
+	// RHash is the hash both sides are locked to, it identifies the submarine swap
+	RHash [32]byte `json:"rhash"`
+	// Preimage unlocks both sides. The user picks it, the exchange only knows it once the user
+	// has claimed the exchange's side.
+	Preimage [16]byte `json:"preimage"`
+	// Pubkey is the user's, it's also their key in the on-chain HTLC
+	Pubkey [33]byte `json:"pubkey"`
+	// ExchangeKey is the exchange's key in the on-chain HTLC
+	ExchangeKey [33]byte           `json:"exchangekey"`
+	Direction   SubmarineDirection `json:"direction"`
+	Asset       Asset              `json:"asset"`
+	Amount      uint64             `json:"amount"`
+	// ChainLocktime is the height the on-chain HTLC can be refunded at
+	ChainLocktime uint32 `json:"chainlocktime"`
+	// ChannelLocktime is the height the lightning HTLCs time out. For swaps in it's set when the
+	// exchange offers its HTLCs, for swaps out it's the least the user's HTLCs have to last.
+	ChannelLocktime uint32 `json:"channellocktime"`
+	// Outpoint is the on-chain HTLC, as txid:index, once it's been funded
+	Outpoint   string `json:"outpoint,omitempty"`
+	FundAmount uint64 `json:"fundamount"`
+	// FundHeight is the height of the block the on-chain HTLC was funded in, 0 if it hasn't
+	// confirmed
+	FundHeight uint64 `json:"fundheight"`
+	// SpendTxid is the transaction that spent the on-chain HTLC, or the exchange sent to spend it
+	SpendTxid string `json:"spendtxid,omitempty"`
+	// SpendHeight is the height of the block the on-chain HTLC was spent in, 0 if it hasn't been
+	SpendHeight uint64         `json:"spendheight"`
+	State       SubmarineState `json:"state"`
+	// Reason is why the submarine swap expired or was refunded
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// NewSubmarineSwap creates a submarine swap for a request, at height on the chain of the asset.
+// The exchange's side lasts policy.Timeout blocks from when it's locked, and the user's side has
+// to last policy.ClaimMargin blocks past that. A swap in's on-chain HTLC lasts twice the timeout,
+// so the user has as long to fund it as the exchange's HTLCs last.
+func NewSubmarineSwap(request *SubmarineRequest, pubkey [33]byte, exchangeKey [33]byte, height uint32, policy *SwapPolicy, createTime time.Time) (swap *SubmarineSwap, err error) {
+	if request.Amount == 0 {
+		err = fmt.Errorf("Can't create an empty submarine swap")
+		return
+	}
+	if _, err = SubmarineDirectionFromString(string(request.Direction)); err != nil {
+		return
+	}
+
+	swap = &SubmarineSwap{
+		RHash:       request.RHash,
+		Pubkey:      pubkey,
+		ExchangeKey: exchangeKey,
+		Direction:   request.Direction,
+		Asset:       request.Asset,
+		Amount:      request.Amount,
+		State:       SubmarineCreated,
+		Created:     createTime,
+		Updated:     createTime,
+	}
+	if request.Direction == SubmarineIn {
+		swap.ChainLocktime = height + 2*policy.Timeout
+	} else {
+		swap.ChainLocktime = height + policy.Timeout
+		swap.ChannelLocktime = swap.ChainLocktime + policy.ClaimMargin
+	}
+	return
+}
+
+// ID returns the hex hash of the submarine swap, which is how users refer to it
+func (s *SubmarineSwap) ID() string {
+	return hex.EncodeToString(s.RHash[:])
+}
+
+// String returns a short description of the submarine swap
+func (s *SubmarineSwap) String() string {
+	str := fmt.Sprintf("submarine swap %s: %s %d %s, %s", s.ID(), s.Direction, s.Amount, s.Asset, s.State)
+	if s.Reason != "" {
+		str += fmt.Sprintf(" (%s)", s.Reason)
+	}
+	return str
+}
+
+// SetState moves the submarine swap to a new state at a time, with the reason it expired or was
+// refunded
+func (s *SubmarineSwap) SetState(state SubmarineState, reason string, updateTime time.Time) {
+	s.State = state
+	s.Reason = reason
+	s.Updated = updateTime
+	return
+}
+
+// UpdateFrom copies everything that changes as a submarine swap moves along from another copy of
+// it, which is what stores save when a submarine swap is updated
+func (s *SubmarineSwap) UpdateFrom(other *SubmarineSwap) {
+	s.Preimage = other.Preimage
+	s.ChannelLocktime = other.ChannelLocktime
+	s.Outpoint = other.Outpoint
+	s.FundAmount = other.FundAmount
+	s.FundHeight = other.FundHeight
+	s.SpendTxid = other.SpendTxid
+	s.SpendHeight = other.SpendHeight
+	s.State = other.State
+	s.Reason = other.Reason
+	s.Updated = other.Updated
+	return
+}
+
+// CanLock returns true if there's still time at height for the exchange to lock its side. The
+// exchange's HTLCs for a swap in have to time out policy.ClaimMargin blocks before the on-chain
+// HTLC, so it can claim on chain once the user claims them. The user has to have
+// policy.ClaimMargin blocks to claim a swap out's on-chain HTLC.
+func (s *SubmarineSwap) CanLock(height uint32, policy *SwapPolicy) bool {
+	if s.Direction == SubmarineIn {
+		return height+policy.Timeout+policy.ClaimMargin <= s.ChainLocktime
+	}
+	return height+policy.ClaimMargin <= s.ChainLocktime
+}
+
+// htlc returns the on-chain HTLC. It pays the claim key with the preimage, or the refund key once
+// ChainLocktime has passed. The exchange claims swaps in and the user claims swaps out.
+func (s *SubmarineSwap) htlc() (h *chainHTLC) {
+	h = &chainHTLC{
+		name:      fmt.Sprintf("submarine swap %s", s.ID()),
+		rhash:     s.RHash,
+		claimKey:  s.ExchangeKey,
+		refundKey: s.Pubkey,
+		locktime:  s.ChainLocktime,
+		outpoint:  s.Outpoint,
+		amount:    s.FundAmount,
+	}
+	if s.Direction == SubmarineOut {
+		h.claimKey, h.refundKey = s.Pubkey, s.ExchangeKey
+	}
+	return
+}
+
+// Script returns the on-chain HTLC's witness script
+func (s *SubmarineSwap) Script() (script []byte, err error) {
+	return s.htlc().script()
+}
+
+// PkScript returns the P2WSH script the on-chain HTLC pays to
+func (s *SubmarineSwap) PkScript() (pkScript []byte, err error) {
+	return s.htlc().pkScript()
+}
+
+// CheckHTLCs returns an error if the user's side of a swap out isn't all there. The user's
+// uncleared HTLCs have to add up to at least Amount, and each of them has to last until at least
+// ChannelLocktime.
+func (s *SubmarineSwap) CheckHTLCs(htlcs []SwapHTLC) (err error) {
+	var total uint64
+	for _, htlc := range htlcs {
+		if !htlc.Incoming || htlc.Cleared {
+			continue
+		}
+		if htlc.Locktime < s.ChannelLocktime {
+			err = fmt.Errorf("HTLC for submarine swap %s times out at %d, it has to last until at least %d", s.ID(), htlc.Locktime, s.ChannelLocktime)
+			return
+		}
+		total += htlc.Amount
+	}
+	if total < s.Amount {
+		err = fmt.Errorf("HTLCs for submarine swap %s only add up to %d, need %d", s.ID(), total, s.Amount)
+		return
+	}
+	return
+}
+
+// ClaimedPreimage returns the preimage the user cleared the exchange's HTLCs for a swap in with,
+// ok is false if they haven't cleared any
+func (s *SubmarineSwap) ClaimedPreimage(htlcs []SwapHTLC) (preimage [16]byte, ok bool) {
+	for _, htlc := range htlcs {
+		if htlc.Incoming || !htlc.Cleared {
+			continue
+		}
+		if sha256.Sum256(htlc.Preimage[:]) == s.RHash {
+			return htlc.Preimage, true
+		}
+	}
+	return
+}
+
+// FundingOutput returns the index of the output of tx that funds the on-chain HTLC, found is
+// false if there isn't one
+func (s *SubmarineSwap) FundingOutput(tx *wire.MsgTx) (index uint32, found bool, err error) {
+	return s.htlc().fundingOutput(tx)
+}
+
+// SpendingInput returns the index of the input of tx that spends the on-chain HTLC, found is
+// false if there isn't one or the HTLC hasn't been funded
+func (s *SubmarineSwap) SpendingInput(tx *wire.MsgTx) (index int, found bool) {
+	return s.htlc().spendingInput(tx)
+}
+
+// PreimageFromWitness returns the preimage in the witness of an input that spends the on-chain
+// HTLC, ok is false if it was refunded rather than claimed
+func (s *SubmarineSwap) PreimageFromWitness(witness wire.TxWitness) (preimage [16]byte, ok bool) {
+	return s.htlc().preimageFromWitness(witness)
+}
+
+// SpendTx creates a transaction spending the on-chain HTLC to outScript, paying fee. It claims
+// the HTLC with the preimage, or refunds it if preimage is nil. The refund can't be mined until
+// ChainLocktime.
+func (s *SubmarineSwap) SpendTx(outScript []byte, fee uint64, priv *koblitz.PrivateKey, preimage *[16]byte) (tx *wire.MsgTx, err error) {
+	return s.htlc().spendTx(outScript, fee, priv, preimage)
+}
+
+// VerifySpend checks that input index of tx spends the on-chain HTLC properly
+func (s *SubmarineSwap) VerifySpend(tx *wire.MsgTx, index int) (err error) {
+	return s.htlc().verifySpend(tx, index)
+}
@@ -0,0 +1,200 @@
+package match
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+// fundedSubmarine creates a submarine swap between two keys, funded by a made up transaction
+func fundedSubmarine(t *testing.T, direction SubmarineDirection, user *koblitz.PrivateKey, exchange *koblitz.PrivateKey, preimage [16]byte) (swap *SubmarineSwap) {
+	request := &SubmarineRequest{
+		Direction: direction,
+		Asset:     BTCReg,
+		Amount:    100000,
+		RHash:     sha256.Sum256(preimage[:]),
+	}
+	var pubkey, exchangeKey [33]byte
+	copy(pubkey[:], user.PubKey().SerializeCompressed())
+	copy(exchangeKey[:], exchange.PubKey().SerializeCompressed())
+
+	var err error
+	if swap, err = NewSubmarineSwap(request, pubkey, exchangeKey, 100, DefaultSwapPolicy(), time.Unix(1500000000, 0)); err != nil {
+		t.Fatalf("Error creating submarine swap: %s", err)
+	}
+
+	var pkScript []byte
+	if pkScript, err = swap.PkScript(); err != nil {
+		t.Fatalf("Error getting submarine swap pkscript: %s", err)
+	}
+	fundTx := wire.NewMsgTx()
+	prevHash := chainhash.DoubleHashH([]byte{0x01})
+	fundTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	fundTx.AddTxOut(wire.NewTxOut(50000, []byte{0x00}))
+	fundTx.AddTxOut(wire.NewTxOut(int64(swap.Amount), pkScript))
+
+	index, found, err := swap.FundingOutput(fundTx)
+	if err != nil || !found || index != 1 {
+		t.Fatalf("Funding output should be 1, got %d, %t, %v", index, found, err)
+	}
+	swap.Outpoint = fundTx.TxHash().String() + ":1"
+	swap.FundAmount = swap.Amount
+	return
+}
+
+// TestSubmarineLocktimes makes sure the exchange only locks its side while there's time left for
+// the other side to be claimed
+func TestSubmarineLocktimes(t *testing.T) {
+	user, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x01})
+	exchange, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x02})
+	policy := DefaultSwapPolicy()
+
+	in := fundedSubmarine(t, SubmarineIn, user, exchange, [16]byte{0x01})
+	if in.ChainLocktime != 100+2*DefaultSwapTimeout || in.ChannelLocktime != 0 {
+		t.Errorf("Swap in locktimes are wrong, got %d and %d", in.ChainLocktime, in.ChannelLocktime)
+	}
+	last := in.ChainLocktime - policy.Timeout - policy.ClaimMargin
+	if !in.CanLock(last, policy) || in.CanLock(last+1, policy) {
+		t.Errorf("Swap in should only be offered until height %d", last)
+	}
+
+	out := fundedSubmarine(t, SubmarineOut, user, exchange, [16]byte{0x02})
+	if out.ChainLocktime != 100+DefaultSwapTimeout || out.ChannelLocktime != out.ChainLocktime+DefaultSwapClaimMargin {
+		t.Errorf("Swap out locktimes are wrong, got %d and %d", out.ChainLocktime, out.ChannelLocktime)
+	}
+	last = out.ChainLocktime - policy.ClaimMargin
+	if !out.CanLock(last, policy) || out.CanLock(last+1, policy) {
+		t.Errorf("Swap out should only be funded until height %d", last)
+	}
+
+	if _, err := NewSubmarineSwap(&SubmarineRequest{Direction: SubmarineIn, Asset: BTCReg}, in.Pubkey, in.ExchangeKey, 100, policy, time.Now()); err == nil {
+		t.Errorf("Empty submarine swap should be refused")
+	}
+	if _, err := NewSubmarineSwap(&SubmarineRequest{Direction: "sideways", Asset: BTCReg, Amount: 1}, in.Pubkey, in.ExchangeKey, 100, policy, time.Now()); err == nil {
+		t.Errorf("Submarine swap with an unknown direction should be refused")
+	}
+}
+
+// TestSubmarineSpend claims and refunds on-chain HTLCs in both directions, and makes sure only
+// the right key can take each path
+func TestSubmarineSpend(t *testing.T) {
+	user, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x01})
+	exchange, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x02})
+	outScript := []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+
+	for _, tc := range []struct {
+		direction SubmarineDirection
+		claimer   *koblitz.PrivateKey
+		refunder  *koblitz.PrivateKey
+	}{
+		{SubmarineIn, exchange, user},
+		{SubmarineOut, user, exchange},
+	} {
+		preimage := [16]byte{0x42}
+		swap := fundedSubmarine(t, tc.direction, user, exchange, preimage)
+
+		claim, err := swap.SpendTx(outScript, 1000, tc.claimer, &preimage)
+		if err != nil {
+			t.Fatalf("Error claiming swap %s: %s", tc.direction, err)
+		}
+		if err = swap.VerifySpend(claim, 0); err != nil {
+			t.Errorf("Claim of swap %s should be valid: %s", tc.direction, err)
+		}
+		if index, found := swap.SpendingInput(claim); !found || index != 0 {
+			t.Errorf("Claim of swap %s should spend it", tc.direction)
+		}
+		if got, ok := swap.PreimageFromWitness(claim.TxIn[0].Witness); !ok || got != preimage {
+			t.Errorf("Claim of swap %s should reveal the preimage, got %x", tc.direction, got)
+		}
+		if claim.TxOut[0].Value != int64(swap.FundAmount-1000) {
+			t.Errorf("Claim of swap %s should pay the fee, got %d", tc.direction, claim.TxOut[0].Value)
+		}
+
+		refund, err := swap.SpendTx(outScript, 1000, tc.refunder, nil)
+		if err != nil {
+			t.Fatalf("Error refunding swap %s: %s", tc.direction, err)
+		}
+		if err = swap.VerifySpend(refund, 0); err != nil {
+			t.Errorf("Refund of swap %s should be valid: %s", tc.direction, err)
+		}
+		if refund.LockTime != swap.ChainLocktime {
+			t.Errorf("Refund of swap %s should be locked until %d, got %d", tc.direction, swap.ChainLocktime, refund.LockTime)
+		}
+		if _, ok := swap.PreimageFromWitness(refund.TxIn[0].Witness); ok {
+			t.Errorf("Refund of swap %s shouldn't reveal a preimage", tc.direction)
+		}
+
+		// the keys can't take each other's path, and the preimage has to be right
+		if wrongKey, err := swap.SpendTx(outScript, 1000, tc.refunder, &preimage); err != nil || swap.VerifySpend(wrongKey, 0) == nil {
+			t.Errorf("Claim of swap %s with the refund key should be invalid", tc.direction)
+		}
+		if wrongKey, err := swap.SpendTx(outScript, 1000, tc.claimer, nil); err != nil || swap.VerifySpend(wrongKey, 0) == nil {
+			t.Errorf("Refund of swap %s with the claim key should be invalid", tc.direction)
+		}
+		wrongPreimage := [16]byte{0x43}
+		if wrong, err := swap.SpendTx(outScript, 1000, tc.claimer, &wrongPreimage); err != nil || swap.VerifySpend(wrong, 0) == nil {
+			t.Errorf("Claim of swap %s with the wrong preimage should be invalid", tc.direction)
+		}
+
+		if _, err = swap.SpendTx(outScript, swap.FundAmount, tc.claimer, &preimage); err == nil {
+			t.Errorf("Spend of swap %s should fail if the fee is everything", tc.direction)
+		}
+	}
+}
+
+// TestSubmarineHTLCs checks the lightning side of both directions
+func TestSubmarineHTLCs(t *testing.T) {
+	user, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x01})
+	exchange, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x02})
+
+	out := fundedSubmarine(t, SubmarineOut, user, exchange, [16]byte{0x01})
+	htlcs := []SwapHTLC{
+		{Incoming: true, Amount: 60000, Locktime: out.ChannelLocktime},
+		{Incoming: true, Amount: 40000, Locktime: out.ChannelLocktime + 10},
+	}
+	if err := out.CheckHTLCs(htlcs); err != nil {
+		t.Errorf("HTLCs should cover the swap out: %s", err)
+	}
+	if err := out.CheckHTLCs(htlcs[:1]); err == nil {
+		t.Errorf("One HTLC shouldn't cover the swap out")
+	}
+	htlcs[1].Locktime = out.ChannelLocktime - 1
+	if err := out.CheckHTLCs(htlcs); err == nil {
+		t.Errorf("HTLC that times out too soon shouldn't count")
+	}
+
+	preimage := [16]byte{0x02}
+	in := fundedSubmarine(t, SubmarineIn, user, exchange, preimage)
+	if _, ok := in.ClaimedPreimage([]SwapHTLC{{Amount: 100000}}); ok {
+		t.Errorf("Uncleared HTLC shouldn't have a preimage")
+	}
+	if _, ok := in.ClaimedPreimage([]SwapHTLC{{Amount: 100000, Cleared: true}}); ok {
+		t.Errorf("HTLC that timed out shouldn't have a preimage")
+	}
+	if got, ok := in.ClaimedPreimage([]SwapHTLC{{Amount: 100000, Cleared: true, Preimage: preimage}}); !ok || got != preimage {
+		t.Errorf("Claimed HTLC should have the preimage, got %x", got)
+	}
+}
+
+// TestSubmarineRequestSignature makes sure the pubkey that signed a request is recovered
+func TestSubmarineRequestSignature(t *testing.T) {
+	user, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), []byte{0x01})
+	request := &SubmarineRequest{Direction: SubmarineOut, Asset: BTCReg, Amount: 100000, RHash: [32]byte{0x01}}
+	signature, err := koblitz.SignCompact(koblitz.S256(), user, request.SigHash(), false)
+	if err != nil {
+		t.Fatalf("Error signing request: %s", err)
+	}
+	pubkey, err := request.RecoverPubkey(signature)
+	if err != nil || !pubkey.IsEqual(user.PubKey()) {
+		t.Errorf("Should recover the user's pubkey, got %v", err)
+	}
+
+	request.Amount++
+	if pubkey, err = request.RecoverPubkey(signature); err == nil && pubkey.IsEqual(user.PubKey()) {
+		t.Errorf("Signature shouldn't be for a different amount")
+	}
+}
@@ -0,0 +1,226 @@
+package match
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// SwapState is where a swap is in its life. A swap starts out pending, and ends up completed,
+// refunded or failed.
+type SwapState string
+
+const (
+	// SwapPending is a swap for a fill the exchange hasn't offered its HTLCs for yet
+	SwapPending SwapState = "pending"
+	// SwapOffered is a swap whose exchange HTLCs are offered, waiting for the user's HTLCs
+	SwapOffered SwapState = "offered"
+	// SwapClaimed is a swap where the exchange claimed the user's HTLCs, which reveals the
+	// preimage, so the user can claim the exchange's
+	SwapClaimed SwapState = "claimed"
+	// SwapCompleted is a swap where the user claimed the exchange's HTLCs
+	SwapCompleted SwapState = "completed"
+	// SwapRefunded is a swap whose exchange HTLCs timed out and were taken back
+	SwapRefunded SwapState = "refunded"
+	// SwapFailed is a swap the exchange couldn't offer its HTLCs for before it timed out
+	SwapFailed SwapState = "failed"
+)
+
+const (
+	// DefaultSwapTimeout is how many blocks the exchange's HTLCs last if there's no swap policy
+	DefaultSwapTimeout = 144
+	// DefaultSwapClaimMargin is how many blocks the user's HTLCs need left if there's no swap
+	// policy
+	DefaultSwapClaimMargin = 6
+)
+
+// Final returns true if nothing else will happen to the swap
+func (s SwapState) Final() bool {
+	return s == SwapCompleted || s == SwapRefunded || s == SwapFailed
+}
+
+// SwapStateFromString returns the state for a string, or an error if it isn't a state
+func SwapStateFromString(str string) (state SwapState, err error) {
+	switch SwapState(str) {
+	case SwapPending, SwapOffered, SwapClaimed, SwapCompleted, SwapRefunded, SwapFailed:
+		state = SwapState(str)
+	default:
+		err = fmt.Errorf("Unknown swap state %s", str)
+	}
+	return
+}
+
+// SwapPolicy is how long the HTLCs in a swap have to last. The exchange offers its HTLCs first
+// and only claims the user's once they're all there, so the user has until the exchange's
+// HTLCs time out to claim them after the preimage is revealed.
+type SwapPolicy struct {
+	// Timeout is how many blocks of the asset the exchange sends its HTLCs last for
+	Timeout uint32
+	// ClaimMargin is how many blocks the user's HTLCs have to have left for the exchange to
+	// claim them, so there's time to claim on chain if a channel closes
+	ClaimMargin uint32
+	// EscrowTimeout is how many blocks the user's HTLCs for a channel order have to last, which
+	// is as long as the order can rest on the book
+	EscrowTimeout uint32
+}
+
+// DefaultSwapPolicy returns the policy used if the exchange doesn't set one
+func DefaultSwapPolicy() *SwapPolicy {
+	return &SwapPolicy{
+		Timeout:       DefaultSwapTimeout,
+		ClaimMargin:   DefaultSwapClaimMargin,
+		EscrowTimeout: DefaultEscrowTimeout,
+	}
+}
+
+// Swap settles a fill of a swap order over lightning, so the exchange never holds the user's
+// coins. The exchange sends what the order wants in HTLCs, the user sends what the order gives
+// up in HTLCs with the same hash, and the exchange claiming the user's HTLCs reveals the
+// preimage the user needs to claim the exchange's.
+type Swap struct {
+	// RHash is the hash every HTLC in the swap is locked to, it identifies the swap
+	RHash [32]byte `json:"rhash"`
+	// Preimage unlocks the HTLCs, only the exchange knows it until the swap is claimed
+	Preimage [16]byte `json:"preimage"`
+	Pubkey   [33]byte `json:"pubkey"`
+	// OrderID is the swap order that was filled
+	OrderID     OrderID `json:"orderid"`
+	TradingPair Pair    `json:"pair"`
+	Side        Side    `json:"side"`
+	// SendAsset is what the exchange sends, in HTLCs that time out at SendLocktime on its chain
+	SendAsset    Asset  `json:"sendasset"`
+	AmountSend   uint64 `json:"amountsend"`
+	SendLocktime uint32 `json:"sendlocktime"`
+	// ReceiveAsset is what the user sends
+	ReceiveAsset  Asset     `json:"receiveasset"`
+	AmountReceive uint64    `json:"amountreceive"`
+	State         SwapState `json:"state"`
+	// Reason is why the swap was refunded or failed
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// SwapHTLC is an HTLC locked to a swap's hash, on either side of the swap
+type SwapHTLC struct {
+	// Incoming is true for HTLCs the user offered the exchange
+	Incoming bool
+	Amount   uint64
+	Locktime uint32
+	Cleared  bool
+	// Preimage is what the HTLC was cleared with, it's empty if it timed out
+	Preimage [16]byte
+	// CoinType is the coin of the channel the HTLC is on
+	CoinType uint32
+}
+
+// NewSwap creates a pending swap for a fill of a swap order, with a new random preimage. The
+// exchange's HTLCs time out policy.Timeout blocks after sendHeight, the height of the chain of
+// the asset the order wants.
+func NewSwap(fill *FillEntry, sendHeight uint32, policy *SwapPolicy, createTime time.Time) (swap *Swap, err error) {
+	if fill.AmountHave == 0 || fill.AmountWant == 0 {
+		err = fmt.Errorf("Can't swap an empty fill of order %s", hex.EncodeToString(fill.OrderID[:]))
+		return
+	}
+
+	have, want := fill.TradingPair.HaveWantAssets(fill.Side)
+	swap = &Swap{
+		Pubkey:        fill.Pubkey,
+		OrderID:       fill.OrderID,
+		TradingPair:   fill.TradingPair,
+		Side:          fill.Side,
+		SendAsset:     want,
+		AmountSend:    fill.AmountWant,
+		SendLocktime:  sendHeight + policy.Timeout,
+		ReceiveAsset:  have,
+		AmountReceive: fill.AmountHave,
+		State:         SwapPending,
+		Created:       createTime,
+		Updated:       createTime,
+	}
+	if _, err = rand.Read(swap.Preimage[:]); err != nil {
+		err = fmt.Errorf("Error reading random bytes into preimage for NewSwap: %s", err)
+		swap = nil
+		return
+	}
+	swap.RHash = sha256.Sum256(swap.Preimage[:])
+	return
+}
+
+// ID returns the hex hash of the swap, which is how users refer to it
+func (s *Swap) ID() string {
+	return hex.EncodeToString(s.RHash[:])
+}
+
+// String returns a short description of the swap
+func (s *Swap) String() string {
+	str := fmt.Sprintf("swap %s: send %d %s, receive %d %s, %s", s.ID(), s.AmountSend, s.SendAsset, s.AmountReceive, s.ReceiveAsset, s.State)
+	if s.Reason != "" {
+		str += fmt.Sprintf(" (%s)", s.Reason)
+	}
+	return str
+}
+
+// SetState moves the swap to a new state at a time, with the reason it was refunded or failed
+func (s *Swap) SetState(state SwapState, reason string, updateTime time.Time) {
+	s.State = state
+	s.Reason = reason
+	s.Updated = updateTime
+	return
+}
+
+// Public returns a copy of the swap that's safe to show the user. The preimage is left out until
+// the exchange has revealed it by claiming the user's HTLCs.
+func (s *Swap) Public() (public *Swap) {
+	public = new(Swap)
+	*public = *s
+	if s.State != SwapClaimed && s.State != SwapCompleted {
+		public.Preimage = [16]byte{}
+	}
+	return
+}
+
+// CheckClaim returns an error if the exchange shouldn't claim the user's HTLCs yet. The user's
+// uncleared HTLCs have to add up to at least AmountReceive, and each of them has to last at least
+// policy.ClaimMargin blocks past receiveHeight, the height of the chain of the asset received.
+func (s *Swap) CheckClaim(htlcs []SwapHTLC, receiveHeight uint32, policy *SwapPolicy) (err error) {
+	var total uint64
+	for _, htlc := range htlcs {
+		if !htlc.Incoming || htlc.Cleared {
+			continue
+		}
+		if htlc.Locktime < receiveHeight+policy.ClaimMargin {
+			err = fmt.Errorf("HTLC for swap %s times out at %d, it has to last until at least %d", s.ID(), htlc.Locktime, receiveHeight+policy.ClaimMargin)
+			return
+		}
+		total += htlc.Amount
+	}
+	if total < s.AmountReceive {
+		err = fmt.Errorf("HTLCs for swap %s only add up to %d, need %d", s.ID(), total, s.AmountReceive)
+		return
+	}
+	return
+}
+
+// SentClaimed returns true if the user claimed every HTLC the exchange sent for the swap
+func (s *Swap) SentClaimed(htlcs []SwapHTLC) bool {
+	var sent int
+	for _, htlc := range htlcs {
+		if htlc.Incoming {
+			continue
+		}
+		if !htlc.Cleared || sha256.Sum256(htlc.Preimage[:]) != s.RHash {
+			return false
+		}
+		sent++
+	}
+	return sent != 0
+}
+
+// TimedOut returns true if the exchange's HTLCs have timed out at sendHeight, the height of the
+// chain of the asset sent
+func (s *Swap) TimedOut(sendHeight uint32) bool {
+	return sendHeight >= s.SendLocktime
+}
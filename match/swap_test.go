@@ -0,0 +1,92 @@
+package match
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func testSwap(t *testing.T) (swap *Swap) {
+	fill := &FillEntry{
+		OrderID:     OrderID{0x01},
+		TradingPair: Pair{AssetWant: BTCTest, AssetHave: LTCTest},
+		Side:        Buy,
+		AmountHave:  4000,
+		AmountWant:  1000,
+	}
+	var err error
+	if swap, err = NewSwap(fill, 100, &SwapPolicy{Timeout: 50, ClaimMargin: 6}, time.Unix(1, 0)); err != nil {
+		t.Fatalf("Error creating swap: %s", err)
+	}
+	return
+}
+
+// TestNewSwap tests that a swap sends what the order wants and receives what it gives up
+func TestNewSwap(t *testing.T) {
+	swap := testSwap(t)
+	if swap.SendAsset != BTCTest || swap.AmountSend != 1000 || swap.ReceiveAsset != LTCTest || swap.AmountReceive != 4000 {
+		t.Errorf("Buy fill should send 1000 %s and receive 4000 %s, got %s", BTCTest, LTCTest, swap)
+	}
+	if swap.SendLocktime != 150 || swap.State != SwapPending {
+		t.Errorf("Swap should be pending and time out at 150, got %d and %s", swap.SendLocktime, swap.State)
+	}
+	if sha256.Sum256(swap.Preimage[:]) != swap.RHash {
+		t.Errorf("RHash should be the hash of the preimage")
+	}
+	if swap.Public().Preimage != [16]byte{} {
+		t.Errorf("Preimage shouldn't be public before the swap is claimed")
+	}
+	swap.SetState(SwapClaimed, "", time.Unix(2, 0))
+	if swap.Public().Preimage != swap.Preimage {
+		t.Errorf("Preimage should be public once the swap is claimed")
+	}
+
+	if _, err := NewSwap(&FillEntry{}, 100, DefaultSwapPolicy(), time.Unix(1, 0)); err == nil {
+		t.Errorf("Empty fill should not make a swap")
+	}
+}
+
+// TestSwapCheckClaim tests when the exchange can claim the user's HTLCs
+func TestSwapCheckClaim(t *testing.T) {
+	swap := testSwap(t)
+	policy := &SwapPolicy{Timeout: 50, ClaimMargin: 6}
+	outgoing := SwapHTLC{Amount: 1000, Locktime: 150}
+
+	for _, tc := range []struct {
+		name  string
+		htlcs []SwapHTLC
+		claim bool
+	}{
+		{"no htlcs", []SwapHTLC{outgoing}, false},
+		{"too little", []SwapHTLC{outgoing, {Incoming: true, Amount: 3999, Locktime: 200}}, false},
+		{"split", []SwapHTLC{{Incoming: true, Amount: 2000, Locktime: 200}, {Incoming: true, Amount: 2000, Locktime: 106}}, true},
+		{"too short", []SwapHTLC{{Incoming: true, Amount: 4000, Locktime: 105}}, false},
+		{"cleared", []SwapHTLC{{Incoming: true, Amount: 4000, Locktime: 200, Cleared: true}}, false},
+	} {
+		if err := swap.CheckClaim(tc.htlcs, 100, policy); (err == nil) != tc.claim {
+			t.Errorf("Claim for %s should be %t, got error %v", tc.name, tc.claim, err)
+		}
+	}
+}
+
+// TestSwapSentClaimed tests that the user has to clear every outgoing HTLC with the preimage
+func TestSwapSentClaimed(t *testing.T) {
+	swap := testSwap(t)
+	incoming := SwapHTLC{Incoming: true, Amount: 4000, Cleared: true, Preimage: swap.Preimage}
+
+	if swap.SentClaimed([]SwapHTLC{incoming}) {
+		t.Errorf("Swap with no outgoing HTLCs should not be claimed")
+	}
+	if swap.SentClaimed([]SwapHTLC{{Amount: 500, Cleared: true, Preimage: swap.Preimage}, {Amount: 500}}) {
+		t.Errorf("Swap with an uncleared outgoing HTLC should not be claimed")
+	}
+	if swap.SentClaimed([]SwapHTLC{{Amount: 1000, Cleared: true}}) {
+		t.Errorf("Outgoing HTLC cleared without the preimage timed out, it wasn't claimed")
+	}
+	if !swap.SentClaimed([]SwapHTLC{incoming, {Amount: 1000, Cleared: true, Preimage: swap.Preimage}}) {
+		t.Errorf("Swap should be claimed")
+	}
+	if swap.TimedOut(149) || !swap.TimedOut(150) {
+		t.Errorf("Swap should time out at 150")
+	}
+}
@@ -1,33 +1,173 @@
 package match
 
-import "encoding/binary"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+)
+
+// DefaultWithdrawalDomain is the domain withdrawals are signed for if the exchange doesn't set one
+const DefaultWithdrawalDomain = "opencx"
 
 // Withdrawal is a representation of a withdrawal. This is because withdrawals are now signed.
+// The signature is over SigHash, which commits to the exchange the withdrawal is for and a nonce,
+// so a signed withdrawal can't be taken to another exchange or sent twice.
 type Withdrawal struct {
 	Asset   Asset
 	Amount  uint64
 	Address string
 	// This tells whether or not this is a lightning withdrawal. Default value is false so that makes it easier to not mess up
 	Lightning bool
+	// Nonce has to be different for every withdrawal a user signs for an asset
+	Nonce uint64
+	// Domain is the exchange the withdrawal is for
+	Domain string
+	// Priority decides the fee rate of the transaction the withdrawal goes out in
+	Priority FeePriority
+	// PayFee takes the user's share of the network fee out of what they're sent, rather than the
+	// exchange paying it
+	PayFee bool
 }
 
-// Serialize serializes the withdrawal
+// Serialize serializes the withdrawal. This is what gets signed, so every field is fixed size or
+// length prefixed and two different withdrawals never serialize the same way.
 func (w *Withdrawal) Serialize() (buf []byte) {
-	// bool yes or no
+	// len(domain) [8 bytes]
+	// Domain [len(domain)]
 	// Asset [1 byte]
-	// Amount [64 bytes]
-	// len(address)
+	// Lightning [1 byte]
+	// Priority [1 byte]
+	// PayFee [1 byte]
+	// Amount [8 bytes]
+	// Nonce [8 bytes]
+	// len(address) [8 bytes]
 	// Address [len(address)]
 
-	var oneorzero byte
+	lenDomainBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenDomainBytes, uint64(len(w.Domain)))
+	buf = append(buf, lenDomainBytes[:]...)
+	buf = append(buf, []byte(w.Domain)...)
+
+	buf = append(buf, byte(w.Asset))
+
+	var lightningByte byte = 0x00
 	if w.Lightning {
-		oneorzero = 0xff
+		lightningByte = 0x01
 	}
-	buf = make([]byte, 66+len(w.Address))
-	buf = append(buf, oneorzero)
-	buf = append(buf, byte(w.Asset))
-	binary.LittleEndian.PutUint64(buf, w.Amount)
-	binary.LittleEndian.PutUint64(buf, uint64(len(w.Address)))
+	buf = append(buf, lightningByte)
+
+	buf = append(buf, byte(w.Priority))
+
+	var payFeeByte byte = 0x00
+	if w.PayFee {
+		payFeeByte = 0x01
+	}
+	buf = append(buf, payFeeByte)
+
+	amountBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amountBytes, w.Amount)
+	buf = append(buf, amountBytes[:]...)
+
+	nonceBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(nonceBytes, w.Nonce)
+	buf = append(buf, nonceBytes[:]...)
+
+	lenAddressBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenAddressBytes, uint64(len(w.Address)))
+	buf = append(buf, lenAddressBytes[:]...)
 	buf = append(buf, []byte(w.Address)...)
 	return
 }
+
+// Deserialize deserializes a withdrawal into the struct ptr it's being called on
+func (w *Withdrawal) Deserialize(data []byte) (err error) {
+	buf := bytes.NewBuffer(data)
+
+	var lenDomain uint64
+	if err = binary.Read(buf, binary.LittleEndian, &lenDomain); err != nil {
+		err = fmt.Errorf("Error reading domain length for withdrawal: %s", err)
+		return
+	}
+	if lenDomain > uint64(buf.Len()) {
+		err = fmt.Errorf("Withdrawal domain is %d bytes, only %d left", lenDomain, buf.Len())
+		return
+	}
+	w.Domain = string(buf.Next(int(lenDomain)))
+
+	var assetByte, lightningByte byte
+	if assetByte, err = buf.ReadByte(); err != nil {
+		err = fmt.Errorf("Error reading asset for withdrawal: %s", err)
+		return
+	}
+	w.Asset = Asset(assetByte)
+	if lightningByte, err = buf.ReadByte(); err != nil {
+		err = fmt.Errorf("Error reading lightning for withdrawal: %s", err)
+		return
+	}
+	if lightningByte > 0x01 {
+		err = fmt.Errorf("Withdrawal lightning byte should be 0 or 1, got %d", lightningByte)
+		return
+	}
+	w.Lightning = lightningByte == 0x01
+
+	var priorityByte, payFeeByte byte
+	if priorityByte, err = buf.ReadByte(); err != nil {
+		err = fmt.Errorf("Error reading priority for withdrawal: %s", err)
+		return
+	}
+	w.Priority = FeePriority(priorityByte)
+	if !w.Priority.Valid() {
+		err = fmt.Errorf("Withdrawal has unknown fee priority %d", priorityByte)
+		return
+	}
+	if payFeeByte, err = buf.ReadByte(); err != nil {
+		err = fmt.Errorf("Error reading pay fee for withdrawal: %s", err)
+		return
+	}
+	if payFeeByte > 0x01 {
+		err = fmt.Errorf("Withdrawal pay fee byte should be 0 or 1, got %d", payFeeByte)
+		return
+	}
+	w.PayFee = payFeeByte == 0x01
+
+	if err = binary.Read(buf, binary.LittleEndian, &w.Amount); err != nil {
+		err = fmt.Errorf("Error reading amount for withdrawal: %s", err)
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &w.Nonce); err != nil {
+		err = fmt.Errorf("Error reading nonce for withdrawal: %s", err)
+		return
+	}
+
+	var lenAddress uint64
+	if err = binary.Read(buf, binary.LittleEndian, &lenAddress); err != nil {
+		err = fmt.Errorf("Error reading address length for withdrawal: %s", err)
+		return
+	}
+	if lenAddress != uint64(buf.Len()) {
+		err = fmt.Errorf("Withdrawal address is %d bytes, but %d are left", lenAddress, buf.Len())
+		return
+	}
+	w.Address = string(buf.Next(int(lenAddress)))
+	return
+}
+
+// SigHash returns the hash of the serialized withdrawal, which is what the user signs
+func (w *Withdrawal) SigHash() (e []byte) {
+	sha3 := sha3.New256()
+	sha3.Write(w.Serialize())
+	e = sha3.Sum(nil)
+	return
+}
+
+// RecoverPubkey returns the pubkey that made a compact signature over the withdrawal
+func (w *Withdrawal) RecoverPubkey(signature []byte) (pubkey *koblitz.PublicKey, err error) {
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), signature, w.SigHash()); err != nil {
+		err = fmt.Errorf("Error recovering pubkey from withdrawal signature: %s", err)
+		return
+	}
+	return
+}
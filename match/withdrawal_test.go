@@ -0,0 +1,86 @@
+package match
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+// TestWithdrawalSerialize makes sure withdrawals deserialize to what was serialized, and that
+// moving bytes between the domain and the address changes the serialization
+func TestWithdrawalSerialize(t *testing.T) {
+	withdrawal := &Withdrawal{
+		Asset:     BTCReg,
+		Amount:    123456789,
+		Address:   "bcrt1qtestaddress",
+		Lightning: true,
+		Nonce:     42,
+		Domain:    "exchange.example",
+		Priority:  FeePriorityHigh,
+		PayFee:    true,
+	}
+
+	deserialized := new(Withdrawal)
+	if err := deserialized.Deserialize(withdrawal.Serialize()); err != nil {
+		t.Errorf("Error deserializing withdrawal: %s", err)
+		return
+	}
+	if *deserialized != *withdrawal {
+		t.Errorf("Deserialized withdrawal %+v is not the same as %+v", deserialized, withdrawal)
+	}
+
+	shifted := *withdrawal
+	shifted.Domain = "exchange.examplebcrt1q"
+	shifted.Address = "testaddress"
+	if bytes.Equal(shifted.Serialize(), withdrawal.Serialize()) {
+		t.Errorf("Withdrawals with different domains and addresses should not serialize the same")
+	}
+
+	if err := deserialized.Deserialize(withdrawal.Serialize()[:20]); err == nil {
+		t.Errorf("Deserializing a truncated withdrawal should fail")
+	}
+}
+
+// TestWithdrawalRequestSignature signs a withdrawal and checks the request it's stored with can
+// be verified, and can't once it's changed
+func TestWithdrawalRequestSignature(t *testing.T) {
+	var err error
+	var priv *koblitz.PrivateKey
+	if priv, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating key: %s", err)
+		return
+	}
+
+	withdrawal := &Withdrawal{
+		Asset:   BTCReg,
+		Amount:  5000,
+		Address: "bcrt1qtestaddress",
+		Nonce:   1,
+		Domain:  "exchange.example",
+	}
+
+	var signature []byte
+	if signature, err = koblitz.SignCompact(koblitz.S256(), priv, withdrawal.SigHash(), false); err != nil {
+		t.Errorf("Error signing withdrawal: %s", err)
+		return
+	}
+
+	request := &WithdrawalRequest{
+		Asset:     withdrawal.Asset,
+		Amount:    withdrawal.Amount,
+		Address:   withdrawal.Address,
+		Nonce:     withdrawal.Nonce,
+		Domain:    withdrawal.Domain,
+		Signature: signature,
+	}
+	copy(request.Pubkey[:], priv.PubKey().SerializeCompressed())
+	if err = request.VerifySignature(); err != nil {
+		t.Errorf("Request should verify: %s", err)
+	}
+
+	request.Amount++
+	if err = request.VerifySignature(); err == nil {
+		t.Errorf("Request with a different amount should not verify")
+	}
+}
@@ -0,0 +1,52 @@
+package match
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/mit-dci/lit/wire"
+)
+
+// WithdrawalBatch is a withdrawal transaction that hasn't confirmed yet, along with what it takes
+// to replace it with one paying a higher fee. Every version of the transaction spends the same
+// inputs and pays the same withdrawal outputs, only the change is different. It's stored so
+// transactions sent before a restart can still be replaced, and still confirm as any version.
+type WithdrawalBatch struct {
+	// Txid is the txid of the first version of the transaction, the batch is stored by it
+	Txid     string      `json:"txid"`
+	Priority FeePriority `json:"priority"`
+	// IDs are the withdrawals in the batch
+	IDs []uint64 `json:"ids"`
+	// Inputs are the serialized wallet outputs the transaction spends
+	Inputs     [][]byte `json:"inputs"`
+	InputTotal int64    `json:"inputtotal"`
+	// Outputs are the outputs paying the withdrawals, without the change
+	Outputs      []*wire.TxOut `json:"outputs"`
+	ChangeScript []byte        `json:"changescript"`
+	FeeRate      int64         `json:"feerate"`
+	// SentHeight is the height of the chain when the current version was sent
+	SentHeight uint64 `json:"sentheight"`
+	// Txs are every serialized version of the transaction, the current one last
+	Txs [][]byte `json:"txs"`
+}
+
+// Serialize uses gob encoding to turn the batch into bytes
+func (wb *WithdrawalBatch) Serialize() (raw []byte, err error) {
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(wb); err != nil {
+		err = fmt.Errorf("Error encoding withdrawal batch: %s", err)
+		return
+	}
+	raw = b.Bytes()
+	return
+}
+
+// Deserialize turns the batch from bytes into a usable struct
+func (wb *WithdrawalBatch) Deserialize(raw []byte) (err error) {
+	if err = gob.NewDecoder(bytes.NewReader(raw)).Decode(wb); err != nil {
+		err = fmt.Errorf("Error decoding withdrawal batch: %s", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,185 @@
+package match
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+// WithdrawalState is where a withdrawal request is in its life. A request starts out requested or
+// approved, gets broadcast in a batch, and ends up confirmed or failed.
+type WithdrawalState string
+
+const (
+	// WithdrawalRequested is a withdrawal waiting for an operator to approve it
+	WithdrawalRequested WithdrawalState = "requested"
+	// WithdrawalApproved is a withdrawal waiting to go out in the next batch
+	WithdrawalApproved WithdrawalState = "approved"
+	// WithdrawalBroadcast is a withdrawal whose transaction has been sent but isn't in a block yet
+	WithdrawalBroadcast WithdrawalState = "broadcast"
+	// WithdrawalConfirmed is a withdrawal whose transaction is in a block
+	WithdrawalConfirmed WithdrawalState = "confirmed"
+	// WithdrawalFailed is a withdrawal that was rejected or couldn't be sent, the amount has been
+	// given back to the user
+	WithdrawalFailed WithdrawalState = "failed"
+)
+
+// WithdrawalLimitWindow is how far back withdrawals count towards a daily limit
+const WithdrawalLimitWindow = 24 * time.Hour
+
+// Final returns true if nothing else will happen to the withdrawal
+func (s WithdrawalState) Final() bool {
+	return s == WithdrawalConfirmed || s == WithdrawalFailed
+}
+
+// WithdrawalStateFromString returns the state for a string, or an error if it isn't a state
+func WithdrawalStateFromString(str string) (state WithdrawalState, err error) {
+	switch WithdrawalState(str) {
+	case WithdrawalRequested, WithdrawalApproved, WithdrawalBroadcast, WithdrawalConfirmed, WithdrawalFailed:
+		state = WithdrawalState(str)
+	default:
+		err = fmt.Errorf("Unknown withdrawal state %s", str)
+	}
+	return
+}
+
+// WithdrawalRequest is an on-chain withdrawal and what has happened to it. The amount is taken out
+// of the user's balance when it's requested, and only given back if the withdrawal fails.
+type WithdrawalRequest struct {
+	// ID is set by the withdrawal store, and is unique for the coin
+	ID      uint64          `json:"id"`
+	Pubkey  [33]byte        `json:"pubkey"`
+	Asset   Asset           `json:"asset"`
+	Amount  uint64          `json:"amount"`
+	Address string          `json:"address"`
+	State   WithdrawalState `json:"state"`
+	// Txid is the transaction the withdrawal was batched into, once it's broadcast
+	Txid string `json:"txid,omitempty"`
+	// Reason is why the withdrawal failed
+	Reason    string    `json:"reason,omitempty"`
+	Requested time.Time `json:"requested"`
+	Updated   time.Time `json:"updated"`
+	// Lightning withdrawals are pushed through a channel right away, so they're stored confirmed
+	Lightning bool `json:"lightning,omitempty"`
+	// Nonce, Domain and Signature are from the Withdrawal the user signed, so anyone can check
+	// the user asked for this withdrawal
+	Nonce     uint64 `json:"nonce"`
+	Domain    string `json:"domain"`
+	Signature []byte `json:"signature"`
+	// Priority and PayFee are how the user asked for the network fee to be paid
+	Priority FeePriority `json:"priority"`
+	PayFee   bool        `json:"payfee,omitempty"`
+	// Fee is the user's share of the network fee, taken out of what they were sent. It's only set
+	// for withdrawals that pay their own fee, once they're broadcast.
+	Fee uint64 `json:"fee,omitempty"`
+}
+
+// Withdrawal returns the withdrawal the user signed for this request
+func (wr *WithdrawalRequest) Withdrawal() (withdrawal *Withdrawal) {
+	withdrawal = &Withdrawal{
+		Asset:     wr.Asset,
+		Amount:    wr.Amount,
+		Address:   wr.Address,
+		Lightning: wr.Lightning,
+		Nonce:     wr.Nonce,
+		Domain:    wr.Domain,
+		Priority:  wr.Priority,
+		PayFee:    wr.PayFee,
+	}
+	return
+}
+
+// VerifySignature checks that the request's signature is by its pubkey, over the withdrawal
+func (wr *WithdrawalRequest) VerifySignature() (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = wr.Withdrawal().RecoverPubkey(wr.Signature); err != nil {
+		return
+	}
+	if !bytes.Equal(pubkey.SerializeCompressed(), wr.Pubkey[:]) {
+		err = fmt.Errorf("Withdrawal %d was signed by %x, not %x", wr.ID, pubkey.SerializeCompressed(), wr.Pubkey)
+		return
+	}
+	return
+}
+
+// String returns a short description of the withdrawal request
+func (wr *WithdrawalRequest) String() string {
+	str := fmt.Sprintf("withdrawal %d: %d %s to %s, %s", wr.ID, wr.Amount, wr.Asset, wr.Address, wr.State)
+	if wr.Txid != "" {
+		str += fmt.Sprintf(" in %s", wr.Txid)
+	}
+	if wr.Fee != 0 {
+		str += fmt.Sprintf(" paying %d in fees", wr.Fee)
+	}
+	if wr.Reason != "" {
+		str += fmt.Sprintf(" (%s)", wr.Reason)
+	}
+	return str
+}
+
+// SetState moves the withdrawal to a new state at a time
+func (wr *WithdrawalRequest) SetState(state WithdrawalState, updateTime time.Time) {
+	wr.State = state
+	wr.Updated = updateTime
+	return
+}
+
+// WithdrawalPolicy is how much users can withdraw of an asset, and which withdrawals need to be
+// approved by an operator before they go out. The zero value has no limit and approves everything.
+type WithdrawalPolicy struct {
+	// DailyLimit is the most a user can withdraw in WithdrawalLimitWindow, 0 means no limit
+	DailyLimit uint64
+	// ApprovalThreshold is the amount at and above which a withdrawal waits for an operator, 0
+	// means no withdrawal does
+	ApprovalThreshold uint64
+}
+
+// NeedsApproval returns true if a withdrawal of amount has to be approved by an operator
+func (wp *WithdrawalPolicy) NeedsApproval(amount uint64) bool {
+	return wp.ApprovalThreshold != 0 && amount >= wp.ApprovalThreshold
+}
+
+// WithinLimit returns true if a user that has already withdrawn withdrawn in the last
+// WithdrawalLimitWindow can withdraw amount more
+func (wp *WithdrawalPolicy) WithinLimit(withdrawn uint64, amount uint64) bool {
+	if wp.DailyLimit == 0 {
+		return true
+	}
+	// check for overflow before adding
+	return amount <= wp.DailyLimit && withdrawn <= wp.DailyLimit-amount
+}
+
+// String returns the policy in the same format ParseWithdrawalPolicy takes
+func (wp *WithdrawalPolicy) String() string {
+	return fmt.Sprintf("%d:%d", wp.DailyLimit, wp.ApprovalThreshold)
+}
+
+// ParseWithdrawalPolicy parses a policy that looks like "dailylimit" or
+// "dailylimit:approvalthreshold", where 0 means no limit or no approvals.
+func ParseWithdrawalPolicy(str string) (policy *WithdrawalPolicy, err error) {
+	parts := strings.Split(str, ":")
+	if len(parts) != 1 && len(parts) != 2 {
+		err = fmt.Errorf("Withdrawal policy %s should look like dailylimit or dailylimit:approvalthreshold", str)
+		return
+	}
+
+	var values []uint64
+	for _, part := range parts {
+		var value uint64
+		if value, err = strconv.ParseUint(part, 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing %s in withdrawal policy %s: %s", part, str, err)
+			return
+		}
+		values = append(values, value)
+	}
+
+	policy = &WithdrawalPolicy{DailyLimit: values[0]}
+	if len(values) == 2 {
+		policy.ApprovalThreshold = values[1]
+	}
+	return
+}
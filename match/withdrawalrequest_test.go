@@ -0,0 +1,49 @@
+package match
+
+import "testing"
+
+// TestWithdrawalPolicy tests daily limits and approval thresholds
+func TestWithdrawalPolicy(t *testing.T) {
+	policy, err := ParseWithdrawalPolicy("10000:5000")
+	if err != nil {
+		t.Errorf("Error parsing policy: %s", err)
+		return
+	}
+
+	if policy.NeedsApproval(4999) {
+		t.Errorf("Withdrawal under the threshold should not need approval")
+	}
+	if !policy.NeedsApproval(5000) {
+		t.Errorf("Withdrawal at the threshold should need approval")
+	}
+
+	for _, tc := range []struct {
+		withdrawn uint64
+		amount    uint64
+		expected  bool
+	}{
+		{0, 10000, true},
+		{6000, 4000, true},
+		{6000, 4001, false},
+		{0, 10001, false},
+		{1, ^uint64(0), false},
+	} {
+		if got := policy.WithinLimit(tc.withdrawn, tc.amount); got != tc.expected {
+			t.Errorf("Withdrawing %d after %d should be within limit: %t, got %t", tc.amount, tc.withdrawn, tc.expected, got)
+		}
+	}
+
+	if policy, err = ParseWithdrawalPolicy("0"); err != nil {
+		t.Errorf("Error parsing policy: %s", err)
+		return
+	}
+	if !policy.WithinLimit(^uint64(0), ^uint64(0)) || policy.NeedsApproval(^uint64(0)) {
+		t.Errorf("Policy of 0 should have no limit and need no approval")
+	}
+
+	for _, bad := range []string{"", "1:2:3", "ten"} {
+		if _, err = ParseWithdrawalPolicy(bad); err == nil {
+			t.Errorf("Policy %q should not parse", bad)
+		}
+	}
+}